@@ -16,9 +16,21 @@ package main
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	sImage "github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity-cri/pkg/server/image"
+	"github.com/sylabs/singularity-cri/pkg/server/runtime"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
 	"gopkg.in/yaml.v2"
 )
 
@@ -27,22 +39,424 @@ import (
 type Config struct {
 	// ListenSocket is a unix socket to serve CRI requests on.
 	ListenSocket string `yaml:"listenSocket"`
+	// ListenSocketMode is an octal mode of ListenSocket, e.g. 0660.
+	ListenSocketMode string `yaml:"listenSocketMode"`
+	// ListenSocketOwner is an owner of ListenSocket in form of uid:gid.
+	ListenSocketOwner string `yaml:"listenSocketOwner"`
+	// ListenTCP is a TCP address to serve CRI requests on with mutual TLS.
+	// Empty value disables TCP endpoint.
+	ListenTCP string `yaml:"listenTCP"`
+	// TLSCert and TLSKey are paths to PEM encoded certificate and key
+	// ListenTCP is served with, TLSClientCA is a path to PEM encoded CA
+	// certificates clients must present certificates signed by.
+	TLSCert     string `yaml:"tlsCert"`
+	TLSKey      string `yaml:"tlsKey"`
+	TLSClientCA string `yaml:"tlsClientCA"`
+	// MaxTCPConnections limits simultaneous connections to ListenTCP.
+	MaxTCPConnections int `yaml:"maxTCPConnections"`
+	// MaxConcurrentStreams limits concurrent requests of each connection.
+	MaxConcurrentStreams int `yaml:"maxConcurrentStreams"`
+	// MaxRecvMsgSize and MaxSendMsgSize limit size of received requests
+	// and sent responses in bytes.
+	MaxRecvMsgSize int `yaml:"maxRecvMsgSize"`
+	MaxSendMsgSize int `yaml:"maxSendMsgSize"`
+	// DebugSocket is a unix socket accessible by daemon user only that serves
+	// RuntimeAdmin service, e.g. dump-state calls. Empty value disables it.
+	DebugSocket string `yaml:"debugSocket"`
 	// StorageDir is a directory to store all pulled images in.
 	StorageDir string `yaml:"storageDir"`
+	// ImageScratchDir is a directory images are downloaded and converted in
+	// before they are moved to StorageDir. May be located on another disk.
+	ImageScratchDir string `yaml:"imageScratchDir"`
 	// StreamingURL is an address to serve streaming requests on (exec, attach, portforward).
 	StreamingURL string `yaml:"streamingURL"`
+	// StreamingTokenTTL is a time streaming URLs returned by Exec, Attach and
+	// PortForward calls are valid for. Each URL may be used only once.
+	StreamingTokenTTL time.Duration `yaml:"streamingTokenTTL"`
+	// StreamingTLSCert and StreamingTLSKey are paths to PEM encoded certificate
+	// and key streaming requests are served over HTTPS with. Both must be set or empty.
+	StreamingTLSCert string `yaml:"streamingTLSCert"`
+	StreamingTLSKey  string `yaml:"streamingTLSKey"`
 	// CNIBinDir is a directory to look for CNI plugin binaries.
 	CNIBinDir string `yaml:"cniBinDir"`
 	// CNIConfDir is a directory to look for CNI network configuration files.
 	CNIConfDir string `yaml:"cniConfDir"`
+	// CNIConfTemplate is a template of CNI network configuration that is
+	// rendered into CNIConfDir once kubelet sets pod CIDR.
+	CNIConfTemplate string `yaml:"cniConfTemplate"`
+	// IPAMReconcileNetworks are names of CNI networks whose host-local IPAM
+	// allocations of removed pods are released, e.g. after daemon crash.
+	IPAMReconcileNetworks []string `yaml:"ipamReconcileNetworks"`
+	// IPAMReconcileInterval is how often IPAM allocations are reconciled.
+	// Negative value disables periodic reconciliation.
+	IPAMReconcileInterval time.Duration `yaml:"ipamReconcileInterval"`
+	// OrphanReapInterval is how often network namespaces, cgroups and mounts
+	// leaked by removed pods and containers are cleaned up.
+	// Negative value disables periodic cleanup.
+	OrphanReapInterval time.Duration `yaml:"orphanReapInterval"`
 	// BaseRunDir is a directory to store currently running pods and containers.
 	BaseRunDir string `yaml:"baseRunDir"`
 	// TrashDir is a directory where all container logs and configs will
 	// be stored upon removal. Useful for debugging.
 	TrashDir string `yaml:"trashDir"`
+	// CgroupDriver is either cgroupfs or systemd, it must match kubelet cgroup driver.
+	CgroupDriver string `yaml:"cgroupDriver"`
+	// WritableLayerSize is a default size limit in bytes of container
+	// writable layers, zero leaves them unlimited.
+	WritableLayerSize int64 `yaml:"writableLayerSize"`
+	// LiveRestore leaves pods and containers running on shutdown, so that
+	// they are restored from BaseRunDir when daemon is started again.
+	LiveRestore bool `yaml:"liveRestore"`
+	// ContainerMonitor makes each container supervised by a monitor process
+	// that forwards its output and records its exit while daemon is down.
+	ContainerMonitor bool `yaml:"containerMonitor"`
+	// LogDirOwner is an owner of created pod log directories in form of uid:gid.
+	LogDirOwner string `yaml:"logDirOwner"`
+	// RegistryAuthFile is a docker-style config file with node-level registry
+	// credentials used when image is pulled without any credentials.
+	RegistryAuthFile string `yaml:"registryAuthFile"`
+	// OCIImageLayout makes docker images stored in OCI layout as downloaded
+	// instead of being converted into SIF. Layers are unpacked into root
+	// filesystem shared by containers on first use instead.
+	OCIImageLayout bool `yaml:"ociImageLayout"`
+	// DisableDigestCheck forces images to be pulled again even if the
+	// docker tag still resolves to the digest of already present image.
+	DisableDigestCheck bool `yaml:"disableDigestCheck"`
+	// LenientImageNames makes uppercase docker repository names lowercased
+	// with a warning instead of being rejected.
+	LenientImageNames bool `yaml:"lenientImageNames"`
+	// FullImageCheck forces full image checksum verification on each container
+	// creation instead of a quick size and partial checksum check.
+	FullImageCheck bool `yaml:"fullImageCheck"`
+	// RedactedEnvs is a list of environment variable name patterns which
+	// values are hidden in verbose container and pod status and in logged requests.
+	RedactedEnvs []string `yaml:"redactedEnvs"`
+	// RestrictHostPaths allows bind mounts of host paths under
+	// AllowedHostPaths only.
+	RestrictHostPaths bool `yaml:"restrictHostPaths"`
+	// AllowedHostPaths is a list of host path prefixes that may be bind
+	// mounted when RestrictHostPaths is set. Kubelet paths are allowed by default.
+	AllowedHostPaths []string `yaml:"allowedHostPaths"`
+	// PrivilegedHostPaths allows privileged containers to bind mount
+	// any host path even if RestrictHostPaths is set.
+	PrivilegedHostPaths bool `yaml:"privilegedHostPaths"`
+	// DisableSELinuxRelabel makes host paths of mounts requesting SELinux
+	// relabeling be mounted as is, e.g. when they are labeled by administrator.
+	DisableSELinuxRelabel bool `yaml:"disableSELinuxRelabel"`
+	// RestrictOOMScoreAdj makes containers never get oom_score_adj lower
+	// than Singularity-CRI itself has, e.g. when it runs in user namespace.
+	RestrictOOMScoreAdj bool `yaml:"restrictOOMScoreAdj"`
+	// NUMAMemoryBinding binds memory of containers pinned to CPUs, e.g. by kubelet
+	// static CPU manager policy, to NUMA nodes local to those CPUs.
+	NUMAMemoryBinding bool `yaml:"numaMemoryBinding"`
+	// AllowedUnsafeSysctls is a list of unsafe sysctls pods may set in
+	// addition to safe ones, entry ending with * allows sysctls with that prefix.
+	AllowedUnsafeSysctls []string `yaml:"allowedUnsafeSysctls"`
+	// SeccompProfileRoot is a directory relative localhost seccomp
+	// profiles are looked up in, kubelet one by default.
+	SeccompProfileRoot string `yaml:"seccompProfileRoot"`
+	// AnnotationPassthrough is a list of annotation patterns that are copied
+	// into OCI spec in addition to Kubernetes and Singularity-CRI annotations.
+	AnnotationPassthrough []string `yaml:"annotationPassthrough"`
+	// PinnedImages is a list of image references that cannot be removed,
+	// e.g. by kubelet image GC. List is re-read from config on SIGHUP.
+	PinnedImages []string `yaml:"pinnedImages"`
+	// WarmImages is a list of image references that are pulled on startup,
+	// retrying failed pulls with backoff, and cannot be removed.
+	WarmImages []string `yaml:"warmImages"`
+	// PreloadPinTTL is a time images preloaded via ImageAdmin service
+	// cannot be removed for. Negative value disables pinning.
+	PreloadPinTTL time.Duration `yaml:"preloadPinTTL"`
+	// ImageStorageReserve is free space pulls must leave on image storage
+	// filesystem, e.g. 10%, 5Gi or both comma separated, the larger is used.
+	ImageStorageReserve string `yaml:"imageStorageReserve"`
+	// PullStallTimeout is a time pull may transfer no data for before
+	// it is aborted. Negative value disables stall detection.
+	PullStallTimeout time.Duration `yaml:"pullStallTimeout"`
+	// PullBandwidth limits bandwidth of image downloads.
+	// Limits are re-read from config on SIGHUP.
+	PullBandwidth PullBandwidthConfig `yaml:"pullBandwidth"`
+	// Registries configures registry mirrors and the pull-through image
+	// cache, e.g. for air-gapped clusters. Re-read from config on SIGHUP.
+	Registries RegistriesConfig `yaml:"registries"`
+	// MaxConcurrentPulls is a number of image downloads run at the same
+	// time, other pulls wait for a free slot. Zero means unlimited.
+	MaxConcurrentPulls int `yaml:"maxConcurrentPulls"`
+	// ConcurrentLayerDownloads is a number of layers of a single image
+	// downloaded at the same time when ociImageLayout is enabled.
+	ConcurrentLayerDownloads int `yaml:"concurrentLayerDownloads"`
+	// ImageGCHighWatermark is image storage filesystem usage percent above
+	// which least recently used images are removed by CRI itself. Zero
+	// disables local image GC leaving it up to kubelet.
+	ImageGCHighWatermark int `yaml:"imageGCHighWatermark"`
+	// ImageGCLowWatermark is usage percent local image GC frees space to.
+	ImageGCLowWatermark int `yaml:"imageGCLowWatermark"`
+	// ImageGCInterval is how often image storage usage is checked.
+	ImageGCInterval time.Duration `yaml:"imageGCInterval"`
+	// ImageGCDryRun makes local image GC log images it would
+	// remove instead of removing them.
+	ImageGCDryRun bool `yaml:"imageGCDryRun"`
+	// ImageUsageInterval is how often disk usage of image layer
+	// cache reported to kubelet in ImageFsInfo is rescanned.
+	ImageUsageInterval time.Duration `yaml:"imageUsageInterval"`
+	// SignaturePolicy is either any-trusted or all-trusted and defines
+	// whether any or all signatures of a pulled SIF must be verified.
+	SignaturePolicy string `yaml:"signaturePolicy"`
+	// KeyServer is a key server signing keys missing in the
+	// local keyring are fetched from during verification.
+	KeyServer string `yaml:"keyServer"`
+	// ImageTrust is a list of per registry and per namespace
+	// signature requirements of pulled images.
+	ImageTrust []TrustRuleConfig `yaml:"imageTrust"`
+	// LogDriver is a log driver of containers that do not select one with
+	// singularity.cri/log-driver annotation: file, journald or null.
+	LogDriver string `yaml:"logDriver"`
+	// LogBufferSize is a size in bytes of the buffer container output
+	// forwarded by the daemon goes through, e.g. for journald driver.
+	LogBufferSize int `yaml:"logBufferSize"`
+	// LogOverflow is either block or drop and defines whether container
+	// is blocked on write or its output is dropped when log buffer is full.
+	LogOverflow string `yaml:"logOverflow"`
+	// LogMaxSize is a size in bytes CRI log file is rotated by the daemon at,
+	// for clusters where kubelet does not rotate logs. Zero disables rotation.
+	LogMaxSize int64 `yaml:"logMaxSize"`
+	// LogMaxFiles is a number of log files kept per container
+	// including the current one when LogMaxSize is set.
+	LogMaxFiles int `yaml:"logMaxFiles"`
+	// AttachReplaySize is a size in bytes of the most recent container output
+	// replayed to clients attaching to container. Output of all containers is
+	// forwarded by the daemon when replay is enabled. Negative value disables replay.
+	AttachReplaySize int `yaml:"attachReplaySize"`
+	// ExecSyncOutputLimit is a size in bytes of each output stream captured
+	// by ExecSync, output beyond it is discarded. Negative value captures all output.
+	ExecSyncOutputLimit int `yaml:"execSyncOutputLimit"`
+	// ContainerEventBufferSize is a number of container events buffered for
+	// each GetContainerEvents client, events beyond it are dropped.
+	ContainerEventBufferSize int `yaml:"containerEventBufferSize"`
+	// Hooks is a list of commands run on pod and container lifecycle events.
+	Hooks []HookConfig `yaml:"hooks"`
+	// Admission is a policy pods and containers are admitted with.
+	Admission AdmissionConfig `yaml:"admission"`
+	// OCIHooksDirs is a list of hooks.d directories OCI hook definitions
+	// are loaded from, hooks are injected into specs of matching containers.
+	OCIHooksDirs []string `yaml:"ociHooksDirs"`
+	// ContainerDefaults are node-wide values applied to every container.
+	ContainerDefaults ContainerDefaultsConfig `yaml:"containerDefaults"`
+	// WarmPools is a list of pre-created pod sandbox pools.
+	WarmPools []WarmPoolConfig `yaml:"warmPools"`
+	// RuntimeHandlers is a list of runtime handlers RuntimeClass may refer
+	// to in addition to the default singularity one.
+	RuntimeHandlers []RuntimeHandlerConfig `yaml:"runtimeHandlers"`
+	// UserNamespace sets ID mappings pods are run with in user namespaces.
+	// Pods are run in the host user namespace when no mappings are set.
+	UserNamespace UserNamespaceConfig `yaml:"userNamespace"`
+	// MaxHotExitedContainers is a number of most recently exited containers
+	// kept in full, older ones are compacted to status-only records.
+	// Negative value disables the limit.
+	MaxHotExitedContainers int `yaml:"maxHotExitedContainers"`
+	// ExitedCompactAge is a time since exit after which exited container is
+	// compacted to status-only record. Negative value disables compaction by age.
+	ExitedCompactAge time.Duration `yaml:"exitedCompactAge"`
+	// StatsInterval is how often usage of running containers is sampled
+	// for stats requests. Negative value makes stats collected on request.
+	StatsInterval time.Duration `yaml:"statsInterval"`
+	// OOMWatchInterval is how often running containers are checked for
+	// processes killed by OOM killer. Negative value disables checks.
+	OOMWatchInterval time.Duration `yaml:"oomWatchInterval"`
+	// MaxListAnnotationsSize is a size limit in bytes of each container's
+	// annotations in list responses. Negative value disables the limit.
+	MaxListAnnotationsSize int `yaml:"maxListAnnotationsSize"`
+	// DebugSandbox makes pods that fail to run keep their files, namespaces
+	// and partial bundle for inspection instead of removing them immediately.
+	DebugSandbox bool `yaml:"debugSandbox"`
+	// DebugSandboxRetention is a time failed pod artifacts are kept for.
+	DebugSandboxRetention time.Duration `yaml:"debugSandboxRetention"`
+	// HealthListen is a TCP address to serve /healthz and /readyz on.
+	// Empty value disables health endpoints.
+	HealthListen string `yaml:"healthListen"`
+	// HealthInterval is how often daemon health is evaluated.
+	HealthInterval time.Duration `yaml:"healthInterval"`
+	// HealthTimeout is a time each health check may take.
+	HealthTimeout time.Duration `yaml:"healthTimeout"`
+	// MetricsListen is a TCP address to serve /metrics on in Prometheus
+	// text format and /debug/pulls on. Empty value disables both endpoints.
+	MetricsListen string `yaml:"metricsListen"`
+	// MetricsTLSCert and MetricsTLSKey are paths to PEM encoded certificate
+	// and key metrics are served over HTTPS with. Both must be set or empty.
+	MetricsTLSCert string `yaml:"metricsTLSCert"`
+	MetricsTLSKey  string `yaml:"metricsTLSKey"`
+	// Tracing configures export of request traces.
+	Tracing TracingConfig `yaml:"tracing"`
+	// WarningRetention is a time recorded pod, container and image
+	// warnings are reported for after they were last seen.
+	WarningRetention time.Duration `yaml:"warningRetention"`
 	// When Debug is true all CRI requests and responses will be logged. When false
 	// only requests with error responses will be logged.
 	Debug bool `yaml:"debug"`
+	// LogLevel is a log verbosity level, -v flag overrides it.
+	LogLevel int `yaml:"logLevel"`
+}
+
+// TracingConfig holds OpenTelemetry trace export settings.
+type TracingConfig struct {
+	// Endpoint is an OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces.
+	// Empty value disables tracing.
+	Endpoint string `yaml:"endpoint"`
+	// Headers are set on each export request, e.g. to authenticate with collector.
+	Headers map[string]string `yaml:"headers"`
+	// ServiceName is a service name spans are reported with.
+	ServiceName string `yaml:"serviceName"`
+	// SampleRatio is a fraction of traces started by the daemon that are
+	// exported, zero means all of them.
+	SampleRatio float64 `yaml:"sampleRatio"`
+	// ExportInterval is how often finished spans are exported.
+	ExportInterval time.Duration `yaml:"exportInterval"`
+}
+
+// HookConfig is a single lifecycle hook command configuration.
+type HookConfig struct {
+	// Event is one of on-sandbox-ready, on-sandbox-removed,
+	// on-container-started or on-container-exited.
+	Event string `yaml:"event"`
+	// Command is an executable followed by its arguments.
+	Command []string `yaml:"command"`
+	// Timeout is time hook is killed after.
+	Timeout time.Duration `yaml:"timeout"`
+	// FailurePolicy is either log or fail.
+	FailurePolicy string `yaml:"failurePolicy"`
+}
+
+// AdmissionConfig holds built-in admission rules and webhook.
+type AdmissionConfig struct {
+	Rules   []AdmissionRuleConfig   `yaml:"rules"`
+	Webhook *AdmissionWebhookConfig `yaml:"webhook"`
+}
+
+// AdmissionRuleConfig is a single built-in admission rule configuration.
+type AdmissionRuleConfig struct {
+	// Name identifies rule in denial reasons.
+	Name string `yaml:"name"`
+	// Namespaces rule applies to, empty list means all namespaces.
+	Namespaces []string `yaml:"namespaces"`
+	// DenyPrivileged rejects privileged pods and containers.
+	DenyPrivileged bool `yaml:"denyPrivileged"`
+	// DenyHostNamespaces rejects pods sharing host network, PID or IPC namespace.
+	DenyHostNamespaces bool `yaml:"denyHostNamespaces"`
+	// DenyHostPaths is a list of host paths containers may not mount.
+	DenyHostPaths []string `yaml:"denyHostPaths"`
+	// RequireSignedImages rejects images without verified signature.
+	RequireSignedImages bool `yaml:"requireSignedImages"`
+}
+
+// AdmissionWebhookConfig is an external admission policy engine configuration.
+type AdmissionWebhookConfig struct {
+	// URL requests are posted to, e.g. OPA data API document URL.
+	URL string `yaml:"url"`
+	// Timeout is time webhook may respond in.
+	Timeout time.Duration `yaml:"timeout"`
+	// FailurePolicy is either fail or log.
+	FailurePolicy string `yaml:"failurePolicy"`
+}
+
+// WarmPoolConfig is a single warm sandbox pool configuration.
+type WarmPoolConfig struct {
+	// Handler is a runtime handler pool serves, empty means the default one.
+	Handler string `yaml:"handler"`
+	// Namespace is the only namespace whose pods may adopt pooled sandboxes.
+	Namespace string `yaml:"namespace"`
+	// Class is a class pods request with singularity.cri/warm-pool annotation.
+	Class string `yaml:"class"`
+	// Image is an image which root filesystem is kept mounted.
+	Image string `yaml:"image"`
+	// Size is a number of idle sandboxes kept.
+	Size int `yaml:"size"`
+	// IdleTimeout is time idle sandbox is kept for.
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	// RunAsUser and RunAsGroup are user and group sandboxes run as.
+	RunAsUser  *int64 `yaml:"runAsUser"`
+	RunAsGroup *int64 `yaml:"runAsGroup"`
+}
+
+// RuntimeHandlerConfig is a single runtime handler configuration.
+type RuntimeHandlerConfig struct {
+	// Name is a handler name RuntimeClass refers to.
+	Name string `yaml:"name"`
+	// Path is a path to singularity binary pods are run with.
+	Path string `yaml:"path"`
+	// Flags are global singularity flags passed to oci commands.
+	Flags []string `yaml:"flags"`
+}
+
+// UserNamespaceConfig holds user and group ID mappings of pod user namespaces.
+type UserNamespaceConfig struct {
+	UIDMappings []IDMappingConfig `yaml:"uidMappings"`
+	GIDMappings []IDMappingConfig `yaml:"gidMappings"`
+}
+
+// IDMappingConfig maps size IDs starting at containerID to ones starting at hostID.
+type IDMappingConfig struct {
+	ContainerID uint32 `yaml:"containerID"`
+	HostID      uint32 `yaml:"hostID"`
+	Size        uint32 `yaml:"size"`
+}
+
+// TrustRuleConfig is a single image trust rule configuration.
+type TrustRuleConfig struct {
+	// Scope is a registry optionally followed by namespace, or * for all images.
+	Scope string `yaml:"scope"`
+	// RequireSigned rejects images without signatures.
+	RequireSigned bool `yaml:"requireSigned"`
+	// Fingerprints is an allow list of signing key fingerprints.
+	Fingerprints []string `yaml:"fingerprints"`
+}
+
+// ContainerDefaultsConfig holds node-wide container defaults.
+type ContainerDefaultsConfig struct {
+	// Umask is an octal umask of container processes, e.g. 0022.
+	Umask string `yaml:"umask"`
+	// Env is a set of environment variables injected into containers.
+	Env map[string]string `yaml:"env"`
+	// Ulimits maps ulimit names, e.g. nofile, to soft[:hard] limits.
+	Ulimits map[string]string `yaml:"ulimits"`
+	// ProxyHostNetwork allows injecting proxy variables into host network pods.
+	ProxyHostNetwork bool `yaml:"proxyHostNetwork"`
+	// PidsLimit is a maximum number of processes in each container.
+	PidsLimit int64 `yaml:"pidsLimit"`
+}
+
+// PullBandwidthConfig holds image download bandwidth limits in bytes
+// per second, e.g. 50Mi. Empty or zero value means unlimited.
+type PullBandwidthConfig struct {
+	// Global is bandwidth shared by all pulls.
+	Global string `yaml:"global"`
+	// Registries maps registry host, e.g. docker.io, to bandwidth shared
+	// by pulls from it, Global still applies.
+	Registries map[string]string `yaml:"registries"`
+}
+
+// RegistriesConfig holds docker registry mirrors and pull-through cache settings.
+type RegistriesConfig struct {
+	// Hosts maps registry domain, e.g. docker.io, to its mirrors.
+	Hosts map[string]RegistryHostConfig `yaml:"hosts"`
+	// CacheDir is a directory pulled docker images are kept in and
+	// served from on consequent pulls. Empty value disables the cache.
+	CacheDir string `yaml:"cacheDir"`
+}
+
+// RegistryHostConfig is a single registry configuration.
+type RegistryHostConfig struct {
+	// Mirrors are tried in order before the registry itself.
+	Mirrors []MirrorConfig `yaml:"mirrors"`
+	// Insecure makes registry accessed over plain HTTP.
+	Insecure bool `yaml:"insecure"`
+}
+
+// MirrorConfig is a single registry mirror configuration.
+type MirrorConfig struct {
+	// Host is a mirror host optionally followed by path, e.g. harbor.local/dockerhub.
+	Host string `yaml:"host"`
+	// Insecure makes mirror accessed over plain HTTP.
+	Insecure bool `yaml:"insecure"`
 }
 
 var defaultConfig = Config{
@@ -75,11 +489,423 @@ func validConfig(config Config) (Config, error) {
 	if config.ListenSocket == "" {
 		return Config{}, fmt.Errorf("socket to serve cannot be empty")
 	}
+	if _, err := parseSocketMode(config.ListenSocketMode); err != nil {
+		return Config{}, err
+	}
+	if _, err := kube.ParseCgroupDriver(config.CgroupDriver); err != nil {
+		return Config{}, err
+	}
+	if config.WritableLayerSize != 0 && config.WritableLayerSize < kube.MinWritableLayerSize {
+		return Config{}, fmt.Errorf("writable layer size cannot be less than %d", kube.MinWritableLayerSize)
+	}
+	if _, err := parseOwner(config.ListenSocketOwner); err != nil {
+		return Config{}, fmt.Errorf("invalid socket owner: %v", err)
+	}
+	if config.DebugSocket != "" && config.DebugSocket == config.ListenSocket {
+		return Config{}, fmt.Errorf("debug socket must differ from socket to serve")
+	}
+	if config.ListenTCP != "" && (config.TLSCert == "" || config.TLSKey == "" || config.TLSClientCA == "") {
+		return Config{}, fmt.Errorf("TLS certificate, key and client CA are required to listen on TCP")
+	}
+	if config.MaxTCPConnections < 0 || config.MaxConcurrentStreams < 0 {
+		return Config{}, fmt.Errorf("connection and stream limits cannot be negative")
+	}
+	if config.MaxRecvMsgSize < 0 || config.MaxSendMsgSize < 0 {
+		return Config{}, fmt.Errorf("message size limits cannot be negative")
+	}
+	if config.StreamingTokenTTL < 0 {
+		return Config{}, fmt.Errorf("streaming token TTL cannot be negative")
+	}
+	if (config.StreamingTLSCert == "") != (config.StreamingTLSKey == "") {
+		return Config{}, fmt.Errorf("streaming TLS certificate and key must be set together")
+	}
 	if config.StorageDir == "" {
 		return Config{}, fmt.Errorf("directory to pull images cannot be empty")
 	}
 	if config.BaseRunDir == "" {
 		return Config{}, fmt.Errorf("directory to run containers cannot be empty")
 	}
+	if _, err := parseOwner(config.LogDirOwner); err != nil {
+		return Config{}, fmt.Errorf("invalid log directory owner: %v", err)
+	}
+	if _, err := image.ParseStorageReserve(config.ImageStorageReserve); err != nil {
+		return Config{}, err
+	}
+	if _, err := pullLimits(config); err != nil {
+		return Config{}, err
+	}
+	if err := registries(config).Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid registries: %v", err)
+	}
+	if err := sysctlPolicy(config).Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid allowed unsafe sysctls: %v", err)
+	}
+	if config.MaxConcurrentPulls < 0 {
+		return Config{}, fmt.Errorf("max concurrent pulls cannot be negative")
+	}
+	if config.ConcurrentLayerDownloads < 0 {
+		return Config{}, fmt.Errorf("concurrent layer downloads cannot be negative")
+	}
+	if gc := imageGC(config); gc != nil {
+		if err := gc.Validate(); err != nil {
+			return Config{}, err
+		}
+	}
+	if _, err := sImage.ParseSignaturePolicy(config.SignaturePolicy); err != nil {
+		return Config{}, err
+	}
+	if config.KeyServer != "" {
+		if u, err := url.Parse(config.KeyServer); err != nil || u.Scheme == "" || u.Host == "" {
+			return Config{}, fmt.Errorf("invalid key server %q", config.KeyServer)
+		}
+	}
+	scopes := make(map[string]bool)
+	for _, rule := range trustRules(config) {
+		if err := rule.Validate(); err != nil {
+			return Config{}, fmt.Errorf("invalid image trust rule: %v", err)
+		}
+		if scopes[rule.Scope] {
+			return Config{}, fmt.Errorf("duplicate image trust rule %s", rule.Scope)
+		}
+		scopes[rule.Scope] = true
+	}
+	if _, err := kube.ParseLogDriver(config.LogDriver); err != nil {
+		return Config{}, err
+	}
+	if config.LogBufferSize != 0 && config.LogBufferSize < kube.MinLogBufferSize {
+		return Config{}, fmt.Errorf("log buffer size cannot be less than %d", kube.MinLogBufferSize)
+	}
+	if _, err := kube.ParseLogOverflow(config.LogOverflow); err != nil {
+		return Config{}, err
+	}
+	if err := logRotation(config).Validate(); err != nil {
+		return Config{}, err
+	}
+	if _, err := containerDefaults(config); err != nil {
+		return Config{}, fmt.Errorf("invalid container defaults: %v", err)
+	}
+	if config.SeccompProfileRoot != "" && !filepath.IsAbs(config.SeccompProfileRoot) {
+		return Config{}, fmt.Errorf("seccomp profile root must be an absolute path")
+	}
+	if config.ContainerEventBufferSize < 0 {
+		return Config{}, fmt.Errorf("container event buffer size cannot be negative")
+	}
+	if config.LogLevel < 0 {
+		return Config{}, fmt.Errorf("log level cannot be negative")
+	}
+	if config.HealthInterval < 0 || config.HealthTimeout < 0 {
+		return Config{}, fmt.Errorf("health interval and timeout cannot be negative")
+	}
+	if (config.MetricsTLSCert == "") != (config.MetricsTLSKey == "") {
+		return Config{}, fmt.Errorf("metrics TLS certificate and key must be set together")
+	}
+	if config.MetricsTLSCert != "" && config.MetricsListen == "" {
+		return Config{}, fmt.Errorf("metrics TLS is set while metrics listen address is empty")
+	}
+	if err := validTracing(config.Tracing); err != nil {
+		return Config{}, fmt.Errorf("invalid tracing config: %v", err)
+	}
+	if config.ImageUsageInterval < 0 {
+		return Config{}, fmt.Errorf("image usage interval cannot be negative")
+	}
+	if config.WarningRetention < 0 {
+		return Config{}, fmt.Errorf("warning retention cannot be negative")
+	}
+	if err := admissionPolicy(config).Validate(); err != nil {
+		return Config{}, err
+	}
+	for _, hook := range lifecycleHooks(config) {
+		if err := hook.Validate(); err != nil {
+			return Config{}, fmt.Errorf("invalid hook: %v", err)
+		}
+	}
+	if _, err := kube.LoadOCIHooks(config.OCIHooksDirs); err != nil {
+		return Config{}, err
+	}
+	handlers := map[string]bool{"": true, singularity.RuntimeName: true}
+	for _, h := range runtimeHandlers(config) {
+		if err := h.Validate(); err != nil {
+			return Config{}, fmt.Errorf("invalid runtime handler: %v", err)
+		}
+		if handlers[h.Name] {
+			return Config{}, fmt.Errorf("duplicate runtime handler %s", h.Name)
+		}
+		handlers[h.Name] = true
+	}
+	if userNs := userNamespace(config); userNs != nil {
+		if err := userNs.Validate(); err != nil {
+			return Config{}, fmt.Errorf("invalid user namespace: %v", err)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, pool := range warmPools(config) {
+		if err := pool.Validate(); err != nil {
+			return Config{}, fmt.Errorf("invalid warm pool: %v", err)
+		}
+		if !handlers[pool.Handler] {
+			return Config{}, fmt.Errorf("warm pool %s refers to unknown runtime handler %s", pool.Class, pool.Handler)
+		}
+		key := pool.Handler + "/" + pool.Namespace + "/" + pool.Class
+		if seen[key] {
+			return Config{}, fmt.Errorf("duplicate warm pool %s in namespace %s", pool.Class, pool.Namespace)
+		}
+		seen[key] = true
+	}
 	return config, nil
 }
+
+// redactedEnvs returns patterns of environment variable names
+// which values must not be shown.
+func redactedEnvs(config Config) []string {
+	if config.RedactedEnvs != nil {
+		return config.RedactedEnvs
+	}
+	return runtime.DefaultRedactedEnvs
+}
+
+// pullLimits returns image download bandwidth limits set by config.
+func pullLimits(config Config) (sImage.PullLimits, error) {
+	return image.ParsePullLimits(config.PullBandwidth.Global, config.PullBandwidth.Registries)
+}
+
+// registries returns registry mirrors and pull-through cache set by config.
+// When neither is set nil is returned.
+func registries(config Config) *sImage.Registries {
+	if len(config.Registries.Hosts) == 0 && config.Registries.CacheDir == "" {
+		return nil
+	}
+	r := &sImage.Registries{
+		Hosts:    make(map[string]sImage.RegistryConfig, len(config.Registries.Hosts)),
+		CacheDir: config.Registries.CacheDir,
+	}
+	for domain, h := range config.Registries.Hosts {
+		var mirrors []sImage.Mirror
+		for _, m := range h.Mirrors {
+			mirrors = append(mirrors, sImage.Mirror{
+				Host:     m.Host,
+				Insecure: m.Insecure,
+			})
+		}
+		r.Hosts[domain] = sImage.RegistryConfig{
+			Mirrors:  mirrors,
+			Insecure: h.Insecure,
+		}
+	}
+	return r
+}
+
+// logRotation returns CRI log file rotation policy set by config.
+func logRotation(config Config) kube.LogRotation {
+	return kube.LogRotation{
+		MaxSize:  config.LogMaxSize,
+		MaxFiles: config.LogMaxFiles,
+	}
+}
+
+// imageGC returns local image GC policy set by config. When local
+// image GC is disabled nil is returned. Low watermark defaults to
+// 10% below the high one.
+func imageGC(config Config) *image.ImageGC {
+	if config.ImageGCHighWatermark == 0 {
+		return nil
+	}
+	low := config.ImageGCLowWatermark
+	if low == 0 {
+		low = config.ImageGCHighWatermark - 10
+	}
+	return &image.ImageGC{
+		HighWatermark: config.ImageGCHighWatermark,
+		LowWatermark:  low,
+		Interval:      config.ImageGCInterval,
+		DryRun:        config.ImageGCDryRun,
+	}
+}
+
+// lifecycleHooks returns runtime hooks set by config.
+func lifecycleHooks(config Config) []runtime.Hook {
+	var hooks []runtime.Hook
+	for _, h := range config.Hooks {
+		hooks = append(hooks, runtime.Hook{
+			Event:   runtime.HookEvent(h.Event),
+			Command: h.Command,
+			Timeout: h.Timeout,
+			Failure: runtime.HookFailurePolicy(h.FailurePolicy),
+		})
+	}
+	return hooks
+}
+
+// admissionPolicy returns admission policy set by config,
+// nil is returned when there is none.
+func admissionPolicy(config Config) *runtime.AdmissionPolicy {
+	if len(config.Admission.Rules) == 0 && config.Admission.Webhook == nil {
+		return nil
+	}
+	policy := new(runtime.AdmissionPolicy)
+	for _, r := range config.Admission.Rules {
+		policy.Rules = append(policy.Rules, runtime.AdmissionRule{
+			Name:                r.Name,
+			Namespaces:          r.Namespaces,
+			DenyPrivileged:      r.DenyPrivileged,
+			DenyHostNamespaces:  r.DenyHostNamespaces,
+			DenyHostPaths:       r.DenyHostPaths,
+			RequireSignedImages: r.RequireSignedImages,
+		})
+	}
+	if w := config.Admission.Webhook; w != nil {
+		policy.Webhook = &runtime.AdmissionWebhook{
+			URL:     w.URL,
+			Timeout: w.Timeout,
+			Failure: runtime.HookFailurePolicy(w.FailurePolicy),
+		}
+	}
+	return policy
+}
+
+// warmPools returns warm sandbox pools set by config.
+func warmPools(config Config) []runtime.WarmPool {
+	var pools []runtime.WarmPool
+	for _, p := range config.WarmPools {
+		pools = append(pools, runtime.WarmPool{
+			Handler:     p.Handler,
+			Namespace:   p.Namespace,
+			Class:       p.Class,
+			Image:       p.Image,
+			RunAsUser:   p.RunAsUser,
+			RunAsGroup:  p.RunAsGroup,
+			Size:        p.Size,
+			IdleTimeout: p.IdleTimeout,
+		})
+	}
+	return pools
+}
+
+// runtimeHandlers returns runtime handlers set by config.
+func runtimeHandlers(config Config) []runtime.RuntimeHandler {
+	var handlers []runtime.RuntimeHandler
+	for _, h := range config.RuntimeHandlers {
+		handlers = append(handlers, runtime.RuntimeHandler{
+			Name:  h.Name,
+			Path:  h.Path,
+			Flags: h.Flags,
+		})
+	}
+	return handlers
+}
+
+// userNamespace returns ID mappings of pod user namespaces set by config.
+// When no mappings are set nil is returned.
+func userNamespace(config Config) *kube.UserNamespace {
+	if len(config.UserNamespace.UIDMappings) == 0 && len(config.UserNamespace.GIDMappings) == 0 {
+		return nil
+	}
+	mappings := func(config []IDMappingConfig) []specs.LinuxIDMapping {
+		var mappings []specs.LinuxIDMapping
+		for _, m := range config {
+			mappings = append(mappings, specs.LinuxIDMapping{
+				ContainerID: m.ContainerID,
+				HostID:      m.HostID,
+				Size:        m.Size,
+			})
+		}
+		return mappings
+	}
+	return &kube.UserNamespace{
+		UIDMappings: mappings(config.UserNamespace.UIDMappings),
+		GIDMappings: mappings(config.UserNamespace.GIDMappings),
+	}
+}
+
+// trustRules returns image trust rules set by config.
+func trustRules(config Config) []sImage.TrustRule {
+	var rules []sImage.TrustRule
+	for _, r := range config.ImageTrust {
+		rules = append(rules, sImage.TrustRule{
+			Scope:         r.Scope,
+			RequireSigned: r.RequireSigned,
+			Fingerprints:  r.Fingerprints,
+		})
+	}
+	return rules
+}
+
+// containerDefaults returns node-wide container defaults set by config.
+// When no defaults are set nil is returned.
+func containerDefaults(config Config) (*kube.ContainerDefaults, error) {
+	c := config.ContainerDefaults
+	if c.Umask == "" && len(c.Env) == 0 && len(c.Ulimits) == 0 && c.PidsLimit == 0 {
+		return nil, nil
+	}
+	if c.PidsLimit < 0 {
+		return nil, fmt.Errorf("pids limit cannot be negative")
+	}
+	umask, err := kube.ParseUmask(c.Umask)
+	if err != nil {
+		return nil, err
+	}
+	defaults := &kube.ContainerDefaults{
+		Umask:            umask,
+		Env:              c.Env,
+		ProxyHostNetwork: c.ProxyHostNetwork,
+		PidsLimit:        c.PidsLimit,
+	}
+	for name := range c.Env {
+		if name == "" || strings.ContainsRune(name, '=') {
+			return nil, fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	names := make([]string, 0, len(c.Ulimits))
+	for name := range c.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rlimit, err := kube.ParseRlimit(name, c.Ulimits[name])
+		if err != nil {
+			return nil, err
+		}
+		defaults.Rlimits = append(defaults.Rlimits, rlimit)
+	}
+	return defaults, nil
+}
+
+// mountPolicy returns host path mount policy set by config.
+func mountPolicy(config Config) *kube.MountPolicy {
+	if !config.RestrictHostPaths {
+		return nil
+	}
+	allowed := config.AllowedHostPaths
+	if len(allowed) == 0 {
+		allowed = kube.DefaultAllowedHostPaths
+	}
+	return &kube.MountPolicy{
+		AllowedPrefixes:  allowed,
+		PrivilegedBypass: config.PrivilegedHostPaths,
+	}
+}
+
+// sysctlPolicy returns policy sysctls requested for pods are checked against.
+func sysctlPolicy(config Config) *kube.SysctlPolicy {
+	return &kube.SysctlPolicy{AllowedUnsafe: config.AllowedUnsafeSysctls}
+}
+
+// parseOwner parses owner in form of uid:gid. Empty
+// owner is valid and results in nil.
+func parseOwner(owner string) (*kube.Owner, error) {
+	if owner == "" {
+		return nil, nil
+	}
+	parts := strings.Split(owner, ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("expected uid:gid, got %q", owner)
+	}
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil || uid < 0 {
+		return nil, fmt.Errorf("invalid uid %q", parts[0])
+	}
+	gid, err := strconv.Atoi(parts[1])
+	if err != nil || gid < 0 {
+		return nil, fmt.Errorf("invalid gid %q", parts[1])
+	}
+	return &kube.Owner{UID: uid, GID: gid}, nil
+}
@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/kube"
 )
 
 func TestParseConfig(t *testing.T) {
@@ -134,6 +135,402 @@ func TestValidConfig(t *testing.T) {
 			expectConfig: Config{},
 			expectError:  fmt.Errorf("directory to run containers cannot be empty"),
 		},
+		{
+			name: "invalid storage reserve",
+			input: Config{
+				ListenSocket:        "/var/run/sycri.sock",
+				StorageDir:          "/var/lib/singularity",
+				BaseRunDir:          "/var/run/cri",
+				ImageStorageReserve: "5GB",
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid storage reserve \"5GB\": bad size"),
+		},
+		{
+			name: "invalid image gc watermarks",
+			input: Config{
+				ListenSocket:         "/var/run/sycri.sock",
+				StorageDir:           "/var/lib/singularity",
+				BaseRunDir:           "/var/run/cri",
+				ImageGCHighWatermark: 80,
+				ImageGCLowWatermark:  90,
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid image GC watermarks 80%%/90%%: 0 < low < high <= 100 is required"),
+		},
+		{
+			name: "invalid signature policy",
+			input: Config{
+				ListenSocket:    "/var/run/sycri.sock",
+				StorageDir:      "/var/lib/singularity",
+				BaseRunDir:      "/var/run/cri",
+				SignaturePolicy: "none-trusted",
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("unknown signature policy \"none-trusted\""),
+		},
+		{
+			name: "invalid log driver",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				LogDriver:    "syslog",
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("unknown log driver \"syslog\""),
+		},
+		{
+			name: "small log buffer",
+			input: Config{
+				ListenSocket:  "/var/run/sycri.sock",
+				StorageDir:    "/var/lib/singularity",
+				BaseRunDir:    "/var/run/cri",
+				LogBufferSize: 1024,
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("log buffer size cannot be less than 65536"),
+		},
+		{
+			name: "single log file",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				LogMaxSize:   10 << 20,
+				LogMaxFiles:  1,
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("log max files must be at least 2, got 1"),
+		},
+		{
+			name: "invalid log overflow",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				LogOverflow:  "spill",
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("unknown log overflow policy \"spill\""),
+		},
+		{
+			name: "invalid pull bandwidth",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				PullBandwidth: PullBandwidthConfig{
+					Global:     "100Mi",
+					Registries: map[string]string{"docker.io": "fast"},
+				},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid docker.io pull bandwidth \"fast\": bad size"),
+		},
+		{
+			name: "mirror with scheme",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				Registries: RegistriesConfig{
+					Hosts: map[string]RegistryHostConfig{
+						"docker.io": {Mirrors: []MirrorConfig{{Host: "https://mirror.local"}}},
+					},
+				},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid registries: registry docker.io: mirror \"https://mirror.local\" must not include scheme"),
+		},
+		{
+			name: "relative registry cache",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				Registries:   RegistriesConfig{CacheDir: "cache"},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid registries: cache directory \"cache\" is not absolute"),
+		},
+		{
+			name: "negative max concurrent pulls",
+			input: Config{
+				ListenSocket:       "/var/run/sycri.sock",
+				StorageDir:         "/var/lib/singularity",
+				BaseRunDir:         "/var/run/cri",
+				MaxConcurrentPulls: -1,
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("max concurrent pulls cannot be negative"),
+		},
+		{
+			name: "unsafe sysctl not namespaced",
+			input: Config{
+				ListenSocket:         "/var/run/sycri.sock",
+				StorageDir:           "/var/lib/singularity",
+				BaseRunDir:           "/var/run/cri",
+				AllowedUnsafeSysctls: []string{"vm.*"},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf(`invalid allowed unsafe sysctls: unsafe sysctl pattern "vm.*" is not namespaced`),
+		},
+		{
+			name: "negative concurrent layer downloads",
+			input: Config{
+				ListenSocket:             "/var/run/sycri.sock",
+				StorageDir:               "/var/lib/singularity",
+				BaseRunDir:               "/var/run/cri",
+				ConcurrentLayerDownloads: -1,
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("concurrent layer downloads cannot be negative"),
+		},
+		{
+			name: "negative container event buffer size",
+			input: Config{
+				ListenSocket:             "/var/run/sycri.sock",
+				StorageDir:               "/var/lib/singularity",
+				BaseRunDir:               "/var/run/cri",
+				ContainerEventBufferSize: -1,
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("container event buffer size cannot be negative"),
+		},
+		{
+			name: "invalid default ulimit",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				ContainerDefaults: ContainerDefaultsConfig{
+					Ulimits: map[string]string{"nofile": "4096:1024"},
+				},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid container defaults: invalid nofile ulimit \"4096:1024\": soft limit exceeds hard one"),
+		},
+		{
+			name: "negative pids limit",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				ContainerDefaults: ContainerDefaultsConfig{
+					PidsLimit: -1,
+				},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid container defaults: pids limit cannot be negative"),
+		},
+		{
+			name: "invalid hook",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				Hooks: []HookConfig{
+					{Event: "on-sandbox-ready", Command: []string{"/usr/local/bin/register"}, FailurePolicy: "retry"},
+				},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid hook: unknown on-sandbox-ready hook failure policy \"retry\""),
+		},
+		{
+			name: "duplicate warm pool",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				WarmPools: []WarmPoolConfig{
+					{Namespace: "batch", Class: "small", Image: "busybox", Size: 2},
+					{Namespace: "batch", Class: "small", Image: "alpine", Size: 1},
+				},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("duplicate warm pool small in namespace batch"),
+		},
+		{
+			name: "duplicate runtime handler",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				RuntimeHandlers: []RuntimeHandlerConfig{
+					{Name: "userns", Path: "/opt/singularity/bin/singularity"},
+					{Name: "userns"},
+				},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("duplicate runtime handler userns"),
+		},
+		{
+			name: "user namespace without gid mappings",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				UserNamespace: UserNamespaceConfig{
+					UIDMappings: []IDMappingConfig{{ContainerID: 0, HostID: 100000, Size: 65536}},
+				},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid user namespace: invalid gid mappings: at least one mapping must be set"),
+		},
+		{
+			name: "warm pool of unknown runtime handler",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				WarmPools: []WarmPoolConfig{
+					{Handler: "kata", Namespace: "batch", Class: "small", Image: "busybox", Size: 2},
+				},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("warm pool small refers to unknown runtime handler kata"),
+		},
+		{
+			name: "invalid trust rule fingerprint",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				ImageTrust: []TrustRuleConfig{
+					{Scope: "cloud.sylabs.io/sylabs", Fingerprints: []string{"not-a-key"}},
+				},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid image trust rule: trust rule cloud.sylabs.io/sylabs has invalid fingerprint \"NOT-A-KEY\""),
+		},
+		{
+			name: "duplicate trust rule",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				ImageTrust: []TrustRuleConfig{
+					{Scope: "*", RequireSigned: true},
+					{Scope: "*"},
+				},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("duplicate image trust rule *"),
+		},
+		{
+			name: "invalid key server",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				KeyServer:    "keys.example.com",
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid key server \"keys.example.com\""),
+		},
+		{
+			name: "relative seccomp profile root",
+			input: Config{
+				ListenSocket:       "/var/run/sycri.sock",
+				StorageDir:         "/var/lib/singularity",
+				BaseRunDir:         "/var/run/cri",
+				SeccompProfileRoot: "seccomp",
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("seccomp profile root must be an absolute path"),
+		},
+		{
+			name: "metrics TLS key without certificate",
+			input: Config{
+				ListenSocket:  "/var/run/sycri.sock",
+				StorageDir:    "/var/lib/singularity",
+				BaseRunDir:    "/var/run/cri",
+				MetricsListen: "127.0.0.1:9811",
+				MetricsTLSKey: "/etc/sycri/metrics.key",
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("metrics TLS certificate and key must be set together"),
+		},
+		{
+			name: "tracing endpoint without scheme",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				Tracing:      TracingConfig{Endpoint: "localhost:4318/v1/traces"},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid tracing config: endpoint must be an absolute http or https URL"),
+		},
+		{
+			name: "tracing sample ratio above one",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				Tracing:      TracingConfig{Endpoint: "http://localhost:4318/v1/traces", SampleRatio: 2},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid tracing config: sample ratio must be between 0 and 1"),
+		},
+		{
+			name: "admission rule without name",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				Admission:    AdmissionConfig{Rules: []AdmissionRuleConfig{{DenyPrivileged: true}}},
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("admission rule name cannot be empty"),
+		},
+		{
+			name: "invalid socket mode",
+			input: Config{
+				ListenSocket:     "/var/run/sycri.sock",
+				ListenSocketMode: "0999",
+				StorageDir:       "/var/lib/singularity",
+				BaseRunDir:       "/var/run/cri",
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("invalid socket mode \"0999\": expected octal permissions, e.g. 0660"),
+		},
+		{
+			name: "unknown cgroup driver",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+				CgroupDriver: "cgroupv2",
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("unknown cgroup driver \"cgroupv2\""),
+		},
+		{
+			name: "TCP without client CA",
+			input: Config{
+				ListenSocket: "/var/run/sycri.sock",
+				ListenTCP:    "0.0.0.0:10010",
+				TLSCert:      "/etc/sycri/tls.crt",
+				TLSKey:       "/etc/sycri/tls.key",
+				StorageDir:   "/var/lib/singularity",
+				BaseRunDir:   "/var/run/cri",
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("TLS certificate, key and client CA are required to listen on TCP"),
+		},
+		{
+			name: "negative message size",
+			input: Config{
+				ListenSocket:   "/var/run/sycri.sock",
+				MaxRecvMsgSize: -1,
+				StorageDir:     "/var/lib/singularity",
+				BaseRunDir:     "/var/run/cri",
+			},
+			expectConfig: Config{},
+			expectError:  fmt.Errorf("message size limits cannot be negative"),
+		},
 		{
 			name: "minimum valid",
 			input: Config{
@@ -179,3 +576,48 @@ func TestValidConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestParseOwner(t *testing.T) {
+	tt := []struct {
+		name        string
+		owner       string
+		expectOwner *kube.Owner
+		expectError bool
+	}{
+		{
+			name: "empty owner",
+		},
+		{
+			name:        "uid and gid",
+			owner:       "1000:1001",
+			expectOwner: &kube.Owner{UID: 1000, GID: 1001},
+		},
+		{
+			name:        "uid only",
+			owner:       "1000",
+			expectError: true,
+		},
+		{
+			name:        "user name",
+			owner:       "sasha:1000",
+			expectError: true,
+		},
+		{
+			name:        "negative gid",
+			owner:       "1000:-1",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			owner, err := parseOwner(tc.owner)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectOwner, owner)
+		})
+	}
+}
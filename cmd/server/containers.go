@@ -0,0 +1,112 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"google.golang.org/grpc"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const listContainersCmd = "list-containers"
+
+// labelSelector is a repeatable key=value flag.
+type labelSelector map[string]string
+
+func (l labelSelector) String() string {
+	var pairs []string
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l labelSelector) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("label must be in key=value form")
+	}
+	l[kv[0]] = kv[1]
+	return nil
+}
+
+// runListContainers executes list-containers subcommand that pages
+// through containers with RuntimeAdmin service of the running Singularity-CRI.
+func runListContainers(args []string) error {
+	flags := flag.NewFlagSet(listContainersCmd, flag.ContinueOnError)
+	socket := flags.String("socket", defaultConfig.ListenSocket, "Singularity-CRI socket")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of each page request")
+	pageSize := flags.Int("page-size", 0, "number of containers fetched per request, server default is used when not set")
+	pod := flags.String("pod", "", "list containers of this pod only")
+	labels := make(labelSelector)
+	flags.Var(labels, "label", "list containers with this key=value label only, may be repeated")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options]\n", os.Args[0], listContainersCmd)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("unexpected number of arguments")
+	}
+
+	conn, err := grpc.Dial("unix://"+*socket, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("could not dial %s: %v", *socket, err)
+	}
+	defer conn.Close()
+	client := admin.NewRuntimeAdminClient(conn)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTAINER ID\tNAME\tATTEMPT\tSTATE\tPOD ID\tCREATED")
+	req := &admin.ListContainersPageRequest{
+		PageSize:      int32(*pageSize),
+		PodSandboxId:  *pod,
+		LabelSelector: labels,
+	}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		resp, err := client.ListContainersPage(ctx, req)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("could not list containers: %v", err)
+		}
+		writeContainers(tw, resp.Containers)
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	return tw.Flush()
+}
+
+func writeContainers(w io.Writer, containers []*admin.ContainerSummary) {
+	for _, c := range containers {
+		state := strings.TrimPrefix(k8s.ContainerState(c.State).String(), "CONTAINER_")
+		created := time.Unix(0, c.CreatedAt).Format(time.RFC3339)
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", c.Id, c.Name, c.Attempt, state, c.PodSandboxId, created)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/server/image"
+	"github.com/sylabs/singularity-cri/pkg/server/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const dumpStateCmd = "dump-state"
+
+// sections of daemon state dump
+const (
+	sectionPods       = "pods"
+	sectionContainers = "containers"
+	sectionImages     = "images"
+	sectionPulls      = "pulls"
+)
+
+var allSections = []string{sectionPods, sectionContainers, sectionImages, sectionPulls}
+
+// stateDump is daemon internal state returned by DumpState call.
+type stateDump struct {
+	Pods       []runtime.PodDump       `json:"pods,omitempty"`
+	Containers []runtime.ContainerDump `json:"containers,omitempty"`
+	Images     []image.ImageDump       `json:"images,omitempty"`
+	Pulls      *image.PullsDump        `json:"pulls,omitempty"`
+}
+
+// parseSections returns set of requested state sections,
+// all of them are returned when none is requested.
+func parseSections(sections []string) (map[string]bool, error) {
+	if len(sections) == 0 {
+		sections = allSections
+	}
+	set := make(map[string]bool, len(sections))
+	for _, section := range sections {
+		known := false
+		for _, s := range allSections {
+			known = known || s == section
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown section %q, expected one of %s", section, strings.Join(allSections, ", "))
+		}
+		set[section] = true
+	}
+	return set, nil
+}
+
+// DumpState returns daemon internal view of pods, containers, images and
+// pulls as JSON. Dump holds runtime specs and host paths, so it is only
+// served to local socket peers.
+func (a *runtimeAdmin) DumpState(ctx context.Context, req *admin.DumpStateRequest) (*admin.DumpStateResponse, error) {
+	if p, ok := peer.FromContext(ctx); !ok || p.Addr == nil || p.Addr.Network() != "unix" {
+		return nil, status.Errorf(codes.PermissionDenied, "state dump is only available on local socket")
+	}
+	sections, err := parseSections(req.GetSections())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var dump stateDump
+	if sections[sectionPods] {
+		dump.Pods = a.DumpPods(req.GetId())
+	}
+	if sections[sectionContainers] {
+		dump.Containers = a.DumpContainers(req.GetId())
+	}
+	if sections[sectionImages] {
+		dump.Images = a.images.DumpImages()
+	}
+	if sections[sectionPulls] {
+		pulls := a.images.DumpPulls()
+		dump.Pulls = &pulls
+	}
+	data, err := json.Marshal(dump)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not marshal state: %v", err)
+	}
+	return &admin.DumpStateResponse{State: data}, nil
+}
+
+// runDumpState executes dump-state subcommand that prints internal state
+// of the running Singularity-CRI as JSON.
+func runDumpState(args []string) error {
+	flags := flag.NewFlagSet(dumpStateCmd, flag.ContinueOnError)
+	socket := flags.String("socket", defaultConfig.ListenSocket, "Singularity-CRI socket or debug socket")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of the request")
+	sections := flags.String("sections", "", "comma separated sections to dump: "+strings.Join(allSections, ", ")+"; all when not set")
+	id := flags.String("id", "", "dump only pods and containers which ID starts with this prefix")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options]\n", os.Args[0], dumpStateCmd)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("unexpected number of arguments")
+	}
+	req := &admin.DumpStateRequest{Id: *id}
+	if *sections != "" {
+		req.Sections = strings.Split(*sections, ",")
+	}
+	if _, err := parseSections(req.Sections); err != nil {
+		return err
+	}
+
+	conn, err := grpc.Dial("unix://"+*socket, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("could not dial %s: %v", *socket, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	resp, err := admin.NewRuntimeAdminClient(conn).DumpState(ctx, req)
+	if err != nil {
+		return fmt.Errorf("could not dump state: %v", err)
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, resp.State, "", "  "); err != nil {
+		return fmt.Errorf("could not format state: %v", err)
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSections(t *testing.T) {
+	tt := []struct {
+		name        string
+		sections    []string
+		expect      map[string]bool
+		expectError bool
+	}{
+		{
+			name:   "all by default",
+			expect: map[string]bool{"pods": true, "containers": true, "images": true, "pulls": true},
+		},
+		{
+			name:     "selected",
+			sections: []string{"containers", "pulls"},
+			expect:   map[string]bool{"containers": true, "pulls": true},
+		},
+		{
+			name:        "unknown",
+			sections:    []string{"pods", "networks"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			sections, err := parseSections(tc.sections)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, sections)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"google.golang.org/grpc"
+)
+
+const watchEventsCmd = "watch-events"
+
+// runWatchEvents executes watch-events subcommand that prints container
+// lifecycle events streamed by RuntimeAdmin service of the running Singularity-CRI.
+func runWatchEvents(args []string) error {
+	flags := flag.NewFlagSet(watchEventsCmd, flag.ContinueOnError)
+	socket := flags.String("socket", defaultConfig.ListenSocket, "Singularity-CRI socket")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options]\n", os.Args[0], watchEventsCmd)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("unexpected number of arguments")
+	}
+
+	conn, err := grpc.Dial("unix://"+*socket, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("could not dial %s: %v", *socket, err)
+	}
+	defer conn.Close()
+	client := admin.NewRuntimeAdminClient(conn)
+
+	stream, err := client.GetContainerEvents(context.Background(), &admin.GetEventsRequest{})
+	if err != nil {
+		return fmt.Errorf("could not watch container events: %v", err)
+	}
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not receive container event: %v", err)
+		}
+		writeEvent(os.Stdout, event)
+	}
+}
+
+func writeEvent(w io.Writer, event *admin.ContainerEventResponse) {
+	if event.Overflow {
+		fmt.Fprintln(w, "some events were dropped, relist containers to catch up")
+	}
+	eventType := strings.TrimSuffix(strings.TrimPrefix(event.ContainerEventType.String(), "CONTAINER_"), "_EVENT")
+	created := time.Unix(0, event.CreatedAt).Format(time.RFC3339Nano)
+	podID := ""
+	if event.PodSandboxStatus != nil {
+		podID = event.PodSandboxStatus.Id
+	}
+	fmt.Fprintf(w, "%s %s container=%s pod=%s\n", created, eventType, event.ContainerId, podID)
+}
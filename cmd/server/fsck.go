@@ -0,0 +1,122 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"google.golang.org/grpc"
+)
+
+const fsckCmd = "fsck"
+
+// runFsck executes fsck subcommand that verifies stored images and cached
+// blobs with ImageAdmin service of the running Singularity-CRI. Command
+// fails when any corrupted content is found.
+func runFsck(args []string) error {
+	flags := flag.NewFlagSet(fsckCmd, flag.ContinueOnError)
+	socket := flags.String("socket", defaultConfig.ListenSocket, "Singularity-CRI socket")
+	timeout := flags.Duration("timeout", 0, "verification timeout, no timeout when not set")
+	dryRun := flags.Bool("dry-run", false, "report corrupted content without quarantining it")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options] [image]\n", os.Args[0], fsckCmd)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return fmt.Errorf("unexpected number of arguments")
+	}
+
+	conn, err := grpc.Dial("unix://"+*socket, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("could not dial %s: %v", *socket, err)
+	}
+	defer conn.Close()
+	client := admin.NewImageAdminClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if *timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), *timeout)
+	}
+	defer cancel()
+	resp, err := client.VerifyImages(ctx, &admin.VerifyImagesRequest{
+		Image:  flags.Arg(0),
+		DryRun: *dryRun,
+	})
+	if err != nil {
+		return fmt.Errorf("could not verify images: %v", err)
+	}
+	if err := writeChecks(os.Stdout, resp); err != nil {
+		return err
+	}
+
+	var corrupt int
+	for _, check := range resp.Images {
+		if check.Corrupt != "" {
+			corrupt++
+		}
+	}
+	corrupt += len(resp.CorruptBlobs)
+	if corrupt != 0 {
+		return fmt.Errorf("found %d corrupted items", corrupt)
+	}
+	return nil
+}
+
+func writeChecks(w io.Writer, resp *admin.VerifyImagesResponse) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE/BLOB\tTAGS\tSTATUS\tDETAILS")
+	for _, check := range resp.Images {
+		state, details := checkState(check.Corrupt, check.Quarantined, check.Error)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", check.ImageRef, strings.Join(check.Tags, ","), state, details)
+	}
+	for _, check := range resp.CorruptBlobs {
+		state, details := checkState(check.Corrupt, check.Quarantined, check.Error)
+		fmt.Fprintf(tw, "%s\t\t%s\t%s\n", check.Digest, state, details)
+	}
+	return tw.Flush()
+}
+
+// checkState returns status and details column of verified item.
+func checkState(corrupt, quarantined, errMsg string) (string, string) {
+	var details []string
+	if corrupt != "" {
+		details = append(details, corrupt)
+	}
+	if quarantined != "" {
+		details = append(details, "quarantined as "+quarantined)
+	}
+	if errMsg != "" {
+		details = append(details, "error: "+errMsg)
+	}
+	state := "ok"
+	switch {
+	case corrupt != "":
+		state = "corrupt"
+	case errMsg != "":
+		state = "unverified"
+	}
+	return state, strings.Join(details, "; ")
+}
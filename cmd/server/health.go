@@ -0,0 +1,257 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	defaultHealthInterval = 10 * time.Second
+	defaultHealthTimeout  = 5 * time.Second
+)
+
+// healthReport is a result of a single daemon health evaluation. Live
+// daemon serves CRI requests and its indexes are not deadlocked, healthy
+// one additionally has RuntimeReady condition set and ready one has all
+// conditions kubelet requires set.
+type healthReport struct {
+	Time       time.Time               `json:"time"`
+	Live       bool                    `json:"live"`
+	Healthy    bool                    `json:"healthy"`
+	Ready      bool                    `json:"ready"`
+	Conditions []*k8s.RuntimeCondition `json:"conditions,omitempty"`
+	Errors     []string                `json:"errors,omitempty"`
+}
+
+// healthChecker evaluates daemon health the same way kubelet does, i.e.
+// with CRI Status request sent over the listen socket, and additionally
+// probes runtime indexes with timeout to detect deadlocks.
+type healthChecker struct {
+	status   func(ctx context.Context) (*k8s.StatusResponse, error)
+	probe    func(timeout time.Duration) error
+	interval time.Duration
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	last *healthReport
+}
+
+// evaluate checks daemon health and stores the result to be served over HTTP.
+func (h *healthChecker) evaluate() *healthReport {
+	report := &healthReport{Time: time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	resp, err := h.status(ctx)
+	cancel()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("CRI is not serving: %v", err))
+	}
+	if err := h.probe(h.timeout); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("index is deadlocked: %v", err))
+	}
+	report.Live = len(report.Errors) == 0
+
+	required := map[string]bool{k8s.RuntimeReady: false, k8s.NetworkReady: false}
+	for _, cond := range resp.GetStatus().GetConditions() {
+		report.Conditions = append(report.Conditions, cond)
+		if _, ok := required[cond.Type]; !ok {
+			continue
+		}
+		required[cond.Type] = cond.Status
+		if !cond.Status {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s is false: %s", cond.Type, cond.Message))
+		}
+	}
+	report.Healthy = report.Live && required[k8s.RuntimeReady]
+	report.Ready = report.Healthy && required[k8s.NetworkReady]
+
+	h.mu.Lock()
+	h.last = report
+	h.mu.Unlock()
+	return report
+}
+
+// current returns the last health evaluation. Evaluation that is not
+// refreshed in time means the loop running it is wedged, so the
+// daemon is reported as not live.
+func (h *healthChecker) current() healthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.last == nil {
+		return healthReport{Time: time.Now(), Errors: []string{"health is not evaluated yet"}}
+	}
+	report := *h.last
+	if since := time.Since(report.Time); since > 2*h.interval+2*h.timeout {
+		report.Live, report.Healthy, report.Ready = false, false, false
+		report.Errors = append(append([]string(nil), report.Errors...),
+			fmt.Sprintf("health is not evaluated for %s", since.Round(time.Second)))
+	}
+	return report
+}
+
+// handler returns HTTP handler that responds with 200 when passed check
+// succeeds for the last evaluation and 503 otherwise. Evaluation details
+// are returned in JSON when verbose query parameter is set.
+func (h *healthChecker) handler(check func(r healthReport) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := h.current()
+		code := http.StatusOK
+		if !check(report) {
+			code = http.StatusServiceUnavailable
+		}
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			if err := json.NewEncoder(w).Encode(report); err != nil {
+				glog.Errorf("Could not write health report: %v", err)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		if code == http.StatusOK {
+			fmt.Fprintln(w, "ok")
+			return
+		}
+		fmt.Fprintln(w, strings.Join(report.Errors, "\n"))
+	}
+}
+
+// startHealth returns health checker of CRI served on config.ListenSocket.
+// When config.HealthListen is set /healthz and /readyz are served on it
+// until ctx is done.
+func startHealth(ctx context.Context, wg *sync.WaitGroup, config Config, probe func(time.Duration) error) (*healthChecker, error) {
+	conn, err := grpc.Dial("unix://"+config.ListenSocket, grpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("could not dial CRI: %v", err)
+	}
+	client := k8s.NewRuntimeServiceClient(conn)
+	h := &healthChecker{
+		status: func(ctx context.Context) (*k8s.StatusResponse, error) {
+			return client.Status(ctx, &k8s.StatusRequest{})
+		},
+		probe:    probe,
+		interval: config.HealthInterval,
+		timeout:  config.HealthTimeout,
+	}
+	if h.interval == 0 {
+		h.interval = defaultHealthInterval
+	}
+	if h.timeout == 0 {
+		h.timeout = defaultHealthTimeout
+	}
+
+	var srv *http.Server
+	if config.HealthListen != "" {
+		lis, err := net.Listen("tcp", config.HealthListen)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not listen on %s: %v", config.HealthListen, err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", h.handler(func(r healthReport) bool { return r.Healthy }))
+		mux.Handle("/readyz", h.handler(func(r healthReport) bool { return r.Ready }))
+		srv = &http.Server{Handler: mux}
+		go func() {
+			if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+				glog.Errorf("Health server failed: %v", err)
+			}
+		}()
+		glog.Infof("Health server started on %v", lis.Addr())
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		if srv != nil {
+			srv.Close()
+		}
+		conn.Close()
+	}()
+	return h, nil
+}
+
+// watchdog pets systemd service watchdog over notify socket.
+type watchdog struct {
+	addr     *net.UnixAddr
+	interval time.Duration
+}
+
+// newWatchdog returns watchdog set up by systemd with NOTIFY_SOCKET and
+// WATCHDOG_USEC environment variables. When watchdog is not enabled for
+// this process nil is returned. Watchdog is petted twice per timeout.
+func newWatchdog() (*watchdog, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	usec := os.Getenv("WATCHDOG_USEC")
+	if socket == "" || usec == "" {
+		return nil, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	timeout, err := strconv.ParseUint(usec, 10, 64)
+	if err != nil || timeout == 0 {
+		return nil, fmt.Errorf("invalid WATCHDOG_USEC %q", usec)
+	}
+	return &watchdog{
+		addr:     &net.UnixAddr{Name: socket, Net: "unixgram"},
+		interval: time.Duration(timeout) * time.Microsecond / 2,
+	}, nil
+}
+
+// pet notifies systemd that the service is alive.
+func (w *watchdog) pet() error {
+	conn, err := net.DialUnix(w.addr.Net, nil, w.addr)
+	if err != nil {
+		return fmt.Errorf("could not connect to notify socket: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("WATCHDOG=1")); err != nil {
+		return fmt.Errorf("could not notify systemd: %v", err)
+	}
+	return nil
+}
+
+// checkHealth evaluates daemon health and pets watchdog, if any,
+// only while daemon is live so that systemd restarts a wedged one.
+func checkHealth(h *healthChecker, wd *watchdog) {
+	report := h.evaluate()
+	if !report.Live {
+		glog.Errorf("Singularity-CRI is not live: %s", strings.Join(report.Errors, "; "))
+		return
+	}
+	if wd == nil {
+		return
+	}
+	if err := wd.pet(); err != nil {
+		glog.Errorf("Could not pet systemd watchdog: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestHealthChecker(t *testing.T) {
+	tt := []struct {
+		name      string
+		statusErr error
+		probeErr  error
+		network   bool
+		stale     bool
+		healthz   int
+		readyz    int
+	}{
+		{
+			name:    "ready",
+			network: true,
+			healthz: http.StatusOK,
+			readyz:  http.StatusOK,
+		},
+		{
+			name:    "network not ready",
+			healthz: http.StatusOK,
+			readyz:  http.StatusServiceUnavailable,
+		},
+		{
+			name:      "not serving",
+			statusErr: fmt.Errorf("deadline exceeded"),
+			healthz:   http.StatusServiceUnavailable,
+			readyz:    http.StatusServiceUnavailable,
+		},
+		{
+			name:     "deadlocked index",
+			probeErr: fmt.Errorf("lock is not acquired in time"),
+			network:  true,
+			healthz:  http.StatusServiceUnavailable,
+			readyz:   http.StatusServiceUnavailable,
+		},
+		{
+			name:    "stale evaluation",
+			network: true,
+			stale:   true,
+			healthz: http.StatusServiceUnavailable,
+			readyz:  http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &healthChecker{
+				status: func(context.Context) (*k8s.StatusResponse, error) {
+					if tc.statusErr != nil {
+						return nil, tc.statusErr
+					}
+					return &k8s.StatusResponse{
+						Status: &k8s.RuntimeStatus{
+							Conditions: []*k8s.RuntimeCondition{
+								{Type: k8s.RuntimeReady, Status: true},
+								{Type: k8s.NetworkReady, Status: tc.network},
+							},
+						},
+					}, nil
+				},
+				probe:    func(time.Duration) error { return tc.probeErr },
+				interval: time.Second,
+				timeout:  time.Second,
+			}
+			report := h.evaluate()
+			require.Equal(t, tc.statusErr == nil && tc.probeErr == nil, report.Live)
+			if tc.stale {
+				h.last.Time = h.last.Time.Add(-time.Minute)
+			}
+
+			for path, code := range map[string]int{"/healthz": tc.healthz, "/readyz": tc.readyz} {
+				check := func(r healthReport) bool { return r.Healthy }
+				if path == "/readyz" {
+					check = func(r healthReport) bool { return r.Ready }
+				}
+				rec := httptest.NewRecorder()
+				h.handler(check).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"?verbose", nil))
+				require.Equal(t, code, rec.Code, path)
+				require.Contains(t, rec.Body.String(), `"live"`)
+			}
+		})
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchdog-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, env := range []string{"NOTIFY_SOCKET", "WATCHDOG_USEC", "WATCHDOG_PID"} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	os.Setenv("NOTIFY_SOCKET", socket)
+	os.Unsetenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+	wd, err := newWatchdog()
+	require.NoError(t, err)
+	require.Nil(t, wd, "watchdog is not enabled")
+
+	os.Setenv("WATCHDOG_USEC", "not a number")
+	_, err = newWatchdog()
+	require.Error(t, err)
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	os.Setenv("WATCHDOG_PID", "1")
+	wd, err = newWatchdog()
+	require.NoError(t, err)
+	require.Nil(t, wd, "watchdog is enabled for another process")
+
+	os.Setenv("WATCHDOG_PID", fmt.Sprint(os.Getpid()))
+	wd, err = newWatchdog()
+	require.NoError(t, err)
+	require.NotNil(t, wd)
+	require.Equal(t, 15*time.Second, wd.interval)
+
+	require.NoError(t, wd.pet())
+	buf := make([]byte, 64)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "WATCHDOG=1", string(buf[:n]))
+}
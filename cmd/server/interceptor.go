@@ -0,0 +1,104 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sylabs/singularity-cri/pkg/metrics"
+	"github.com/sylabs/singularity-cri/pkg/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// maxDeadlineMargin limits how much earlier than requested by
+// the caller internal request deadline fires.
+const maxDeadlineMargin = 5 * time.Second
+
+var requestDuration = metrics.NewHistogram("sycri_grpc_request_duration_seconds",
+	"Time CRI requests took to handle.", metrics.DefaultBuckets, "method", "code")
+
+// chainInterceptors combines interceptors into a single one. The first
+// interceptor is the outermost one, i.e. it is called first.
+func chainInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{},
+		info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
+// internalDeadline shortens incoming request deadline so that handler
+// is able to clean up and return a specific error before the caller
+// gives up on the request.
+func internalDeadline(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return handler(ctx, req)
+	}
+	margin := time.Until(deadline) / 10
+	if margin > maxDeadlineMargin {
+		margin = maxDeadlineMargin
+	}
+	internalCtx, cancel := context.WithDeadline(ctx, deadline.Add(-margin))
+	defer cancel()
+
+	resp, err := handler(internalCtx, req)
+	if err != nil && internalCtx.Err() == context.DeadlineExceeded {
+		return nil, status.Errorf(codes.DeadlineExceeded, "%s exceeded internal deadline: %v", info.FullMethod, err)
+	}
+	return resp, err
+}
+
+// observeDuration records handler latency by method and response code.
+// It should be the outermost interceptor so that recovered panics
+// are reported with the code they are responded with.
+func observeDuration(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	requestDuration.Observe(time.Since(start).Seconds(), info.FullMethod, status.Code(err).String())
+	return resp, err
+}
+
+// traceRequest records span of handling request. Trace passed by the
+// caller in traceparent metadata is continued, if any.
+func traceRequest(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(trace.TraceparentHeader); len(values) != 0 {
+			if sc, err := trace.ParseTraceparent(values[0]); err == nil {
+				ctx = trace.ContextWithRemote(ctx, sc)
+			}
+		}
+	}
+	ctx, span := trace.StartServer(ctx, info.FullMethod)
+	defer span.End()
+
+	resp, err := handler(ctx, req)
+	span.SetAttribute("rpc.grpc.status_code", status.Code(err).String())
+	span.SetError(err)
+	return resp, err
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInternalDeadline(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/test/Method"}
+	// slow handler that respects context
+	slow := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("could not finish: %v", ctx.Err())
+		case <-time.After(time.Second):
+			return "done", nil
+		}
+	}
+
+	tt := []struct {
+		name        string
+		timeout     time.Duration
+		expectResp  interface{}
+		expectCode  codes.Code
+		expectEarly bool
+	}{
+		{
+			name:       "no deadline",
+			expectResp: "done",
+			expectCode: codes.OK,
+		},
+		{
+			name:       "enough time",
+			timeout:    10 * time.Second,
+			expectResp: "done",
+			expectCode: codes.OK,
+		},
+		{
+			name:        "internal deadline exceeded",
+			timeout:     500 * time.Millisecond,
+			expectCode:  codes.DeadlineExceeded,
+			expectEarly: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.timeout != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+
+			resp, err := internalDeadline(ctx, nil, info, slow)
+			require.Equal(t, tc.expectResp, resp)
+			require.Equal(t, tc.expectCode, status.Code(err))
+			if tc.expectEarly {
+				require.NoError(t, ctx.Err(), "handler returned after caller's deadline")
+				require.Contains(t, err.Error(), "exceeded internal deadline")
+			}
+		})
+	}
+}
+
+func TestChainInterceptors(t *testing.T) {
+	var calls []string
+	interceptor := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{},
+			info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			calls = append(calls, name)
+			return handler(ctx, req)
+		}
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls = append(calls, "handler")
+		return req, nil
+	}
+
+	chained := chainInterceptors(interceptor("first"), interceptor("second"))
+	resp, err := chained(context.Background(), "req", &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+	require.Equal(t, "req", resp)
+	require.Equal(t, []string{"first", "second", "handler"}, calls)
+}
+
+func TestLogAndRecover(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/test/Method"}
+	panicking := func(ctx context.Context, req interface{}) (interface{}, error) {
+		var config *struct{ Name string }
+		return config.Name, nil
+	}
+
+	var resp interface{}
+	var err error
+	require.NotPanics(t, func() {
+		resp, err = logAndRecover(false, nil)(context.Background(), "req", info, panicking)
+	})
+	require.Nil(t, resp)
+	require.Equal(t, codes.Internal, status.Code(err))
+	require.Contains(t, err.Error(), "/test/Method panicked")
+}
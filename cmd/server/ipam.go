@@ -0,0 +1,87 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"google.golang.org/grpc"
+)
+
+const reconcileIPAMCmd = "reconcile-ipam"
+
+// runReconcileIPAM executes reconcile-ipam subcommand that releases IPAM
+// addresses leaked by removed pods with RuntimeAdmin service of the running
+// Singularity-CRI. Only networks listed in daemon config are reconciled.
+func runReconcileIPAM(args []string) error {
+	flags := flag.NewFlagSet(reconcileIPAMCmd, flag.ContinueOnError)
+	socket := flags.String("socket", defaultConfig.ListenSocket, "Singularity-CRI socket")
+	timeout := flags.Duration("timeout", time.Minute, "request timeout")
+	dryRun := flags.Bool("dry-run", false, "list leaked addresses without releasing them")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options]\n", os.Args[0], reconcileIPAMCmd)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("unexpected number of arguments")
+	}
+
+	conn, err := grpc.Dial("unix://"+*socket, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("could not dial %s: %v", *socket, err)
+	}
+	defer conn.Close()
+	client := admin.NewRuntimeAdminClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	resp, err := client.ReconcileIPAM(ctx, &admin.ReconcileIPAMRequest{DryRun: *dryRun})
+	if err != nil {
+		return fmt.Errorf("could not reconcile IPAM: %v", err)
+	}
+	return writeAllocations(os.Stdout, resp.Allocations, *dryRun)
+}
+
+func writeAllocations(w io.Writer, allocs []*admin.IPAMAllocation, dryRun bool) error {
+	action := "released"
+	if dryRun {
+		action = "leaked"
+	}
+	if len(allocs) == 0 {
+		_, err := fmt.Fprintf(w, "No addresses %s\n", action)
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NETWORK\tIP\tPOD ID\tINTERFACE")
+	for _, a := range allocs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.Network, a.Ip, a.ContainerId, a.IfName)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d addresses %s\n", len(allocs), action)
+	return err
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	syunix "github.com/sylabs/singularity/pkg/util/unix"
+	"google.golang.org/grpc"
+)
+
+// defaultSocketMode is a mode of CRI socket unless configured otherwise.
+const defaultSocketMode os.FileMode = 0600
+
+// criListeners returns listeners CRI is served on: unix socket with configured
+// mode and owner and, when TCP address is set, TCP listener that requires
+// clients to present certificates signed by the configured CA.
+func criListeners(config Config) ([]net.Listener, error) {
+	mode, err := parseSocketMode(config.ListenSocketMode)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := parseOwner(config.ListenSocketOwner)
+	if err != nil {
+		return nil, err
+	}
+	lis, err := syunix.CreateSocket(config.ListenSocket)
+	if err != nil {
+		return nil, err
+	}
+	// socket is created with 0600 mode, so it is never more open than configured
+	if err := os.Chmod(config.ListenSocket, mode); err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("could not set socket mode: %v", err)
+	}
+	if owner != nil {
+		if err := os.Chown(config.ListenSocket, owner.UID, owner.GID); err != nil {
+			lis.Close()
+			return nil, fmt.Errorf("could not set socket owner: %v", err)
+		}
+	}
+	if config.ListenTCP == "" {
+		return []net.Listener{lis}, nil
+	}
+
+	tlsConfig, err := serverTLSConfig(config)
+	if err != nil {
+		lis.Close()
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp", config.ListenTCP)
+	if err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("could not listen on %s: %v", config.ListenTCP, err)
+	}
+	if config.MaxTCPConnections > 0 {
+		tcp = newLimitListener(tcp, config.MaxTCPConnections)
+	}
+	return []net.Listener{lis, tls.NewListener(tcp, tlsConfig)}, nil
+}
+
+// serverTLSConfig returns mutual TLS config of CRI TCP listener.
+func serverTLSConfig(config Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not load TLS key pair: %v", err)
+	}
+	data, err := ioutil.ReadFile(config.TLSClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("could not read TLS client CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in TLS client CA %s", config.TLSClientCA)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2"},
+	}, nil
+}
+
+// streamingTLSConfig returns TLS config of streaming server or nil when
+// streaming requests are served over plain HTTP. Key pair is loaded upfront
+// so that invalid files fail daemon start.
+func streamingTLSConfig(config Config) (*tls.Config, error) {
+	if config.StreamingTLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(config.StreamingTLSCert, config.StreamingTLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not load streaming TLS key pair: %v", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// streams are upgraded to SPDY which is not possible over HTTP/2
+		NextProtos: []string{"http/1.1"},
+	}, nil
+}
+
+// grpcLimits returns server options that enforce configured stream
+// and message size limits. Zero values keep gRPC defaults.
+func grpcLimits(config Config) []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if config.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(uint32(config.MaxConcurrentStreams)))
+	}
+	if config.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(config.MaxRecvMsgSize))
+	}
+	if config.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(config.MaxSendMsgSize))
+	}
+	return opts
+}
+
+// parseSocketMode parses octal permission bits of CRI socket,
+// empty value means defaultSocketMode.
+func parseSocketMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return defaultSocketMode, nil
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, fmt.Errorf("invalid socket mode %q: expected octal permissions, e.g. 0660", mode)
+	}
+	return os.FileMode(perm), nil
+}
+
+// limitListener accepts at most n simultaneous connections,
+// Accept blocks until one of accepted connections is closed.
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+}
+
+func newLimitListener(l net.Listener, n int) net.Listener {
+	return &limitListener{Listener: l, sem: make(chan struct{}, n)}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.sem <- struct{}{}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+// limitConn releases its slot in limitListener once closed.
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
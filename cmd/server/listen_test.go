@@ -0,0 +1,119 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSocketMode(t *testing.T) {
+	tt := []struct {
+		mode        string
+		expect      os.FileMode
+		expectError bool
+	}{
+		{mode: "", expect: 0600},
+		{mode: "0660", expect: 0660},
+		{mode: "666", expect: 0666},
+		{mode: "0999", expectError: true},
+		{mode: "01777", expectError: true},
+		{mode: "rw", expectError: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.mode, func(t *testing.T) {
+			mode, err := parseSocketMode(tc.mode)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, mode)
+		})
+	}
+}
+
+func TestCRIListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "listen-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "sycri.sock")
+	listeners, err := criListeners(Config{
+		ListenSocket:      socket,
+		ListenSocketMode:  "0660",
+		ListenSocketOwner: fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+	})
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	defer listeners[0].Close()
+	fi, err := os.Stat(socket)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0660), fi.Mode().Perm())
+
+	_, err = criListeners(Config{
+		ListenSocket: filepath.Join(dir, "tcp.sock"),
+		ListenTCP:    "127.0.0.1:0",
+		TLSCert:      filepath.Join(dir, "missing.crt"),
+		TLSKey:       filepath.Join(dir, "missing.key"),
+		TLSClientCA:  filepath.Join(dir, "ca.crt"),
+	})
+	require.Error(t, err, "TCP must not be served without valid TLS files")
+}
+
+func TestLimitListener(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	lis := newLimitListener(tcp, 1)
+	defer lis.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", tcp.Addr().String())
+		require.NoError(t, err)
+		defer c.Close()
+	}
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatalf("connection accepted over the limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, first.Close())
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(time.Second):
+		t.Fatalf("connection is not accepted after slot is released")
+	}
+}
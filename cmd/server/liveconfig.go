@@ -0,0 +1,374 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+	sImage "github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/server/image"
+	"github.com/sylabs/singularity-cri/pkg/server/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	settingLogLevel           = "logLevel"
+	settingMaxConcurrentPulls = "maxConcurrentPulls"
+	settingPullGlobal         = "pullBandwidth.global"
+	settingPullRegistry       = "pullBandwidth.registries."
+	settingGCHighWatermark    = "imageGCHighWatermark"
+	settingGCLowWatermark     = "imageGCLowWatermark"
+	settingGCDryRun           = "imageGCDryRun"
+)
+
+// hotSettings are config settings that can be changed without restart.
+var hotSettings = []string{
+	settingLogLevel,
+	settingMaxConcurrentPulls,
+	settingPullGlobal,
+	settingPullRegistry + "<host>",
+	settingGCHighWatermark,
+	settingGCLowWatermark,
+	settingGCDryRun,
+}
+
+// imageSettings is a part of image service hot-reloadable settings are applied to.
+type imageSettings interface {
+	SetPinnedImages(refs []string) error
+	SetPullLimits(limits sImage.PullLimits)
+	SetMaxConcurrentPulls(n int)
+	SetImageGC(gc *image.ImageGC) error
+}
+
+// liveConfig holds config of the running daemon and applies changes
+// of hot-reloadable settings received with SetRuntimeConfig call.
+type liveConfig struct {
+	mu     sync.Mutex
+	path   string
+	config Config
+	images imageSettings
+}
+
+func newLiveConfig(path string, config Config, images imageSettings) *liveConfig {
+	config.LogLevel = logLevel()
+	return &liveConfig{
+		path:   path,
+		config: config,
+		images: images,
+	}
+}
+
+// settings returns current values of hot-reloadable settings.
+func (l *liveConfig) settings() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return formatSettings(l.config)
+}
+
+// set validates and applies passed settings, optionally writing them to
+// config file first. Either all settings are applied or none of them.
+func (l *liveConfig) set(settings map[string]string, persist bool) (map[string]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	config, err := updateSettings(l.config, settings)
+	if err != nil {
+		return nil, err
+	}
+	if persist {
+		if err := persistSettings(l.path, config, settings); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.apply(config); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not apply settings: %v", err)
+	}
+	glog.Infof("Runtime config is changed: %v", settings)
+	return formatSettings(l.config), nil
+}
+
+// apply changes settings of running services that differ from current config.
+func (l *liveConfig) apply(config Config) error {
+	old := l.config
+	if config.LogLevel != old.LogLevel {
+		if err := flag.Set("v", strconv.Itoa(config.LogLevel)); err != nil {
+			return fmt.Errorf("could not set log level: %v", err)
+		}
+		setSingularityLogLevel()
+	}
+	if config.MaxConcurrentPulls != old.MaxConcurrentPulls {
+		l.images.SetMaxConcurrentPulls(config.MaxConcurrentPulls)
+	}
+	newLimits, err := pullLimits(config)
+	if err != nil {
+		return err
+	}
+	oldLimits, _ := pullLimits(old)
+	if !equalLimits(newLimits, oldLimits) {
+		l.images.SetPullLimits(newLimits)
+	}
+	if config.ImageGCHighWatermark != old.ImageGCHighWatermark ||
+		config.ImageGCLowWatermark != old.ImageGCLowWatermark ||
+		config.ImageGCDryRun != old.ImageGCDryRun {
+		if err := l.images.SetImageGC(imageGC(config)); err != nil {
+			return err
+		}
+	}
+	l.config = config
+	return nil
+}
+
+// reloadImageConfig re-reads pinned images, registries
+// and pull bandwidth from config file.
+func (l *liveConfig) reloadImageConfig() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	config, err := parseConfig(l.path)
+	if err != nil {
+		glog.Errorf("Could not reload image config: %v", err)
+		return
+	}
+	if err := l.images.SetPinnedImages(config.PinnedImages); err != nil {
+		glog.Errorf("Could not reload pinned images: %v", err)
+	} else {
+		glog.Infof("Pinned images are set to %v", config.PinnedImages)
+	}
+	sImage.SetRegistries(registries(config))
+	l.config.Registries = config.Registries
+	glog.Infof("Registries are reloaded")
+	limits, err := pullLimits(config)
+	if err != nil {
+		glog.Errorf("Could not reload pull bandwidth: %v", err)
+		return
+	}
+	l.images.SetPullLimits(limits)
+	l.config.PullBandwidth = config.PullBandwidth
+	glog.Infof("Pull bandwidth is limited to %s globally, %v per registry",
+		config.PullBandwidth.Global, config.PullBandwidth.Registries)
+}
+
+// updateSettings returns a copy of config with passed settings changed.
+// Returned errors are gRPC statuses.
+func updateSettings(config Config, settings map[string]string) (Config, error) {
+	registries := make(map[string]string, len(config.PullBandwidth.Registries))
+	for host, limit := range config.PullBandwidth.Registries {
+		registries[host] = limit
+	}
+	config.PullBandwidth.Registries = registries
+
+	var unknown []string
+	for key, value := range settings {
+		var err error
+		switch {
+		case key == settingLogLevel:
+			config.LogLevel, err = strconv.Atoi(value)
+		case key == settingMaxConcurrentPulls:
+			config.MaxConcurrentPulls, err = strconv.Atoi(value)
+		case key == settingGCHighWatermark:
+			config.ImageGCHighWatermark, err = strconv.Atoi(value)
+		case key == settingGCLowWatermark:
+			config.ImageGCLowWatermark, err = strconv.Atoi(value)
+		case key == settingGCDryRun:
+			if config.ImageGCDryRun, err = strconv.ParseBool(value); err != nil {
+				return Config{}, status.Errorf(codes.InvalidArgument, "invalid %s value %q: not a boolean", key, value)
+			}
+		case key == settingPullGlobal:
+			config.PullBandwidth.Global = value
+		case strings.HasPrefix(key, settingPullRegistry) && len(key) > len(settingPullRegistry):
+			host := strings.TrimPrefix(key, settingPullRegistry)
+			if value == "" {
+				delete(registries, host)
+			} else {
+				registries[host] = value
+			}
+		default:
+			unknown = append(unknown, key)
+		}
+		if err != nil {
+			return Config{}, status.Errorf(codes.InvalidArgument, "invalid %s value %q: not a number", key, value)
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return Config{}, status.Errorf(codes.InvalidArgument,
+			"%s cannot be changed at runtime, hot-reloadable settings are: %s",
+			strings.Join(unknown, ", "), strings.Join(hotSettings, ", "))
+	}
+	if len(registries) == 0 {
+		config.PullBandwidth.Registries = nil
+	}
+	if config.LogLevel < 0 {
+		return Config{}, status.Errorf(codes.InvalidArgument, "log level cannot be negative")
+	}
+	if config.MaxConcurrentPulls < 0 {
+		return Config{}, status.Errorf(codes.InvalidArgument, "max concurrent pulls cannot be negative")
+	}
+	if _, err := pullLimits(config); err != nil {
+		return Config{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if gc := imageGC(config); gc != nil {
+		if err := gc.Validate(); err != nil {
+			return Config{}, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return config, nil
+}
+
+// formatSettings returns hot-reloadable settings of config in config file format.
+func formatSettings(config Config) map[string]string {
+	settings := map[string]string{
+		settingLogLevel:           strconv.Itoa(config.LogLevel),
+		settingMaxConcurrentPulls: strconv.Itoa(config.MaxConcurrentPulls),
+		settingPullGlobal:         config.PullBandwidth.Global,
+		settingGCHighWatermark:    strconv.Itoa(config.ImageGCHighWatermark),
+		settingGCLowWatermark:     strconv.Itoa(config.ImageGCLowWatermark),
+		settingGCDryRun:           strconv.FormatBool(config.ImageGCDryRun),
+	}
+	for host, limit := range config.PullBandwidth.Registries {
+		settings[settingPullRegistry+host] = limit
+	}
+	return settings
+}
+
+// persistSettings writes settings changed in config into config file at path.
+// Other content of the file is kept as is, except for comments.
+func persistSettings(path string, config Config, settings map[string]string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return status.Errorf(codes.FailedPrecondition, "config file %s does not exist, settings are not applied", path)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not stat config file: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not read config file: %v", err)
+	}
+	var file yaml.MapSlice
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return status.Errorf(codes.Internal, "could not decode config file: %v", err)
+	}
+
+	// new keys are appended in the order they are documented
+	// in config file rather than in random map order
+	items := []struct {
+		key   string
+		value interface{}
+	}{
+		{key: "pullBandwidth", value: config.PullBandwidth},
+		{key: settingMaxConcurrentPulls, value: config.MaxConcurrentPulls},
+		{key: settingGCHighWatermark, value: config.ImageGCHighWatermark},
+		{key: settingGCLowWatermark, value: config.ImageGCLowWatermark},
+		{key: settingGCDryRun, value: config.ImageGCDryRun},
+		{key: settingLogLevel, value: config.LogLevel},
+	}
+	for _, item := range items {
+		for key := range settings {
+			if key == item.key || item.key == "pullBandwidth" && strings.HasPrefix(key, "pullBandwidth.") {
+				file = setItem(file, item.key, item.value)
+				break
+			}
+		}
+	}
+
+	data, err = yaml.Marshal(file)
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not encode config file: %v", err)
+	}
+	// make sure daemon is able to start with the new file
+	var check Config
+	if err := yaml.Unmarshal(data, &check); err != nil {
+		return status.Errorf(codes.Internal, "could not decode updated config file: %v", err)
+	}
+	if _, err := validConfig(check); err != nil {
+		return status.Errorf(codes.FailedPrecondition, "updated config file is invalid: %v", err)
+	}
+	if err := fs.WriteFileAtomic(path, data, fi.Mode().Perm()); err != nil {
+		return status.Errorf(codes.Internal, "could not write config file: %v", err)
+	}
+	return nil
+}
+
+// setItem sets value of a top level key keeping its position.
+func setItem(file yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i := range file {
+		if file[i].Key == key {
+			file[i].Value = value
+			return file
+		}
+	}
+	return append(file, yaml.MapItem{Key: key, Value: value})
+}
+
+func equalLimits(a, b sImage.PullLimits) bool {
+	if a.Global != b.Global || len(a.Registries) != len(b.Registries) {
+		return false
+	}
+	for host, limit := range a.Registries {
+		if l, ok := b.Registries[host]; !ok || l != limit {
+			return false
+		}
+	}
+	return true
+}
+
+// logLevel returns current log verbosity level.
+func logLevel() int {
+	f := flag.Lookup("v")
+	if f == nil {
+		return 0
+	}
+	level, _ := strconv.Atoi(f.Value.String())
+	return level
+}
+
+// runtimeAdmin serves RuntimeAdmin service. Runtime config calls are
+// handled by the daemon itself since settings belong to both CRI services.
+type runtimeAdmin struct {
+	*runtime.SingularityRuntime
+	images *image.SingularityRegistry
+	live   *liveConfig
+}
+
+// SetRuntimeConfig changes hot-reloadable settings.
+func (a *runtimeAdmin) SetRuntimeConfig(_ context.Context, req *admin.SetRuntimeConfigRequest) (*admin.SetRuntimeConfigResponse, error) {
+	if len(req.GetSettings()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no settings to change")
+	}
+	settings, err := a.live.set(req.GetSettings(), req.GetPersist())
+	if err != nil {
+		return nil, err
+	}
+	return &admin.SetRuntimeConfigResponse{Settings: settings}, nil
+}
+
+// GetRuntimeConfig returns current values of hot-reloadable settings.
+func (a *runtimeAdmin) GetRuntimeConfig(context.Context, *admin.GetRuntimeConfigRequest) (*admin.GetRuntimeConfigResponse, error) {
+	return &admin.GetRuntimeConfigResponse{Settings: a.live.settings()}, nil
+}
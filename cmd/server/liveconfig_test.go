@@ -0,0 +1,236 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	sImage "github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/server/image"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
+)
+
+type fakeImageSettings struct {
+	pinned   []string
+	limits   *sImage.PullLimits
+	maxPulls int
+	gc       *image.ImageGC
+	gcSet    bool
+}
+
+func (f *fakeImageSettings) SetPinnedImages(refs []string) error {
+	f.pinned = refs
+	return nil
+}
+
+func (f *fakeImageSettings) SetPullLimits(limits sImage.PullLimits) {
+	f.limits = &limits
+}
+
+func (f *fakeImageSettings) SetMaxConcurrentPulls(n int) {
+	f.maxPulls = n
+}
+
+func (f *fakeImageSettings) SetImageGC(gc *image.ImageGC) error {
+	f.gc = gc
+	f.gcSet = true
+	return nil
+}
+
+func TestLiveConfigSet(t *testing.T) {
+	base := Config{
+		ListenSocket: "/var/run/sycri.sock",
+		StorageDir:   "/var/lib/singularity",
+		BaseRunDir:   "/var/run/cri",
+		PullBandwidth: PullBandwidthConfig{
+			Registries: map[string]string{"docker.io": "20Mi"},
+		},
+	}
+
+	tt := []struct {
+		name        string
+		settings    map[string]string
+		expect      map[string]string
+		expectCode  codes.Code
+		expectError string
+	}{
+		{
+			name: "pulls and gc",
+			settings: map[string]string{
+				"maxConcurrentPulls":   "2",
+				"imageGCHighWatermark": "90",
+				"imageGCLowWatermark":  "80",
+				"imageGCDryRun":        "true",
+			},
+			expect: map[string]string{
+				"maxConcurrentPulls":   "2",
+				"imageGCHighWatermark": "90",
+				"imageGCLowWatermark":  "80",
+				"imageGCDryRun":        "true",
+			},
+		},
+		{
+			name: "pull bandwidth",
+			settings: map[string]string{
+				"pullBandwidth.global":                     "100Mi",
+				"pullBandwidth.registries.docker.io":       "",
+				"pullBandwidth.registries.quay.io":         "10Mi",
+				"pullBandwidth.registries.cloud.sylabs.io": "0",
+			},
+			expect: map[string]string{
+				"pullBandwidth.global":                     "100Mi",
+				"pullBandwidth.registries.quay.io":         "10Mi",
+				"pullBandwidth.registries.cloud.sylabs.io": "0",
+			},
+		},
+		{
+			name:        "not hot-reloadable",
+			settings:    map[string]string{"maxConcurrentPulls": "2", "storageDir": "/tmp", "debug": "true"},
+			expectCode:  codes.InvalidArgument,
+			expectError: "debug, storageDir cannot be changed at runtime, hot-reloadable settings are: logLevel, maxConcurrentPulls",
+		},
+		{
+			name:        "registry without host",
+			settings:    map[string]string{"pullBandwidth.registries.": "1Mi"},
+			expectCode:  codes.InvalidArgument,
+			expectError: "pullBandwidth.registries. cannot be changed at runtime",
+		},
+		{
+			name:        "not a number",
+			settings:    map[string]string{"maxConcurrentPulls": "many"},
+			expectCode:  codes.InvalidArgument,
+			expectError: `invalid maxConcurrentPulls value "many": not a number`,
+		},
+		{
+			name:        "not a boolean",
+			settings:    map[string]string{"imageGCDryRun": "maybe"},
+			expectCode:  codes.InvalidArgument,
+			expectError: `invalid imageGCDryRun value "maybe": not a boolean`,
+		},
+		{
+			name:        "invalid watermarks",
+			settings:    map[string]string{"imageGCHighWatermark": "50", "imageGCLowWatermark": "60"},
+			expectCode:  codes.InvalidArgument,
+			expectError: "invalid image GC watermarks",
+		},
+		{
+			name:        "invalid bandwidth",
+			settings:    map[string]string{"pullBandwidth.global": "fast"},
+			expectCode:  codes.InvalidArgument,
+			expectError: "bad size",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			images := &fakeImageSettings{}
+			live := newLiveConfig("", base, images)
+			before := live.settings()
+
+			settings, err := live.set(tc.settings, false)
+			if tc.expectError != "" {
+				require.Error(t, err)
+				require.Equal(t, tc.expectCode, status.Code(err))
+				require.Contains(t, err.Error(), tc.expectError)
+				require.Equal(t, &fakeImageSettings{}, images, "nothing must be applied on error")
+				require.Equal(t, before, live.settings())
+				return
+			}
+			require.NoError(t, err)
+			for key, value := range tc.expect {
+				require.Equal(t, value, settings[key], key)
+			}
+			require.Equal(t, settings, live.settings())
+			require.Equal(t, map[string]string{"docker.io": "20Mi"}, base.PullBandwidth.Registries,
+				"passed config must not be modified")
+		})
+	}
+}
+
+func TestLiveConfigApply(t *testing.T) {
+	images := &fakeImageSettings{}
+	live := newLiveConfig("", Config{ImageGCHighWatermark: 90}, images)
+
+	_, err := live.set(map[string]string{"maxConcurrentPulls": "3"}, false)
+	require.NoError(t, err)
+	require.Equal(t, 3, images.maxPulls)
+	require.Nil(t, images.limits, "unchanged pull limits must not be reset")
+	require.False(t, images.gcSet, "unchanged image GC must not be reset")
+
+	_, err = live.set(map[string]string{"pullBandwidth.registries.docker.io": "1Ki"}, false)
+	require.NoError(t, err)
+	require.Equal(t, &sImage.PullLimits{Registries: map[string]int64{"docker.io": 1024}}, images.limits)
+
+	_, err = live.set(map[string]string{"imageGCHighWatermark": "0"}, false)
+	require.NoError(t, err)
+	require.True(t, images.gcSet)
+	require.Nil(t, images.gc, "zero high watermark must disable image GC")
+}
+
+func TestLiveConfigPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "liveconfig-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sycri.yaml")
+	base := Config{
+		ListenSocket: "/var/run/sycri.sock",
+		StorageDir:   "/var/lib/singularity",
+		BaseRunDir:   "/var/run/cri",
+	}
+
+	live := newLiveConfig(path, base, &fakeImageSettings{})
+	_, err = live.set(map[string]string{"maxConcurrentPulls": "2"}, true)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err), "missing config file must not be created")
+
+	content := "listenSocket: /var/run/sycri.sock\nstorageDir: /var/lib/singularity\nbaseRunDir: /var/run/cri\n" +
+		"# comment\nmaxConcurrentPulls: 1\ndebug: true\n"
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0640))
+
+	_, err = live.set(map[string]string{
+		"maxConcurrentPulls":                 "2",
+		"pullBandwidth.registries.docker.io": "20Mi",
+		"imageGCHighWatermark":               "90",
+	}, true)
+	require.NoError(t, err)
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+
+	var file yaml.MapSlice
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, yaml.Unmarshal(data, &file))
+	var keys []interface{}
+	for _, item := range file {
+		keys = append(keys, item.Key)
+	}
+	require.Equal(t, []interface{}{"listenSocket", "storageDir", "baseRunDir", "maxConcurrentPulls", "debug",
+		"pullBandwidth", "imageGCHighWatermark"}, keys)
+
+	config, err := parseConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, config.MaxConcurrentPulls)
+	require.Equal(t, 90, config.ImageGCHighWatermark)
+	require.Equal(t, map[string]string{"docker.io": "20Mi"}, config.PullBandwidth.Registries)
+	require.True(t, config.Debug)
+}
@@ -19,23 +19,40 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
+	goruntime "runtime"
+	godebug "runtime/debug"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
 	"github.com/sylabs/singularity-cri/pkg/fs"
+	sImage "github.com/sylabs/singularity-cri/pkg/image"
 	"github.com/sylabs/singularity-cri/pkg/index"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity-cri/pkg/preflight"
+	"github.com/sylabs/singularity-cri/pkg/reference"
 	"github.com/sylabs/singularity-cri/pkg/server/device"
 	"github.com/sylabs/singularity-cri/pkg/server/image"
 	"github.com/sylabs/singularity-cri/pkg/server/runtime"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
 	sRuntime "github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity-cri/pkg/version"
+	"github.com/sylabs/singularity-cri/pkg/warnings"
 	syunix "github.com/sylabs/singularity/pkg/util/unix"
 	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/kubernetes/pkg/kubectl/util/logs"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 	k8sDP "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
@@ -44,8 +61,20 @@ import (
 var (
 	errGPUNotSupported = fmt.Errorf("GPU device plugin is not supported on this host")
 
-	configPath string
-	version    = "unknown"
+	configPath        string
+	printVersion      bool
+	versionFormat     string
+	registryAuthFile  string
+	imageScratchDir   string
+	restrictHostPaths bool
+	lenientImages     bool
+	annotations       string
+	storageReserve    string
+	preflightSkip     string
+	maxHotExited      int
+	exitedCompactAge  time.Duration
+	fakeEngine        bool
+	debugSandbox      bool
 )
 
 func init() {
@@ -54,25 +83,159 @@ func init() {
 	// test binary b/c it won't be initialized before main() is called and we will have
 	// 'flag provided but not defined' error.
 	flag.StringVar(&configPath, "config", "/usr/local/etc/sycri/sycri.yaml", "path to config file")
+	flag.BoolVar(&printVersion, "version", false, "print version information and exit")
+	flag.StringVar(&versionFormat, "version-format", "text", "version output format, one of text or json")
+	flag.StringVar(&registryAuthFile, "registry-auth-file", "", "docker config file with node-level registry credentials, overrides config value")
+	flag.StringVar(&imageScratchDir, "image-scratch-dir", "", "directory images are downloaded and converted in, overrides config value")
+	flag.StringVar(&annotations, "annotation-passthrough", "", "comma separated annotation patterns to copy into OCI spec, overrides config value")
+	flag.StringVar(&storageReserve, "image-storage-reserve", "", "free space pulls must leave on image storage, e.g. 10%,5Gi, overrides config value")
+	flag.StringVar(&preflightSkip, "preflight-skip", "", "comma separated preflight checks to skip on startup")
+	flag.BoolVar(&lenientImages, "lenient-image-names", false, "lowercase uppercase docker repository names instead of rejecting them, overrides config value")
+	flag.BoolVar(&restrictHostPaths, "restrict-host-paths", false, "allow bind mounts of allowed host paths only, overrides config value")
+	flag.IntVar(&maxHotExited, "max-hot-exited-containers", 0, "number of most recently exited containers kept in full, negative disables the limit, overrides config value")
+	flag.DurationVar(&exitedCompactAge, "exited-compact-age", 0, "time since exit after which containers are compacted, negative disables it, overrides config value")
+	flag.BoolVar(&fakeEngine, "fake-engine", false, "run containers as host processes without Singularity, for integration testing only")
+	flag.BoolVar(&debugSandbox, "debug-sandbox", false, "keep artifacts of pods that fail to run for inspection, overrides config value")
 }
 
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "version" {
-		fmt.Println(version)
-		return
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "version":
+			fmt.Println(version.Version)
+			return
+		case preloadCmd, preloadStatusCmd:
+			if err := runPreload(os.Args[1], os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		case preflightCmd:
+			if err := runPreflight(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		case exportImageCmd, importImageCmd:
+			if err := runTransfer(os.Args[1], os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		case fsckCmd:
+			if err := runFsck(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		case listContainersCmd:
+			if err := runListContainers(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		case dumpStateCmd:
+			if err := runDumpState(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		case watchEventsCmd:
+			if err := runWatchEvents(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		case netnsCmd:
+			if err := runNetNs(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		case reconcileIPAMCmd:
+			if err := runReconcileIPAM(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		case kube.MonitorCommand:
+			if err := runMonitor(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		case runtimeConfigCmd:
+			if err := runRuntimeConfig(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
 	}
 
 	flag.Parse()
+	if printVersion {
+		if err := writeVersion(os.Stdout, versionFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not print version: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// pods and containers rely on Linux namespaces, cgroups and SIF
+	// mounts, other platforms are only good for building and client commands
+	if goruntime.GOOS != "linux" {
+		fmt.Fprintf(os.Stderr, "Singularity-CRI cannot serve on %s, Linux is required\n", goruntime.GOOS)
+		os.Exit(1)
+	}
+
 	logs.InitLogs()
 	defer logs.FlushLogs()
 
-	setSingularityLogLevel()
-
 	config, err := parseConfig(configPath)
 	if err != nil {
 		glog.Errorf("Could not parse config: %v", err)
 		return
 	}
+	if config.LogLevel != 0 && !flagPassed("v") {
+		if err := flag.Set("v", strconv.Itoa(config.LogLevel)); err != nil {
+			glog.Errorf("Could not set log level: %v", err)
+		}
+	}
+	setSingularityLogLevel()
+	if registryAuthFile != "" {
+		config.RegistryAuthFile = registryAuthFile
+	}
+	if imageScratchDir != "" {
+		config.ImageScratchDir = imageScratchDir
+	}
+	if lenientImages {
+		config.LenientImageNames = true
+	}
+	if restrictHostPaths {
+		config.RestrictHostPaths = true
+	}
+	if annotations != "" {
+		config.AnnotationPassthrough = strings.Split(annotations, ",")
+	}
+	if storageReserve != "" {
+		config.ImageStorageReserve = storageReserve
+	}
+	if maxHotExited != 0 {
+		config.MaxHotExitedContainers = maxHotExited
+	}
+	if exitedCompactAge != 0 {
+		config.ExitedCompactAge = exitedCompactAge
+	}
+	if debugSandbox {
+		config.DebugSandbox = true
+	}
+
+	checks, err := runStartupPreflight(config, preflightSkip)
+	if err != nil {
+		glog.Errorf("Could not run preflight checks: %v", err)
+		return
+	}
 
 	// initialize user agent strings
 	useragent.InitValue("singularity", "3.1.0")
@@ -81,6 +244,9 @@ func main() {
 	exitCh := make(chan os.Signal, 1)
 	signal.Notify(exitCh, unix.SIGINT, unix.SIGTERM, unix.SIGQUIT)
 
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, unix.SIGHUP)
+
 	// the next defer calls will be executed in reverse order
 	// each defer is specified separately to prevent weird runtime behavior when
 	// defer func in not yet called but objects are already garbage collected, e.g.
@@ -101,11 +267,48 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if err := startCRI(ctx, criWG, config); err != nil {
+	startTracing(ctx, criWG, config.Tracing)
+	syRuntime, syImage, live, err := startCRI(ctx, criWG, config, checks)
+	if err != nil {
 		glog.Errorf("Could not start Singularity-CRI server: %v", err)
 		return
 	}
 
+	if err := syRuntime.WatchEngine(ctx); err != nil {
+		glog.Warningf("Singularity engine changes will be detected on SIGHUP only: %v", err)
+	}
+	syRuntime.StartIPAMReconcile(ctx)
+	syRuntime.StartOrphanReaper(ctx)
+	syRuntime.StartOOMWatcher(ctx)
+	syRuntime.StartStatsCollector(ctx)
+
+	health, err := startHealth(ctx, criWG, config, syRuntime.ProbeIndexes)
+	if err != nil {
+		glog.Errorf("Could not start health checks: %v", err)
+		return
+	}
+	if err := startMetrics(ctx, criWG, config, syImage.PullsInProgress); err != nil {
+		glog.Errorf("Could not start metrics server: %v", err)
+		return
+	}
+	wd, err := newWatchdog()
+	if err != nil {
+		glog.Errorf("Could not set up systemd watchdog: %v", err)
+		return
+	}
+	healthInterval := health.interval
+	if wd != nil {
+		if wd.interval < healthInterval {
+			healthInterval = wd.interval
+		}
+		if 2*health.timeout >= wd.interval {
+			glog.Warningf("Health timeout %s is too long for systemd watchdog interval %s", health.timeout, wd.interval)
+		}
+	}
+	healthTicker := time.NewTicker(healthInterval)
+	defer healthTicker.Stop()
+	checkHealth(health, wd)
+
 	dpCtx, dpCancel := context.WithCancel(ctx)
 	err = startDevicePlugin(dpCtx, dpWG, config)
 	devicePluginEnabled := err == nil
@@ -142,6 +345,14 @@ func main() {
 					return
 				}
 			}
+		case <-healthTicker.C:
+			checkHealth(health, wd)
+		case <-hupCh:
+			glog.Infof("Received SIGHUP signal, re-checking Singularity engine version, pinned images, pull bandwidth and registries")
+			if err := syRuntime.RefreshEngineVersion(); err != nil {
+				glog.Errorf("Could not refresh Singularity engine version: %v", err)
+			}
+			live.reloadImageConfig()
 		case s := <-exitCh:
 			glog.Infof("Received %s signal, shutting down...", s)
 			return
@@ -150,42 +361,250 @@ func main() {
 
 }
 
-func startCRI(ctx context.Context, wg *sync.WaitGroup, config Config) error {
+func startCRI(ctx context.Context, wg *sync.WaitGroup, config Config, checks []preflight.Result) (*runtime.SingularityRuntime, *image.SingularityRegistry, *liveConfig, error) {
+	// stored references are parsed when registry is restored
+	reference.SetLenient(config.LenientImageNames)
+	warnings.SetRetention(config.WarningRetention)
+	kube.SetSeccompProfileRoot(config.SeccompProfileRoot)
+	if cgroups, err := kube.DetectCgroups(); err != nil {
+		glog.Warningf("Could not detect cgroup hierarchies: %v", err)
+	} else {
+		glog.Infof("Detected %s cgroup hierarchies", cgroups.Version)
+		kube.SetCgroupInfo(cgroups)
+	}
+	sImage.SetRegistries(registries(config))
 	imageIndex := index.NewImageIndex()
-	syImage, err := image.NewSingularityRegistry(config.StorageDir, imageIndex)
+	imageOpts := []image.Option{
+		image.WithAuthFile(config.RegistryAuthFile),
+	}
+	if config.ImageScratchDir != "" {
+		imageOpts = append(imageOpts, image.WithScratchDir(config.ImageScratchDir))
+	}
+	if config.DisableDigestCheck {
+		imageOpts = append(imageOpts, image.WithoutDigestCheck())
+	}
+	if config.OCIImageLayout {
+		imageOpts = append(imageOpts, image.WithOCILayout())
+	}
+	if len(config.PinnedImages) != 0 {
+		imageOpts = append(imageOpts, image.WithPinnedImages(config.PinnedImages))
+	}
+	if len(config.WarmImages) != 0 {
+		imageOpts = append(imageOpts, image.WithWarmImages(config.WarmImages))
+	}
+	if config.PreloadPinTTL != 0 {
+		imageOpts = append(imageOpts, image.WithPreloadPinTTL(config.PreloadPinTTL))
+	}
+	if config.ImageStorageReserve != "" {
+		reserve, err := image.ParseStorageReserve(config.ImageStorageReserve)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		imageOpts = append(imageOpts, image.WithStorageReserve(reserve))
+	}
+	if config.PullStallTimeout != 0 {
+		imageOpts = append(imageOpts, image.WithPullStallTimeout(config.PullStallTimeout))
+	}
+	if config.ImageUsageInterval != 0 {
+		imageOpts = append(imageOpts, image.WithUsageRefreshInterval(config.ImageUsageInterval))
+	}
+	if gc := imageGC(config); gc != nil {
+		imageOpts = append(imageOpts, image.WithImageGC(*gc))
+	}
+	limits, err := pullLimits(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	imageOpts = append(imageOpts, image.WithPullLimits(limits))
+	if config.MaxConcurrentPulls != 0 {
+		imageOpts = append(imageOpts, image.WithMaxConcurrentPulls(config.MaxConcurrentPulls))
+	}
+	if config.ConcurrentLayerDownloads != 0 {
+		imageOpts = append(imageOpts, image.WithConcurrentLayerDownloads(config.ConcurrentLayerDownloads))
+	}
+	if config.SignaturePolicy != "" {
+		policy, err := sImage.ParseSignaturePolicy(config.SignaturePolicy)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		imageOpts = append(imageOpts, image.WithSignaturePolicy(policy))
+	}
+	if config.KeyServer != "" {
+		imageOpts = append(imageOpts, image.WithKeyServer(config.KeyServer))
+	}
+	if rules := trustRules(config); len(rules) != 0 {
+		imageOpts = append(imageOpts, image.WithTrustRules(rules))
+	}
+	if fakeEngine {
+		imageOpts = append(imageOpts, image.WithoutEngineCheck())
+	}
+	// runtime restores containers from image index populated by registry,
+	// so it is created later and image release is bound lazily
+	var (
+		releaseMu sync.Mutex
+		release   func(imageID string) bool
+	)
+	imageOpts = append(imageOpts, image.WithImageRelease(func(imageID string) bool {
+		releaseMu.Lock()
+		defer releaseMu.Unlock()
+		return release == nil || release(imageID)
+	}))
+	syImage, err := image.NewSingularityRegistry(config.StorageDir, imageIndex, imageOpts...)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("could not create Singularity image service: %v", err)
+	}
+	logOwner, err := parseOwner(config.LogDirOwner)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid log directory owner: %v", err)
+	}
+	logDriver, err := kube.ParseLogDriver(config.LogDriver)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	logOverflow, err := kube.ParseLogOverflow(config.LogOverflow)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	contDefaults, err := containerDefaults(config)
 	if err != nil {
-		return fmt.Errorf("could not create Singularity image service: %v", err)
+		return nil, nil, nil, fmt.Errorf("invalid container defaults: %v", err)
 	}
-	syRuntime, err := runtime.NewSingularityRuntime(
-		imageIndex,
-		runtime.WithStreaming(config.StreamingURL),
-		runtime.WithNetwork(config.CNIBinDir, config.CNIConfDir),
+	ociHooks, err := kube.LoadOCIHooks(config.OCIHooksDirs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var monitorPath string
+	if config.ContainerMonitor {
+		if monitorPath, err = os.Executable(); err != nil {
+			return nil, nil, nil, fmt.Errorf("could not find container monitor executable: %v", err)
+		}
+	}
+	if kube.SELinuxEnabled() {
+		glog.Infof("SELinux is enabled, relabeling of mounts disabled: %t", config.DisableSELinuxRelabel)
+	}
+	streamingTLS, err := streamingTLSConfig(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cgroupDriver, _ := kube.ParseCgroupDriver(config.CgroupDriver)
+	runtimeOpts := []runtime.Option{
+		runtime.WithStreaming(config.StreamingURL, config.StreamingTokenTTL, streamingTLS),
+		runtime.WithNetwork(config.CNIBinDir, config.CNIConfDir, config.CNIConfTemplate),
 		runtime.WithBaseRunDir(config.BaseRunDir),
 		runtime.WithTrashDir(config.TrashDir),
-	)
+		runtime.WithCgroupDriver(cgroupDriver),
+		runtime.WithWritableLayerSize(config.WritableLayerSize),
+		runtime.WithLiveRestore(config.LiveRestore),
+		runtime.WithContainerMonitor(monitorPath),
+		runtime.WithFullImageCheck(config.FullImageCheck),
+		runtime.WithLogDirOwner(logOwner),
+		runtime.WithMountPolicy(mountPolicy(config)),
+		runtime.WithSELinuxRelabel(!config.DisableSELinuxRelabel),
+		runtime.WithRestrictedOOMScoreAdj(config.RestrictOOMScoreAdj),
+		runtime.WithSysctlPolicy(sysctlPolicy(config)),
+		runtime.WithNUMAMemoryBinding(config.NUMAMemoryBinding),
+		runtime.WithAnnotationPassthrough(config.AnnotationPassthrough),
+		runtime.WithLogDriver(logDriver),
+		runtime.WithLogBuffer(config.LogBufferSize, logOverflow),
+		runtime.WithLogRotation(logRotation(config)),
+		runtime.WithAttachReplay(config.AttachReplaySize),
+		runtime.WithExecSyncOutputLimit(config.ExecSyncOutputLimit),
+		runtime.WithEventBufferSize(config.ContainerEventBufferSize),
+		runtime.WithIPAMReconcile(config.IPAMReconcileNetworks, config.IPAMReconcileInterval),
+		runtime.WithOrphanReaper(config.OrphanReapInterval),
+		runtime.WithStatsInterval(config.StatsInterval),
+		runtime.WithOOMWatchInterval(config.OOMWatchInterval),
+		runtime.WithHooks(lifecycleHooks(config)),
+		runtime.WithAdmission(admissionPolicy(config)),
+		runtime.WithOCIHooks(ociHooks),
+		runtime.WithWarmPools(warmPools(config)),
+		runtime.WithRuntimeHandlers(runtimeHandlers(config)),
+		runtime.WithUserNamespace(userNamespace(config)),
+		runtime.WithContainerDefaults(contDefaults),
+		runtime.WithPreflight(checks),
+	}
+	if config.RedactedEnvs != nil {
+		runtimeOpts = append(runtimeOpts, runtime.WithRedactedEnvs(config.RedactedEnvs))
+	}
+	if config.MaxHotExitedContainers != 0 || config.ExitedCompactAge != 0 {
+		maxHot, age := config.MaxHotExitedContainers, config.ExitedCompactAge
+		if maxHot == 0 {
+			maxHot = kube.DefaultMaxHotExited
+		}
+		if age == 0 {
+			age = kube.DefaultExitedCompactAge
+		}
+		runtimeOpts = append(runtimeOpts, runtime.WithExitedCompaction(maxHot, age))
+	}
+	if config.MaxListAnnotationsSize != 0 {
+		runtimeOpts = append(runtimeOpts, runtime.WithListAnnotationsLimit(config.MaxListAnnotationsSize))
+	}
+	if config.DebugSandbox || config.DebugSandboxRetention != 0 {
+		runtimeOpts = append(runtimeOpts, runtime.WithDebugSandbox(config.DebugSandbox, config.DebugSandboxRetention))
+	}
+	if fakeEngine {
+		glog.Warningf("Using fake engine, containers are run as host processes without any isolation")
+		runtimeOpts = append(runtimeOpts, runtime.WithFakeEngine())
+	}
+	syRuntime, err := runtime.NewSingularityRuntime(imageIndex, runtimeOpts...)
 	if err != nil {
-		return fmt.Errorf("could not create Singularity runtime service: %v", err)
+		return nil, nil, nil, fmt.Errorf("could not create Singularity runtime service: %v", err)
 	}
+	releaseMu.Lock()
+	release = syRuntime.ReleaseImage
+	releaseMu.Unlock()
 
-	lis, err := syunix.CreateSocket(config.ListenSocket)
+	listeners, err := criListeners(config)
 	if err != nil {
-		return fmt.Errorf("could not start CRI listener: %v ", err)
+		return nil, nil, nil, fmt.Errorf("could not start CRI listener: %v ", err)
 	}
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(logAndRecover(config.Debug)))
+	grpcOpts := append(grpcLimits(config), grpc.UnaryInterceptor(
+		chainInterceptors(observeDuration, traceRequest, logAndRecover(config.Debug, redactedEnvs(config)), internalDeadline),
+	))
+	grpcServer := grpc.NewServer(grpcOpts...)
 	k8s.RegisterRuntimeServiceServer(grpcServer, syRuntime)
 	k8s.RegisterImageServiceServer(grpcServer, syImage)
+	admin.RegisterImageAdminServer(grpcServer, syImage)
+	live := newLiveConfig(configPath, config, syImage)
+	runtimeAdmin := &runtimeAdmin{SingularityRuntime: syRuntime, images: syImage, live: live}
+	admin.RegisterRuntimeAdminServer(grpcServer, runtimeAdmin)
+
+	// debug server is served on its own socket, so that daemon state
+	// may be inspected even when CRI socket is shared with other users
+	var debugLis net.Listener
+	debugServer := grpc.NewServer(grpc.UnaryInterceptor(
+		chainInterceptors(logAndRecover(config.Debug, redactedEnvs(config))),
+	))
+	admin.RegisterRuntimeAdminServer(debugServer, runtimeAdmin)
+	if config.DebugSocket != "" {
+		debugLis, err = syunix.CreateSocket(config.DebugSocket)
+		if err != nil {
+			for _, lis := range listeners {
+				lis.Close()
+			}
+			return nil, nil, nil, fmt.Errorf("could not start debug listener: %v", err)
+		}
+	}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer lis.Close()
+		for _, lis := range listeners {
+			defer lis.Close()
 
-		go grpcServer.Serve(lis)
+			go grpcServer.Serve(lis)
+			glog.Infof("Singularity-CRI server started on %v", lis.Addr())
+		}
+		if debugLis != nil {
+			defer debugLis.Close()
 
-		glog.Infof("Singularity-CRI server started on %v", lis.Addr())
+			go debugServer.Serve(debugLis)
+			glog.Infof("Singularity-CRI debug server started on %v", debugLis.Addr())
+		}
 		<-ctx.Done()
 
 		glog.Info("Singularity-CRI service exiting...")
+		debugServer.Stop()
 		grpcServer.Stop()
 		if err := syRuntime.Shutdown(); err != nil {
 			glog.Errorf("Error during singularity runtime service shutdown: %v", err)
@@ -194,12 +613,42 @@ func startCRI(ctx context.Context, wg *sync.WaitGroup, config Config) error {
 			glog.Errorf("Error during singularity image service shutdown: %v", err)
 		}
 	}()
-	return nil
+	return syRuntime, syImage, live, nil
+}
+
+func writeVersion(w io.Writer, format string) error {
+	info := struct {
+		version.Info
+		EngineVersion string `json:"engineVersion"`
+	}{
+		Info:          version.Get(),
+		EngineVersion: "unknown",
+	}
+	if out, err := exec.Command(singularity.RuntimeName, "version").Output(); err == nil {
+		info.EngineVersion = strings.TrimSpace(string(out))
+	}
+
+	switch format {
+	case "text":
+		_, err := fmt.Fprintf(w, "Version:        %s\nGit commit:     %s\nBuild date:     %s\nCRI version:    %s\nGo version:     %s\nEngine version: %s\n",
+			info.Version, info.GitCommit, info.BuildDate, info.CRIVersion, info.GoVersion, info.EngineVersion)
+		return err
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	default:
+		return fmt.Errorf("unknown version format %q", format)
+	}
 }
 
 func startDevicePlugin(ctx context.Context, wg *sync.WaitGroup, config Config) error {
 	const devicePluginSocket = k8sDP.DevicePluginPath + "singularity.sock"
 
+	if fakeEngine {
+		glog.Warningf("GPU support is not enabled with fake engine")
+		return errGPUNotSupported
+	}
 	devicePlugin, err := device.NewSingularityDevicePlugin()
 	if err == device.ErrUnableToLoad || err == device.ErrNoGPUs {
 		glog.Warningf("GPU support is not enabled: %v", err)
@@ -221,7 +670,7 @@ func startDevicePlugin(ctx context.Context, wg *sync.WaitGroup, config Config) e
 		return fmt.Errorf("could not start device plugin listener: %v ", err)
 	}
 
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(logAndRecover(config.Debug)))
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(logAndRecover(config.Debug, redactedEnvs(config))))
 	k8sDP.RegisterDevicePluginServer(grpcServer, devicePlugin)
 
 	register := make(chan error)
@@ -250,24 +699,33 @@ func startDevicePlugin(ctx context.Context, wg *sync.WaitGroup, config Config) e
 	return <-register
 }
 
-func logAndRecover(debug bool) grpc.UnaryServerInterceptor {
+// logAndRecover logs failed requests, or all of them in debug mode, and turns
+// handler panics into errors. Environment variables and annotations which
+// names match redacted patterns are hidden from logged requests.
+func logAndRecover(debug bool, redacted []string) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{},
 		info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, e error) {
 		defer func() {
 			if err := recover(); err != nil {
-				glog.Errorf("Caught panic in %s: %v", info.FullMethod, err)
-				e = fmt.Errorf("panic: %v", err)
+				glog.Errorf("Caught panic in %s: %v\n%s", info.FullMethod, err, godebug.Stack())
+				e = status.Errorf(codes.Internal, "%s panicked: %v", info.FullMethod, err)
 			}
 		}()
 
 		resp, err := handler(ctx, req)
 		if debug || err != nil {
 			// mask any credentials received before logging
-			r, ok := req.(*k8s.PullImageRequest)
-			if ok && r.Auth != nil {
-				r.Auth.Reset()
+			switch r := req.(type) {
+			case *k8s.PullImageRequest:
+				if r.Auth != nil {
+					r.Auth.Reset()
+				}
+			case *admin.PreloadImageRequest:
+				if r.Auth != nil {
+					r.Auth.Reset()
+				}
 			}
-			jsonReq, _ := json.Marshal(req)
+			jsonReq, _ := json.Marshal(runtime.RedactRequest(req, redacted))
 			jsonResp, _ := json.Marshal(resp)
 			logFunc := glog.Infof
 			if err != nil {
@@ -279,19 +737,19 @@ func logAndRecover(debug bool) grpc.UnaryServerInterceptor {
 	}
 }
 
-func setSingularityLogLevel() {
-	f := flag.Lookup("v")
-	if f == nil {
-		return
-	}
-
-	level, err := strconv.Atoi(f.Value.String())
-	if err != nil {
-		glog.Errorf("Could not parse verbose level %s", err)
-		return
-	}
+// flagPassed checks whether flag with the name is set on command line.
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
 
-	if level >= 6 {
+func setSingularityLogLevel() {
+	if logLevel() >= 6 {
 		err := os.Setenv(sRuntime.LogLevelEnv, sRuntime.LogLevelDebug)
 		if err != nil {
 			glog.Errorf("Could not set env log level %s", err)
@@ -0,0 +1,80 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/metrics"
+	"github.com/sylabs/singularity-cri/pkg/server/image"
+)
+
+// startMetrics serves /metrics and /debug/pulls with running image pulls
+// returned by pulls on config.MetricsListen until ctx is done. Both are
+// served over HTTPS when TLS certificate and key are set.
+func startMetrics(ctx context.Context, wg *sync.WaitGroup, config Config, pulls func() []image.PullStatus) error {
+	if config.MetricsListen == "" {
+		return nil
+	}
+	srv := &http.Server{}
+	if config.MetricsTLSCert != "" {
+		// load key pair upfront so that invalid files fail daemon start
+		cert, err := tls.LoadX509KeyPair(config.MetricsTLSCert, config.MetricsTLSKey)
+		if err != nil {
+			return fmt.Errorf("could not load metrics TLS key pair: %v", err)
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	lis, err := net.Listen("tcp", config.MetricsListen)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %v", config.MetricsListen, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/debug/pulls", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(pulls()); err != nil {
+			glog.Errorf("Could not write pulls in progress: %v", err)
+		}
+	})
+	srv.Handler = mux
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ServeTLS(lis, "", "")
+		} else {
+			err = srv.Serve(lis)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			glog.Errorf("Metrics server failed: %v", err)
+		}
+	}()
+	glog.Infof("Metrics server started on %v (TLS: %t)", lis.Addr(), srv.TLSConfig != nil)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		srv.Close()
+	}()
+	return nil
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+)
+
+// runMonitor executes monitor subcommand that supervises a single container.
+// It is started by the daemon for each container when containerMonitor is set,
+// monitor log is written to stderr which daemon redirects to container directory.
+func runMonitor(args []string) error {
+	flags := flag.NewFlagSet(kube.MonitorCommand, flag.ContinueOnError)
+	verbosity := flags.String("v", "0", "log level of the monitor")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options] <container directory>\n", os.Args[0], kube.MonitorCommand)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("unexpected number of arguments")
+	}
+	// glog registers its flags on the default flag set
+	flag.Set("logtostderr", "true")
+	flag.Set("v", *verbosity)
+	defer glog.Flush()
+	return kube.RunMonitor(flags.Arg(0), os.Stdout)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"google.golang.org/grpc"
+)
+
+const netnsCmd = "netns"
+
+// netNsExecArgs splits netns exec arguments into pod reference and command.
+func netNsExecArgs(args []string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("pod is not specified")
+	}
+	pod, command := args[0], args[1:]
+	if len(command) != 0 && command[0] == "--" {
+		command = command[1:]
+	}
+	if len(command) == 0 {
+		return "", nil, fmt.Errorf("command is not specified")
+	}
+	return pod, command, nil
+}
+
+// runNetNs executes netns subcommand that runs a command in pod network
+// namespace, e.g. tcpdump. Pod is resolved by RuntimeAdmin service of the
+// running Singularity-CRI, command is then run by this process with its stdio.
+func runNetNs(args []string) error {
+	flags := flag.NewFlagSet(netnsCmd, flag.ContinueOnError)
+	socket := flags.String("socket", defaultConfig.ListenSocket, "Singularity-CRI socket")
+	timeout := flags.Duration("timeout", 10*time.Second, "request timeout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s exec [options] <pod-id|[namespace/]name> -- <command> [args...]\n", os.Args[0], netnsCmd)
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "exec" {
+		flags.Usage()
+		return fmt.Errorf("unknown %s subcommand", netnsCmd)
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	pod, command, err := netNsExecArgs(flags.Args())
+	if err != nil {
+		flags.Usage()
+		return err
+	}
+
+	conn, err := grpc.Dial("unix://"+*socket, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("could not dial %s: %v", *socket, err)
+	}
+	defer conn.Close()
+	client := admin.NewRuntimeAdminClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	resp, err := client.PrepareNetNsExec(ctx, &admin.PrepareNetNsExecRequest{
+		Pod:     pod,
+		Command: command,
+	})
+	if err != nil {
+		return fmt.Errorf("could not enter pod network namespace: %v", err)
+	}
+	if len(resp.Args) == 0 {
+		return fmt.Errorf("empty command line returned")
+	}
+
+	fmt.Fprintf(os.Stderr, "Warning: running in network namespace %s of pod %s; "+
+		"host filesystem, mounts and privileges are still visible\n", resp.NetNsPath, resp.PodSandboxId)
+	return syscall.Exec(resp.Args[0], resp.Args, os.Environ())
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetNsExecArgs(t *testing.T) {
+	tt := []struct {
+		name          string
+		args          []string
+		expectPod     string
+		expectCommand []string
+		expectError   bool
+	}{
+		{
+			name:          "with separator",
+			args:          []string{"default/nginx", "--", "tcpdump", "-i", "eth0"},
+			expectPod:     "default/nginx",
+			expectCommand: []string{"tcpdump", "-i", "eth0"},
+		},
+		{
+			name:          "without separator",
+			args:          []string{"4f107d2", "ip", "addr"},
+			expectPod:     "4f107d2",
+			expectCommand: []string{"ip", "addr"},
+		},
+		{
+			name:        "no command",
+			args:        []string{"nginx", "--"},
+			expectError: true,
+		},
+		{
+			name:        "no pod",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			pod, command, err := netNsExecArgs(tc.args)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectPod, pod)
+			require.Equal(t, tc.expectCommand, command)
+		})
+	}
+}
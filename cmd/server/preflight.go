@@ -0,0 +1,168 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity-cri/pkg/network"
+	"github.com/sylabs/singularity-cri/pkg/preflight"
+	"github.com/sylabs/singularity-cri/pkg/server/runtime"
+)
+
+const preflightCmd = "preflight"
+
+// preflightChecks returns host checks for the passed config. The same
+// checks are run by preflight subcommand and on server startup.
+func preflightChecks(config Config) []preflight.Check {
+	cniBinDir := config.CNIBinDir
+	if cniBinDir == "" {
+		cniBinDir = network.CNIBinDir
+	}
+	cniConfDir := config.CNIConfDir
+	if cniConfDir == "" {
+		cniConfDir = network.CNIConfDir
+	}
+	dirs := []string{config.StorageDir, config.BaseRunDir}
+	if config.TrashDir != "" {
+		dirs = append(dirs, config.TrashDir)
+	}
+	return []preflight.Check{
+		preflight.Kernel(),
+		{Name: "cgroups", Run: checkCgroups},
+		preflight.UserNamespaces(),
+		{Name: "engine", Run: checkEngine},
+		preflight.CNI(cniBinDir, cniConfDir, config.CNIConfTemplate != ""),
+		preflight.Storage(dirs...),
+		preflight.SELinux(dirs...),
+		preflight.AppArmor(),
+		// needed for pod network namespace exec, traffic marking and read-only exec
+		preflight.Binaries("nsenter", "iptables", "unshare", "setpriv"),
+	}
+}
+
+func checkCgroups() (preflight.Status, string) {
+	info, err := kube.DetectCgroups()
+	if err != nil {
+		return preflight.StatusFail, err.Error()
+	}
+	available := make(map[string]bool, len(info.Controllers))
+	for _, c := range info.Controllers {
+		available[c] = true
+	}
+	msg := fmt.Sprintf("%s, %s", info.Version, strings.Join(info.Controllers, ","))
+	for _, c := range []string{"cpu", "memory"} {
+		if !available[c] {
+			return preflight.StatusFail, fmt.Sprintf("%s, %s controller is not available", msg, c)
+		}
+	}
+	if !available["pids"] {
+		return preflight.StatusWarn, fmt.Sprintf("%s, pids controller is not available", msg)
+	}
+	return preflight.StatusPass, msg
+}
+
+func checkEngine() (preflight.Status, string) {
+	engine := runtime.ProbeEngine()
+	if !engine.Ready {
+		return preflight.StatusFail, engine.Message
+	}
+	msg := fmt.Sprintf("%s at %s", engine.Version, engine.Path)
+	if engine.Starter != "" && !engine.Setuid {
+		msg += ", setuid starter is not installed"
+	}
+	return preflight.StatusPass, msg
+}
+
+// runStartupPreflight runs preflight checks on server startup and logs
+// problems found. Server starts anyway since some of them may be resolved
+// later, e.g. CNI configuration may be installed after startup.
+func runStartupPreflight(config Config, skip string) ([]preflight.Result, error) {
+	checks := preflightChecks(config)
+	skipped, err := preflight.ParseSkip(skip, checks)
+	if err != nil {
+		return nil, err
+	}
+	results := preflight.Run(checks, skipped)
+	for _, r := range results {
+		switch r.Status {
+		case preflight.StatusFail:
+			glog.Errorf("Preflight check %s failed: %s", r.Name, r.Message)
+		case preflight.StatusWarn:
+			glog.Warningf("Preflight check %s: %s", r.Name, r.Message)
+		default:
+			glog.V(2).Infof("Preflight check %s: %s %s", r.Name, r.Status, r.Message)
+		}
+	}
+	return results, nil
+}
+
+// runPreflight executes preflight subcommand that checks whether
+// host is capable of running Singularity-CRI.
+func runPreflight(args []string) error {
+	flags := flag.NewFlagSet(preflightCmd, flag.ContinueOnError)
+	config := flags.String("config", configPath, "path to config file")
+	skip := flags.String("skip", "", "comma separated checks to skip")
+	format := flags.String("format", "text", "output format, one of text or json")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options]\n", os.Args[0], preflightCmd)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("unexpected number of arguments")
+	}
+
+	cfg, err := parseConfig(*config)
+	if err != nil {
+		return fmt.Errorf("could not parse config: %v", err)
+	}
+	checks := preflightChecks(cfg)
+	skipped, err := preflight.ParseSkip(*skip, checks)
+	if err != nil {
+		return err
+	}
+	results := preflight.Run(checks, skipped)
+	if err := writePreflight(os.Stdout, results, *format); err != nil {
+		return err
+	}
+	if preflight.Failed(results) {
+		return fmt.Errorf("preflight checks failed")
+	}
+	return nil
+}
+
+func writePreflight(w io.Writer, results []preflight.Result, format string) error {
+	switch format {
+	case "text":
+		return preflight.Write(w, results)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"google.golang.org/grpc"
+)
+
+const (
+	preloadCmd       = "preload"
+	preloadStatusCmd = "preload-status"
+)
+
+// runPreload executes preload and preload-status subcommands
+// that talk to ImageAdmin service of the running Singularity-CRI.
+func runPreload(cmd string, args []string) error {
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	socket := flags.String("socket", defaultConfig.ListenSocket, "Singularity-CRI socket")
+	timeout := flags.Duration("timeout", 10*time.Second, "request timeout")
+	var (
+		priority      *int
+		username      *string
+		passwordStdin *bool
+		wait          *bool
+		pin           *bool
+		retry         *bool
+	)
+	if cmd == preloadCmd {
+		priority = flags.Int("priority", 0, "preload priority, images with higher priority are pulled first")
+		pin = flags.Bool("pin", false, "keep image from removal until Singularity-CRI restart")
+		retry = flags.Bool("retry", false, "retry failed pull with backoff until it succeeds")
+		username = flags.String("username", "", "registry username, node-level credentials are used when not set")
+		passwordStdin = flags.Bool("password-stdin", false, "read registry password from stdin")
+		wait = flags.Bool("wait", false, "wait for preload to finish")
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options] image\n", os.Args[0], cmd)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if cmd == preloadCmd && flags.NArg() != 1 || flags.NArg() > 1 {
+		flags.Usage()
+		return fmt.Errorf("unexpected number of arguments")
+	}
+
+	conn, err := grpc.Dial("unix://"+*socket, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("could not dial %s: %v", *socket, err)
+	}
+	defer conn.Close()
+	client := admin.NewImageAdminClient(conn)
+
+	call := func(f func(ctx context.Context) error) error {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		return f(ctx)
+	}
+
+	ref := flags.Arg(0)
+	if cmd == preloadStatusCmd {
+		return call(func(ctx context.Context) error {
+			resp, err := client.PreloadStatus(ctx, &admin.PreloadStatusRequest{Image: ref})
+			if err != nil {
+				return fmt.Errorf("could not get preload status: %v", err)
+			}
+			return writePreloads(os.Stdout, resp.Preloads)
+		})
+	}
+
+	req := &admin.PreloadImageRequest{
+		Image:    ref,
+		Priority: int32(*priority),
+		Pin:      *pin,
+		Retry:    *retry,
+	}
+	if *username != "" || *passwordStdin {
+		req.Auth = &admin.AuthConfig{Username: *username}
+		if *passwordStdin {
+			password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("could not read password: %v", err)
+			}
+			req.Auth.Password = strings.TrimRight(password, "\r\n")
+		}
+	}
+	var preload *admin.Preload
+	err = call(func(ctx context.Context) error {
+		resp, err := client.PreloadImage(ctx, req)
+		if err != nil {
+			return fmt.Errorf("could not preload image: %v", err)
+		}
+		preload = resp.Preload
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for *wait && !isFinished(preload) {
+		time.Sleep(time.Second)
+		err = call(func(ctx context.Context) error {
+			resp, err := client.PreloadStatus(ctx, &admin.PreloadStatusRequest{Image: preload.Image})
+			if err != nil {
+				return fmt.Errorf("could not get preload status: %v", err)
+			}
+			if len(resp.Preloads) != 0 {
+				preload = resp.Preloads[0]
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := writePreloads(os.Stdout, []*admin.Preload{preload}); err != nil {
+		return err
+	}
+	if preload.State == admin.PreloadState_PRELOAD_FAILED {
+		return fmt.Errorf("preload failed: %s", preload.Error)
+	}
+	return nil
+}
+
+func isFinished(p *admin.Preload) bool {
+	return p.State == admin.PreloadState_PRELOAD_DONE || p.State == admin.PreloadState_PRELOAD_FAILED
+}
+
+func writePreloads(w io.Writer, preloads []*admin.Preload) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tSTATE\tPRIORITY\tIMAGE ID\tPINNED UNTIL\tATTEMPTS\tERROR")
+	for _, p := range preloads {
+		pinned := ""
+		switch {
+		case p.Pinned:
+			pinned = "restart"
+		case p.PinnedUntil != 0:
+			pinned = time.Unix(0, p.PinnedUntil).Format(time.RFC3339)
+		}
+		errMsg := p.Error
+		if p.RetryAt != 0 {
+			errMsg += fmt.Sprintf(" (retry at %s)", time.Unix(0, p.RetryAt).Format(time.RFC3339))
+		}
+		state := strings.TrimPrefix(p.State.String(), "PRELOAD_")
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%d\t%s\n", p.Image, state, p.Priority, p.ImageRef, pinned, p.Attempts, errMsg)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"google.golang.org/grpc"
+)
+
+const runtimeConfigCmd = "runtime-config"
+
+// parseSettings parses key=value arguments of runtime-config set.
+func parseSettings(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no settings to change")
+	}
+	settings := make(map[string]string, len(args))
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("setting %q must be in key=value form", arg)
+		}
+		settings[kv[0]] = kv[1]
+	}
+	return settings, nil
+}
+
+// runRuntimeConfig executes runtime-config subcommand that shows or
+// changes hot-reloadable settings with RuntimeAdmin service of the
+// running Singularity-CRI.
+func runRuntimeConfig(args []string) error {
+	flags := flag.NewFlagSet(runtimeConfigCmd, flag.ContinueOnError)
+	socket := flags.String("socket", defaultConfig.ListenSocket, "Singularity-CRI socket")
+	timeout := flags.Duration("timeout", 10*time.Second, "request timeout")
+	persist := flags.Bool("persist", false, "write changed settings to config file so that they survive restart")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s get [options]\n", os.Args[0], runtimeConfigCmd)
+		fmt.Fprintf(flags.Output(), "       %s %s set [options] <key=value>...\n", os.Args[0], runtimeConfigCmd)
+		fmt.Fprintf(flags.Output(), "Hot-reloadable settings: %s\n", strings.Join(hotSettings, ", "))
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || (args[0] != "get" && args[0] != "set") {
+		flags.Usage()
+		return fmt.Errorf("unknown %s subcommand", runtimeConfigCmd)
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	var settings map[string]string
+	if args[0] == "set" {
+		var err error
+		settings, err = parseSettings(flags.Args())
+		if err != nil {
+			flags.Usage()
+			return err
+		}
+	} else if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("unexpected number of arguments")
+	}
+
+	conn, err := grpc.Dial("unix://"+*socket, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("could not dial %s: %v", *socket, err)
+	}
+	defer conn.Close()
+	client := admin.NewRuntimeAdminClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if settings == nil {
+		resp, err := client.GetRuntimeConfig(ctx, &admin.GetRuntimeConfigRequest{})
+		if err != nil {
+			return fmt.Errorf("could not get runtime config: %v", err)
+		}
+		return writeSettings(os.Stdout, resp.Settings)
+	}
+	resp, err := client.SetRuntimeConfig(ctx, &admin.SetRuntimeConfigRequest{
+		Settings: settings,
+		Persist:  *persist,
+	})
+	if err != nil {
+		return fmt.Errorf("could not set runtime config: %v", err)
+	}
+	return writeSettings(os.Stdout, resp.Settings)
+}
+
+func writeSettings(w io.Writer, settings map[string]string) error {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", key, settings[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/trace"
+)
+
+// startTracing exports spans to configured OTLP endpoint until ctx
+// is done. Tracing is disabled when no endpoint is set.
+func startTracing(ctx context.Context, wg *sync.WaitGroup, config TracingConfig) {
+	if config.Endpoint == "" {
+		return
+	}
+	ratio := config.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	exporter := trace.NewExporter(config.Endpoint, config.ServiceName, config.Headers)
+	trace.SetTracer(trace.NewTracer(exporter, ratio))
+	glog.Infof("Exporting traces to %s, sample ratio: %v", config.Endpoint, ratio)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		exporter.Run(ctx, config.ExportInterval)
+		trace.SetTracer(nil)
+	}()
+}
+
+// validTracing checks tracing config is usable.
+func validTracing(config TracingConfig) error {
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		return fmt.Errorf("sample ratio must be between 0 and 1")
+	}
+	if config.ExportInterval < 0 {
+		return fmt.Errorf("export interval cannot be negative")
+	}
+	if config.Endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint must be an absolute http or https URL")
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"google.golang.org/grpc"
+)
+
+const (
+	exportImageCmd = "export-image"
+	importImageCmd = "import-image"
+
+	// transferChunkSize matches chunk size used by server.
+	transferChunkSize = 1 << 20
+)
+
+// runTransfer executes export-image and import-image subcommands
+// that talk to ImageAdmin service of the running Singularity-CRI.
+func runTransfer(cmd string, args []string) error {
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	socket := flags.String("socket", defaultConfig.ListenSocket, "Singularity-CRI socket")
+	timeout := flags.Duration("timeout", 0, "transfer timeout, no timeout when not set")
+	var output *string
+	usage := "[options] [archive]"
+	if cmd == exportImageCmd {
+		output = flags.String("output", "", "archive to write image to, stdout when not set")
+		usage = "[options] image"
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s %s\n", os.Args[0], cmd, usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if cmd == exportImageCmd && flags.NArg() != 1 || flags.NArg() > 1 {
+		flags.Usage()
+		return fmt.Errorf("unexpected number of arguments")
+	}
+
+	conn, err := grpc.Dial("unix://"+*socket, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("could not dial %s: %v", *socket, err)
+	}
+	defer conn.Close()
+	client := admin.NewImageAdminClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if *timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), *timeout)
+	}
+	defer cancel()
+
+	if cmd == exportImageCmd {
+		return exportImage(ctx, client, flags.Arg(0), *output)
+	}
+	return importImage(ctx, client, flags.Arg(0))
+}
+
+func exportImage(ctx context.Context, client *admin.ImageAdminClient, ref, output string) (err error) {
+	stream, err := client.ExportImage(ctx, &admin.ExportImageRequest{Image: ref})
+	if err != nil {
+		return fmt.Errorf("could not export image: %v", err)
+	}
+
+	w := io.Writer(os.Stdout)
+	if output != "" && output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("could not create archive: %v", err)
+		}
+		w = f
+		defer func() {
+			f.Close()
+			// do not leave incomplete archive behind
+			if err != nil {
+				os.Remove(output)
+			}
+		}()
+	}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not export image: %v", err)
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return fmt.Errorf("could not write archive: %v", err)
+		}
+	}
+}
+
+func importImage(ctx context.Context, client *admin.ImageAdminClient, input string) error {
+	r := io.Reader(os.Stdin)
+	if input != "" && input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return fmt.Errorf("could not open archive: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	stream, err := client.ImportImage(ctx)
+	if err != nil {
+		return fmt.Errorf("could not import image: %v", err)
+	}
+	start := time.Now()
+	buf := make([]byte, transferChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n != 0 {
+			if err := stream.Send(&admin.ImageChunk{Data: buf[:n]}); err != nil {
+				// actual error is returned by CloseAndRecv
+				break
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not read archive: %v", err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return fmt.Errorf("could not import image: %v", err)
+	}
+	fmt.Printf("Imported image %s in %v\n", resp.ImageRef, time.Since(start).Round(time.Millisecond))
+	if len(resp.Tags) != 0 {
+		fmt.Printf("Tags: %s\n", strings.Join(resp.Tags, ", "))
+	}
+	return nil
+}
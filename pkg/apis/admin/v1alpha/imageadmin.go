@@ -0,0 +1,560 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package v1alpha holds Go definitions of ImageAdmin and RuntimeAdmin services
+// described in imageadmin.proto and runtimeadmin.proto. Messages are marshaled
+// by protobuf library using struct tags, so any edit of the proto files must
+// be reflected here.
+package v1alpha
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// ServiceName is a full name of ImageAdmin gRPC service.
+const ServiceName = "singularity.cri.v1alpha.ImageAdmin"
+
+// PreloadState is a state of image preload.
+type PreloadState int32
+
+// Possible preload states.
+const (
+	PreloadState_PRELOAD_QUEUED  PreloadState = 0
+	PreloadState_PRELOAD_PULLING PreloadState = 1
+	PreloadState_PRELOAD_DONE    PreloadState = 2
+	PreloadState_PRELOAD_FAILED  PreloadState = 3
+)
+
+var preloadStateName = map[int32]string{
+	0: "PRELOAD_QUEUED",
+	1: "PRELOAD_PULLING",
+	2: "PRELOAD_DONE",
+	3: "PRELOAD_FAILED",
+}
+
+var preloadStateValue = map[string]int32{
+	"PRELOAD_QUEUED":  0,
+	"PRELOAD_PULLING": 1,
+	"PRELOAD_DONE":    2,
+	"PRELOAD_FAILED":  3,
+}
+
+func (s PreloadState) String() string {
+	return proto.EnumName(preloadStateName, int32(s))
+}
+
+// AuthConfig mirrors CRI AuthConfig message.
+type AuthConfig struct {
+	Username      string `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Password      string `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	Auth          string `protobuf:"bytes,3,opt,name=auth,proto3" json:"auth,omitempty"`
+	ServerAddress string `protobuf:"bytes,4,opt,name=server_address,json=serverAddress,proto3" json:"server_address,omitempty"`
+	IdentityToken string `protobuf:"bytes,5,opt,name=identity_token,json=identityToken,proto3" json:"identity_token,omitempty"`
+	RegistryToken string `protobuf:"bytes,6,opt,name=registry_token,json=registryToken,proto3" json:"registry_token,omitempty"`
+}
+
+func (m *AuthConfig) Reset()         { *m = AuthConfig{} }
+func (m *AuthConfig) String() string { return proto.CompactTextString(m) }
+func (*AuthConfig) ProtoMessage()    {}
+
+// PreloadImageRequest is a request of PreloadImage call.
+type PreloadImageRequest struct {
+	Image    string      `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+	Auth     *AuthConfig `protobuf:"bytes,2,opt,name=auth,proto3" json:"auth,omitempty"`
+	Priority int32       `protobuf:"varint,3,opt,name=priority,proto3" json:"priority,omitempty"`
+	Pin      bool        `protobuf:"varint,4,opt,name=pin,proto3" json:"pin,omitempty"`
+	Retry    bool        `protobuf:"varint,5,opt,name=retry,proto3" json:"retry,omitempty"`
+}
+
+func (m *PreloadImageRequest) Reset()         { *m = PreloadImageRequest{} }
+func (m *PreloadImageRequest) String() string { return proto.CompactTextString(m) }
+func (*PreloadImageRequest) ProtoMessage()    {}
+
+// GetImage returns requested image, it is safe to call on nil request.
+func (m *PreloadImageRequest) GetImage() string {
+	if m != nil {
+		return m.Image
+	}
+	return ""
+}
+
+// GetAuth returns request auth config, it is safe to call on nil request.
+func (m *PreloadImageRequest) GetAuth() *AuthConfig {
+	if m != nil {
+		return m.Auth
+	}
+	return nil
+}
+
+// GetPriority returns preload priority, it is safe to call on nil request.
+func (m *PreloadImageRequest) GetPriority() int32 {
+	if m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
+// GetPin returns whether preloaded image is pinned until
+// restart, it is safe to call on nil request.
+func (m *PreloadImageRequest) GetPin() bool {
+	if m != nil {
+		return m.Pin
+	}
+	return false
+}
+
+// GetRetry returns whether failed preload is retried, it is safe to call on nil request.
+func (m *PreloadImageRequest) GetRetry() bool {
+	if m != nil {
+		return m.Retry
+	}
+	return false
+}
+
+// PreloadImageResponse is a response of PreloadImage call.
+type PreloadImageResponse struct {
+	Preload *Preload `protobuf:"bytes,1,opt,name=preload,proto3" json:"preload,omitempty"`
+}
+
+func (m *PreloadImageResponse) Reset()         { *m = PreloadImageResponse{} }
+func (m *PreloadImageResponse) String() string { return proto.CompactTextString(m) }
+func (*PreloadImageResponse) ProtoMessage()    {}
+
+// PreloadStatusRequest is a request of PreloadStatus call.
+type PreloadStatusRequest struct {
+	Image string `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+}
+
+func (m *PreloadStatusRequest) Reset()         { *m = PreloadStatusRequest{} }
+func (m *PreloadStatusRequest) String() string { return proto.CompactTextString(m) }
+func (*PreloadStatusRequest) ProtoMessage()    {}
+
+// GetImage returns requested image, it is safe to call on nil request.
+func (m *PreloadStatusRequest) GetImage() string {
+	if m != nil {
+		return m.Image
+	}
+	return ""
+}
+
+// PreloadStatusResponse is a response of PreloadStatus call.
+type PreloadStatusResponse struct {
+	Preloads []*Preload `protobuf:"bytes,1,rep,name=preloads,proto3" json:"preloads,omitempty"`
+}
+
+func (m *PreloadStatusResponse) Reset()         { *m = PreloadStatusResponse{} }
+func (m *PreloadStatusResponse) String() string { return proto.CompactTextString(m) }
+func (*PreloadStatusResponse) ProtoMessage()    {}
+
+// Preload describes a single image preload.
+type Preload struct {
+	Image       string       `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+	State       PreloadState `protobuf:"varint,2,opt,name=state,proto3,enum=singularity.cri.v1alpha.PreloadState" json:"state,omitempty"`
+	Priority    int32        `protobuf:"varint,3,opt,name=priority,proto3" json:"priority,omitempty"`
+	ImageRef    string       `protobuf:"bytes,4,opt,name=image_ref,json=imageRef,proto3" json:"image_ref,omitempty"`
+	Error       string       `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	QueuedAt    int64        `protobuf:"varint,6,opt,name=queued_at,json=queuedAt,proto3" json:"queued_at,omitempty"`
+	FinishedAt  int64        `protobuf:"varint,7,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
+	PinnedUntil int64        `protobuf:"varint,8,opt,name=pinned_until,json=pinnedUntil,proto3" json:"pinned_until,omitempty"`
+	Pinned      bool         `protobuf:"varint,9,opt,name=pinned,proto3" json:"pinned,omitempty"`
+	Attempts    int32        `protobuf:"varint,10,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	RetryAt     int64        `protobuf:"varint,11,opt,name=retry_at,json=retryAt,proto3" json:"retry_at,omitempty"`
+}
+
+func (m *Preload) Reset()         { *m = Preload{} }
+func (m *Preload) String() string { return proto.CompactTextString(m) }
+func (*Preload) ProtoMessage()    {}
+
+// ExportImageRequest is a request of ExportImage call.
+type ExportImageRequest struct {
+	Image string `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+}
+
+func (m *ExportImageRequest) Reset()         { *m = ExportImageRequest{} }
+func (m *ExportImageRequest) String() string { return proto.CompactTextString(m) }
+func (*ExportImageRequest) ProtoMessage()    {}
+
+// GetImage returns requested image, it is safe to call on nil request.
+func (m *ExportImageRequest) GetImage() string {
+	if m != nil {
+		return m.Image
+	}
+	return ""
+}
+
+// ImageChunk is a part of image archive streamed by ExportImage and ImportImage calls.
+type ImageChunk struct {
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (m *ImageChunk) Reset()         { *m = ImageChunk{} }
+func (m *ImageChunk) String() string { return proto.CompactTextString(m) }
+func (*ImageChunk) ProtoMessage()    {}
+
+// GetData returns chunk data, it is safe to call on nil chunk.
+func (m *ImageChunk) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+// ImportImageResponse is a response of ImportImage call.
+type ImportImageResponse struct {
+	ImageRef string   `protobuf:"bytes,1,opt,name=image_ref,json=imageRef,proto3" json:"image_ref,omitempty"`
+	Tags     []string `protobuf:"bytes,2,rep,name=tags,proto3" json:"tags,omitempty"`
+}
+
+func (m *ImportImageResponse) Reset()         { *m = ImportImageResponse{} }
+func (m *ImportImageResponse) String() string { return proto.CompactTextString(m) }
+func (*ImportImageResponse) ProtoMessage()    {}
+
+// VerifyImagesRequest is a request of VerifyImages call.
+type VerifyImagesRequest struct {
+	Image  string `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+	DryRun bool   `protobuf:"varint,2,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+}
+
+func (m *VerifyImagesRequest) Reset()         { *m = VerifyImagesRequest{} }
+func (m *VerifyImagesRequest) String() string { return proto.CompactTextString(m) }
+func (*VerifyImagesRequest) ProtoMessage()    {}
+
+// GetImage returns requested image, it is safe to call on nil request.
+func (m *VerifyImagesRequest) GetImage() string {
+	if m != nil {
+		return m.Image
+	}
+	return ""
+}
+
+// GetDryRun returns whether dry run is requested, it is safe to call on nil request.
+func (m *VerifyImagesRequest) GetDryRun() bool {
+	if m != nil {
+		return m.DryRun
+	}
+	return false
+}
+
+// VerifyImagesResponse is a response of VerifyImages call.
+type VerifyImagesResponse struct {
+	Images       []*ImageCheck `protobuf:"bytes,1,rep,name=images,proto3" json:"images,omitempty"`
+	CorruptBlobs []*BlobCheck  `protobuf:"bytes,2,rep,name=corrupt_blobs,json=corruptBlobs,proto3" json:"corrupt_blobs,omitempty"`
+}
+
+func (m *VerifyImagesResponse) Reset()         { *m = VerifyImagesResponse{} }
+func (m *VerifyImagesResponse) String() string { return proto.CompactTextString(m) }
+func (*VerifyImagesResponse) ProtoMessage()    {}
+
+// ImageCheck is a result of a single image verification.
+type ImageCheck struct {
+	ImageRef    string   `protobuf:"bytes,1,opt,name=image_ref,json=imageRef,proto3" json:"image_ref,omitempty"`
+	Tags        []string `protobuf:"bytes,2,rep,name=tags,proto3" json:"tags,omitempty"`
+	Corrupt     string   `protobuf:"bytes,3,opt,name=corrupt,proto3" json:"corrupt,omitempty"`
+	Quarantined string   `protobuf:"bytes,4,opt,name=quarantined,proto3" json:"quarantined,omitempty"`
+	Error       string   `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (m *ImageCheck) Reset()         { *m = ImageCheck{} }
+func (m *ImageCheck) String() string { return proto.CompactTextString(m) }
+func (*ImageCheck) ProtoMessage()    {}
+
+// BlobCheck describes cached blob that doesn't match its digest.
+type BlobCheck struct {
+	Digest      string `protobuf:"bytes,1,opt,name=digest,proto3" json:"digest,omitempty"`
+	Corrupt     string `protobuf:"bytes,2,opt,name=corrupt,proto3" json:"corrupt,omitempty"`
+	Quarantined string `protobuf:"bytes,3,opt,name=quarantined,proto3" json:"quarantined,omitempty"`
+	Error       string `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (m *BlobCheck) Reset()         { *m = BlobCheck{} }
+func (m *BlobCheck) String() string { return proto.CompactTextString(m) }
+func (*BlobCheck) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterEnum("singularity.cri.v1alpha.PreloadState", preloadStateName, preloadStateValue)
+	proto.RegisterType((*AuthConfig)(nil), "singularity.cri.v1alpha.AuthConfig")
+	proto.RegisterType((*PreloadImageRequest)(nil), "singularity.cri.v1alpha.PreloadImageRequest")
+	proto.RegisterType((*PreloadImageResponse)(nil), "singularity.cri.v1alpha.PreloadImageResponse")
+	proto.RegisterType((*PreloadStatusRequest)(nil), "singularity.cri.v1alpha.PreloadStatusRequest")
+	proto.RegisterType((*PreloadStatusResponse)(nil), "singularity.cri.v1alpha.PreloadStatusResponse")
+	proto.RegisterType((*Preload)(nil), "singularity.cri.v1alpha.Preload")
+	proto.RegisterType((*ExportImageRequest)(nil), "singularity.cri.v1alpha.ExportImageRequest")
+	proto.RegisterType((*ImageChunk)(nil), "singularity.cri.v1alpha.ImageChunk")
+	proto.RegisterType((*ImportImageResponse)(nil), "singularity.cri.v1alpha.ImportImageResponse")
+	proto.RegisterType((*VerifyImagesRequest)(nil), "singularity.cri.v1alpha.VerifyImagesRequest")
+	proto.RegisterType((*VerifyImagesResponse)(nil), "singularity.cri.v1alpha.VerifyImagesResponse")
+	proto.RegisterType((*ImageCheck)(nil), "singularity.cri.v1alpha.ImageCheck")
+	proto.RegisterType((*BlobCheck)(nil), "singularity.cri.v1alpha.BlobCheck")
+}
+
+// ImageAdminServer is the server API for ImageAdmin service.
+type ImageAdminServer interface {
+	PreloadImage(context.Context, *PreloadImageRequest) (*PreloadImageResponse, error)
+	PreloadStatus(context.Context, *PreloadStatusRequest) (*PreloadStatusResponse, error)
+	ExportImage(*ExportImageRequest, ImageAdmin_ExportImageServer) error
+	ImportImage(ImageAdmin_ImportImageServer) error
+	VerifyImages(context.Context, *VerifyImagesRequest) (*VerifyImagesResponse, error)
+}
+
+// ImageAdmin_ExportImageServer is the server side stream of ExportImage call.
+type ImageAdmin_ExportImageServer interface {
+	Send(*ImageChunk) error
+	grpc.ServerStream
+}
+
+type imageAdminExportImageServer struct {
+	grpc.ServerStream
+}
+
+func (x *imageAdminExportImageServer) Send(m *ImageChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// ImageAdmin_ImportImageServer is the server side stream of ImportImage call.
+type ImageAdmin_ImportImageServer interface {
+	SendAndClose(*ImportImageResponse) error
+	Recv() (*ImageChunk, error)
+	grpc.ServerStream
+}
+
+type imageAdminImportImageServer struct {
+	grpc.ServerStream
+}
+
+func (x *imageAdminImportImageServer) SendAndClose(m *ImportImageResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *imageAdminImportImageServer) Recv() (*ImageChunk, error) {
+	m := new(ImageChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RegisterImageAdminServer registers ImageAdmin service implementation in gRPC server.
+func RegisterImageAdminServer(s *grpc.Server, srv ImageAdminServer) {
+	s.RegisterService(&imageAdminServiceDesc, srv)
+}
+
+var imageAdminServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*ImageAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PreloadImage",
+			Handler:    preloadImageHandler,
+		},
+		{
+			MethodName: "PreloadStatus",
+			Handler:    preloadStatusHandler,
+		},
+		{
+			MethodName: "VerifyImages",
+			Handler:    verifyImagesHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ExportImage",
+			Handler:       exportImageHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ImportImage",
+			Handler:       importImageHandler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "imageadmin.proto",
+}
+
+func preloadImageHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PreloadImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageAdminServer).PreloadImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/PreloadImage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageAdminServer).PreloadImage(ctx, req.(*PreloadImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func preloadStatusHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PreloadStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageAdminServer).PreloadStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/PreloadStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageAdminServer).PreloadStatus(ctx, req.(*PreloadStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func verifyImagesHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyImagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageAdminServer).VerifyImages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/VerifyImages",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageAdminServer).VerifyImages(ctx, req.(*VerifyImagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func exportImageHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(ExportImageRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(ImageAdminServer).ExportImage(in, &imageAdminExportImageServer{stream})
+}
+
+func importImageHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ImageAdminServer).ImportImage(&imageAdminImportImageServer{stream})
+}
+
+// ImageAdminClient is the client API for ImageAdmin service.
+type ImageAdminClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewImageAdminClient returns ImageAdmin client that uses passed connection.
+func NewImageAdminClient(cc *grpc.ClientConn) *ImageAdminClient {
+	return &ImageAdminClient{cc: cc}
+}
+
+// PreloadImage queues an image to be pulled in background.
+func (c *ImageAdminClient) PreloadImage(ctx context.Context, in *PreloadImageRequest, opts ...grpc.CallOption) (*PreloadImageResponse, error) {
+	out := new(PreloadImageResponse)
+	err := c.cc.Invoke(ctx, "/"+ServiceName+"/PreloadImage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PreloadStatus returns status of image preloads.
+func (c *ImageAdminClient) PreloadStatus(ctx context.Context, in *PreloadStatusRequest, opts ...grpc.CallOption) (*PreloadStatusResponse, error) {
+	out := new(PreloadStatusResponse)
+	err := c.cc.Invoke(ctx, "/"+ServiceName+"/PreloadStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// VerifyImages checks stored images and cached blobs.
+func (c *ImageAdminClient) VerifyImages(ctx context.Context, in *VerifyImagesRequest, opts ...grpc.CallOption) (*VerifyImagesResponse, error) {
+	out := new(VerifyImagesResponse)
+	err := c.cc.Invoke(ctx, "/"+ServiceName+"/VerifyImages", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ImageAdmin_ExportImageClient is the client side stream of ExportImage call.
+type ImageAdmin_ExportImageClient interface {
+	Recv() (*ImageChunk, error)
+	grpc.ClientStream
+}
+
+type imageAdminExportImageClient struct {
+	grpc.ClientStream
+}
+
+func (x *imageAdminExportImageClient) Recv() (*ImageChunk, error) {
+	m := new(ImageChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ExportImage streams archive of the stored image.
+func (c *ImageAdminClient) ExportImage(ctx context.Context, in *ExportImageRequest, opts ...grpc.CallOption) (ImageAdmin_ExportImageClient, error) {
+	stream, err := c.cc.NewStream(ctx, &imageAdminServiceDesc.Streams[0], "/"+ServiceName+"/ExportImage", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &imageAdminExportImageClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// ImageAdmin_ImportImageClient is the client side stream of ImportImage call.
+type ImageAdmin_ImportImageClient interface {
+	Send(*ImageChunk) error
+	CloseAndRecv() (*ImportImageResponse, error)
+	grpc.ClientStream
+}
+
+type imageAdminImportImageClient struct {
+	grpc.ClientStream
+}
+
+func (x *imageAdminImportImageClient) Send(m *ImageChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *imageAdminImportImageClient) CloseAndRecv() (*ImportImageResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportImageResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ImportImage opens stream that image archive is sent over.
+func (c *ImageAdminClient) ImportImage(ctx context.Context, opts ...grpc.CallOption) (ImageAdmin_ImportImageClient, error) {
+	stream, err := c.cc.NewStream(ctx, &imageAdminServiceDesc.Streams[1], "/"+ServiceName+"/ImportImage", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &imageAdminImportImageClient{stream}, nil
+}
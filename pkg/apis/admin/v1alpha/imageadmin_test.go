@@ -0,0 +1,116 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshal(t *testing.T) {
+	tt := []struct {
+		name string
+		msg  proto.Message
+		new  func() proto.Message
+	}{
+		{
+			name: "preload request",
+			msg: &PreloadImageRequest{
+				Image:    "docker://busybox",
+				Auth:     &AuthConfig{Username: "user", Password: "pass", ServerAddress: "registry.local"},
+				Priority: 10,
+			},
+			new: func() proto.Message { return new(PreloadImageRequest) },
+		},
+		{
+			name: "status response",
+			msg: &PreloadStatusResponse{
+				Preloads: []*Preload{
+					{Image: "docker.io/busybox:latest", State: PreloadState_PRELOAD_DONE, ImageRef: "abc", PinnedUntil: 42},
+					{Image: "library://alpine", State: PreloadState_PRELOAD_FAILED, Error: "not found"},
+				},
+			},
+			new: func() proto.Message { return new(PreloadStatusResponse) },
+		},
+		{
+			name: "image chunk",
+			msg:  &ImageChunk{Data: []byte("sif")},
+			new:  func() proto.Message { return new(ImageChunk) },
+		},
+		{
+			name: "import response",
+			msg:  &ImportImageResponse{ImageRef: "abc", Tags: []string{"busybox:latest"}},
+			new:  func() proto.Message { return new(ImportImageResponse) },
+		},
+		{
+			name: "verify images response",
+			msg: &VerifyImagesResponse{
+				Images: []*ImageCheck{
+					{ImageRef: "abc", Tags: []string{"busybox:latest"}},
+					{ImageRef: "def", Corrupt: "partial checksum mismatch", Quarantined: "42-def"},
+				},
+				CorruptBlobs: []*BlobCheck{{Digest: "sha256:abc", Corrupt: "got sha256:def", Error: "permission denied"}},
+			},
+			new: func() proto.Message { return new(VerifyImagesResponse) },
+		},
+		{
+			name: "list containers page request",
+			msg: &ListContainersPageRequest{
+				PageSize:      10,
+				PageToken:     "token",
+				LabelSelector: map[string]string{"app": "nginx"},
+			},
+			new: func() proto.Message { return new(ListContainersPageRequest) },
+		},
+		{
+			name: "list containers page response",
+			msg: &ListContainersPageResponse{
+				Containers: []*ContainerSummary{
+					{
+						Id:          "abc",
+						Name:        "nginx",
+						Attempt:     2,
+						State:       1,
+						CreatedAt:   42,
+						Labels:      map[string]string{"app": "nginx"},
+						Annotations: map[string]string{"foo": "bar"},
+					},
+				},
+				NextPageToken: "next",
+			},
+			new: func() proto.Message { return new(ListContainersPageResponse) },
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := proto.Marshal(tc.msg)
+			require.NoError(t, err)
+			actual := tc.new()
+			require.NoError(t, proto.Unmarshal(data, actual))
+			require.Equal(t, tc.msg, actual)
+		})
+	}
+}
+
+func TestWireFormat(t *testing.T) {
+	// field numbers must match imageadmin.proto
+	data, err := proto.Marshal(&Preload{Image: "a", State: PreloadState_PRELOAD_PULLING, QueuedAt: 1})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x0a, 0x01, 'a', 0x10, 0x01, 0x30, 0x01}, data)
+	require.Equal(t, "PRELOAD_PULLING", PreloadState_PRELOAD_PULLING.String())
+}
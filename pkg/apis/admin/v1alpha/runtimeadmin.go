@@ -0,0 +1,624 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// RuntimeServiceName is a full name of RuntimeAdmin gRPC service.
+const RuntimeServiceName = "singularity.cri.v1alpha.RuntimeAdmin"
+
+// ListContainersPageRequest is a request of ListContainersPage call.
+type ListContainersPageRequest struct {
+	PageSize      int32             `protobuf:"varint,1,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken     string            `protobuf:"bytes,2,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	PodSandboxId  string            `protobuf:"bytes,3,opt,name=pod_sandbox_id,json=podSandboxId,proto3" json:"pod_sandbox_id,omitempty"`
+	LabelSelector map[string]string `protobuf:"bytes,4,rep,name=label_selector,json=labelSelector,proto3" json:"label_selector,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *ListContainersPageRequest) Reset()         { *m = ListContainersPageRequest{} }
+func (m *ListContainersPageRequest) String() string { return proto.CompactTextString(m) }
+func (*ListContainersPageRequest) ProtoMessage()    {}
+
+// GetPageSize returns requested page size, it is safe to call on nil request.
+func (m *ListContainersPageRequest) GetPageSize() int32 {
+	if m != nil {
+		return m.PageSize
+	}
+	return 0
+}
+
+// GetPageToken returns requested page token, it is safe to call on nil request.
+func (m *ListContainersPageRequest) GetPageToken() string {
+	if m != nil {
+		return m.PageToken
+	}
+	return ""
+}
+
+// GetPodSandboxId returns pod filter, it is safe to call on nil request.
+func (m *ListContainersPageRequest) GetPodSandboxId() string {
+	if m != nil {
+		return m.PodSandboxId
+	}
+	return ""
+}
+
+// GetLabelSelector returns label filter, it is safe to call on nil request.
+func (m *ListContainersPageRequest) GetLabelSelector() map[string]string {
+	if m != nil {
+		return m.LabelSelector
+	}
+	return nil
+}
+
+// ListContainersPageResponse is a response of ListContainersPage call.
+type ListContainersPageResponse struct {
+	Containers    []*ContainerSummary `protobuf:"bytes,1,rep,name=containers,proto3" json:"containers,omitempty"`
+	NextPageToken string              `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+}
+
+func (m *ListContainersPageResponse) Reset()         { *m = ListContainersPageResponse{} }
+func (m *ListContainersPageResponse) String() string { return proto.CompactTextString(m) }
+func (*ListContainersPageResponse) ProtoMessage()    {}
+
+// ContainerSummary mirrors CRI Container message.
+type ContainerSummary struct {
+	Id           string            `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	PodSandboxId string            `protobuf:"bytes,2,opt,name=pod_sandbox_id,json=podSandboxId,proto3" json:"pod_sandbox_id,omitempty"`
+	Name         string            `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	Attempt      uint32            `protobuf:"varint,4,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	Image        string            `protobuf:"bytes,5,opt,name=image,proto3" json:"image,omitempty"`
+	ImageRef     string            `protobuf:"bytes,6,opt,name=image_ref,json=imageRef,proto3" json:"image_ref,omitempty"`
+	State        int32             `protobuf:"varint,7,opt,name=state,proto3" json:"state,omitempty"`
+	CreatedAt    int64             `protobuf:"varint,8,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Labels       map[string]string `protobuf:"bytes,9,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Annotations  map[string]string `protobuf:"bytes,10,rep,name=annotations,proto3" json:"annotations,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *ContainerSummary) Reset()         { *m = ContainerSummary{} }
+func (m *ContainerSummary) String() string { return proto.CompactTextString(m) }
+func (*ContainerSummary) ProtoMessage()    {}
+
+// ReconcileIPAMRequest is a request of ReconcileIPAM call.
+type ReconcileIPAMRequest struct {
+	DryRun bool `protobuf:"varint,1,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+}
+
+func (m *ReconcileIPAMRequest) Reset()         { *m = ReconcileIPAMRequest{} }
+func (m *ReconcileIPAMRequest) String() string { return proto.CompactTextString(m) }
+func (*ReconcileIPAMRequest) ProtoMessage()    {}
+
+// GetDryRun returns whether addresses are only reported, it is safe to call on nil request.
+func (m *ReconcileIPAMRequest) GetDryRun() bool {
+	if m != nil {
+		return m.DryRun
+	}
+	return false
+}
+
+// ReconcileIPAMResponse is a response of ReconcileIPAM call.
+type ReconcileIPAMResponse struct {
+	Allocations []*IPAMAllocation `protobuf:"bytes,1,rep,name=allocations,proto3" json:"allocations,omitempty"`
+}
+
+func (m *ReconcileIPAMResponse) Reset()         { *m = ReconcileIPAMResponse{} }
+func (m *ReconcileIPAMResponse) String() string { return proto.CompactTextString(m) }
+func (*ReconcileIPAMResponse) ProtoMessage()    {}
+
+// IPAMAllocation is an address reserved by IPAM plugin for a pod.
+type IPAMAllocation struct {
+	Network     string `protobuf:"bytes,1,opt,name=network,proto3" json:"network,omitempty"`
+	Ip          string `protobuf:"bytes,2,opt,name=ip,proto3" json:"ip,omitempty"`
+	ContainerId string `protobuf:"bytes,3,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	IfName      string `protobuf:"bytes,4,opt,name=if_name,json=ifName,proto3" json:"if_name,omitempty"`
+}
+
+func (m *IPAMAllocation) Reset()         { *m = IPAMAllocation{} }
+func (m *IPAMAllocation) String() string { return proto.CompactTextString(m) }
+func (*IPAMAllocation) ProtoMessage()    {}
+
+// PrepareNetNsExecRequest is a request of PrepareNetNsExec call.
+type PrepareNetNsExecRequest struct {
+	Pod     string   `protobuf:"bytes,1,opt,name=pod,proto3" json:"pod,omitempty"`
+	Command []string `protobuf:"bytes,2,rep,name=command,proto3" json:"command,omitempty"`
+}
+
+func (m *PrepareNetNsExecRequest) Reset()         { *m = PrepareNetNsExecRequest{} }
+func (m *PrepareNetNsExecRequest) String() string { return proto.CompactTextString(m) }
+func (*PrepareNetNsExecRequest) ProtoMessage()    {}
+
+// GetPod returns pod reference, it is safe to call on nil request.
+func (m *PrepareNetNsExecRequest) GetPod() string {
+	if m != nil {
+		return m.Pod
+	}
+	return ""
+}
+
+// GetCommand returns command to run, it is safe to call on nil request.
+func (m *PrepareNetNsExecRequest) GetCommand() []string {
+	if m != nil {
+		return m.Command
+	}
+	return nil
+}
+
+// PrepareNetNsExecResponse is a response of PrepareNetNsExec call.
+type PrepareNetNsExecResponse struct {
+	PodSandboxId string   `protobuf:"bytes,1,opt,name=pod_sandbox_id,json=podSandboxId,proto3" json:"pod_sandbox_id,omitempty"`
+	NetNsPath    string   `protobuf:"bytes,2,opt,name=net_ns_path,json=netNsPath,proto3" json:"net_ns_path,omitempty"`
+	Args         []string `protobuf:"bytes,3,rep,name=args,proto3" json:"args,omitempty"`
+}
+
+func (m *PrepareNetNsExecResponse) Reset()         { *m = PrepareNetNsExecResponse{} }
+func (m *PrepareNetNsExecResponse) String() string { return proto.CompactTextString(m) }
+func (*PrepareNetNsExecResponse) ProtoMessage()    {}
+
+// SetRuntimeConfigRequest is a request of SetRuntimeConfig call.
+type SetRuntimeConfigRequest struct {
+	Settings map[string]string `protobuf:"bytes,1,rep,name=settings,proto3" json:"settings,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Persist  bool              `protobuf:"varint,2,opt,name=persist,proto3" json:"persist,omitempty"`
+}
+
+func (m *SetRuntimeConfigRequest) Reset()         { *m = SetRuntimeConfigRequest{} }
+func (m *SetRuntimeConfigRequest) String() string { return proto.CompactTextString(m) }
+func (*SetRuntimeConfigRequest) ProtoMessage()    {}
+
+// GetSettings returns settings to change, it is safe to call on nil request.
+func (m *SetRuntimeConfigRequest) GetSettings() map[string]string {
+	if m != nil {
+		return m.Settings
+	}
+	return nil
+}
+
+// GetPersist returns whether settings are written to config file, it is safe to call on nil request.
+func (m *SetRuntimeConfigRequest) GetPersist() bool {
+	if m != nil {
+		return m.Persist
+	}
+	return false
+}
+
+// SetRuntimeConfigResponse is a response of SetRuntimeConfig call.
+type SetRuntimeConfigResponse struct {
+	Settings map[string]string `protobuf:"bytes,1,rep,name=settings,proto3" json:"settings,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *SetRuntimeConfigResponse) Reset()         { *m = SetRuntimeConfigResponse{} }
+func (m *SetRuntimeConfigResponse) String() string { return proto.CompactTextString(m) }
+func (*SetRuntimeConfigResponse) ProtoMessage()    {}
+
+// GetRuntimeConfigRequest is a request of GetRuntimeConfig call.
+type GetRuntimeConfigRequest struct{}
+
+func (m *GetRuntimeConfigRequest) Reset()         { *m = GetRuntimeConfigRequest{} }
+func (m *GetRuntimeConfigRequest) String() string { return proto.CompactTextString(m) }
+func (*GetRuntimeConfigRequest) ProtoMessage()    {}
+
+// GetRuntimeConfigResponse is a response of GetRuntimeConfig call.
+type GetRuntimeConfigResponse struct {
+	Settings map[string]string `protobuf:"bytes,1,rep,name=settings,proto3" json:"settings,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *GetRuntimeConfigResponse) Reset()         { *m = GetRuntimeConfigResponse{} }
+func (m *GetRuntimeConfigResponse) String() string { return proto.CompactTextString(m) }
+func (*GetRuntimeConfigResponse) ProtoMessage()    {}
+
+// GetEventsRequest is a request of GetContainerEvents call.
+type GetEventsRequest struct{}
+
+func (m *GetEventsRequest) Reset()         { *m = GetEventsRequest{} }
+func (m *GetEventsRequest) String() string { return proto.CompactTextString(m) }
+func (*GetEventsRequest) ProtoMessage()    {}
+
+// ContainerEventType is a type of container lifecycle event.
+type ContainerEventType int32
+
+// Possible container event types.
+const (
+	ContainerEventType_CONTAINER_CREATED_EVENT ContainerEventType = 0
+	ContainerEventType_CONTAINER_STARTED_EVENT ContainerEventType = 1
+	ContainerEventType_CONTAINER_STOPPED_EVENT ContainerEventType = 2
+	ContainerEventType_CONTAINER_DELETED_EVENT ContainerEventType = 3
+)
+
+var containerEventTypeName = map[int32]string{
+	0: "CONTAINER_CREATED_EVENT",
+	1: "CONTAINER_STARTED_EVENT",
+	2: "CONTAINER_STOPPED_EVENT",
+	3: "CONTAINER_DELETED_EVENT",
+}
+
+var containerEventTypeValue = map[string]int32{
+	"CONTAINER_CREATED_EVENT": 0,
+	"CONTAINER_STARTED_EVENT": 1,
+	"CONTAINER_STOPPED_EVENT": 2,
+	"CONTAINER_DELETED_EVENT": 3,
+}
+
+func (t ContainerEventType) String() string {
+	return proto.EnumName(containerEventTypeName, int32(t))
+}
+
+// ContainerEventResponse is a single event streamed by GetContainerEvents call.
+type ContainerEventResponse struct {
+	ContainerId        string                 `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	ContainerEventType ContainerEventType     `protobuf:"varint,2,opt,name=container_event_type,json=containerEventType,proto3,enum=singularity.cri.v1alpha.ContainerEventType" json:"container_event_type,omitempty"`
+	CreatedAt          int64                  `protobuf:"varint,3,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	PodSandboxStatus   *k8s.PodSandboxStatus  `protobuf:"bytes,4,opt,name=pod_sandbox_status,json=podSandboxStatus,proto3" json:"pod_sandbox_status,omitempty"`
+	ContainersStatuses []*k8s.ContainerStatus `protobuf:"bytes,5,rep,name=containers_statuses,json=containersStatuses,proto3" json:"containers_statuses,omitempty"`
+	Overflow           bool                   `protobuf:"varint,6,opt,name=overflow,proto3" json:"overflow,omitempty"`
+}
+
+func (m *ContainerEventResponse) Reset()         { *m = ContainerEventResponse{} }
+func (m *ContainerEventResponse) String() string { return proto.CompactTextString(m) }
+func (*ContainerEventResponse) ProtoMessage()    {}
+
+// DumpStateRequest is a request of DumpState call.
+type DumpStateRequest struct {
+	Sections []string `protobuf:"bytes,1,rep,name=sections,proto3" json:"sections,omitempty"`
+	Id       string   `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (m *DumpStateRequest) Reset()         { *m = DumpStateRequest{} }
+func (m *DumpStateRequest) String() string { return proto.CompactTextString(m) }
+func (*DumpStateRequest) ProtoMessage()    {}
+
+// GetSections returns sections to dump, it is safe to call on nil request.
+func (m *DumpStateRequest) GetSections() []string {
+	if m != nil {
+		return m.Sections
+	}
+	return nil
+}
+
+// GetId returns pod and container ID prefix, it is safe to call on nil request.
+func (m *DumpStateRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+// DumpStateResponse is a response of DumpState call.
+type DumpStateResponse struct {
+	State []byte `protobuf:"bytes,1,opt,name=state,proto3" json:"state,omitempty"`
+}
+
+func (m *DumpStateResponse) Reset()         { *m = DumpStateResponse{} }
+func (m *DumpStateResponse) String() string { return proto.CompactTextString(m) }
+func (*DumpStateResponse) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterType((*ListContainersPageRequest)(nil), "singularity.cri.v1alpha.ListContainersPageRequest")
+	proto.RegisterMapType((map[string]string)(nil), "singularity.cri.v1alpha.ListContainersPageRequest.LabelSelectorEntry")
+	proto.RegisterType((*ListContainersPageResponse)(nil), "singularity.cri.v1alpha.ListContainersPageResponse")
+	proto.RegisterType((*ContainerSummary)(nil), "singularity.cri.v1alpha.ContainerSummary")
+	proto.RegisterMapType((map[string]string)(nil), "singularity.cri.v1alpha.ContainerSummary.LabelsEntry")
+	proto.RegisterMapType((map[string]string)(nil), "singularity.cri.v1alpha.ContainerSummary.AnnotationsEntry")
+	proto.RegisterType((*ReconcileIPAMRequest)(nil), "singularity.cri.v1alpha.ReconcileIPAMRequest")
+	proto.RegisterType((*ReconcileIPAMResponse)(nil), "singularity.cri.v1alpha.ReconcileIPAMResponse")
+	proto.RegisterType((*IPAMAllocation)(nil), "singularity.cri.v1alpha.IPAMAllocation")
+	proto.RegisterType((*PrepareNetNsExecRequest)(nil), "singularity.cri.v1alpha.PrepareNetNsExecRequest")
+	proto.RegisterType((*PrepareNetNsExecResponse)(nil), "singularity.cri.v1alpha.PrepareNetNsExecResponse")
+	proto.RegisterType((*SetRuntimeConfigRequest)(nil), "singularity.cri.v1alpha.SetRuntimeConfigRequest")
+	proto.RegisterMapType((map[string]string)(nil), "singularity.cri.v1alpha.SetRuntimeConfigRequest.SettingsEntry")
+	proto.RegisterType((*SetRuntimeConfigResponse)(nil), "singularity.cri.v1alpha.SetRuntimeConfigResponse")
+	proto.RegisterMapType((map[string]string)(nil), "singularity.cri.v1alpha.SetRuntimeConfigResponse.SettingsEntry")
+	proto.RegisterType((*GetRuntimeConfigRequest)(nil), "singularity.cri.v1alpha.GetRuntimeConfigRequest")
+	proto.RegisterType((*GetRuntimeConfigResponse)(nil), "singularity.cri.v1alpha.GetRuntimeConfigResponse")
+	proto.RegisterMapType((map[string]string)(nil), "singularity.cri.v1alpha.GetRuntimeConfigResponse.SettingsEntry")
+	proto.RegisterEnum("singularity.cri.v1alpha.ContainerEventType", containerEventTypeName, containerEventTypeValue)
+	proto.RegisterType((*GetEventsRequest)(nil), "singularity.cri.v1alpha.GetEventsRequest")
+	proto.RegisterType((*ContainerEventResponse)(nil), "singularity.cri.v1alpha.ContainerEventResponse")
+	proto.RegisterType((*DumpStateRequest)(nil), "singularity.cri.v1alpha.DumpStateRequest")
+	proto.RegisterType((*DumpStateResponse)(nil), "singularity.cri.v1alpha.DumpStateResponse")
+}
+
+// RuntimeAdminServer is the server API for RuntimeAdmin service.
+type RuntimeAdminServer interface {
+	ListContainersPage(context.Context, *ListContainersPageRequest) (*ListContainersPageResponse, error)
+	ReconcileIPAM(context.Context, *ReconcileIPAMRequest) (*ReconcileIPAMResponse, error)
+	PrepareNetNsExec(context.Context, *PrepareNetNsExecRequest) (*PrepareNetNsExecResponse, error)
+	SetRuntimeConfig(context.Context, *SetRuntimeConfigRequest) (*SetRuntimeConfigResponse, error)
+	GetRuntimeConfig(context.Context, *GetRuntimeConfigRequest) (*GetRuntimeConfigResponse, error)
+	GetContainerEvents(*GetEventsRequest, RuntimeAdmin_GetContainerEventsServer) error
+	DumpState(context.Context, *DumpStateRequest) (*DumpStateResponse, error)
+}
+
+// RuntimeAdmin_GetContainerEventsServer is the server side stream of GetContainerEvents call.
+type RuntimeAdmin_GetContainerEventsServer interface {
+	Send(*ContainerEventResponse) error
+	grpc.ServerStream
+}
+
+type runtimeAdminGetContainerEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *runtimeAdminGetContainerEventsServer) Send(m *ContainerEventResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// RegisterRuntimeAdminServer registers RuntimeAdmin service implementation in gRPC server.
+func RegisterRuntimeAdminServer(s *grpc.Server, srv RuntimeAdminServer) {
+	s.RegisterService(&runtimeAdminServiceDesc, srv)
+}
+
+var runtimeAdminServiceDesc = grpc.ServiceDesc{
+	ServiceName: RuntimeServiceName,
+	HandlerType: (*RuntimeAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListContainersPage",
+			Handler:    listContainersPageHandler,
+		},
+		{
+			MethodName: "ReconcileIPAM",
+			Handler:    reconcileIPAMHandler,
+		},
+		{
+			MethodName: "PrepareNetNsExec",
+			Handler:    prepareNetNsExecHandler,
+		},
+		{
+			MethodName: "SetRuntimeConfig",
+			Handler:    setRuntimeConfigHandler,
+		},
+		{
+			MethodName: "GetRuntimeConfig",
+			Handler:    getRuntimeConfigHandler,
+		},
+		{
+			MethodName: "DumpState",
+			Handler:    dumpStateHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetContainerEvents",
+			Handler:       getContainerEventsHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "runtimeadmin.proto",
+}
+
+func listContainersPageHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListContainersPageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuntimeAdminServer).ListContainersPage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + RuntimeServiceName + "/ListContainersPage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuntimeAdminServer).ListContainersPage(ctx, req.(*ListContainersPageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func reconcileIPAMHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReconcileIPAMRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuntimeAdminServer).ReconcileIPAM(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + RuntimeServiceName + "/ReconcileIPAM",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuntimeAdminServer).ReconcileIPAM(ctx, req.(*ReconcileIPAMRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func prepareNetNsExecHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrepareNetNsExecRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuntimeAdminServer).PrepareNetNsExec(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + RuntimeServiceName + "/PrepareNetNsExec",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuntimeAdminServer).PrepareNetNsExec(ctx, req.(*PrepareNetNsExecRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func setRuntimeConfigHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRuntimeConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuntimeAdminServer).SetRuntimeConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + RuntimeServiceName + "/SetRuntimeConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuntimeAdminServer).SetRuntimeConfig(ctx, req.(*SetRuntimeConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func getRuntimeConfigHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRuntimeConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuntimeAdminServer).GetRuntimeConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + RuntimeServiceName + "/GetRuntimeConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuntimeAdminServer).GetRuntimeConfig(ctx, req.(*GetRuntimeConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func dumpStateHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DumpStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuntimeAdminServer).DumpState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + RuntimeServiceName + "/DumpState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuntimeAdminServer).DumpState(ctx, req.(*DumpStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func getContainerEventsHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(GetEventsRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(RuntimeAdminServer).GetContainerEvents(in, &runtimeAdminGetContainerEventsServer{stream})
+}
+
+// RuntimeAdminClient is the client API for RuntimeAdmin service.
+type RuntimeAdminClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewRuntimeAdminClient returns RuntimeAdmin client that uses passed connection.
+func NewRuntimeAdminClient(cc *grpc.ClientConn) *RuntimeAdminClient {
+	return &RuntimeAdminClient{cc: cc}
+}
+
+// ListContainersPage returns a single page of containers.
+func (c *RuntimeAdminClient) ListContainersPage(ctx context.Context, in *ListContainersPageRequest, opts ...grpc.CallOption) (*ListContainersPageResponse, error) {
+	out := new(ListContainersPageResponse)
+	err := c.cc.Invoke(ctx, "/"+RuntimeServiceName+"/ListContainersPage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReconcileIPAM releases addresses leaked by pods that no longer exist.
+func (c *RuntimeAdminClient) ReconcileIPAM(ctx context.Context, in *ReconcileIPAMRequest, opts ...grpc.CallOption) (*ReconcileIPAMResponse, error) {
+	out := new(ReconcileIPAMResponse)
+	err := c.cc.Invoke(ctx, "/"+RuntimeServiceName+"/ReconcileIPAM", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PrepareNetNsExec returns command line that runs command in pod network namespace.
+func (c *RuntimeAdminClient) PrepareNetNsExec(ctx context.Context, in *PrepareNetNsExecRequest, opts ...grpc.CallOption) (*PrepareNetNsExecResponse, error) {
+	out := new(PrepareNetNsExecResponse)
+	err := c.cc.Invoke(ctx, "/"+RuntimeServiceName+"/PrepareNetNsExec", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SetRuntimeConfig changes hot-reloadable settings of the running runtime.
+func (c *RuntimeAdminClient) SetRuntimeConfig(ctx context.Context, in *SetRuntimeConfigRequest, opts ...grpc.CallOption) (*SetRuntimeConfigResponse, error) {
+	out := new(SetRuntimeConfigResponse)
+	err := c.cc.Invoke(ctx, "/"+RuntimeServiceName+"/SetRuntimeConfig", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetRuntimeConfig returns current values of hot-reloadable settings.
+func (c *RuntimeAdminClient) GetRuntimeConfig(ctx context.Context, in *GetRuntimeConfigRequest, opts ...grpc.CallOption) (*GetRuntimeConfigResponse, error) {
+	out := new(GetRuntimeConfigResponse)
+	err := c.cc.Invoke(ctx, "/"+RuntimeServiceName+"/GetRuntimeConfig", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DumpState returns daemon internal state as JSON.
+func (c *RuntimeAdminClient) DumpState(ctx context.Context, in *DumpStateRequest, opts ...grpc.CallOption) (*DumpStateResponse, error) {
+	out := new(DumpStateResponse)
+	err := c.cc.Invoke(ctx, "/"+RuntimeServiceName+"/DumpState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RuntimeAdmin_GetContainerEventsClient is the client side stream of GetContainerEvents call.
+type RuntimeAdmin_GetContainerEventsClient interface {
+	Recv() (*ContainerEventResponse, error)
+	grpc.ClientStream
+}
+
+type runtimeAdminGetContainerEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *runtimeAdminGetContainerEventsClient) Recv() (*ContainerEventResponse, error) {
+	m := new(ContainerEventResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetContainerEvents streams container lifecycle events.
+func (c *RuntimeAdminClient) GetContainerEvents(ctx context.Context, in *GetEventsRequest, opts ...grpc.CallOption) (RuntimeAdmin_GetContainerEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &runtimeAdminServiceDesc.Streams[0], "/"+RuntimeServiceName+"/GetContainerEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &runtimeAdminGetContainerEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
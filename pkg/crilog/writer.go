@@ -0,0 +1,163 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package crilog writes container output in Kubernetes CRI log format, i.e.
+// lines of <RFC3339Nano timestamp> <stream> <tag> <message>, where tag is F
+// for entries that end a line and P for partial ones, see
+// https://github.com/kubernetes/community/blob/master/contributors/design-proposals/node/kubelet-cri-logging.md.
+package crilog
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxLine is a length of the longest message written in a single entry.
+	DefaultMaxLine = 16 << 10
+
+	// Stdout is a stream name of container standard output.
+	Stdout = "stdout"
+	// Stderr is a stream name of container standard error.
+	Stderr = "stderr"
+
+	tagFull    = "F"
+	tagPartial = "P"
+)
+
+// Writer formats output of a single stream into CRI log entries. Each line
+// goes into an entry tagged F, lines longer than max line are split into
+// entries tagged P followed by the final F one. Incomplete line is buffered
+// until its rest is written, it grows long enough to be split or Flush is
+// called, so that output written in small chunks is not split needlessly.
+// Entry timestamp is the time of the first byte of its message.
+type Writer struct {
+	mu      sync.Locker
+	w       io.Writer
+	stream  string
+	maxLine int
+	now     func() time.Time
+
+	buf   []byte
+	stamp time.Time
+	entry []byte
+}
+
+// Option configures Writer.
+type Option func(w *Writer)
+
+// WithMaxLine sets length of the longest message written in a single
+// entry. Overrides DefaultMaxLine.
+func WithMaxLine(n int) Option {
+	return func(w *Writer) {
+		if n > 0 {
+			w.maxLine = n
+		}
+	}
+}
+
+// WithLock sets lock held while entries are written, so that writers of
+// different streams may share the same destination without mixing entries.
+func WithLock(mu sync.Locker) Option {
+	return func(w *Writer) {
+		w.mu = mu
+	}
+}
+
+// NewWriter returns writer of stream entries into w.
+func NewWriter(w io.Writer, stream string, opts ...Option) *Writer {
+	writer := &Writer{
+		mu:      new(sync.Mutex),
+		w:       w,
+		stream:  stream,
+		maxLine: DefaultMaxLine,
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(writer)
+	}
+	return writer
+}
+
+// Write writes entries of complete lines in p and buffers the rest.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(p)
+	for len(p) != 0 {
+		if len(w.buf) == 0 {
+			w.stamp = w.now()
+		}
+		room := w.maxLine - len(w.buf)
+		i := bytes.IndexByte(p, '\n')
+		var err error
+		switch {
+		case i >= 0 && i <= room:
+			w.buf = append(w.buf, p[:i]...)
+			p = p[i+1:]
+			err = w.emit(tagFull)
+		case len(p) <= room:
+			w.buf = append(w.buf, p...)
+			p = nil
+		default:
+			w.buf = append(w.buf, p[:room]...)
+			p = p[room:]
+			err = w.emit(tagPartial)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Flush writes buffered incomplete line as a partial entry,
+// e.g. when stream has been idle for a while.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	return w.emit(tagPartial)
+}
+
+// Close writes buffered incomplete line as the final entry of the line,
+// as no more output follows. Underlying writer is not closed.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	return w.emit(tagFull)
+}
+
+// emit writes buffered message as a single entry with the passed tag.
+func (w *Writer) emit(tag string) error {
+	w.entry = w.stamp.UTC().AppendFormat(w.entry[:0], time.RFC3339Nano)
+	w.entry = append(w.entry, ' ')
+	w.entry = append(w.entry, w.stream...)
+	w.entry = append(w.entry, ' ')
+	w.entry = append(w.entry, tag...)
+	w.entry = append(w.entry, ' ')
+	w.entry = append(w.entry, w.buf...)
+	w.entry = append(w.entry, '\n')
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(w.entry)
+	return err
+}
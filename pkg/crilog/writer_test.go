@@ -0,0 +1,195 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crilog
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	tt := []struct {
+		name    string
+		maxLine int
+		writes  []string
+		flush   bool
+		expect  []string
+	}{
+		{
+			name:   "full lines",
+			writes: []string{"one\ntwo\n"},
+			expect: []string{"stdout F one", "stdout F two"},
+		},
+		{
+			name:   "line written in chunks",
+			writes: []string{"par", "tial\n"},
+			expect: []string{"stdout F partial"},
+		},
+		{
+			name:   "empty line",
+			writes: []string{"\n"},
+			expect: []string{"stdout F "},
+		},
+		{
+			name:    "long line",
+			maxLine: 4,
+			writes:  []string{"abcdefghij\n"},
+			expect:  []string{"stdout P abcd", "stdout P efgh", "stdout F ij"},
+		},
+		{
+			name:    "line of max length",
+			maxLine: 4,
+			writes:  []string{"ab", "cd\n"},
+			expect:  []string{"stdout F abcd"},
+		},
+		{
+			name:   "flushed line",
+			writes: []string{"prompt$ "},
+			flush:  true,
+			expect: []string{"stdout P prompt$ "},
+		},
+		{
+			name:   "incomplete line on close",
+			writes: []string{"one\ntw", "o"},
+			expect: []string{"stdout F one", "stdout F two"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, Stdout, WithMaxLine(tc.maxLine))
+			for _, p := range tc.writes {
+				n, err := w.Write([]byte(p))
+				require.NoError(t, err)
+				require.Equal(t, len(p), n)
+			}
+			if tc.flush {
+				require.NoError(t, w.Flush())
+			} else {
+				require.NoError(t, w.Close())
+			}
+			var actual []string
+			for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+				actual = append(actual, strings.SplitN(line, " ", 2)[1])
+			}
+			require.Equal(t, tc.expect, actual)
+		})
+	}
+}
+
+func TestWriter_Timestamp(t *testing.T) {
+	start := time.Date(2019, 5, 15, 10, 20, 30, 1, time.UTC)
+	now := start
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Stderr)
+	w.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	// entry is stamped with the time of its first byte
+	_, err := w.Write([]byte("a"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("b\nc\n"))
+	require.NoError(t, err)
+	require.Equal(t, "2019-05-15T10:20:31.000000001Z stderr F ab\n"+
+		"2019-05-15T10:20:32.000000001Z stderr F c\n", buf.String())
+}
+
+func TestWriter_SharedLock(t *testing.T) {
+	var buf bytes.Buffer
+	mu := new(sync.Mutex)
+	stdout := NewWriter(&buf, Stdout, WithLock(mu))
+	stderr := NewWriter(&buf, Stderr, WithLock(mu))
+
+	var wg sync.WaitGroup
+	for _, w := range []*Writer{stdout, stderr} {
+		wg.Add(1)
+		go func(w *Writer) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				w.Write([]byte("line\n"))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 200)
+	for _, line := range lines {
+		parts := strings.SplitN(line, " ", 4)
+		require.Len(t, parts, 4, line)
+		require.Equal(t, "line", parts[3], line)
+	}
+}
+
+// TestWriter_Split feeds random output in random chunks and checks that
+// entries are well formed, fit max line and restore the original output.
+func TestWriter_Split(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	alphabet := []byte("abc \n\n")
+	for i := 0; i < 1000; i++ {
+		maxLine := 1 + rnd.Intn(16)
+		output := make([]byte, rnd.Intn(200))
+		for j := range output {
+			output[j] = alphabet[rnd.Intn(len(alphabet))]
+		}
+
+		var buf bytes.Buffer
+		w := NewWriter(&buf, Stdout, WithMaxLine(maxLine))
+		for rest := output; len(rest) != 0; {
+			n := 1 + rnd.Intn(len(rest))
+			written, err := w.Write(rest[:n])
+			require.NoError(t, err)
+			require.Equal(t, n, written)
+			rest = rest[n:]
+		}
+		require.NoError(t, w.Close())
+
+		var restored []byte
+		for _, line := range strings.SplitAfter(buf.String(), "\n") {
+			if line == "" {
+				continue
+			}
+			parts := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 4)
+			require.Len(t, parts, 4, "entry %q of %q", line, output)
+			_, err := time.Parse(time.RFC3339Nano, parts[0])
+			require.NoError(t, err)
+			require.Equal(t, Stdout, parts[1])
+			require.True(t, len(parts[3]) <= maxLine, "entry %q is longer than %d", line, maxLine)
+			require.NotContains(t, parts[3], "\n")
+			restored = append(restored, parts[3]...)
+			switch parts[2] {
+			case tagFull:
+				restored = append(restored, '\n')
+			case tagPartial:
+			default:
+				t.Fatalf("unexpected tag in %q", line)
+			}
+		}
+		// incomplete last line is ended on close
+		if len(output) != 0 && output[len(output)-1] != '\n' {
+			output = append(output, '\n')
+		}
+		require.Equal(t, string(output), string(restored), "max line %d", maxLine)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package critest
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Fault is a failure injected into calls of a single CRI method.
+type Fault struct {
+	// Err is returned instead of serving the call. When Err is nil
+	// calls are only delayed.
+	Err error
+	// Delay is time each call is held for before it is served or failed.
+	Delay time.Duration
+	// Count is the number of calls affected, zero means all of them.
+	Count int
+}
+
+type faults struct {
+	mu     sync.Mutex
+	faults map[string]*Fault
+}
+
+func newFaults() *faults {
+	return &faults{faults: make(map[string]*Fault)}
+}
+
+// InjectFault makes calls to the CRI method, e.g. CreateContainer or
+// PullImage, fail or delayed until fault is used up or cleared. New
+// fault replaces the one injected into the method before.
+func (s *Server) InjectFault(method string, f Fault) {
+	s.faults.mu.Lock()
+	defer s.faults.mu.Unlock()
+	s.faults.faults[method] = &f
+}
+
+// ClearFaults removes all injected faults.
+func (s *Server) ClearFaults() {
+	s.faults.mu.Lock()
+	defer s.faults.mu.Unlock()
+	s.faults.faults = make(map[string]*Fault)
+}
+
+// take returns fault for the method call.
+func (f *faults) take(method string) (Fault, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fault, ok := f.faults[method]
+	if !ok {
+		return Fault{}, false
+	}
+	if fault.Count > 0 {
+		fault.Count--
+		if fault.Count == 0 {
+			delete(f.faults, method)
+		}
+	}
+	return *fault, true
+}
+
+func (f *faults) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+	fault, ok := f.take(method)
+	if !ok {
+		return handler(ctx, req)
+	}
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if fault.Err != nil {
+		return nil, fault.Err
+	}
+	return handler(ctx, req)
+}
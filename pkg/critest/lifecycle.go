@@ -0,0 +1,138 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package critest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sylabs/singularity-cri/pkg/server/runtime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// pollInterval is how often state is checked while waiting for it.
+const pollInterval = 10 * time.Millisecond
+
+// eventLog keeps container events received since server start, so that
+// events of one container can be checked after those of another one.
+type eventLog struct {
+	sub *runtime.EventSubscription
+
+	mu      sync.Mutex
+	pending []*runtime.ContainerEvent
+}
+
+func newEventLog(sub *runtime.EventSubscription) *eventLog {
+	return &eventLog{sub: sub}
+}
+
+func (l *eventLog) cancel() {
+	l.sub.Cancel()
+}
+
+// next returns the oldest event of the container that is not consumed yet.
+func (l *eventLog) next(id string, timeout time.Duration) (*runtime.ContainerEvent, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, event := range l.pending {
+		if event.ContainerID == id {
+			l.pending = append(l.pending[:i], l.pending[i+1:]...)
+			return event, true
+		}
+	}
+	deadline := time.After(timeout)
+	for {
+		select {
+		case event, ok := <-l.sub.Events():
+			if !ok {
+				return nil, false
+			}
+			if event.ContainerID == id {
+				return event, true
+			}
+			l.pending = append(l.pending, event)
+		case <-deadline:
+			return nil, false
+		}
+	}
+}
+
+// ExpectContainerEvents checks that the next lifecycle events of the container
+// are of the passed types in the given order, waiting up to timeout for each
+// event. Events of other containers are kept for later checks.
+func (s *Server) ExpectContainerEvents(id string, timeout time.Duration, types ...runtime.ContainerEventType) {
+	s.t.Helper()
+	for i, expect := range types {
+		event, ok := s.events.next(id, timeout)
+		if !ok {
+			s.t.Fatalf("container %s: no %v event received after %v, preceding events %v", id, expect, timeout, types[:i])
+		}
+		if event.Type != expect {
+			s.t.Fatalf("container %s: expected %v event, got %v", id, expect, event.Type)
+		}
+	}
+}
+
+// WaitContainerState waits until container reaches the state
+// and fails the test if it does not happen within timeout.
+func (s *Server) WaitContainerState(id string, state k8s.ContainerState, timeout time.Duration) *k8s.ContainerStatus {
+	s.t.Helper()
+	var last *k8s.ContainerStatus
+	ok := s.poll(timeout, func() bool {
+		resp, err := s.runtime.ContainerStatus(context.Background(), &k8s.ContainerStatusRequest{ContainerId: id})
+		if err != nil {
+			s.t.Fatalf("could not get container %s status: %v", id, err)
+		}
+		last = resp.GetStatus()
+		return last.GetState() == state
+	})
+	if !ok {
+		s.t.Fatalf("container %s is %v after %v, expected %v", id, last.GetState(), timeout, state)
+	}
+	return last
+}
+
+// WaitPodState waits until pod reaches the state and fails
+// the test if it does not happen within timeout.
+func (s *Server) WaitPodState(id string, state k8s.PodSandboxState, timeout time.Duration) *k8s.PodSandboxStatus {
+	s.t.Helper()
+	var last *k8s.PodSandboxStatus
+	ok := s.poll(timeout, func() bool {
+		resp, err := s.runtime.PodSandboxStatus(context.Background(), &k8s.PodSandboxStatusRequest{PodSandboxId: id})
+		if err != nil {
+			s.t.Fatalf("could not get pod %s status: %v", id, err)
+		}
+		last = resp.GetStatus()
+		return last.GetState() == state
+	})
+	if !ok {
+		s.t.Fatalf("pod %s is %v after %v, expected %v", id, last.GetState(), timeout, state)
+	}
+	return last
+}
+
+func (s *Server) poll(timeout time.Duration, done func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if done() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(pollInterval)
+	}
+}
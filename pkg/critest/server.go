@@ -0,0 +1,291 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package critest provides a CRI server for testing CRI clients, e.g. custom
+// schedulers and node agents. The server runs in the test process, serves real
+// runtime and image services and runs containers with the fake engine, so it
+// needs neither root nor Singularity. Containers are plain host processes,
+// thus commands must exist on the host and pods must use node network.
+package critest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	imageServer "github.com/sylabs/singularity-cri/pkg/server/image"
+	"github.com/sylabs/singularity-cri/pkg/server/runtime"
+	"google.golang.org/grpc"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// FakeImage is an image preloaded into the fake server image store.
+// Image file is a placeholder as fake engine does not mount images.
+type FakeImage struct {
+	// Ref is image reference, e.g. busybox or docker.io/library/busybox:1.31.
+	Ref string
+	// Config is optional image config, e.g. default command and environment.
+	Config *specs.ImageConfig
+}
+
+type options struct {
+	images      []FakeImage
+	runtimeOpts []runtime.Option
+	imageOpts   []imageServer.Option
+}
+
+// Option configures fake server started with StartFakeServer.
+type Option func(o *options)
+
+// WithImages preloads the passed images into image store.
+func WithImages(images ...FakeImage) Option {
+	return func(o *options) {
+		o.images = append(o.images, images...)
+	}
+}
+
+// WithRuntimeOptions passes additional options to the runtime service.
+func WithRuntimeOptions(opts ...runtime.Option) Option {
+	return func(o *options) {
+		o.runtimeOpts = append(o.runtimeOpts, opts...)
+	}
+}
+
+// WithImageOptions passes additional options to the image service.
+func WithImageOptions(opts ...imageServer.Option) Option {
+	return func(o *options) {
+		o.imageOpts = append(o.imageOpts, opts...)
+	}
+}
+
+// Server is a fake CRI server listening on a unix socket in a temporary
+// directory. It must be stopped with Stop once test is over, e.g.
+//
+//	srv := critest.StartFakeServer(t, critest.WithImages(critest.FakeImage{Ref: "busybox"}))
+//	defer srv.Stop()
+type Server struct {
+	// Runtime is a client of the runtime service.
+	Runtime k8s.RuntimeServiceClient
+	// Image is a client of the image service.
+	Image k8s.ImageServiceClient
+
+	t        testing.TB
+	dir      string
+	socket   string
+	server   *grpc.Server
+	conn     *grpc.ClientConn
+	runtime  *runtime.SingularityRuntime
+	registry *imageServer.SingularityRegistry
+	faults   *faults
+	events   *eventLog
+	stopOnce sync.Once
+}
+
+// StartFakeServer starts fake CRI server and connects clients to it.
+// Test fails immediately if server cannot be started.
+func StartFakeServer(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	dir, err := ioutil.TempDir("", "critest-")
+	if err != nil {
+		t.Fatalf("could not create server directory: %v", err)
+	}
+	s := &Server{
+		t:      t,
+		dir:    dir,
+		socket: filepath.Join(dir, "cri.sock"),
+		faults: newFaults(),
+	}
+	if err := s.start(&o); err != nil {
+		s.Stop()
+		t.Fatalf("could not start fake CRI server: %v", err)
+	}
+	return s
+}
+
+func (s *Server) start(o *options) error {
+	imgIndex := index.NewImageIndex()
+	storage := filepath.Join(s.dir, "images")
+	imageOpts := append([]imageServer.Option{imageServer.WithoutEngineCheck()}, o.imageOpts...)
+	var err error
+	s.registry, err = imageServer.NewSingularityRegistry(storage, imgIndex, imageOpts...)
+	if err != nil {
+		return fmt.Errorf("could not create image service: %v", err)
+	}
+	for _, img := range o.images {
+		if err := addFakeImage(imgIndex, storage, img); err != nil {
+			return err
+		}
+	}
+
+	runtimeOpts := append([]runtime.Option{
+		runtime.WithBaseRunDir(filepath.Join(s.dir, "run")),
+	}, o.runtimeOpts...)
+	runtimeOpts = append(runtimeOpts, runtime.WithFakeEngine())
+	s.runtime, err = runtime.NewSingularityRuntime(imgIndex, runtimeOpts...)
+	if err != nil {
+		return fmt.Errorf("could not create runtime service: %v", err)
+	}
+	s.events = newEventLog(s.runtime.SubscribeContainerEvents())
+
+	lis, err := net.Listen("unix", s.socket)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %v", s.socket, err)
+	}
+	s.server = grpc.NewServer(grpc.UnaryInterceptor(s.faults.intercept))
+	k8s.RegisterRuntimeServiceServer(s.server, s.runtime)
+	k8s.RegisterImageServiceServer(s.server, s.registry)
+	go s.server.Serve(lis)
+
+	s.conn, err = grpc.Dial(s.Endpoint(), grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %v", s.Endpoint(), err)
+	}
+	s.Runtime = k8s.NewRuntimeServiceClient(s.conn)
+	s.Image = k8s.NewImageServiceClient(s.conn)
+	return nil
+}
+
+// addFakeImage writes placeholder image file and adds it to the index.
+func addFakeImage(imgIndex *index.ImageIndex, storage string, img FakeImage) error {
+	ref, err := image.ParseRef(img.Ref)
+	if err != nil {
+		return fmt.Errorf("invalid image %s: %v", img.Ref, err)
+	}
+	content := []byte(ref.String())
+	sum := sha256.Sum256(content)
+	id := hex.EncodeToString(sum[:])
+	path := filepath.Join(storage, id)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("could not write image %s: %v", img.Ref, err)
+	}
+	info := &image.Info{
+		ID:        id,
+		Sha256:    id,
+		Size:      uint64(len(content)),
+		Path:      path,
+		Ref:       ref,
+		OciConfig: img.Config,
+	}
+	if err := imgIndex.Add(info); err != nil {
+		return fmt.Errorf("could not add image %s: %v", img.Ref, err)
+	}
+	return nil
+}
+
+// Endpoint returns address of the server for clients that connect on their own.
+func (s *Server) Endpoint() string {
+	return "unix://" + s.socket
+}
+
+// RuntimeService returns runtime service clients are connected to, so that
+// tests may reach functionality not exposed over CRI.
+func (s *Server) RuntimeService() *runtime.SingularityRuntime {
+	return s.runtime
+}
+
+// RunDir returns directory pods and containers are stored under.
+func (s *Server) RunDir() string {
+	return filepath.Join(s.dir, "run")
+}
+
+// Stop removes all pods left by the test, stops the server and removes its
+// files. It is safe to call Stop more than once.
+func (s *Server) Stop() {
+	s.stopOnce.Do(s.stop)
+}
+
+func (s *Server) stop() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	if s.server != nil {
+		s.server.Stop()
+	}
+	if s.runtime != nil {
+		s.events.cancel()
+		if err := s.removePods(); err != nil {
+			s.t.Errorf("could not remove pods: %v", err)
+		}
+		if err := s.runtime.Shutdown(); err != nil {
+			s.t.Errorf("could not shut down runtime service: %v", err)
+		}
+	}
+	if s.registry != nil {
+		if err := s.registry.Shutdown(); err != nil {
+			s.t.Errorf("could not shut down image service: %v", err)
+		}
+	}
+	if err := os.RemoveAll(s.dir); err != nil {
+		s.t.Errorf("could not remove server directory: %v", err)
+	}
+}
+
+// removePods stops and removes pods together with their containers,
+// requests are served directly so that injected faults do not apply.
+func (s *Server) removePods() error {
+	ctx := context.Background()
+	resp, err := s.runtime.ListPodSandbox(ctx, &k8s.ListPodSandboxRequest{})
+	if err != nil {
+		return err
+	}
+	for _, pod := range resp.GetItems() {
+		_, err := s.runtime.StopPodSandbox(ctx, &k8s.StopPodSandboxRequest{PodSandboxId: pod.GetId()})
+		if err != nil {
+			return err
+		}
+		_, err = s.runtime.RemovePodSandbox(ctx, &k8s.RemovePodSandboxRequest{PodSandboxId: pod.GetId()})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PodConfig returns minimal pod config fake engine is able to run.
+func PodConfig(name string) *k8s.PodSandboxConfig {
+	return &k8s.PodSandboxConfig{
+		Metadata: &k8s.PodSandboxMetadata{Name: name, Namespace: "default", Uid: name},
+		Linux: &k8s.LinuxPodSandboxConfig{
+			SecurityContext: &k8s.LinuxSandboxSecurityContext{
+				NamespaceOptions: &k8s.NamespaceOption{Network: k8s.NamespaceMode_NODE},
+			},
+		},
+	}
+}
+
+// ContainerConfig returns minimal container config running the passed
+// host command with the image.
+func ContainerConfig(name, image string, command ...string) *k8s.ContainerConfig {
+	return &k8s.ContainerConfig{
+		Metadata: &k8s.ContainerMetadata{Name: name},
+		Image:    &k8s.ImageSpec{Image: image},
+		Command:  command,
+		Envs:     []*k8s.KeyValue{{Key: "PATH", Value: "/usr/local/bin:/usr/bin:/bin"}},
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package critest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/server/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestFakeServer(t *testing.T) {
+	srv := StartFakeServer(t, WithImages(FakeImage{Ref: "busybox"}))
+	defer srv.Stop()
+	ctx := context.Background()
+
+	images, err := srv.Image.ListImages(ctx, &k8s.ListImagesRequest{})
+	require.NoError(t, err)
+	require.Len(t, images.GetImages(), 1)
+	require.Contains(t, images.GetImages()[0].GetRepoTags(), "busybox:latest")
+
+	podConfig := PodConfig("fake")
+	pod, err := srv.Runtime.RunPodSandbox(ctx, &k8s.RunPodSandboxRequest{Config: podConfig})
+	require.NoError(t, err)
+	srv.WaitPodState(pod.GetPodSandboxId(), k8s.PodSandboxState_SANDBOX_READY, time.Second)
+
+	srv.InjectFault("CreateContainer", Fault{Err: status.Error(codes.Unavailable, "injected"), Count: 1})
+	req := &k8s.CreateContainerRequest{
+		PodSandboxId:  pod.GetPodSandboxId(),
+		Config:        ContainerConfig("app", "busybox", "/bin/sh", "-c", "exit 3"),
+		SandboxConfig: podConfig,
+	}
+	_, err = srv.Runtime.CreateContainer(ctx, req)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	cont, err := srv.Runtime.CreateContainer(ctx, req)
+	require.NoError(t, err)
+
+	_, err = srv.Runtime.StartContainer(ctx, &k8s.StartContainerRequest{ContainerId: cont.GetContainerId()})
+	require.NoError(t, err)
+	st := srv.WaitContainerState(cont.GetContainerId(), k8s.ContainerState_CONTAINER_EXITED, 5*time.Second)
+	require.EqualValues(t, 3, st.GetExitCode())
+	srv.ExpectContainerEvents(cont.GetContainerId(), time.Second,
+		runtime.ContainerCreatedEvent, runtime.ContainerStartedEvent)
+
+	// pods left by test are removed on stop
+	srv.Stop()
+	_, err = srv.RuntimeService().PodSandboxStatus(ctx, &k8s.PodSandboxStatusRequest{PodSandboxId: pod.GetPodSandboxId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
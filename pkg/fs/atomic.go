@@ -0,0 +1,113 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CorruptDir is a directory files that could not be decoded are moved to
+// by Quarantine. It is created next to the quarantined file.
+const CorruptDir = ".corrupt"
+
+// atomicOps are steps of atomic write. They are replaced in tests
+// to simulate crash at each step.
+type atomicOps struct {
+	write   func(f *os.File, data []byte) error
+	sync    func(f *os.File) error
+	rename  func(from, to string) error
+	syncDir func(dir string) error
+}
+
+var ops = atomicOps{
+	write: func(f *os.File, data []byte) error {
+		_, err := f.Write(data)
+		return err
+	},
+	sync:    (*os.File).Sync,
+	rename:  os.Rename,
+	syncDir: syncDir,
+}
+
+// WriteFileAtomic replaces file at path with the passed data so that either
+// old or new content is observed after a crash. Data is written into a
+// temporary file in the same directory, which is synced and renamed to
+// path, then the directory is synced to persist the rename. Temporary file
+// is removed on failure.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %v", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	err = ops.write(tmp, data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = ops.sync(tmp)
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not write %s: %v", tmp.Name(), err)
+	}
+	if err := ops.rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not replace %s: %v", path, err)
+	}
+	committed = true
+	if err := ops.syncDir(dir); err != nil {
+		return fmt.Errorf("could not sync %s: %v", dir, err)
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Quarantine moves file that could not be decoded into CorruptDir next to it
+// so that it no longer affects daemon start, yet is kept for inspection.
+// Moved file name is suffixed with the current time. It returns new file path.
+func Quarantine(path string) (string, error) {
+	dir := filepath.Join(filepath.Dir(path), CorruptDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("could not create %s: %v", dir, err)
+	}
+	suffix := strings.Replace(time.Now().UTC().Format("20060102T150405.000000000"), ".", "", 1)
+	to := filepath.Join(dir, filepath.Base(path)+"."+suffix)
+	if err := os.Rename(path, to); err != nil {
+		return "", fmt.Errorf("could not move %s to %s: %v", path, dir, err)
+	}
+	return to, nil
+}
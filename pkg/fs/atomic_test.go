@@ -0,0 +1,163 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	errInjected := fmt.Errorf("injected failure")
+	defaultOps := ops
+	defer func() { ops = defaultOps }()
+
+	tt := []struct {
+		name      string
+		inject    func(o *atomicOps)
+		expectErr bool
+		// expectNew is true when new content must be
+		// observed despite the failure
+		expectNew bool
+	}{
+		{
+			name:      "success",
+			inject:    func(o *atomicOps) {},
+			expectNew: true,
+		},
+		{
+			name: "write failure",
+			inject: func(o *atomicOps) {
+				o.write = func(f *os.File, data []byte) error {
+					// simulate short write
+					f.Write(data[:len(data)/2])
+					return errInjected
+				}
+			},
+			expectErr: true,
+		},
+		{
+			name: "fsync failure",
+			inject: func(o *atomicOps) {
+				o.sync = func(*os.File) error { return errInjected }
+			},
+			expectErr: true,
+		},
+		{
+			name: "rename failure",
+			inject: func(o *atomicOps) {
+				o.rename = func(string, string) error { return errInjected }
+			},
+			expectErr: true,
+		},
+		{
+			name: "directory fsync failure",
+			inject: func(o *atomicOps) {
+				o.syncDir = func(string) error { return errInjected }
+			},
+			expectErr: true,
+			expectNew: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			require.NoError(t, err, "could not create temp dir")
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "registry.json")
+			require.NoError(t, ioutil.WriteFile(path, []byte(`{"id":"old"}`), 0600))
+
+			ops = defaultOps
+			tc.inject(&ops)
+			err = WriteFileAtomic(path, []byte(`{"id":"new"}`), 0644)
+			ops = defaultOps
+			if tc.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			data, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			if tc.expectNew {
+				require.Equal(t, `{"id":"new"}`, string(data))
+				fi, err := os.Stat(path)
+				require.NoError(t, err)
+				require.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+			} else {
+				require.Equal(t, `{"id":"old"}`, string(data))
+			}
+
+			// no temporary files are left behind
+			fii, err := ioutil.ReadDir(dir)
+			require.NoError(t, err)
+			require.Len(t, fii, 1)
+		})
+	}
+}
+
+func TestWriteFileAtomic_NoDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	err = WriteFileAtomic(filepath.Join(dir, "missing", "config.json"), []byte("{}"), 0644)
+	require.Error(t, err)
+}
+
+func TestQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "registry.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"id":`), 0644))
+
+	moved, err := Quarantine(path)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, CorruptDir), filepath.Dir(moved))
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+	data, err := ioutil.ReadFile(moved)
+	require.NoError(t, err)
+	require.Equal(t, `{"id":`, string(data))
+
+	_, err = Quarantine(path)
+	require.Error(t, err)
+}
+
+func BenchmarkWriteFileAtomic(b *testing.B) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(b, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	// registry backup file of about a hundred images
+	data := make([]byte, 64<<10)
+	path := filepath.Join(dir, "registry.json")
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := WriteFileAtomic(path, data, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const uuidDir = "/dev/disk/by-uuid"
+
+// DiskUsage returns ID of the device file resides on and
+// number of bytes allocated for it on that device.
+func DiskUsage(fi os.FileInfo) (uint64, uint64) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, uint64(fi.Size())
+	}
+	// st_blocks is always in 512-byte units
+	return uint64(st.Dev), uint64(st.Blocks) * 512
+}
+
+// DeviceUUID returns UUID of filesystem on the device with the passed ID.
+// Empty string is returned for filesystems without UUID, e.g. tmpfs.
+func DeviceUUID(dev uint64) string {
+	fii, err := ioutil.ReadDir(uuidDir)
+	if err != nil {
+		return ""
+	}
+	for _, fi := range fii {
+		// entries are symlinks to block devices
+		target, err := os.Stat(filepath.Join(uuidDir, fi.Name()))
+		if err != nil {
+			continue
+		}
+		if st, ok := target.Sys().(*syscall.Stat_t); ok && uint64(st.Rdev) == dev {
+			return fi.Name()
+		}
+	}
+	return ""
+}
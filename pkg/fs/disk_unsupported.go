@@ -0,0 +1,33 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux
+
+package fs
+
+import (
+	"os"
+)
+
+// DiskUsage reports no device and file size as allocated
+// bytes since file stat is not inspected on this platform.
+func DiskUsage(fi os.FileInfo) (uint64, uint64) {
+	return 0, uint64(fi.Size())
+}
+
+// DeviceUUID always returns empty string as filesystem
+// UUIDs are not looked up on this platform.
+func DeviceUUID(dev uint64) string {
+	return ""
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// moveRename is a variable so that tests may simulate cross-device rename.
+var moveRename = os.Rename
+
+// MoveFile moves regular file to the passed path. It is renamed when both
+// paths are on the same filesystem, otherwise it is copied next to the
+// destination, synced and renamed into place, then the source is removed.
+func MoveFile(from, to string) error {
+	err := moveRename(from, to)
+	if err == nil {
+		return nil
+	}
+	if lErr, ok := err.(*os.LinkError); !ok || lErr.Err != syscall.EXDEV {
+		return err
+	}
+
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(to), "."+filepath.Base(to)+".tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %v", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	_, err = io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Chmod(fi.Mode().Perm())
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not copy %s: %v", from, err)
+	}
+	if err := os.Rename(tmp.Name(), to); err != nil {
+		return err
+	}
+	committed = true
+	return os.Remove(from)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoveFile(t *testing.T) {
+	defer func() { moveRename = os.Rename }()
+
+	tt := []struct {
+		name   string
+		rename func(from, to string) error
+	}{
+		{
+			name:   "same filesystem",
+			rename: os.Rename,
+		},
+		{
+			name: "cross device",
+			rename: func(from, to string) error {
+				return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			from := filepath.Join(dir, "from")
+			to := filepath.Join(dir, "to")
+			require.NoError(t, ioutil.WriteFile(from, []byte("content"), 0640))
+			require.NoError(t, os.Chmod(from, 0640))
+
+			moveRename = tc.rename
+			require.NoError(t, MoveFile(from, to))
+			_, err = os.Stat(from)
+			require.True(t, os.IsNotExist(err), "source is not removed")
+			content, err := ioutil.ReadFile(to)
+			require.NoError(t, err)
+			require.Equal(t, "content", string(content))
+			fi, err := os.Stat(to)
+			require.NoError(t, err)
+			require.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+			left, err := ioutil.ReadDir(dir)
+			require.NoError(t, err)
+			require.Len(t, left, 1, "temporary file is left")
+		})
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fs
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrQuotaNotSupported is returned when filesystem has no project quotas
+	// enabled, e.g. xfs mounted without prjquota or ext4 without project feature.
+	ErrQuotaNotSupported = errors.New("project quotas are not supported")
+	// ErrQuotaNotSet is returned when directory has no project quota assigned.
+	ErrQuotaNotSet = errors.New("directory has no project quota")
+)
+
+// ProjectQuota limits size of directories on a single xfs or ext4 filesystem
+// with project quotas. Each limited directory is assigned its own project,
+// projects with no usage and no limits are considered free.
+type ProjectQuota struct {
+	device     string
+	mountPoint string
+
+	mu   sync.Mutex
+	next uint32
+}
+
+// MountPoint returns mount point of the filesystem quotas are set on.
+func (q *ProjectQuota) MountPoint() string {
+	return q.mountPoint
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// minProjectID and maxProjects define a range of project IDs assigned
+	// to limited directories, IDs below are left to system administrators.
+	minProjectID = 1 << 20
+	maxProjects  = 1 << 20
+
+	qGetQuota = 0x800007
+	qSetQuota = 0x800008
+	prjQuota  = 2
+	// quota block limits are set in 1KiB units
+	qifBLimits   = 1
+	qifILimits   = 4
+	qifBlockSize = 1024
+
+	// ioctl requests of struct fsxattr, see linux/fs.h
+	fsIocFsGetXattr    = 0x801c581f
+	fsIocFsSetXattr    = 0x401c5820
+	fsXflagProjInherit = 0x200
+)
+
+// dqblk is struct if_dqblk of quotactl(2).
+type dqblk struct {
+	bHardLimit uint64
+	bSoftLimit uint64
+	curSpace   uint64
+	iHardLimit uint64
+	iSoftLimit uint64
+	curInodes  uint64
+	bTime      uint64
+	iTime      uint64
+	valid      uint32
+}
+
+// fsxattr is struct fsxattr of FS_IOC_FSGETXATTR ioctl.
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// NewProjectQuota returns quota of the filesystem dir resides on.
+// ErrQuotaNotSupported is returned when project quotas are not enabled.
+func NewProjectQuota(dir string) (*ProjectQuota, error) {
+	var stfs unix.Statfs_t
+	if err := unix.Statfs(dir, &stfs); err != nil {
+		return nil, fmt.Errorf("could not stat filesystem of %s: %v", dir, err)
+	}
+	if stfs.Type != unix.XFS_SUPER_MAGIC && stfs.Type != unix.EXT4_SUPER_MAGIC {
+		return nil, ErrQuotaNotSupported
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(dir, &st); err != nil {
+		return nil, fmt.Errorf("could not stat %s: %v", dir, err)
+	}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("could not read mount info: %v", err)
+	}
+	defer f.Close()
+	device, mountPoint, err := findMount(f, unix.Major(uint64(st.Dev)), unix.Minor(uint64(st.Dev)))
+	if err != nil {
+		return nil, err
+	}
+
+	q := &ProjectQuota{
+		device:     device,
+		mountPoint: mountPoint,
+		next:       minProjectID,
+	}
+	if _, err := q.get(minProjectID); err != nil {
+		return nil, ErrQuotaNotSupported
+	}
+	if _, err := getFsxattr(dir); err != nil {
+		return nil, ErrQuotaNotSupported
+	}
+	return q, nil
+}
+
+// findMount returns source and mount point of the filesystem mounted from
+// device with the passed numbers, mountinfo is in proc(5) format.
+func findMount(mountinfo io.Reader, major, minor uint32) (string, string, error) {
+	dev := fmt.Sprintf("%d:%d", major, minor)
+	scanner := bufio.NewScanner(mountinfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 || fields[2] != dev {
+			continue
+		}
+		// optional fields are terminated by a single hyphen
+		for i := 6; i < len(fields)-2; i++ {
+			if fields[i] == "-" {
+				return fields[i+2], fields[4], nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("could not read mount info: %v", err)
+	}
+	return "", "", fmt.Errorf("no mount of device %s found", dev)
+}
+
+// SetLimit assigns dir a free project and limits its size in bytes. Project is
+// inherited by everything created in dir later on, content that exists already
+// is assigned the project as well.
+func (q *ProjectQuota) SetLimit(dir string, size int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id, err := q.freeProject()
+	if err != nil {
+		return err
+	}
+	limit := dqblk{
+		bHardLimit: (uint64(size) + qifBlockSize - 1) / qifBlockSize,
+		valid:      qifBLimits,
+	}
+	if err := q.set(id, &limit); err != nil {
+		return fmt.Errorf("could not set project %d limit: %v", id, err)
+	}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			// ioctl cannot be issued on symlinks and special files
+			return nil
+		}
+		return setProject(path, id, info.IsDir())
+	})
+	if err != nil {
+		q.set(id, &dqblk{valid: qifBLimits | qifILimits})
+		return fmt.Errorf("could not assign project %d to %s: %v", id, dir, err)
+	}
+	q.next = id + 1
+	return nil
+}
+
+// Release clears size limit of dir set by SetLimit, so that its project
+// becomes free once dir content is removed.
+func (q *ProjectQuota) Release(dir string) error {
+	attr, err := getFsxattr(dir)
+	if err != nil {
+		return fmt.Errorf("could not get project of %s: %v", dir, err)
+	}
+	if attr.projid == 0 {
+		return nil
+	}
+	if err := q.set(attr.projid, &dqblk{valid: qifBLimits | qifILimits}); err != nil {
+		return fmt.Errorf("could not clear project %d limit: %v", attr.projid, err)
+	}
+	return nil
+}
+
+// Usage returns usage of dir project, which is much cheaper than walking dir
+// with Usage. ErrQuotaNotSet is returned when dir has no project assigned.
+func (q *ProjectQuota) Usage(dir string) (*UsageInfo, error) {
+	attr, err := getFsxattr(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not get project of %s: %v", dir, err)
+	}
+	if attr.projid < minProjectID {
+		return nil, ErrQuotaNotSet
+	}
+	dq, err := q.get(attr.projid)
+	if err != nil {
+		return nil, fmt.Errorf("could not get project %d usage: %v", attr.projid, err)
+	}
+	return &UsageInfo{
+		MountPoint: q.mountPoint,
+		Bytes:      int64(dq.curSpace),
+		Inodes:     int64(dq.curInodes),
+	}, nil
+}
+
+// freeProject looks for a project with no usage and no limits,
+// starting with the one after the most recently assigned.
+func (q *ProjectQuota) freeProject() (uint32, error) {
+	for i := uint32(0); i < maxProjects; i++ {
+		id := minProjectID + (q.next-minProjectID+i)%maxProjects
+		dq, err := q.get(id)
+		if err == unix.ENOENT || err == unix.ESRCH {
+			// xfs has no quota structure for projects never used
+			return id, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("could not get project %d quota: %v", id, err)
+		}
+		if dq.curSpace == 0 && dq.curInodes == 0 && dq.bHardLimit == 0 && dq.iHardLimit == 0 {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no free project ID left")
+}
+
+func (q *ProjectQuota) get(id uint32) (*dqblk, error) {
+	var dq dqblk
+	if err := q.quotactl(qGetQuota, id, &dq); err != nil {
+		return nil, err
+	}
+	return &dq, nil
+}
+
+func (q *ProjectQuota) set(id uint32, dq *dqblk) error {
+	return q.quotactl(qSetQuota, id, dq)
+}
+
+func (q *ProjectQuota) quotactl(cmd int, id uint32, dq *dqblk) error {
+	device, err := unix.BytePtrFromString(q.device)
+	if err != nil {
+		return err
+	}
+	qcmd := cmd<<8 | prjQuota
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL, uintptr(qcmd), uintptr(unsafe.Pointer(device)),
+		uintptr(id), uintptr(unsafe.Pointer(dq)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func getFsxattr(path string) (*fsxattr, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var attr fsxattr
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(&attr)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return &attr, nil
+}
+
+// setProject assigns project to the file, directories are also
+// marked so that everything created in them inherits the project.
+func setProject(path string, id uint32, dir bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var attr fsxattr
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(&attr))); errno != 0 {
+		return errno
+	}
+	attr.projid = id
+	if dir {
+		attr.xflags |= fsXflagProjInherit
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFsSetXattr, uintptr(unsafe.Pointer(&attr))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package fs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindMount(t *testing.T) {
+	const mountinfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+36 22 8:16 / /var/lib/sycri rw,noatime shared:2 master:1 - xfs /dev/sdb rw,prjquota
+40 22 0:5 / /dev rw,nosuid - devtmpfs udev rw
+`
+	tt := []struct {
+		name         string
+		major, minor uint32
+		expectSource string
+		expectMount  string
+		expectError  bool
+	}{
+		{name: "root", major: 8, minor: 1, expectSource: "/dev/sda1", expectMount: "/"},
+		{name: "optional fields", major: 8, minor: 16, expectSource: "/dev/sdb", expectMount: "/var/lib/sycri"},
+		{name: "unknown device", major: 8, minor: 2, expectError: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			source, mount, err := findMount(strings.NewReader(mountinfo), tc.major, tc.minor)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectSource, source)
+			require.Equal(t, tc.expectMount, mount)
+		})
+	}
+}
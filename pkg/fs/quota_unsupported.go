@@ -0,0 +1,37 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// +build !linux
+
+package fs
+
+// NewProjectQuota returns ErrQuotaNotSupported since
+// project quotas are available only on Linux.
+func NewProjectQuota(dir string) (*ProjectQuota, error) {
+	return nil, ErrQuotaNotSupported
+}
+
+// SetLimit returns ErrQuotaNotSupported.
+func (q *ProjectQuota) SetLimit(dir string, size int64) error {
+	return ErrQuotaNotSupported
+}
+
+// Release returns ErrQuotaNotSupported.
+func (q *ProjectQuota) Release(dir string) error {
+	return ErrQuotaNotSupported
+}
+
+// Usage returns ErrQuotaNotSupported.
+func (q *ProjectQuota) Usage(dir string) (*UsageInfo, error) {
+	return nil, ErrQuotaNotSupported
+}
@@ -28,6 +28,8 @@ const (
 	OpRemove
 	// OpCreate is used when watched file was created.
 	OpCreate
+	// OpWrite is used when watched file content or attributes were changed.
+	OpWrite
 )
 
 // Watcher is a filesystem watcher that can be used
@@ -46,7 +48,7 @@ type WatchEvent struct {
 }
 
 // NewWatcher creates new Watcher that will be watching passed files or directories
-// that already exist. Currently only create and remove operations are supported.
+// that already exist. Currently only create, remove and write operations are supported.
 // NOTE: when watching a single file no new event will be triggered after it's removal.
 func NewWatcher(files ...string) (*Watcher, error) {
 	watcher, err := fsnotify.NewWatcher()
@@ -79,6 +81,9 @@ func (w *Watcher) Watch(ctx context.Context) <-chan WatchEvent {
 				if event.Op&fsnotify.Create == fsnotify.Create {
 					op = OpCreate
 				}
+				if event.Op&(fsnotify.Write|fsnotify.Chmod) != 0 {
+					op = OpWrite
+				}
 				if event.Op&fsnotify.Remove == fsnotify.Remove {
 					op = OpRemove
 				}
@@ -86,4 +86,10 @@ func TestWatcher(t *testing.T) {
 		Path: file2New,
 		Op:   OpCreate,
 	}, <-upd, "unexpected update")
+
+	require.NoError(t, os.Chmod(file3, 0755), "could not chmod test file")
+	require.Equal(t, WatchEvent{
+		Path: file3,
+		Op:   OpWrite,
+	}, <-upd, "unexpected update")
 }
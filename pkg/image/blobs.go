@@ -0,0 +1,242 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// ociBlobDirs are directories relative to singularity cache where downloaded
+// docker blobs are kept in OCI layout. Singularity 3.1 and newer nests
+// its cache into cache subdirectory while older versions do not.
+var ociBlobDirs = []string{
+	filepath.Join("cache", "oci", "blobs"),
+	filepath.Join("oci", "blobs"),
+}
+
+// Layer is a content addressed blob docker image is assembled from.
+type Layer struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// BlobStore keeps docker blobs downloaded during pulls by their digest so that
+// layers shared by different images are downloaded and stored only once.
+// Each blob is referenced by IDs of images that were built from it and is
+// removed once the last of those images is released. This type is thread-safe.
+type BlobStore struct {
+	dir string
+
+	mu    sync.Mutex
+	blobs map[string]*blobRefs
+}
+
+type blobRefs struct {
+	size   int64
+	images map[string]struct{}
+}
+
+// NewBlobStore returns blob store located at the passed directory.
+// Directory is used as singularity cache when building docker images.
+func NewBlobStore(dir string) (*BlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create blob store directory: %v", err)
+	}
+	return &BlobStore{
+		dir:   dir,
+		blobs: make(map[string]*blobRefs),
+	}, nil
+}
+
+// Dir returns path to the blob store directory.
+func (b *BlobStore) Dir() string {
+	return b.dir
+}
+
+// Retain marks passed layers as used by the image. Retaining the same
+// image twice has no effect, so it is safe to call this on each pull.
+func (b *BlobStore) Retain(imageID string, layers []Layer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, layer := range layers {
+		if !validDigest(layer.Digest) {
+			continue
+		}
+		refs, ok := b.blobs[layer.Digest]
+		if !ok {
+			refs = &blobRefs{
+				size:   layer.Size,
+				images: make(map[string]struct{}),
+			}
+			b.blobs[layer.Digest] = refs
+		}
+		refs.images[imageID] = struct{}{}
+	}
+}
+
+// Release drops all references the image holds and removes blobs no other
+// image refers to. Returns number of bytes freed.
+func (b *BlobStore) Release(imageID string) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var freed uint64
+	var errs []string
+	for digest, refs := range b.blobs {
+		if _, ok := refs.images[imageID]; !ok {
+			continue
+		}
+		delete(refs.images, imageID)
+		if len(refs.images) != 0 {
+			continue
+		}
+		delete(b.blobs, digest)
+		removed, err := b.removeBlob(digest)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if removed {
+			freed += uint64(refs.size)
+		}
+	}
+	if len(errs) != 0 {
+		return freed, fmt.Errorf("could not remove blobs: %s", strings.Join(errs, "; "))
+	}
+	return freed, nil
+}
+
+// Refs returns number of images that refer to the blob with the passed digest.
+func (b *BlobStore) Refs(digest string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	refs, ok := b.blobs[digest]
+	if !ok {
+		return 0
+	}
+	return len(refs.images)
+}
+
+// Savings returns number of bytes that would have been downloaded
+// and stored additionally if blobs were not shared between images.
+func (b *BlobStore) Savings() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var saved uint64
+	for _, refs := range b.blobs {
+		if len(refs.images) > 1 {
+			saved += uint64(refs.size) * uint64(len(refs.images)-1)
+		}
+	}
+	return saved
+}
+
+// CorruptBlob describes blob whose content doesn't match its digest.
+type CorruptBlob struct {
+	Digest string
+	Path   string
+	Reason string
+}
+
+// Verify hashes every sha256 blob kept in the store and returns the ones
+// that don't match their digest. Blobs being written by an ongoing build
+// may be reported as well, so verification should be run when no docker
+// images are pulled.
+func (b *BlobStore) Verify(ctx context.Context) ([]CorruptBlob, error) {
+	var corrupt []CorruptBlob
+	for _, dir := range ociBlobDirs {
+		dir = filepath.Join(b.dir, dir, "sha256")
+		fii, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read blobs directory: %v", err)
+		}
+		for _, fi := range fii {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			digest := "sha256:" + fi.Name()
+			if !fi.Mode().IsRegular() || !validDigest(digest) {
+				continue
+			}
+			path := filepath.Join(dir, fi.Name())
+			checksum, err := fileChecksum(path)
+			if err != nil {
+				return nil, err
+			}
+			if checksum != fi.Name() {
+				corrupt = append(corrupt, CorruptBlob{
+					Digest: digest,
+					Path:   path,
+					Reason: fmt.Sprintf("blob %s: got sha256:%s", digest, checksum),
+				})
+			}
+		}
+	}
+	return corrupt, nil
+}
+
+// removeBlob removes blob file from singularity cache. Blob that
+// is already missing is not an error, but false is returned.
+func (b *BlobStore) removeBlob(digest string) (bool, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	removed := false
+	for _, dir := range ociBlobDirs {
+		path := filepath.Join(b.dir, dir, parts[0], parts[1])
+		glog.V(5).Infof("Removing blob %s", path)
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return removed, fmt.Errorf("could not remove blob %s: %v", digest, err)
+		}
+		removed = true
+	}
+	return removed, nil
+}
+
+// validDigest checks digest is in form of <algorithm>:<hex> so
+// that it is safe to be used as a part of blob file path.
+func validDigest(digest string) bool {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return false
+	}
+	for _, r := range parts[0] {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	for _, r := range parts[1] {
+		if !(r >= 'a' && r <= 'f' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
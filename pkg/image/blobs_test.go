@@ -0,0 +1,95 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	const (
+		base   = "sha256:aaaa"
+		python = "sha256:bbbb"
+		golang = "sha256:cccc"
+	)
+	blobPath := func(digest string) string {
+		return filepath.Join(dir, "cache", "oci", "blobs", "sha256", digest[len("sha256:"):])
+	}
+	for _, digest := range []string{base, python, golang} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(blobPath(digest)), 0755))
+		require.NoError(t, ioutil.WriteFile(blobPath(digest), []byte(digest), 0644))
+	}
+
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+	require.Equal(t, dir, store.Dir())
+
+	store.Retain("python", []Layer{{Digest: base, Size: 1000}, {Digest: python, Size: 10}})
+	store.Retain("python", []Layer{{Digest: base, Size: 1000}, {Digest: python, Size: 10}})
+	store.Retain("golang", []Layer{{Digest: base, Size: 1000}, {Digest: golang, Size: 20}, {Digest: "../../etc/passwd"}})
+	require.Equal(t, 2, store.Refs(base))
+	require.Equal(t, 1, store.Refs(python))
+	require.Equal(t, 0, store.Refs("../../etc/passwd"))
+	require.Equal(t, uint64(1000), store.Savings())
+
+	freed, err := store.Release("python")
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), freed)
+	require.FileExists(t, blobPath(base))
+	require.FileExists(t, blobPath(golang))
+	_, err = os.Stat(blobPath(python))
+	require.True(t, os.IsNotExist(err), "unused blob must be removed")
+	require.Equal(t, 1, store.Refs(base))
+	require.Equal(t, uint64(0), store.Savings())
+
+	freed, err = store.Release("python")
+	require.NoError(t, err)
+	require.Zero(t, freed)
+
+	freed, err = store.Release("golang")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1020), freed)
+	_, err = os.Stat(blobPath(base))
+	require.True(t, os.IsNotExist(err), "unused blob must be removed")
+}
+
+func TestValidDigest(t *testing.T) {
+	tt := []struct {
+		digest string
+		expect bool
+	}{
+		{digest: "sha256:165768770ca428e9e6d8290d5672652773edf1f80d442252a0ec737ed2cc312c", expect: true},
+		{digest: "sha512:ab01", expect: true},
+		{digest: "sha256:", expect: false},
+		{digest: ":abcd", expect: false},
+		{digest: "abcd", expect: false},
+		{digest: "sha256:../../etc", expect: false},
+		{digest: "../sha256:abcd", expect: false},
+	}
+	for _, tc := range tt {
+		t.Run(tc.digest, func(t *testing.T) {
+			require.Equal(t, tc.expect, validDigest(tc.digest))
+		})
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const credentialHelperPrefix = "docker-credential-"
+
+// dockerHubAliases are registry names docker hub may be referred by in docker config.
+var dockerHubAliases = []string{"docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com"}
+
+// dockerConfig is a subset of docker config.json format that holds credentials.
+type dockerConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+	CredsStore  string                `json:"credsStore"`
+}
+
+type dockerAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+// CredentialStore provides node-level registry credentials
+// stored in a docker-style config file. File is re-read
+// whenever it changes so that no restart is needed.
+type CredentialStore struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	config  *dockerConfig
+}
+
+// NewCredentialStore returns credential store backed by the passed file.
+func NewCredentialStore(path string) *CredentialStore {
+	return &CredentialStore{path: path}
+}
+
+// Lookup returns credentials to pull image referenced by ref. Most specific,
+// i.e. the longest, matching registry entry is chosen. When no credentials
+// are found nil is returned. Credentials are looked up only for docker images.
+func (c *CredentialStore) Lookup(ctx context.Context, ref *Reference) (*k8s.AuthConfig, error) {
+	if ref.URI() != singularity.DockerDomain {
+		return nil, nil
+	}
+	config, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+
+	parsed, err := ref.parsed()
+	if err != nil {
+		return nil, err
+	}
+	domain, repo := registryRepo(parsed, nil)
+	fullName := normalizeRegistry(domain) + "/" + repo
+
+	var helperKey, helper string
+	for key, h := range config.CredHelpers {
+		if matchesRegistry(fullName, key) && len(normalizeRegistry(key)) > len(normalizeRegistry(helperKey)) {
+			helperKey, helper = key, h
+		}
+	}
+	var authKey string
+	var auth dockerAuth
+	for key, a := range config.Auths {
+		if matchesRegistry(fullName, key) && len(normalizeRegistry(key)) > len(normalizeRegistry(authKey)) {
+			authKey, auth = key, a
+		}
+	}
+
+	// credential helpers take precedence over static entries of the same specificity
+	switch {
+	case helper != "" && len(normalizeRegistry(helperKey)) >= len(normalizeRegistry(authKey)):
+		glog.V(4).Infof("Using credential helper %s for %s", helper, ref)
+		return helperCredentials(ctx, helper, helperKey)
+	case authKey != "":
+		glog.V(4).Infof("Using %s credentials from %s for %s", authKey, c.path, ref)
+		return auth.authConfig()
+	case config.CredsStore != "":
+		glog.V(4).Infof("Using credential store %s for %s", config.CredsStore, ref)
+		return helperCredentials(ctx, config.CredsStore, domain)
+	}
+	return nil, nil
+}
+
+// load re-reads config file if it was changed since the last read.
+// Missing config file is not an error, it simply has no credentials.
+func (c *CredentialStore) load() (*dockerConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fi, err := os.Stat(c.path)
+	if os.IsNotExist(err) {
+		c.config = nil
+		c.modTime = time.Time{}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not stat registry auth file: %v", err)
+	}
+	if c.config != nil && fi.ModTime().Equal(c.modTime) {
+		return c.config, nil
+	}
+
+	glog.V(2).Infof("Loading registry credentials from %s", c.path)
+	f, err := os.Open(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open registry auth file: %v", err)
+	}
+	defer f.Close()
+
+	var config dockerConfig
+	if err := json.NewDecoder(f).Decode(&config); err != nil {
+		return nil, fmt.Errorf("could not decode registry auth file: %v", err)
+	}
+	c.config = &config
+	c.modTime = fi.ModTime()
+	return c.config, nil
+}
+
+func (a dockerAuth) authConfig() (*k8s.AuthConfig, error) {
+	auth := &k8s.AuthConfig{
+		Username:      a.Username,
+		Password:      a.Password,
+		IdentityToken: a.IdentityToken,
+		RegistryToken: a.RegistryToken,
+	}
+	if a.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode auth entry: %v", err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid auth entry: expected username:password")
+		}
+		auth.Username = parts[0]
+		auth.Password = parts[1]
+	}
+	return auth, nil
+}
+
+// DecodeAuth returns auth config passed with pull request with its base64
+// encoded auth field decoded into username and password, as kubelet does
+// for imagePullSecrets. Configs that set username already are returned as is.
+func DecodeAuth(auth *k8s.AuthConfig) (*k8s.AuthConfig, error) {
+	if auth.GetAuth() == "" || auth.GetUsername() != "" {
+		return auth, nil
+	}
+	decoded, err := dockerAuth{
+		Auth:          auth.GetAuth(),
+		IdentityToken: auth.GetIdentityToken(),
+		RegistryToken: auth.GetRegistryToken(),
+	}.authConfig()
+	if err != nil {
+		return nil, err
+	}
+	decoded.ServerAddress = auth.GetServerAddress()
+	return decoded, nil
+}
+
+// helperCredentials invokes docker credential helper with get command. Helper
+// output holds secrets so it is never logged or included into errors.
+func helperCredentials(ctx context.Context, helper, registry string) (*k8s.AuthConfig, error) {
+	cmd := exec.CommandContext(ctx, credentialHelperPrefix+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("credential helper %s failed: %v", helper, err)
+	}
+
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return nil, fmt.Errorf("credential helper %s returned invalid output", helper)
+	}
+	// docker helpers use <token> username to return identity token
+	if creds.Username == "<token>" {
+		return &k8s.AuthConfig{IdentityToken: creds.Secret}, nil
+	}
+	return &k8s.AuthConfig{
+		Username: creds.Username,
+		Password: creds.Secret,
+	}, nil
+}
+
+// matchesRegistry checks whether docker config key refers
+// to the registry and, optionally, repository of the image.
+func matchesRegistry(fullName, key string) bool {
+	key = normalizeRegistry(key)
+	if key == "" {
+		return false
+	}
+	return fullName == key || strings.HasPrefix(fullName, key+"/")
+}
+
+// normalizeRegistry trims scheme, trailing slashes, API version path and
+// default https port from docker config key and replaces docker hub aliases
+// with the host images are actually pulled from. Other ports are kept since
+// they identify different registries.
+func normalizeRegistry(key string) string {
+	if i := strings.Index(key, "://"); i != -1 {
+		key = key[i+3:]
+	}
+	key = strings.TrimSuffix(key, "/")
+	key = strings.TrimSuffix(strings.TrimSuffix(key, "/v1"), "/v2")
+
+	host, path := key, ""
+	if i := strings.IndexByte(key, '/'); i != -1 {
+		host, path = key[:i], key[i:]
+	}
+	host = strings.TrimSuffix(host, ":443")
+	for _, alias := range dockerHubAliases {
+		if host == alias {
+			return dockerHubRegistry + path
+		}
+	}
+	return host + path
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const testDockerConfig = `{
+	"auths": {
+		"https://index.docker.io/v1/": {"auth": "aHViOmh1YnBhc3M="},
+		"gcr.io": {"username": "gcr", "password": "gcrpass"},
+		"gcr.io/private": {"username": "private", "password": "privatepass"},
+		"localhost:5000": {"auth": "bm9wYXNz"},
+		"https://registry.internal:443": {"username": "internal", "password": "internalpass"},
+		"registry.internal:5000": {"username": "internal5000", "password": "internal5000pass"}
+	},
+	"credHelpers": {
+		"quay.io": "sycri-test"
+	}
+}`
+
+const testCredHelper = `#!/bin/sh
+read registry
+echo "{\"ServerURL\":\"$registry\",\"Username\":\"helper\",\"Secret\":\"helperpass\"}"
+`
+
+func TestCredentialStore_Lookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "creds")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	helper := filepath.Join(dir, credentialHelperPrefix+"sycri-test")
+	require.NoError(t, ioutil.WriteFile(helper, []byte(testCredHelper), 0755))
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	configPath := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(testDockerConfig), 0644))
+	store := NewCredentialStore(configPath)
+
+	tt := []struct {
+		name        string
+		ref         string
+		expectAuth  *k8s.AuthConfig
+		expectError bool
+	}{
+		{
+			name:       "docker hub by v1 url",
+			ref:        "busybox",
+			expectAuth: &k8s.AuthConfig{Username: "hub", Password: "hubpass"},
+		},
+		{
+			name:       "registry entry",
+			ref:        "gcr.io/google/pause:3.1",
+			expectAuth: &k8s.AuthConfig{Username: "gcr", Password: "gcrpass"},
+		},
+		{
+			name:       "longest prefix entry",
+			ref:        "gcr.io/private/app@sha256:165768770ca428e9e6d8290d5672652773edf1f80d442252a0ec737ed2cc312c",
+			expectAuth: &k8s.AuthConfig{Username: "private", Password: "privatepass"},
+		},
+		{
+			name:       "path boundary",
+			ref:        "gcr.io/privateer/app:1.0",
+			expectAuth: &k8s.AuthConfig{Username: "gcr", Password: "gcrpass"},
+		},
+		{
+			name:       "credential helper",
+			ref:        "quay.io/sylabs/app:1.0",
+			expectAuth: &k8s.AuthConfig{Username: "helper", Password: "helperpass"},
+		},
+		{
+			name:       "default https port is omitted in reference",
+			ref:        "registry.internal/team/app:1.2",
+			expectAuth: &k8s.AuthConfig{Username: "internal", Password: "internalpass"},
+		},
+		{
+			name:       "default https port in reference",
+			ref:        "registry.internal:443/team/app:1.2",
+			expectAuth: &k8s.AuthConfig{Username: "internal", Password: "internalpass"},
+		},
+		{
+			name:       "registry port",
+			ref:        "registry.internal:5000/team/app:1.2",
+			expectAuth: &k8s.AuthConfig{Username: "internal5000", Password: "internal5000pass"},
+		},
+		{
+			name: "no matching entry",
+			ref:  "example.com/app:1.0",
+		},
+		{
+			name: "library image",
+			ref:  "cloud.sylabs.io/sylabs/tests/busybox:1.0.0",
+		},
+		{
+			name:        "malformed auth",
+			ref:         "localhost:5000/app:1.0",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := ParseRef(tc.ref)
+			require.NoError(t, err)
+			auth, err := store.Lookup(context.Background(), ref)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectAuth, auth)
+		})
+	}
+
+	t.Run("reload on change", func(t *testing.T) {
+		config := `{"auths": {"example.com": {"username": "new", "password": "newpass"}}}`
+		require.NoError(t, ioutil.WriteFile(configPath, []byte(config), 0644))
+		future := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(configPath, future, future))
+
+		ref, err := ParseRef("example.com/app:1.0")
+		require.NoError(t, err)
+		auth, err := store.Lookup(context.Background(), ref)
+		require.NoError(t, err)
+		require.Equal(t, &k8s.AuthConfig{Username: "new", Password: "newpass"}, auth)
+	})
+
+	t.Run("removed file", func(t *testing.T) {
+		require.NoError(t, os.Remove(configPath))
+		ref, err := ParseRef("example.com/app:1.0")
+		require.NoError(t, err)
+		auth, err := store.Lookup(context.Background(), ref)
+		require.NoError(t, err)
+		require.Nil(t, auth)
+	})
+}
+
+func TestDecodeAuth(t *testing.T) {
+	tt := []struct {
+		name        string
+		auth        *k8s.AuthConfig
+		expectAuth  *k8s.AuthConfig
+		expectError bool
+	}{
+		{
+			name: "no auth",
+		},
+		{
+			name:       "encoded",
+			auth:       &k8s.AuthConfig{Auth: "c2FzaGE6c2VjcmV0", ServerAddress: "https://index.docker.io/v1/"},
+			expectAuth: &k8s.AuthConfig{Username: "sasha", Password: "secret", ServerAddress: "https://index.docker.io/v1/"},
+		},
+		{
+			name:       "username set",
+			auth:       &k8s.AuthConfig{Username: "sasha", Password: "secret", Auth: "b3RoZXI6b3RoZXI="},
+			expectAuth: &k8s.AuthConfig{Username: "sasha", Password: "secret", Auth: "b3RoZXI6b3RoZXI="},
+		},
+		{
+			name:        "no password",
+			auth:        &k8s.AuthConfig{Auth: "c2FzaGE="},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			auth, err := DecodeAuth(tc.auth)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectAuth, auth)
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// DefaultConcurrentDownloads is the default number of layers
+	// of a single image downloaded at the same time.
+	DefaultConcurrentDownloads = 3
+
+	// blobResumeAttempts is a number of times in a row interrupted blob
+	// download is resumed without receiving any data before it fails.
+	blobResumeAttempts = 3
+	// partialBlobsDir is a directory relative to cache directory layers
+	// are downloaded into, so that download interrupted together with
+	// pull is resumed by the next pull of any image with that layer.
+	partialBlobsDir = "partial"
+	// partialBlobTTL is a time partial layer that is not resumed is kept for.
+	partialBlobTTL = 24 * time.Hour
+)
+
+// busyPartials holds digests of partial blobs that are being downloaded,
+// concurrent pulls of the same layer don't share partial blob.
+var busyPartials = struct {
+	sync.Mutex
+	digests map[string]bool
+}{digests: make(map[string]bool)}
+
+// WithConcurrentDownloads sets number of layers of a single image downloaded
+// at the same time when image is stored in OCI layout, see WithOCILayout.
+// Non-positive n means DefaultConcurrentDownloads.
+func WithConcurrentDownloads(n int) PullOption {
+	return func(o *pullOptions) {
+		o.downloads = n
+	}
+}
+
+// downloadBlob downloads blob described by layer and saves it at pullPath.
+// Data already present at pullPath, e.g. left by interrupted download, is
+// resumed with a range request. Download interrupted by network is resumed
+// the same way. Blob digest is computed as data streams in and blob is
+// moved into quarantine when it doesn't match digest or size of layer.
+// Nil quarantine means such blob is removed.
+func downloadBlob(ctx context.Context, blobURL string, auth *k8s.AuthConfig, layer *descriptor, pullPath string,
+	throttle *Throttle, host string, quarantine *Quarantine) error {
+	w, err := os.OpenFile(pullPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create file to pull image: %v", err)
+	}
+	defer w.Close()
+
+	h := sha256.New()
+	offset, err := io.Copy(h, w)
+	if err != nil {
+		return fmt.Errorf("could not read partial blob: %v", err)
+	}
+	if offset > layer.Size {
+		if offset, err = restartBlob(w, h); err != nil {
+			return err
+		}
+	}
+	if offset != 0 {
+		glog.V(2).Infof("Resuming download of blob %s from %d of %d bytes", layer.Digest, offset, layer.Size)
+	}
+
+	failures := 0
+	for offset < layer.Size {
+		body, start, err := openBlob(ctx, blobURL, auth, offset)
+		if err != nil {
+			return err
+		}
+		if start != offset {
+			glog.V(4).Infof("Registry ignored range request for %s, downloading blob anew", layer.Digest)
+			if offset, err = restartBlob(w, h); err != nil {
+				body.Close()
+				return err
+			}
+		}
+		// read one extra byte to detect blob larger than advertised
+		n, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(throttle.Reader(ctx, host, body), layer.Size-offset+1))
+		body.Close()
+		offset += n
+		if offset > layer.Size {
+			break
+		}
+		if err == nil && offset < layer.Size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil {
+			break
+		}
+		if n == 0 {
+			failures++
+		} else {
+			failures = 0
+		}
+		if ctx.Err() != nil || failures == blobResumeAttempts {
+			return fmt.Errorf("could not download blob: %v", err)
+		}
+		glog.V(2).Infof("Download of blob %s is interrupted at %d of %d bytes, resuming: %v",
+			layer.Digest, offset, layer.Size, err)
+	}
+
+	if offset != layer.Size {
+		err := fmt.Errorf("blob size mismatch: expected %d, got %d", layer.Size, offset)
+		discardBlob(quarantine, pullPath, err)
+		return err
+	}
+	actual := "sha256:" + hex.EncodeToString(h.Sum(nil))
+	if actual != layer.Digest {
+		err := fmt.Errorf("blob digest mismatch: expected %s, got %s", layer.Digest, actual)
+		discardBlob(quarantine, pullPath, err)
+		return err
+	}
+	return nil
+}
+
+// openBlob requests blob content starting from offset. Returned start is
+// the offset content actually starts from, registries that don't support
+// range requests return the whole blob. Caller is responsible for closing body.
+func openBlob(ctx context.Context, blobURL string, auth *k8s.AuthConfig, offset int64) (io.ReadCloser, int64, error) {
+	var header http.Header
+	if offset != 0 {
+		header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+	}
+	resp, err := requestRegistryHeader(ctx, http.MethodGet, blobURL, auth, header)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, 0, nil
+	case http.StatusPartialContent:
+		contentRange := resp.Header.Get("Content-Range")
+		if offset == 0 || !strings.HasPrefix(contentRange, fmt.Sprintf("bytes %d-", offset)) {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("unexpected blob content range %q", contentRange)
+		}
+		return resp.Body, offset, nil
+	default:
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("unexpected blob response status %s", resp.Status)
+	}
+}
+
+// restartBlob drops data downloaded into w so far.
+func restartBlob(w *os.File, h hash.Hash) (int64, error) {
+	h.Reset()
+	if err := w.Truncate(0); err != nil {
+		return 0, fmt.Errorf("could not truncate partial blob: %v", err)
+	}
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("could not truncate partial blob: %v", err)
+	}
+	return 0, nil
+}
+
+// discardBlob moves blob that doesn't match its descriptor
+// into quarantine, so that it is not resumed.
+func discardBlob(quarantine *Quarantine, path string, reason error) {
+	if _, err := quarantine.Add(path, reason.Error()); err != nil {
+		glog.Errorf("Could not discard invalid blob: %v", err)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			glog.Errorf("Could not remove invalid blob %s: %v", path, err)
+		}
+	}
+}
+
+// acquirePartial returns path partial blob with the passed digest is
+// kept at in cacheDir. Empty path is returned when cacheDir is not set
+// or blob is being downloaded by another pull. Returned function must
+// be called once download is finished.
+func acquirePartial(cacheDir, digest string) (string, func()) {
+	if cacheDir == "" {
+		return "", func() {}
+	}
+	busyPartials.Lock()
+	defer busyPartials.Unlock()
+	if busyPartials.digests[digest] {
+		return "", func() {}
+	}
+	busyPartials.digests[digest] = true
+	return partialBlobPath(cacheDir, digest), func() {
+		busyPartials.Lock()
+		delete(busyPartials.digests, digest)
+		busyPartials.Unlock()
+	}
+}
+
+// partialBlobPath returns path to partial blob with the passed digest.
+func partialBlobPath(cacheDir, digest string) string {
+	return filepath.Join(cacheDir, partialBlobsDir, strings.TrimPrefix(digest, "sha256:"))
+}
+
+// prunePartials removes partial blobs that were not resumed for partialBlobTTL.
+func prunePartials(cacheDir string) {
+	dir := filepath.Join(cacheDir, partialBlobsDir)
+	fii, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Errorf("Could not read partial blobs: %v", err)
+		}
+		return
+	}
+
+	busyPartials.Lock()
+	defer busyPartials.Unlock()
+	for _, fi := range fii {
+		if busyPartials.digests["sha256:"+fi.Name()] || time.Since(fi.ModTime()) < partialBlobTTL {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		glog.V(4).Infof("Removing stale partial blob %s", path)
+		if err := os.Remove(path); err != nil {
+			glog.Errorf("Could not remove %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadBlob(t *testing.T) {
+	blob := bytes.Repeat([]byte("layer data "), 1000)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	var (
+		mu          sync.Mutex
+		ranges      []string
+		interrupt   bool
+		ignoreRange bool
+	)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		offset := 0
+		if rng := r.Header.Get("Range"); rng != "" && !ignoreRange {
+			offset, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(blob)-1, len(blob)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(blob)-offset))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+		}
+		if interrupt {
+			// connection is closed before advertised content is sent
+			interrupt = false
+			w.Write(blob[offset : offset+(len(blob)-offset)/2])
+			return
+		}
+		w.Write(blob[offset:])
+	}))
+	defer srv.Close()
+
+	defaultClient := registryClient
+	registryClient = srv.Client()
+	defer func() { registryClient = defaultClient }()
+
+	dir, err := ioutil.TempDir("", "download-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tt := []struct {
+		name         string
+		partial      []byte
+		interrupt    bool
+		ignoreRange  bool
+		expectRanges []string
+		expectError  bool
+	}{
+		{
+			name:         "whole blob",
+			expectRanges: []string{""},
+		},
+		{
+			name:         "interrupted download",
+			interrupt:    true,
+			expectRanges: []string{"", fmt.Sprintf("bytes=%d-", len(blob)/2)},
+		},
+		{
+			name:         "partial blob",
+			partial:      blob[:100],
+			expectRanges: []string{"bytes=100-"},
+		},
+		{
+			name:         "partial blob without range support",
+			partial:      blob[:100],
+			ignoreRange:  true,
+			expectRanges: []string{"bytes=100-"},
+		},
+		{
+			name:         "complete partial blob",
+			partial:      blob,
+			expectRanges: nil,
+		},
+		{
+			name:         "corrupted partial blob",
+			partial:      []byte("corrupted"),
+			expectRanges: []string{"bytes=9-"},
+			expectError:  true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, "blob")
+			defer os.Remove(path)
+			if tc.partial != nil {
+				require.NoError(t, ioutil.WriteFile(path, tc.partial, 0644))
+			}
+			mu.Lock()
+			ranges, interrupt, ignoreRange = nil, tc.interrupt, tc.ignoreRange
+			mu.Unlock()
+
+			layer := &descriptor{Digest: digest, Size: int64(len(blob))}
+			err := downloadBlob(context.Background(), srv.URL+"/v2/test/app/blobs/"+digest, nil, layer, path, nil, "", nil)
+			require.Equal(t, tc.expectRanges, ranges)
+			if tc.expectError {
+				require.Error(t, err)
+				_, err = os.Stat(path)
+				require.True(t, os.IsNotExist(err), "invalid blob must be removed")
+				return
+			}
+			require.NoError(t, err)
+			data, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			require.Equal(t, blob, data)
+		})
+	}
+}
+
+func TestPull_ConcurrentDownloads(t *testing.T) {
+	config := []byte(`{"config":{}}`)
+	blobDigest := func(data []byte) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	}
+	blobs := map[string][]byte{blobDigest(config): config}
+	var layers []string
+	for i := 0; i < 6; i++ {
+		layer := []byte(fmt.Sprintf("layer %d", i))
+		blobs[blobDigest(layer)] = layer
+		layers = append(layers, fmt.Sprintf(`{"digest":%q,"size":%d}`, blobDigest(layer), len(layer)))
+	}
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":%q,"size":%d},"layers":[%s]}`,
+		blobDigest(config), len(config), strings.Join(layers, ","))
+
+	var (
+		mu              sync.Mutex
+		active, maxSeen int
+		ranges          []string
+	)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/test/app/manifests/1.0" {
+			fmt.Fprint(w, manifest)
+			return
+		}
+		blob, ok := blobs[strings.TrimPrefix(r.URL.Path, "/v2/test/app/blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		active++
+		if active > maxSeen {
+			maxSeen = active
+		}
+		if rng := r.Header.Get("Range"); rng != "" {
+			ranges = append(ranges, rng)
+		}
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		if rng := r.Header.Get("Range"); rng != "" {
+			offset, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(blob)-1, len(blob)))
+			w.WriteHeader(http.StatusPartialContent)
+			blob = blob[offset:]
+		}
+		w.Write(blob)
+	}))
+	defer srv.Close()
+
+	defaultClient := registryClient
+	registryClient = srv.Client()
+	defer func() { registryClient = defaultClient }()
+
+	dir, err := ioutil.TempDir("", "download-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+
+	// pretend the first layer was partially downloaded by interrupted pull
+	first := []byte("layer 0")
+	partial := partialBlobPath(cacheDir, blobDigest(first))
+	require.NoError(t, os.MkdirAll(filepath.Dir(partial), 0755))
+	require.NoError(t, ioutil.WriteFile(partial, first[:3], 0644))
+
+	ref, err := ParseRef(strings.TrimPrefix(srv.URL, "https://") + "/test/app:1.0")
+	require.NoError(t, err)
+	info, err := Pull(context.Background(), dir, ref, nil,
+		WithOCILayout(), WithConcurrentDownloads(2), WithCacheDir(cacheDir))
+	require.NoError(t, err)
+	require.Equal(t, 2, maxSeen, "downloads must be limited")
+	require.Equal(t, []string{"bytes=3-"}, ranges, "partial blob must be resumed")
+	_, err = os.Stat(partial)
+	require.True(t, os.IsNotExist(err), "partial blob must be moved into layout")
+
+	paths, err := LayoutLayers(info.Path)
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(paths[0])
+	require.NoError(t, err)
+	require.Equal(t, first, data)
+}
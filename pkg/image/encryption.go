@@ -0,0 +1,142 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// IsEncryptedSIF checks whether root filesystem partition of SIF image at path
+// is encrypted. Anything that is not a SIF file, e.g. OCI layout directory,
+// is reported as not encrypted.
+func IsEncryptedSIF(path string) (bool, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if fi.IsDir() {
+		return false, nil
+	}
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return false, nil
+	}
+	defer fimg.UnloadContainer()
+
+	part, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return false, nil
+	}
+	fsType, err := part.GetFsType()
+	if err != nil {
+		return false, fmt.Errorf("could not read root filesystem type: %v", err)
+	}
+	return fsType == sif.FsEncryptedSquashfs, nil
+}
+
+// DecryptSIFKey decrypts the key root filesystem of SIF image at path is
+// encrypted with. The key is stored in the image as a cryptographic message
+// encrypted with RSA-OAEP for the owner of privateKey, which is PEM encoded.
+// Returned key is never written anywhere, callers should wipe it after use.
+func DecryptSIFKey(path string, privateKey []byte) ([]byte, error) {
+	key, err := parseRSAPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return nil, fmt.Errorf("could not load SIF image: %v", err)
+	}
+	defer fimg.UnloadContainer()
+
+	msg, err := cryptoMessage(&fimg)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, msg, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt image key: %v", err)
+	}
+	return plaintext, nil
+}
+
+// cryptoMessage returns encrypted key found in SIF image. Message linked to
+// the primary partition is preferred, PEM encoded messages are unwrapped.
+func cryptoMessage(fimg *sif.FileImage) ([]byte, error) {
+	var msg *sif.Descriptor
+	part, _, err := fimg.GetPartPrimSys()
+	if err == nil {
+		linked, _, err := fimg.GetLinkedDescrsByType(part.ID, sif.DataCryptoMessage)
+		if err == nil {
+			msg = linked[0]
+		}
+	}
+	for i := 0; msg == nil && i < len(fimg.DescrArr); i++ {
+		if fimg.DescrArr[i].Used && fimg.DescrArr[i].Datatype == sif.DataCryptoMessage {
+			msg = &fimg.DescrArr[i]
+		}
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("no encrypted key found in SIF image")
+	}
+
+	if mt, err := msg.GetMessageType(); err != nil || mt != sif.MessageRSAOAEP {
+		return nil, fmt.Errorf("unsupported image key message type")
+	}
+	data := msg.GetData(fimg)
+	if ft, _ := msg.GetFormatType(); ft == sif.FormatPEM {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("could not decode image key message")
+		}
+		data = block.Bytes
+		var ciphertext []byte
+		if _, err := asn1.Unmarshal(data, &ciphertext); err == nil {
+			data = ciphertext
+		}
+	}
+	return data, nil
+}
+
+// parseRSAPrivateKey parses PEM encoded RSA private key
+// stored either in PKCS #1 or in PKCS #8 form.
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("could not decode PEM private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %v", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// writeTestSIF creates SIF with a fake root filesystem of fsType and
+// optional cryptographic message linked to it.
+func writeTestSIF(t *testing.T, path string, fsType sif.Fstype, msg []byte) {
+	part := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Data:     []byte("not really squashfs"),
+		Size:     19,
+	}
+	require.NoError(t, part.SetPartExtra(fsType, sif.PartPrimSys, sif.HdrArchAMD64))
+	inputs := []sif.DescriptorInput{part}
+	if msg != nil {
+		crypto := sif.DescriptorInput{
+			Datatype: sif.DataCryptoMessage,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     1,
+			Data:     msg,
+			Size:     int64(len(msg)),
+		}
+		require.NoError(t, crypto.SetCryptoMsgExtra(sif.FormatPEM, sif.MessageRSAOAEP))
+		inputs = append(inputs, crypto)
+	}
+	_, err := sif.CreateContainer(sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: inputs,
+	})
+	require.NoError(t, err)
+}
+
+func TestDecryptSIFKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypted-sif-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(other)})
+
+	passphrase := []byte("image passphrase")
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.PublicKey, passphrase, nil)
+	require.NoError(t, err)
+	asn1Msg, err := asn1.Marshal(ciphertext)
+	require.NoError(t, err)
+	msg := pem.EncodeToMemory(&pem.Block{Type: "MESSAGE", Bytes: asn1Msg})
+
+	encrypted := filepath.Join(dir, "encrypted.sif")
+	writeTestSIF(t, encrypted, sif.FsEncryptedSquashfs, msg)
+	plain := filepath.Join(dir, "plain.sif")
+	writeTestSIF(t, plain, sif.FsSquash, nil)
+
+	ok, err := IsEncryptedSIF(encrypted)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = IsEncryptedSIF(plain)
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = IsEncryptedSIF(dir)
+	require.NoError(t, err)
+	require.False(t, ok, "directory is not encrypted")
+
+	decrypted, err := DecryptSIFKey(encrypted, privatePEM)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(passphrase, decrypted))
+
+	_, err = DecryptSIFKey(encrypted, otherPEM)
+	require.Error(t, err, "key of other owner must not decrypt image key")
+	_, err = DecryptSIFKey(encrypted, []byte("garbage"))
+	require.Error(t, err)
+	_, err = DecryptSIFKey(plain, privatePEM)
+	require.Error(t, err, "plain image has no key")
+}
@@ -28,15 +28,18 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	specs "github.com/opencontainers/image-spec/specs-go/v1"
 	library "github.com/sylabs/scs-library-client/client"
+	"github.com/sylabs/singularity-cri/pkg/fs"
 	"github.com/sylabs/singularity-cri/pkg/rand"
 	"github.com/sylabs/singularity-cri/pkg/singularity"
 	"github.com/sylabs/singularity-cri/pkg/slice"
+	"github.com/sylabs/singularity-cri/pkg/trace"
+	"github.com/sylabs/singularity-cri/pkg/warnings"
 	"github.com/sylabs/singularity/pkg/image"
-	"github.com/sylabs/singularity/pkg/signing"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
@@ -55,17 +58,119 @@ var (
 	ErrNotLibrary = fmt.Errorf("not library image")
 )
 
+// ChecksumError is returned when pulled image content doesn't match
+// the checksum reported by the remote.
+type ChecksumError struct {
+	Ref      string
+	Expected string
+	Actual   string
+}
+
+// Error implements error interface.
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("image %s checksum mismatch: expected sha256:%s, got sha256:%s", e.Ref, e.Expected, e.Actual)
+}
+
 // Info represents image stored on the host filesystem.
 type Info struct {
-	ID        string             `json:"id"`
-	Sha256    string             `json:"sha256"`
-	Size      uint64             `json:"size"`
-	Path      string             `json:"path"`
-	Ref       *Reference         `json:"ref"`
-	OciConfig *specs.ImageConfig `json:"ociConfig,omitempty"`
-
-	mu     sync.RWMutex
-	usedBy []string
+	ID            string             `json:"id"`
+	Sha256        string             `json:"sha256"`
+	PartialSha256 string             `json:"partialSha256,omitempty"`
+	Size          uint64             `json:"size"`
+	Path          string             `json:"path"`
+	Ref           *Reference         `json:"ref"`
+	OciConfig     *specs.ImageConfig `json:"ociConfig,omitempty"`
+	Layers        []Layer            `json:"layers,omitempty"`
+	// Labels are image config labels capped at MaxLabels,
+	// DroppedLabels is the number of labels that did not fit.
+	Labels        map[string]string `json:"labels,omitempty"`
+	DroppedLabels int               `json:"droppedLabels,omitempty"`
+	// Signatures are results of SIF signatures verification.
+	Signatures []SignatureResult `json:"signatures,omitempty"`
+	// StopTimeout is the nonstandard docker image config StopTimeout,
+	// i.e. time in seconds container is given to stop gracefully.
+	StopTimeout int64 `json:"stopTimeout,omitempty"`
+	// SourceFormat is the format image was pulled in, e.g. SourceOrasSIF.
+	SourceFormat string `json:"sourceFormat,omitempty"`
+	// Endpoint is a registry or mirror docker image was fetched from,
+	// EndpointCache when it was served by the pull-through cache.
+	Endpoint string `json:"endpoint,omitempty"`
+	// CreatedAt is time in nanoseconds image file was written at.
+	CreatedAt int64 `json:"createdAt,omitempty"`
+
+	mu       sync.RWMutex
+	usedBy   []string
+	corrupt  string
+	lastUsed time.Time
+	warnings warnings.Buffer
+}
+
+// infoJSON has the same fields as Info but no methods, so that
+// Info can extend its JSON representation without recursion.
+type infoJSON Info
+
+// MarshalJSON implements json.Marshaler. Besides exported fields
+// it includes time image was last used at and recurring warnings.
+func (i *Info) MarshalJSON() ([]byte, error) {
+	var lastUsed int64
+	if t := i.LastUsed(); !t.IsZero() {
+		lastUsed = t.UnixNano()
+	}
+	return json.Marshal(struct {
+		*infoJSON
+		LastUsed int64            `json:"lastUsed,omitempty"`
+		Warnings []warnings.Entry `json:"warnings,omitempty"`
+	}{
+		infoJSON: (*infoJSON)(i),
+		LastUsed: lastUsed,
+		Warnings: i.warnings.Entries(),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (i *Info) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*infoJSON
+		LastUsed int64           `json:"lastUsed,omitempty"`
+		Warnings json.RawMessage `json:"warnings,omitempty"`
+	}{
+		infoJSON: (*infoJSON)(i),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.LastUsed != 0 {
+		i.MarkUsed(time.Unix(0, aux.LastUsed))
+	}
+	if len(aux.Warnings) != 0 {
+		if err := i.warnings.UnmarshalJSON(aux.Warnings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Warnings returns recent distinct warnings about image, e.g. failed
+// integrity checks. They are saved along with image metadata.
+func (i *Info) Warnings() *warnings.Buffer {
+	return &i.warnings
+}
+
+// MarkUsed records time image was used at, e.g. by a new container.
+// This method is thread-safe to use.
+func (i *Info) MarkUsed(t time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.lastUsed = t
+}
+
+// LastUsed returns time image was last used at or zero time
+// if image was not used since it was pulled.
+// This method is thread-safe to use.
+func (i *Info) LastUsed() time.Time {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.lastUsed
 }
 
 // Borrow notifies that image is used by some container and should
@@ -98,39 +203,155 @@ func (i *Info) UsedBy() []string {
 	return usedBy
 }
 
+// PullOption is a type representing functional option for Pull.
+type PullOption func(o *pullOptions)
+
+type pullOptions struct {
+	cacheDir     string
+	scratchDir   string
+	stallTimeout time.Duration
+	throttle     *Throttle
+	progress     *Progress
+	// sifLayer is set when docker reference points to a SIF artifact
+	sifLayer  *descriptor
+	layout    bool
+	downloads int
+
+	quarantine *Quarantine
+}
+
+// WithCacheDir sets directory singularity keeps downloaded docker blobs in,
+// so that blobs are reused by the consequent pulls of any image.
+func WithCacheDir(dir string) PullOption {
+	return func(o *pullOptions) {
+		o.cacheDir = dir
+	}
+}
+
+// WithScratchDir sets directory image is downloaded and converted in before it
+// is moved to its location. Pulled image is copied when directory is on another
+// filesystem. By default image location is used.
+func WithScratchDir(dir string) PullOption {
+	return func(o *pullOptions) {
+		o.scratchDir = dir
+	}
+}
+
+// WithStallTimeout sets time pull may transfer no data for before it is
+// aborted with StallError. Non-positive timeout disables stall detection.
+func WithStallTimeout(timeout time.Duration) PullOption {
+	return func(o *pullOptions) {
+		o.stallTimeout = timeout
+	}
+}
+
+// WithThrottle limits bandwidth of image download.
+// By default download is not limited.
+func WithThrottle(t *Throttle) PullOption {
+	return func(o *pullOptions) {
+		o.throttle = t
+	}
+}
+
+// WithQuarantine makes downloaded blobs that don't match their digest moved
+// into quarantine instead of being removed.
+func WithQuarantine(q *Quarantine) PullOption {
+	return func(o *pullOptions) {
+		o.quarantine = q
+	}
+}
+
 // Pull pulls image referenced by ref and saves it to the passed location.
-func Pull(ctx context.Context, location string, ref *Reference, auth *k8s.AuthConfig) (*Info, error) {
+func Pull(ctx context.Context, location string, ref *Reference, auth *k8s.AuthConfig, opts ...PullOption) (*Info, error) {
+	var o pullOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	if ref.URI() == singularity.LocalFileDomain {
 		info, err := sifInfo(strings.TrimPrefix(ref.tags[0], singularity.LocalFileDomain))
 		if err != nil {
 			return nil, fmt.Errorf("could not fetch local SIF info: %v", err)
 		}
 		info.Ref = ref
+		info.SourceFormat = SourceSIF
 		return info, nil
 	}
 
-	pullPath := filepath.Join(location, "."+rand.GenerateID(64))
+	cached := cachePath(currentRegistries().CacheDir, ref)
+	fromCache := false
+	if cached != "" {
+		_, err := os.Stat(cached)
+		fromCache = err == nil
+	}
+	if !fromCache {
+		o.sifLayer = sifArtifactLayer(ctx, ref, auth)
+	}
+	if fromCache || o.sifLayer != nil || ref.URI() != singularity.DockerDomain {
+		o.layout = false
+	}
+	scratch := o.scratchDir
+	if scratch == "" || o.layout {
+		// layout is not converted, so it is downloaded right into location
+		scratch = location
+	}
+	pullPath := filepath.Join(scratch, "."+rand.GenerateID(64))
 	glog.V(5).Infof("Pulling %s to temporary file %s", ref, pullPath)
 	cleanup := func() {
-		if err := os.Remove(pullPath); err != nil && !os.IsNotExist(err) {
+		if err := os.RemoveAll(pullPath); err != nil {
 			glog.Errorf("Could not remove %s: %v", pullPath, err)
 		}
 	}
 
-	err := pullImage(ctx, ref, auth, pullPath)
+	fetchCtx, span := trace.Start(ctx, "image.fetch")
+	span.SetAttribute("image.ref", ref.String())
+	var endpoint string
+	var err error
+	if fromCache {
+		glog.V(2).Infof("Pulling %s from cache %s", ref, cached)
+		endpoint = EndpointCache
+		err = copyImage(cached, pullPath)
+	} else {
+		endpoint, err = pullImage(fetchCtx, ref, auth, pullPath, o)
+	}
+	span.SetAttribute("image.endpoint", endpoint)
+	span.SetError(err)
+	span.End()
 	if err != nil {
 		cleanup()
+		if ctx.Err() != nil {
+			glog.V(2).Infof("Pull of %s is cancelled, partial download is removed: %v", ref, ctx.Err())
+		}
+		switch err.(type) {
+		case *StallError, *RateLimitError:
+			return nil, err
+		}
 		return nil, fmt.Errorf("could not pull image: %v", err)
 	}
-	info, err := sifInfo(pullPath)
+	imageInfo, kind := sifInfo, "SIF"
+	if o.layout {
+		imageInfo, kind = layoutInfo, "layout"
+	}
+	info, err := imageInfo(pullPath)
 	if err != nil {
 		cleanup()
-		return nil, fmt.Errorf("could not fetch SIF info: %v", err)
+		return nil, fmt.Errorf("could not fetch %s info: %v", kind, err)
+	}
+
+	if cached != "" && !fromCache && !o.layout {
+		glog.V(4).Infof("Caching %s at %s", ref, cached)
+		if err := copyImage(pullPath, cached); err != nil {
+			glog.Warningf("Could not cache %s: %v", ref, err)
+		}
 	}
 
 	path := filepath.Join(location, info.Sha256)
-	glog.V(5).Infof("Renaming %s to %s", pullPath, path)
-	err = os.Rename(pullPath, path)
+	glog.V(5).Infof("Moving %s to %s", pullPath, path)
+	if o.layout {
+		err = replaceLayout(pullPath, path)
+	} else {
+		err = fs.MoveFile(pullPath, path)
+	}
 	if err != nil {
 		cleanup()
 		return nil, fmt.Errorf("could not save pulled image: %v", err)
@@ -138,6 +359,14 @@ func Pull(ctx context.Context, location string, ref *Reference, auth *k8s.AuthCo
 
 	info.Path = path
 	info.Ref = ref
+	info.SourceFormat = sourceFormat(ref, o)
+	if fromCache {
+		info.SourceFormat = SourceSIF
+	}
+	info.Endpoint = endpoint
+	if endpoint != "" {
+		glog.V(2).Infof("Image %s is fetched from %s", ref, endpoint)
+	}
 	return info, nil
 }
 
@@ -180,6 +409,7 @@ func LibraryInfo(ctx context.Context, ref *Reference, auth *k8s.AuthConfig) (*In
 // Remove removes image from the host filesystem. It makes sure
 // no one relies on image file and if this check fails it returns ErrIsUsed error.
 // Local SIF images that were not pulled by CRI are never actually removed.
+// Image file that is already gone, e.g. moved into quarantine, is not an error.
 func (i *Info) Remove() error {
 	if i.Ref.URI() == singularity.LocalFileDomain {
 		return nil
@@ -192,28 +422,52 @@ func (i *Info) Remove() error {
 		return ErrIsUsed
 	}
 
-	err := os.Remove(i.Path)
-	if err != nil {
+	var err error
+	if i.SourceFormat == SourceOCILayout {
+		err = os.RemoveAll(i.Path)
+	} else {
+		err = os.Remove(i.Path)
+	}
+	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("could not remove image: %v", err)
 	}
 	return nil
 }
 
-// Verify verifies image signatures.
-func (i *Info) Verify() error {
-	if i.Ref.URI() == singularity.DockerDomain {
-		return nil
+// MoveTo moves image content to path replacing content that may be stored
+// there already, e.g. corrupted one, and updates i.Path accordingly.
+func (i *Info) MoveTo(path string) error {
+	var err error
+	if i.SourceFormat == SourceOCILayout {
+		err = replaceLayout(i.Path, path)
+	} else {
+		err = fs.MoveFile(i.Path, path)
+	}
+	if err != nil {
+		return err
 	}
+	i.Path = path
+	return nil
+}
 
-	_, _, err := signing.Verify(i.Path, singularity.KeysServer, 0, false, "", false, true)
-	noSignatures := err != nil && strings.Contains(err.Error(), "no signatures found")
-	if noSignatures {
-		glog.V(2).Infof("Image %s is not signed", i.Ref)
+// VerifyChecksum checks that image content matches the expected sha256
+// checksum and returns *ChecksumError if it doesn't. Empty expected
+// checksum is ignored.
+func (i *Info) VerifyChecksum(expected string) error {
+	expected = strings.TrimPrefix(expected, "sha256:")
+	if expected == "" || expected == i.Sha256 {
+		return nil
 	}
-	if err != nil && !noSignatures {
-		return fmt.Errorf("SIF verification failed: %v", err)
+	return &ChecksumError{
+		Ref:      i.Ref.String(),
+		Expected: expected,
+		Actual:   i.Sha256,
 	}
-	return nil
+}
+
+// Verify verifies image signatures with DefaultVerifier.
+func (i *Info) Verify() error {
+	return DefaultVerifier().Verify(context.Background(), i)
 }
 
 // Matches tests image against passed filter and returns true if it matches.
@@ -238,7 +492,25 @@ func (i *Info) Matches(filter *k8s.ImageFilter) bool {
 	return false
 }
 
-func pullImage(ctx context.Context, ref *Reference, auth *k8s.AuthConfig, pullPath string) error {
+// pullImage pulls image referenced by ref to pullPath and returns
+// endpoint docker image is fetched from.
+func pullImage(ctx context.Context, ref *Reference, auth *k8s.AuthConfig, pullPath string, o pullOptions) (endpoint string, err error) {
+	// watch starts stall detection once all paths that grow
+	// during pull are known
+	var stall *stallWatch
+	watch := func(measure func() pullProgress) {
+		if o.stallTimeout > 0 {
+			ctx, stall = watchStall(ctx, o.stallTimeout, measure)
+		}
+	}
+	defer o.progress.stop()
+	defer func() {
+		if stall != nil && stall.Stop() && err != nil {
+			glog.Warningf("Aborting pull of %s: no data transferred for %v", ref, o.stallTimeout)
+			err = &StallError{Ref: ref, Timeout: o.stallTimeout}
+		}
+	}()
+
 	pullURL := strings.TrimPrefix(ref.String(), ref.URI()+"/")
 	switch ref.URI() {
 	case singularity.LibraryDomain:
@@ -248,43 +520,148 @@ func pullImage(ctx context.Context, ref *Reference, auth *k8s.AuthConfig, pullPa
 		}
 		client, err := library.NewClient(config)
 		if err != nil {
-			return fmt.Errorf("could not create library client: %v", err)
+			return "", fmt.Errorf("could not create library client: %v", err)
 		}
 		w, err := os.Create(pullPath)
 		if err != nil {
-			return fmt.Errorf("could not create file to pull image: %v", err)
+			return "", fmt.Errorf("could not create file to pull image: %v", err)
 		}
+		watch(func() pullProgress {
+			return measurePaths(pullPath)
+		})
+		o.progress.track(func() int64 {
+			return measurePaths(pullPath).bytes
+		}, 0)
 		parts := strings.Split(pullURL, ":")
 		// don't check index out of range since we add :latest by default when parsing ref
-		err = client.DownloadImage(ctx, w, runtime.GOARCH, parts[0], parts[1], nil)
+		tw := o.throttle.Writer(ctx, pullHost(ref, auth), w)
+		err = client.DownloadImage(ctx, tw, runtime.GOARCH, parts[0], parts[1], nil)
 		_ = w.Close()
 		if err != nil {
-			return fmt.Errorf("could not pull library image: %v", err)
+			return "", fmt.Errorf("could not pull library image: %v", err)
 		}
 	case singularity.DockerDomain:
-		var errMsg bytes.Buffer
-		if auth.GetServerAddress() != "" {
-			pullURL = fmt.Sprintf("%s/%s", auth.GetServerAddress(), pullURL)
+		if o.layout {
+			// partial blobs of concurrent pulls are counted as well
+			var partials string
+			if o.cacheDir != "" {
+				partials = filepath.Join(o.cacheDir, partialBlobsDir)
+			}
+			watch(func() pullProgress {
+				return measurePaths(pullPath, partials)
+			})
+			return pullLayout(ctx, ref, auth, pullPath, o)
 		}
-		remote := fmt.Sprintf("%s://%s", singularity.DockerProtocol, pullURL)
-		buildCmd := exec.CommandContext(ctx, singularity.RuntimeName, "build", "-F", pullPath, remote)
-		buildCmd.Env = []string{
-			fmt.Sprintf("PATH=%s", os.Getenv("PATH")),
-			// assume auth.Auth is not needed b/c k8s decodes it into username and password,
-			// see https://github.com/kubernetes/kubernetes/blob/master/pkg/credentialprovider/config.go#L284
-			fmt.Sprintf("%s=%s", singularity.EnvDockerUsername, auth.GetUsername()),
-			fmt.Sprintf("%s=%s", singularity.EnvDockerPassword, auth.GetPassword()),
+		if o.sifLayer != nil {
+			watch(func() pullProgress {
+				return measurePaths(pullPath)
+			})
+			o.progress.track(func() int64 {
+				return measurePaths(pullPath).bytes
+			}, o.sifLayer.Size)
+			ep, err := downloadSIF(ctx, ref, auth, o.sifLayer, pullPath, o.throttle, o.quarantine)
+			if err != nil {
+				return "", err
+			}
+			return ep.String(), nil
 		}
-		buildCmd.Stderr = &errMsg
-		buildCmd.Stdout = ioutil.Discard
-		err := buildCmd.Run()
+		// root filesystem is unpacked next to the resulting image
+		// so that unpacking is seen as pull progress
+		tmpDir := pullPath + ".tmp"
+		if err := os.Mkdir(tmpDir, 0700); err != nil {
+			return "", fmt.Errorf("could not create temporary build directory: %v", err)
+		}
+		defer func() {
+			if err := os.RemoveAll(tmpDir); err != nil {
+				glog.Errorf("Could not remove %s: %v", tmpDir, err)
+			}
+		}()
+
+		parsed, err := ref.parsed()
 		if err != nil {
-			return fmt.Errorf("could not build image: %s", &errMsg)
+			return "", err
+		}
+		var errMsg bytes.Buffer
+		output := &countingWriter{w: &errMsg}
+		watch(func() pullProgress {
+			p := measurePaths(pullPath, tmpDir, o.cacheDir)
+			p.output = output.count()
+			return p
+		})
+		if o.cacheDir != "" {
+			// layers are downloaded into cache, so its growth is the
+			// download progress, concurrent pulls are counted as well
+			cached := measurePaths(o.cacheDir).bytes
+			o.progress.track(func() int64 {
+				return measurePaths(o.cacheDir).bytes - cached
+			}, 0)
 		}
+		ep, err := fromEndpoints(ctx, parsed, auth, func(ep Endpoint) error {
+			// image is looked up the same way registry is queried, see registryRepo
+			pullURL := parsed.Familiar()
+			if ep.Mirror {
+				pullURL = ep.image(parsed)
+			} else if auth.GetServerAddress() != "" {
+				pullURL = fmt.Sprintf("%s/%s", auth.GetServerAddress(), pullURL)
+			}
+			errMsg.Reset()
+			args := []string{"build", "-F"}
+			if ep.Insecure {
+				args = append(args, "--nohttps")
+			}
+			remote := fmt.Sprintf("%s://%s", singularity.DockerProtocol, pullURL)
+			// build both downloads layers and converts them to SIF
+			buildCtx, span := trace.Start(ctx, "image.convert")
+			defer span.End()
+			span.SetAttribute("image.remote", remote)
+			buildCmd := exec.CommandContext(buildCtx, singularity.RuntimeName, append(args, pullPath, remote)...)
+			buildCmd.Env = []string{
+				fmt.Sprintf("PATH=%s", os.Getenv("PATH")),
+				// assume auth.Auth is not needed b/c k8s decodes it into username and password,
+				// see https://github.com/kubernetes/kubernetes/blob/master/pkg/credentialprovider/config.go#L284
+				fmt.Sprintf("%s=%s", singularity.EnvDockerUsername, auth.GetUsername()),
+				fmt.Sprintf("%s=%s", singularity.EnvDockerPassword, auth.GetPassword()),
+				fmt.Sprintf("%s=%s", singularity.EnvTmpDir, tmpDir),
+				// helpers run by build must not fall back to /tmp
+				fmt.Sprintf("TMPDIR=%s", tmpDir),
+			}
+			if o.cacheDir != "" {
+				buildCmd.Env = append(buildCmd.Env, fmt.Sprintf("%s=%s", singularity.EnvCacheDir, o.cacheDir))
+			}
+			buildCmd.Env = append(buildCmd.Env, trace.Environ(buildCtx)...)
+			buildCmd.Stderr = output
+			buildCmd.Stdout = ioutil.Discard
+			err := buildCmd.Run()
+			if err != nil && isRateLimitOutput(errMsg.String()) {
+				err = registryLimits.limit(ep.Host, auth, 0)
+			} else if err != nil {
+				err = fmt.Errorf("could not build image: %s", &errMsg)
+			}
+			span.SetError(err)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		return ep.String(), nil
 	default:
-		return fmt.Errorf("unknown image registry: %s", ref.URI())
+		return "", fmt.Errorf("unknown image registry: %s", ref.URI())
 	}
-	return nil
+	return "", nil
+}
+
+// sourceFormat returns the format image referenced by ref was pulled in.
+func sourceFormat(ref *Reference, o pullOptions) string {
+	if ref.URI() != singularity.DockerDomain {
+		return SourceSIF
+	}
+	if o.sifLayer != nil {
+		return SourceOrasSIF
+	}
+	if o.layout {
+		return SourceOCILayout
+	}
+	return SourceOCIImage
 }
 
 func sifInfo(sifPath string) (*Info, error) {
@@ -310,21 +687,52 @@ func sifInfo(sifPath string) (*Info, error) {
 		return nil, fmt.Errorf("could not close pulled image: %v", err)
 	}
 
+	partial, err := partialChecksum(sifPath)
+	if err != nil {
+		return nil, err
+	}
+
 	ociConfig, err := fetchOCIConfig(sifPath)
 	if err != nil {
 		glog.Errorf("Could not fetch OCI config for image %s: %v", sifPath, err)
 	}
+	var stopTimeout int64
+	if ociConfig != nil {
+		stopTimeout = ociConfig.stopTimeout()
+	}
 
-	return &Info{
-		ID:        checksum,
-		Sha256:    checksum,
-		Size:      uint64(fi.Size()),
-		Path:      sifPath,
-		OciConfig: ociConfig,
-	}, nil
+	info := &Info{
+		ID:            checksum,
+		Sha256:        checksum,
+		PartialSha256: partial,
+		Size:          uint64(fi.Size()),
+		Path:          sifPath,
+		StopTimeout:   stopTimeout,
+		CreatedAt:     fi.ModTime().UnixNano(),
+	}
+	if ociConfig != nil {
+		info.OciConfig = &ociConfig.ImageConfig
+	}
+	info.setLabels()
+	return info, nil
+}
+
+// imageConfig is OCI image config extended with nonstandard
+// fields that are commonly set in docker images.
+type imageConfig struct {
+	specs.ImageConfig
+	StopTimeout *int64 `json:"StopTimeout,omitempty"`
+}
+
+// stopTimeout returns image stop timeout in seconds, zero if not set.
+func (c *imageConfig) stopTimeout() int64 {
+	if c.StopTimeout == nil || *c.StopTimeout < 0 {
+		return 0
+	}
+	return *c.StopTimeout
 }
 
-func fetchOCIConfig(imgPath string) (*specs.ImageConfig, error) {
+func fetchOCIConfig(imgPath string) (*imageConfig, error) {
 	const ociConfigSection = "oci-config.json"
 
 	img, err := image.Init(imgPath, false)
@@ -341,7 +749,7 @@ func fetchOCIConfig(imgPath string) (*specs.ImageConfig, error) {
 		return nil, fmt.Errorf("failed to read %s section: %v", ociConfigSection, err)
 	}
 
-	var imgConfig specs.ImageConfig
+	var imgConfig imageConfig
 	err = json.NewDecoder(reader).Decode(&imgConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode %s section: %v", ociConfigSection, err)
@@ -17,11 +17,11 @@ package image
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	specs "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/require"
@@ -99,10 +99,11 @@ func TestPullImage(t *testing.T) {
 				},
 			},
 			expectImage: &Info{
-				ID:     "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba",
-				Sha256: "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba",
-				Size:   672699,
-				Path:   filepath.Join(os.TempDir(), "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba"),
+				ID:            "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba",
+				Sha256:        "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba",
+				PartialSha256: "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba",
+				Size:          672699,
+				Path:          filepath.Join(os.TempDir(), "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba"),
 				Ref: &Reference{
 					uri: singularity.LibraryDomain,
 					digests: []string{
@@ -120,10 +121,11 @@ func TestPullImage(t *testing.T) {
 				},
 			},
 			expectImage: &Info{
-				ID:     "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba",
-				Sha256: "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba",
-				Size:   672699,
-				Path:   filepath.Join(os.TempDir(), "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba"),
+				ID:            "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba",
+				Sha256:        "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba",
+				PartialSha256: "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba",
+				Size:          672699,
+				Path:          filepath.Join(os.TempDir(), "8b5478b0f2962eba3982be245986eb0ea54f5164d90a65c078af5b83147009ba"),
 				Ref: &Reference{
 					uri: singularity.LibraryDomain,
 					tags: []string{
@@ -198,6 +200,7 @@ func TestPullImage(t *testing.T) {
 			if image != nil && tc.ref.URI() == singularity.DockerDomain {
 				image.ID = ""
 				image.Sha256 = ""
+				image.PartialSha256 = ""
 				image.Path = ""
 			}
 			require.Equal(t, tc.expectImage, image, "image mismatch")
@@ -422,11 +425,11 @@ func TestInfo_Remove(t *testing.T) {
 		expectError error
 	}{
 		{
-			name: "non existent file",
+			name: "quarantined file",
 			image: &Info{
 				Path: "/foo/bar",
 			},
-			expectError: fmt.Errorf("could not remove image: remove /foo/bar: no such file or directory"),
+			expectError: nil,
 		},
 		{
 			name: "image is used",
@@ -453,6 +456,49 @@ func TestInfo_Remove(t *testing.T) {
 	}
 }
 
+func TestInfo_VerifyChecksum(t *testing.T) {
+	ref, err := ParseRef("library://sashayakovtseva/test/busybox:1.28")
+	require.NoError(t, err)
+	img := &Info{
+		Sha256: "8b7fb2b8e25a1ecc8da8879c5d3ab7df2bd4d7c7343b7d1973095afa7bdc1b56",
+		Ref:    ref,
+	}
+
+	tt := []struct {
+		name        string
+		expected    string
+		expectError error
+	}{
+		{
+			name: "no checksum",
+		},
+		{
+			name:     "matching checksum",
+			expected: "8b7fb2b8e25a1ecc8da8879c5d3ab7df2bd4d7c7343b7d1973095afa7bdc1b56",
+		},
+		{
+			name:     "matching prefixed checksum",
+			expected: "sha256:8b7fb2b8e25a1ecc8da8879c5d3ab7df2bd4d7c7343b7d1973095afa7bdc1b56",
+		},
+		{
+			name:     "checksum mismatch",
+			expected: "165768770ca428e9e6d8290d5672652773edf1f80d442252a0ec737ed2cc312c",
+			expectError: &ChecksumError{
+				Ref:      ref.String(),
+				Expected: "165768770ca428e9e6d8290d5672652773edf1f80d442252a0ec737ed2cc312c",
+				Actual:   "8b7fb2b8e25a1ecc8da8879c5d3ab7df2bd4d7c7343b7d1973095afa7bdc1b56",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := img.VerifyChecksum(tc.expected)
+			require.Equal(t, tc.expectError, err)
+		})
+	}
+}
+
 func TestInfo_Matches(t *testing.T) {
 	tt := []struct {
 		name   string
@@ -627,6 +673,29 @@ func TestInfo_UnmarshalJSON(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "last used",
+			input: `
+				{
+					"id":"0d408f32cc56b16509f30ae3dfa56ffb01269b2100036991d49af645a7b717a0",
+					"size":741376,
+					"ref":{
+						"uri":"docker.io",
+						"tags":["busybox:1.28"],
+						"digests":null
+					},
+					"lastUsed":1558000000000000000
+				}`,
+			expect: &Info{
+				ID:   "0d408f32cc56b16509f30ae3dfa56ffb01269b2100036991d49af645a7b717a0",
+				Size: 741376,
+				Ref: &Reference{
+					uri:  singularity.DockerDomain,
+					tags: []string{"busybox:1.28"},
+				},
+				lastUsed: time.Unix(0, 1558000000000000000),
+			},
+		},
 	}
 
 	for _, tc := range tt {
@@ -707,6 +776,31 @@ func TestInfo_MarshalJSON(t *testing.T) {
 					}
 				}`,
 		},
+		{
+			name: "last used",
+			input: &Info{
+				ID:   "0d408f32cc56b16509f30ae3dfa56ffb01269b2100036991d49af645a7b717a0",
+				Size: 741376,
+				Ref: &Reference{
+					uri:  singularity.DockerDomain,
+					tags: []string{"busybox:1.28"},
+				},
+				lastUsed: time.Unix(0, 1558000000000000000),
+			},
+			expect: `
+				{
+					"id":"0d408f32cc56b16509f30ae3dfa56ffb01269b2100036991d49af645a7b717a0",
+					"sha256":"",
+					"size":741376,
+					"path":"",
+					"ref":{
+						"uri":"docker.io",
+						"tags":["busybox:1.28"],
+						"digests":null
+					},
+					"lastUsed":1558000000000000000
+				}`,
+		},
 	}
 
 	for _, tc := range tt {
@@ -717,3 +811,59 @@ func TestInfo_MarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestInfo_Warnings(t *testing.T) {
+	info := &Info{
+		ID: "0d408f32cc56b16509f30ae3dfa56ffb01269b2100036991d49af645a7b717a0",
+		Ref: &Reference{
+			uri:  singularity.DockerDomain,
+			tags: []string{"busybox:1.28"},
+		},
+	}
+	info.Warnings().Add("could not verify image %s: %v", info.ID, "read error")
+	info.Warnings().Add("could not verify image %s: %v", info.ID, "read error")
+
+	data, err := json.Marshal(info)
+	require.NoError(t, err, "could not marshal image")
+
+	var actual *Info
+	require.NoError(t, json.Unmarshal(data, &actual), "could not unmarshal image")
+	entries := actual.Warnings().Entries()
+	require.Len(t, entries, 1)
+	require.Equal(t, uint64(2), entries[0].Count)
+	require.Equal(t, "could not verify image "+info.ID+": read error", entries[0].Message)
+}
+
+func TestImageConfig_StopTimeout(t *testing.T) {
+	tt := []struct {
+		name         string
+		config       string
+		expect       int64
+		expectSignal string
+	}{
+		{
+			name:         "not set",
+			config:       `{"StopSignal":"SIGQUIT"}`,
+			expectSignal: "SIGQUIT",
+		},
+		{
+			name:         "set",
+			config:       `{"StopSignal":"SIGQUIT","StopTimeout":45}`,
+			expect:       45,
+			expectSignal: "SIGQUIT",
+		},
+		{
+			name:   "negative",
+			config: `{"StopTimeout":-1}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var config imageConfig
+			require.NoError(t, json.Unmarshal([]byte(tc.config), &config))
+			require.Equal(t, tc.expect, config.stopTimeout())
+			require.Equal(t, tc.expectSignal, config.StopSignal)
+		})
+	}
+}
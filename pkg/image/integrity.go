@@ -0,0 +1,178 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+)
+
+// partialHashChunk is a size of image head and tail that are hashed
+// to quickly detect truncated or damaged image files.
+const partialHashChunk = 1 << 20
+
+// CorruptError is returned when image file on the host
+// doesn't match what was stored at pull time.
+type CorruptError struct {
+	ID     string
+	Reason string
+}
+
+// Error implements error interface.
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("image %s is corrupted: %s", e.ID, e.Reason)
+}
+
+// VerifyIntegrity checks that image file still matches the size and partial
+// checksum recorded at pull time. When full is true, or no partial checksum is
+// known, the whole file is hashed instead. Local SIF images that were not
+// pulled by CRI are never checked. Mismatch is reported with *CorruptError.
+func (i *Info) VerifyIntegrity(full bool) error {
+	if i.Ref.URI() == singularity.LocalFileDomain {
+		return nil
+	}
+	if i.SourceFormat == SourceOCILayout {
+		return i.verifyLayout(full)
+	}
+
+	fi, err := os.Stat(i.Path)
+	if os.IsNotExist(err) {
+		return &CorruptError{ID: i.ID, Reason: "image file is missing"}
+	}
+	if err != nil {
+		return fmt.Errorf("could not stat image file: %v", err)
+	}
+	if uint64(fi.Size()) != i.Size {
+		return &CorruptError{
+			ID:     i.ID,
+			Reason: fmt.Sprintf("expected size %d, got %d", i.Size, fi.Size()),
+		}
+	}
+
+	if !full && i.PartialSha256 != "" {
+		partial, err := partialChecksum(i.Path)
+		if err != nil {
+			return err
+		}
+		if partial != i.PartialSha256 {
+			return &CorruptError{ID: i.ID, Reason: "partial checksum mismatch"}
+		}
+		return nil
+	}
+
+	checksum, err := fileChecksum(i.Path)
+	if err != nil {
+		return err
+	}
+	if checksum != i.Sha256 {
+		return &CorruptError{
+			ID:     i.ID,
+			Reason: fmt.Sprintf("expected sha256:%s, got sha256:%s", i.Sha256, checksum),
+		}
+	}
+	return nil
+}
+
+// Quarantine moves corrupted image content into quarantine, so that image
+// may be pulled again while the damaged content is kept for inspection. Image
+// that is in use is not quarantined and ErrIsUsed is returned. Name content is
+// kept under in quarantine directory is returned, see Quarantine.Add. Content
+// that is already gone is not an error, empty name is returned then.
+func (i *Info) Quarantine(q *Quarantine, reason string) (string, error) {
+	if i.Ref.URI() == singularity.LocalFileDomain {
+		return "", fmt.Errorf("local SIF images are not stored by CRI")
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if len(i.usedBy) > 0 {
+		return "", ErrIsUsed
+	}
+	if _, err := os.Lstat(i.Path); os.IsNotExist(err) {
+		return "", nil
+	}
+	return q.Add(i.Path, fmt.Sprintf("image %s: %s", i.ID, reason))
+}
+
+// MarkCorrupt marks image as corrupted so that it
+// is pulled again instead of being reused.
+func (i *Info) MarkCorrupt(reason string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.corrupt = reason
+}
+
+// ClearCorrupt removes corrupted mark from the image.
+func (i *Info) ClearCorrupt() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.corrupt = ""
+}
+
+// Corrupt returns reason image was marked as corrupted
+// or an empty string if image is considered intact.
+func (i *Info) Corrupt() string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.corrupt
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("could not open image file: %v", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("could not get image checksum: %v", err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// partialChecksum hashes image head and tail chunks. Images that
+// are smaller than two chunks are hashed completely.
+func partialChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("could not open image file: %v", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("could not stat image file: %v", err)
+	}
+
+	h := sha256.New()
+	if fi.Size() <= 2*partialHashChunk {
+		_, err = io.Copy(h, f)
+	} else {
+		_, err = io.CopyN(h, f, partialHashChunk)
+		if err == nil {
+			_, err = io.Copy(h, io.NewSectionReader(f, fi.Size()-partialHashChunk, partialHashChunk))
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not get image partial checksum: %v", err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+)
+
+func TestInfo_VerifyIntegrity(t *testing.T) {
+	content := bytes.Repeat([]byte("singularity"), 3*partialHashChunk/10)
+
+	f, err := ioutil.TempFile("", "")
+	require.NoError(t, err, "could not create temp image file")
+	defer os.Remove(f.Name())
+	_, err = f.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	checksum, err := fileChecksum(f.Name())
+	require.NoError(t, err)
+	partial, err := partialChecksum(f.Name())
+	require.NoError(t, err)
+	require.NotEqual(t, checksum, partial)
+
+	dockerRef := &Reference{uri: singularity.DockerDomain, tags: []string{"busybox:latest"}}
+	tt := []struct {
+		name          string
+		info          *Info
+		full          bool
+		expectCorrupt bool
+	}{
+		{
+			name: "intact image",
+			info: &Info{
+				ID:            "intact",
+				Sha256:        checksum,
+				PartialSha256: partial,
+				Size:          uint64(len(content)),
+				Path:          f.Name(),
+				Ref:           dockerRef,
+			},
+		},
+		{
+			name: "intact image full check",
+			info: &Info{
+				ID:            "intact",
+				Sha256:        checksum,
+				PartialSha256: partial,
+				Size:          uint64(len(content)),
+				Path:          f.Name(),
+				Ref:           dockerRef,
+			},
+			full: true,
+		},
+		{
+			name: "truncated image",
+			info: &Info{
+				ID:            "truncated",
+				Sha256:        checksum,
+				PartialSha256: partial,
+				Size:          uint64(len(content) + 1),
+				Path:          f.Name(),
+				Ref:           dockerRef,
+			},
+			expectCorrupt: true,
+		},
+		{
+			name: "damaged tail",
+			info: &Info{
+				ID:            "damaged",
+				Sha256:        checksum,
+				PartialSha256: "bad",
+				Size:          uint64(len(content)),
+				Path:          f.Name(),
+				Ref:           dockerRef,
+			},
+			expectCorrupt: true,
+		},
+		{
+			name: "damaged middle without partial checksum",
+			info: &Info{
+				ID:     "damaged",
+				Sha256: "bad",
+				Size:   uint64(len(content)),
+				Path:   f.Name(),
+				Ref:    dockerRef,
+			},
+			expectCorrupt: true,
+		},
+		{
+			name: "missing file",
+			info: &Info{
+				ID:   "missing",
+				Path: "/foo/bar",
+				Ref:  dockerRef,
+			},
+			expectCorrupt: true,
+		},
+		{
+			name: "local SIF is not checked",
+			info: &Info{
+				ID:   "local",
+				Path: "/foo/bar",
+				Ref:  &Reference{uri: singularity.LocalFileDomain, tags: []string{"local.file/foo/bar"}},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.info.VerifyIntegrity(tc.full)
+			if !tc.expectCorrupt {
+				require.NoError(t, err)
+				return
+			}
+			require.IsType(t, &CorruptError{}, err)
+		})
+	}
+}
+
+func TestInfo_MarkCorrupt(t *testing.T) {
+	var info Info
+	require.Empty(t, info.Corrupt())
+	info.MarkCorrupt("truncated")
+	require.Equal(t, "truncated", info.Corrupt())
+	info.ClearCorrupt()
+	require.Empty(t, info.Corrupt())
+}
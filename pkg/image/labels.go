@@ -0,0 +1,60 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"sort"
+)
+
+// MaxLabels limits number of image labels kept with image metadata
+// so that images with huge label sets do not bloat status responses.
+const MaxLabels = 64
+
+// setLabels keeps up to MaxLabels image config labels, the first ones
+// in key order, and counts the dropped ones.
+func (i *Info) setLabels() {
+	i.Labels = nil
+	i.DroppedLabels = 0
+	if i.OciConfig == nil || len(i.OciConfig.Labels) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(i.OciConfig.Labels))
+	for k := range i.OciConfig.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > MaxLabels {
+		i.DroppedLabels = len(keys) - MaxLabels
+		keys = keys[:MaxLabels]
+	}
+	i.Labels = make(map[string]string, len(keys))
+	for _, k := range keys {
+		i.Labels[k] = i.OciConfig.Labels[k]
+	}
+}
+
+// ExposedPorts returns ports image config exposes sorted,
+// e.g. 80/tcp, 53/udp.
+func (i *Info) ExposedPorts() []string {
+	if i.OciConfig == nil || len(i.OciConfig.ExposedPorts) == 0 {
+		return nil
+	}
+	ports := make([]string, 0, len(i.OciConfig.ExposedPorts))
+	for port := range i.OciConfig.ExposedPorts {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	return ports
+}
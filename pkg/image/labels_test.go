@@ -0,0 +1,80 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package image
+
+import (
+	"fmt"
+	"testing"
+
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfo_SetLabels(t *testing.T) {
+	many := make(map[string]string, MaxLabels+2)
+	for i := 0; i < MaxLabels+2; i++ {
+		many[fmt.Sprintf("label%03d", i)] = "value"
+	}
+
+	tt := []struct {
+		name          string
+		config        *specs.ImageConfig
+		expectLabels  int
+		expectDropped int
+		expectKept    string
+	}{
+		{
+			name: "no config",
+		},
+		{
+			name:         "few labels",
+			config:       &specs.ImageConfig{Labels: map[string]string{"foo": "bar"}},
+			expectLabels: 1,
+			expectKept:   "foo",
+		},
+		{
+			name:          "too many labels",
+			config:        &specs.ImageConfig{Labels: many},
+			expectLabels:  MaxLabels,
+			expectDropped: 2,
+			expectKept:    "label000",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			info := &Info{OciConfig: tc.config}
+			info.setLabels()
+			require.Len(t, info.Labels, tc.expectLabels)
+			require.Equal(t, tc.expectDropped, info.DroppedLabels)
+			if tc.expectKept != "" {
+				require.Contains(t, info.Labels, tc.expectKept)
+			}
+		})
+	}
+}
+
+func TestInfo_ExposedPorts(t *testing.T) {
+	info := &Info{}
+	require.Nil(t, info.ExposedPorts())
+
+	info.OciConfig = &specs.ImageConfig{
+		ExposedPorts: map[string]struct{}{
+			"8080/tcp": {},
+			"53/udp":   {},
+			"443/tcp":  {},
+		},
+	}
+	require.Equal(t, []string{"443/tcp", "53/udp", "8080/tcp"}, info.ExposedPorts())
+}
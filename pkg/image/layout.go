@@ -0,0 +1,416 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+	"github.com/sylabs/singularity-cri/pkg/rand"
+	"github.com/sylabs/singularity-cri/pkg/reference"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// Names of OCI image layout entries, see
+// https://github.com/opencontainers/image-spec/blob/master/image-layout.md
+const (
+	layoutVersionFile = "oci-layout"
+	layoutIndexFile   = "index.json"
+	layoutBlobsDir    = "blobs"
+)
+
+// docker media types pulled images may use and their OCI counterparts
+// that are stored in layout instead, so that layout is readable by OCI tools.
+var layoutMediaTypes = map[string]string{
+	"application/vnd.docker.container.image.v1+json":            specs.MediaTypeImageConfig,
+	"application/vnd.docker.image.rootfs.diff.tar":              specs.MediaTypeImageLayer,
+	"application/vnd.docker.image.rootfs.diff.tar.gzip":         specs.MediaTypeImageLayerGzip,
+	"application/vnd.docker.image.rootfs.foreign.diff.tar.gzip": specs.MediaTypeImageLayerNonDistributableGzip,
+}
+
+// layoutDigestRe matches digests blobs are stored by. Digests come from
+// registry manifests and are used in paths, so nothing else is accepted.
+var layoutDigestRe = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// layoutIndex is OCI image layout index.json.
+type layoutIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Manifests     []descriptor `json:"manifests"`
+}
+
+// layoutManifest is OCI image manifest stored in layout.
+type layoutManifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        descriptor   `json:"config"`
+	Layers        []descriptor `json:"layers"`
+}
+
+// WithOCILayout makes docker images stored in OCI image layout as they
+// are downloaded instead of being converted into SIF. Such images take
+// no conversion time and space, their layers are unpacked into root
+// filesystem when image is first used instead, see LayoutLayers.
+// SIF artifacts and images served by the pull-through cache are still
+// stored as SIF.
+func WithOCILayout() PullOption {
+	return func(o *pullOptions) {
+		o.layout = true
+	}
+}
+
+// pullLayout downloads manifest, config and layers of docker image referenced
+// by ref into OCI image layout at pullPath. Blobs are downloaded concurrently,
+// see WithConcurrentDownloads, and each is verified against its digest and
+// size. Endpoint the last blob is downloaded from is returned.
+func pullLayout(ctx context.Context, ref *Reference, auth *k8s.AuthConfig, pullPath string, o pullOptions) (string, error) {
+	parsed, err := ref.parsed()
+	if err != nil {
+		return "", err
+	}
+	m, err := remoteManifest(ctx, ref, auth)
+	if err != nil {
+		return "", err
+	}
+	if len(m.Layers) == 0 {
+		return "", fmt.Errorf("manifest has no layers")
+	}
+	stored := layoutManifest{
+		SchemaVersion: 2,
+		MediaType:     specs.MediaTypeImageManifest,
+		Config:        layoutDescriptor(m.Config),
+	}
+	total := m.Config.Size
+	for _, layer := range m.Layers {
+		stored.Layers = append(stored.Layers, layoutDescriptor(layer))
+		total += layer.Size
+	}
+	blobs := append([]descriptor{m.Config}, m.Layers...)
+	paths := []string{pullPath}
+	if o.cacheDir != "" {
+		prunePartials(o.cacheDir)
+		for _, blob := range blobs {
+			paths = append(paths, partialBlobPath(o.cacheDir, blob.Digest))
+		}
+	}
+	o.progress.track(func() int64 {
+		return measurePaths(paths...).bytes
+	}, total)
+
+	if err := os.MkdirAll(filepath.Join(pullPath, layoutBlobsDir, "sha256"), 0755); err != nil {
+		return "", fmt.Errorf("could not create layout: %v", err)
+	}
+	if o.cacheDir != "" {
+		if err := os.MkdirAll(filepath.Join(o.cacheDir, partialBlobsDir), 0755); err != nil {
+			return "", fmt.Errorf("could not create partial blobs directory: %v", err)
+		}
+	}
+	for _, blob := range blobs {
+		if !layoutDigestRe.MatchString(blob.Digest) {
+			return "", fmt.Errorf("unsupported blob digest %q", blob.Digest)
+		}
+	}
+
+	// blobs are downloaded concurrently, the first failure cancels the rest
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	downloads := o.downloads
+	if downloads <= 0 {
+		downloads = DefaultConcurrentDownloads
+	}
+	slots := make(chan struct{}, downloads)
+	errs := make(chan error, len(blobs))
+	var mu sync.Mutex
+	var endpoint Endpoint
+	var wg sync.WaitGroup
+	for _, blob := range blobs {
+		wg.Add(1)
+		go func(blob descriptor) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-slots }()
+
+			ep, err := pullLayoutBlob(ctx, ref, parsed, auth, blob, pullPath, o)
+			if err != nil {
+				errs <- err
+				cancel()
+				return
+			}
+			mu.Lock()
+			endpoint = ep
+			mu.Unlock()
+		}(blob)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	manifest, err := json.Marshal(stored)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal manifest: %v", err)
+	}
+	manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	if err := ioutil.WriteFile(layoutBlobPath(pullPath, manifestDigest), manifest, 0644); err != nil {
+		return "", fmt.Errorf("could not write manifest: %v", err)
+	}
+	index, err := json.Marshal(layoutIndex{
+		SchemaVersion: 2,
+		Manifests: []descriptor{{
+			MediaType: specs.MediaTypeImageManifest,
+			Digest:    manifestDigest,
+			Size:      int64(len(manifest)),
+		}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not marshal index: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pullPath, layoutIndexFile), index, 0644); err != nil {
+		return "", fmt.Errorf("could not write index: %v", err)
+	}
+	version := []byte(fmt.Sprintf(`{"imageLayoutVersion":%q}`, specs.ImageLayoutVersion))
+	if err := ioutil.WriteFile(filepath.Join(pullPath, layoutVersionFile), version, 0644); err != nil {
+		return "", fmt.Errorf("could not write layout version: %v", err)
+	}
+	return endpoint.String(), nil
+}
+
+// pullLayoutBlob downloads blob into layout at pullPath. When cache directory
+// is set, blob is downloaded into its partial blobs directory first, so that
+// download interrupted together with pull is resumed by the next pull.
+func pullLayoutBlob(ctx context.Context, ref *Reference, parsed *reference.Reference, auth *k8s.AuthConfig,
+	blob descriptor, pullPath string, o pullOptions) (Endpoint, error) {
+	path := layoutBlobPath(pullPath, blob.Digest)
+	partial, release := acquirePartial(o.cacheDir, blob.Digest)
+	defer release()
+	download := path
+	if partial != "" {
+		download = partial
+	}
+
+	glog.V(4).Infof("Downloading blob %s of %s", blob.Digest, ref)
+	ep, err := fromEndpoints(ctx, parsed, auth, func(ep Endpoint) error {
+		return downloadBlob(ctx, ep.url("blobs", blob.Digest), auth, &blob, download, o.throttle, pullHost(ref, auth), o.quarantine)
+	})
+	if err != nil {
+		return Endpoint{}, err
+	}
+	if partial != "" {
+		if err := fs.MoveFile(partial, path); err != nil {
+			return Endpoint{}, fmt.Errorf("could not move blob into layout: %v", err)
+		}
+	}
+	return ep, nil
+}
+
+// replaceLayout moves layout at from to path replacing layout that may
+// be stored there already, e.g. corrupted one that is pulled again.
+func replaceLayout(from, path string) error {
+	old := filepath.Join(filepath.Dir(path), "."+rand.GenerateID(64))
+	err := os.Rename(path, old)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	replaced := err == nil
+	if err := os.Rename(from, path); err != nil {
+		return err
+	}
+	if replaced {
+		if err := os.RemoveAll(old); err != nil {
+			glog.Errorf("Could not remove replaced layout %s: %v", old, err)
+		}
+	}
+	return nil
+}
+
+// layoutDescriptor returns descriptor d is stored in layout with.
+func layoutDescriptor(d descriptor) descriptor {
+	if mediaType, ok := layoutMediaTypes[d.MediaType]; ok {
+		d.MediaType = mediaType
+	}
+	return d
+}
+
+// layoutBlobPath returns path to blob with the passed digest in layout at dir.
+func layoutBlobPath(dir, digest string) string {
+	return filepath.Join(dir, layoutBlobsDir, "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+// readLayout returns descriptor and content of the only manifest of layout at dir.
+func readLayout(dir string) (descriptor, *layoutManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, layoutIndexFile))
+	if err != nil {
+		return descriptor{}, nil, fmt.Errorf("could not read layout index: %v", err)
+	}
+	var index layoutIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return descriptor{}, nil, fmt.Errorf("could not decode layout index: %v", err)
+	}
+	if len(index.Manifests) != 1 {
+		return descriptor{}, nil, fmt.Errorf("layout has %d manifests, expected 1", len(index.Manifests))
+	}
+	desc := index.Manifests[0]
+	var m layoutManifest
+	if err := readLayoutBlob(dir, desc, &m); err != nil {
+		return descriptor{}, nil, fmt.Errorf("could not read manifest: %v", err)
+	}
+	for _, blob := range append([]descriptor{m.Config}, m.Layers...) {
+		if !layoutDigestRe.MatchString(blob.Digest) {
+			return descriptor{}, nil, fmt.Errorf("unsupported blob digest %q", blob.Digest)
+		}
+	}
+	return desc, &m, nil
+}
+
+// readLayoutBlob decodes JSON blob described by desc.
+func readLayoutBlob(dir string, desc descriptor, v interface{}) error {
+	if !layoutDigestRe.MatchString(desc.Digest) {
+		return fmt.Errorf("unsupported blob digest %q", desc.Digest)
+	}
+	data, err := ioutil.ReadFile(layoutBlobPath(dir, desc.Digest))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// LayoutLayers returns paths to layer blobs of image stored
+// in OCI layout at dir in the order they are applied.
+func LayoutLayers(dir string) ([]string, error) {
+	_, m, err := readLayout(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(m.Layers))
+	for _, layer := range m.Layers {
+		paths = append(paths, layoutBlobPath(dir, layer.Digest))
+	}
+	return paths, nil
+}
+
+// layoutInfo returns info of image stored in OCI layout at dir.
+// Image ID is the digest of its stored manifest.
+func layoutInfo(dir string) (*Info, error) {
+	desc, m, err := readLayout(dir)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Config imageConfig `json:"config"`
+	}
+	if err := readLayoutBlob(dir, m.Config, &config); err != nil {
+		return nil, fmt.Errorf("could not read image config: %v", err)
+	}
+	fi, err := os.Stat(filepath.Join(dir, layoutIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch layout info: %v", err)
+	}
+
+	checksum := strings.TrimPrefix(desc.Digest, "sha256:")
+	info := &Info{
+		ID:           checksum,
+		Sha256:       checksum,
+		Size:         uint64(layoutSize(desc, m)),
+		Path:         dir,
+		OciConfig:    &config.Config.ImageConfig,
+		StopTimeout:  config.Config.stopTimeout(),
+		SourceFormat: SourceOCILayout,
+		CreatedAt:    fi.ModTime().UnixNano(),
+	}
+	info.setLabels()
+	return info, nil
+}
+
+// layoutSize returns total size of layout blobs.
+func layoutSize(desc descriptor, m *layoutManifest) int64 {
+	size := desc.Size + m.Config.Size
+	for _, layer := range m.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+// verifyLayout checks that blobs of image stored in OCI layout still have
+// sizes recorded in its manifest. When full is true, blob digests are checked
+// as well. Mismatch is reported with *CorruptError.
+func (i *Info) verifyLayout(full bool) error {
+	if _, err := os.Stat(i.Path); os.IsNotExist(err) {
+		return &CorruptError{ID: i.ID, Reason: "image layout is missing"}
+	}
+	desc, m, err := readLayout(i.Path)
+	if err != nil {
+		return &CorruptError{ID: i.ID, Reason: err.Error()}
+	}
+	if size := layoutSize(desc, m); uint64(size) != i.Size {
+		return &CorruptError{
+			ID:     i.ID,
+			Reason: fmt.Sprintf("expected size %d, got %d", i.Size, size),
+		}
+	}
+	for _, blob := range append([]descriptor{desc, m.Config}, m.Layers...) {
+		path := layoutBlobPath(i.Path, blob.Digest)
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return &CorruptError{ID: i.ID, Reason: fmt.Sprintf("blob %s is missing", blob.Digest)}
+		}
+		if err != nil {
+			return fmt.Errorf("could not stat blob: %v", err)
+		}
+		if fi.Size() != blob.Size {
+			return &CorruptError{
+				ID:     i.ID,
+				Reason: fmt.Sprintf("blob %s: expected size %d, got %d", blob.Digest, blob.Size, fi.Size()),
+			}
+		}
+		if !full {
+			continue
+		}
+		checksum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		if "sha256:"+checksum != blob.Digest {
+			return &CorruptError{
+				ID:     i.ID,
+				Reason: fmt.Sprintf("blob %s: got sha256:%s", blob.Digest, checksum),
+			}
+		}
+	}
+	if strings.TrimPrefix(desc.Digest, "sha256:") != i.Sha256 {
+		return &CorruptError{
+			ID:     i.ID,
+			Reason: fmt.Sprintf("expected manifest sha256:%s, got %s", i.Sha256, desc.Digest),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPull_OCILayout(t *testing.T) {
+	config := []byte(`{"config":{"Cmd":["/bin/sh"]}}`)
+	layers := [][]byte{[]byte("base layer"), []byte("top layer")}
+	blobDigest := func(data []byte) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	}
+	blobs := map[string][]byte{blobDigest(config): config}
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"config":{"mediaType":"application/vnd.docker.container.image.v1+json","digest":%q,"size":%d},"layers":[`,
+		blobDigest(config), len(config))
+	for i, layer := range layers {
+		if i != 0 {
+			manifest += ","
+		}
+		blobs[blobDigest(layer)] = layer
+		manifest += fmt.Sprintf(`{"mediaType":"application/vnd.docker.image.rootfs.diff.tar.gzip","digest":%q,"size":%d}`,
+			blobDigest(layer), len(layer))
+	}
+	manifest += "]}"
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/test/app/manifests/1.0" {
+			fmt.Fprint(w, manifest)
+			return
+		}
+		if blob, ok := blobs[strings.TrimPrefix(r.URL.Path, "/v2/test/app/blobs/")]; ok {
+			w.Write(blob)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	defaultClient := registryClient
+	registryClient = srv.Client()
+	defer func() { registryClient = defaultClient }()
+
+	dir, err := ioutil.TempDir("", "layout-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	host := strings.TrimPrefix(srv.URL, "https://")
+	ref, err := ParseRef(host + "/test/app:1.0")
+	require.NoError(t, err)
+	info, err := Pull(context.Background(), dir, ref, nil, WithOCILayout())
+	require.NoError(t, err)
+	require.Equal(t, SourceOCILayout, info.SourceFormat)
+	require.Equal(t, info.ID, info.Sha256)
+	require.Equal(t, host, info.Endpoint)
+	require.Equal(t, []string{"/bin/sh"}, info.OciConfig.Cmd)
+
+	paths, err := LayoutLayers(info.Path)
+	require.NoError(t, err)
+	require.Len(t, paths, len(layers))
+	for i, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, layers[i], data)
+	}
+
+	again, err := Pull(context.Background(), dir, ref, nil, WithOCILayout())
+	require.NoError(t, err)
+	require.Equal(t, info.ID, again.ID, "same image must be stored under the same ID")
+
+	require.NoError(t, info.VerifyIntegrity(false))
+	require.NoError(t, info.VerifyIntegrity(true))
+	require.NoError(t, ioutil.WriteFile(paths[1], []byte("tap layer"), 0644))
+	require.NoError(t, info.VerifyIntegrity(false), "quick check must only compare sizes")
+	_, ok := info.VerifyIntegrity(true).(*CorruptError)
+	require.True(t, ok, "modified layer must be detected")
+	require.NoError(t, ioutil.WriteFile(paths[0], []byte("base"), 0644))
+	_, ok = info.VerifyIntegrity(false).(*CorruptError)
+	require.True(t, ok, "truncated layer must be detected")
+
+	require.NoError(t, info.Remove())
+	_, err = os.Stat(info.Path)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/reference"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// EndpointCache is recorded as an endpoint of images
+// served by the pull-through cache.
+const EndpointCache = "cache"
+
+// Mirror is a registry mirror. Host may be followed by a path
+// repositories of the mirrored registry are found under,
+// e.g. harbor.local/dockerhub.
+type Mirror struct {
+	Host string
+	// Insecure makes mirror accessed over plain HTTP.
+	Insecure bool
+}
+
+// RegistryConfig configures how images of a single registry are fetched.
+type RegistryConfig struct {
+	// Mirrors are tried in order before the registry itself.
+	Mirrors []Mirror
+	// Insecure makes registry accessed over plain HTTP.
+	Insecure bool
+}
+
+// Registries configures where docker images are fetched from.
+type Registries struct {
+	// Hosts maps registry domain as found in image references,
+	// e.g. docker.io, to its configuration.
+	Hosts map[string]RegistryConfig
+	// CacheDir is a pull-through cache directory. Pulled images are
+	// stored there by reference and served from there on consequent pulls
+	// without contacting registry, so that the directory may be populated
+	// in advance for air-gapped clusters, e.g. docker.io/library/alpine
+	// image tagged latest is kept at docker.io/library/alpine/latest.sif.
+	CacheDir string
+}
+
+// Validate checks registries configuration.
+func (r *Registries) Validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.CacheDir != "" && !filepath.IsAbs(r.CacheDir) {
+		return fmt.Errorf("cache directory %q is not absolute", r.CacheDir)
+	}
+	for domain, config := range r.Hosts {
+		if domain == "" || strings.ContainsRune(domain, '/') {
+			return fmt.Errorf("invalid registry %q", domain)
+		}
+		if domain != strings.ToLower(domain) {
+			return fmt.Errorf("registry %q must be lowercase", domain)
+		}
+		for _, m := range config.Mirrors {
+			if m.Host == "" {
+				return fmt.Errorf("registry %s: empty mirror host", domain)
+			}
+			if strings.Contains(m.Host, "://") {
+				return fmt.Errorf("registry %s: mirror %q must not include scheme", domain, m.Host)
+			}
+			if strings.HasSuffix(m.Host, "/") || strings.HasPrefix(m.Host, "/") {
+				return fmt.Errorf("registry %s: invalid mirror %q", domain, m.Host)
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	registriesMu sync.RWMutex
+	// registries is shared by all registry requests.
+	registries *Registries
+)
+
+// SetRegistries sets registries configuration used by all
+// consequent pulls and registry requests.
+func SetRegistries(r *Registries) {
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+	registries = r
+}
+
+func currentRegistries() *Registries {
+	registriesMu.RLock()
+	defer registriesMu.RUnlock()
+	if registries == nil {
+		return &Registries{}
+	}
+	return registries
+}
+
+// Endpoint is a registry or its mirror image is fetched from.
+type Endpoint struct {
+	Host     string
+	Insecure bool
+	Mirror   bool
+	// location is mirror host with path or registry host.
+	location string
+	// repo is a repository image is found in at the endpoint.
+	repo string
+}
+
+// String returns endpoint location as configured.
+func (e Endpoint) String() string {
+	return e.location
+}
+
+// url returns registry API url of the image object, e.g. manifest or blob.
+func (e Endpoint) url(kind, object string) string {
+	scheme := "https"
+	if e.Insecure {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", scheme, e.Host, e.repo, kind, object)
+}
+
+// image returns location of the image referenced by ref at the endpoint.
+func (e Endpoint) image(ref *reference.Reference) string {
+	return e.Host + "/" + e.repo + strings.TrimPrefix(ref.Familiar(), ref.FamiliarName())
+}
+
+// endpoints returns endpoints docker image referenced by ref is looked up at
+// in order: configured registry mirrors followed by the registry itself.
+func endpoints(ref *reference.Reference, auth *k8s.AuthConfig) []Endpoint {
+	config := currentRegistries().Hosts[ref.Domain]
+	eps := make([]Endpoint, 0, len(config.Mirrors)+1)
+	for _, m := range config.Mirrors {
+		host, prefix := m.Host, ""
+		if i := strings.IndexByte(host, '/'); i != -1 {
+			host, prefix = host[:i], host[i+1:]+"/"
+		}
+		eps = append(eps, Endpoint{
+			Host:     host,
+			Insecure: m.Insecure,
+			Mirror:   true,
+			location: m.Host,
+			repo:     prefix + ref.Path,
+		})
+	}
+	host, repo := registryRepo(ref, auth)
+	return append(eps, Endpoint{Host: host, Insecure: config.Insecure, location: host, repo: repo})
+}
+
+// fromEndpoints calls fetch with endpoints of the image referenced by ref in
+// order until one succeeds and returns that endpoint. Endpoints backing off
+// after rate limiting are skipped. When all of them fail, an error listing
+// failure of each endpoint is returned, unless all of them are rate limited,
+// then RateLimitError of the last one is returned as is.
+func fromEndpoints(ctx context.Context, ref *reference.Reference, auth *k8s.AuthConfig, fetch func(Endpoint) error) (Endpoint, error) {
+	eps := endpoints(ref, auth)
+	var errs []string
+	limited := 0
+	for _, ep := range eps {
+		err := registryLimits.check(ep.Host, auth)
+		if err == nil {
+			err = fetch(ep)
+		}
+		if err == nil {
+			registryLimits.reset(ep.Host, auth)
+			return ep, nil
+		}
+		if _, ok := err.(*RateLimitError); ok {
+			limited++
+			if limited == len(eps) {
+				return Endpoint{}, err
+			}
+		}
+		if len(eps) == 1 || ctx.Err() != nil {
+			return Endpoint{}, err
+		}
+		glog.V(4).Infof("Could not fetch %s from %s: %v", ref, ep, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", ep, err))
+	}
+	return Endpoint{}, fmt.Errorf("all endpoints failed: %s", strings.Join(errs, "; "))
+}
+
+// cachePath returns path image referenced by ref is kept at in the
+// pull-through cache, e.g. <cacheDir>/docker.io/library/alpine/latest.sif.
+// Only docker images are cached.
+func cachePath(cacheDir string, ref *Reference) string {
+	if cacheDir == "" || ref.URI() != singularity.DockerDomain {
+		return ""
+	}
+	parsed, err := ref.parsed()
+	if err != nil {
+		return ""
+	}
+	object := parsed.Tag
+	if parsed.Digest != "" {
+		object = parsed.Digest
+	}
+	return filepath.Join(cacheDir, parsed.Domain, filepath.FromSlash(parsed.Path), object+".sif")
+}
+
+// copyImage copies image file to the passed path atomically,
+// so that partially copied images are never seen at path.
+func copyImage(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return fmt.Errorf("could not create directory: %v", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(to), "."+filepath.Base(to)+".tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %v", err)
+	}
+	_, err = io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), to)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not copy %s: %v", from, err)
+	}
+	return nil
+}
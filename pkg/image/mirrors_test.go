@@ -0,0 +1,207 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/reference"
+)
+
+func TestRegistriesValidate(t *testing.T) {
+	tt := []struct {
+		name        string
+		registries  *Registries
+		expectError error
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name: "valid",
+			registries: &Registries{
+				Hosts: map[string]RegistryConfig{
+					"docker.io": {Mirrors: []Mirror{{Host: "harbor.local/dockerhub"}, {Host: "mirror.local:5000", Insecure: true}}},
+				},
+				CacheDir: "/var/lib/cache",
+			},
+		},
+		{
+			name:        "relative cache",
+			registries:  &Registries{CacheDir: "cache"},
+			expectError: fmt.Errorf(`cache directory "cache" is not absolute`),
+		},
+		{
+			name: "uppercase registry",
+			registries: &Registries{
+				Hosts: map[string]RegistryConfig{"Docker.io": {}},
+			},
+			expectError: fmt.Errorf(`registry "Docker.io" must be lowercase`),
+		},
+		{
+			name: "mirror with scheme",
+			registries: &Registries{
+				Hosts: map[string]RegistryConfig{
+					"docker.io": {Mirrors: []Mirror{{Host: "http://mirror.local"}}},
+				},
+			},
+			expectError: fmt.Errorf(`registry docker.io: mirror "http://mirror.local" must not include scheme`),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectError, tc.registries.Validate())
+		})
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	defer SetRegistries(nil)
+	SetRegistries(&Registries{
+		Hosts: map[string]RegistryConfig{
+			"docker.io": {Mirrors: []Mirror{{Host: "harbor.local/dockerhub"}, {Host: "mirror.local:5000", Insecure: true}}},
+			"quay.io":   {Insecure: true},
+		},
+	})
+
+	tt := []struct {
+		name         string
+		ref          string
+		expectURLs   []string
+		expectImages []string
+	}{
+		{
+			name: "mirrored registry",
+			ref:  "busybox:1.28",
+			expectURLs: []string{
+				"https://harbor.local/v2/dockerhub/library/busybox/manifests/1.28",
+				"http://mirror.local:5000/v2/library/busybox/manifests/1.28",
+				"https://registry-1.docker.io/v2/library/busybox/manifests/1.28",
+			},
+			expectImages: []string{
+				"harbor.local/dockerhub/library/busybox:1.28",
+				"mirror.local:5000/library/busybox:1.28",
+			},
+		},
+		{
+			name: "insecure registry",
+			ref:  "quay.io/test/busybox:latest",
+			expectURLs: []string{
+				"http://quay.io/v2/test/busybox/manifests/latest",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := reference.Parse(tc.ref)
+			require.NoError(t, err)
+			var urls, images []string
+			for _, ep := range endpoints(ref, nil) {
+				urls = append(urls, ep.url("manifests", ref.Tag))
+				if ep.Mirror {
+					images = append(images, ep.image(ref))
+				}
+			}
+			require.Equal(t, tc.expectURLs, urls)
+			require.Equal(t, tc.expectImages, images)
+		})
+	}
+}
+
+func TestFromEndpoints(t *testing.T) {
+	defer SetRegistries(nil)
+	SetRegistries(&Registries{
+		Hosts: map[string]RegistryConfig{
+			"docker.io": {Mirrors: []Mirror{{Host: "down.local"}, {Host: "up.local"}}},
+		},
+	})
+	ref, err := reference.Parse("busybox")
+	require.NoError(t, err)
+
+	var tried []string
+	ep, err := fromEndpoints(context.Background(), ref, nil, func(ep Endpoint) error {
+		tried = append(tried, ep.String())
+		if ep.Host == "down.local" {
+			return fmt.Errorf("unavailable")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "up.local", ep.String())
+	require.Equal(t, []string{"down.local", "up.local"}, tried)
+
+	_, err = fromEndpoints(context.Background(), ref, nil, func(ep Endpoint) error {
+		return fmt.Errorf("unavailable")
+	})
+	require.EqualError(t, err, "all endpoints failed: down.local: unavailable; "+
+		"up.local: unavailable; registry-1.docker.io: unavailable")
+}
+
+func TestCachePath(t *testing.T) {
+	tt := []struct {
+		name       string
+		ref        string
+		expectPath string
+	}{
+		{
+			name:       "docker hub tag",
+			ref:        "busybox:1.28",
+			expectPath: "/cache/docker.io/library/busybox/1.28.sif",
+		},
+		{
+			name:       "docker digest",
+			ref:        "quay.io/test/busybox@sha256:165768770ca428e9e6d8290d5672652773edf1f80d442252a0ec737ed2cc312c",
+			expectPath: "/cache/quay.io/test/busybox/sha256:165768770ca428e9e6d8290d5672652773edf1f80d442252a0ec737ed2cc312c.sif",
+		},
+		{
+			name: "library image",
+			ref:  "cloud.sylabs.io/sashayakovtseva/test/busybox:latest",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := ParseRef(tc.ref)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectPath, cachePath("/cache", ref))
+		})
+	}
+}
+
+func TestCopyImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	from := filepath.Join(dir, "image.sif")
+	require.NoError(t, ioutil.WriteFile(from, []byte("sif"), 0600))
+	to := filepath.Join(dir, "cache", "docker.io", "busybox", "latest.sif")
+	require.NoError(t, copyImage(from, to))
+
+	data, err := ioutil.ReadFile(to)
+	require.NoError(t, err)
+	require.Equal(t, "sif", string(data))
+	fi, err := os.Stat(to)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0644), fi.Mode())
+}
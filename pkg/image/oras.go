@@ -0,0 +1,101 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// SIFLayerMediaType is the media type of a SIF image pushed
+	// to an OCI registry as an artifact, e.g. with oras.
+	SIFLayerMediaType = "application/vnd.sylabs.sif.layer.v1.sif"
+	// SIFConfigMediaType is the media type of a SIF artifact config.
+	SIFConfigMediaType = "application/vnd.sylabs.sif.config.v1+json"
+)
+
+// Source formats of pulled images.
+const (
+	// SourceSIF is a SIF image pulled from library or found locally.
+	SourceSIF = "sif"
+	// SourceOrasSIF is a SIF image stored in an OCI registry as an artifact.
+	SourceOrasSIF = "oras-sif"
+	// SourceOCIImage is an OCI image converted into SIF.
+	SourceOCIImage = "oci-image"
+	// SourceOCILayout is an OCI image stored in OCI image layout as is.
+	SourceOCILayout = "oci-layout"
+)
+
+// remoteSIFLayer fetches manifest of the docker image referenced by ref and
+// returns its SIF layer when image is a SIF artifact. For regular OCI images
+// no layer and no error is returned.
+func remoteSIFLayer(ctx context.Context, ref *Reference, auth *k8s.AuthConfig) (*descriptor, error) {
+	m, err := remoteManifest(ctx, ref, auth)
+	if err != nil {
+		return nil, err
+	}
+	for i, layer := range m.Layers {
+		if layer.MediaType != SIFLayerMediaType {
+			continue
+		}
+		if len(m.Layers) != 1 {
+			return nil, fmt.Errorf("SIF artifact has %d layers, expected 1", len(m.Layers))
+		}
+		return &m.Layers[i], nil
+	}
+	return nil, nil
+}
+
+// downloadSIF downloads SIF layer blob of the image referenced by ref
+// and saves it at pullPath. Blob digest and size are verified against
+// the ones specified in the manifest, mismatching blob is moved into quarantine.
+// Download bandwidth is limited by throttle. Endpoint blob is downloaded from
+// is returned.
+func downloadSIF(ctx context.Context, ref *Reference, auth *k8s.AuthConfig, layer *descriptor, pullPath string,
+	throttle *Throttle, quarantine *Quarantine) (Endpoint, error) {
+	if !strings.HasPrefix(layer.Digest, "sha256:") {
+		return Endpoint{}, fmt.Errorf("unsupported SIF layer digest %q", layer.Digest)
+	}
+	parsed, err := ref.parsed()
+	if err != nil {
+		return Endpoint{}, err
+	}
+	glog.V(4).Infof("Downloading SIF layer %s of %s", layer.Digest, ref)
+	return fromEndpoints(ctx, parsed, auth, func(ep Endpoint) error {
+		return downloadBlob(ctx, ep.url("blobs", layer.Digest), auth, layer, pullPath,
+			throttle, pullHost(ref, auth), quarantine)
+	})
+}
+
+// sifArtifactLayer returns SIF layer when docker reference points to a SIF
+// artifact.
+// Errors are not fatal since image may still be pulled with singularity build.
+func sifArtifactLayer(ctx context.Context, ref *Reference, auth *k8s.AuthConfig) *descriptor {
+	if ref.URI() != singularity.DockerDomain {
+		return nil
+	}
+	layer, err := remoteSIFLayer(ctx, ref, auth)
+	if err != nil {
+		glog.V(4).Infof("Could not check whether %s is a SIF artifact: %v", ref, err)
+		return nil
+	}
+	return layer
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteSIFLayer(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/test/app/manifests/sif":
+			fmt.Fprintf(w, `{"config":{"mediaType":"%s","size":2},"layers":[{"mediaType":"%s","digest":"sha256:a","size":10}]}`,
+				SIFConfigMediaType, SIFLayerMediaType)
+		case "/v2/test/app/manifests/oci":
+			fmt.Fprint(w, `{"config":{"mediaType":"application/vnd.oci.image.config.v1+json","size":5},
+				"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"sha256:b","size":10}]}`)
+		case "/v2/test/app/manifests/broken":
+			fmt.Fprintf(w, `{"layers":[{"mediaType":"%[1]s","size":10},{"mediaType":"%[1]s","size":10}]}`, SIFLayerMediaType)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	defaultClient := registryClient
+	registryClient = srv.Client()
+	defer func() { registryClient = defaultClient }()
+
+	host := strings.TrimPrefix(srv.URL, "https://")
+	tt := []struct {
+		name        string
+		ref         string
+		expectLayer *descriptor
+		expectError bool
+	}{
+		{
+			name:        "sif artifact",
+			ref:         host + "/test/app:sif",
+			expectLayer: &descriptor{MediaType: SIFLayerMediaType, Digest: "sha256:a", Size: 10},
+		},
+		{
+			name: "oci image in the same repository",
+			ref:  host + "/test/app:oci",
+		},
+		{
+			name:        "multiple sif layers",
+			ref:         host + "/test/app:broken",
+			expectError: true,
+		},
+		{
+			name:        "unknown tag",
+			ref:         host + "/test/app:unknown",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := ParseRef(tc.ref)
+			require.NoError(t, err)
+			layer, err := remoteSIFLayer(context.Background(), ref, nil)
+			if tc.expectError {
+				require.Error(t, err)
+				require.Nil(t, sifArtifactLayer(context.Background(), ref, nil))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectLayer, layer)
+		})
+	}
+}
+
+func TestDownloadSIF(t *testing.T) {
+	blob := []byte("SIF_MAGIC image content")
+	sum := sha256.Sum256(blob)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/test/app/blobs/"+digest {
+			w.Write(blob)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	defaultClient := registryClient
+	registryClient = srv.Client()
+	defer func() { registryClient = defaultClient }()
+
+	dir, err := ioutil.TempDir("", "oras-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ref, err := ParseRef(strings.TrimPrefix(srv.URL, "https://") + "/test/app:sif")
+	require.NoError(t, err)
+
+	tt := []struct {
+		name        string
+		layer       *descriptor
+		expectError bool
+	}{
+		{
+			name:  "valid blob",
+			layer: &descriptor{Digest: digest, Size: int64(len(blob))},
+		},
+		{
+			name:        "digest mismatch",
+			layer:       &descriptor{Digest: "sha256:" + strings.Repeat("0", 64), Size: int64(len(blob))},
+			expectError: true,
+		},
+		{
+			name:        "blob larger than advertised",
+			layer:       &descriptor{Digest: digest, Size: int64(len(blob)) - 1},
+			expectError: true,
+		},
+		{
+			name:        "unsupported digest",
+			layer:       &descriptor{Digest: "md5:" + digest, Size: int64(len(blob))},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			pullPath := filepath.Join(dir, "image.sif")
+			_, err := downloadSIF(context.Background(), ref, nil, tc.layer, pullPath, nil, nil)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			content, err := ioutil.ReadFile(pullPath)
+			require.NoError(t, err)
+			require.Equal(t, blob, content)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"sync"
+)
+
+// Progress reports how many bytes of an image are downloaded. It is
+// safe to read while pull is running. Progress of images built from
+// docker layers is approximate since cached layers are not downloaded.
+type Progress struct {
+	mu      sync.Mutex
+	total   int64
+	done    int64
+	measure func() int64
+}
+
+// NewProgress returns progress of a pull that is expected to download
+// total bytes. Zero total means expected size is unknown.
+func NewProgress(total int64) *Progress {
+	return &Progress{total: total}
+}
+
+// Get returns number of downloaded bytes and expected total, zero when
+// unknown. Downloaded bytes never exceed known total.
+func (p *Progress) Get() (int64, int64) {
+	p.mu.Lock()
+	measure, total, done := p.measure, p.total, p.done
+	p.mu.Unlock()
+
+	if measure != nil {
+		done = measure()
+	}
+	if done < 0 {
+		done = 0
+	}
+	if total > 0 && done > total {
+		done = total
+	}
+	return done, total
+}
+
+// track starts reporting bytes returned by measure. Positive total
+// replaces the expected one, e.g. when pull finds out the exact size.
+func (p *Progress) track(measure func() int64, total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.measure = measure
+	if total > 0 {
+		p.total = total
+	}
+}
+
+// stop measures progress for the last time and keeps
+// the result, so that it is reported once pull is over.
+func (p *Progress) stop() {
+	if p == nil {
+		return
+	}
+	done, _ := p.Get()
+	p.mu.Lock()
+	p.done = done
+	p.measure = nil
+	p.mu.Unlock()
+}
+
+// WithProgress makes pull report its download progress to p.
+func WithProgress(p *Progress) PullOption {
+	return func(o *pullOptions) {
+		o.progress = p
+	}
+}
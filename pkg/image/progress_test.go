@@ -0,0 +1,84 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	tt := []struct {
+		name        string
+		total       int64
+		measured    []int64
+		trackTotal  int64
+		expectDone  []int64
+		expectTotal int64
+	}{
+		{
+			name:        "unknown total",
+			measured:    []int64{0, 10, 20},
+			expectDone:  []int64{0, 10, 20},
+			expectTotal: 0,
+		},
+		{
+			name:        "known total",
+			total:       15,
+			measured:    []int64{-5, 10, 20},
+			expectDone:  []int64{0, 10, 15},
+			expectTotal: 15,
+		},
+		{
+			name:        "total found by pull",
+			total:       15,
+			trackTotal:  30,
+			measured:    []int64{10, 20},
+			expectDone:  []int64{10, 20},
+			expectTotal: 30,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewProgress(tc.total)
+			done, total := p.Get()
+			require.Zero(t, done)
+			require.Equal(t, tc.total, total)
+
+			var measured int64
+			p.track(func() int64 { return measured }, tc.trackTotal)
+			for i := range tc.measured {
+				measured = tc.measured[i]
+				done, total := p.Get()
+				require.Equal(t, tc.expectDone[i], done)
+				require.Equal(t, tc.expectTotal, total)
+			}
+
+			last := tc.expectDone[len(tc.expectDone)-1]
+			p.stop()
+			measured = 0
+			done, _ = p.Get()
+			require.Equal(t, last, done)
+		})
+	}
+
+	var p *Progress
+	require.NotPanics(t, func() {
+		p.track(func() int64 { return 0 }, 0)
+		p.stop()
+	})
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+)
+
+// QuarantineLogFile is an audit log kept in quarantine directory,
+// each line is a JSON encoded QuarantineEntry.
+const QuarantineLogFile = "audit.log"
+
+// QuarantineEntry records content moved into quarantine.
+type QuarantineEntry struct {
+	// Time is a unix timestamp in nanoseconds.
+	Time int64 `json:"time"`
+	// Source is the path content was found at.
+	Source string `json:"source"`
+	// Name is the name of content in quarantine directory.
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// Quarantine keeps content that failed verification, e.g. blobs that don't
+// match their digest or corrupted images, for inspection instead of removing
+// it. Each quarantined item is recorded in audit log. Quarantine directory is
+// never cleaned up by CRI. This type is thread-safe.
+type Quarantine struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewQuarantine returns quarantine located at the passed directory. Directory
+// should be on the same filesystem as image storage so that content is moved
+// into quarantine without copying.
+func NewQuarantine(dir string) (*Quarantine, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create quarantine directory: %v", err)
+	}
+	return &Quarantine{dir: dir}, nil
+}
+
+// Dir returns path to the quarantine directory.
+func (q *Quarantine) Dir() string {
+	return q.dir
+}
+
+// Add moves file or directory at path into quarantine and records reason in
+// audit log. Nil quarantine removes content instead. Name content is kept
+// under in quarantine directory is returned.
+func (q *Quarantine) Add(path, reason string) (string, error) {
+	if q == nil {
+		glog.V(2).Infof("Removing %s: %s", path, reason)
+		if err := os.RemoveAll(path); err != nil {
+			return "", fmt.Errorf("could not remove %s: %v", path, err)
+		}
+		return "", nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return "", fmt.Errorf("could not stat %s: %v", path, err)
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%d-%s", now.UnixNano(), filepath.Base(path))
+	dest := filepath.Join(q.dir, name)
+	if fi.IsDir() {
+		err = os.Rename(path, dest)
+	} else {
+		err = fs.MoveFile(path, dest)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not move %s into quarantine: %v", path, err)
+	}
+	glog.Warningf("Moved %s into quarantine as %s: %s", path, name, reason)
+
+	entry, err := json.Marshal(QuarantineEntry{
+		Time:   now.UnixNano(),
+		Source: path,
+		Name:   name,
+		Reason: reason,
+	})
+	if err != nil {
+		return name, fmt.Errorf("could not marshal audit log entry: %v", err)
+	}
+	log, err := os.OpenFile(filepath.Join(q.dir, QuarantineLogFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return name, fmt.Errorf("could not open audit log: %v", err)
+	}
+	_, err = log.Write(append(entry, '\n'))
+	if cErr := log.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return name, fmt.Errorf("could not write audit log: %v", err)
+	}
+	return name, nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuarantine_Add(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quarantine-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	blob := filepath.Join(dir, "blob")
+	require.NoError(t, ioutil.WriteFile(blob, []byte("corrupted"), 0644))
+	layout := filepath.Join(dir, "layout")
+	require.NoError(t, os.MkdirAll(filepath.Join(layout, "blobs"), 0755))
+
+	var nilQuarantine *Quarantine
+	removed := filepath.Join(dir, "removed")
+	require.NoError(t, ioutil.WriteFile(removed, nil, 0644))
+	name, err := nilQuarantine.Add(removed, "test")
+	require.NoError(t, err)
+	require.Empty(t, name)
+	_, err = os.Stat(removed)
+	require.True(t, os.IsNotExist(err), "nil quarantine must remove content")
+
+	q, err := NewQuarantine(filepath.Join(dir, "quarantine"))
+	require.NoError(t, err)
+	blobName, err := q.Add(blob, "digest mismatch")
+	require.NoError(t, err)
+	layoutName, err := q.Add(layout, "blob is missing")
+	require.NoError(t, err)
+	_, err = q.Add(blob, "again")
+	require.Error(t, err, "missing content cannot be quarantined")
+
+	data, err := ioutil.ReadFile(filepath.Join(q.Dir(), blobName))
+	require.NoError(t, err)
+	require.Equal(t, "corrupted", string(data))
+	fi, err := os.Stat(filepath.Join(q.Dir(), layoutName, "blobs"))
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+
+	log, err := os.Open(filepath.Join(q.Dir(), QuarantineLogFile))
+	require.NoError(t, err)
+	defer log.Close()
+	var entries []QuarantineEntry
+	scanner := bufio.NewScanner(log)
+	for scanner.Scan() {
+		var entry QuarantineEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		require.NotZero(t, entry.Time)
+		entry.Time = 0
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, []QuarantineEntry{
+		{Source: blob, Name: blobName, Reason: "digest mismatch"},
+		{Source: layout, Name: layoutName, Reason: "blob is missing"},
+	}, entries)
+}
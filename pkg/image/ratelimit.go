@@ -0,0 +1,181 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// rateLimitBackoff and maxRateLimitBackoff bound time requests to
+	// a registry are held back for after it responded with 429 Too Many
+	// Requests. Backoff doubles with each consecutive rejection.
+	rateLimitBackoff    = 30 * time.Second
+	maxRateLimitBackoff = 10 * time.Minute
+)
+
+// RateLimitError is returned when registry rejected requests made with
+// some credentials due to rate limiting. Until RetryAt requests to the
+// registry with the same credentials fail with it without reaching registry,
+// so that backoff is not prolonged by kubelet retries.
+type RateLimitError struct {
+	Registry string
+	RetryAt  time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	msg := fmt.Sprintf("registry %s rate limit exceeded, retry after %s",
+		e.Registry, e.RetryAt.UTC().Format(time.RFC3339))
+	if e.Registry == dockerHubRegistry {
+		msg += "; authenticated pulls, e.g. with imagePullSecrets, have higher limits"
+	}
+	return msg
+}
+
+// registryLimits is shared by all registry requests so that
+// concurrent pulls back off from rate limited registry together.
+var registryLimits = newRateLimiter()
+
+// rateLimiter tracks registries that rejected requests with 429 Too Many
+// Requests per credentials, since registries account anonymous requests
+// per client address and authenticated ones per user. This type is thread-safe.
+type rateLimiter struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	limited map[string]*rateLimit
+}
+
+type rateLimit struct {
+	backoff time.Duration
+	until   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		now:     time.Now,
+		limited: make(map[string]*rateLimit),
+	}
+}
+
+// check returns RateLimitError when requests to host with
+// the passed credentials are backing off.
+func (l *rateLimiter) check(host string, auth *k8s.AuthConfig) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit, ok := l.limited[host+"@"+credentialsDigest(auth)]
+	if !ok || !l.now().Before(limit.until) {
+		return nil
+	}
+	return &RateLimitError{Registry: host, RetryAt: limit.until}
+}
+
+// limit records rejection of request to host and returns error requests
+// fail with until backoff is over. Backoff is never shorter than registry
+// asked for with Retry-After.
+func (l *rateLimiter) limit(host string, auth *k8s.AuthConfig, retryAfter time.Duration) *RateLimitError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for key, limit := range l.limited {
+		if now.Sub(limit.until) > maxRateLimitBackoff {
+			delete(l.limited, key)
+		}
+	}
+
+	key := host + "@" + credentialsDigest(auth)
+	limit, ok := l.limited[key]
+	if !ok {
+		limit = &rateLimit{}
+		l.limited[key] = limit
+	}
+	if now.Before(limit.until) {
+		// concurrent requests rejected at once count as a single rejection
+		return &RateLimitError{Registry: host, RetryAt: limit.until}
+	}
+	limit.backoff *= 2
+	if limit.backoff < rateLimitBackoff {
+		limit.backoff = rateLimitBackoff
+	}
+	if limit.backoff > maxRateLimitBackoff {
+		limit.backoff = maxRateLimitBackoff
+	}
+	wait := limit.backoff
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+	limit.until = now.Add(wait)
+	glog.Warningf("Registry %s rate limit exceeded, holding back requests for %v", host, wait)
+	return &RateLimitError{Registry: host, RetryAt: limit.until}
+}
+
+// reset clears backoff of host after request with the passed credentials succeeded.
+func (l *rateLimiter) reset(host string, auth *k8s.AuthConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.limited, host+"@"+credentialsDigest(auth))
+}
+
+// CheckRateLimit returns RateLimitError when all endpoints docker image
+// referenced by ref may be fetched from are backing off after rejecting
+// requests with the passed credentials, so that pull fails without waiting
+// for a download slot. Nil is returned for other images.
+func CheckRateLimit(ref *Reference, auth *k8s.AuthConfig) error {
+	if ref.URI() != singularity.DockerDomain {
+		return nil
+	}
+	parsed, err := ref.parsed()
+	if err != nil {
+		return nil
+	}
+	var limitErr error
+	for _, ep := range endpoints(parsed, auth) {
+		if limitErr = registryLimits.check(ep.Host, auth); limitErr == nil {
+			return nil
+		}
+	}
+	return limitErr
+}
+
+// parseRetryAfter parses Retry-After header value which is
+// either a number of seconds or HTTP date. Zero is returned
+// for missing or invalid values.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(value); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// isRateLimitOutput checks whether output of failed singularity build
+// reports registry rejected request due to rate limiting.
+func isRateLimitOutput(output string) bool {
+	return strings.Contains(output, "toomanyrequests") || strings.Contains(output, "429 Too Many Requests")
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestRequestRegistry_RateLimit(t *testing.T) {
+	var requests, limited int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&limited) != 0 {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	defaultClient := registryClient
+	registryClient = srv.Client()
+	defer func() { registryClient = defaultClient }()
+
+	now := time.Now()
+	defaultLimits := registryLimits
+	registryLimits = newRateLimiter()
+	registryLimits.now = func() time.Time { return now }
+	defer func() { registryLimits = defaultLimits }()
+
+	anonymous := &k8s.AuthConfig{}
+	user := &k8s.AuthConfig{Username: "sasha", Password: "secret"}
+	request := func(auth *k8s.AuthConfig) error {
+		resp, err := requestRegistry(context.Background(), http.MethodGet, srv.URL+"/v2/test/busybox/manifests/latest", auth)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	atomic.StoreInt32(&limited, 1)
+	err = request(anonymous)
+	require.Equal(t, &RateLimitError{Registry: u.Host, RetryAt: now.Add(2 * time.Minute)}, err)
+	require.EqualValues(t, 1, requests)
+
+	// requests are held back until retry time
+	atomic.StoreInt32(&limited, 0)
+	require.Error(t, request(anonymous))
+	require.EqualValues(t, 1, requests)
+
+	// other credentials are limited separately
+	require.NoError(t, request(user))
+	require.EqualValues(t, 2, requests)
+
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, request(anonymous))
+	require.EqualValues(t, 3, requests)
+}
+
+func TestRateLimiter_limit(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+
+	expectBackoff := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute,
+		8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for _, backoff := range expectBackoff {
+		err := l.limit("registry-1.docker.io", nil, 0)
+		require.Equal(t, now.Add(backoff), err.RetryAt)
+		require.Equal(t, err, l.limit("registry-1.docker.io", nil, 0), "concurrent rejection must not extend backoff")
+		require.Equal(t, err, l.check("registry-1.docker.io", nil))
+		now = err.RetryAt
+		require.NoError(t, l.check("registry-1.docker.io", nil))
+	}
+
+	l.reset("registry-1.docker.io", nil)
+	err := l.limit("registry-1.docker.io", nil, time.Hour)
+	require.Equal(t, now.Add(time.Hour), err.RetryAt, "Retry-After must be honored")
+	require.Contains(t, err.Error(), "imagePullSecrets")
+}
+
+func TestIsRateLimitOutput(t *testing.T) {
+	require.True(t, isRateLimitOutput(`FATAL: toomanyrequests: You have reached your pull rate limit.`))
+	require.True(t, isRateLimitOutput(`unexpected status: 429 Too Many Requests`))
+	require.False(t, isRateLimitOutput(`FATAL: manifest unknown`))
+}
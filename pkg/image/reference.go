@@ -16,9 +16,10 @@ package image
 
 import (
 	"encoding/json"
-	"strings"
+	"fmt"
 	"sync"
 
+	"github.com/sylabs/singularity-cri/pkg/reference"
 	"github.com/sylabs/singularity-cri/pkg/singularity"
 	"github.com/sylabs/singularity-cri/pkg/slice"
 )
@@ -32,7 +33,7 @@ type Reference struct {
 	digests []string
 }
 
-// String returns first tag or digest found with origin domain as a prefix.
+// String returns canonical form of the first tag or digest found.
 func (r *Reference) String() string {
 	var ref string
 	if len(r.tags) > 0 {
@@ -40,8 +41,8 @@ func (r *Reference) String() string {
 	} else {
 		ref = r.digests[0]
 	}
-	if r.uri == singularity.DockerDomain {
-		ref = singularity.DockerDomain + "/" + ref
+	if normalized, err := reference.Normalize(ref); err == nil {
+		return normalized
 	}
 	return ref
 }
@@ -69,48 +70,48 @@ func (r *Reference) UnmarshalJSON(data []byte) error {
 	}{}
 	err := json.Unmarshal(data, &jsonRef)
 	r.uri = jsonRef.URI
-	r.tags = jsonRef.Tags
-	r.digests = jsonRef.Digests
+	// references saved by older versions may be in a different form
+	r.tags = familiarRefs(jsonRef.Tags)
+	r.digests = familiarRefs(jsonRef.Digests)
 	return err
 }
 
-// ParseRef constructs image reference based on imgRef.
+// ParseRef constructs image reference based on imgRef. Tags and digests
+// are kept in familiar form, see reference.Familiar.
 func ParseRef(imgRef string) (*Reference, error) {
-	imgRef = NormalizedImageRef(imgRef)
-	if strings.HasPrefix(imgRef, singularity.LocalFileDomain) {
-		return &Reference{
-			uri:  singularity.LocalFileDomain,
-			tags: []string{imgRef},
-		}, nil
-	}
-
-	uri := singularity.DockerDomain
-	if strings.HasPrefix(imgRef, singularity.LibraryDomain) {
-		uri = singularity.LibraryDomain
+	parsed, err := reference.Parse(imgRef)
+	if err != nil {
+		return nil, err
 	}
 
 	ref := Reference{
-		uri: uri,
+		uri: singularity.DockerDomain,
 	}
-
-	switch uri {
-	case singularity.LibraryDomain:
-		if strings.Contains(imgRef, "sha256.") {
-			ref.digests = []string{imgRef}
-		} else {
-			ref.tags = []string{imgRef}
-		}
-	case singularity.DockerDomain:
-		if strings.IndexByte(imgRef, '@') != -1 {
-			ref.digests = []string{imgRef}
-		} else {
-			ref.tags = []string{imgRef}
-		}
+	switch {
+	case parsed.IsLocalFile():
+		ref.uri = singularity.LocalFileDomain
+	case parsed.IsLibrary():
+		ref.uri = singularity.LibraryDomain
+	}
+	if parsed.Digest != "" {
+		ref.digests = []string{parsed.Familiar()}
+	} else {
+		ref.tags = []string{parsed.Familiar()}
 	}
-
 	return &ref, nil
 }
 
+// parsed returns the first tag or digest found parsed.
+func (r *Reference) parsed() (*reference.Reference, error) {
+	if len(r.tags) > 0 {
+		return reference.Parse(r.tags[0])
+	}
+	if len(r.digests) > 0 {
+		return reference.Parse(r.digests[0])
+	}
+	return nil, fmt.Errorf("reference has neither tags nor digests")
+}
+
 // URI returns uri from which image was originally pulled.
 func (r *Reference) URI() string {
 	if r == nil {
@@ -161,21 +162,23 @@ func (r *Reference) RemoveTag(tag string) {
 	r.tags = slice.RemoveFromString(r.tags, tag)
 }
 
-// NormalizedImageRef appends tag 'latest' if the passed ref
-// does not have any tag or digest already. It also trims
-// default docker domain prefix if present.
-func NormalizedImageRef(imgRef string) string {
-	imgRef = strings.TrimPrefix(imgRef, singularity.DockerDomain+"/")
-	i := strings.LastIndexByte(imgRef, ':')
-	if strings.HasPrefix(imgRef, singularity.LocalFileDomain) {
-		if i == -1 {
-			return imgRef
-		}
-		// kubernetes will add :latest tag, so we need to trim it for the file
-		return imgRef[:i]
+// familiarRefs converts refs to familiar form removing duplicates.
+// References that cannot be parsed are kept as is.
+func familiarRefs(refs []string) []string {
+	if refs == nil {
+		return nil
 	}
-	if i == -1 {
-		return imgRef + ":latest"
+	familiar := make([]string, 0, len(refs))
+	seen := make(map[string]struct{}, len(refs))
+	for _, ref := range refs {
+		if f, err := reference.Familiar(ref); err == nil {
+			ref = f
+		}
+		if _, ok := seen[ref]; ok {
+			continue
+		}
+		seen[ref] = struct{}{}
+		familiar = append(familiar, ref)
 	}
-	return imgRef
+	return familiar
 }
@@ -15,6 +15,7 @@
 package image
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -73,7 +74,7 @@ func TestParseImageRef(t *testing.T) {
 			ref:  "docker.io/gcr.io/cri-tools/test-image-tags:1",
 			expect: &Reference{
 				uri:     singularity.DockerDomain,
-				tags:    []string{"gcr.io/cri-tools/test-image-tags:1"},
+				tags:    []string{"docker.io/gcr.io/cri-tools/test-image-tags:1"},
 				digests: nil,
 			},
 			expectError: nil,
@@ -84,10 +85,26 @@ func TestParseImageRef(t *testing.T) {
 			expect: &Reference{
 				uri:     singularity.DockerDomain,
 				tags:    nil,
-				digests: []string{"gcr.io/cri-tools/test-image-digest@sha256:9179135b4b4cc5a8721e09379244807553c318d92fa3111a65133241551ca343"},
+				digests: []string{"docker.io/gcr.io/cri-tools/test-image-digest@sha256:9179135b4b4cc5a8721e09379244807553c318d92fa3111a65133241551ca343"},
 			},
 			expectError: nil,
 		},
+		{
+			name: "docker hub official image",
+			ref:  "docker.io/library/busybox",
+			expect: &Reference{
+				uri:  singularity.DockerDomain,
+				tags: []string{"busybox:latest"},
+			},
+		},
+		{
+			name: "library scheme",
+			ref:  "library://sashayakovtseva/test/image-server:1",
+			expect: &Reference{
+				uri:  singularity.LibraryDomain,
+				tags: []string{"cloud.sylabs.io/sashayakovtseva/test/image-server:1"},
+			},
+		},
 		{
 			name: "local SIF",
 			ref:  "local.file/home/sasha/my.sif",
@@ -106,9 +123,12 @@ func TestParseImageRef(t *testing.T) {
 			require.Equal(t, tc.expect, actual)
 		})
 	}
+
+	_, err := ParseRef("gcr.io/cri-tools/Test-Image")
+	require.Error(t, err)
 }
 
-func TestNormalizedImageRef(t *testing.T) {
+func TestReferenceString(t *testing.T) {
 	tt := []struct {
 		name   string
 		ref    string
@@ -127,7 +147,7 @@ func TestNormalizedImageRef(t *testing.T) {
 		{
 			name:   "docker image with domain",
 			ref:    "docker.io/cri-tools/test-image-tags",
-			expect: "cri-tools/test-image-tags:latest",
+			expect: "docker.io/cri-tools/test-image-tags:latest",
 		},
 		{
 			name:   "docker image with digest",
@@ -163,12 +183,28 @@ func TestNormalizedImageRef(t *testing.T) {
 
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
-			actual := NormalizedImageRef(tc.ref)
-			require.Equal(t, tc.expect, actual)
+			ref, err := ParseRef(tc.ref)
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, ref.String())
 		})
 	}
 }
 
+func TestReference_UnmarshalJSON(t *testing.T) {
+	data := `{
+		"uri":"docker.io",
+		"tags":["library/busybox:1.28","busybox:1.28","docker.io/busybox:latest"],
+		"digests":["busybox@sha256:141c253bc4c3fd0a201d32dc1f493bcf3fff003b6df416dea4f41046e0f37d47"]
+	}`
+	var ref Reference
+	require.NoError(t, json.Unmarshal([]byte(data), &ref))
+	require.Equal(t, []string{"busybox:1.28", "busybox:latest"}, ref.Tags())
+	require.Equal(t, []string{
+		"busybox@sha256:141c253bc4c3fd0a201d32dc1f493bcf3fff003b6df416dea4f41046e0f37d47",
+	}, ref.Digests())
+	require.Equal(t, "docker.io/library/busybox:1.28", ref.String())
+}
+
 func TestReferenceDigests(t *testing.T) {
 	ref := &Reference{
 		digests: []string{
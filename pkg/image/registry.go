@@ -0,0 +1,303 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/reference"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const dockerHubRegistry = "registry-1.docker.io"
+
+var (
+	// ErrNotDockerTag is used when remote digest is requested for a reference
+	// that is not a docker tag, e.g. library image or docker digest.
+	ErrNotDockerTag = fmt.Errorf("not docker tag")
+	// ErrNotDockerImage is used when remote size is requested for
+	// a reference that is not a docker image.
+	ErrNotDockerImage = fmt.Errorf("not docker image")
+
+	registryClient = &http.Client{Timeout: 30 * time.Second}
+
+	manifestMediaTypes = []string{
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.oci.image.index.v1+json",
+	}
+)
+
+// RemoteDigest resolves docker image tag into manifest digest with
+// a HEAD request to the registry. Returned value is a digest reference
+// in form of name@sha256:<hash> that may be stored along with image tags.
+// For references other than docker tags returns ErrNotDockerTag.
+func RemoteDigest(ctx context.Context, ref *Reference, auth *k8s.AuthConfig) (string, error) {
+	if ref.URI() != singularity.DockerDomain || len(ref.tags) == 0 {
+		return "", ErrNotDockerTag
+	}
+
+	parsed, err := ref.parsed()
+	if err != nil {
+		return "", err
+	}
+	var digest string
+	_, err = fromEndpoints(ctx, parsed, auth, func(ep Endpoint) error {
+		resp, err := requestRegistry(ctx, http.MethodHead, ep.url("manifests", parsed.Tag), auth)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected manifest response status: %s", resp.Status)
+		}
+		digest = resp.Header.Get("Docker-Content-Digest")
+		if !strings.HasPrefix(digest, "sha256:") {
+			return fmt.Errorf("registry didn't return manifest digest")
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return parsed.FamiliarName() + "@" + digest, nil
+}
+
+// RemoteSize returns size of docker image referenced by ref as a sum of
+// its compressed layers and config sizes listed in registry manifest.
+// When manifest is a list, manifest of the host platform is used.
+// For references other than docker images returns ErrNotDockerImage.
+func RemoteSize(ctx context.Context, ref *Reference, auth *k8s.AuthConfig) (int64, error) {
+	layers, err := RemoteLayers(ctx, ref, auth)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, layer := range layers {
+		size += layer.Size
+	}
+	return size, nil
+}
+
+// RemoteLayers returns blobs docker image referenced by ref consists of, i.e.
+// image config followed by its layers, as listed in registry manifest.
+// When manifest is a list, manifest of the host platform is used.
+// For references other than docker images returns ErrNotDockerImage.
+func RemoteLayers(ctx context.Context, ref *Reference, auth *k8s.AuthConfig) ([]Layer, error) {
+	if ref.URI() != singularity.DockerDomain {
+		return nil, ErrNotDockerImage
+	}
+
+	m, err := remoteManifest(ctx, ref, auth)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Layers) == 0 {
+		return nil, fmt.Errorf("manifest has no layer sizes")
+	}
+
+	layers := make([]Layer, 0, len(m.Layers)+1)
+	layers = append(layers, m.Config.layer())
+	for _, layer := range m.Layers {
+		layers = append(layers, layer.layer())
+	}
+	return layers, nil
+}
+
+// remoteManifest fetches manifest of docker image referenced by ref.
+// When manifest is a list, manifest of the host platform is returned.
+func remoteManifest(ctx context.Context, ref *Reference, auth *k8s.AuthConfig) (*manifest, error) {
+	parsed, err := ref.parsed()
+	if err != nil {
+		return nil, err
+	}
+	object := parsed.Tag
+	if parsed.Digest != "" {
+		object = parsed.Digest
+	}
+
+	var m *manifest
+	_, err = fromEndpoints(ctx, parsed, auth, func(ep Endpoint) error {
+		m, err = fetchManifest(ctx, ep.url("manifests", object), auth)
+		if err != nil {
+			return err
+		}
+		if len(m.Manifests) == 0 {
+			return nil
+		}
+		digest := ""
+		for _, platform := range m.Manifests {
+			if platform.Platform.OS == "linux" && platform.Platform.Architecture == runtime.GOARCH {
+				digest = platform.Digest
+				break
+			}
+		}
+		if digest == "" {
+			return fmt.Errorf("no manifest found for linux/%s", runtime.GOARCH)
+		}
+		m, err = fetchManifest(ctx, ep.url("manifests", digest), auth)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// manifest is a subset of docker image manifest, manifest list and their OCI
+// counterparts that describes sizes of image parts.
+type manifest struct {
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []struct {
+		descriptor
+		Platform struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+func (d descriptor) layer() Layer {
+	return Layer{Digest: d.Digest, Size: d.Size}
+}
+
+func fetchManifest(ctx context.Context, manifestURL string, auth *k8s.AuthConfig) (*manifest, error) {
+	resp, err := requestRegistry(ctx, http.MethodGet, manifestURL, auth)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected manifest response status: %s", resp.Status)
+	}
+	var m manifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("could not decode manifest: %v", err)
+	}
+	return &m, nil
+}
+
+// requestRegistry requests manifest or blob authorizing at registry when
+// needed. Tokens are cached and renewed before expiry, so that long pulls
+// consisting of many requests keep being authorized. Token rejected by
+// registry is requested anew once. Request rejected due to rate limiting fails
+// with RateLimitError. Caller is responsible for closing response body.
+func requestRegistry(ctx context.Context, method, registryURL string, auth *k8s.AuthConfig) (*http.Response, error) {
+	return requestRegistryHeader(ctx, method, registryURL, auth, nil)
+}
+
+// requestRegistryHeader is requestRegistry that sets header in addition
+// to the default ones, e.g. to request a range of blob.
+func requestRegistryHeader(ctx context.Context, method, registryURL string, auth *k8s.AuthConfig, header http.Header) (*http.Response, error) {
+	host := registryURL
+	if u, err := url.Parse(registryURL); err == nil {
+		host = u.Host
+	}
+	if err := registryLimits.check(host, auth); err != nil {
+		return nil, err
+	}
+	token := registryTokens.token(ctx, registryURL, auth)
+	resp, err := doRegistryRequest(ctx, method, registryURL, token, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return checkRateLimit(resp, host, auth)
+	}
+	resp.Body.Close()
+	if token != "" {
+		glog.V(4).Infof("Registry rejected token for %s, requesting new one", registryURL)
+		registryTokens.forget(registryURL, auth)
+	}
+	token, err = registryTokens.authorize(ctx, registryURL, resp.Header.Get("Www-Authenticate"), auth)
+	if err != nil {
+		return nil, fmt.Errorf("could not authorize at registry: %v", err)
+	}
+	resp, err = doRegistryRequest(ctx, method, registryURL, token, header)
+	if err != nil {
+		return nil, err
+	}
+	return checkRateLimit(resp, host, auth)
+}
+
+// checkRateLimit converts registry response rejecting request due
+// to rate limiting into RateLimitError, other responses are returned as is.
+func checkRateLimit(resp *http.Response, host string, auth *k8s.AuthConfig) (*http.Response, error) {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return resp, nil
+	}
+	resp.Body.Close()
+	return nil, registryLimits.limit(host, auth, parseRetryAfter(resp.Header.Get("Retry-After")))
+}
+
+func doRegistryRequest(ctx context.Context, method, registryURL, token string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, registryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create registry request: %v", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ","))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := registryClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("could not request registry: %v", err)
+	}
+	return resp, nil
+}
+
+// registryRepo returns registry host and repository docker image should be
+// fetched from. Server address set in auth overrides registry domain, image
+// is then looked up in that registry by its familiar name.
+func registryRepo(ref *reference.Reference, auth *k8s.AuthConfig) (string, string) {
+	if auth.GetServerAddress() != "" {
+		return registryHost(auth.GetServerAddress()), ref.FamiliarName()
+	}
+	if ref.Domain == singularity.DockerDomain {
+		return dockerHubRegistry, ref.Path
+	}
+	return ref.Domain, ref.Path
+}
+
+// registryHost trims scheme and path from registry server address.
+func registryHost(address string) string {
+	if i := strings.Index(address, "://"); i != -1 {
+		address = address[i+3:]
+	}
+	if i := strings.IndexByte(address, '/'); i != -1 {
+		address = address[:i]
+	}
+	return address
+}
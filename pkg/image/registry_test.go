@@ -0,0 +1,213 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/reference"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestRemoteDigest(t *testing.T) {
+	const digest = "sha256:165768770ca428e9e6d8290d5672652773edf1f80d442252a0ec737ed2cc312c"
+
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			fmt.Fprint(w, `{"token":"secret"}`)
+		case r.Header.Get("Authorization") != "Bearer secret":
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method != http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/v2/test/busybox/manifests/1.28":
+			w.Header().Set("Docker-Content-Digest", digest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	defaultClient := registryClient
+	registryClient = srv.Client()
+	defer func() { registryClient = defaultClient }()
+
+	host := strings.TrimPrefix(srv.URL, "https://")
+	tt := []struct {
+		name         string
+		ref          string
+		expectDigest string
+		expectError  error
+	}{
+		{
+			name:         "known tag",
+			ref:          host + "/test/busybox:1.28",
+			expectDigest: host + "/test/busybox@" + digest,
+		},
+		{
+			name: "unknown tag",
+			ref:  host + "/test/busybox:1.29",
+		},
+		{
+			name:        "docker digest",
+			ref:         host + "/test/busybox@" + digest,
+			expectError: ErrNotDockerTag,
+		},
+		{
+			name:        "library image",
+			ref:         "cloud.sylabs.io/sashayakovtseva/test/busybox:latest",
+			expectError: ErrNotDockerTag,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := ParseRef(tc.ref)
+			require.NoError(t, err)
+			digest, err := RemoteDigest(context.Background(), ref, nil)
+			if tc.expectError != nil {
+				require.Equal(t, tc.expectError, err)
+				return
+			}
+			if tc.expectDigest == "" {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectDigest, digest)
+		})
+	}
+}
+
+func TestRemoteSize(t *testing.T) {
+	const platformDigest = "sha256:165768770ca428e9e6d8290d5672652773edf1f80d442252a0ec737ed2cc312c"
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/test/busybox/manifests/1.28":
+			fmt.Fprint(w, `{"config":{"size":1000},"layers":[{"size":20000},{"size":300}]}`)
+		case "/v2/test/busybox/manifests/multi":
+			fmt.Fprintf(w, `{"manifests":[
+				{"digest":"sha256:other","platform":{"os":"linux","architecture":"unknown"}},
+				{"digest":"%s","platform":{"os":"linux","architecture":"%s"}}
+			]}`, platformDigest, runtime.GOARCH)
+		case "/v2/test/busybox/manifests/" + platformDigest:
+			fmt.Fprint(w, `{"config":{"size":5},"layers":[{"size":10}]}`)
+		case "/v2/test/busybox/manifests/schema1":
+			fmt.Fprint(w, `{"fsLayers":[{"blobSum":"sha256:a"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	defaultClient := registryClient
+	registryClient = srv.Client()
+	defer func() { registryClient = defaultClient }()
+
+	host := strings.TrimPrefix(srv.URL, "https://")
+	tt := []struct {
+		name        string
+		ref         string
+		expectSize  int64
+		expectError bool
+	}{
+		{
+			name:       "single manifest",
+			ref:        host + "/test/busybox:1.28",
+			expectSize: 21300,
+		},
+		{
+			name:       "manifest list",
+			ref:        host + "/test/busybox:multi",
+			expectSize: 15,
+		},
+		{
+			name:       "docker digest",
+			ref:        host + "/test/busybox@" + platformDigest,
+			expectSize: 15,
+		},
+		{
+			name:        "no layer sizes",
+			ref:         host + "/test/busybox:schema1",
+			expectError: true,
+		},
+		{
+			name:        "unknown tag",
+			ref:         host + "/test/busybox:1.29",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := ParseRef(tc.ref)
+			require.NoError(t, err)
+			size, err := RemoteSize(context.Background(), ref, nil)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectSize, size)
+		})
+	}
+
+	ref, err := ParseRef("cloud.sylabs.io/sashayakovtseva/test/busybox:latest")
+	require.NoError(t, err)
+	_, err = RemoteSize(context.Background(), ref, nil)
+	require.Equal(t, ErrNotDockerImage, err)
+}
+
+func TestRegistryRepo(t *testing.T) {
+	tt := []struct {
+		name           string
+		ref            string
+		auth           *k8s.AuthConfig
+		expectRegistry string
+		expectRepo     string
+	}{
+		{name: "official image", ref: "busybox", expectRegistry: dockerHubRegistry, expectRepo: "library/busybox"},
+		{name: "docker hub image", ref: "sylabs/busybox", expectRegistry: dockerHubRegistry, expectRepo: "sylabs/busybox"},
+		{name: "registry image", ref: "gcr.io/google/pause", expectRegistry: "gcr.io", expectRepo: "google/pause"},
+		{name: "registry with port", ref: "localhost:5000/pause", expectRegistry: "localhost:5000", expectRepo: "pause"},
+		{
+			name:           "server address",
+			ref:            "busybox:1.28",
+			auth:           &k8s.AuthConfig{ServerAddress: "https://mirror.example.com/v2/"},
+			expectRegistry: "mirror.example.com",
+			expectRepo:     "busybox",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := reference.Parse(tc.ref)
+			require.NoError(t, err)
+			registry, repo := registryRepo(ref, tc.auth)
+			require.Equal(t, tc.expectRegistry, registry)
+			require.Equal(t, tc.expectRepo, repo)
+		})
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// DefaultStallTimeout is the default time pull may make no progress for
+// before it is aborted.
+const DefaultStallTimeout = time.Minute
+
+// StallError is returned by Pull when pull made no progress for stall timeout.
+type StallError struct {
+	Ref     *Reference
+	Timeout time.Duration
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("pull of %s stalled: no data transferred for %v", e.Ref, e.Timeout)
+}
+
+// pullProgress is a snapshot of pull progress. Any change
+// of any field is considered to be progress.
+type pullProgress struct {
+	bytes  int64
+	files  int
+	output int64
+}
+
+// stallWatch aborts pull that makes no progress for timeout.
+type stallWatch struct {
+	timeout time.Duration
+	measure func() pullProgress
+
+	mu      sync.Mutex
+	last    pullProgress
+	stalled bool
+	stop    chan struct{}
+}
+
+// watchStall returns context that is canceled when progress reported
+// by measure doesn't change for timeout. Progress is measured a few
+// times per timeout, so that a stall is detected promptly.
+func watchStall(ctx context.Context, timeout time.Duration, measure func() pullProgress) (context.Context, *stallWatch) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &stallWatch{
+		timeout: timeout,
+		measure: measure,
+		last:    measure(),
+		stop:    make(chan struct{}),
+	}
+	interval := timeout / 10
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	go func() {
+		defer cancel()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		lastChange := time.Now()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				current := w.measure()
+				w.mu.Lock()
+				if current != w.last {
+					w.last = current
+					lastChange = now
+				} else if now.Sub(lastChange) >= w.timeout {
+					w.stalled = true
+				}
+				stalled := w.stalled
+				w.mu.Unlock()
+				if stalled {
+					return
+				}
+			}
+		}
+	}()
+	return ctx, w
+}
+
+// Stop stops watching and reports whether pull was aborted.
+func (w *stallWatch) Stop() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+	return w.stalled
+}
+
+// measurePaths sums sizes and numbers of files under the passed paths.
+// Missing paths and files removed during walk are skipped.
+func measurePaths(paths ...string) pullProgress {
+	var p pullProgress
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		err := filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			p.files++
+			if fi.Mode().IsRegular() {
+				p.bytes += fi.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			glog.V(4).Infof("Could not measure %s: %v", path, err)
+		}
+	}
+	return p
+}
+
+// countingWriter counts bytes written to it so that pull
+// progress includes engine output.
+type countingWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+	n  int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingWriter) count() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
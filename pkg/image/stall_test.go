@@ -0,0 +1,90 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchStall(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	t.Run("stalled", func(t *testing.T) {
+		var calls int64
+		measure := func() pullProgress {
+			// progress for a few ticks, then stall
+			n := atomic.AddInt64(&calls, 1)
+			if n > 5 {
+				n = 5
+			}
+			return pullProgress{bytes: n}
+		}
+		ctx, w := watchStall(context.Background(), timeout, measure)
+		select {
+		case <-ctx.Done():
+		case <-time.After(10 * timeout):
+			t.Fatalf("stalled pull was not aborted")
+		}
+		require.True(t, w.Stop())
+		require.True(t, w.Stop(), "second stop changed result")
+	})
+
+	t.Run("progressing", func(t *testing.T) {
+		var calls int64
+		measure := func() pullProgress {
+			// any field change is progress, e.g. a new layer
+			// file created while current layer stalls
+			return pullProgress{files: int(atomic.AddInt64(&calls, 1))}
+		}
+		ctx, w := watchStall(context.Background(), timeout, measure)
+		select {
+		case <-ctx.Done():
+			t.Fatalf("progressing pull was aborted")
+		case <-time.After(3 * timeout):
+		}
+		require.False(t, w.Stop())
+		<-ctx.Done()
+	})
+
+	t.Run("parent canceled", func(t *testing.T) {
+		parent, cancel := context.WithCancel(context.Background())
+		ctx, w := watchStall(parent, timeout, func() pullProgress { return pullProgress{} })
+		cancel()
+		<-ctx.Done()
+		require.False(t, w.Stop())
+	})
+}
+
+func TestMeasurePaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "measure-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "layers"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "layers", "a"), []byte("12345"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "image"), []byte("123"), 0644))
+
+	require.Equal(t, pullProgress{bytes: 5, files: 2}, measurePaths(filepath.Join(dir, "layers")))
+	require.Equal(t, pullProgress{bytes: 8, files: 3},
+		measurePaths(filepath.Join(dir, "layers"), filepath.Join(dir, "image"), filepath.Join(dir, "missing"), ""))
+}
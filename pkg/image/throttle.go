@@ -0,0 +1,341 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// throttleChunk is the largest amount of data passed through throttle
+	// at once. Concurrent pulls wait for bandwidth chunk by chunk in order
+	// of arrival, so that it is shared between them in round-robin fashion.
+	throttleChunk = 32 << 10
+	// throughputWindow is a number of seconds throughput is averaged over.
+	throughputWindow = 10
+)
+
+// PullLimits is image download bandwidth in bytes per second.
+// Zero or negative value means unlimited.
+type PullLimits struct {
+	// Global is bandwidth shared by all pulls.
+	Global int64
+	// Registries maps registry host, e.g. docker.io or cloud.sylabs.io,
+	// to bandwidth shared by pulls from it, Global still applies.
+	Registries map[string]int64
+}
+
+// ThrottleStats describes bandwidth usage of a single limit.
+type ThrottleStats struct {
+	// Limit is bandwidth limit in bytes per second, zero if unlimited.
+	Limit int64 `json:"limitBytesPerSecond"`
+	// Throughput is bandwidth used in bytes per second
+	// averaged over last throughputWindow seconds.
+	Throughput int64 `json:"throughputBytesPerSecond"`
+	// Utilization is Throughput to Limit ratio, zero if unlimited.
+	Utilization float64 `json:"utilization"`
+	// Waited is total time downloads were delayed by this limit.
+	Waited time.Duration `json:"waitedNanoseconds"`
+}
+
+// ThrottleInfo holds bandwidth usage of global and per registry limits.
+type ThrottleInfo struct {
+	Global     ThrottleStats            `json:"global"`
+	Registries map[string]ThrottleStats `json:"registries,omitempty"`
+}
+
+// Throttle limits bandwidth of image downloads. Limits may be changed at any
+// time, downloads in progress are slowed down or sped up accordingly.
+// Docker images that are built by Singularity are downloaded by a separate
+// process and are not throttled, while library images and SIF artifacts are.
+// All Throttle methods are safe to call on nil Throttle, which is unlimited.
+type Throttle struct {
+	now    func() time.Time
+	global *bucket
+
+	mu         sync.Mutex
+	registries map[string]*bucket
+}
+
+// bucket enforces a single limit. Instead of counting tokens it keeps time
+// the next chunk may pass at, each chunk moves it forward according to
+// the limit, so that waiting downloads are served in order of arrival.
+type bucket struct {
+	mu     sync.Mutex
+	limit  int64
+	next   time.Time
+	waited time.Duration
+	// bytes passed during each of the last seconds,
+	// slot of second s is s % throughputWindow
+	bytes   [throughputWindow]uint64
+	seconds [throughputWindow]int64
+}
+
+// NewThrottle returns throttle that enforces the passed limits.
+func NewThrottle(limits PullLimits) *Throttle {
+	t := &Throttle{
+		now:        time.Now,
+		global:     &bucket{},
+		registries: make(map[string]*bucket),
+	}
+	t.SetLimits(limits)
+	return t
+}
+
+// SetLimits replaces limits. Downloads in progress are not interrupted
+// and follow new limits starting from their next chunk.
+func (t *Throttle) SetLimits(limits PullLimits) {
+	if t == nil {
+		return
+	}
+	t.global.setLimit(limits.Global)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for host, b := range t.registries {
+		if limit := limits.Registries[host]; limit <= 0 {
+			// downloads in progress keep the bucket, make them unlimited
+			b.setLimit(0)
+			delete(t.registries, host)
+		}
+	}
+	for host, limit := range limits.Registries {
+		if limit <= 0 {
+			continue
+		}
+		b, ok := t.registries[host]
+		if !ok {
+			b = &bucket{}
+			t.registries[host] = b
+		}
+		b.setLimit(limit)
+	}
+}
+
+// Info returns current bandwidth usage.
+func (t *Throttle) Info() ThrottleInfo {
+	if t == nil {
+		return ThrottleInfo{}
+	}
+	now := t.now()
+	info := ThrottleInfo{
+		Global: t.global.stats(now),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.registries) != 0 {
+		info.Registries = make(map[string]ThrottleStats, len(t.registries))
+	}
+	for host, b := range t.registries {
+		info.Registries[host] = b.stats(now)
+	}
+	return info
+}
+
+// Limited returns true if any limit is set.
+func (t *Throttle) Limited() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.global.currentLimit() > 0 || len(t.registries) != 0
+}
+
+// Reader returns reader that reads r with bandwidth limits of host. Read
+// returns ctx error once ctx is done while waiting for bandwidth.
+func (t *Throttle) Reader(ctx context.Context, host string, r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, t: t, buckets: t.buckets(host), r: r}
+}
+
+// Writer returns writer that writes to w with bandwidth limits of host.
+// Write returns ctx error once ctx is done while waiting for bandwidth.
+func (t *Throttle) Writer(ctx context.Context, host string, w io.Writer) io.Writer {
+	if t == nil {
+		return w
+	}
+	return &throttledWriter{ctx: ctx, t: t, buckets: t.buckets(host), w: w}
+}
+
+// buckets returns buckets download from host is limited by.
+func (t *Throttle) buckets(host string) []*bucket {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if b, ok := t.registries[host]; ok {
+		return []*bucket{b, t.global}
+	}
+	return []*bucket{t.global}
+}
+
+// wait blocks until n bytes may pass through all buckets.
+func (t *Throttle) wait(ctx context.Context, buckets []*bucket, n int) error {
+	for _, b := range buckets {
+		now := t.now()
+		delay := b.reserve(n, now)
+		b.record(n, delay, now)
+		if delay <= 0 {
+			continue
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (b *bucket) setLimit(limit int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if limit < 0 {
+		limit = 0
+	}
+	b.limit = limit
+}
+
+// reserve schedules n bytes to pass and returns time to wait before that.
+func (b *bucket) reserve(n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.limit == 0 {
+		return 0
+	}
+	if b.next.Before(now) {
+		b.next = now
+	}
+	delay := b.next.Sub(now)
+	b.next = b.next.Add(time.Duration(int64(n) * int64(time.Second) / b.limit))
+	return delay
+}
+
+func (b *bucket) currentLimit() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit
+}
+
+func (b *bucket) record(n int, waited time.Duration, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sec := now.Unix()
+	slot := sec % throughputWindow
+	if b.seconds[slot] != sec {
+		b.seconds[slot] = sec
+		b.bytes[slot] = 0
+	}
+	b.bytes[slot] += uint64(n)
+	b.waited += waited
+}
+
+func (b *bucket) stats(now time.Time) ThrottleStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var total uint64
+	sec := now.Unix()
+	for i := range b.bytes {
+		if sec-b.seconds[i] < throughputWindow {
+			total += b.bytes[i]
+		}
+	}
+	stats := ThrottleStats{
+		Limit:      b.limit,
+		Throughput: int64(total / throughputWindow),
+		Waited:     b.waited,
+	}
+	if b.limit > 0 {
+		stats.Utilization = float64(stats.Throughput) / float64(b.limit)
+	}
+	return stats
+}
+
+type throttledReader struct {
+	ctx     context.Context
+	t       *Throttle
+	buckets []*bucket
+	r       io.Reader
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.t.wait(r.ctx, r.buckets, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type throttledWriter struct {
+	ctx     context.Context
+	t       *Throttle
+	buckets []*bucket
+	w       io.Writer
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+		if err := w.t.wait(w.ctx, w.buckets, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// pullHost returns registry host image referenced by ref is downloaded from.
+func pullHost(ref *Reference, auth *k8s.AuthConfig) string {
+	if auth.GetServerAddress() != "" {
+		return registryHost(auth.GetServerAddress())
+	}
+	if ref.URI() == singularity.DockerDomain {
+		if parsed, err := ref.parsed(); err == nil {
+			return parsed.Domain
+		}
+	}
+	return ref.URI()
+}
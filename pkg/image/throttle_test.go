@@ -0,0 +1,187 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestBucket_Reserve(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := &bucket{}
+
+	// unlimited bucket never delays
+	require.Zero(t, b.reserve(throttleChunk, now))
+	require.Zero(t, b.reserve(throttleChunk, now))
+
+	// chunks are served in order of arrival
+	b.setLimit(throttleChunk)
+	require.Zero(t, b.reserve(throttleChunk, now))
+	require.Equal(t, time.Second, b.reserve(throttleChunk, now))
+	require.Equal(t, 2*time.Second, b.reserve(throttleChunk, now))
+	require.Equal(t, 2500*time.Millisecond, b.reserve(throttleChunk/2, now.Add(500*time.Millisecond)))
+
+	// new limit applies to the next chunk
+	b.setLimit(2 * throttleChunk)
+	require.Equal(t, 2500*time.Millisecond, b.reserve(throttleChunk, now.Add(time.Second)))
+	require.Equal(t, 3*time.Second, b.reserve(throttleChunk, now.Add(time.Second)))
+
+	// idle time is not accumulated
+	require.Zero(t, b.reserve(throttleChunk, now.Add(time.Minute)))
+	require.Equal(t, 500*time.Millisecond, b.reserve(throttleChunk, now.Add(time.Minute)))
+
+	b.setLimit(-1)
+	require.Zero(t, b.reserve(throttleChunk, now.Add(time.Minute)))
+}
+
+func TestBucket_Stats(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := &bucket{}
+	b.setLimit(1000)
+	for i := 0; i < throughputWindow; i++ {
+		b.record(500, time.Second, now.Add(time.Duration(i)*time.Second))
+	}
+	stats := b.stats(now.Add((throughputWindow - 1) * time.Second))
+	require.Equal(t, ThrottleStats{
+		Limit:       1000,
+		Throughput:  500,
+		Utilization: 0.5,
+		Waited:      throughputWindow * time.Second,
+	}, stats)
+
+	// old samples are not counted
+	stats = b.stats(now.Add((2*throughputWindow - 2) * time.Second))
+	require.Equal(t, int64(50), stats.Throughput)
+	stats = b.stats(now.Add(time.Hour))
+	require.Zero(t, stats.Throughput)
+	require.Zero(t, stats.Utilization)
+}
+
+func TestThrottle_SetLimits(t *testing.T) {
+	var nilThrottle *Throttle
+	require.False(t, nilThrottle.Limited())
+	require.Equal(t, ThrottleInfo{}, nilThrottle.Info())
+
+	th := NewThrottle(PullLimits{})
+	require.False(t, th.Limited())
+	require.Len(t, th.buckets("docker.io"), 1)
+
+	th.SetLimits(PullLimits{
+		Global:     100 << 20,
+		Registries: map[string]int64{"docker.io": 20 << 20, "gcr.io": 0},
+	})
+	require.True(t, th.Limited())
+	dockerBuckets := th.buckets("docker.io")
+	require.Len(t, dockerBuckets, 2)
+	require.Len(t, th.buckets("gcr.io"), 1)
+	info := th.Info()
+	require.Equal(t, int64(100<<20), info.Global.Limit)
+	require.Equal(t, map[string]ThrottleStats{
+		"docker.io": {Limit: 20 << 20},
+	}, info.Registries)
+
+	// download in progress keeps its bucket which becomes unlimited
+	th.SetLimits(PullLimits{Registries: map[string]int64{"gcr.io": 1 << 20}})
+	require.True(t, th.Limited())
+	require.Zero(t, dockerBuckets[0].currentLimit())
+	require.Zero(t, dockerBuckets[1].currentLimit())
+	require.Len(t, th.buckets("docker.io"), 1)
+	require.Len(t, th.buckets("gcr.io"), 2)
+
+	th.SetLimits(PullLimits{})
+	require.False(t, th.Limited())
+}
+
+func TestThrottle_Reader(t *testing.T) {
+	const limit = 1 << 20
+	th := NewThrottle(PullLimits{Registries: map[string]int64{"docker.io": limit}})
+	data := bytes.Repeat([]byte("x"), 10*throttleChunk)
+
+	start := time.Now()
+	out, err := ioutil.ReadAll(th.Reader(context.Background(), "docker.io", bytes.NewReader(data)))
+	require.NoError(t, err)
+	require.Equal(t, data, out)
+	// the first chunk passes immediately
+	expect := time.Duration(len(data)-throttleChunk) * time.Second / limit
+	require.True(t, time.Since(start) >= expect, "read took %v, expected at least %v", time.Since(start), expect)
+
+	// other registries are not limited
+	start = time.Now()
+	_, err = io.Copy(ioutil.Discard, th.Reader(context.Background(), "gcr.io", bytes.NewReader(data)))
+	require.NoError(t, err)
+	require.True(t, time.Since(start) < expect)
+
+	info := th.Info()
+	require.True(t, info.Registries["docker.io"].Waited > 0)
+	require.Zero(t, info.Global.Waited)
+
+	// cancelled download returns without waiting for bandwidth
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	th.SetLimits(PullLimits{Global: 1})
+	_, err = io.Copy(ioutil.Discard, th.Reader(ctx, "gcr.io", bytes.NewReader(data)))
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestThrottle_Writer(t *testing.T) {
+	const limit = 1 << 20
+	th := NewThrottle(PullLimits{Global: limit})
+	data := bytes.Repeat([]byte("x"), 10*throttleChunk+1)
+
+	var out bytes.Buffer
+	start := time.Now()
+	n, err := th.Writer(context.Background(), "cloud.sylabs.io", &out).Write(data)
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	require.Equal(t, data, out.Bytes())
+	expect := time.Duration(len(data)-throttleChunk-1) * time.Second / limit
+	require.True(t, time.Since(start) >= expect, "write took %v, expected at least %v", time.Since(start), expect)
+
+	var nilThrottle *Throttle
+	var buf bytes.Buffer
+	require.True(t, nilThrottle.Writer(context.Background(), "", &buf) == io.Writer(&buf))
+}
+
+func TestPullHost(t *testing.T) {
+	tt := []struct {
+		ref    string
+		auth   *k8s.AuthConfig
+		expect string
+	}{
+		{ref: "busybox", expect: "docker.io"},
+		{ref: "gcr.io/foo/app:1.0", expect: "gcr.io"},
+		{ref: "library://library/default/alpine:3.8", expect: "cloud.sylabs.io"},
+		{
+			ref:    "busybox",
+			auth:   &k8s.AuthConfig{ServerAddress: "https://registry.example.com/v2/"},
+			expect: "registry.example.com",
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.ref, func(t *testing.T) {
+			ref, err := ParseRef(tc.ref)
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, pullHost(ref, tc.auth))
+		})
+	}
+}
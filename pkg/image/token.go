@@ -0,0 +1,258 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// defaultTokenLifetime is a lifetime of bearer token issued
+	// without expires_in, see https://docs.docker.com/registry/spec/auth/token/.
+	defaultTokenLifetime = 60 * time.Second
+	// tokenRefreshMargin is how long before expiry cached token is renewed
+	// so that it doesn't expire while request is in flight.
+	tokenRefreshMargin = 10 * time.Second
+	// tokenClientID identifies CRI at auth servers in OAuth2 token requests.
+	tokenClientID = "singularity-cri"
+)
+
+// registryTokens is shared by all registry requests so that tokens
+// are reused across manifest and blob requests of concurrent pulls.
+var registryTokens = newTokenManager()
+
+// tokenManager implements docker registry token authentication: it answers
+// bearer challenges, caches issued tokens per repository scope and credentials
+// and renews them before expiry using the challenge they were issued for.
+// This type is thread-safe.
+type tokenManager struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]*scopedToken
+}
+
+// scopedToken is a bearer token along with the challenge it was issued for.
+type scopedToken struct {
+	challenge string
+	value     string
+	expires   time.Time
+}
+
+func newTokenManager() *tokenManager {
+	return &tokenManager{
+		now:    time.Now,
+		tokens: make(map[string]*scopedToken),
+	}
+}
+
+// token returns bearer token to send with request to registryURL without
+// waiting for a challenge. Token that is about to expire is renewed, empty
+// string is returned when no token was issued for the scope yet.
+func (m *tokenManager) token(ctx context.Context, registryURL string, auth *k8s.AuthConfig) string {
+	if auth.GetRegistryToken() != "" {
+		return auth.GetRegistryToken()
+	}
+
+	key := tokenKey(registryURL, auth)
+	m.mu.Lock()
+	cached, ok := m.tokens[key]
+	m.mu.Unlock()
+	if !ok {
+		return ""
+	}
+	if m.now().Add(tokenRefreshMargin).Before(cached.expires) {
+		return cached.value
+	}
+
+	glog.V(4).Infof("Renewing registry token for %s", key)
+	value, err := m.authorize(ctx, registryURL, cached.challenge, auth)
+	if err != nil {
+		glog.Warningf("Could not renew registry token: %v", err)
+		if m.now().Before(cached.expires) {
+			return cached.value
+		}
+		return ""
+	}
+	return value
+}
+
+// authorize requests new bearer token according to the passed challenge
+// and caches it for subsequent requests to the same repository.
+func (m *tokenManager) authorize(ctx context.Context, registryURL, challenge string, auth *k8s.AuthConfig) (string, error) {
+	issued, err := fetchToken(ctx, challenge, auth)
+	if err != nil {
+		return "", err
+	}
+	lifetime := defaultTokenLifetime
+	if issued.ExpiresIn > 0 {
+		lifetime = time.Duration(issued.ExpiresIn) * time.Second
+	}
+	issuedAt := m.now()
+	if !issued.IssuedAt.IsZero() && issued.IssuedAt.Before(issuedAt) {
+		issuedAt = issued.IssuedAt
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for key, cached := range m.tokens {
+		if !now.Before(cached.expires) {
+			delete(m.tokens, key)
+		}
+	}
+	m.tokens[tokenKey(registryURL, auth)] = &scopedToken{
+		challenge: challenge,
+		value:     issued.value(),
+		expires:   issuedAt.Add(lifetime),
+	}
+	return issued.value(), nil
+}
+
+// forget drops cached token after registry rejected it, e.g. when it was revoked.
+func (m *tokenManager) forget(registryURL string, auth *k8s.AuthConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tokens, tokenKey(registryURL, auth))
+}
+
+// tokenKey returns token cache key for the request, i.e. registry host and
+// repository the request is scoped to along with credentials digest so that
+// tokens issued for different users are never mixed.
+func tokenKey(registryURL string, auth *k8s.AuthConfig) string {
+	scope := registryURL
+	if u, err := url.Parse(registryURL); err == nil {
+		path := strings.TrimPrefix(u.Path, "/v2/")
+		for _, object := range []string{"/manifests/", "/blobs/"} {
+			if i := strings.LastIndex(path, object); i != -1 {
+				path = path[:i]
+				break
+			}
+		}
+		scope = u.Host + "/" + path
+	}
+	return scope + "@" + credentialsDigest(auth)
+}
+
+// credentialsDigest returns short digest identifying credentials
+// without exposing them, e.g. in cache keys.
+func credentialsDigest(auth *k8s.AuthConfig) string {
+	h := sha256.New()
+	for _, part := range []string{auth.GetUsername(), auth.GetPassword(), auth.GetIdentityToken()} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// issuedToken is a token response of docker registry auth server.
+type issuedToken struct {
+	Token       string    `json:"token"`
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   int64     `json:"expires_in"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
+
+func (t *issuedToken) value() string {
+	if t.Token != "" {
+		return t.Token
+	}
+	return t.AccessToken
+}
+
+// fetchToken requests bearer token according to the passed challenge,
+// see https://docs.docker.com/registry/spec/auth/token/. When identity
+// token is set it is exchanged for an access token with OAuth2 refresh
+// token grant, see https://docs.docker.com/registry/spec/auth/oauth/.
+func fetchToken(ctx context.Context, challenge string, auth *k8s.AuthConfig) (*issuedToken, error) {
+	const bearerPrefix = "Bearer "
+	if !strings.HasPrefix(challenge, bearerPrefix) {
+		return nil, fmt.Errorf("unsupported auth challenge %q", challenge)
+	}
+	params := make(map[string]string)
+	for _, param := range strings.Split(strings.TrimPrefix(challenge, bearerPrefix), ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return nil, fmt.Errorf("invalid auth realm %q", params["realm"])
+	}
+
+	var req *http.Request
+	if auth.GetIdentityToken() != "" {
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", auth.GetIdentityToken())
+		form.Set("client_id", tokenClientID)
+		if params["service"] != "" {
+			form.Set("service", params["service"])
+		}
+		if params["scope"] != "" {
+			form.Set("scope", params["scope"])
+		}
+		req, err = http.NewRequest(http.MethodPost, realm.String(), strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		query := realm.Query()
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+		if params["scope"] != "" {
+			query.Set("scope", params["scope"])
+		}
+		realm.RawQuery = query.Encode()
+		req, err = http.NewRequest(http.MethodGet, realm.String(), nil)
+		if err == nil && auth.GetUsername() != "" {
+			req.SetBasicAuth(auth.GetUsername(), auth.GetPassword())
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not create token request: %v", err)
+	}
+	resp, err := registryClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("could not request token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected token response status: %s", resp.Status)
+	}
+
+	var token issuedToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("could not decode token: %v", err)
+	}
+	if token.value() == "" {
+		return nil, fmt.Errorf("auth server returned empty token")
+	}
+	return &token, nil
+}
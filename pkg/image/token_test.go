@@ -0,0 +1,226 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestRequestRegistry_TokenRefresh(t *testing.T) {
+	var issued, challenged int32
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			user, pass, _ := r.BasicAuth()
+			if user != "sasha" || pass != "secret" || r.URL.Query().Get("scope") != "repository:test/busybox:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprintf(w, `{"token":"token-%d","expires_in":300}`, atomic.AddInt32(&issued, 1))
+		case r.Header.Get("Authorization") != fmt.Sprintf("Bearer token-%d", atomic.LoadInt32(&issued)):
+			atomic.AddInt32(&challenged, 1)
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:test/busybox:pull"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	defaultClient := registryClient
+	registryClient = srv.Client()
+	defer func() { registryClient = defaultClient }()
+
+	now := time.Now()
+	defaultTokens := registryTokens
+	registryTokens = newTokenManager()
+	registryTokens.now = func() time.Time { return now }
+	defer func() { registryTokens = defaultTokens }()
+
+	auth := &k8s.AuthConfig{Username: "sasha", Password: "secret"}
+	request := func(object string) {
+		resp, err := requestRegistry(context.Background(), http.MethodGet, srv.URL+"/v2/test/busybox/"+object, auth)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// token issued for manifest is reused for blobs
+	request("manifests/latest")
+	request("blobs/sha256:a")
+	request("blobs/sha256:b")
+	require.EqualValues(t, 1, issued)
+	require.EqualValues(t, 1, challenged)
+
+	// token that is about to expire is renewed without a challenge
+	now = now.Add(295 * time.Second)
+	request("blobs/sha256:c")
+	require.EqualValues(t, 2, issued)
+	require.EqualValues(t, 1, challenged)
+
+	// token rejected by registry is requested anew
+	atomic.AddInt32(&issued, 10)
+	request("blobs/sha256:d")
+	require.EqualValues(t, 13, issued)
+	require.EqualValues(t, 2, challenged)
+
+	// invalid credentials are reported
+	_, err := requestRegistry(context.Background(), http.MethodGet, srv.URL+"/v2/test/busybox/manifests/latest", &k8s.AuthConfig{Username: "sasha"})
+	require.Error(t, err)
+}
+
+func TestFetchToken(t *testing.T) {
+	issuedAt := time.Date(2019, 5, 16, 10, 0, 0, 0, time.UTC)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "identity" ||
+				r.FormValue("client_id") != tokenClientID || r.FormValue("service") != "test" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"access_token":"access","expires_in":120,"issued_at":"%s"}`, issuedAt.Format(time.RFC3339))
+		case http.MethodGet:
+			if _, _, ok := r.BasicAuth(); ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token":"anonymous"}`)
+		}
+	}))
+	defer srv.Close()
+
+	defaultClient := registryClient
+	registryClient = srv.Client()
+	defer func() { registryClient = defaultClient }()
+
+	challenge := fmt.Sprintf(`Bearer realm="%s/token",service="test"`, srv.URL)
+	tt := []struct {
+		name        string
+		challenge   string
+		auth        *k8s.AuthConfig
+		expectToken *issuedToken
+		expectError bool
+	}{
+		{
+			name:        "anonymous",
+			challenge:   challenge,
+			expectToken: &issuedToken{Token: "anonymous"},
+		},
+		{
+			name:      "identity token",
+			challenge: challenge,
+			auth:      &k8s.AuthConfig{IdentityToken: "identity"},
+			expectToken: &issuedToken{
+				AccessToken: "access",
+				ExpiresIn:   120,
+				IssuedAt:    issuedAt,
+			},
+		},
+		{
+			name:        "rejected credentials",
+			challenge:   challenge,
+			auth:        &k8s.AuthConfig{Username: "sasha", Password: "secret"},
+			expectError: true,
+		},
+		{
+			name:        "basic challenge",
+			challenge:   `Basic realm="test"`,
+			expectError: true,
+		},
+		{
+			name:        "no realm",
+			challenge:   `Bearer service="test"`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := fetchToken(context.Background(), tc.challenge, tc.auth)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectToken, token)
+		})
+	}
+}
+
+func TestTokenManager_Token(t *testing.T) {
+	m := newTokenManager()
+	require.Empty(t, m.token(context.Background(), "https://example.com/v2/test/busybox/manifests/latest", nil))
+	require.Equal(t, "registry", m.token(context.Background(), "https://example.com/v2/test/busybox/manifests/latest",
+		&k8s.AuthConfig{RegistryToken: "registry"}))
+}
+
+func TestTokenKey(t *testing.T) {
+	sasha := &k8s.AuthConfig{Username: "sasha", Password: "secret"}
+	tt := []struct {
+		name       string
+		urlA, urlB string
+		authA      *k8s.AuthConfig
+		authB      *k8s.AuthConfig
+		expectSame bool
+	}{
+		{
+			name:       "manifest and blob",
+			urlA:       "https://example.com/v2/test/busybox/manifests/latest",
+			urlB:       "https://example.com/v2/test/busybox/blobs/sha256:a",
+			expectSame: true,
+		},
+		{
+			name: "different repositories",
+			urlA: "https://example.com/v2/test/busybox/manifests/latest",
+			urlB: "https://example.com/v2/test/alpine/manifests/latest",
+		},
+		{
+			name: "different registries",
+			urlA: "https://example.com/v2/test/busybox/manifests/latest",
+			urlB: "https://example.com:5000/v2/test/busybox/manifests/latest",
+		},
+		{
+			name:  "different credentials",
+			urlA:  "https://example.com/v2/test/busybox/manifests/latest",
+			urlB:  "https://example.com/v2/test/busybox/manifests/latest",
+			authA: sasha,
+		},
+		{
+			name:       "same credentials",
+			urlA:       "https://example.com/v2/test/busybox/manifests/latest",
+			urlB:       "https://example.com/v2/test/busybox/blobs/sha256:a",
+			authA:      sasha,
+			authB:      &k8s.AuthConfig{Username: "sasha", Password: "secret"},
+			expectSame: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := tokenKey(tc.urlA, tc.authA), tokenKey(tc.urlB, tc.authB)
+			require.Equal(t, tc.expectSame, a == b)
+			require.NotContains(t, a, "secret")
+		})
+	}
+}
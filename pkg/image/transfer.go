@@ -0,0 +1,318 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/rand"
+)
+
+// Names of entries in exported image archive, metadata always goes first.
+// Images stored in OCI layout are archived as layout files under
+// exportLayoutDir instead of a single SIF file.
+const (
+	exportMetadataName = "metadata.json"
+	exportImageName    = "image.sif"
+	exportLayoutDir    = "layout/"
+
+	// maxExportMetadataSize limits metadata entry so that
+	// malformed archives are not read into memory.
+	maxExportMetadataSize = 1 << 20
+)
+
+// Export writes image as a tar archive with image metadata followed
+// by the stored SIF file or OCI layout. Archive may be loaded on any
+// node with Import, stored content is archived as is, so image ID and
+// SIF signatures are preserved.
+func Export(w io.Writer, info *Info) error {
+	metadata, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("could not marshal image metadata: %v", err)
+	}
+	tw := tar.NewWriter(w)
+	err = tw.WriteHeader(&tar.Header{
+		Name:    exportMetadataName,
+		Mode:    0644,
+		Size:    int64(len(metadata)),
+		ModTime: time.Now(),
+	})
+	if err == nil {
+		_, err = tw.Write(metadata)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write image metadata: %v", err)
+	}
+
+	if info.SourceFormat == SourceOCILayout {
+		err = exportLayout(tw, info.Path)
+	} else {
+		err = exportFile(tw, exportImageName, info.Path)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("could not finish archive: %v", err)
+	}
+	return nil
+}
+
+// exportLayout writes layout files of image stored in OCI layout at dir.
+// Only blobs referenced by the image manifest are written.
+func exportLayout(tw *tar.Writer, dir string) error {
+	desc, m, err := readLayout(dir)
+	if err != nil {
+		return fmt.Errorf("could not read image layout: %v", err)
+	}
+	for _, name := range []string{layoutVersionFile, layoutIndexFile} {
+		if err := exportFile(tw, exportLayoutDir+name, filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	written := make(map[string]bool)
+	for _, blob := range append([]descriptor{desc, m.Config}, m.Layers...) {
+		if written[blob.Digest] {
+			continue
+		}
+		written[blob.Digest] = true
+		name := exportLayoutDir + layoutBlobsDir + "/sha256/" + strings.TrimPrefix(blob.Digest, "sha256:")
+		if err := exportFile(tw, name, layoutBlobPath(dir, blob.Digest)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportFile writes file at path as archive entry with the passed name.
+func exportFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open image: %v", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat image: %v", err)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not write %s header: %v", name, err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("could not write %s: %v", name, err)
+	}
+	return nil
+}
+
+// Import reads archive written by Export and saves image into a temporary
+// file in dir that returned info.Path points to, so that caller decides
+// whether to keep it. Images stored in OCI layout are saved into a temporary
+// directory in layoutDir instead, layout is moved into storage by rename,
+// so layoutDir should be located on the same filesystem. Image content is
+// verified against exported checksum and ID. Partially imported file is
+// removed on error.
+func Import(r io.Reader, dir, layoutDir string) (*Info, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("could not read archive: %v", err)
+	}
+	if hdr.Name != exportMetadataName || hdr.Size > maxExportMetadataSize {
+		return nil, fmt.Errorf("unexpected archive entry %s: expected %s first", hdr.Name, exportMetadataName)
+	}
+	var info Info
+	if err := json.NewDecoder(io.LimitReader(tr, maxExportMetadataSize)).Decode(&info); err != nil {
+		return nil, fmt.Errorf("could not decode image metadata: %v", err)
+	}
+	if info.Ref == nil || len(info.Ref.Tags())+len(info.Ref.Digests()) == 0 {
+		return nil, fmt.Errorf("image metadata has no references")
+	}
+	if info.ID != info.Sha256 || len(info.ID) != IDLen {
+		return nil, fmt.Errorf("image metadata has invalid ID %q", info.ID)
+	}
+
+	if info.SourceFormat == SourceOCILayout {
+		return importLayout(tr, &info, layoutDir)
+	}
+
+	hdr, err = tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("could not read archive: %v", err)
+	}
+	if hdr.Name != exportImageName {
+		return nil, fmt.Errorf("unexpected archive entry %s: expected %s", hdr.Name, exportImageName)
+	}
+	path := filepath.Join(dir, "."+rand.GenerateID(64))
+	glog.V(5).Infof("Importing image %s to temporary file %s", info.ID, path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not create image file: %v", err)
+	}
+	cleanup := func() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			glog.Errorf("Could not remove %s: %v", path, err)
+		}
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), tr)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("could not write image file: %v", err)
+	}
+	if uint64(n) != info.Size {
+		cleanup()
+		return nil, fmt.Errorf("image size mismatch: expected %d bytes, got %d", info.Size, n)
+	}
+	if checksum := fmt.Sprintf("%x", h.Sum(nil)); checksum != info.Sha256 {
+		cleanup()
+		return nil, &ChecksumError{Ref: info.Ref.String(), Expected: info.Sha256, Actual: checksum}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		cleanup()
+		if err != nil {
+			return nil, fmt.Errorf("could not read archive: %v", err)
+		}
+		return nil, fmt.Errorf("unexpected archive entry after %s", exportImageName)
+	}
+	// partial checksum detects corruption of stored file, so it
+	// is computed locally rather than trusted from metadata
+	info.PartialSha256, err = partialChecksum(path)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	info.Path = path
+	return &info, nil
+}
+
+// importLayout saves layout files archived by exportLayout into a temporary
+// directory in dir. Each blob is verified against its digest as it is read,
+// layout manifest digest must match image ID.
+func importLayout(tr *tar.Reader, info *Info, dir string) (*Info, error) {
+	path := filepath.Join(dir, "."+rand.GenerateID(64))
+	glog.V(5).Infof("Importing image %s to temporary layout %s", info.ID, path)
+	if err := os.MkdirAll(filepath.Join(path, layoutBlobsDir, "sha256"), 0755); err != nil {
+		return nil, fmt.Errorf("could not create layout: %v", err)
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(path); err != nil {
+			glog.Errorf("Could not remove %s: %v", path, err)
+		}
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("could not read archive: %v", err)
+		}
+		if err := importLayoutEntry(tr, hdr, path); err != nil {
+			cleanup()
+			if cErr, ok := err.(*ChecksumError); ok {
+				cErr.Ref = info.Ref.String()
+				return nil, cErr
+			}
+			return nil, err
+		}
+	}
+
+	desc, _, err := readLayout(path)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("invalid image layout: %v", err)
+	}
+	if checksum := strings.TrimPrefix(desc.Digest, "sha256:"); checksum != info.Sha256 {
+		cleanup()
+		return nil, &ChecksumError{Ref: info.Ref.String(), Expected: info.Sha256, Actual: checksum}
+	}
+	info.Path = path
+	// blob digests are verified already, sizes are checked against manifest
+	if err := info.verifyLayout(false); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("invalid image layout: %v", err)
+	}
+	return info, nil
+}
+
+// importLayoutEntry writes archive entry described by hdr into layout at dir.
+// Blob names are their digests, so blob content is checked to match it.
+func importLayoutEntry(r io.Reader, hdr *tar.Header, dir string) error {
+	name := strings.TrimPrefix(hdr.Name, exportLayoutDir)
+	blobPrefix := layoutBlobsDir + "/sha256/"
+	target := filepath.Join(dir, name)
+	var digest string
+	switch {
+	case name == hdr.Name:
+		return fmt.Errorf("unexpected archive entry %s", hdr.Name)
+	case name == layoutVersionFile || name == layoutIndexFile:
+		if hdr.Size > maxExportMetadataSize {
+			return fmt.Errorf("archive entry %s is too large", hdr.Name)
+		}
+	case strings.HasPrefix(name, blobPrefix):
+		digest = "sha256:" + strings.TrimPrefix(name, blobPrefix)
+		if !layoutDigestRe.MatchString(digest) {
+			return fmt.Errorf("unexpected archive entry %s", hdr.Name)
+		}
+		target = layoutBlobPath(dir, digest)
+	default:
+		return fmt.Errorf("unexpected archive entry %s", hdr.Name)
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %v", name, err)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not write %s: %v", name, err)
+	}
+	if n != hdr.Size {
+		return fmt.Errorf("could not write %s: expected %d bytes, got %d", name, hdr.Size, n)
+	}
+	if checksum := fmt.Sprintf("sha256:%x", h.Sum(nil)); digest != "" && checksum != digest {
+		return &ChecksumError{
+			Expected: strings.TrimPrefix(digest, "sha256:"),
+			Actual:   strings.TrimPrefix(checksum, "sha256:"),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+)
+
+func TestExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	content := bytes.Repeat([]byte("singularity"), 3*partialHashChunk/10)
+	imgPath := filepath.Join(dir, "image")
+	require.NoError(t, ioutil.WriteFile(imgPath, content, 0644))
+	checksum, err := fileChecksum(imgPath)
+	require.NoError(t, err)
+
+	info := &Info{
+		ID:     checksum,
+		Sha256: checksum,
+		Size:   uint64(len(content)),
+		Path:   imgPath,
+		Ref:    &Reference{uri: singularity.DockerDomain, tags: []string{"busybox:latest"}},
+	}
+	var archive bytes.Buffer
+	require.NoError(t, Export(&archive, info))
+
+	tamper := func(name string, data []byte) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			entry, err := ioutil.ReadAll(tr)
+			require.NoError(t, err)
+			if hdr.Name == name {
+				entry = data
+				hdr.Size = int64(len(data))
+			}
+			require.NoError(t, tw.WriteHeader(hdr))
+			_, err = tw.Write(entry)
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+		return buf.Bytes()
+	}
+	corrupted := append([]byte{}, content...)
+	corrupted[0] = 'S'
+
+	tt := []struct {
+		name        string
+		archive     []byte
+		expectError string
+	}{
+		{
+			name:    "valid archive",
+			archive: archive.Bytes(),
+		},
+		{
+			name:        "checksum mismatch",
+			archive:     tamper(exportImageName, corrupted),
+			expectError: "checksum mismatch",
+		},
+		{
+			name:        "size mismatch",
+			archive:     tamper(exportImageName, content[1:]),
+			expectError: "image size mismatch",
+		},
+		{
+			name:        "no references",
+			archive:     tamper(exportMetadataName, []byte(`{"id":"`+checksum+`","sha256":"`+checksum+`"}`)),
+			expectError: "image metadata has no references",
+		},
+		{
+			name:        "truncated archive",
+			archive:     archive.Bytes()[:archive.Len()/2],
+			expectError: "could not write image file",
+		},
+		{
+			name:        "empty archive",
+			expectError: "could not read archive",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			importDir, err := ioutil.TempDir(dir, "")
+			require.NoError(t, err)
+
+			imported, err := Import(bytes.NewReader(tc.archive), importDir, importDir)
+			fii, readErr := ioutil.ReadDir(importDir)
+			require.NoError(t, readErr)
+			if tc.expectError != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectError)
+				require.Empty(t, fii, "partial image must be removed")
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, fii, 1)
+			require.Equal(t, filepath.Join(importDir, fii[0].Name()), imported.Path)
+			require.Equal(t, info.ID, imported.ID)
+			require.Equal(t, info.Ref, imported.Ref)
+			require.NoError(t, imported.VerifyIntegrity(true))
+
+			data, err := ioutil.ReadFile(imported.Path)
+			require.NoError(t, err)
+			require.Equal(t, content, data)
+		})
+	}
+}
+
+func TestExportImport_OCILayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	layoutDir := filepath.Join(dir, "layout")
+	config := []byte(`{"config":{"Cmd":["/bin/sh"]}}`)
+	layer := []byte("layer")
+	writeBlob := func(data []byte) descriptor {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+		require.NoError(t, ioutil.WriteFile(layoutBlobPath(layoutDir, digest), data, 0644))
+		return descriptor{Digest: digest, Size: int64(len(data))}
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(layoutDir, layoutBlobsDir, "sha256"), 0755))
+	manifest, err := json.Marshal(layoutManifest{
+		SchemaVersion: 2,
+		Config:        writeBlob(config),
+		Layers:        []descriptor{writeBlob(layer), writeBlob(layer)},
+	})
+	require.NoError(t, err)
+	index, err := json.Marshal(layoutIndex{SchemaVersion: 2, Manifests: []descriptor{writeBlob(manifest)}})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(layoutDir, layoutIndexFile), index, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(layoutDir, layoutVersionFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644))
+
+	info, err := layoutInfo(layoutDir)
+	require.NoError(t, err)
+	info.Ref = &Reference{uri: singularity.DockerDomain, tags: []string{"busybox:latest"}}
+	var archive bytes.Buffer
+	require.NoError(t, Export(&archive, info))
+
+	importDir, err := ioutil.TempDir(dir, "")
+	require.NoError(t, err)
+	imported, err := Import(bytes.NewReader(archive.Bytes()), dir, importDir)
+	require.NoError(t, err)
+	require.Equal(t, importDir, filepath.Dir(imported.Path))
+	require.Equal(t, info.ID, imported.ID)
+	require.Equal(t, SourceOCILayout, imported.SourceFormat)
+	require.NoError(t, imported.VerifyIntegrity(true))
+	paths, err := LayoutLayers(imported.Path)
+	require.NoError(t, err)
+	require.Len(t, paths, 2)
+	data, err := ioutil.ReadFile(paths[0])
+	require.NoError(t, err)
+	require.Equal(t, layer, data)
+
+	// tamper with layer keeping its name and size
+	var tampered bytes.Buffer
+	tw := tar.NewWriter(&tampered)
+	tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		entry, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		if bytes.Equal(entry, layer) {
+			entry = []byte("tayer")
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(entry)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	importDir, err = ioutil.TempDir(dir, "")
+	require.NoError(t, err)
+	_, err = Import(&tampered, dir, importDir)
+	_, ok := err.(*ChecksumError)
+	require.True(t, ok, "tampered layer must be detected, got %v", err)
+	fii, err := ioutil.ReadDir(importDir)
+	require.NoError(t, err)
+	require.Empty(t, fii, "partial layout must be removed")
+}
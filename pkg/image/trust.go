@@ -0,0 +1,116 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TrustScopeAll is a trust rule scope that matches all images.
+const TrustScopeAll = "*"
+
+// TrustRule sets signature requirements for images within Scope, i.e.
+// registry host optionally followed by repository namespace, e.g.
+// cloud.sylabs.io/sylabs or docker.io. When several rules match an
+// image the most specific one is applied.
+type TrustRule struct {
+	Scope string
+	// RequireSigned rejects images that carry no signatures,
+	// including OCI images converted into SIF on pull.
+	RequireSigned bool
+	// Fingerprints is an allow list of signing keys. When set only
+	// signatures made with the listed keys are trusted.
+	Fingerprints []string
+}
+
+// Validate checks rule scope and fingerprints.
+func (r TrustRule) Validate() error {
+	if r.Scope == "" {
+		return fmt.Errorf("trust rule scope is empty")
+	}
+	for _, fp := range r.Fingerprints {
+		fp = normalizeFingerprint(fp)
+		if fp == "" {
+			return fmt.Errorf("trust rule %s has empty fingerprint", r.Scope)
+		}
+		for _, c := range fp {
+			if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'F') {
+				return fmt.Errorf("trust rule %s has invalid fingerprint %q", r.Scope, fp)
+			}
+		}
+	}
+	return nil
+}
+
+// allows checks whether signature made with the key is trusted by rule.
+func (r TrustRule) allows(fingerprint string) bool {
+	if len(r.Fingerprints) == 0 {
+		return true
+	}
+	fingerprint = normalizeFingerprint(fingerprint)
+	for _, fp := range r.Fingerprints {
+		if normalizeFingerprint(fp) == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// restrict marks verified signatures that were made with
+// keys out of the rule allow list as untrusted.
+func (r TrustRule) restrict(results []SignatureResult) {
+	for i, res := range results {
+		if res.Verified() && !r.allows(res.Fingerprint) {
+			results[i].Status = SignatureUntrusted
+			results[i].Error = fmt.Sprintf("signing key is not allowed by %s trust rule", r.Scope)
+		}
+	}
+}
+
+// matchTrustRule returns the most specific rule that matches image
+// referenced by ref. When no rule matches zero rule is returned,
+// which accepts unsigned images and any verified signature.
+func matchTrustRule(rules []TrustRule, ref *Reference) TrustRule {
+	var name string
+	if ref != nil {
+		if parsed, err := ref.parsed(); err == nil {
+			domain, repo := registryRepo(parsed, nil)
+			name = normalizeRegistry(domain) + "/" + repo
+		}
+	}
+
+	var match TrustRule
+	matchLen := -1
+	for _, rule := range rules {
+		if rule.Scope == TrustScopeAll {
+			if matchLen < 0 {
+				match, matchLen = rule, 0
+			}
+			continue
+		}
+		if scope := normalizeRegistry(rule.Scope); matchesRegistry(name, scope) && len(scope) > matchLen {
+			match, matchLen = rule, len(scope)
+		}
+	}
+	return match
+}
+
+// normalizeFingerprint uppercases fingerprint and removes
+// spaces and 0x prefix it is often printed with.
+func normalizeFingerprint(fp string) string {
+	fp = strings.TrimPrefix(strings.TrimPrefix(fp, "0x"), "0X")
+	return strings.ToUpper(strings.Replace(fp, " ", "", -1))
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestMatchTrustRule(t *testing.T) {
+	rules := []TrustRule{
+		{Scope: TrustScopeAll},
+		{Scope: "cloud.sylabs.io", RequireSigned: true},
+		{Scope: "cloud.sylabs.io/sylabs", Fingerprints: []string{"AAAA"}},
+		{Scope: "docker.io/library"},
+		{Scope: "local.file"},
+	}
+
+	tt := []struct {
+		name        string
+		ref         string
+		rules       []TrustRule
+		expectScope string
+	}{
+		{
+			name:        "library namespace",
+			ref:         "library://sylabs/tests/busybox:1.0.0",
+			rules:       rules,
+			expectScope: "cloud.sylabs.io/sylabs",
+		},
+		{
+			name:        "library registry",
+			ref:         "library://sashayakovtseva/test/busybox:latest",
+			rules:       rules,
+			expectScope: "cloud.sylabs.io",
+		},
+		{
+			name:        "namespace prefix is not matched",
+			ref:         "library://sylabsed/tests/busybox:1.0.0",
+			rules:       rules,
+			expectScope: "cloud.sylabs.io",
+		},
+		{
+			name:        "docker hub alias",
+			ref:         "busybox:1.28",
+			rules:       rules,
+			expectScope: "docker.io/library",
+		},
+		{
+			name:        "fallback",
+			ref:         "gcr.io/google-containers/pause:3.1",
+			rules:       rules,
+			expectScope: TrustScopeAll,
+		},
+		{
+			name:        "local file",
+			ref:         "local.file/home/sasha/my.sif",
+			rules:       rules,
+			expectScope: "local.file",
+		},
+		{
+			name: "no rules",
+			ref:  "busybox:1.28",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := ParseRef(tc.ref)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectScope, matchTrustRule(tc.rules, ref).Scope)
+		})
+	}
+}
+
+func TestTrustRule_Validate(t *testing.T) {
+	require.NoError(t, TrustRule{Scope: "*", Fingerprints: []string{"0x8883491f4268f173", "8883 491F"}}.Validate())
+	require.Error(t, TrustRule{}.Validate())
+	require.Error(t, TrustRule{Scope: "docker.io", Fingerprints: []string{""}}.Validate())
+	require.Error(t, TrustRule{Scope: "docker.io", Fingerprints: []string{"XYZ"}}.Validate())
+}
+
+func TestVerifier_TrustRules(t *testing.T) {
+	now := time.Now().Add(time.Minute)
+	vendor := newTestEntity(t, "vendor")
+	site := newTestEntity(t, "site")
+
+	v := NewVerifier(SignaturePolicyAny, nil, TrustRule{Scope: TrustScopeAll, RequireSigned: true, Fingerprints: []string{fingerprint(vendor)}})
+	v.localKeys = func() (openpgp.EntityList, error) {
+		return openpgp.EntityList{vendor, site}, nil
+	}
+	v.now = func() time.Time { return now }
+	rule := matchTrustRule(v.rules, nil)
+
+	results := v.checkSignatures(context.Background(), []rawSignature{sign(t, site, testSIFHash, now)}, testSIFHash)
+	rule.restrict(results)
+	require.Equal(t, SignatureUntrusted, results[0].Status)
+	require.Error(t, v.apply(results))
+
+	results = v.checkSignatures(context.Background(), []rawSignature{
+		sign(t, site, testSIFHash, now),
+		sign(t, vendor, testSIFHash, now),
+	}, testSIFHash)
+	rule.restrict(results)
+	require.Equal(t, SignatureUntrusted, results[0].Status)
+	require.Equal(t, SignatureTrusted, results[1].Status)
+	require.NoError(t, v.apply(results))
+
+	ref, err := ParseRef("busybox:1.28")
+	require.NoError(t, err)
+	err = v.Verify(context.Background(), &Info{Ref: ref, SourceFormat: SourceOCIImage})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "converted from OCI image")
+}
@@ -0,0 +1,413 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/scs-key-client/client"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity-cri/pkg/keys"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	"github.com/sylabs/singularity/pkg/sypgp"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// maxClockSkew is how far in the future signature may be created
+// before a clock skew warning is reported.
+const maxClockSkew = 5 * time.Minute
+
+// SignaturePolicy defines which signatures of a SIF image must be
+// verified for the image to be accepted.
+type SignaturePolicy string
+
+const (
+	// SignaturePolicyAny accepts image when at least one signature is verified.
+	SignaturePolicyAny SignaturePolicy = "any-trusted"
+	// SignaturePolicyAll accepts image only when all its signatures are verified.
+	SignaturePolicyAll SignaturePolicy = "all-trusted"
+)
+
+// ParseSignaturePolicy parses signature policy name,
+// empty name means SignaturePolicyAny.
+func ParseSignaturePolicy(name string) (SignaturePolicy, error) {
+	switch p := SignaturePolicy(name); p {
+	case "":
+		return SignaturePolicyAny, nil
+	case SignaturePolicyAny, SignaturePolicyAll:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown signature policy %q", name)
+	}
+}
+
+// SignatureStatus is a result of a single signature verification.
+type SignatureStatus string
+
+const (
+	// SignatureTrusted means signature is verified with a key from local keyring.
+	SignatureTrusted SignatureStatus = "trusted"
+	// SignatureMatched means signature is verified with a key fetched from keyserver.
+	SignatureMatched SignatureStatus = "matched"
+	// SignatureUnknown means signer key could not be found.
+	SignatureUnknown SignatureStatus = "unknown"
+	// SignatureMismatch means signature or signed data hash does not match.
+	SignatureMismatch SignatureStatus = "mismatch"
+	// SignatureUntrusted means signature is valid, but signing
+	// key is not in the allow list of the matching trust rule.
+	SignatureUntrusted SignatureStatus = "untrusted"
+)
+
+// SignatureResult describes verification of a single SIF signature.
+type SignatureResult struct {
+	Fingerprint string          `json:"fingerprint"`
+	Signer      string          `json:"signer,omitempty"`
+	Status      SignatureStatus `json:"status"`
+	// Warnings are non-fatal issues, e.g. expired key or clock skew.
+	Warnings []string `json:"warnings,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Verified returns true if signature is valid with either local or remote key.
+func (r SignatureResult) Verified() bool {
+	return r.Status == SignatureTrusted || r.Status == SignatureMatched
+}
+
+// KeyGetter fetches armored public keys, e.g. *keys.Client.
+type KeyGetter interface {
+	Get(ctx context.Context, query string, opts keys.SearchOptions) (string, error)
+}
+
+// Verifier checks all signatures of SIF images concurrently
+// and accepts images according to signature policy.
+type Verifier struct {
+	policy    SignaturePolicy
+	keys      KeyGetter
+	rules     []TrustRule
+	localKeys func() (openpgp.EntityList, error)
+	now       func() time.Time
+	flight    keyFlight
+}
+
+// NewVerifier returns verifier that looks up signer keys in the local
+// keyring first and fetches missing ones with the passed key getter.
+// Trust rules may additionally require images to be signed by specific keys.
+func NewVerifier(policy SignaturePolicy, keys KeyGetter, rules ...TrustRule) *Verifier {
+	return &Verifier{
+		policy: policy,
+		keys:   keys,
+		rules:  rules,
+		localKeys: func() (openpgp.EntityList, error) {
+			return sypgp.NewHandle("").LoadPubKeyring()
+		},
+		now: time.Now,
+	}
+}
+
+var (
+	defaultVerifierOnce sync.Once
+	defaultVerifier     *Verifier
+)
+
+// DefaultVerifier returns verifier with SignaturePolicyAny that
+// fetches keys from Singularity default keyserver.
+func DefaultVerifier() *Verifier {
+	defaultVerifierOnce.Do(func() {
+		c, err := keys.NewClient(&client.Config{BaseURL: singularity.KeysServer})
+		if err != nil {
+			// keyserver URL is a constant, this may never happen
+			panic(err)
+		}
+		defaultVerifier = NewVerifier(SignaturePolicyAny, c)
+	})
+	return defaultVerifier
+}
+
+// rawSignature is a signature block of SIF primary partition.
+type rawSignature struct {
+	fingerprint string
+	data        []byte
+}
+
+// Verify checks signatures of the image primary partition and records
+// their results in info.Signatures. Unsigned images are accepted unless
+// trust rule matching the image requires signatures.
+func (v *Verifier) Verify(ctx context.Context, info *Info) error {
+	rule := matchTrustRule(v.rules, info.Ref)
+	// docker images served by the pull-through cache are kept as SIF
+	if info.Ref.URI() == singularity.DockerDomain && info.SourceFormat != SourceOrasSIF && info.SourceFormat != SourceSIF {
+		if rule.RequireSigned {
+			return fmt.Errorf("SIF verification failed: image is converted from OCI image and is not signed, "+
+				"%s trust rule requires signed images", rule.Scope)
+		}
+		return nil
+	}
+	sigs, sifHash, err := readSignatures(info.Path)
+	if err != nil {
+		return fmt.Errorf("SIF verification failed: %v", err)
+	}
+	if len(sigs) == 0 {
+		if rule.RequireSigned {
+			return fmt.Errorf("SIF verification failed: image is not signed, %s trust rule requires signed images", rule.Scope)
+		}
+		glog.V(2).Infof("Image %s is not signed", info.Ref)
+		return nil
+	}
+
+	info.Signatures = v.checkSignatures(ctx, sigs, sifHash)
+	rule.restrict(info.Signatures)
+	for _, res := range info.Signatures {
+		for _, w := range res.Warnings {
+			glog.Warningf("Image %s signature %s: %s", info.Ref, res.Fingerprint, w)
+		}
+	}
+	return v.apply(info.Signatures)
+}
+
+// apply checks signature results against verifier policy.
+func (v *Verifier) apply(results []SignatureResult) error {
+	var failed []string
+	verified := 0
+	for _, res := range results {
+		if res.Verified() {
+			verified++
+			continue
+		}
+		failed = append(failed, fmt.Sprintf("%s: %s (%s)", res.Fingerprint, res.Status, res.Error))
+	}
+	if verified == 0 || (v.policy == SignaturePolicyAll && len(failed) != 0) {
+		return fmt.Errorf("SIF verification failed: %d of %d signatures verified, %s policy is not satisfied: %s",
+			verified, len(results), v.policy, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// readSignatures returns signatures of the SIF primary partition along
+// with its hash in the form it is stored in signature blocks.
+func readSignatures(path string) ([]rawSignature, string, error) {
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not load SIF: %v", err)
+	}
+	defer fimg.UnloadContainer()
+
+	part, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return nil, "", fmt.Errorf("no primary partition found")
+	}
+	descrs, _, err := fimg.GetLinkedDescrsByType(part.ID, sif.DataSignature)
+	if err == sif.ErrNotFound {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("could not find signatures: %v", err)
+	}
+
+	hash := sha512.New384()
+	hash.Write(part.GetData(&fimg))
+	sifHash := fmt.Sprintf("SIFHASH:\n%x", hash.Sum(nil))
+
+	sigs := make([]rawSignature, 0, len(descrs))
+	for _, d := range descrs {
+		fingerprint, err := d.GetEntityString()
+		if err != nil {
+			return nil, "", fmt.Errorf("could not get signature %d fingerprint: %v", d.ID, err)
+		}
+		// data is mapped and is no longer available once container is unloaded
+		data := append([]byte(nil), d.GetData(&fimg)...)
+		sigs = append(sigs, rawSignature{fingerprint: fingerprint, data: data})
+	}
+	return sigs, sifHash, nil
+}
+
+// checkSignatures verifies passed signatures concurrently. Local keyring is
+// loaded once and keys are fetched from keyserver once per fingerprint.
+func (v *Verifier) checkSignatures(ctx context.Context, sigs []rawSignature, sifHash string) []SignatureResult {
+	local, err := v.localKeys()
+	if err != nil {
+		glog.Warningf("Could not load local public keyring: %v", err)
+	}
+
+	results := make([]SignatureResult, len(sigs))
+	var wg sync.WaitGroup
+	for i := range sigs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = v.checkSignature(ctx, sigs[i], sifHash, local)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+func (v *Verifier) checkSignature(ctx context.Context, sig rawSignature, sifHash string, local openpgp.EntityList) SignatureResult {
+	res := SignatureResult{Fingerprint: sig.fingerprint}
+	block, _ := clearsign.Decode(sig.data)
+	if block == nil {
+		res.Status = SignatureMismatch
+		res.Error = "signature block is corrupted"
+		return res
+	}
+	if !bytes.Equal(bytes.TrimRight(block.Plaintext, "\n"), []byte(sifHash)) {
+		res.Status = SignatureMismatch
+		res.Error = "system partition hash differs, data may be corrupted"
+		return res
+	}
+	signature, err := ioutil.ReadAll(block.ArmoredSignature.Body)
+	if err != nil {
+		res.Status = SignatureMismatch
+		res.Error = fmt.Sprintf("could not read signature: %v", err)
+		return res
+	}
+
+	res.Status = SignatureTrusted
+	signer, err := openpgp.CheckDetachedSignature(local, bytes.NewReader(block.Bytes), bytes.NewReader(signature))
+	if err == pgperrors.ErrUnknownIssuer {
+		remote, ferr := v.remoteKeys(ctx, sig.fingerprint)
+		if ferr != nil {
+			res.Status = SignatureUnknown
+			res.Error = ferr.Error()
+			return res
+		}
+		res.Status = SignatureMatched
+		signer, err = openpgp.CheckDetachedSignature(remote, bytes.NewReader(block.Bytes), bytes.NewReader(signature))
+		if err == pgperrors.ErrUnknownIssuer {
+			res.Status = SignatureUnknown
+			res.Error = "keyserver returned key that did not sign the image"
+			return res
+		}
+	}
+	if err != nil {
+		res.Status = SignatureMismatch
+		res.Error = fmt.Sprintf("invalid signature: %v", err)
+		return res
+	}
+	for _, id := range signer.Identities {
+		res.Signer = id.Name
+		break
+	}
+	res.Warnings = signatureWarnings(signer, signature, v.now())
+	return res
+}
+
+// signatureWarnings reports expired keys and signatures as well as
+// signatures which times suggest signer clock was skewed.
+func signatureWarnings(signer *openpgp.Entity, signature []byte, now time.Time) []string {
+	var warnings []string
+	for _, id := range signer.Identities {
+		if id.SelfSignature != nil && id.SelfSignature.KeyExpired(now) {
+			expiry := id.SelfSignature.CreationTime.Add(time.Duration(*id.SelfSignature.KeyLifetimeSecs) * time.Second)
+			warnings = append(warnings, fmt.Sprintf("signing key expired at %s", expiry.UTC().Format(time.RFC3339)))
+			break
+		}
+	}
+
+	p, err := packet.Read(bytes.NewReader(signature))
+	if err != nil {
+		return warnings
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return warnings
+	}
+	if sig.SigLifetimeSecs != nil && *sig.SigLifetimeSecs != 0 {
+		expiry := sig.CreationTime.Add(time.Duration(*sig.SigLifetimeSecs) * time.Second)
+		if now.After(expiry) {
+			warnings = append(warnings, fmt.Sprintf("signature expired at %s", expiry.UTC().Format(time.RFC3339)))
+		}
+	}
+	if sig.CreationTime.After(now.Add(maxClockSkew)) {
+		warnings = append(warnings, fmt.Sprintf("signature is created in the future at %s, clock may be skewed",
+			sig.CreationTime.UTC().Format(time.RFC3339)))
+	}
+	if sig.CreationTime.Before(signer.PrimaryKey.CreationTime) {
+		warnings = append(warnings, fmt.Sprintf("signature at %s predates signing key, clock may be skewed",
+			sig.CreationTime.UTC().Format(time.RFC3339)))
+	}
+	return warnings
+}
+
+// remoteKeys fetches signer key from keyserver. Concurrent
+// fetches of the same fingerprint are done only once.
+func (v *Verifier) remoteKeys(ctx context.Context, fingerprint string) (openpgp.EntityList, error) {
+	if v.keys == nil {
+		return nil, fmt.Errorf("key is not found in local keyring")
+	}
+	return v.flight.do(fingerprint, func() (openpgp.EntityList, error) {
+		armored, err := v.keys.Get(ctx, "0x"+fingerprint, keys.SearchOptions{Exact: true})
+		if keys.IsNotFound(err) {
+			return nil, fmt.Errorf("key is not found in local keyring or on keyserver")
+		}
+		if err != nil {
+			return nil, err
+		}
+		el, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+		if err != nil {
+			return nil, fmt.Errorf("could not read keyserver key: %v", err)
+		}
+		return el, nil
+	})
+}
+
+// keyFlight deduplicates concurrent key fetches, similar to
+// golang.org/x/sync/singleflight which is not vendored.
+type keyFlight struct {
+	mu    sync.Mutex
+	calls map[string]*keyCall
+}
+
+type keyCall struct {
+	wg   sync.WaitGroup
+	keys openpgp.EntityList
+	err  error
+}
+
+// do calls fn unless the call with the same key is in flight already,
+// in which case its result is waited for and returned.
+func (f *keyFlight) do(key string, fn func() (openpgp.EntityList, error)) (openpgp.EntityList, error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]*keyCall)
+	}
+	if c, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		c.wg.Wait()
+		return c.keys, c.err
+	}
+	c := new(keyCall)
+	c.wg.Add(1)
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	c.keys, c.err = fn()
+	c.wg.Done()
+
+	f.mu.Lock()
+	delete(f.calls, key)
+	f.mu.Unlock()
+	return c.keys, c.err
+}
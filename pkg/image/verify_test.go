@@ -0,0 +1,248 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/keys"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+const testSIFHash = "SIFHASH:\n0123456789abcdef"
+
+type fakeKeyGetter struct {
+	keys  map[string]string
+	calls int32
+}
+
+func (f *fakeKeyGetter) Get(ctx context.Context, query string, opts keys.SearchOptions) (string, error) {
+	atomic.AddInt32(&f.calls, 1)
+	armored, ok := f.keys[query]
+	if !ok {
+		return "", &keys.HTTPError{Op: "get keys", StatusCode: http.StatusNotFound}
+	}
+	return armored, nil
+}
+
+func newTestEntity(t *testing.T, name string) *openpgp.Entity {
+	e, err := openpgp.NewEntity(name, "", "", &packet.Config{RSABits: 1024})
+	require.NoError(t, err)
+	return e
+}
+
+func fingerprint(e *openpgp.Entity) string {
+	return fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+}
+
+func armoredPublicKey(t *testing.T, e *openpgp.Entity) string {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, e.Serialize(w))
+	require.NoError(t, w.Close())
+	return buf.String()
+}
+
+func sign(t *testing.T, e *openpgp.Entity, hash string, at time.Time) rawSignature {
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, e.PrivateKey, &packet.Config{Time: func() time.Time { return at }})
+	require.NoError(t, err)
+	_, err = w.Write([]byte(hash))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return rawSignature{fingerprint: fingerprint(e), data: buf.Bytes()}
+}
+
+func TestVerifier_CheckSignatures(t *testing.T) {
+	// signatures must not predate keys which creation time is truncated to seconds
+	now := time.Now().Add(time.Minute)
+	vendor := newTestEntity(t, "vendor")
+	site := newTestEntity(t, "site")
+	stranger := newTestEntity(t, "stranger")
+	expiring := newTestEntity(t, "expiring")
+	for _, id := range expiring.Identities {
+		lifetime := uint32(time.Hour / time.Second)
+		id.SelfSignature.KeyLifetimeSecs = &lifetime
+		require.NoError(t, id.SelfSignature.SignUserId(id.UserId.Id, expiring.PrimaryKey, expiring.PrivateKey, nil))
+	}
+
+	getter := &fakeKeyGetter{
+		keys: map[string]string{
+			"0x" + fingerprint(site):     armoredPublicKey(t, site),
+			"0x" + fingerprint(expiring): armoredPublicKey(t, expiring),
+		},
+	}
+	newVerifier := func(policy SignaturePolicy, at time.Time) *Verifier {
+		v := NewVerifier(policy, getter)
+		v.localKeys = func() (openpgp.EntityList, error) {
+			return openpgp.EntityList{vendor}, nil
+		}
+		v.now = func() time.Time { return at }
+		return v
+	}
+
+	tt := []struct {
+		name          string
+		policy        SignaturePolicy
+		at            time.Time
+		sigs          []rawSignature
+		expectStatus  []SignatureStatus
+		expectWarning []string
+		expectError   bool
+	}{
+		{
+			name:         "local key",
+			policy:       SignaturePolicyAll,
+			at:           now,
+			sigs:         []rawSignature{sign(t, vendor, testSIFHash, now)},
+			expectStatus: []SignatureStatus{SignatureTrusted},
+		},
+		{
+			name:         "trusted second signer",
+			policy:       SignaturePolicyAny,
+			at:           now,
+			sigs:         []rawSignature{sign(t, stranger, testSIFHash, now), sign(t, site, testSIFHash, now)},
+			expectStatus: []SignatureStatus{SignatureUnknown, SignatureMatched},
+		},
+		{
+			name:         "unknown signer with all policy",
+			policy:       SignaturePolicyAll,
+			at:           now,
+			sigs:         []rawSignature{sign(t, vendor, testSIFHash, now), sign(t, stranger, testSIFHash, now)},
+			expectStatus: []SignatureStatus{SignatureTrusted, SignatureUnknown},
+			expectError:  true,
+		},
+		{
+			name:         "hash mismatch",
+			policy:       SignaturePolicyAny,
+			at:           now,
+			sigs:         []rawSignature{sign(t, vendor, "SIFHASH:\nfedcba9876543210", now)},
+			expectStatus: []SignatureStatus{SignatureMismatch},
+			expectError:  true,
+		},
+		{
+			name:         "corrupted signature block",
+			policy:       SignaturePolicyAny,
+			at:           now,
+			sigs:         []rawSignature{{fingerprint: fingerprint(vendor), data: []byte("garbage")}},
+			expectStatus: []SignatureStatus{SignatureMismatch},
+			expectError:  true,
+		},
+		{
+			name:          "expired key",
+			policy:        SignaturePolicyAny,
+			at:            now.Add(2 * time.Hour),
+			sigs:          []rawSignature{sign(t, expiring, testSIFHash, now)},
+			expectStatus:  []SignatureStatus{SignatureMatched},
+			expectWarning: []string{"signing key expired at"},
+		},
+		{
+			name:          "signature from the future",
+			policy:        SignaturePolicyAny,
+			at:            now,
+			sigs:          []rawSignature{sign(t, vendor, testSIFHash, now.Add(time.Hour))},
+			expectStatus:  []SignatureStatus{SignatureTrusted},
+			expectWarning: []string{"clock may be skewed"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newVerifier(tc.policy, tc.at)
+			results := v.checkSignatures(context.Background(), tc.sigs, testSIFHash)
+			var statuses []SignatureStatus
+			var warnings []string
+			for i, res := range results {
+				require.Equal(t, tc.sigs[i].fingerprint, res.Fingerprint)
+				statuses = append(statuses, res.Status)
+				warnings = append(warnings, res.Warnings...)
+			}
+			require.Equal(t, tc.expectStatus, statuses)
+			require.Len(t, warnings, len(tc.expectWarning), "%v", warnings)
+			for i, w := range tc.expectWarning {
+				require.Contains(t, warnings[i], w)
+			}
+
+			err := v.apply(results)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestKeyFlight(t *testing.T) {
+	var f keyFlight
+	var calls int32
+	release := make(chan struct{})
+	fetch := func() (openpgp.EntityList, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil, fmt.Errorf("not found")
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = f.do("F38D871E", fetch)
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, err := range errs {
+		require.EqualError(t, err, "not found")
+	}
+
+	// finished calls are not cached
+	_, err := f.do("F38D871E", fetch)
+	require.Error(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestParseSignaturePolicy(t *testing.T) {
+	for name, expect := range map[string]SignaturePolicy{
+		"":            SignaturePolicyAny,
+		"any-trusted": SignaturePolicyAny,
+		"all-trusted": SignaturePolicyAll,
+	} {
+		policy, err := ParseSignaturePolicy(name)
+		require.NoError(t, err)
+		require.Equal(t, expect, policy)
+	}
+	_, err := ParseSignaturePolicy("none")
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "unknown signature policy"))
+}
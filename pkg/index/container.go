@@ -16,20 +16,32 @@ package index
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/sylabs/singularity-cri/pkg/kube"
 	"github.com/sylabs/singularity-cri/pkg/truncindex"
 )
 
 // ContainerIndex provides a convenient and thread-safe way for storing containers.
+// Container names are unique within a pod, i.e. there may be only one container
+// with the same name and attempt in a pod.
 type ContainerIndex struct {
 	indx *truncindex.TruncIndex
+
+	mu    sync.Mutex
+	names map[string]string
+	// generation is incremented each time container is added or removed.
+	generation uint64
+
+	prober prober
 }
 
 // NewContainerIndex returns new ContainerIndex ready to use.
 func NewContainerIndex() *ContainerIndex {
 	return &ContainerIndex{
-		indx: truncindex.NewTruncIndex(kube.ContainerIDLen),
+		indx:  truncindex.NewTruncIndex(kube.ContainerIDLen),
+		names: make(map[string]string),
 	}
 }
 
@@ -47,27 +59,101 @@ func (i *ContainerIndex) Find(id string) (*kube.Container, error) {
 	return cont, nil
 }
 
+// FindByName searches for container in the given pod by its name and attempt.
+func (i *ContainerIndex) FindByName(podID, name string, attempt uint32) (*kube.Container, error) {
+	i.mu.Lock()
+	id, ok := i.names[containerName(podID, name, attempt)]
+	i.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return i.Find(id)
+}
+
+// FindAttempts returns all attempts of the container with the given name in the pod.
+func (i *ContainerIndex) FindAttempts(podID, name string) []*kube.Container {
+	prefix := containerName(podID, name, 0)
+	prefix = prefix[:len(prefix)-1]
+
+	i.mu.Lock()
+	var ids []string
+	for key, id := range i.names {
+		if strings.HasPrefix(key, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	i.mu.Unlock()
+
+	var containers []*kube.Container
+	for _, id := range ids {
+		cont, err := i.Find(id)
+		if err == nil {
+			containers = append(containers, cont)
+		}
+	}
+	return containers
+}
+
 // Remove removes container from index if it present or does nothing otherwise.
 func (i *ContainerIndex) Remove(id string) error {
-	err := i.indx.Delete(id)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	item, err := i.indx.Get(id)
 	if err == truncindex.ErrNotFound {
 		return nil
 	}
 	if err != nil {
 		return fmt.Errorf("could not remove container: %v", err)
 	}
+	cont, _ := item.(*kube.Container)
+	if err := i.indx.Delete(cont.ID()); err != nil {
+		return fmt.Errorf("could not remove container: %v", err)
+	}
+	if md := cont.GetMetadata(); md != nil {
+		delete(i.names, containerName(cont.PodID(), md.GetName(), md.GetAttempt()))
+	}
+	i.generation++
 	return nil
 }
 
-// Add adds the given container. If container already exists it returns an error.
+// Add adds the given container. If container with the same ID already exists
+// ErrIDExists is returned. If there is another container with the same name and attempt in the same pod
+// ErrAlreadyExists is returned.
 func (i *ContainerIndex) Add(cont *kube.Container) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var name string
+	if md := cont.GetMetadata(); md != nil {
+		name = containerName(cont.PodID(), md.GetName(), md.GetAttempt())
+		if _, ok := i.names[name]; ok {
+			return ErrAlreadyExists
+		}
+	}
 	err := i.indx.Add(cont.ID(), cont)
+	if err == truncindex.ErrAlreadyExists {
+		return ErrIDExists
+	}
 	if err != nil {
 		return fmt.Errorf("could not add container: %v", err)
 	}
+	if name != "" {
+		i.names[name] = cont.ID()
+	}
+	i.generation++
 	return nil
 }
 
+// Generation returns a number that changes each time the set of containers
+// in index changes. Equal generations guarantee that the same containers are
+// registered, but not that their state is unchanged.
+func (i *ContainerIndex) Generation() uint64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.generation
+}
+
 // Iterate calls handler func on each container registered in index.
 func (i *ContainerIndex) Iterate(handler func(*kube.Container)) {
 	innerIterate := func(key string, item interface{}) {
@@ -75,3 +161,7 @@ func (i *ContainerIndex) Iterate(handler func(*kube.Container)) {
 	}
 	i.indx.Iterate(innerIterate)
 }
+
+func containerName(podID, name string, attempt uint32) string {
+	return fmt.Sprintf("%s_%s_%d", podID, name, attempt)
+}
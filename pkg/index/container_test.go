@@ -20,6 +20,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/sylabs/singularity-cri/pkg/image"
 	"github.com/sylabs/singularity-cri/pkg/kube"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
 func TestContainerIndex(t *testing.T) {
@@ -98,3 +99,63 @@ func TestContainerIndex(t *testing.T) {
 		require.Equal(t, 2, count, "unexpected index contents")
 	})
 }
+
+func TestContainerIndex_FindByName(t *testing.T) {
+	indx := NewContainerIndex()
+
+	pod := kube.NewPod(nil)
+	config := &k8s.ContainerConfig{
+		Metadata: &k8s.ContainerMetadata{
+			Name:    "busybox",
+			Attempt: 1,
+		},
+	}
+	busybox := kube.NewContainer(config, pod, &image.Info{}, "")
+	duplicate := kube.NewContainer(config, pod, &image.Info{}, "")
+
+	require.NoError(t, indx.Add(busybox), "could not add container")
+	require.Equal(t, ErrAlreadyExists, indx.Add(duplicate), "duplicate container was added")
+
+	found, err := indx.FindByName(pod.ID(), "busybox", 1)
+	require.NoError(t, err, "index returned unexpected error")
+	require.Equal(t, busybox, found, "index returned wrong container")
+
+	found, err = indx.FindByName(pod.ID(), "busybox", 2)
+	require.Equal(t, ErrNotFound, err, "index didn't return ErrNotFound")
+	require.Nil(t, found, "index returned unexpected container")
+
+	nextAttempt := kube.NewContainer(&k8s.ContainerConfig{
+		Metadata: &k8s.ContainerMetadata{
+			Name:    "busybox",
+			Attempt: 2,
+		},
+	}, pod, &image.Info{}, "")
+	require.NoError(t, indx.Add(nextAttempt), "could not add next attempt")
+	require.ElementsMatch(t, []*kube.Container{busybox, nextAttempt}, indx.FindAttempts(pod.ID(), "busybox"))
+	require.Empty(t, indx.FindAttempts(pod.ID(), "busy"), "unexpected attempts found")
+
+	require.NoError(t, indx.Remove(busybox.ID()), "could not remove container")
+	require.NoError(t, indx.Add(duplicate), "name was not released on remove")
+
+	anonymous := kube.NewContainer(&k8s.ContainerConfig{}, pod, &image.Info{}, "")
+	require.NoError(t, indx.Add(anonymous), "could not add container")
+	require.Equal(t, ErrIDExists, indx.Add(anonymous), "container with the same ID was added")
+}
+
+func TestContainerIndex_Generation(t *testing.T) {
+	indx := NewContainerIndex()
+	busybox := kube.NewContainer(nil, nil, &image.Info{}, "")
+
+	gen := indx.Generation()
+	require.NoError(t, indx.Remove(busybox.ID()))
+	require.Equal(t, gen, indx.Generation(), "removing absent container changed generation")
+
+	require.NoError(t, indx.Add(busybox))
+	require.NotEqual(t, gen, indx.Generation(), "add didn't change generation")
+	gen = indx.Generation()
+	require.Equal(t, ErrIDExists, indx.Add(busybox))
+	require.Equal(t, gen, indx.Generation(), "failed add changed generation")
+
+	require.NoError(t, indx.Remove(busybox.ID()))
+	require.NotEqual(t, gen, indx.Generation(), "remove didn't change generation")
+}
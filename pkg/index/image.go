@@ -16,9 +16,12 @@ package index
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 
+	"github.com/golang/glog"
 	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/reference"
 	"github.com/sylabs/singularity-cri/pkg/truncindex"
 )
 
@@ -28,6 +31,9 @@ type ImageIndex struct {
 
 	mu      sync.RWMutex
 	refToID map[string]string
+
+	prober prober
+	usage  usageScanner
 }
 
 // NewImageIndex returns new ImageIndex ready to use.
@@ -40,11 +46,16 @@ func NewImageIndex() *ImageIndex {
 
 // Find searches for expectImage info by its ID or prefix or any of tags.
 // This method may return error if prefix is not long enough to identify expectImage uniquely.
-// If image is not found ErrNotFound is returned.
+// Short references, e.g. tag without repository domain or bare digest, are resolved only
+// when they match a single image. If image is not found ErrNotFound is returned.
 func (i *ImageIndex) Find(id string) (*image.Info, error) {
-	info, err := i.find(id)
+	info, err := i.find(strings.TrimPrefix(id, "sha256:"))
 	if err == ErrNotFound {
-		id = i.readRef(image.NormalizedImageRef(id))
+		ref := familiar(id)
+		id = i.readRef(ref)
+		if id == "" {
+			id = i.resolveShortRef(ref)
+		}
 		if id == "" {
 			return nil, ErrNotFound
 		}
@@ -79,9 +90,44 @@ func (i *ImageIndex) Remove(id string) error {
 
 	i.removeRefs(imgInfo.Ref.Tags()...)
 	i.removeRefs(imgInfo.Ref.Digests()...)
+	i.usage.forgetImage(imgInfo.ID)
 	return nil
 }
 
+// Untag removes the passed tag from the image it references unless it is the
+// last image tag, in which case image should be removed altogether. Image IDs
+// and digests are never treated as tags. It returns whether tag was removed.
+func (i *ImageIndex) Untag(ref string) (bool, error) {
+	if _, err := i.find(strings.TrimPrefix(ref, "sha256:")); err != ErrNotFound {
+		return false, err
+	}
+	tag := familiar(ref)
+	if i.readRef(tag) == "" {
+		tag = i.resolveShortTag(tag)
+	}
+	if tag == "" {
+		return false, nil
+	}
+	info, err := i.find(i.readRef(tag))
+	if err != nil {
+		return false, err
+	}
+	tags := info.Ref.Tags()
+	if len(tags) < 2 {
+		return false, nil
+	}
+	found := false
+	for _, t := range tags {
+		found = found || t == tag
+	}
+	if !found {
+		return false, nil
+	}
+	info.Ref.RemoveTag(tag)
+	i.removeRefs(tag)
+	return true, nil
+}
+
 // Iterate calls handler func on each pod registered in index.
 func (i *ImageIndex) Iterate(handler func(image *image.Info)) {
 	innerIterate := func(key string, item interface{}) {
@@ -116,27 +162,37 @@ func (i *ImageIndex) add(image *image.Info) error {
 	for _, digest := range image.Ref.Digests() {
 		i.setRef(digest, image.ID)
 	}
+	i.usage.trackImage(image)
 	return nil
 }
 
 func (i *ImageIndex) merge(oldImage, image *image.Info) error {
 	oldImage.Ref.AddTags(image.Ref.Tags())
 	oldImage.Ref.AddDigests(image.Ref.Digests())
+	if len(oldImage.Layers) == 0 {
+		oldImage.Layers = image.Layers
+	}
+	if oldImage.Labels == nil {
+		oldImage.Labels = image.Labels
+		oldImage.DroppedLabels = image.DroppedLabels
+	}
 
 	for _, tag := range image.Ref.Tags() {
 		oldID := i.readRef(tag)
 		i.setRef(tag, image.ID)
 		if oldID != "" && oldID != image.ID {
-			oldInfo, _ := i.Find(image.ID)
-			oldInfo.Ref.RemoveTag(tag)
+			if oldInfo, err := i.find(oldID); err == nil {
+				oldInfo.Ref.RemoveTag(tag)
+			}
 		}
 	}
 	for _, digest := range image.Ref.Digests() {
 		oldID := i.readRef(digest)
 		i.setRef(digest, image.ID)
-		if oldID != "" && oldID == image.ID {
-			oldInfo, _ := i.Find(image.ID)
-			oldInfo.Ref.RemoveDigest(digest)
+		if oldID != "" && oldID != image.ID {
+			if oldInfo, err := i.find(oldID); err == nil {
+				oldInfo.Ref.RemoveDigest(digest)
+			}
 		}
 	}
 	return nil
@@ -166,6 +222,55 @@ func (i *ImageIndex) removeOverlapRefs(image *image.Info) error {
 	return nil
 }
 
+// resolveShortRef looks for an image with a tag or digest ending with the
+// passed short reference. Empty string is returned if nothing matches or
+// reference is ambiguous, i.e. it matches more than one image.
+func (i *ImageIndex) resolveShortRef(ref string) string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var id string
+	for fullRef, refID := range i.refToID {
+		if !strings.HasSuffix(fullRef, "/"+ref) && !strings.HasSuffix(fullRef, "@"+ref) {
+			continue
+		}
+		if id != "" && id != refID {
+			glog.V(4).Infof("Image reference %s is ambiguous, matches both %s and %s", ref, id, refID)
+			return ""
+		}
+		id = refID
+	}
+	return id
+}
+
+// resolveShortTag looks for a single tag ending with the passed short reference.
+// Empty string is returned if nothing matches or reference is ambiguous.
+func (i *ImageIndex) resolveShortTag(ref string) string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var tag string
+	for fullRef := range i.refToID {
+		if !strings.HasSuffix(fullRef, "/"+ref) {
+			continue
+		}
+		if tag != "" {
+			return ""
+		}
+		tag = fullRef
+	}
+	return tag
+}
+
+// familiar returns reference in the form images are indexed by. References
+// that cannot be parsed are returned as is, they will not be found anyway.
+func familiar(ref string) string {
+	if f, err := reference.Familiar(ref); err == nil {
+		return f
+	}
+	return ref
+}
+
 func (i *ImageIndex) readRef(ref string) string {
 	i.mu.RLock()
 	defer i.mu.RUnlock()
@@ -199,4 +199,174 @@ func AdvancedTestImageIndex(t *testing.T) {
 		})
 		require.Equal(t, 2, count)
 	})
+
+	t.Run("move tag to existing image", func(t *testing.T) {
+		indx := NewImageIndex()
+		oldRef, err := image.ParseRef("busybox:1.28")
+		require.NoError(t, err, "could not parse busybox ref")
+		newRef, err := image.ParseRef("busybox:latest")
+		require.NoError(t, err, "could not parse busybox ref")
+		require.NoError(t, indx.Add(&image.Info{ID: "oldbusybox", Ref: oldRef}))
+		require.NoError(t, indx.Add(&image.Info{ID: "newbusybox", Ref: newRef}))
+
+		movedRef, err := image.ParseRef("busybox:latest")
+		require.NoError(t, err, "could not parse busybox ref")
+		err = indx.Add(&image.Info{ID: "oldbusybox", Ref: movedRef})
+		require.NoError(t, err)
+
+		found, err := indx.Find("busybox:latest")
+		require.NoError(t, err, "index returned unexpected error")
+		require.Equal(t, "oldbusybox", found.ID, "index returned wrong image")
+		require.ElementsMatch(t, []string{"busybox:1.28", "busybox:latest"}, found.Ref.Tags())
+
+		found, err = indx.Find("newbusybox")
+		require.NoError(t, err, "index returned unexpected error")
+		require.Empty(t, found.Ref.Tags(), "tag was not removed from previous image")
+	})
+}
+
+func TestImageIndex_ShortRef(t *testing.T) {
+	indx := NewImageIndex()
+
+	for _, img := range []struct {
+		id  string
+		ref string
+	}{
+		{id: "busyboxgcr", ref: "gcr.io/foo/busybox:1.28"},
+		{id: "busyboxquay", ref: "quay.io/bar/busybox:1.28"},
+		{id: "pause", ref: "gcr.io/google-containers/pause:3.1"},
+		{id: "nginx", ref: "nginx@sha256:31b8e90a349d1fce7621f5a5a08e4fc519b634f7d3feb09d53fac9b12aa4d991"},
+	} {
+		ref, err := image.ParseRef(img.ref)
+		require.NoError(t, err, "could not parse ref")
+		require.NoError(t, indx.Add(&image.Info{ID: img.id, Ref: ref}), "could not add image")
+	}
+
+	tt := []struct {
+		name     string
+		ref      string
+		expectID string
+	}{
+		{
+			name:     "full tag",
+			ref:      "gcr.io/foo/busybox:1.28",
+			expectID: "busyboxgcr",
+		},
+		{
+			name:     "unambiguous short tag",
+			ref:      "pause:3.1",
+			expectID: "pause",
+		},
+		{
+			name: "ambiguous short tag",
+			ref:  "busybox:1.28",
+		},
+		{
+			name:     "bare digest",
+			ref:      "sha256:31b8e90a349d1fce7621f5a5a08e4fc519b634f7d3feb09d53fac9b12aa4d991",
+			expectID: "nginx",
+		},
+		{
+			name:     "truncated id",
+			ref:      "busyboxq",
+			expectID: "busyboxquay",
+		},
+		{
+			name:     "truncated id with algorithm",
+			ref:      "sha256:busyboxq",
+			expectID: "busyboxquay",
+		},
+		{
+			name: "unknown",
+			ref:  "alpine:3.8",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			found, err := indx.Find(tc.ref)
+			if tc.expectID == "" {
+				require.Equal(t, ErrNotFound, err, "index didn't return ErrNotFound")
+				require.Nil(t, found, "index returned unexpected image")
+				return
+			}
+			require.NoError(t, err, "index returned unexpected error")
+			require.Equal(t, tc.expectID, found.ID, "index returned wrong image")
+		})
+	}
+}
+
+func TestImageIndex_Untag(t *testing.T) {
+	indx := NewImageIndex()
+	for _, img := range []struct {
+		id   string
+		refs []string
+	}{
+		{id: "app", refs: []string{"gcr.io/foo/app:old", "gcr.io/foo/app:stable"}},
+		{id: "busybox", refs: []string{"busybox:1.28", "busybox@sha256:141c253bc4c3fd0a201d32dc1f493bcf3fff003b6df416dea4f41046e0f37d47"}},
+	} {
+		for _, r := range img.refs {
+			ref, err := image.ParseRef(r)
+			require.NoError(t, err, "could not parse ref")
+			require.NoError(t, indx.Add(&image.Info{ID: img.id, Ref: ref}), "could not add image")
+		}
+	}
+
+	tt := []struct {
+		name        string
+		ref         string
+		expectUntag bool
+		expectID    string
+		expectTags  []string
+	}{
+		{
+			name:     "by id",
+			ref:      "app",
+			expectID: "app",
+			expectTags: []string{
+				"gcr.io/foo/app:old",
+				"gcr.io/foo/app:stable",
+			},
+		},
+		{
+			name:        "short tag",
+			ref:         "app:old",
+			expectUntag: true,
+			expectID:    "app",
+			expectTags:  []string{"gcr.io/foo/app:stable"},
+		},
+		{
+			name:       "last tag",
+			ref:        "gcr.io/foo/app:stable",
+			expectID:   "app",
+			expectTags: []string{"gcr.io/foo/app:stable"},
+		},
+		{
+			name:       "digest",
+			ref:        "busybox@sha256:141c253bc4c3fd0a201d32dc1f493bcf3fff003b6df416dea4f41046e0f37d47",
+			expectID:   "busybox",
+			expectTags: []string{"busybox:1.28"},
+		},
+		{
+			name: "unknown",
+			ref:  "alpine:3.8",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			untagged, err := indx.Untag(tc.ref)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectUntag, untagged)
+			if tc.expectID == "" {
+				return
+			}
+			info, err := indx.Find(tc.expectID)
+			require.NoError(t, err)
+			require.ElementsMatch(t, tc.expectTags, info.Ref.Tags())
+		})
+	}
+
+	_, err := indx.Find("app:old")
+	require.Equal(t, ErrNotFound, err, "untagged reference is still resolved")
 }
@@ -0,0 +1,268 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	"github.com/sylabs/singularity/pkg/util/fs/proc"
+)
+
+// FsUsage is disk space and inodes taken by stored images
+// and image caches on a single filesystem.
+type FsUsage struct {
+	// Device is ID of the device filesystem is on.
+	Device uint64
+	// UUID is filesystem UUID, empty if filesystem has none.
+	UUID string
+	// MountPoint is where filesystem is mounted.
+	MountPoint string
+	Bytes      uint64
+	Inodes     uint64
+}
+
+// diskUsage is disk space taken by a single image or directory.
+type diskUsage struct {
+	device uint64
+	path   string
+	bytes  uint64
+	inodes uint64
+}
+
+// fsIdentity is filesystem UUID and mount point looked up once per device.
+type fsIdentity struct {
+	uuid       string
+	mountPoint string
+}
+
+// usageScanner tracks on-disk size of each image as images are added to or
+// removed from index, and periodically rescans cache directories, e.g. blob
+// store, that grow and shrink on their own. Zero value is ready to use.
+type usageScanner struct {
+	mu      sync.Mutex
+	dirs    []string
+	images  map[string]diskUsage
+	dirSize []diskUsage
+	scanned time.Time
+	fsIDs   map[uint64]fsIdentity
+}
+
+// TrackUsage sets directories, e.g. image layer cache, that are
+// included in usage along with stored image files.
+func (i *ImageIndex) TrackUsage(dirs ...string) {
+	i.usage.mu.Lock()
+	defer i.usage.mu.Unlock()
+	i.usage.dirs = dirs
+	i.usage.scanned = time.Time{}
+}
+
+// Usage returns disk usage of stored images and tracked directories grouped
+// by filesystem. Usage is rescanned when the last scan is older than maxAge.
+func (i *ImageIndex) Usage(maxAge time.Duration) ([]FsUsage, error) {
+	i.usage.mu.Lock()
+	stale := time.Since(i.usage.scanned) > maxAge
+	i.usage.mu.Unlock()
+	if stale {
+		if err := i.RefreshUsage(); err != nil {
+			return nil, err
+		}
+	}
+	return i.usage.totals(), nil
+}
+
+// RefreshUsage rescans image files and tracked directories.
+func (i *ImageIndex) RefreshUsage() error {
+	images := make(map[string]diskUsage)
+	var missing []string
+	i.Iterate(func(info *image.Info) {
+		if usage, ok := imageUsage(info); ok {
+			images[info.ID] = usage
+		} else {
+			missing = append(missing, info.ID)
+		}
+	})
+
+	i.usage.mu.Lock()
+	dirs := i.usage.dirs
+	i.usage.mu.Unlock()
+	dirSize := make([]diskUsage, 0, len(dirs))
+	for _, dir := range dirs {
+		usage, err := scanDir(dir)
+		if err != nil {
+			return fmt.Errorf("could not scan %s: %v", dir, err)
+		}
+		dirSize = append(dirSize, usage...)
+	}
+
+	i.usage.mu.Lock()
+	defer i.usage.mu.Unlock()
+	if i.usage.images == nil {
+		i.usage.images = make(map[string]diskUsage)
+	}
+	// images removed during scan must not be counted again
+	for id, usage := range images {
+		if _, err := i.find(id); err == nil {
+			i.usage.images[id] = usage
+		}
+	}
+	for _, id := range missing {
+		delete(i.usage.images, id)
+	}
+	i.usage.dirSize = dirSize
+	i.usage.scanned = time.Now()
+	return nil
+}
+
+// trackImage records on-disk size of the image file.
+func (s *usageScanner) trackImage(info *image.Info) {
+	usage, ok := imageUsage(info)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.images == nil {
+		s.images = make(map[string]diskUsage)
+	}
+	s.images[info.ID] = usage
+}
+
+// forgetImage drops image from usage once it is removed.
+func (s *usageScanner) forgetImage(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.images, id)
+}
+
+// totals aggregates usage per filesystem ordered by mount point.
+func (s *usageScanner) totals() []FsUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byDevice := make(map[uint64]*FsUsage)
+	add := func(usage diskUsage) {
+		total, ok := byDevice[usage.device]
+		if !ok {
+			id := s.identify(usage.device, usage.path)
+			total = &FsUsage{
+				Device:     usage.device,
+				UUID:       id.uuid,
+				MountPoint: id.mountPoint,
+			}
+			byDevice[usage.device] = total
+		}
+		total.Bytes += usage.bytes
+		total.Inodes += usage.inodes
+	}
+	for _, usage := range s.dirSize {
+		add(usage)
+	}
+	for _, usage := range s.images {
+		add(usage)
+	}
+
+	totals := make([]FsUsage, 0, len(byDevice))
+	for _, total := range byDevice {
+		totals = append(totals, *total)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		return totals[i].MountPoint < totals[j].MountPoint
+	})
+	return totals
+}
+
+// identify returns UUID and mount point of filesystem path resides on.
+// It must be called with s.mu held.
+func (s *usageScanner) identify(device uint64, path string) fsIdentity {
+	if id, ok := s.fsIDs[device]; ok {
+		return id
+	}
+	mountPoint, err := proc.ParentMount(path)
+	if err != nil {
+		glog.Errorf("Could not get mount point of %s: %v", path, err)
+	}
+	id := fsIdentity{
+		uuid:       fs.DeviceUUID(device),
+		mountPoint: mountPoint,
+	}
+	if s.fsIDs == nil {
+		s.fsIDs = make(map[uint64]fsIdentity)
+	}
+	s.fsIDs[device] = id
+	return id
+}
+
+// imageUsage returns on-disk size of the image file. Local SIF
+// files are not stored by CRI and so are not counted.
+func imageUsage(info *image.Info) (diskUsage, bool) {
+	if info.Path == "" || info.Ref == nil || info.Ref.URI() == singularity.LocalFileDomain {
+		return diskUsage{}, false
+	}
+	fi, err := os.Lstat(info.Path)
+	if err != nil {
+		glog.V(4).Infof("Could not stat image %s: %v", info.ID, err)
+		return diskUsage{}, false
+	}
+	device, bytes := fs.DiskUsage(fi)
+	return diskUsage{
+		device: device,
+		path:   info.Path,
+		bytes:  bytes,
+		inodes: 1,
+	}, true
+}
+
+// scanDir walks directory and returns its usage per device, so
+// that mount points under directory are accounted separately.
+// Missing directory takes no space.
+func scanDir(dir string) ([]diskUsage, error) {
+	byDevice := make(map[uint64]*diskUsage)
+	var order []uint64
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		device, bytes := fs.DiskUsage(fi)
+		usage, ok := byDevice[device]
+		if !ok {
+			usage = &diskUsage{device: device, path: path}
+			byDevice[device] = usage
+			order = append(order, device)
+		}
+		usage.bytes += bytes
+		usage.inodes++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	usage := make([]diskUsage, 0, len(order))
+	for _, device := range order {
+		usage = append(usage, *byDevice[device])
+	}
+	return usage, nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/image"
+)
+
+func TestImageIndex_Usage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-usage-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	blobs := filepath.Join(dir, "blobs")
+	require.NoError(t, os.MkdirAll(filepath.Join(blobs, "sha256"), 0755))
+	write := func(path string, size int) {
+		require.NoError(t, ioutil.WriteFile(path, make([]byte, size), 0644))
+	}
+	write(filepath.Join(blobs, "sha256", "a"), 8192)
+
+	indx := NewImageIndex()
+	indx.TrackUsage(blobs)
+	addImage := func(id, ref string, size int) {
+		r, err := image.ParseRef(ref)
+		require.NoError(t, err)
+		path := filepath.Join(dir, id)
+		write(path, size)
+		require.NoError(t, indx.Add(&image.Info{ID: id, Ref: r, Path: path}))
+	}
+	addImage("busybox", "busybox:1.28", 16384)
+	addImage("local", "local.file"+filepath.Join(dir, "local"), 16384)
+
+	total := func(maxAge time.Duration) FsUsage {
+		usage, err := indx.Usage(maxAge)
+		require.NoError(t, err)
+		require.Len(t, usage, 1)
+		require.NotEmpty(t, usage[0].MountPoint)
+		return usage[0]
+	}
+
+	// blob directories, blob and busybox image; local image is not stored by CRI
+	usage := total(time.Minute)
+	require.Equal(t, uint64(4), usage.Inodes)
+	require.True(t, usage.Bytes >= 8192+16384, "usage %d is less than stored data", usage.Bytes)
+
+	// pulled image is accounted without rescan
+	addImage("alpine", "alpine:3.8", 16384)
+	usage = total(time.Minute)
+	require.Equal(t, uint64(5), usage.Inodes)
+	require.True(t, usage.Bytes >= 8192+2*16384, "usage %d is less than stored data", usage.Bytes)
+
+	// new blobs are accounted once cache is rescanned
+	write(filepath.Join(blobs, "sha256", "b"), 8192)
+	require.Equal(t, uint64(5), total(time.Minute).Inodes)
+	require.NoError(t, indx.RefreshUsage())
+	require.Equal(t, uint64(6), total(time.Minute).Inodes)
+
+	// removed image is not accounted
+	require.NoError(t, indx.Remove("alpine"))
+	require.Equal(t, uint64(5), total(time.Minute).Inodes)
+
+	// image file that disappeared is dropped on rescan
+	require.NoError(t, os.Remove(filepath.Join(dir, "busybox")))
+	require.Equal(t, uint64(4), total(0).Inodes)
+}
+
+func TestScanDir_Missing(t *testing.T) {
+	usage, err := scanDir("/no/such/dir")
+	require.NoError(t, err)
+	require.Empty(t, usage)
+}
@@ -16,25 +16,41 @@ package index
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/sylabs/singularity-cri/pkg/kube"
 	"github.com/sylabs/singularity-cri/pkg/truncindex"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
 // PodIndex provides a convenient and thread-safe way for storing pods.
+// Pod metadata is unique within index, i.e. there may be only one pod with
+// the same name, namespace, uid and attempt.
 type PodIndex struct {
 	indx *truncindex.TruncIndex
+
+	mu    sync.Mutex
+	names map[string]string
+
+	prober prober
 }
 
 var (
 	// ErrNotFound is returned when object is not found in index.
 	ErrNotFound = fmt.Errorf("not found")
+	// ErrAlreadyExists is returned when object with the same metadata
+	// is already present in index.
+	ErrAlreadyExists = fmt.Errorf("already exists")
+	// ErrIDExists is returned when object with the same ID is already
+	// present in index, existing object is left intact.
+	ErrIDExists = fmt.Errorf("id already exists")
 )
 
 // NewPodIndex returns new PodIndex ready to use.
 func NewPodIndex() *PodIndex {
 	return &PodIndex{
-		indx: truncindex.NewTruncIndex(kube.PodIDLen),
+		indx:  truncindex.NewTruncIndex(kube.PodIDLen),
+		names: make(map[string]string),
 	}
 }
 
@@ -52,24 +68,62 @@ func (i *PodIndex) Find(id string) (*kube.Pod, error) {
 	return pod, nil
 }
 
+// FindByMetadata searches for pod by its name, namespace, uid and attempt.
+func (i *PodIndex) FindByMetadata(md *k8s.PodSandboxMetadata) (*kube.Pod, error) {
+	i.mu.Lock()
+	id, ok := i.names[podName(md)]
+	i.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return i.Find(id)
+}
+
 // Remove removes pod from index if it present or returns otherwise.
 func (i *PodIndex) Remove(id string) error {
-	err := i.indx.Delete(id)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	item, err := i.indx.Get(id)
 	if err == truncindex.ErrNotFound {
 		return nil
 	}
 	if err != nil {
 		return fmt.Errorf("could not remove pod: %v", err)
 	}
+	pod, _ := item.(*kube.Pod)
+	if err := i.indx.Delete(pod.ID()); err != nil {
+		return fmt.Errorf("could not remove pod: %v", err)
+	}
+	if md := pod.GetMetadata(); md != nil {
+		delete(i.names, podName(md))
+	}
 	return nil
 }
 
-// Add adds the given pod. If pod already exists it returns an error.
+// Add adds the given pod. If pod with the same ID already exists ErrIDExists
+// is returned. If there is another pod with the same metadata ErrAlreadyExists is returned.
 func (i *PodIndex) Add(pod *kube.Pod) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var name string
+	if md := pod.GetMetadata(); md != nil {
+		name = podName(md)
+		if _, ok := i.names[name]; ok {
+			return ErrAlreadyExists
+		}
+	}
 	err := i.indx.Add(pod.ID(), pod)
+	if err == truncindex.ErrAlreadyExists {
+		return ErrIDExists
+	}
 	if err != nil {
 		return fmt.Errorf("could not add pod: %v", err)
 	}
+	if name != "" {
+		i.names[name] = pod.ID()
+	}
 	return nil
 }
 
@@ -80,3 +134,7 @@ func (i *PodIndex) Iterate(handler func(*kube.Pod)) {
 	}
 	i.indx.Iterate(innerIterate)
 }
+
+func podName(md *k8s.PodSandboxMetadata) string {
+	return fmt.Sprintf("%s_%s_%s_%d", md.GetName(), md.GetNamespace(), md.GetUid(), md.GetAttempt())
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"github.com/sylabs/singularity-cri/pkg/kube"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
 func TestPodIndex(t *testing.T) {
@@ -97,3 +98,43 @@ func TestPodIndex(t *testing.T) {
 		require.Equal(t, 2, count, "unexpected index contents")
 	})
 }
+
+func TestPodIndex_FindByMetadata(t *testing.T) {
+	indx := NewPodIndex()
+
+	config := &k8s.PodSandboxConfig{
+		Metadata: &k8s.PodSandboxMetadata{
+			Name:      "busybox",
+			Namespace: "default",
+			Uid:       "1",
+		},
+	}
+	busybox := kube.NewPod(config)
+	duplicate := kube.NewPod(config)
+
+	require.NoError(t, indx.Add(busybox), "could not add pod")
+	require.Equal(t, ErrAlreadyExists, indx.Add(duplicate), "duplicate pod was added")
+
+	found, err := indx.FindByMetadata(config.Metadata)
+	require.NoError(t, err, "index returned unexpected error")
+	require.Equal(t, busybox, found, "index returned wrong pod")
+
+	found, err = indx.FindByMetadata(&k8s.PodSandboxMetadata{
+		Name:      "busybox",
+		Namespace: "default",
+		Uid:       "1",
+		Attempt:   1,
+	})
+	require.Equal(t, ErrNotFound, err, "index didn't return ErrNotFound")
+	require.Nil(t, found, "index returned unexpected pod")
+
+	require.NoError(t, indx.Remove(busybox.ID()), "could not remove pod")
+	require.NoError(t, indx.Add(duplicate), "metadata was not released on remove")
+
+	anonymous := kube.NewPod(&k8s.PodSandboxConfig{})
+	require.NoError(t, indx.Add(anonymous), "could not add pod")
+	require.Equal(t, ErrIDExists, indx.Add(anonymous), "pod with the same ID was added")
+	found, err = indx.Find(anonymous.ID())
+	require.NoError(t, err, "index returned unexpected error")
+	require.Equal(t, anonymous, found, "index returned wrong pod")
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrProbeTimeout is returned by index probes when index lock is
+// not acquired within the passed timeout.
+var ErrProbeTimeout = fmt.Errorf("lock is not acquired in time")
+
+// prober checks that a lock may be acquired in a bounded time. Probe that
+// timed out is left waiting for the lock and no new probes are started
+// until it completes, so that a deadlocked index doesn't leak goroutines.
+type prober struct {
+	pending int32
+}
+
+func (p *prober) probe(l sync.Locker, timeout time.Duration) error {
+	if !atomic.CompareAndSwapInt32(&p.pending, 0, 1) {
+		return fmt.Errorf("previous probe is still waiting: %v", ErrProbeTimeout)
+	}
+	done := make(chan struct{})
+	go func() {
+		l.Lock()
+		l.Unlock()
+		atomic.StoreInt32(&p.pending, 0)
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return ErrProbeTimeout
+	}
+}
+
+// Probe checks that index is not deadlocked, i.e. that its lock
+// is acquired within timeout.
+func (i *ContainerIndex) Probe(timeout time.Duration) error {
+	return i.prober.probe(&i.mu, timeout)
+}
+
+// Probe checks that index is not deadlocked, i.e. that its lock
+// is acquired within timeout.
+func (i *PodIndex) Probe(timeout time.Duration) error {
+	return i.prober.probe(&i.mu, timeout)
+}
+
+// Probe checks that index is not deadlocked, i.e. that its lock
+// is acquired within timeout.
+func (i *ImageIndex) Probe(timeout time.Duration) error {
+	return i.prober.probe(&i.mu, timeout)
+}
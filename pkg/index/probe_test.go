@@ -0,0 +1,37 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbe(t *testing.T) {
+	indx := NewPodIndex()
+	require.NoError(t, indx.Probe(time.Second))
+
+	indx.mu.Lock()
+	require.Equal(t, ErrProbeTimeout, indx.Probe(10*time.Millisecond))
+	// timed out probe is still waiting, no new probe is started
+	require.Error(t, indx.Probe(time.Second))
+	indx.mu.Unlock()
+
+	require.Eventually(t, func() bool {
+		return indx.Probe(time.Second) == nil
+	}, time.Second, 10*time.Millisecond)
+}
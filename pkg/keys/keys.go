@@ -0,0 +1,337 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jsonresp "github.com/sylabs/json-resp"
+	"github.com/sylabs/scs-key-client/client"
+)
+
+const armoredKeyHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+
+// Client wraps keyserver client and returns
+// structured results instead of raw responses.
+type Client struct {
+	c *client.Client
+}
+
+// SearchOptions tunes keyserver search.
+type SearchOptions struct {
+	// Exact requests exact match of the search.
+	Exact bool
+	// Fingerprint requests full fingerprints to be returned.
+	Fingerprint bool
+	// Page is used for paginated search and is advanced with
+	// next page token after each request, if set.
+	Page *client.PageDetails
+}
+
+// Key is a single public key returned by keyserver index.
+type Key struct {
+	KeyID     string
+	Algorithm int
+	Length    int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Flags     string
+	UIDs      []UID
+}
+
+// UID is a single user ID attached to a public key.
+type UID struct {
+	Name      string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Flags     string
+}
+
+// HTTPError is returned when keyserver responds with a non-200 status.
+type HTTPError struct {
+	// Op is a failed operation, e.g. "add key".
+	Op string
+	// StatusCode is HTTP status code returned by keyserver.
+	StatusCode int
+	// Message is an error message returned by keyserver, if any.
+	Message string
+}
+
+// Error implements error interface.
+func (e *HTTPError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("could not %s: %s (%d %s)", e.Op, e.Message, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("could not %s: %d %s", e.Op, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// IsNotFound returns true if err is a keyserver 404 response.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsUnauthorized returns true if err is a keyserver 401 response.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsForbidden returns true if err is a keyserver 403 response.
+func IsForbidden(err error) bool {
+	return hasStatus(err, http.StatusForbidden)
+}
+
+// IsConflict returns true if err is a keyserver 409 response,
+// e.g. the pushed key already exists.
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+// IsServerError returns true if err is a keyserver 5xx response,
+// so the request may be retried later.
+func IsServerError(err error) bool {
+	e, ok := err.(*HTTPError)
+	return ok && e.StatusCode >= http.StatusInternalServerError
+}
+
+func hasStatus(err error, code int) bool {
+	e, ok := err.(*HTTPError)
+	return ok && e.StatusCode == code
+}
+
+// MalformedResponseError is returned when keyserver
+// response cannot be parsed.
+type MalformedResponseError struct {
+	Line   int
+	Reason string
+}
+
+// Error implements error interface.
+func (e *MalformedResponseError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("malformed keyserver response: %s", e.Reason)
+	}
+	return fmt.Sprintf("malformed keyserver response at line %d: %s", e.Line, e.Reason)
+}
+
+// Option is a type representing functional option for Client.
+type Option func(o *options)
+
+type options struct {
+	source          TokenSource
+	tokenTTL        time.Duration
+	maxResponseSize int64
+}
+
+// NewClient returns new keyserver client. AuthToken and UserAgent
+// from the passed config are sent along with each request. When token
+// source is set, AuthToken is only used if source returns an empty token.
+func NewClient(cfg *client.Config, opts ...Option) (*Client, error) {
+	if cfg == nil {
+		cfg = client.DefaultConfig
+	}
+	o := options{
+		tokenTTL:        DefaultTokenTTL,
+		maxResponseSize: DefaultMaxResponseSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	httpClient := http.DefaultClient
+	if cfg.HTTPClient != nil {
+		httpClient = cfg.HTTPClient
+	}
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport := &tokenTransport{
+		base: &limitTransport{
+			base:  base,
+			limit: o.maxResponseSize,
+		},
+		static: cfg.AuthToken,
+		source: o.source,
+		ttl:    o.tokenTTL,
+	}
+
+	withToken := *cfg
+	withToken.HTTPClient = &http.Client{
+		Transport:     transport,
+		CheckRedirect: httpClient.CheckRedirect,
+		Jar:           httpClient.Jar,
+		Timeout:       httpClient.Timeout,
+	}
+	c, err := client.NewClient(&withToken)
+	if err != nil {
+		return nil, fmt.Errorf("could not create key client: %v", err)
+	}
+	return &Client{c: c}, nil
+}
+
+// Add pushes armored public key to the keyserver. If keyserver
+// already has this key returned error satisfies IsConflict.
+func (c *Client) Add(ctx context.Context, armored string) error {
+	err := c.c.PKSAdd(ctx, armored)
+	if err != nil {
+		return wrapError("add key", err)
+	}
+	return nil
+}
+
+// Search looks for public keys matching the passed query, e.g. name,
+// email or fingerprint, and returns parsed machine readable index.
+func (c *Client) Search(ctx context.Context, query string, opts SearchOptions) ([]Key, error) {
+	resp, err := c.c.PKSLookup(ctx, opts.Page, query, client.OperationIndex,
+		opts.Fingerprint, opts.Exact, []string{client.OptionMachineReadable})
+	if err != nil {
+		return nil, wrapError("search keys", err)
+	}
+	return parseIndex(resp)
+}
+
+// Get fetches armored public keys matching the passed query.
+func (c *Client) Get(ctx context.Context, query string, opts SearchOptions) (string, error) {
+	resp, err := c.c.PKSLookup(ctx, opts.Page, query, client.OperationGet,
+		opts.Fingerprint, opts.Exact, []string{client.OptionMachineReadable})
+	if err != nil {
+		return "", wrapError("get keys", err)
+	}
+	if !strings.Contains(resp, armoredKeyHeader) {
+		return "", &MalformedResponseError{Reason: "no armored public key found"}
+	}
+	return resp, nil
+}
+
+// wrapError converts keyserver status errors into *HTTPError
+// and annotates any other errors with the failed operation.
+// *ResponseTooLargeError is returned as is.
+func wrapError(op string, err error) error {
+	if uerr, ok := err.(*url.Error); ok {
+		if lerr, ok := uerr.Err.(*ResponseTooLargeError); ok {
+			err = lerr
+		}
+	}
+	if lerr, ok := err.(*ResponseTooLargeError); ok {
+		lerr.Op = op
+		return lerr
+	}
+	if jerr, ok := err.(*jsonresp.Error); ok {
+		return &HTTPError{
+			Op:         op,
+			StatusCode: jerr.Code,
+			Message:    jerr.Message,
+		}
+	}
+	return fmt.Errorf("could not %s: %v", op, err)
+}
+
+// parseIndex parses machine readable index output as described in
+// https://tools.ietf.org/html/draft-shaw-openpgp-hkp-00#section-5.2.
+func parseIndex(resp string) ([]Key, error) {
+	var keys []Key
+	for i, line := range strings.Split(resp, "\n") {
+		lineNum := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		switch fields[0] {
+		case "info":
+			if len(fields) < 2 || fields[1] != "1" {
+				return nil, &MalformedResponseError{Line: lineNum, Reason: "unsupported index version"}
+			}
+		case "pub":
+			if len(fields) < 2 || fields[1] == "" {
+				return nil, &MalformedResponseError{Line: lineNum, Reason: "missing key id"}
+			}
+			fields = pad(fields, 7)
+			key := Key{
+				KeyID: fields[1],
+				Flags: fields[6],
+			}
+			var err error
+			if key.Algorithm, err = parseInt(fields[2]); err != nil {
+				return nil, &MalformedResponseError{Line: lineNum, Reason: fmt.Sprintf("bad algorithm: %v", err)}
+			}
+			if key.Length, err = parseInt(fields[3]); err != nil {
+				return nil, &MalformedResponseError{Line: lineNum, Reason: fmt.Sprintf("bad key length: %v", err)}
+			}
+			if key.CreatedAt, err = parseTime(fields[4]); err != nil {
+				return nil, &MalformedResponseError{Line: lineNum, Reason: fmt.Sprintf("bad creation date: %v", err)}
+			}
+			if key.ExpiresAt, err = parseTime(fields[5]); err != nil {
+				return nil, &MalformedResponseError{Line: lineNum, Reason: fmt.Sprintf("bad expiration date: %v", err)}
+			}
+			keys = append(keys, key)
+		case "uid":
+			if len(keys) == 0 {
+				return nil, &MalformedResponseError{Line: lineNum, Reason: "uid precedes any key"}
+			}
+			fields = pad(fields, 5)
+			name, err := url.PathUnescape(fields[1])
+			if err != nil {
+				return nil, &MalformedResponseError{Line: lineNum, Reason: fmt.Sprintf("bad uid: %v", err)}
+			}
+			uid := UID{
+				Name:  name,
+				Flags: fields[4],
+			}
+			if uid.CreatedAt, err = parseTime(fields[2]); err != nil {
+				return nil, &MalformedResponseError{Line: lineNum, Reason: fmt.Sprintf("bad creation date: %v", err)}
+			}
+			if uid.ExpiresAt, err = parseTime(fields[3]); err != nil {
+				return nil, &MalformedResponseError{Line: lineNum, Reason: fmt.Sprintf("bad expiration date: %v", err)}
+			}
+			last := &keys[len(keys)-1]
+			last.UIDs = append(last.UIDs, uid)
+		default:
+			// unknown lines must be ignored according to the spec
+		}
+	}
+	return keys, nil
+}
+
+func pad(fields []string, n int) []string {
+	for len(fields) < n {
+		fields = append(fields, "")
+	}
+	return fields
+}
+
+func parseInt(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
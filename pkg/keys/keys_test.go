@@ -0,0 +1,291 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	jsonresp "github.com/sylabs/json-resp"
+	"github.com/sylabs/scs-key-client/client"
+)
+
+const (
+	testToken     = "token"
+	testUserAgent = "sycri-test"
+	testKey       = armoredKeyHeader + "\n\nmQENBFzH\n-----END PGP PUBLIC KEY BLOCK-----\n"
+	testIndex     = "info:1:2\n" +
+		"pub:8883491F4268F173C6E5DC49EDECE4F3F38D871E:1:4096:1557405875::\n" +
+		"uid:Sasha Yakovtseva (test key) %3Csasha@sylabs.io%3E:1557405875::\n" +
+		"uid:Sasha Yakovtseva:1557405875:1588941875:r\n" +
+		"pub:F38D871E:17:1024:1557405875:1557492275:e\n"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) (*Client, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken || r.Header.Get("User-Agent") != testUserAgent {
+			jsonresp.WriteError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}))
+	c, err := NewClient(&client.Config{
+		BaseURL:   srv.URL,
+		AuthToken: testToken,
+		UserAgent: testUserAgent,
+	}, opts...)
+	require.NoError(t, err)
+	return c, srv.Close
+}
+
+func TestClient_Add(t *testing.T) {
+	tt := []struct {
+		name        string
+		status      int
+		expectError error
+	}{
+		{
+			name:   "added",
+			status: http.StatusOK,
+		},
+		{
+			name:        "duplicate",
+			status:      http.StatusConflict,
+			expectError: &HTTPError{Op: "add key", StatusCode: http.StatusConflict, Message: "oops"},
+		},
+		{
+			name:        "server error",
+			status:      http.StatusInternalServerError,
+			expectError: &HTTPError{Op: "add key", StatusCode: http.StatusInternalServerError, Message: "oops"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c, cleanup := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/pks/add", r.URL.Path)
+				require.Equal(t, testKey, r.FormValue("keytext"))
+				if tc.status != http.StatusOK {
+					jsonresp.WriteError(w, "oops", tc.status)
+				}
+			})
+			defer cleanup()
+
+			err := c.Add(context.Background(), testKey)
+			require.Equal(t, tc.expectError, err)
+		})
+	}
+}
+
+func TestClient_Search(t *testing.T) {
+	created := time.Unix(1557405875, 0).UTC()
+
+	tt := []struct {
+		name        string
+		status      int
+		body        string
+		expectKeys  []Key
+		expectError error
+	}{
+		{
+			name:   "found keys",
+			status: http.StatusOK,
+			body:   testIndex,
+			expectKeys: []Key{
+				{
+					KeyID:     "8883491F4268F173C6E5DC49EDECE4F3F38D871E",
+					Algorithm: 1,
+					Length:    4096,
+					CreatedAt: created,
+					UIDs: []UID{
+						{
+							Name:      "Sasha Yakovtseva (test key) <sasha@sylabs.io>",
+							CreatedAt: created,
+						},
+						{
+							Name:      "Sasha Yakovtseva",
+							CreatedAt: created,
+							ExpiresAt: time.Unix(1588941875, 0).UTC(),
+							Flags:     "r",
+						},
+					},
+				},
+				{
+					KeyID:     "F38D871E",
+					Algorithm: 17,
+					Length:    1024,
+					CreatedAt: created,
+					ExpiresAt: time.Unix(1557492275, 0).UTC(),
+					Flags:     "e",
+				},
+			},
+		},
+		{
+			name:        "not found",
+			status:      http.StatusNotFound,
+			expectError: &HTTPError{Op: "search keys", StatusCode: http.StatusNotFound},
+		},
+		{
+			name:        "unsupported version",
+			status:      http.StatusOK,
+			body:        "info:2:1\n",
+			expectError: &MalformedResponseError{Line: 1, Reason: "unsupported index version"},
+		},
+		{
+			name:        "orphan uid",
+			status:      http.StatusOK,
+			body:        "info:1:1\nuid:Sasha:1557405875::\n",
+			expectError: &MalformedResponseError{Line: 2, Reason: "uid precedes any key"},
+		},
+		{
+			name:        "bad date",
+			status:      http.StatusOK,
+			body:        "info:1:1\npub:F38D871E:17:1024:yesterday::\n",
+			expectError: &MalformedResponseError{Line: 2, Reason: `bad creation date: strconv.ParseInt: parsing "yesterday": invalid syntax`},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c, cleanup := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/pks/lookup", r.URL.Path)
+				require.Equal(t, "index", r.FormValue("op"))
+				require.Equal(t, "mr", r.FormValue("options"))
+				require.Equal(t, "on", r.FormValue("exact"))
+				require.Equal(t, "10", r.FormValue("x-pagesize"))
+				if tc.status != http.StatusOK {
+					jsonresp.WriteError(w, "", tc.status)
+					return
+				}
+				w.Header().Set("X-HKP-Next-Page-Token", "next")
+				fmt.Fprint(w, tc.body)
+			})
+			defer cleanup()
+
+			page := &client.PageDetails{Size: 10}
+			keys, err := c.Search(context.Background(), "sasha@sylabs.io", SearchOptions{
+				Exact: true,
+				Page:  page,
+			})
+			require.Equal(t, tc.expectError, err)
+			require.Equal(t, tc.expectKeys, keys)
+			if err == nil {
+				require.Equal(t, "next", page.Token)
+			}
+		})
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	tt := []struct {
+		name         string
+		err          error
+		expectString string
+		notFound     bool
+		unauthorized bool
+		forbidden    bool
+		conflict     bool
+		serverError  bool
+	}{
+		{
+			name:         "not found",
+			err:          &HTTPError{Op: "get keys", StatusCode: http.StatusNotFound},
+			expectString: "could not get keys: 404 Not Found",
+			notFound:     true,
+		},
+		{
+			name:         "unauthorized",
+			err:          &HTTPError{Op: "add key", StatusCode: http.StatusUnauthorized, Message: "bad token"},
+			expectString: "could not add key: bad token (401 Unauthorized)",
+			unauthorized: true,
+		},
+		{
+			name:         "forbidden",
+			err:          &HTTPError{Op: "add key", StatusCode: http.StatusForbidden},
+			expectString: "could not add key: 403 Forbidden",
+			forbidden:    true,
+		},
+		{
+			name:         "conflict",
+			err:          &HTTPError{Op: "add key", StatusCode: http.StatusConflict},
+			expectString: "could not add key: 409 Conflict",
+			conflict:     true,
+		},
+		{
+			name:         "server error",
+			err:          &HTTPError{Op: "search keys", StatusCode: http.StatusBadGateway},
+			expectString: "could not search keys: 502 Bad Gateway",
+			serverError:  true,
+		},
+		{
+			name:         "other error",
+			err:          fmt.Errorf("could not search keys: connection refused"),
+			expectString: "could not search keys: connection refused",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectString, tc.err.Error())
+			require.Equal(t, tc.notFound, IsNotFound(tc.err))
+			require.Equal(t, tc.unauthorized, IsUnauthorized(tc.err))
+			require.Equal(t, tc.forbidden, IsForbidden(tc.err))
+			require.Equal(t, tc.conflict, IsConflict(tc.err))
+			require.Equal(t, tc.serverError, IsServerError(tc.err))
+		})
+	}
+}
+
+func TestClient_Get(t *testing.T) {
+	tt := []struct {
+		name        string
+		body        string
+		expectKey   string
+		expectError error
+	}{
+		{
+			name:      "armored key",
+			body:      testKey,
+			expectKey: testKey,
+		},
+		{
+			name:        "not a key",
+			body:        "<html>hello</html>",
+			expectError: &MalformedResponseError{Reason: "no armored public key found"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c, cleanup := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "get", r.FormValue("op"))
+				require.Equal(t, "on", r.FormValue("fingerprint"))
+				fmt.Fprint(w, tc.body)
+			})
+			defer cleanup()
+
+			key, err := c.Get(context.Background(), "0x8883491F4268F173C6E5DC49EDECE4F3F38D871E", SearchOptions{
+				Fingerprint: true,
+			})
+			require.Equal(t, tc.expectError, err)
+			require.Equal(t, tc.expectKey, key)
+		})
+	}
+}
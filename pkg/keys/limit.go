@@ -0,0 +1,226 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/golang/glog"
+	jsonresp "github.com/sylabs/json-resp"
+	"github.com/sylabs/scs-key-client/client"
+)
+
+// DefaultMaxResponseSize is a size limit of keyserver responses by default.
+// Public keys are rarely larger than several kilobytes, but may carry
+// many signatures.
+const DefaultMaxResponseSize = 4 << 20
+
+const pathPKSLookup = "/pks/lookup"
+
+// ResponseTooLargeError is returned when keyserver response
+// exceeds configured size limit.
+type ResponseTooLargeError struct {
+	// Op is a failed operation, e.g. "get keys".
+	Op string
+	// Limit is a maximum allowed response size in bytes.
+	Limit int64
+}
+
+// Error implements error interface.
+func (e *ResponseTooLargeError) Error() string {
+	if e.Op == "" {
+		return fmt.Sprintf("keyserver response exceeds %d bytes", e.Limit)
+	}
+	return fmt.Sprintf("could not %s: keyserver response exceeds %d bytes", e.Op, e.Limit)
+}
+
+// IsResponseTooLarge returns true if err is caused by keyserver
+// response exceeding size limit.
+func IsResponseTooLarge(err error) bool {
+	_, ok := err.(*ResponseTooLargeError)
+	return ok
+}
+
+// WithMaxResponseSize sets size limit of keyserver responses. Non-positive
+// limit disables the check. Overrides DefaultMaxResponseSize.
+func WithMaxResponseSize(size int64) Option {
+	return func(o *options) {
+		o.maxResponseSize = size
+	}
+}
+
+// limitTransport fails reading of response bodies larger than limit.
+type limitTransport struct {
+	base  http.RoundTripper
+	limit int64
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Path == pathPKSLookup && req.URL.Query().Get("op") == client.OperationGet &&
+		resp.StatusCode == http.StatusOK {
+		checkKeyContentType(resp.Header.Get("Content-Type"))
+	}
+	if t.limit > 0 {
+		resp.Body = &limitedBody{
+			ReadCloser: resp.Body,
+			r:          io.LimitReader(resp.Body, t.limit+1),
+			limit:      t.limit,
+			oversized:  resp.ContentLength > t.limit,
+		}
+	}
+	return resp, nil
+}
+
+// limitedBody returns *ResponseTooLargeError once more than limit bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	r         io.Reader
+	limit     int64
+	read      int64
+	oversized bool
+}
+
+// Read implements io.Reader.
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.oversized {
+		return 0, &ResponseTooLargeError{Limit: b.limit}
+	}
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		b.oversized = true
+		return 0, &ResponseTooLargeError{Limit: b.limit}
+	}
+	return n, err
+}
+
+// checkKeyContentType warns when armored key is served with unexpected
+// content type. Keyservers vary, so this is never fatal.
+func checkKeyContentType(contentType string) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && (mediaType == "application/pgp-keys" || mediaType == "text/plain") {
+		return
+	}
+	glog.Warningf("Keyserver returned key with unexpected content type %q", contentType)
+}
+
+// Download fetches armored public keys matching the passed query and writes
+// them to w as they arrive, so that large responses are not buffered. It
+// returns number of bytes written. Response that turns out not to contain an
+// armored key results in *MalformedResponseError, w should be discarded then.
+func (c *Client) Download(ctx context.Context, query string, opts SearchOptions, w io.Writer) (int64, error) {
+	if query == "" {
+		return 0, wrapError("download keys", client.ErrInvalidSearch)
+	}
+	u := c.c.BaseURL.ResolveReference(&url.URL{
+		Path:     pathPKSLookup,
+		RawQuery: lookupQuery(query, client.OperationGet, opts).Encode(),
+	})
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, wrapError("download keys", err)
+	}
+	if c.c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.c.UserAgent)
+	}
+	resp, err := c.c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, wrapError("download keys", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if err := jsonresp.ReadError(resp.Body); err != nil {
+			return 0, wrapError("download keys", err)
+		}
+		return 0, wrapError("download keys", jsonresp.NewError(resp.StatusCode, ""))
+	}
+	if opts.Page != nil {
+		opts.Page.Token = resp.Header.Get("X-HKP-Next-Page-Token")
+	}
+
+	hw := &headerWriter{w: w, header: []byte(armoredKeyHeader)}
+	n, err := io.Copy(hw, resp.Body)
+	if err != nil {
+		return n, wrapError("download keys", err)
+	}
+	if !hw.found {
+		return n, &MalformedResponseError{Reason: "no armored public key found"}
+	}
+	return n, nil
+}
+
+// lookupQuery returns HKP lookup query parameters the same way
+// keyserver client does.
+func lookupQuery(query, op string, opts SearchOptions) url.Values {
+	v := url.Values{}
+	v.Set("search", query)
+	v.Set("op", op)
+	v.Set("options", client.OptionMachineReadable)
+	if opts.Fingerprint {
+		v.Set("fingerprint", "on")
+	}
+	if opts.Exact {
+		v.Set("exact", "on")
+	}
+	if opts.Page != nil {
+		if opts.Page.Size != 0 {
+			v.Set("x-pagesize", strconv.Itoa(opts.Page.Size))
+		}
+		if opts.Page.Token != "" {
+			v.Set("x-pagetoken", opts.Page.Token)
+		}
+	}
+	return v
+}
+
+// headerWriter passes data through looking for header
+// that may span several writes.
+type headerWriter struct {
+	w      io.Writer
+	header []byte
+	tail   []byte
+	found  bool
+}
+
+// Write implements io.Writer.
+func (h *headerWriter) Write(p []byte) (int, error) {
+	if !h.found {
+		buf := append(h.tail, p...)
+		if bytes.Contains(buf, h.header) {
+			h.found = true
+			h.tail = nil
+		} else {
+			keep := len(h.header) - 1
+			if len(buf) < keep {
+				keep = len(buf)
+			}
+			h.tail = append([]byte(nil), buf[len(buf)-keep:]...)
+		}
+	}
+	return h.w.Write(p)
+}
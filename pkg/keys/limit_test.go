@@ -0,0 +1,149 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/scs-key-client/client"
+)
+
+func TestClient_MaxResponseSize(t *testing.T) {
+	large := testKey + strings.Repeat("x", 1024)
+
+	tt := []struct {
+		name        string
+		body        string
+		chunked     bool
+		expectError error
+	}{
+		{
+			name: "fits",
+			body: testKey,
+		},
+		{
+			name:        "too large",
+			body:        large,
+			expectError: &ResponseTooLargeError{Op: "get keys", Limit: 512},
+		},
+		{
+			name:        "too large without content length",
+			body:        large,
+			chunked:     true,
+			expectError: &ResponseTooLargeError{Op: "get keys", Limit: 512},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c, cleanup := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/pgp-keys")
+				if !tc.chunked {
+					w.Header().Set("Content-Length", fmt.Sprint(len(tc.body)))
+				}
+				w.Write([]byte(tc.body))
+			}, WithMaxResponseSize(512))
+			defer cleanup()
+
+			key, err := c.Get(context.Background(), "F38D871E", SearchOptions{})
+			require.Equal(t, tc.expectError, err)
+			if tc.expectError != nil {
+				require.True(t, IsResponseTooLarge(err))
+				return
+			}
+			require.Equal(t, tc.body, key)
+		})
+	}
+}
+
+func TestClient_Download(t *testing.T) {
+	tt := []struct {
+		name        string
+		contentType string
+		body        string
+		expectError error
+	}{
+		{
+			name:        "armored key",
+			contentType: "application/pgp-keys",
+			body:        testKey,
+		},
+		{
+			name:        "unexpected content type",
+			contentType: "application/octet-stream",
+			body:        testKey,
+		},
+		{
+			name:        "not a key",
+			contentType: "text/plain",
+			body:        "<html>maintenance</html>",
+			expectError: &MalformedResponseError{Reason: "no armored public key found"},
+		},
+		{
+			name:        "too large",
+			contentType: "text/plain",
+			body:        testKey + strings.Repeat("x", 1024),
+			expectError: &ResponseTooLargeError{Op: "download keys", Limit: 512},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c, cleanup := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, pathPKSLookup, r.URL.Path)
+				require.Equal(t, client.OperationGet, r.FormValue("op"))
+				require.Equal(t, "F38D871E", r.FormValue("search"))
+				require.Equal(t, "on", r.FormValue("exact"))
+				w.Header().Set("Content-Type", tc.contentType)
+				// split armored header between chunks
+				w.Write([]byte(tc.body[:10]))
+				w.(http.Flusher).Flush()
+				w.Write([]byte(tc.body[10:]))
+			}, WithMaxResponseSize(512))
+			defer cleanup()
+
+			var buf bytes.Buffer
+			n, err := c.Download(context.Background(), "F38D871E", SearchOptions{Exact: true}, &buf)
+			require.Equal(t, tc.expectError, err)
+			if tc.expectError != nil {
+				return
+			}
+			require.Equal(t, int64(len(tc.body)), n)
+			require.Equal(t, tc.body, buf.String())
+		})
+	}
+}
+
+func TestHeaderWriter(t *testing.T) {
+	var buf bytes.Buffer
+	hw := &headerWriter{w: &buf, header: []byte(armoredKeyHeader)}
+	for _, b := range []byte("comment\n" + testKey) {
+		_, err := hw.Write([]byte{b})
+		require.NoError(t, err)
+	}
+	require.True(t, hw.found)
+	require.Equal(t, "comment\n"+testKey, buf.String())
+
+	hw = &headerWriter{w: &buf, header: []byte(armoredKeyHeader)}
+	_, err := hw.Write([]byte(armoredKeyHeader[1:]))
+	require.NoError(t, err)
+	require.False(t, hw.found)
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTokenTTL is time token returned by TokenSource is cached for.
+const DefaultTokenTTL = time.Minute
+
+// TokenSource returns current auth token sent with each keyserver request.
+// Empty token means static AuthToken from client config should be used.
+type TokenSource func(ctx context.Context) (string, error)
+
+// FileTokenSource returns TokenSource that reads token from the passed file,
+// so that rotated token is picked up without restart.
+func FileTokenSource(path string) TokenSource {
+	return func(context.Context) (string, error) {
+		token, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("could not read auth token file: %v", err)
+		}
+		return strings.TrimSpace(string(token)), nil
+	}
+}
+
+// WithTokenSource sets source auth token is fetched from before each request.
+func WithTokenSource(source TokenSource) Option {
+	return func(o *options) {
+		o.source = source
+	}
+}
+
+// WithAuthTokenFile makes client read auth token from the passed file.
+// Empty path is ignored.
+func WithAuthTokenFile(path string) Option {
+	return func(o *options) {
+		if path != "" {
+			o.source = FileTokenSource(path)
+		}
+	}
+}
+
+// WithTokenTTL sets time token returned by token source is cached for.
+// Overrides DefaultTokenTTL.
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.tokenTTL = ttl
+	}
+}
+
+// tokenTransport sets Authorization header of each request to the current
+// token. When keyserver responds with 401 cached token is dropped and request
+// is retried once if token source returns a different token.
+type tokenTransport struct {
+	base   http.RoundTripper
+	static string
+	source TokenSource
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.get(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(authorize(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || t.source == nil {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	t.invalidate(token)
+	fresh, err := t.get(req.Context())
+	if err != nil || fresh == token {
+		return resp, nil
+	}
+	retry := authorize(req, fresh)
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return t.base.RoundTrip(retry)
+}
+
+// get returns cached token or fetches a new one from token source.
+func (t *tokenTransport) get(ctx context.Context) (string, error) {
+	if t.source == nil {
+		return t.static, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.expires.IsZero() && time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+	token, err := t.source(ctx)
+	if err != nil {
+		return "", fmt.Errorf("could not get auth token: %v", err)
+	}
+	if token == "" {
+		token = t.static
+	}
+	t.token = token
+	t.expires = time.Now().Add(t.ttl)
+	return token, nil
+}
+
+// invalidate drops cached token unless it has been refreshed already.
+func (t *tokenTransport) invalidate(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token == token {
+		t.expires = time.Time{}
+	}
+}
+
+// authorize returns shallow copy of the request with Authorization header
+// set to the passed token. Request is not modified, as RoundTripper requires.
+func authorize(req *http.Request, token string) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	r.Header.Del("Authorization")
+	if token = trimScheme(token); token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+// trimScheme strips auth scheme from tokens that were configured along
+// with it, e.g. "BEARER <token>", as the scheme is always added by client.
+func trimScheme(token string) string {
+	const scheme = "bearer "
+	if len(token) > len(scheme) && strings.EqualFold(token[:len(scheme)], scheme) {
+		return strings.TrimSpace(token[len(scheme):])
+	}
+	return token
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	jsonresp "github.com/sylabs/json-resp"
+	"github.com/sylabs/scs-key-client/client"
+)
+
+func TestClient_TokenRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+
+	var mu sync.Mutex
+	valid := "first"
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if r.Header.Get("Authorization") != "Bearer "+valid {
+			jsonresp.WriteError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		require.Equal(t, testKey, r.FormValue("keytext"))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(&client.Config{BaseURL: srv.URL}, WithAuthTokenFile(tokenFile), WithTokenTTL(time.Hour))
+	require.NoError(t, err)
+
+	tt := []struct {
+		name       string
+		fileToken  string
+		validToken string
+		expectReqs int
+		expectCode int
+		removeFile bool
+	}{
+		{
+			name:       "initial token",
+			fileToken:  "first\n",
+			validToken: "first",
+			expectReqs: 1,
+		},
+		{
+			name:       "rotated token is reread on 401",
+			fileToken:  "second",
+			validToken: "second",
+			expectReqs: 2,
+		},
+		{
+			name:       "cached token is reused",
+			fileToken:  "third",
+			validToken: "second",
+			expectReqs: 1,
+		},
+		{
+			name:       "unchanged token is not retried",
+			fileToken:  "third",
+			validToken: "fourth",
+			expectReqs: 2,
+			expectCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "missing token file",
+			validToken: "fourth",
+			removeFile: true,
+			expectReqs: 1,
+			expectCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.removeFile {
+				require.NoError(t, os.Remove(tokenFile))
+			} else {
+				require.NoError(t, ioutil.WriteFile(tokenFile, []byte(tc.fileToken), 0600))
+			}
+			mu.Lock()
+			valid, requests = tc.validToken, 0
+			mu.Unlock()
+
+			err := c.Add(context.Background(), testKey)
+			if tc.expectCode != 0 {
+				require.True(t, hasStatus(err, tc.expectCode), "%v", err)
+			} else {
+				require.NoError(t, err)
+			}
+			mu.Lock()
+			require.Equal(t, tc.expectReqs, requests)
+			mu.Unlock()
+		})
+	}
+}
+
+func TestTokenTransport_Authorize(t *testing.T) {
+	tt := []struct {
+		name         string
+		static       string
+		source       TokenSource
+		expectHeader string
+		expectError  bool
+	}{
+		{
+			name:         "static token",
+			static:       "token",
+			expectHeader: "Bearer token",
+		},
+		{
+			name:         "static token with scheme",
+			static:       "BEARER token",
+			expectHeader: "Bearer token",
+		},
+		{
+			name:   "empty source falls back to static",
+			static: "token",
+			source: func(context.Context) (string, error) {
+				return "", nil
+			},
+			expectHeader: "Bearer token",
+		},
+		{
+			name:   "source token",
+			static: "token",
+			source: func(context.Context) (string, error) {
+				return "fresh", nil
+			},
+			expectHeader: "Bearer fresh",
+		},
+		{
+			name: "source error",
+			source: func(context.Context) (string, error) {
+				return "", fmt.Errorf("vault is sealed")
+			},
+			expectError: true,
+		},
+		{
+			name: "no token",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var header string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header.Get("Authorization")
+			}))
+			defer srv.Close()
+
+			transport := &tokenTransport{
+				base:   http.DefaultTransport,
+				static: tc.static,
+				source: tc.source,
+				ttl:    DefaultTokenTTL,
+			}
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "BEARER stale")
+			resp, err := transport.RoundTrip(req)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, tc.expectHeader, header)
+			require.Equal(t, "BEARER stale", req.Header.Get("Authorization"), "request must not be modified")
+		})
+	}
+}
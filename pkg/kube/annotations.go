@@ -0,0 +1,101 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Standard annotations CRI adds to generated OCI specs so that OCI hooks
+// and node agents are able to identify pods and containers.
+const (
+	AnnotationPodName       = "io.kubernetes.pod.name"
+	AnnotationPodNamespace  = "io.kubernetes.pod.namespace"
+	AnnotationPodUID        = "io.kubernetes.pod.uid"
+	AnnotationContainerName = "io.kubernetes.container.name"
+	AnnotationSandboxID     = "io.kubernetes.cri.sandbox-id"
+	AnnotationContainerType = "io.kubernetes.cri.container-type"
+
+	// AnnotationImageLabelPrefix prefixes image config labels copied into
+	// container OCI spec, see imageLabelAnnotations.
+	AnnotationImageLabelPrefix = "singularity.cri/image-label."
+
+	containerTypeSandbox   = "sandbox"
+	containerTypeContainer = "container"
+)
+
+// passthroughPrefixes are prefixes of annotations that are
+// always copied into OCI spec from pod and container configs.
+var passthroughPrefixes = []string{"io.kubernetes.", "singularity.cri/"}
+
+// filterAnnotations returns annotations that should be copied into OCI spec.
+// Kubernetes and Singularity-CRI annotations are always passed, others are
+// passed only when they match any of the allowed patterns. Patterns use shell
+// file name pattern syntax. Passed annotations are never modified.
+func filterAnnotations(annotations map[string]string, allowed []string) map[string]string {
+	filtered := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		if isPassthroughAnnotation(k, allowed) {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
+func isPassthroughAnnotation(key string, allowed []string) bool {
+	for _, prefix := range passthroughPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	for _, pattern := range allowed {
+		if ok, err := filepath.Match(pattern, key); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// imageLabelAnnotations returns image labels as prefixed annotations. Label
+// keys are sanitized by replacing characters other than alphanumerics, '.',
+// '_' and '-' with '_'. When sanitized keys collide the least label key wins.
+func imageLabelAnnotations(labels map[string]string) map[string]string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	annotations := make(map[string]string, len(labels))
+	for _, k := range keys {
+		key := AnnotationImageLabelPrefix + sanitizeAnnotationKey(k)
+		if _, ok := annotations[key]; !ok {
+			annotations[key] = labels[k]
+		}
+	}
+	return annotations
+}
+
+func sanitizeAnnotationKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, key)
+}
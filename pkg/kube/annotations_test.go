@@ -0,0 +1,93 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterAnnotations(t *testing.T) {
+	annotations := map[string]string{
+		"io.kubernetes.container.hash":                     "1234",
+		"io.kubernetes.container.restartCount":             "0",
+		"singularity.cri/add-hosts":                        "foo:10.0.0.1",
+		"example.com/trace":                                "on",
+		"example.com/token":                                "secret",
+		"kubectl.kubernetes.io/last-applied-configuration": "{}",
+	}
+
+	tt := []struct {
+		name    string
+		allowed []string
+		expect  map[string]string
+	}{
+		{
+			name: "default",
+			expect: map[string]string{
+				"io.kubernetes.container.hash":         "1234",
+				"io.kubernetes.container.restartCount": "0",
+				"singularity.cri/add-hosts":            "foo:10.0.0.1",
+			},
+		},
+		{
+			name:    "exact key",
+			allowed: []string{"example.com/trace"},
+			expect: map[string]string{
+				"io.kubernetes.container.hash":         "1234",
+				"io.kubernetes.container.restartCount": "0",
+				"singularity.cri/add-hosts":            "foo:10.0.0.1",
+				"example.com/trace":                    "on",
+			},
+		},
+		{
+			name:    "pattern",
+			allowed: []string{"example.com/*", "[invalid"},
+			expect: map[string]string{
+				"io.kubernetes.container.hash":         "1234",
+				"io.kubernetes.container.restartCount": "0",
+				"singularity.cri/add-hosts":            "foo:10.0.0.1",
+				"example.com/trace":                    "on",
+				"example.com/token":                    "secret",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := filterAnnotations(annotations, tc.allowed)
+			require.Equal(t, tc.expect, actual)
+			require.Len(t, annotations, 6, "original annotations are modified")
+		})
+	}
+}
+
+func TestImageLabelAnnotations(t *testing.T) {
+	actual := imageLabelAnnotations(map[string]string{
+		"maintainer":                   "sylabs",
+		"org.opencontainers.image.url": "https://sylabs.io",
+		"build date":                   "today",
+		"build_date":                   "yesterday",
+		"name/with:chars":              "value",
+	})
+	require.Equal(t, map[string]string{
+		AnnotationImageLabelPrefix + "maintainer":                   "sylabs",
+		AnnotationImageLabelPrefix + "org.opencontainers.image.url": "https://sylabs.io",
+		AnnotationImageLabelPrefix + "build_date":                   "today",
+		AnnotationImageLabelPrefix + "name_with_chars":              "value",
+	}, actual)
+	require.Empty(t, imageLabelAnnotations(nil))
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const unconfinedAppArmorProfile = "unconfined"
+
+// appArmorEnabledPath and appArmorProfilesPath are variables
+// so that tests may override them.
+var (
+	appArmorEnabledPath  = "/sys/module/apparmor/parameters/enabled"
+	appArmorProfilesPath = "/sys/kernel/security/apparmor/profiles"
+)
+
+// AppArmorEnabled checks whether AppArmor is enabled on the host.
+func AppArmorEnabled() bool {
+	enabled, err := ioutil.ReadFile(appArmorEnabledPath)
+	return err == nil && strings.HasPrefix(string(enabled), "Y")
+}
+
+// LoadedAppArmorProfiles returns names of AppArmor profiles loaded into kernel.
+func LoadedAppArmorProfiles() ([]string, error) {
+	f, err := os.Open(appArmorProfilesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var profiles []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// each line is "<name> (<mode>)", name may contain spaces
+		line := scanner.Text()
+		if i := strings.LastIndex(line, " ("); i != -1 {
+			line = line[:i]
+		}
+		if line != "" {
+			profiles = append(profiles, line)
+		}
+	}
+	return profiles, scanner.Err()
+}
+
+// prepareAppArmorProfile returns name of AppArmor profile requested
+// by CRI, empty when no profile should be applied. Runtime default and
+// unconfined profiles need no AppArmor, so they work on any host, while
+// named profile must be loaded on the host.
+func prepareAppArmorProfile(profile string) (string, error) {
+	switch profile {
+	case "", defaultAppArmorProfile, unconfinedAppArmorProfile:
+		return "", nil
+	}
+	name := strings.TrimPrefix(profile, appArmorLocalhostPrefix)
+	if name == "" {
+		return "", fmt.Errorf("localhost profile name is empty")
+	}
+	if !AppArmorEnabled() {
+		return "", fmt.Errorf("AppArmor is not enabled on the host, profile %s cannot be applied", name)
+	}
+	loaded, err := LoadedAppArmorProfiles()
+	if err != nil {
+		return "", fmt.Errorf("could not read loaded profiles: %v", err)
+	}
+	for _, p := range loaded {
+		if p == name {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("profile %s is not loaded", name)
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareAppArmorProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apparmor-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func(enabled, profiles string) {
+		appArmorEnabledPath, appArmorProfilesPath = enabled, profiles
+	}(appArmorEnabledPath, appArmorProfilesPath)
+	appArmorEnabledPath = filepath.Join(dir, "enabled")
+	appArmorProfilesPath = filepath.Join(dir, "profiles")
+	require.NoError(t, ioutil.WriteFile(appArmorProfilesPath,
+		[]byte("docker-default (enforce)\nmy profile (complain)\n"), 0644))
+
+	tt := []struct {
+		name          string
+		enabled       bool
+		profile       string
+		expectProfile string
+		expectError   string
+	}{
+		{
+			name:    "runtime default without apparmor",
+			profile: "runtime/default",
+		},
+		{
+			name:    "unconfined without apparmor",
+			profile: "unconfined",
+		},
+		{
+			name:        "localhost without apparmor",
+			profile:     "localhost/docker-default",
+			expectError: "AppArmor is not enabled on the host, profile docker-default cannot be applied",
+		},
+		{
+			name:          "loaded localhost",
+			enabled:       true,
+			profile:       "localhost/docker-default",
+			expectProfile: "docker-default",
+		},
+		{
+			name:          "loaded name with spaces",
+			enabled:       true,
+			profile:       "localhost/my profile",
+			expectProfile: "my profile",
+		},
+		{
+			name:        "not loaded",
+			enabled:     true,
+			profile:     "localhost/missing",
+			expectError: "profile missing is not loaded",
+		},
+		{
+			name:        "empty localhost",
+			enabled:     true,
+			profile:     "localhost/",
+			expectError: "localhost profile name is empty",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			enabled := "N\n"
+			if tc.enabled {
+				enabled = "Y\n"
+			}
+			require.NoError(t, ioutil.WriteFile(appArmorEnabledPath, []byte(enabled), 0644))
+
+			profile, err := prepareAppArmorProfile(tc.profile)
+			if tc.expectError != "" {
+				require.EqualError(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectProfile, profile)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// atomicDataDir is a symlink kubelet atomic writer swaps to publish
+	// new contents of secret, config map, downward API and projected volumes.
+	atomicDataDir = "..data"
+	// atomicVolumesPath is a container directory atomic writer directories
+	// are mounted under when only some of their files are requested.
+	atomicVolumesPath = "/.singularity-cri/atomic"
+)
+
+// atomicWriterSource checks whether host path points inside a directory
+// maintained by kubelet atomic writer, i.e. one that has ..data symlink
+// and top-level entries that are symlinks into ..data. Such paths must not
+// be resolved at creation time since their targets are removed on update.
+// Directory that is written atomically and path relative to it are returned.
+func atomicWriterSource(hostPath string) (string, string, bool) {
+	hostPath = filepath.Clean(hostPath)
+	for dir := filepath.Dir(hostPath); dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		fi, err := os.Lstat(filepath.Join(dir, atomicDataDir))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		rel, err := filepath.Rel(dir, hostPath)
+		if err != nil {
+			return "", "", false
+		}
+		top := strings.Split(rel, "/")[0]
+		if top == atomicDataDir {
+			return dir, rel, true
+		}
+		target, err := os.Readlink(filepath.Join(dir, top))
+		if err != nil || !strings.HasPrefix(target, atomicDataDir+"/") {
+			return "", "", false
+		}
+		return dir, rel, true
+	}
+	return "", "", false
+}
+
+// atomicMountPath returns container path atomic writer directory
+// of the mount with the passed index is mounted at.
+func atomicMountPath(i int) string {
+	return filepath.Join(atomicVolumesPath, strconv.Itoa(i))
+}
+
+// addAtomicLinks replaces container paths of mounts from atomic writer
+// directories with symlinks into mounted directories, so that container
+// follows ..data swaps the same way kubelet does on the host. Symlinks are
+// created even on read-only rootfs, like mount points are.
+func (c *Container) addAtomicLinks() error {
+	mounts := c.GetMounts()
+	for i, rel := range c.atomicMounts {
+		mountPoint, err := resolveInRoot(c.rootfsPath(), atomicMountPath(i))
+		if err != nil {
+			return fmt.Errorf("could not resolve atomic writer mount point: %v", err)
+		}
+		if err := os.MkdirAll(mountPoint, 0755); err != nil {
+			return fmt.Errorf("could not create atomic writer mount point: %v", err)
+		}
+		dest := mounts[i].GetContainerPath()
+		link, err := atomicLink(c.rootfsPath(), dest, filepath.Join(atomicMountPath(i), rel))
+		if err != nil {
+			return fmt.Errorf("could not link %s: %v", dest, err)
+		}
+		glog.V(4).Infof("Linked %s to atomic writer directory %s in container %s", link, mounts[i].GetHostPath(), c.id)
+	}
+	return nil
+}
+
+// atomicLink creates symlink at container path dest pointing to target.
+// Files existing at dest in the image are replaced.
+func atomicLink(rootfs, dest, target string) (string, error) {
+	dir, err := resolveInRoot(rootfs, filepath.Dir(dest))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	link := filepath.Join(dir, filepath.Base(dest))
+	fi, err := os.Lstat(link)
+	if err == nil && fi.IsDir() {
+		return "", fmt.Errorf("%s is a directory in image", dest)
+	}
+	if err == nil {
+		if err := os.Remove(link); err != nil {
+			return "", err
+		}
+	}
+	return link, os.Symlink(target, link)
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// writeAtomic publishes files into dir the way kubelet atomic writer does:
+// files are written into a new timestamped directory, ..data symlink is
+// swapped to it with rename and the previous directory is removed.
+func writeAtomic(t *testing.T, dir, ts string, files map[string]string) {
+	tsDir := filepath.Join(dir, ".."+ts)
+	require.NoError(t, os.MkdirAll(tsDir, 0755))
+	for name, data := range files {
+		require.NoError(t, os.MkdirAll(filepath.Join(tsDir, filepath.Dir(name)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tsDir, name), []byte(data), 0644))
+	}
+
+	oldTsDir, _ := os.Readlink(filepath.Join(dir, atomicDataDir))
+	tmpLink := filepath.Join(dir, "..data_tmp")
+	require.NoError(t, os.Symlink(filepath.Base(tsDir), tmpLink))
+	require.NoError(t, os.Rename(tmpLink, filepath.Join(dir, atomicDataDir)))
+	for name := range files {
+		top := filepath.Join(dir, splitFirst(name))
+		if _, err := os.Lstat(top); os.IsNotExist(err) {
+			require.NoError(t, os.Symlink(filepath.Join(atomicDataDir, splitFirst(name)), top))
+		}
+	}
+	if oldTsDir != "" {
+		require.NoError(t, os.RemoveAll(filepath.Join(dir, oldTsDir)))
+	}
+}
+
+func splitFirst(path string) string {
+	for path != "." && filepath.Dir(path) != "." {
+		path = filepath.Dir(path)
+	}
+	return path
+}
+
+func TestAtomicWriterSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	volume := filepath.Join(dir, "volumes", "token")
+	writeAtomic(t, volume, "1", map[string]string{"token": "old", "certs/ca.crt": "ca"})
+	plain := filepath.Join(dir, "plain")
+	require.NoError(t, os.MkdirAll(plain, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(plain, "file"), nil, 0644))
+	require.NoError(t, os.Symlink("file", filepath.Join(plain, "link")))
+
+	tt := []struct {
+		name      string
+		path      string
+		expectDir string
+		expectRel string
+	}{
+		{
+			name:      "file",
+			path:      filepath.Join(volume, "token"),
+			expectDir: volume,
+			expectRel: "token",
+		},
+		{
+			name:      "nested file",
+			path:      filepath.Join(volume, "certs", "ca.crt"),
+			expectDir: volume,
+			expectRel: "certs/ca.crt",
+		},
+		{
+			name:      "data directory",
+			path:      filepath.Join(volume, atomicDataDir, "token"),
+			expectDir: volume,
+			expectRel: "..data/token",
+		},
+		{
+			name: "whole volume",
+			path: volume,
+		},
+		{
+			name: "timestamped directory",
+			path: filepath.Join(volume, "..1", "token"),
+		},
+		{
+			name: "plain file",
+			path: filepath.Join(plain, "file"),
+		},
+		{
+			name: "plain symlink",
+			path: filepath.Join(plain, "link"),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, rel, ok := atomicWriterSource(tc.path)
+			require.Equal(t, tc.expectDir != "", ok)
+			require.Equal(t, tc.expectDir, dir)
+			require.Equal(t, tc.expectRel, rel)
+		})
+	}
+}
+
+func TestContainer_AtomicMounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+
+	// volume is placed inside rootfs and linked to its mount point
+	// relatively, which is how the bind mount is seen from the container
+	baseDir := filepath.Join(dir, "container")
+	rootfs := filepath.Join(baseDir, contBundlePath, contRootfsPath)
+	volume := filepath.Join(rootfs, "host", "token")
+	writeAtomic(t, volume, "1", map[string]string{"token": "old"})
+	plain := filepath.Join(rootfs, "host", "plain")
+	require.NoError(t, ioutil.WriteFile(plain, []byte("plain"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "var", "run", "secrets"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rootfs, "var", "run", "secrets", "token"), []byte("image"), 0644))
+
+	pod := &Pod{
+		PodSandboxConfig: &k8s.PodSandboxConfig{Hostname: "pod"},
+		baseDir:          filepath.Join(dir, "pod"),
+	}
+	cont := &Container{
+		ContainerConfig: &k8s.ContainerConfig{
+			Mounts: []*k8s.Mount{
+				{HostPath: plain, ContainerPath: "/etc/plain", Readonly: true},
+				{HostPath: filepath.Join(volume, "token"), ContainerPath: "/var/run/secrets/token", Readonly: true},
+			},
+		},
+		pod:     pod,
+		baseDir: baseDir,
+	}
+	require.NoError(t, cont.validateMounts())
+	require.Equal(t, map[int]string{1: "token"}, cont.atomicMounts)
+
+	g, err := generate.New("linux")
+	require.NoError(t, err)
+	tr := containerTranslator{cont: cont, pod: pod, g: g}
+	require.NoError(t, tr.configureMounts())
+	var volumes []specs.Mount
+	for _, m := range g.Config.Mounts {
+		if m.Source == plain || m.Source == volume {
+			volumes = append(volumes, m)
+		}
+	}
+	require.Equal(t, []specs.Mount{
+		{Source: plain, Destination: "/etc/plain", Options: []string{"rbind", "ro", "rprivate"}},
+		{Source: volume, Destination: atomicMountPath(1), Options: []string{"rbind", "ro", "rprivate"}},
+	}, volumes)
+
+	require.NoError(t, cont.addAtomicLinks())
+	link := filepath.Join(rootfs, "var", "run", "secrets", "token")
+	target, err := os.Readlink(link)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(atomicMountPath(1), "token"), target)
+
+	mountPoint := filepath.Join(rootfs, atomicMountPath(1))
+	require.NoError(t, os.Remove(mountPoint))
+	require.NoError(t, os.Symlink("../../../host/token", mountPoint))
+	readToken := func() string {
+		path, err := resolveInRoot(rootfs, "/var/run/secrets/token")
+		require.NoError(t, err)
+		data, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		return string(data)
+	}
+	require.Equal(t, "old", readToken())
+
+	// kubelet rotates the token while container keeps running
+	writeAtomic(t, volume, "2", map[string]string{"token": "new"})
+	require.Equal(t, "new", readToken())
+}
+
+func TestAtomicLink(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "atomic-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(rootfs)
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "etc", "dir"), 0755))
+
+	_, err = atomicLink(rootfs, "/etc/dir", "/target")
+	require.Error(t, err)
+
+	link, err := atomicLink(rootfs, "/missing/parent/file", "/target")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(rootfs, "missing", "parent", "file"), link)
+	target, err := os.Readlink(link)
+	require.NoError(t, err)
+	require.Equal(t, "/target", target)
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity/pkg/util/unix"
+)
+
+// ttyClientBuffer is a number of output chunks kept for each attached TTY
+// client, output client cannot keep up with is skipped.
+const ttyClientBuffer = 64
+
+// ttyHub shares a single connection to container attach socket between all
+// clients attached to container TTY. Output is fanned out to every client,
+// input written by clients is serialized so that writes never interleave.
+type ttyHub struct {
+	conn    net.Conn
+	onClose func()
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	closed  bool
+	clients map[*ttyClient]struct{}
+}
+
+// ttyClient is a single client attached to container TTY.
+type ttyClient struct {
+	out     chan []byte
+	done    chan struct{}
+	skipped int
+}
+
+// newTTYHub starts passing output read from conn to attached clients.
+// Once conn is closed onClose is called.
+func newTTYHub(conn net.Conn, onClose func()) *ttyHub {
+	h := &ttyHub{
+		conn:    conn,
+		onClose: onClose,
+		clients: make(map[*ttyClient]struct{}),
+	}
+	go h.run()
+	return h
+}
+
+func (h *ttyHub) run() {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := h.conn.Read(buf)
+		if n > 0 {
+			h.broadcast(buf[:n])
+		}
+		if err != nil {
+			if err != io.EOF {
+				glog.V(4).Infof("Attach socket read failed: %v", err)
+			}
+			h.close()
+			return
+		}
+	}
+}
+
+func (h *ttyHub) broadcast(data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		chunk := append([]byte(nil), data...)
+		select {
+		case client.out <- chunk:
+		default:
+			client.skipped += len(chunk)
+		}
+	}
+}
+
+// add attaches w to TTY output. Returned detach function stops passing
+// output, done channel is closed once output ends or w fails. False is
+// returned when hub is already closed.
+func (h *ttyHub) add(w io.Writer) (func(), <-chan struct{}, bool) {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	client := &ttyClient{
+		out:  make(chan []byte, ttyClientBuffer),
+		done: make(chan struct{}),
+	}
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil, nil, false
+	}
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		defer close(client.done)
+		for data := range client.out {
+			if _, err := w.Write(data); err != nil {
+				h.remove(client)
+				return
+			}
+		}
+	}()
+	return func() { h.remove(client) }, client.done, true
+}
+
+// remove detaches client, hub is closed once the last client is gone.
+func (h *ttyHub) remove(client *ttyClient) {
+	h.mu.Lock()
+	if _, ok := h.clients[client]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.clients, client)
+	close(client.out)
+	if client.skipped != 0 {
+		glog.V(4).Infof("Attached TTY client skipped %d bytes of output", client.skipped)
+	}
+	// no client may join hub that is about to be closed
+	last := len(h.clients) == 0
+	h.closed = last
+	h.mu.Unlock()
+
+	if last {
+		h.conn.Close()
+		if h.onClose != nil {
+			h.onClose()
+		}
+	}
+}
+
+// close detaches all clients once container output ends.
+func (h *ttyHub) close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	for client := range h.clients {
+		delete(h.clients, client)
+		close(client.out)
+	}
+	h.mu.Unlock()
+
+	h.conn.Close()
+	if h.onClose != nil {
+		h.onClose()
+	}
+}
+
+// Write passes input to container TTY, concurrent writes are serialized.
+func (h *ttyHub) Write(p []byte) (int, error) {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	return h.conn.Write(p)
+}
+
+// lockedWriter serializes writes of multiple attached clients.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// AttachTTY attaches stdout to output of container TTY, stdout may be nil
+// when client only passes input. All clients share a single connection to
+// container attach socket, see AttachStdin. Returned detach function stops
+// passing output, done channel is closed once container output ends or
+// stdout fails.
+func (c *Container) AttachTTY(stdout io.Writer) (func(), <-chan struct{}, error) {
+	c.attachMu.Lock()
+	defer c.attachMu.Unlock()
+
+	if c.ttyHub != nil {
+		if detach, done, ok := c.ttyHub.add(stdout); ok {
+			return detach, done, nil
+		}
+	}
+	socket := c.AttachSocket()
+	if socket == "" {
+		return nil, nil, fmt.Errorf("container didn't provide attach socket")
+	}
+	conn, err := unix.Dial(socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not connect to attach socket: %v", err)
+	}
+	var hub *ttyHub
+	hub = newTTYHub(conn, func() {
+		c.attachMu.Lock()
+		if c.ttyHub == hub {
+			c.ttyHub = nil
+		}
+		c.attachMu.Unlock()
+	})
+	c.ttyHub = hub
+	detach, done, ok := hub.add(stdout)
+	if !ok {
+		return nil, nil, fmt.Errorf("container output ended")
+	}
+	return detach, done, nil
+}
+
+// AttachStdin returns writer that passes input of attached client to container,
+// writes of multiple clients are serialized. TTY input is passed through the
+// connection shared by clients attached with AttachTTY. Nil is returned when
+// container has no stdin or it is already closed.
+func (c *Container) AttachStdin() io.Writer {
+	if !c.GetStdin() || c.StdinClosed() {
+		return nil
+	}
+	if c.GetTty() {
+		c.attachMu.Lock()
+		defer c.attachMu.Unlock()
+		if c.ttyHub == nil {
+			return nil
+		}
+		return c.ttyHub
+	}
+	stdin := c.Stdin()
+	if stdin == nil {
+		return nil
+	}
+	return lockedWriter{mu: &c.stdinMu, w: stdin}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a buffer attached clients write output to.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTTYHub(t *testing.T) {
+	conn, engine := net.Pipe()
+	defer engine.Close()
+	closed := make(chan struct{})
+	hub := newTTYHub(conn, func() { close(closed) })
+
+	var first, second syncBuffer
+	detachFirst, firstDone, ok := hub.add(&first)
+	require.True(t, ok)
+	detachSecond, secondDone, ok := hub.add(&second)
+	require.True(t, ok)
+
+	_, err := engine.Write([]byte("prompt$ "))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return first.String() == "prompt$ " && second.String() == "prompt$ "
+	}, time.Second, 10*time.Millisecond, "output must be passed to all clients")
+
+	// input of clients is passed through the shared connection
+	input := make(chan string, 2)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := engine.Read(buf)
+			if err != nil {
+				return
+			}
+			input <- string(buf[:n])
+		}
+	}()
+	var wg sync.WaitGroup
+	for _, in := range []string{"ls\n", "pwd\n"} {
+		wg.Add(1)
+		go func(in string) {
+			defer wg.Done()
+			_, err := hub.Write([]byte(in))
+			require.NoError(t, err)
+		}(in)
+	}
+	wg.Wait()
+	require.ElementsMatch(t, []string{"ls\n", "pwd\n"}, []string{<-input, <-input})
+
+	detachFirst()
+	<-firstDone
+	_, err = engine.Write([]byte("done"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return second.String() == "prompt$ done"
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, "prompt$ ", first.String(), "detached client must not get output")
+
+	detachSecond()
+	<-secondDone
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("hub must be closed once the last client detaches")
+	}
+	_, _, ok = hub.add(&first)
+	require.False(t, ok, "closed hub must not accept clients")
+}
+
+func TestTTYHub_EOF(t *testing.T) {
+	conn, engine := net.Pipe()
+	closed := make(chan struct{})
+	hub := newTTYHub(conn, func() { close(closed) })
+
+	_, done, ok := hub.add(nil)
+	require.True(t, ok)
+	require.NoError(t, engine.Close())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("client must be detached once output ends")
+	}
+	<-closed
+	_, err := hub.Write([]byte("late"))
+	require.Error(t, err)
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	ocibundle "github.com/sylabs/singularity/pkg/ocibundle/sif"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	bundleOverlayPath = "overlay"
+	overlayUpperPath  = "upper"
+	overlayWorkPath   = "work"
+)
+
+// overlaySupport caches whether kernel accepts optional overlay options.
+var overlaySupport = struct {
+	sync.Mutex
+	options map[string]bool
+}{options: make(map[string]bool)}
+
+// createBundle creates OCI bundle at bundlePath with SIF image mounted as rootfs.
+func createBundle(imagePath, bundlePath string) error {
+	d, err := ocibundle.FromSif(imagePath, bundlePath, true)
+	if err != nil {
+		return fmt.Errorf("could not create SIF bundle driver: %v", err)
+	}
+	if err := d.Create(nil); err != nil {
+		return fmt.Errorf("could not create SIF bundle: %v", err)
+	}
+	return nil
+}
+
+// deleteBundle unmounts rootfs and removes OCI bundle at bundlePath.
+func deleteBundle(bundlePath string) error {
+	d, err := ocibundle.FromSif("", bundlePath, true)
+	if err != nil {
+		return fmt.Errorf("could not create SIF bundle driver: %v", err)
+	}
+	if err := d.Delete(); err != nil {
+		return fmt.Errorf("could not delete SIF bundle: %v", err)
+	}
+	return nil
+}
+
+// createOverlayBundle creates OCI bundle at bundlePath with rootfs being
+// a writable overlay on top of the shared read-only lowerDir. Metacopy is
+// used whenever kernel supports it, volatile only when requested. Optional
+// options that were actually used are returned.
+func createOverlayBundle(lowerDir, bundlePath string, volatile bool) ([]string, error) {
+	rootfs := filepath.Join(bundlePath, contRootfsPath)
+	upper := filepath.Join(bundlePath, bundleOverlayPath, overlayUpperPath)
+	work := filepath.Join(bundlePath, bundleOverlayPath, overlayWorkPath)
+	for _, dir := range []string{rootfs, upper, work} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("could not create bundle directory: %v", err)
+		}
+	}
+
+	wanted := []string{overlayMetacopy}
+	if volatile {
+		wanted = append(wanted, overlayVolatile)
+	}
+	var extra []string
+	for _, option := range wanted {
+		if supportsOverlayOption(option, filepath.Join(bundlePath, bundleOverlayPath)) {
+			extra = append(extra, option)
+		}
+	}
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lowerDir, upper, work)
+	err := unix.Mount("overlay", rootfs, "overlay", 0, joinOptions(options, extra))
+	if err == unix.EINVAL && len(extra) != 0 {
+		glog.V(2).Infof("Could not mount overlay with %s, retrying without them: %v", strings.Join(extra, ","), err)
+		extra = nil
+		err = unix.Mount("overlay", rootfs, "overlay", 0, options)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not mount overlay: %v", err)
+	}
+	return extra, nil
+}
+
+// supportsOverlayOption reports whether kernel accepts the overlay mount
+// option. Support is probed once by mounting a scratch overlay under dir,
+// which is expected to reside on the same filesystem as upper directories.
+func supportsOverlayOption(option, dir string) bool {
+	overlaySupport.Lock()
+	defer overlaySupport.Unlock()
+
+	supported, ok := overlaySupport.options[option]
+	if !ok {
+		supported = probeOverlayOption(option, dir)
+		glog.V(2).Infof("Overlay option %s supported: %t", option, supported)
+		overlaySupport.options[option] = supported
+	}
+	return supported
+}
+
+func probeOverlayOption(option, dir string) bool {
+	probe, err := ioutil.TempDir(dir, "probe-")
+	if err != nil {
+		glog.Warningf("Could not create overlay probe directory: %v", err)
+		return false
+	}
+	defer os.RemoveAll(probe)
+
+	var dirs []string
+	for _, name := range []string{"lower", "upper", "work", "merged"} {
+		d := filepath.Join(probe, name)
+		if err := os.Mkdir(d, 0700); err != nil {
+			glog.Warningf("Could not create overlay probe directory: %v", err)
+			return false
+		}
+		dirs = append(dirs, d)
+	}
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s,%s", dirs[0], dirs[1], dirs[2], option)
+	if err := unix.Mount("overlay", dirs[3], "overlay", 0, options); err != nil {
+		return false
+	}
+	if err := unix.Unmount(dirs[3], unix.MNT_DETACH); err != nil {
+		glog.Warningf("Could not unmount overlay probe: %v", err)
+	}
+	return true
+}
+
+func joinOptions(options string, extra []string) string {
+	if len(extra) == 0 {
+		return options
+	}
+	return options + "," + strings.Join(extra, ",")
+}
+
+// mountWritableTmpfs mounts tmpfs of the passed size in bytes where
+// createOverlayBundle keeps writable layer of bundle at bundlePath.
+func mountWritableTmpfs(bundlePath string, size int64) error {
+	dir := filepath.Join(bundlePath, bundleOverlayPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create %s: %v", dir, err)
+	}
+	options := fmt.Sprintf("size=%d,mode=0755", size)
+	if err := unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, options); err != nil {
+		return fmt.Errorf("could not mount tmpfs: %v", err)
+	}
+	return nil
+}
+
+// deleteOverlayBundle unmounts rootfs and removes OCI bundle at bundlePath.
+// Shared lower directory is left intact.
+func deleteOverlayBundle(bundlePath string) error {
+	rootfs := filepath.Join(bundlePath, contRootfsPath)
+	err := unix.Unmount(rootfs, unix.MNT_DETACH)
+	if err != nil && err != unix.EINVAL && err != unix.ENOENT {
+		return fmt.Errorf("could not unmount %s: %v", rootfs, err)
+	}
+	// writable layer is on tmpfs when its size is limited, see mountWritableTmpfs
+	overlay := filepath.Join(bundlePath, bundleOverlayPath)
+	err = unix.Unmount(overlay, unix.MNT_DETACH)
+	if err != nil && err != unix.EINVAL && err != unix.ENOENT {
+		return fmt.Errorf("could not unmount %s: %v", overlay, err)
+	}
+	if err := os.RemoveAll(bundlePath); err != nil {
+		return fmt.Errorf("could not remove bundle: %v", err)
+	}
+	return nil
+}
+
+// detachMount lazily unmounts path. Path that is not
+// a mount point or doesn't exist is not an error.
+func detachMount(path string) error {
+	err := unix.Unmount(path, unix.MNT_DETACH)
+	if err != nil && err != unix.EINVAL && err != unix.ENOENT {
+		return fmt.Errorf("could not unmount %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestCreateOverlayBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overlay-bundle-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	lower := filepath.Join(dir, "lower")
+	require.NoError(t, os.Mkdir(lower, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(lower, "file"), []byte("lower"), 0644))
+
+	for _, volatile := range []bool{false, true} {
+		bundle := filepath.Join(dir, "bundle")
+		options, err := createOverlayBundle(lower, bundle, volatile)
+		if err != nil && err.Error() == "could not mount overlay: "+unix.EPERM.Error() {
+			t.Skipf("Overlay mount is not permitted: %v", err)
+		}
+		require.NoError(t, err)
+		if !volatile {
+			require.NotContains(t, options, overlayVolatile)
+		}
+		for _, option := range options {
+			require.True(t, overlaySupport.options[option], "unsupported option %s is used", option)
+		}
+		// probe directories are cleaned up
+		entries, err := ioutil.ReadDir(filepath.Join(bundle, bundleOverlayPath))
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+
+		data, err := ioutil.ReadFile(filepath.Join(bundle, contRootfsPath, "file"))
+		require.NoError(t, err)
+		require.Equal(t, "lower", string(data))
+		require.NoError(t, deleteOverlayBundle(bundle))
+	}
+}
+
+// newBundleFixture creates lower directory with total of size bytes
+// spread over files of 1MiB each.
+func newBundleFixture(b *testing.B, dir string, size int) string {
+	lower := filepath.Join(dir, "lower")
+	require.NoError(b, os.MkdirAll(filepath.Join(lower, "usr", "lib"), 0755))
+	chunk := make([]byte, 1<<20)
+	for i := 0; i < size/len(chunk); i++ {
+		name := filepath.Join(lower, "usr", "lib", fmt.Sprintf("lib%d.so", i))
+		require.NoError(b, ioutil.WriteFile(name, chunk, 0644))
+	}
+	return lower
+}
+
+// BenchmarkBundle compares container rootfs creation with an overlay
+// on top of shared image content to copying image content into bundle.
+func BenchmarkBundle(b *testing.B) {
+	const fixtureSize = 256 << 20
+
+	dir, err := ioutil.TempDir("", "bundle-bench-")
+	require.NoError(b, err)
+	defer os.RemoveAll(dir)
+	lower := newBundleFixture(b, dir, fixtureSize)
+	libs := filepath.Join(lower, "usr", "lib")
+
+	b.Run("overlay", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bundle := filepath.Join(dir, "bundle")
+			_, err := createOverlayBundle(lower, bundle, false)
+			if err != nil && err.Error() == "could not mount overlay: "+unix.EPERM.Error() {
+				b.Skipf("Overlay mount is not permitted: %v", err)
+			}
+			require.NoError(b, err)
+			require.NoError(b, deleteOverlayBundle(bundle))
+		}
+	})
+	b.Run("copy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rootfs := filepath.Join(dir, "bundle", contRootfsPath, "usr", "lib")
+			require.NoError(b, os.MkdirAll(rootfs, 0755))
+			files, err := ioutil.ReadDir(libs)
+			require.NoError(b, err)
+			for _, fi := range files {
+				require.NoError(b, copyFile(filepath.Join(libs, fi.Name()), filepath.Join(rootfs, fi.Name())))
+			}
+			require.NoError(b, os.RemoveAll(filepath.Join(dir, "bundle")))
+		}
+	})
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux
+
+package kube
+
+// createBundle returns ErrNotSupported since SIF
+// images can be mounted only on Linux.
+func createBundle(imagePath, bundlePath string) error {
+	return ErrNotSupported
+}
+
+// deleteBundle returns ErrNotSupported.
+func deleteBundle(bundlePath string) error {
+	return ErrNotSupported
+}
+
+// createOverlayBundle returns ErrNotSupported.
+func createOverlayBundle(lowerDir, bundlePath string, volatile bool) ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+// mountWritableTmpfs returns ErrNotSupported.
+func mountWritableTmpfs(bundlePath string, size int64) error {
+	return ErrNotSupported
+}
+
+// deleteOverlayBundle returns ErrNotSupported.
+func deleteOverlayBundle(bundlePath string) error {
+	return ErrNotSupported
+}
+
+// detachMount returns ErrNotSupported.
+func detachMount(path string) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// AnnotationNoNewPrivs is a pod annotation that makes pod process and all pod
+// containers run with no_new_privs set, e.g. "true". CRI has no pod level
+// setting for it, container security context may only enable it further.
+const AnnotationNoNewPrivs = "singularity.cri/no-new-privs"
+
+// DefaultCapabilities is a bounding set of container processes unless
+// container security context adds or drops any capabilities. It is the
+// same set other container runtimes use.
+var DefaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// ParseNoNewPrivs returns whether no_new_privs is requested by pod annotations.
+func ParseNoNewPrivs(annotations map[string]string) (bool, error) {
+	value, ok := annotations[AnnotationNoNewPrivs]
+	if !ok {
+		return false, nil
+	}
+	noNewPrivs, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s annotation %q: expected boolean", AnnotationNoNewPrivs, value)
+	}
+	return noNewPrivs, nil
+}
+
+// containerCapabilities computes capability sets of container process run as
+// uid. Bounding set is DefaultCapabilities with added capabilities and without
+// dropped ones, see prepareCapabilities for how add and drop lists are resolved.
+// Process run as root is given the whole bounding set, others are given added
+// capabilities only, which are also ambient so that they survive execve.
+func containerCapabilities(caps *k8s.Capability, uid uint32) *specs.LinuxCapabilities {
+	added := caps.GetAddCapabilities()
+	dropped := make(map[string]bool)
+	for _, capb := range caps.GetDropCapabilities() {
+		dropped[capb] = true
+	}
+
+	bounding := make([]string, 0, len(DefaultCapabilities)+len(added))
+	seen := make(map[string]bool)
+	for _, list := range [][]string{DefaultCapabilities, added} {
+		for _, capb := range list {
+			if seen[capb] || dropped[capb] {
+				continue
+			}
+			seen[capb] = true
+			bounding = append(bounding, capb)
+		}
+	}
+
+	granted := bounding
+	if uid != 0 {
+		granted = added
+	}
+	return &specs.LinuxCapabilities{
+		Bounding:    bounding,
+		Effective:   append([]string{}, granted...),
+		Permitted:   append([]string{}, granted...),
+		Inheritable: append([]string{}, added...),
+		Ambient:     append([]string{}, added...),
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestContainerCapabilities(t *testing.T) {
+	tt := []struct {
+		name   string
+		add    []string
+		drop   []string
+		uid    uint32
+		expect *specs.LinuxCapabilities
+	}{
+		{
+			name: "defaults",
+			expect: &specs.LinuxCapabilities{
+				Bounding:    DefaultCapabilities,
+				Effective:   DefaultCapabilities,
+				Permitted:   DefaultCapabilities,
+				Inheritable: []string{},
+				Ambient:     []string{},
+			},
+		},
+		{
+			name: "non-root defaults",
+			uid:  1000,
+			expect: &specs.LinuxCapabilities{
+				Bounding:    DefaultCapabilities,
+				Effective:   []string{},
+				Permitted:   []string{},
+				Inheritable: []string{},
+				Ambient:     []string{},
+			},
+		},
+		{
+			name: "drop all add one",
+			add:  []string{"net_admin"},
+			drop: []string{"ALL"},
+			uid:  1000,
+			expect: &specs.LinuxCapabilities{
+				Bounding:    []string{"CAP_NET_ADMIN"},
+				Effective:   []string{"CAP_NET_ADMIN"},
+				Permitted:   []string{"CAP_NET_ADMIN"},
+				Inheritable: []string{"CAP_NET_ADMIN"},
+				Ambient:     []string{"CAP_NET_ADMIN"},
+			},
+		},
+		{
+			name: "add dropped",
+			add:  []string{"CAP_CHOWN"},
+			drop: []string{"CAP_CHOWN", "CAP_KILL"},
+			expect: &specs.LinuxCapabilities{
+				Bounding: []string{
+					"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_FSETID", "CAP_FOWNER", "CAP_MKNOD",
+					"CAP_NET_RAW", "CAP_SETGID", "CAP_SETUID", "CAP_SETFCAP", "CAP_SETPCAP",
+					"CAP_NET_BIND_SERVICE", "CAP_SYS_CHROOT", "CAP_AUDIT_WRITE",
+				},
+				Effective: []string{
+					"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_FSETID", "CAP_FOWNER", "CAP_MKNOD",
+					"CAP_NET_RAW", "CAP_SETGID", "CAP_SETUID", "CAP_SETFCAP", "CAP_SETPCAP",
+					"CAP_NET_BIND_SERVICE", "CAP_SYS_CHROOT", "CAP_AUDIT_WRITE",
+				},
+				Permitted: []string{
+					"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_FSETID", "CAP_FOWNER", "CAP_MKNOD",
+					"CAP_NET_RAW", "CAP_SETGID", "CAP_SETUID", "CAP_SETFCAP", "CAP_SETPCAP",
+					"CAP_NET_BIND_SERVICE", "CAP_SYS_CHROOT", "CAP_AUDIT_WRITE",
+				},
+				Inheritable: []string{"CAP_CHOWN"},
+				Ambient:     []string{"CAP_CHOWN"},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// lists are resolved on container validation
+			add := prepareCapabilities(tc.add, nil)
+			caps := &k8s.Capability{
+				AddCapabilities:  add,
+				DropCapabilities: prepareCapabilities(tc.drop, add),
+			}
+			require.Equal(t, tc.expect, containerCapabilities(caps, tc.uid))
+		})
+	}
+}
+
+func TestParseNoNewPrivs(t *testing.T) {
+	noNewPrivs, err := ParseNoNewPrivs(nil)
+	require.NoError(t, err)
+	require.False(t, noNewPrivs)
+
+	noNewPrivs, err = ParseNoNewPrivs(map[string]string{AnnotationNoNewPrivs: "true"})
+	require.NoError(t, err)
+	require.True(t, noNewPrivs)
+
+	_, err = ParseNoNewPrivs(map[string]string{AnnotationNoNewPrivs: "yes"})
+	require.Error(t, err)
+}
@@ -0,0 +1,306 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// cgroupMount is a mounted cgroup hierarchy.
+type cgroupMount struct {
+	mountPoint string
+	root       string
+	unified    bool
+	options    map[string]bool
+}
+
+// cgroupV1Controllers lists controllers that may appear in cgroup v1 mount options.
+var cgroupV1Controllers = []string{
+	"blkio", "cpu", "cpuacct", "cpuset", "devices", "freezer",
+	"hugetlb", "memory", "net_cls", "net_prio", "perf_event", "pids",
+}
+
+// CgroupInfo describes cgroup hierarchies mounted on the host.
+type CgroupInfo struct {
+	// Version is one of v1, v2 or hybrid.
+	Version string `json:"version"`
+	// Controllers are available cgroup controllers in sorted order.
+	Controllers []string `json:"controllers"`
+	// UnifiedMount is where cgroup v2 hierarchy is mounted, if any.
+	UnifiedMount string `json:"unifiedMount,omitempty"`
+}
+
+// unifiedMount is set when host runs cgroup v2 unified hierarchy only, see
+// SetCgroupInfo. Engine may know nothing about it, so container cgroups and
+// resource limits are then set up by the runtime itself.
+var unifiedMount string
+
+// SetCgroupInfo remembers cgroup hierarchies detected on startup.
+func SetCgroupInfo(info *CgroupInfo) {
+	unifiedMount = ""
+	if info != nil && info.Version == "v2" {
+		unifiedMount = info.UnifiedMount
+	}
+}
+
+// DetectCgroups inspects cgroup hierarchies mounted on the host.
+func DetectCgroups() (*CgroupInfo, error) {
+	mounts, err := readCgroupMounts()
+	if err != nil {
+		return nil, err
+	}
+	return cgroupInfo(mounts, ioutil.ReadFile)
+}
+
+// readCgroupMounts returns cgroup hierarchies mounted on the host.
+func readCgroupMounts() ([]cgroupMount, error) {
+	mountInfo, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("could not open mountinfo: %v", err)
+	}
+	defer mountInfo.Close()
+
+	mounts, err := parseCgroupMounts(mountInfo)
+	if err != nil {
+		return nil, fmt.Errorf("could not read cgroup mounts: %v", err)
+	}
+	return mounts, nil
+}
+
+func cgroupInfo(mounts []cgroupMount, readFile func(string) ([]byte, error)) (*CgroupInfo, error) {
+	var v1, v2 bool
+	info := &CgroupInfo{}
+	controllers := make(map[string]bool)
+	for _, mount := range mounts {
+		if !mount.unified {
+			v1 = true
+			for _, c := range cgroupV1Controllers {
+				if mount.options[c] {
+					controllers[c] = true
+				}
+			}
+			continue
+		}
+		v2 = true
+		if info.UnifiedMount == "" {
+			info.UnifiedMount = mount.mountPoint
+		}
+		data, err := readFile(filepath.Join(mount.mountPoint, "cgroup.controllers"))
+		if err != nil {
+			return nil, fmt.Errorf("could not read cgroup v2 controllers: %v", err)
+		}
+		for _, c := range strings.Fields(string(data)) {
+			controllers[c] = true
+		}
+	}
+
+	switch {
+	case v1 && v2:
+		info.Version = "hybrid"
+	case v1:
+		info.Version = "v1"
+	case v2:
+		info.Version = "v2"
+	default:
+		return nil, fmt.Errorf("no mounted cgroup hierarchies found")
+	}
+	for c := range controllers {
+		info.Controllers = append(info.Controllers, c)
+	}
+	sort.Strings(info.Controllers)
+	return info, nil
+}
+
+// cgroupProcsFiles returns cgroup.procs files of all cgroups process with the
+// passed pid belongs to. Writing pid into them moves process into the same cgroups.
+// Hierarchies that are not mounted on the host are skipped.
+func cgroupProcsFiles(pid int) ([]string, error) {
+	cgroupFile, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return nil, fmt.Errorf("could not open process cgroups: %v", err)
+	}
+	defer cgroupFile.Close()
+
+	mountInfo, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("could not open mountinfo: %v", err)
+	}
+	defer mountInfo.Close()
+
+	mounts, err := parseCgroupMounts(mountInfo)
+	if err != nil {
+		return nil, fmt.Errorf("could not read cgroup mounts: %v", err)
+	}
+	return resolveCgroupProcs(cgroupFile, mounts)
+}
+
+// parseCgroupMounts reads cgroup mounts from mountinfo, see proc(5).
+func parseCgroupMounts(r io.Reader) ([]cgroupMount, error) {
+	var mounts []cgroupMount
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		sep := strings.Index(line, " - ")
+		if sep == -1 {
+			continue
+		}
+		fields := strings.Fields(line[:sep])
+		extra := strings.Fields(line[sep+3:])
+		if len(fields) < 5 || len(extra) < 3 {
+			continue
+		}
+		if extra[0] != "cgroup" && extra[0] != "cgroup2" {
+			continue
+		}
+		mount := cgroupMount{
+			root:       fields[3],
+			mountPoint: fields[4],
+			unified:    extra[0] == "cgroup2",
+			options:    make(map[string]bool),
+		}
+		for _, opt := range strings.Split(extra[2], ",") {
+			mount.options[opt] = true
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts, scanner.Err()
+}
+
+// resolveCgroupProcs matches process cgroups read from /proc/<pid>/cgroup
+// against mounted hierarchies and returns paths of cgroup.procs files.
+func resolveCgroupProcs(r io.Reader, mounts []cgroupMount) ([]string, error) {
+	var procs []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		mount, ok := findCgroupMount(mounts, parts[0] == "0" && parts[1] == "", parts[1])
+		if !ok {
+			continue
+		}
+		dir, ok := mount.dir(parts[2])
+		if !ok {
+			continue
+		}
+		procs = append(procs, filepath.Join(dir, "cgroup.procs"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(procs) == 0 {
+		return nil, fmt.Errorf("no mounted cgroup hierarchies found")
+	}
+	return procs, nil
+}
+
+func findCgroupMount(mounts []cgroupMount, unified bool, controllers string) (cgroupMount, bool) {
+	for _, mount := range mounts {
+		if mount.unified != unified {
+			continue
+		}
+		if unified {
+			return mount, true
+		}
+		matches := true
+		for _, c := range strings.Split(controllers, ",") {
+			if !mount.options[c] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return mount, true
+		}
+	}
+	return cgroupMount{}, false
+}
+
+// dir returns host path of the cgroup located at path in the hierarchy.
+func (m cgroupMount) dir(path string) (string, bool) {
+	if m.root != "/" {
+		rel, err := filepath.Rel(m.root, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return "", false
+		}
+		path = rel
+	}
+	return filepath.Join(m.mountPoint, path), true
+}
+
+// cgroupV1Dir returns host path of cgroup v1 directory process with the
+// passed pid belongs to in the hierarchy of the passed controller.
+func cgroupV1Dir(pid int, controller string) (string, error) {
+	cgroupFile, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", fmt.Errorf("could not open process cgroups: %v", err)
+	}
+	defer cgroupFile.Close()
+
+	mountInfo, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", fmt.Errorf("could not open mountinfo: %v", err)
+	}
+	defer mountInfo.Close()
+
+	mounts, err := parseCgroupMounts(mountInfo)
+	if err != nil {
+		return "", fmt.Errorf("could not read cgroup mounts: %v", err)
+	}
+	return resolveCgroupV1Dir(cgroupFile, mounts, controller)
+}
+
+// resolveCgroupV1Dir finds controller hierarchy in process cgroups read
+// from /proc/<pid>/cgroup and returns host path of the cgroup directory.
+func resolveCgroupV1Dir(r io.Reader, mounts []cgroupMount, controller string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		found := false
+		for _, c := range strings.Split(parts[1], ",") {
+			if c == controller {
+				found = true
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+		mount, ok := findCgroupMount(mounts, false, parts[1])
+		if !ok {
+			break
+		}
+		dir, ok := mount.dir(parts[2])
+		if !ok {
+			break
+		}
+		return dir, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s cgroup is not mounted", controller)
+}
@@ -0,0 +1,336 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	minCPUShares = 2
+	maxCPUShares = 262144
+	maxCPUWeight = 10000
+
+	// defaultCPUPeriod is CFS period in microseconds used
+	// when cpu quota is requested without a period.
+	defaultCPUPeriod = 100000
+)
+
+// unifiedControllers are cgroup v2 controllers enabled for
+// container cgroups created by the runtime.
+var unifiedControllers = []string{"cpu", "cpuset", "hugetlb", "memory", "pids"}
+
+// CgroupLimits holds limits in effect for container as read back from its
+// cgroup v2 files. Values are kept in cgroup file format, e.g. max stands
+// for no limit, and are empty when the file is not available.
+type CgroupLimits struct {
+	CPUWeight string `json:"cpuWeight,omitempty"`
+	CPUMax    string `json:"cpuMax,omitempty"`
+	MemoryMax string `json:"memoryMax,omitempty"`
+	PidsMax   string `json:"pidsMax,omitempty"`
+	Cpus      string `json:"cpusetCpusEffective,omitempty"`
+}
+
+// String formats limits using cgroup file names.
+func (l *CgroupLimits) String() string {
+	return fmt.Sprintf("cpu.weight=%s cpu.max=%q memory.max=%s pids.max=%s cpuset.cpus.effective=%s",
+		l.CPUWeight, l.CPUMax, l.MemoryMax, l.PidsMax, l.Cpus)
+}
+
+// ValidateCPUWeight checks that cpu shares requested by the passed resources
+// can be converted into cgroup v2 cpu weight. Zero shares are left to defaults.
+func ValidateCPUWeight(res *k8s.LinuxContainerResources) error {
+	shares := res.GetCpuShares()
+	if shares != 0 && (shares < minCPUShares || shares > maxCPUShares) {
+		return fmt.Errorf("cpu shares %d is out of range [%d, %d]", shares, minCPUShares, maxCPUShares)
+	}
+	return nil
+}
+
+// cpuSharesToWeight maps cgroup v1 cpu shares range [2, 262144] onto
+// cgroup v2 cpu weight range [1, 10000] the same way runc and crun do.
+func cpuSharesToWeight(shares uint64) uint64 {
+	if shares < minCPUShares {
+		shares = minCPUShares
+	}
+	if shares > maxCPUShares {
+		shares = maxCPUShares
+	}
+	return 1 + ((shares-minCPUShares)*(maxCPUWeight-1))/(maxCPUShares-minCPUShares)
+}
+
+// EffectiveLimits reads limits currently in effect for container cgroup.
+// It returns nil when container has no cgroup v2 directory.
+func (c *Container) EffectiveLimits() *CgroupLimits {
+	dir := unifiedCgroupDir(c.cgroupDirs)
+	if dir == "" {
+		return nil
+	}
+	return readCgroupLimits(dir)
+}
+
+// applyResources writes requested resources into container cgroup v2 files
+// and warns about requested values that cannot take effect because pod cgroup
+// is more restrictive. Engine passes cpu shares as is and cgroup v2 has no
+// such knob, so cpu weight is converted and set here. On hosts with unified
+// hierarchy only the engine sets no limits at all, so all of them are written
+// along with hugepage limits set with AnnotationHugepageLimits.
+// Files of controllers not enabled for container cgroup are skipped.
+// Fake engine processes share cgroups with the runtime and are skipped.
+func (c *Container) applyResources(res *k8s.LinuxContainerResources) {
+	if runtime.IsFake(c.cli) {
+		return
+	}
+	c.resolveCgroupDirs()
+	dir := unifiedCgroupDir(c.cgroupDirs)
+	if dir == "" {
+		return
+	}
+	var pidsLimit int64
+	if defaults := c.nodeDefaults(); defaults != nil {
+		pidsLimit = defaults.PidsLimit
+	}
+	files := unifiedResources(res, pidsLimit)
+	for _, limit := range c.hugepageLimits {
+		files = append(files, cgroupFile{
+			name:  fmt.Sprintf("hugetlb.%s.max", limit.Pagesize),
+			value: strconv.FormatUint(limit.Limit, 10),
+		})
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := ioutil.WriteFile(path, []byte(f.value), 0644); err != nil {
+			c.warnings.Logf(glog.WarningDepth, "Could not set container %s %s to %s: %v", c.id, f.name, f.value, err)
+		}
+	}
+	limits := readCgroupLimits(dir)
+	glog.V(4).Infof("Container %s effective limits: %s", c.id, limits)
+	for _, w := range limitWarnings(res, limits, readCgroupLimits(filepath.Dir(dir))) {
+		glog.Warningf("Container %s: %s", c.id, w)
+	}
+}
+
+// cgroupFile is a value to be written into cgroup file.
+type cgroupFile struct {
+	name  string
+	value string
+}
+
+// unifiedResources translates requested resources into cgroup v2 controller
+// files. Resources that are not requested are left out, so that values set
+// before, e.g. on container creation, are kept on update.
+func unifiedResources(res *k8s.LinuxContainerResources, pidsLimit int64) []cgroupFile {
+	var files []cgroupFile
+	if shares := res.GetCpuShares(); shares != 0 {
+		weight := strconv.FormatUint(cpuSharesToWeight(uint64(shares)), 10)
+		files = append(files, cgroupFile{name: "cpu.weight", value: weight})
+	}
+	if quota := res.GetCpuQuota(); quota > 0 {
+		period := res.GetCpuPeriod()
+		if period <= 0 {
+			period = defaultCPUPeriod
+		}
+		files = append(files, cgroupFile{name: "cpu.max", value: fmt.Sprintf("%d %d", quota, period)})
+	}
+	if limit := res.GetMemoryLimitInBytes(); limit > 0 {
+		files = append(files, cgroupFile{name: "memory.max", value: strconv.FormatInt(limit, 10)})
+	}
+	if pidsLimit > 0 {
+		files = append(files, cgroupFile{name: "pids.max", value: strconv.FormatInt(pidsLimit, 10)})
+	}
+	if cpus := res.GetCpusetCpus(); cpus != "" {
+		files = append(files, cgroupFile{name: "cpuset.cpus", value: cpus})
+	}
+	if mems := res.GetCpusetMems(); mems != "" {
+		files = append(files, cgroupFile{name: "cpuset.mems", value: mems})
+	}
+	return files
+}
+
+// placeUnifiedCgroup moves container process into its own cgroup under pod
+// cgroup parent on hosts with cgroup v2 unified hierarchy only, unless engine
+// has already done so. Controllers needed for resource limits are enabled
+// on the way down from hierarchy root.
+func (c *Container) placeUnifiedCgroup() error {
+	if unifiedMount == "" || runtime.IsFake(c.cli) || c.Pid() <= 0 {
+		return nil
+	}
+	dir := filepath.Join(unifiedMount, c.pod.cgroupPath, c.pod.cgroupDriver.leaf(c.id))
+	procs, err := cgroupProcsFiles(c.Pid())
+	if err != nil {
+		return fmt.Errorf("could not find container cgroups: %v", err)
+	}
+	for _, p := range procs {
+		if filepath.Dir(p) == dir {
+			return nil
+		}
+	}
+	created, err := createUnifiedCgroup(unifiedMount, dir)
+	if err != nil {
+		return err
+	}
+	if created {
+		c.ownCgroup = dir
+	}
+	if err := joinCgroups(c.Pid(), []string{filepath.Join(dir, "cgroup.procs")}); err != nil {
+		return err
+	}
+	glog.V(4).Infof("Container %s is placed into %s", c.id, dir)
+	c.cgroupDirs = nil
+	c.resolveCgroupDirs()
+	return nil
+}
+
+// createUnifiedCgroup creates cgroup v2 directory and enables controllers
+// for it in subtree_control of every ancestor up to the hierarchy root.
+// Controllers that cannot be enabled, e.g. because they are not available,
+// are skipped. Created reports whether directory did not exist before.
+func createUnifiedCgroup(root, dir string) (bool, error) {
+	root, dir = filepath.Clean(root), filepath.Clean(dir)
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return false, fmt.Errorf("%s is outside of cgroup hierarchy %s", dir, root)
+	}
+	var ancestors []string
+	for p := filepath.Dir(dir); ; p = filepath.Dir(p) {
+		ancestors = append([]string{p}, ancestors...)
+		if p == root {
+			break
+		}
+	}
+	for _, p := range ancestors {
+		if err := os.Mkdir(p, 0755); err != nil && !os.IsExist(err) {
+			return false, fmt.Errorf("could not create cgroup %s: %v", p, err)
+		}
+		control := filepath.Join(p, "cgroup.subtree_control")
+		for _, controller := range unifiedControllers {
+			if err := ioutil.WriteFile(control, []byte("+"+controller), 0644); err != nil {
+				glog.V(4).Infof("Could not enable %s controller in %s: %v", controller, p, err)
+			}
+		}
+	}
+	err = os.Mkdir(dir, 0755)
+	if err != nil && !os.IsExist(err) {
+		return false, fmt.Errorf("could not create cgroup %s: %v", dir, err)
+	}
+	return err == nil, nil
+}
+
+// removeUnifiedCgroup removes cgroup created by placeUnifiedCgroup.
+// It must be called once container processes are gone.
+func (c *Container) removeUnifiedCgroup() {
+	if c.ownCgroup == "" {
+		return
+	}
+	if err := os.Remove(c.ownCgroup); err != nil && !os.IsNotExist(err) {
+		glog.Warningf("Could not remove container %s cgroup %s: %v", c.id, c.ownCgroup, err)
+		return
+	}
+	c.ownCgroup = ""
+}
+
+// unifiedCgroupDir returns the cgroup v2 directory among the passed ones.
+func unifiedCgroupDir(dirs []string) string {
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, "cgroup.controllers")); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+func readCgroupLimits(dir string) *CgroupLimits {
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+	return &CgroupLimits{
+		CPUWeight: read("cpu.weight"),
+		CPUMax:    read("cpu.max"),
+		MemoryMax: read("memory.max"),
+		PidsMax:   read("pids.max"),
+		Cpus:      read("cpuset.cpus.effective"),
+	}
+}
+
+// limitWarnings compares requested resources with limits in effect for
+// container and its parent cgroup and describes values that do not apply.
+func limitWarnings(res *k8s.LinuxContainerResources, limits, parent *CgroupLimits) []string {
+	var warnings []string
+	if shares := res.GetCpuShares(); shares != 0 && limits.CPUWeight != "" {
+		want := strconv.FormatUint(cpuSharesToWeight(uint64(shares)), 10)
+		if limits.CPUWeight != want {
+			warnings = append(warnings, fmt.Sprintf("requested cpu.weight %s (cpu shares %d), effective %s",
+				want, shares, limits.CPUWeight))
+		}
+	}
+	if want := res.GetMemoryLimitInBytes(); want > 0 {
+		if max, err := strconv.ParseInt(parent.MemoryMax, 10, 64); err == nil && max < want {
+			warnings = append(warnings, fmt.Sprintf("requested memory limit %d exceeds pod cgroup memory.max %d",
+				want, max))
+		}
+	}
+	if quota, period := res.GetCpuQuota(), res.GetCpuPeriod(); quota > 0 && period > 0 {
+		maxQuota, maxPeriod, ok := parseCPUMax(parent.CPUMax)
+		// compare quota/period with maxQuota/maxPeriod without division
+		if ok && quota*maxPeriod > maxQuota*period {
+			warnings = append(warnings, fmt.Sprintf("requested cpu quota %d/%d exceeds pod cgroup cpu.max %d/%d",
+				quota, period, maxQuota, maxPeriod))
+		}
+	}
+	if want := res.GetCpusetCpus(); want != "" && parent.Cpus != "" {
+		cpus, err := parseCPUList(want)
+		allowed, perr := parseCPUList(parent.Cpus)
+		if err == nil && perr == nil {
+			if len(missing(cpus, allowed)) > 0 {
+				warnings = append(warnings, fmt.Sprintf("requested cpuset %s is outside of pod cgroup cpuset.cpus.effective %s",
+					want, parent.Cpus))
+			}
+		}
+	}
+	return warnings
+}
+
+// parseCPUMax parses cpu.max contents, ok is false if there is no quota.
+func parseCPUMax(val string) (quota, period int64, ok bool) {
+	fields := strings.Fields(val)
+	if len(fields) != 2 {
+		return 0, 0, false
+	}
+	quota, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	period, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || period <= 0 {
+		return 0, 0, false
+	}
+	return quota, period, true
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestCPUSharesToWeight(t *testing.T) {
+	tt := []struct {
+		shares uint64
+		weight uint64
+	}{
+		{shares: 0, weight: 1},
+		{shares: 2, weight: 1},
+		{shares: 1024, weight: 39},
+		{shares: 262144, weight: 10000},
+		{shares: 1 << 20, weight: 10000},
+	}
+	for _, tc := range tt {
+		require.Equal(t, tc.weight, cpuSharesToWeight(tc.shares), "shares %d", tc.shares)
+	}
+}
+
+func TestValidateCPUWeight(t *testing.T) {
+	require.NoError(t, ValidateCPUWeight(nil))
+	require.NoError(t, ValidateCPUWeight(&k8s.LinuxContainerResources{CpuShares: 2}))
+	require.Error(t, ValidateCPUWeight(&k8s.LinuxContainerResources{CpuShares: 1}))
+	require.Error(t, ValidateCPUWeight(&k8s.LinuxContainerResources{CpuShares: 262145}))
+}
+
+func TestCgroupLimits(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(root)
+
+	writeCgroupFiles(t, root, map[string]string{
+		"cgroup.controllers":    "cpuset cpu memory pids\n",
+		"cpu.max":               "100000 100000\n",
+		"memory.max":            "268435456\n",
+		"pids.max":              "max\n",
+		"cpuset.cpus.effective": "0-1\n",
+	})
+	cont := filepath.Join(root, "cont")
+	writeCgroupFiles(t, cont, map[string]string{
+		"cgroup.controllers":    "cpuset cpu memory pids\n",
+		"cpu.weight":            "100\n",
+		"cpu.max":               "200000 100000\n",
+		"memory.max":            "536870912\n",
+		"pids.max":              "max\n",
+		"cpuset.cpus.effective": "0-1\n",
+	})
+	v1 := filepath.Join(root, "v1")
+	writeCgroupFiles(t, v1, map[string]string{})
+
+	require.Equal(t, cont, unifiedCgroupDir([]string{v1, cont}))
+	require.Empty(t, unifiedCgroupDir([]string{v1}))
+
+	limits := readCgroupLimits(cont)
+	require.Equal(t, &CgroupLimits{
+		CPUWeight: "100",
+		CPUMax:    "200000 100000",
+		MemoryMax: "536870912",
+		PidsMax:   "max",
+		Cpus:      "0-1",
+	}, limits)
+	parent := readCgroupLimits(root)
+
+	tt := []struct {
+		name   string
+		res    *k8s.LinuxContainerResources
+		parent *CgroupLimits
+		expect []string
+	}{
+		{
+			name: "within parent",
+			res: &k8s.LinuxContainerResources{
+				CpuShares:          2,
+				CpuQuota:           50000,
+				CpuPeriod:          100000,
+				MemoryLimitInBytes: 1 << 20,
+				CpusetCpus:         "1",
+			},
+			parent: parent,
+			expect: []string{"requested cpu.weight 1 (cpu shares 2), effective 100"},
+		},
+		{
+			name: "exceeds parent",
+			res: &k8s.LinuxContainerResources{
+				CpuQuota:           200000,
+				CpuPeriod:          100000,
+				MemoryLimitInBytes: 536870912,
+				CpusetCpus:         "0-3",
+			},
+			parent: parent,
+			expect: []string{
+				"requested memory limit 536870912 exceeds pod cgroup memory.max 268435456",
+				"requested cpu quota 200000/100000 exceeds pod cgroup cpu.max 100000/100000",
+				"requested cpuset 0-3 is outside of pod cgroup cpuset.cpus.effective 0-1",
+			},
+		},
+		{
+			name: "unlimited parent",
+			res: &k8s.LinuxContainerResources{
+				CpuShares:          1024,
+				MemoryLimitInBytes: 1 << 40,
+			},
+			parent: &CgroupLimits{CPUMax: "max 100000", MemoryMax: "max"},
+			expect: []string{"requested cpu.weight 39 (cpu shares 1024), effective 100"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expect, limitWarnings(tc.res, limits, tc.parent))
+		})
+	}
+}
+
+func TestUnifiedResources(t *testing.T) {
+	tt := []struct {
+		name      string
+		res       *k8s.LinuxContainerResources
+		pidsLimit int64
+		expect    []cgroupFile
+	}{
+		{
+			name: "nothing requested",
+		},
+		{
+			name: "all requested",
+			res: &k8s.LinuxContainerResources{
+				CpuShares:          1024,
+				CpuQuota:           50000,
+				CpuPeriod:          200000,
+				MemoryLimitInBytes: 1 << 30,
+				CpusetCpus:         "0-1",
+				CpusetMems:         "0",
+			},
+			pidsLimit: 4096,
+			expect: []cgroupFile{
+				{name: "cpu.weight", value: "39"},
+				{name: "cpu.max", value: "50000 200000"},
+				{name: "memory.max", value: "1073741824"},
+				{name: "pids.max", value: "4096"},
+				{name: "cpuset.cpus", value: "0-1"},
+				{name: "cpuset.mems", value: "0"},
+			},
+		},
+		{
+			name: "quota without period",
+			res: &k8s.LinuxContainerResources{
+				CpuQuota:  25000,
+				CpuPeriod: 0,
+			},
+			expect: []cgroupFile{
+				{name: "cpu.max", value: "25000 100000"},
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expect, unifiedResources(tc.res, tc.pidsLimit))
+		})
+	}
+}
+
+func TestCreateUnifiedCgroup(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, "kubepods", "pod1", "cont1")
+	created, err := createUnifiedCgroup(root, dir)
+	require.NoError(t, err)
+	require.True(t, created)
+	require.DirExists(t, dir)
+
+	// regular files stand for subtree_control, last enabled controller is kept
+	for _, p := range []string{root, filepath.Join(root, "kubepods"), filepath.Join(root, "kubepods", "pod1")} {
+		data, err := ioutil.ReadFile(filepath.Join(p, "cgroup.subtree_control"))
+		require.NoError(t, err)
+		require.Equal(t, "+pids", string(data))
+	}
+	_, err = os.Stat(filepath.Join(dir, "cgroup.subtree_control"))
+	require.True(t, os.IsNotExist(err), "controllers must not be enabled below container")
+
+	created, err = createUnifiedCgroup(root, dir)
+	require.NoError(t, err)
+	require.False(t, created)
+
+	_, err = createUnifiedCgroup(root, root)
+	require.Error(t, err)
+	_, err = createUnifiedCgroup(filepath.Join(root, "kubepods"), filepath.Join(root, "other"))
+	require.Error(t, err)
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	systemd "github.com/coreos/go-systemd/dbus"
+	"github.com/godbus/dbus"
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// systemdJobTimeout is how long systemd jobs starting
+	// and stopping transient units are waited for.
+	systemdJobTimeout = 10 * time.Second
+
+	errUnitExists = "org.freedesktop.systemd1.UnitExists"
+	errNoSuchUnit = "org.freedesktop.systemd1.NoSuchUnit"
+)
+
+var (
+	systemdMu   sync.Mutex
+	systemdConn *systemd.Conn
+)
+
+// systemdConnection returns shared connection to systemd, it is
+// established on first use and re-established after failures.
+func systemdConnection() (*systemd.Conn, error) {
+	systemdMu.Lock()
+	defer systemdMu.Unlock()
+	if systemdConn != nil {
+		return systemdConn, nil
+	}
+	conn, err := systemd.New()
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to systemd: %v", err)
+	}
+	systemdConn = conn
+	return conn, nil
+}
+
+// resetSystemdConnection drops shared connection after it failed.
+func resetSystemdConnection(conn *systemd.Conn) {
+	systemdMu.Lock()
+	defer systemdMu.Unlock()
+	if systemdConn == conn {
+		systemdConn.Close()
+		systemdConn = nil
+	}
+}
+
+// startTransientUnit starts transient unit and waits for the start job to
+// finish. False is returned when unit exists already, e.g. slice of the pod
+// created by kubelet, such units are used as is.
+func startTransientUnit(name string, props []systemd.Property) (bool, error) {
+	conn, err := systemdConnection()
+	if err != nil {
+		return false, err
+	}
+	done := make(chan string, 1)
+	if _, err := conn.StartTransientUnit(name, "replace", props, done); err != nil {
+		if isDBusError(err, errUnitExists) {
+			return false, nil
+		}
+		if _, ok := err.(dbus.Error); !ok {
+			resetSystemdConnection(conn)
+		}
+		return false, fmt.Errorf("could not start unit %s: %v", name, err)
+	}
+	select {
+	case result := <-done:
+		if result != "done" {
+			return false, fmt.Errorf("could not start unit %s: job %s", name, result)
+		}
+	case <-time.After(systemdJobTimeout):
+		return false, fmt.Errorf("could not start unit %s: timed out", name)
+	}
+	return true, nil
+}
+
+// stopUnit stops unit and waits for the stop job to finish.
+// Units that are already gone are ignored.
+func stopUnit(name string) error {
+	conn, err := systemdConnection()
+	if err != nil {
+		return err
+	}
+	done := make(chan string, 1)
+	if _, err := conn.StopUnit(name, "replace", done); err != nil {
+		if isDBusError(err, errNoSuchUnit) {
+			return nil
+		}
+		if _, ok := err.(dbus.Error); !ok {
+			resetSystemdConnection(conn)
+		}
+		return fmt.Errorf("could not stop unit %s: %v", name, err)
+	}
+	select {
+	case result := <-done:
+		if result != "done" {
+			return fmt.Errorf("could not stop unit %s: job %s", name, result)
+		}
+	case <-time.After(systemdJobTimeout):
+		return fmt.Errorf("could not stop unit %s: timed out", name)
+	}
+	return nil
+}
+
+func isDBusError(err error, name string) bool {
+	dbusErr, ok := err.(dbus.Error)
+	return ok && dbusErr.Name == name
+}
+
+// parentSlice returns slice the passed slice is nested in, e.g.
+// kubepods-burstable.slice is a parent of kubepods-burstable-pod1.slice.
+func parentSlice(slice string) string {
+	name := strings.TrimSuffix(slice, ".slice")
+	i := strings.LastIndex(name, "-")
+	if i <= 0 {
+		return "-.slice"
+	}
+	return name[:i] + ".slice"
+}
+
+// sliceProperties returns properties of transient pod slice with pod
+// resources applied the same way kubelet applies them to slices it creates.
+func sliceProperties(podID, slice string, res *k8s.LinuxContainerResources) []systemd.Property {
+	props := []systemd.Property{
+		systemd.PropDescription("Singularity-CRI pod " + podID),
+		systemd.PropWants(parentSlice(slice)),
+		newProperty("DefaultDependencies", false),
+		newProperty("CPUAccounting", true),
+		newProperty("MemoryAccounting", true),
+	}
+	if shares := res.GetCpuShares(); shares != 0 {
+		props = append(props, newProperty("CPUShares", uint64(shares)))
+	}
+	if quota := res.GetCpuQuota(); quota > 0 {
+		period := res.GetCpuPeriod()
+		if period <= 0 {
+			period = defaultCPUPeriod
+		}
+		// systemd accepts quota per second with 10ms granularity only
+		perSec := uint64(quota) * uint64(time.Second/time.Microsecond) / uint64(period)
+		if rem := perSec % 10000; rem != 0 {
+			perSec += 10000 - rem
+		}
+		props = append(props, newProperty("CPUQuotaPerSecUSec", perSec))
+	}
+	if limit := res.GetMemoryLimitInBytes(); limit > 0 {
+		props = append(props, newProperty("MemoryLimit", uint64(limit)))
+	}
+	return props
+}
+
+// scopeProperties returns properties of transient scope process with
+// the passed pid is registered in. Cgroup subtree of the scope is
+// delegated, so that systemd leaves nested cgroups as they are.
+func scopeProperties(id, slice string, pid int) []systemd.Property {
+	return []systemd.Property{
+		systemd.PropDescription("Singularity-CRI " + id),
+		systemd.PropSlice(slice),
+		systemd.PropPids(uint32(pid)),
+		newProperty("Delegate", true),
+		newProperty("DefaultDependencies", false),
+	}
+}
+
+func newProperty(name string, value interface{}) systemd.Property {
+	return systemd.Property{
+		Name:  name,
+		Value: dbus.MakeVariant(value),
+	}
+}
+
+// setupSlice creates pod slice as transient systemd unit so that systemd,
+// and thus kubelet run with systemd cgroup driver, knows about it. Slice
+// that exists already is left as is and is not stopped at pod removal.
+func (p *Pod) setupSlice() error {
+	slice := filepath.Base(p.cgroupPath)
+	created, err := startTransientUnit(slice, sliceProperties(p.id, slice, p.resources))
+	if err != nil {
+		return err
+	}
+	if created {
+		p.ownUnits = append(p.ownUnits, slice)
+	}
+	return nil
+}
+
+// registerScope registers process with the passed pid in systemd scope named
+// after pod or container ID. Process is already in the scope cgroup created
+// by the engine, so this only makes systemd account it. Scope is stopped by
+// systemd once all its processes exit, see also unregisterScope.
+func (p *Pod) registerScope(id string, pid int) error {
+	if p.cgroupDriver != CgroupDriverSystemd || runtime.IsFake(p.cli) || pid <= 0 {
+		return nil
+	}
+	scope := p.cgroupDriver.leaf(id)
+	_, err := startTransientUnit(scope, scopeProperties(id, filepath.Base(p.cgroupPath), pid))
+	return err
+}
+
+// unregisterScope stops scope of pod or container with the passed ID.
+func (p *Pod) unregisterScope(id string) {
+	if p.cgroupDriver != CgroupDriverSystemd || runtime.IsFake(p.cli) {
+		return
+	}
+	if err := stopUnit(p.cgroupDriver.leaf(id)); err != nil {
+		glog.Warningf("Could not stop scope of %s: %v", id, err)
+	}
+}
+
+// stopUnits stops transient units created by setupSlice.
+func (p *Pod) stopUnits() {
+	for i := len(p.ownUnits) - 1; i >= 0; i-- {
+		if err := stopUnit(p.ownUnits[i]); err != nil {
+			glog.Warningf("Could not stop pod %s unit: %v", p.id, err)
+		}
+	}
+	p.ownUnits = nil
+}
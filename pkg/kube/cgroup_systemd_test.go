@@ -0,0 +1,88 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestParentSlice(t *testing.T) {
+	tt := []struct {
+		slice  string
+		expect string
+	}{
+		{slice: "kubepods.slice", expect: "-.slice"},
+		{slice: "kubepods-burstable.slice", expect: "kubepods.slice"},
+		{slice: "kubepods-burstable-pod1234.slice", expect: "kubepods-burstable.slice"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.slice, func(t *testing.T) {
+			require.Equal(t, tc.expect, parentSlice(tc.slice))
+		})
+	}
+}
+
+func TestSliceProperties(t *testing.T) {
+	tt := []struct {
+		name   string
+		res    *k8s.LinuxContainerResources
+		expect map[string]interface{}
+	}{
+		{
+			name: "no resources",
+			expect: map[string]interface{}{
+				"Description":         "Singularity-CRI pod pod1",
+				"Wants":               []string{"singularity_cri.slice"},
+				"DefaultDependencies": false,
+				"CPUAccounting":       true,
+				"MemoryAccounting":    true,
+			},
+		},
+		{
+			name: "limits",
+			res: &k8s.LinuxContainerResources{
+				CpuShares:          512,
+				CpuQuota:           33333,
+				CpuPeriod:          100000,
+				MemoryLimitInBytes: 1 << 30,
+			},
+			expect: map[string]interface{}{
+				"Description":         "Singularity-CRI pod pod1",
+				"Wants":               []string{"singularity_cri.slice"},
+				"DefaultDependencies": false,
+				"CPUAccounting":       true,
+				"MemoryAccounting":    true,
+				"CPUShares":           uint64(512),
+				"CPUQuotaPerSecUSec":  uint64(340000),
+				"MemoryLimit":         uint64(1 << 30),
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			props := sliceProperties("pod1", "singularity_cri-podpod1.slice", tc.res)
+			actual := make(map[string]interface{}, len(props))
+			for _, p := range props {
+				actual[p.Name] = p.Value.Value()
+			}
+			require.Equal(t, tc.expect, actual)
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveCgroupProcs(t *testing.T) {
+	const mountInfo = `25 30 0:23 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+33 25 0:28 / /sys/fs/cgroup ro,nosuid,nodev,noexec shared:9 - tmpfs tmpfs ro,mode=755
+34 33 0:29 / /sys/fs/cgroup/unified rw,nosuid,nodev,noexec,relatime shared:10 - cgroup2 cgroup2 rw
+35 33 0:30 / /sys/fs/cgroup/systemd rw,nosuid,nodev,noexec,relatime shared:11 - cgroup cgroup rw,xattr,name=systemd
+38 33 0:33 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:16 - cgroup cgroup rw,cpu,cpuacct
+39 33 0:34 /kubepods /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime shared:17 - cgroup cgroup rw,memory
+`
+	mounts, err := parseCgroupMounts(strings.NewReader(mountInfo))
+	require.NoError(t, err)
+	require.Len(t, mounts, 4)
+
+	tt := []struct {
+		name        string
+		cgroup      string
+		expect      []string
+		expectError bool
+	}{
+		{
+			name: "hybrid hierarchy",
+			cgroup: `12:pids:/singularity-cri/abc
+5:memory:/kubepods/pod1/abc
+3:cpu,cpuacct:/kubepods/pod1/abc
+1:name=systemd:/system.slice/sycri.service
+0::/system.slice/sycri.service
+`,
+			expect: []string{
+				"/sys/fs/cgroup/memory/pod1/abc/cgroup.procs",
+				"/sys/fs/cgroup/cpu,cpuacct/kubepods/pod1/abc/cgroup.procs",
+				"/sys/fs/cgroup/systemd/system.slice/sycri.service/cgroup.procs",
+				"/sys/fs/cgroup/unified/system.slice/sycri.service/cgroup.procs",
+			},
+		},
+		{
+			name:        "outside of mount root",
+			cgroup:      "5:memory:/other/abc\n",
+			expectError: true,
+		},
+		{
+			name:        "nothing mounted",
+			cgroup:      "7:blkio:/abc\n",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			procs, err := resolveCgroupProcs(strings.NewReader(tc.cgroup), mounts)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, procs)
+		})
+	}
+}
+
+func TestResolveCgroupV1Dir(t *testing.T) {
+	const mountInfo = `34 33 0:29 / /sys/fs/cgroup/unified rw,nosuid,nodev,noexec,relatime shared:10 - cgroup2 cgroup2 rw
+38 33 0:33 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:16 - cgroup cgroup rw,cpu,cpuacct
+39 33 0:34 /kubepods /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime shared:17 - cgroup cgroup rw,memory
+`
+	mounts, err := parseCgroupMounts(strings.NewReader(mountInfo))
+	require.NoError(t, err)
+
+	const cgroup = `5:memory:/kubepods/pod1/abc
+3:cpu,cpuacct:/kubepods/pod1/abc
+0::/system.slice/sycri.service
+`
+	tt := []struct {
+		name        string
+		controller  string
+		expect      string
+		expectError bool
+	}{
+		{
+			name:       "mount root",
+			controller: "memory",
+			expect:     "/sys/fs/cgroup/memory/pod1/abc",
+		},
+		{
+			name:       "co-mounted controllers",
+			controller: "cpuacct",
+			expect:     "/sys/fs/cgroup/cpu,cpuacct/kubepods/pod1/abc",
+		},
+		{
+			name:        "not mounted",
+			controller:  "cpuset",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := resolveCgroupV1Dir(strings.NewReader(cgroup), mounts, tc.controller)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, dir)
+		})
+	}
+}
+
+func TestCgroupInfo(t *testing.T) {
+	tt := []struct {
+		name        string
+		mountInfo   string
+		expect      *CgroupInfo
+		expectError bool
+	}{
+		{
+			name: "v1",
+			mountInfo: `38 33 0:33 / /sys/fs/cgroup/cpu,cpuacct rw,relatime shared:16 - cgroup cgroup rw,cpu,cpuacct
+39 33 0:34 / /sys/fs/cgroup/memory rw,relatime shared:17 - cgroup cgroup rw,memory
+35 33 0:30 / /sys/fs/cgroup/systemd rw,relatime shared:11 - cgroup cgroup rw,xattr,name=systemd
+`,
+			expect: &CgroupInfo{Version: "v1", Controllers: []string{"cpu", "cpuacct", "memory"}},
+		},
+		{
+			name: "hybrid",
+			mountInfo: `34 33 0:29 / /sys/fs/cgroup/unified rw,relatime shared:10 - cgroup2 cgroup2 rw
+39 33 0:34 / /sys/fs/cgroup/memory rw,relatime shared:17 - cgroup cgroup rw,memory
+`,
+			expect: &CgroupInfo{
+				Version:      "hybrid",
+				Controllers:  []string{"memory"},
+				UnifiedMount: "/sys/fs/cgroup/unified",
+			},
+		},
+		{
+			name:      "v2",
+			mountInfo: "34 33 0:29 / /sys/fs/cgroup rw,relatime shared:10 - cgroup2 cgroup2 rw\n",
+			expect: &CgroupInfo{
+				Version:      "v2",
+				Controllers:  []string{"cpu", "io", "memory", "pids"},
+				UnifiedMount: "/sys/fs/cgroup",
+			},
+		},
+		{
+			name:        "none",
+			mountInfo:   "25 30 0:23 / /sys rw,relatime shared:7 - sysfs sysfs rw\n",
+			expectError: true,
+		},
+	}
+
+	readFile := func(path string) ([]byte, error) {
+		switch path {
+		case "/sys/fs/cgroup/cgroup.controllers":
+			return []byte("cpu io memory pids\n"), nil
+		default:
+			return nil, nil
+		}
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mounts, err := parseCgroupMounts(strings.NewReader(tc.mountInfo))
+			require.NoError(t, err)
+			info, err := cgroupInfo(mounts, readFile)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, info)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity/pkg/ociruntime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// DefaultMaxHotExited is the default number of exited containers
+	// that are kept in full, i.e. not compacted regardless of their age.
+	DefaultMaxHotExited = 256
+	// DefaultExitedCompactAge is the default time since exit
+	// after which exited container is compacted.
+	DefaultExitedCompactAge = 10 * time.Minute
+)
+
+// Compact drops details of exited container that are not needed to answer
+// status, list and log requests, i.e. container command, environment, devices
+// and security settings, and caches container state since it is final.
+// Details that are still needed, e.g. for verbose status, are read lazily
+// from OCI config persisted in container bundle. Compact does nothing if
+// container is not exited.
+func (c *Container) Compact() {
+	if c.isCompacted || c.runtimeState != runtime.StateExited {
+		return
+	}
+
+	glog.V(4).Infof("Compacting exited container %s", c.id)
+	c.ContainerConfig = &k8s.ContainerConfig{
+		Metadata:    c.GetMetadata(),
+		Image:       c.GetImage(),
+		Labels:      c.GetLabels(),
+		Annotations: c.GetAnnotations(),
+		Mounts:      c.GetMounts(),
+		LogPath:     c.GetLogPath(),
+		Stdin:       c.GetStdin(),
+		StdinOnce:   c.GetStdinOnce(),
+		Tty:         c.GetTty(),
+	}
+	if c.ociState != nil {
+		c.ociState = &ociruntime.State{
+			State: specs.State{
+				ID:     c.ociState.ID,
+				Status: c.ociState.Status,
+				Pid:    c.ociState.Pid,
+			},
+			CreatedAt:  c.ociState.CreatedAt,
+			StartedAt:  c.ociState.StartedAt,
+			FinishedAt: c.ociState.FinishedAt,
+			ExitCode:   c.ociState.ExitCode,
+			ExitDesc:   c.ociState.ExitDesc,
+		}
+	}
+	c.execEnvs = nil
+	c.isCompacted = true
+}
+
+// Compacted returns true if container details were dropped by Compact.
+func (c *Container) Compacted() bool {
+	return c.isCompacted
+}
+
+// CompactExited compacts exited containers that finished more than age ago and
+// oldest exited containers beyond maxHot most recent ones. Containers that are
+// not exited or already compacted are skipped. Negative maxHot or age disables
+// the respective limit. It returns the number of compacted containers.
+func CompactExited(containers []*Container, maxHot int, age time.Duration, now time.Time) int {
+	var hot []*Container
+	for _, cont := range containers {
+		if cont.runtimeState == runtime.StateExited && !cont.isCompacted {
+			hot = append(hot, cont)
+		}
+	}
+	// most recently finished first
+	sort.SliceStable(hot, func(i, j int) bool {
+		return hot[i].FinishedAt() > hot[j].FinishedAt()
+	})
+
+	compacted := 0
+	for i, cont := range hot {
+		tooOld := age >= 0 && now.Sub(time.Unix(0, cont.FinishedAt())) > age
+		tooMany := maxHot >= 0 && i >= maxHot
+		if tooOld || tooMany {
+			cont.Compact()
+			compacted++
+		}
+	}
+	return compacted
+}
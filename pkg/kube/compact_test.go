@@ -0,0 +1,196 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity/pkg/ociruntime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func exitedContainer(finishedAt time.Time, exitCode int) *Container {
+	created := finishedAt.Add(-time.Minute).UnixNano()
+	finished := finishedAt.UnixNano()
+	config := &k8s.ContainerConfig{
+		Metadata:    &k8s.ContainerMetadata{Name: "job", Attempt: 1},
+		Image:       &k8s.ImageSpec{Image: "busybox"},
+		Command:     []string{"sh", "-c"},
+		Args:        []string{"exit 1"},
+		Envs:        []*k8s.KeyValue{{Key: "SECRET", Value: "value"}},
+		Labels:      map[string]string{"app": "batch"},
+		Annotations: map[string]string{"note": "value"},
+		Mounts:      []*k8s.Mount{{ContainerPath: "/data", HostPath: "/tmp"}},
+		LogPath:     "job/1.log",
+		Linux:       &k8s.LinuxContainerConfig{Resources: &k8s.LinuxContainerResources{CpuShares: 2}},
+	}
+	pod := &Pod{id: "pod"}
+	cont := NewContainer(config, pod, &image.Info{ID: "busybox"}, "")
+	cont.runtimeState = runtime.StateExited
+	cont.ociState = &ociruntime.State{
+		State: specs.State{
+			ID:          cont.id,
+			Status:      "exited",
+			Bundle:      "/var/run/singularity/containers/bundle",
+			Annotations: map[string]string{"io.kubernetes.cri.container-type": "container"},
+		},
+		CreatedAt:     &created,
+		StartedAt:     &created,
+		FinishedAt:    &finished,
+		ExitCode:      &exitCode,
+		ExitDesc:      "exited with code 1",
+		ControlSocket: "/var/run/control.sock",
+	}
+	return cont
+}
+
+func TestContainer_Compact(t *testing.T) {
+	cont := exitedContainer(time.Now(), 1)
+	metadata := cont.GetMetadata()
+	before := &k8s.ContainerStatus{
+		Id:          cont.ID(),
+		Metadata:    cont.GetMetadata(),
+		State:       cont.State(),
+		CreatedAt:   cont.CreatedAt(),
+		StartedAt:   cont.StartedAt(),
+		FinishedAt:  cont.FinishedAt(),
+		ExitCode:    cont.ExitCode(),
+		Image:       cont.GetImage(),
+		ImageRef:    cont.ImageRef(),
+		Reason:      cont.StateReason(),
+		Message:     cont.ExitDescription(),
+		Labels:      cont.GetLabels(),
+		Annotations: cont.GetAnnotations(),
+		Mounts:      cont.GetMounts(),
+	}
+
+	cont.Compact()
+	require.True(t, cont.Compacted())
+	require.NoError(t, cont.UpdateState())
+	require.True(t, metadata == cont.GetMetadata())
+	require.Equal(t, before, &k8s.ContainerStatus{
+		Id:          cont.ID(),
+		Metadata:    cont.GetMetadata(),
+		State:       cont.State(),
+		CreatedAt:   cont.CreatedAt(),
+		StartedAt:   cont.StartedAt(),
+		FinishedAt:  cont.FinishedAt(),
+		ExitCode:    cont.ExitCode(),
+		Image:       cont.GetImage(),
+		ImageRef:    cont.ImageRef(),
+		Reason:      cont.StateReason(),
+		Message:     cont.ExitDescription(),
+		Labels:      cont.GetLabels(),
+		Annotations: cont.GetAnnotations(),
+		Mounts:      cont.GetMounts(),
+	})
+	require.Equal(t, "job/1.log", cont.GetLogPath())
+	require.True(t, cont.MatchesFilter(&k8s.ContainerFilter{
+		PodSandboxId:  "pod",
+		State:         &k8s.ContainerStateValue{State: k8s.ContainerState_CONTAINER_EXITED},
+		LabelSelector: map[string]string{"app": "batch"},
+	}))
+
+	require.Nil(t, cont.GetEnvs())
+	require.Nil(t, cont.GetCommand())
+	require.Nil(t, cont.GetLinux())
+	require.Nil(t, cont.execEnvs)
+	require.Empty(t, cont.ociState.Annotations)
+	require.Empty(t, cont.ControlSocket())
+}
+
+func TestContainer_CompactRunning(t *testing.T) {
+	cont := exitedContainer(time.Now(), 0)
+	cont.runtimeState = runtime.StateRunning
+	cont.Compact()
+	require.False(t, cont.Compacted())
+	require.NotNil(t, cont.GetEnvs())
+}
+
+func TestCompactExited(t *testing.T) {
+	now := time.Now()
+
+	tt := []struct {
+		name     string
+		finished []time.Duration
+		maxHot   int
+		age      time.Duration
+		expect   []bool
+	}{
+		{
+			name:     "all hot",
+			finished: []time.Duration{time.Minute, 2 * time.Minute},
+			maxHot:   2,
+			age:      time.Hour,
+			expect:   []bool{false, false},
+		},
+		{
+			name:     "too old",
+			finished: []time.Duration{time.Minute, 2 * time.Hour, 30 * time.Minute},
+			maxHot:   10,
+			age:      time.Hour,
+			expect:   []bool{false, true, false},
+		},
+		{
+			name:     "too many",
+			finished: []time.Duration{3 * time.Minute, time.Minute, 2 * time.Minute},
+			maxHot:   1,
+			age:      time.Hour,
+			expect:   []bool{true, false, true},
+		},
+		{
+			name:     "limits disabled",
+			finished: []time.Duration{time.Minute, 2000 * time.Hour},
+			maxHot:   -1,
+			age:      -1,
+			expect:   []bool{false, false},
+		},
+		{
+			name:     "compact all",
+			finished: []time.Duration{time.Minute, time.Second},
+			maxHot:   0,
+			age:      -1,
+			expect:   []bool{true, true},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var containers []*Container
+			expectCount := 0
+			for i, ago := range tc.finished {
+				containers = append(containers, exitedContainer(now.Add(-ago), 0))
+				if tc.expect[i] {
+					expectCount++
+				}
+			}
+			running := exitedContainer(now.Add(-1000*time.Hour), 0)
+			running.runtimeState = runtime.StateRunning
+			containers = append(containers, running)
+
+			require.Equal(t, expectCount, CompactExited(containers, tc.maxHot, tc.age, now))
+			for i, expect := range tc.expect {
+				require.Equal(t, expect, containers[i].Compacted(), "container %d", i)
+			}
+			require.False(t, running.Compacted())
+			require.Equal(t, 0, CompactExited(containers, tc.maxHot, tc.age, now))
+		})
+	}
+}
@@ -20,13 +20,21 @@ import (
 	"fmt"
 	"io"
 	"os/exec"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity-cri/pkg/fs"
 	"github.com/sylabs/singularity-cri/pkg/image"
 	"github.com/sylabs/singularity-cri/pkg/rand"
+	"github.com/sylabs/singularity-cri/pkg/reference"
 	"github.com/sylabs/singularity-cri/pkg/singularity"
 	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity-cri/pkg/spec"
+	"github.com/sylabs/singularity-cri/pkg/warnings"
 	"github.com/sylabs/singularity/pkg/ociruntime"
 	"github.com/sylabs/singularity/pkg/util/unix"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
@@ -34,13 +42,23 @@ import (
 
 const (
 	// ContainerIDLen reflects number of symbols in container unique ID.
-	ContainerIDLen = 64
+	ContainerIDLen = rand.IDLen
+
+	// DefaultExecSyncOutputLimit is the default number of bytes of each
+	// output stream captured by ExecSync.
+	DefaultExecSyncOutputLimit = 16 << 20
+	// ExecSyncTruncatedMarker is appended to stderr of ExecSync
+	// when command output exceeded the capture limit.
+	ExecSyncTruncatedMarker = "\n[output truncated]\n"
 )
 
 var (
 	// ErrContainerNotCreated is used when attempting to perform operations on containers that
 	// are not in CONTAINER_CREATED state, e.g. start already started container.
 	ErrContainerNotCreated = fmt.Errorf("container is not in %s state", k8s.ContainerState_CONTAINER_CREATED.String())
+	// ErrNotSupported is used when containers are managed on a platform
+	// other than Linux, where SIF images cannot be mounted.
+	ErrNotSupported = fmt.Errorf("containers are not supported on this platform")
 )
 
 // Container represents kubernetes container inside a pod. It encapsulates
@@ -50,45 +68,180 @@ type Container struct {
 	*k8s.ContainerConfig
 	pod      *Pod
 	imgInfo  *image.Info
+	imgRef   string
 	baseDir  string
 	trashDir string
 
-	runtimeState runtime.State
-	ociState     *ociruntime.State
-	logPath      string
-	execEnvs     []string
-
-	isStopped bool
-	isRemoved bool
+	runtimeState  runtime.State
+	ociState      *ociruntime.State
+	logPath       string
+	logDriver     LogDriver
+	logsDisabled  bool
+	logMu         sync.Mutex // guards logForwarder and logFile
+	logForwarder  *logForwarder
+	logFile       *criFileWriter
+	logCounters   *logCounters
+	logBufferSize int
+	logOverflow   LogOverflow
+	logRotation   LogRotation
+	replaySize    int
+	execEnvs      []string
+	resolvConf    string
+	phases        phaseDurations
+	times         transitionTimes
+	startErr      string
+	warnings      warnings.Buffer
+
+	cpusetMu sync.Mutex
+	cpuset   CPUSet
+
+	resourcesMu sync.Mutex
+	resources   k8s.LinuxContainerResources
+
+	cgroupDirs     []string
+	ownCgroup      string
+	exitStats      *ExitStats
+	exitStatsTaken bool
+
+	isStopped   bool
+	isRemoved   bool
+	isReclaimed bool
+	isCompacted bool
 
 	isStdinClosed bool
 	stdin         io.WriteCloser
-
-	cli        *runtime.CLIClient
+	// stdinMu serializes input of attached clients.
+	stdinMu sync.Mutex
+	// attachMu protects ttyHub shared by clients attached to TTY.
+	attachMu sync.Mutex
+	ttyHub   *ttyHub
+
+	mountPolicy        *MountPolicy
+	selinuxRelabel     bool
+	processLabel       string
+	mountLabel         string
+	atomicMounts       map[int]string
+	tmpfsOptions       map[string][]string
+	hugepageLimits     []specs.LinuxHugepageLimit
+	allowedAnnotations []string
+	lowerDirs          *LowerDirs
+	overlayOptions     []string
+	writableLayerSize  int64
+	writableQuota      *fs.ProjectQuota
+	defaults           *ContainerDefaults
+	injectedEnv        []string
+	nvidia             *NvidiaFiles
+	ociHooks           *OCIHooks
+	minOOMScoreAdj     *int
+
+	cli        runtime.Engine
 	syncChan   <-chan runtime.State
 	syncCancel context.CancelFunc
+
+	// monitorPath is an executable containers are supervised by,
+	// see WithMonitor, monitored is set for containers created with one
+	monitorPath string
+	monitored   bool
+}
+
+// ContainerOption is a type representing functional option for Container.
+type ContainerOption func(c *Container)
+
+// WithMountPolicy sets policy host path mounts are checked against.
+// By default any host path may be mounted.
+func WithMountPolicy(policy *MountPolicy) ContainerOption {
+	return func(c *Container) {
+		c.mountPolicy = policy
+	}
+}
+
+// WithSELinuxRelabel sets whether host paths of mounts that request it
+// are relabeled with container mount label. By default they are.
+func WithSELinuxRelabel(enabled bool) ContainerOption {
+	return func(c *Container) {
+		c.selinuxRelabel = enabled
+	}
+}
+
+// WithNvidiaFiles makes containers that request NVIDIA GPU devices get
+// NVIDIA driver files found by n. By default only requested devices are added.
+func WithNvidiaFiles(n *NvidiaFiles) ContainerOption {
+	return func(c *Container) {
+		c.nvidia = n
+	}
+}
+
+// WithOCIHooks makes hooks matching container injected into its OCI spec.
+// By default container spec has no hooks.
+func WithOCIHooks(hooks *OCIHooks) ContainerOption {
+	return func(c *Container) {
+		c.ociHooks = hooks
+	}
+}
+
+// WithContainerAnnotations sets patterns of container annotations that are
+// copied into OCI spec in addition to Kubernetes and Singularity-CRI ones.
+func WithContainerAnnotations(allowed []string) ContainerOption {
+	return func(c *Container) {
+		c.allowedAnnotations = allowed
+	}
+}
+
+// WithLowerDirs makes container share read-only image root filesystem
+// with other containers of the same image. By default each container
+// mounts image on its own.
+func WithLowerDirs(lowerDirs *LowerDirs) ContainerOption {
+	return func(c *Container) {
+		c.lowerDirs = lowerDirs
+	}
+}
+
+// WithContainerEngine sets engine container is run with.
+// By default engine of container's pod is used.
+func WithContainerEngine(engine runtime.Engine) ContainerOption {
+	return func(c *Container) {
+		c.cli = engine
+	}
 }
 
 // NewContainer constructs Container instance. Container is thread safe to use.
-func NewContainer(config *k8s.ContainerConfig, pod *Pod, info *image.Info, trashDir string) *Container {
-	contID := rand.GenerateID(ContainerIDLen)
+func NewContainer(config *k8s.ContainerConfig, pod *Pod, info *image.Info, trashDir string, opts ...ContainerOption) *Container {
+	contID := rand.NewID()
 	var execEnvs []string
 	if info.OciConfig != nil {
-		execEnvs = info.OciConfig.Env
+		execEnvs = append(execEnvs, info.OciConfig.Env...)
 	}
 	// environments from config will override oci image values
 	for _, kv := range config.GetEnvs() {
 		execEnvs = append(execEnvs, fmt.Sprintf("%s=%s", kv.Key, kv.Value))
 	}
-	return &Container{
+	cont := &Container{
 		id:              contID,
 		ContainerConfig: config,
 		pod:             pod,
 		imgInfo:         info,
+		imgRef:          imageRef(config.GetImage().GetImage(), info),
 		cli:             runtime.NewCLIClient(),
 		trashDir:        trashDir,
 		execEnvs:        execEnvs,
+		selinuxRelabel:  true,
+		cpuset: CPUSet{
+			Cpus: config.GetLinux().GetResources().GetCpusetCpus(),
+			Mems: config.GetLinux().GetResources().GetCpusetMems(),
+		},
+	}
+	if res := config.GetLinux().GetResources(); res != nil {
+		cont.resources = *res
+	}
+	// containers are run with engine of their pod unless set otherwise
+	if pod != nil && pod.cli != nil {
+		cont.cli = pod.cli
+	}
+	for _, o := range opts {
+		o(cont)
 	}
+	cont.execEnvs = append(cont.execEnvs, cont.injectDefaultEnv()...)
+	return cont
 }
 
 // ID returns unique container ID.
@@ -101,6 +254,12 @@ func (c *Container) PodID() string {
 	return c.pod.id
 }
 
+// UserNamespace returns ID mappings of user namespace container is run in,
+// nil means container is run in the host user namespace.
+func (c *Container) UserNamespace() *UserNamespace {
+	return c.pod.UserNamespace()
+}
+
 // State returns current container state understood by k8s.
 func (c *Container) State() k8s.ContainerState {
 	switch c.runtimeState {
@@ -114,28 +273,28 @@ func (c *Container) State() k8s.ContainerState {
 	return k8s.ContainerState_CONTAINER_UNKNOWN
 }
 
-// CreatedAt returns pod creation time in Unix nano.
+// CreatedAt returns container creation time in Unix nano.
 func (c *Container) CreatedAt() int64 {
-	if c.ociState.CreatedAt == nil {
-		return 0
+	if c.times.createdAt != 0 {
+		return c.times.createdAt
 	}
-	return *c.ociState.CreatedAt
+	return derefTime(c.ociState.CreatedAt)
 }
 
 // StartedAt returns container start time in unix nano.
 func (c *Container) StartedAt() int64 {
-	if c.ociState.StartedAt == nil {
-		return 0
+	if c.times.createdAt != 0 {
+		return c.times.startedAt
 	}
-	return *c.ociState.StartedAt
+	return derefTime(c.ociState.StartedAt)
 }
 
 // FinishedAt returns container finish time in unix nano.
 func (c *Container) FinishedAt() int64 {
-	if c.ociState.FinishedAt == nil {
-		return 0
+	if c.times.createdAt != 0 {
+		return c.times.finishedAt
 	}
-	return *c.ociState.FinishedAt
+	return derefTime(c.ociState.FinishedAt)
 }
 
 // ExitCode returns container exit code.
@@ -146,18 +305,31 @@ func (c *Container) ExitCode() int32 {
 	return int32(*c.ociState.ExitCode)
 }
 
-// ExitDescription returns human readable message of why container has exited.
+// ExitDescription returns human readable message of why container has exited,
+// e.g. signal that killed it.
 func (c *Container) ExitDescription() string {
 	return c.ociState.ExitDesc
 }
 
+// Warnings returns recent distinct warnings about container.
+func (c *Container) Warnings() *warnings.Buffer {
+	return &c.warnings
+}
+
+// StartError returns error of the last failed attempt to start container.
+// Container which engine could not start its process stays created.
+func (c *Container) StartError() string {
+	return c.startErr
+}
+
 // StateReason returns brief string explaining why container is in its current state.
 // K8s requires us to return CamelCase here, but we will fallback to full description
 // in case of unknown container state.
 func (c *Container) StateReason() string {
 	const (
-		reasonCompleted = "Completed"
-		reasonError     = "Error"
+		reasonCompleted  = "Completed"
+		reasonError      = "Error"
+		reasonStartError = "StartError"
 	)
 
 	if c.runtimeState == runtime.StateRunning {
@@ -169,9 +341,16 @@ func (c *Container) StateReason() string {
 		if c.ExitCode() == 0 {
 			return reasonCompleted
 		}
+		if c.OOMKilled() {
+			return ReasonOOMKilled
+		}
 		return reasonError
 	}
 
+	if c.runtimeState == runtime.StateCreated && c.startErr != "" {
+		return reasonStartError
+	}
+
 	// fallback to the description as a last resort
 	return c.ociState.ExitDesc
 }
@@ -198,6 +377,44 @@ func (c *Container) ImageID() string {
 	return c.imgInfo.ID
 }
 
+// ImageRef returns digest reference of the container image resolved at
+// container creation. Image ID is returned for images without digests.
+func (c *Container) ImageRef() string {
+	return c.imgRef
+}
+
+// imageRef picks the digest reference that identifies the image container is
+// created from. Requested reference is used if it is a digest itself, otherwise
+// digest of the same repository is preferred.
+func imageRef(requested string, info *image.Info) string {
+	if info.Ref == nil || len(info.Ref.Digests()) == 0 {
+		return info.ID
+	}
+	// digests are kept unordered, sort them so that the choice is stable
+	digests := info.Ref.Digests()
+	sort.Strings(digests)
+	repo := requested
+	if parsed, err := reference.Parse(requested); err == nil {
+		requested, repo = parsed.Familiar(), parsed.FamiliarName()
+	}
+	for _, digest := range digests {
+		if digest == requested {
+			return digest
+		}
+	}
+	for _, digest := range digests {
+		if strings.HasPrefix(digest, repo+"@") {
+			return digest
+		}
+	}
+	return digests[0]
+}
+
+// Image returns info of the container base image.
+func (c *Container) Image() *image.Info {
+	return c.imgInfo
+}
+
 // Stdin returns write end of container's stdin, if any. If container
 // is created with StdinOnce set to true this call will return
 // nil after first attach to container finishes.
@@ -227,7 +444,7 @@ func (c *Container) CloseStdin() error {
 
 // Create creates container inside a pod from the image.
 // All files created (bundle, sync socket, etc) are located in baseDir.
-func (c *Container) Create(baseDir string) error {
+func (c *Container) Create(ctx context.Context, baseDir string) error {
 	var err error
 	defer func() {
 		if err != nil {
@@ -249,15 +466,29 @@ func (c *Container) Create(baseDir string) error {
 
 	c.baseDir = baseDir
 	err = c.validateConfig()
+	if _, ok := err.(*MountError); ok {
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("invalid container config: %v", err)
 	}
+	err = c.setupSELinux()
+	if _, ok := err.(*MountError); ok {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("could not setup SELinux: %v", err)
+	}
 	err = c.addLogDirectory()
 	if err != nil {
 		return fmt.Errorf("could not create log directory: %v", err)
 	}
 	c.imgInfo.Borrow(c.id)
-	err = c.spawnOCIContainer()
+	err = c.spawnOCIContainer(ctx)
+	switch err.(type) {
+	case *spec.ValidationError, *WorkingDirError:
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("could not spawn container: %v", err)
 	}
@@ -265,12 +496,25 @@ func (c *Container) Create(baseDir string) error {
 	if err != nil {
 		return fmt.Errorf("could not update container state: %v", err)
 	}
+	if err := c.placeUnifiedCgroup(); err != nil {
+		c.warnings.Logf(glog.WarningDepth, "Could not place container %s into its cgroup, limits are not applied: %v", c.id, err)
+	} else if unifiedMount != "" {
+		// set limits before container process is started
+		c.applyResources(c.GetLinux().GetResources())
+	}
 	c.pod.addContainer(c)
+	if err := c.pod.registerScope(c.id, c.Pid()); err != nil {
+		c.warnings.Logf(glog.WarningDepth, "Could not register container %s scope in systemd: %v", c.id, err)
+	}
+	if err := c.pod.placeMonitor(c.id, c.Pid()); err != nil {
+		glog.Warningf("Could not charge container %s monitor to pod cgroups: %v", c.id, err)
+	}
 	return nil
 }
 
-// Start starts created container.
-func (c *Container) Start() error {
+// Start starts created container. If ctx is done before container
+// is started, container is killed so that it never starts afterwards.
+func (c *Container) Start(ctx context.Context) error {
 	if err := c.UpdateState(); err != nil {
 		return fmt.Errorf("could not update container state: %v", err)
 	}
@@ -278,16 +522,36 @@ func (c *Container) Start() error {
 		return ErrContainerNotCreated
 	}
 	glog.V(3).Infof("Starting container %s", c.id)
-	if err := c.cli.Start(c.id); err != nil {
-		return fmt.Errorf("could not start container: %v", err)
+	start := time.Now()
+	err := c.cli.Start(ctx, c.id)
+	if err == nil {
+		err = c.expectState(ctx, runtime.StateRunning)
+		if err != nil && c.runtimeState == runtime.StateExited {
+			// process was started and exited right away, this is
+			// not a start failure, exit code is reported by status
+			glog.V(3).Infof("Container %s exited right after start", c.id)
+			err = nil
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		if err := c.kill(); err != nil {
+			glog.Errorf("Could not kill container after cancelled start: %v", err)
+		}
 	}
-	err := c.expectState(runtime.StateRunning)
 	if err != nil {
-		return err
+		c.startErr = err.Error()
+		return fmt.Errorf("could not start container: %v", err)
 	}
+	c.startErr = ""
+	c.phases.record(PhaseEngineStart, start)
+	start = time.Now()
 	if err := c.UpdateState(); err != nil {
 		return fmt.Errorf("could not update container state: %v", err)
 	}
+	if c.runtimeState == runtime.StateRunning {
+		c.applyResources(c.GetLinux().GetResources())
+	}
+	c.phases.record(PhasePostStart, start)
 	return nil
 }
 
@@ -302,7 +566,7 @@ func (c *Container) Stop(timeout int64) error {
 	if err := c.UpdateState(); err != nil {
 		return fmt.Errorf("could not update container state: %v", err)
 	}
-	if err := c.terminate(timeout); err != nil {
+	if err := c.terminate(c.stopTimeout(timeout)); err != nil {
 		return fmt.Errorf("could not terminate container process: %v", err)
 	}
 	if err := c.UpdateState(); err != nil {
@@ -319,6 +583,11 @@ func (c *Container) Remove() error {
 	if c.isRemoved {
 		return nil
 	}
+	if c.isReclaimed {
+		c.pod.removeContainer(c)
+		c.isRemoved = true
+		return nil
+	}
 	err := c.UpdateState()
 	if err != nil && err != runtime.ErrNotFound {
 		return fmt.Errorf("could not update container state: %v", err)
@@ -327,10 +596,12 @@ func (c *Container) Remove() error {
 		if err := c.kill(); err != nil {
 			return fmt.Errorf("could not kill container: %v", err)
 		}
+		c.snapshotExitStats()
 		if err := c.cli.Delete(c.id); err != nil && err != runtime.ErrNotFound {
 			return fmt.Errorf("could not delete container: %v", err)
 		}
 	}
+	c.pod.unregisterScope(c.id)
 	if err := c.CloseStdin(); err != nil {
 		glog.Errorf("Could not close container stdin: %v", err)
 	}
@@ -346,8 +617,55 @@ func (c *Container) Remove() error {
 	return nil
 }
 
+// Reclaim frees resources held by exited container, i.e. its bundle with
+// writable layer and runtime state, while keeping container status and logs
+// available until Remove is called. This is useful for previous attempts
+// of restarted containers. Reclaim does nothing if container is not exited.
+func (c *Container) Reclaim() error {
+	if c.isReclaimed || c.isRemoved {
+		return nil
+	}
+	if err := c.UpdateState(); err != nil {
+		return fmt.Errorf("could not update container state: %v", err)
+	}
+	if c.runtimeState != runtime.StateExited {
+		return nil
+	}
+
+	glog.V(3).Infof("Reclaiming resources of exited container %s", c.id)
+	if c.syncCancel != nil {
+		c.syncCancel()
+	}
+	c.stopLogForwarder()
+	if err := c.cli.Delete(c.id); err != nil && err != runtime.ErrNotFound {
+		return fmt.Errorf("could not delete container: %v", err)
+	}
+	// container state is cached from now on since runtime doesn't know it anymore
+	c.isReclaimed = true
+	if err := c.CloseStdin(); err != nil {
+		glog.Errorf("Could not close container stdin: %v", err)
+	}
+	if err := c.collectTrash(); err != nil {
+		glog.Errorf("Could not collect container trash: %v", err)
+	}
+	if err := c.cleanupFiles(false); err != nil {
+		return fmt.Errorf("could not cleanup container: %v", err)
+	}
+	c.imgInfo.Return(c.id)
+	return nil
+}
+
+// Reclaimed returns true if container resources were freed by Reclaim.
+func (c *Container) Reclaimed() bool {
+	return c.isReclaimed
+}
+
 // ExecSync runs passed command inside a container and returns result.
-func (c *Container) ExecSync(timeout time.Duration, cmd []string) (*k8s.ExecSyncResponse, error) {
+// Command is killed along with its children once timeout passes, in which
+// case context.DeadlineExceeded is returned. At most limit bytes of each
+// output stream are captured, zero limit means unlimited. When output is
+// truncated ExecSyncTruncatedMarker is appended to stderr.
+func (c *Container) ExecSync(timeout time.Duration, cmd []string, limit int) (*k8s.ExecSyncResponse, error) {
 	ctx := context.Background()
 	if timeout > 0 {
 		var cancel context.CancelFunc
@@ -355,13 +673,21 @@ func (c *Container) ExecSync(timeout time.Duration, cmd []string) (*k8s.ExecSync
 		defer cancel()
 	}
 
-	if c.imgInfo.Ref.URI() != singularity.DockerDomain || c.imgInfo.OciConfig == nil {
-		cmd = append([]string{singularity.ExecScript}, cmd...)
+	execCmd, err := c.prepareExec(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := runtime.RunSyncLimited(ctx, execCmd, limit)
+	if err == context.DeadlineExceeded {
+		return nil, err
 	}
-	resp, err := c.cli.ExecSync(ctx, c.id, cmd, c.execEnvs)
 	if err != nil {
 		return nil, fmt.Errorf("exec sync returned error: %v", err)
 	}
+	if resp.Truncated {
+		glog.V(2).Infof("Output of exec %v in container %s exceeded %d bytes and was truncated", cmd, c.id, limit)
+		resp.Stderr = append(resp.Stderr, ExecSyncTruncatedMarker...)
+	}
 
 	return &k8s.ExecSyncResponse{
 		Stdout:   resp.Stdout,
@@ -371,13 +697,16 @@ func (c *Container) ExecSync(timeout time.Duration, cmd []string) (*k8s.ExecSync
 }
 
 // Exec executes a command inside a container with attaching passed io streams to it.
+// Non-zero exit code of the command is reported with *runtime.ExitError.
 func (c *Container) Exec(cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
-	ctx := context.Background()
-
-	if c.imgInfo.Ref.URI() != singularity.DockerDomain || c.imgInfo.OciConfig == nil {
-		cmd = append([]string{singularity.ExecScript}, cmd...)
+	execCmd, err := c.prepareExec(context.Background(), cmd)
+	if err != nil {
+		return err
+	}
+	err = runtime.RunAttached(execCmd, stdin, stdout, stderr)
+	if _, ok := err.(*runtime.ExitError); ok {
+		return err
 	}
-	err := c.cli.Exec(ctx, c.id, stdin, stdout, stderr, cmd, c.execEnvs)
 	if err != nil {
 		return fmt.Errorf("exec returned error: %v", err)
 	}
@@ -387,17 +716,45 @@ func (c *Container) Exec(cmd []string, stdin io.Reader, stdout, stderr io.Writer
 
 // PrepareExec creates an instance of exec.Cmd that may be used
 // later to run a command inside an allocated tty.
-func (c *Container) PrepareExec(cmd []string) *exec.Cmd {
-	ctx := context.Background()
+func (c *Container) PrepareExec(cmd []string) (*exec.Cmd, error) {
+	return c.prepareExec(context.Background(), cmd)
+}
+
+// prepareExec creates an instance of exec.Cmd running passed command inside
+// a container. Commands prefixed with ReadonlyExecEnv marker see container
+// filesystem read-only, see readonlyExec.
+func (c *Container) prepareExec(ctx context.Context, cmd []string) (*exec.Cmd, error) {
+	cmd, readonly := parseReadonlyExec(cmd)
 	if c.imgInfo.Ref.URI() != singularity.DockerDomain || c.imgInfo.OciConfig == nil {
 		cmd = append([]string{singularity.ExecScript}, cmd...)
 	}
-	return c.cli.PrepareExec(ctx, c.id, cmd, c.execEnvs)
+	opts, err := c.execOptions()
+	if err != nil {
+		return nil, err
+	}
+	if readonly {
+		glog.Infof("Starting read-only exec session %v in container %s", cmd, c.id)
+		return readonlyExec(ctx, c.Pid(), c.rootfsPath(), cmd, c.execEnvs, opts...)
+	}
+	return c.cli.PrepareExec(ctx, c.id, cmd, c.execEnvs, opts...), nil
+}
+
+// execOptions returns options that make executed processes join container
+// cgroups. Engine takes care of namespaces, capabilities and seccomp.
+func (c *Container) execOptions() ([]runtime.ExecOption, error) {
+	procs, err := cgroupProcsFiles(c.Pid())
+	if err != nil {
+		return nil, fmt.Errorf("could not find container cgroups: %v", err)
+	}
+	return []runtime.ExecOption{runtime.WithCgroups(procs)}, nil
 }
 
 // ReopenLogFile reopens container log file.
 // This method is usually called when logs are rotated.
 func (c *Container) ReopenLogFile() error {
+	if ok, err := c.reopenLogFile(); ok {
+		return err
+	}
 	socket := c.ControlSocket()
 	if socket == "" {
 		return fmt.Errorf("container didn't provide control socket")
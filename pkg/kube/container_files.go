@@ -20,9 +20,14 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
-	ocibundle "github.com/sylabs/singularity/pkg/ocibundle/sif"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity-cri/pkg/spec"
 )
 
 const (
@@ -30,6 +35,9 @@ const (
 	contBundlePath    = "bundle/"
 	contRootfsPath    = "rootfs/"
 	contOCIConfigPath = "config.json"
+
+	contBaseDirPerm   = 0700
+	contOCIConfigPerm = 0600
 )
 
 // ociConfigPath returns path to container's config.json file.
@@ -37,6 +45,11 @@ func (c *Container) ociConfigPath() string {
 	return filepath.Join(c.baseDir, contBundlePath, contOCIConfigPath)
 }
 
+// Spec returns OCI runtime spec container was created with.
+func (c *Container) Spec() (*specs.Spec, error) {
+	return readSpec(c.ociConfigPath())
+}
+
 // rootfsPath returns path to container's rootfs directory.
 func (c *Container) rootfsPath() string {
 	return filepath.Join(c.baseDir, contBundlePath, contRootfsPath)
@@ -54,69 +67,167 @@ func (c *Container) bundlePath() string {
 
 // addLogDirectory creates a dedicated directory for container logs under pod's
 // log directory. If pod log directory is not specified, no container logs will be collected
-// even if container log path is not empty.
+// even if container log path is not empty. Neither are they when logs are disabled.
 func (c *Container) addLogDirectory() error {
+	if err := c.selectLogDriver(); err != nil {
+		return err
+	}
 	logDir := c.pod.GetLogDirectory()
 	logPath := c.GetLogPath()
-	if logDir == "" || logPath == "" {
+	if logDir == "" || logPath == "" || c.logsDisabled {
 		return nil
 	}
 
-	logPath = filepath.Join(logDir, logPath)
-	logDir = filepath.Dir(logPath)
-	glog.V(5).Infof("Creating log directory %s", logDir)
-	err := os.MkdirAll(logDir, 0755)
+	logPath, err := resolveLogPath(logDir, logPath)
 	if err != nil {
-		return fmt.Errorf("could not create %s: %v", logDir, err)
+		return fmt.Errorf("invalid log path: %v", err)
 	}
 	c.logPath = logPath
 	return nil
 }
 
+// resolveLogPath resolves container log path relative to the pod log directory
+// and creates all parent directories. Absolute log path is accepted as long as
+// it points inside log directory. Symlinks are followed, but resolved path must
+// not escape log directory.
+func resolveLogPath(logDir, logPath string) (string, error) {
+	origPath := logPath
+	root, err := filepath.EvalSymlinks(logDir)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve log directory: %v", err)
+	}
+
+	if filepath.IsAbs(logPath) {
+		rel, err := filepath.Rel(filepath.Clean(logDir), filepath.Clean(logPath))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			rel, err = filepath.Rel(root, filepath.Clean(logPath))
+		}
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return "", fmt.Errorf("%s is outside of %s", logPath, logDir)
+		}
+		logPath = rel
+	}
+	logPath = filepath.Join(root, logPath)
+	if !isWithin(root, logPath) || logPath == root {
+		return "", fmt.Errorf("%s escapes %s", origPath, logDir)
+	}
+
+	dir := filepath.Dir(logPath)
+	glog.V(5).Infof("Creating log directory %s", dir)
+	if err := os.MkdirAll(dir, podLogDirPerm); err != nil {
+		return "", fmt.Errorf("could not create %s: %v", dir, err)
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s: %v", dir, err)
+	}
+	if !isWithin(root, realDir) {
+		return "", fmt.Errorf("%s resolves outside of %s", dir, logDir)
+	}
+	return filepath.Join(realDir, filepath.Base(logPath)), nil
+}
+
+// isWithin checks whether path is root or is located under it.
+func isWithin(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
 func (c *Container) addOCIBundle() error {
+	// OCI config holds container environment which may contain secrets,
+	// so nothing under container base directory is accessible to other users
+	if err := os.MkdirAll(c.baseDir, contBaseDirPerm); err != nil {
+		return fmt.Errorf("could not create container directory: %v", err)
+	}
+	if err := os.Chmod(c.baseDir, contBaseDirPerm); err != nil {
+		return fmt.Errorf("could not restrict container directory: %v", err)
+	}
 	glog.V(5).Infof("Creating SIF bundle at %s", c.bundlePath())
-	d, err := ocibundle.FromSif(c.imgInfo.Path, c.bundlePath(), true)
-	if err != nil {
-		return fmt.Errorf("could not create SIF bundle driver: %v", err)
+	start := time.Now()
+	if runtime.IsFake(c.cli) {
+		// fake engine runs processes on the host, there is no image to mount
+		if err := os.MkdirAll(c.rootfsPath(), 0755); err != nil {
+			return fmt.Errorf("could not create rootfs directory: %v", err)
+		}
+	} else if c.lowerDirs != nil {
+		key, err := c.imageKey()
+		if err != nil {
+			return err
+		}
+		lowerDir, err := c.lowerDirs.Acquire(c.imgInfo.ID, c.imgInfo.Path, c.id, key)
+		wipe(key)
+		if err != nil {
+			return err
+		}
+		// pod annotations are validated on pod creation
+		volatile, _ := ParseDisposable(c.pod.GetAnnotations())
+		if err := c.prepareWritableLayer(); err != nil {
+			return err
+		}
+		c.overlayOptions, err = createOverlayBundle(lowerDir, c.bundlePath(), volatile)
+		if err != nil {
+			return err
+		}
+	} else if err := createBundle(c.imgInfo.Path, c.bundlePath()); err != nil {
+		return err
 	}
-	if err := d.Create(nil); err != nil {
-		return fmt.Errorf("could not create SIF bundle: %v", err)
+	if err := c.limitWritableLayer(); err != nil {
+		return err
+	}
+	c.phases.record(PhaseRootfs, start)
+
+	if err := c.addResolvConf(); err != nil {
+		return fmt.Errorf("could not create resolv.conf: %v", err)
 	}
 
 	glog.V(5).Infof("Generating OCI config for container %s", c.id)
+	start = time.Now()
+	defer c.phases.record(PhaseSpec, start)
 	ociSpec, err := translateContainer(c, c.pod)
 	if err != nil {
 		return fmt.Errorf("could not generate oci spec for container: %v", err)
 	}
-	config, err := os.OpenFile(c.ociConfigPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return fmt.Errorf("could not create OCI config file: %v", err)
+	if err := spec.Validate(ociSpec); err != nil {
+		return err
+	}
+	if err := c.addWorkingDir(ociSpec); err != nil {
+		return err
+	}
+	if err := c.addAtomicLinks(); err != nil {
+		return err
 	}
-	defer config.Close()
-	err = json.NewEncoder(config).Encode(ociSpec)
+	config, err := json.Marshal(ociSpec)
 	if err != nil {
 		return fmt.Errorf("could not encode OCI config into json: %v", err)
 	}
+	if err := fs.WriteFileAtomic(c.ociConfigPath(), config, contOCIConfigPerm); err != nil {
+		return fmt.Errorf("could not create OCI config file: %v", err)
+	}
 	return nil
 }
 
 func (c *Container) cleanupFiles(silent bool) error {
-	glog.V(5).Infof("Removing bundle at %s", c.bundlePath())
-	d, err := ocibundle.FromSif("", c.bundlePath(), true)
-	if err != nil {
-		if !silent {
-			return fmt.Errorf("could not create SIF bundle driver: %v", err)
+	c.stopLogForwarder()
+	c.stopMonitor()
+	c.removeUnifiedCgroup()
+	if !runtime.IsFake(c.cli) {
+		c.releaseWritableLayer()
+		glog.V(5).Infof("Removing bundle at %s", c.bundlePath())
+		deleteFunc := deleteBundle
+		if c.lowerDirs != nil {
+			deleteFunc = deleteOverlayBundle
 		}
-		glog.Errorf("Could not create SIF bundle driver: %v", err)
-	}
-	if err := d.Delete(); err != nil {
-		if !silent {
-			return fmt.Errorf("could not delete SIF bundle: %v", err)
+		if err := deleteFunc(c.bundlePath()); err != nil {
+			if !silent {
+				return err
+			}
+			glog.Errorf("Could not remove bundle: %v", err)
+		}
+		if c.lowerDirs != nil {
+			c.lowerDirs.Release(c.imgInfo.ID, c.id)
 		}
-		glog.Errorf("Could not delete SIF bundle: %v", err)
 	}
 	glog.V(5).Infof("Removing container base directory %s", c.baseDir)
-	err = os.RemoveAll(c.baseDir)
+	err := os.RemoveAll(c.baseDir)
 	if err != nil {
 		if !silent {
 			return fmt.Errorf("could not cleanup container: %v", err)
@@ -134,7 +245,7 @@ func (c *Container) collectTrash() error {
 		return nil
 	}
 	contTrashDir := filepath.Join(c.trashDir, c.PodID(), c.id)
-	err := os.MkdirAll(contTrashDir, 0755)
+	err := os.MkdirAll(contTrashDir, contBaseDirPerm)
 	if err != nil {
 		return fmt.Errorf("could not create trash directory: %v", err)
 	}
@@ -143,6 +254,12 @@ func (c *Container) collectTrash() error {
 	if err != nil {
 		return fmt.Errorf("could not save OCI config to trash directory: %v", err)
 	}
+	if c.exitStats != nil {
+		err = copyFile(c.exitStatsPath(), filepath.Join(contTrashDir, contExitStatsPath))
+		if err != nil {
+			return fmt.Errorf("could not save exit stats to trash directory: %v", err)
+		}
+	}
 
 	if c.logPath == "" {
 		return nil
@@ -155,7 +272,11 @@ func (c *Container) collectTrash() error {
 	}
 
 	dir := filepath.Dir(c.logPath)
-	if dir == c.pod.GetLogDirectory() {
+	podLogDir, err := filepath.EvalSymlinks(c.pod.GetLogDirectory())
+	if err != nil {
+		podLogDir = c.pod.GetLogDirectory()
+	}
+	if dir == podLogDir {
 		// container doesn't have its own log directory
 		// store a single file only
 		err := copyFile(c.logPath, filepath.Join(trashLogs, "1.log"))
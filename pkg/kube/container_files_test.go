@@ -0,0 +1,100 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveLogPath(t *testing.T) {
+	base, err := ioutil.TempDir("", "logs")
+	require.NoError(t, err)
+	defer os.RemoveAll(base)
+	base, err = filepath.EvalSymlinks(base)
+	require.NoError(t, err)
+
+	// pod log directory is a symlink to a separate log partition
+	realLogDir := filepath.Join(base, "partition", "pod")
+	require.NoError(t, os.MkdirAll(realLogDir, 0755))
+	logDir := filepath.Join(base, "pod")
+	require.NoError(t, os.Symlink(realLogDir, logDir))
+
+	outside := filepath.Join(base, "outside")
+	require.NoError(t, os.MkdirAll(outside, 0755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(realLogDir, "evil")))
+
+	tt := []struct {
+		name        string
+		logPath     string
+		expectPath  string
+		expectError bool
+	}{
+		{
+			name:       "relative file",
+			logPath:    "0.log",
+			expectPath: filepath.Join(realLogDir, "0.log"),
+		},
+		{
+			name:       "relative nested file",
+			logPath:    "busybox/0.log",
+			expectPath: filepath.Join(realLogDir, "busybox", "0.log"),
+		},
+		{
+			name:       "absolute path inside log directory",
+			logPath:    filepath.Join(logDir, "nginx", "1.log"),
+			expectPath: filepath.Join(realLogDir, "nginx", "1.log"),
+		},
+		{
+			name:       "absolute path inside resolved log directory",
+			logPath:    filepath.Join(realLogDir, "alpine", "1.log"),
+			expectPath: filepath.Join(realLogDir, "alpine", "1.log"),
+		},
+		{
+			name:        "absolute path outside log directory",
+			logPath:     filepath.Join(outside, "0.log"),
+			expectError: true,
+		},
+		{
+			name:        "relative escape",
+			logPath:     "../../outside/0.log",
+			expectError: true,
+		},
+		{
+			name:        "symlink escape",
+			logPath:     "evil/0.log",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			path, err := resolveLogPath(logDir, tc.logPath)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectPath, path)
+			fi, err := os.Stat(filepath.Dir(path))
+			require.NoError(t, err)
+			require.True(t, fi.IsDir())
+		})
+	}
+}
@@ -20,7 +20,7 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/opencontainers/runc/libcontainer/devices"
+	"github.com/golang/glog"
 	"github.com/opencontainers/runc/libcontainer/user"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/opencontainers/runtime-tools/generate"
@@ -29,6 +29,10 @@ import (
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
+// shmPath is where POSIX shared memory is mounted, host one
+// is bind mounted into containers sharing host IPC namespace.
+const shmPath = "/dev/shm"
+
 type containerTranslator struct {
 	cont *Container
 	pod  *Pod
@@ -64,9 +68,18 @@ func (t *containerTranslator) translate() (*specs.Spec, error) {
 	if err := t.configureProcess(); err != nil {
 		return nil, fmt.Errorf("could not configure container process: %v", err)
 	}
+	if err := t.configureNvidia(); err != nil {
+		return nil, fmt.Errorf("could not configure NVIDIA GPUs: %v", err)
+	}
 	t.configureNamespaces()
 	t.configureResources()
+	t.configureDefaults()
+	if err := t.configureNetClassID(); err != nil {
+		return nil, fmt.Errorf("could not configure net_cls class ID: %v", err)
+	}
 	t.configureAnnotations()
+	// hooks are matched against complete spec
+	t.cont.ociHooks.Inject(t.g.Config)
 	return t.g.Config, nil
 }
 
@@ -84,19 +97,48 @@ func (t *containerTranslator) configureMounts() error {
 	// default propagation set to rprivate for security reasons
 	t.g.SetLinuxRootPropagation(propagationRprivate)
 
-	if t.pod.GetDnsConfig() != nil {
+	if t.cont.resolvConf != "" {
 		t.g.AddMount(specs.Mount{
 			Destination: "/etc/resolv.conf",
-			Source:      t.pod.resolvConfFilePath(),
+			Source:      t.cont.resolvConf,
 			Options:     []string{"bind", "ro"},
 		})
 	}
-	t.g.SetHostname(t.pod.GetHostname())
+	// hostname can only be set in a private UTS namespace
+	hostname := t.pod.GetHostname()
+	if t.pod.hostUTS() {
+		hostname = ""
+	}
+	t.g.SetHostname(hostname)
+	if t.hostIPC() && !t.hasMount(shmPath) {
+		// share host IPC objects including POSIX shared memory
+		t.g.RemoveMount(shmPath)
+		t.g.AddMount(specs.Mount{
+			Destination: shmPath,
+			Type:        "bind",
+			Source:      shmPath,
+			Options:     []string{"rbind", "rw"},
+		})
+	}
 	t.g.AddMount(specs.Mount{
 		Destination: "/etc/hostname",
 		Source:      t.pod.hostnameFilePath(),
 		Options:     []string{"bind", "ro"},
 	})
+	if !t.hasMount("/etc/hosts") {
+		t.g.AddMount(specs.Mount{
+			Destination: "/etc/hosts",
+			Source:      t.pod.hostsFilePath(),
+			Options:     []string{"bind", "ro"},
+		})
+	}
+	if t.pod.timezone != nil && !t.hasMount(localtimePath) {
+		t.g.AddMount(specs.Mount{
+			Destination: localtimePath,
+			Source:      t.pod.timezone.Path,
+			Options:     []string{"bind", "ro"},
+		})
+	}
 
 	if !t.cont.GetLinux().GetSecurityContext().GetPrivileged() {
 		for _, maskedPath := range t.cont.GetLinux().GetSecurityContext().GetMaskedPaths() {
@@ -117,23 +159,28 @@ func (t *containerTranslator) configureMounts() error {
 		}
 	}
 
-	for _, mount := range t.cont.GetMounts() {
-		source, err := filepath.EvalSymlinks(mount.GetHostPath())
-		if err != nil {
-			if os.IsNotExist(err) {
-				source = mount.GetHostPath()
-				err = os.MkdirAll(source, 0755)
-				if err != nil {
-					return fmt.Errorf("could not create %s: %s", source, err)
-				}
-			} else {
-				return fmt.Errorf("invalid bind mount source: %v", err)
+	for i, mount := range t.cont.GetMounts() {
+		// mount source is already resolved at config validation
+		source := mount.GetHostPath()
+		dest := mount.GetContainerPath()
+		if source == "" {
+			t.addTmpfs(dest, mount.GetReadonly())
+			continue
+		}
+		if _, ok := t.cont.atomicMounts[i]; ok {
+			// whole directory is mounted and container path is linked into it
+			dest = atomicMountPath(i)
+		}
+		if _, err := os.Lstat(source); os.IsNotExist(err) {
+			err = os.MkdirAll(source, 0755)
+			if err != nil {
+				return fmt.Errorf("could not create %s: %s", source, err)
 			}
 		}
 
 		volume := specs.Mount{
 			Source:      source,
-			Destination: mount.GetContainerPath(),
+			Destination: dest,
 			Options:     []string{"rbind"},
 		}
 		if mount.GetReadonly() {
@@ -155,73 +202,107 @@ func (t *containerTranslator) configureMounts() error {
 		t.g.AddMount(volume)
 	}
 
+	for _, volume := range t.cont.Volumes() {
+		source, err := t.pod.ensureVolume(volume.Name)
+		if err != nil {
+			return err
+		}
+		mount := specs.Mount{
+			Source:      source,
+			Destination: volume.ContainerPath,
+			Options:     []string{"rbind", "nosuid", "nodev", propagationRprivate},
+		}
+		if volume.Readonly {
+			mount.Options = append(mount.Options, "ro")
+		}
+		t.g.AddMount(mount)
+	}
 	return nil
 }
 
+// addTmpfs adds tmpfs mount requested by a container mount with empty host
+// path. Options set with AnnotationTmpfsOptions override default ones.
+func (t *containerTranslator) addTmpfs(dest string, readonly bool) {
+	options, ok := t.cont.tmpfsOptions[dest]
+	if !ok {
+		options = tmpfsOptions("", defaultTmpfsMode)
+	}
+	if readonly {
+		options = append(append([]string(nil), options...), "ro")
+	}
+	t.g.AddMount(specs.Mount{
+		Type:        "tmpfs",
+		Source:      "tmpfs",
+		Destination: dest,
+		Options:     options,
+	})
+}
+
+// hasMount checks whether container config requests a mount with the
+// passed container path or it is a runtime-managed volume mount.
+func (t *containerTranslator) hasMount(path string) bool {
+	if t.cont.hasMount(path) {
+		return true
+	}
+	for _, volume := range t.cont.Volumes() {
+		if volume.ContainerPath == path {
+			return true
+		}
+	}
+	return false
+}
+
+// containerDevice is a device exposed to container along
+// with access permissions set in devices cgroup.
+type containerDevice struct {
+	specs.LinuxDevice
+	hostPath    string
+	permissions string
+}
+
 func (t *containerTranslator) configureDevices() error {
 	if t.cont.GetLinux().GetSecurityContext().GetPrivileged() {
-		hostDevices, err := devices.HostDevices()
+		hostDevices, err := hostDevices()
 		if err != nil {
 			return err
 		}
 		for _, hostDevice := range hostDevices {
-			t.g.AddDevice(specs.LinuxDevice{
-				Path:     hostDevice.Path,
-				Type:     string(hostDevice.Type),
-				Major:    hostDevice.Major,
-				Minor:    hostDevice.Minor,
-				FileMode: &hostDevice.FileMode,
-				UID:      &hostDevice.Uid,
-				GID:      &hostDevice.Gid,
-			})
+			t.g.AddDevice(hostDevice)
 		}
 		t.g.Config.Linux.Resources.Devices = []specs.LinuxDeviceCgroup{{Allow: true, Access: "rwm"}}
 		return nil
 	}
 
 	for _, dev := range t.cont.GetDevices() {
-		device, err := devices.DeviceFromPath(dev.GetHostPath(), dev.GetPermissions())
-		if err == devices.ErrNotADevice {
-			devs, err := devices.GetDevices(dev.GetHostPath())
-			if err != nil {
-				return fmt.Errorf("could not read devices in %s: %v", dev.GetHostPath(), err)
-			}
-
-			for _, device := range devs {
-				t.g.AddDevice(specs.LinuxDevice{
-					Path:     strings.Replace(device.Path, dev.GetHostPath(), dev.GetContainerPath(), 1),
-					Type:     string(device.Type),
-					Major:    device.Major,
-					Minor:    device.Minor,
-					FileMode: &device.FileMode,
-					UID:      &device.Uid,
-					GID:      &device.Gid,
+		devs, err := findDevices(dev.GetHostPath(), dev.GetContainerPath(), dev.GetPermissions())
+		if err != nil {
+			return err
+		}
+		for _, device := range devs {
+			major, minor := device.Major, device.Minor
+			if t.pod.UserNamespace() != nil {
+				// device nodes cannot be created in user namespace,
+				// host ones are bind mounted instead
+				t.g.AddMount(specs.Mount{
+					Source:      device.hostPath,
+					Destination: device.Path,
+					Type:        "bind",
+					Options:     []string{"bind", "nosuid", "noexec"},
 				})
-				t.g.AddLinuxResourcesDevice(true, string(device.Type), &device.Major, &device.Minor, device.Permissions)
+			} else {
+				t.g.AddDevice(device.LinuxDevice)
 			}
-			continue
-		}
-		if err != nil {
-			return fmt.Errorf("could not get device: %v", err)
+			t.g.AddLinuxResourcesDevice(true, device.Type, &major, &minor, device.permissions)
 		}
-
-		t.g.AddDevice(specs.LinuxDevice{
-			Path:     device.Path,
-			Type:     string(device.Type),
-			Major:    device.Major,
-			Minor:    device.Minor,
-			FileMode: &device.FileMode,
-			UID:      &device.Uid,
-			GID:      &device.Gid,
-		})
-		t.g.AddLinuxResourcesDevice(true, string(device.Type), &device.Major, &device.Minor, device.Permissions)
 	}
 	return nil
 }
 
 func (t *containerTranslator) configureNamespaces() {
 	t.g.ClearLinuxNamespaces()
-	t.g.AddOrReplaceLinuxNamespace(specs.UTSNamespace, t.pod.namespacePath(specs.UTSNamespace))
+	if !t.pod.hostUTS() {
+		t.g.AddOrReplaceLinuxNamespace(specs.UTSNamespace, t.pod.namespacePath(specs.UTSNamespace))
+	}
 	t.g.AddOrReplaceLinuxNamespace(specs.MountNamespace, "")
 
 	security := t.cont.GetLinux().GetSecurityContext()
@@ -252,13 +333,28 @@ func (t *containerTranslator) configureNamespaces() {
 			t.g.AddOrReplaceLinuxNamespace(string(specs.PIDNamespace), podNsPath)
 		}
 	}
+	// ID mappings are set up by pod, containers only join its user namespace
+	if podNsPath := t.pod.namespacePath(specs.UserNamespace); podNsPath != "" {
+		t.g.AddOrReplaceLinuxNamespace(string(specs.UserNamespace), podNsPath)
+	}
+}
+
+// hostIPC checks whether container shares IPC namespace with the host.
+func (t *containerTranslator) hostIPC() bool {
+	switch t.cont.GetLinux().GetSecurityContext().GetNamespaceOptions().GetIpc() {
+	case k8s.NamespaceMode_NODE:
+		return true
+	case k8s.NamespaceMode_POD:
+		return t.pod.hostIPC()
+	}
+	return false
 }
 
 func (t *containerTranslator) configureResources() {
 	res := t.cont.GetLinux().GetResources()
 	t.g.SetLinuxResourcesCPUMems(res.GetCpusetMems())
 	t.g.SetLinuxResourcesCPUCpus(res.GetCpusetCpus())
-	t.g.SetLinuxCgroupsPath(filepath.Join(t.pod.GetLinux().GetCgroupParent(), t.cont.id))
+	t.g.SetLinuxCgroupsPath(filepath.Join(t.pod.cgroupPath, t.pod.cgroupDriver.leaf(t.cont.id)))
 
 	if res.GetCpuPeriod() != 0 {
 		t.g.SetLinuxResourcesCPUPeriod(uint64(res.GetCpuPeriod()))
@@ -270,11 +366,30 @@ func (t *containerTranslator) configureResources() {
 		t.g.SetLinuxResourcesCPUShares(uint64(res.GetCpuShares()))
 	}
 	if res.GetOomScoreAdj() != 0 {
-		t.g.SetProcessOOMScoreAdj(int(res.GetOomScoreAdj()))
+		t.g.SetProcessOOMScoreAdj(int(t.cont.oomScoreAdj(res.GetOomScoreAdj())))
 	}
 	if res.GetMemoryLimitInBytes() != 0 {
 		t.g.SetLinuxResourcesMemoryLimit(res.GetMemoryLimitInBytes())
 	}
+	for _, limit := range t.cont.hugepageLimits {
+		t.g.AddLinuxResourcesHugepageLimit(limit.Pagesize, limit.Limit)
+	}
+	for _, mount := range hugepagesMounts(t.cont.hugepageLimits) {
+		t.g.AddMount(mount)
+	}
+}
+
+func (t *containerTranslator) configureNetClassID() error {
+	classID, err := t.cont.netClassID()
+	if err != nil || classID == 0 {
+		return err
+	}
+	if !netClsMounted() {
+		glog.Warningf("Ignoring net_cls class ID of container %s: net_cls controller is not mounted", t.cont.id)
+		return nil
+	}
+	t.g.SetLinuxResourcesNetworkClassID(classID)
+	return nil
 }
 
 func (t *containerTranslator) configureProcess() error {
@@ -288,9 +403,12 @@ func (t *containerTranslator) configureProcess() error {
 
 		// add image envs first and allow container config to override them
 		for _, env := range t.cont.imgInfo.OciConfig.Env {
-			// assuming VARNAME=VARVALUE format
-			parts := strings.Split(env, "=")
-			t.g.AddProcessEnv(parts[0], parts[1])
+			name, value, ok := splitEnv(env)
+			if !ok {
+				glog.Warningf("Ignoring malformed image environment variable %q", env)
+				continue
+			}
+			t.g.AddProcessEnv(name, value)
 		}
 
 		// fill cmd and args if they are not provided
@@ -322,27 +440,36 @@ func (t *containerTranslator) configureProcess() error {
 		}
 	}
 
+	// container that mounts its own /etc/localtime keeps the timezone of that file
+	if t.pod.timezone != nil && !t.hasMount(localtimePath) {
+		t.g.AddProcessEnv("TZ", t.pod.timezone.Name)
+	}
+	for _, name := range t.cont.InjectedEnv() {
+		t.g.AddProcessEnv(name, t.cont.defaults.Env[name])
+	}
 	for _, env := range t.cont.GetEnvs() {
 		t.g.AddProcessEnv(env.GetKey(), env.GetValue())
 	}
 	t.g.SetProcessCwd(cwd)
 	t.g.SetProcessTerminal(t.cont.GetTty())
-	t.g.SetProcessArgs(append(cmd, args...))
+	// cmd and args may come from image config shared by all containers
+	processArgs := make([]string, 0, len(cmd)+len(args))
+	processArgs = append(processArgs, cmd...)
+	t.g.SetProcessArgs(append(processArgs, args...))
 
 	security := t.cont.GetLinux().GetSecurityContext()
-	t.g.SetProcessNoNewPrivileges(security.GetNoNewPrivs())
+	// pod annotation is validated on pod creation
+	podNoNewPrivs, _ := ParseNoNewPrivs(t.pod.GetAnnotations())
+	t.g.SetProcessNoNewPrivileges(security.GetNoNewPrivs() || podNoNewPrivs)
 
-	if err := t.configureCapabilities(); err != nil {
-		return err
-	}
+	t.configureCapabilities()
 	if t.g.Config.Linux == nil {
 		t.g.Config.Linux = new(specs.Linux)
 	}
 	t.g.Config.Linux.Seccomp = seccomp.DefaultProfile(t.g.Config) // reload seccomp profile after capabilities setup
 	t.g.SetProcessApparmorProfile(security.GetApparmorProfile())
-	if err := setupSELinux(&t.g, security.GetSelinuxOptions()); err != nil {
-		return err
-	}
+	// labels are computed and mounts are relabeled at container creation
+	setSELinuxLabels(&t.g, t.cont.processLabel, t.cont.mountLabel)
 	if err := setupSeccomp(&t.g, security.GetSeccompProfilePath()); err != nil {
 		return err
 	}
@@ -352,52 +479,29 @@ func (t *containerTranslator) configureProcess() error {
 	return nil
 }
 
-func (t *containerTranslator) configureCapabilities() error {
-	security := t.cont.GetLinux().GetSecurityContext()
-	addCapabilities := security.GetCapabilities().GetAddCapabilities()
-	dropCapabilities := security.GetCapabilities().GetDropCapabilities()
-
-	for _, capb := range addCapabilities {
-		if err := t.g.AddProcessCapabilityEffective(capb); err != nil {
-			return fmt.Errorf("could not add effective capability: %v", err)
-		}
-		if err := t.g.AddProcessCapabilityAmbient(capb); err != nil {
-			return fmt.Errorf("could not add ambient capability: %v", err)
-		}
-		if err := t.g.AddProcessCapabilityBounding(capb); err != nil {
-			return fmt.Errorf("could not add bounding capability: %v", err)
-		}
-		if err := t.g.AddProcessCapabilityInheritable(capb); err != nil {
-			return fmt.Errorf("could not add inheritable capability: %v", err)
-		}
-		if err := t.g.AddProcessCapabilityPermitted(capb); err != nil {
-			return fmt.Errorf("could not add permitted capability: %v", err)
-		}
-	}
-	for _, capb := range dropCapabilities {
-		if err := t.g.DropProcessCapabilityEffective(capb); err != nil {
-			return fmt.Errorf("could not drop effective capability: %v", err)
-		}
-		if err := t.g.DropProcessCapabilityAmbient(capb); err != nil {
-			return fmt.Errorf("could not drop ambient capability: %v", err)
-		}
-		if err := t.g.DropProcessCapabilityBounding(capb); err != nil {
-			return fmt.Errorf("could not drop bounding capability: %v", err)
-		}
-		if err := t.g.DropProcessCapabilityInheritable(capb); err != nil {
-			return fmt.Errorf("could not drop inheritable capability: %v", err)
-		}
-		if err := t.g.DropProcessCapabilityPermitted(capb); err != nil {
-			return fmt.Errorf("could not drop permitted capability: %v", err)
-		}
-	}
-	return nil
+// configureCapabilities sets capabilities of container process instead of
+// engine defaults, see containerCapabilities. User must be configured already.
+func (t *containerTranslator) configureCapabilities() {
+	caps := t.cont.GetLinux().GetSecurityContext().GetCapabilities()
+	t.g.Config.Process.Capabilities = containerCapabilities(caps, t.g.Config.Process.User.UID)
 }
 
 func (t *containerTranslator) configureAnnotations() {
-	for k, v := range t.cont.GetAnnotations() {
+	for k, v := range filterAnnotations(t.cont.GetAnnotations(), t.cont.allowedAnnotations) {
 		t.g.AddAnnotation(k, v)
 	}
+	// image labels never override annotations set by container config
+	for k, v := range imageLabelAnnotations(t.cont.imgInfo.Labels) {
+		if _, ok := t.g.Config.Annotations[k]; !ok {
+			t.g.AddAnnotation(k, v)
+		}
+	}
+	t.g.AddAnnotation(AnnotationPodName, t.pod.GetMetadata().GetName())
+	t.g.AddAnnotation(AnnotationPodNamespace, t.pod.GetMetadata().GetNamespace())
+	t.g.AddAnnotation(AnnotationPodUID, t.pod.GetMetadata().GetUid())
+	t.g.AddAnnotation(AnnotationContainerName, t.cont.GetMetadata().GetName())
+	t.g.AddAnnotation(AnnotationSandboxID, t.pod.id)
+	t.g.AddAnnotation(AnnotationContainerType, containerTypeContainer)
 }
 
 func (t *containerTranslator) configureUser() error {
@@ -429,6 +533,11 @@ func (t *containerTranslator) configureUser() error {
 		return err
 	}
 
+	if userNs := t.pod.UserNamespace(); userNs != nil {
+		if err := checkMapped(userNs, uint32(containerUser.Uid), uint32(containerUser.Gid)); err != nil {
+			return err
+		}
+	}
 	t.g.SetProcessUID(uint32(containerUser.Uid))
 	t.g.SetProcessGID(uint32(containerUser.Gid))
 	for _, gid := range containerUser.Sgids {
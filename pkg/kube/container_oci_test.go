@@ -0,0 +1,156 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestContainerTranslator_HostNamespaces(t *testing.T) {
+	tt := []struct {
+		name           string
+		podOptions     *k8s.NamespaceOption
+		contOptions    *k8s.NamespaceOption
+		expectNs       []specs.LinuxNamespaceType
+		expectHostname string
+		expectShmBind  bool
+	}{
+		{
+			name:           "private namespaces",
+			podOptions:     &k8s.NamespaceOption{},
+			contOptions:    &k8s.NamespaceOption{},
+			expectNs:       []specs.LinuxNamespaceType{specs.UTSNamespace, specs.MountNamespace, specs.IPCNamespace, specs.NetworkNamespace},
+			expectHostname: "pod",
+		},
+		{
+			name:        "host network implies host UTS",
+			podOptions:  &k8s.NamespaceOption{Network: k8s.NamespaceMode_NODE},
+			contOptions: &k8s.NamespaceOption{Network: k8s.NamespaceMode_NODE},
+			expectNs:    []specs.LinuxNamespaceType{specs.MountNamespace, specs.IPCNamespace},
+		},
+		{
+			name:           "host IPC with private network",
+			podOptions:     &k8s.NamespaceOption{Ipc: k8s.NamespaceMode_NODE},
+			contOptions:    &k8s.NamespaceOption{Ipc: k8s.NamespaceMode_NODE},
+			expectNs:       []specs.LinuxNamespaceType{specs.UTSNamespace, specs.MountNamespace, specs.NetworkNamespace},
+			expectHostname: "pod",
+			expectShmBind:  true,
+		},
+		{
+			name:           "pod IPC of host IPC pod",
+			podOptions:     &k8s.NamespaceOption{Ipc: k8s.NamespaceMode_NODE},
+			contOptions:    &k8s.NamespaceOption{},
+			expectNs:       []specs.LinuxNamespaceType{specs.UTSNamespace, specs.MountNamespace, specs.NetworkNamespace},
+			expectHostname: "pod",
+			expectShmBind:  true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &Pod{
+				PodSandboxConfig: &k8s.PodSandboxConfig{
+					Hostname: "pod",
+					Linux: &k8s.LinuxPodSandboxConfig{
+						SecurityContext: &k8s.LinuxSandboxSecurityContext{NamespaceOptions: tc.podOptions},
+					},
+				},
+				baseDir: "/var/run/singularity/pods/test",
+			}
+			for _, ns := range []specs.LinuxNamespaceType{specs.UTSNamespace, specs.NetworkNamespace, specs.IPCNamespace} {
+				if ns == specs.UTSNamespace && pod.hostUTS() ||
+					ns == specs.NetworkNamespace && tc.podOptions.GetNetwork() == k8s.NamespaceMode_NODE ||
+					ns == specs.IPCNamespace && pod.hostIPC() {
+					continue
+				}
+				pod.namespaces = append(pod.namespaces, specs.LinuxNamespace{Type: ns})
+			}
+			cont := &Container{
+				ContainerConfig: &k8s.ContainerConfig{
+					Linux: &k8s.LinuxContainerConfig{
+						SecurityContext: &k8s.LinuxContainerSecurityContext{NamespaceOptions: tc.contOptions},
+					},
+				},
+				pod: pod,
+			}
+			require.NoError(t, ValidateNamespaces(cont.ContainerConfig, pod))
+
+			g, err := generate.New("linux")
+			require.NoError(t, err)
+			tr := containerTranslator{cont: cont, pod: pod, g: g}
+			require.NoError(t, tr.configureMounts())
+			tr.configureNamespaces()
+
+			var namespaces []specs.LinuxNamespaceType
+			for _, ns := range g.Config.Linux.Namespaces {
+				namespaces = append(namespaces, ns.Type)
+			}
+			require.Equal(t, tc.expectNs, namespaces)
+			require.Equal(t, tc.expectHostname, g.Config.Hostname)
+
+			var shm *specs.Mount
+			for i, m := range g.Config.Mounts {
+				if m.Destination == shmPath {
+					require.Nil(t, shm, "duplicate /dev/shm mount")
+					shm = &g.Config.Mounts[i]
+				}
+			}
+			require.NotNil(t, shm)
+			require.Equal(t, tc.expectShmBind, shm.Type == "bind")
+		})
+	}
+}
+
+func TestValidateNamespaces(t *testing.T) {
+	pod := &Pod{
+		PodSandboxConfig: &k8s.PodSandboxConfig{
+			Linux: &k8s.LinuxPodSandboxConfig{
+				SecurityContext: &k8s.LinuxSandboxSecurityContext{
+					NamespaceOptions: &k8s.NamespaceOption{Ipc: k8s.NamespaceMode_NODE},
+				},
+			},
+		},
+	}
+	config := &k8s.ContainerConfig{
+		Linux: &k8s.LinuxContainerConfig{
+			SecurityContext: &k8s.LinuxContainerSecurityContext{
+				NamespaceOptions: &k8s.NamespaceOption{Ipc: k8s.NamespaceMode_CONTAINER},
+			},
+		},
+	}
+	require.Error(t, ValidateNamespaces(config, pod))
+	require.NoError(t, ValidateNamespaces(config, &Pod{PodSandboxConfig: &k8s.PodSandboxConfig{}}))
+
+	podPID := func(mode k8s.NamespaceMode) *Pod {
+		return &Pod{
+			PodSandboxConfig: &k8s.PodSandboxConfig{
+				Linux: &k8s.LinuxPodSandboxConfig{
+					SecurityContext: &k8s.LinuxSandboxSecurityContext{
+						NamespaceOptions: &k8s.NamespaceOption{Pid: mode},
+					},
+				},
+			},
+		}
+	}
+	config.Linux.SecurityContext.NamespaceOptions = &k8s.NamespaceOption{Pid: k8s.NamespaceMode_POD}
+	require.NoError(t, ValidateNamespaces(config, podPID(k8s.NamespaceMode_POD)))
+	require.NoError(t, ValidateNamespaces(config, podPID(k8s.NamespaceMode_NODE)))
+	require.Error(t, ValidateNamespaces(config, podPID(k8s.NamespaceMode_CONTAINER)))
+}
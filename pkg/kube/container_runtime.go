@@ -17,53 +17,86 @@ package kube
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity-cri/pkg/spec"
+	"github.com/sylabs/singularity/pkg/ociruntime"
 )
 
-func (c *Container) spawnOCIContainer() error {
+func (c *Container) spawnOCIContainer(ctx context.Context) error {
 	err := c.addOCIBundle()
+	switch err.(type) {
+	case *spec.ValidationError, *WorkingDirError:
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("could not create oci bundle: %v", err)
 	}
 
+	c.monitored = c.monitorPath != ""
+	if err := c.startLogForwarder(); err != nil {
+		return err
+	}
+
 	syncCtx, cancel := context.WithCancel(context.Background())
 	c.syncCancel = cancel
 	c.syncChan, err = runtime.ObserveState(syncCtx, c.socketPath())
 	if err != nil {
 		return fmt.Errorf("could not listen for state changes: %v", err)
 	}
+	// monitored container reports its state via monitor
+	syncSocket := c.socketPath()
+	if c.monitored {
+		if err := c.startMonitor(); err != nil {
+			return err
+		}
+		syncSocket = filepath.Join(c.baseDir, contMonitorSocketPath)
+	}
 
 	glog.V(3).Infof("Creating container %s", c.id)
+	start := time.Now()
 	// Allocate PTY only if no TTY was explicitly requested by a user.
 	// TTY is a special case handled on runtime side via attach socket.
-	c.stdin, err = c.cli.Create(c.id, c.bundlePath(), c.GetStdin(), c.GetTty(),
-		"--sync-socket", c.socketPath(), "--log-path", c.logPath)
+	c.stdin, err = c.cli.Create(ctx, c.id, c.bundlePath(), c.GetStdin(), c.GetTty(),
+		"--sync-socket", syncSocket, "--log-path", c.engineLogPath())
 	if err != nil {
 		return fmt.Errorf("could not create container: %v", err)
 	}
 
-	if err := c.expectState(runtime.StateCreating); err != nil {
+	if err := c.expectState(ctx, runtime.StateCreating); err != nil {
 		return err
 	}
-	if err := c.expectState(runtime.StateCreated); err != nil {
+	if err := c.expectState(ctx, runtime.StateCreated); err != nil {
 		return err
 	}
-
+	c.phases.record(PhaseEngineCreate, start)
 	return nil
 }
 
 // UpdateState updates container state according to information
-// received from the runtime.
+// received from the runtime and records time of state transitions.
+// State of reclaimed containers is not known to the runtime, so the
+// last observed state is kept. The same applies to compacted containers
+// as their state is final.
 func (c *Container) UpdateState() error {
+	if c.isReclaimed || c.isCompacted {
+		return nil
+	}
 	var err error
 	c.ociState, err = c.cli.State(c.id)
 	if err != nil {
 		return fmt.Errorf("could not get container state: %v", err)
 	}
 	c.runtimeState = runtime.StatusToState(c.ociState.Status)
+	c.recordTransition()
+	if c.runtimeState == runtime.StateExited {
+		c.snapshotExitStats()
+	} else {
+		c.resolveCgroupDirs()
+	}
 	return nil
 }
 
@@ -72,20 +105,64 @@ func (c *Container) Pid() int {
 	return c.ociState.Pid
 }
 
-func (c *Container) expectState(expect runtime.State) error {
-	c.runtimeState = <-c.syncChan
+// EngineState returns a copy of container state last reported by the
+// engine, nil if the container was never created.
+func (c *Container) EngineState() *ociruntime.State {
+	if c.ociState == nil {
+		return nil
+	}
+	state := *c.ociState
+	return &state
+}
+
+// expectState waits for the next container state change until ctx
+// is done and checks it is the expected one.
+func (c *Container) expectState(ctx context.Context, expect runtime.State) error {
+	select {
+	case state := <-c.syncChan:
+		c.runtimeState = state
+	case <-ctx.Done():
+		return fmt.Errorf("could not wait for container state %v: %v", expect, ctx.Err())
+	}
 	if c.runtimeState != expect {
 		return fmt.Errorf("unexpected container state: %v", c.runtimeState)
 	}
 	return nil
 }
 
+// annotationTerminationGracePeriod is set by kubelet on containers
+// of pods that specify terminationGracePeriodSeconds.
+const annotationTerminationGracePeriod = "io.kubernetes.pod.terminationGracePeriod"
+
+// stopTimeout returns time in seconds container is given to stop gracefully
+// when stop is requested with the passed timeout. Precedence is as follows:
+//   - zero timeout means kill immediately, as CRI requires;
+//   - grace period set in pod spec always wins, kubelet marks containers
+//     of such pods with io.kubernetes.pod.terminationGracePeriod annotation;
+//   - otherwise, i.e. when pod spec omits grace period or timeout is
+//     negative, image StopTimeout is used if image sets one.
+func (c *Container) stopTimeout(timeout int64) int64 {
+	if timeout == 0 {
+		return 0
+	}
+	if _, ok := c.GetAnnotations()[annotationTerminationGracePeriod]; ok && timeout > 0 {
+		return timeout
+	}
+	if c.imgInfo != nil && c.imgInfo.StopTimeout > 0 {
+		glog.V(4).Infof("Using image stop timeout %ds for container %s", c.imgInfo.StopTimeout, c.id)
+		return c.imgInfo.StopTimeout
+	}
+	return timeout
+}
+
 func (c *Container) terminate(timeout int64) error {
 	// Call cancel to free any resources taken by context.
 	// We should call it when sync socket will no longer be used, and
 	// since multiple calls are fine with cancel func, call it at
 	// the end of terminate.
-	defer c.syncCancel()
+	if c.syncCancel != nil {
+		defer c.syncCancel()
+	}
 
 	if c.runtimeState == runtime.StateExited {
 		return nil
@@ -136,5 +213,5 @@ func (c *Container) kill() error {
 	if err != nil {
 		return fmt.Errorf("could not kill container: %v", err)
 	}
-	return c.expectState(runtime.StateExited)
+	return c.expectState(context.Background(), runtime.StateExited)
 }
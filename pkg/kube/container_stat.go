@@ -15,11 +15,13 @@
 package kube
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
-	"github.com/containerd/cgroups"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sylabs/singularity-cri/pkg/fs"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
@@ -29,49 +31,96 @@ import (
 type ContainerStat struct {
 	// Writable layer fs usage.
 	Fs *fs.UsageInfo
-	// Total memory used by container in bytes
+	// Memory working set in bytes, i.e. usage less inactive file cache.
 	Memory uint64
 	// Total CPU used in nanoseconds.
 	CPU uint64
+	// Timestamp is time usage was sampled at.
+	Timestamp time.Time
 }
 
-// Stat fetches information about container resources usage. This method
-// implies that cpuacct and memory cgroups controllers are mounted on host
-// at /sys/fs/cgroups/cpuacct and  /sys/fs/cgroups/memory respectively.
+// Stat fetches information about container resources usage. CPU and memory
+// are read from container cgroups directly, both cgroup v1 and v2
+// hierarchies are supported.
 func (c *Container) Stat() (*ContainerStat, error) {
-	fsInfo, err := fs.Usage(c.baseDir)
+	fsInfo, err := c.writableLayerUsage()
 	if err != nil {
 		return nil, fmt.Errorf("could not get fs usage: %v", err)
 	}
-	cgroup, err := cgroups.Load(cgroups.V1, cgroups.PidPath(c.Pid()))
-	if err != nil {
-		return nil, fmt.Errorf("could not load cgroups: %v", err)
-	}
-
-	metrics, err := cgroup.Stat(cgroups.IgnoreNotExist)
-	if err != nil {
-		return nil, fmt.Errorf("could not fetch metrics: %v", err)
-	}
-
-	var cpuTotal uint64
-	var memoryTotal uint64
-	if metrics.CPU != nil && metrics.CPU.Usage != nil {
-		cpuTotal = metrics.CPU.Usage.Total
+	dirs := c.cgroupDirs
+	if len(dirs) == 0 {
+		procs, err := cgroupProcsFiles(c.Pid())
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve cgroups: %v", err)
+		}
+		for _, p := range procs {
+			dirs = append(dirs, filepath.Dir(p))
+		}
 	}
-	if metrics.Memory != nil && metrics.Memory.Usage != nil {
-		memoryTotal = metrics.Memory.Usage.Usage
+	cpu, workingSet, ok := readCgroupUsage(dirs)
+	if !ok {
+		return nil, fmt.Errorf("no cgroup usage found in %v", dirs)
 	}
 
 	return &ContainerStat{
-		Fs:     fsInfo,
-		Memory: memoryTotal,
-		CPU:    cpuTotal,
+		Fs:        fsInfo,
+		Memory:    workingSet,
+		CPU:       cpu,
+		Timestamp: time.Now(),
 	}, nil
 }
 
+// writableLayerPath returns path to the directory container changes go to.
+// Both overlay and writable SIF bundles keep them in overlay upper directory;
+// if there is none, e.g. with fake engine, container base directory is used.
+func (c *Container) writableLayerPath() string {
+	upper := filepath.Join(c.bundlePath(), "overlay", "upper")
+	if _, err := os.Stat(upper); err == nil {
+		return upper
+	}
+	return c.baseDir
+}
+
+// readCgroupUsage reads total CPU time in nanoseconds and memory working set
+// in bytes from the passed cgroup directories, both v1 and v2 file names are
+// understood and the first value found wins. Working set is accounted the same
+// way kubelet does it, i.e. memory usage less inactive file cache.
+func readCgroupUsage(dirs []string) (cpu, workingSet uint64, ok bool) {
+	var cpuFound, memFound bool
+	for _, dir := range dirs {
+		if !cpuFound {
+			cpu, cpuFound = readCgroupValue(filepath.Join(dir, "cpuacct.usage"))
+		}
+		if !cpuFound {
+			var usec uint64
+			usec, cpuFound = readCgroupKey(filepath.Join(dir, "cpu.stat"), "usage_usec")
+			cpu = usec * uint64(time.Microsecond)
+		}
+		if memFound {
+			continue
+		}
+		usage, found := readCgroupValue(filepath.Join(dir, "memory.usage_in_bytes"))
+		inactiveKey := "total_inactive_file"
+		if !found {
+			usage, found = readCgroupValue(filepath.Join(dir, "memory.current"))
+			inactiveKey = "inactive_file"
+		}
+		if !found {
+			continue
+		}
+		memFound = true
+		inactive, _ := readCgroupKey(filepath.Join(dir, "memory.stat"), inactiveKey)
+		if usage > inactive {
+			workingSet = usage - inactive
+		}
+	}
+	return cpu, workingSet, cpuFound || memFound
+}
+
 // UpdateResources updates container resources according to the passed request.
 // This method implies that cpu, cpuset and memory cgroups controllers are mounted on host
-// at /sys/fs/cgroups/cpu, /sys/fs/cgroups/cpuset  and  /sys/fs/cgroups/memory respectively.
+// at /sys/fs/cgroups/cpu, /sys/fs/cgroups/cpuset  and  /sys/fs/cgroups/memory respectively,
+// unless host has cgroup v2 unified hierarchy only.
 func (c *Container) UpdateResources(upd *k8s.LinuxContainerResources) error {
 	var (
 		cpuPeriod   *uint64
@@ -79,8 +128,9 @@ func (c *Container) UpdateResources(upd *k8s.LinuxContainerResources) error {
 		cpuShares   *uint64
 		memoryLimit *int64
 	)
-	if upd.MemoryLimitInBytes != 0 {
-		memoryLimit = &upd.MemoryLimitInBytes
+	if upd.GetMemoryLimitInBytes() != 0 {
+		memoryLimit = new(int64)
+		*memoryLimit = upd.GetMemoryLimitInBytes()
 	}
 	if upd.GetCpuPeriod() != 0 {
 		cpuPeriod = new(uint64)
@@ -103,26 +153,131 @@ func (c *Container) UpdateResources(upd *k8s.LinuxContainerResources) error {
 			Shares: cpuShares,
 			Quota:  cpuQuota,
 			Period: cpuPeriod,
-			Cpus:   upd.CpusetCpus,
-			Mems:   upd.CpusetMems,
+			Cpus:   upd.GetCpusetCpus(),
+			Mems:   upd.GetCpusetMems(),
 		},
 	}
-	err := c.cli.UpdateContainerResources(c.id, req)
-	if err != nil {
-		return fmt.Errorf("could not update resources: %v", err)
+	// engine knows nothing about cgroup v2 only hosts, limits
+	// are written into container cgroup by applyResources then
+	if unifiedMount == "" {
+		err := c.cli.UpdateContainerResources(c.id, req)
+		if err != nil {
+			return fmt.Errorf("could not update resources: %v", err)
+		}
+	}
+	c.updateCPUSet(upd)
+	c.applyResources(upd)
+	if err := c.recordResources(upd); err != nil {
+		return err
 	}
 
-	if upd.OomScoreAdj != 0 {
-		oomAdj, err := os.OpenFile(fmt.Sprintf("/proc/%d/oom_adj", c.Pid()), os.O_WRONLY, 0644)
+	if upd.GetOomScoreAdj() != 0 {
+		oomAdj, err := os.OpenFile(fmt.Sprintf("/proc/%d/oom_score_adj", c.Pid()), os.O_WRONLY, 0644)
 		if err != nil {
-			return fmt.Errorf("could not open oom_adj for container: %v", err)
+			return fmt.Errorf("could not open oom_score_adj for container: %v", err)
 		}
 		defer oomAdj.Close()
 
-		_, err = oomAdj.WriteString(strconv.FormatInt(upd.OomScoreAdj, 32))
+		_, err = oomAdj.WriteString(strconv.FormatInt(c.oomScoreAdj(upd.GetOomScoreAdj()), 10))
 		if err != nil {
-			return fmt.Errorf("could not update oom_adj for container: %v", err)
+			return fmt.Errorf("could not update oom_score_adj for container: %v", err)
 		}
 	}
 	return nil
 }
+
+// Resources returns resources container currently runs with, i.e. requested
+// on creation with values set by UpdateResources since then.
+func (c *Container) Resources() *k8s.LinuxContainerResources {
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+	res := c.resources
+	return &res
+}
+
+// recordResources sets non-zero values of the update as container resources
+// and persists them in container OCI config, so that runtime spec reported
+// by verbose status shows ones container currently runs with.
+func (c *Container) recordResources(upd *k8s.LinuxContainerResources) error {
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+	res := &c.resources
+	if upd.GetCpuPeriod() != 0 {
+		res.CpuPeriod = upd.GetCpuPeriod()
+	}
+	if upd.GetCpuQuota() != 0 {
+		res.CpuQuota = upd.GetCpuQuota()
+	}
+	if upd.GetCpuShares() != 0 {
+		res.CpuShares = upd.GetCpuShares()
+	}
+	if upd.GetMemoryLimitInBytes() != 0 {
+		res.MemoryLimitInBytes = upd.GetMemoryLimitInBytes()
+	}
+	if upd.GetOomScoreAdj() != 0 {
+		res.OomScoreAdj = upd.GetOomScoreAdj()
+	}
+	if upd.GetCpusetCpus() != "" {
+		res.CpusetCpus = upd.GetCpusetCpus()
+	}
+	if upd.GetCpusetMems() != "" {
+		res.CpusetMems = upd.GetCpusetMems()
+	}
+
+	spec, err := c.Spec()
+	if err != nil {
+		return fmt.Errorf("could not read OCI config: %v", err)
+	}
+	setSpecResources(spec, res)
+	config, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("could not encode OCI config into json: %v", err)
+	}
+	if err := fs.WriteFileAtomic(c.ociConfigPath(), config, contOCIConfigPerm); err != nil {
+		return fmt.Errorf("could not update OCI config file: %v", err)
+	}
+	return nil
+}
+
+// setSpecResources sets cpu and memory limits of the spec to res ones.
+func setSpecResources(spec *specs.Spec, res *k8s.LinuxContainerResources) {
+	if spec.Linux == nil {
+		spec.Linux = new(specs.Linux)
+	}
+	if spec.Linux.Resources == nil {
+		spec.Linux.Resources = new(specs.LinuxResources)
+	}
+	resources := spec.Linux.Resources
+	if resources.CPU == nil {
+		resources.CPU = new(specs.LinuxCPU)
+	}
+	if res.CpuPeriod != 0 {
+		period := uint64(res.CpuPeriod)
+		resources.CPU.Period = &period
+	}
+	if res.CpuQuota != 0 {
+		quota := res.CpuQuota
+		resources.CPU.Quota = &quota
+	}
+	if res.CpuShares != 0 {
+		shares := uint64(res.CpuShares)
+		resources.CPU.Shares = &shares
+	}
+	if res.CpusetCpus != "" {
+		resources.CPU.Cpus = res.CpusetCpus
+	}
+	if res.CpusetMems != "" {
+		resources.CPU.Mems = res.CpusetMems
+	}
+	if res.MemoryLimitInBytes != 0 {
+		if resources.Memory == nil {
+			resources.Memory = new(specs.LinuxMemory)
+		}
+		limit := res.MemoryLimitInBytes
+		resources.Memory.Limit = &limit
+	}
+	if res.OomScoreAdj != 0 && spec.Process != nil {
+		adj := int(res.OomScoreAdj)
+		spec.Process.OOMScoreAdj = &adj
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestReadCgroupUsage(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(root)
+
+	v1Memory := filepath.Join(root, "v1", "memory")
+	writeCgroupFiles(t, v1Memory, map[string]string{
+		"memory.usage_in_bytes": "104857600\n",
+		"memory.stat":           "cache 8388608\ninactive_file 1\ntotal_inactive_file 4194304\n",
+	})
+	v1Cpuacct := filepath.Join(root, "v1", "cpuacct")
+	writeCgroupFiles(t, v1Cpuacct, map[string]string{
+		"cpuacct.usage": "1500000000\n",
+		"cpu.stat":      "nr_periods 0\nnr_throttled 0\nthrottled_time 0\n",
+	})
+	v2 := filepath.Join(root, "v2")
+	writeCgroupFiles(t, v2, map[string]string{
+		"memory.current": "52428800\n",
+		"memory.stat":    "anon 41943040\nfile 10485760\ninactive_file 2097152\n",
+		"cpu.stat":       "usage_usec 2500\nuser_usec 2000\nsystem_usec 500\n",
+	})
+	reclaimable := filepath.Join(root, "reclaimable")
+	writeCgroupFiles(t, reclaimable, map[string]string{
+		"memory.current": "4096\n",
+		"memory.stat":    "inactive_file 8192\n",
+	})
+	broken := filepath.Join(root, "broken")
+	writeCgroupFiles(t, broken, map[string]string{
+		"memory.current": "max\n",
+		"cpu.stat":       "usage_usec lots\n",
+	})
+
+	tt := []struct {
+		name             string
+		dirs             []string
+		expectCPU        uint64
+		expectWorkingSet uint64
+		expectOK         bool
+	}{
+		{
+			name:             "cgroup v1",
+			dirs:             []string{v1Memory, v1Cpuacct},
+			expectCPU:        1500000000,
+			expectWorkingSet: 100663296,
+			expectOK:         true,
+		},
+		{
+			name:             "cgroup v2",
+			dirs:             []string{v2},
+			expectCPU:        2500000,
+			expectWorkingSet: 50331648,
+			expectOK:         true,
+		},
+		{
+			name:             "hybrid prefers first found",
+			dirs:             []string{v1Cpuacct, v1Memory, v2},
+			expectCPU:        1500000000,
+			expectWorkingSet: 100663296,
+			expectOK:         true,
+		},
+		{
+			name:     "inactive cache exceeds usage",
+			dirs:     []string{reclaimable},
+			expectOK: true,
+		},
+		{
+			name: "no counters",
+			dirs: []string{broken, filepath.Join(root, "removed")},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, workingSet, ok := readCgroupUsage(tc.dirs)
+			require.Equal(t, tc.expectOK, ok)
+			require.Equal(t, tc.expectCPU, cpu)
+			require.Equal(t, tc.expectWorkingSet, workingSet)
+		})
+	}
+}
+
+func TestContainer_RecordResources(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(baseDir)
+
+	c := &Container{
+		baseDir: baseDir,
+		resources: k8s.LinuxContainerResources{
+			CpuShares:          512,
+			MemoryLimitInBytes: 1 << 20,
+			CpusetCpus:         "0-1",
+		},
+	}
+	shares := uint64(512)
+	limit := int64(1 << 20)
+	initial := &specs.Spec{
+		Process: &specs.Process{},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{
+				CPU: &specs.LinuxCPU{
+					Shares: &shares,
+					Cpus:   "0-1",
+				},
+				Memory: &specs.LinuxMemory{Limit: &limit},
+			},
+		},
+	}
+	config, err := json.Marshal(initial)
+	require.NoError(t, err, "could not encode spec")
+	require.NoError(t, os.MkdirAll(c.bundlePath(), 0700), "could not create bundle")
+	require.NoError(t, ioutil.WriteFile(c.ociConfigPath(), config, 0600), "could not write spec")
+
+	err = c.recordResources(&k8s.LinuxContainerResources{
+		CpuQuota:           50000,
+		CpuPeriod:          100000,
+		MemoryLimitInBytes: 1 << 30,
+		OomScoreAdj:        100,
+	})
+	require.NoError(t, err, "could not record resources")
+
+	expectRes := &k8s.LinuxContainerResources{
+		CpuPeriod:          100000,
+		CpuQuota:           50000,
+		CpuShares:          512,
+		MemoryLimitInBytes: 1 << 30,
+		OomScoreAdj:        100,
+		CpusetCpus:         "0-1",
+	}
+	require.Equal(t, expectRes, c.Resources())
+
+	spec, err := c.Spec()
+	require.NoError(t, err, "could not read spec")
+	cpu := spec.Linux.Resources.CPU
+	require.Equal(t, uint64(100000), *cpu.Period)
+	require.Equal(t, int64(50000), *cpu.Quota)
+	require.Equal(t, uint64(512), *cpu.Shares)
+	require.Equal(t, "0-1", cpu.Cpus)
+	require.Equal(t, int64(1<<30), *spec.Linux.Resources.Memory.Limit)
+	require.Equal(t, 100, *spec.Process.OOMScoreAdj)
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestImageRef(t *testing.T) {
+	const (
+		busyboxDigest = "busybox@sha256:7964ad52e396a6e045c39b5a44438424ac52e12e4d5a25d94895f2058cb863a0"
+		mirrorDigest  = "mirror.local/busybox@sha256:7964ad52e396a6e045c39b5a44438424ac52e12e4d5a25d94895f2058cb863a0"
+	)
+	ref, err := image.ParseRef("busybox:1.29")
+	require.NoError(t, err)
+	ref.AddDigests([]string{mirrorDigest, busyboxDigest})
+	withDigests := &image.Info{ID: "7964ad52e396", Ref: ref}
+
+	ref, err = image.ParseRef("local.sif")
+	require.NoError(t, err)
+	withoutDigests := &image.Info{ID: "3f1cbe2a0221", Ref: ref}
+
+	tt := []struct {
+		name      string
+		requested string
+		info      *image.Info
+		expect    string
+	}{
+		{
+			name:      "image without digests",
+			requested: "local.sif",
+			info:      withoutDigests,
+			expect:    "3f1cbe2a0221",
+		},
+		{
+			name:      "image without reference",
+			requested: "3f1cbe2a0221",
+			info:      &image.Info{ID: "3f1cbe2a0221"},
+			expect:    "3f1cbe2a0221",
+		},
+		{
+			name:      "requested digest",
+			requested: mirrorDigest,
+			info:      withDigests,
+			expect:    mirrorDigest,
+		},
+		{
+			name:      "digest of requested repository",
+			requested: "busybox:1.29",
+			info:      withDigests,
+			expect:    busyboxDigest,
+		},
+		{
+			name:      "requested by id",
+			requested: "sha256:7964ad52e396",
+			info:      withDigests,
+			expect:    busyboxDigest,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expect, imageRef(tc.requested, tc.info))
+		})
+	}
+}
+
+func TestContainer_StopTimeout(t *testing.T) {
+	podGrace := map[string]string{annotationTerminationGracePeriod: "30"}
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		imageStop   int64
+		timeout     int64
+		expect      int64
+	}{
+		{
+			name:      "zero timeout kills immediately",
+			imageStop: 60,
+			timeout:   0,
+			expect:    0,
+		},
+		{
+			name:        "pod grace period wins",
+			annotations: podGrace,
+			imageStop:   60,
+			timeout:     30,
+			expect:      30,
+		},
+		{
+			name:      "image timeout when pod omits grace period",
+			imageStop: 60,
+			timeout:   30,
+			expect:    60,
+		},
+		{
+			name:        "image timeout on negative timeout",
+			annotations: podGrace,
+			imageStop:   60,
+			timeout:     -1,
+			expect:      60,
+		},
+		{
+			name:    "no image timeout",
+			timeout: 10,
+			expect:  10,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Container{
+				ContainerConfig: &k8s.ContainerConfig{Annotations: tc.annotations},
+				imgInfo:         &image.Info{StopTimeout: tc.imageStop},
+			}
+			require.Equal(t, tc.expect, c.stopTimeout(tc.timeout))
+		})
+	}
+}
@@ -16,10 +16,12 @@ package kube
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 
 	"github.com/golang/glog"
 	"github.com/sylabs/singularity/pkg/util/capabilities"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
 const (
@@ -42,10 +44,10 @@ func (c *Container) validateConfig() error {
 	}
 
 	if aaProfile != "" {
-		if aaProfile == defaultAppArmorProfile {
-			aaProfile = "" // do not specify anything in that case
+		aaProfile, err := prepareAppArmorProfile(aaProfile)
+		if err != nil {
+			return fmt.Errorf("invalid AppArmor profile: %v", err)
 		}
-		aaProfile = strings.TrimPrefix(aaProfile, appArmorLocalhostPrefix)
 		glog.V(2).Infof("Setting AppArmor profile to %q for container %s", aaProfile, c.id)
 		security.ApparmorProfile = aaProfile
 	}
@@ -62,23 +64,141 @@ func (c *Container) validateConfig() error {
 		caps.AddCapabilities = prepareCapabilities(caps.AddCapabilities, nil)
 		caps.DropCapabilities = prepareCapabilities(caps.DropCapabilities, caps.AddCapabilities)
 	}
+	hugepageLimits, err := ParseHugepageLimits(c.GetAnnotations())
+	if err != nil {
+		return err
+	}
+	c.hugepageLimits = hugepageLimits
+	if err := c.validateDevices(); err != nil {
+		return err
+	}
+	if err := c.validateMounts(); err != nil {
+		return err
+	}
+	return c.validateVolumes()
+}
+
+// validateDevices checks requested devices and fills in defaults: devices
+// without container path are exposed at their host path and devices without
+// permissions are given full access.
+func (c *Container) validateDevices() error {
+	for _, dev := range c.GetDevices() {
+		if !filepath.IsAbs(dev.GetHostPath()) {
+			return fmt.Errorf("invalid device: host path %q is not absolute", dev.GetHostPath())
+		}
+		if dev.GetContainerPath() == "" {
+			dev.ContainerPath = filepath.Clean(dev.GetHostPath())
+		}
+		dest, err := cleanDestination(dev.GetContainerPath())
+		if err != nil {
+			return fmt.Errorf("invalid device: %v", err)
+		}
+		dev.ContainerPath = dest
+		permissions, err := devicePermissions(dev.GetPermissions())
+		if err != nil {
+			return fmt.Errorf("invalid device %s: %v", dev.GetHostPath(), err)
+		}
+		dev.Permissions = permissions
+	}
+	return nil
+}
+
+// devicePermissions checks permissions are a combination of
+// read (r), write (w) and mknod (m) access. Empty permissions
+// are treated as full access.
+func devicePermissions(permissions string) (string, error) {
+	if permissions == "" {
+		return "rwm", nil
+	}
+	seen := make(map[rune]bool)
+	for _, p := range permissions {
+		if !strings.ContainsRune("rwm", p) {
+			return "", fmt.Errorf("unknown permission %q in %q", p, permissions)
+		}
+		if seen[p] {
+			return "", fmt.Errorf("duplicate permission %q in %q", p, permissions)
+		}
+		seen[p] = true
+	}
+	return permissions, nil
+}
+
+// ValidateNamespaces checks container namespace options are compatible
+// with pod ones. Containers of a pod in host IPC namespace cannot have
+// private IPC namespace. Containers may share PID namespace only with
+// pods that have one, so that they never end up in host PID namespace.
+func ValidateNamespaces(config *k8s.ContainerConfig, pod *Pod) error {
+	nsOptions := config.GetLinux().GetSecurityContext().GetNamespaceOptions()
+	if pod.hostIPC() && nsOptions.GetIpc() == k8s.NamespaceMode_CONTAINER {
+		return fmt.Errorf("container cannot have private IPC namespace in pod with host IPC namespace")
+	}
+	if nsOptions.GetPid() == k8s.NamespaceMode_POD && pod.privatePID() {
+		return fmt.Errorf("container cannot share PID namespace with pod that has no shared PID namespace")
+	}
 	return nil
 }
 
-func prepareSeccompPath(scProfile string) (string, error) {
-	if scProfile == "" || scProfile == unconfinedSeccompProfile {
-		// empty profile equals to unconfined according to docs
-		return unconfinedSeccompProfile, nil
+// validateMounts resolves mount sources and checks them against mount
+// policy. Resolved and cleaned paths replace the requested ones so that
+// no symlink is followed when the mount is actually performed. Paths inside
+// kubelet atomic writer directories are replaced with the directory itself,
+// see atomicWriterSource. Host mounts of sources with propagation other than
+// private are checked to propagate mounts, see checkPropagation. Mounts with
+// empty host path become tmpfs mounts,
+// their options may be set with AnnotationTmpfsOptions.
+func (c *Container) validateMounts() error {
+	tmpfsOptions, err := ParseTmpfsOptions(c.GetAnnotations())
+	if err != nil {
+		return err
 	}
-	if scProfile == defaultSeccompProfile || scProfile == defaultDockerSeccompProfile {
-		// set runtime default profile - nothing in our case
-		return "", nil
+	tmpfs := make(map[string]bool)
+	privileged := c.GetLinux().GetSecurityContext().GetPrivileged()
+	for i, mount := range c.GetMounts() {
+		hostPath := mount.GetHostPath()
+		if hostPath == "" {
+			dest, err := cleanDestination(mount.GetContainerPath())
+			if err != nil {
+				return fmt.Errorf("invalid tmpfs mount: %v", err)
+			}
+			glog.V(4).Infof("Mounting tmpfs at %s for container %s", dest, c.id)
+			mount.ContainerPath = dest
+			tmpfs[dest] = true
+			continue
+		}
+		dir, rel, atomic := atomicWriterSource(hostPath)
+		if atomic {
+			hostPath = dir
+		}
+		source, err := c.mountPolicy.checkSource(hostPath, privileged)
+		if err != nil {
+			return err
+		}
+		if err := checkPropagation(source, mount.GetPropagation(), privileged); err != nil {
+			return err
+		}
+		dest, err := cleanDestination(mount.GetContainerPath())
+		if err != nil {
+			return fmt.Errorf("invalid mount: %v", err)
+		}
+		if atomic {
+			if c.atomicMounts == nil {
+				c.atomicMounts = make(map[int]string)
+			}
+			c.atomicMounts[i] = rel
+			glog.V(4).Infof("Mount %s is in atomic writer directory %s for container %s", mount.GetHostPath(), source, c.id)
+		} else {
+			glog.V(4).Infof("Resolved mount %s to %s for container %s", mount.GetHostPath(), source, c.id)
+		}
+		mount.HostPath = source
+		mount.ContainerPath = dest
 	}
-	if !strings.HasPrefix(scProfile, seccompLocalhostPrefix) {
-		return "", fmt.Errorf("custom profiles without %q prefix are not allowed", seccompLocalhostPrefix)
+	for dest := range tmpfsOptions {
+		if !tmpfs[dest] {
+			return fmt.Errorf("invalid %s annotation: %s is not a tmpfs mount", AnnotationTmpfsOptions, dest)
+		}
 	}
-	scProfile = strings.TrimPrefix(scProfile, seccompLocalhostPrefix)
-	return scProfile, nil
+	c.tmpfsOptions = tmpfsOptions
+	return nil
 }
 
 func prepareCapabilities(caps []string, excluded []string) []string {
@@ -91,6 +211,7 @@ func prepareCapabilities(caps []string, excluded []string) []string {
 		for _, exclude := range excluded {
 			if exclude == normalized[i] {
 				normalized = append(normalized[:i], normalized[i+1:]...)
+				break
 			}
 		}
 	}
@@ -0,0 +1,350 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// sysNodeDir and sysCPUDir point to sysfs directories describing node
+// topology, see sysfs-devices-system-cpu and sysfs-devices-node.
+var (
+	sysCPUDir  = "/sys/devices/system/cpu"
+	sysNodeDir = "/sys/devices/system/node"
+)
+
+// Topology describes online CPUs and NUMA nodes of the host.
+type Topology struct {
+	// CPUs are online CPUs in sorted order.
+	CPUs []int
+	// Nodes maps online NUMA node to CPUs it holds.
+	Nodes map[int][]int
+}
+
+// CPUSet is a cpuset a container is restricted to.
+type CPUSet struct {
+	// Cpus is a list of CPUs in cpuset.cpus format, e.g. 0-3,6.
+	Cpus string `json:"cpus,omitempty"`
+	// Mems is a list of NUMA nodes in cpuset.mems format.
+	Mems string `json:"mems,omitempty"`
+}
+
+// ReadTopology reads online CPUs and NUMA nodes from sysfs. Hosts without
+// NUMA support are reported as a single node holding all online CPUs.
+func ReadTopology() (*Topology, error) {
+	cpus, err := readCPUList(filepath.Join(sysCPUDir, "online"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read online CPUs: %v", err)
+	}
+	topology := &Topology{
+		CPUs:  cpus,
+		Nodes: make(map[int][]int),
+	}
+
+	nodes, err := readCPUList(filepath.Join(sysNodeDir, "online"))
+	if err != nil {
+		topology.Nodes[0] = cpus
+		return topology, nil
+	}
+	for _, node := range nodes {
+		nodeCPUs, err := readCPUList(filepath.Join(sysNodeDir, fmt.Sprintf("node%d", node), "cpulist"))
+		if err != nil {
+			return nil, fmt.Errorf("could not read NUMA node %d CPUs: %v", node, err)
+		}
+		topology.Nodes[node] = nodeCPUs
+	}
+	return topology, nil
+}
+
+// Validate checks that all CPUs and NUMA nodes of the cpuset are online.
+func (t *Topology) Validate(set CPUSet) error {
+	cpus, err := parseCPUList(set.Cpus)
+	if err != nil {
+		return fmt.Errorf("invalid cpuset cpus %q: %v", set.Cpus, err)
+	}
+	if invalid := missing(cpus, t.CPUs); len(invalid) != 0 {
+		return fmt.Errorf("cpuset cpus %q: CPUs %s are not online on the node (online: %s)",
+			set.Cpus, formatCPUList(invalid), formatCPUList(t.CPUs))
+	}
+
+	mems, err := parseCPUList(set.Mems)
+	if err != nil {
+		return fmt.Errorf("invalid cpuset mems %q: %v", set.Mems, err)
+	}
+	if invalid := missing(mems, t.nodes()); len(invalid) != 0 {
+		return fmt.Errorf("cpuset mems %q: NUMA nodes %s are not online on the node (online: %s)",
+			set.Mems, formatCPUList(invalid), formatCPUList(t.nodes()))
+	}
+	return nil
+}
+
+// NUMANodes returns NUMA nodes the cpuset spans. Those are nodes listed in
+// cpuset mems and nodes holding any of cpuset CPUs. Empty cpus or mems
+// are unrestricted and thus span all nodes.
+func (t *Topology) NUMANodes(set CPUSet) []int {
+	if set.Cpus == "" || set.Mems == "" {
+		return t.nodes()
+	}
+	cpus, _ := parseCPUList(set.Cpus)
+	mems, _ := parseCPUList(set.Mems)
+	spanned := make(map[int]bool)
+	for _, node := range mems {
+		spanned[node] = true
+	}
+	for node, nodeCPUs := range t.Nodes {
+		if len(missing(cpus, nodeCPUs)) != len(cpus) {
+			spanned[node] = true
+		}
+	}
+	nodes := make([]int, 0, len(spanned))
+	for node := range spanned {
+		nodes = append(nodes, node)
+	}
+	sort.Ints(nodes)
+	return nodes
+}
+
+// LocalMems returns NUMA nodes holding any of the passed CPUs in cpuset.mems
+// format. Memory allocated on those nodes is local to the CPUs.
+func (t *Topology) LocalMems(cpus string) string {
+	list, _ := parseCPUList(cpus)
+	var nodes []int
+	for _, node := range t.nodes() {
+		if len(missing(list, t.Nodes[node])) != len(list) {
+			nodes = append(nodes, node)
+		}
+	}
+	return formatCPUList(nodes)
+}
+
+func (t *Topology) nodes() []int {
+	nodes := make([]int, 0, len(t.Nodes))
+	for node := range t.Nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Ints(nodes)
+	return nodes
+}
+
+// ValidateCPUSet checks cpuset requested by the passed resources
+// against online CPUs and NUMA nodes of the host. Validation is skipped
+// when host topology cannot be read leaving the check to the engine.
+func ValidateCPUSet(res *k8s.LinuxContainerResources) error {
+	set := CPUSet{
+		Cpus: res.GetCpusetCpus(),
+		Mems: res.GetCpusetMems(),
+	}
+	if set.Cpus == "" && set.Mems == "" {
+		return nil
+	}
+	topology, err := ReadTopology()
+	if err != nil {
+		glog.Warningf("Skipping cpuset validation: could not read node topology: %v", err)
+		return nil
+	}
+	return topology.Validate(set)
+}
+
+// BindNUMAMemory restricts memory of pinned container to NUMA nodes local to
+// its CPUs. Cpuset mems of the passed resources are set to nodes holding
+// requested cpuset cpus unless mems are requested explicitly. Resources
+// are left unchanged when host topology cannot be read.
+func BindNUMAMemory(res *k8s.LinuxContainerResources) {
+	if res.GetCpusetCpus() == "" || res.GetCpusetMems() != "" {
+		return
+	}
+	topology, err := ReadTopology()
+	if err != nil {
+		glog.Warningf("Skipping NUMA memory binding: could not read node topology: %v", err)
+		return
+	}
+	res.CpusetMems = topology.LocalMems(res.CpusetCpus)
+	glog.V(4).Infof("Binding memory of CPUs %s to NUMA nodes %s", res.CpusetCpus, res.CpusetMems)
+}
+
+// readCPUList reads a file in cpuset list format, see cpuset(7).
+func readCPUList(path string) ([]int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCPUList(string(data))
+}
+
+// parseCPUList parses list format of cpuset(7), e.g. 0-3,8,10-11, into
+// sorted list of unique numbers.
+func parseCPUList(list string) ([]int, error) {
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return nil, nil
+	}
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid element %q", part)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		}
+		for i := first; i <= last; i++ {
+			seen[i] = true
+		}
+	}
+	nums := make([]int, 0, len(seen))
+	for i := range seen {
+		nums = append(nums, i)
+	}
+	sort.Ints(nums)
+	return nums, nil
+}
+
+// formatCPUList formats sorted numbers in cpuset(7) list format.
+func formatCPUList(nums []int) string {
+	var parts []string
+	for i := 0; i < len(nums); {
+		j := i
+		for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(nums[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", nums[i], nums[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// missing returns elements of nums that are not in the sorted set.
+func missing(nums, set []int) []int {
+	var res []int
+	for _, n := range nums {
+		i := sort.SearchInts(set, n)
+		if i == len(set) || set[i] != n {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// CPUSet returns cpuset container is currently restricted to. Empty
+// fields mean container is not restricted by its own cpuset.
+func (c *Container) CPUSet() CPUSet {
+	c.cpusetMu.Lock()
+	defer c.cpusetMu.Unlock()
+	return c.cpuset
+}
+
+// updateCPUSet records cpuset applied with resources update. Fields
+// that are not set in the update are left unchanged.
+func (c *Container) updateCPUSet(upd *k8s.LinuxContainerResources) {
+	c.cpusetMu.Lock()
+	defer c.cpusetMu.Unlock()
+	if upd.GetCpusetCpus() != "" {
+		c.cpuset.Cpus = upd.GetCpusetCpus()
+	}
+	if upd.GetCpusetMems() != "" {
+		c.cpuset.Mems = upd.GetCpusetMems()
+	}
+}
+
+// NUMAMemory returns memory used by container on each NUMA node in bytes
+// as reported by memory.numa_stat of container's memory cgroup.
+func (c *Container) NUMAMemory() (map[int]uint64, error) {
+	dir, err := cgroupV1Dir(c.Pid(), "memory")
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Open(filepath.Join(dir, "memory.numa_stat"))
+	if err != nil {
+		return nil, fmt.Errorf("could not open numa stat: %v", err)
+	}
+	defer stat.Close()
+	return parseNUMAStat(stat, uint64(os.Getpagesize()))
+}
+
+// parseNUMAStat reads per node memory usage from cgroup v1 memory.numa_stat,
+// where usage is reported in pages, e.g. total=128 N0=100 N1=28.
+// Hierarchical usage is preferred as it accounts for child cgroups, too.
+func parseNUMAStat(r io.Reader, pageSize uint64) (map[int]uint64, error) {
+	var usage map[int]uint64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		stat := strings.SplitN(fields[0], "=", 2)[0]
+		if stat != "hierarchical_total" && (stat != "total" || usage != nil) {
+			continue
+		}
+		usage = make(map[int]uint64)
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 || !strings.HasPrefix(kv[0], "N") {
+				return nil, fmt.Errorf("unexpected numa stat field %q", field)
+			}
+			node, err := strconv.Atoi(kv[0][1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid numa node %q: %v", kv[0], err)
+			}
+			pages, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid numa node %d usage: %v", node, err)
+			}
+			usage[node] = pages * pageSize
+		}
+		if stat == "hierarchical_total" {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if usage == nil {
+		return nil, fmt.Errorf("no total usage in numa stat")
+	}
+	return usage, nil
+}
+
+// Effective returns cpuset with unrestricted fields replaced
+// by all online CPUs and NUMA nodes respectively.
+func (t *Topology) Effective(set CPUSet) CPUSet {
+	if set.Cpus == "" {
+		set.Cpus = formatCPUList(t.CPUs)
+	}
+	if set.Mems == "" {
+		set.Mems = formatCPUList(t.nodes())
+	}
+	return set
+}
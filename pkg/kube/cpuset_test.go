@@ -0,0 +1,301 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tt := []struct {
+		name        string
+		list        string
+		expect      []int
+		expectError bool
+	}{
+		{
+			name: "empty",
+			list: "",
+		},
+		{
+			name:   "single",
+			list:   "3\n",
+			expect: []int{3},
+		},
+		{
+			name:   "ranges and duplicates",
+			list:   "0-2,5,1,7-8",
+			expect: []int{0, 1, 2, 5, 7, 8},
+		},
+		{
+			name:        "reversed range",
+			list:        "4-2",
+			expectError: true,
+		},
+		{
+			name:        "garbage",
+			list:        "0,a",
+			expectError: true,
+		},
+		{
+			name:        "negative",
+			list:        "-1",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cpus, err := parseCPUList(tc.list)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, cpus)
+			if len(cpus) != 0 {
+				again, err := parseCPUList(formatCPUList(cpus))
+				require.NoError(t, err)
+				require.Equal(t, cpus, again)
+			}
+		})
+	}
+}
+
+func TestFormatCPUList(t *testing.T) {
+	require.Equal(t, "", formatCPUList(nil))
+	require.Equal(t, "0-3,5,7-8", formatCPUList([]int{0, 1, 2, 3, 5, 7, 8}))
+}
+
+// writeTopology creates fake sysfs topology and points
+// topology readers to it. Returned function restores them.
+func writeTopology(t *testing.T, online string, nodes map[int]string) func() {
+	dir, err := ioutil.TempDir("", "topology-")
+	require.NoError(t, err)
+	cpuDir, nodeDir := sysCPUDir, sysNodeDir
+	sysCPUDir = filepath.Join(dir, "cpu")
+	sysNodeDir = filepath.Join(dir, "node")
+	cleanup := func() {
+		sysCPUDir, sysNodeDir = cpuDir, nodeDir
+		os.RemoveAll(dir)
+	}
+
+	require.NoError(t, os.MkdirAll(sysCPUDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sysCPUDir, "online"), []byte(online+"\n"), 0644))
+	if nodes == nil {
+		return cleanup
+	}
+	var ids []int
+	for id, cpus := range nodes {
+		ids = append(ids, id)
+		node := filepath.Join(sysNodeDir, fmt.Sprintf("node%d", id))
+		require.NoError(t, os.MkdirAll(node, 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(node, "cpulist"), []byte(cpus+"\n"), 0644))
+	}
+	nodeList := make([]string, 0, len(ids))
+	for _, id := range ids {
+		nodeList = append(nodeList, fmt.Sprint(id))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sysNodeDir, "online"), []byte(strings.Join(nodeList, ",")), 0644))
+	return cleanup
+}
+
+func TestTopology(t *testing.T) {
+	defer writeTopology(t, "0-7", map[int]string{0: "0-3", 1: "4-7"})()
+
+	topology, err := ReadTopology()
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7}, topology.CPUs)
+	require.Equal(t, map[int][]int{0: {0, 1, 2, 3}, 1: {4, 5, 6, 7}}, topology.Nodes)
+
+	tt := []struct {
+		name          string
+		set           CPUSet
+		expectError   string
+		expectNodes   []int
+		expectCurrent CPUSet
+	}{
+		{
+			name:          "unrestricted",
+			expectNodes:   []int{0, 1},
+			expectCurrent: CPUSet{Cpus: "0-7", Mems: "0-1"},
+		},
+		{
+			name:          "single node",
+			set:           CPUSet{Cpus: "1-2", Mems: "0"},
+			expectNodes:   []int{0},
+			expectCurrent: CPUSet{Cpus: "1-2", Mems: "0"},
+		},
+		{
+			name:          "cpus span nodes",
+			set:           CPUSet{Cpus: "3-4", Mems: "0"},
+			expectNodes:   []int{0, 1},
+			expectCurrent: CPUSet{Cpus: "3-4", Mems: "0"},
+		},
+		{
+			name:          "cpus only",
+			set:           CPUSet{Cpus: "5"},
+			expectNodes:   []int{0, 1},
+			expectCurrent: CPUSet{Cpus: "5", Mems: "0-1"},
+		},
+		{
+			name:        "offline cpus",
+			set:         CPUSet{Cpus: "6-9,12"},
+			expectError: "CPUs 8-9,12 are not online",
+		},
+		{
+			name:        "offline mems",
+			set:         CPUSet{Cpus: "0", Mems: "0,2"},
+			expectError: "NUMA nodes 2 are not online",
+		},
+		{
+			name:        "invalid cpus",
+			set:         CPUSet{Cpus: "0-"},
+			expectError: "invalid cpuset cpus",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := topology.Validate(tc.set)
+			if tc.expectError != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectNodes, topology.NUMANodes(tc.set))
+			require.Equal(t, tc.expectCurrent, topology.Effective(tc.set))
+		})
+	}
+}
+
+func TestTopology_LocalMems(t *testing.T) {
+	topology := &Topology{
+		CPUs:  []int{0, 1, 2, 3, 4, 5, 6, 7},
+		Nodes: map[int][]int{0: {0, 1, 2, 3}, 1: {4, 5, 6, 7}},
+	}
+	require.Equal(t, "0", topology.LocalMems("1-2"))
+	require.Equal(t, "1", topology.LocalMems("7"))
+	require.Equal(t, "0-1", topology.LocalMems("3-4"))
+	require.Equal(t, "", topology.LocalMems(""))
+}
+
+func TestBindNUMAMemory(t *testing.T) {
+	defer writeTopology(t, "0-7", map[int]string{0: "0-3", 1: "4-7"})()
+
+	tt := []struct {
+		name   string
+		res    *k8s.LinuxContainerResources
+		expect *k8s.LinuxContainerResources
+	}{
+		{
+			name: "nil resources",
+		},
+		{
+			name:   "not pinned",
+			res:    &k8s.LinuxContainerResources{CpuShares: 1024},
+			expect: &k8s.LinuxContainerResources{CpuShares: 1024},
+		},
+		{
+			name:   "pinned to single node",
+			res:    &k8s.LinuxContainerResources{CpusetCpus: "4-5"},
+			expect: &k8s.LinuxContainerResources{CpusetCpus: "4-5", CpusetMems: "1"},
+		},
+		{
+			name:   "pinned across nodes",
+			res:    &k8s.LinuxContainerResources{CpusetCpus: "2-5"},
+			expect: &k8s.LinuxContainerResources{CpusetCpus: "2-5", CpusetMems: "0-1"},
+		},
+		{
+			name:   "explicit mems",
+			res:    &k8s.LinuxContainerResources{CpusetCpus: "4-5", CpusetMems: "0"},
+			expect: &k8s.LinuxContainerResources{CpusetCpus: "4-5", CpusetMems: "0"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			BindNUMAMemory(tc.res)
+			require.Equal(t, tc.expect, tc.res)
+		})
+	}
+}
+
+func TestValidateCPUSet(t *testing.T) {
+	defer writeTopology(t, "0-3", nil)()
+
+	require.NoError(t, ValidateCPUSet(nil))
+	require.NoError(t, ValidateCPUSet(&k8s.LinuxContainerResources{CpusetCpus: "0-3", CpusetMems: "0"}))
+	err := ValidateCPUSet(&k8s.LinuxContainerResources{CpusetCpus: "4"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "CPUs 4 are not online")
+	err = ValidateCPUSet(&k8s.LinuxContainerResources{CpusetMems: "1"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "NUMA nodes 1 are not online")
+}
+
+func TestParseNUMAStat(t *testing.T) {
+	tt := []struct {
+		name        string
+		stat        string
+		expect      map[int]uint64
+		expectError bool
+	}{
+		{
+			name: "hierarchical",
+			stat: `total=30 N0=10 N1=20
+file=10 N0=5 N1=5
+hierarchical_total=40 N0=15 N1=25
+`,
+			expect: map[int]uint64{0: 15 * 4096, 1: 25 * 4096},
+		},
+		{
+			name:   "total only",
+			stat:   "total=3 N0=3\nanon=1 N0=1\n",
+			expect: map[int]uint64{0: 3 * 4096},
+		},
+		{
+			name:        "no total",
+			stat:        "anon=1 N0=1\n",
+			expectError: true,
+		},
+		{
+			name:        "malformed",
+			stat:        "total=1 X0=1\n",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			usage, err := parseNUMAStat(strings.NewReader(tc.stat), 4096)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, usage)
+		})
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// AnnotationSkipNodeDefaults is a pod annotation that, when set to true,
+// makes its containers ignore node-wide container defaults.
+const AnnotationSkipNodeDefaults = "singularity.cri/skip-node-defaults"
+
+// umaskShell is a shell container command is wrapped with to set umask,
+// since OCI spec has no means to set it.
+const umaskShell = "/bin/sh"
+
+// proxyEnvs are names of proxy variables that are not injected into
+// host network pods unless explicitly allowed.
+var proxyEnvs = map[string]bool{
+	"HTTP_PROXY":  true,
+	"HTTPS_PROXY": true,
+	"FTP_PROXY":   true,
+	"ALL_PROXY":   true,
+	"NO_PROXY":    true,
+}
+
+// rlimitNames maps ulimit names to resource names of OCI spec.
+var rlimitNames = map[string]string{
+	"as":         "RLIMIT_AS",
+	"core":       "RLIMIT_CORE",
+	"cpu":        "RLIMIT_CPU",
+	"data":       "RLIMIT_DATA",
+	"fsize":      "RLIMIT_FSIZE",
+	"locks":      "RLIMIT_LOCKS",
+	"memlock":    "RLIMIT_MEMLOCK",
+	"msgqueue":   "RLIMIT_MSGQUEUE",
+	"nice":       "RLIMIT_NICE",
+	"nofile":     "RLIMIT_NOFILE",
+	"nproc":      "RLIMIT_NPROC",
+	"rss":        "RLIMIT_RSS",
+	"rtprio":     "RLIMIT_RTPRIO",
+	"rttime":     "RLIMIT_RTTIME",
+	"sigpending": "RLIMIT_SIGPENDING",
+	"stack":      "RLIMIT_STACK",
+}
+
+// ContainerDefaults holds node-wide values applied to every container.
+// Values set by container config always take precedence.
+type ContainerDefaults struct {
+	// Umask is a umask of container process, nil leaves it untouched.
+	Umask *uint32
+	// Env holds environment variables injected into containers.
+	Env map[string]string
+	// Rlimits are process resource limits of containers.
+	Rlimits []specs.POSIXRlimit
+	// PidsLimit is a maximum number of processes in container cgroup,
+	// zero means no limit.
+	PidsLimit int64
+	// ProxyHostNetwork allows injecting proxy variables
+	// into pods in host network namespace.
+	ProxyHostNetwork bool
+}
+
+// WithContainerDefaults sets node-wide container defaults.
+// By default no defaults are applied.
+func WithContainerDefaults(defaults *ContainerDefaults) ContainerOption {
+	return func(c *Container) {
+		c.defaults = defaults
+	}
+}
+
+// ParseUmask parses umask in octal form, e.g. 0022.
+// Empty umask is valid and results in nil.
+func ParseUmask(umask string) (*uint32, error) {
+	if umask == "" {
+		return nil, nil
+	}
+	mask, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || mask > 0777 {
+		return nil, fmt.Errorf("invalid umask %q: expected octal number up to 0777", umask)
+	}
+	value := uint32(mask)
+	return &value, nil
+}
+
+// ParseRlimit parses ulimit in form of soft[:hard] where both values are
+// either numbers or unlimited. When hard limit is omitted it equals soft one.
+func ParseRlimit(name, limit string) (specs.POSIXRlimit, error) {
+	rType, ok := rlimitNames[strings.ToLower(name)]
+	if !ok {
+		return specs.POSIXRlimit{}, fmt.Errorf("unknown ulimit %q", name)
+	}
+	parts := strings.Split(limit, ":")
+	if len(parts) > 2 {
+		return specs.POSIXRlimit{}, fmt.Errorf("invalid %s ulimit %q: expected soft[:hard]", name, limit)
+	}
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		if part == "unlimited" {
+			values[i] = ^uint64(0)
+			continue
+		}
+		value, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return specs.POSIXRlimit{}, fmt.Errorf("invalid %s ulimit %q: expected soft[:hard]", name, limit)
+		}
+		values[i] = value
+	}
+	rlimit := specs.POSIXRlimit{Type: rType, Soft: values[0], Hard: values[len(values)-1]}
+	if rlimit.Soft > rlimit.Hard {
+		return specs.POSIXRlimit{}, fmt.Errorf("invalid %s ulimit %q: soft limit exceeds hard one", name, limit)
+	}
+	return rlimit, nil
+}
+
+// ParseSkipNodeDefaults checks pod annotation that disables node defaults.
+func ParseSkipNodeDefaults(annotations map[string]string) (bool, error) {
+	value, ok := annotations[AnnotationSkipNodeDefaults]
+	if !ok {
+		return false, nil
+	}
+	skip, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s annotation %q: expected boolean", AnnotationSkipNodeDefaults, value)
+	}
+	return skip, nil
+}
+
+// InjectedEnv returns names of environment variables set from node defaults.
+func (c *Container) InjectedEnv() []string {
+	return c.injectedEnv
+}
+
+// nodeDefaults returns defaults applicable to the container, nil when
+// there are none or pod opted out of them.
+func (c *Container) nodeDefaults() *ContainerDefaults {
+	if c.defaults == nil {
+		return nil
+	}
+	if skip, _ := ParseSkipNodeDefaults(c.pod.GetAnnotations()); skip {
+		return nil
+	}
+	return c.defaults
+}
+
+// injectDefaultEnv returns default environment variables in KEY=VALUE form
+// that are not set by container config and remembers their names.
+func (c *Container) injectDefaultEnv() []string {
+	defaults := c.nodeDefaults()
+	if defaults == nil {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, kv := range c.GetEnvs() {
+		set[kv.GetKey()] = true
+	}
+	hostNetwork := c.pod.GetLinux().GetSecurityContext().GetNamespaceOptions().GetNetwork() != k8s.NamespaceMode_POD
+
+	c.injectedEnv = nil
+	for name := range defaults.Env {
+		if set[name] {
+			continue
+		}
+		if hostNetwork && !defaults.ProxyHostNetwork && proxyEnvs[strings.ToUpper(name)] {
+			continue
+		}
+		c.injectedEnv = append(c.injectedEnv, name)
+	}
+	sort.Strings(c.injectedEnv)
+
+	envs := make([]string, 0, len(c.injectedEnv))
+	for _, name := range c.injectedEnv {
+		envs = append(envs, fmt.Sprintf("%s=%s", name, defaults.Env[name]))
+	}
+	return envs
+}
+
+// configureDefaults sets default rlimits, pids limit and umask. Umask is set by wrapping
+// container command with a shell, so it is skipped for images without one.
+func (t *containerTranslator) configureDefaults() {
+	defaults := t.cont.nodeDefaults()
+	if defaults == nil {
+		return
+	}
+	for _, rlimit := range defaults.Rlimits {
+		t.g.AddProcessRlimits(rlimit.Type, rlimit.Hard, rlimit.Soft)
+	}
+	if defaults.PidsLimit > 0 {
+		t.g.SetLinuxResourcesPidsLimit(defaults.PidsLimit)
+	}
+	if defaults.Umask == nil || t.g.Config.Process == nil {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(t.cont.rootfsPath(), umaskShell)); err != nil {
+		glog.Warningf("Ignoring default umask of container %s: no %s in image", t.cont.id, umaskShell)
+		return
+	}
+	script := fmt.Sprintf(`umask %04o && exec "$@"`, *defaults.Umask)
+	args := append([]string{umaskShell, "-c", script, "sh"}, t.g.Config.Process.Args...)
+	t.g.SetProcessArgs(args)
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestParseRlimit(t *testing.T) {
+	tt := []struct {
+		name         string
+		ulimit       string
+		limit        string
+		expectRlimit specs.POSIXRlimit
+		expectError  bool
+	}{
+		{
+			name:         "soft only",
+			ulimit:       "nofile",
+			limit:        "4096",
+			expectRlimit: specs.POSIXRlimit{Type: "RLIMIT_NOFILE", Soft: 4096, Hard: 4096},
+		},
+		{
+			name:         "soft and hard",
+			ulimit:       "NPROC",
+			limit:        "1024:unlimited",
+			expectRlimit: specs.POSIXRlimit{Type: "RLIMIT_NPROC", Soft: 1024, Hard: ^uint64(0)},
+		},
+		{
+			name:        "unknown",
+			ulimit:      "files",
+			limit:       "1024",
+			expectError: true,
+		},
+		{
+			name:        "soft above hard",
+			ulimit:      "core",
+			limit:       "unlimited:0",
+			expectError: true,
+		},
+		{
+			name:        "bad format",
+			ulimit:      "stack",
+			limit:       "1:2:3",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rlimit, err := ParseRlimit(tc.ulimit, tc.limit)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectRlimit, rlimit)
+		})
+	}
+}
+
+func TestParseUmask(t *testing.T) {
+	umask, err := ParseUmask("0027")
+	require.NoError(t, err)
+	require.Equal(t, uint32(027), *umask)
+
+	umask, err = ParseUmask("")
+	require.NoError(t, err)
+	require.Nil(t, umask)
+
+	_, err = ParseUmask("0999")
+	require.Error(t, err)
+	_, err = ParseUmask("01000")
+	require.Error(t, err)
+}
+
+func TestContainer_InjectDefaultEnv(t *testing.T) {
+	defaults := &ContainerDefaults{
+		Env: map[string]string{
+			"HTTP_PROXY": "http://proxy:3128",
+			"no_proxy":   "localhost",
+			"LANG":       "C.UTF-8",
+			"REGION":     "eu",
+		},
+	}
+	tt := []struct {
+		name           string
+		defaults       *ContainerDefaults
+		annotations    map[string]string
+		hostNetwork    bool
+		expectEnvs     []string
+		expectInjected []string
+	}{
+		{
+			name: "no defaults",
+		},
+		{
+			name:           "container wins",
+			defaults:       defaults,
+			expectEnvs:     []string{"HTTP_PROXY=http://proxy:3128", "LANG=C.UTF-8", "no_proxy=localhost"},
+			expectInjected: []string{"HTTP_PROXY", "LANG", "no_proxy"},
+		},
+		{
+			name:           "host network skips proxy",
+			defaults:       defaults,
+			hostNetwork:    true,
+			expectEnvs:     []string{"LANG=C.UTF-8"},
+			expectInjected: []string{"LANG"},
+		},
+		{
+			name: "host network proxy allowed",
+			defaults: &ContainerDefaults{
+				Env:              map[string]string{"HTTP_PROXY": "http://proxy:3128"},
+				ProxyHostNetwork: true,
+			},
+			hostNetwork:    true,
+			expectEnvs:     []string{"HTTP_PROXY=http://proxy:3128"},
+			expectInjected: []string{"HTTP_PROXY"},
+		},
+		{
+			name:        "pod opted out",
+			defaults:    defaults,
+			annotations: map[string]string{AnnotationSkipNodeDefaults: "true"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			network := k8s.NamespaceMode_POD
+			if tc.hostNetwork {
+				network = k8s.NamespaceMode_NODE
+			}
+			c := &Container{
+				ContainerConfig: &k8s.ContainerConfig{
+					Envs: []*k8s.KeyValue{{Key: "REGION", Value: "us"}},
+				},
+				pod: &Pod{
+					PodSandboxConfig: &k8s.PodSandboxConfig{
+						Annotations: tc.annotations,
+						Linux: &k8s.LinuxPodSandboxConfig{
+							SecurityContext: &k8s.LinuxSandboxSecurityContext{
+								NamespaceOptions: &k8s.NamespaceOption{Network: network},
+							},
+						},
+					},
+				},
+				defaults: tc.defaults,
+			}
+			require.Equal(t, tc.expectEnvs, c.injectDefaultEnv())
+			require.Equal(t, tc.expectInjected, c.InjectedEnv())
+		})
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/devices"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// hostDevices returns all devices found on host.
+func hostDevices() ([]specs.LinuxDevice, error) {
+	hostDevices, err := devices.HostDevices()
+	if err != nil {
+		return nil, err
+	}
+	devs := make([]specs.LinuxDevice, 0, len(hostDevices))
+	for _, hostDevice := range hostDevices {
+		devs = append(devs, linuxDevice(hostDevice, hostDevice.Path))
+	}
+	return devs, nil
+}
+
+// findDevices returns device found at hostPath. When hostPath is a directory
+// all devices in it are returned with their paths rebased onto containerPath.
+// Symlinks in hostPath are resolved so that e.g. /dev/disk/by-id entries can
+// be passed, devices are still exposed at containerPath.
+func findDevices(hostPath, containerPath, permissions string) ([]containerDevice, error) {
+	if containerPath == "" {
+		containerPath = hostPath
+	}
+	realPath, err := filepath.EvalSymlinks(hostPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve device path: %v", err)
+	}
+
+	device, err := devices.DeviceFromPath(realPath, permissions)
+	if err == devices.ErrNotADevice {
+		fi, err := os.Stat(realPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not stat %s: %v", hostPath, err)
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("%s is neither a device nor a directory", hostPath)
+		}
+		devs, err := devices.GetDevices(realPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read devices in %s: %v", hostPath, err)
+		}
+
+		found := make([]containerDevice, 0, len(devs))
+		for _, device := range devs {
+			rel, err := filepath.Rel(realPath, device.Path)
+			if err != nil {
+				return nil, fmt.Errorf("could not rebase device %s: %v", device.Path, err)
+			}
+			found = append(found, containerDevice{
+				LinuxDevice: linuxDevice(device, filepath.Join(containerPath, rel)),
+				hostPath:    device.Path,
+				permissions: permissions,
+			})
+		}
+		return found, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get device: %v", err)
+	}
+	return []containerDevice{{
+		LinuxDevice: linuxDevice(device, containerPath),
+		hostPath:    realPath,
+		permissions: device.Permissions,
+	}}, nil
+}
+
+func linuxDevice(device *configs.Device, path string) specs.LinuxDevice {
+	return specs.LinuxDevice{
+		Path:     path,
+		Type:     string(device.Type),
+		Major:    device.Major,
+		Minor:    device.Minor,
+		FileMode: &device.FileMode,
+		UID:      &device.Uid,
+		GID:      &device.Gid,
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestValidateDevices(t *testing.T) {
+	tt := []struct {
+		name        string
+		device      *k8s.Device
+		expect      *k8s.Device
+		expectError bool
+	}{
+		{
+			name:   "defaults",
+			device: &k8s.Device{HostPath: "/dev/fuse"},
+			expect: &k8s.Device{HostPath: "/dev/fuse", ContainerPath: "/dev/fuse", Permissions: "rwm"},
+		},
+		{
+			name:   "explicit",
+			device: &k8s.Device{HostPath: "/dev/infiniband", ContainerPath: "/dev/ib/", Permissions: "rw"},
+			expect: &k8s.Device{HostPath: "/dev/infiniband", ContainerPath: "/dev/ib", Permissions: "rw"},
+		},
+		{
+			name:        "relative host path",
+			device:      &k8s.Device{HostPath: "dev/fuse"},
+			expectError: true,
+		},
+		{
+			name:        "escaping container path",
+			device:      &k8s.Device{HostPath: "/dev/fuse", ContainerPath: "/dev/../etc/fuse"},
+			expectError: true,
+		},
+		{
+			name:        "unknown permission",
+			device:      &k8s.Device{HostPath: "/dev/fuse", Permissions: "rx"},
+			expectError: true,
+		},
+		{
+			name:        "duplicate permission",
+			device:      &k8s.Device{HostPath: "/dev/fuse", Permissions: "rr"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Container{ContainerConfig: &k8s.ContainerConfig{Devices: []*k8s.Device{tc.device}}}
+			err := c.validateDevices()
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, tc.device)
+		})
+	}
+}
+
+func TestFindDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devices-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	link := filepath.Join(dir, "null")
+	require.NoError(t, os.Symlink("/dev/null", link))
+	devs, err := findDevices(link, "/dev/custom", "rw")
+	require.NoError(t, err)
+	require.Len(t, devs, 1)
+	require.Equal(t, "/dev/custom", devs[0].Path, "device must be exposed at container path")
+	require.Equal(t, "/dev/null", devs[0].hostPath, "symlink must be resolved")
+	require.Equal(t, "c", devs[0].Type)
+	require.Equal(t, "rw", devs[0].permissions)
+
+	devs, err = findDevices("/dev/null", "", "r")
+	require.NoError(t, err)
+	require.Len(t, devs, 1)
+	require.Equal(t, "/dev/null", devs[0].Path)
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(file, nil, 0644))
+	_, err = findDevices(file, "", "rwm")
+	require.Error(t, err, "regular file is not a device")
+
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, os.Mkdir(empty, 0755))
+	devs, err = findDevices(empty, "/dev/empty", "rwm")
+	require.NoError(t, err)
+	require.Empty(t, devs)
+}
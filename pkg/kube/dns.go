@@ -0,0 +1,136 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// AnnotationDNSServers is a container annotation that holds comma-separated
+	// list of name servers container uses instead of the pod ones.
+	AnnotationDNSServers = "singularity.cri/dns-servers"
+	// AnnotationDNSSearch is a container annotation that holds comma-separated
+	// list of search domains container uses instead of the pod ones.
+	AnnotationDNSSearch = "singularity.cri/dns-search"
+	// AnnotationDNSOptions is a container annotation that holds comma-separated
+	// list of resolver options container uses instead of the pod ones.
+	AnnotationDNSOptions = "singularity.cri/dns-options"
+
+	// maxDNSServers and maxDNSSearches mirror glibc resolver limits.
+	maxDNSServers  = 3
+	maxDNSSearches = 6
+
+	contResolvConfPath = "resolv.conf"
+)
+
+// ParseContainerDNS parses container DNS override from the passed annotations.
+// Settings without annotation are inherited from pod DNS config. When no
+// DNS annotation is set nil is returned and container uses pod resolv.conf.
+func ParseContainerDNS(annotations map[string]string, pod *k8s.DNSConfig) (*k8s.DNSConfig, error) {
+	servers, hasServers := splitAnnotation(annotations, AnnotationDNSServers)
+	searches, hasSearches := splitAnnotation(annotations, AnnotationDNSSearch)
+	options, hasOptions := splitAnnotation(annotations, AnnotationDNSOptions)
+	if !hasServers && !hasSearches && !hasOptions {
+		return nil, nil
+	}
+
+	config := &k8s.DNSConfig{
+		Servers:  pod.GetServers(),
+		Searches: pod.GetSearches(),
+		Options:  pod.GetOptions(),
+	}
+	if hasServers {
+		for _, server := range servers {
+			if !isNameServer(server) {
+				return nil, fmt.Errorf("invalid %s annotation: bad name server %q", AnnotationDNSServers, server)
+			}
+		}
+		config.Servers = servers
+	}
+	if hasSearches {
+		for _, search := range searches {
+			if strings.ContainsAny(search, " \t") {
+				return nil, fmt.Errorf("invalid %s annotation: bad search domain %q", AnnotationDNSSearch, search)
+			}
+		}
+		config.Searches = searches
+	}
+	if hasOptions {
+		config.Options = options
+	}
+	if len(config.Servers) > maxDNSServers {
+		return nil, fmt.Errorf("invalid %s annotation: at most %d name servers are allowed", AnnotationDNSServers, maxDNSServers)
+	}
+	if len(config.Searches) > maxDNSSearches {
+		return nil, fmt.Errorf("invalid %s annotation: at most %d search domains are allowed", AnnotationDNSSearch, maxDNSSearches)
+	}
+	return config, nil
+}
+
+// isNameServer checks server is an IP address resolver accepts. IPv6
+// link-local name servers may be scoped to an interface, e.g. fe80::1%eth0.
+func isNameServer(server string) bool {
+	if i := strings.Index(server, "%"); i != -1 && strings.Contains(server, ":") {
+		server = server[:i]
+	}
+	return net.ParseIP(server) != nil
+}
+
+// splitAnnotation splits comma-separated annotation value skipping empty elements.
+func splitAnnotation(annotations map[string]string, key string) ([]string, bool) {
+	value, ok := annotations[key]
+	if !ok {
+		return nil, false
+	}
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values, true
+}
+
+// resolvConfFilePath returns path to container's own resolv.conf file.
+func (c *Container) resolvConfFilePath() string {
+	return filepath.Join(c.baseDir, contResolvConfPath)
+}
+
+// addResolvConf generates container resolv.conf when DNS override is
+// requested, otherwise container uses pod resolv.conf, if any. Generated
+// file is removed together with container base directory.
+func (c *Container) addResolvConf() error {
+	config, err := ParseContainerDNS(c.GetAnnotations(), c.pod.GetDnsConfig())
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		if c.pod.hasResolvConf() {
+			c.resolvConf = c.pod.resolvConfFilePath()
+		}
+		return nil
+	}
+	if err := writeResolvConf(c.resolvConfFilePath(), config); err != nil {
+		return err
+	}
+	c.resolvConf = c.resolvConfFilePath()
+	return nil
+}
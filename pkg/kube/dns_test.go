@@ -0,0 +1,203 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestParseContainerDNS(t *testing.T) {
+	pod := &k8s.DNSConfig{
+		Servers:  []string{"10.96.0.10"},
+		Searches: []string{"default.svc.cluster.local", "svc.cluster.local"},
+		Options:  []string{"ndots:5"},
+	}
+	tt := []struct {
+		name         string
+		annotations  map[string]string
+		expectConfig *k8s.DNSConfig
+		expectError  bool
+	}{
+		{
+			name: "no override",
+		},
+		{
+			name:        "servers only",
+			annotations: map[string]string{AnnotationDNSServers: "127.0.0.1, ::1"},
+			expectConfig: &k8s.DNSConfig{
+				Servers:  []string{"127.0.0.1", "::1"},
+				Searches: pod.Searches,
+				Options:  pod.Options,
+			},
+		},
+		{
+			name: "all set",
+			annotations: map[string]string{
+				AnnotationDNSServers: "1.1.1.1",
+				AnnotationDNSSearch:  "example.com",
+				AnnotationDNSOptions: "ndots:1,edns0",
+			},
+			expectConfig: &k8s.DNSConfig{
+				Servers:  []string{"1.1.1.1"},
+				Searches: []string{"example.com"},
+				Options:  []string{"ndots:1", "edns0"},
+			},
+		},
+		{
+			name:         "empty search clears pod ones",
+			annotations:  map[string]string{AnnotationDNSSearch: ""},
+			expectConfig: &k8s.DNSConfig{Servers: pod.Servers, Options: pod.Options},
+		},
+		{
+			name:        "too many servers",
+			annotations: map[string]string{AnnotationDNSServers: "1.1.1.1,1.0.0.1,8.8.8.8,8.8.4.4"},
+			expectError: true,
+		},
+		{
+			name:        "too many search domains",
+			annotations: map[string]string{AnnotationDNSSearch: "a,b,c,d,e,f,g"},
+			expectError: true,
+		},
+		{
+			name:        "scoped ipv6 server",
+			annotations: map[string]string{AnnotationDNSServers: "fe80::1%eth0"},
+			expectConfig: &k8s.DNSConfig{
+				Servers:  []string{"fe80::1%eth0"},
+				Searches: pod.Searches,
+				Options:  pod.Options,
+			},
+		},
+		{
+			name:        "bad scoped server",
+			annotations: map[string]string{AnnotationDNSServers: "10.0.0.1%eth0"},
+			expectError: true,
+		},
+		{
+			name:        "bad server",
+			annotations: map[string]string{AnnotationDNSServers: "dns.google"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := ParseContainerDNS(tc.annotations, pod)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectConfig, config)
+		})
+	}
+}
+
+func TestContainer_AddResolvConf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	pod := &Pod{
+		PodSandboxConfig: &k8s.PodSandboxConfig{
+			DnsConfig: &k8s.DNSConfig{Servers: []string{"10.96.0.10"}},
+		},
+		baseDir: filepath.Join(dir, "pod"),
+	}
+	c := &Container{
+		ContainerConfig: &k8s.ContainerConfig{},
+		pod:             pod,
+		baseDir:         filepath.Join(dir, "cont"),
+	}
+	require.NoError(t, os.MkdirAll(c.baseDir, 0755))
+
+	require.NoError(t, c.addResolvConf())
+	require.Equal(t, pod.resolvConfFilePath(), c.resolvConf, "pod resolv.conf is expected without annotation")
+
+	c.Annotations = map[string]string{
+		AnnotationDNSServers: "127.0.0.1",
+		AnnotationDNSSearch:  "example.com",
+	}
+	require.NoError(t, c.addResolvConf())
+	require.Equal(t, c.resolvConfFilePath(), c.resolvConf)
+	content, err := ioutil.ReadFile(c.resolvConf)
+	require.NoError(t, err)
+	require.Equal(t, "nameserver 127.0.0.1\nsearch example.com\n", string(content))
+}
+
+func TestPod_AddResolvConf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	defaultPath := hostResolvConfPath
+	defer func() { hostResolvConfPath = defaultPath }()
+	hostResolvConfPath = filepath.Join(dir, "host-resolv.conf")
+	require.NoError(t, ioutil.WriteFile(hostResolvConfPath, []byte("nameserver 192.168.1.1\n"), 0644))
+
+	hostNetwork := &k8s.LinuxPodSandboxConfig{
+		SecurityContext: &k8s.LinuxSandboxSecurityContext{
+			NamespaceOptions: &k8s.NamespaceOption{Network: k8s.NamespaceMode_NODE},
+		},
+	}
+	tt := []struct {
+		name         string
+		config       *k8s.PodSandboxConfig
+		expectConf   string
+		expectNoConf bool
+	}{
+		{
+			name:         "pod network without DNS config",
+			config:       &k8s.PodSandboxConfig{},
+			expectNoConf: true,
+		},
+		{
+			name:       "host network without DNS config",
+			config:     &k8s.PodSandboxConfig{Linux: hostNetwork},
+			expectConf: "nameserver 192.168.1.1\n",
+		},
+		{
+			name: "host network with DNS config",
+			config: &k8s.PodSandboxConfig{
+				Linux:     hostNetwork,
+				DnsConfig: &k8s.DNSConfig{Servers: []string{"10.96.0.10"}},
+			},
+			expectConf: "nameserver 10.96.0.10\n",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &Pod{
+				PodSandboxConfig: tc.config,
+				baseDir:          filepath.Join(dir, tc.name),
+			}
+			require.NoError(t, os.MkdirAll(pod.baseDir, 0755))
+			require.NoError(t, pod.addResolvConf())
+			require.Equal(t, !tc.expectNoConf, pod.hasResolvConf())
+			if tc.expectNoConf {
+				return
+			}
+			content, err := ioutil.ReadFile(pod.resolvConfFilePath())
+			require.NoError(t, err)
+			require.Equal(t, tc.expectConf, string(content))
+		})
+	}
+}
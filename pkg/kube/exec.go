@@ -0,0 +1,87 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+)
+
+// ReadonlyExecEnv is an environment variable that requests read-only exec session
+// when set to 1 in front of the exec command, e.g. SINGULARITY_CRI_READONLY_EXEC=1 sh
+// or env SINGULARITY_CRI_READONLY_EXEC=1 sh. The marker itself is never executed.
+const ReadonlyExecEnv = "SINGULARITY_CRI_READONLY_EXEC"
+
+// readonlyExecScript runs in a private mount namespace with container's
+// rootfs bind mounted read-only onto itself. Positional parameters are
+// rootfs, paths of mount, setpriv and chroot binaries and the command.
+const readonlyExecScript = `set -e; root=$1; mount=$2; setpriv=$3; chroot=$4; shift 4
+"$mount" --bind "$root" "$root"
+"$mount" -o remount,bind,ro "$root"
+if [ -d "$root/proc" ]; then "$mount" -t proc -o ro,nosuid,nodev,noexec proc "$root/proc"; fi
+if [ -d "$root/sys" ]; then "$mount" -t sysfs -o ro,nosuid,nodev,noexec sysfs "$root/sys"; fi
+exec "$setpriv" --bounding-set -dac_override --inh-caps -dac_override "$chroot" "$root" "$@"`
+
+// lookPath is used to find host binaries read-only exec relies on.
+var lookPath = exec.LookPath
+
+// parseReadonlyExec strips read-only exec marker from the passed command
+// and reports whether it was present.
+func parseReadonlyExec(cmd []string) ([]string, bool) {
+	marker := ReadonlyExecEnv + "=1"
+	if len(cmd) > 1 && cmd[0] == marker {
+		return cmd[1:], true
+	}
+	if len(cmd) > 2 && cmd[0] == "env" && cmd[1] == marker {
+		return cmd[2:], true
+	}
+	return cmd, false
+}
+
+// readonlyExec prepares command that runs inside network, PID, IPC and UTS
+// namespaces of the process with the passed pid, but sees container rootfs
+// read-only in a new private mount namespace, and has CAP_DAC_OVERRIDE dropped.
+// The engine is not involved since it would join container mount namespace.
+func readonlyExec(ctx context.Context, pid int, rootfs string, cmd, envs []string, opts ...runtime.ExecOption) (*exec.Cmd, error) {
+	tools := make(map[string]string)
+	for _, name := range []string{"nsenter", "unshare", "mount", "setpriv", "chroot"} {
+		path, err := lookPath(name)
+		if err != nil {
+			return nil, fmt.Errorf("read-only exec requires %s: %v", name, err)
+		}
+		tools[name] = path
+	}
+
+	args := []string{
+		tools["nsenter"], "--target", strconv.Itoa(pid), "--net", "--pid", "--ipc", "--uts", "--",
+		tools["unshare"], "--mount", "--propagation", "private", "--",
+		"/bin/sh", "-c", readonlyExecScript, "sh",
+		strings.TrimSuffix(rootfs, "/"), tools["mount"], tools["setpriv"], tools["chroot"],
+	}
+	args = append(args, cmd...)
+
+	execCmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	// nil env means inherit the current process one
+	execCmd.Env = append([]string{}, envs...)
+	for _, o := range opts {
+		o(execCmd)
+	}
+	return execCmd, nil
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReadonlyExec(t *testing.T) {
+	tt := []struct {
+		name           string
+		cmd            []string
+		expectCmd      []string
+		expectReadonly bool
+	}{
+		{
+			name:      "regular",
+			cmd:       []string{"ls", "-l"},
+			expectCmd: []string{"ls", "-l"},
+		},
+		{
+			name:           "marker",
+			cmd:            []string{"SINGULARITY_CRI_READONLY_EXEC=1", "ls", "-l"},
+			expectCmd:      []string{"ls", "-l"},
+			expectReadonly: true,
+		},
+		{
+			name:           "env marker",
+			cmd:            []string{"env", "SINGULARITY_CRI_READONLY_EXEC=1", "sh"},
+			expectCmd:      []string{"sh"},
+			expectReadonly: true,
+		},
+		{
+			name:      "marker without command",
+			cmd:       []string{"SINGULARITY_CRI_READONLY_EXEC=1"},
+			expectCmd: []string{"SINGULARITY_CRI_READONLY_EXEC=1"},
+		},
+		{
+			name:      "disabled marker",
+			cmd:       []string{"env", "SINGULARITY_CRI_READONLY_EXEC=0", "sh"},
+			expectCmd: []string{"env", "SINGULARITY_CRI_READONLY_EXEC=0", "sh"},
+		},
+		{
+			name:      "marker in arguments",
+			cmd:       []string{"sh", "SINGULARITY_CRI_READONLY_EXEC=1"},
+			expectCmd: []string{"sh", "SINGULARITY_CRI_READONLY_EXEC=1"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd, readonly := parseReadonlyExec(tc.cmd)
+			require.Equal(t, tc.expectCmd, cmd)
+			require.Equal(t, tc.expectReadonly, readonly)
+		})
+	}
+}
+
+func TestReadonlyExec(t *testing.T) {
+	defer func(orig func(string) (string, error)) { lookPath = orig }(lookPath)
+
+	lookPath = func(name string) (string, error) {
+		return "/host/bin/" + name, nil
+	}
+	cmd, err := readonlyExec(context.Background(), 42, "/bundle/rootfs/", []string{"ls", "/"}, []string{"FOO=bar"})
+	require.NoError(t, err)
+	require.Equal(t, "/host/bin/nsenter", cmd.Path)
+	require.Equal(t, []string{
+		"/host/bin/nsenter", "--target", "42", "--net", "--pid", "--ipc", "--uts", "--",
+		"/host/bin/unshare", "--mount", "--propagation", "private", "--",
+		"/bin/sh", "-c", readonlyExecScript, "sh",
+		"/bundle/rootfs", "/host/bin/mount", "/host/bin/setpriv", "/host/bin/chroot",
+		"ls", "/",
+	}, cmd.Args)
+	require.Equal(t, []string{"FOO=bar"}, cmd.Env)
+
+	lookPath = func(name string) (string, error) {
+		if name == "setpriv" {
+			return "", fmt.Errorf("not found")
+		}
+		return "/host/bin/" + name, nil
+	}
+	_, err = readonlyExec(context.Background(), 42, "/bundle/rootfs/", []string{"ls"}, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "setpriv")
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+)
+
+const contExitStatsPath = "exit-stats.json"
+
+// ExitStats is a snapshot of container cgroup usage taken when container
+// exits, before its cgroups are removed. Counters that are not provided
+// by the host cgroup hierarchies are left zero.
+type ExitStats struct {
+	// MemoryPeak is the maximum memory usage recorded in bytes.
+	MemoryPeak uint64 `json:"memoryPeakBytes"`
+	// CPU is total CPU time consumed in nanoseconds.
+	CPU uint64 `json:"cpuNanoseconds"`
+	// OOMKills is the number of processes killed by OOM killer.
+	OOMKills uint64 `json:"oomKills"`
+	// PidsPeak is the maximum number of processes recorded.
+	PidsPeak uint64 `json:"pidsPeak,omitempty"`
+	// TakenAt is time snapshot was taken at.
+	TakenAt time.Time `json:"takenAt"`
+}
+
+// ExitStats returns usage snapshot taken on container exit. It returns
+// nil if container has not exited or usage could not be read.
+func (c *Container) ExitStats() *ExitStats {
+	return c.exitStats
+}
+
+// exitStatsPath returns path to the persisted exit usage snapshot.
+func (c *Container) exitStatsPath() string {
+	return filepath.Join(c.baseDir, contExitStatsPath)
+}
+
+// resolveCgroupDirs remembers cgroup directories of container process
+// so that they can be read once the process is gone.
+func (c *Container) resolveCgroupDirs() {
+	if c.cgroupDirs != nil || c.Pid() <= 0 {
+		return
+	}
+	procs, err := cgroupProcsFiles(c.Pid())
+	if err != nil {
+		glog.V(4).Infof("Could not resolve container %s cgroups: %v", c.id, err)
+		c.cgroupDirs = []string{}
+		return
+	}
+	c.cgroupDirs = make([]string, 0, len(procs))
+	for _, p := range procs {
+		c.cgroupDirs = append(c.cgroupDirs, filepath.Dir(p))
+	}
+}
+
+// snapshotExitStats reads final usage of exited container and persists it
+// next to container bundle. It must be called before container is deleted
+// from the engine as that removes container cgroups. Snapshot is taken once.
+func (c *Container) snapshotExitStats() {
+	if c.exitStatsTaken || len(c.cgroupDirs) == 0 {
+		return
+	}
+	c.exitStatsTaken = true
+	stats := readExitStats(c.cgroupDirs)
+	if stats == nil {
+		glog.V(4).Infof("No cgroup usage found for exited container %s", c.id)
+		return
+	}
+	stats.TakenAt = time.Now()
+	c.exitStats = stats
+	if err := writeExitStats(c.exitStatsPath(), stats); err != nil {
+		glog.Warningf("Could not persist container %s exit stats: %v", c.id, err)
+	}
+}
+
+// readExitStats collects usage counters from the passed cgroup directories,
+// both v1 and v2 file names are understood. It returns nil if none is found.
+func readExitStats(dirs []string) *ExitStats {
+	var stats ExitStats
+	found := false
+	update := func(dst *uint64, v uint64, ok bool) {
+		if ok {
+			found = true
+			if v > *dst {
+				*dst = v
+			}
+		}
+	}
+	for _, dir := range dirs {
+		v, ok := readCgroupValue(filepath.Join(dir, "memory.peak"))
+		update(&stats.MemoryPeak, v, ok)
+		v, ok = readCgroupValue(filepath.Join(dir, "memory.max_usage_in_bytes"))
+		update(&stats.MemoryPeak, v, ok)
+		v, ok = readCgroupValue(filepath.Join(dir, "cpuacct.usage"))
+		update(&stats.CPU, v, ok)
+		v, ok = readCgroupKey(filepath.Join(dir, "cpu.stat"), "usage_usec")
+		update(&stats.CPU, v*uint64(time.Microsecond), ok)
+		v, ok = readOOMKills([]string{dir})
+		update(&stats.OOMKills, v, ok)
+		v, ok = readCgroupValue(filepath.Join(dir, "pids.peak"))
+		update(&stats.PidsPeak, v, ok)
+	}
+	if !found {
+		return nil
+	}
+	return &stats
+}
+
+// readCgroupValue reads cgroup file holding a single number.
+func readCgroupValue(path string) (uint64, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// readCgroupKey reads number stored under the key in flat keyed cgroup file.
+func readCgroupKey(path, key string) (uint64, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != key {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return v, true
+	}
+	return 0, false
+}
+
+func writeExitStats(path string, stats *ExitStats) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("could not marshal exit stats: %v", err)
+	}
+	return fs.WriteFileAtomic(path, data, 0644)
+}
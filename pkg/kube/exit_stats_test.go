@@ -0,0 +1,136 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeCgroupFiles(t *testing.T, dir string, files map[string]string) {
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+}
+
+func TestReadExitStats(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(root)
+
+	v1Memory := filepath.Join(root, "v1", "memory")
+	writeCgroupFiles(t, v1Memory, map[string]string{
+		"memory.max_usage_in_bytes": "104857600\n",
+		"memory.oom_control":        "oom_kill_disable 0\nunder_oom 0\noom_kill 2\n",
+	})
+	v1Cpuacct := filepath.Join(root, "v1", "cpuacct")
+	writeCgroupFiles(t, v1Cpuacct, map[string]string{
+		"cpuacct.usage": "1500000000\n",
+		"cpu.stat":      "nr_periods 0\nnr_throttled 0\nthrottled_time 0\n",
+	})
+	v1Pids := filepath.Join(root, "v1", "pids")
+	writeCgroupFiles(t, v1Pids, map[string]string{
+		"pids.current": "0\n",
+	})
+	v2 := filepath.Join(root, "v2")
+	writeCgroupFiles(t, v2, map[string]string{
+		"memory.peak":   "52428800\n",
+		"memory.events": "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+		"cpu.stat":      "usage_usec 2500\nuser_usec 2000\nsystem_usec 500\n",
+		"pids.peak":     "7\n",
+	})
+	broken := filepath.Join(root, "broken")
+	writeCgroupFiles(t, broken, map[string]string{
+		"memory.peak": "max\n",
+		"cpu.stat":    "usage_usec lots\n",
+	})
+
+	tt := []struct {
+		name   string
+		dirs   []string
+		expect *ExitStats
+	}{
+		{
+			name: "cgroup v1",
+			dirs: []string{v1Memory, v1Cpuacct, v1Pids},
+			expect: &ExitStats{
+				MemoryPeak: 104857600,
+				CPU:        1500000000,
+				OOMKills:   2,
+			},
+		},
+		{
+			name: "cgroup v2",
+			dirs: []string{v2},
+			expect: &ExitStats{
+				MemoryPeak: 52428800,
+				CPU:        2500000,
+				OOMKills:   1,
+				PidsPeak:   7,
+			},
+		},
+		{
+			name: "no counters",
+			dirs: []string{v1Pids, broken, filepath.Join(root, "removed")},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expect, readExitStats(tc.dirs))
+		})
+	}
+}
+
+func TestSnapshotExitStats(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(root)
+
+	cgroup := filepath.Join(root, "cgroup")
+	writeCgroupFiles(t, cgroup, map[string]string{
+		"memory.peak": "4096\n",
+	})
+	baseDir := filepath.Join(root, "container")
+	require.NoError(t, os.Mkdir(baseDir, 0755))
+
+	c := &Container{id: "test", baseDir: baseDir, cgroupDirs: []string{cgroup}}
+	c.snapshotExitStats()
+	stats := c.ExitStats()
+	require.NotNil(t, stats)
+	require.EqualValues(t, 4096, stats.MemoryPeak)
+	require.False(t, stats.TakenAt.IsZero())
+
+	data, err := ioutil.ReadFile(filepath.Join(baseDir, contExitStatsPath))
+	require.NoError(t, err)
+	var persisted ExitStats
+	require.NoError(t, json.Unmarshal(data, &persisted))
+	require.Equal(t, stats.MemoryPeak, persisted.MemoryPeak)
+	require.True(t, stats.TakenAt.Equal(persisted.TakenAt))
+
+	// snapshot is taken once, later reads would see a removed cgroup
+	writeCgroupFiles(t, cgroup, map[string]string{
+		"memory.peak": "0\n",
+	})
+	c.snapshotExitStats()
+	require.True(t, stats == c.ExitStats())
+	require.EqualValues(t, 4096, c.ExitStats().MemoryPeak)
+}
@@ -15,12 +15,14 @@
 package kube
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 
 	"github.com/golang/glog"
+	"github.com/opencontainers/runtime-spec/specs-go"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
@@ -30,7 +32,7 @@ func writeResolvConf(path string, config *k8s.DNSConfig) error {
 	}
 
 	glog.V(5).Infof("Creating resolv.conf file %s", path)
-	resolv, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	resolv, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("could not create %s: %v", podResolvConfPath, err)
 	}
@@ -49,18 +51,23 @@ func writeResolvConf(path string, config *k8s.DNSConfig) error {
 	return nil
 }
 
+// copyFile copies file keeping its permissions.
 func copyFile(from, to string) error {
-	dest, err := os.OpenFile(to, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return fmt.Errorf("could not create copy destination: %v", err)
-	}
-	defer dest.Close()
-
 	src, err := os.Open(from)
 	if err != nil {
 		return fmt.Errorf("could not open copy source: %v", err)
 	}
 	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat copy source: %v", err)
+	}
+
+	dest, err := os.OpenFile(to, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("could not create copy destination: %v", err)
+	}
+	defer dest.Close()
 
 	_, err = io.Copy(dest, src)
 	if err != nil {
@@ -68,3 +75,28 @@ func copyFile(from, to string) error {
 	}
 	return nil
 }
+
+// readSpec reads OCI runtime spec from the passed config.json file.
+func readSpec(path string) (*specs.Spec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open oci config: %v", err)
+	}
+	defer f.Close()
+
+	var spec specs.Spec
+	if err := json.NewDecoder(f).Decode(&spec); err != nil {
+		return nil, fmt.Errorf("could not decode oci config: %v", err)
+	}
+	return &spec, nil
+}
+
+// splitEnv splits environment variable in NAME=VALUE form, value
+// may contain '='. Variable without name or '=' is malformed.
+func splitEnv(env string) (string, string, bool) {
+	i := strings.IndexByte(env, '=')
+	if i <= 0 {
+		return "", "", false
+	}
+	return env[:i], env[i+1:], true
+}
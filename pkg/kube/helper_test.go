@@ -69,3 +69,28 @@ func TestWriteResolvConf(t *testing.T) {
 	}
 
 }
+
+func TestSplitEnv(t *testing.T) {
+	tt := []struct {
+		env    string
+		name   string
+		value  string
+		expect bool
+	}{
+		{env: "PATH=/bin:/usr/bin", name: "PATH", value: "/bin:/usr/bin", expect: true},
+		{env: "OPTS=-Dfoo=bar", name: "OPTS", value: "-Dfoo=bar", expect: true},
+		{env: "EMPTY=", name: "EMPTY", expect: true},
+		{env: "NOVALUE"},
+		{env: "=value"},
+		{env: ""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.env, func(t *testing.T) {
+			name, value, ok := splitEnv(tc.env)
+			require.Equal(t, tc.expect, ok)
+			require.Equal(t, tc.name, name)
+			require.Equal(t, tc.value, value)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// AnnotationAddHosts is a pod annotation that holds comma-separated list
+// of host:ip pairs that should be appended to pod's /etc/hosts file.
+const AnnotationAddHosts = "singularity.cri/add-hosts"
+
+// HostEntry is a single extra /etc/hosts entry.
+type HostEntry struct {
+	Host string
+	IP   string
+}
+
+// ParseExtraHosts parses extra hosts entries from the passed pod annotations.
+// Each entry is split on the first colon so IPv6 addresses are accepted as is.
+// The same host may be listed several times to map it to multiple addresses.
+func ParseExtraHosts(annotations map[string]string) ([]HostEntry, error) {
+	value, ok := annotations[AnnotationAddHosts]
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var hosts []HostEntry
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, ":")
+		if i == -1 {
+			return nil, fmt.Errorf("invalid host entry %q: expected host:ip", entry)
+		}
+		host := strings.TrimSpace(entry[:i])
+		ip := strings.TrimSpace(entry[i+1:])
+		if host == "" || strings.ContainsAny(host, " \t") {
+			return nil, fmt.Errorf("invalid host entry %q: bad host name", entry)
+		}
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid host entry %q: bad ip address", entry)
+		}
+		hosts = append(hosts, HostEntry{Host: host, IP: ip})
+	}
+	return hosts, nil
+}
+
+// copyHosts generates hosts file of pod in host network namespace from host's
+// hosts file at hostPath followed by the extra entries. As with writeHosts
+// file is rewritten in place.
+func copyHosts(path, hostPath string, extra []HostEntry) error {
+	glog.V(5).Infof("Creating hosts file %s from %s", path, hostPath)
+	src, err := os.Open(hostPath)
+	if err != nil {
+		return fmt.Errorf("could not open host hosts file: %v", err)
+	}
+	defer src.Close()
+
+	hosts, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %v", podHostsPath, err)
+	}
+	if _, err := io.Copy(hosts, src); err != nil {
+		hosts.Close()
+		return fmt.Errorf("could not copy host hosts file: %v", err)
+	}
+	for _, h := range extra {
+		fmt.Fprintf(hosts, "%s\t%s\n", h.IP, h.Host)
+	}
+	if err = hosts.Close(); err != nil {
+		return fmt.Errorf("could not close %s: %v", podHostsPath, err)
+	}
+	return nil
+}
+
+// writeHosts generates hosts file with the standard entries followed by
+// the extra ones. Hostname is mapped to each of pod IPs. File is rewritten in place
+// so that it may be regenerated once pod IPs are known without breaking bind mounts.
+func writeHosts(path, hostname string, podIPs []string, extra []HostEntry) error {
+	glog.V(5).Infof("Creating hosts file %s", path)
+	hosts, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %v", podHostsPath, err)
+	}
+	fmt.Fprintln(hosts, "127.0.0.1\tlocalhost")
+	fmt.Fprintln(hosts, "::1\tlocalhost ip6-localhost ip6-loopback")
+	fmt.Fprintln(hosts, "fe00::0\tip6-localnet")
+	fmt.Fprintln(hosts, "ff00::0\tip6-mcastprefix")
+	fmt.Fprintln(hosts, "ff02::1\tip6-allnodes")
+	fmt.Fprintln(hosts, "ff02::2\tip6-allrouters")
+	if hostname != "" {
+		for _, ip := range podIPs {
+			fmt.Fprintf(hosts, "%s\t%s\n", ip, hostname)
+		}
+	}
+	for _, h := range extra {
+		fmt.Fprintf(hosts, "%s\t%s\n", h.IP, h.Host)
+	}
+	if err = hosts.Close(); err != nil {
+		return fmt.Errorf("could not close %s: %v", podHostsPath, err)
+	}
+	return nil
+}
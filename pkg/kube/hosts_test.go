@@ -0,0 +1,134 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExtraHosts(t *testing.T) {
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		expect      []HostEntry
+		expectError bool
+	}{
+		{
+			name:        "no annotation",
+			annotations: map[string]string{"foo": "bar"},
+		},
+		{
+			name:        "empty annotation",
+			annotations: map[string]string{AnnotationAddHosts: " "},
+		},
+		{
+			name:        "single ipv4",
+			annotations: map[string]string{AnnotationAddHosts: "registry.local:10.0.0.5"},
+			expect:      []HostEntry{{Host: "registry.local", IP: "10.0.0.5"}},
+		},
+		{
+			name:        "ipv6 and duplicates",
+			annotations: map[string]string{AnnotationAddHosts: "license:fd00::1, license:10.0.0.7,"},
+			expect: []HostEntry{
+				{Host: "license", IP: "fd00::1"},
+				{Host: "license", IP: "10.0.0.7"},
+			},
+		},
+		{
+			name:        "missing ip",
+			annotations: map[string]string{AnnotationAddHosts: "registry.local"},
+			expectError: true,
+		},
+		{
+			name:        "bad ip",
+			annotations: map[string]string{AnnotationAddHosts: "registry.local:10.0.0"},
+			expectError: true,
+		},
+		{
+			name:        "empty host",
+			annotations: map[string]string{AnnotationAddHosts: ":10.0.0.5"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			hosts, err := ParseExtraHosts(tc.annotations)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, hosts)
+		})
+	}
+}
+
+func TestWriteHosts(t *testing.T) {
+	const standard = "127.0.0.1\tlocalhost\n" +
+		"::1\tlocalhost ip6-localhost ip6-loopback\n" +
+		"fe00::0\tip6-localnet\n" +
+		"ff00::0\tip6-mcastprefix\n" +
+		"ff02::1\tip6-allnodes\n" +
+		"ff02::2\tip6-allrouters\n"
+
+	dir, err := ioutil.TempDir("", "hosts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts")
+
+	extra := []HostEntry{{Host: "license", IP: "fd00::1"}, {Host: "license", IP: "10.0.0.7"}}
+	err = writeHosts(path, "pod", nil, extra)
+	require.NoError(t, err)
+	actual, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, standard+"fd00::1\tlicense\n10.0.0.7\tlicense\n", string(actual))
+
+	// regenerate once pod IP is known
+	err = writeHosts(path, "pod", []string{"10.244.0.3"}, extra)
+	require.NoError(t, err)
+	actual, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, standard+"10.244.0.3\tpod\nfd00::1\tlicense\n10.0.0.7\tlicense\n", string(actual))
+
+	// dual-stack pod gets entries for both families
+	err = writeHosts(path, "pod", []string{"fd00:10:244::3", "10.244.0.3"}, nil)
+	require.NoError(t, err)
+	actual, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, standard+"fd00:10:244::3\tpod\n10.244.0.3\tpod\n", string(actual))
+}
+
+func TestCopyHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hosts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	hostPath := filepath.Join(dir, "host")
+	require.NoError(t, ioutil.WriteFile(hostPath, []byte("127.0.0.1\tlocalhost\n10.0.0.2\tnode-1\n"), 0644))
+	path := filepath.Join(dir, "hosts")
+	require.NoError(t, ioutil.WriteFile(path, []byte("stale content that is longer than the new one\n\n\n\n\n\n\n\n"), 0644))
+
+	err = copyHosts(path, hostPath, []HostEntry{{Host: "license", IP: "10.0.0.7"}})
+	require.NoError(t, err)
+	actual, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1\tlocalhost\n10.0.0.2\tnode-1\n10.0.0.7\tlicense\n", string(actual))
+}
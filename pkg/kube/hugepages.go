@@ -0,0 +1,149 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// AnnotationHugepageLimits is a container annotation that limits hugepages
+// container may use. CRI v1alpha2 has no hugepage limits in container resources,
+// so kubelet hugepages-<size> requests are expected to be passed with it. Value
+// is a comma separated list of <page size>=<limit> entries, e.g. "2Mi=1Gi,1Gi=2Gi".
+// Container that requests hugepages gets hugetlbfs mounted at /dev/hugepages.
+const AnnotationHugepageLimits = "singularity.cri/hugepage-limits"
+
+// hugepagesMountPath is where hugetlbfs of the smallest requested page size
+// is mounted, other page sizes are mounted at hugepagesMountPath-<size>.
+const hugepagesMountPath = "/dev/hugepages"
+
+// sysHugepagesDir lists page sizes supported by the host, see sysfs-kernel-mm-hugepages.
+var sysHugepagesDir = "/sys/kernel/mm/hugepages"
+
+var sizeUnits = []struct {
+	suffix string
+	mult   uint64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40},
+}
+
+// ParseHugepageLimits returns hugepage limits set by container annotation sorted
+// by page size. Page sizes are in format of hugetlb cgroup files, e.g. 2MB.
+// Each page size must be supported by the host and each limit must be a multiple
+// of its page size. Nil limits are returned when annotation is not set.
+func ParseHugepageLimits(annotations map[string]string) ([]specs.LinuxHugepageLimit, error) {
+	value, ok := annotations[AnnotationHugepageLimits]
+	if !ok {
+		return nil, nil
+	}
+	limits := make(map[uint64]uint64)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid %s annotation entry %q: expected <page size>=<limit>", AnnotationHugepageLimits, entry)
+		}
+		pageSize, err := parseBytes(kv[0])
+		if err != nil || pageSize == 0 {
+			return nil, fmt.Errorf("invalid %s annotation entry %q: bad page size", AnnotationHugepageLimits, entry)
+		}
+		limit, err := parseBytes(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation entry %q: bad limit", AnnotationHugepageLimits, entry)
+		}
+		if limit%pageSize != 0 {
+			return nil, fmt.Errorf("invalid %s annotation entry %q: limit is not a multiple of page size", AnnotationHugepageLimits, entry)
+		}
+		if _, ok := limits[pageSize]; ok {
+			return nil, fmt.Errorf("invalid %s annotation: duplicate entry for page size %s", AnnotationHugepageLimits, kv[0])
+		}
+		dir := filepath.Join(sysHugepagesDir, fmt.Sprintf("hugepages-%dkB", pageSize>>10))
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: page size %s is not supported by the node", AnnotationHugepageLimits, kv[0])
+		}
+		limits[pageSize] = limit
+	}
+
+	sizes := make([]uint64, 0, len(limits))
+	for size := range limits {
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	var res []specs.LinuxHugepageLimit
+	for _, size := range sizes {
+		res = append(res, specs.LinuxHugepageLimit{
+			Pagesize: hugetlbPageSize(size),
+			Limit:    limits[size],
+		})
+	}
+	return res, nil
+}
+
+// hugepagesMounts returns hugetlbfs mounts for the passed hugepage limits.
+func hugepagesMounts(limits []specs.LinuxHugepageLimit) []specs.Mount {
+	var mounts []specs.Mount
+	for i, limit := range limits {
+		dest := hugepagesMountPath
+		if i != 0 {
+			dest += "-" + limit.Pagesize
+		}
+		mounts = append(mounts, specs.Mount{
+			Destination: dest,
+			Type:        "hugetlbfs",
+			Source:      "hugetlbfs",
+			Options:     []string{"nosuid", "nodev", "pagesize=" + strings.TrimSuffix(limit.Pagesize, "B")},
+		})
+	}
+	return mounts
+}
+
+// hugetlbPageSize formats page size the way hugetlb cgroup files name it.
+func hugetlbPageSize(size uint64) string {
+	switch {
+	case size >= 1<<30 && size%(1<<30) == 0:
+		return strconv.FormatUint(size>>30, 10) + "GB"
+	case size >= 1<<20 && size%(1<<20) == 0:
+		return strconv.FormatUint(size>>20, 10) + "MB"
+	default:
+		return strconv.FormatUint(size>>10, 10) + "KB"
+	}
+}
+
+// parseBytes parses amount of bytes with optional binary suffix, e.g. 2Mi or 2MB.
+func parseBytes(value string) (uint64, error) {
+	value, mult := strings.TrimSpace(value), uint64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value, mult = strings.TrimSuffix(value, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mult, nil
+}
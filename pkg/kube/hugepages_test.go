@@ -0,0 +1,121 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHugepageLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hugepages-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, size := range []string{"hugepages-2048kB", "hugepages-1048576kB"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, size), 0755))
+	}
+	hugepagesDir := sysHugepagesDir
+	sysHugepagesDir = dir
+	defer func() { sysHugepagesDir = hugepagesDir }()
+
+	tt := []struct {
+		name         string
+		annotations  map[string]string
+		expectLimits []specs.LinuxHugepageLimit
+		expectError  string
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:         "single page size",
+			annotations:  map[string]string{AnnotationHugepageLimits: "2Mi=64Mi"},
+			expectLimits: []specs.LinuxHugepageLimit{{Pagesize: "2MB", Limit: 64 << 20}},
+		},
+		{
+			name:        "multiple page sizes",
+			annotations: map[string]string{AnnotationHugepageLimits: "1GB=2Gi, 2MB=4194304"},
+			expectLimits: []specs.LinuxHugepageLimit{
+				{Pagesize: "2MB", Limit: 4 << 20},
+				{Pagesize: "1GB", Limit: 2 << 30},
+			},
+		},
+		{
+			name:        "unsupported page size",
+			annotations: map[string]string{AnnotationHugepageLimits: "16Mi=32Mi"},
+			expectError: "page size 16Mi is not supported by the node",
+		},
+		{
+			name:        "limit not multiple of page size",
+			annotations: map[string]string{AnnotationHugepageLimits: "2Mi=3Mi"},
+			expectError: "limit is not a multiple of page size",
+		},
+		{
+			name:        "duplicate page size",
+			annotations: map[string]string{AnnotationHugepageLimits: "2Mi=2Mi,2MB=4Mi"},
+			expectError: "duplicate entry for page size 2MB",
+		},
+		{
+			name:        "bad limit",
+			annotations: map[string]string{AnnotationHugepageLimits: "2Mi=lots"},
+			expectError: "bad limit",
+		},
+		{
+			name:        "no limit",
+			annotations: map[string]string{AnnotationHugepageLimits: "2Mi"},
+			expectError: "expected <page size>=<limit>",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			limits, err := ParseHugepageLimits(tc.annotations)
+			if tc.expectError != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectLimits, limits)
+		})
+	}
+}
+
+func TestHugepagesMounts(t *testing.T) {
+	require.Nil(t, hugepagesMounts(nil))
+	mounts := hugepagesMounts([]specs.LinuxHugepageLimit{
+		{Pagesize: "2MB", Limit: 4 << 20},
+		{Pagesize: "1GB", Limit: 2 << 30},
+	})
+	require.Equal(t, []specs.Mount{
+		{
+			Destination: "/dev/hugepages",
+			Type:        "hugetlbfs",
+			Source:      "hugetlbfs",
+			Options:     []string{"nosuid", "nodev", "pagesize=2M"},
+		},
+		{
+			Destination: "/dev/hugepages-1GB",
+			Type:        "hugetlbfs",
+			Source:      "hugetlbfs",
+			Options:     []string{"nosuid", "nodev", "pagesize=1G"},
+		},
+	}, mounts)
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sylabs/singularity-cri/pkg/image"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// AnnotationImageKey is a pod annotation that refers to the key encrypted
+	// SIF images of pod containers are decrypted with. Key is either read from
+	// a file mounted into container, e.g. "secret:/etc/image-key/key.pem" for
+	// a Kubernetes secret mounted at /etc/image-key, or fetched from a key
+	// server, e.g. "https://keys.example.com/models/bert".
+	AnnotationImageKey = "singularity.cri/image-key"
+
+	// AnnotationImageKeyFormat is a pod annotation that sets how image key is
+	// used: ImageKeyPEM (default) or ImageKeyPassphrase.
+	AnnotationImageKeyFormat = "singularity.cri/image-key-format"
+
+	// ImageKeyPEM is an RSA private key the key stored in the image is decrypted with.
+	ImageKeyPEM = "pem"
+	// ImageKeyPassphrase is a passphrase image is encrypted with.
+	ImageKeyPassphrase = "passphrase"
+
+	imageKeySecretPrefix = "secret:"
+	// maxImageKeySize limits size of key read from a file or fetched from a key server.
+	maxImageKeySize = 64 << 10
+)
+
+// imageKeyClient is a variable so that tests may override it.
+var imageKeyClient = &http.Client{Timeout: 30 * time.Second}
+
+// ImageKey refers to the key encrypted images of pod containers are decrypted with.
+// Exactly one of SecretPath and URL is set.
+type ImageKey struct {
+	// SecretPath is a container path of the file holding the key.
+	SecretPath string
+	// URL is an address of the key server the key is fetched from.
+	URL string
+	// Format is either ImageKeyPEM or ImageKeyPassphrase.
+	Format string
+}
+
+// ParseImageKey parses image key reference from the passed pod annotations.
+// When no key is referred nil is returned.
+func ParseImageKey(annotations map[string]string) (*ImageKey, error) {
+	ref := strings.TrimSpace(annotations[AnnotationImageKey])
+	format := strings.TrimSpace(annotations[AnnotationImageKeyFormat])
+	if ref == "" {
+		if format != "" {
+			return nil, fmt.Errorf("%s annotation requires %s annotation", AnnotationImageKeyFormat, AnnotationImageKey)
+		}
+		return nil, nil
+	}
+
+	key := &ImageKey{Format: format}
+	switch key.Format {
+	case "":
+		key.Format = ImageKeyPEM
+	case ImageKeyPEM, ImageKeyPassphrase:
+	default:
+		return nil, fmt.Errorf("invalid %s annotation %q: expected %s or %s",
+			AnnotationImageKeyFormat, format, ImageKeyPEM, ImageKeyPassphrase)
+	}
+
+	if strings.HasPrefix(ref, imageKeySecretPrefix) {
+		key.SecretPath = filepath.Clean(strings.TrimPrefix(ref, imageKeySecretPrefix))
+		if !filepath.IsAbs(key.SecretPath) {
+			return nil, fmt.Errorf("invalid %s annotation %q: secret path must be absolute", AnnotationImageKey, ref)
+		}
+		return key, nil
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid %s annotation %q: expected secret path or key server URL", AnnotationImageKey, ref)
+	}
+	// key must never travel in plaintext
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid %s annotation %q: key server must be accessed over https", AnnotationImageKey, ref)
+	}
+	key.URL = u.String()
+	return key, nil
+}
+
+// fetch returns key material read from the secret found among container
+// mounts or fetched from the key server. Key is kept in memory only.
+func (k *ImageKey) fetch(ctx context.Context, mounts []*k8s.Mount) ([]byte, error) {
+	var data []byte
+	var err error
+	if k.SecretPath != "" {
+		data, err = readSecretKey(k.SecretPath, mounts)
+	} else {
+		data, err = fetchServerKey(ctx, k.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if k.Format == ImageKeyPassphrase {
+		data = bytes.TrimSuffix(data, []byte("\n"))
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("image key is empty")
+	}
+	return data, nil
+}
+
+// plaintext returns the key image at imagePath is encrypted with.
+func (k *ImageKey) plaintext(ctx context.Context, imagePath string, mounts []*k8s.Mount) ([]byte, error) {
+	data, err := k.fetch(ctx, mounts)
+	if err != nil {
+		return nil, fmt.Errorf("could not get image key: %v", err)
+	}
+	if k.Format == ImageKeyPassphrase {
+		return data, nil
+	}
+	defer wipe(data)
+	return image.DecryptSIFKey(imagePath, data)
+}
+
+// readSecretKey reads key from a file at container path, which is resolved
+// to the host through the container mount it is located in. Symlinks, which
+// Kubernetes uses in secret volumes, may not lead outside of the mount.
+func readSecretKey(path string, mounts []*k8s.Mount) ([]byte, error) {
+	var mount *k8s.Mount
+	for _, m := range mounts {
+		dst := filepath.Clean(m.GetContainerPath())
+		if isWithin(dst, path) && (mount == nil || len(dst) > len(filepath.Clean(mount.GetContainerPath()))) {
+			mount = m
+		}
+	}
+	if mount == nil {
+		return nil, fmt.Errorf("%s is not mounted into container", path)
+	}
+
+	root, err := filepath.EvalSymlinks(mount.GetHostPath())
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve mount source: %v", err)
+	}
+	rel, err := filepath.Rel(filepath.Clean(mount.GetContainerPath()), path)
+	if err != nil {
+		return nil, err
+	}
+	hostPath, err := filepath.EvalSymlinks(filepath.Join(root, rel))
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve %s: %v", path, err)
+	}
+	if !isWithin(root, hostPath) {
+		return nil, fmt.Errorf("%s resolves outside of its mount", path)
+	}
+
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readKey(f)
+}
+
+// fetchServerKey fetches key from the key server at keyURL.
+func fetchServerKey(ctx context.Context, keyURL string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, keyURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := imageKeyClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("could not reach key server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxImageKeySize))
+		return nil, fmt.Errorf("key server responded with %s", resp.Status)
+	}
+	return readKey(resp.Body)
+}
+
+// readKey reads key of at most maxImageKeySize bytes.
+func readKey(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxImageKeySize+1))
+	if err != nil {
+		wipe(data)
+		return nil, fmt.Errorf("could not read image key: %v", err)
+	}
+	if len(data) > maxImageKeySize {
+		wipe(data)
+		return nil, fmt.Errorf("image key exceeds %d bytes", maxImageKeySize)
+	}
+	return data, nil
+}
+
+// wipe overwrites key material so that it doesn't linger in memory.
+func wipe(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
+
+// imageKey returns the key container image is encrypted with,
+// or nil when image is not encrypted. Callers should wipe it after use.
+func (c *Container) imageKey() ([]byte, error) {
+	encrypted, err := image.IsEncryptedSIF(c.imgInfo.Path)
+	if err != nil {
+		return nil, fmt.Errorf("could not check whether image is encrypted: %v", err)
+	}
+	if !encrypted {
+		return nil, nil
+	}
+	// pod annotations are validated on pod creation
+	ref, _ := ParseImageKey(c.pod.GetAnnotations())
+	if ref == nil {
+		return nil, fmt.Errorf("image %s is encrypted but pod has no %s annotation", c.imgInfo.ID, AnnotationImageKey)
+	}
+	return ref.plaintext(context.Background(), c.imgInfo.Path, c.GetMounts())
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestParseImageKey(t *testing.T) {
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		expect      *ImageKey
+		expectError bool
+	}{
+		{
+			name:        "no annotation",
+			annotations: map[string]string{"foo": "bar"},
+		},
+		{
+			name:        "secret",
+			annotations: map[string]string{AnnotationImageKey: "secret:/etc/image-key/key.pem"},
+			expect:      &ImageKey{SecretPath: "/etc/image-key/key.pem", Format: ImageKeyPEM},
+		},
+		{
+			name: "key server passphrase",
+			annotations: map[string]string{
+				AnnotationImageKey:       "https://keys.example.com/models/bert",
+				AnnotationImageKeyFormat: ImageKeyPassphrase,
+			},
+			expect: &ImageKey{URL: "https://keys.example.com/models/bert", Format: ImageKeyPassphrase},
+		},
+		{
+			name:        "relative secret",
+			annotations: map[string]string{AnnotationImageKey: "secret:key.pem"},
+			expectError: true,
+		},
+		{
+			name:        "plain http",
+			annotations: map[string]string{AnnotationImageKey: "http://keys.example.com/models/bert"},
+			expectError: true,
+		},
+		{
+			name:        "unknown reference",
+			annotations: map[string]string{AnnotationImageKey: "key.pem"},
+			expectError: true,
+		},
+		{
+			name: "unknown format",
+			annotations: map[string]string{
+				AnnotationImageKey:       "secret:/etc/image-key/key",
+				AnnotationImageKeyFormat: "pgp",
+			},
+			expectError: true,
+		},
+		{
+			name:        "format without key",
+			annotations: map[string]string{AnnotationImageKeyFormat: ImageKeyPEM},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := ParseImageKey(tc.annotations)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, key)
+		})
+	}
+}
+
+func TestImageKey_fetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-key-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// secret volumes keep files behind symlinks
+	secret := filepath.Join(dir, "secret")
+	require.NoError(t, os.MkdirAll(filepath.Join(secret, "..data"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(secret, "..data", "passphrase"), []byte("s3cret\n"), 0600))
+	require.NoError(t, os.Symlink("..data/passphrase", filepath.Join(secret, "passphrase")))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "outside"), []byte("other"), 0600))
+	require.NoError(t, os.Symlink("../outside", filepath.Join(secret, "escape")))
+	mounts := []*k8s.Mount{
+		{ContainerPath: "/etc", HostPath: dir},
+		{ContainerPath: "/etc/image-key", HostPath: secret},
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/bert" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("served"))
+	}))
+	defer server.Close()
+	defer func(client *http.Client) {
+		imageKeyClient = client
+	}(imageKeyClient)
+	imageKeyClient = server.Client()
+
+	tt := []struct {
+		name        string
+		key         ImageKey
+		expect      string
+		expectError bool
+	}{
+		{
+			name:   "secret",
+			key:    ImageKey{SecretPath: "/etc/image-key/passphrase", Format: ImageKeyPassphrase},
+			expect: "s3cret",
+		},
+		{
+			name:        "secret escapes mount",
+			key:         ImageKey{SecretPath: "/etc/image-key/escape", Format: ImageKeyPassphrase},
+			expectError: true,
+		},
+		{
+			name:        "not mounted",
+			key:         ImageKey{SecretPath: "/run/key", Format: ImageKeyPassphrase},
+			expectError: true,
+		},
+		{
+			name:   "key server",
+			key:    ImageKey{URL: server.URL + "/models/bert", Format: ImageKeyPEM},
+			expect: "served",
+		},
+		{
+			name:        "key server not found",
+			key:         ImageKey{URL: server.URL + "/models/gpt", Format: ImageKeyPEM},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := tc.key.fetch(context.Background(), mounts)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, string(data))
+		})
+	}
+}
@@ -0,0 +1,763 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// AnnotationLogDriver is a container or pod annotation that selects
+	// log driver of the container. Container annotation takes precedence.
+	AnnotationLogDriver = "singularity.cri/log-driver"
+
+	// DefaultLogDriver is a log driver used when neither annotation
+	// nor daemon config selects one.
+	DefaultLogDriver = LogDriverFile
+
+	contLogPipePath = "log.pipe"
+
+	// logDrainTimeout is time forwarder waits for the rest of container
+	// output before it is stopped.
+	logDrainTimeout = 100 * time.Millisecond
+
+	// maxJournalMessage limits journald entry message size so that
+	// it always fits into a single datagram.
+	maxJournalMessage = 64 << 10
+)
+
+// LogDriver defines where container output is written to.
+type LogDriver string
+
+const (
+	// LogDriverFile writes CRI formatted log file at path set by kubelet.
+	LogDriverFile LogDriver = "file"
+	// LogDriverJournald writes structured entries to systemd journal. CRI
+	// log file is written as well when kubelet sets log path.
+	LogDriverJournald LogDriver = "journald"
+	// LogDriverNull discards container output. CRI log file is still written
+	// when kubelet sets log path, unless null driver is selected by annotation.
+	LogDriverNull LogDriver = "null"
+)
+
+// journaldSocket is a variable so that tests may override it.
+var journaldSocket = "/run/systemd/journal/socket"
+
+// ParseLogDriver checks name refers to a known log driver.
+// Empty name is valid and results in empty driver.
+func ParseLogDriver(name string) (LogDriver, error) {
+	switch driver := LogDriver(strings.TrimSpace(name)); driver {
+	case "", LogDriverFile, LogDriverJournald, LogDriverNull:
+		return driver, nil
+	}
+	return "", fmt.Errorf("unknown log driver %q", name)
+}
+
+// WithLogDriver sets log driver used when container has no log
+// driver annotation. By default DefaultLogDriver is used.
+func WithLogDriver(driver LogDriver) ContainerOption {
+	return func(c *Container) {
+		c.logDriver = driver
+	}
+}
+
+// LogDriver returns log driver container output is written with.
+func (c *Container) LogDriver() LogDriver {
+	return c.logDriver
+}
+
+// LogsDisabled reports whether CRI log file requested by kubelet
+// is not written since null log driver is forced by annotation.
+func (c *Container) LogsDisabled() bool {
+	return c.logsDisabled
+}
+
+// selectLogDriver picks container log driver from annotations falling back
+// to the configured one. Only explicitly selected null driver disables CRI log file.
+func (c *Container) selectLogDriver() error {
+	name, ok := c.GetAnnotations()[AnnotationLogDriver]
+	if !ok {
+		name = c.pod.GetAnnotations()[AnnotationLogDriver]
+	}
+	driver, err := ParseLogDriver(name)
+	if err != nil {
+		return err
+	}
+	c.logsDisabled = driver == LogDriverNull && c.GetLogPath() != ""
+	if driver == "" {
+		driver = c.logDriver
+	}
+	if driver == "" {
+		driver = DefaultLogDriver
+	}
+	c.logDriver = driver
+	return nil
+}
+
+// forwardsOutput reports whether container output is read by the daemon
+// rather than written by the engine directly.
+func (c *Container) forwardsOutput() bool {
+	return c.logDriver == LogDriverJournald || c.replaySize > 0 ||
+		c.logRotation.Enabled() && c.logPath != ""
+}
+
+// engineLogPath returns path runtime engine writes container output to.
+func (c *Container) engineLogPath() string {
+	switch {
+	case c.forwardsOutput():
+		return filepath.Join(c.baseDir, contLogPipePath)
+	case c.logDriver == LogDriverNull && c.logPath == "":
+		return os.DevNull
+	}
+	return c.logPath
+}
+
+// startLogForwarder creates a pipe engine writes container output to and
+// forwards its entries to journald, if selected, CRI log file, if requested,
+// and attach replay buffer. Otherwise output is handled by the engine itself.
+// Output of monitored container is forwarded by its monitor, see startMonitor.
+func (c *Container) startLogForwarder() error {
+	if !c.forwardsOutput() {
+		return nil
+	}
+	pipePath := c.engineLogPath()
+	if err := syscall.Mkfifo(pipePath, 0600); err != nil {
+		return fmt.Errorf("could not create log pipe: %v", err)
+	}
+	if c.monitored {
+		return nil
+	}
+	return c.forwardOutput(pipePath)
+}
+
+// restoreLogForwarder forwards output of restored container again if
+// engine writes it to a pipe. Configuration may have changed since
+// container was created, so the pipe itself is looked up. Output lost
+// while CRI was down is lost unless container is monitored.
+func (c *Container) restoreLogForwarder() error {
+	pipePath := filepath.Join(c.baseDir, contLogPipePath)
+	if _, err := os.Stat(pipePath); os.IsNotExist(err) {
+		return nil
+	}
+	if c.monitored {
+		return c.forwardMonitorOutput()
+	}
+	return c.forwardOutput(pipePath)
+}
+
+// forwardOutput starts forwarding container output
+// engine writes to the pipe at pipePath.
+func (c *Container) forwardOutput(pipePath string) error {
+	// open for both reading and writing so that no EOF is seen between engine writes
+	pipe, err := os.OpenFile(pipePath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("could not open log pipe: %v", err)
+	}
+
+	var journal []journalField
+	if c.logDriver == LogDriverJournald {
+		journal = c.journalFields()
+	}
+	writers, fileWriter, err := newOutputWriters(c.logPath, c.logRotation, journal)
+	if err != nil {
+		pipe.Close()
+		return err
+	}
+	c.startForwarder(pipe, fileWriter, writers...)
+	return nil
+}
+
+// newOutputWriters returns writers of container output to journald, when
+// journal fields are set, and to CRI log file, when log path is set.
+func newOutputWriters(logPath string, rotation LogRotation, journal []journalField) ([]logWriter, *criFileWriter, error) {
+	var writers []logWriter
+	if journal != nil {
+		writers = append(writers, newJournaldWriter(journaldSocket, journal))
+	}
+	if logPath == "" {
+		return writers, nil, nil
+	}
+	fileWriter, err := newCRIFileWriter(logPath, rotation)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(writers, fileWriter), fileWriter, nil
+}
+
+// startForwarder starts forwarding container output read from src to writers
+// and attach replay buffer. File writer, if any, must be one of writers.
+func (c *Container) startForwarder(src logSource, fileWriter *criFileWriter, writers ...logWriter) {
+	size, overflow := c.logBufferConfig()
+	fwd := newLogForwarder(c.id, src, size, overflow, writers...)
+	if c.replaySize > 0 {
+		fwd.replay = newLogReplay(c.replaySize, &fwd.counters)
+	}
+	c.logMu.Lock()
+	c.logForwarder = fwd
+	c.logFile = fileWriter
+	c.logMu.Unlock()
+	c.logCounters = &fwd.counters
+	go fwd.run()
+}
+
+// stopLogForwarder stops forwarding container output, if any.
+func (c *Container) stopLogForwarder() {
+	c.logMu.Lock()
+	defer c.logMu.Unlock()
+	if c.logForwarder != nil {
+		c.logForwarder.stop()
+		if c.logForwarder.replay != nil {
+			c.logForwarder.replay.release()
+		}
+		c.logForwarder = nil
+		c.logFile = nil
+	}
+}
+
+// reopenLogFile reopens CRI log file written by the daemon or asks container
+// monitor to reopen it. False is returned when log file is written by the engine.
+func (c *Container) reopenLogFile() (bool, error) {
+	if c.monitored {
+		if _, err := os.Stat(filepath.Join(c.baseDir, contLogPipePath)); err == nil {
+			return c.signalMonitor(syscall.SIGHUP)
+		}
+	}
+	c.logMu.Lock()
+	defer c.logMu.Unlock()
+	if c.logFile == nil {
+		return false, nil
+	}
+	return true, c.logFile.reopen()
+}
+
+// AttachOutput passes container output forwarded by the daemon to stdout and
+// stderr writers according to its stream, either of them may be nil. Recent
+// output kept for replay is passed first. Output writers cannot keep up with
+// is skipped, so attached client never slows container down. Returned detach
+// function stops passing output, done channel is closed once container output
+// ends or writer fails. False is returned when container output is not
+// forwarded by the daemon.
+func (c *Container) AttachOutput(stdout, stderr io.Writer) (func(), <-chan struct{}, bool) {
+	c.logMu.Lock()
+	defer c.logMu.Unlock()
+	if c.logForwarder == nil || c.GetTty() {
+		return nil, nil, false
+	}
+	fwd := c.logForwarder
+	markers := "stdout"
+	if stdout == nil {
+		markers = "stderr"
+	}
+	sink := fwd.addReplayedSink(&attachWriter{stdout: stdout, stderr: stderr}, markers)
+	detach := func() {
+		sink.remove()
+		if skipped := fwd.ring.skipped(sink.cursor); skipped != 0 {
+			glog.V(4).Infof("Attached client of container %s skipped %d bytes of output", c.id, skipped)
+		}
+	}
+	return detach, sink.done, true
+}
+
+func (c *Container) journalFields() []journalField {
+	return []journalField{
+		{"SYSLOG_IDENTIFIER", c.GetMetadata().GetName()},
+		{"CONTAINER_ID", c.id},
+		{"CONTAINER_NAME", c.GetMetadata().GetName()},
+		{"POD_ID", c.pod.id},
+		{"POD_NAME", c.pod.GetMetadata().GetName()},
+		{"POD_NAMESPACE", c.pod.GetMetadata().GetNamespace()},
+		{"POD_UID", c.pod.GetMetadata().GetUid()},
+	}
+}
+
+// logEntry is a single line of CRI formatted container log.
+type logEntry struct {
+	raw       []byte // the whole line including trailing newline
+	timestamp time.Time
+	stream    string
+	partial   bool
+	message   []byte
+}
+
+// parseLogEntry parses CRI log line in form of <time> <stream> <tag> <message>.
+func parseLogEntry(line []byte) (*logEntry, error) {
+	parts := bytes.SplitN(bytes.TrimSuffix(line, []byte("\n")), []byte(" "), 4)
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("unexpected log line format")
+	}
+	ts, err := time.Parse(time.RFC3339Nano, string(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid log timestamp: %v", err)
+	}
+	entry := &logEntry{
+		raw:       line,
+		timestamp: ts,
+		stream:    string(parts[1]),
+		partial:   string(parts[2]) == "P",
+	}
+	if len(parts) == 4 {
+		entry.message = parts[3]
+	}
+	return entry, nil
+}
+
+// logWriter is a destination container log entries are forwarded to.
+type logWriter interface {
+	WriteEntry(e *logEntry) error
+	Close() error
+}
+
+// criFileWriter appends entries to CRI log file as is. Whole entries
+// go to either file when log file is reopened or rotated.
+type criFileWriter struct {
+	path     string
+	rotation LogRotation
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newCRIFileWriter(path string, rotation LogRotation) (*criFileWriter, error) {
+	w := &criFileWriter{path: path, rotation: rotation}
+	if err := w.reopen(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// reopen opens log file anew, e.g. after it has been rotated.
+func (w *criFileWriter) reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reopenLocked()
+}
+
+func (w *criFileWriter) reopenLocked() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %v", err)
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not stat log file: %v", err)
+	}
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.file = file
+	w.size = fi.Size()
+	return nil
+}
+
+func (w *criFileWriter) WriteEntry(e *logEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rotation.Enabled() && w.size > 0 && w.size+int64(len(e.raw)) > w.rotation.MaxSize {
+		if err := rotateLogFile(w.path, w.rotation.MaxFiles); err != nil {
+			glog.Errorf("Could not rotate %s: %v", w.path, err)
+			// retry once another MaxSize bytes are written
+			w.size = 0
+		} else if err := w.reopenLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(e.raw)
+	w.size += int64(n)
+	return err
+}
+
+func (w *criFileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// attachWriter passes entry messages to attached client streams.
+type attachWriter struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (w *attachWriter) WriteEntry(e *logEntry) error {
+	out := w.stdout
+	if e.stream == "stderr" {
+		out = w.stderr
+	}
+	if out == nil {
+		return nil
+	}
+	if _, err := out.Write(e.message); err != nil {
+		return err
+	}
+	if e.partial {
+		return nil
+	}
+	_, err := out.Write([]byte{'\n'})
+	return err
+}
+
+func (w *attachWriter) Close() error {
+	return nil
+}
+
+type journalField struct {
+	name  string
+	value string
+}
+
+// journaldWriter sends entries to journald using its native protocol, see
+// https://systemd.io/JOURNAL_NATIVE_PROTOCOL. Partial lines are joined.
+type journaldWriter struct {
+	socket string
+	fields []journalField
+
+	conn    net.Conn
+	partial []byte
+}
+
+func newJournaldWriter(socket string, fields []journalField) *journaldWriter {
+	return &journaldWriter{
+		socket: socket,
+		fields: fields,
+	}
+}
+
+func (w *journaldWriter) WriteEntry(e *logEntry) error {
+	w.partial = append(w.partial, e.message...)
+	if e.partial && len(w.partial) < maxJournalMessage {
+		return nil
+	}
+	message := w.partial
+	w.partial = nil
+	if len(message) > maxJournalMessage {
+		message = message[:maxJournalMessage]
+	}
+
+	if w.conn == nil {
+		conn, err := net.Dial("unixgram", w.socket)
+		if err != nil {
+			return fmt.Errorf("could not connect to journald: %v", err)
+		}
+		w.conn = conn
+	}
+	priority := "6"
+	if e.stream == "stderr" {
+		priority = "3"
+	}
+	fields := append([]journalField{
+		{"MESSAGE", string(message)},
+		{"PRIORITY", priority},
+		{"CRI_STREAM", e.stream},
+	}, w.fields...)
+	_, err := w.conn.Write(encodeJournalFields(fields))
+	return err
+}
+
+func (w *journaldWriter) Close() error {
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.Close()
+}
+
+// encodeJournalFields encodes fields in journald native format.
+// Values with newlines are length prefixed as protocol requires.
+func encodeJournalFields(fields []journalField) []byte {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		if !strings.ContainsRune(f.value, '\n') {
+			fmt.Fprintf(&buf, "%s=%s\n", f.name, f.value)
+			continue
+		}
+		buf.WriteString(f.name)
+		buf.WriteByte('\n')
+		binary.Write(&buf, binary.LittleEndian, uint64(len(f.value)))
+		buf.WriteString(f.value)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// logForwarder reads CRI formatted container output and passes each entry
+// to all its sinks. Output is buffered once in a ring shared by sinks, each
+// sink drains it at its own pace. When the ring is full container output is
+// either not read or dropped according to overflow policy, lossy sinks,
+// e.g. attached clients, skip output instead so that they never slow
+// container down.
+type logForwarder struct {
+	id       string
+	pipe     logSource
+	ring     *logRing
+	overflow LogOverflow
+	counters logCounters
+	pending  int // bytes dropped since the last marker line
+	done     chan struct{}
+
+	// replayMu makes recording line for replay and passing it to sinks
+	// atomic, so that sink added with replay sees every line once.
+	replayMu sync.Mutex
+	replay   *logReplay
+
+	mu      sync.Mutex
+	sinks   map[*logSink]struct{}
+	stopped bool
+}
+
+// logSource is container output forwarder reads, either the pipe engine
+// writes to or connection to container monitor, see RunMonitor.
+type logSource interface {
+	io.ReadCloser
+	SetReadDeadline(t time.Time) error
+}
+
+// logSink is a destination of forwarded output with its own ring cursor.
+type logSink struct {
+	fwd    *logForwarder
+	writer logWriter
+	cursor *logCursor
+	done   chan struct{}
+	// backlog is written before any output read from the ring.
+	backlog []*logEntry
+}
+
+func newLogForwarder(id string, pipe logSource, size int, overflow LogOverflow, writers ...logWriter) *logForwarder {
+	f := &logForwarder{
+		id:       id,
+		pipe:     pipe,
+		ring:     newLogRing(size),
+		overflow: overflow,
+		done:     make(chan struct{}),
+		sinks:    make(map[*logSink]struct{}),
+	}
+	for _, w := range writers {
+		f.addSink(w, false)
+	}
+	return f
+}
+
+// addSink registers writer that receives output read from now on until sink
+// is removed or forwarder is stopped. Writer is closed once sink is finished.
+func (f *logForwarder) addSink(w logWriter, lossy bool) *logSink {
+	return f.startSink(&logSink{
+		fwd:    f,
+		writer: w,
+		cursor: f.ring.addCursor(lossy),
+		done:   make(chan struct{}),
+	})
+}
+
+// addReplayedSink registers lossy sink that receives output kept for replay
+// surrounded by markers written to the passed stream and then output read
+// from now on.
+func (f *logForwarder) addReplayedSink(w logWriter, markers string) *logSink {
+	f.replayMu.Lock()
+	defer f.replayMu.Unlock()
+	sink := &logSink{
+		fwd:    f,
+		writer: w,
+		cursor: f.ring.addCursor(true),
+		done:   make(chan struct{}),
+	}
+	if f.replay != nil {
+		sink.backlog = replayEntries(f.replay.snapshot(), markers, false)
+	}
+	return f.startSink(sink)
+}
+
+func (f *logForwarder) startSink(sink *logSink) *logSink {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopped {
+		f.ring.removeCursor(sink.cursor)
+	} else {
+		f.sinks[sink] = struct{}{}
+	}
+	go sink.drain()
+	return sink
+}
+
+// remove stops passing output to sink without waiting for its writer,
+// which may be blocked by a slow client.
+func (s *logSink) remove() {
+	s.fwd.ring.removeCursor(s.cursor)
+}
+
+func (f *logForwarder) run() {
+	defer close(f.done)
+
+	lines := newLogLineReader(f.pipe)
+	for {
+		line, err := lines.next()
+		if len(line) != 0 {
+			f.buffer(line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if f.pending != 0 {
+		marker := f.dropMarker()
+		if !f.pushLine(marker) {
+			f.ring.push(marker, true)
+			f.recordLine(marker)
+		}
+	}
+	f.ring.close()
+
+	// lossy sinks are not waited for as their clients may be stuck
+	f.mu.Lock()
+	f.stopped = true
+	var sinks []*logSink
+	for sink := range f.sinks {
+		if !sink.cursor.lossy {
+			sinks = append(sinks, sink)
+		}
+	}
+	f.mu.Unlock()
+	for _, sink := range sinks {
+		<-sink.done
+	}
+}
+
+// buffer puts line into the ring applying overflow policy when it is full.
+func (f *logForwarder) buffer(line []byte) {
+	if f.overflow != LogOverflowDrop {
+		if f.pushLine(line) {
+			return
+		}
+		// wait for space without replayMu not to block attaching clients,
+		// client attached meanwhile may miss this line
+		_, waited := f.ring.push(line, true)
+		atomic.AddInt64(&f.counters.blocked, int64(waited))
+		f.recordLine(line)
+		return
+	}
+
+	if f.pending != 0 {
+		if f.pushLine(f.dropMarker()) {
+			f.pending = 0
+		}
+	}
+	if !f.pushLine(line) {
+		if f.pending == 0 {
+			glog.V(4).Infof("Log buffer of container %s is full, dropping output", f.id)
+		}
+		f.pending += len(line)
+		atomic.AddUint64(&f.counters.dropped, uint64(len(line)))
+	}
+}
+
+// pushLine puts line into the ring unless it is full and records it for replay.
+func (f *logForwarder) pushLine(line []byte) bool {
+	f.replayMu.Lock()
+	defer f.replayMu.Unlock()
+	ok, _ := f.ring.push(line, false)
+	if ok && f.replay != nil {
+		f.replay.add(line)
+	}
+	return ok
+}
+
+// recordLine records line that is already in the ring for replay.
+func (f *logForwarder) recordLine(line []byte) {
+	if f.replay == nil {
+		return
+	}
+	f.replayMu.Lock()
+	defer f.replayMu.Unlock()
+	f.replay.add(line)
+}
+
+// dropMarker returns log line reporting output dropped since the last marker.
+func (f *logForwarder) dropMarker() []byte {
+	return []byte(fmt.Sprintf("%s stderr F [singularity-cri: dropped %d bytes of container output]\n",
+		time.Now().UTC().Format(time.RFC3339Nano), f.pending))
+}
+
+// drain passes lines to sink writer until cursor is removed, or ring is
+// closed and drained. Lossy sink is removed on the first write failure as
+// its client has most likely gone.
+func (s *logSink) drain() {
+	f := s.fwd
+	defer func() {
+		s.remove()
+		if err := s.writer.Close(); err != nil {
+			glog.Errorf("Could not close container %s log writer: %v", f.id, err)
+		}
+		f.mu.Lock()
+		delete(f.sinks, s)
+		f.mu.Unlock()
+		close(s.done)
+	}()
+
+	for _, entry := range s.backlog {
+		if err := s.writer.WriteEntry(entry); err != nil {
+			glog.V(4).Infof("Detaching container %s output sink: %v", f.id, err)
+			return
+		}
+	}
+	s.backlog = nil
+
+	failed := false
+	var line []byte
+	for {
+		var ok bool
+		line, ok = f.ring.pop(s.cursor, line[:0])
+		if !ok {
+			return
+		}
+		entry, err := parseLogEntry(line)
+		if err != nil {
+			glog.Errorf("Could not parse container %s log line: %v", f.id, err)
+			continue
+		}
+		werr := s.writer.WriteEntry(entry)
+		if werr != nil && s.cursor.lossy {
+			glog.V(4).Infof("Detaching container %s output sink: %v", f.id, werr)
+			return
+		}
+		// log the first failure only not to flood daemon logs
+		if werr != nil && !failed {
+			glog.Errorf("Could not write container %s logs: %v", f.id, werr)
+		}
+		failed = werr != nil
+	}
+}
+
+// stop stops forwarding once everything written so far is read.
+func (f *logForwarder) stop() {
+	if err := f.pipe.SetReadDeadline(time.Now().Add(logDrainTimeout)); err != nil {
+		f.pipe.Close()
+		<-f.done
+		return
+	}
+	<-f.done
+	f.pipe.Close()
+}
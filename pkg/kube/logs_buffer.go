@@ -0,0 +1,441 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// AnnotationLogBufferSize is a container annotation that overrides
+	// size in bytes of the buffer container output is forwarded through.
+	AnnotationLogBufferSize = "singularity.cri/log-buffer-size"
+
+	// DefaultLogBufferSize is a default size of container log buffer.
+	DefaultLogBufferSize = 1 << 20
+	// MinLogBufferSize is the smallest allowed container log buffer size,
+	// it always fits the longest line forwarder produces.
+	MinLogBufferSize = 64 << 10
+
+	// maxLogLine limits length of a single log line read from container
+	// output, longer lines are split into partial entries.
+	maxLogLine = 16 << 10
+
+	// logRingInitSize is a size of log ring buffer allocated
+	// once the first line is buffered.
+	logRingInitSize = 32 << 10
+)
+
+// LogOverflow defines what log forwarder does when container
+// writes output faster than it can be forwarded.
+type LogOverflow string
+
+const (
+	// LogOverflowBlock stops reading container output until there is room
+	// in the buffer, so that container blocks on write as with docker.
+	LogOverflowBlock LogOverflow = "block"
+	// LogOverflowDrop discards output that does not fit into the buffer
+	// and logs a marker line with the number of dropped bytes instead.
+	LogOverflowDrop LogOverflow = "drop"
+)
+
+// ParseLogOverflow checks name refers to a known overflow policy.
+// Empty name results in LogOverflowBlock.
+func ParseLogOverflow(name string) (LogOverflow, error) {
+	switch policy := LogOverflow(strings.TrimSpace(name)); policy {
+	case "":
+		return LogOverflowBlock, nil
+	case LogOverflowBlock, LogOverflowDrop:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown log overflow policy %q", name)
+}
+
+// ParseLogBufferSize returns log buffer size set by container annotation.
+// Zero is returned when annotation is not set.
+func ParseLogBufferSize(annotations map[string]string) (int, error) {
+	value, ok := annotations[AnnotationLogBufferSize]
+	if !ok {
+		return 0, nil
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil || size < MinLogBufferSize {
+		return 0, fmt.Errorf("invalid %s annotation %q: expected number of bytes not less than %d",
+			AnnotationLogBufferSize, value, MinLogBufferSize)
+	}
+	return int(size), nil
+}
+
+// WithLogBuffer sets size of the buffer container output forwarded by
+// the daemon goes through and what happens when it is full. Zero size
+// results in DefaultLogBufferSize, annotation takes precedence.
+func WithLogBuffer(size int, overflow LogOverflow) ContainerOption {
+	return func(c *Container) {
+		c.logBufferSize = size
+		c.logOverflow = overflow
+	}
+}
+
+// LogStats are counters of container output forwarded by the daemon.
+type LogStats struct {
+	// DroppedBytes is a size of output discarded since buffer was full.
+	DroppedBytes uint64
+	// Blocked is total time container output was not read since buffer was full.
+	Blocked time.Duration
+	// ReplayBytes is a size of recent output kept for attach replay.
+	ReplayBytes int64
+}
+
+// LogStats returns counters of container output forwarder. False
+// is returned when container output is not forwarded by the daemon.
+func (c *Container) LogStats() (LogStats, bool) {
+	if c.logCounters == nil {
+		return LogStats{}, false
+	}
+	return c.logCounters.stats(), true
+}
+
+// logBufferConfig returns log buffer size and overflow policy of the container.
+func (c *Container) logBufferConfig() (int, LogOverflow) {
+	size := c.logBufferSize
+	// annotation is validated on container creation
+	if annotated, _ := ParseLogBufferSize(c.GetAnnotations()); annotated != 0 {
+		size = annotated
+	}
+	if size <= 0 {
+		size = DefaultLogBufferSize
+	}
+	if size < MinLogBufferSize {
+		size = MinLogBufferSize
+	}
+	overflow := c.logOverflow
+	if overflow == "" {
+		overflow = LogOverflowBlock
+	}
+	return size, overflow
+}
+
+type logCounters struct {
+	dropped uint64
+	blocked int64
+	replay  int64
+}
+
+func (c *logCounters) stats() LogStats {
+	return LogStats{
+		DroppedBytes: atomic.LoadUint64(&c.dropped),
+		Blocked:      time.Duration(atomic.LoadInt64(&c.blocked)),
+		ReplayBytes:  atomic.LoadInt64(&c.replay),
+	}
+}
+
+// logRing is a bounded ring buffer of complete log lines shared by all sinks
+// of container output. Each sink reads the ring with its own cursor and space
+// is reclaimed once every cursor has passed a line, so output is buffered once
+// no matter how many sinks there are. Buffer grows up to ring capacity only
+// when sinks fall behind, containers whose sinks keep up hold a small one.
+type logRing struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      []byte
+	capacity int
+	head     int64 // offset of the next pushed byte since ring creation
+	tail     int64 // offset of the oldest byte some cursor has not read yet
+	cursors  map[*logCursor]struct{}
+	closed   bool
+}
+
+// logCursor is a read position of a single sink in the ring. Lossy cursor
+// never makes push wait or fail: when it holds the oldest line of a full
+// ring, all lines it has not read yet are skipped.
+type logCursor struct {
+	pos     int64
+	lossy   bool
+	removed bool
+	skipped uint64
+}
+
+func newLogRing(capacity int) *logRing {
+	r := &logRing{
+		capacity: capacity,
+		cursors:  make(map[*logCursor]struct{}),
+	}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// addCursor registers a new cursor that reads lines pushed from now on.
+func (r *logRing) addCursor(lossy bool) *logCursor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := &logCursor{pos: r.head, lossy: lossy}
+	r.cursors[c] = struct{}{}
+	return c
+}
+
+// removeCursor unregisters cursor, pop on it returns false from now on.
+func (r *logRing) removeCursor(c *logCursor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c.removed {
+		return
+	}
+	c.removed = true
+	delete(r.cursors, c)
+	r.updateTail()
+	r.cond.Broadcast()
+}
+
+// skipped returns number of bytes lossy cursor has skipped.
+func (r *logRing) skipped(c *logCursor) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return c.skipped
+}
+
+// push copies line ending with a newline into the ring. When wait is set and
+// there is no room for line, push blocks until there is and returns time spent
+// waiting. Otherwise false is returned for the line that does not fit.
+func (r *logRing) push(line []byte, wait bool) (bool, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var start time.Time
+	for !r.closed && r.free() < len(line) {
+		if r.skipLossy() {
+			continue
+		}
+		if !wait {
+			break
+		}
+		if start.IsZero() {
+			start = time.Now()
+		}
+		r.cond.Wait()
+	}
+	var waited time.Duration
+	if !start.IsZero() {
+		waited = time.Since(start)
+	}
+	if r.closed || r.free() < len(line) {
+		return false, waited
+	}
+	if len(r.cursors) == 0 {
+		// nobody is going to read the line
+		r.head += int64(len(line))
+		r.tail = r.head
+		return true, waited
+	}
+
+	r.grow(len(line))
+	end := int(r.head % int64(len(r.buf)))
+	n := copy(r.buf[end:], line)
+	copy(r.buf, line[n:])
+	r.head += int64(len(line))
+	r.cond.Broadcast()
+	return true, waited
+}
+
+// pop appends the next line of cursor to dst and returns it. It blocks until
+// line is available and returns false once cursor is removed, or ring is
+// closed and everything is popped.
+func (r *logRing) pop(c *logCursor, dst []byte) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for !c.removed && c.pos == r.head && !r.closed {
+		r.cond.Wait()
+	}
+	if c.removed || c.pos == r.head {
+		return dst, false
+	}
+
+	// lines are pushed as a whole, so there is always a newline
+	size := int(r.head - c.pos)
+	start := int(c.pos % int64(len(r.buf)))
+	n := size
+	head := r.buf[start:]
+	if len(head) > size {
+		head = head[:size]
+	}
+	if i := bytes.IndexByte(head, '\n'); i >= 0 {
+		n = i + 1
+	} else if i := bytes.IndexByte(r.buf[:size-len(head)], '\n'); i >= 0 {
+		n = len(head) + i + 1
+	}
+	if n <= len(head) {
+		dst = append(dst, head[:n]...)
+	} else {
+		dst = append(dst, head...)
+		dst = append(dst, r.buf[:n-len(head)]...)
+	}
+	c.pos += int64(n)
+	tail := r.tail
+	r.updateTail()
+	if r.tail != tail {
+		r.cond.Broadcast()
+	}
+	return dst, true
+}
+
+// close wakes up all waiters, lines that are already pushed may still be popped.
+func (r *logRing) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
+
+// free returns number of bytes that can be pushed without waiting.
+func (r *logRing) free() int {
+	return r.capacity - int(r.head-r.tail)
+}
+
+// updateTail moves tail to the oldest line some cursor has not read yet.
+func (r *logRing) updateTail() {
+	r.tail = r.head
+	for c := range r.cursors {
+		if c.pos < r.tail {
+			r.tail = c.pos
+		}
+	}
+}
+
+// skipLossy moves lossy cursors holding the oldest line to the end of the
+// ring and reports whether any cursor is moved.
+func (r *logRing) skipLossy() bool {
+	moved := false
+	for c := range r.cursors {
+		if c.lossy && c.pos == r.tail && c.pos != r.head {
+			c.skipped += uint64(r.head - c.pos)
+			c.pos = r.head
+			moved = true
+		}
+	}
+	if moved {
+		r.updateTail()
+	}
+	return moved
+}
+
+// grow makes sure buffer fits n more bytes, it is reallocated
+// doubling its size, but never above ring capacity.
+func (r *logRing) grow(n int) {
+	used := int(r.head - r.tail)
+	if len(r.buf)-used >= n {
+		return
+	}
+	size := 2 * len(r.buf)
+	if size < logRingInitSize {
+		size = logRingInitSize
+	}
+	for size < used+n {
+		size *= 2
+	}
+	if size > r.capacity {
+		size = r.capacity
+	}
+	buf := make([]byte, size)
+	for off := r.tail; off < r.head; {
+		from := int(off % int64(len(r.buf)))
+		to := int(off % int64(size))
+		chunk := int(r.head - off)
+		if l := len(r.buf) - from; l < chunk {
+			chunk = l
+		}
+		if l := size - to; l < chunk {
+			chunk = l
+		}
+		copy(buf[to:to+chunk], r.buf[from:from+chunk])
+		off += int64(chunk)
+	}
+	r.buf = buf
+}
+
+// logLineReader reads CRI log lines of bounded length. Lines longer than
+// maxLogLine, e.g. terminal output with no newlines, are split into partial
+// entries with the same timestamp and stream.
+type logLineReader struct {
+	r      *bufio.Reader
+	line   []byte
+	split  bool   // whether the rest of a long line is being read
+	header []byte // timestamp and stream of the split line
+	tag    string // tag of the split line
+}
+
+func newLogLineReader(r io.Reader) *logLineReader {
+	return &logLineReader{
+		r: bufio.NewReaderSize(r, maxLogLine),
+	}
+}
+
+// next returns the next line ending with a newline. Returned
+// slice is only valid until the next call.
+func (l *logLineReader) next() ([]byte, error) {
+	chunk, err := l.r.ReadSlice('\n')
+	if len(chunk) == 0 {
+		return nil, err
+	}
+	complete := err == nil
+	if err == bufio.ErrBufferFull {
+		err = nil
+	}
+	chunk = bytes.TrimSuffix(chunk, []byte("\n"))
+
+	l.line = l.line[:0]
+	if !l.split {
+		if complete {
+			l.line = append(l.line, chunk...)
+			l.line = append(l.line, '\n')
+			return l.line, err
+		}
+		parts := bytes.SplitN(chunk, []byte(" "), 4)
+		if len(parts) != 4 {
+			// not a CRI line, pass it as is for parser to complain
+			l.line = append(l.line, chunk...)
+			l.line = append(l.line, '\n')
+			return l.line, err
+		}
+		l.split = true
+		l.header = append(l.header[:0], parts[0]...)
+		l.header = append(l.header, ' ')
+		l.header = append(l.header, parts[1]...)
+		l.header = append(l.header, ' ')
+		l.tag = string(parts[2])
+		chunk = parts[3]
+	}
+
+	tag := "P"
+	if complete {
+		tag = l.tag
+	}
+	l.line = append(l.line, l.header...)
+	l.line = append(l.line, tag...)
+	l.line = append(l.line, ' ')
+	l.line = append(l.line, chunk...)
+	l.line = append(l.line, '\n')
+	l.split = !complete
+	return l.line, err
+}
@@ -0,0 +1,365 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestLogRing(t *testing.T) {
+	r := newLogRing(10)
+	c := r.addCursor(false)
+
+	ok, _ := r.push([]byte("abcd\n"), false)
+	require.True(t, ok)
+	ok, _ = r.push([]byte("efg\n"), false)
+	require.True(t, ok)
+	ok, _ = r.push([]byte("hij\n"), false)
+	require.False(t, ok, "line must not fit")
+
+	line, ok := r.pop(c, nil)
+	require.True(t, ok)
+	require.Equal(t, "abcd\n", string(line))
+
+	// wraps around the end of the buffer
+	ok, _ = r.push([]byte("klmno\n"), false)
+	require.True(t, ok)
+	line, ok = r.pop(c, line[:0])
+	require.True(t, ok)
+	require.Equal(t, "efg\n", string(line))
+	line, ok = r.pop(c, line[:0])
+	require.True(t, ok)
+	require.Equal(t, "klmno\n", string(line))
+
+	blocked := make(chan time.Duration)
+	go func() {
+		r.push([]byte("0123456789"), false)
+		ok, waited := r.push([]byte("last\n"), true)
+		require.True(t, ok)
+		blocked <- waited
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_, ok = r.pop(c, nil)
+	require.True(t, ok)
+	require.True(t, <-blocked > 0)
+
+	r.close()
+	line, ok = r.pop(c, nil)
+	require.True(t, ok, "buffered lines must be popped after close")
+	require.Equal(t, "last\n", string(line))
+	_, ok = r.pop(c, nil)
+	require.False(t, ok)
+}
+
+func TestLogRing_Cursors(t *testing.T) {
+	r := newLogRing(3 * logRingInitSize)
+	fast := r.addCursor(false)
+	slow := r.addCursor(false)
+	lossy := r.addCursor(true)
+
+	line := []byte(strings.Repeat("x", 1023) + "\n")
+	for i := 0; i < logRingInitSize/len(line); i++ {
+		ok, _ := r.push(line, false)
+		require.True(t, ok)
+	}
+	require.Len(t, r.buf, logRingInitSize, "buffer must not grow while it fits pushed lines")
+	for i := 0; i < logRingInitSize/len(line); i++ {
+		got, ok := r.pop(fast, nil)
+		require.True(t, ok)
+		require.Equal(t, line, got)
+	}
+
+	// slow cursor keeps lines, so buffer grows until capacity
+	for i := 0; i < 2*logRingInitSize/len(line); i++ {
+		ok, _ := r.push(line, false)
+		require.True(t, ok)
+	}
+	require.Len(t, r.buf, 3*logRingInitSize)
+
+	// full ring skips lossy cursor but does not overrun blocking ones
+	ok, _ := r.push(line, false)
+	require.False(t, ok)
+	require.Equal(t, uint64(3*logRingInitSize), r.skipped(lossy))
+	_, ok = r.pop(slow, nil)
+	require.True(t, ok)
+	ok, _ = r.push([]byte("last\n"), false)
+	require.True(t, ok)
+
+	got, ok := r.pop(lossy, nil)
+	require.True(t, ok)
+	require.Equal(t, "last\n", string(got), "lossy cursor must continue from the end")
+
+	// removed cursor no longer holds lines
+	r.removeCursor(slow)
+	_, ok = r.pop(slow, nil)
+	require.False(t, ok)
+	for {
+		got, ok = r.pop(fast, got[:0])
+		require.True(t, ok)
+		if string(got) == "last\n" {
+			break
+		}
+	}
+	require.Equal(t, r.head, r.tail, "all lines must be reclaimed")
+}
+
+func TestLogLineReader(t *testing.T) {
+	long := strings.Repeat("x", maxLogLine+10)
+	input := "2019-05-15T10:20:30Z stdout F short\n" +
+		"2019-05-15T10:20:31Z stderr F " + long + "\n" +
+		"2019-05-15T10:20:32Z stdout P " + long
+
+	l := newLogLineReader(strings.NewReader(input))
+	var lines []string
+	for {
+		line, err := l.next()
+		if len(line) != 0 {
+			require.True(t, len(line) <= maxLogLine+len("2019-05-15T10:20:31Z stderr P \n"))
+			lines = append(lines, string(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	var joined []string
+	for _, line := range lines {
+		entry, err := parseLogEntry([]byte(line))
+		require.NoError(t, err)
+		n := len(joined)
+		if n != 0 && strings.HasSuffix(joined[n-1], "P") {
+			joined[n-1] = strings.TrimSuffix(joined[n-1], "P")
+		} else {
+			joined = append(joined, entry.timestamp.Format(time.RFC3339)+" "+entry.stream+" ")
+			n++
+		}
+		tag := "F"
+		if entry.partial {
+			tag = "P"
+		}
+		joined[n-1] += string(entry.message) + tag
+	}
+	require.Equal(t, []string{
+		"2019-05-15T10:20:30Z stdout shortF",
+		"2019-05-15T10:20:31Z stderr " + long + "F",
+		"2019-05-15T10:20:32Z stdout " + long + "P",
+	}, joined)
+}
+
+func TestParseLogBufferSize(t *testing.T) {
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		expectSize  int
+		expectError bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:        "valid size",
+			annotations: map[string]string{AnnotationLogBufferSize: " 131072 "},
+			expectSize:  131072,
+		},
+		{
+			name:        "too small",
+			annotations: map[string]string{AnnotationLogBufferSize: "1024"},
+			expectError: true,
+		},
+		{
+			name:        "not a number",
+			annotations: map[string]string{AnnotationLogBufferSize: "1Mi"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			size, err := ParseLogBufferSize(tc.annotations)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectSize, size)
+		})
+	}
+}
+
+func TestContainer_LogBufferConfig(t *testing.T) {
+	c := &Container{
+		ContainerConfig: &k8s.ContainerConfig{},
+	}
+	size, overflow := c.logBufferConfig()
+	require.Equal(t, DefaultLogBufferSize, size)
+	require.Equal(t, LogOverflowBlock, overflow)
+
+	WithLogBuffer(2*MinLogBufferSize, LogOverflowDrop)(c)
+	size, overflow = c.logBufferConfig()
+	require.Equal(t, 2*MinLogBufferSize, size)
+	require.Equal(t, LogOverflowDrop, overflow)
+
+	c.Annotations = map[string]string{AnnotationLogBufferSize: "100000"}
+	size, _ = c.logBufferConfig()
+	require.Equal(t, 100000, size)
+}
+
+// throttledWriter is a slow log destination.
+type throttledWriter struct {
+	mu      sync.Mutex
+	delay   time.Duration
+	entries []string
+}
+
+func (w *throttledWriter) WriteEntry(e *logEntry) error {
+	time.Sleep(w.delay)
+	w.mu.Lock()
+	w.entries = append(w.entries, string(e.message))
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *throttledWriter) Close() error {
+	return nil
+}
+
+func TestLogForwarder_Overflow(t *testing.T) {
+	const lines = 500
+	line := strings.Repeat("y", 1000)
+
+	tt := []struct {
+		name     string
+		overflow LogOverflow
+	}{
+		{
+			name:     "block",
+			overflow: LogOverflowBlock,
+		},
+		{
+			name:     "drop",
+			overflow: LogOverflowDrop,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r, w, err := os.Pipe()
+			require.NoError(t, err)
+
+			writer := &throttledWriter{delay: 100 * time.Microsecond}
+			fwd := newLogForwarder("test", r, MinLogBufferSize, tc.overflow, writer)
+			go fwd.run()
+
+			// produce output much faster than writer drains it
+			for i := 0; i < lines; i++ {
+				_, err := fmt.Fprintf(w, "2019-05-15T10:20:30Z stdout F %s\n", line)
+				require.NoError(t, err)
+			}
+			require.NoError(t, w.Close())
+			<-fwd.done
+
+			stats := fwd.counters.stats()
+			switch tc.overflow {
+			case LogOverflowBlock:
+				require.Len(t, writer.entries, lines, "no output must be lost")
+				require.Zero(t, stats.DroppedBytes)
+				require.True(t, stats.Blocked > 0, "container must be blocked")
+			case LogOverflowDrop:
+				require.True(t, stats.DroppedBytes > 0, "output must be dropped")
+				require.True(t, len(writer.entries) < lines)
+				var delivered, markers int
+				var reported uint64
+				for _, e := range writer.entries {
+					if e == line {
+						delivered++
+						continue
+					}
+					var n uint64
+					_, err := fmt.Sscanf(e, "[singularity-cri: dropped %d bytes of container output]", &n)
+					require.NoError(t, err, "unexpected entry %q", e)
+					reported += n
+					markers++
+				}
+				require.NotZero(t, markers)
+				require.Equal(t, stats.DroppedBytes, reported, "all dropped bytes must be reported")
+				require.Equal(t, lines, delivered+int(stats.DroppedBytes)/(len(line)+len("2019-05-15T10:20:30Z stdout F \n")))
+			}
+		})
+	}
+}
+
+// stuckWriter blocks its first write until released.
+type stuckWriter struct {
+	release chan struct{}
+}
+
+func (w *stuckWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return 0, io.ErrClosedPipe
+}
+
+func TestLogForwarder_Attach(t *testing.T) {
+	const lines = 500
+	line := strings.Repeat("z", 1000)
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+
+	writer := &throttledWriter{}
+	fwd := newLogForwarder("test", r, MinLogBufferSize, LogOverflowBlock, writer)
+	stuck := &stuckWriter{release: make(chan struct{})}
+	defer close(stuck.release)
+	stuckSink := fwd.addSink(&attachWriter{stdout: stuck}, true)
+	go fwd.run()
+
+	var stdout, stderr strings.Builder
+	_, err = fmt.Fprintf(w, "2019-05-15T10:20:30Z stdout F before attach\n")
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	attached := fwd.addSink(&attachWriter{stdout: &stdout, stderr: &stderr}, true)
+	_, err = fmt.Fprintf(w, "2019-05-15T10:20:30Z stdout P hello \n2019-05-15T10:20:30Z stdout F world\n"+
+		"2019-05-15T10:20:30Z stderr F oops\n")
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	attached.remove()
+	<-attached.done
+
+	// stuck client must neither block container nor the other sink
+	for i := 0; i < lines; i++ {
+		_, err := fmt.Fprintf(w, "2019-05-15T10:20:30Z stdout F %s\n", line)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	select {
+	case <-fwd.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("forwarder is blocked by attached client")
+	}
+
+	require.Equal(t, "hello world\n", stdout.String())
+	require.Equal(t, "oops\n", stderr.String())
+	require.Len(t, writer.entries, lines+4, "no output must be lost")
+	require.NotZero(t, fwd.ring.skipped(stuckSink.cursor))
+}
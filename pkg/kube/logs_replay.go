@@ -0,0 +1,146 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultAttachReplaySize is the default size of container output kept
+// for replay to attaching clients.
+const DefaultAttachReplaySize = 64 << 10
+
+// replayBytes is memory held by replay buffers of all containers.
+var replayBytes int64
+
+// ReplayBufferBytes returns memory held by attach replay buffers of
+// all containers in bytes.
+func ReplayBufferBytes() int64 {
+	return atomic.LoadInt64(&replayBytes)
+}
+
+// WithAttachReplay sets size of the most recent container output replayed
+// to clients attaching to container. Output of containers with replay is
+// always forwarded by the daemon. Non-positive size disables replay.
+func WithAttachReplay(size int) ContainerOption {
+	return func(c *Container) {
+		c.replaySize = size
+	}
+}
+
+// logReplay keeps the most recent container output up to its size,
+// older entries are discarded as new ones are added.
+type logReplay struct {
+	size     int
+	counters *logCounters
+
+	mu      sync.Mutex
+	entries []*logEntry
+	bytes   int
+}
+
+func newLogReplay(size int, counters *logCounters) *logReplay {
+	return &logReplay{size: size, counters: counters}
+}
+
+// add copies log line into replay buffer. Lines larger
+// than the whole buffer are not kept.
+func (r *logReplay) add(line []byte) {
+	if len(line) > r.size {
+		return
+	}
+	entry, err := parseLogEntry(append([]byte(nil), line...))
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+	delta := len(line)
+	for r.bytes+delta > r.size {
+		delta -= len(r.entries[0].raw)
+		r.entries[0] = nil
+		r.entries = r.entries[1:]
+	}
+	r.account(delta)
+}
+
+// snapshot returns entries currently kept.
+func (r *logReplay) snapshot() []*logEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*logEntry(nil), r.entries...)
+}
+
+// release discards all entries.
+func (r *logReplay) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = nil
+	r.account(-r.bytes)
+}
+
+func (r *logReplay) account(delta int) {
+	r.bytes += delta
+	atomic.AddInt64(&replayBytes, int64(delta))
+	atomic.AddInt64(&r.counters.replay, int64(delta))
+}
+
+// replayEntries surrounds replayed entries with marker lines written to
+// the passed stream, so that client can tell backlog from live output.
+// Terminal needs carriage return as its output is not post-processed.
+func replayEntries(entries []*logEntry, stream string, tty bool) []*logEntry {
+	if len(entries) == 0 {
+		return nil
+	}
+	size := 0
+	for _, e := range entries {
+		size += len(e.message)
+	}
+	eol := ""
+	if tty {
+		eol = "\r"
+	}
+	marker := func(format string, a ...interface{}) *logEntry {
+		return &logEntry{stream: stream, message: []byte(fmt.Sprintf(format, a...) + eol)}
+	}
+	res := make([]*logEntry, 0, len(entries)+2)
+	res = append(res, marker("[singularity-cri: replaying %d bytes of recent output]", size))
+	res = append(res, entries...)
+	return append(res, marker("[singularity-cri: end of replayed output]"))
+}
+
+// ReplayOutput writes the most recent output of TTY container to w. Output of
+// containers with TTY is merged into a single stream and is passed to attached
+// clients by the engine, so replay is written before client is connected.
+func (c *Container) ReplayOutput(w io.Writer) error {
+	c.logMu.Lock()
+	fwd := c.logForwarder
+	c.logMu.Unlock()
+	if fwd == nil || fwd.replay == nil {
+		return nil
+	}
+	out := &attachWriter{stdout: w, stderr: w}
+	for _, e := range replayEntries(fwd.replay.snapshot(), "stdout", true) {
+		if err := out.WriteEntry(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogReplay(t *testing.T) {
+	line := func(msg string) []byte {
+		return []byte(fmt.Sprintf("2019-05-15T10:20:30Z stdout F %s\n", msg))
+	}
+	messages := func(entries []*logEntry) []string {
+		var res []string
+		for _, e := range entries {
+			res = append(res, string(e.message))
+		}
+		return res
+	}
+
+	before := ReplayBufferBytes()
+	var counters logCounters
+	size := 3 * len(line("aaa"))
+	replay := newLogReplay(size, &counters)
+	for _, msg := range []string{"aaa", "bbb", "ccc", "ddd"} {
+		replay.add(line(msg))
+	}
+	require.Equal(t, []string{"bbb", "ccc", "ddd"}, messages(replay.snapshot()))
+	require.EqualValues(t, size, counters.stats().ReplayBytes)
+	require.EqualValues(t, size, ReplayBufferBytes()-before)
+
+	replay.add(line(strings.Repeat("x", size)))
+	require.Equal(t, []string{"bbb", "ccc", "ddd"}, messages(replay.snapshot()), "oversized line must be skipped")
+	replay.add(line("a"))
+	require.Equal(t, []string{"ccc", "ddd", "a"}, messages(replay.snapshot()))
+
+	replay.release()
+	require.Empty(t, replay.snapshot())
+	require.Zero(t, counters.stats().ReplayBytes)
+	require.Equal(t, before, ReplayBufferBytes())
+}
+
+func TestLogForwarder_Replay(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer w.Close()
+
+	fwd := newLogForwarder("test", r, MinLogBufferSize, LogOverflowBlock)
+	fwd.replay = newLogReplay(DefaultAttachReplaySize, &fwd.counters)
+	go fwd.run()
+	defer fwd.stop()
+
+	_, err = fmt.Fprintf(w, "2019-05-15T10:20:30Z stdout P hello \n2019-05-15T10:20:30Z stdout F world\n"+
+		"2019-05-15T10:20:30Z stderr F oops\n")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(fwd.replay.snapshot()) == 3
+	}, time.Second, time.Millisecond)
+
+	var stdout, stderr strings.Builder
+	attached := fwd.addReplayedSink(&attachWriter{stdout: &stdout, stderr: &stderr}, "stdout")
+	_, err = fmt.Fprintf(w, "2019-05-15T10:20:31Z stdout F live\n")
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	attached.remove()
+	<-attached.done
+	require.Equal(t, "[singularity-cri: replaying 15 bytes of recent output]\nhello world\n"+
+		"[singularity-cri: end of replayed output]\nlive\n", stdout.String())
+	require.Equal(t, "oops\n", stderr.String())
+
+	// TTY output is replayed merged
+	c := &Container{logForwarder: fwd}
+	var tty strings.Builder
+	require.NoError(t, c.ReplayOutput(&tty))
+	require.Equal(t, "[singularity-cri: replaying 19 bytes of recent output]\r\nhello world\noops\nlive\n"+
+		"[singularity-cri: end of replayed output]\r\n", tty.String())
+}
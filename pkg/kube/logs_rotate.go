@@ -0,0 +1,84 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// DefaultLogMaxFiles is a number of log files kept when log rotation is
+// enabled without explicit limit. It matches kubelet default.
+const DefaultLogMaxFiles = 5
+
+// LogRotation is a policy of CRI log file rotation done by the daemon, for
+// clusters where kubelet does not rotate container logs. Log file is renamed
+// to <path>.1 once it reaches MaxSize, older files are shifted to <path>.2
+// and so on. Log file is never rotated when MaxSize is zero.
+type LogRotation struct {
+	// MaxSize is a size in bytes log file is rotated at.
+	MaxSize int64
+	// MaxFiles is a number of log files kept including the current one.
+	MaxFiles int
+}
+
+// Enabled reports whether log files are rotated.
+func (r LogRotation) Enabled() bool {
+	return r.MaxSize > 0
+}
+
+// Validate checks rotation limits are meaningful.
+func (r LogRotation) Validate() error {
+	if r.MaxSize < 0 {
+		return fmt.Errorf("log max size cannot be negative")
+	}
+	if r.MaxFiles < 0 || r.MaxFiles == 1 {
+		return fmt.Errorf("log max files must be at least 2, got %d", r.MaxFiles)
+	}
+	return nil
+}
+
+// WithLogRotation makes the daemon rotate CRI log file of the container.
+// When rotation is enabled, container output is forwarded by the daemon.
+// Zero MaxFiles results in DefaultLogMaxFiles.
+func WithLogRotation(r LogRotation) ContainerOption {
+	return func(c *Container) {
+		if r.MaxFiles == 0 {
+			r.MaxFiles = DefaultLogMaxFiles
+		}
+		c.logRotation = r
+	}
+}
+
+// rotatedLogPath returns path of n-th rotated log file, the most recent is the first.
+func rotatedLogPath(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+// rotateLogFile shifts rotated log files dropping the oldest one
+// and renames log file to the most recent rotated one.
+func rotateLogFile(path string, maxFiles int) error {
+	for n := maxFiles - 1; n > 1; n-- {
+		err := os.Rename(rotatedLogPath(path, n-1), rotatedLogPath(path, n))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not shift rotated log file: %v", err)
+		}
+	}
+	if err := os.Rename(path, rotatedLogPath(path, 1)); err != nil {
+		return fmt.Errorf("could not rotate log file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogRotation_Validate(t *testing.T) {
+	tt := []struct {
+		name        string
+		rotation    LogRotation
+		expectError string
+	}{
+		{name: "disabled"},
+		{name: "valid", rotation: LogRotation{MaxSize: 1 << 20, MaxFiles: 3}},
+		{name: "default files", rotation: LogRotation{MaxSize: 1 << 20}},
+		{name: "negative size", rotation: LogRotation{MaxSize: -1}, expectError: "log max size cannot be negative"},
+		{name: "single file", rotation: LogRotation{MaxSize: 1 << 20, MaxFiles: 1}, expectError: "log max files must be at least 2, got 1"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rotation.Validate()
+			if tc.expectError != "" {
+				require.EqualError(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestCRIFileWriter_Rotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "0.log")
+	line := "2019-05-15T10:20:30.000000001Z stdout F 0123456789\n"
+	// the file written before restart is accounted
+	require.NoError(t, ioutil.WriteFile(logPath, []byte(line), 0640))
+	w, err := newCRIFileWriter(logPath, LogRotation{MaxSize: int64(2 * len(line)), MaxFiles: 3})
+	require.NoError(t, err)
+	defer w.Close()
+
+	for i := 0; i < 6; i++ {
+		require.NoError(t, w.WriteEntry(&logEntry{raw: []byte(line)}))
+	}
+	// seven lines in total, the oldest two are dropped
+	expect := map[string]string{
+		logPath:                    line,
+		rotatedLogPath(logPath, 1): line + line,
+		rotatedLogPath(logPath, 2): line + line,
+	}
+	for path, lines := range expect {
+		content, err := ioutil.ReadFile(path)
+		require.NoError(t, err, path)
+		require.Equal(t, lines, string(content), path)
+	}
+	_, err = os.Stat(rotatedLogPath(logPath, 3))
+	require.True(t, os.IsNotExist(err), "oldest file must be removed")
+}
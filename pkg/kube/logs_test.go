@@ -0,0 +1,259 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestContainer_SelectLogDriver(t *testing.T) {
+	tt := []struct {
+		name           string
+		configured     LogDriver
+		contAnnotation string
+		podAnnotation  string
+		logPath        string
+		expectDriver   LogDriver
+		expectEngine   string
+		expectDisabled bool
+		expectError    bool
+	}{
+		{
+			name:         "default",
+			logPath:      "0.log",
+			expectDriver: LogDriverFile,
+			expectEngine: "0.log",
+		},
+		{
+			name:         "configured journald",
+			configured:   LogDriverJournald,
+			logPath:      "0.log",
+			expectDriver: LogDriverJournald,
+			expectEngine: contLogPipePath,
+		},
+		{
+			name:         "configured null keeps kubelet file",
+			configured:   LogDriverNull,
+			logPath:      "0.log",
+			expectDriver: LogDriverNull,
+			expectEngine: "0.log",
+		},
+		{
+			name:         "configured null without kubelet file",
+			configured:   LogDriverNull,
+			expectDriver: LogDriverNull,
+			expectEngine: os.DevNull,
+		},
+		{
+			name:           "forced null",
+			configured:     LogDriverJournald,
+			podAnnotation:  "null",
+			logPath:        "0.log",
+			expectDriver:   LogDriverNull,
+			expectEngine:   os.DevNull,
+			expectDisabled: true,
+		},
+		{
+			name:           "container annotation wins",
+			contAnnotation: "file",
+			podAnnotation:  "null",
+			logPath:        "0.log",
+			expectDriver:   LogDriverFile,
+			expectEngine:   "0.log",
+		},
+		{
+			name:           "unknown driver",
+			contAnnotation: "syslog",
+			expectError:    true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			contAnnotations := map[string]string{}
+			if tc.contAnnotation != "" {
+				contAnnotations[AnnotationLogDriver] = tc.contAnnotation
+			}
+			podAnnotations := map[string]string{}
+			if tc.podAnnotation != "" {
+				podAnnotations[AnnotationLogDriver] = tc.podAnnotation
+			}
+			c := &Container{
+				ContainerConfig: &k8s.ContainerConfig{
+					Annotations: contAnnotations,
+					LogPath:     tc.logPath,
+				},
+				pod: &Pod{
+					PodSandboxConfig: &k8s.PodSandboxConfig{Annotations: podAnnotations},
+				},
+				logDriver: tc.configured,
+			}
+			err := c.selectLogDriver()
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if !c.LogsDisabled() {
+				// normally resolved by addLogDirectory
+				c.logPath = tc.logPath
+			}
+			require.Equal(t, tc.expectDriver, c.LogDriver())
+			require.Equal(t, tc.expectDisabled, c.LogsDisabled())
+			require.Equal(t, tc.expectEngine, c.engineLogPath())
+		})
+	}
+}
+
+func TestParseLogEntry(t *testing.T) {
+	ts := "2019-05-15T10:20:30.123456789Z"
+	tt := []struct {
+		name          string
+		line          string
+		expectStream  string
+		expectPartial bool
+		expectMessage string
+		expectError   bool
+	}{
+		{
+			name:          "full line",
+			line:          ts + " stdout F hello world\n",
+			expectStream:  "stdout",
+			expectMessage: "hello world",
+		},
+		{
+			name:          "partial line",
+			line:          ts + " stderr P hello ",
+			expectStream:  "stderr",
+			expectPartial: true,
+			expectMessage: "hello ",
+		},
+		{
+			name:         "empty message",
+			line:         ts + " stdout F\n",
+			expectStream: "stdout",
+		},
+		{
+			name:        "bad timestamp",
+			line:        "yesterday stdout F hello\n",
+			expectError: true,
+		},
+		{
+			name:        "bad format",
+			line:        "hello\n",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			entry, err := parseLogEntry([]byte(tc.line))
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.line, string(entry.raw))
+			require.Equal(t, tc.expectStream, entry.stream)
+			require.Equal(t, tc.expectPartial, entry.partial)
+			require.Equal(t, tc.expectMessage, string(entry.message))
+			require.Equal(t, 2019, entry.timestamp.Year())
+		})
+	}
+}
+
+func TestEncodeJournalFields(t *testing.T) {
+	actual := encodeJournalFields([]journalField{
+		{"MESSAGE", "hello"},
+		{"CONTAINER_NAME", "nginx"},
+		{"MESSAGE", "a\nb"},
+	})
+	expect := "MESSAGE=hello\nCONTAINER_NAME=nginx\nMESSAGE\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\n"
+	require.Equal(t, expect, string(actual))
+}
+
+func TestLogForwarder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "journal.sock")
+	journal, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer journal.Close()
+
+	logPath := filepath.Join(dir, "0.log")
+	file, err := newCRIFileWriter(logPath, LogRotation{})
+	require.NoError(t, err)
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer w.Close()
+	fwd := newLogForwarder("test", r, MinLogBufferSize, LogOverflowBlock,
+		newJournaldWriter(socket, []journalField{{"CONTAINER_NAME", "nginx"}}),
+		file,
+	)
+	go fwd.run()
+
+	lines := []string{
+		"2019-05-15T10:20:30.000000001Z stdout P hello \n",
+		"2019-05-15T10:20:30.000000002Z stdout F world\n",
+		"2019-05-15T10:20:30.000000003Z stderr F oops\n",
+	}
+	for _, line := range lines {
+		_, err := w.WriteString(line)
+		require.NoError(t, err)
+	}
+	// kubelet rotates logs by renaming file and asking to reopen it
+	require.Eventually(t, func() bool {
+		fi, err := os.Stat(logPath)
+		return err == nil && fi.Size() == int64(len(strings.Join(lines, "")))
+	}, time.Second, time.Millisecond)
+	require.NoError(t, os.Rename(logPath, logPath+".1"))
+	require.NoError(t, file.reopen())
+	rotated := "2019-05-15T10:20:31Z stdout F rotated\n"
+	_, err = w.WriteString(rotated)
+	require.NoError(t, err)
+	fwd.stop()
+
+	content, err := ioutil.ReadFile(logPath + ".1")
+	require.NoError(t, err)
+	require.Equal(t, strings.Join(lines, ""), string(content), "CRI file must be kept intact")
+	content, err = ioutil.ReadFile(logPath)
+	require.NoError(t, err)
+	require.Equal(t, rotated, string(content))
+
+	require.NoError(t, journal.SetReadDeadline(time.Now().Add(time.Second)))
+	buf := make([]byte, 1024)
+	var entries []string
+	for i := 0; i < 2; i++ {
+		n, err := journal.Read(buf)
+		require.NoError(t, err)
+		entries = append(entries, string(buf[:n]))
+	}
+	require.Equal(t, []string{
+		"MESSAGE=hello world\nPRIORITY=6\nCRI_STREAM=stdout\nCONTAINER_NAME=nginx\n",
+		"MESSAGE=oops\nPRIORITY=3\nCRI_STREAM=stderr\nCONTAINER_NAME=nginx\n",
+	}, entries)
+}
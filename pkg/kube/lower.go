@@ -0,0 +1,329 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultLowerDirGracePeriod is the default time image root filesystem
+	// stays mounted after the last container using it is removed.
+	DefaultLowerDirGracePeriod = time.Minute
+
+	lowerRootfsPath = "rootfs"
+	lowerUsersPath  = "users"
+	// lowerUnpackedPath marks root filesystem unpacked from OCI layout,
+	// which, unlike mounted SIF, cannot be told apart from an empty one.
+	lowerUnpackedPath = "unpacked"
+)
+
+// LowerDirs keeps read-only image root filesystems that are shared as
+// overlay lower directories by all containers created from the same image.
+// Each image is mounted once on first use and is unmounted when the last
+// container using it is released and grace period passes. Images stored
+// in OCI layout are unpacked instead and are removed the same way. Users of each
+// image are recorded on disk so that they survive daemon restarts.
+type LowerDirs struct {
+	baseDir  string
+	grace    time.Duration
+	mount    func(imagePath, dir string, key []byte) error
+	unmount  func(dir string) error
+	unpack   func(layoutPath, dir string) error
+	checkKey func(imagePath string, key []byte) error
+
+	mu     sync.Mutex
+	lowers map[string]*lowerDir
+}
+
+// lowerDir is a single image root filesystem shared between containers.
+type lowerDir struct {
+	// mu serializes mount of image so that no lock
+	// covering all images is held during mount.
+	mu      sync.Mutex
+	mounted bool
+	// users and expire are protected by LowerDirs.mu.
+	users  map[string]struct{}
+	expire *time.Timer
+}
+
+// NewLowerDirs returns LowerDirs that mounts images under baseDir. Users of
+// images recorded by previous daemon run are restored, images that were left
+// without users are unmounted once grace period passes.
+func NewLowerDirs(baseDir string, grace time.Duration) (*LowerDirs, error) {
+	l := &LowerDirs{
+		baseDir:  baseDir,
+		grace:    grace,
+		mount:    mountLower,
+		unmount:  unmountLower,
+		unpack:   unpackLayout,
+		checkKey: checkLowerKey,
+		lowers:   make(map[string]*lowerDir),
+	}
+	if err := os.MkdirAll(baseDir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create lower directories base: %v", err)
+	}
+	if err := l.restore(); err != nil {
+		return nil, fmt.Errorf("could not restore lower directories: %v", err)
+	}
+	return l, nil
+}
+
+// Acquire returns path to root filesystem of image with the passed ID
+// mounting image file, or unpacking image layout directory, first if needed. Container with contID is recorded as
+// image user until Release is called. Acquire is idempotent for the same container.
+// Encrypted image is decrypted with key when mounted; when it is already
+// mounted key is still checked so that only key holders may share it.
+func (l *LowerDirs) Acquire(imageID, imagePath, contID string, key []byte) (string, error) {
+	l.mu.Lock()
+	lower, ok := l.lowers[imageID]
+	if !ok {
+		lower = &lowerDir{users: make(map[string]struct{})}
+		l.lowers[imageID] = lower
+	}
+	if lower.expire != nil {
+		lower.expire.Stop()
+		lower.expire = nil
+	}
+	lower.users[contID] = struct{}{}
+	l.mu.Unlock()
+
+	rootfs := l.rootfsPath(imageID)
+	mountedNow := false
+	err := l.addUser(imageID, contID)
+	if err == nil {
+		err = lower.ensureMounted(func() error {
+			mountedNow = true
+			if fi, err := os.Stat(imagePath); err == nil && fi.IsDir() {
+				return l.unpackLayout(imageID, imagePath)
+			}
+			glog.V(4).Infof("Mounting image %s at %s", imageID, rootfs)
+			if err := os.MkdirAll(rootfs, 0700); err != nil {
+				return fmt.Errorf("could not create lower directory: %v", err)
+			}
+			return l.mount(imagePath, rootfs, key)
+		})
+	}
+	if err == nil && key != nil && !mountedNow {
+		err = l.checkKey(imagePath, key)
+	}
+	if err != nil {
+		l.Release(imageID, contID)
+		return "", fmt.Errorf("could not mount image: %v", err)
+	}
+	return rootfs, nil
+}
+
+// unpackLayout unpacks image stored in OCI layout at layoutPath
+// into its root filesystem and marks it as unpacked.
+func (l *LowerDirs) unpackLayout(imageID, layoutPath string) error {
+	rootfs := l.rootfsPath(imageID)
+	glog.V(4).Infof("Unpacking image %s at %s", imageID, rootfs)
+	start := time.Now()
+	if err := l.unpack(layoutPath, rootfs); err != nil {
+		return fmt.Errorf("could not unpack image: %v", err)
+	}
+	f, err := os.Create(filepath.Join(l.baseDir, imageID, lowerUnpackedPath))
+	if err != nil {
+		return fmt.Errorf("could not mark image as unpacked: %v", err)
+	}
+	glog.V(4).Infof("Image %s is unpacked in %v", imageID, time.Since(start))
+	return f.Close()
+}
+
+// Release notifies that container with contID no longer uses image root filesystem.
+// When image has no users left it is scheduled for unmount after grace period.
+// Releasing container that doesn't use image is a no-op.
+func (l *LowerDirs) Release(imageID, contID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lower, ok := l.lowers[imageID]
+	if !ok {
+		return
+	}
+	if _, ok := lower.users[contID]; !ok {
+		return
+	}
+	delete(lower.users, contID)
+	if err := os.Remove(filepath.Join(l.usersPath(imageID), contID)); err != nil && !os.IsNotExist(err) {
+		glog.Errorf("Could not remove image %s user %s: %v", imageID, contID, err)
+	}
+	if len(lower.users) == 0 {
+		l.scheduleExpire(imageID, lower)
+	}
+}
+
+// Users returns number of containers that use root filesystem of image with the passed ID.
+func (l *LowerDirs) Users(imageID string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lower, ok := l.lowers[imageID]
+	if !ok {
+		return 0
+	}
+	return len(lower.users)
+}
+
+// Drop unmounts root filesystem of image with the passed ID right away if no
+// container uses it, e.g. when image itself is about to be removed.
+// It returns false if image is still used.
+func (l *LowerDirs) Drop(imageID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lower, ok := l.lowers[imageID]
+	if !ok {
+		return true
+	}
+	if len(lower.users) != 0 {
+		return false
+	}
+	l.remove(imageID, lower)
+	return true
+}
+
+// Flush unmounts all images that have no users without waiting for grace period.
+func (l *LowerDirs) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for imageID, lower := range l.lowers {
+		if len(lower.users) == 0 {
+			l.remove(imageID, lower)
+		}
+	}
+}
+
+// scheduleExpire arranges unmount of unused image after grace period.
+// Caller must hold l.mu.
+func (l *LowerDirs) scheduleExpire(imageID string, lower *lowerDir) {
+	var expire *time.Timer
+	expire = time.AfterFunc(l.grace, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		// image may be acquired again during grace period
+		if lower.expire != expire || len(lower.users) != 0 {
+			return
+		}
+		l.remove(imageID, lower)
+	})
+	lower.expire = expire
+}
+
+// remove unmounts unused image and forgets about it. Lock of lower is
+// never held here for long since it has no users. Caller must hold l.mu.
+func (l *LowerDirs) remove(imageID string, lower *lowerDir) {
+	if lower.expire != nil {
+		lower.expire.Stop()
+		lower.expire = nil
+	}
+	lower.mu.Lock()
+	defer lower.mu.Unlock()
+
+	if lower.mounted {
+		glog.V(4).Infof("Unmounting unused image %s", imageID)
+		if err := l.unmount(l.rootfsPath(imageID)); err != nil {
+			glog.Errorf("Could not unmount image %s: %v", imageID, err)
+			return
+		}
+		lower.mounted = false
+	}
+	if err := os.RemoveAll(filepath.Join(l.baseDir, imageID)); err != nil {
+		glog.Errorf("Could not remove image %s lower directory: %v", imageID, err)
+	}
+	delete(l.lowers, imageID)
+}
+
+// restore reads image users recorded on disk.
+func (l *LowerDirs) restore() error {
+	dirs, err := ioutil.ReadDir(l.baseDir)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		imageID := dir.Name()
+		users, err := ioutil.ReadDir(l.usersPath(imageID))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		_, err = os.Stat(filepath.Join(l.baseDir, imageID, lowerUnpackedPath))
+		lower := &lowerDir{
+			users:   make(map[string]struct{}, len(users)),
+			mounted: err == nil || isMountPoint(l.rootfsPath(imageID)),
+		}
+		for _, user := range users {
+			lower.users[user.Name()] = struct{}{}
+		}
+		glog.V(4).Infof("Restored image %s lower directory with %d users", imageID, len(lower.users))
+		l.lowers[imageID] = lower
+		if len(lower.users) == 0 {
+			l.scheduleExpire(imageID, lower)
+		}
+	}
+	return nil
+}
+
+// addUser records container as image user on disk.
+func (l *LowerDirs) addUser(imageID, contID string) error {
+	users := l.usersPath(imageID)
+	if err := os.MkdirAll(users, 0700); err != nil {
+		return fmt.Errorf("could not create users directory: %v", err)
+	}
+	f, err := os.Create(filepath.Join(users, contID))
+	if err != nil {
+		return fmt.Errorf("could not record image user: %v", err)
+	}
+	return f.Close()
+}
+
+func (l *LowerDirs) rootfsPath(imageID string) string {
+	return filepath.Join(l.baseDir, imageID, lowerRootfsPath)
+}
+
+func (l *LowerDirs) usersPath(imageID string) string {
+	return filepath.Join(l.baseDir, imageID, lowerUsersPath)
+}
+
+// ensureMounted calls mount unless image is already mounted.
+func (d *lowerDir) ensureMounted(mount func() error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.mounted {
+		return nil
+	}
+	if err := mount(); err != nil {
+		return err
+	}
+	d.mounted = true
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	simage "github.com/sylabs/singularity/pkg/image"
+	"github.com/sylabs/singularity/pkg/ocibundle/tools"
+	"golang.org/x/sys/unix"
+)
+
+// cryptsetup is a tool encrypted image partitions are opened with.
+const cryptsetup = "cryptsetup"
+
+// mountLower mounts root filesystem partition of SIF image read-only at dir
+// with nosuid and nodev options. Encrypted partition is opened as a device
+// mapper target with key first, see openCrypt.
+func mountLower(imagePath, dir string, key []byte) error {
+	img, err := simage.Init(imagePath, false)
+	if err != nil {
+		return fmt.Errorf("could not load SIF image %s: %v", imagePath, err)
+	}
+	defer img.File.Close()
+
+	if img.Type != simage.SIF {
+		return fmt.Errorf("%s is not a SIF image", imagePath)
+	}
+	if !img.HasRootFs() {
+		return fmt.Errorf("no root filesystem found in SIF %s", imagePath)
+	}
+	encrypted := img.Partitions[0].Type == simage.ENCRYPTSQUASHFS
+	if img.Partitions[0].Type != simage.SQUASHFS && !encrypted {
+		return fmt.Errorf("unsupported image fs type: %v", img.Partitions[0].Type)
+	}
+	if encrypted && key == nil {
+		return fmt.Errorf("SIF %s is encrypted, no key provided", imagePath)
+	}
+
+	loop, err := tools.CreateLoop(img.File, img.Partitions[0].Offset, img.Partitions[0].Size)
+	if err != nil {
+		return fmt.Errorf("could not attach loop device: %v", err)
+	}
+	if encrypted {
+		loop, err = openCrypt(loop, cryptName(dir), key)
+		if err != nil {
+			return err
+		}
+	}
+	// loop device is detached automatically once unmounted; image is shared
+	// by containers, so nothing on it may be modified or act as a device
+	flags := uintptr(unix.MS_RDONLY | unix.MS_NOSUID | unix.MS_NODEV)
+	if err := unix.Mount(loop, dir, "squashfs", flags, "errors=remount-ro"); err != nil {
+		if encrypted {
+			closeCrypt(cryptName(dir))
+		}
+		return fmt.Errorf("could not mount SIF partition: %v", err)
+	}
+	return nil
+}
+
+// unmountLower unmounts image root filesystem. Lazy unmount is used so that
+// containers that were not cleaned up properly keep their root filesystem.
+// Device mapper target of encrypted image is removed once it is unused.
+func unmountLower(dir string) error {
+	err := unix.Unmount(dir, unix.MNT_DETACH)
+	if err != nil && err != unix.EINVAL && err != unix.ENOENT {
+		return fmt.Errorf("could not unmount %s: %v", dir, err)
+	}
+	name := cryptName(dir)
+	if _, err := os.Stat(filepath.Join("/dev/mapper", name)); err == nil {
+		return closeCrypt(name)
+	}
+	return nil
+}
+
+// checkLowerKey checks that key opens encrypted partition of SIF image
+// without setting up a device mapper target.
+func checkLowerKey(imagePath string, key []byte) error {
+	img, err := simage.Init(imagePath, false)
+	if err != nil {
+		return fmt.Errorf("could not load SIF image %s: %v", imagePath, err)
+	}
+	defer img.File.Close()
+
+	if !img.HasRootFs() || img.Partitions[0].Type != simage.ENCRYPTSQUASHFS {
+		return nil
+	}
+	loop, err := tools.CreateLoop(img.File, img.Partitions[0].Offset, img.Partitions[0].Size)
+	if err != nil {
+		return fmt.Errorf("could not attach loop device: %v", err)
+	}
+	if err := runCryptsetup(key, "open", "--test-passphrase", "--key-file=-", loop); err != nil {
+		return fmt.Errorf("invalid image key: %v", err)
+	}
+	return nil
+}
+
+// cryptName returns name of device mapper target encrypted image mounted
+// at lower directory dir is opened as. Lower directories are named after
+// images, so the name is unique.
+func cryptName(dir string) string {
+	return "sycri-" + filepath.Base(filepath.Dir(dir))
+}
+
+// openCrypt opens encrypted device as a read-only device mapper target
+// with the passed name and returns path to the decrypted device. Key is
+// passed to cryptsetup through a pipe so that it never reaches disk.
+func openCrypt(device, name string, key []byte) (string, error) {
+	if err := runCryptsetup(key, "open", "--readonly", "--key-file=-", device, name); err != nil {
+		return "", fmt.Errorf("could not open encrypted partition: %v", err)
+	}
+	return filepath.Join("/dev/mapper", name), nil
+}
+
+// closeCrypt removes device mapper target with the passed name. Removal is
+// deferred until lazily unmounted filesystem releases the device.
+func closeCrypt(name string) error {
+	if err := runCryptsetup(nil, "close", "--deferred", name); err != nil {
+		return fmt.Errorf("could not close encrypted partition: %v", err)
+	}
+	return nil
+}
+
+func runCryptsetup(key []byte, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(cryptsetup, args...)
+	cmd.Stdin = bytes.NewReader(key)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// isMountPoint checks whether path is a mount point.
+func isMountPoint(path string) bool {
+	var st, parent unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return false
+	}
+	if err := unix.Stat(filepath.Dir(path), &parent); err != nil {
+		return false
+	}
+	return st.Dev != parent.Dev
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMounts counts mounts of image files instead of mounting them.
+type fakeMounts struct {
+	mu      sync.Mutex
+	mounted map[string]bool
+	mounts  int32
+	fail    bool
+	t       *testing.T
+}
+
+// fakeKey is the only key fake encrypted images are opened with.
+const fakeKey = "secret"
+
+func (f *fakeMounts) mount(imagePath, dir string, key []byte) error {
+	time.Sleep(time.Millisecond)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail {
+		return fmt.Errorf("mount failed")
+	}
+	if err := f.checkKey(imagePath, key); err != nil {
+		return err
+	}
+	if f.mounted[dir] {
+		f.t.Errorf("%s is mounted twice", dir)
+	}
+	f.mounted[dir] = true
+	atomic.AddInt32(&f.mounts, 1)
+	return nil
+}
+
+func (f *fakeMounts) checkKey(imagePath string, key []byte) error {
+	if strings.HasSuffix(imagePath, ".enc.sif") && string(key) != fakeKey {
+		return fmt.Errorf("invalid image key")
+	}
+	return nil
+}
+
+func (f *fakeMounts) unmount(dir string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.mounted[dir] {
+		f.t.Errorf("%s is not mounted", dir)
+	}
+	delete(f.mounted, dir)
+	return nil
+}
+
+func (f *fakeMounts) isMounted(dir string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.mounted[dir]
+}
+
+func newTestLowerDirs(t *testing.T, dir string, grace time.Duration) (*LowerDirs, *fakeMounts) {
+	l, err := NewLowerDirs(dir, grace)
+	require.NoError(t, err, "could not create lower dirs")
+	f := &fakeMounts{mounted: make(map[string]bool), t: t}
+	l.mount = f.mount
+	l.unmount = f.unmount
+	l.unpack = func(layoutPath, dir string) error {
+		return f.mount(layoutPath, dir, nil)
+	}
+	l.checkKey = f.checkKey
+	return l, f
+}
+
+func TestLowerDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lower-test-")
+	require.NoError(t, err, "could not create temp directory")
+	defer os.RemoveAll(dir)
+
+	l, f := newTestLowerDirs(t, dir, 50*time.Millisecond)
+
+	lower, err := l.Acquire("busybox", "/images/busybox.sif", "cont1", nil)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "busybox", "rootfs"), lower)
+	same, err := l.Acquire("busybox", "/images/busybox.sif", "cont2", nil)
+	require.NoError(t, err)
+	require.Equal(t, lower, same)
+	_, err = l.Acquire("busybox", "/images/busybox.sif", "cont2", nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, l.Users("busybox"))
+	require.EqualValues(t, 1, f.mounts, "image must be mounted once")
+
+	l.Release("busybox", "cont1")
+	l.Release("busybox", "unknown")
+	require.Equal(t, 1, l.Users("busybox"))
+	l.Release("busybox", "cont2")
+	require.Equal(t, 0, l.Users("busybox"))
+	require.True(t, f.isMounted(lower), "image must stay mounted during grace period")
+
+	// acquire during grace period reuses mount
+	_, err = l.Acquire("busybox", "/images/busybox.sif", "cont3", nil)
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	require.True(t, f.isMounted(lower), "acquired image must not be unmounted")
+	require.EqualValues(t, 1, f.mounts)
+
+	l.Release("busybox", "cont3")
+	time.Sleep(100 * time.Millisecond)
+	require.False(t, f.isMounted(lower), "image must be unmounted after grace period")
+	_, err = os.Stat(filepath.Join(dir, "busybox"))
+	require.True(t, os.IsNotExist(err), "lower directory must be removed")
+
+	f.fail = true
+	_, err = l.Acquire("alpine", "/images/alpine.sif", "cont4", nil)
+	require.Error(t, err)
+	require.Equal(t, 0, l.Users("alpine"))
+}
+
+func TestLowerDirs_Encrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lower-test-")
+	require.NoError(t, err, "could not create temp directory")
+	defer os.RemoveAll(dir)
+
+	l, f := newTestLowerDirs(t, dir, time.Hour)
+	_, err = l.Acquire("model", "/images/model.enc.sif", "cont1", []byte("wrong"))
+	require.Error(t, err)
+	require.Equal(t, 0, l.Users("model"))
+
+	lower, err := l.Acquire("model", "/images/model.enc.sif", "cont1", []byte(fakeKey))
+	require.NoError(t, err)
+	require.True(t, f.isMounted(lower))
+
+	// mounted image is shared with key holders only
+	_, err = l.Acquire("model", "/images/model.enc.sif", "cont2", []byte("wrong"))
+	require.Error(t, err)
+	_, err = l.Acquire("model", "/images/model.enc.sif", "cont3", []byte(fakeKey))
+	require.NoError(t, err)
+	require.Equal(t, 2, l.Users("model"))
+	require.EqualValues(t, 1, f.mounts, "image must be mounted once")
+}
+
+func TestLowerDirs_Drop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lower-test-")
+	require.NoError(t, err, "could not create temp directory")
+	defer os.RemoveAll(dir)
+
+	l, f := newTestLowerDirs(t, dir, time.Hour)
+	require.True(t, l.Drop("unknown"))
+
+	lower, err := l.Acquire("busybox", "/images/busybox.sif", "cont1", nil)
+	require.NoError(t, err)
+	require.False(t, l.Drop("busybox"), "used image must not be dropped")
+	require.True(t, f.isMounted(lower))
+
+	l.Release("busybox", "cont1")
+	require.True(t, f.isMounted(lower), "image must stay mounted during grace period")
+	require.True(t, l.Drop("busybox"))
+	require.False(t, f.isMounted(lower), "dropped image must be unmounted")
+	_, err = os.Stat(filepath.Join(dir, "busybox"))
+	require.True(t, os.IsNotExist(err), "lower directory must be removed")
+}
+
+func TestLowerDirs_Restore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lower-test-")
+	require.NoError(t, err, "could not create temp directory")
+	defer os.RemoveAll(dir)
+
+	l, _ := newTestLowerDirs(t, dir, time.Hour)
+	for _, cont := range []string{"cont1", "cont2"} {
+		_, err := l.Acquire("busybox", "/images/busybox.sif", cont, nil)
+		require.NoError(t, err)
+	}
+	_, err = l.Acquire("alpine", "/images/alpine.sif", "cont3", nil)
+	require.NoError(t, err)
+	l.Release("alpine", "cont3")
+
+	restored, _ := newTestLowerDirs(t, dir, time.Hour)
+	require.Equal(t, 2, restored.Users("busybox"))
+	require.Equal(t, 0, restored.Users("alpine"))
+
+	restored.Release("busybox", "cont1")
+	require.Equal(t, 1, restored.Users("busybox"))
+	restored.Flush()
+	_, err = os.Stat(filepath.Join(dir, "alpine"))
+	require.True(t, os.IsNotExist(err), "unused lower directory must be removed")
+	_, err = os.Stat(filepath.Join(dir, "busybox", "users", "cont2"))
+	require.NoError(t, err, "image user must be kept")
+}
+
+func TestLowerDirs_Unpack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lower-test-")
+	require.NoError(t, err, "could not create temp directory")
+	defer os.RemoveAll(dir)
+	layout := filepath.Join(dir, "layout")
+	require.NoError(t, os.Mkdir(layout, 0755))
+
+	l, f := newTestLowerDirs(t, filepath.Join(dir, "lower"), time.Hour)
+	lower, err := l.Acquire("busybox", layout, "cont1", nil)
+	require.NoError(t, err)
+	require.True(t, f.isMounted(lower), "layout must be unpacked")
+	_, err = os.Stat(filepath.Join(dir, "lower", "busybox", lowerUnpackedPath))
+	require.NoError(t, err, "unpacked image must be marked")
+
+	l.Release("busybox", "cont1")
+	require.True(t, l.Drop("busybox"))
+	require.False(t, f.isMounted(lower))
+	_, err = os.Stat(filepath.Join(dir, "lower", "busybox"))
+	require.True(t, os.IsNotExist(err), "unpacked root filesystem must be removed")
+
+	// unpacked root filesystem is not a mount point, but is reused after restart
+	_, err = l.Acquire("busybox", layout, "cont2", nil)
+	require.NoError(t, err)
+	restored, rf := newTestLowerDirs(t, filepath.Join(dir, "lower"), time.Hour)
+	_, err = restored.Acquire("busybox", layout, "cont3", nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, atomic.LoadInt32(&rf.mounts), "restored layout must not be unpacked again")
+	require.Equal(t, 2, restored.Users("busybox"))
+}
+
+func TestLowerDirs_Stress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lower-test-")
+	require.NoError(t, err, "could not create temp directory")
+	defer os.RemoveAll(dir)
+
+	l, f := newTestLowerDirs(t, dir, time.Millisecond)
+
+	images := []string{"busybox", "alpine"}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				imageID := images[(i+j)%len(images)]
+				cont := fmt.Sprintf("cont-%d-%d", i, j)
+				lower, err := l.Acquire(imageID, "/images/"+imageID+".sif", cont, nil)
+				if err != nil {
+					t.Errorf("could not acquire %s: %v", imageID, err)
+					return
+				}
+				if !f.isMounted(lower) {
+					t.Errorf("acquired %s is not mounted", imageID)
+				}
+				l.Release(imageID, cont)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, imageID := range images {
+		require.Equal(t, 0, l.Users(imageID))
+	}
+	l.Flush()
+	require.Empty(t, f.mounted, "all images must be unmounted")
+}
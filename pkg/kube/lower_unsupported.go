@@ -0,0 +1,42 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux
+
+package kube
+
+// mountLower returns ErrNotSupported.
+func mountLower(imagePath, dir string, key []byte) error {
+	return ErrNotSupported
+}
+
+// unmountLower returns ErrNotSupported.
+func unmountLower(dir string) error {
+	return ErrNotSupported
+}
+
+// checkLowerKey returns ErrNotSupported.
+func checkLowerKey(imagePath string, key []byte) error {
+	return ErrNotSupported
+}
+
+// isMountPoint always returns false.
+func isMountPoint(path string) bool {
+	return false
+}
+
+// unpackLayout returns ErrNotSupported.
+func unpackLayout(layoutPath, dir string) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,408 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity/pkg/ociruntime"
+	"github.com/sylabs/singularity/pkg/util/unix"
+)
+
+const (
+	// MonitorCommand is a subcommand of CRI executable that runs
+	// container monitor, see RunMonitor.
+	MonitorCommand = "monitor"
+
+	contMonitorConfigPath = "monitor.json"
+	contMonitorSocketPath = "monitor.sock"
+	contMonitorOutputPath = "output.sock"
+	contMonitorPidPath    = "monitor.pid"
+	contMonitorLogPath    = "monitor.log"
+	contMonitorExitPath   = "exit.json"
+
+	// monitorReady is written by monitor once it is ready to supervise container.
+	monitorReady = "ready\n"
+	// monitorStartTimeout is time monitor is given to get ready.
+	monitorStartTimeout = 10 * time.Second
+)
+
+// WithMonitor makes containers supervised by a monitor process run from
+// the executable at path with MonitorCommand, see RunMonitor. Monitor
+// outlives the daemon, so that container output is written and container
+// exit is recorded while the daemon is down. By default container output
+// and state changes are handled by the daemon itself.
+func WithMonitor(path string) ContainerOption {
+	return func(c *Container) {
+		c.monitorPath = path
+	}
+}
+
+// monitorConfig is passed by the daemon to container monitor.
+type monitorConfig struct {
+	ID string `json:"id"`
+	// Engine is a command of Singularity engine monitor
+	// queries exit status with, empty for the fake engine.
+	Engine []string `json:"engine,omitempty"`
+	// LogPipe is set when engine writes container
+	// output to the log pipe for monitor to forward.
+	LogPipe     bool              `json:"logPipe,omitempty"`
+	LogPath     string            `json:"logPath,omitempty"`
+	LogRotation LogRotation       `json:"logRotation"`
+	Journal     map[string]string `json:"journal,omitempty"`
+	BufferSize  int               `json:"bufferSize,omitempty"`
+	Overflow    LogOverflow       `json:"overflow,omitempty"`
+}
+
+// monitorExit is container exit status recorded by monitor.
+type monitorExit struct {
+	ExitCode   *int   `json:"exitCode,omitempty"`
+	ExitDesc   string `json:"exitDesc,omitempty"`
+	FinishedAt int64  `json:"finishedAt"`
+}
+
+// RunMonitor supervises container whose files are located in dir until it
+// exits or monitor is terminated. Monitor relays state changes engine reports
+// to the daemon, records container exit status and forwards container output
+// to its log file and journald when the daemon would do so. Daemon receives
+// the same output on monitor output socket. SIGHUP makes monitor reopen
+// container log file. Once monitor is ready monitorReady is written to ready,
+// which is closed then.
+func RunMonitor(dir string, ready io.WriteCloser) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, contMonitorConfigPath))
+	if err != nil {
+		return fmt.Errorf("could not read monitor config: %v", err)
+	}
+	var config monitorConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("could not decode monitor config: %v", err)
+	}
+
+	pidPath := filepath.Join(dir, contMonitorPidPath)
+	if err := fs.WriteFileAtomic(pidPath, []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+		return fmt.Errorf("could not write monitor pid: %v", err)
+	}
+	defer os.Remove(pidPath)
+
+	syncChan, syncCancel, err := observeState(filepath.Join(dir, contMonitorSocketPath))
+	if err != nil {
+		return err
+	}
+	defer syncCancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	var fwd *logForwarder
+	var fileWriter *criFileWriter
+	if config.LogPipe {
+		fwd, fileWriter, err = serveMonitorOutput(dir, &config)
+		if err != nil {
+			return err
+		}
+		defer fwd.stop()
+	}
+
+	if _, err := io.WriteString(ready, monitorReady); err != nil {
+		return fmt.Errorf("could not report monitor is ready: %v", err)
+	}
+	ready.Close()
+
+	notifySocket := filepath.Join(dir, contSocketPath)
+	for {
+		select {
+		case state, ok := <-syncChan:
+			if !ok {
+				glog.Warningf("Container %s state is not observed anymore", config.ID)
+				return nil
+			}
+			// daemon may be down, it reconciles state with the engine on restart
+			if err := runtime.NotifyState(notifySocket, state); err != nil {
+				glog.V(4).Infof("Could not relay container %s state %v: %v", config.ID, state, err)
+			}
+			if state == runtime.StateExited {
+				recordMonitorExit(dir, &config)
+				return nil
+			}
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				glog.V(3).Infof("Container %s monitor received %v, stopping", config.ID, sig)
+				return nil
+			}
+			if fileWriter == nil {
+				continue
+			}
+			if err := fileWriter.reopen(); err != nil {
+				glog.Errorf("Could not reopen container %s log file: %v", config.ID, err)
+			}
+		}
+	}
+}
+
+// serveMonitorOutput forwards container output engine writes to the log pipe
+// in dir and serves it to clients of monitor output socket as is.
+func serveMonitorOutput(dir string, config *monitorConfig) (*logForwarder, *criFileWriter, error) {
+	pipe, err := os.OpenFile(filepath.Join(dir, contLogPipePath), os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open log pipe: %v", err)
+	}
+	var journal []journalField
+	for name, value := range config.Journal {
+		journal = append(journal, journalField{name, value})
+	}
+	sort.Slice(journal, func(i, j int) bool { return journal[i].name < journal[j].name })
+	writers, fileWriter, err := newOutputWriters(config.LogPath, config.LogRotation, journal)
+	if err != nil {
+		pipe.Close()
+		return nil, nil, err
+	}
+
+	outputPath := filepath.Join(dir, contMonitorOutputPath)
+	if err := os.Remove(outputPath); err != nil && !os.IsNotExist(err) {
+		pipe.Close()
+		return nil, nil, fmt.Errorf("could not remove stale output socket: %v", err)
+	}
+	ln, err := unix.Listen(outputPath)
+	if err != nil {
+		pipe.Close()
+		return nil, nil, fmt.Errorf("could not listen output socket: %v", err)
+	}
+
+	fwd := newLogForwarder(config.ID, pipe, config.BufferSize, config.Overflow, writers...)
+	go fwd.run()
+	go func() {
+		<-fwd.done
+		ln.Close()
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// daemon must never slow container down
+			fwd.addSink(&rawWriter{conn: conn}, true)
+		}
+	}()
+	return fwd, fileWriter, nil
+}
+
+// rawWriter passes CRI log lines to the daemon as is.
+type rawWriter struct {
+	conn net.Conn
+}
+
+func (w *rawWriter) WriteEntry(e *logEntry) error {
+	_, err := w.conn.Write(e.raw)
+	return err
+}
+
+func (w *rawWriter) Close() error {
+	return w.conn.Close()
+}
+
+// recordMonitorExit records exit status of the container. Exit code
+// is known only when monitor is able to query the engine.
+func recordMonitorExit(dir string, config *monitorConfig) {
+	exit := monitorExit{FinishedAt: time.Now().UnixNano()}
+	if len(config.Engine) != 0 {
+		state, err := runtime.NewCLIClientWithCommand(config.Engine).State(config.ID)
+		if err != nil {
+			glog.Errorf("Could not get container %s exit status: %v", config.ID, err)
+		} else {
+			exit.ExitCode = state.ExitCode
+			exit.ExitDesc = state.ExitDesc
+			if state.FinishedAt != nil {
+				exit.FinishedAt = *state.FinishedAt
+			}
+		}
+	}
+	data, err := json.Marshal(exit)
+	if err == nil {
+		err = fs.WriteFileAtomic(filepath.Join(dir, contMonitorExitPath), data, 0600)
+	}
+	if err != nil {
+		glog.Errorf("Could not record container %s exit: %v", config.ID, err)
+	}
+}
+
+// readMonitorExit returns exit status recorded by monitor in dir,
+// nil is returned if there is none.
+func readMonitorExit(dir string) (*monitorExit, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, contMonitorExitPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	exit := new(monitorExit)
+	if err := json.Unmarshal(data, exit); err != nil {
+		return nil, err
+	}
+	return exit, nil
+}
+
+// startMonitor runs container monitor that relays engine state changes to the
+// container sync socket. Engine must report state changes to monitor socket.
+// Container output, if forwarded, is read from monitor from now on.
+func (c *Container) startMonitor() error {
+	config := monitorConfig{ID: c.id}
+	if cli, ok := c.cli.(*runtime.CLIClient); ok {
+		config.Engine = cli.Command()
+	}
+	if c.forwardsOutput() {
+		config.LogPipe = true
+		config.LogPath = c.logPath
+		config.LogRotation = c.logRotation
+		config.BufferSize, config.Overflow = c.logBufferConfig()
+		if c.logDriver == LogDriverJournald {
+			config.Journal = make(map[string]string)
+			for _, f := range c.journalFields() {
+				config.Journal[f.name] = f.value
+			}
+		}
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("could not encode monitor config: %v", err)
+	}
+	if err := fs.WriteFileAtomic(filepath.Join(c.baseDir, contMonitorConfigPath), data, contOCIConfigPerm); err != nil {
+		return fmt.Errorf("could not write monitor config: %v", err)
+	}
+
+	logPath := filepath.Join(c.baseDir, contMonitorLogPath)
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("could not create monitor log: %v", err)
+	}
+	defer logFile.Close()
+
+	glog.V(3).Infof("Starting monitor of container %s", c.id)
+	cmd := exec.Command(c.monitorPath, MonitorCommand, c.baseDir)
+	cmd.Dir = "/"
+	cmd.Stderr = logFile
+	// monitor must not be killed along with the daemon
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("could not create monitor pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start monitor: %v", err)
+	}
+	readyChan := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(out).ReadString('\n')
+		if err == nil && line != monitorReady {
+			err = fmt.Errorf("unexpected output %q", line)
+		}
+		readyChan <- err
+	}()
+	select {
+	case err = <-readyChan:
+	case <-time.After(monitorStartTimeout):
+		err = fmt.Errorf("timed out")
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return fmt.Errorf("container monitor failed, see %s: %v", logPath, err)
+	}
+	// reap monitor should it exit while daemon is running
+	go cmd.Wait()
+
+	if config.LogPipe {
+		return c.forwardMonitorOutput()
+	}
+	return nil
+}
+
+// forwardMonitorOutput reads container output from monitor
+// for replay and attached clients.
+func (c *Container) forwardMonitorOutput() error {
+	conn, err := unix.Dial(filepath.Join(c.baseDir, contMonitorOutputPath))
+	if err != nil {
+		return fmt.Errorf("could not connect to monitor output socket: %v", err)
+	}
+	c.startForwarder(conn, nil)
+	return nil
+}
+
+// signalMonitor sends sig to container monitor if it is running.
+// False is returned when there is no monitor.
+func (c *Container) signalMonitor(sig syscall.Signal) (bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.baseDir, contMonitorPidPath))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not read monitor pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return false, fmt.Errorf("invalid monitor pid: %v", err)
+	}
+	if err := syscall.Kill(pid, sig); err != nil {
+		if err == syscall.ESRCH {
+			return false, nil
+		}
+		return false, fmt.Errorf("could not signal monitor: %v", err)
+	}
+	return true, nil
+}
+
+// stopMonitor terminates container monitor if it is still running.
+func (c *Container) stopMonitor() {
+	if !c.monitored {
+		return
+	}
+	if _, err := c.signalMonitor(syscall.SIGTERM); err != nil {
+		glog.Errorf("Could not stop container %s monitor: %v", c.id, err)
+	}
+}
+
+// restoreExit makes container that engine doesn't know anymore exited
+// with status recorded by its monitor. Such container is reclaimed.
+func (c *Container) restoreExit(exit *monitorExit) {
+	c.ociState = &ociruntime.State{
+		State:      specs.State{ID: c.id, Status: runtime.StateToStatus(runtime.StateExited)},
+		ExitCode:   exit.ExitCode,
+		ExitDesc:   exit.ExitDesc,
+		FinishedAt: &exit.FinishedAt,
+	}
+	c.runtimeState = runtime.StateExited
+	c.recordTransition()
+	c.isReclaimed = true
+}
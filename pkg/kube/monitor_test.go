@@ -0,0 +1,103 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity/pkg/util/unix"
+)
+
+func TestRunMonitor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monitor-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "container.log")
+	data, err := json.Marshal(monitorConfig{
+		ID:         "test",
+		LogPipe:    true,
+		LogPath:    logPath,
+		BufferSize: MinLogBufferSize,
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, contMonitorConfigPath), data, 0600))
+	require.NoError(t, syscall.Mkfifo(filepath.Join(dir, contLogPipePath), 0600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	syncChan, err := runtime.ObserveState(ctx, filepath.Join(dir, contSocketPath))
+	require.NoError(t, err)
+
+	readyR, readyW, err := os.Pipe()
+	require.NoError(t, err)
+	defer readyR.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- RunMonitor(dir, readyW)
+	}()
+	ready, err := ioutil.ReadAll(readyR)
+	require.NoError(t, err)
+	require.Equal(t, monitorReady, string(ready))
+	_, err = os.Stat(filepath.Join(dir, contMonitorPidPath))
+	require.NoError(t, err, "monitor must record its pid")
+
+	output, err := unix.Dial(filepath.Join(dir, contMonitorOutputPath))
+	require.NoError(t, err)
+	defer output.Close()
+	pipe, err := os.OpenFile(filepath.Join(dir, contLogPipePath), os.O_WRONLY, 0)
+	require.NoError(t, err)
+	defer pipe.Close()
+	// output written before monitor accepts connection is not received,
+	// so line is written until it is
+	const line = "2019-01-01T00:00:00Z stdout F hello\n"
+	reader := bufio.NewReader(output)
+	var received string
+	for i := 0; i < 50 && received == ""; i++ {
+		_, err = pipe.Write([]byte(line))
+		require.NoError(t, err)
+		require.NoError(t, output.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+		received, _ = reader.ReadString('\n')
+	}
+	require.Equal(t, line, received, "daemon must receive output as is")
+
+	monitorSocket := filepath.Join(dir, contMonitorSocketPath)
+	require.NoError(t, runtime.NotifyState(monitorSocket, runtime.StateRunning))
+	require.Equal(t, runtime.StateRunning, <-syncChan)
+	require.NoError(t, runtime.NotifyState(monitorSocket, runtime.StateExited))
+	require.Equal(t, runtime.StateExited, <-syncChan)
+	require.NoError(t, <-done)
+
+	logged, err := ioutil.ReadFile(logPath)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(logged), line), "monitor must write log file")
+	exit, err := readMonitorExit(dir)
+	require.NoError(t, err)
+	require.NotNil(t, exit, "monitor must record container exit")
+	require.NotZero(t, exit.FinishedAt)
+	_, err = os.Stat(filepath.Join(dir, contMonitorPidPath))
+	require.True(t, os.IsNotExist(err), "pid file must be removed on exit")
+}
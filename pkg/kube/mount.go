@@ -0,0 +1,151 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinks limits number of symlinks followed while resolving a single path.
+const maxSymlinks = 255
+
+// DefaultAllowedHostPaths is a list of host path prefixes kubelet
+// uses for volumes and per-pod files, e.g. etc-hosts and termination logs.
+var DefaultAllowedHostPaths = []string{
+	"/var/lib/kubelet",
+	"/var/log/pods",
+	"/var/log/containers",
+}
+
+// MountPolicy restricts host paths that may be bind mounted into containers.
+type MountPolicy struct {
+	// AllowedPrefixes lists host path prefixes mount sources must
+	// resolve into. Empty list allows any host path.
+	AllowedPrefixes []string
+	// PrivilegedBypass allows privileged containers to mount any host path.
+	PrivilegedBypass bool
+}
+
+// MountError is returned when requested mount violates mount policy.
+type MountError struct {
+	HostPath string
+	Reason   string
+}
+
+func (e *MountError) Error() string {
+	return fmt.Sprintf("mount of %s is not allowed: %s", e.HostPath, e.Reason)
+}
+
+// resolveSource resolves host path following symlinks component by component.
+// Absent trailing components are appended to the resolved existing part as is,
+// so that they may be created later without following any unexpected symlink.
+func resolveSource(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("path %s is not absolute", path)
+	}
+
+	var (
+		resolved = "/"
+		links    = 0
+	)
+	rest := strings.Split(filepath.Clean(path), "/")
+	for len(rest) > 0 {
+		part := rest[0]
+		rest = rest[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			return filepath.Join(append([]string{next}, rest...)...), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not stat %s: %v", next, err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks in %s", path)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", fmt.Errorf("could not read link %s: %v", next, err)
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return resolved, nil
+}
+
+// ResolveHostPath resolves host path following symlinks the same way mount
+// sources are resolved, so that policies see the path actually mounted.
+func ResolveHostPath(path string) (string, error) {
+	return resolveSource(path)
+}
+
+// checkSource resolves mount source and checks it against the policy.
+// Allowed prefixes are resolved as well so that symlinked kubelet
+// directories keep working.
+func (p *MountPolicy) checkSource(hostPath string, privileged bool) (string, error) {
+	source, err := resolveSource(hostPath)
+	if err != nil {
+		return "", &MountError{HostPath: hostPath, Reason: err.Error()}
+	}
+	if p == nil || len(p.AllowedPrefixes) == 0 || (privileged && p.PrivilegedBypass) {
+		return source, nil
+	}
+	for _, prefix := range p.AllowedPrefixes {
+		resolvedPrefix, err := resolveSource(prefix)
+		if err != nil {
+			continue
+		}
+		if isWithin(resolvedPrefix, source) {
+			return source, nil
+		}
+	}
+	return "", &MountError{
+		HostPath: hostPath,
+		Reason:   fmt.Sprintf("%s is outside of allowed host paths", source),
+	}
+}
+
+// cleanDestination validates container path of a mount so that
+// it always stays inside container rootfs.
+func cleanDestination(containerPath string) (string, error) {
+	if !filepath.IsAbs(containerPath) {
+		return "", fmt.Errorf("container path %q is not absolute", containerPath)
+	}
+	for _, part := range strings.Split(containerPath, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("container path %q must not contain ..", containerPath)
+		}
+	}
+	return filepath.Clean(containerPath), nil
+}
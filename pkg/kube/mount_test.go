@@ -0,0 +1,155 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mount-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+
+	kubelet := filepath.Join(dir, "kubelet")
+	require.NoError(t, os.MkdirAll(filepath.Join(kubelet, "pods"), 0755))
+	require.NoError(t, os.Symlink("/etc", filepath.Join(kubelet, "pods", "escape")))
+	require.NoError(t, os.Symlink("../pods", filepath.Join(kubelet, "pods", "relative")))
+	require.NoError(t, os.Symlink("loop", filepath.Join(kubelet, "loop")))
+
+	tt := []struct {
+		name      string
+		path      string
+		expect    string
+		expectErr bool
+	}{
+		{
+			name:   "plain path",
+			path:   filepath.Join(kubelet, "pods"),
+			expect: filepath.Join(kubelet, "pods"),
+		},
+		{
+			name:   "absolute symlink",
+			path:   filepath.Join(kubelet, "pods", "escape", "passwd"),
+			expect: "/etc/passwd",
+		},
+		{
+			name:   "relative symlink",
+			path:   filepath.Join(kubelet, "pods", "relative", "relative"),
+			expect: filepath.Join(kubelet, "pods"),
+		},
+		{
+			name:   "missing tail",
+			path:   filepath.Join(kubelet, "pods", "new", "volume"),
+			expect: filepath.Join(kubelet, "pods", "new", "volume"),
+		},
+		{
+			name:   "dot dot",
+			path:   filepath.Join(kubelet, "pods") + "/../../../../../../etc",
+			expect: "/etc",
+		},
+		{
+			name:      "symlink loop",
+			path:      filepath.Join(kubelet, "loop"),
+			expectErr: true,
+		},
+		{
+			name:      "relative path",
+			path:      "kubelet/pods",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := resolveSource(tc.path)
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, actual)
+		})
+	}
+
+	t.Run("policy", func(t *testing.T) {
+		policy := &MountPolicy{AllowedPrefixes: []string{kubelet}, PrivilegedBypass: true}
+
+		source, err := policy.checkSource(filepath.Join(kubelet, "pods", "relative"), false)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(kubelet, "pods"), source)
+
+		_, err = policy.checkSource(filepath.Join(kubelet, "pods", "escape"), false)
+		require.IsType(t, &MountError{}, err)
+
+		source, err = policy.checkSource(filepath.Join(kubelet, "pods", "escape"), true)
+		require.NoError(t, err)
+		require.Equal(t, "/etc", source)
+
+		var noPolicy *MountPolicy
+		source, err = noPolicy.checkSource("/etc", false)
+		require.NoError(t, err)
+		require.Equal(t, "/etc", source)
+	})
+}
+
+func TestCleanDestination(t *testing.T) {
+	tt := []struct {
+		name      string
+		path      string
+		expect    string
+		expectErr bool
+	}{
+		{
+			name:   "clean path",
+			path:   "/data",
+			expect: "/data",
+		},
+		{
+			name:   "redundant separators",
+			path:   "/data//./volume/",
+			expect: "/data/volume",
+		},
+		{
+			name:      "relative path",
+			path:      "data",
+			expectErr: true,
+		},
+		{
+			name:      "parent reference",
+			path:      "/data/../../etc",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := cleanDestination(tc.path)
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, actual)
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AnnotationNetFwmark is a pod annotation that sets firewall mark on all
+	// traffic originating from the pod, in form of value[/mask], e.g. 0x10/0xff.
+	AnnotationNetFwmark = "singularity.cri/net-fwmark"
+	// AnnotationNetConnmark is a pod annotation that sets connection mark on all
+	// connections originating from the pod, in form of value[/mask].
+	AnnotationNetConnmark = "singularity.cri/net-connmark"
+	// AnnotationNetClassID is a pod or container annotation that sets net_cls
+	// class ID of container cgroup in tc major:minor hex form, e.g. 10:1, or as
+	// a single number. Container annotation takes precedence. Class ID is set
+	// only on hosts with cgroup v1 net_cls controller mounted.
+	AnnotationNetClassID = "singularity.cri/net-cls-classid"
+
+	// netQoSChain is a mangle table chain in pod network namespace
+	// that holds all marking rules so that they are removed at once.
+	netQoSChain = "SYCRI-QOS"
+)
+
+// netClsMounted is a variable so that tests may override it.
+var netClsMounted = isNetClsMounted
+
+// NetMark is a firewall or connection mark with a mask of bits to set.
+type NetMark struct {
+	Value uint32
+	Mask  uint32
+}
+
+// String returns mark in iptables value/mask form.
+func (m NetMark) String() string {
+	return fmt.Sprintf("0x%x/0x%x", m.Value, m.Mask)
+}
+
+// NetQoS holds pod traffic marking requested by annotations.
+type NetQoS struct {
+	Fwmark   *NetMark
+	Connmark *NetMark
+}
+
+// ParseNetQoS parses traffic marks from the passed pod annotations.
+// When no marks are requested nil is returned.
+func ParseNetQoS(annotations map[string]string) (*NetQoS, error) {
+	var qos NetQoS
+	var err error
+	if value, ok := annotations[AnnotationNetFwmark]; ok {
+		qos.Fwmark, err = parseNetMark(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %v", AnnotationNetFwmark, err)
+		}
+	}
+	if value, ok := annotations[AnnotationNetConnmark]; ok {
+		qos.Connmark, err = parseNetMark(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %v", AnnotationNetConnmark, err)
+		}
+	}
+	if qos.Fwmark == nil && qos.Connmark == nil {
+		return nil, nil
+	}
+	return &qos, nil
+}
+
+// ParseNetClassID parses net_cls class ID from the passed annotations.
+// When class ID is not set zero is returned.
+func ParseNetClassID(annotations map[string]string) (uint32, error) {
+	value, ok := annotations[AnnotationNetClassID]
+	if !ok {
+		return 0, nil
+	}
+	value = strings.TrimSpace(value)
+	var classID uint64
+	var err error
+	if i := strings.IndexByte(value, ':'); i != -1 {
+		// tc handles are hex without 0x prefix
+		var major, minor uint64
+		major, err = strconv.ParseUint(value[:i], 16, 16)
+		if err == nil {
+			minor, err = strconv.ParseUint(value[i+1:], 16, 16)
+		}
+		classID = major<<16 | minor
+	} else {
+		classID, err = strconv.ParseUint(value, 0, 32)
+	}
+	if err != nil || classID == 0 {
+		return 0, fmt.Errorf("invalid %s annotation %q: expected major:minor or non-zero number", AnnotationNetClassID, value)
+	}
+	return uint32(classID), nil
+}
+
+// ValidateNetQoS checks traffic marking annotations. Marking is refused for pods
+// in host network namespace since it would apply to all host traffic.
+func ValidateNetQoS(annotations map[string]string, hostNetwork bool) error {
+	qos, err := ParseNetQoS(annotations)
+	if err != nil {
+		return err
+	}
+	if _, err := ParseNetClassID(annotations); err != nil {
+		return err
+	}
+	if qos != nil && hostNetwork {
+		return fmt.Errorf("%s and %s annotations are not allowed for pods in host network",
+			AnnotationNetFwmark, AnnotationNetConnmark)
+	}
+	return nil
+}
+
+func parseNetMark(value string) (*NetMark, error) {
+	value = strings.TrimSpace(value)
+	mark := &NetMark{Mask: 0xffffffff}
+	parts := strings.SplitN(value, "/", 2)
+	v, err := strconv.ParseUint(parts[0], 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad mark %q", value)
+	}
+	mark.Value = uint32(v)
+	if len(parts) == 2 {
+		m, err := strconv.ParseUint(parts[1], 0, 32)
+		if err != nil || m == 0 {
+			return nil, fmt.Errorf("bad mark mask %q", value)
+		}
+		mark.Mask = uint32(m)
+	}
+	if mark.Value&^mark.Mask != 0 {
+		return nil, fmt.Errorf("mark %q sets bits outside of mask", value)
+	}
+	return mark, nil
+}
+
+// rules returns iptables rules of netQoSChain.
+func (q *NetQoS) rules() [][]string {
+	var rules [][]string
+	if q.Fwmark != nil {
+		rules = append(rules, []string{"-j", "MARK", "--set-xmark", q.Fwmark.String()})
+	}
+	if q.Connmark != nil {
+		rules = append(rules, []string{"-j", "CONNMARK", "--set-xmark", q.Connmark.String()})
+	}
+	return rules
+}
+
+// isNetClsMounted checks whether cgroup v1 net_cls controller is mounted on the host.
+func isNetClsMounted() bool {
+	mountInfo, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	defer mountInfo.Close()
+
+	mounts, err := parseCgroupMounts(mountInfo)
+	if err != nil {
+		return false
+	}
+	for _, mount := range mounts {
+		if !mount.unified && mount.options["net_cls"] {
+			return true
+		}
+	}
+	return false
+}
+
+// setUpNetQoS installs marking rules into pod network namespace.
+func (p *Pod) setUpNetQoS(nsPath string) error {
+	qos, err := ParseNetQoS(p.GetAnnotations())
+	if err != nil || qos == nil {
+		return err
+	}
+	if nsPath == "" {
+		return fmt.Errorf("traffic marking requires pod network namespace")
+	}
+	return applyNetQoS(nsPath, qos)
+}
+
+// tearDownNetQoS removes marking rules from pod network namespace.
+func (p *Pod) tearDownNetQoS(nsPath string) error {
+	qos, err := ParseNetQoS(p.GetAnnotations())
+	if err != nil || qos == nil || nsPath == "" {
+		return nil
+	}
+	return removeNetQoS(nsPath)
+}
+
+// netClassID returns net_cls class ID of the container. Container
+// annotation takes precedence over pod one.
+func (c *Container) netClassID() (uint32, error) {
+	if _, ok := c.GetAnnotations()[AnnotationNetClassID]; ok {
+		return ParseNetClassID(c.GetAnnotations())
+	}
+	return ParseNetClassID(c.pod.GetAnnotations())
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/golang/glog"
+)
+
+const mangleTable = "mangle"
+
+// applyNetQoS installs marking rules into mangle table of the network namespace
+// at nsPath. Rules are kept in netQoSChain which OUTPUT chain jumps to.
+// IPv6 rules are installed when ip6tables is available.
+func applyNetQoS(nsPath string, qos *NetQoS) error {
+	return ns.WithNetNSPath(nsPath, func(ns.NetNS) error {
+		for _, proto := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+			ipt, err := iptables.NewWithProtocol(proto)
+			if err != nil {
+				if proto == iptables.ProtocolIPv6 {
+					glog.Warningf("Skipping IPv6 traffic marking: %v", err)
+					continue
+				}
+				return fmt.Errorf("could not init iptables: %v", err)
+			}
+			// ClearChain creates chain when it does not exist
+			if err := ipt.ClearChain(mangleTable, netQoSChain); err != nil {
+				return fmt.Errorf("could not create %s chain: %v", netQoSChain, err)
+			}
+			for _, rule := range qos.rules() {
+				if err := ipt.Append(mangleTable, netQoSChain, rule...); err != nil {
+					return fmt.Errorf("could not add marking rule: %v", err)
+				}
+			}
+			if err := ipt.AppendUnique(mangleTable, "OUTPUT", "-j", netQoSChain); err != nil {
+				return fmt.Errorf("could not add %s jump: %v", netQoSChain, err)
+			}
+		}
+		return nil
+	})
+}
+
+// removeNetQoS removes marking rules from the network namespace at nsPath.
+// Missing namespace or rules are not treated as an error.
+func removeNetQoS(nsPath string) error {
+	if _, err := os.Stat(nsPath); os.IsNotExist(err) {
+		return nil
+	}
+	return ns.WithNetNSPath(nsPath, func(ns.NetNS) error {
+		for _, proto := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+			ipt, err := iptables.NewWithProtocol(proto)
+			if err != nil {
+				continue
+			}
+			chains, err := ipt.ListChains(mangleTable)
+			if err != nil {
+				return fmt.Errorf("could not list chains: %v", err)
+			}
+			found := false
+			for _, chain := range chains {
+				found = found || chain == netQoSChain
+			}
+			if !found {
+				continue
+			}
+			if err := ipt.Delete(mangleTable, "OUTPUT", "-j", netQoSChain); err != nil {
+				glog.Warningf("Could not remove %s jump: %v", netQoSChain, err)
+			}
+			if err := ipt.ClearChain(mangleTable, netQoSChain); err != nil {
+				return fmt.Errorf("could not flush %s chain: %v", netQoSChain, err)
+			}
+			if err := ipt.DeleteChain(mangleTable, netQoSChain); err != nil {
+				return fmt.Errorf("could not delete %s chain: %v", netQoSChain, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetQoS(t *testing.T) {
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		expectQoS   *NetQoS
+		expectRules [][]string
+		expectError bool
+	}{
+		{
+			name: "no marks",
+		},
+		{
+			name:        "fwmark without mask",
+			annotations: map[string]string{AnnotationNetFwmark: "16"},
+			expectQoS:   &NetQoS{Fwmark: &NetMark{Value: 0x10, Mask: 0xffffffff}},
+			expectRules: [][]string{{"-j", "MARK", "--set-xmark", "0x10/0xffffffff"}},
+		},
+		{
+			name: "both marks",
+			annotations: map[string]string{
+				AnnotationNetFwmark:   "0x10/0xff",
+				AnnotationNetConnmark: "0x100/0xf00",
+			},
+			expectQoS: &NetQoS{
+				Fwmark:   &NetMark{Value: 0x10, Mask: 0xff},
+				Connmark: &NetMark{Value: 0x100, Mask: 0xf00},
+			},
+			expectRules: [][]string{
+				{"-j", "MARK", "--set-xmark", "0x10/0xff"},
+				{"-j", "CONNMARK", "--set-xmark", "0x100/0xf00"},
+			},
+		},
+		{
+			name:        "not a number",
+			annotations: map[string]string{AnnotationNetFwmark: "high"},
+			expectError: true,
+		},
+		{
+			name:        "too big",
+			annotations: map[string]string{AnnotationNetConnmark: "0x100000000"},
+			expectError: true,
+		},
+		{
+			name:        "zero mask",
+			annotations: map[string]string{AnnotationNetFwmark: "0x0/0x0"},
+			expectError: true,
+		},
+		{
+			name:        "value outside mask",
+			annotations: map[string]string{AnnotationNetFwmark: "0x100/0xff"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			qos, err := ParseNetQoS(tc.annotations)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectQoS, qos)
+			if qos != nil {
+				require.Equal(t, tc.expectRules, qos.rules())
+			}
+		})
+	}
+}
+
+func TestParseNetClassID(t *testing.T) {
+	tt := []struct {
+		name        string
+		value       string
+		expectID    uint32
+		expectError bool
+	}{
+		{
+			name:     "tc handle",
+			value:    "10:1",
+			expectID: 0x100001,
+		},
+		{
+			name:     "hex number",
+			value:    "0x100001",
+			expectID: 0x100001,
+		},
+		{
+			name:        "zero",
+			value:       "0:0",
+			expectError: true,
+		},
+		{
+			name:        "minor too big",
+			value:       "10:10000",
+			expectError: true,
+		},
+		{
+			name:        "garbage",
+			value:       "gold",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			id, err := ParseNetClassID(map[string]string{AnnotationNetClassID: tc.value})
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectID, id)
+		})
+	}
+}
+
+func TestValidateNetQoS(t *testing.T) {
+	marks := map[string]string{AnnotationNetFwmark: "0x10"}
+	require.NoError(t, ValidateNetQoS(marks, false))
+	require.Error(t, ValidateNetQoS(marks, true), "host network pods must refuse marks")
+	require.NoError(t, ValidateNetQoS(map[string]string{AnnotationNetClassID: "10:1"}, true))
+	require.Error(t, ValidateNetQoS(map[string]string{AnnotationNetClassID: "bad"}, false))
+}
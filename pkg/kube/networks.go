@@ -0,0 +1,87 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// AnnotationNetworks is a Multus-style pod annotation that lists CNI networks
+// pod is attached to in addition to the default one. Networks are looked up by
+// name in CNI configuration directory and attached in the listed order. Both
+// comma separated [namespace/]name list and JSON list of network selection
+// elements are accepted; namespace is only a part of the name for Multus
+// and is ignored here as networks are not namespaced.
+const AnnotationNetworks = "k8s.v1.cni.cncf.io/networks"
+
+// networkSelection is an element of JSON network selection list. Only
+// fields that can be honoured are declared, unknown ones are refused.
+type networkSelection struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Interface string `json:"interface,omitempty"`
+}
+
+// ParseNetworks parses additional pod networks from the passed annotations.
+// Interface names cannot be chosen since interfaces are named in order of
+// attachment, so selections that request them are refused.
+func ParseNetworks(annotations map[string]string) ([]string, error) {
+	value := strings.TrimSpace(annotations[AnnotationNetworks])
+	if value == "" {
+		return nil, nil
+	}
+
+	var selections []networkSelection
+	if strings.HasPrefix(value, "[") {
+		dec := json.NewDecoder(strings.NewReader(value))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&selections); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %v", AnnotationNetworks, err)
+		}
+	} else {
+		for _, elem := range strings.Split(value, ",") {
+			var sel networkSelection
+			elem = strings.TrimSpace(elem)
+			if i := strings.IndexByte(elem, '@'); i != -1 {
+				elem, sel.Interface = elem[:i], elem[i+1:]
+			}
+			if i := strings.IndexByte(elem, '/'); i != -1 {
+				sel.Namespace, elem = elem[:i], elem[i+1:]
+			}
+			sel.Name = elem
+			selections = append(selections, sel)
+		}
+	}
+
+	networks := make([]string, 0, len(selections))
+	seen := make(map[string]bool, len(selections))
+	for _, sel := range selections {
+		if sel.Name == "" || strings.ContainsAny(sel.Name, "/@ \t") {
+			return nil, fmt.Errorf("invalid %s annotation: bad network name %q", AnnotationNetworks, sel.Name)
+		}
+		if sel.Interface != "" {
+			return nil, fmt.Errorf("invalid %s annotation: interface name of network %s cannot be set",
+				AnnotationNetworks, sel.Name)
+		}
+		if seen[sel.Name] {
+			return nil, fmt.Errorf("invalid %s annotation: network %s is listed twice", AnnotationNetworks, sel.Name)
+		}
+		seen[sel.Name] = true
+		networks = append(networks, sel.Name)
+	}
+	return networks, nil
+}
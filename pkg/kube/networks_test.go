@@ -0,0 +1,86 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetworks(t *testing.T) {
+	tt := []struct {
+		name        string
+		value       string
+		expect      []string
+		expectError bool
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:   "comma separated",
+			value:  "storage, kube-system/backend",
+			expect: []string{"storage", "backend"},
+		},
+		{
+			name:   "json",
+			value:  `[{"name": "storage"}, {"name": "backend", "namespace": "kube-system"}]`,
+			expect: []string{"storage", "backend"},
+		},
+		{
+			name:        "interface name",
+			value:       "storage@net1",
+			expectError: true,
+		},
+		{
+			name:        "json interface name",
+			value:       `[{"name": "storage", "interface": "net1"}]`,
+			expectError: true,
+		},
+		{
+			name:        "json unsupported field",
+			value:       `[{"name": "storage", "ips": ["10.1.1.1/24"]}]`,
+			expectError: true,
+		},
+		{
+			name:        "duplicate",
+			value:       "storage,storage",
+			expectError: true,
+		},
+		{
+			name:        "empty name",
+			value:       "storage,,backend",
+			expectError: true,
+		},
+		{
+			name:        "broken json",
+			value:       `[{"name": "storage"`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			networks, err := ParseNetworks(map[string]string{AnnotationNetworks: tc.value})
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, networks)
+		})
+	}
+}
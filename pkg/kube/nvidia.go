@@ -0,0 +1,169 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity/pkg/util/nvidia"
+)
+
+// nvidiaLibDir is where NVIDIA driver libraries are bound to in containers.
+// It is the directory singularity --nv uses, so SIF run scripts already have
+// it in LD_LIBRARY_PATH.
+const nvidiaLibDir = "/.singularity.d/libs"
+
+// nvidiaGPUDevice matches NVIDIA GPU device nodes, e.g. /dev/nvidia0.
+var nvidiaGPUDevice = regexp.MustCompile(`^/dev/nvidia[0-9]+$`)
+
+// NvidiaFiles looks up NVIDIA driver files containers using NVIDIA GPUs need
+// in addition to GPU devices, i.e. control devices, libraries and binaries.
+type NvidiaFiles struct {
+	once      sync.Once
+	nvliblist string
+
+	paths   func(nvliblist string) ([]string, []string, error)
+	devices func() ([]string, error)
+}
+
+// NewNvidiaFiles returns NVIDIA files lookup. Files are listed with
+// nvidia-container-cli when it is installed or with singularity
+// nvliblist.conf otherwise, the same way singularity --nv does.
+func NewNvidiaFiles() *NvidiaFiles {
+	return &NvidiaFiles{
+		paths: func(nvliblist string) ([]string, []string, error) {
+			return nvidia.Paths(nvliblist, "")
+		},
+		devices: func() ([]string, error) {
+			return nvidia.Devices(false)
+		},
+	}
+}
+
+// nvliblistPath returns path to singularity nvliblist.conf. It is
+// found once, so that engine is not queried for each container.
+func (n *NvidiaFiles) nvliblistPath() string {
+	n.once.Do(func() {
+		config, err := runtime.NewCLIClient().BuildConfig()
+		if err != nil {
+			glog.Errorf("Could not get engine build config to find nvliblist.conf: %v", err)
+			return
+		}
+		n.nvliblist = filepath.Join(config.SingularityConfdir, "nvliblist.conf")
+	})
+	return n.nvliblist
+}
+
+// hasNvidiaGPU checks whether any of container devices is an NVIDIA GPU.
+func (t *containerTranslator) hasNvidiaGPU() bool {
+	for _, dev := range t.cont.GetDevices() {
+		if nvidiaGPUDevice.MatchString(filepath.Clean(dev.GetHostPath())) {
+			return true
+		}
+	}
+	return false
+}
+
+// configureNvidia adds NVIDIA control devices and binds driver libraries
+// and binaries into container that requested NVIDIA GPUs. Libraries are
+// bound into nvidiaLibDir that is appended to LD_LIBRARY_PATH, binaries
+// keep their host paths.
+func (t *containerTranslator) configureNvidia() error {
+	files := t.cont.nvidia
+	if files == nil || !t.hasNvidiaGPU() {
+		return nil
+	}
+
+	privileged := t.cont.GetLinux().GetSecurityContext().GetPrivileged()
+	devs, err := files.devices()
+	if err != nil {
+		return err
+	}
+	for _, dev := range devs {
+		found, err := findDevices(dev, dev, "rw")
+		if err != nil {
+			return err
+		}
+		for _, device := range found {
+			major, minor := device.Major, device.Minor
+			t.g.AddDevice(device.LinuxDevice)
+			if !privileged {
+				t.g.AddLinuxResourcesDevice(true, device.Type, &major, &minor, device.permissions)
+			}
+		}
+	}
+
+	libs, bins, err := files.paths(files.nvliblistPath())
+	if err != nil {
+		return fmt.Errorf("could not find NVIDIA driver files: %v", err)
+	}
+	glog.V(4).Infof("Binding NVIDIA libraries %v and binaries %v into container %s", libs, bins, t.cont.id)
+	bound := make(map[string]bool, len(libs)+len(bins))
+	bind := func(source, dest string) {
+		if bound[dest] || t.hasMount(dest) {
+			return
+		}
+		bound[dest] = true
+		t.g.AddMount(specs.Mount{
+			Source:      source,
+			Destination: dest,
+			Type:        "bind",
+			Options:     []string{"bind", "ro", "nosuid"},
+		})
+	}
+	for _, lib := range libs {
+		bind(lib, filepath.Join(nvidiaLibDir, filepath.Base(lib)))
+	}
+	for _, bin := range bins {
+		bind(bin, bin)
+	}
+	if len(libs) != 0 {
+		t.g.AddProcessEnv("LD_LIBRARY_PATH", appendPathList(t.processEnv("LD_LIBRARY_PATH"), nvidiaLibDir))
+	}
+	return nil
+}
+
+// processEnv returns value of the environment variable set in OCI config.
+func (t *containerTranslator) processEnv(name string) string {
+	if t.g.Config.Process == nil {
+		return ""
+	}
+	for _, env := range t.g.Config.Process.Env {
+		if key, value, ok := splitEnv(env); ok && key == name {
+			return value
+		}
+	}
+	return ""
+}
+
+// appendPathList appends dir to colon separated list unless it is there.
+func appendPathList(list, dir string) string {
+	if list == "" {
+		return dir
+	}
+	for _, p := range strings.Split(list, ":") {
+		if p == dir {
+			return list
+		}
+	}
+	return list + ":" + dir
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestAppendPathList(t *testing.T) {
+	tt := []struct {
+		name   string
+		list   string
+		expect string
+	}{
+		{name: "empty list", list: "", expect: "/libs"},
+		{name: "append", list: "/usr/lib:/lib", expect: "/usr/lib:/lib:/libs"},
+		{name: "already present", list: "/libs:/lib", expect: "/libs:/lib"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expect, appendPathList(tc.list, "/libs"))
+		})
+	}
+}
+
+func TestContainerTranslator_ConfigureNvidia(t *testing.T) {
+	tt := []struct {
+		name         string
+		devices      []*k8s.Device
+		env          []*k8s.KeyValue
+		expectMounts []specs.Mount
+		expectEnv    string
+	}{
+		{
+			name:    "no GPU requested",
+			devices: []*k8s.Device{{HostPath: "/dev/fuse", ContainerPath: "/dev/fuse"}},
+		},
+		{
+			name:    "control device only",
+			devices: []*k8s.Device{{HostPath: "/dev/nvidiactl", ContainerPath: "/dev/nvidiactl"}},
+		},
+		{
+			name:    "GPU requested",
+			devices: []*k8s.Device{{HostPath: "/dev/nvidia0", ContainerPath: "/dev/nvidia0"}},
+			env:     []*k8s.KeyValue{{Key: "LD_LIBRARY_PATH", Value: "/usr/local/lib"}},
+			expectMounts: []specs.Mount{
+				{
+					Source:      "/usr/lib64/libcuda.so.1",
+					Destination: "/.singularity.d/libs/libcuda.so.1",
+					Type:        "bind",
+					Options:     []string{"bind", "ro", "nosuid"},
+				},
+				{
+					Source:      "/usr/bin/nvidia-smi",
+					Destination: "/usr/bin/nvidia-smi",
+					Type:        "bind",
+					Options:     []string{"bind", "ro", "nosuid"},
+				},
+			},
+			expectEnv: "/usr/local/lib:/.singularity.d/libs",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			files := &NvidiaFiles{
+				paths: func(nvliblist string) ([]string, []string, error) {
+					require.Equal(t, "/etc/singularity/nvliblist.conf", nvliblist)
+					libs := []string{"/usr/lib64/libcuda.so.1", "/usr/lib/libcuda.so.1"}
+					return libs, []string{"/usr/bin/nvidia-smi"}, nil
+				},
+				devices: func() ([]string, error) {
+					return nil, nil
+				},
+			}
+			files.once.Do(func() {
+				files.nvliblist = "/etc/singularity/nvliblist.conf"
+			})
+
+			cont := &Container{
+				ContainerConfig: &k8s.ContainerConfig{
+					Devices: tc.devices,
+					Envs:    tc.env,
+				},
+				nvidia: files,
+			}
+			g, err := generate.New("linux")
+			require.NoError(t, err)
+			g.Config.Mounts = nil
+			g.Config.Process.Env = nil
+			for _, env := range tc.env {
+				g.AddProcessEnv(env.Key, env.Value)
+			}
+
+			tr := containerTranslator{cont: cont, g: g}
+			require.NoError(t, tr.configureNvidia())
+			require.Equal(t, tc.expectMounts, g.Config.Mounts)
+			require.Equal(t, tc.expectEnv, tr.processEnv("LD_LIBRARY_PATH"))
+		})
+	}
+}
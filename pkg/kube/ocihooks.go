@@ -0,0 +1,266 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// OCIHookVersion is the only supported version of hook definition files.
+const OCIHookVersion = "1.0.0"
+
+// Stages OCI hooks may be injected at.
+const (
+	OCIHookPrestart  = "prestart"
+	OCIHookPoststart = "poststart"
+	OCIHookPoststop  = "poststop"
+)
+
+// OCIHook is a hook definition file in hooks.d directory, the format
+// is the one CRI-O and podman use, see oci-hooks(5).
+type OCIHook struct {
+	Version string       `json:"version"`
+	Hook    specs.Hook   `json:"hook"`
+	When    OCIHookWhen  `json:"when"`
+	Stages  []string     `json:"stages"`
+	name    string       // file name hook is loaded from
+	matcher *hookMatcher // compiled When
+}
+
+// OCIHookWhen defines containers hook is injected into. Hook is injected when
+// all of the set conditions match, or any of them when Or is set.
+type OCIHookWhen struct {
+	// Always matches any container when true.
+	Always *bool `json:"always,omitempty"`
+	// Annotations maps key patterns to value patterns, each pair
+	// must be matched by an annotation of container spec.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Commands are patterns any of which must match container command path.
+	Commands []string `json:"commands,omitempty"`
+	// HasBindMounts matches containers with bind mounts when true.
+	HasBindMounts *bool `json:"hasBindMounts,omitempty"`
+	Or            bool  `json:"or,omitempty"`
+}
+
+type hookMatcher struct {
+	annotations map[*regexp.Regexp]*regexp.Regexp
+	commands    []*regexp.Regexp
+}
+
+// OCIHooks is a set of hooks loaded from hooks.d directories
+// that are injected into OCI specs of matching containers.
+type OCIHooks struct {
+	hooks []*OCIHook
+}
+
+// LoadOCIHooks loads hook definitions from *.json files of the passed
+// directories. File of a later directory overrides the one of the same
+// name found earlier, so that e.g. /etc/containers/oci/hooks.d may
+// override hooks installed by packages. Missing directories are skipped.
+// Hooks are injected in the order of their file names.
+func LoadOCIHooks(dirs []string) (*OCIHooks, error) {
+	byName := make(map[string]*OCIHook)
+	for _, dir := range dirs {
+		fii, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			glog.V(2).Infof("OCI hooks directory %s does not exist, skipping", dir)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read OCI hooks directory: %v", err)
+		}
+		for _, fi := range fii {
+			if fi.IsDir() || filepath.Ext(fi.Name()) != ".json" {
+				continue
+			}
+			hook, err := readOCIHook(filepath.Join(dir, fi.Name()))
+			if err != nil {
+				return nil, err
+			}
+			byName[fi.Name()] = hook
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := &OCIHooks{}
+	for _, name := range names {
+		glog.V(2).Infof("Loaded OCI hook %s for stages %v", name, byName[name].Stages)
+		h.hooks = append(h.hooks, byName[name])
+	}
+	return h, nil
+}
+
+func readOCIHook(path string) (*OCIHook, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read OCI hook: %v", err)
+	}
+	var hook OCIHook
+	if err := json.Unmarshal(data, &hook); err != nil {
+		return nil, fmt.Errorf("could not decode OCI hook %s: %v", path, err)
+	}
+	hook.name = filepath.Base(path)
+	if err := hook.compile(); err != nil {
+		return nil, fmt.Errorf("invalid OCI hook %s: %v", path, err)
+	}
+	return &hook, nil
+}
+
+// compile validates hook definition and compiles its conditions.
+func (h *OCIHook) compile() error {
+	if h.Version != OCIHookVersion {
+		return fmt.Errorf("unsupported version %q, expected %s", h.Version, OCIHookVersion)
+	}
+	if !filepath.IsAbs(h.Hook.Path) {
+		return fmt.Errorf("hook path %q is not absolute", h.Hook.Path)
+	}
+	if h.Hook.Timeout != nil && *h.Hook.Timeout <= 0 {
+		return fmt.Errorf("hook timeout must be positive")
+	}
+	if len(h.Stages) == 0 {
+		return fmt.Errorf("no stages set")
+	}
+	for _, stage := range h.Stages {
+		switch stage {
+		case OCIHookPrestart, OCIHookPoststart, OCIHookPoststop:
+		default:
+			return fmt.Errorf("unknown stage %q", stage)
+		}
+	}
+
+	w := h.When
+	if w.Always == nil && w.HasBindMounts == nil && len(w.Annotations) == 0 && len(w.Commands) == 0 {
+		return fmt.Errorf("no when conditions set")
+	}
+	m := &hookMatcher{annotations: make(map[*regexp.Regexp]*regexp.Regexp)}
+	for key, value := range w.Annotations {
+		keyRe, err := regexp.Compile(key)
+		if err != nil {
+			return fmt.Errorf("invalid annotation key pattern: %v", err)
+		}
+		valueRe, err := regexp.Compile(value)
+		if err != nil {
+			return fmt.Errorf("invalid annotation value pattern: %v", err)
+		}
+		m.annotations[keyRe] = valueRe
+	}
+	for _, command := range w.Commands {
+		re, err := regexp.Compile(command)
+		if err != nil {
+			return fmt.Errorf("invalid command pattern: %v", err)
+		}
+		m.commands = append(m.commands, re)
+	}
+	h.matcher = m
+	return nil
+}
+
+// matches checks whether hook should be injected into spec.
+func (h *OCIHook) matches(spec *specs.Spec) bool {
+	var results []bool
+	if h.When.Always != nil {
+		results = append(results, *h.When.Always)
+	}
+	for keyRe, valueRe := range h.matcher.annotations {
+		matched := false
+		for key, value := range spec.Annotations {
+			if keyRe.MatchString(key) && valueRe.MatchString(value) {
+				matched = true
+				break
+			}
+		}
+		results = append(results, matched)
+	}
+	if len(h.matcher.commands) != 0 {
+		matched := false
+		if spec.Process != nil && len(spec.Process.Args) != 0 {
+			for _, re := range h.matcher.commands {
+				if re.MatchString(spec.Process.Args[0]) {
+					matched = true
+					break
+				}
+			}
+		}
+		results = append(results, matched)
+	}
+	if h.When.HasBindMounts != nil {
+		results = append(results, *h.When.HasBindMounts == hasBindMounts(spec))
+	}
+
+	for _, matched := range results {
+		if h.When.Or && matched {
+			return true
+		}
+		if !h.When.Or && !matched {
+			return false
+		}
+	}
+	return !h.When.Or
+}
+
+// Inject adds hooks matching spec to its hooks of the corresponding stages.
+// Injected hooks are run by the engine along with hooks spec already has.
+func (h *OCIHooks) Inject(spec *specs.Spec) {
+	if h == nil {
+		return
+	}
+	for _, hook := range h.hooks {
+		if !hook.matches(spec) {
+			continue
+		}
+		glog.V(4).Infof("Injecting OCI hook %s", hook.name)
+		if spec.Hooks == nil {
+			spec.Hooks = &specs.Hooks{}
+		}
+		for _, stage := range hook.Stages {
+			switch stage {
+			case OCIHookPrestart:
+				spec.Hooks.Prestart = append(spec.Hooks.Prestart, hook.Hook)
+			case OCIHookPoststart:
+				spec.Hooks.Poststart = append(spec.Hooks.Poststart, hook.Hook)
+			case OCIHookPoststop:
+				spec.Hooks.Poststop = append(spec.Hooks.Poststop, hook.Hook)
+			}
+		}
+	}
+}
+
+// hasBindMounts checks whether spec has any bind mounts.
+func hasBindMounts(spec *specs.Spec) bool {
+	for _, mount := range spec.Mounts {
+		if mount.Type == "bind" {
+			return true
+		}
+		for _, option := range mount.Options {
+			if option == "bind" || option == "rbind" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOCIHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-hooks-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeHook := func(dir, name, content string) {
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	usr := filepath.Join(dir, "usr")
+	etc := filepath.Join(dir, "etc")
+	writeHook(usr, "nvidia.json", `{"version":"1.0.0","hook":{"path":"/usr/bin/nvidia-hook"},
+		"when":{"always":true},"stages":["prestart"]}`)
+	writeHook(usr, "audit.json", `{"version":"1.0.0","hook":{"path":"/usr/bin/audit"},
+		"when":{"always":true},"stages":["poststart"]}`)
+	writeHook(usr, "README", "not a hook")
+	writeHook(etc, "nvidia.json", `{"version":"1.0.0","hook":{"path":"/opt/nvidia-hook"},
+		"when":{"always":true},"stages":["prestart","poststop"]}`)
+
+	hooks, err := LoadOCIHooks([]string{usr, etc, filepath.Join(dir, "missing")})
+	require.NoError(t, err)
+	require.Len(t, hooks.hooks, 2)
+	require.Equal(t, "audit.json", hooks.hooks[0].name)
+	require.Equal(t, "/opt/nvidia-hook", hooks.hooks[1].Hook.Path, "later directory must override hook")
+
+	tt := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "unknown version",
+			content: `{"version":"2.0.0","hook":{"path":"/bin/true"},"when":{"always":true},"stages":["prestart"]}`,
+		},
+		{
+			name:    "relative path",
+			content: `{"version":"1.0.0","hook":{"path":"true"},"when":{"always":true},"stages":["prestart"]}`,
+		},
+		{
+			name:    "unknown stage",
+			content: `{"version":"1.0.0","hook":{"path":"/bin/true"},"when":{"always":true},"stages":["prerun"]}`,
+		},
+		{
+			name:    "no conditions",
+			content: `{"version":"1.0.0","hook":{"path":"/bin/true"},"when":{},"stages":["prestart"]}`,
+		},
+		{
+			name:    "invalid pattern",
+			content: `{"version":"1.0.0","hook":{"path":"/bin/true"},"when":{"commands":["("]},"stages":["prestart"]}`,
+		},
+		{
+			name:    "invalid json",
+			content: `{"version":`,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			invalid := filepath.Join(dir, "invalid")
+			defer os.RemoveAll(invalid)
+			writeHook(invalid, "hook.json", tc.content)
+			_, err := LoadOCIHooks([]string{invalid})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestOCIHooks_Inject(t *testing.T) {
+	yes, no := true, false
+	spec := &specs.Spec{
+		Process:     &specs.Process{Args: []string{"/usr/bin/python3", "app.py"}},
+		Annotations: map[string]string{"io.kubernetes.cri.container-type": "container", "audit": "enabled"},
+		Mounts: []specs.Mount{
+			{Destination: "/data", Source: "/var/lib/kubelet/data", Options: []string{"rbind", "ro"}},
+		},
+	}
+
+	tt := []struct {
+		name        string
+		when        OCIHookWhen
+		expectMatch bool
+	}{
+		{
+			name:        "always",
+			when:        OCIHookWhen{Always: &yes},
+			expectMatch: true,
+		},
+		{
+			name: "never",
+			when: OCIHookWhen{Always: &no},
+		},
+		{
+			name:        "annotation",
+			when:        OCIHookWhen{Annotations: map[string]string{"^audit$": "^enabled$"}},
+			expectMatch: true,
+		},
+		{
+			name: "annotation value mismatch",
+			when: OCIHookWhen{Annotations: map[string]string{"^audit$": "^disabled$"}},
+		},
+		{
+			name:        "command",
+			when:        OCIHookWhen{Commands: []string{"^/bin/sh$", "python"}},
+			expectMatch: true,
+		},
+		{
+			name:        "bind mounts",
+			when:        OCIHookWhen{HasBindMounts: &yes},
+			expectMatch: true,
+		},
+		{
+			name: "all conditions",
+			when: OCIHookWhen{Commands: []string{"python"}, HasBindMounts: &no},
+		},
+		{
+			name:        "any condition",
+			when:        OCIHookWhen{Commands: []string{"python"}, HasBindMounts: &no, Or: true},
+			expectMatch: true,
+		},
+		{
+			name: "no condition matches",
+			when: OCIHookWhen{Commands: []string{"^/bin/sh$"}, Always: &no, Or: true},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			hook := &OCIHook{
+				Version: OCIHookVersion,
+				Hook:    specs.Hook{Path: "/usr/bin/hook", Args: []string{"hook", "prestart"}},
+				When:    tc.when,
+				Stages:  []string{OCIHookPrestart, OCIHookPoststop},
+			}
+			require.NoError(t, hook.compile())
+			hooks := &OCIHooks{hooks: []*OCIHook{hook}}
+
+			s := *spec
+			hooks.Inject(&s)
+			if !tc.expectMatch {
+				require.Nil(t, s.Hooks)
+				return
+			}
+			require.Equal(t, &specs.Hooks{
+				Prestart: []specs.Hook{hook.Hook},
+				Poststop: []specs.Hook{hook.Hook},
+			}, s.Hooks)
+		})
+	}
+
+	var nilHooks *OCIHooks
+	s := *spec
+	nilHooks.Inject(&s)
+	require.Nil(t, s.Hooks)
+}
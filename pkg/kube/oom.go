@@ -0,0 +1,108 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+)
+
+// ReasonOOMKilled is a container state reason reported when container
+// was killed by OOM killer. Kubelet recognizes it and reports it as is.
+const ReasonOOMKilled = "OOMKilled"
+
+// WithRestrictedOOMScoreAdj makes container processes never get oom_score_adj
+// lower than min, e.g. one of the runtime itself, as unprivileged processes
+// may not lower their score. By default requested value is set as is.
+func WithRestrictedOOMScoreAdj(min int) ContainerOption {
+	return func(c *Container) {
+		c.minOOMScoreAdj = &min
+	}
+}
+
+// oomScoreAdj returns oom_score_adj container process gets when adj is requested.
+func (c *Container) oomScoreAdj(adj int64) int64 {
+	if c.minOOMScoreAdj != nil && adj < int64(*c.minOOMScoreAdj) {
+		return int64(*c.minOOMScoreAdj)
+	}
+	return adj
+}
+
+// ReadOOMScoreAdj returns oom_score_adj of the process with the passed pid.
+func ReadOOMScoreAdj(pid int) (int, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/oom_score_adj", pid))
+	if err != nil {
+		return 0, fmt.Errorf("could not read oom_score_adj: %v", err)
+	}
+	adj, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("could not parse oom_score_adj: %v", err)
+	}
+	return adj, nil
+}
+
+// OOMKilled checks whether exited container was killed by OOM killer, i.e.
+// it exited with non-zero code and OOM kills were recorded in its cgroup.
+func (c *Container) OOMKilled() bool {
+	return c.runtimeState == runtime.StateExited && c.ExitCode() != 0 &&
+		c.exitStats != nil && c.exitStats.OOMKills != 0
+}
+
+// OOMKills returns number of container processes killed by OOM killer so far.
+// False is returned when container cgroups are not known or host doesn't
+// account OOM kills, e.g. on kernels older than 4.13 with cgroup v1.
+func (c *Container) OOMKills() (uint64, bool) {
+	return readOOMKills(c.cgroupDirs)
+}
+
+// OOMEventFiles returns cgroup v2 memory.events files of container that
+// are modified on every OOM kill, so that kills may be watched for.
+func (c *Container) OOMEventFiles() []string {
+	var files []string
+	for _, dir := range c.cgroupDirs {
+		path := filepath.Join(dir, "memory.events")
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// readOOMKills reads OOM kill counters from the passed cgroup directories,
+// both v1 and v2 file names are understood.
+func readOOMKills(dirs []string) (uint64, bool) {
+	var kills uint64
+	found := false
+	for _, dir := range dirs {
+		for _, path := range []string{
+			filepath.Join(dir, "memory.events"),
+			filepath.Join(dir, "memory.oom_control"),
+		} {
+			if v, ok := readCgroupKey(path, "oom_kill"); ok {
+				found = true
+				if v > kills {
+					kills = v
+				}
+			}
+		}
+	}
+	return kills, found
+}
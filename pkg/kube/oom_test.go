@@ -0,0 +1,115 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity/pkg/ociruntime"
+)
+
+func TestOOMKills(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(root)
+
+	v1 := filepath.Join(root, "memory")
+	writeCgroupFiles(t, v1, map[string]string{
+		"memory.oom_control": "oom_kill_disable 0\nunder_oom 0\noom_kill 2\n",
+	})
+	v2 := filepath.Join(root, "unified")
+	writeCgroupFiles(t, v2, map[string]string{
+		"memory.events": "low 0\nhigh 0\nmax 4\noom 1\noom_kill 1\n",
+	})
+
+	c := &Container{}
+	_, ok := c.OOMKills()
+	require.False(t, ok, "unknown cgroups must not report kills")
+
+	c.cgroupDirs = []string{filepath.Join(root, "cpu"), v1, v2}
+	kills, ok := c.OOMKills()
+	require.True(t, ok)
+	require.EqualValues(t, 2, kills)
+	require.Equal(t, []string{filepath.Join(v2, "memory.events")}, c.OOMEventFiles())
+}
+
+func TestOOMKilled(t *testing.T) {
+	code := func(c int) *int { return &c }
+	tt := []struct {
+		name   string
+		state  runtime.State
+		code   *int
+		stats  *ExitStats
+		expect string
+	}{
+		{
+			name:   "killed",
+			state:  runtime.StateExited,
+			code:   code(137),
+			stats:  &ExitStats{OOMKills: 1},
+			expect: ReasonOOMKilled,
+		},
+		{
+			name:   "child killed",
+			state:  runtime.StateExited,
+			code:   code(0),
+			stats:  &ExitStats{OOMKills: 1},
+			expect: "Completed",
+		},
+		{
+			name:   "error",
+			state:  runtime.StateExited,
+			code:   code(1),
+			stats:  &ExitStats{},
+			expect: "Error",
+		},
+		{
+			name:   "no stats",
+			state:  runtime.StateExited,
+			code:   code(137),
+			expect: "Error",
+		},
+		{
+			name:  "running",
+			state: runtime.StateRunning,
+			stats: &ExitStats{OOMKills: 1},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Container{
+				runtimeState: tc.state,
+				ociState:     &ociruntime.State{ExitCode: tc.code},
+				exitStats:    tc.stats,
+			}
+			require.Equal(t, tc.expect == ReasonOOMKilled, c.OOMKilled())
+			require.Equal(t, tc.expect, c.StateReason())
+		})
+	}
+}
+
+func TestOOMScoreAdj(t *testing.T) {
+	c := &Container{}
+	require.EqualValues(t, -998, c.oomScoreAdj(-998))
+
+	WithRestrictedOOMScoreAdj(-500)(c)
+	require.EqualValues(t, -500, c.oomScoreAdj(-998))
+	require.EqualValues(t, 1000, c.oomScoreAdj(1000))
+}
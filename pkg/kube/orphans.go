@@ -0,0 +1,164 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/rand"
+)
+
+// Directories of base run directory pods and containers keep their files in,
+// each pod and container has a subdirectory named after its ID.
+const (
+	podsRunDir       = "pods"
+	containersRunDir = "containers"
+)
+
+// isRuntimeID checks whether name may be an ID of pod or container.
+func isRuntimeID(name string) bool {
+	if len(name) != rand.IDLen {
+		return false
+	}
+	for _, c := range name {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// RemoveStaleMounts lazily unmounts mounts left in directories of pods and
+// containers under baseRunDir that are not known, i.e. keep returns false,
+// e.g. root filesystems of containers whose cleanup was interrupted by
+// daemon crash. Unmounted mount points are returned.
+func RemoveStaleMounts(baseRunDir string, keep func(id string) bool) ([]string, error) {
+	mountInfo, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open mountinfo: %v", err)
+	}
+	defer mountInfo.Close()
+	peers, err := parseMountPeers(mountInfo)
+	if err != nil {
+		return nil, fmt.Errorf("could not read mountinfo: %v", err)
+	}
+
+	var stale []string
+	for _, peer := range peers {
+		if id := runDirOwner(baseRunDir, peer.mountPoint); id != "" && !keep(id) {
+			stale = append(stale, peer.mountPoint)
+		}
+	}
+	// nested mounts go first, stacked mounts are unmounted one by one
+	sort.SliceStable(stale, func(i, j int) bool { return len(stale[i]) > len(stale[j]) })
+	var removed []string
+	for _, path := range stale {
+		glog.V(3).Infof("Unmounting stale mount %s", path)
+		if err := detachMount(path); err != nil {
+			glog.Errorf("Could not remove stale mount: %v", err)
+			continue
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// runDirOwner returns ID of pod or container path belongs to when
+// it is located in its directory under baseRunDir.
+func runDirOwner(baseRunDir, path string) string {
+	rel, err := filepath.Rel(baseRunDir, path)
+	if err != nil {
+		return ""
+	}
+	parts := strings.SplitN(rel, string(filepath.Separator), 3)
+	if len(parts) < 2 || (parts[0] != podsRunDir && parts[0] != containersRunDir) || !isRuntimeID(parts[1]) {
+		return ""
+	}
+	return parts[1]
+}
+
+// RemoveStaleCgroups removes empty cgroups the runtime created for pods and
+// containers that are not known, i.e. keep returns false. Pod cgroups under
+// the default parent and process cgroups under passed pod cgroup parents are
+// checked. Cgroups that still have processes are left intact. Only cgroupfs
+// driver is handled, systemd removes scopes of exited processes itself.
+// Removed cgroup directories are returned.
+func RemoveStaleCgroups(podParents []string, keep func(id string) bool) ([]string, error) {
+	mounts, err := readCgroupMounts()
+	if err != nil {
+		return nil, err
+	}
+	parents := append([]string{defaultCgroup}, podParents...)
+	var removed []string
+	for _, mount := range mounts {
+		if mount.unified && mount.mountPoint != unifiedMount {
+			continue
+		}
+		if !mount.unified && !hasV1Controller(mount) {
+			continue
+		}
+		for _, parent := range parents {
+			dir, ok := mount.dir(parent)
+			if !ok {
+				continue
+			}
+			removed = append(removed, removeStaleCgroups(dir, keep)...)
+		}
+	}
+	return removed, nil
+}
+
+// removeStaleCgroups removes cgroups under dir named after
+// IDs that are not kept along with their descendants.
+func removeStaleCgroups(dir string, keep func(id string) bool) []string {
+	fii, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Errorf("Could not read cgroup %s: %v", dir, err)
+		}
+		return nil
+	}
+	var removed []string
+	for _, fi := range fii {
+		if !fi.IsDir() || !isRuntimeID(fi.Name()) || keep(fi.Name()) {
+			continue
+		}
+		cgroup := filepath.Join(dir, fi.Name())
+		var dirs []string
+		filepath.Walk(cgroup, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				dirs = append(dirs, path)
+			}
+			return nil
+		})
+		// children are removed before their parents, cgroup
+		// with processes cannot be removed and is left as is
+		for i := len(dirs) - 1; i >= 0; i-- {
+			glog.V(3).Infof("Removing stale cgroup %s", dirs[i])
+			if err := os.Remove(dirs[i]); err != nil {
+				glog.Warningf("Could not remove stale cgroup %s: %v", dirs[i], err)
+				break
+			}
+			removed = append(removed, dirs[i])
+		}
+	}
+	return removed
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunDirOwner(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	tt := []struct {
+		name   string
+		path   string
+		expect string
+	}{
+		{
+			name:   "container rootfs",
+			path:   "/var/run/singularity/containers/" + id + "/bundle/rootfs",
+			expect: id,
+		},
+		{
+			name:   "pod directory",
+			path:   "/var/run/singularity/pods/" + id,
+			expect: id,
+		},
+		{
+			name: "pods directory",
+			path: "/var/run/singularity/pods",
+		},
+		{
+			name: "not an ID",
+			path: "/var/run/singularity/containers/" + id[:12] + "/bundle",
+		},
+		{
+			name: "other directory",
+			path: "/var/run/singularity/lower/" + id,
+		},
+		{
+			name: "outside run directory",
+			path: "/var/lib/kubelet/pods/" + id,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expect, runDirOwner("/var/run/singularity", tc.path))
+		})
+	}
+}
+
+func TestRemoveStaleCgroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	live := strings.Repeat("1", 64)
+	stale := strings.Repeat("2", 64)
+	for _, path := range []string{
+		filepath.Join(live, "init"),
+		filepath.Join(stale, "init"),
+		"system",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, path), 0755))
+	}
+
+	removed := removeStaleCgroups(dir, func(id string) bool { return id == live })
+	require.Equal(t, []string{
+		filepath.Join(dir, stale, "init"),
+		filepath.Join(dir, stale),
+	}, removed)
+
+	fii, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	var left []string
+	for _, fi := range fii {
+		left = append(left, fi.Name())
+	}
+	require.Equal(t, []string{live, "system"}, left)
+}
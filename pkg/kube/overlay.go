@@ -0,0 +1,51 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// AnnotationDisposable is a pod annotation that, when set to true, marks
+// pod containers as disposable. Writable layers of disposable containers
+// are mounted with overlay volatile option which skips all syncs to disk,
+// so their contents do not survive unclean host shutdown.
+const AnnotationDisposable = "singularity.cri/disposable"
+
+// Optional overlay mount options used when kernel supports them.
+const (
+	overlayVolatile = "volatile"
+	overlayMetacopy = "metacopy=on"
+)
+
+// ParseDisposable checks pod annotation that marks pod containers as disposable.
+func ParseDisposable(annotations map[string]string) (bool, error) {
+	value, ok := annotations[AnnotationDisposable]
+	if !ok {
+		return false, nil
+	}
+	disposable, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s annotation %q: expected boolean", AnnotationDisposable, value)
+	}
+	return disposable, nil
+}
+
+// OverlayOptions returns optional overlay mount options container
+// writable layer was mounted with, e.g. metacopy=on.
+func (c *Container) OverlayOptions() []string {
+	return c.overlayOptions
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDisposable(t *testing.T) {
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		expect      bool
+		expectError string
+	}{
+		{
+			name:   "no annotation",
+			expect: false,
+		},
+		{
+			name:        "disposable",
+			annotations: map[string]string{AnnotationDisposable: "true"},
+			expect:      true,
+		},
+		{
+			name:        "not disposable",
+			annotations: map[string]string{AnnotationDisposable: "0"},
+			expect:      false,
+		},
+		{
+			name:        "invalid value",
+			annotations: map[string]string{AnnotationDisposable: "yes"},
+			expectError: `invalid singularity.cri/disposable annotation "yes": expected boolean`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			disposable, err := ParseDisposable(tc.annotations)
+			if tc.expectError != "" {
+				require.EqualError(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, disposable)
+		})
+	}
+}
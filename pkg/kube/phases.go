@@ -0,0 +1,100 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Phase is a step of pod or container creation which duration is recorded.
+type Phase int
+
+// Recorded phases of pod and container creation and start.
+const (
+	// PhaseFiles is generation of pod files, e.g. hosts and resolv.conf.
+	PhaseFiles Phase = iota
+	// PhaseNamespaces is creation of pod namespaces.
+	PhaseNamespaces
+	// PhaseNetwork is pod network setup, i.e. CNI ADD.
+	PhaseNetwork
+	// PhaseRootfs is preparation of container rootfs from SIF image.
+	PhaseRootfs
+	// PhaseSpec is generation and validation of OCI spec.
+	PhaseSpec
+	// PhaseEngineCreate is engine create call until created state is reported.
+	PhaseEngineCreate
+	// PhaseEngineStart is engine start call until running state is reported.
+	PhaseEngineStart
+	// PhasePostStart is bookkeeping after container is started.
+	PhasePostStart
+
+	phaseCount
+)
+
+var phaseNames = [phaseCount]string{
+	PhaseFiles:        "files",
+	PhaseNamespaces:   "namespaces",
+	PhaseNetwork:      "network",
+	PhaseRootfs:       "rootfs",
+	PhaseSpec:         "spec",
+	PhaseEngineCreate: "engineCreate",
+	PhaseEngineStart:  "engineStart",
+	PhasePostStart:    "postStart",
+}
+
+// String returns name of the phase.
+func (p Phase) String() string {
+	if p < 0 || p >= phaseCount {
+		return "unknown"
+	}
+	return phaseNames[p]
+}
+
+// PhaseDurations returns durations of the pod creation phases recorded so far.
+func (p *Pod) PhaseDurations() map[string]time.Duration {
+	return p.phases.durations()
+}
+
+// PhaseDurations returns durations of the container creation
+// and start phases recorded so far.
+func (c *Container) PhaseDurations() map[string]time.Duration {
+	return c.phases.durations()
+}
+
+// phaseDurations holds durations of phases. Recording a phase is a single
+// atomic store so it is safe to read durations concurrently.
+type phaseDurations [phaseCount]int64
+
+// record stores time elapsed since start as duration of the phase.
+func (d *phaseDurations) record(phase Phase, start time.Time) {
+	atomic.StoreInt64(&d[phase], int64(time.Since(start)))
+}
+
+// recorded returns true if phase duration is recorded.
+func (d *phaseDurations) recorded(phase Phase) bool {
+	return atomic.LoadInt64(&d[phase]) != 0
+}
+
+// durations returns durations of all recorded phases.
+func (d *phaseDurations) durations() map[string]time.Duration {
+	res := make(map[string]time.Duration)
+	for i := range d {
+		if v := atomic.LoadInt64(&d[i]); v != 0 {
+			res[Phase(i).String()] = time.Duration(v)
+		}
+	}
+	return res
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPhaseDurations(t *testing.T) {
+	var d phaseDurations
+	require.Empty(t, d.durations())
+
+	d.record(PhaseSpec, time.Now().Add(-time.Second))
+	d.record(PhaseEngineStart, time.Now().Add(-time.Millisecond))
+
+	durations := d.durations()
+	require.Len(t, durations, 2)
+	require.True(t, durations["spec"] >= time.Second)
+	require.True(t, durations["engineStart"] >= time.Millisecond)
+	require.True(t, durations["engineStart"] < time.Second)
+}
+
+func TestPhase_String(t *testing.T) {
+	for phase := Phase(0); phase < phaseCount; phase++ {
+		require.NotEmpty(t, phase.String(), "phase %d has no name", phase)
+		require.NotEqual(t, "unknown", phase.String())
+	}
+	require.Equal(t, "unknown", phaseCount.String())
+	require.Equal(t, "network", PhaseNetwork.String())
+}
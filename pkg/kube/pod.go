@@ -18,20 +18,23 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/selinux/go-selinux/label"
 	"github.com/sylabs/singularity-cri/pkg/namespace"
 	"github.com/sylabs/singularity-cri/pkg/network"
 	"github.com/sylabs/singularity-cri/pkg/rand"
 	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity-cri/pkg/warnings"
 	"github.com/sylabs/singularity/pkg/ociruntime"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
 const (
 	// PodIDLen reflects number of symbols in pod unique ID.
-	PodIDLen = 64
+	PodIDLen = rand.IDLen
 )
 
 // Pod represents kubernetes pod. It encapsulates all pod-specific
@@ -47,25 +50,129 @@ type Pod struct {
 	runtimeState runtime.State
 	ociState     *ociruntime.State
 	namespaces   []specs.LinuxNamespace
+	netNsDir     string
 
 	mu         sync.Mutex
 	containers []*Container
+	monitors   map[string]int
 
-	cli        *runtime.CLIClient
+	cli        runtime.Engine
+	handler    string
+	// userNsDefaults are runtime-wide ID mappings, userNs
+	// are ones pod is run with, nil for host user namespace
+	userNsDefaults *UserNamespace
+	userNs         *UserNamespace
 	syncChan   <-chan runtime.State
 	syncCancel context.CancelFunc
 
-	network *network.PodNetwork
+	phases phaseDurations
+
+	network    *network.PodNetwork
+	extraHosts []HostEntry
+	timezone   *Timezone
+
+	// restoredIPs are pod IPs recorded by previous CRI
+	// instance, restored network doesn't report them
+	restoredIPs []string
+
+	logOwner           *Owner
+	allowedAnnotations []string
+	sysctlPolicy       *SysctlPolicy
+	sysctls            map[string]string
+	// cgroupPath is a path of pod cgroup in cgroup hierarchies, pod
+	// and container processes are run in its children; ownCgroups
+	// are its directories created by the runtime, ownUnits are
+	// transient systemd units created with systemd driver
+	cgroupDriver CgroupDriver
+	cgroupPath   string
+	ownCgroups   []string
+	ownUnits     []string
+	resources    *k8s.LinuxContainerResources
+
+	// processLabel and mountLabel are SELinux labels pod is run
+	// with, containers inherit them, empty if SELinux is disabled
+	processLabel string
+	mountLabel   string
+
+	retainOnFailure bool
+	failure         *RunFailure
+	engineStderr    string
+
+	adoptedAt int64
+
+	warnings warnings.Buffer
+}
+
+// Owner holds numeric user and group IDs of a file owner.
+type Owner struct {
+	UID int
+	GID int
+}
+
+// PodOption is a type representing functional option for Pod.
+type PodOption func(p *Pod)
+
+// WithLogOwner sets owner of the pod log directory created at pod run.
+// By default log directory is owned by the user CRI runs as.
+func WithLogOwner(owner *Owner) PodOption {
+	return func(p *Pod) {
+		p.logOwner = owner
+	}
+}
+
+// WithPodAnnotations sets patterns of pod annotations that are copied
+// into OCI spec in addition to Kubernetes and Singularity-CRI ones.
+func WithPodAnnotations(allowed []string) PodOption {
+	return func(p *Pod) {
+		p.allowedAnnotations = allowed
+	}
+}
+
+// WithPodEngine sets engine pod is run with.
+// By default Singularity OCI engine is used.
+func WithPodEngine(engine runtime.Engine) PodOption {
+	return func(p *Pod) {
+		p.cli = engine
+	}
+}
+
+// WithRuntimeHandler sets name of runtime handler pod is run with.
+// It is reported only, engine is set with WithPodEngine.
+func WithRuntimeHandler(name string) PodOption {
+	return func(p *Pod) {
+		p.handler = name
+	}
+}
+
+// WithUserNamespace sets ID mappings pods are run with in a new user namespace,
+// see UserNamespace. By default pods are run in the host user namespace.
+func WithUserNamespace(userNs *UserNamespace) PodOption {
+	return func(p *Pod) {
+		p.userNsDefaults = userNs
+	}
+}
+
+// WithSysctlPolicy sets policy sysctls requested for pod are checked
+// against. By default only SafeSysctls are allowed.
+func WithSysctlPolicy(policy *SysctlPolicy) PodOption {
+	return func(p *Pod) {
+		p.sysctlPolicy = policy
+	}
 }
 
 // NewPod constructs Pod instance. Pod is thread safe to use.
-func NewPod(config *k8s.PodSandboxConfig) *Pod {
-	podID := rand.GenerateID(PodIDLen)
-	return &Pod{
+func NewPod(config *k8s.PodSandboxConfig, opts ...PodOption) *Pod {
+	podID := rand.NewID()
+	pod := &Pod{
 		PodSandboxConfig: config,
 		id:               podID,
 		cli:              runtime.NewCLIClient(),
+		cgroupDriver:     CgroupDriverCgroupfs,
+	}
+	for _, o := range opts {
+		o(pod)
 	}
+	return pod
 }
 
 // ID returns unique pod ID.
@@ -73,6 +180,18 @@ func (p *Pod) ID() string {
 	return p.id
 }
 
+// RuntimeHandler returns name of runtime handler pod is run with,
+// empty means the default one.
+func (p *Pod) RuntimeHandler() string {
+	return p.handler
+}
+
+// UserNamespace returns ID mappings of pod user namespace,
+// nil means pod is run in the host user namespace.
+func (p *Pod) UserNamespace() *UserNamespace {
+	return p.userNs
+}
+
 // State returns current pod state.
 func (p *Pod) State() k8s.PodSandboxState {
 	if p.runtimeState == runtime.StateRunning {
@@ -81,9 +200,18 @@ func (p *Pod) State() k8s.PodSandboxState {
 	return k8s.PodSandboxState_SANDBOX_NOTREADY
 }
 
+// Warnings returns recent distinct warnings about pod.
+func (p *Pod) Warnings() *warnings.Buffer {
+	return &p.warnings
+}
+
 // CreatedAt returns pod creation time in Unix nano.
+// Pods adopted from warm pool are created at adoption.
 func (p *Pod) CreatedAt() int64 {
-	if p.ociState.CreatedAt == nil {
+	if p.adoptedAt != 0 {
+		return p.adoptedAt
+	}
+	if p.ociState == nil || p.ociState.CreatedAt == nil {
 		return 0
 	}
 	return *p.ociState.CreatedAt
@@ -91,19 +219,13 @@ func (p *Pod) CreatedAt() int64 {
 
 // Run prepares and runs pod based on initial config passed to NewPod.
 // All files created (namespaces, sync socket, etc) are located in baseDir.
-func (p *Pod) Run(baseDir string) error {
+// On failure the failed stage is recorded, see Fail.
+func (p *Pod) Run(ctx context.Context, baseDir string) error {
 	var err error
+	stage := StageConfig
 	defer func() {
 		if err != nil {
-			if err := p.terminate(true); err != nil {
-				glog.Errorf("Could not kill pod after failed run: %v", err)
-			}
-			if err := p.cli.Delete(p.id); err != nil {
-				glog.Errorf("Could not remove pod: %v", err)
-			}
-			if err := p.cleanupFiles(true); err != nil {
-				glog.Errorf("Could not cleanup pod after failed run: %v", err)
-			}
+			p.Fail(stage, err)
 		}
 	}()
 
@@ -111,18 +233,38 @@ func (p *Pod) Run(baseDir string) error {
 	if err = p.validateConfig(); err != nil {
 		return fmt.Errorf("invalid pod config: %v", err)
 	}
+	stage = PhaseFiles.String()
+	start := time.Now()
 	if err = p.prepareFiles(); err != nil {
 		return fmt.Errorf("could not create pod directories: %v", err)
 	}
+	p.phases.record(PhaseFiles, start)
+	if err = p.setupCgroup(); err != nil {
+		return fmt.Errorf("could not set up pod cgroup: %v", err)
+	}
+	stage = PhaseNamespaces.String()
+	start = time.Now()
 	if err = p.unshareNamespaces(); err != nil {
 		return fmt.Errorf("could not unshare namespaces: %v", err)
 	}
-	if err = p.spawnOCIPod(); err != nil {
+	p.phases.record(PhaseNamespaces, start)
+	if err = p.spawnOCIPod(ctx); err != nil {
+		stage = PhaseEngineCreate.String()
+		if p.phases.recorded(PhaseEngineCreate) {
+			stage = PhaseEngineStart.String()
+		}
 		return fmt.Errorf("could not spawn pod: %v", err)
 	}
+	stage = PhaseEngineStart.String()
 	if err = p.UpdateState(); err != nil {
 		return fmt.Errorf("could not update pod state: %v", err)
 	}
+	if err := p.registerScope(p.id, p.Pid()); err != nil {
+		p.warnings.Logf(glog.WarningDepth, "Could not register pod %s scope in systemd: %v", p.id, err)
+	}
+	if err := p.PlaceHelpers(); err != nil {
+		glog.Warningf("Could not charge pod %s helpers to pod cgroups: %v", p.id, err)
+	}
 	return nil
 }
 
@@ -139,15 +281,17 @@ func (p *Pod) Stop() error {
 		}
 	}
 
-	err := p.terminate(false)
-	if err != nil {
-		return fmt.Errorf("could not stop pod process: %v", err)
+	// failed pod process is already stopped
+	if p.failure == nil {
+		if err := p.terminate(false); err != nil {
+			return fmt.Errorf("could not stop pod process: %v", err)
+		}
 	}
 	if err := p.UpdateState(); err != nil {
 		return fmt.Errorf("could not update container state: %v", err)
 	}
 	p.isStopped = true
-	return err
+	return nil
 }
 
 // Remove removes pod and all its containers, making sure nothing
@@ -165,15 +309,21 @@ func (p *Pod) Remove() error {
 		}
 	}
 
-	if err := p.terminate(true); err != nil {
-		return fmt.Errorf("could not kill pod process: %v", err)
-	}
-	if err := p.cli.Delete(p.id); err != nil && err != runtime.ErrNotFound {
-		return fmt.Errorf("could not remove pod: %v", err)
+	// failed pod is already killed and deleted from the runtime
+	if p.failure == nil {
+		if err := p.terminate(true); err != nil {
+			return fmt.Errorf("could not kill pod process: %v", err)
+		}
+		if err := p.cli.Delete(p.id); err != nil && err != runtime.ErrNotFound {
+			return fmt.Errorf("could not remove pod: %v", err)
+		}
 	}
 	if err := p.cleanupFiles(false); err != nil {
 		glog.Errorf("Pod cleanup failed: %v", err)
 	}
+	p.unregisterScope(p.id)
+	p.removeCgroup()
+	label.ReleaseLabel(p.processLabel)
 	p.isRemoved = true
 	return nil
 }
@@ -227,6 +377,7 @@ func (p *Pod) addContainer(cont *Container) {
 func (p *Pod) removeContainer(cont *Container) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	delete(p.monitors, cont.id)
 	for i, c := range p.containers {
 		if c.id == cont.id {
 			p.containers = append(p.containers[:i], p.containers[i+1:]...)
@@ -235,11 +386,39 @@ func (p *Pod) removeContainer(cont *Container) {
 	}
 }
 
+// hostIPC checks whether pod shares IPC namespace with the host.
+func (p *Pod) hostIPC() bool {
+	return p.GetLinux().GetSecurityContext().GetNamespaceOptions().GetIpc() == k8s.NamespaceMode_NODE
+}
+
+// privatePID checks whether pod containers are run in their own PID
+// namespaces, i.e. pod has neither shared nor host PID namespace.
+func (p *Pod) privatePID() bool {
+	return p.GetLinux().GetSecurityContext().GetNamespaceOptions().GetPid() == k8s.NamespaceMode_CONTAINER
+}
+
+// hostUTS checks whether pod shares UTS namespace with the host,
+// which is implied by host network.
+func (p *Pod) hostUTS() bool {
+	return p.hostNetwork()
+}
+
+// hostNetwork checks whether pod shares network namespace with the host.
+func (p *Pod) hostNetwork() bool {
+	return p.GetLinux().GetSecurityContext().GetNamespaceOptions().GetNetwork() == k8s.NamespaceMode_NODE
+}
+
 func (p *Pod) unshareNamespaces() error {
-	p.namespaces = append(p.namespaces, specs.LinuxNamespace{
-		Type: specs.UTSNamespace,
-		Path: p.bindNamespacePath(specs.UTSNamespace),
-	})
+	if runtime.IsFake(p.cli) {
+		// fake engine runs processes in the host namespaces
+		return nil
+	}
+	if !p.hostUTS() {
+		p.namespaces = append(p.namespaces, specs.LinuxNamespace{
+			Type: specs.UTSNamespace,
+			Path: p.bindNamespacePath(specs.UTSNamespace),
+		})
+	}
 	security := p.GetLinux().GetSecurityContext()
 	if security.GetNamespaceOptions().GetNetwork() == k8s.NamespaceMode_POD {
 		p.namespaces = append(p.namespaces, specs.LinuxNamespace{
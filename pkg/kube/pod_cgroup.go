@@ -0,0 +1,308 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// AnnotationPodResources is a pod annotation that sets limits of pod cgroup
+// containers of the pod are parented under. CRI v1alpha2 has no pod-level
+// resources in PodSandboxConfig, so pod overhead and limits are expected to be
+// passed with it. Value is JSON encoded CRI LinuxContainerResources, e.g.
+// {"cpu_quota":200000,"cpu_period":100000,"memory_limit_in_bytes":1073741824}.
+const AnnotationPodResources = "singularity.cri/pod-resources"
+
+// CgroupDriver tells how cgroup parents passed by kubelet are interpreted,
+// it must match kubelet cgroup driver.
+type CgroupDriver string
+
+// Supported cgroup drivers.
+const (
+	// CgroupDriverCgroupfs treats cgroup parent as a path in cgroup hierarchies.
+	CgroupDriverCgroupfs CgroupDriver = "cgroupfs"
+	// CgroupDriverSystemd treats cgroup parent as a systemd slice name, e.g.
+	// kubepods-burstable-pod<uid>.slice, processes are run in scopes under it.
+	CgroupDriverSystemd CgroupDriver = "systemd"
+)
+
+const (
+	// defaultSystemdSlice is a parent slice of pods with no cgroup parent
+	// when systemd driver is used, see defaultCgroup for cgroupfs one.
+	defaultSystemdSlice = "singularity_cri"
+	// systemdScopePrefix prefixes scopes pod and container processes
+	// are run in when systemd driver is used.
+	systemdScopePrefix = "sycri"
+)
+
+// ParseCgroupDriver checks that driver is supported, empty
+// driver stands for CgroupDriverCgroupfs.
+func ParseCgroupDriver(driver string) (CgroupDriver, error) {
+	switch CgroupDriver(driver) {
+	case "", CgroupDriverCgroupfs:
+		return CgroupDriverCgroupfs, nil
+	case CgroupDriverSystemd:
+		return CgroupDriverSystemd, nil
+	}
+	return "", fmt.Errorf("unknown cgroup driver %q", driver)
+}
+
+// WithCgroupDriver sets how cgroup parents of pods are interpreted.
+// By default CgroupDriverCgroupfs is used.
+func WithCgroupDriver(driver CgroupDriver) PodOption {
+	return func(p *Pod) {
+		p.cgroupDriver = driver
+	}
+}
+
+// defaultParent returns cgroup parent of pod with the passed ID
+// that has no cgroup parent set by kubelet.
+func (d CgroupDriver) defaultParent(podID string) string {
+	if d == CgroupDriverSystemd {
+		return defaultSystemdSlice + "-pod" + podID + ".slice"
+	}
+	return filepath.Join(defaultCgroup, podID)
+}
+
+// path returns path of cgroup parent in cgroup hierarchies.
+func (d CgroupDriver) path(parent string) (string, error) {
+	if d == CgroupDriverSystemd {
+		return expandSlice(parent)
+	}
+	return parent, nil
+}
+
+// leaf returns name of cgroup process with the passed ID is run in under pod cgroup.
+func (d CgroupDriver) leaf(id string) string {
+	if d == CgroupDriverSystemd {
+		return systemdScopePrefix + "-" + id + ".scope"
+	}
+	return id
+}
+
+// expandSlice converts systemd slice name into cgroup path the same way systemd
+// does, e.g. kubepods-burstable-pod1.slice becomes
+// /kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1.slice.
+func expandSlice(slice string) (string, error) {
+	const suffix = ".slice"
+	if !strings.HasSuffix(slice, suffix) || strings.Contains(slice, "/") {
+		return "", fmt.Errorf("invalid slice name %q", slice)
+	}
+	if slice == "-"+suffix {
+		return "/", nil
+	}
+	name := strings.TrimSuffix(slice, suffix)
+	var path, prefix string
+	for _, component := range strings.Split(name, "-") {
+		if component == "" {
+			return "", fmt.Errorf("invalid slice name %q", slice)
+		}
+		path += "/" + prefix + component + suffix
+		prefix += component + "-"
+	}
+	return path, nil
+}
+
+// ParsePodResources returns pod cgroup limits set by AnnotationPodResources.
+// Nil resources are returned when annotation is not set.
+func ParsePodResources(annotations map[string]string) (*k8s.LinuxContainerResources, error) {
+	value, ok := annotations[AnnotationPodResources]
+	if !ok {
+		return nil, nil
+	}
+	var res k8s.LinuxContainerResources
+	if err := json.Unmarshal([]byte(value), &res); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", AnnotationPodResources, err)
+	}
+	if res.CpuPeriod < 0 || res.MemoryLimitInBytes < 0 || res.CpuShares < 0 {
+		return nil, fmt.Errorf("invalid %s annotation: negative values are not allowed", AnnotationPodResources)
+	}
+	if err := ValidateCPUWeight(&res); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", AnnotationPodResources, err)
+	}
+	return &res, nil
+}
+
+// setupCgroup creates pod cgroup in every mounted hierarchy and applies pod
+// resources to it. Cgroups that exist already, e.g. created by kubelet, are
+// used as is and are never removed by the runtime, see removeCgroup. With
+// systemd driver pod slice is created via systemd first, directories of
+// hierarchies systemd doesn't manage, e.g. cpuset, are created as usual.
+// Fake engine processes share cgroups with the runtime and are skipped.
+func (p *Pod) setupCgroup() error {
+	if runtime.IsFake(p.cli) {
+		return nil
+	}
+	if p.cgroupDriver == CgroupDriverSystemd {
+		if err := p.setupSlice(); err != nil {
+			return err
+		}
+	}
+	mounts, err := readCgroupMounts()
+	if err != nil {
+		return err
+	}
+	for _, mount := range mounts {
+		if mount.unified && mount.mountPoint != unifiedMount {
+			// controllers of hybrid setup are in v1 hierarchies
+			continue
+		}
+		if !mount.unified && !hasV1Controller(mount) {
+			continue
+		}
+		dir, ok := mount.dir(p.cgroupPath)
+		if !ok {
+			continue
+		}
+		if err := p.createCgroup(mount, dir); err != nil {
+			return err
+		}
+		if p.resources == nil {
+			continue
+		}
+		for _, f := range podCgroupFiles(mount, p.resources) {
+			path := filepath.Join(dir, f.name)
+			if err := ioutil.WriteFile(path, []byte(f.value), 0644); err != nil {
+				return fmt.Errorf("could not set pod %s to %s: %v", f.name, f.value, err)
+			}
+		}
+	}
+	glog.V(4).Infof("Pod %s cgroup is set up at %s", p.id, p.cgroupPath)
+	return nil
+}
+
+// createCgroup creates cgroup directory along with missing ancestors. Only the
+// directory itself is remembered as created, ancestors may be shared with other
+// pods. Cpuset of created cgroup v1 directories is copied from their parents,
+// otherwise no process could join them.
+func (p *Pod) createCgroup(mount cgroupMount, dir string) error {
+	if mount.unified {
+		if _, err := os.Stat(dir); err == nil {
+			return nil
+		}
+		created, err := createUnifiedCgroup(mount.mountPoint, dir)
+		if err != nil {
+			return err
+		}
+		if created {
+			p.ownCgroups = append(p.ownCgroups, dir)
+		}
+		return nil
+	}
+
+	var missing []string
+	for d := dir; d != mount.mountPoint && d != "/"; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append([]string{d}, missing...)
+	}
+	for _, d := range missing {
+		if err := os.Mkdir(d, 0755); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("could not create cgroup %s: %v", d, err)
+		}
+		if d == dir {
+			p.ownCgroups = append(p.ownCgroups, d)
+		}
+		if !mount.options["cpuset"] {
+			continue
+		}
+		for _, name := range []string{"cpuset.cpus", "cpuset.mems"} {
+			data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(d), name))
+			if err != nil {
+				return fmt.Errorf("could not read parent %s: %v", name, err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(d, name), data, 0644); err != nil {
+				return fmt.Errorf("could not set %s of %s: %v", name, d, err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeCgroup removes cgroups created by setupCgroup. It must be
+// called once all pod and container processes are gone.
+func (p *Pod) removeCgroup() {
+	p.stopUnits()
+	for i := len(p.ownCgroups) - 1; i >= 0; i-- {
+		dir := p.ownCgroups[i]
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			glog.Warningf("Could not remove pod %s cgroup %s: %v", p.id, dir, err)
+		}
+	}
+	p.ownCgroups = nil
+}
+
+// CgroupParent returns path of pod cgroup in cgroup hierarchies.
+func (p *Pod) CgroupParent() string {
+	return p.cgroupPath
+}
+
+// podCgroupFiles translates pod resources into files of controllers
+// available in the passed hierarchy.
+func podCgroupFiles(mount cgroupMount, res *k8s.LinuxContainerResources) []cgroupFile {
+	if mount.unified {
+		return unifiedResources(res, 0)
+	}
+	var files []cgroupFile
+	if mount.options["cpu"] {
+		if shares := res.GetCpuShares(); shares != 0 {
+			files = append(files, cgroupFile{name: "cpu.shares", value: strconv.FormatInt(shares, 10)})
+		}
+		if quota := res.GetCpuQuota(); quota > 0 {
+			period := res.GetCpuPeriod()
+			if period <= 0 {
+				period = defaultCPUPeriod
+			}
+			// period goes first so that quota is checked against the new one
+			files = append(files,
+				cgroupFile{name: "cpu.cfs_period_us", value: strconv.FormatInt(period, 10)},
+				cgroupFile{name: "cpu.cfs_quota_us", value: strconv.FormatInt(quota, 10)})
+		}
+	}
+	if mount.options["memory"] {
+		if limit := res.GetMemoryLimitInBytes(); limit > 0 {
+			files = append(files, cgroupFile{name: "memory.limit_in_bytes", value: strconv.FormatInt(limit, 10)})
+		}
+	}
+	if mount.options["cpuset"] {
+		if cpus := res.GetCpusetCpus(); cpus != "" {
+			files = append(files, cgroupFile{name: "cpuset.cpus", value: cpus})
+		}
+		if mems := res.GetCpusetMems(); mems != "" {
+			files = append(files, cgroupFile{name: "cpuset.mems", value: mems})
+		}
+	}
+	return files
+}
+
+func hasV1Controller(mount cgroupMount) bool {
+	for _, c := range cgroupV1Controllers {
+		if mount.options[c] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestExpandSlice(t *testing.T) {
+	tt := []struct {
+		slice       string
+		expect      string
+		expectError bool
+	}{
+		{slice: "-.slice", expect: "/"},
+		{slice: "kubepods.slice", expect: "/kubepods.slice"},
+		{
+			slice:  "kubepods-burstable-pod1234.slice",
+			expect: "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234.slice",
+		},
+		{slice: "kubepods", expectError: true},
+		{slice: "kubepods--burstable.slice", expectError: true},
+		{slice: "/kubepods/burstable.slice", expectError: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.slice, func(t *testing.T) {
+			path, err := expandSlice(tc.slice)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, path)
+		})
+	}
+}
+
+func TestPod_cgroupPath(t *testing.T) {
+	tt := []struct {
+		name       string
+		driver     CgroupDriver
+		parent     string
+		expectPath string
+		expectLeaf string
+		expectErr  bool
+	}{
+		{
+			name:       "cgroupfs default",
+			driver:     CgroupDriverCgroupfs,
+			expectPath: "singularity-cri/abc",
+			expectLeaf: "singularity-cri/abc/abc",
+		},
+		{
+			name:       "cgroupfs kubelet parent",
+			driver:     CgroupDriverCgroupfs,
+			parent:     "/kubepods/burstable/pod1",
+			expectPath: "/kubepods/burstable/pod1",
+			expectLeaf: "/kubepods/burstable/pod1/abc",
+		},
+		{
+			name:       "systemd default",
+			driver:     CgroupDriverSystemd,
+			expectPath: "/singularity_cri.slice/singularity_cri-podabc.slice",
+			expectLeaf: "/singularity_cri.slice/singularity_cri-podabc.slice/sycri-abc.scope",
+		},
+		{
+			name:       "systemd kubelet parent",
+			driver:     CgroupDriverSystemd,
+			parent:     "kubepods-pod1.slice",
+			expectPath: "/kubepods.slice/kubepods-pod1.slice",
+			expectLeaf: "/kubepods.slice/kubepods-pod1.slice/sycri-abc.scope",
+		},
+		{
+			name:      "systemd with cgroupfs parent",
+			driver:    CgroupDriverSystemd,
+			parent:    "/kubepods/pod1",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := NewPod(&k8s.PodSandboxConfig{
+				Metadata: &k8s.PodSandboxMetadata{Name: "pod", Namespace: "default"},
+				Hostname: "pod",
+				Linux:    &k8s.LinuxPodSandboxConfig{CgroupParent: tc.parent},
+			}, WithCgroupDriver(tc.driver))
+			pod.id = "abc"
+			err := pod.validateConfig()
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectPath, pod.CgroupParent())
+
+			spec, err := translatePod(pod)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectLeaf, spec.Linux.CgroupsPath)
+		})
+	}
+}
+
+func TestParsePodResources(t *testing.T) {
+	tt := []struct {
+		name        string
+		value       string
+		expect      *k8s.LinuxContainerResources
+		expectError bool
+	}{
+		{
+			name:   "limits",
+			value:  `{"cpu_quota":200000,"cpu_period":100000,"memory_limit_in_bytes":1073741824}`,
+			expect: &k8s.LinuxContainerResources{CpuQuota: 200000, CpuPeriod: 100000, MemoryLimitInBytes: 1 << 30},
+		},
+		{
+			name:        "not JSON",
+			value:       "2 cpus",
+			expectError: true,
+		},
+		{
+			name:        "negative memory",
+			value:       `{"memory_limit_in_bytes":-1}`,
+			expectError: true,
+		},
+		{
+			name:        "shares out of range",
+			value:       `{"cpu_shares":1}`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := ParsePodResources(map[string]string{AnnotationPodResources: tc.value})
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, res)
+		})
+	}
+}
+
+func TestPodCgroupFiles(t *testing.T) {
+	res := &k8s.LinuxContainerResources{
+		CpuShares:          512,
+		CpuQuota:           50000,
+		MemoryLimitInBytes: 1 << 20,
+		CpusetCpus:         "0-1",
+	}
+	cpu := cgroupMount{options: map[string]bool{"cpu": true, "cpuacct": true}}
+	require.Equal(t, []cgroupFile{
+		{name: "cpu.shares", value: "512"},
+		{name: "cpu.cfs_period_us", value: "100000"},
+		{name: "cpu.cfs_quota_us", value: "50000"},
+	}, podCgroupFiles(cpu, res))
+
+	memory := cgroupMount{options: map[string]bool{"memory": true}}
+	require.Equal(t, []cgroupFile{{name: "memory.limit_in_bytes", value: "1048576"}}, podCgroupFiles(memory, res))
+
+	unified := cgroupMount{unified: true}
+	require.Equal(t, unifiedResources(res, 0), podCgroupFiles(unified, res))
+}
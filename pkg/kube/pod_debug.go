@@ -0,0 +1,108 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+)
+
+// AnnotationDebugSandbox is a pod annotation that makes pod keep its files,
+// namespaces and partial bundle for inspection when it fails to run.
+const AnnotationDebugSandbox = "singularity.cri/debug-sandbox"
+
+// Stages of pod run that are not recorded as phases.
+const (
+	StageConfig = "config"
+	StageHook   = "hook"
+)
+
+// RunFailure describes why pod failed to run.
+type RunFailure struct {
+	// Stage is pod run stage that failed, e.g. engineCreate or network.
+	Stage string `json:"stage"`
+	// Error is the error stage failed with.
+	Error string `json:"error"`
+	// Stderr is engine stderr output, if the stage ran engine command.
+	Stderr string `json:"stderr,omitempty"`
+	// FailedAt is time failure occurred at.
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// ParseDebugSandbox checks pod annotation that requests
+// pod artifacts to be kept on failure.
+func ParseDebugSandbox(annotations map[string]string) (bool, error) {
+	value, ok := annotations[AnnotationDebugSandbox]
+	if !ok {
+		return false, nil
+	}
+	debug, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s annotation %q: expected boolean", AnnotationDebugSandbox, value)
+	}
+	return debug, nil
+}
+
+// WithRetainOnFailure makes pod keep its files, namespaces and partial
+// bundle when it fails to run, so that they can be inspected. Such pod
+// is not ready and must be removed with Remove. By default everything
+// created is removed as soon as pod fails to run.
+func WithRetainOnFailure(retain bool) PodOption {
+	return func(p *Pod) {
+		p.retainOnFailure = retain
+	}
+}
+
+// Fail records failure of pod run stage and stops pod process. Unless pod
+// retains artifacts on failure, all pod files are removed as well. Failed
+// pod state is not updated from the runtime anymore.
+func (p *Pod) Fail(stage string, err error) {
+	if p.failure != nil {
+		return
+	}
+	p.failure = &RunFailure{
+		Stage:    stage,
+		Error:    err.Error(),
+		Stderr:   p.engineStderr,
+		FailedAt: time.Now(),
+	}
+	if err := p.terminate(true); err != nil {
+		glog.Errorf("Could not kill pod after failed run: %v", err)
+	}
+	if err := p.cli.Delete(p.id); err != nil && err != runtime.ErrNotFound {
+		glog.Errorf("Could not remove pod: %v", err)
+	}
+	if p.retainOnFailure {
+		glog.Warningf("Pod %s failed at %s stage, keeping its files at %s for inspection", p.id, stage, p.baseDir)
+		return
+	}
+	if err := p.cleanupFiles(true); err != nil {
+		glog.Errorf("Could not cleanup pod after failed run: %v", err)
+	}
+}
+
+// Failure returns why pod failed to run or nil if it didn't fail.
+func (p *Pod) Failure() *RunFailure {
+	return p.failure
+}
+
+// Retained returns true if pod failed to run and kept its artifacts.
+func (p *Pod) Retained() bool {
+	return p.failure != nil && p.retainOnFailure
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// deletedEngine reports all instances as already deleted,
+// any other call panics.
+type deletedEngine struct {
+	runtime.Engine
+}
+
+func (deletedEngine) Delete(id string) error {
+	return runtime.ErrNotFound
+}
+
+func TestParseDebugSandbox(t *testing.T) {
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		expect      bool
+		expectError bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:        "enabled",
+			annotations: map[string]string{AnnotationDebugSandbox: "true"},
+			expect:      true,
+		},
+		{
+			name:        "disabled",
+			annotations: map[string]string{AnnotationDebugSandbox: "false"},
+		},
+		{
+			name:        "invalid",
+			annotations: map[string]string{AnnotationDebugSandbox: "yes please"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			debug, err := ParseDebugSandbox(tc.annotations)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, debug)
+		})
+	}
+}
+
+func TestPod_Fail(t *testing.T) {
+	tt := []struct {
+		name   string
+		retain bool
+	}{
+		{
+			name:   "cleanup",
+			retain: false,
+		},
+		{
+			name:   "retain",
+			retain: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			baseDir, err := ioutil.TempDir("", "")
+			require.NoError(t, err)
+			defer os.RemoveAll(baseDir)
+			require.NoError(t, ioutil.WriteFile(filepath.Join(baseDir, "hostname"), []byte("pod"), 0644))
+
+			pod := NewPod(&k8s.PodSandboxConfig{}, WithPodEngine(deletedEngine{}), WithRetainOnFailure(tc.retain))
+			pod.baseDir = baseDir
+			pod.runtimeState = runtime.StateExited
+			pod.engineStderr = "FATAL: could not mount"
+			require.Nil(t, pod.Failure())
+			require.False(t, pod.Retained())
+
+			pod.Fail(PhaseEngineStart.String(), fmt.Errorf("could not start pod"))
+			failure := pod.Failure()
+			require.NotNil(t, failure)
+			require.Equal(t, "engineStart", failure.Stage)
+			require.Equal(t, "could not start pod", failure.Error)
+			require.Equal(t, "FATAL: could not mount", failure.Stderr)
+			require.False(t, failure.FailedAt.IsZero())
+			require.Equal(t, tc.retain, pod.Retained())
+
+			// the first failure is kept
+			pod.Fail(PhaseNetwork.String(), fmt.Errorf("could not set up network"))
+			require.Equal(t, failure, pod.Failure())
+
+			// state of failed pod is not queried
+			require.NoError(t, pod.UpdateState())
+			require.Equal(t, k8s.PodSandboxState_SANDBOX_NOTREADY, pod.State())
+			require.Zero(t, pod.Pid())
+			require.Zero(t, pod.CreatedAt())
+
+			_, err = os.Stat(baseDir)
+			if tc.retain {
+				require.NoError(t, err, "retained pod files are removed")
+				require.NoError(t, pod.Remove())
+				_, err = os.Stat(baseDir)
+			}
+			require.True(t, os.IsNotExist(err), "pod files are not removed")
+		})
+	}
+}
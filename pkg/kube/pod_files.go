@@ -22,6 +22,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity-cri/pkg/fs"
 	"github.com/sylabs/singularity-cri/pkg/namespace"
 )
 
@@ -29,11 +30,21 @@ const (
 	podNsStorePath    = "namespaces/"
 	podResolvConfPath = "resolv.conf"
 	podHostnamePath   = "hostname"
+	podHostsPath      = "hosts"
 	podSocketPath     = "sync.sock"
 
 	podBundlePath    = "bundle/"
 	podRootfsPath    = "rootfs/"
 	podOCIConfigPath = "config.json"
+
+	podLogDirPerm = 0750
+)
+
+// hostHostsPath and hostResolvConfPath are host files that pods
+// in host network namespace get copies of.
+var (
+	hostHostsPath      = "/etc/hosts"
+	hostResolvConfPath = "/etc/resolv.conf"
 )
 
 // namespacePath returns path to pod's namespace file of the passed type.
@@ -53,6 +64,11 @@ func (p *Pod) hostnameFilePath() string {
 	return filepath.Join(p.baseDir, podHostnamePath)
 }
 
+// hostsFilePath returns path to pod's hosts file.
+func (p *Pod) hostsFilePath() string {
+	return filepath.Join(p.baseDir, podHostsPath)
+}
+
 // resolvConfFilePath returns path to pod's resolv.conf file.
 func (p *Pod) resolvConfFilePath() string {
 	return filepath.Join(p.baseDir, podResolvConfPath)
@@ -73,6 +89,11 @@ func (p *Pod) ociConfigPath() string {
 	return filepath.Join(p.baseDir, podBundlePath, podOCIConfigPath)
 }
 
+// Spec returns OCI runtime spec pod was created with.
+func (p *Pod) Spec() (*specs.Spec, error) {
+	return readSpec(p.ociConfigPath())
+}
+
 // socketPath returns path to pod's sync socket.
 func (p *Pod) socketPath() string {
 	return filepath.Join(p.baseDir, podSocketPath)
@@ -80,6 +101,9 @@ func (p *Pod) socketPath() string {
 
 // bindNamespacePath returns path to pod's namespace file of the passed type.
 func (p *Pod) bindNamespacePath(nsType specs.LinuxNamespaceType) string {
+	if nsType == specs.NetworkNamespace && p.netNsDir != "" {
+		return filepath.Join(p.netNsDir, p.id)
+	}
 	return filepath.Join(p.baseDir, podNsStorePath, string(nsType))
 }
 
@@ -90,21 +114,66 @@ func (p *Pod) prepareFiles() error {
 	if err != nil {
 		return fmt.Errorf("could not create directory for pod: %v", err)
 	}
+	if p.netNsDir != "" {
+		if err := os.MkdirAll(p.netNsDir, 0755); err != nil {
+			return fmt.Errorf("could not create network namespaces directory: %v", err)
+		}
+	}
 	if err := p.addLogDirectory(); err != nil {
 		return fmt.Errorf("could not create log directory: %v", err)
 	}
-	if err := writeResolvConf(p.resolvConfFilePath(), p.GetDnsConfig()); err != nil {
+	if err := p.addResolvConf(); err != nil {
 		return fmt.Errorf("could not create resolv.conf: %v", err)
 	}
 	if err := p.addHostname(); err != nil {
 		return fmt.Errorf("could not create hostname file: %v", err)
 	}
+	if err := p.addHosts(); err != nil {
+		return fmt.Errorf("could not create hosts file: %v", err)
+	}
 	return nil
 }
 
+// addHosts (re)generates pod's hosts file. Pod IP entries are
+// added only when network is already set up. Pods in host network
+// namespace get host's hosts file instead of the standard entries.
+func (p *Pod) addHosts() error {
+	if p.hostNetwork() {
+		return copyHosts(p.hostsFilePath(), hostHostsPath, p.extraHosts)
+	}
+	return writeHosts(p.hostsFilePath(), p.GetHostname(), p.IPs(), p.extraHosts)
+}
+
+// addResolvConf generates pod's resolv.conf from pod DNS config. Pods in
+// host network namespace without DNS config get a copy of host's resolv.conf,
+// other pods without DNS config have no resolv.conf, see hasResolvConf.
+func (p *Pod) addResolvConf() error {
+	if p.GetDnsConfig() != nil || !p.hostNetwork() {
+		return writeResolvConf(p.resolvConfFilePath(), p.GetDnsConfig())
+	}
+	if _, err := os.Stat(hostResolvConfPath); os.IsNotExist(err) {
+		glog.Warningf("Host has no resolv.conf, pod %s is left without one", p.id)
+		return nil
+	}
+	glog.V(5).Infof("Copying %s to %s", hostResolvConfPath, p.resolvConfFilePath())
+	return copyFile(hostResolvConfPath, p.resolvConfFilePath())
+}
+
+// hasResolvConf checks whether pod's resolv.conf is generated.
+func (p *Pod) hasResolvConf() bool {
+	if p.GetDnsConfig() != nil {
+		return true
+	}
+	if !p.hostNetwork() {
+		return false
+	}
+	_, err := os.Stat(p.resolvConfFilePath())
+	return err == nil
+}
+
 func (p *Pod) addHostname() error {
 	glog.V(5).Infof("Creating hostname file %s", p.hostnameFilePath())
-	host, err := os.OpenFile(p.hostnameFilePath(), os.O_RDWR|os.O_CREATE, 0644)
+	host, err := os.OpenFile(p.hostnameFilePath(), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("could not create %s: %v", podHostnamePath, err)
 	}
@@ -115,16 +184,24 @@ func (p *Pod) addHostname() error {
 	return nil
 }
 
+// addLogDirectory creates pod log directory if it doesn't exist yet.
+// Log directory is owned by kubelet, so it is never removed by CRI.
 func (p *Pod) addLogDirectory() error {
 	logDir := p.GetLogDirectory()
 	if logDir == "" {
 		return nil
 	}
 	glog.V(5).Infof("Creating log directory %s", logDir)
-	err := os.MkdirAll(logDir, 0755)
+	err := os.MkdirAll(logDir, podLogDirPerm)
 	if err != nil {
 		return fmt.Errorf("could not create %s: %v", logDir, err)
 	}
+	if p.logOwner != nil {
+		err = os.Chown(logDir, p.logOwner.UID, p.logOwner.GID)
+		if err != nil {
+			return fmt.Errorf("could not change %s owner: %v", logDir, err)
+		}
+	}
 	return nil
 }
 
@@ -139,15 +216,13 @@ func (p *Pod) addOCIBundle() error {
 		return fmt.Errorf("could not generate OCI spec for pod: %v", err)
 	}
 	glog.V(5).Infof("Creating oci config %s", p.ociConfigPath())
-	config, err := os.OpenFile(p.ociConfigPath(), os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		return fmt.Errorf("could not create OCI config file: %v", err)
-	}
-	defer config.Close()
-	err = json.NewEncoder(config).Encode(spec)
+	config, err := json.Marshal(spec)
 	if err != nil {
 		return fmt.Errorf("could not encode OCI config into json: %v", err)
 	}
+	if err := fs.WriteFileAtomic(p.ociConfigPath(), config, 0600); err != nil {
+		return fmt.Errorf("could not create OCI config file: %v", err)
+	}
 	return nil
 }
 
@@ -172,13 +247,5 @@ func (p *Pod) cleanupFiles(silent bool) error {
 		}
 		glog.Errorf("Could not cleanup pod: %v", err)
 	}
-	glog.V(5).Infof("Removing pod log directory %s", p.GetLogDirectory())
-	err = os.RemoveAll(p.GetLogDirectory())
-	if err != nil {
-		if !silent {
-			return fmt.Errorf("could not remove log directory: %v", err)
-		}
-		glog.Errorf("Could not remove log directory: %v", err)
-	}
 	return nil
 }
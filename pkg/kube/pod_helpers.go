@@ -0,0 +1,172 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// Cgroups helper resource usage may be charged to, see Helper.
+const (
+	ChargedToPod       = "pod"
+	ChargedToContainer = "container"
+	ChargedToDaemon    = "daemon"
+)
+
+// Helper describes a process or goroutine runtime keeps on behalf of a pod
+// and a cgroup its resource usage is charged to. Pid is set only for
+// long living helper processes.
+type Helper struct {
+	Name      string `json:"name"`
+	Container string `json:"container,omitempty"`
+	Pid       int    `json:"pid,omitempty"`
+	ChargedTo string `json:"chargedTo"`
+}
+
+// CgroupProcsFiles returns cgroup.procs files of pod cgroups. Writing pid
+// into them charges the process resource usage to the pod.
+func (p *Pod) CgroupProcsFiles() ([]string, error) {
+	if p.Pid() == 0 {
+		return nil, fmt.Errorf("pod is not running")
+	}
+	return cgroupProcsFiles(p.Pid())
+}
+
+// PlaceHelpers moves engine processes monitoring pod and its containers into
+// pod cgroups, so that their usage is charged to the pod rather than to the
+// runtime. It is safe to call PlaceHelpers multiple times, e.g. after a pod
+// is restored, already placed processes are skipped.
+func (p *Pod) PlaceHelpers() error {
+	if err := p.placeMonitor("", p.Pid()); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	containers := append([]*Container(nil), p.containers...)
+	p.mu.Unlock()
+	for _, c := range containers {
+		if c.Pid() == 0 {
+			continue
+		}
+		if err := p.placeMonitor(c.id, c.Pid()); err != nil {
+			return fmt.Errorf("could not place container %s helpers: %v", c.id, err)
+		}
+	}
+	return nil
+}
+
+// placeMonitor moves monitor of the pod or container process with the passed
+// pid into pod cgroups. Processes run by runtime directly, e.g. by fake engine,
+// have no monitor and are left as they are.
+func (p *Pod) placeMonitor(id string, pid int) error {
+	monitor, err := parentPid(procDir, pid)
+	if err != nil {
+		return fmt.Errorf("could not find engine monitor: %v", err)
+	}
+	if monitor <= 1 || monitor == os.Getpid() {
+		return nil
+	}
+
+	p.mu.Lock()
+	placed := p.monitors[id] == monitor
+	p.mu.Unlock()
+	if placed {
+		return nil
+	}
+
+	procs, err := p.CgroupProcsFiles()
+	if err != nil {
+		return fmt.Errorf("could not find pod cgroups: %v", err)
+	}
+	if err := joinCgroups(monitor, procs); err != nil {
+		return err
+	}
+	glog.V(4).Infof("Engine monitor %d of %s is charged to pod %s", monitor, id, p.id)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.monitors == nil {
+		p.monitors = make(map[string]int)
+	}
+	p.monitors[id] = monitor
+	return nil
+}
+
+// Helpers reports helpers runtime keeps for the pod and where their
+// resource usage is charged to. Engine monitors that could not be placed
+// into pod cgroups are reported as charged to daemon.
+func (p *Pod) Helpers() []Helper {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	monitor := func(id string) Helper {
+		h := Helper{Name: "engine-monitor", Container: id, ChargedTo: ChargedToDaemon}
+		if pid, ok := p.monitors[id]; ok {
+			h.Pid, h.ChargedTo = pid, ChargedToPod
+		}
+		return h
+	}
+	helpers := []Helper{
+		// holder process exits before pod cgroups are created
+		{Name: "namespace-holder", ChargedTo: ChargedToDaemon},
+		monitor(""),
+	}
+	for _, c := range p.containers {
+		helpers = append(helpers, monitor(c.id))
+	}
+	return append(helpers,
+		Helper{Name: "exec-session", ChargedTo: ChargedToContainer},
+		Helper{Name: "port-forward", ChargedTo: ChargedToPod},
+		// log forwarders are goroutines and cannot leave daemon cgroups
+		Helper{Name: "log-forwarder", ChargedTo: ChargedToDaemon},
+	)
+}
+
+// joinCgroups moves process with the passed pid into cgroups
+// by writing it into the passed cgroup.procs files.
+func joinCgroups(pid int, procs []string) error {
+	for _, file := range procs {
+		err := ioutil.WriteFile(file, []byte(strconv.Itoa(pid)), 0644)
+		if err != nil {
+			return fmt.Errorf("could not move %d into %s: %v", pid, filepath.Dir(file), err)
+		}
+	}
+	return nil
+}
+
+// parentPid reads parent pid of the process from its stat file, see proc(5).
+func parentPid(procDir string, pid int) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// command is in parentheses and may contain spaces
+	stat := string(data)
+	i := strings.LastIndexByte(stat, ')')
+	if i == -1 {
+		return 0, fmt.Errorf("unexpected stat format")
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected stat format")
+	}
+	return strconv.Atoi(fields[1])
+}
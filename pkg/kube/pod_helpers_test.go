@@ -0,0 +1,136 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity/pkg/ociruntime"
+)
+
+func TestPod_PlaceHelpers(t *testing.T) {
+	podCgroup := filepath.Join("/sys/fs/cgroup/pids", fmt.Sprintf("sycri-helpers-test-%d", os.Getpid()))
+	if err := os.Mkdir(podCgroup, 0755); err != nil {
+		t.Skipf("could not create pids cgroup: %v", err)
+	}
+	defer os.Remove(podCgroup)
+	procsFile := filepath.Join(podCgroup, "cgroup.procs")
+
+	podCmd := exec.Command("sleep", "30")
+	require.NoError(t, podCmd.Start())
+	defer func() {
+		podCmd.Process.Kill()
+		podCmd.Wait()
+	}()
+	require.NoError(t, joinCgroups(podCmd.Process.Pid, []string{procsFile}))
+
+	// shell plays engine monitor of the sleeping container process
+	monitorCmd := exec.Command("sh", "-c", "sleep 30 & echo $!; wait")
+	stdout, err := monitorCmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, monitorCmd.Start())
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	contPid, err := strconv.Atoi(strings.TrimSpace(line))
+	require.NoError(t, err)
+	defer func() {
+		syscall.Kill(contPid, syscall.SIGKILL)
+		monitorCmd.Process.Kill()
+		monitorCmd.Wait()
+	}()
+
+	pod := &Pod{
+		id:       "pod",
+		ociState: &ociruntime.State{State: specs.State{Pid: podCmd.Process.Pid}},
+	}
+	pod.containers = []*Container{{
+		id:       "cont",
+		ociState: &ociruntime.State{State: specs.State{Pid: contPid}},
+	}}
+	require.NoError(t, pod.PlaceHelpers())
+	// placing is idempotent
+	require.NoError(t, pod.PlaceHelpers())
+
+	data, err := ioutil.ReadFile(procsFile)
+	require.NoError(t, err)
+	pids := strings.Fields(string(data))
+	require.Contains(t, pids, strconv.Itoa(monitorCmd.Process.Pid), "monitor is not in pod cgroup")
+	require.NotContains(t, pids, strconv.Itoa(contPid), "container process is moved")
+
+	helpers := pod.Helpers()
+	require.Contains(t, helpers, Helper{Name: "engine-monitor", ChargedTo: ChargedToDaemon})
+	require.Contains(t, helpers, Helper{
+		Name:      "engine-monitor",
+		Container: "cont",
+		Pid:       monitorCmd.Process.Pid,
+		ChargedTo: ChargedToPod,
+	})
+	require.Contains(t, helpers, Helper{Name: "log-forwarder", ChargedTo: ChargedToDaemon})
+
+	pod.removeContainer(pod.containers[0])
+	require.Empty(t, pod.monitors)
+}
+
+func TestParentPid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	for pid, stat := range map[string]string{
+		"10": "10 (sleep) S 7 10 7 0 -1",
+		"11": "11 (my (odd) cmd) S 8 11 8 0 -1",
+		"12": "12 (broken",
+	} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, pid), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, pid, "stat"), []byte(stat), 0644))
+	}
+
+	tt := []struct {
+		pid         int
+		expectPid   int
+		expectError bool
+	}{
+		{pid: 10, expectPid: 7},
+		{pid: 11, expectPid: 8},
+		{pid: 12, expectError: true},
+		{pid: 13, expectError: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(strconv.Itoa(tc.pid), func(t *testing.T) {
+			ppid, err := parentPid(dir, tc.pid)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectPid, ppid)
+		})
+	}
+	ppid, err := parentPid(procDir, os.Getpid())
+	require.NoError(t, err)
+	require.Equal(t, os.Getppid(), ppid)
+}
@@ -15,16 +15,68 @@
 package kube
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity-cri/pkg/namespace"
 	"github.com/sylabs/singularity-cri/pkg/network"
+	"github.com/sylabs/singularity-cri/pkg/trace"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
-// NetworkStatus returns pod's IP address.
+// WithNetNsDir sets directory pod network namespace is pinned under, the
+// namespace file is named after pod ID. Keeping network namespaces in a
+// dedicated directory allows cleaning up the ones leaked by a crashed daemon,
+// see RemoveStaleNetNs. By default namespace is pinned in pod base directory.
+func WithNetNsDir(dir string) PodOption {
+	return func(p *Pod) {
+		p.netNsDir = dir
+	}
+}
+
+// RemoveStaleNetNs unmounts and removes network namespaces pinned under
+// dir by pods that are not known, i.e. keep returns false. IDs of pods
+// whose namespaces were removed are returned.
+func RemoveStaleNetNs(dir string, keep func(podID string) bool) ([]string, error) {
+	fii, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read network namespaces directory: %v", err)
+	}
+	var removed []string
+	for _, fi := range fii {
+		if keep(fi.Name()) {
+			continue
+		}
+		ns := specs.LinuxNamespace{
+			Type: specs.NetworkNamespace,
+			Path: filepath.Join(dir, fi.Name()),
+		}
+		glog.V(3).Infof("Removing stale network namespace %s", ns.Path)
+		if err := namespace.Remove(ns); err != nil {
+			glog.Errorf("Could not remove stale network namespace: %v", err)
+			continue
+		}
+		removed = append(removed, fi.Name())
+	}
+	return removed, nil
+}
+
+// NetworkStatus returns pod's primary IP address. CRI version implemented
+// here has no room for additional addresses, for dual-stack pods they are
+// reported in verbose pod status, see IPs.
 func (p *Pod) NetworkStatus() *k8s.PodSandboxNetworkStatus {
+	if len(p.restoredIPs) != 0 {
+		return &k8s.PodSandboxNetworkStatus{Ip: p.restoredIPs[0]}
+	}
 	if p.network == nil {
 		return nil
 	}
@@ -36,31 +88,85 @@ func (p *Pod) NetworkStatus() *k8s.PodSandboxNetworkStatus {
 	return &k8s.PodSandboxNetworkStatus{Ip: netIP.String()}
 }
 
+// IPs returns all pod's IP addresses with the primary one first.
+func (p *Pod) IPs() []string {
+	if len(p.restoredIPs) != 0 {
+		return p.restoredIPs
+	}
+	if p.network == nil {
+		return nil
+	}
+	netIPs, err := p.network.GetIPs()
+	if err != nil {
+		glog.Warningf("Could not get IPs for pod %s: %v", p.id, err)
+		return nil
+	}
+	ips := make([]string, len(netIPs))
+	for i, ip := range netIPs {
+		ips[i] = ip.String()
+	}
+	return ips
+}
+
+// Networks returns interfaces of all CNI networks pod is attached to,
+// default network goes first.
+func (p *Pod) Networks() []network.Attachment {
+	if p.network == nil {
+		return nil
+	}
+	return p.network.Attachments()
+}
+
+// NetNsPath returns path to pod's network namespace. If pod
+// doesn't have a dedicated network namespace an empty string is returned.
+func (p *Pod) NetNsPath() string {
+	return p.namespacePath(specs.NetworkNamespace)
+}
+
 // SetUpNetwork brings up network interface and configure it
 // inside pod's network namespace.
-func (p *Pod) SetUpNetwork(manager *network.Manager) error {
+func (p *Pod) SetUpNetwork(ctx context.Context, manager *network.Manager) error {
 	nsPath := p.namespacePath(specs.NetworkNamespace)
 	if nsPath == "" {
 		return nil
 	}
+	// annotations are validated on pod creation
+	networks, _ := ParseNetworks(p.GetAnnotations())
 	networkConfig := &network.PodConfig{
 		ID:           p.id,
-		Namespace:    p.GetMetadata().Namespace,
-		Name:         p.GetMetadata().Name,
+		Namespace:    p.GetMetadata().GetNamespace(),
+		Name:         p.GetMetadata().GetName(),
 		NsPath:       nsPath,
 		PortMappings: p.GetPortMappings(),
+		Networks:     networks,
 	}
-	net, err := manager.SetUpPod(networkConfig)
+	ctx, span := trace.Start(ctx, "cni.setup")
+	span.SetAttribute("pod.id", p.id)
+	start := time.Now()
+	net, err := manager.SetUpPod(ctx, networkConfig)
+	span.SetError(err)
+	span.End()
 	if err != nil {
 		return fmt.Errorf("could not set up pod's network: %v", err)
 	}
+	p.phases.record(PhaseNetwork, start)
 	p.network = net
+	if err := p.setUpNetQoS(nsPath); err != nil {
+		return fmt.Errorf("could not set up pod's traffic marking: %v", err)
+	}
+	if err := p.addHosts(); err != nil {
+		return fmt.Errorf("could not update hosts file: %v", err)
+	}
 	return nil
 }
 
 // TearDownNetwork tears down network interface previously
 // set inside pod's network namespace.
 func (p *Pod) TearDownNetwork(manager *network.Manager) error {
+	if err := p.tearDownNetQoS(p.namespacePath(specs.NetworkNamespace)); err != nil {
+		return fmt.Errorf("could not tear down pod's traffic marking: %v", err)
+	}
+	p.restoredIPs = nil
 	if p.network == nil {
 		return nil
 	}
@@ -0,0 +1,60 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPod_NetNsPath(t *testing.T) {
+	pod := &Pod{
+		id:         "pod1",
+		baseDir:    "/run/sycri/pods/pod1",
+		namespaces: []specs.LinuxNamespace{{Type: specs.NetworkNamespace}, {Type: specs.UTSNamespace}},
+	}
+	require.Equal(t, "/run/sycri/pods/pod1/namespaces/network", pod.NetNsPath())
+
+	WithNetNsDir("/run/sycri/netns")(pod)
+	require.Equal(t, "/run/sycri/netns/pod1", pod.NetNsPath())
+	require.Equal(t, "/run/sycri/pods/pod1/namespaces/uts", pod.namespacePath(specs.UTSNamespace))
+}
+
+func TestRemoveStaleNetNs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netns-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	removed, err := RemoveStaleNetNs(filepath.Join(dir, "missing"), nil)
+	require.NoError(t, err)
+	require.Empty(t, removed)
+
+	for _, id := range []string{"alive", "leaked"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, id), nil, 0644))
+	}
+	removed, err = RemoveStaleNetNs(dir, func(podID string) bool {
+		return podID == "alive"
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"leaked"}, removed)
+	require.FileExists(t, filepath.Join(dir, "alive"))
+	_, err = os.Stat(filepath.Join(dir, "leaked"))
+	require.True(t, os.IsNotExist(err), "stale namespace must be removed")
+}
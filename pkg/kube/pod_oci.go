@@ -15,15 +15,10 @@
 package kube
 
 import (
-	"fmt"
-	"io/ioutil"
+	"path/filepath"
 
-	"github.com/golang/glog"
-	"github.com/kubernetes-sigs/cri-o/pkg/seccomp"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/opencontainers/runtime-tools/generate"
-	"github.com/opencontainers/selinux/go-selinux/label"
-	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
 type podTranslator struct {
@@ -49,7 +44,12 @@ func (t *podTranslator) translate() (*specs.Spec, error) {
 	t.g.SetRootPath(t.pod.rootfsPath())
 	t.g.SetRootReadonly(false)
 
-	t.g.SetHostname(t.pod.GetHostname())
+	// hostname can only be set in a private UTS namespace
+	hostname := t.pod.GetHostname()
+	if t.pod.hostUTS() {
+		hostname = ""
+	}
+	t.g.SetHostname(hostname)
 	t.g.AddMount(specs.Mount{
 		Destination: "/proc",
 		Source:      "proc",
@@ -67,90 +67,53 @@ func (t *podTranslator) translate() (*specs.Spec, error) {
 	for _, ns := range t.pod.namespaces {
 		t.g.AddOrReplaceLinuxNamespace(string(ns.Type), ns.Path)
 	}
+	if t.pod.namespacePath(specs.UserNamespace) != "" {
+		for _, m := range t.pod.userNs.UIDMappings {
+			t.g.AddLinuxUIDMapping(m.HostID, m.ContainerID, m.Size)
+		}
+		for _, m := range t.pod.userNs.GIDMappings {
+			t.g.AddLinuxGIDMapping(m.HostID, m.ContainerID, m.Size)
+		}
+	}
 	t.g.AddOrReplaceLinuxNamespace(string(specs.MountNamespace), "")
 
-	for k, v := range t.pod.GetAnnotations() {
+	for k, v := range filterAnnotations(t.pod.GetAnnotations(), t.pod.allowedAnnotations) {
 		t.g.AddAnnotation(k, v)
 	}
-	for k, v := range t.pod.GetLinux().GetSysctls() {
+	t.g.AddAnnotation(AnnotationPodName, t.pod.GetMetadata().GetName())
+	t.g.AddAnnotation(AnnotationPodNamespace, t.pod.GetMetadata().GetNamespace())
+	t.g.AddAnnotation(AnnotationPodUID, t.pod.GetMetadata().GetUid())
+	t.g.AddAnnotation(AnnotationSandboxID, t.pod.id)
+	t.g.AddAnnotation(AnnotationContainerType, containerTypeSandbox)
+	for k, v := range t.pod.sysctls {
 		t.g.AddLinuxSysctl(k, v)
 	}
 
 	security := t.pod.GetLinux().GetSecurityContext()
-	if err := setupSELinux(&t.g, security.GetSelinuxOptions()); err != nil {
-		return nil, err
-	}
+	if !security.GetPrivileged() || security.GetSelinuxOptions() != nil {
+		var err error
+		t.pod.processLabel, t.pod.mountLabel, err = selinuxLabels(security.GetSelinuxOptions(), "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	setSELinuxLabels(&t.g, t.pod.processLabel, t.pod.mountLabel)
 	if err := setupSeccomp(&t.g, security.GetSeccompProfilePath()); err != nil {
 		return nil, err
 	}
 
-	t.g.SetLinuxCgroupsPath(t.pod.GetLinux().GetCgroupParent())
+	t.g.SetLinuxCgroupsPath(filepath.Join(t.pod.cgroupPath, t.pod.cgroupDriver.leaf(t.pod.id)))
 	t.g.SetRootReadonly(security.GetReadonlyRootfs())
 	t.g.SetProcessUID(uint32(security.GetRunAsUser().GetValue()))
 	t.g.SetProcessGID(uint32(security.GetRunAsGroup().GetValue()))
 	for _, gid := range security.GetSupplementalGroups() {
 		t.g.AddProcessAdditionalGid(uint32(gid))
 	}
+	// annotation is validated on pod creation
+	noNewPrivs, _ := ParseNoNewPrivs(t.pod.GetAnnotations())
+	t.g.SetProcessNoNewPrivileges(noNewPrivs)
 
 	// simply apply privileged at the end of the config
 	t.g.SetupPrivileged(security.GetPrivileged())
 	return t.g.Config, nil
 }
-
-func setupSELinux(g *generate.Generator, options *k8s.SELinuxOption) error {
-	if options == nil {
-		return nil
-	}
-
-	var labels []string
-	if options.GetUser() != "" {
-		labels = append(labels, "user:"+options.GetUser())
-	}
-	if options.GetRole() != "" {
-		labels = append(labels, "role:"+options.GetRole())
-	}
-	if options.GetType() != "" {
-		labels = append(labels, "type:"+options.GetType())
-	}
-	if options.GetLevel() != "" {
-		labels = append(labels, "level:"+options.GetLevel())
-	}
-	processLabel, mountLabel, err := label.InitLabels(labels)
-	if err != nil {
-		return fmt.Errorf("could not init selinux labels: %v", err)
-	}
-	glog.V(3).Infof("Setting mount label to %q", mountLabel)
-	g.SetLinuxMountLabel(mountLabel)
-	glog.V(3).Infof("Setting process's SELinux label to %q", processLabel)
-	g.SetProcessSelinuxLabel(processLabel)
-	return nil
-}
-
-func setupSeccomp(g *generate.Generator, profile string) error {
-	if profile == "" {
-		return nil
-	}
-	if g.Config.Linux == nil {
-		g.Config.Linux = new(specs.Linux)
-	}
-	if profile == unconfinedSeccompProfile {
-		// drop any default config
-		g.Config.Linux.Seccomp = nil
-		return nil
-	}
-
-	data, err := ioutil.ReadFile(profile)
-	if err != nil {
-		return fmt.Errorf("could not read seccomp profile: %v", err)
-	}
-	if g.Config.Process == nil {
-		g.Config.Process = new(specs.Process)
-	}
-	if g.Config.Process.Capabilities == nil {
-		g.Config.Process.Capabilities = new(specs.LinuxCapabilities)
-	}
-	if err := seccomp.LoadProfileFromBytes(data, g); err != nil {
-		return fmt.Errorf("could not setup seccomp: %v", err)
-	}
-	return nil
-}
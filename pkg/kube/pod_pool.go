@@ -0,0 +1,111 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/golang/glog"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// AnnotationWarmPool names resource class of the warm pool pod sandbox
+// should be adopted from instead of being created from scratch.
+const AnnotationWarmPool = "singularity.cri/warm-pool"
+
+// CheckAdoptable checks whether pod with config may adopt sandbox that was
+// created with template config. Only properties that cannot be changed once
+// sandbox is running are compared: namespace, namespace options, user the
+// sandbox runs as, SELinux options, sysctls, port mappings and traffic marking.
+// Network is set up for the template, so pods with port mappings or traffic
+// marking annotations never adopt pooled sandboxes.
+func CheckAdoptable(template, config *k8s.PodSandboxConfig) error {
+	if template.GetMetadata().GetNamespace() != config.GetMetadata().GetNamespace() {
+		return fmt.Errorf("pooled sandbox belongs to namespace %q", template.GetMetadata().GetNamespace())
+	}
+	if len(config.GetPortMappings()) != 0 {
+		return fmt.Errorf("port mappings are not supported")
+	}
+	if len(config.GetLinux().GetSysctls()) != 0 {
+		return fmt.Errorf("sysctls are not supported")
+	}
+	for _, key := range []string{AnnotationNetFwmark, AnnotationNetConnmark, AnnotationNetworks, AnnotationUserNamespace,
+		AnnotationSysctls, AnnotationUnsafeSysctls} {
+		if _, ok := config.GetAnnotations()[key]; ok {
+			return fmt.Errorf("%s annotation is not supported", key)
+		}
+	}
+
+	want := template.GetLinux().GetSecurityContext()
+	got := config.GetLinux().GetSecurityContext()
+	wantNs, gotNs := want.GetNamespaceOptions(), got.GetNamespaceOptions()
+	if wantNs.GetNetwork() != gotNs.GetNetwork() || wantNs.GetPid() != gotNs.GetPid() || wantNs.GetIpc() != gotNs.GetIpc() {
+		return fmt.Errorf("namespace options %v differ from pooled sandbox ones %v", gotNs, wantNs)
+	}
+	if !reflect.DeepEqual(want.GetRunAsUser(), got.GetRunAsUser()) ||
+		!reflect.DeepEqual(want.GetRunAsGroup(), got.GetRunAsGroup()) {
+		return fmt.Errorf("pooled sandbox runs as a different user")
+	}
+	if want.GetPrivileged() != got.GetPrivileged() {
+		return fmt.Errorf("privileged differs from pooled sandbox")
+	}
+	if !reflect.DeepEqual(want.GetSelinuxOptions(), got.GetSelinuxOptions()) {
+		return fmt.Errorf("SELinux options differ from pooled sandbox")
+	}
+	return nil
+}
+
+// Adopt hands running pod created from a placeholder config over to the pod
+// with the passed config, see CheckAdoptable. Pod keeps its ID, namespaces
+// and network, while metadata, labels, annotations, hostname, DNS config and
+// log directory of config are used from now on. Containers are placed into
+// cgroup parent of config. Pod must not be indexed yet.
+func (p *Pod) Adopt(config *k8s.PodSandboxConfig) error {
+	if err := CheckAdoptable(p.PodSandboxConfig, config); err != nil {
+		return err
+	}
+	template := p.PodSandboxConfig
+	p.PodSandboxConfig = config
+	if err := p.validateConfig(); err != nil {
+		p.PodSandboxConfig = template
+		return fmt.Errorf("invalid pod config: %v", err)
+	}
+	if err := p.setupCgroup(); err != nil {
+		return fmt.Errorf("could not set up pod cgroup: %v", err)
+	}
+	if err := p.addLogDirectory(); err != nil {
+		return fmt.Errorf("could not create log directory: %v", err)
+	}
+	if err := p.addResolvConf(); err != nil {
+		return fmt.Errorf("could not create resolv.conf: %v", err)
+	}
+	if err := p.addHostname(); err != nil {
+		return fmt.Errorf("could not create hostname file: %v", err)
+	}
+	if err := p.addHosts(); err != nil {
+		return fmt.Errorf("could not create hosts file: %v", err)
+	}
+	p.adoptedAt = time.Now().UnixNano()
+	glog.V(3).Infof("Pooled pod %s is adopted by %s/%s", p.id,
+		config.GetMetadata().GetNamespace(), config.GetMetadata().GetName())
+	return nil
+}
+
+// Adopted reports whether pod was taken from warm pool, see Adopt.
+func (p *Pod) Adopted() bool {
+	return p.adoptedAt != 0
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func poolConfig(namespace string, user int64) *k8s.PodSandboxConfig {
+	return &k8s.PodSandboxConfig{
+		Metadata: &k8s.PodSandboxMetadata{Name: "job", Namespace: namespace, Uid: "uid"},
+		Hostname: "job",
+		Linux: &k8s.LinuxPodSandboxConfig{
+			SecurityContext: &k8s.LinuxSandboxSecurityContext{
+				RunAsUser: &k8s.Int64Value{Value: user},
+				NamespaceOptions: &k8s.NamespaceOption{
+					Network: k8s.NamespaceMode_POD,
+					Pid:     k8s.NamespaceMode_CONTAINER,
+					Ipc:     k8s.NamespaceMode_POD,
+				},
+			},
+		},
+	}
+}
+
+func TestCheckAdoptable(t *testing.T) {
+	tt := []struct {
+		name        string
+		modify      func(config *k8s.PodSandboxConfig)
+		expectError string
+	}{
+		{
+			name:   "adoptable",
+			modify: func(config *k8s.PodSandboxConfig) {},
+		},
+		{
+			name: "other namespace",
+			modify: func(config *k8s.PodSandboxConfig) {
+				config.Metadata.Namespace = "default"
+			},
+			expectError: `pooled sandbox belongs to namespace "batch"`,
+		},
+		{
+			name: "port mappings",
+			modify: func(config *k8s.PodSandboxConfig) {
+				config.PortMappings = []*k8s.PortMapping{{ContainerPort: 80, HostPort: 8080}}
+			},
+			expectError: "port mappings are not supported",
+		},
+		{
+			name: "sysctls",
+			modify: func(config *k8s.PodSandboxConfig) {
+				config.Linux.Sysctls = map[string]string{"net.ipv4.ip_forward": "1"}
+			},
+			expectError: "sysctls are not supported",
+		},
+		{
+			name: "fwmark",
+			modify: func(config *k8s.PodSandboxConfig) {
+				config.Annotations = map[string]string{AnnotationNetFwmark: "0x10"}
+			},
+			expectError: AnnotationNetFwmark + " annotation is not supported",
+		},
+		{
+			name: "host network",
+			modify: func(config *k8s.PodSandboxConfig) {
+				config.Linux.SecurityContext.NamespaceOptions.Network = k8s.NamespaceMode_NODE
+			},
+			expectError: "namespace options",
+		},
+		{
+			name: "other user",
+			modify: func(config *k8s.PodSandboxConfig) {
+				config.Linux.SecurityContext.RunAsUser.Value = 0
+			},
+			expectError: "pooled sandbox runs as a different user",
+		},
+		{
+			name: "group set",
+			modify: func(config *k8s.PodSandboxConfig) {
+				config.Linux.SecurityContext.RunAsGroup = &k8s.Int64Value{Value: 1000}
+			},
+			expectError: "pooled sandbox runs as a different user",
+		},
+		{
+			name: "privileged",
+			modify: func(config *k8s.PodSandboxConfig) {
+				config.Linux.SecurityContext.Privileged = true
+			},
+			expectError: "privileged differs from pooled sandbox",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			config := poolConfig("batch", 1000)
+			tc.modify(config)
+			err := CheckAdoptable(poolConfig("batch", 1000), config)
+			if tc.expectError == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expectError)
+		})
+	}
+}
+
+func TestPod_Adopt(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(baseDir)
+
+	template := poolConfig("batch", 1000)
+	template.Metadata.Name = "warm-pool-small-12345678"
+	template.Hostname = "warm-pool"
+	pod := NewPod(template)
+	pod.baseDir = baseDir
+	id := pod.ID()
+
+	// rejected config does not change pod
+	err = pod.Adopt(poolConfig("default", 1000))
+	require.Error(t, err)
+	require.Equal(t, template, pod.PodSandboxConfig)
+	require.False(t, pod.Adopted())
+
+	config := poolConfig("batch", 1000)
+	config.LogDirectory = filepath.Join(baseDir, "logs")
+	config.DnsConfig = &k8s.DNSConfig{Servers: []string{"10.0.0.10"}}
+	require.NoError(t, pod.Adopt(config))
+	require.True(t, pod.Adopted())
+	require.Equal(t, id, pod.ID())
+	require.Equal(t, "job", pod.GetMetadata().GetName())
+	require.NotZero(t, pod.CreatedAt(), "creation time is not updated")
+
+	hostname, err := ioutil.ReadFile(pod.hostnameFilePath())
+	require.NoError(t, err)
+	require.Equal(t, "job\n", string(hostname))
+	resolv, err := ioutil.ReadFile(pod.resolvConfFilePath())
+	require.NoError(t, err)
+	require.Contains(t, string(resolv), "nameserver 10.0.0.10")
+	_, err = os.Stat(config.LogDirectory)
+	require.NoError(t, err)
+}
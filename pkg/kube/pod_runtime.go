@@ -17,22 +17,34 @@ package kube
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sylabs/singularity-cri/pkg/namespace"
 	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity/pkg/ociruntime"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
-func (p *Pod) spawnOCIPod() error {
-	// PID namespace is a special case, to create it pod process should be run
-	podPID := p.GetLinux().GetSecurityContext().GetNamespaceOptions().GetPid() == k8s.NamespaceMode_POD
+func (p *Pod) spawnOCIPod(ctx context.Context) error {
+	// PID namespace is a special case, to create it pod process should be run;
+	// pod process becomes init of the shared namespace and reaps processes
+	// of pod containers that got orphaned, e.g. after sidecar killed its parent
+	podPID := p.GetLinux().GetSecurityContext().GetNamespaceOptions().GetPid() == k8s.NamespaceMode_POD &&
+		!runtime.IsFake(p.cli)
 	if podPID {
 		p.namespaces = append(p.namespaces, specs.LinuxNamespace{
 			Type: specs.PIDNamespace,
 		})
 	}
+	// user namespace is created by pod process as well,
+	// so that engine sets up its ID mappings
+	if p.userNs != nil && !runtime.IsFake(p.cli) {
+		p.namespaces = append(p.namespaces, specs.LinuxNamespace{
+			Type: specs.UserNamespace,
+		})
+	}
 
 	err := p.addOCIBundle()
 	if err != nil {
@@ -47,51 +59,80 @@ func (p *Pod) spawnOCIPod() error {
 	}
 
 	glog.V(3).Infof("Creating pod %s", p.id)
-	pty, err := p.cli.Create(p.id, p.bundlePath(), false, false, "--empty-process", "--sync-socket", p.socketPath())
+	start := time.Now()
+	pty, err := p.cli.Create(ctx, p.id, p.bundlePath(), false, false, "--empty-process", "--sync-socket", p.socketPath())
 	if err != nil {
+		p.engineStderr = runtime.Stderr(err)
 		return fmt.Errorf("could not create pod: %v", err)
 	}
 	defer pty.Close()
 
-	if err := p.expectState(runtime.StateCreating); err != nil {
+	if err := p.expectState(ctx, runtime.StateCreating); err != nil {
 		return err
 	}
-	if err := p.expectState(runtime.StateCreated); err != nil {
+	if err := p.expectState(ctx, runtime.StateCreated); err != nil {
 		return err
 	}
+	p.phases.record(PhaseEngineCreate, start)
 
 	glog.V(3).Infof("Starting pod %s", p.id)
-	if err := p.cli.Start(p.id); err != nil {
+	start = time.Now()
+	if err := p.cli.Start(ctx, p.id); err != nil {
+		p.engineStderr = runtime.Stderr(err)
 		return fmt.Errorf("could not start pod: %v", err)
 	}
 
-	if err := p.expectState(runtime.StateRunning); err != nil {
+	if err := p.expectState(ctx, runtime.StateRunning); err != nil {
 		return err
 	}
+	p.phases.record(PhaseEngineStart, start)
 
 	podState, err := p.cli.State(p.id)
 	if err != nil {
 		return fmt.Errorf("could not get pod pid: %v", err)
 	}
 
-	if podPID {
-		for i, ns := range p.namespaces {
-			if ns.Type != specs.PIDNamespace {
-				continue
-			}
-			p.namespaces[i].Path = p.bindNamespacePath(ns.Type)
-			err := namespace.Bind(podState.Pid, p.namespaces[i])
-			if err != nil {
-				return fmt.Errorf("could not bind PID namespace: %v", err)
-			}
+	for i, ns := range p.namespaces {
+		if ns.Type != specs.PIDNamespace && ns.Type != specs.UserNamespace {
+			continue
+		}
+		p.namespaces[i].Path = p.bindNamespacePath(ns.Type)
+		err := namespace.Bind(podState.Pid, p.namespaces[i])
+		if err != nil {
+			return fmt.Errorf("could not bind %s namespace: %v", ns.Type, err)
 		}
 	}
+	if podPID {
+		return checkNamespaceInit(podState.Pid)
+	}
 	return nil
 }
 
-// UpdateState updates container state according to information
-// received from the runtime.
+// checkNamespaceInit checks that pod process with the passed host pid is
+// init of its PID namespace, otherwise nothing would reap orphaned processes
+// of containers sharing pod PID namespace.
+func checkNamespaceInit(pid int) error {
+	nsPid, err := namespace.InnermostPid(pid)
+	if err != nil {
+		return fmt.Errorf("could not check pod PID namespace init: %v", err)
+	}
+	if nsPid == 0 {
+		glog.V(4).Infof("Kernel doesn't report namespace pid of pod process %d", pid)
+		return nil
+	}
+	if nsPid != 1 {
+		return fmt.Errorf("pod process is not init of pod PID namespace: has pid %d", nsPid)
+	}
+	return nil
+}
+
+// UpdateState updates pod state according to information
+// received from the runtime. State of failed pod is not known
+// to the runtime, so the last observed state is kept.
 func (p *Pod) UpdateState() error {
+	if p.failure != nil {
+		return nil
+	}
 	var err error
 	p.ociState, err = p.cli.State(p.id)
 	if err != nil {
@@ -103,11 +144,31 @@ func (p *Pod) UpdateState() error {
 
 // Pid returns pid of the pod process in the host's PID namespace.
 func (p *Pod) Pid() int {
+	if p.ociState == nil {
+		return 0
+	}
 	return p.ociState.Pid
 }
 
-func (p *Pod) expectState(expect runtime.State) error {
-	p.runtimeState = <-p.syncChan
+// EngineState returns a copy of pod state last reported by the engine,
+// nil if the pod was never run.
+func (p *Pod) EngineState() *ociruntime.State {
+	if p.ociState == nil {
+		return nil
+	}
+	state := *p.ociState
+	return &state
+}
+
+// expectState waits for the next pod state change until ctx is done
+// and checks it is the expected one.
+func (p *Pod) expectState(ctx context.Context, expect runtime.State) error {
+	select {
+	case state := <-p.syncChan:
+		p.runtimeState = state
+	case <-ctx.Done():
+		return fmt.Errorf("could not wait for pod state %v: %v", expect, ctx.Err())
+	}
 	if p.runtimeState != expect {
 		return fmt.Errorf("unexpected pod state: %v", p.runtimeState)
 	}
@@ -136,5 +197,5 @@ func (p *Pod) terminate(force bool) error {
 	if err != nil {
 		return fmt.Errorf("could not terminate pod: %v", err)
 	}
-	return p.expectState(runtime.StateExited)
+	return p.expectState(context.Background(), runtime.StateExited)
 }
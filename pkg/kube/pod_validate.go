@@ -17,44 +17,22 @@ package kube
 import (
 	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/golang/glog"
-	"github.com/opencontainers/runtime-spec/specs-go"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
-var (
-	sysctlToNs = map[string]specs.LinuxNamespaceType{
-		"kernel.shm": specs.IPCNamespace,
-		"kernel.msg": specs.IPCNamespace,
-		"kernel.sem": specs.IPCNamespace,
-		"fs.mqueue.": specs.IPCNamespace,
-		"net.":       specs.NetworkNamespace,
-	}
-)
-
 const (
 	defaultCgroup = "singularity-cri"
 )
 
 func (p *Pod) validateConfig() error {
-	hasIPC := p.GetLinux().GetSecurityContext().GetNamespaceOptions().GetIpc() == k8s.NamespaceMode_POD
-	hasNET := p.GetLinux().GetSecurityContext().GetNamespaceOptions().GetNetwork() == k8s.NamespaceMode_POD
-
-	for sysctl := range p.GetLinux().GetSysctls() {
-		for prefix, nsType := range sysctlToNs {
-			if strings.HasPrefix(sysctl, prefix) {
-				if (nsType == specs.IPCNamespace && !hasIPC) ||
-					(nsType == specs.NetworkNamespace && !hasNET) {
-					return fmt.Errorf("sysctl %s requires a separate %s namespace", sysctl, nsType)
-				}
-			}
-		}
+	var err error
+	p.sysctls, err = PodSysctls(p.PodSandboxConfig, p.sysctlPolicy)
+	if err != nil {
+		return err
 	}
 
-	var err error
 	hostname := p.GetHostname()
 	if hostname == "" {
 		hostname, err = os.Hostname()
@@ -65,17 +43,50 @@ func (p *Pod) validateConfig() error {
 		p.Hostname = hostname
 	}
 
-	cgroupsPath := p.GetLinux().GetCgroupParent()
-	if cgroupsPath == "" {
-		cgroupsPath = filepath.Join(defaultCgroup, p.id)
-		glog.V(2).Infof("Setting pod's %s cgroup parent to default value %q", p.id, cgroupsPath)
+	cgroupParent := p.GetLinux().GetCgroupParent()
+	if cgroupParent == "" {
+		cgroupParent = p.cgroupDriver.defaultParent(p.id)
+		glog.V(2).Infof("Setting pod's %s cgroup parent to default value %q", p.id, cgroupParent)
 		if p.GetLinux() == nil {
 			p.Linux = new(k8s.LinuxPodSandboxConfig)
 		}
-		p.Linux.CgroupParent = cgroupsPath
+		p.Linux.CgroupParent = cgroupParent
+	}
+	p.cgroupPath, err = p.cgroupDriver.path(cgroupParent)
+	if err != nil {
+		return fmt.Errorf("invalid cgroup parent for %s cgroup driver: %v", p.cgroupDriver, err)
+	}
+	p.resources, err = ParsePodResources(p.GetAnnotations())
+	if err != nil {
+		return err
 	}
 
+	p.extraHosts, err = ParseExtraHosts(p.GetAnnotations())
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation: %v", AnnotationAddHosts, err)
+	}
+	p.timezone, err = ParseTimezone(p.GetAnnotations())
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation: %v", AnnotationTimezone, err)
+	}
+	if _, err := ParseNoNewPrivs(p.GetAnnotations()); err != nil {
+		return err
+	}
+	if _, err := ParseImageKey(p.GetAnnotations()); err != nil {
+		return err
+	}
+
+	p.userNs, err = PodUserNamespace(p.PodSandboxConfig, p.userNsDefaults)
+	if err != nil {
+		return err
+	}
 	security := p.GetLinux().GetSecurityContext()
+	if p.userNs != nil {
+		uid, gid := uint32(security.GetRunAsUser().GetValue()), uint32(security.GetRunAsGroup().GetValue())
+		if err := checkMapped(p.userNs, uid, gid); err != nil {
+			return err
+		}
+	}
 	if security != nil {
 		scProfile, err := prepareSeccompPath(security.GetSeccompProfilePath())
 		if err != nil {
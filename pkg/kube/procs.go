@@ -0,0 +1,180 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MaxFdWalk caps number of file descriptors counted per request so that
+// a container leaking descriptors does not stall status collection.
+const MaxFdWalk = 1 << 16
+
+const procDir = "/proc"
+
+// ProcessCounts holds number of threads and open file descriptors of all
+// processes in container cgroup. Fds is a lower bound when FdsTruncated is set.
+// Counts that could not be collected are left nil.
+type ProcessCounts struct {
+	Processes    int
+	Threads      *int
+	Fds          *int
+	FdsTruncated bool
+}
+
+// FdsString returns open file descriptor count, prefixed with >= when
+// counting was stopped at the limit. Empty string is returned when
+// count is unknown.
+func (c *ProcessCounts) FdsString() string {
+	if c.Fds == nil {
+		return ""
+	}
+	if c.FdsTruncated {
+		return ">=" + strconv.Itoa(*c.Fds)
+	}
+	return strconv.Itoa(*c.Fds)
+}
+
+// ProcessCounts counts threads and open file descriptors of container
+// processes enumerated from its memory cgroup.
+func (c *Container) ProcessCounts() (*ProcessCounts, error) {
+	dir, err := cgroupV1Dir(c.Pid(), "memory")
+	if err != nil {
+		return nil, err
+	}
+	pids, err := cgroupProcs(dir)
+	if err != nil {
+		return nil, err
+	}
+	return countProcesses(procDir, pids, MaxFdWalk), nil
+}
+
+// cgroupProcs returns pids of processes in the cgroup and all its children.
+func cgroupProcs(dir string) ([]int, error) {
+	var pids []int
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || fi.Name() != "cgroup.procs" {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			pid, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+			if err != nil {
+				return fmt.Errorf("invalid pid in %s: %v", path, err)
+			}
+			pids = append(pids, pid)
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read cgroup processes: %v", err)
+	}
+	return pids, nil
+}
+
+// countProcesses counts threads and open file descriptors of the passed
+// processes, processes that have exited meanwhile are skipped. At most
+// limit file descriptors are counted in total.
+func countProcesses(procDir string, pids []int, limit int) *ProcessCounts {
+	var counts ProcessCounts
+	threads, fds := 0, 0
+	threadsOK, fdsOK := true, true
+	for _, pid := range pids {
+		n, err := processThreads(procDir, pid)
+		if os.IsNotExist(err) {
+			continue
+		}
+		counts.Processes++
+		if err != nil {
+			threadsOK = false
+		}
+		threads += n
+
+		if !fdsOK || counts.FdsTruncated {
+			continue
+		}
+		n, truncated, err := processFds(procDir, pid, limit-fds)
+		if err != nil && !os.IsNotExist(err) {
+			fdsOK = false
+		}
+		fds += n
+		counts.FdsTruncated = truncated
+	}
+	if threadsOK && counts.Processes != 0 {
+		counts.Threads = &threads
+	}
+	if fdsOK && counts.Processes != 0 {
+		counts.Fds = &fds
+	}
+	return &counts
+}
+
+// processThreads reads number of process threads from /proc/<pid>/status.
+func processThreads(procDir string, pid int) (int, error) {
+	f, err := os.Open(filepath.Join(procDir, strconv.Itoa(pid), "status"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Threads:") {
+			continue
+		}
+		return strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Threads:")))
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no threads count in process %d status", pid)
+}
+
+// processFds counts entries of /proc/<pid>/fd, reading at most limit of them.
+// It reports whether there were more descriptors than limit.
+func processFds(procDir string, pid, limit int) (int, bool, error) {
+	if limit <= 0 {
+		return 0, true, nil
+	}
+	d, err := os.Open(filepath.Join(procDir, strconv.Itoa(pid), "fd"))
+	if err != nil {
+		return 0, false, err
+	}
+	defer d.Close()
+
+	names, err := d.Readdirnames(limit + 1)
+	if err != nil && err != io.EOF {
+		return 0, false, err
+	}
+	if len(names) > limit {
+		return limit, true, nil
+	}
+	return len(names), false, nil
+}
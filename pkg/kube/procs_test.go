@@ -0,0 +1,109 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgroupProcs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("10\n12\n"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "child"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "child", "cgroup.procs"), []byte("15\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tasks"), []byte("10\n11\n12\n15\n"), 0644))
+
+	pids, err := cgroupProcs(dir)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int{10, 12, 15}, pids)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("ten\n"), 0644))
+	_, err = cgroupProcs(dir)
+	require.Error(t, err)
+}
+
+func TestCountProcesses(t *testing.T) {
+	// helper holds stdin, stdout, stderr and three more descriptors
+	cmd := exec.Command("sh", "-c", "exec 3</dev/null 4</dev/null 5</dev/null; echo ready; exec sleep 30")
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	_, err = bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+
+	// exited processes are skipped
+	exited := exec.Command("true")
+	require.NoError(t, exited.Run())
+	pids := []int{cmd.Process.Pid, exited.Process.Pid}
+
+	tt := []struct {
+		name            string
+		procDir         string
+		limit           int
+		expectProcesses int
+		expectThreads   bool
+		expectFds       string
+	}{
+		{
+			name:            "all counted",
+			procDir:         procDir,
+			limit:           MaxFdWalk,
+			expectProcesses: 1,
+			expectThreads:   true,
+			expectFds:       "6",
+		},
+		{
+			name:            "fds capped",
+			procDir:         procDir,
+			limit:           4,
+			expectProcesses: 1,
+			expectThreads:   true,
+			expectFds:       ">=4",
+		},
+		{
+			name:    "no proc",
+			procDir: "/not/exists",
+			limit:   MaxFdWalk,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			counts := countProcesses(tc.procDir, pids, tc.limit)
+			require.Equal(t, tc.expectProcesses, counts.Processes)
+			require.Equal(t, tc.expectFds, counts.FdsString())
+			if !tc.expectThreads {
+				require.Nil(t, counts.Threads)
+				return
+			}
+			require.NotNil(t, counts.Threads)
+			require.Equal(t, 1, *counts.Threads)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// mountInfoPath is read to find propagation of host mounts.
+var mountInfoPath = "/proc/self/mountinfo"
+
+// mountPeer is a mount point along with its propagation type.
+type mountPeer struct {
+	mountPoint string
+	shared     bool
+	slave      bool
+}
+
+// mountPathEscaper unescapes mount points as written by the kernel into mountinfo.
+var mountPathEscaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// checkPropagation checks that the host mount holding source is able to propagate
+// mounts the way container requests. Bidirectional propagation requires shared
+// host mount and privileged container, host to container propagation requires
+// either shared or slave host mount, otherwise late appearing host mounts would
+// silently never show up in container.
+func checkPropagation(source string, propagation k8s.MountPropagation, privileged bool) error {
+	if propagation == k8s.MountPropagation_PROPAGATION_PRIVATE {
+		return nil
+	}
+	if propagation == k8s.MountPropagation_PROPAGATION_BIDIRECTIONAL && !privileged {
+		return &MountError{HostPath: source, Reason: "bidirectional propagation requires privileged container"}
+	}
+
+	mountInfo, err := os.Open(mountInfoPath)
+	if err != nil {
+		return fmt.Errorf("could not open mountinfo: %v", err)
+	}
+	defer mountInfo.Close()
+	peers, err := parseMountPeers(mountInfo)
+	if err != nil {
+		return fmt.Errorf("could not read mountinfo: %v", err)
+	}
+	peer := findMountPeer(peers, source)
+	if peer == nil {
+		return fmt.Errorf("could not find mount point of %s", source)
+	}
+	switch {
+	case peer.shared:
+		return nil
+	case peer.slave && propagation == k8s.MountPropagation_PROPAGATION_HOST_TO_CONTAINER:
+		return nil
+	case propagation == k8s.MountPropagation_PROPAGATION_BIDIRECTIONAL:
+		return &MountError{HostPath: source, Reason: fmt.Sprintf("%s is mounted on %s which is not a shared mount", source, peer.mountPoint)}
+	default:
+		return &MountError{HostPath: source, Reason: fmt.Sprintf("%s is mounted on %s which is neither a shared nor a slave mount", source, peer.mountPoint)}
+	}
+}
+
+// parseMountPeers reads mount points with their propagation from mountinfo, see proc(5).
+func parseMountPeers(r io.Reader) ([]mountPeer, error) {
+	var peers []mountPeer
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		sep := strings.Index(line, " - ")
+		if sep == -1 {
+			continue
+		}
+		fields := strings.Fields(line[:sep])
+		if len(fields) < 6 {
+			continue
+		}
+		peer := mountPeer{mountPoint: mountPathEscaper.Replace(fields[4])}
+		for _, tag := range fields[6:] {
+			switch {
+			case strings.HasPrefix(tag, "shared:"):
+				peer.shared = true
+			case strings.HasPrefix(tag, "master:"):
+				peer.slave = true
+			}
+		}
+		peers = append(peers, peer)
+	}
+	return peers, scanner.Err()
+}
+
+// findMountPeer returns the mount path belongs to, i.e. the one with the longest
+// mount point containing path. Mount listed later wins, as it is stacked on top.
+func findMountPeer(peers []mountPeer, path string) *mountPeer {
+	var found *mountPeer
+	for i := range peers {
+		if peers[i].mountPoint != "/" && !isWithin(peers[i].mountPoint, path) {
+			continue
+		}
+		if found == nil || len(peers[i].mountPoint) >= len(found.mountPoint) {
+			found = &peers[i]
+		}
+	}
+	return found
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const testMountInfo = `21 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+22 21 0:20 / /proc rw,nosuid,nodev,noexec,relatime shared:5 - proc proc rw
+30 21 8:2 / /var/lib/kubelet rw,relatime - ext4 /dev/sda2 rw
+31 30 0:45 / /var/lib/kubelet/plugins rw,relatime master:7 - tmpfs tmpfs rw
+32 30 0:46 / /var/lib/kubelet/pods rw,relatime shared:9 master:8 - tmpfs tmpfs rw
+33 21 0:47 / /mnt/csi\040data rw,relatime - tmpfs tmpfs rw
+`
+
+func TestParseMountPeers(t *testing.T) {
+	peers, err := parseMountPeers(strings.NewReader(testMountInfo))
+	require.NoError(t, err)
+	require.Equal(t, []mountPeer{
+		{mountPoint: "/", shared: true},
+		{mountPoint: "/proc", shared: true},
+		{mountPoint: "/var/lib/kubelet"},
+		{mountPoint: "/var/lib/kubelet/plugins", slave: true},
+		{mountPoint: "/var/lib/kubelet/pods", shared: true, slave: true},
+		{mountPoint: "/mnt/csi data"},
+	}, peers)
+
+	require.Equal(t, "/", findMountPeer(peers, "/opt/data").mountPoint)
+	require.Equal(t, "/var/lib/kubelet", findMountPeer(peers, "/var/lib/kubelet/plugins-registry").mountPoint)
+	require.Equal(t, "/var/lib/kubelet/plugins", findMountPeer(peers, "/var/lib/kubelet/plugins/csi").mountPoint)
+}
+
+func TestCheckPropagation(t *testing.T) {
+	f, err := ioutil.TempFile("", "mountinfo-")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(testMountInfo)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	defaultPath := mountInfoPath
+	mountInfoPath = f.Name()
+	defer func() { mountInfoPath = defaultPath }()
+
+	tt := []struct {
+		name        string
+		source      string
+		propagation k8s.MountPropagation
+		privileged  bool
+		expectError string
+	}{
+		{
+			name:        "private",
+			source:      "/var/lib/kubelet/volumes",
+			propagation: k8s.MountPropagation_PROPAGATION_PRIVATE,
+		},
+		{
+			name:        "host to container from shared",
+			source:      "/var/lib/kubelet/pods/123",
+			propagation: k8s.MountPropagation_PROPAGATION_HOST_TO_CONTAINER,
+		},
+		{
+			name:        "host to container from slave",
+			source:      "/var/lib/kubelet/plugins/csi",
+			propagation: k8s.MountPropagation_PROPAGATION_HOST_TO_CONTAINER,
+		},
+		{
+			name:        "host to container from private",
+			source:      "/var/lib/kubelet/volumes",
+			propagation: k8s.MountPropagation_PROPAGATION_HOST_TO_CONTAINER,
+			expectError: "mount of /var/lib/kubelet/volumes is not allowed: /var/lib/kubelet/volumes is mounted on /var/lib/kubelet which is neither a shared nor a slave mount",
+		},
+		{
+			name:        "bidirectional",
+			source:      "/var/lib/kubelet/pods/123",
+			propagation: k8s.MountPropagation_PROPAGATION_BIDIRECTIONAL,
+			privileged:  true,
+		},
+		{
+			name:        "bidirectional from slave",
+			source:      "/var/lib/kubelet/plugins/csi",
+			propagation: k8s.MountPropagation_PROPAGATION_BIDIRECTIONAL,
+			privileged:  true,
+			expectError: "mount of /var/lib/kubelet/plugins/csi is not allowed: /var/lib/kubelet/plugins/csi is mounted on /var/lib/kubelet/plugins which is not a shared mount",
+		},
+		{
+			name:        "bidirectional unprivileged",
+			source:      "/var/lib/kubelet/pods/123",
+			propagation: k8s.MountPropagation_PROPAGATION_BIDIRECTIONAL,
+			expectError: "mount of /var/lib/kubelet/pods/123 is not allowed: bidirectional propagation requires privileged container",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkPropagation(tc.source, tc.propagation, tc.privileged)
+			if tc.expectError != "" {
+				require.EqualError(t, err, tc.expectError)
+				_, ok := err.(*MountError)
+				require.True(t, ok)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
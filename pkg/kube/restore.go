@@ -0,0 +1,289 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/selinux/go-selinux/label"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/network"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// PodRecord is a part of pod state that is persisted so that running
+// pod can be re-adopted after CRI restart, see RestorePod.
+type PodRecord struct {
+	ID         string                 `json:"id"`
+	Config     *k8s.PodSandboxConfig  `json:"config"`
+	BaseDir    string                 `json:"baseDir"`
+	Handler    string                 `json:"handler,omitempty"`
+	Namespaces []specs.LinuxNamespace `json:"namespaces,omitempty"`
+	UserNs     *UserNamespace         `json:"userNs,omitempty"`
+	IPs        []string               `json:"ips,omitempty"`
+	AdoptedAt  int64                  `json:"adoptedAt,omitempty"`
+	// Cgroup is unset for pods run in cgroup parent
+	// directly by older Singularity-CRI versions.
+	CgroupDriver CgroupDriver `json:"cgroupDriver,omitempty"`
+	Cgroup       string       `json:"cgroup,omitempty"`
+	OwnCgroups   []string     `json:"ownCgroups,omitempty"`
+	OwnUnits     []string     `json:"ownUnits,omitempty"`
+}
+
+// ContainerRecord is a part of container state that is persisted so that
+// container can be re-adopted after CRI restart, see RestoreContainer.
+// Record holds container environment, so it must be kept private.
+type ContainerRecord struct {
+	ID           string                       `json:"id"`
+	PodID        string                       `json:"podID"`
+	Config       *k8s.ContainerConfig         `json:"config"`
+	ImageID      string                       `json:"imageID"`
+	ImageRef     string                       `json:"imageRef,omitempty"`
+	BaseDir      string                       `json:"baseDir"`
+	LogPath      string                       `json:"logPath,omitempty"`
+	LogDriver    LogDriver                    `json:"logDriver,omitempty"`
+	LogsDisabled bool                         `json:"logsDisabled,omitempty"`
+	Resources    *k8s.LinuxContainerResources `json:"resources,omitempty"`
+	CPUSet       CPUSet                       `json:"cpuset"`
+	OwnCgroup    string                       `json:"ownCgroup,omitempty"`
+	CreatedAt    int64                        `json:"createdAt,omitempty"`
+	StartedAt    int64                        `json:"startedAt,omitempty"`
+	FinishedAt   int64                        `json:"finishedAt,omitempty"`
+	Monitored    bool                         `json:"monitored,omitempty"`
+}
+
+// Record returns pod state that is needed to restore pod, see RestorePod.
+func (p *Pod) Record() *PodRecord {
+	return &PodRecord{
+		ID:         p.id,
+		Config:     p.PodSandboxConfig,
+		BaseDir:    p.baseDir,
+		Handler:    p.handler,
+		Namespaces: p.namespaces,
+		UserNs:     p.userNs,
+		IPs:        p.IPs(),
+		AdoptedAt:  p.adoptedAt,
+
+		CgroupDriver: p.cgroupDriver,
+		Cgroup:       p.cgroupPath,
+		OwnCgroups:   p.ownCgroups,
+		OwnUnits:     p.ownUnits,
+	}
+}
+
+// RestorePod re-adopts pod that was run by previous CRI instance according to
+// the record. Pod state is reconciled with the engine, pod that engine doesn't
+// know anymore has its files removed and runtime.ErrNotFound is returned.
+// Pod network is restored separately with RestoreNetwork.
+func RestorePod(rec *PodRecord, opts ...PodOption) (*Pod, error) {
+	pod := NewPod(rec.Config, opts...)
+	pod.id = rec.ID
+	pod.baseDir = rec.BaseDir
+	pod.handler = rec.Handler
+	pod.namespaces = rec.Namespaces
+	pod.userNs = rec.UserNs
+	pod.restoredIPs = rec.IPs
+	pod.adoptedAt = rec.AdoptedAt
+	// empty driver of older records makes processes be named as with cgroupfs
+	pod.cgroupDriver = rec.CgroupDriver
+	pod.cgroupPath = rec.Cgroup
+	pod.ownCgroups = rec.OwnCgroups
+	pod.ownUnits = rec.OwnUnits
+	if pod.cgroupPath == "" {
+		pod.cgroupPath = pod.GetLinux().GetCgroupParent()
+	}
+	// annotations were validated when pod was run
+	pod.extraHosts, _ = ParseExtraHosts(pod.GetAnnotations())
+	pod.timezone, _ = ParseTimezone(pod.GetAnnotations())
+
+	var err error
+	pod.syncChan, pod.syncCancel, err = observeState(pod.socketPath())
+	if err != nil {
+		return nil, err
+	}
+	pod.ociState, err = pod.cli.State(pod.id)
+	if err == runtime.ErrNotFound {
+		pod.syncCancel()
+		glog.V(3).Infof("Pod %s is gone, removing its files", pod.id)
+		if err := pod.cleanupFiles(true); err != nil {
+			glog.Errorf("Could not cleanup pod: %v", err)
+		}
+		return nil, err
+	}
+	if err != nil {
+		pod.syncCancel()
+		return nil, fmt.Errorf("could not get pod state: %v", err)
+	}
+	pod.runtimeState = runtime.StatusToState(pod.ociState.Status)
+	if spec, err := pod.Spec(); err == nil && spec.Process != nil && spec.Linux != nil {
+		// keep MCS categories of running pod from being given to a new one
+		pod.processLabel, pod.mountLabel = spec.Process.SelinuxLabel, spec.Linux.MountLabel
+		label.ReserveLabel(pod.processLabel)
+	}
+	if pod.runtimeState == runtime.StateExited {
+		pod.syncCancel()
+	}
+	glog.V(3).Infof("Restored pod %s in %s state", pod.id, pod.runtimeState)
+	return pod, nil
+}
+
+// RestoreNetwork restores network of pod that was set up by previous
+// CRI instance, so that it is torn down along with the pod.
+func (p *Pod) RestoreNetwork(manager *network.Manager) error {
+	nsPath := p.namespacePath(specs.NetworkNamespace)
+	if nsPath == "" || len(p.restoredIPs) == 0 {
+		return nil
+	}
+	networks, _ := ParseNetworks(p.GetAnnotations())
+	net, err := manager.RestorePod(&network.PodConfig{
+		ID:           p.id,
+		Namespace:    p.GetMetadata().GetNamespace(),
+		Name:         p.GetMetadata().GetName(),
+		NsPath:       nsPath,
+		PortMappings: p.GetPortMappings(),
+		Networks:     networks,
+	})
+	if err != nil {
+		return fmt.Errorf("could not restore pod's network: %v", err)
+	}
+	p.network = net
+	return nil
+}
+
+// Record returns container state that is needed to restore
+// container, see RestoreContainer.
+func (c *Container) Record() *ContainerRecord {
+	c.resourcesMu.Lock()
+	resources := c.resources
+	c.resourcesMu.Unlock()
+	c.cpusetMu.Lock()
+	cpuset := c.cpuset
+	c.cpusetMu.Unlock()
+	return &ContainerRecord{
+		ID:           c.id,
+		PodID:        c.pod.id,
+		Config:       c.ContainerConfig,
+		ImageID:      c.imgInfo.ID,
+		ImageRef:     c.imgRef,
+		BaseDir:      c.baseDir,
+		LogPath:      c.logPath,
+		LogDriver:    c.logDriver,
+		LogsDisabled: c.logsDisabled,
+		Resources:    &resources,
+		CPUSet:       cpuset,
+		OwnCgroup:    c.ownCgroup,
+		CreatedAt:    c.times.createdAt,
+		StartedAt:    c.times.startedAt,
+		FinishedAt:   c.times.finishedAt,
+		Monitored:    c.monitored,
+	}
+}
+
+// RestoreContainer re-adopts container of pod that was created by previous CRI
+// instance according to the record. Container state is reconciled with the
+// engine, container that engine doesn't know anymore has its files removed and
+// runtime.ErrNotFound is returned, unless its monitor has recorded container
+// exit, see WithMonitor. Output of running container is forwarded again if it
+// was forwarded by the daemon, output written while CRI was down is lost unless
+// container is monitored. Container stdin cannot be restored.
+func RestoreContainer(rec *ContainerRecord, pod *Pod, info *image.Info, trashDir string, opts ...ContainerOption) (*Container, error) {
+	cont := NewContainer(rec.Config, pod, info, trashDir, opts...)
+	cont.id = rec.ID
+	cont.imgRef = rec.ImageRef
+	cont.baseDir = rec.BaseDir
+	cont.logPath = rec.LogPath
+	cont.logDriver = rec.LogDriver
+	cont.logsDisabled = rec.LogsDisabled
+	if rec.Resources != nil {
+		cont.resources = *rec.Resources
+	}
+	cont.cpuset = rec.CPUSet
+	cont.ownCgroup = rec.OwnCgroup
+	cont.monitored = rec.Monitored
+	cont.times.restore(readClock(), rec.CreatedAt, rec.StartedAt, rec.FinishedAt)
+
+	var err error
+	cont.syncChan, cont.syncCancel, err = observeState(cont.socketPath())
+	if err != nil {
+		return nil, err
+	}
+	_, err = cont.cli.State(cont.id)
+	if err == runtime.ErrNotFound {
+		cont.syncCancel()
+		exit, exitErr := readMonitorExit(cont.baseDir)
+		if exitErr != nil {
+			glog.Errorf("Could not read container %s exit status: %v", cont.id, exitErr)
+		}
+		glog.V(3).Infof("Container %s is gone, removing its files", cont.id)
+		if err := cont.cleanupFiles(true); err != nil {
+			glog.Errorf("Could not cleanup container: %v", err)
+		}
+		if exit == nil {
+			return nil, err
+		}
+		// exit status recorded by monitor is kept until container is removed
+		cont.restoreExit(exit)
+		pod.addContainer(cont)
+		glog.V(3).Infof("Restored reclaimed container %s", cont.id)
+		return cont, nil
+	}
+	if err := cont.UpdateState(); err != nil {
+		cont.syncCancel()
+		return nil, err
+	}
+	if cont.runtimeState == runtime.StateExited {
+		cont.syncCancel()
+	} else if err := cont.restoreLogForwarder(); err != nil {
+		cont.warnings.Logf(glog.WarningDepth, "Could not restore container %s output forwarding: %v", cont.id, err)
+	}
+	info.Borrow(cont.id)
+	pod.addContainer(cont)
+	glog.V(3).Infof("Restored container %s in %s state", cont.id, cont.runtimeState)
+	return cont, nil
+}
+
+// restore sets timestamps recorded by previous CRI instance. Monotonic
+// clock is not preserved across restarts, so container timeline continues
+// from the wall clock reading r.
+func (t *transitionTimes) restore(r clockReading, createdAt, startedAt, finishedAt int64) {
+	if createdAt == 0 {
+		return
+	}
+	t.origin = clockReading{wall: createdAt, mono: r.mono - time.Duration(r.wall-createdAt)}
+	t.createdAt = createdAt
+	t.startedAt = startedAt
+	t.finishedAt = finishedAt
+}
+
+// observeState listens for state changes engine reports on the sync
+// socket at path. Socket left by previous CRI instance is replaced.
+func observeState(path string) (<-chan runtime.State, context.CancelFunc, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("could not remove stale sync socket: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	syncChan, err := runtime.ObserveState(ctx, path)
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("could not listen for state changes: %v", err)
+	}
+	return syncChan, cancel, nil
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity/pkg/ociruntime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// instancesEngine reports states of known instances only,
+// any call other than State panics.
+type instancesEngine struct {
+	runtime.Engine
+	states map[string]ociruntime.State
+}
+
+func (e instancesEngine) State(id string) (*ociruntime.State, error) {
+	state, ok := e.states[id]
+	if !ok {
+		return nil, runtime.ErrNotFound
+	}
+	return &state, nil
+}
+
+func TestRestorePod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore-pod-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	createdAt := time.Now().Add(-time.Hour).UnixNano()
+	engine := instancesEngine{states: map[string]ociruntime.State{
+		"running": {State: specs.State{Status: "running", Pid: 42}, CreatedAt: &createdAt},
+		"exited":  {State: specs.State{Status: "stopped"}},
+	}}
+
+	tt := []struct {
+		name        string
+		id          string
+		expectState k8s.PodSandboxState
+		expectError error
+	}{
+		{
+			name:        "running",
+			id:          "running",
+			expectState: k8s.PodSandboxState_SANDBOX_READY,
+		},
+		{
+			name:        "exited",
+			id:          "exited",
+			expectState: k8s.PodSandboxState_SANDBOX_NOTREADY,
+		},
+		{
+			name:        "gone",
+			id:          "gone",
+			expectError: runtime.ErrNotFound,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			baseDir := filepath.Join(dir, tc.id)
+			require.NoError(t, os.MkdirAll(baseDir, 0755))
+			rec := &PodRecord{
+				ID: tc.id,
+				Config: &k8s.PodSandboxConfig{
+					Metadata: &k8s.PodSandboxMetadata{Name: "pod", Namespace: "default"},
+				},
+				BaseDir: baseDir,
+				Handler: "fake",
+				IPs:     []string{"10.0.0.2"},
+			}
+			// record must survive encoding as it is stored on disk
+			data, err := json.Marshal(rec)
+			require.NoError(t, err)
+			decoded := new(PodRecord)
+			require.NoError(t, json.Unmarshal(data, decoded))
+
+			pod, err := RestorePod(decoded, WithPodEngine(engine))
+			if tc.expectError != nil {
+				require.Equal(t, tc.expectError, err)
+				_, err := os.Stat(baseDir)
+				require.True(t, os.IsNotExist(err), "files of gone pod must be removed")
+				return
+			}
+			require.NoError(t, err)
+			defer pod.syncCancel()
+			require.Equal(t, tc.expectState, pod.State())
+			require.Equal(t, "10.0.0.2", pod.NetworkStatus().GetIp())
+			require.Equal(t, rec, pod.Record())
+		})
+	}
+}
+
+func TestRestoreContainer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore-container-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	createdAt := now.Add(-time.Hour).UnixNano()
+	startedAt := now.Add(-time.Minute).UnixNano()
+	finishedAt := now.Add(-time.Second).UnixNano()
+	exitCode := 3
+	engine := instancesEngine{states: map[string]ociruntime.State{
+		"exited": {
+			State:      specs.State{Status: "stopped"},
+			ExitCode:   &exitCode,
+			StartedAt:  &startedAt,
+			FinishedAt: &finishedAt,
+		},
+	}}
+	pod := NewPod(&k8s.PodSandboxConfig{}, WithPodEngine(engine))
+	info := &image.Info{ID: "image"}
+
+	baseDir := filepath.Join(dir, "exited")
+	require.NoError(t, os.MkdirAll(baseDir, 0755))
+	rec := &ContainerRecord{
+		ID:    "exited",
+		PodID: pod.ID(),
+		Config: &k8s.ContainerConfig{
+			Metadata: &k8s.ContainerMetadata{Name: "cont"},
+		},
+		ImageID:   "image",
+		BaseDir:   baseDir,
+		Resources: &k8s.LinuxContainerResources{MemoryLimitInBytes: 1 << 20},
+		CPUSet:    CPUSet{Cpus: "0-1"},
+		CreatedAt: createdAt,
+		StartedAt: startedAt,
+	}
+	cont, err := RestoreContainer(rec, pod, info, "")
+	require.NoError(t, err)
+	require.Equal(t, k8s.ContainerState_CONTAINER_EXITED, cont.State())
+	require.Equal(t, int32(3), cont.ExitCode())
+	require.Equal(t, createdAt, cont.CreatedAt())
+	require.Equal(t, startedAt, cont.StartedAt())
+	require.Equal(t, finishedAt, cont.FinishedAt())
+	require.Equal(t, []string{"exited"}, pod.Containers())
+	require.Equal(t, []string{"exited"}, info.UsedBy())
+	rec.FinishedAt = finishedAt
+	require.Equal(t, rec, cont.Record())
+
+	goneDir := filepath.Join(dir, "gone")
+	require.NoError(t, os.MkdirAll(goneDir, 0755))
+	_, err = RestoreContainer(&ContainerRecord{ID: "gone", BaseDir: goneDir}, pod, info, "", WithContainerEngine(engine))
+	require.Equal(t, runtime.ErrNotFound, err)
+	_, err = os.Stat(goneDir)
+	require.True(t, os.IsNotExist(err), "files of gone container must be removed")
+
+	monitoredDir := filepath.Join(dir, "monitored")
+	require.NoError(t, os.MkdirAll(monitoredDir, 0755))
+	data, err := json.Marshal(monitorExit{ExitCode: &exitCode, FinishedAt: finishedAt})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(monitoredDir, contMonitorExitPath), data, 0600))
+	cont, err = RestoreContainer(&ContainerRecord{
+		ID:        "monitored",
+		BaseDir:   monitoredDir,
+		CreatedAt: createdAt,
+		StartedAt: startedAt,
+		Monitored: true,
+	}, pod, info, "", WithContainerEngine(engine))
+	require.NoError(t, err, "exit recorded by monitor must be restored")
+	require.Equal(t, k8s.ContainerState_CONTAINER_EXITED, cont.State())
+	require.Equal(t, int32(3), cont.ExitCode())
+	require.Equal(t, finishedAt, cont.FinishedAt())
+	require.True(t, cont.Reclaimed())
+	_, err = os.Stat(monitoredDir)
+	require.True(t, os.IsNotExist(err), "files of gone container must be removed")
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	criseccomp "github.com/kubernetes-sigs/cri-o/pkg/seccomp"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	rtseccomp "github.com/opencontainers/runtime-tools/generate/seccomp"
+	"github.com/sylabs/singularity-cri/pkg/slice"
+)
+
+// DefaultSeccompProfileRoot is a directory kubelet keeps
+// localhost seccomp profiles in by default.
+const DefaultSeccompProfileRoot = "/var/lib/kubelet/seccomp"
+
+// seccompProfileRoot is a directory relative localhost
+// profiles are looked up in, see SetSeccompProfileRoot.
+var seccompProfileRoot = DefaultSeccompProfileRoot
+
+// SetSeccompProfileRoot sets directory relative localhost seccomp profiles
+// are looked up in. Empty dir resets it to DefaultSeccompProfileRoot.
+func SetSeccompProfileRoot(dir string) {
+	if dir == "" {
+		dir = DefaultSeccompProfileRoot
+	}
+	seccompProfileRoot = filepath.Clean(dir)
+}
+
+// goArchToSeccomp maps architectures Go runs on to libseccomp ones.
+var goArchToSeccomp = map[string]criseccomp.Arch{
+	"386":      criseccomp.ArchX86,
+	"amd64":    criseccomp.ArchX86_64,
+	"arm":      criseccomp.ArchARM,
+	"arm64":    criseccomp.ArchAARCH64,
+	"mips64":   criseccomp.ArchMIPS64,
+	"mips64le": criseccomp.ArchMIPSEL64,
+	"ppc64":    criseccomp.ArchPPC64,
+	"ppc64le":  criseccomp.ArchPPC64LE,
+	"s390x":    criseccomp.ArchS390X,
+}
+
+var seccompActions = map[criseccomp.Action]bool{
+	criseccomp.ActKill:  true,
+	criseccomp.ActTrap:  true,
+	criseccomp.ActErrno: true,
+	criseccomp.ActTrace: true,
+	criseccomp.ActAllow: true,
+}
+
+var seccompOperators = map[criseccomp.Operator]bool{
+	criseccomp.OpNotEqual:     true,
+	criseccomp.OpLessThan:     true,
+	criseccomp.OpLessEqual:    true,
+	criseccomp.OpEqualTo:      true,
+	criseccomp.OpGreaterEqual: true,
+	criseccomp.OpGreaterThan:  true,
+	criseccomp.OpMaskedEqual:  true,
+}
+
+// prepareSeccompPath checks requested seccomp profile and returns either
+// unconfinedSeccompProfile, defaultSeccompProfile or path to a localhost
+// profile. Relative localhost profiles are resolved in seccomp profile root
+// and must not escape it. Localhost profile must exist and be valid.
+func prepareSeccompPath(scProfile string) (string, error) {
+	switch scProfile {
+	case "", unconfinedSeccompProfile:
+		// empty profile equals to unconfined according to docs
+		return unconfinedSeccompProfile, nil
+	case defaultSeccompProfile, defaultDockerSeccompProfile:
+		return defaultSeccompProfile, nil
+	}
+	if !strings.HasPrefix(scProfile, seccompLocalhostPrefix) {
+		return "", fmt.Errorf("unknown profile %q, expected %s, %s or %s<path>",
+			scProfile, defaultSeccompProfile, unconfinedSeccompProfile, seccompLocalhostPrefix)
+	}
+	path := strings.TrimPrefix(scProfile, seccompLocalhostPrefix)
+	if path == "" {
+		return "", fmt.Errorf("localhost profile path is empty")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(seccompProfileRoot, path)
+		if !isWithin(seccompProfileRoot, path) || path == seccompProfileRoot {
+			return "", fmt.Errorf("profile %s escapes %s", scProfile, seccompProfileRoot)
+		}
+	}
+	path = filepath.Clean(path)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("profile %s is not found", path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read profile: %v", err)
+	}
+	if _, err := parseSeccompProfile(data, nil); err != nil {
+		return "", fmt.Errorf("invalid profile %s: %v", path, err)
+	}
+	return path, nil
+}
+
+// setupSeccomp sets seccomp filter of profile returned by prepareSeccompPath.
+// Empty profile keeps whatever generator already has.
+func setupSeccomp(g *generate.Generator, profile string) error {
+	if profile == "" {
+		return nil
+	}
+	if g.Config.Linux == nil {
+		g.Config.Linux = new(specs.Linux)
+	}
+	if g.Config.Process == nil {
+		g.Config.Process = new(specs.Process)
+	}
+	if g.Config.Process.Capabilities == nil {
+		g.Config.Process.Capabilities = new(specs.LinuxCapabilities)
+	}
+
+	switch profile {
+	case unconfinedSeccompProfile:
+		// drop any default config
+		g.Config.Linux.Seccomp = nil
+		return nil
+	case defaultSeccompProfile:
+		g.Config.Linux.Seccomp = rtseccomp.DefaultProfile(g.Config)
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(profile)
+	if err != nil {
+		return fmt.Errorf("could not read seccomp profile: %v", err)
+	}
+	filter, err := parseSeccompProfile(data, g.Config.Process.Capabilities.Permitted)
+	if err != nil {
+		return fmt.Errorf("could not setup seccomp profile %s: %v", profile, err)
+	}
+	g.Config.Linux.Seccomp = filter
+	return nil
+}
+
+// parseSeccompProfile translates seccomp profile in docker format into
+// OCI seccomp filter. Translation is done here and not by libseccomp, so
+// that profiles are applied regardless of how the daemon is built, engine
+// is the one that loads the filter. Syscall rules are filtered by native
+// architecture and passed process capabilities.
+func parseSeccompProfile(data []byte, caps []string) (*specs.LinuxSeccomp, error) {
+	var profile criseccomp.Seccomp
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("could not decode profile: %v", err)
+	}
+	if profile.DefaultAction == "" {
+		return nil, fmt.Errorf("default action is not set")
+	}
+	if !seccompActions[profile.DefaultAction] {
+		return nil, fmt.Errorf("unknown default action %q", profile.DefaultAction)
+	}
+	if len(profile.Architectures) != 0 && len(profile.ArchMap) != 0 {
+		return nil, fmt.Errorf("architectures and archMap cannot be used together")
+	}
+
+	filter := &specs.LinuxSeccomp{
+		DefaultAction: specs.LinuxSeccompAction(profile.DefaultAction),
+	}
+	// with no architectures set engine allows native one only
+	for _, arch := range profile.Architectures {
+		filter.Architectures = append(filter.Architectures, specs.Arch(arch))
+	}
+	native := goArchToSeccomp[runtime.GOARCH]
+	for _, arch := range profile.ArchMap {
+		if arch.Arch != native {
+			continue
+		}
+		filter.Architectures = append(filter.Architectures, specs.Arch(arch.Arch))
+		for _, sub := range arch.SubArches {
+			filter.Architectures = append(filter.Architectures, specs.Arch(sub))
+		}
+	}
+
+	for i, call := range profile.Syscalls {
+		if call == nil {
+			continue
+		}
+		if call.Name != "" && len(call.Names) != 0 {
+			return nil, fmt.Errorf("syscall rule %d has both name and names set", i)
+		}
+		names := call.Names
+		if call.Name != "" {
+			names = []string{call.Name}
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("syscall rule %d has no names", i)
+		}
+		if !seccompActions[call.Action] {
+			return nil, fmt.Errorf("syscall rule %d has unknown action %q", i, call.Action)
+		}
+		var args []specs.LinuxSeccompArg
+		for _, arg := range call.Args {
+			if arg == nil {
+				continue
+			}
+			if !seccompOperators[arg.Op] {
+				return nil, fmt.Errorf("syscall rule %d has unknown operator %q", i, arg.Op)
+			}
+			args = append(args, specs.LinuxSeccompArg{
+				Index:    arg.Index,
+				Value:    arg.Value,
+				ValueTwo: arg.ValueTwo,
+				Op:       specs.LinuxSeccompOperator(arg.Op),
+			})
+		}
+		if !seccompRuleApplies(call, caps) {
+			continue
+		}
+		filter.Syscalls = append(filter.Syscalls, specs.LinuxSyscall{
+			Names:  names,
+			Action: specs.LinuxSeccompAction(call.Action),
+			Args:   args,
+		})
+	}
+	return filter, nil
+}
+
+// seccompRuleApplies checks syscall rule filters against native
+// architecture and process capabilities.
+func seccompRuleApplies(call *criseccomp.Syscall, caps []string) bool {
+	if slice.ContainsString(call.Excludes.Arches, runtime.GOARCH) {
+		return false
+	}
+	if len(call.Includes.Arches) != 0 && !slice.ContainsString(call.Includes.Arches, runtime.GOARCH) {
+		return false
+	}
+	for _, c := range call.Excludes.Caps {
+		if slice.ContainsString(caps, c) {
+			return false
+		}
+	}
+	for _, c := range call.Includes.Caps {
+		if !slice.ContainsString(caps, c) {
+			return false
+		}
+	}
+	return true
+}
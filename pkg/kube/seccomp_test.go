@@ -0,0 +1,260 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/stretchr/testify/require"
+)
+
+const testSeccompProfile = `{
+	"defaultAction": "SCMP_ACT_ERRNO",
+	"syscalls": [
+		{"names": ["read", "write"], "action": "SCMP_ACT_ALLOW"},
+		{"name": "mount", "action": "SCMP_ACT_ALLOW", "includes": {"caps": ["CAP_SYS_ADMIN"]}},
+		{"name": "ptrace", "action": "SCMP_ACT_ALLOW", "excludes": {"arches": ["%s"]}},
+		{"name": "personality", "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "value": 8, "op": "SCMP_CMP_EQ"}]}
+	]
+}`
+
+func TestPrepareSeccompPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "seccomp-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	profile := fmt.Sprintf(testSeccompProfile, runtime.GOARCH)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "good.json"), []byte(profile), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "bad.json"), []byte(`{"syscalls": []}`), 0644))
+
+	SetSeccompProfileRoot(root)
+	defer SetSeccompProfileRoot("")
+
+	tt := []struct {
+		name        string
+		profile     string
+		expectPath  string
+		expectError string
+	}{
+		{
+			name:       "empty",
+			expectPath: unconfinedSeccompProfile,
+		},
+		{
+			name:       "unconfined",
+			profile:    "unconfined",
+			expectPath: unconfinedSeccompProfile,
+		},
+		{
+			name:       "runtime default",
+			profile:    "runtime/default",
+			expectPath: defaultSeccompProfile,
+		},
+		{
+			name:       "docker default",
+			profile:    "docker/default",
+			expectPath: defaultSeccompProfile,
+		},
+		{
+			name:       "relative localhost",
+			profile:    "localhost/good.json",
+			expectPath: filepath.Join(root, "good.json"),
+		},
+		{
+			name:       "absolute localhost",
+			profile:    "localhost/" + filepath.Join(root, "good.json"),
+			expectPath: filepath.Join(root, "good.json"),
+		},
+		{
+			name:        "unknown",
+			profile:     "good.json",
+			expectError: `unknown profile "good.json", expected runtime/default, unconfined or localhost/<path>`,
+		},
+		{
+			name:        "empty localhost",
+			profile:     "localhost/",
+			expectError: "localhost profile path is empty",
+		},
+		{
+			name:        "escaping localhost",
+			profile:     "localhost/../good.json",
+			expectError: fmt.Sprintf("profile localhost/../good.json escapes %s", root),
+		},
+		{
+			name:        "missing localhost",
+			profile:     "localhost/missing.json",
+			expectError: fmt.Sprintf("profile %s is not found", filepath.Join(root, "missing.json")),
+		},
+		{
+			name:        "invalid localhost",
+			profile:     "localhost/bad.json",
+			expectError: fmt.Sprintf("invalid profile %s: default action is not set", filepath.Join(root, "bad.json")),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			path, err := prepareSeccompPath(tc.profile)
+			if tc.expectError != "" {
+				require.EqualError(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectPath, path)
+		})
+	}
+}
+
+func TestParseSeccompProfile(t *testing.T) {
+	profile := fmt.Sprintf(testSeccompProfile, runtime.GOARCH+"-other")
+	tt := []struct {
+		name        string
+		profile     string
+		caps        []string
+		expect      *specs.LinuxSeccomp
+		expectError string
+	}{
+		{
+			name:    "without capabilities",
+			profile: profile,
+			expect: &specs.LinuxSeccomp{
+				DefaultAction: specs.ActErrno,
+				Syscalls: []specs.LinuxSyscall{
+					{Names: []string{"read", "write"}, Action: specs.ActAllow},
+					{Names: []string{"ptrace"}, Action: specs.ActAllow},
+					{
+						Names:  []string{"personality"},
+						Action: specs.ActAllow,
+						Args:   []specs.LinuxSeccompArg{{Index: 0, Value: 8, Op: specs.OpEqualTo}},
+					},
+				},
+			},
+		},
+		{
+			name:    "included capability",
+			profile: profile,
+			caps:    []string{"CAP_SYS_ADMIN"},
+			expect: &specs.LinuxSeccomp{
+				DefaultAction: specs.ActErrno,
+				Syscalls: []specs.LinuxSyscall{
+					{Names: []string{"read", "write"}, Action: specs.ActAllow},
+					{Names: []string{"mount"}, Action: specs.ActAllow},
+					{Names: []string{"ptrace"}, Action: specs.ActAllow},
+					{
+						Names:  []string{"personality"},
+						Action: specs.ActAllow,
+						Args:   []specs.LinuxSeccompArg{{Index: 0, Value: 8, Op: specs.OpEqualTo}},
+					},
+				},
+			},
+		},
+		{
+			name:    "excluded architecture",
+			profile: fmt.Sprintf(`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"name": "ptrace", "action": "SCMP_ACT_ERRNO", "excludes": {"arches": [%q]}}]}`, runtime.GOARCH),
+			expect: &specs.LinuxSeccomp{
+				DefaultAction: specs.ActAllow,
+			},
+		},
+		{
+			name:    "architectures",
+			profile: `{"defaultAction": "SCMP_ACT_ALLOW", "architectures": ["SCMP_ARCH_X86_64", "SCMP_ARCH_X86"]}`,
+			expect: &specs.LinuxSeccomp{
+				DefaultAction: specs.ActAllow,
+				Architectures: []specs.Arch{specs.ArchX86_64, specs.ArchX86},
+			},
+		},
+		{
+			name:        "invalid json",
+			profile:     `{"defaultAction": `,
+			expectError: "could not decode profile: unexpected end of JSON input",
+		},
+		{
+			name:        "unknown default action",
+			profile:     `{"defaultAction": "SCMP_ACT_NOTIFY"}`,
+			expectError: `unknown default action "SCMP_ACT_NOTIFY"`,
+		},
+		{
+			name:        "architectures with arch map",
+			profile:     `{"defaultAction": "SCMP_ACT_ALLOW", "architectures": ["SCMP_ARCH_X86"], "archMap": [{"architecture": "SCMP_ARCH_X86_64"}]}`,
+			expectError: "architectures and archMap cannot be used together",
+		},
+		{
+			name:        "name and names",
+			profile:     `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"name": "read", "names": ["write"], "action": "SCMP_ACT_ERRNO"}]}`,
+			expectError: "syscall rule 0 has both name and names set",
+		},
+		{
+			name:        "no names",
+			profile:     `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"action": "SCMP_ACT_ERRNO"}]}`,
+			expectError: "syscall rule 0 has no names",
+		},
+		{
+			name:        "unknown action",
+			profile:     `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"name": "read", "action": "SCMP_ACT_DENY"}]}`,
+			expectError: `syscall rule 0 has unknown action "SCMP_ACT_DENY"`,
+		},
+		{
+			name:        "unknown operator",
+			profile:     `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"name": "read", "action": "SCMP_ACT_ERRNO", "args": [{"index": 0, "op": "SCMP_CMP_IN"}]}]}`,
+			expectError: `syscall rule 0 has unknown operator "SCMP_CMP_IN"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := parseSeccompProfile([]byte(tc.profile), tc.caps)
+			if tc.expectError != "" {
+				require.EqualError(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, filter)
+		})
+	}
+}
+
+func TestSetupSeccomp(t *testing.T) {
+	f, err := ioutil.TempFile("", "seccomp-test-")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"name": "read", "action": "SCMP_ACT_ALLOW"}]}`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	g := generate.Generator{Config: &specs.Spec{}}
+	require.NoError(t, setupSeccomp(&g, ""))
+	require.Nil(t, g.Config.Linux)
+
+	require.NoError(t, setupSeccomp(&g, defaultSeccompProfile))
+	require.NotNil(t, g.Config.Linux.Seccomp)
+	require.Equal(t, specs.ActErrno, g.Config.Linux.Seccomp.DefaultAction)
+
+	require.NoError(t, setupSeccomp(&g, f.Name()))
+	require.Equal(t, &specs.LinuxSeccomp{
+		DefaultAction: specs.ActErrno,
+		Syscalls:      []specs.LinuxSyscall{{Names: []string{"read"}, Action: specs.ActAllow}},
+	}, g.Config.Linux.Seccomp)
+
+	require.NoError(t, setupSeccomp(&g, unconfinedSeccompProfile))
+	require.Nil(t, g.Config.Linux.Seccomp)
+
+	require.Error(t, setupSeccomp(&g, f.Name()+".missing"))
+}
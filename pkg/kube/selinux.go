@@ -0,0 +1,121 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/opencontainers/selinux/go-selinux"
+	"github.com/opencontainers/selinux/go-selinux/label"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// SELinuxEnabled reports whether SELinux is enabled on the host, when it
+// is not labels are neither set on containers nor applied to mounts.
+func SELinuxEnabled() bool {
+	return selinux.GetEnabled()
+}
+
+// selinuxLabels returns process and mount labels for the passed options.
+// Level that is not set explicitly is taken from inherit label, so that
+// containers share MCS categories of their pod. Empty labels are returned
+// when SELinux is disabled.
+func selinuxLabels(options *k8s.SELinuxOption, inherit string) (string, string, error) {
+	level := options.GetLevel()
+	if level == "" && inherit != "" {
+		context, err := selinux.NewContext(inherit)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid inherited label %q: %v", inherit, err)
+		}
+		level = context["level"]
+	}
+
+	var labels []string
+	if options.GetUser() != "" {
+		labels = append(labels, "user:"+options.GetUser())
+	}
+	if options.GetRole() != "" {
+		labels = append(labels, "role:"+options.GetRole())
+	}
+	if options.GetType() != "" {
+		labels = append(labels, "type:"+options.GetType())
+	}
+	if level != "" {
+		labels = append(labels, "level:"+level)
+	}
+	processLabel, mountLabel, err := label.InitLabels(labels)
+	if err != nil {
+		return "", "", fmt.Errorf("could not init selinux labels: %v", err)
+	}
+	return processLabel, mountLabel, nil
+}
+
+// setSELinuxLabels sets non-empty labels on generated spec.
+func setSELinuxLabels(g *generate.Generator, processLabel, mountLabel string) {
+	if mountLabel != "" {
+		glog.V(3).Infof("Setting mount label to %q", mountLabel)
+		g.SetLinuxMountLabel(mountLabel)
+	}
+	if processLabel != "" {
+		glog.V(3).Infof("Setting process's SELinux label to %q", processLabel)
+		g.SetProcessSelinuxLabel(processLabel)
+	}
+}
+
+// setupSELinux computes labels container is run with and relabels
+// mounts that request it. Container without SELinux options is run
+// with labels of its pod, unless it is privileged.
+func (c *Container) setupSELinux() error {
+	security := c.GetLinux().GetSecurityContext()
+	options := security.GetSelinuxOptions()
+	switch {
+	case options != nil:
+		var err error
+		c.processLabel, c.mountLabel, err = selinuxLabels(options, c.pod.processLabel)
+		if err != nil {
+			return err
+		}
+	case !security.GetPrivileged():
+		c.processLabel, c.mountLabel = c.pod.processLabel, c.pod.mountLabel
+	}
+
+	for _, mount := range c.GetMounts() {
+		if !mount.GetSelinuxRelabel() || mount.GetHostPath() == "" {
+			continue
+		}
+		if !c.selinuxRelabel || c.mountLabel == "" {
+			glog.V(4).Infof("Skipping SELinux relabel of %s", mount.GetHostPath())
+			continue
+		}
+		// mount source is already resolved at config validation, absent
+		// one is created here so that it is labeled before container runs
+		if _, err := os.Lstat(mount.GetHostPath()); os.IsNotExist(err) {
+			if err := os.MkdirAll(mount.GetHostPath(), 0755); err != nil {
+				return fmt.Errorf("could not create %s: %v", mount.GetHostPath(), err)
+			}
+		}
+		glog.V(3).Infof("Relabeling %s with %q", mount.GetHostPath(), c.mountLabel)
+		if err := label.Relabel(mount.GetHostPath(), c.mountLabel, false); err != nil {
+			return &MountError{
+				HostPath: mount.GetHostPath(),
+				Reason:   fmt.Sprintf("could not relabel: %v", err),
+			}
+		}
+	}
+	return nil
+}
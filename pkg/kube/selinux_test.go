@@ -0,0 +1,92 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestContainer_setupSELinux(t *testing.T) {
+	const (
+		podProcess = "system_u:system_r:container_t:s0:c1,c2"
+		podMount   = "system_u:object_r:container_file_t:s0:c1,c2"
+	)
+
+	tmp, err := ioutil.TempDir("", "selinux-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	tt := []struct {
+		name          string
+		privileged    bool
+		relabel       bool
+		expectLabels  bool
+		expectCreated bool
+	}{
+		{
+			name:          "inherit pod labels",
+			relabel:       true,
+			expectLabels:  true,
+			expectCreated: true,
+		},
+		{
+			name:          "relabel disabled",
+			expectLabels:  true,
+			expectCreated: false,
+		},
+		{
+			name:          "privileged",
+			privileged:    true,
+			relabel:       true,
+			expectCreated: false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			source := filepath.Join(tmp, tc.name)
+			pod := &Pod{processLabel: podProcess, mountLabel: podMount}
+			c := &Container{
+				pod:            pod,
+				selinuxRelabel: tc.relabel,
+				ContainerConfig: &k8s.ContainerConfig{
+					Mounts: []*k8s.Mount{
+						{HostPath: source, ContainerPath: "/data", SelinuxRelabel: true},
+						{ContainerPath: "/tmp", SelinuxRelabel: true},
+					},
+					Linux: &k8s.LinuxContainerConfig{
+						SecurityContext: &k8s.LinuxContainerSecurityContext{Privileged: tc.privileged},
+					},
+				},
+			}
+			require.NoError(t, c.setupSELinux())
+			if tc.expectLabels {
+				require.Equal(t, podProcess, c.processLabel)
+				require.Equal(t, podMount, c.mountLabel)
+			} else {
+				require.Empty(t, c.processLabel)
+				require.Empty(t, c.mountLabel)
+			}
+			_, err := os.Stat(source)
+			require.Equal(t, tc.expectCreated, err == nil, "relabeled source must be created")
+		})
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// Pod annotations sysctls were requested with before they were
+// added to pod security context, both hold comma separated key=value pairs.
+const (
+	AnnotationSysctls       = "security.alpha.kubernetes.io/sysctls"
+	AnnotationUnsafeSysctls = "security.alpha.kubernetes.io/unsafe-sysctls"
+)
+
+// SafeSysctls are namespaced sysctls that cannot affect other pods or
+// the node, pods may always set them. The list is the one kubelet uses.
+var SafeSysctls = []string{
+	"kernel.shm_rmid_forced",
+	"net.ipv4.ip_local_port_range",
+	"net.ipv4.tcp_syncookies",
+	"net.ipv4.ping_group_range",
+	"net.ipv4.ip_unprivileged_port_start",
+}
+
+// sysctlToNs maps prefixes of namespaced sysctls to namespace they belong to.
+var sysctlToNs = map[string]specs.LinuxNamespaceType{
+	"kernel.shm": specs.IPCNamespace,
+	"kernel.msg": specs.IPCNamespace,
+	"kernel.sem": specs.IPCNamespace,
+	"fs.mqueue.": specs.IPCNamespace,
+	"net.":       specs.NetworkNamespace,
+}
+
+// SysctlPolicy restricts sysctls pods may set. Only SafeSysctls
+// are allowed when policy is nil.
+type SysctlPolicy struct {
+	// AllowedUnsafe lists unsafe sysctls allowed in addition to SafeSysctls.
+	// Entry ending with * allows all sysctls with that prefix, e.g. net.core.*.
+	AllowedUnsafe []string
+}
+
+// Validate checks that policy allows namespaced sysctls only.
+func (p *SysctlPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	for _, pattern := range p.AllowedUnsafe {
+		name := strings.TrimSuffix(pattern, "*")
+		if name == "" || strings.Contains(name, "*") {
+			return fmt.Errorf("invalid unsafe sysctl pattern %q", pattern)
+		}
+		if sysctlNamespace(name) == "" {
+			return fmt.Errorf("unsafe sysctl pattern %q is not namespaced", pattern)
+		}
+	}
+	return nil
+}
+
+func (p *SysctlPolicy) allows(name string) bool {
+	for _, safe := range SafeSysctls {
+		if name == safe {
+			return true
+		}
+	}
+	if p == nil {
+		return false
+	}
+	for _, pattern := range p.AllowedUnsafe {
+		if name == pattern || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(name, strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// PodSysctls returns sysctls requested for pod with config after checking them
+// against policy. Sysctls set in pod config override ones of pod annotations.
+// Each sysctl must be namespaced and pod must have its own namespace of
+// that type so that sysctl is applied to the pod only.
+func PodSysctls(config *k8s.PodSandboxConfig, policy *SysctlPolicy) (map[string]string, error) {
+	sysctls := make(map[string]string)
+	for _, key := range []string{AnnotationSysctls, AnnotationUnsafeSysctls} {
+		value, ok := config.GetAnnotations()[key]
+		if !ok {
+			continue
+		}
+		if err := parseSysctls(value, sysctls); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %v", key, err)
+		}
+	}
+	for k, v := range config.GetLinux().GetSysctls() {
+		sysctls[k] = v
+	}
+
+	names := make([]string, 0, len(sysctls))
+	for name := range sysctls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	nsOptions := config.GetLinux().GetSecurityContext().GetNamespaceOptions()
+	for _, name := range names {
+		nsType := sysctlNamespace(name)
+		switch {
+		case nsType == "":
+			return nil, fmt.Errorf("sysctl %s is not namespaced", name)
+		case nsType == specs.IPCNamespace && nsOptions.GetIpc() != k8s.NamespaceMode_POD,
+			nsType == specs.NetworkNamespace && nsOptions.GetNetwork() != k8s.NamespaceMode_POD:
+			return nil, fmt.Errorf("sysctl %s requires a separate %s namespace", name, nsType)
+		case !policy.allows(name):
+			return nil, fmt.Errorf("sysctl %s is unsafe and not allowed", name)
+		}
+	}
+	return sysctls, nil
+}
+
+// parseSysctls parses comma separated key=value pairs into sysctls.
+func parseSysctls(value string, sysctls map[string]string) error {
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("sysctl %q must be in key=value form", pair)
+		}
+		sysctls[kv[0]] = kv[1]
+	}
+	return nil
+}
+
+// sysctlNamespace returns type of namespace sysctl belongs to,
+// empty type is returned for sysctls that are not namespaced.
+func sysctlNamespace(name string) specs.LinuxNamespaceType {
+	for prefix, nsType := range sysctlToNs {
+		if strings.HasPrefix(name, prefix) {
+			return nsType
+		}
+	}
+	return ""
+}
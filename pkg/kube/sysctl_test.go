@@ -0,0 +1,160 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestSysctlPolicy_Validate(t *testing.T) {
+	tt := []struct {
+		name        string
+		policy      *SysctlPolicy
+		expectError error
+	}{
+		{
+			name: "nil policy",
+		},
+		{
+			name:   "valid policy",
+			policy: &SysctlPolicy{AllowedUnsafe: []string{"net.core.somaxconn", "kernel.msg*", "net.*"}},
+		},
+		{
+			name:        "not namespaced",
+			policy:      &SysctlPolicy{AllowedUnsafe: []string{"kernel.pid_max"}},
+			expectError: fmt.Errorf(`unsafe sysctl pattern "kernel.pid_max" is not namespaced`),
+		},
+		{
+			name:        "not namespaced prefix",
+			policy:      &SysctlPolicy{AllowedUnsafe: []string{"kernel.*"}},
+			expectError: fmt.Errorf(`unsafe sysctl pattern "kernel.*" is not namespaced`),
+		},
+		{
+			name:        "wildcard in the middle",
+			policy:      &SysctlPolicy{AllowedUnsafe: []string{"net.*.somaxconn"}},
+			expectError: fmt.Errorf(`invalid unsafe sysctl pattern "net.*.somaxconn"`),
+		},
+		{
+			name:        "wildcard only",
+			policy:      &SysctlPolicy{AllowedUnsafe: []string{"*"}},
+			expectError: fmt.Errorf(`invalid unsafe sysctl pattern "*"`),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectError, tc.policy.Validate())
+		})
+	}
+}
+
+func TestPodSysctls(t *testing.T) {
+	podNamespaces := &k8s.NamespaceOption{Network: k8s.NamespaceMode_POD, Ipc: k8s.NamespaceMode_POD}
+	config := func(sysctls, annotations map[string]string, ns *k8s.NamespaceOption) *k8s.PodSandboxConfig {
+		return &k8s.PodSandboxConfig{
+			Annotations: annotations,
+			Linux: &k8s.LinuxPodSandboxConfig{
+				Sysctls:         sysctls,
+				SecurityContext: &k8s.LinuxSandboxSecurityContext{NamespaceOptions: ns},
+			},
+		}
+	}
+
+	tt := []struct {
+		name          string
+		config        *k8s.PodSandboxConfig
+		policy        *SysctlPolicy
+		expectSysctls map[string]string
+		expectError   string
+	}{
+		{
+			name:          "no sysctls",
+			config:        &k8s.PodSandboxConfig{},
+			expectSysctls: map[string]string{},
+		},
+		{
+			name: "safe sysctls",
+			config: config(map[string]string{
+				"net.ipv4.ip_local_port_range": "1024 65000",
+				"kernel.shm_rmid_forced":       "1",
+			}, nil, podNamespaces),
+			expectSysctls: map[string]string{
+				"net.ipv4.ip_local_port_range": "1024 65000",
+				"kernel.shm_rmid_forced":       "1",
+			},
+		},
+		{
+			name: "annotations",
+			config: config(map[string]string{"net.ipv4.tcp_syncookies": "1"}, map[string]string{
+				AnnotationSysctls:       "net.ipv4.tcp_syncookies=0, net.ipv4.ping_group_range=0 1000",
+				AnnotationUnsafeSysctls: "net.core.somaxconn=1024",
+			}, podNamespaces),
+			policy: &SysctlPolicy{AllowedUnsafe: []string{"net.core.*"}},
+			expectSysctls: map[string]string{
+				"net.ipv4.tcp_syncookies":   "1",
+				"net.ipv4.ping_group_range": "0 1000",
+				"net.core.somaxconn":        "1024",
+			},
+		},
+		{
+			name:        "invalid annotation",
+			config:      config(nil, map[string]string{AnnotationSysctls: "net.ipv4.tcp_syncookies"}, podNamespaces),
+			expectError: `invalid security.alpha.kubernetes.io/sysctls annotation: sysctl "net.ipv4.tcp_syncookies" must be in key=value form`,
+		},
+		{
+			name:        "unsafe sysctl",
+			config:      config(map[string]string{"net.core.somaxconn": "1024"}, nil, podNamespaces),
+			expectError: "sysctl net.core.somaxconn is unsafe and not allowed",
+		},
+		{
+			name:          "allowed unsafe sysctl",
+			config:        config(map[string]string{"kernel.msgmax": "8192"}, nil, podNamespaces),
+			policy:        &SysctlPolicy{AllowedUnsafe: []string{"kernel.msgmax"}},
+			expectSysctls: map[string]string{"kernel.msgmax": "8192"},
+		},
+		{
+			name:        "not namespaced",
+			config:      config(map[string]string{"vm.max_map_count": "262144"}, nil, podNamespaces),
+			policy:      &SysctlPolicy{AllowedUnsafe: []string{"net.*"}},
+			expectError: "sysctl vm.max_map_count is not namespaced",
+		},
+		{
+			name:        "host network",
+			config:      config(map[string]string{"net.ipv4.tcp_syncookies": "1"}, nil, &k8s.NamespaceOption{Network: k8s.NamespaceMode_NODE}),
+			expectError: "sysctl net.ipv4.tcp_syncookies requires a separate network namespace",
+		},
+		{
+			name:        "host ipc",
+			config:      config(map[string]string{"kernel.shm_rmid_forced": "1"}, nil, &k8s.NamespaceOption{Ipc: k8s.NamespaceMode_NODE}),
+			expectError: "sysctl kernel.shm_rmid_forced requires a separate ipc namespace",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			sysctls, err := PodSysctls(tc.config, tc.policy)
+			if tc.expectError != "" {
+				require.EqualError(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectSysctls, sysctls)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"time"
+
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+)
+
+// clockReading is a point in time read from wall and monotonic clocks at once.
+type clockReading struct {
+	wall int64         // Unix nano
+	mono time.Duration // since clockOrigin
+}
+
+var clockOrigin = time.Now()
+
+// readClock returns current clock reading, it is overridden in
+// tests to simulate wall clock steps.
+var readClock = func() clockReading {
+	now := time.Now()
+	return clockReading{wall: now.UnixNano(), mono: now.Sub(clockOrigin)}
+}
+
+// transitionTimes holds container state transition timestamps in Unix nano.
+// Wall clock is read only once when container creation is observed, later
+// timestamps are derived from monotonic time elapsed since then so that wall
+// clock steps, e.g. by NTP, cannot reorder them. Each timestamp is recorded
+// once and never changes afterwards.
+type transitionTimes struct {
+	origin     clockReading
+	createdAt  int64
+	startedAt  int64
+	finishedAt int64
+}
+
+// at returns Unix nano timestamp of r on container's timeline.
+func (t *transitionTimes) at(r clockReading) int64 {
+	return t.createdAt + int64(r.mono-t.origin.mono)
+}
+
+// bound returns reported timestamp if it is within [lower, upper]
+// and upper otherwise.
+func bound(reported, lower, upper int64) int64 {
+	if reported < lower || reported > upper {
+		return upper
+	}
+	return reported
+}
+
+func (t *transitionTimes) recordCreated(r clockReading) {
+	if t.createdAt != 0 {
+		return
+	}
+	t.origin = r
+	t.createdAt = r.wall
+}
+
+// recordStarted records container start. Start time reported by runtime
+// is used as long as it's consistent with the observed timeline.
+func (t *transitionTimes) recordStarted(r clockReading, reported int64) {
+	t.recordCreated(r)
+	if t.startedAt != 0 {
+		return
+	}
+	t.startedAt = bound(reported, t.createdAt, t.at(r))
+}
+
+// recordFinished records container exit. Exit time reported by runtime
+// is used as long as it's consistent with the observed timeline.
+func (t *transitionTimes) recordFinished(r clockReading, reported int64) {
+	t.recordCreated(r)
+	if t.finishedAt != 0 {
+		return
+	}
+	lower := t.createdAt
+	if t.startedAt != 0 {
+		lower = t.startedAt
+	}
+	t.finishedAt = bound(reported, lower, t.at(r))
+}
+
+// recordTransition records time of the first observation
+// of the current container state.
+func (c *Container) recordTransition() {
+	r := readClock()
+	switch c.runtimeState {
+	case runtime.StateCreated:
+		c.times.recordCreated(r)
+	case runtime.StateRunning:
+		c.times.recordStarted(r, derefTime(c.ociState.StartedAt))
+	case runtime.StateExited:
+		if startedAt := derefTime(c.ociState.StartedAt); startedAt != 0 {
+			// container may exit before its start is observed
+			c.times.recordStarted(r, startedAt)
+		}
+		c.times.recordFinished(r, derefTime(c.ociState.FinishedAt))
+	}
+}
+
+func derefTime(t *int64) int64 {
+	if t == nil {
+		return 0
+	}
+	return *t
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity/pkg/ociruntime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// stateEngine reports preset container state.
+type stateEngine struct {
+	runtime.Engine
+	state *ociruntime.State
+}
+
+func (e *stateEngine) State(id string) (*ociruntime.State, error) {
+	return e.state, nil
+}
+
+func TestContainer_TransitionTimes(t *testing.T) {
+	wall := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC).UnixNano()
+	ts := func(d time.Duration) *int64 {
+		v := wall + int64(d)
+		return &v
+	}
+
+	type step struct {
+		status   string
+		wallStep time.Duration // wall clock step since creation
+		elapsed  time.Duration // monotonic time since creation
+		started  *int64        // start time reported by runtime
+		finished *int64        // finish time reported by runtime
+	}
+	tt := []struct {
+		name           string
+		steps          []step
+		expectStarted  time.Duration
+		expectFinished time.Duration
+	}{
+		{
+			name: "no clock skew",
+			steps: []step{
+				{status: "created"},
+				{status: "running", elapsed: time.Second, started: ts(time.Second)},
+				{status: "stopped", elapsed: time.Minute, started: ts(time.Second), finished: ts(30 * time.Second)},
+			},
+			expectStarted:  time.Second,
+			expectFinished: 30 * time.Second,
+		},
+		{
+			name: "clock stepped back before start",
+			steps: []step{
+				{status: "created"},
+				{status: "running", wallStep: -time.Hour, elapsed: time.Second, started: ts(-time.Hour)},
+				{status: "stopped", wallStep: -time.Hour, elapsed: time.Minute, started: ts(-time.Hour), finished: ts(-time.Hour + time.Minute)},
+			},
+			expectStarted:  time.Second,
+			expectFinished: time.Minute,
+		},
+		{
+			name: "clock stepped forward before exit",
+			steps: []step{
+				{status: "created"},
+				{status: "running", elapsed: time.Second, started: ts(time.Second)},
+				{status: "stopped", wallStep: time.Hour, elapsed: time.Minute, started: ts(time.Second), finished: ts(time.Hour)},
+			},
+			expectStarted:  time.Second,
+			expectFinished: time.Minute,
+		},
+		{
+			name: "exited before start is observed",
+			steps: []step{
+				{status: "created"},
+				{status: "stopped", elapsed: time.Minute, started: ts(time.Second), finished: ts(2 * time.Second)},
+			},
+			expectStarted:  time.Second,
+			expectFinished: 2 * time.Second,
+		},
+		{
+			name: "exited without start",
+			steps: []step{
+				{status: "created"},
+				{status: "stopped", wallStep: -time.Hour, elapsed: time.Minute},
+			},
+			expectFinished: time.Minute,
+		},
+	}
+
+	defer func(orig func() clockReading) { readClock = orig }(readClock)
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := &stateEngine{}
+			cont := NewContainer(&k8s.ContainerConfig{}, &Pod{id: "pod"}, &image.Info{ID: "busybox"}, "",
+				WithContainerEngine(engine))
+
+			for _, s := range tc.steps {
+				now := clockReading{wall: wall + int64(s.wallStep+s.elapsed), mono: time.Hour + s.elapsed}
+				readClock = func() clockReading { return now }
+				engine.state = &ociruntime.State{
+					State:      specs.State{Status: s.status},
+					CreatedAt:  ts(0),
+					StartedAt:  s.started,
+					FinishedAt: s.finished,
+				}
+				require.NoError(t, cont.UpdateState())
+			}
+			// subsequent observations never change recorded timestamps
+			readClock = func() clockReading { return clockReading{wall: wall - int64(time.Hour), mono: 2 * time.Hour} }
+			require.NoError(t, cont.UpdateState())
+
+			require.Equal(t, wall, cont.CreatedAt())
+			if tc.expectStarted != 0 {
+				require.Equal(t, wall+int64(tc.expectStarted), cont.StartedAt())
+				require.True(t, cont.CreatedAt() <= cont.StartedAt())
+				require.True(t, cont.StartedAt() <= cont.FinishedAt())
+			} else {
+				require.Zero(t, cont.StartedAt())
+			}
+			require.Equal(t, wall+int64(tc.expectFinished), cont.FinishedAt())
+			require.True(t, cont.CreatedAt() <= cont.FinishedAt())
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// AnnotationTimezone is a pod annotation that sets timezone of all pod
+	// containers, e.g. "Europe/Amsterdam". Special value "Local" makes
+	// containers use the timezone of the host.
+	AnnotationTimezone = "singularity.cri/timezone"
+
+	// LocalTimezone is a timezone annotation value that refers to host's timezone.
+	LocalTimezone = "Local"
+
+	// DefaultZoneinfoDir is a directory on the host timezone files are looked up in.
+	DefaultZoneinfoDir = "/usr/share/zoneinfo"
+
+	localtimePath = "/etc/localtime"
+)
+
+// zoneinfoDir and hostLocaltime are variables so that tests may override them.
+var (
+	zoneinfoDir   = DefaultZoneinfoDir
+	hostLocaltime = localtimePath
+)
+
+// Timezone holds timezone containers of a pod should be run in.
+type Timezone struct {
+	// Name is a value of TZ environment variable.
+	Name string
+	// Path is a host path of the timezone file mounted at /etc/localtime.
+	Path string
+}
+
+// ParseTimezone parses timezone from the passed pod annotations and resolves
+// it to a file on the host. When no timezone is requested nil is returned.
+func ParseTimezone(annotations map[string]string) (*Timezone, error) {
+	name := strings.TrimSpace(annotations[AnnotationTimezone])
+	if name == "" {
+		return nil, nil
+	}
+
+	if name == LocalTimezone {
+		if err := checkZoneFile(hostLocaltime); err != nil {
+			return nil, fmt.Errorf("could not use host timezone: %v", err)
+		}
+		return &Timezone{
+			Name: ":" + localtimePath,
+			Path: hostLocaltime,
+		}, nil
+	}
+
+	if filepath.IsAbs(name) || strings.Contains(name, "..") {
+		return nil, fmt.Errorf("invalid timezone %q", name)
+	}
+	path := filepath.Join(zoneinfoDir, name)
+	if err := checkZoneFile(path); err != nil {
+		return nil, fmt.Errorf("unknown timezone %q: not found in %s", name, zoneinfoDir)
+	}
+	return &Timezone{
+		Name: name,
+		Path: path,
+	}, nil
+}
+
+// checkZoneFile checks that path refers to a regular file, symlinks are followed.
+func checkZoneFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	return nil
+}
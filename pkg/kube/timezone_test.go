@@ -0,0 +1,90 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimezone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zoneinfo-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "Europe"), 0755))
+	amsterdam := filepath.Join(dir, "Europe", "Amsterdam")
+	require.NoError(t, ioutil.WriteFile(amsterdam, []byte("TZif"), 0644))
+	localtime := filepath.Join(dir, "localtime")
+	require.NoError(t, os.Symlink(amsterdam, localtime))
+
+	defer func(dir, local string) {
+		zoneinfoDir, hostLocaltime = dir, local
+	}(zoneinfoDir, hostLocaltime)
+	zoneinfoDir, hostLocaltime = dir, localtime
+
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		expect      *Timezone
+		expectError bool
+	}{
+		{
+			name:        "no annotation",
+			annotations: map[string]string{"foo": "bar"},
+		},
+		{
+			name:        "named zone",
+			annotations: map[string]string{AnnotationTimezone: "Europe/Amsterdam"},
+			expect:      &Timezone{Name: "Europe/Amsterdam", Path: amsterdam},
+		},
+		{
+			name:        "host zone",
+			annotations: map[string]string{AnnotationTimezone: LocalTimezone},
+			expect:      &Timezone{Name: ":/etc/localtime", Path: localtime},
+		},
+		{
+			name:        "unknown zone",
+			annotations: map[string]string{AnnotationTimezone: "Mars/Olympus"},
+			expectError: true,
+		},
+		{
+			name:        "directory",
+			annotations: map[string]string{AnnotationTimezone: "Europe"},
+			expectError: true,
+		},
+		{
+			name:        "escape zoneinfo",
+			annotations: map[string]string{AnnotationTimezone: "../etc/passwd"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			tz, err := ParseTimezone(tc.annotations)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, tz)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AnnotationTmpfsOptions is a container annotation that sets size and mode
+// of tmpfs mounts, i.e. container mounts with empty host path. Value is a
+// semicolon separated list of <container path>:<options> entries, e.g.
+// "/cache:size=64m,mode=0700;/scratch:size=1g".
+const AnnotationTmpfsOptions = "singularity.cri/tmpfs-options"
+
+// defaultTmpfsMode is a mode of tmpfs root directory unless overridden.
+const defaultTmpfsMode = "1777"
+
+// tmpfsSize matches tmpfs size option value, either in bytes with
+// an optional k, m or g suffix or in percent of physical memory.
+var tmpfsSize = regexp.MustCompile(`^[0-9]+[kKmMgG%]?$`)
+
+// ParseTmpfsOptions returns tmpfs mount options set by container annotation
+// keyed by cleaned container path. Nil map is returned when annotation
+// is not set.
+func ParseTmpfsOptions(annotations map[string]string) (map[string][]string, error) {
+	value, ok := annotations[AnnotationTmpfsOptions]
+	if !ok {
+		return nil, nil
+	}
+	options := make(map[string][]string)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		sep := strings.Index(entry, ":")
+		if sep == -1 {
+			return nil, fmt.Errorf("invalid %s annotation entry %q: expected <path>:<options>", AnnotationTmpfsOptions, entry)
+		}
+		dest, err := cleanDestination(entry[:sep])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation entry %q: %v", AnnotationTmpfsOptions, entry, err)
+		}
+		if _, ok := options[dest]; ok {
+			return nil, fmt.Errorf("invalid %s annotation: duplicate entry for %s", AnnotationTmpfsOptions, dest)
+		}
+		opts, err := parseTmpfsOptions(entry[sep+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation entry %q: %v", AnnotationTmpfsOptions, entry, err)
+		}
+		options[dest] = opts
+	}
+	return options, nil
+}
+
+// parseTmpfsOptions parses comma separated size and mode options and returns
+// complete tmpfs mount options.
+func parseTmpfsOptions(value string) ([]string, error) {
+	var size string
+	mode := defaultTmpfsMode
+	for _, opt := range strings.Split(value, ",") {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			continue
+		}
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("option %q is not a key=value pair", opt)
+		}
+		key, val := kv[0], kv[1]
+		switch key {
+		case "size":
+			if !tmpfsSize.MatchString(val) {
+				return nil, fmt.Errorf("invalid size %q", val)
+			}
+			size = val
+		case "mode":
+			m, err := strconv.ParseUint(val, 8, 32)
+			if err != nil || m > 07777 {
+				return nil, fmt.Errorf("invalid mode %q: expected octal permissions", val)
+			}
+			mode = strconv.FormatUint(m, 8)
+		default:
+			return nil, fmt.Errorf("unsupported option %q", key)
+		}
+	}
+	return tmpfsOptions(size, mode), nil
+}
+
+// tmpfsOptions returns tmpfs mount options with the given size and mode.
+// Empty size leaves the kernel default, i.e. half of physical memory.
+func tmpfsOptions(size, mode string) []string {
+	opts := []string{"nosuid", "nodev", "mode=" + mode}
+	if size != "" {
+		opts = append(opts, "size="+size)
+	}
+	return opts
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestParseTmpfsOptions(t *testing.T) {
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		expect      map[string][]string
+		expectError string
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name: "size and mode",
+			annotations: map[string]string{
+				AnnotationTmpfsOptions: "/cache:size=64m,mode=0700; /scratch/:size=10%",
+			},
+			expect: map[string][]string{
+				"/cache":   {"nosuid", "nodev", "mode=700", "size=64m"},
+				"/scratch": {"nosuid", "nodev", "mode=1777", "size=10%"},
+			},
+		},
+		{
+			name:        "missing options separator",
+			annotations: map[string]string{AnnotationTmpfsOptions: "/cache"},
+			expectError: `invalid singularity.cri/tmpfs-options annotation entry "/cache": expected <path>:<options>`,
+		},
+		{
+			name:        "relative path",
+			annotations: map[string]string{AnnotationTmpfsOptions: "cache:size=1g"},
+			expectError: `invalid singularity.cri/tmpfs-options annotation entry "cache:size=1g": container path "cache" is not absolute`,
+		},
+		{
+			name:        "duplicate path",
+			annotations: map[string]string{AnnotationTmpfsOptions: "/cache:size=1g;/cache/:size=2g"},
+			expectError: "invalid singularity.cri/tmpfs-options annotation: duplicate entry for /cache",
+		},
+		{
+			name:        "invalid size",
+			annotations: map[string]string{AnnotationTmpfsOptions: "/cache:size=1t"},
+			expectError: `invalid singularity.cri/tmpfs-options annotation entry "/cache:size=1t": invalid size "1t"`,
+		},
+		{
+			name:        "invalid mode",
+			annotations: map[string]string{AnnotationTmpfsOptions: "/cache:mode=0999"},
+			expectError: `invalid singularity.cri/tmpfs-options annotation entry "/cache:mode=0999": invalid mode "0999": expected octal permissions`,
+		},
+		{
+			name:        "unsupported option",
+			annotations: map[string]string{AnnotationTmpfsOptions: "/cache:exec=true"},
+			expectError: `invalid singularity.cri/tmpfs-options annotation entry "/cache:exec=true": unsupported option "exec"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := ParseTmpfsOptions(tc.annotations)
+			if tc.expectError != "" {
+				require.EqualError(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, actual)
+		})
+	}
+}
+
+func TestContainer_TmpfsMounts(t *testing.T) {
+	tt := []struct {
+		name         string
+		annotations  map[string]string
+		expectMounts []specs.Mount
+		expectError  string
+	}{
+		{
+			name: "default options",
+			expectMounts: []specs.Mount{
+				{Type: "tmpfs", Source: "tmpfs", Destination: "/cache", Options: []string{"nosuid", "nodev", "mode=1777"}},
+				{Type: "tmpfs", Source: "tmpfs", Destination: "/config", Options: []string{"nosuid", "nodev", "mode=1777", "ro"}},
+			},
+		},
+		{
+			name:        "annotated options",
+			annotations: map[string]string{AnnotationTmpfsOptions: "/cache:size=64m,mode=0700"},
+			expectMounts: []specs.Mount{
+				{Type: "tmpfs", Source: "tmpfs", Destination: "/cache", Options: []string{"nosuid", "nodev", "mode=700", "size=64m"}},
+				{Type: "tmpfs", Source: "tmpfs", Destination: "/config", Options: []string{"nosuid", "nodev", "mode=1777", "ro"}},
+			},
+		},
+		{
+			name:        "options for unknown mount",
+			annotations: map[string]string{AnnotationTmpfsOptions: "/data:size=64m"},
+			expectError: "invalid singularity.cri/tmpfs-options annotation: /data is not a tmpfs mount",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &Pod{
+				PodSandboxConfig: &k8s.PodSandboxConfig{Hostname: "pod"},
+				baseDir:          "/var/run/singularity/pods/test",
+			}
+			cont := &Container{
+				ContainerConfig: &k8s.ContainerConfig{
+					Mounts: []*k8s.Mount{
+						{ContainerPath: "/cache/"},
+						{ContainerPath: "/config", Readonly: true},
+					},
+					Annotations: tc.annotations,
+					Linux: &k8s.LinuxContainerConfig{
+						SecurityContext: &k8s.LinuxContainerSecurityContext{ReadonlyRootfs: true},
+					},
+				},
+				pod:     pod,
+				baseDir: "/var/run/singularity/containers/test",
+			}
+			err := cont.validateMounts()
+			if tc.expectError != "" {
+				require.EqualError(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+
+			g, err := generate.New("linux")
+			require.NoError(t, err)
+			tr := containerTranslator{cont: cont, pod: pod, g: g}
+			tr.configureImage()
+			require.NoError(t, tr.configureMounts())
+			require.True(t, g.Config.Root.Readonly)
+
+			var tmpfs []specs.Mount
+			for _, m := range g.Config.Mounts {
+				if m.Destination == "/cache" || m.Destination == "/config" {
+					tmpfs = append(tmpfs, m)
+				}
+			}
+			require.Equal(t, tc.expectMounts, tmpfs)
+		})
+	}
+}
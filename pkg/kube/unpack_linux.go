@@ -0,0 +1,268 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// whiteoutPrefix marks removal of lower layers entry with the rest of name.
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaque marks removal of lower layers content of its directory.
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+	// xattrPAXPrefix starts PAX records holding extended attributes.
+	xattrPAXPrefix = "SCHILY.xattr."
+)
+
+// unpackLayout applies layers of image stored in OCI layout at layoutPath
+// one by one into dir, so that dir holds image root filesystem that may be
+// used as overlay lower directory. Whiteouts are applied rather than kept.
+// Anything left in dir by a failed attempt is removed first.
+func unpackLayout(layoutPath, dir string) error {
+	layers, err := image.LayoutLayers(layoutPath)
+	if err != nil {
+		return fmt.Errorf("could not read image layout: %v", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("could not clean up root filesystem: %v", err)
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return fmt.Errorf("could not create root filesystem: %v", err)
+	}
+	for _, layer := range layers {
+		if err := applyLayerFile(layer, dir); err != nil {
+			return fmt.Errorf("could not apply layer %s: %v", filepath.Base(layer), err)
+		}
+	}
+	return nil
+}
+
+// applyLayerFile applies layer blob found at path, gzip compressed
+// layers are detected by their magic number.
+func applyLayerFile(path, root string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return applyLayer(r, root)
+}
+
+// applyLayer extracts layer tar stream into root on top of previous layers.
+func applyLayer(r io.Reader, root string) error {
+	// entries of this layer are kept by opaque whiteouts of their directory
+	added := make(map[string]bool)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean("/" + hdr.Name)
+		target := filepath.Join(root, filepath.FromSlash(name))
+		if err := checkParents(root, target); err != nil {
+			return err
+		}
+		base := path.Base(name)
+		switch {
+		case base == whiteoutOpaque:
+			if err := clearDir(filepath.Dir(target), added); err != nil {
+				return err
+			}
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			removed := filepath.Join(filepath.Dir(target), strings.TrimPrefix(base, whiteoutPrefix))
+			if err := os.RemoveAll(removed); err != nil {
+				return err
+			}
+			continue
+		}
+		if target == root && hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := applyEntry(tr, hdr, root, target); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		// parents may be created implicitly, they are added as well
+		for p := target; p != root && !added[p]; p = filepath.Dir(p) {
+			added[p] = true
+		}
+	}
+}
+
+// applyEntry creates a single layer entry at target replacing lower layers
+// entry, directories are merged instead. Ownership, permissions, extended
+// attributes and modification time are preserved.
+func applyEntry(tr *tar.Reader, hdr *tar.Header, root, target string) error {
+	if fi, err := os.Lstat(target); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if cErr := f.Close(); err == nil {
+			err = cErr
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		source := filepath.Join(root, filepath.FromSlash(path.Clean("/"+hdr.Linkname)))
+		if err := checkParents(root, source); err != nil {
+			return err
+		}
+		// hard link shares ownership and permissions with its source
+		return os.Link(source, target)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		fileType := uint32(unix.S_IFIFO)
+		switch hdr.Typeflag {
+		case tar.TypeChar:
+			fileType = unix.S_IFCHR
+		case tar.TypeBlock:
+			fileType = unix.S_IFBLK
+		}
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknod(target, fileType|mode, int(dev)); err != nil {
+			return err
+		}
+	default:
+		glog.V(4).Infof("Skipping layer entry %s of unsupported type %q", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+		return err
+	}
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, xattrPAXPrefix) {
+			continue
+		}
+		attr := strings.TrimPrefix(key, xattrPAXPrefix)
+		if err := unix.Lsetxattr(target, attr, []byte(value), 0); err != nil {
+			if err != unix.ENOTSUP {
+				return fmt.Errorf("could not set %s: %v", attr, err)
+			}
+			glog.V(4).Infof("Skipping %s of %s: %v", attr, hdr.Name, err)
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	// chown resets setuid and setgid bits, so mode is set afterwards
+	if err := unix.Chmod(target, mode); err != nil {
+		return err
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+// checkParents makes sure no directory between root and target is a
+// symlink, so that layer entries cannot be written outside of root.
+func checkParents(root, target string) error {
+	if target == root {
+		return nil
+	}
+	rel, err := filepath.Rel(root, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+	dir := root
+	for _, component := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, component)
+		fi, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is located under symlink %s", target, dir)
+		}
+	}
+	return nil
+}
+
+// clearDir removes lower layers content of dir, i.e. entries not in added.
+func clearDir(dir string, added map[string]bool) error {
+	f, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if added[path] {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	"golang.org/x/sys/unix"
+)
+
+// layerEntry is a single entry of test layer, content is used for regular files.
+type layerEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	content  string
+	linkname string
+}
+
+// newTestLayer returns tar layer with the passed entries owned by current user.
+func newTestLayer(tb testing.TB, compress bool, entries ...layerEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(&buf)
+	if compress {
+		w = tar.NewWriter(gz)
+	}
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Mode:     e.mode,
+			Linkname: e.linkname,
+			Size:     int64(len(e.content)),
+			Uid:      os.Getuid(),
+			Gid:      os.Getgid(),
+		}
+		require.NoError(tb, w.WriteHeader(hdr))
+		_, err := w.Write([]byte(e.content))
+		require.NoError(tb, err)
+	}
+	require.NoError(tb, w.Close())
+	if compress {
+		require.NoError(tb, gz.Close())
+	}
+	return buf.Bytes()
+}
+
+// newTestLayout writes OCI image layout with the passed layers into dir.
+func newTestLayout(tb testing.TB, dir string, layers ...[]byte) {
+	blobs := filepath.Join(dir, "blobs", "sha256")
+	require.NoError(tb, os.MkdirAll(blobs, 0755))
+	writeBlob := func(data []byte) string {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+		require.NoError(tb, ioutil.WriteFile(filepath.Join(blobs, digest[len("sha256:"):]), data, 0644))
+		return fmt.Sprintf(`{"mediaType":"application/vnd.oci.image.layer.v1.tar","digest":%q,"size":%d}`, digest, len(data))
+	}
+
+	config := writeBlob([]byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers"}}`))
+	var descs []string
+	for _, layer := range layers {
+		descs = append(descs, writeBlob(layer))
+	}
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"config":%s,"layers":[%s]}`, config, join(descs))
+	index := fmt.Sprintf(`{"schemaVersion":2,"manifests":[%s]}`, writeBlob([]byte(manifest)))
+	require.NoError(tb, ioutil.WriteFile(filepath.Join(dir, "index.json"), []byte(index), 0644))
+	require.NoError(tb, ioutil.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644))
+}
+
+func join(items []string) string {
+	var buf bytes.Buffer
+	for i, item := range items {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(item)
+	}
+	return buf.String()
+}
+
+func TestUnpackLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unpack-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	base := newTestLayer(t, false,
+		layerEntry{name: "./", typeflag: tar.TypeDir, mode: 0755},
+		layerEntry{name: "etc/", typeflag: tar.TypeDir, mode: 0755},
+		layerEntry{name: "etc/passwd", typeflag: tar.TypeReg, mode: 0644, content: "root:x:0:0"},
+		layerEntry{name: "etc/hosts", typeflag: tar.TypeReg, mode: 0644, content: "127.0.0.1"},
+		layerEntry{name: "var/cache/old", typeflag: tar.TypeReg, mode: 0600, content: "old"},
+		layerEntry{name: "usr/bin/sh", typeflag: tar.TypeReg, mode: 04755, content: "#!"},
+		layerEntry{name: "usr/bin/ash", typeflag: tar.TypeLink, linkname: "usr/bin/sh"},
+		layerEntry{name: "bin", typeflag: tar.TypeSymlink, linkname: "usr/bin"},
+	)
+	top := newTestLayer(t, true,
+		layerEntry{name: "etc/.wh.hosts", typeflag: tar.TypeReg},
+		layerEntry{name: "etc/passwd", typeflag: tar.TypeReg, mode: 0600, content: "root:x:0:0:root"},
+		layerEntry{name: "var/cache/new", typeflag: tar.TypeReg, mode: 0644, content: "new"},
+		layerEntry{name: "var/cache/.wh..wh..opq", typeflag: tar.TypeReg},
+	)
+	layout := filepath.Join(dir, "layout")
+	newTestLayout(t, layout, base, top)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "leftover"), 0755))
+	require.NoError(t, unpackLayout(layout, rootfs))
+
+	_, err = os.Stat(filepath.Join(rootfs, "leftover"))
+	require.True(t, os.IsNotExist(err), "leftovers of previous attempt must be removed")
+	data, err := ioutil.ReadFile(filepath.Join(rootfs, "etc", "passwd"))
+	require.NoError(t, err)
+	require.Equal(t, "root:x:0:0:root", string(data))
+	fi, err := os.Stat(filepath.Join(rootfs, "etc", "passwd"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode())
+	_, err = os.Stat(filepath.Join(rootfs, "etc", "hosts"))
+	require.True(t, os.IsNotExist(err), "whiteout must remove lower file")
+
+	entries, err := ioutil.ReadDir(filepath.Join(rootfs, "var", "cache"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "opaque whiteout must keep entries of its own layer only")
+	require.Equal(t, "new", entries[0].Name())
+
+	fi, err = os.Stat(filepath.Join(rootfs, "usr", "bin", "sh"))
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSetuid|0755, fi.Mode())
+	link, err := os.Stat(filepath.Join(rootfs, "usr", "bin", "ash"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(fi, link), "hard link must point to the same file")
+	target, err := os.Readlink(filepath.Join(rootfs, "bin"))
+	require.NoError(t, err)
+	require.Equal(t, "usr/bin", target)
+}
+
+func TestUnpackLayout_Escape(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unpack-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tt := []struct {
+		name    string
+		entries []layerEntry
+		outside string
+		isError bool
+	}{
+		{
+			name: "dot dot",
+			entries: []layerEntry{
+				{name: "../../escaped", typeflag: tar.TypeReg, mode: 0644, content: "x"},
+			},
+			outside: filepath.Join(dir, "escaped"),
+		},
+		{
+			name: "symlink parent",
+			entries: []layerEntry{
+				{name: "evil", typeflag: tar.TypeSymlink, linkname: dir},
+				{name: "evil/escaped", typeflag: tar.TypeReg, mode: 0644, content: "x"},
+			},
+			outside: filepath.Join(dir, "escaped"),
+			isError: true,
+		},
+		{
+			name: "hard link to host file",
+			entries: []layerEntry{
+				{name: "evil", typeflag: tar.TypeSymlink, linkname: dir},
+				{name: "passwd", typeflag: tar.TypeLink, linkname: "evil/layout/index.json"},
+			},
+			isError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			layout := filepath.Join(dir, "layout")
+			defer os.RemoveAll(layout)
+			newTestLayout(t, layout, newTestLayer(t, false, tc.entries...))
+
+			err := unpackLayout(layout, filepath.Join(dir, "rootfs"))
+			if tc.isError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			if tc.outside != "" {
+				_, err = os.Stat(tc.outside)
+				require.True(t, os.IsNotExist(err), "%s must not be created", tc.outside)
+			}
+		})
+	}
+}
+
+// newColdStartFixture creates image layout with total of size bytes
+// spread over compressed layers of random files 1MiB each.
+func newColdStartFixture(b *testing.B, dir string, size, layers int) string {
+	chunk := make([]byte, 1<<20)
+	var blobs [][]byte
+	for l := 0; l < layers; l++ {
+		var entries []layerEntry
+		for i := 0; i < size/len(chunk)/layers; i++ {
+			_, err := rand.Read(chunk)
+			require.NoError(b, err)
+			entries = append(entries, layerEntry{
+				name:     fmt.Sprintf("usr/lib/layer%d/lib%d.so", l, i),
+				typeflag: tar.TypeReg,
+				mode:     0644,
+				content:  string(chunk),
+			})
+		}
+		blobs = append(blobs, newTestLayer(b, true, entries...))
+	}
+	layout := filepath.Join(dir, "layout")
+	newTestLayout(b, layout, blobs...)
+	return layout
+}
+
+// BenchmarkColdStart compares time it takes to get root filesystem of a
+// pulled docker image ready for its first container. Image stored in OCI
+// layout is unpacked, while SIF conversion builds SIF out of the same
+// layout and mounts it. Download time is the same and is not included.
+func BenchmarkColdStart(b *testing.B) {
+	const (
+		fixtureSize   = 128 << 20
+		fixtureLayers = 4
+	)
+
+	dir, err := ioutil.TempDir("", "cold-start-bench-")
+	require.NoError(b, err)
+	defer os.RemoveAll(dir)
+	layout := newColdStartFixture(b, dir, fixtureSize, fixtureLayers)
+	rootfs := filepath.Join(dir, "rootfs")
+
+	b.Run("oci-layout", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, unpackLayout(layout, rootfs))
+			require.NoError(b, os.RemoveAll(rootfs))
+		}
+	})
+	b.Run("sif-conversion", func(b *testing.B) {
+		if _, err := exec.LookPath(singularity.RuntimeName); err != nil {
+			b.Skipf("%s is not installed: %v", singularity.RuntimeName, err)
+		}
+		if unix.Geteuid() != 0 {
+			b.Skip("SIF mount requires root")
+		}
+		sif := filepath.Join(dir, "image.sif")
+		require.NoError(b, os.MkdirAll(rootfs, 0755))
+		for i := 0; i < b.N; i++ {
+			out, err := exec.Command(singularity.RuntimeName, "build", "-F", sif, "oci:"+layout).CombinedOutput()
+			require.NoError(b, err, "%s", out)
+			require.NoError(b, mountLower(sif, rootfs, nil))
+			require.NoError(b, unmountLower(rootfs))
+		}
+	})
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// AnnotationUserNamespace is a pod annotation that sets ID mappings of pod
+// user namespace instead of runtime-wide ones. Value is a semicolon separated
+// list of uid=<container id>:<host id>:<size> and gid=<container id>:<host id>:<size>
+// entries, e.g. "uid=0:200000:65536;gid=0:200000:65536". Host IDs must be
+// within a single runtime-wide mapping each.
+const AnnotationUserNamespace = "singularity.cri/userns-mappings"
+
+// UserNamespace holds ID mappings of pod user namespace. Pod sandbox is run
+// in a new user namespace with those mappings and pod containers join it, so
+// that users containers run as, including root, are unprivileged on the host.
+// CRI RunAsUser and RunAsGroup are IDs inside of the namespace.
+type UserNamespace struct {
+	UIDMappings []specs.LinuxIDMapping `json:"uidMappings"`
+	GIDMappings []specs.LinuxIDMapping `json:"gidMappings"`
+}
+
+// Validate checks that both user and group mappings are set and that
+// neither container nor host ID ranges overlap.
+func (u *UserNamespace) Validate() error {
+	if err := validateIDMappings(u.UIDMappings); err != nil {
+		return fmt.Errorf("invalid uid mappings: %v", err)
+	}
+	if err := validateIDMappings(u.GIDMappings); err != nil {
+		return fmt.Errorf("invalid gid mappings: %v", err)
+	}
+	return nil
+}
+
+// HostUID returns host user ID uid maps to. False is returned if uid is not mapped.
+func (u *UserNamespace) HostUID(uid uint32) (uint32, bool) {
+	return mapID(u.UIDMappings, uid)
+}
+
+// HostGID returns host group ID gid maps to. False is returned if gid is not mapped.
+func (u *UserNamespace) HostGID(gid uint32) (uint32, bool) {
+	return mapID(u.GIDMappings, gid)
+}
+
+// PodUserNamespace returns ID mappings of user namespace pod with the passed
+// config is run in, either set with AnnotationUserNamespace or default ones.
+// Privileged and host network pods are run in the host user namespace, so nil
+// is returned for them, as well as when defaults are nil, i.e. user namespaces
+// are not enabled.
+func PodUserNamespace(config *k8s.PodSandboxConfig, defaults *UserNamespace) (*UserNamespace, error) {
+	value, annotated := config.GetAnnotations()[AnnotationUserNamespace]
+	if annotated && defaults == nil {
+		return nil, fmt.Errorf("%s annotation is set while user namespaces are not enabled", AnnotationUserNamespace)
+	}
+	security := config.GetLinux().GetSecurityContext()
+	if security.GetPrivileged() || security.GetNamespaceOptions().GetNetwork() != k8s.NamespaceMode_POD {
+		if annotated {
+			return nil, fmt.Errorf("%s annotation is not supported for privileged and host network pods", AnnotationUserNamespace)
+		}
+		return nil, nil
+	}
+	if !annotated {
+		return defaults, nil
+	}
+
+	userNs, err := parseUserNamespace(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", AnnotationUserNamespace, err)
+	}
+	if err := userNs.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", AnnotationUserNamespace, err)
+	}
+	if m, ok := withinHostIDs(defaults.UIDMappings, userNs.UIDMappings); !ok {
+		return nil, fmt.Errorf("uid mapping %s is outside of allowed host IDs", formatIDMapping(m))
+	}
+	if m, ok := withinHostIDs(defaults.GIDMappings, userNs.GIDMappings); !ok {
+		return nil, fmt.Errorf("gid mapping %s is outside of allowed host IDs", formatIDMapping(m))
+	}
+	return userNs, nil
+}
+
+// parseUserNamespace parses AnnotationUserNamespace value.
+func parseUserNamespace(value string) (*UserNamespace, error) {
+	var userNs UserNamespace
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("entry %q: expected uid=<mapping> or gid=<mapping>", entry)
+		}
+		m, err := parseIDMapping(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %v", entry, err)
+		}
+		switch kv[0] {
+		case "uid":
+			userNs.UIDMappings = append(userNs.UIDMappings, m)
+		case "gid":
+			userNs.GIDMappings = append(userNs.GIDMappings, m)
+		default:
+			return nil, fmt.Errorf("entry %q: unknown mapping kind %q", entry, kv[0])
+		}
+	}
+	return &userNs, nil
+}
+
+// parseIDMapping parses <container id>:<host id>:<size> mapping.
+func parseIDMapping(value string) (specs.LinuxIDMapping, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return specs.LinuxIDMapping{}, fmt.Errorf("expected <container id>:<host id>:<size>, got %q", value)
+	}
+	var ids [3]uint32
+	for i, part := range parts {
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return specs.LinuxIDMapping{}, fmt.Errorf("invalid ID %q", part)
+		}
+		ids[i] = uint32(id)
+	}
+	return specs.LinuxIDMapping{ContainerID: ids[0], HostID: ids[1], Size: ids[2]}, nil
+}
+
+func formatIDMapping(m specs.LinuxIDMapping) string {
+	return fmt.Sprintf("%d:%d:%d", m.ContainerID, m.HostID, m.Size)
+}
+
+func validateIDMappings(mappings []specs.LinuxIDMapping) error {
+	if len(mappings) == 0 {
+		return fmt.Errorf("at least one mapping must be set")
+	}
+	for i, m := range mappings {
+		if m.Size == 0 {
+			return fmt.Errorf("mapping %s is empty", formatIDMapping(m))
+		}
+		if uint64(m.ContainerID)+uint64(m.Size) > 1<<32 || uint64(m.HostID)+uint64(m.Size) > 1<<32 {
+			return fmt.Errorf("mapping %s overflows 32-bit IDs", formatIDMapping(m))
+		}
+		for _, prev := range mappings[:i] {
+			if overlaps(prev.ContainerID, prev.Size, m.ContainerID, m.Size) {
+				return fmt.Errorf("container IDs of mappings %s and %s overlap", formatIDMapping(prev), formatIDMapping(m))
+			}
+			if overlaps(prev.HostID, prev.Size, m.HostID, m.Size) {
+				return fmt.Errorf("host IDs of mappings %s and %s overlap", formatIDMapping(prev), formatIDMapping(m))
+			}
+		}
+	}
+	return nil
+}
+
+// overlaps checks whether ID ranges of the passed starts and sizes overlap.
+func overlaps(a, aSize, b, bSize uint32) bool {
+	return uint64(a) < uint64(b)+uint64(bSize) && uint64(b) < uint64(a)+uint64(aSize)
+}
+
+// withinHostIDs checks host IDs of every mapping are within host IDs of one of
+// the allowed mappings. The first mapping that is not is returned along with false.
+func withinHostIDs(allowed, mappings []specs.LinuxIDMapping) (specs.LinuxIDMapping, bool) {
+	for _, m := range mappings {
+		within := false
+		for _, a := range allowed {
+			if m.HostID >= a.HostID && uint64(m.HostID)+uint64(m.Size) <= uint64(a.HostID)+uint64(a.Size) {
+				within = true
+				break
+			}
+		}
+		if !within {
+			return m, false
+		}
+	}
+	return specs.LinuxIDMapping{}, true
+}
+
+func mapID(mappings []specs.LinuxIDMapping, id uint32) (uint32, bool) {
+	for _, m := range mappings {
+		if id >= m.ContainerID && uint64(id) < uint64(m.ContainerID)+uint64(m.Size) {
+			return m.HostID + (id - m.ContainerID), true
+		}
+	}
+	return 0, false
+}
+
+// checkMapped checks that user and group processes run as are mapped in user namespace.
+func checkMapped(userNs *UserNamespace, uid, gid uint32) error {
+	if _, ok := userNs.HostUID(uid); !ok {
+		return fmt.Errorf("user %d is not mapped in pod user namespace", uid)
+	}
+	if _, ok := userNs.HostGID(gid); !ok {
+		return fmt.Errorf("group %d is not mapped in pod user namespace", gid)
+	}
+	return nil
+}
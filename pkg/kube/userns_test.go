@@ -0,0 +1,246 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestPodUserNamespace(t *testing.T) {
+	defaults := &UserNamespace{
+		UIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 1000000}},
+		GIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 1000000}},
+	}
+	podConfig := func(network k8s.NamespaceMode, privileged bool, annotation string) *k8s.PodSandboxConfig {
+		config := &k8s.PodSandboxConfig{
+			Linux: &k8s.LinuxPodSandboxConfig{
+				SecurityContext: &k8s.LinuxSandboxSecurityContext{
+					NamespaceOptions: &k8s.NamespaceOption{Network: network},
+					Privileged:       privileged,
+				},
+			},
+		}
+		if annotation != "" {
+			config.Annotations = map[string]string{AnnotationUserNamespace: annotation}
+		}
+		return config
+	}
+
+	tt := []struct {
+		name        string
+		config      *k8s.PodSandboxConfig
+		defaults    *UserNamespace
+		expect      *UserNamespace
+		expectError string
+	}{
+		{
+			name:   "disabled",
+			config: podConfig(k8s.NamespaceMode_POD, false, ""),
+		},
+		{
+			name:        "annotation while disabled",
+			config:      podConfig(k8s.NamespaceMode_POD, false, "uid=0:200000:65536;gid=0:200000:65536"),
+			expectError: "singularity.cri/userns-mappings annotation is set while user namespaces are not enabled",
+		},
+		{
+			name:     "defaults",
+			config:   podConfig(k8s.NamespaceMode_POD, false, ""),
+			defaults: defaults,
+			expect:   defaults,
+		},
+		{
+			name:     "host network",
+			config:   podConfig(k8s.NamespaceMode_NODE, false, ""),
+			defaults: defaults,
+		},
+		{
+			name:     "privileged",
+			config:   podConfig(k8s.NamespaceMode_POD, true, ""),
+			defaults: defaults,
+		},
+		{
+			name:        "annotated privileged",
+			config:      podConfig(k8s.NamespaceMode_POD, true, "uid=0:200000:65536;gid=0:200000:65536"),
+			defaults:    defaults,
+			expectError: "singularity.cri/userns-mappings annotation is not supported for privileged and host network pods",
+		},
+		{
+			name:     "annotated",
+			config:   podConfig(k8s.NamespaceMode_POD, false, "uid=0:200000:65536; gid=0:300000:1;gid=1:300001:65535"),
+			defaults: defaults,
+			expect: &UserNamespace{
+				UIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 200000, Size: 65536}},
+				GIDMappings: []specs.LinuxIDMapping{
+					{ContainerID: 0, HostID: 300000, Size: 1},
+					{ContainerID: 1, HostID: 300001, Size: 65535},
+				},
+			},
+		},
+		{
+			name:        "outside of allowed host IDs",
+			config:      podConfig(k8s.NamespaceMode_POD, false, "uid=0:0:65536;gid=0:200000:65536"),
+			defaults:    defaults,
+			expectError: "uid mapping 0:0:65536 is outside of allowed host IDs",
+		},
+		{
+			name:        "crossing allowed host IDs end",
+			config:      podConfig(k8s.NamespaceMode_POD, false, "uid=0:200000:65536;gid=0:1000000:200000"),
+			defaults:    defaults,
+			expectError: "gid mapping 0:1000000:200000 is outside of allowed host IDs",
+		},
+		{
+			name:        "no gid mappings",
+			config:      podConfig(k8s.NamespaceMode_POD, false, "uid=0:200000:65536"),
+			defaults:    defaults,
+			expectError: "invalid singularity.cri/userns-mappings annotation: invalid gid mappings: at least one mapping must be set",
+		},
+		{
+			name:        "overlapping container IDs",
+			config:      podConfig(k8s.NamespaceMode_POD, false, "uid=0:200000:10;uid=5:300000:10;gid=0:200000:65536"),
+			defaults:    defaults,
+			expectError: "invalid singularity.cri/userns-mappings annotation: invalid uid mappings: container IDs of mappings 0:200000:10 and 5:300000:10 overlap",
+		},
+		{
+			name:        "unknown kind",
+			config:      podConfig(k8s.NamespaceMode_POD, false, "user=0:200000:65536"),
+			defaults:    defaults,
+			expectError: `invalid singularity.cri/userns-mappings annotation: entry "user=0:200000:65536": unknown mapping kind "user"`,
+		},
+		{
+			name:        "invalid mapping",
+			config:      podConfig(k8s.NamespaceMode_POD, false, "uid=0:200000"),
+			defaults:    defaults,
+			expectError: `invalid singularity.cri/userns-mappings annotation: entry "uid=0:200000": expected <container id>:<host id>:<size>, got "0:200000"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			userNs, err := PodUserNamespace(tc.config, tc.defaults)
+			if tc.expectError != "" {
+				require.EqualError(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, userNs)
+		})
+	}
+}
+
+func TestUserNamespace_Validate(t *testing.T) {
+	tt := []struct {
+		name        string
+		mappings    []specs.LinuxIDMapping
+		expectError string
+	}{
+		{
+			name:     "valid",
+			mappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65535}},
+		},
+		{
+			name:        "empty mapping",
+			mappings:    []specs.LinuxIDMapping{{ContainerID: 0, HostID: 1000}},
+			expectError: "invalid uid mappings: mapping 0:1000:0 is empty",
+		},
+		{
+			name:        "overflow",
+			mappings:    []specs.LinuxIDMapping{{ContainerID: 0, HostID: 4294967295, Size: 2}},
+			expectError: "invalid uid mappings: mapping 0:4294967295:2 overflows 32-bit IDs",
+		},
+		{
+			name:        "overlapping host IDs",
+			mappings:    []specs.LinuxIDMapping{{ContainerID: 0, HostID: 1000, Size: 10}, {ContainerID: 10, HostID: 1009, Size: 10}},
+			expectError: "invalid uid mappings: host IDs of mappings 0:1000:10 and 10:1009:10 overlap",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			userNs := &UserNamespace{
+				UIDMappings: tc.mappings,
+				GIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 1000, Size: 1}},
+			}
+			err := userNs.Validate()
+			if tc.expectError != "" {
+				require.EqualError(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestUserNamespace_HostUID(t *testing.T) {
+	userNs := &UserNamespace{
+		UIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65535}},
+	}
+	tt := []struct {
+		uid      uint32
+		expect   uint32
+		expectOk bool
+	}{
+		{uid: 0, expect: 1000, expectOk: true},
+		{uid: 1, expect: 100000, expectOk: true},
+		{uid: 65535, expect: 165534, expectOk: true},
+		{uid: 65536},
+	}
+	for _, tc := range tt {
+		uid, ok := userNs.HostUID(tc.uid)
+		require.Equal(t, tc.expectOk, ok, "uid %d", tc.uid)
+		require.Equal(t, tc.expect, uid, "uid %d", tc.uid)
+	}
+}
+
+func TestPodUserNamespace_Spec(t *testing.T) {
+	userNs := &UserNamespace{
+		UIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		GIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 200000, Size: 65536}},
+	}
+	pod := &Pod{
+		PodSandboxConfig: &k8s.PodSandboxConfig{
+			Hostname: "pod",
+			Linux:    &k8s.LinuxPodSandboxConfig{},
+		},
+		baseDir:    "/var/run/singularity/pods/test",
+		namespaces: []specs.LinuxNamespace{{Type: specs.UserNamespace}},
+		userNs:     userNs,
+	}
+	podSpec, err := translatePod(pod)
+	require.NoError(t, err)
+	require.Contains(t, podSpec.Linux.Namespaces, specs.LinuxNamespace{Type: specs.UserNamespace})
+	require.Equal(t, userNs.UIDMappings, podSpec.Linux.UIDMappings)
+	require.Equal(t, userNs.GIDMappings, podSpec.Linux.GIDMappings)
+
+	// containers join pod user namespace without mappings of their own
+	pod.namespaces[0].Path = pod.bindNamespacePath(specs.UserNamespace)
+	cont := &Container{
+		ContainerConfig: &k8s.ContainerConfig{},
+		pod:             pod,
+	}
+	g, err := generate.New("linux")
+	require.NoError(t, err)
+	tr := containerTranslator{cont: cont, pod: pod, g: g}
+	tr.configureNamespaces()
+	require.Contains(t, g.Config.Linux.Namespaces, specs.LinuxNamespace{
+		Type: specs.UserNamespace,
+		Path: "/var/run/singularity/pods/test/namespaces/user",
+	})
+	require.Empty(t, g.Config.Linux.UIDMappings)
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/opencontainers/selinux/go-selinux/label"
+)
+
+// AnnotationVolumes is a container annotation that mounts named volumes
+// managed by the runtime. Value is a semicolon separated list of
+// <name>:<container path>[:ro] entries, e.g. "cache:/var/cache;data:/data:ro".
+// Volumes belong to the pod: volume is created on first use and is shared
+// by all pod containers using the same name, it survives container
+// recreation and is removed along with the pod.
+const AnnotationVolumes = "singularity.cri/volumes"
+
+const (
+	podVolumesPath = "volumes/"
+	// volumeDirPerm allows containers run as any user to write to volume,
+	// the same way kubelet sets up emptyDir volumes.
+	volumeDirPerm = 0777
+)
+
+// volumeName matches names of runtime-managed volumes,
+// i.e. DNS labels, so that name is always a valid directory.
+var volumeName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// VolumeMount is a runtime-managed volume mounted into container.
+type VolumeMount struct {
+	Name          string `json:"name"`
+	ContainerPath string `json:"containerPath"`
+	Readonly      bool   `json:"readonly,omitempty"`
+}
+
+// ParseVolumes returns volume mounts requested by container annotation
+// in order they are listed. Nil is returned when annotation is not set.
+func ParseVolumes(annotations map[string]string) ([]VolumeMount, error) {
+	value, ok := annotations[AnnotationVolumes]
+	if !ok {
+		return nil, nil
+	}
+	var volumes []VolumeMount
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "ro") {
+			return nil, fmt.Errorf("invalid %s annotation entry %q: expected <name>:<path>[:ro]", AnnotationVolumes, entry)
+		}
+		if !volumeName.MatchString(parts[0]) {
+			return nil, fmt.Errorf("invalid %s annotation entry %q: volume name must be a DNS label", AnnotationVolumes, entry)
+		}
+		dest, err := cleanDestination(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation entry %q: %v", AnnotationVolumes, entry, err)
+		}
+		if seen[dest] {
+			return nil, fmt.Errorf("invalid %s annotation: duplicate entry for %s", AnnotationVolumes, dest)
+		}
+		seen[dest] = true
+		volumes = append(volumes, VolumeMount{
+			Name:          parts[0],
+			ContainerPath: dest,
+			Readonly:      len(parts) == 3,
+		})
+	}
+	return volumes, nil
+}
+
+// validateVolumes checks volumes requested by container annotation
+// don't shadow mounts requested by container config.
+func (c *Container) validateVolumes() error {
+	volumes, err := ParseVolumes(c.GetAnnotations())
+	if err != nil {
+		return err
+	}
+	for _, volume := range volumes {
+		if c.hasMount(volume.ContainerPath) {
+			return fmt.Errorf("invalid %s annotation: %s is mounted already", AnnotationVolumes, volume.ContainerPath)
+		}
+	}
+	return nil
+}
+
+// hasMount checks whether container config requests
+// a mount with the passed cleaned container path.
+func (c *Container) hasMount(path string) bool {
+	for _, mount := range c.GetMounts() {
+		if filepath.Clean(mount.GetContainerPath()) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Volumes returns runtime-managed volumes mounted into container.
+func (c *Container) Volumes() []VolumeMount {
+	// annotations are validated on container creation
+	volumes, _ := ParseVolumes(c.GetAnnotations())
+	return volumes
+}
+
+// volumePath returns path to pod's volume directory with the passed name.
+func (p *Pod) volumePath(name string) string {
+	return filepath.Join(p.baseDir, podVolumesPath, name)
+}
+
+// ensureVolume returns path to pod's volume directory creating it when the
+// volume is used for the first time. Volume is labeled with pod mount label,
+// since it may be shared by any pod container.
+func (p *Pod) ensureVolume(name string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	path := p.volumePath(name)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	glog.V(3).Infof("Creating volume %s of pod %s", name, p.id)
+	if err := os.MkdirAll(path, volumeDirPerm); err != nil {
+		return "", fmt.Errorf("could not create volume %s: %v", name, err)
+	}
+	// permissions are set explicitly since umask applies to MkdirAll
+	if err := os.Chmod(path, volumeDirPerm); err != nil {
+		return "", fmt.Errorf("could not set volume %s permissions: %v", name, err)
+	}
+	if p.mountLabel != "" {
+		if err := label.Relabel(path, p.mountLabel, true); err != nil {
+			return "", fmt.Errorf("could not relabel volume %s: %v", name, err)
+		}
+	}
+	return path, nil
+}
+
+// Volumes returns names of runtime-managed volumes created in the pod so far.
+func (p *Pod) Volumes() []string {
+	entries, err := ioutil.ReadDir(filepath.Join(p.baseDir, podVolumesPath))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Warningf("Could not list pod %s volumes: %v", p.id, err)
+		}
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
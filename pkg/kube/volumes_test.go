@@ -0,0 +1,146 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestParseVolumes(t *testing.T) {
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		expect      []VolumeMount
+		expectError string
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:        "volumes",
+			annotations: map[string]string{AnnotationVolumes: "cache:/var/cache/; data:/data:ro"},
+			expect: []VolumeMount{
+				{Name: "cache", ContainerPath: "/var/cache"},
+				{Name: "data", ContainerPath: "/data", Readonly: true},
+			},
+		},
+		{
+			name:        "missing path",
+			annotations: map[string]string{AnnotationVolumes: "cache"},
+			expectError: `invalid singularity.cri/volumes annotation entry "cache": expected <name>:<path>[:ro]`,
+		},
+		{
+			name:        "unknown option",
+			annotations: map[string]string{AnnotationVolumes: "cache:/cache:rw"},
+			expectError: `invalid singularity.cri/volumes annotation entry "cache:/cache:rw": expected <name>:<path>[:ro]`,
+		},
+		{
+			name:        "invalid name",
+			annotations: map[string]string{AnnotationVolumes: "../etc:/cache"},
+			expectError: `invalid singularity.cri/volumes annotation entry "../etc:/cache": volume name must be a DNS label`,
+		},
+		{
+			name:        "relative path",
+			annotations: map[string]string{AnnotationVolumes: "cache:cache"},
+			expectError: `invalid singularity.cri/volumes annotation entry "cache:cache": container path "cache" is not absolute`,
+		},
+		{
+			name:        "duplicate path",
+			annotations: map[string]string{AnnotationVolumes: "cache:/cache;other:/cache/"},
+			expectError: "invalid singularity.cri/volumes annotation: duplicate entry for /cache",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := ParseVolumes(tc.annotations)
+			if tc.expectError != "" {
+				require.EqualError(t, err, tc.expectError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, actual)
+		})
+	}
+}
+
+func TestContainer_VolumeMounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volumes")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pod := &Pod{
+		id:               "pod",
+		PodSandboxConfig: &k8s.PodSandboxConfig{Hostname: "pod"},
+		baseDir:          dir,
+	}
+	newContainer := func(volumes string) *Container {
+		return &Container{
+			ContainerConfig: &k8s.ContainerConfig{
+				Mounts:      []*k8s.Mount{{ContainerPath: "/tmp"}},
+				Annotations: map[string]string{AnnotationVolumes: volumes},
+			},
+			pod: pod,
+		}
+	}
+	mounts := func(cont *Container) []specs.Mount {
+		g, err := generate.New("linux")
+		require.NoError(t, err)
+		tr := containerTranslator{cont: cont, pod: pod, g: g}
+		tr.configureImage()
+		require.NoError(t, tr.configureMounts())
+		var volumes []specs.Mount
+		for _, m := range g.Config.Mounts {
+			if filepath.Dir(m.Source) == filepath.Join(dir, podVolumesPath) {
+				volumes = append(volumes, m)
+			}
+		}
+		return volumes
+	}
+
+	require.EqualError(t, newContainer("cache:/tmp").validateVolumes(),
+		"invalid singularity.cri/volumes annotation: /tmp is mounted already")
+
+	writer := newContainer("cache:/cache")
+	require.NoError(t, writer.validateVolumes())
+	require.Equal(t, []specs.Mount{
+		{Source: filepath.Join(dir, "volumes/cache"), Destination: "/cache", Options: []string{"rbind", "nosuid", "nodev", "rprivate"}},
+	}, mounts(writer))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "volumes/cache/data"), []byte("kept"), 0644))
+
+	// recreated container sees data written by the previous one
+	reader := newContainer("cache:/var/cache:ro;scratch:/scratch")
+	require.NoError(t, reader.validateVolumes())
+	require.Equal(t, []specs.Mount{
+		{Source: filepath.Join(dir, "volumes/cache"), Destination: "/var/cache", Options: []string{"rbind", "nosuid", "nodev", "rprivate", "ro"}},
+		{Source: filepath.Join(dir, "volumes/scratch"), Destination: "/scratch", Options: []string{"rbind", "nosuid", "nodev", "rprivate"}},
+	}, mounts(reader))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "volumes/cache/data"))
+	require.NoError(t, err)
+	require.Equal(t, "kept", string(data))
+
+	fi, err := os.Stat(filepath.Join(dir, "volumes/scratch"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(volumeDirPerm), fi.Mode().Perm())
+	require.Equal(t, []string{"cache", "scratch"}, pod.Volumes())
+}
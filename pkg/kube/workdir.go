@@ -0,0 +1,143 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// WorkingDirError is returned when container working
+// directory cannot be used or created in container rootfs.
+type WorkingDirError struct {
+	Path   string
+	Reason string
+}
+
+func (e *WorkingDirError) Error() string {
+	return fmt.Sprintf("invalid working directory %s: %s", e.Path, e.Reason)
+}
+
+// addWorkingDir creates container working directory in rootfs if it is
+// missing in the image, like docker does, owned by container user.
+func (c *Container) addWorkingDir(s *specs.Spec) error {
+	if s.Process == nil || s.Process.Cwd == "" || s.Root == nil {
+		return nil
+	}
+	return ensureWorkingDir(c.rootfsPath(), s.Process.Cwd, int(s.Process.User.UID), int(s.Process.User.GID), s.Root.Readonly)
+}
+
+func ensureWorkingDir(rootfs, cwd string, uid, gid int, readonly bool) error {
+	if !filepath.IsAbs(cwd) {
+		return &WorkingDirError{Path: cwd, Reason: "path is not absolute"}
+	}
+	path, err := resolveInRoot(rootfs, cwd)
+	if err != nil {
+		return fmt.Errorf("could not resolve working directory: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err == nil {
+		if !fi.IsDir() {
+			return &WorkingDirError{Path: cwd, Reason: "path exists and is not a directory"}
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) && !isNotDir(err) {
+		return fmt.Errorf("could not check working directory: %v", err)
+	}
+
+	// create missing directories top down so that all of them are owned by container user
+	var missing []string
+	for dir := path; dir != rootfs; dir = filepath.Dir(dir) {
+		fi, err := os.Lstat(dir)
+		if err == nil {
+			if !fi.IsDir() {
+				return &WorkingDirError{Path: cwd, Reason: fmt.Sprintf("%s is not a directory", strings.TrimPrefix(dir, rootfs))}
+			}
+			break
+		}
+		missing = append(missing, dir)
+	}
+	if readonly {
+		return &WorkingDirError{Path: cwd, Reason: "directory does not exist in image and cannot be created on read-only rootfs"}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0755); err != nil {
+			return fmt.Errorf("could not create working directory: %v", err)
+		}
+		if err := os.Lchown(missing[i], uid, gid); err != nil {
+			return fmt.Errorf("could not change working directory owner: %v", err)
+		}
+	}
+	return nil
+}
+
+// resolveInRoot resolves path inside root following symlinks the way they are
+// resolved in container, i.e. absolute symlinks point inside root and .. never
+// leaves root. Absent trailing components are appended to the resolved part as is.
+func resolveInRoot(root, path string) (string, error) {
+	resolved := "/"
+	parts := strings.Split(path, "/")
+	links := 0
+	for len(parts) != 0 {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) || isNotDir(err) {
+			rest := filepath.Join(append([]string{next}, parts...)...)
+			return filepath.Join(root, filepath.Clean("/"+rest)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks in %s", path)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+func isNotDir(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	return ok && pathErr.Err == syscall.ENOTDIR
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnsureWorkingDir(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(rootfs)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "srv/app"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rootfs, "srv/file"), nil, 0644))
+	// absolute symlink must be resolved inside rootfs
+	require.NoError(t, os.Symlink("/srv", filepath.Join(rootfs, "data")))
+
+	uid, gid := os.Getuid(), os.Getgid()
+	tt := []struct {
+		name         string
+		cwd          string
+		readonly     bool
+		expectCreate string
+		expectError  bool
+	}{
+		{
+			name: "existing dir",
+			cwd:  "/srv/app",
+		},
+		{
+			name:     "existing dir on read-only rootfs",
+			cwd:      "/srv/app",
+			readonly: true,
+		},
+		{
+			name:         "missing dir",
+			cwd:          "/work/dir",
+			expectCreate: "work/dir",
+		},
+		{
+			name:         "missing dir behind symlink",
+			cwd:          "/data/cache",
+			expectCreate: "srv/cache",
+		},
+		{
+			name:        "file in the way",
+			cwd:         "/srv/file",
+			expectError: true,
+		},
+		{
+			name:        "file in the middle",
+			cwd:         "/srv/file/dir",
+			expectError: true,
+		},
+		{
+			name:        "missing dir on read-only rootfs",
+			cwd:         "/readonly",
+			readonly:    true,
+			expectError: true,
+		},
+		{
+			name:        "relative path",
+			cwd:         "srv/app",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ensureWorkingDir(rootfs, tc.cwd, uid, gid, tc.readonly)
+			if tc.expectError {
+				require.IsType(t, &WorkingDirError{}, err)
+				return
+			}
+			require.NoError(t, err)
+			if tc.expectCreate == "" {
+				return
+			}
+			fi, err := os.Stat(filepath.Join(rootfs, tc.expectCreate))
+			require.NoError(t, err)
+			require.True(t, fi.IsDir())
+			require.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+			st := fi.Sys().(*syscall.Stat_t)
+			require.Equal(t, uint32(uid), st.Uid)
+			require.Equal(t, uint32(gid), st.Gid)
+		})
+	}
+
+	_, err = os.Stat(filepath.Join(rootfs, "readonly"))
+	require.True(t, os.IsNotExist(err), "directory created on read-only rootfs")
+}
+
+func TestResolveInRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(root)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "usr/lib"), 0755))
+	require.NoError(t, os.Symlink("usr/lib", filepath.Join(root, "lib")))
+	require.NoError(t, os.Symlink("../../../..", filepath.Join(root, "usr/up")))
+	require.NoError(t, os.Symlink("loop", filepath.Join(root, "loop")))
+
+	tt := []struct {
+		path        string
+		expect      string
+		expectError bool
+	}{
+		{path: "/usr/lib", expect: "usr/lib"},
+		{path: "/lib/x", expect: "usr/lib/x"},
+		{path: "/usr/up/etc", expect: "etc"},
+		{path: "/../../etc", expect: "etc"},
+		{path: "/missing/../../etc", expect: "etc"},
+		{path: "/loop", expectError: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.path, func(t *testing.T) {
+			resolved, err := resolveInRoot(root, tc.path)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, filepath.Join(root, tc.expect), resolved)
+		})
+	}
+}
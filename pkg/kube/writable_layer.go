@@ -0,0 +1,137 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kube
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+)
+
+const (
+	// AnnotationWritableLayerSize is a container annotation that limits size
+	// in bytes of container writable layer, it overrides runtime default.
+	AnnotationWritableLayerSize = "singularity.cri/writable-layer-size"
+
+	// MinWritableLayerSize is the smallest allowed writable layer size limit.
+	MinWritableLayerSize = 1 << 20
+)
+
+// ParseWritableLayerSize returns writable layer size limit set by container
+// annotation. Zero is returned when annotation is not set.
+func ParseWritableLayerSize(annotations map[string]string) (int64, error) {
+	value, ok := annotations[AnnotationWritableLayerSize]
+	if !ok {
+		return 0, nil
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || size < MinWritableLayerSize {
+		return 0, fmt.Errorf("invalid %s annotation %q: expected number of bytes not less than %d",
+			AnnotationWritableLayerSize, value, MinWritableLayerSize)
+	}
+	return size, nil
+}
+
+// WithWritableLayerLimit sets default size limit of container writable layer
+// and project quota it is enforced with. Zero size leaves writable layer
+// unlimited unless annotation is set. With no quota limited writable layer is
+// kept on sized tmpfs, which is possible only for containers sharing lower
+// directories, see WithLowerDirs.
+func WithWritableLayerLimit(size int64, quota *fs.ProjectQuota) ContainerOption {
+	return func(c *Container) {
+		c.writableLayerSize = size
+		c.writableQuota = quota
+	}
+}
+
+// writableLayerLimit returns size limit of container writable
+// layer, annotation takes precedence over runtime default.
+func (c *Container) writableLayerLimit() int64 {
+	// annotations are validated on container creation
+	if size, _ := ParseWritableLayerSize(c.GetAnnotations()); size != 0 {
+		return size
+	}
+	return c.writableLayerSize
+}
+
+// prepareWritableLayer mounts sized tmpfs writable layer of overlay bundle
+// is created on when its size is limited and project quotas are unavailable.
+func (c *Container) prepareWritableLayer() error {
+	if c.writableQuota != nil || c.writableLayerLimit() == 0 {
+		return nil
+	}
+	if err := mountWritableTmpfs(c.bundlePath(), c.writableLayerLimit()); err != nil {
+		return fmt.Errorf("could not limit writable layer: %v", err)
+	}
+	return nil
+}
+
+// limitWritableLayer sets project quota of created bundle writable layer.
+func (c *Container) limitWritableLayer() error {
+	size := c.writableLayerLimit()
+	if size == 0 || runtime.IsFake(c.cli) {
+		return nil
+	}
+	if c.writableQuota == nil {
+		if c.lowerDirs != nil {
+			// tmpfs is mounted by prepareWritableLayer
+			return nil
+		}
+		return fmt.Errorf("could not limit writable layer: %v", fs.ErrQuotaNotSupported)
+	}
+	path := c.writableLayerPath()
+	if path == c.baseDir {
+		return fmt.Errorf("could not limit writable layer: bundle has no writable layer")
+	}
+	if err := c.writableQuota.SetLimit(path, size); err != nil {
+		return fmt.Errorf("could not limit writable layer: %v", err)
+	}
+	glog.V(4).Infof("Container %s writable layer is limited to %d bytes", c.id, size)
+	return nil
+}
+
+// releaseWritableLayer clears project quota of container writable layer.
+func (c *Container) releaseWritableLayer() {
+	if c.writableQuota == nil || runtime.IsFake(c.cli) {
+		return
+	}
+	path := c.writableLayerPath()
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	if err := c.writableQuota.Release(path); err != nil {
+		glog.Warningf("Could not release container %s writable layer quota: %v", c.id, err)
+	}
+}
+
+// writableLayerUsage returns writable layer usage, project quota usage is
+// used whenever writable layer has one, otherwise its files are walked.
+func (c *Container) writableLayerUsage() (*fs.UsageInfo, error) {
+	path := c.writableLayerPath()
+	if c.writableQuota != nil {
+		usage, err := c.writableQuota.Usage(path)
+		if err == nil {
+			return usage, nil
+		}
+		if err != fs.ErrQuotaNotSet {
+			glog.V(4).Infof("Could not get container %s writable layer quota usage: %v", c.id, err)
+		}
+	}
+	return fs.Usage(path)
+}
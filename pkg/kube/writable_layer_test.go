@@ -0,0 +1,75 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestContainer_writableLayerLimit(t *testing.T) {
+	tt := []struct {
+		name        string
+		annotation  string
+		defaultSize int64
+		expectSize  int64
+		expectError bool
+	}{
+		{
+			name: "unlimited",
+		},
+		{
+			name:        "runtime default",
+			defaultSize: 1 << 30,
+			expectSize:  1 << 30,
+		},
+		{
+			name:        "annotation overrides default",
+			annotation:  " 2097152",
+			defaultSize: 1 << 30,
+			expectSize:  2 << 20,
+		},
+		{
+			name:        "too small",
+			annotation:  "1024",
+			expectError: true,
+		},
+		{
+			name:        "not a number",
+			annotation:  "1Gi",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &k8s.ContainerConfig{}
+			if tc.annotation != "" {
+				config.Annotations = map[string]string{AnnotationWritableLayerSize: tc.annotation}
+			}
+			_, err := ParseWritableLayerSize(config.Annotations)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			c := &Container{ContainerConfig: config}
+			WithWritableLayerLimit(tc.defaultSize, nil)(c)
+			require.Equal(t, tc.expectSize, c.writableLayerLimit())
+		})
+	}
+}
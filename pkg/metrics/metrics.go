@@ -0,0 +1,341 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics implements counters, gauges and histograms exposed
+// in Prometheus text format. Only the subset of the format sycri
+// needs is supported, so that no client library is required.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// DefaultBuckets are histogram buckets in seconds suitable for
+// request latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// PullBuckets are histogram buckets in seconds suitable for image pulls.
+var PullBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200}
+
+// Default is a registry served by Handler.
+var Default = NewRegistry()
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Registry holds registered metric families.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// NewCounter registers a new counter with passed label names.
+// It panics if metric with the same name is already registered.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, typeCounter, labels, nil)}
+}
+
+// NewGauge registers a new gauge with passed label names.
+// It panics if metric with the same name is already registered.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, typeGauge, labels, nil)}
+}
+
+// NewHistogram registers a new histogram with passed upper bounds of
+// buckets and label names. It panics if metric with the same name is
+// already registered or buckets are not sorted.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("buckets of %s are not sorted", name))
+	}
+	return &Histogram{r.register(name, help, typeHistogram, labels, buckets)}
+}
+
+func (r *Registry) register(name, help, typ string, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metric %s is already registered", name))
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// WriteTo writes all registered metrics in Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	families := make([]*family, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		families = append(families, r.families[name])
+	}
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.write(cw)
+	}
+	if err := cw.w.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+// Handler returns HTTP handler that serves metrics of the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := r.WriteTo(w); err != nil {
+			glog.Errorf("Could not write metrics: %v", err)
+		}
+	})
+}
+
+// NewCounter registers a new counter in the default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewGauge registers a new gauge in the default registry.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// NewHistogram registers a new histogram in the default registry.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// Handler returns HTTP handler that serves metrics of the default registry.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// Counter is a metric that only goes up.
+type Counter struct {
+	f *family
+}
+
+// Inc increments counter with passed label values by one.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increments counter with passed label values by v.
+// Negative values are ignored.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.update(values, func(s *series) { s.value += v })
+}
+
+// Gauge is a metric that can go up and down.
+type Gauge struct {
+	f *family
+}
+
+// Inc increments gauge with passed label values by one.
+func (g *Gauge) Inc(values ...string) {
+	g.Add(1, values...)
+}
+
+// Dec decrements gauge with passed label values by one.
+func (g *Gauge) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+// Add adds v to gauge with passed label values.
+func (g *Gauge) Add(v float64, values ...string) {
+	g.f.update(values, func(s *series) { s.value += v })
+}
+
+// Set sets gauge with passed label values to v.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.f.update(values, func(s *series) { s.value = v })
+}
+
+// Histogram counts observations in configured buckets.
+type Histogram struct {
+	f *family
+}
+
+// Observe adds a single observation to histogram with passed label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.f.update(values, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.f.buckets))
+		}
+		for i, upper := range h.f.buckets {
+			if v <= upper {
+				s.counts[i]++
+			}
+		}
+		s.value += v
+		s.count++
+	})
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	count  uint64
+	counts []uint64
+}
+
+func (f *family) update(values []string, fn func(s *series)) {
+	if len(values) != len(f.labels) {
+		glog.Errorf("Metric %s expects %d label values, got %d", f.name, len(f.labels), len(values))
+		return
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+func (f *family) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.typ != typeHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelPairs(s.values, ""), formatFloat(s.value))
+			continue
+		}
+		counts := s.counts
+		if counts == nil {
+			counts = make([]uint64, len(f.buckets))
+		}
+		for i, upper := range f.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelPairs(s.values, formatFloat(upper)), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelPairs(s.values, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, f.labelPairs(s.values, ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, f.labelPairs(s.values, ""), s.count)
+	}
+}
+
+// labelPairs formats label pairs of a single series. Non-empty le
+// is added as a bucket upper bound label.
+func (f *family) labelPairs(values []string, le string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, v := range values {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, f.labels[i], escapeLabel(v)))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	tt := []struct {
+		name   string
+		record func(r *Registry)
+		expect string
+	}{
+		{
+			name: "counter without labels",
+			record: func(r *Registry) {
+				c := r.NewCounter("test_total", "Test counter.")
+				c.Inc()
+				c.Add(2.5)
+				c.Add(-1)
+			},
+			expect: "# HELP test_total Test counter.\n" +
+				"# TYPE test_total counter\n" +
+				"test_total 3.5\n",
+		},
+		{
+			name: "gauge with labels",
+			record: func(r *Registry) {
+				g := r.NewGauge("test_sessions", "Test gauge.", "type")
+				g.Inc("exec")
+				g.Inc("exec")
+				g.Dec("exec")
+				g.Set(5, "attach")
+				g.Inc("bad", "label count")
+			},
+			expect: "# HELP test_sessions Test gauge.\n" +
+				"# TYPE test_sessions gauge\n" +
+				"test_sessions{type=\"attach\"} 5\n" +
+				"test_sessions{type=\"exec\"} 1\n",
+		},
+		{
+			name: "histogram",
+			record: func(r *Registry) {
+				h := r.NewHistogram("test_seconds", "Test histogram.", []float64{0.1, 1}, "method")
+				h.Observe(0.05, "/a")
+				h.Observe(0.5, "/a")
+				h.Observe(2, "/a")
+			},
+			expect: "# HELP test_seconds Test histogram.\n" +
+				"# TYPE test_seconds histogram\n" +
+				"test_seconds_bucket{method=\"/a\",le=\"0.1\"} 1\n" +
+				"test_seconds_bucket{method=\"/a\",le=\"1\"} 2\n" +
+				"test_seconds_bucket{method=\"/a\",le=\"+Inf\"} 3\n" +
+				"test_seconds_sum{method=\"/a\"} 2.55\n" +
+				"test_seconds_count{method=\"/a\"} 3\n",
+		},
+		{
+			name: "escaping and order",
+			record: func(r *Registry) {
+				r.NewCounter("test_b_total", "Second.\nline").Inc()
+				r.NewCounter("test_a_total", "First.", "value").Inc("quote \" slash \\")
+			},
+			expect: "# HELP test_a_total First.\n" +
+				"# TYPE test_a_total counter\n" +
+				"test_a_total{value=\"quote \\\" slash \\\\\"} 1\n" +
+				"# HELP test_b_total Second.\\nline\n" +
+				"# TYPE test_b_total counter\n" +
+				"test_b_total 1\n",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRegistry()
+			tc.record(r)
+			var buf bytes.Buffer
+			n, err := r.WriteTo(&buf)
+			require.NoError(t, err)
+			require.Equal(t, int64(buf.Len()), n)
+			require.Equal(t, tc.expect, buf.String())
+		})
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test counter.")
+	require.Panics(t, func() { r.NewGauge("test_total", "Duplicate.") })
+	require.Panics(t, func() { r.NewHistogram("test_seconds", "Unsorted.", []float64{1, 0.1}) })
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test counter.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, rec.Code)
+	require.Contains(t, rec.Header().Get("Content-Type"), "version=0.0.4")
+	require.Contains(t, rec.Body.String(), "test_total 1\n")
+}
@@ -15,114 +15,32 @@
 package namespace
 
 import (
+	"bufio"
 	"fmt"
-	"os"
-	"os/exec"
-
-	"github.com/opencontainers/runtime-spec/specs-go"
-	"golang.org/x/sys/unix"
-)
-
-type (
-	nsInfo struct {
-		cloneFlag int
-		procFile  string
-	}
-)
-
-var (
-	nsToInfo = map[specs.LinuxNamespaceType]nsInfo{
-		specs.PIDNamespace: {
-			cloneFlag: unix.CLONE_NEWPID,
-			procFile:  "pid",
-		},
-		specs.NetworkNamespace: {
-			cloneFlag: unix.CLONE_NEWNET,
-			procFile:  "net",
-		},
-		specs.MountNamespace: {
-			cloneFlag: unix.CLONE_NEWNS,
-			procFile:  "mnt",
-		},
-		specs.IPCNamespace: {
-			cloneFlag: unix.CLONE_NEWIPC,
-			procFile:  "ipc",
-		},
-		specs.UTSNamespace: {
-			cloneFlag: unix.CLONE_NEWUTS,
-			procFile:  "uts",
-		},
-		specs.UserNamespace: {
-			cloneFlag: unix.CLONE_NEWUSER,
-			procFile:  "user",
-		},
-	}
+	"io"
+	"strconv"
+	"strings"
 )
 
-// UnshareAll is used to create passed namespaces and save them
-// for the later use. After call to UnshareAll passed namespaces
-// can be found at LinuxNamespace.Path.
-func UnshareAll(namespaces []specs.LinuxNamespace) error {
-	if len(namespaces) == 0 {
-		return nil
-	}
-
-	var cloneFlags int
-	for _, ns := range namespaces {
-		cloneFlags |= nsToInfo[ns.Type].cloneFlag
-	}
-	cmd := exec.Command("/bin/sh")
-	cmd.SysProcAttr = &unix.SysProcAttr{
-		Cloneflags: uintptr(cloneFlags),
-	}
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("could not connect to stdin: %v", err)
-	}
-	defer stdin.Close()
-
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("could not start process: %v", err)
-	}
-	defer cmd.Wait()
-
-	for _, ns := range namespaces {
-		if err := Bind(cmd.Process.Pid, ns); err != nil {
-			return fmt.Errorf("could not bind namespace: %v", err)
+// ErrNotSupported is returned when namespaces are managed
+// on a platform that has no Linux namespaces.
+var ErrNotSupported = fmt.Errorf("namespaces are not supported on this platform")
+
+// parseNSpid returns pid of process in its innermost PID namespace
+// read from NSpid field of /proc/<pid>/status. Zero is returned when
+// kernel doesn't report NSpid, i.e. it is older than 4.1.
+func parseNSpid(status io.Reader) (int, error) {
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "NSpid:" {
+			continue
 		}
+		pid, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid NSpid %q: %v", scanner.Text(), err)
+		}
+		return pid, nil
 	}
-	stdin.Close()
-	return nil
-}
-
-// Remove unmounts and removes namespace file at ns.Path. Remove doesn't
-// return an error if namespace is not mounted or file doesn't exist.
-func Remove(ns specs.LinuxNamespace) error {
-	err := unix.Unmount(ns.Path, unix.MNT_DETACH)
-	if err != nil && err != unix.ENOENT && err != unix.EINVAL {
-		return fmt.Errorf("could not umount: %v", err)
-	}
-	err = os.Remove(ns.Path)
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("could not remove %s: %v", ns.Path, err)
-	}
-	return nil
-}
-
-// Bind creates namespace file at ns.Path and mounts corresponding
-// namespace of process with passed pid to it with unix.MS_BIND flag.
-func Bind(pid int, ns specs.LinuxNamespace) error {
-	f, err := os.Create(ns.Path)
-	if err != nil {
-		return fmt.Errorf("could not create %s: %v", ns.Path, err)
-	}
-	if err = f.Close(); err != nil {
-		return fmt.Errorf("could not close %s: %v", ns.Path, err)
-	}
-	source := fmt.Sprintf("/proc/%d/ns/%s", pid, nsToInfo[ns.Type].procFile)
-	err = unix.Mount(source, ns.Path, "", unix.MS_BIND, "")
-	if err != nil {
-		return fmt.Errorf("could not mount %s: %v", source, err)
-	}
-	return nil
+	return 0, scanner.Err()
 }
@@ -0,0 +1,182 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namespace
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+type (
+	nsInfo struct {
+		cloneFlag int
+		procFile  string
+	}
+)
+
+var (
+	nsToInfo = map[specs.LinuxNamespaceType]nsInfo{
+		specs.PIDNamespace: {
+			cloneFlag: unix.CLONE_NEWPID,
+			procFile:  "pid",
+		},
+		specs.NetworkNamespace: {
+			cloneFlag: unix.CLONE_NEWNET,
+			procFile:  "net",
+		},
+		specs.MountNamespace: {
+			cloneFlag: unix.CLONE_NEWNS,
+			procFile:  "mnt",
+		},
+		specs.IPCNamespace: {
+			cloneFlag: unix.CLONE_NEWIPC,
+			procFile:  "ipc",
+		},
+		specs.UTSNamespace: {
+			cloneFlag: unix.CLONE_NEWUTS,
+			procFile:  "uts",
+		},
+		specs.UserNamespace: {
+			cloneFlag: unix.CLONE_NEWUSER,
+			procFile:  "user",
+		},
+	}
+)
+
+// UnshareAll is used to create passed namespaces and save them
+// for the later use. After call to UnshareAll passed namespaces
+// can be found at LinuxNamespace.Path.
+func UnshareAll(namespaces []specs.LinuxNamespace) error {
+	if len(namespaces) == 0 {
+		return nil
+	}
+
+	var cloneFlags int
+	for _, ns := range namespaces {
+		cloneFlags |= nsToInfo[ns.Type].cloneFlag
+	}
+	cmd := exec.Command("/bin/sh")
+	cmd.SysProcAttr = &unix.SysProcAttr{
+		Cloneflags: uintptr(cloneFlags),
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("could not connect to stdin: %v", err)
+	}
+	defer stdin.Close()
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start process: %v", err)
+	}
+	defer cmd.Wait()
+
+	for _, ns := range namespaces {
+		if err := Bind(cmd.Process.Pid, ns); err != nil {
+			return fmt.Errorf("could not bind namespace: %v", err)
+		}
+	}
+	stdin.Close()
+	return nil
+}
+
+// Remove unmounts and removes namespace file at ns.Path. Namespace that
+// is busy is unmounted lazily. Remove doesn't return an error if namespace
+// is not mounted or file doesn't exist.
+func Remove(ns specs.LinuxNamespace) error {
+	err := unix.Unmount(ns.Path, 0)
+	if err == unix.EBUSY {
+		err = unix.Unmount(ns.Path, unix.MNT_DETACH)
+	}
+	if err != nil && err != unix.ENOENT && err != unix.EINVAL {
+		return fmt.Errorf("could not umount: %v", err)
+	}
+	err = os.Remove(ns.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove %s: %v", ns.Path, err)
+	}
+	return nil
+}
+
+// Bind creates namespace file at ns.Path and mounts corresponding
+// namespace of process with passed pid to it with unix.MS_BIND flag.
+func Bind(pid int, ns specs.LinuxNamespace) error {
+	f, err := os.Create(ns.Path)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %v", ns.Path, err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("could not close %s: %v", ns.Path, err)
+	}
+	source := fmt.Sprintf("/proc/%d/ns/%s", pid, nsToInfo[ns.Type].procFile)
+	err = unix.Mount(source, ns.Path, "", unix.MS_BIND, "")
+	if err != nil {
+		return fmt.Errorf("could not mount %s: %v", source, err)
+	}
+	return nil
+}
+
+// InnermostPid returns pid of process with the passed host pid in its own
+// PID namespace, e.g. 1 for init process of a PID namespace. Zero is returned
+// when kernel doesn't report it.
+func InnermostPid(pid int) (int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, fmt.Errorf("could not read process status: %v", err)
+	}
+	defer f.Close()
+	return parseNSpid(f)
+}
+
+// Dial connects to the address on the named network inside network namespace
+// at nsPath, e.g. /proc/<pid>/ns/net. Only the socket is created in that
+// namespace, so address should not require name resolution.
+func Dial(nsPath, network, address string) (net.Conn, error) {
+	var conn net.Conn
+	err := inNetNs(nsPath, func() error {
+		var err error
+		conn, err = net.Dial(network, address)
+		return err
+	})
+	return conn, err
+}
+
+// inNetNs calls fn on an OS thread that entered network namespace at nsPath.
+// Sockets fn creates belong to that namespace. The thread is locked and never
+// returned to the scheduler, so it is destroyed once fn returns.
+func inNetNs(nsPath string, fn func() error) error {
+	ns, err := os.Open(nsPath)
+	if err != nil {
+		return fmt.Errorf("could not open network namespace: %v", err)
+	}
+	defer ns.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			errs <- fmt.Errorf("could not enter network namespace: %v", err)
+			return
+		}
+		errs <- fn()
+	}()
+	return <-errs
+}
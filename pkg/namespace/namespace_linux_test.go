@@ -0,0 +1,116 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namespace
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestUnshareAll_Pinned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netns-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ns := specs.LinuxNamespace{
+		Type: specs.NetworkNamespace,
+		Path: filepath.Join(dir, "pod"),
+	}
+	err = UnshareAll([]specs.LinuxNamespace{ns})
+	if err != nil && os.Geteuid() != 0 {
+		t.Skipf("Namespaces cannot be unshared: %v", err)
+	}
+	require.NoError(t, err)
+	defer Remove(ns)
+
+	// process that created namespace is gone by now,
+	// pinned namespace must still be valid
+	var fs unix.Statfs_t
+	require.NoError(t, unix.Statfs(ns.Path, &fs))
+	require.EqualValues(t, unix.NSFS_MAGIC, fs.Type, "namespace must be pinned")
+
+	var pinned, host unix.Stat_t
+	require.NoError(t, unix.Stat(ns.Path, &pinned))
+	require.NoError(t, unix.Stat("/proc/self/ns/net", &host))
+	require.NotEqual(t, host.Ino, pinned.Ino, "pinned namespace must not be host one")
+
+	f, err := os.Open(ns.Path)
+	require.NoError(t, err)
+	f.Close()
+
+	require.NoError(t, Remove(ns))
+	_, err = os.Stat(ns.Path)
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, Remove(ns), "remove must be idempotent")
+}
+
+func TestDial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netns-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ns := specs.LinuxNamespace{
+		Type: specs.NetworkNamespace,
+		Path: filepath.Join(dir, "pod"),
+	}
+	err = UnshareAll([]specs.LinuxNamespace{ns})
+	if err != nil && os.Geteuid() != 0 {
+		t.Skipf("Namespaces cannot be unshared: %v", err)
+	}
+	require.NoError(t, err)
+	defer Remove(ns)
+	if out, err := exec.Command("nsenter", "--net="+ns.Path, "ip", "link", "set", "lo", "up").CombinedOutput(); err != nil {
+		t.Skipf("Could not bring loopback up: %v: %s", err, out)
+	}
+
+	// echo server is only reachable from inside the namespace
+	var ln net.Listener
+	require.NoError(t, inNetNs(ns.Path, func() error {
+		var err error
+		ln, err = net.Listen("tcp4", "127.0.0.1:0")
+		return err
+	}))
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	conn, err := Dial(ns.Path, "tcp4", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	_, err = Dial(filepath.Join(dir, "missing"), "tcp4", ln.Addr().String())
+	require.Error(t, err)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namespace
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNSpid(t *testing.T) {
+	tt := []struct {
+		name      string
+		status    string
+		expectPid int
+		expectErr bool
+	}{
+		{
+			name:      "host namespace",
+			status:    "Name:\tsleep\nPid:\t4242\nNSpid:\t4242\nPPid:\t1\n",
+			expectPid: 4242,
+		},
+		{
+			name:      "init of nested namespace",
+			status:    "Name:\tsinit\nPid:\t4242\nNSpid:\t4242\t1\nPPid:\t4200\n",
+			expectPid: 1,
+		},
+		{
+			name:      "old kernel",
+			status:    "Name:\tsleep\nPid:\t4242\nPPid:\t1\n",
+			expectPid: 0,
+		},
+		{
+			name:      "invalid value",
+			status:    "NSpid:\t4242\tfoo\n",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			pid, err := parseNSpid(strings.NewReader(tc.status))
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectPid, pid)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux
+
+package namespace
+
+import (
+	"net"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// UnshareAll returns ErrNotSupported.
+func UnshareAll(namespaces []specs.LinuxNamespace) error {
+	if len(namespaces) == 0 {
+		return nil
+	}
+	return ErrNotSupported
+}
+
+// Remove returns ErrNotSupported.
+func Remove(ns specs.LinuxNamespace) error {
+	return ErrNotSupported
+}
+
+// Bind returns ErrNotSupported.
+func Bind(pid int, ns specs.LinuxNamespace) error {
+	return ErrNotSupported
+}
+
+// InnermostPid returns ErrNotSupported.
+func InnermostPid(pid int) (int, error) {
+	return 0, ErrNotSupported
+}
+
+// Dial returns ErrNotSupported.
+func Dial(nsPath, network, address string) (net.Conn, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultHostLocalDataDir is a directory host-local IPAM plugin
+// keeps allocated addresses in unless dataDir is configured.
+const DefaultHostLocalDataDir = "/var/lib/cni/networks"
+
+// Allocation is an address reserved by IPAM plugin for a container.
+type Allocation struct {
+	Network     string `json:"network"`
+	IP          string `json:"ip"`
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifName,omitempty"`
+}
+
+// ipamConfig is a part of CNI plugin configuration that describes IPAM.
+type ipamConfig struct {
+	IPAM struct {
+		Type    string `json:"type"`
+		DataDir string `json:"dataDir"`
+	} `json:"ipam"`
+}
+
+// hostLocalDir returns directory host-local IPAM stores allocations of
+// network in, according to plugin configuration. False is returned when
+// plugin does not use host-local IPAM.
+func hostLocalDir(network string, plugin []byte) (string, bool) {
+	var conf ipamConfig
+	if err := json.Unmarshal(plugin, &conf); err != nil || conf.IPAM.Type != "host-local" {
+		return "", false
+	}
+	dataDir := conf.IPAM.DataDir
+	if dataDir == "" {
+		dataDir = DefaultHostLocalDataDir
+	}
+	return filepath.Join(dataDir, network), true
+}
+
+// hostLocalAllocations reads addresses reserved by host-local IPAM in dir.
+// Each reservation is a file named after the address that holds container ID
+// and, since CNI plugins v0.8.0, interface name on the second line.
+func hostLocalAllocations(network, dir string) ([]Allocation, error) {
+	fii, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read IPAM store: %v", err)
+	}
+
+	var allocs []Allocation
+	for _, fi := range fii {
+		// skips lock and last_reserved_ip.N files
+		if !fi.Mode().IsRegular() || net.ParseIP(fi.Name()) == nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if os.IsNotExist(err) {
+			// released concurrently
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read IPAM reservation: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		alloc := Allocation{
+			Network:     network,
+			IP:          fi.Name(),
+			ContainerID: strings.TrimSpace(lines[0]),
+		}
+		if len(lines) > 1 {
+			alloc.IfName = strings.TrimSpace(lines[1])
+		}
+		if alloc.ContainerID == "" {
+			continue
+		}
+		allocs = append(allocs, alloc)
+	}
+	return allocs, nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostLocalDir(t *testing.T) {
+	tt := []struct {
+		name      string
+		plugin    string
+		expectDir string
+		expectOK  bool
+	}{
+		{
+			name:      "default data dir",
+			plugin:    `{"type":"bridge","ipam":{"type":"host-local"}}`,
+			expectDir: "/var/lib/cni/networks/test",
+			expectOK:  true,
+		},
+		{
+			name:      "custom data dir",
+			plugin:    `{"type":"bridge","ipam":{"type":"host-local","dataDir":"/run/ipam"}}`,
+			expectDir: "/run/ipam/test",
+			expectOK:  true,
+		},
+		{
+			name:   "other ipam",
+			plugin: `{"type":"bridge","ipam":{"type":"whereabouts"}}`,
+		},
+		{
+			name:   "no ipam",
+			plugin: `{"type":"portmap"}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, ok := hostLocalDir("test", []byte(tc.plugin))
+			require.Equal(t, tc.expectOK, ok)
+			require.Equal(t, tc.expectDir, dir)
+		})
+	}
+}
+
+func TestHostLocalAllocations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipam-test-")
+	require.NoError(t, err, "could not create temp directory")
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"10.22.0.2":          "pod1",
+		"10.22.0.3":          "pod2\r\neth1",
+		"fd00:10:22::3":      "pod2\r\neth1",
+		"last_reserved_ip.0": "10.22.0.3",
+		"lock":               "",
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	allocs, err := hostLocalAllocations("test", dir)
+	require.NoError(t, err)
+	require.Equal(t, []Allocation{
+		{Network: "test", IP: "10.22.0.2", ContainerID: "pod1"},
+		{Network: "test", IP: "10.22.0.3", ContainerID: "pod2", IfName: "eth1"},
+		{Network: "test", IP: "fd00:10:22::3", ContainerID: "pod2", IfName: "eth1"},
+	}, allocs)
+
+	allocs, err = hostLocalAllocations("test", filepath.Join(dir, "not-found"))
+	require.NoError(t, err, "missing store means no allocations")
+	require.Empty(t, allocs)
+}
@@ -16,13 +16,13 @@ package network
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
-	"sync"
+	"text/template"
 
-	"github.com/containernetworking/cni/libcni"
-	"github.com/golang/glog"
-	snetwork "github.com/sylabs/singularity/pkg/network"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
@@ -31,16 +31,20 @@ const (
 	CNIBinDir = "/opt/cni/bin"
 	// CNIConfDir is the default path to CNI network configuration files.
 	CNIConfDir = "/etc/cni/net.d"
+
+	// templatedConfName is a name of CNI network configuration file rendered
+	// from configuration template once pod CIDR is known.
+	templatedConfName = "10-sycri-net.conflist"
 )
 
-// Manager contains network manager configuration and exposes
-// methods to bring up and down network interface.
-type Manager struct {
-	sync.RWMutex
-	loNetwork      *libcni.NetworkConfigList
-	defaultNetwork *libcni.NetworkConfigList
-	cniPath        *snetwork.CNIPath
-	podCIDR        string
+// ErrNotSupported is returned by network manager on platforms
+// pod networking is not implemented for.
+var ErrNotSupported = fmt.Errorf("pod networking is not supported on this platform")
+
+// CNIPath holds paths to CNI plugin binaries and network configuration files.
+type CNIPath struct {
+	Plugin string
+	Conf   string
 }
 
 // PodConfig contains/defines pod network configuration.
@@ -50,209 +54,95 @@ type PodConfig struct {
 	Name         string
 	NsPath       string
 	PortMappings []*k8s.PortMapping
+	// Networks are names of CNI networks pod is attached to
+	// after the default one, in order.
+	Networks []string
 }
 
-// PodNetwork represents set up pod's network. It is a caller's responsibility
-// to tear this network down by calling Manager.TearDownPod during pod's shutdown.
-// PodNetwork is also used to retrieve pod's IP address.
-type PodNetwork struct {
-	setup          *snetwork.Setup
-	defaultNetwork string
+// Attachment describes pod interface attached to a CNI network.
+type Attachment struct {
+	Network   string   `json:"network"`
+	Interface string   `json:"interface"`
+	IPs       []string `json:"ips,omitempty"`
 }
 
-// Init initializes CNI network manager.
-func (m *Manager) Init(cniPath *snetwork.CNIPath) error {
-	if m.cniPath != nil {
-		return nil
-	}
-	if cniPath == nil {
-		m.cniPath = &snetwork.CNIPath{
-			Plugin: CNIBinDir,
-			Conf:   CNIConfDir,
-		}
-	} else {
-		m.cniPath = cniPath
-	}
-
-	return m.setDefaultNetwork()
+// confTemplateData is passed to CNI network configuration template.
+type confTemplateData struct {
+	// PodCIDR is the first pod CIDR, kept for single-stack templates.
+	PodCIDR string
+	// PodCIDRRanges holds all pod CIDRs, e.g. both IPv4 and IPv6 for dual-stack.
+	PodCIDRRanges []string
 }
 
-// checkInit updates CNI network configuration and does some sanity checks.
-func (m *Manager) checkInit() error {
-	if err := m.setDefaultNetwork(); err != nil {
-		return err
-	}
-
-	m.Lock()
-	defer m.Unlock()
+// ipGetter is a part of network setup pod IPs are fetched from.
+type ipGetter interface {
+	GetNetworkIP(network string, version string) (net.IP, error)
+}
 
-	ipRanges := false
-	for _, plugin := range m.defaultNetwork.Plugins {
-		if plugin.Network.Capabilities["ipRanges"] {
-			if m.podCIDR == "" {
-				return fmt.Errorf("no PodCIDR set")
-			}
-			ipRanges = true
-			break
+// parsePodCIDRs parses comma separated list of CIDRs.
+func parsePodCIDRs(cidr string) ([]string, error) {
+	var cidrs []string
+	for _, c := range strings.Split(cidr, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
 		}
+		if _, _, err := net.ParseCIDR(c); err != nil {
+			return nil, fmt.Errorf("invalid pod CIDR %q: %v", c, err)
+		}
+		cidrs = append(cidrs, c)
 	}
-	if !ipRanges && m.podCIDR != "" {
-		glog.V(1).Infof("Resetting pod CIDR, network plugin doesn't support it")
-		m.podCIDR = ""
+	if len(cidrs) == 0 {
+		return nil, fmt.Errorf("empty pod CIDR")
 	}
-	return nil
+	return cidrs, nil
 }
 
-func (m *Manager) setDefaultNetwork() error {
-	m.Lock()
-	defer m.Unlock()
-
-	if m.defaultNetwork != nil {
-		return nil
-	}
-	netConfList, err := snetwork.GetAllNetworkConfigList(m.cniPath)
+// renderConfTemplate renders CNI network configuration template
+// into confDir with pod CIDRs substituted.
+func renderConfTemplate(tmplPath, confDir string, cidrs []string) error {
+	tmpl, err := template.ParseFiles(tmplPath)
 	if err != nil {
-		return fmt.Errorf("could not get networks: %v", err)
+		return fmt.Errorf("could not parse template: %v", err)
 	}
-	if len(netConfList) == 0 {
-		return fmt.Errorf("no CNI network configuration found in %s", m.cniPath.Conf)
+	if err := os.MkdirAll(confDir, 0755); err != nil {
+		return fmt.Errorf("could not create CNI config directory: %v", err)
 	}
-	m.defaultNetwork = netConfList[0]
-	glog.V(1).Infof("Network configuration found: %s", m.defaultNetwork.Name)
-
-	for _, p := range m.defaultNetwork.Plugins {
-		if p.Network.Type == "loopback" {
-			return nil
-		}
-	}
-
-	glog.V(1).Infof("%s does not set up loopback interface, adding additional config", m.defaultNetwork.Name)
-	m.loNetwork, _ = libcni.ConfListFromBytes([]byte(`
-{
-	"cniVersion": "0.3.1",
-	"name": "sycri-loopback",
-	"plugins": [{
-        "type": "loopback"
-	}]
-}`))
-
-	return nil
-}
-
-// SetUpPod bring up pod's network interface.
-func (m *Manager) SetUpPod(podConfig *PodConfig) (*PodNetwork, error) {
-	err := m.checkInit()
+	f, err := ioutil.TempFile(confDir, ".sycri-net-")
 	if err != nil {
-		return nil, err
-	}
-	if podConfig == nil {
-		return nil, fmt.Errorf("nil POD configuration")
-	}
-	if podConfig.ID == "" {
-		return nil, fmt.Errorf("empty ID")
-	}
-	if podConfig.NsPath == "" {
-		return nil, fmt.Errorf("empty network namespace path")
-	}
-	if podConfig.Name == "" {
-		return nil, fmt.Errorf("empty POD name")
-	}
-	if podConfig.Namespace == "" {
-		return nil, fmt.Errorf("empty POD namespace name")
+		return fmt.Errorf("could not create temporary file: %v", err)
 	}
+	defer os.Remove(f.Name())
 
-	var cfg []*libcni.NetworkConfigList
-	// add loopback interface if default network doesn't have one
-	if m.loNetwork != nil {
-		cfg = append(cfg, m.loNetwork)
-	}
-	cfg = append(cfg, m.defaultNetwork)
-	setup, err := snetwork.NewSetupFromConfig(cfg, podConfig.ID, podConfig.NsPath, m.cniPath)
+	err = tmpl.Execute(f, confTemplateData{
+		PodCIDR:       cidrs[0],
+		PodCIDRRanges: cidrs,
+	})
+	f.Close()
 	if err != nil {
-		return nil, err
-	}
-
-	args := fmt.Sprintf("%s:", m.defaultNetwork.Name)
-	for i, kv := range [][2]string{
-		{"IgnoreUnknown", "1"},
-		{"K8S_POD_NAMESPACE", podConfig.Namespace},
-		{"K8S_POD_NAME", podConfig.Name},
-		{"K8S_POD_INFRA_CONTAINER_ID", podConfig.ID},
-	} {
-		if i > 0 {
-			args += ";"
-		}
-		args += fmt.Sprintf("%s=%s", kv[0], kv[1])
-	}
-	if m.podCIDR != "" {
-		args += fmt.Sprintf(";ipRange=%s", m.podCIDR)
-	}
-	if podConfig.PortMappings != nil {
-		for _, pm := range podConfig.PortMappings {
-			hostPort := pm.HostPort
-			if hostPort == 0 {
-				hostPort = pm.ContainerPort
-			}
-			err := setup.SetCapability(m.defaultNetwork.Name, "portMappings", snetwork.PortMapEntry{
-				HostPort:      int(hostPort),
-				ContainerPort: int(pm.ContainerPort),
-				Protocol:      strings.ToLower(pm.Protocol.String()),
-			})
-			if err != nil {
-				glog.Warningf("Skipping port mapping due to error: %v", err)
-			}
-		}
-	}
-	glog.V(3).Infof("Network for pod %s args: %s", podConfig.ID, args)
-	if err := setup.SetArgs([]string{args}); err != nil {
-		return nil, err
+		return fmt.Errorf("could not execute template: %v", err)
 	}
-	if err := setup.AddNetworks(); err != nil {
-		return nil, err
-	}
-	return &PodNetwork{
-		setup:          setup,
-		defaultNetwork: m.defaultNetwork.Name,
-	}, nil
-}
-
-// TearDownPod tears down pod's network interface.
-func (m *Manager) TearDownPod(podNetwork *PodNetwork) error {
-	if err := m.checkInit(); err != nil {
-		return err
-	}
-	if podNetwork.setup == nil {
-		return fmt.Errorf("nil network setup")
-	}
-	return podNetwork.setup.DelNetworks()
+	return os.Rename(f.Name(), filepath.Join(confDir, templatedConfName))
 }
 
-// Status returns an error if the network manager is not initialized.
-func (m *Manager) Status() error {
-	return m.checkInit()
-}
-
-// SetPodCIDR updates pod's CIDR.
-func (m *Manager) SetPodCIDR(cidr string) {
-	m.Lock()
-	if m.podCIDR == "" {
-		m.podCIDR = cidr
+func getIPs(setup ipGetter, network string, ipv6First bool) ([]net.IP, error) {
+	versions := []string{"4", "6"}
+	if ipv6First {
+		versions = []string{"6", "4"}
 	}
-	m.Unlock()
-	m.checkInit()
-}
 
-// GetIP returns pod's IP address. It first tries to fetch IPv4
-// and in case of errors will try to fetch IPv6.
-func (n *PodNetwork) GetIP() (net.IP, error) {
-	netIP, err := n.setup.GetNetworkIP(n.defaultNetwork, "4")
-	if err == nil {
-		return netIP, nil
+	var ips []net.IP
+	var lastErr error
+	for _, version := range versions {
+		ip, err := setup.GetNetworkIP(network, version)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ips = append(ips, ip)
 	}
-
-	netIP, err = n.setup.GetNetworkIP(n.defaultNetwork, "6")
-	if err == nil {
-		return netIP, nil
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("could not get pod's IP: %v", lastErr)
 	}
-	return nil, fmt.Errorf("could not get pod's IP: %v", err)
+	return ips, nil
 }
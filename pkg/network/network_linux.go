@@ -0,0 +1,474 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/golang/glog"
+	snetwork "github.com/sylabs/singularity/pkg/network"
+)
+
+// cniTimeout limits CNI plugin execution the same way network setup does.
+const cniTimeout = 5 * time.Second
+
+// Manager contains network manager configuration and exposes
+// methods to bring up and down network interface.
+type Manager struct {
+	sync.RWMutex
+	loNetwork      *libcni.NetworkConfigList
+	defaultNetwork *libcni.NetworkConfigList
+	cniPath        *snetwork.CNIPath
+	confTemplate   string
+	podCIDRs       []string
+}
+
+// PodNetwork represents set up pod's network. It is a caller's responsibility
+// to tear this network down by calling Manager.TearDownPod during pod's shutdown.
+// PodNetwork is also used to retrieve pod's IP address.
+type PodNetwork struct {
+	setup          *snetwork.Setup
+	defaultNetwork string
+	// networks are names of all attached networks, default one first
+	networks []string
+	// ipv6First is set when primary pod CIDR is IPv6
+	ipv6First bool
+}
+
+// Init initializes CNI network manager. When confTemplate is not empty
+// CNI network configuration will be rendered from that template into
+// CNI configuration directory once pod CIDR is set.
+func (m *Manager) Init(cniPath *CNIPath, confTemplate string) error {
+	if m.cniPath != nil {
+		return nil
+	}
+	if cniPath == nil {
+		m.cniPath = &snetwork.CNIPath{
+			Plugin: CNIBinDir,
+			Conf:   CNIConfDir,
+		}
+	} else {
+		m.cniPath = &snetwork.CNIPath{
+			Plugin: cniPath.Plugin,
+			Conf:   cniPath.Conf,
+		}
+	}
+	m.confTemplate = confTemplate
+	if m.confTemplate != "" {
+		glog.V(1).Infof("Waiting for pod CIDR to render %s", m.confTemplate)
+		return nil
+	}
+	m.Lock()
+	defer m.Unlock()
+	return m.loadDefaultNetwork()
+}
+
+// checkInit updates CNI network configuration and does some sanity checks.
+func (m *Manager) checkInit() error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.confTemplate != "" && len(m.podCIDRs) == 0 {
+		return fmt.Errorf("no pod CIDR set to render %s", m.confTemplate)
+	}
+	if err := m.loadDefaultNetwork(); err != nil {
+		return err
+	}
+	if m.needsIPRanges() && len(m.podCIDRs) == 0 {
+		return fmt.Errorf("no pod CIDR set")
+	}
+	return nil
+}
+
+// needsIPRanges checks whether default network expects pod CIDR
+// to be passed by runtime. Caller must hold m's lock.
+func (m *Manager) needsIPRanges() bool {
+	for _, plugin := range m.defaultNetwork.Plugins {
+		if plugin.Network.Capabilities["ipRanges"] {
+			return true
+		}
+	}
+	return false
+}
+
+// loadDefaultNetwork loads default network configuration if
+// it is not loaded yet. Caller must hold m's lock.
+func (m *Manager) loadDefaultNetwork() error {
+	if m.defaultNetwork != nil {
+		return nil
+	}
+	netConfList, err := snetwork.GetAllNetworkConfigList(m.cniPath)
+	if err != nil {
+		return fmt.Errorf("could not get networks: %v", err)
+	}
+	if len(netConfList) == 0 {
+		return fmt.Errorf("no CNI network configuration found in %s", m.cniPath.Conf)
+	}
+	m.defaultNetwork = netConfList[0]
+	glog.V(1).Infof("Network configuration found: %s", m.defaultNetwork.Name)
+
+	for _, p := range m.defaultNetwork.Plugins {
+		if p.Network.Type == "loopback" {
+			return nil
+		}
+	}
+
+	glog.V(1).Infof("%s does not set up loopback interface, adding additional config", m.defaultNetwork.Name)
+	m.loNetwork, _ = libcni.ConfListFromBytes([]byte(`
+{
+	"cniVersion": "0.3.1",
+	"name": "sycri-loopback",
+	"plugins": [{
+        "type": "loopback"
+	}]
+}`))
+
+	return nil
+}
+
+// SetUpPod bring up pod's network interface. CNI plugins cannot be
+// interrupted, so when ctx is done before they finish SetUpPod returns
+// immediately and network is torn down in background once set up.
+func (m *Manager) SetUpPod(ctx context.Context, podConfig *PodConfig) (*PodNetwork, error) {
+	err := m.checkInit()
+	if err != nil {
+		return nil, err
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	podNetwork, err := m.podNetwork(podConfig)
+	if err != nil {
+		return nil, err
+	}
+	setup := podNetwork.setup
+
+	done := make(chan error, 1)
+	go func() {
+		done <- setup.AddNetworks()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+		return podNetwork, nil
+	case <-ctx.Done():
+		go func() {
+			if err := <-done; err != nil {
+				return
+			}
+			glog.V(3).Infof("Tearing down network for cancelled pod %s", podConfig.ID)
+			if err := setup.DelNetworks(); err != nil {
+				glog.Errorf("Could not tear down network for cancelled pod %s: %v", podConfig.ID, err)
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// RestorePod returns network of pod that was set up by previous CRI
+// instance so that it can be torn down. CNI plugins are not called, so
+// restored network reports neither interfaces nor IP addresses.
+func (m *Manager) RestorePod(podConfig *PodConfig) (*PodNetwork, error) {
+	if err := m.checkInit(); err != nil {
+		return nil, err
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.podNetwork(podConfig)
+}
+
+// podNetwork prepares CNI setup of pod's networks without
+// calling any plugin. Caller must hold m's lock.
+func (m *Manager) podNetwork(podConfig *PodConfig) (*PodNetwork, error) {
+	if podConfig == nil {
+		return nil, fmt.Errorf("nil POD configuration")
+	}
+	if podConfig.ID == "" {
+		return nil, fmt.Errorf("empty ID")
+	}
+	if podConfig.NsPath == "" {
+		return nil, fmt.Errorf("empty network namespace path")
+	}
+	if podConfig.Name == "" {
+		return nil, fmt.Errorf("empty POD name")
+	}
+	if podConfig.Namespace == "" {
+		return nil, fmt.Errorf("empty POD namespace name")
+	}
+
+	if m.defaultNetwork == nil {
+		return nil, fmt.Errorf("network configuration is not loaded")
+	}
+
+	var cfg []*libcni.NetworkConfigList
+	// add loopback interface if default network doesn't have one
+	if m.loNetwork != nil {
+		cfg = append(cfg, m.loNetwork)
+	}
+	cfg = append(cfg, m.defaultNetwork)
+	extra, err := m.loadNetworks(podConfig.Networks)
+	if err != nil {
+		return nil, err
+	}
+	cfg = append(cfg, extra...)
+	setup, err := snetwork.NewSetupFromConfig(cfg, podConfig.ID, podConfig.NsPath, m.cniPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var podArgs string
+	for i, kv := range [][2]string{
+		{"IgnoreUnknown", "1"},
+		{"K8S_POD_NAMESPACE", podConfig.Namespace},
+		{"K8S_POD_NAME", podConfig.Name},
+		{"K8S_POD_INFRA_CONTAINER_ID", podConfig.ID},
+	} {
+		if i > 0 {
+			podArgs += ";"
+		}
+		podArgs += fmt.Sprintf("%s=%s", kv[0], kv[1])
+	}
+	args := fmt.Sprintf("%s:%s", m.defaultNetwork.Name, podArgs)
+	if m.needsIPRanges() {
+		// network setup supports a single range set only, so dual-stack
+		// setups should rely on configuration template instead
+		args += fmt.Sprintf(";ipRange=%s", m.podCIDRs[0])
+	}
+	allArgs := []string{args}
+	networks := []string{m.defaultNetwork.Name}
+	for _, conf := range extra {
+		allArgs = append(allArgs, fmt.Sprintf("%s:%s", conf.Name, podArgs))
+		networks = append(networks, conf.Name)
+	}
+	if podConfig.PortMappings != nil {
+		for _, pm := range podConfig.PortMappings {
+			hostPort := pm.HostPort
+			if hostPort == 0 {
+				hostPort = pm.ContainerPort
+			}
+			err := setup.SetCapability(m.defaultNetwork.Name, "portMappings", snetwork.PortMapEntry{
+				HostPort:      int(hostPort),
+				ContainerPort: int(pm.ContainerPort),
+				Protocol:      strings.ToLower(pm.Protocol.String()),
+				HostIP:        pm.HostIp,
+			})
+			if err != nil {
+				glog.Warningf("Skipping port mapping due to error: %v", err)
+			}
+		}
+	}
+	glog.V(3).Infof("Network for pod %s args: %v", podConfig.ID, allArgs)
+	if err := setup.SetArgs(allArgs); err != nil {
+		return nil, err
+	}
+	return &PodNetwork{
+		setup:          setup,
+		defaultNetwork: m.defaultNetwork.Name,
+		networks:       networks,
+		ipv6First:      len(m.podCIDRs) > 0 && strings.Contains(m.podCIDRs[0], ":"),
+	}, nil
+}
+
+// loadNetworks loads configuration of the passed additional pod networks
+// from CNI configuration directory. Caller must hold m's lock.
+func (m *Manager) loadNetworks(names []string) ([]*libcni.NetworkConfigList, error) {
+	var confs []*libcni.NetworkConfigList
+	for _, name := range names {
+		if name == m.defaultNetwork.Name {
+			return nil, fmt.Errorf("network %s is the default pod network", name)
+		}
+		conf, err := libcni.LoadConfList(m.cniPath.Conf, name)
+		if err != nil {
+			return nil, fmt.Errorf("could not load network %s: %v", name, err)
+		}
+		confs = append(confs, conf)
+	}
+	return confs, nil
+}
+
+// TearDownPod tears down pod's network interface.
+func (m *Manager) TearDownPod(podNetwork *PodNetwork) error {
+	if err := m.checkInit(); err != nil {
+		return err
+	}
+	if podNetwork.setup == nil {
+		return fmt.Errorf("nil network setup")
+	}
+	return podNetwork.setup.DelNetworks()
+}
+
+// Status returns an error if the network manager is not initialized.
+func (m *Manager) Status() error {
+	return m.checkInit()
+}
+
+// SetPodCIDR updates pod's CIDR. Comma separated list of CIDRs is accepted
+// to support dual-stack networking. When pod CIDR changes and configuration
+// template is set, CNI network configuration is regenerated. Pods that were
+// set up earlier keep their network allocations.
+func (m *Manager) SetPodCIDR(cidr string) error {
+	cidrs, err := parsePodCIDRs(cidr)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if strings.Join(cidrs, ",") == strings.Join(m.podCIDRs, ",") {
+		return nil
+	}
+	glog.V(1).Infof("Pod CIDR changed from %v to %v", m.podCIDRs, cidrs)
+	m.podCIDRs = cidrs
+	if m.confTemplate == "" {
+		return nil
+	}
+	if err := renderConfTemplate(m.confTemplate, m.cniPath.Conf, cidrs); err != nil {
+		return fmt.Errorf("could not render CNI config template: %v", err)
+	}
+	m.defaultNetwork = nil
+	m.loNetwork = nil
+	return m.loadDefaultNetwork()
+}
+
+// GetIP returns pod's primary IP address, see GetIPs.
+func (n *PodNetwork) GetIP() (net.IP, error) {
+	ips, err := n.GetIPs()
+	if err != nil {
+		return nil, err
+	}
+	return ips[0], nil
+}
+
+// GetIPs returns all pod's IP addresses, one per IP family. Address of
+// the primary pod CIDR family goes first, IPv4 is primary by default.
+func (n *PodNetwork) GetIPs() ([]net.IP, error) {
+	return getIPs(n.setup, n.defaultNetwork, n.ipv6First)
+}
+
+// Attachments returns interfaces of all networks pod is attached to,
+// default network goes first, others follow in order of attachment.
+func (n *PodNetwork) Attachments() []Attachment {
+	attachments := make([]Attachment, 0, len(n.networks))
+	for _, name := range n.networks {
+		attachment := Attachment{Network: name}
+		attachment.Interface, _ = n.setup.GetNetworkInterface(name)
+		ips, err := getIPs(n.setup, name, n.ipv6First)
+		if err != nil {
+			glog.V(4).Infof("No IPs assigned on network %s: %v", name, err)
+		}
+		for _, ip := range ips {
+			attachment.IPs = append(attachment.IPs, ip.String())
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+// ReclaimIPAM releases addresses host-local IPAM keeps for containers
+// live reports as gone with a CNI DEL of the default network, as if pod
+// was torn down. Default network is only touched when it is listed in
+// networks, since IPAM state of other setups is not ours to clean.
+// When dryRun is set leaked addresses are only reported.
+func (m *Manager) ReclaimIPAM(networks []string, live func(id string) bool, dryRun bool) ([]Allocation, error) {
+	if err := m.checkInit(); err != nil {
+		return nil, err
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	name := m.defaultNetwork.Name
+	if !contains(networks, name) {
+		return nil, nil
+	}
+
+	var leaked []Allocation
+	for _, plugin := range m.defaultNetwork.Plugins {
+		dir, ok := hostLocalDir(name, plugin.Bytes)
+		if !ok {
+			continue
+		}
+		allocs, err := hostLocalAllocations(name, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, alloc := range allocs {
+			if !live(alloc.ContainerID) {
+				leaked = append(leaked, alloc)
+			}
+		}
+	}
+	if dryRun {
+		return leaked, nil
+	}
+
+	// default network interface name is set the same way as in SetUpPod
+	ifName := "eth0"
+	if m.loNetwork != nil {
+		ifName = "eth1"
+	}
+	var released []Allocation
+	deleted := make(map[string]error)
+	config := &libcni.CNIConfig{Path: []string{m.cniPath.Plugin}}
+	for _, alloc := range leaked {
+		if alloc.IfName == "" {
+			alloc.IfName = ifName
+		}
+		key := alloc.ContainerID + "/" + alloc.IfName
+		err, ok := deleted[key]
+		if !ok {
+			glog.V(2).Infof("Releasing %s address %s leaked by pod %s", name, alloc.IP, alloc.ContainerID)
+			ctx, cancel := context.WithTimeout(context.Background(), cniTimeout)
+			err = config.DelNetworkList(ctx, m.defaultNetwork, &libcni.RuntimeConf{
+				ContainerID: alloc.ContainerID,
+				IfName:      alloc.IfName,
+				Args: [][2]string{
+					{"IgnoreUnknown", "1"},
+					{"K8S_POD_INFRA_CONTAINER_ID", alloc.ContainerID},
+				},
+			})
+			cancel()
+			deleted[key] = err
+			if err != nil {
+				glog.Errorf("Could not release %s address %s of pod %s: %v", name, alloc.IP, alloc.ContainerID, err)
+			}
+		}
+		if err == nil {
+			released = append(released, alloc)
+		}
+	}
+	return released, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_SetPodCIDR(t *testing.T) {
+	dir, err := ioutil.TempDir("", "network-test-")
+	require.NoError(t, err, "could not create temp directory")
+	defer os.RemoveAll(dir)
+
+	tmplPath := filepath.Join(dir, "net.tmpl")
+	confDir := filepath.Join(dir, "net.d")
+	err = ioutil.WriteFile(tmplPath, []byte(`{
+	"cniVersion": "0.3.1",
+	"name": "test",
+	"plugins": [{
+		"type": "bridge",
+		"ipam": {
+			"type": "host-local",
+			"ranges": [{{range $i, $r := .PodCIDRRanges}}{{if $i}},{{end}}[{"subnet": "{{$r}}"}]{{end}}]
+		}
+	}]
+}`), 0644)
+	require.NoError(t, err, "could not write template")
+
+	var m Manager
+	err = m.Init(&CNIPath{Conf: confDir, Plugin: dir}, tmplPath)
+	require.NoError(t, err, "could not init manager")
+	require.Error(t, m.Status(), "network must not be ready without pod CIDR")
+
+	tt := []struct {
+		cidr   string
+		expect string
+	}{
+		{
+			cidr:   "10.22.0.0/16",
+			expect: `[[{"subnet": "10.22.0.0/16"}]]`,
+		},
+		{
+			cidr:   "10.22.0.0/16,fd00:10:22::/64",
+			expect: `[[{"subnet": "10.22.0.0/16"}],[{"subnet": "fd00:10:22::/64"}]]`,
+		},
+	}
+	for _, tc := range tt {
+		require.NoError(t, m.SetPodCIDR(tc.cidr), "could not set pod CIDR")
+		require.NoError(t, m.Status(), "network must be ready")
+		conf, err := ioutil.ReadFile(filepath.Join(confDir, templatedConfName))
+		require.NoError(t, err, "could not read rendered config")
+		require.Contains(t, string(conf), tc.expect)
+	}
+}
+
+func TestManager_ReclaimIPAM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "network-test-")
+	require.NoError(t, err, "could not create temp directory")
+	defer os.RemoveAll(dir)
+
+	binDir := filepath.Join(dir, "bin")
+	confDir := filepath.Join(dir, "net.d")
+	dataDir := filepath.Join(dir, "ipam")
+	calls := filepath.Join(dir, "calls")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	require.NoError(t, os.MkdirAll(confDir, 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dataDir, "test"), 0755))
+
+	// fake plugin records calls and releases addresses the way host-local does
+	plugin := `#!/bin/sh
+echo "$CNI_COMMAND $CNI_CONTAINERID $CNI_IFNAME $CNI_NETNS" >> ` + calls + `
+grep -lx "$CNI_CONTAINERID" ` + filepath.Join(dataDir, "test") + `/* | xargs -r rm
+`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, "bridge"), []byte(plugin), 0755))
+	err = ioutil.WriteFile(filepath.Join(confDir, "10-test.conflist"), []byte(`{
+	"cniVersion": "0.3.1",
+	"name": "test",
+	"plugins": [{
+		"type": "bridge",
+		"ipam": {"type": "host-local", "dataDir": "`+dataDir+`"}
+	}]
+}`), 0644)
+	require.NoError(t, err)
+	for ip, id := range map[string]string{"10.22.0.2": "live", "10.22.0.3": "gone", "10.22.0.4": "creating"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dataDir, "test", ip), []byte(id), 0644))
+	}
+
+	var m Manager
+	require.NoError(t, m.Init(&CNIPath{Conf: confDir, Plugin: binDir}, ""))
+	live := func(id string) bool {
+		return id == "live" || id == "creating"
+	}
+
+	released, err := m.ReclaimIPAM([]string{"other"}, live, false)
+	require.NoError(t, err)
+	require.Empty(t, released, "network that is not opted in must not be touched")
+
+	released, err = m.ReclaimIPAM([]string{"test"}, live, true)
+	require.NoError(t, err)
+	require.Equal(t, []Allocation{{Network: "test", IP: "10.22.0.3", ContainerID: "gone"}}, released)
+	_, err = os.Stat(calls)
+	require.True(t, os.IsNotExist(err), "dry run must not call plugins")
+
+	released, err = m.ReclaimIPAM([]string{"test"}, live, false)
+	require.NoError(t, err)
+	require.Equal(t, []Allocation{{Network: "test", IP: "10.22.0.3", ContainerID: "gone", IfName: "eth1"}}, released)
+	content, err := ioutil.ReadFile(calls)
+	require.NoError(t, err)
+	require.Equal(t, "DEL gone eth1 \n", string(content))
+
+	released, err = m.ReclaimIPAM([]string{"test"}, live, false)
+	require.NoError(t, err)
+	require.Empty(t, released, "address must be released already")
+}
+
+func TestManager_SetUpPodNetworks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "network-test-")
+	require.NoError(t, err, "could not create temp directory")
+	defer os.RemoveAll(dir)
+
+	binDir := filepath.Join(dir, "bin")
+	confDir := filepath.Join(dir, "net.d")
+	calls := filepath.Join(dir, "calls")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	require.NoError(t, os.MkdirAll(confDir, 0755))
+
+	// fake plugins record calls and assign a single address on ADD
+	for plugin, ip := range map[string]string{"loopback": "", "bridge": "10.22.0.5/16", "macvlan": "192.168.1.5/24"} {
+		result := `{"cniVersion": "0.3.1"}`
+		if ip != "" {
+			result = `{"cniVersion": "0.3.1", "ips": [{"version": "4", "address": "` + ip + `"}]}`
+		}
+		script := `#!/bin/sh
+cat > /dev/null
+echo "$CNI_COMMAND ` + plugin + ` $CNI_IFNAME" >> ` + calls + `
+[ "$CNI_COMMAND" = ADD ] && echo '` + result + `'
+exit 0
+`
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, plugin), []byte(script), 0755))
+	}
+	for name, plugin := range map[string]string{"10-default": "bridge", "20-storage": "macvlan"} {
+		conf := `{"cniVersion": "0.3.1", "name": "` + name[3:] + `", "plugins": [{"type": "` + plugin + `"}]}`
+		require.NoError(t, ioutil.WriteFile(filepath.Join(confDir, name+".conflist"), []byte(conf), 0644))
+	}
+
+	var m Manager
+	require.NoError(t, m.Init(&CNIPath{Conf: confDir, Plugin: binDir}, ""))
+	config := &PodConfig{
+		ID:        "multi",
+		Namespace: "default",
+		Name:      "multi",
+		NsPath:    "/proc/self/ns/net",
+	}
+
+	config.Networks = []string{"missing"}
+	_, err = m.SetUpPod(context.Background(), config)
+	require.Error(t, err, "unknown network must be refused")
+	config.Networks = []string{"default"}
+	_, err = m.SetUpPod(context.Background(), config)
+	require.Error(t, err, "default network must not be attached twice")
+
+	config.Networks = []string{"storage"}
+	podNetwork, err := m.SetUpPod(context.Background(), config)
+	require.NoError(t, err)
+	ip, err := podNetwork.GetIP()
+	require.NoError(t, err)
+	require.Equal(t, "10.22.0.5", ip.String(), "primary IP must come from default network")
+	require.Equal(t, []Attachment{
+		{Network: "default", Interface: "eth1", IPs: []string{"10.22.0.5"}},
+		{Network: "storage", Interface: "eth2", IPs: []string{"192.168.1.5"}},
+	}, podNetwork.Attachments())
+
+	require.NoError(t, m.TearDownPod(podNetwork))
+	content, err := ioutil.ReadFile(calls)
+	require.NoError(t, err)
+	require.Equal(t, `ADD loopback eth0
+ADD bridge eth1
+ADD macvlan eth2
+DEL loopback eth0
+DEL bridge eth1
+DEL macvlan eth2
+`, string(content))
+}
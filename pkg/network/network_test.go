@@ -0,0 +1,130 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePodCIDRs(t *testing.T) {
+	tt := []struct {
+		name        string
+		input       string
+		expect      []string
+		expectError error
+	}{
+		{
+			name:   "single stack",
+			input:  "10.22.0.0/16",
+			expect: []string{"10.22.0.0/16"},
+		},
+		{
+			name:   "dual stack",
+			input:  "10.22.0.0/16, fd00:10:22::/64",
+			expect: []string{"10.22.0.0/16", "fd00:10:22::/64"},
+		},
+		{
+			name:        "empty",
+			input:       " , ",
+			expectError: fmt.Errorf("empty pod CIDR"),
+		},
+		{
+			name:        "invalid",
+			input:       "10.22.0.0/16,foo",
+			expectError: fmt.Errorf("invalid pod CIDR \"foo\": invalid CIDR address: foo"),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := parsePodCIDRs(tc.input)
+			require.Equal(t, tc.expectError, err)
+			require.Equal(t, tc.expect, actual)
+		})
+	}
+}
+
+// fakeSetup returns IPs as if they were parsed from CNI result.
+type fakeSetup map[string]net.IP
+
+func (f fakeSetup) GetNetworkIP(network string, version string) (net.IP, error) {
+	ip, ok := f[version]
+	if !ok {
+		return nil, fmt.Errorf("no IP found for network %s", network)
+	}
+	return ip, nil
+}
+
+func TestGetIPs(t *testing.T) {
+	v4 := net.ParseIP("10.22.0.5")
+	v6 := net.ParseIP("fd00:10:22::5")
+
+	tt := []struct {
+		name      string
+		setup     fakeSetup
+		ipv6First bool
+		expect    []net.IP
+		expectErr bool
+	}{
+		{
+			name:   "ipv4 only",
+			setup:  fakeSetup{"4": v4},
+			expect: []net.IP{v4},
+		},
+		{
+			name:   "ipv6 only",
+			setup:  fakeSetup{"6": v6},
+			expect: []net.IP{v6},
+		},
+		{
+			name:      "ipv6 only with primary ipv6 CIDR",
+			setup:     fakeSetup{"6": v6},
+			ipv6First: true,
+			expect:    []net.IP{v6},
+		},
+		{
+			name:   "dual-stack",
+			setup:  fakeSetup{"4": v4, "6": v6},
+			expect: []net.IP{v4, v6},
+		},
+		{
+			name:      "dual-stack with primary ipv6 CIDR",
+			setup:     fakeSetup{"4": v4, "6": v6},
+			ipv6First: true,
+			expect:    []net.IP{v6, v4},
+		},
+		{
+			name:      "no IPs",
+			setup:     fakeSetup{},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := getIPs(tc.setup, "test", tc.ipv6First)
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, actual)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux
+
+package network
+
+import (
+	"context"
+	"net"
+)
+
+// Manager is a stub of CNI network manager for platforms
+// pod networking is not supported on.
+type Manager struct{}
+
+// PodNetwork is a stub of pod's network.
+type PodNetwork struct{}
+
+// Init returns ErrNotSupported.
+func (m *Manager) Init(cniPath *CNIPath, confTemplate string) error {
+	return ErrNotSupported
+}
+
+// SetUpPod returns ErrNotSupported.
+func (m *Manager) SetUpPod(ctx context.Context, podConfig *PodConfig) (*PodNetwork, error) {
+	return nil, ErrNotSupported
+}
+
+// RestorePod returns ErrNotSupported.
+func (m *Manager) RestorePod(podConfig *PodConfig) (*PodNetwork, error) {
+	return nil, ErrNotSupported
+}
+
+// TearDownPod returns ErrNotSupported.
+func (m *Manager) TearDownPod(podNetwork *PodNetwork) error {
+	return ErrNotSupported
+}
+
+// Status returns ErrNotSupported.
+func (m *Manager) Status() error {
+	return ErrNotSupported
+}
+
+// SetPodCIDR returns ErrNotSupported.
+func (m *Manager) SetPodCIDR(cidr string) error {
+	return ErrNotSupported
+}
+
+// GetIP returns ErrNotSupported.
+func (n *PodNetwork) GetIP() (net.IP, error) {
+	return nil, ErrNotSupported
+}
+
+// GetIPs returns ErrNotSupported.
+func (n *PodNetwork) GetIPs() ([]net.IP, error) {
+	return nil, ErrNotSupported
+}
+
+// Attachments returns nil.
+func (n *PodNetwork) Attachments() []Attachment {
+	return nil
+}
+
+// ReclaimIPAM returns ErrNotSupported.
+func (m *Manager) ReclaimIPAM(networks []string, live func(id string) bool, dryRun bool) ([]Allocation, error) {
+	return nil, ErrNotSupported
+}
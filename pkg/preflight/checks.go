@@ -0,0 +1,212 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preflight
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// procDir and sysDir are variables so that tests may override them.
+var (
+	procDir = "/proc"
+	sysDir  = "/sys"
+)
+
+// Kernel checks kernel supports overlay filesystem that is used
+// to put container writable layer on top of the image.
+func Kernel() Check {
+	return Check{Name: "kernel", Run: checkKernel}
+}
+
+// UserNamespaces checks user namespaces are not disabled by sysctl.
+// They are required when engine is installed without setuid starter.
+func UserNamespaces() Check {
+	return Check{Name: "userns", Run: checkUserNamespaces}
+}
+
+func checkKernel() (Status, string) {
+	release, _ := ioutil.ReadFile(filepath.Join(procDir, "sys/kernel/osrelease"))
+	kernel := strings.TrimSpace(string(release))
+	if kernel == "" {
+		kernel = "unknown kernel"
+	}
+
+	f, err := os.Open(filepath.Join(procDir, "filesystems"))
+	if err != nil {
+		return StatusWarn, fmt.Sprintf("%s, could not read supported filesystems: %v", kernel, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 0 && fields[len(fields)-1] == "overlay" {
+			return StatusPass, kernel
+		}
+	}
+	return StatusWarn, fmt.Sprintf("%s, overlay filesystem is not available, load overlay kernel module", kernel)
+}
+
+func checkUserNamespaces() (Status, string) {
+	if _, err := os.Stat(filepath.Join(procDir, "self/ns/user")); err != nil {
+		return StatusWarn, "user namespaces are not supported by kernel"
+	}
+	if readSysctl("user/max_user_namespaces") == "0" {
+		return StatusWarn, "user namespaces are disabled, user.max_user_namespaces is 0"
+	}
+	if readSysctl("kernel/unprivileged_userns_clone") == "0" {
+		return StatusWarn, "unprivileged user namespaces are disabled, kernel.unprivileged_userns_clone is 0"
+	}
+	return StatusPass, "enabled"
+}
+
+// SELinux checks whether SELinux is enforcing, in which case dirs must
+// be labeled so that containers are allowed to access them.
+func SELinux(dirs ...string) Check {
+	return Check{
+		Name: "selinux",
+		Run: func() (Status, string) {
+			enforce, err := ioutil.ReadFile(filepath.Join(sysDir, "fs/selinux/enforce"))
+			if err != nil {
+				return StatusPass, "disabled"
+			}
+			if strings.TrimSpace(string(enforce)) != "1" {
+				return StatusPass, "permissive"
+			}
+			return StatusWarn, fmt.Sprintf("enforcing, make sure policy allows container access to %s", strings.Join(dirs, ", "))
+		},
+	}
+}
+
+// AppArmor reports whether AppArmor is enabled, in which case containers
+// may request profiles loaded on the host. Profiles are not required.
+func AppArmor() Check {
+	return Check{
+		Name: "apparmor",
+		Run: func() (Status, string) {
+			enabled, err := ioutil.ReadFile(filepath.Join(sysDir, "module/apparmor/parameters/enabled"))
+			if err != nil || !strings.HasPrefix(string(enabled), "Y") {
+				return StatusPass, "disabled, containers requesting localhost profiles will fail"
+			}
+			profiles, err := ioutil.ReadFile(filepath.Join(sysDir, "kernel/security/apparmor/profiles"))
+			if err != nil {
+				return StatusWarn, fmt.Sprintf("enabled, could not read loaded profiles: %v", err)
+			}
+			return StatusPass, fmt.Sprintf("enabled, %d profiles loaded", strings.Count(string(profiles), "\n"))
+		},
+	}
+}
+
+// Storage checks the passed directories are writable. Directories that do
+// not exist yet are checked by their closest existing parent.
+func Storage(dirs ...string) Check {
+	return Check{
+		Name: "storage",
+		Run: func() (Status, string) {
+			for _, dir := range dirs {
+				if err := checkWritable(dir); err != nil {
+					return StatusFail, err.Error()
+				}
+			}
+			return StatusPass, fmt.Sprintf("%s writable", strings.Join(dirs, ", "))
+		},
+	}
+}
+
+// CNI checks CNI plugins and network configuration are in place. Missing
+// configuration is not a failure when it is rendered from template.
+func CNI(binDir, confDir string, fromTemplate bool) Check {
+	return Check{
+		Name: "cni",
+		Run: func() (Status, string) {
+			plugins, err := ioutil.ReadDir(binDir)
+			if err != nil {
+				return StatusFail, fmt.Sprintf("could not read CNI plugins: %v", err)
+			}
+			if len(plugins) == 0 {
+				return StatusFail, fmt.Sprintf("no CNI plugins found in %s", binDir)
+			}
+			if fromTemplate {
+				return StatusPass, fmt.Sprintf("%d plugins, configuration is rendered from template", len(plugins))
+			}
+			var confs []string
+			for _, ext := range []string{"*.conf", "*.conflist", "*.json"} {
+				matches, _ := filepath.Glob(filepath.Join(confDir, ext))
+				confs = append(confs, matches...)
+			}
+			if len(confs) == 0 {
+				return StatusWarn, fmt.Sprintf("no CNI network configuration found in %s, network will not be ready", confDir)
+			}
+			return StatusPass, fmt.Sprintf("%d plugins, %d network configurations", len(plugins), len(confs))
+		},
+	}
+}
+
+// Binaries checks the passed binaries are found in PATH. Missing binaries
+// are reported as warnings since they are needed by optional features.
+func Binaries(names ...string) Check {
+	return Check{
+		Name: "binaries",
+		Run: func() (Status, string) {
+			var missing []string
+			for _, name := range names {
+				if _, err := exec.LookPath(name); err != nil {
+					missing = append(missing, name)
+				}
+			}
+			if len(missing) != 0 {
+				return StatusWarn, fmt.Sprintf("%s not found in PATH", strings.Join(missing, ", "))
+			}
+			return StatusPass, strings.Join(names, ", ")
+		},
+	}
+}
+
+func readSysctl(name string) string {
+	value, err := ioutil.ReadFile(filepath.Join(procDir, "sys", name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(value))
+}
+
+func checkWritable(dir string) error {
+	existing := dir
+	for {
+		fi, err := os.Stat(existing)
+		if err == nil {
+			if !fi.IsDir() {
+				return fmt.Errorf("%s is not a directory", existing)
+			}
+			break
+		}
+		if !os.IsNotExist(err) || filepath.Dir(existing) == existing {
+			return fmt.Errorf("could not check %s: %v", dir, err)
+		}
+		existing = filepath.Dir(existing)
+	}
+	f, err := ioutil.TempFile(existing, ".preflight")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %v", existing, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
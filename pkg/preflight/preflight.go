@@ -0,0 +1,115 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package preflight implements host capability checks that are run by
+// Singularity-CRI on startup and by its preflight subcommand.
+package preflight
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Status is an outcome of a single check.
+type Status string
+
+const (
+	// StatusPass means host is fully capable.
+	StatusPass Status = "pass"
+	// StatusWarn means some features will not be available.
+	StatusWarn Status = "warn"
+	// StatusFail means pods or containers will not run.
+	StatusFail Status = "fail"
+	// StatusSkip means check was skipped on request.
+	StatusSkip Status = "skip"
+)
+
+// Check is a named host capability check.
+type Check struct {
+	Name string
+	Run  func() (Status, string)
+}
+
+// Result is an outcome of a check.
+type Result struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Run executes passed checks in order except the skipped ones.
+func Run(checks []Check, skip []string) []Result {
+	skipped := make(map[string]bool, len(skip))
+	for _, name := range skip {
+		skipped[name] = true
+	}
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		if skipped[check.Name] {
+			results = append(results, Result{Name: check.Name, Status: StatusSkip})
+			continue
+		}
+		status, msg := check.Run()
+		results = append(results, Result{Name: check.Name, Status: status, Message: msg})
+	}
+	return results
+}
+
+// ParseSkip parses comma separated list of checks to skip and makes
+// sure all of them are known.
+func ParseSkip(value string, checks []Check) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	known := make(map[string]bool, len(checks))
+	names := make([]string, 0, len(checks))
+	for _, check := range checks {
+		known[check.Name] = true
+		names = append(names, check.Name)
+	}
+	sort.Strings(names)
+
+	var skip []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if !known[name] {
+			return nil, fmt.Errorf("unknown preflight check %q, expected one of %s", name, strings.Join(names, ", "))
+		}
+		skip = append(skip, name)
+	}
+	return skip, nil
+}
+
+// Failed checks whether any of the checks failed.
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Write prints results as a table.
+func Write(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tMESSAGE")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Name, strings.ToUpper(string(r.Status)), r.Message)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preflight
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	checks := []Check{
+		{Name: "one", Run: func() (Status, string) { return StatusPass, "ok" }},
+		{Name: "two", Run: func() (Status, string) { return StatusFail, "broken" }},
+		{Name: "three", Run: func() (Status, string) { panic("must be skipped") }},
+	}
+
+	_, err := ParseSkip("three,four", checks)
+	require.Error(t, err)
+	skip, err := ParseSkip(" three ", checks)
+	require.NoError(t, err)
+
+	results := Run(checks, skip)
+	require.Equal(t, []Result{
+		{Name: "one", Status: StatusPass, Message: "ok"},
+		{Name: "two", Status: StatusFail, Message: "broken"},
+		{Name: "three", Status: StatusSkip},
+	}, results)
+	require.True(t, Failed(results))
+	require.False(t, Failed(results[:1]))
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, results))
+	require.Equal(t, "CHECK  STATUS  MESSAGE\none    PASS    ok\ntwo    FAIL    broken\nthree  SKIP    \n", buf.String())
+}
+
+func TestChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	defer func(proc, sys string) {
+		procDir, sysDir = proc, sys
+	}(procDir, sysDir)
+	procDir = filepath.Join(dir, "proc")
+	sysDir = filepath.Join(dir, "sys")
+
+	write := func(path, content string) {
+		path = filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	write("proc/sys/kernel/osrelease", "4.19.0\n")
+	write("proc/filesystems", "nodev\tsysfs\n\text4\n")
+	write("proc/self/ns/user", "")
+	write("proc/sys/user/max_user_namespaces", "0\n")
+	write("sys/fs/selinux/enforce", "1")
+	write("sys/module/apparmor/parameters/enabled", "Y\n")
+	write("sys/kernel/security/apparmor/profiles", "docker-default (enforce)\n/usr/bin/man (enforce)\n")
+	write("cni/bin/loopback", "")
+	write("cni/conf/.keep", "")
+
+	tt := []struct {
+		name          string
+		check         Check
+		expectStatus  Status
+		expectMessage string
+	}{
+		{
+			name:          "kernel without overlay",
+			check:         Kernel(),
+			expectStatus:  StatusWarn,
+			expectMessage: "4.19.0, overlay filesystem is not available, load overlay kernel module",
+		},
+		{
+			name:          "user namespaces disabled",
+			check:         UserNamespaces(),
+			expectStatus:  StatusWarn,
+			expectMessage: "user namespaces are disabled, user.max_user_namespaces is 0",
+		},
+		{
+			name:          "selinux enforcing",
+			check:         SELinux("/var/lib/singularity"),
+			expectStatus:  StatusWarn,
+			expectMessage: "enforcing, make sure policy allows container access to /var/lib/singularity",
+		},
+		{
+			name:          "apparmor enabled",
+			check:         AppArmor(),
+			expectStatus:  StatusPass,
+			expectMessage: "enabled, 2 profiles loaded",
+		},
+		{
+			name:          "missing storage parent is writable",
+			check:         Storage(filepath.Join(dir, "storage/images")),
+			expectStatus:  StatusPass,
+			expectMessage: filepath.Join(dir, "storage/images") + " writable",
+		},
+		{
+			name:          "storage is a file",
+			check:         Storage(filepath.Join(dir, "proc/filesystems")),
+			expectStatus:  StatusFail,
+			expectMessage: filepath.Join(dir, "proc/filesystems") + " is not a directory",
+		},
+		{
+			name:          "no CNI configuration",
+			check:         CNI(filepath.Join(dir, "cni/bin"), filepath.Join(dir, "cni/conf"), false),
+			expectStatus:  StatusWarn,
+			expectMessage: "no CNI network configuration found in " + filepath.Join(dir, "cni/conf") + ", network will not be ready",
+		},
+		{
+			name:          "CNI configuration from template",
+			check:         CNI(filepath.Join(dir, "cni/bin"), filepath.Join(dir, "cni/conf"), true),
+			expectStatus:  StatusPass,
+			expectMessage: "1 plugins, configuration is rendered from template",
+		},
+		{
+			name:         "no CNI plugins",
+			check:        CNI(filepath.Join(dir, "cni/none"), filepath.Join(dir, "cni/conf"), false),
+			expectStatus: StatusFail,
+		},
+		{
+			name:          "missing binary",
+			check:         Binaries("sycri-missing-binary"),
+			expectStatus:  StatusWarn,
+			expectMessage: "sycri-missing-binary not found in PATH",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			status, msg := tc.check.Run()
+			require.Equal(t, tc.expectStatus, status, msg)
+			if tc.expectMessage != "" {
+				require.Equal(t, tc.expectMessage, msg)
+			}
+		})
+	}
+
+	write("proc/filesystems", "nodev\toverlay\n")
+	status, msg := Kernel().Run()
+	require.Equal(t, StatusPass, status)
+	require.Equal(t, "4.19.0", msg)
+}
@@ -17,11 +17,24 @@ package rand
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 )
 
+// IDLen is a length of IDs returned by NewID, i.e. 256 bits in hex.
+const IDLen = 64
+
+// NewID returns random 256-bit hex ID that is used for pods and containers.
+func NewID() string {
+	return GenerateID(IDLen)
+}
+
 // GenerateID returns unique random id of passed length generated with crypto/rand.
+// It panics if system random number generator fails, since returning predictable
+// id would lead to collisions.
 func GenerateID(len int) string {
 	buf := make([]byte, (len-1)/2+1)
-	rand.Read(buf)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("could not read random bytes: %v", err))
+	}
 	return hex.EncodeToString(buf)[:len]
 }
@@ -0,0 +1,62 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rand
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateID(t *testing.T) {
+	for _, l := range []int{1, 7, 8, 64} {
+		require.Len(t, GenerateID(l), l)
+	}
+}
+
+func TestNewID_Concurrent(t *testing.T) {
+	const (
+		workers   = 32
+		perWorker = 1000
+	)
+	hexID := regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+	var mu sync.Mutex
+	seen := make(map[string]bool, workers*perWorker)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids := make([]string, perWorker)
+			for j := range ids {
+				ids[j] = NewID()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, id := range ids {
+				seen[id] = true
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, seen, workers*perWorker, "duplicate IDs generated")
+	for id := range seen {
+		require.Regexp(t, hexID, id)
+	}
+}
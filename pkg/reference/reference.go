@@ -0,0 +1,562 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package reference parses and normalizes image references exactly the way
+// Singularity CRI does, so that external tooling may compute the same image
+// names and cache keys as the runtime.
+//
+// Three kinds of references are recognized:
+//   - local SIF files, e.g. local.file/opt/images/app.sif;
+//   - Sylabs library images, e.g. library://user/collection/image:tag or
+//     cloud.sylabs.io/user/collection/image:sha256.<hash>;
+//   - docker images, e.g. busybox, docker://gcr.io/google/pause:3.1 or
+//     localhost:5000/app@sha256:<hash>.
+//
+// The following is guaranteed for any reference r returned by Parse:
+// Parse(r.String()) and Parse(r.Familiar()) return reference equal to r,
+// and two references name the same image if and only if their String
+// representations are equal. The runtime indexes images by their Familiar form.
+package reference
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+)
+
+const (
+	// DefaultTag is set for docker and library references that have
+	// neither a tag nor a digest.
+	DefaultTag = "latest"
+
+	// DockerScheme is an optional prefix of docker image references.
+	DockerScheme = singularity.DockerProtocol + "://"
+	// LibraryScheme is a prefix of library image references
+	// that do not include library domain.
+	LibraryScheme = "library://"
+
+	// legacyDockerDomain is an old docker hub domain normalized to the current one.
+	legacyDockerDomain = "index.docker.io"
+	// officialNamespace holds docker hub official images.
+	officialNamespace = "library"
+	// libraryDigestPrefix starts library tags that are actually digests.
+	libraryDigestPrefix = "sha256."
+	// maxNameLength is a maximum length of docker image name, including domain.
+	maxNameLength = 255
+	// maxTagLength is a maximum length of docker image tag.
+	maxTagLength = 128
+	// sha256HexLength is a length of hex encoded sha256 hash.
+	sha256HexLength = 64
+)
+
+// Components of image reference reported by Error.
+const (
+	ComponentScheme     = "scheme"
+	ComponentRegistry   = "registry"
+	ComponentRepository = "repository"
+	ComponentTag        = "tag"
+	ComponentDigest     = "digest"
+)
+
+var (
+	pathComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+	domainRegexp        = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?$`)
+	tagRegexp           = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegexp        = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+	sha256Regexp        = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// lenient is set when uppercase repositories are accepted, see SetLenient.
+var lenient int32
+
+// SetLenient sets whether uppercase characters in docker repository names are
+// lowercased with a warning instead of being rejected. Some tools produce such
+// references even though they are not valid. Images are indexed by references
+// parsed in the current mode, so it should be set once on startup.
+func SetLenient(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&lenient, v)
+}
+
+// IsLenient returns whether lenient mode is enabled, see SetLenient.
+func IsLenient() bool {
+	return atomic.LoadInt32(&lenient) == 1
+}
+
+// Error is returned when reference cannot be parsed. It points at the
+// reference component that is invalid and, when possible, at the offending
+// character.
+type Error struct {
+	// Ref is the reference as passed to Parse.
+	Ref string
+	// Component is one of Component* constants, it is
+	// empty when error concerns reference as a whole.
+	Component string
+	// Pos is a byte offset of the offending character in Ref
+	// or -1 when error is not caused by a particular character.
+	Pos int
+	// Reason describes what is wrong with component.
+	Reason string
+}
+
+// Error implements error interface. Position is reported starting from 1.
+func (e *Error) Error() string {
+	msg := e.Reason
+	if e.Component != "" {
+		msg = e.Component + " " + msg
+	}
+	if e.Pos >= 0 {
+		msg = fmt.Sprintf("%s at position %d", msg, e.Pos+1)
+	}
+	return fmt.Sprintf("invalid reference %q: %s", e.Ref, msg)
+}
+
+// Reference is a parsed image reference.
+type Reference struct {
+	// Domain is a registry host for docker images, singularity.LibraryDomain
+	// for library images and singularity.LocalFileDomain for local SIF files.
+	// It is always lowercase.
+	Domain string
+	// Path is a repository path within domain. For local SIF files it is the
+	// file path without leading slash.
+	Path string
+	// Tag is empty for local SIF files and for references with digest.
+	Tag string
+	// Digest is in form of algorithm:hash for docker images and
+	// sha256.hash for library images.
+	Digest string
+}
+
+// Parse parses and normalizes image reference s. Scheme, docker hub
+// domain and default tag are added when missing, domain is lowercased and
+// docker hub official images are put into library namespace. When both tag
+// and digest are present tag is dropped as digest identifies image alone.
+// Local SIF references ignore any tag, since kubelet adds one to all images.
+// Returned error is of type *Error.
+func Parse(s string) (*Reference, error) {
+	p := &parser{ref: s}
+	if s == "" {
+		return nil, p.errorf("", -1, "reference is empty")
+	}
+	if i := strings.IndexAny(s, " \t\n\r"); i != -1 {
+		return nil, p.errorf("", i, "reference contains whitespace")
+	}
+
+	switch {
+	case strings.HasPrefix(s, LibraryScheme):
+		return p.parseLibrary(len(LibraryScheme))
+	case strings.HasPrefix(s, DockerScheme):
+		return p.parseDocker(len(DockerScheme))
+	case strings.Contains(s, "://"):
+		return nil, p.errorf(ComponentScheme, 0, "is not supported")
+	}
+	domain := strings.ToLower(firstComponent(s))
+	switch {
+	case domain == singularity.LocalFileDomain:
+		return p.parseLocalFile(len(domain))
+	case domain == singularity.LibraryDomain && strings.ContainsRune(s, '/'):
+		return p.parseLibrary(0)
+	}
+	return p.parseDocker(0)
+}
+
+// Normalize returns canonical representation of image reference s.
+// It is a shortcut for Parse followed by String.
+func Normalize(s string) (string, error) {
+	r, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return r.String(), nil
+}
+
+// Familiar returns the shortest representation of image reference s
+// that is parsed into the same reference. Runtime indexes images by it.
+// It is a shortcut for Parse followed by Familiar.
+func Familiar(s string) (string, error) {
+	r, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return r.Familiar(), nil
+}
+
+// IsLocalFile checks whether reference points to a local SIF file.
+func (r *Reference) IsLocalFile() bool {
+	return r.Domain == singularity.LocalFileDomain
+}
+
+// IsLibrary checks whether reference points to a library image.
+func (r *Reference) IsLibrary() bool {
+	return r.Domain == singularity.LibraryDomain
+}
+
+// IsDocker checks whether reference points to a docker image.
+func (r *Reference) IsDocker() bool {
+	return !r.IsLocalFile() && !r.IsLibrary()
+}
+
+// Name returns fully qualified image name, i.e. domain and path.
+func (r *Reference) Name() string {
+	return r.Domain + "/" + r.Path
+}
+
+// FamiliarName returns image name as it is shown to users. Docker hub
+// domain and official namespace are omitted unless they are needed
+// to parse name back.
+func (r *Reference) FamiliarName() string {
+	if r.Domain != singularity.DockerDomain {
+		return r.Name()
+	}
+	path := r.Path
+	if p := strings.TrimPrefix(path, officialNamespace+"/"); p != path && !strings.ContainsRune(p, '/') {
+		return p
+	}
+	if first := firstComponent(path); strings.ContainsRune(path, '/') && isDomain(first) {
+		// docker hub repository looks like a domain, keep docker hub explicit
+		return r.Name()
+	}
+	return path
+}
+
+// String returns canonical representation of reference.
+func (r *Reference) String() string {
+	return r.Name() + r.suffix()
+}
+
+// Familiar returns the shortest representation of reference.
+func (r *Reference) Familiar() string {
+	return r.FamiliarName() + r.suffix()
+}
+
+func (r *Reference) suffix() string {
+	switch {
+	case r.Digest != "" && r.IsLibrary():
+		return ":" + r.Digest
+	case r.Digest != "":
+		return "@" + r.Digest
+	case r.Tag != "":
+		return ":" + r.Tag
+	}
+	return ""
+}
+
+// parser parses reference and reports errors
+// with offsets relative to the whole reference.
+type parser struct {
+	ref string
+}
+
+func (p *parser) errorf(component string, pos int, format string, args ...interface{}) error {
+	return &Error{
+		Ref:       p.ref,
+		Component: component,
+		Pos:       pos,
+		Reason:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *parser) parseLocalFile(off int) (*Reference, error) {
+	path := strings.TrimLeft(p.ref[off:], "/")
+	if name, tag := splitTag(path); tag != "" {
+		path = name
+	}
+	if path == "" {
+		return nil, p.errorf(ComponentRepository, -1, "file path is empty")
+	}
+	return &Reference{
+		Domain: singularity.LocalFileDomain,
+		Path:   path,
+	}, nil
+}
+
+func (p *parser) parseLibrary(off int) (*Reference, error) {
+	s := p.ref[off:]
+	if strings.EqualFold(firstComponent(s), singularity.LibraryDomain) && strings.ContainsRune(s, '/') {
+		off += len(singularity.LibraryDomain) + 1
+		s = p.ref[off:]
+	}
+	r := &Reference{
+		Domain: singularity.LibraryDomain,
+	}
+	r.Path, r.Tag = splitTag(s)
+	tagOff := off + len(r.Path) + 1
+	if r.Path != s && r.Tag == "" {
+		return nil, p.errorf(ComponentTag, tagOff, "is empty")
+	}
+	if strings.HasPrefix(r.Tag, libraryDigestPrefix) {
+		r.Digest, r.Tag = r.Tag, ""
+		if len(r.Digest) == len(libraryDigestPrefix) {
+			return nil, p.errorf(ComponentDigest, tagOff+len(r.Digest), "is empty")
+		}
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = DefaultTag
+	}
+	if r.Path == "" {
+		return nil, p.errorf(ComponentRepository, off, "is empty")
+	}
+	for _, component := range strings.Split(r.Path, "/") {
+		if component == "" {
+			return nil, p.errorf(ComponentRepository, off, "has empty path component")
+		}
+		off += len(component) + 1
+	}
+	if r.Path != s && r.Digest == "" {
+		if err := p.checkTag(s[len(r.Path)+1:], tagOff); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (p *parser) parseDocker(off int) (*Reference, error) {
+	s := p.ref[off:]
+	r := &Reference{}
+	if i := strings.IndexByte(s, '@'); i != -1 {
+		s, r.Digest = s[:i], s[i+1:]
+		if err := p.checkDigest(r.Digest, off+i+1); err != nil {
+			return nil, err
+		}
+	}
+	name, tag := splitTag(s)
+	if name != s && tag == "" {
+		return nil, p.errorf(ComponentTag, off+len(s), "is empty")
+	}
+	if r.Digest == "" {
+		r.Tag = tag
+		if r.Tag == "" {
+			r.Tag = DefaultTag
+		}
+	}
+	if tag != "" {
+		if err := p.checkTag(tag, off+len(name)+1); err != nil {
+			return nil, err
+		}
+	}
+	if len(name) > maxNameLength {
+		return nil, p.errorf(ComponentRepository, off+maxNameLength,
+			"is longer than %d characters", maxNameLength)
+	}
+
+	r.Domain, r.Path = splitDomain(name)
+	if r.Path != name {
+		if err := p.checkDomain(r.Domain, off); err != nil {
+			return nil, err
+		}
+	}
+	r.Domain = strings.ToLower(r.Domain)
+	if r.Domain == legacyDockerDomain {
+		r.Domain = singularity.DockerDomain
+	}
+	pathOff := off + len(name) - len(r.Path)
+	path, err := p.checkPath(r.Path, pathOff)
+	if err != nil {
+		return nil, err
+	}
+	r.Path = path
+	if r.Domain == singularity.DockerDomain && !strings.ContainsRune(r.Path, '/') {
+		r.Path = officialNamespace + "/" + r.Path
+	}
+	return r, nil
+}
+
+// checkDomain validates registry host and optional port.
+func (p *parser) checkDomain(domain string, off int) error {
+	host, port := domain, ""
+	if i := strings.IndexByte(domain, ':'); i != -1 {
+		host, port = domain[:i], domain[i+1:]
+	}
+	if i := firstInvalid(host, isHostChar); i != -1 {
+		return p.errorf(ComponentRegistry, off+i, "host has invalid character %q", host[i])
+	}
+	if port != "" || len(host) < len(domain) {
+		portOff := off + len(host) + 1
+		if port == "" {
+			return p.errorf(ComponentRegistry, portOff, "port is empty")
+		}
+		if i := firstInvalid(port, isDigit); i != -1 {
+			return p.errorf(ComponentRegistry, portOff+i, "port has invalid character %q", port[i])
+		}
+	}
+	if !domainRegexp.MatchString(domain) {
+		for i, label := range strings.Split(host, ".") {
+			if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+				return p.errorf(ComponentRegistry, off, "host label %d %q is invalid", i+1, label)
+			}
+		}
+		return p.errorf(ComponentRegistry, off, "%q is invalid", domain)
+	}
+	return nil
+}
+
+// checkPath validates repository path. In lenient mode uppercase
+// characters are lowercased and returned path should be used.
+func (p *parser) checkPath(path string, off int) (string, error) {
+	if path == "" {
+		return "", p.errorf(ComponentRepository, off, "is empty")
+	}
+	if lower := strings.ToLower(path); lower != path && IsLenient() {
+		glog.Warningf("Repository %s of image %s is not lowercase, using %s", path, p.ref, lower)
+		path = lower
+	}
+	for _, component := range strings.Split(path, "/") {
+		if component == "" {
+			return "", p.errorf(ComponentRepository, off, "has empty path component")
+		}
+		if i := strings.IndexFunc(component, unicode.IsUpper); i != -1 {
+			return "", p.errorf(ComponentRepository, off+i, "must be lowercase, found %q", component[i])
+		}
+		if i := firstInvalid(component, isPathChar); i != -1 {
+			return "", p.errorf(ComponentRepository, off+i, "has invalid character %q", component[i])
+		}
+		if !pathComponentRegexp.MatchString(component) {
+			return "", p.errorf(ComponentRepository, off,
+				"path component %q must start and end with a letter or digit", component)
+		}
+		off += len(component) + 1
+	}
+	return path, nil
+}
+
+func (p *parser) checkTag(tag string, off int) error {
+	if tagRegexp.MatchString(tag) {
+		return nil
+	}
+	if len(tag) > maxTagLength {
+		return p.errorf(ComponentTag, off+maxTagLength, "is longer than %d characters", maxTagLength)
+	}
+	if i := firstInvalid(tag, isTagChar); i != -1 {
+		return p.errorf(ComponentTag, off+i, "has invalid character %q", tag[i])
+	}
+	return p.errorf(ComponentTag, off, "must start with a letter, digit or underscore")
+}
+
+func (p *parser) checkDigest(digest string, off int) error {
+	if digest == "" {
+		return p.errorf(ComponentDigest, off, "is empty")
+	}
+	i := strings.IndexByte(digest, ':')
+	if i == -1 {
+		return p.errorf(ComponentDigest, off, "must be in form algorithm:hash")
+	}
+	algorithm, hash := digest[:i], digest[i+1:]
+	if j := firstInvalid(algorithm, isAlgorithmChar); j != -1 {
+		return p.errorf(ComponentDigest, off+j, "algorithm has invalid character %q", algorithm[j])
+	}
+	hashOff := off + i + 1
+	if algorithm == "sha256" {
+		if j := firstInvalid(hash, isHexChar); j != -1 {
+			return p.errorf(ComponentDigest, hashOff+j, "sha256 hash has invalid character %q", hash[j])
+		}
+		if len(hash) != sha256HexLength {
+			return p.errorf(ComponentDigest, hashOff, "sha256 hash must have %d characters, found %d",
+				sha256HexLength, len(hash))
+		}
+	}
+	if j := firstInvalid(hash, isHashChar); j != -1 {
+		return p.errorf(ComponentDigest, hashOff+j, "hash has invalid character %q", hash[j])
+	}
+	if !digestRegexp.MatchString(digest) {
+		return p.errorf(ComponentDigest, off, "%q is invalid", digest)
+	}
+	return nil
+}
+
+// splitDomain splits docker image name into domain and path. First name
+// component is a domain only when it looks like a host, i.e. contains a dot
+// or a port, or is localhost.
+func splitDomain(name string) (string, string) {
+	i := strings.IndexByte(name, '/')
+	if i == -1 || !isDomain(name[:i]) {
+		return singularity.DockerDomain, name
+	}
+	return name[:i], name[i+1:]
+}
+
+func isDomain(component string) bool {
+	return strings.ContainsAny(component, ".:") || strings.EqualFold(component, "localhost")
+}
+
+// splitTag splits s into name and tag parts. Tag is empty when s has none.
+func splitTag(s string) (string, string) {
+	i := strings.LastIndexByte(s, ':')
+	if i == -1 || strings.ContainsRune(s[i:], '/') {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
+
+// firstComponent returns part of s before the first slash.
+func firstComponent(s string) string {
+	if i := strings.IndexByte(s, '/'); i != -1 {
+		return s[:i]
+	}
+	return s
+}
+
+// firstInvalid returns index of the first byte of s
+// that is not accepted by valid, or -1 if there is none.
+func firstInvalid(s string, valid func(c byte) bool) int {
+	for i := 0; i < len(s); i++ {
+		if !valid(s[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLower(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+func isHostChar(c byte) bool {
+	return isDigit(c) || isLower(c) || isUpper(c) || c == '.' || c == '-'
+}
+
+func isPathChar(c byte) bool {
+	return isDigit(c) || isLower(c) || c == '.' || c == '_' || c == '-'
+}
+
+func isTagChar(c byte) bool {
+	return isDigit(c) || isLower(c) || isUpper(c) || c == '.' || c == '_' || c == '-'
+}
+
+func isAlgorithmChar(c byte) bool {
+	return isDigit(c) || isLower(c) || c == '.' || c == '+' || c == '_' || c == '-'
+}
+
+func isHexChar(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f')
+}
+
+func isHashChar(c byte) bool {
+	return isDigit(c) || isLower(c) || isUpper(c) || c == '=' || c == '_' || c == '-'
+}
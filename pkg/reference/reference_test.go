@@ -0,0 +1,476 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reference
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+)
+
+const (
+	testSha256  = "sha256:9179135b4b4cc5a8721e09379244807553c318d92fa3111a65133241551ca343"
+	testLibHash = "sha256.9327532a05078d7efd5a0ef9ace1ee5cd278653d8df53590e2fb7a4a34cb0bb8"
+)
+
+func TestParse(t *testing.T) {
+	tt := []struct {
+		name           string
+		ref            string
+		expect         *Reference
+		expectString   string
+		expectFamiliar string
+	}{
+		{
+			name:           "official image",
+			ref:            "busybox",
+			expect:         &Reference{Domain: "docker.io", Path: "library/busybox", Tag: "latest"},
+			expectString:   "docker.io/library/busybox:latest",
+			expectFamiliar: "busybox:latest",
+		},
+		{
+			name:           "official image with tag",
+			ref:            "busybox:1.29",
+			expect:         &Reference{Domain: "docker.io", Path: "library/busybox", Tag: "1.29"},
+			expectString:   "docker.io/library/busybox:1.29",
+			expectFamiliar: "busybox:1.29",
+		},
+		{
+			name:           "official image with namespace",
+			ref:            "library/busybox:1.29",
+			expect:         &Reference{Domain: "docker.io", Path: "library/busybox", Tag: "1.29"},
+			expectString:   "docker.io/library/busybox:1.29",
+			expectFamiliar: "busybox:1.29",
+		},
+		{
+			name:           "fully qualified official image",
+			ref:            "docker.io/library/busybox:1.29",
+			expect:         &Reference{Domain: "docker.io", Path: "library/busybox", Tag: "1.29"},
+			expectString:   "docker.io/library/busybox:1.29",
+			expectFamiliar: "busybox:1.29",
+		},
+		{
+			name:           "docker hub domain without namespace",
+			ref:            "docker.io/busybox",
+			expect:         &Reference{Domain: "docker.io", Path: "library/busybox", Tag: "latest"},
+			expectString:   "docker.io/library/busybox:latest",
+			expectFamiliar: "busybox:latest",
+		},
+		{
+			name:           "legacy docker hub domain",
+			ref:            "index.docker.io/library/busybox",
+			expect:         &Reference{Domain: "docker.io", Path: "library/busybox", Tag: "latest"},
+			expectString:   "docker.io/library/busybox:latest",
+			expectFamiliar: "busybox:latest",
+		},
+		{
+			name:           "docker scheme",
+			ref:            "docker://busybox:1.29",
+			expect:         &Reference{Domain: "docker.io", Path: "library/busybox", Tag: "1.29"},
+			expectString:   "docker.io/library/busybox:1.29",
+			expectFamiliar: "busybox:1.29",
+		},
+		{
+			name:           "docker hub user image",
+			ref:            "sylabs/busybox",
+			expect:         &Reference{Domain: "docker.io", Path: "sylabs/busybox", Tag: "latest"},
+			expectString:   "docker.io/sylabs/busybox:latest",
+			expectFamiliar: "sylabs/busybox:latest",
+		},
+		{
+			name:           "nested official namespace",
+			ref:            "docker.io/library/foo/bar",
+			expect:         &Reference{Domain: "docker.io", Path: "library/foo/bar", Tag: "latest"},
+			expectString:   "docker.io/library/foo/bar:latest",
+			expectFamiliar: "library/foo/bar:latest",
+		},
+		{
+			name:           "docker hub repository looking like domain",
+			ref:            "docker.io/gcr.io/cri-tools/test-image-tags:1",
+			expect:         &Reference{Domain: "docker.io", Path: "gcr.io/cri-tools/test-image-tags", Tag: "1"},
+			expectString:   "docker.io/gcr.io/cri-tools/test-image-tags:1",
+			expectFamiliar: "docker.io/gcr.io/cri-tools/test-image-tags:1",
+		},
+		{
+			name:           "registry image",
+			ref:            "gcr.io/google-containers/pause:3.1",
+			expect:         &Reference{Domain: "gcr.io", Path: "google-containers/pause", Tag: "3.1"},
+			expectString:   "gcr.io/google-containers/pause:3.1",
+			expectFamiliar: "gcr.io/google-containers/pause:3.1",
+		},
+		{
+			name:           "uppercase host",
+			ref:            "GCR.io/google-containers/pause",
+			expect:         &Reference{Domain: "gcr.io", Path: "google-containers/pause", Tag: "latest"},
+			expectString:   "gcr.io/google-containers/pause:latest",
+			expectFamiliar: "gcr.io/google-containers/pause:latest",
+		},
+		{
+			name:           "uppercase localhost",
+			ref:            "LocalHost:5000/app",
+			expect:         &Reference{Domain: "localhost:5000", Path: "app", Tag: "latest"},
+			expectString:   "localhost:5000/app:latest",
+			expectFamiliar: "localhost:5000/app:latest",
+		},
+		{
+			name:           "host with port",
+			ref:            "Registry.Example.COM:8443/team/app:1.0",
+			expect:         &Reference{Domain: "registry.example.com:8443", Path: "team/app", Tag: "1.0"},
+			expectString:   "registry.example.com:8443/team/app:1.0",
+			expectFamiliar: "registry.example.com:8443/team/app:1.0",
+		},
+		{
+			name:           "localhost registry",
+			ref:            "localhost/app",
+			expect:         &Reference{Domain: "localhost", Path: "app", Tag: "latest"},
+			expectString:   "localhost/app:latest",
+			expectFamiliar: "localhost/app:latest",
+		},
+		{
+			name:           "localhost registry with port",
+			ref:            "localhost:5000/app:1.0",
+			expect:         &Reference{Domain: "localhost:5000", Path: "app", Tag: "1.0"},
+			expectString:   "localhost:5000/app:1.0",
+			expectFamiliar: "localhost:5000/app:1.0",
+		},
+		{
+			name:           "localhost without path",
+			ref:            "localhost:5000",
+			expect:         &Reference{Domain: "docker.io", Path: "library/localhost", Tag: "5000"},
+			expectString:   "docker.io/library/localhost:5000",
+			expectFamiliar: "localhost:5000",
+		},
+		{
+			name:           "digest",
+			ref:            "busybox@" + testSha256,
+			expect:         &Reference{Domain: "docker.io", Path: "library/busybox", Digest: testSha256},
+			expectString:   "docker.io/library/busybox@" + testSha256,
+			expectFamiliar: "busybox@" + testSha256,
+		},
+		{
+			name:           "tag and digest",
+			ref:            "busybox:1.29@" + testSha256,
+			expect:         &Reference{Domain: "docker.io", Path: "library/busybox", Digest: testSha256},
+			expectString:   "docker.io/library/busybox@" + testSha256,
+			expectFamiliar: "busybox@" + testSha256,
+		},
+		{
+			name:           "registry with port tag and digest",
+			ref:            "localhost:5000/team/app:1.0@" + testSha256,
+			expect:         &Reference{Domain: "localhost:5000", Path: "team/app", Digest: testSha256},
+			expectString:   "localhost:5000/team/app@" + testSha256,
+			expectFamiliar: "localhost:5000/team/app@" + testSha256,
+		},
+		{
+			name:           "non sha256 digest",
+			ref:            "gcr.io/app@sha512:" + strings.Repeat("ab", 64),
+			expect:         &Reference{Domain: "gcr.io", Path: "app", Digest: "sha512:" + strings.Repeat("ab", 64)},
+			expectString:   "gcr.io/app@sha512:" + strings.Repeat("ab", 64),
+			expectFamiliar: "gcr.io/app@sha512:" + strings.Repeat("ab", 64),
+		},
+		{
+			name:           "path separators",
+			ref:            "quay.io/a_b/c__d/e-f/g--h.i",
+			expect:         &Reference{Domain: "quay.io", Path: "a_b/c__d/e-f/g--h.i", Tag: "latest"},
+			expectString:   "quay.io/a_b/c__d/e-f/g--h.i:latest",
+			expectFamiliar: "quay.io/a_b/c__d/e-f/g--h.i:latest",
+		},
+		{
+			name:           "library domain",
+			ref:            "cloud.sylabs.io/sashayakovtseva/test/image-server",
+			expect:         &Reference{Domain: singularity.LibraryDomain, Path: "sashayakovtseva/test/image-server", Tag: "latest"},
+			expectString:   "cloud.sylabs.io/sashayakovtseva/test/image-server:latest",
+			expectFamiliar: "cloud.sylabs.io/sashayakovtseva/test/image-server:latest",
+		},
+		{
+			name:           "library scheme",
+			ref:            "library://sashayakovtseva/test/image-server:1",
+			expect:         &Reference{Domain: singularity.LibraryDomain, Path: "sashayakovtseva/test/image-server", Tag: "1"},
+			expectString:   "cloud.sylabs.io/sashayakovtseva/test/image-server:1",
+			expectFamiliar: "cloud.sylabs.io/sashayakovtseva/test/image-server:1",
+		},
+		{
+			name:           "library scheme with domain",
+			ref:            "library://cloud.sylabs.io/sashayakovtseva/test/image-server:1",
+			expect:         &Reference{Domain: singularity.LibraryDomain, Path: "sashayakovtseva/test/image-server", Tag: "1"},
+			expectString:   "cloud.sylabs.io/sashayakovtseva/test/image-server:1",
+			expectFamiliar: "cloud.sylabs.io/sashayakovtseva/test/image-server:1",
+		},
+		{
+			name:           "library uppercase domain",
+			ref:            "Cloud.Sylabs.IO/library/default/alpine:3.8",
+			expect:         &Reference{Domain: singularity.LibraryDomain, Path: "library/default/alpine", Tag: "3.8"},
+			expectString:   "cloud.sylabs.io/library/default/alpine:3.8",
+			expectFamiliar: "cloud.sylabs.io/library/default/alpine:3.8",
+		},
+		{
+			name:           "library digest",
+			ref:            "cloud.sylabs.io/sashayakovtseva/test/image-server:" + testLibHash,
+			expect:         &Reference{Domain: singularity.LibraryDomain, Path: "sashayakovtseva/test/image-server", Digest: testLibHash},
+			expectString:   "cloud.sylabs.io/sashayakovtseva/test/image-server:" + testLibHash,
+			expectFamiliar: "cloud.sylabs.io/sashayakovtseva/test/image-server:" + testLibHash,
+		},
+		{
+			name:           "local SIF",
+			ref:            "local.file/home/sasha/my.sif",
+			expect:         &Reference{Domain: singularity.LocalFileDomain, Path: "home/sasha/my.sif"},
+			expectString:   "local.file/home/sasha/my.sif",
+			expectFamiliar: "local.file/home/sasha/my.sif",
+		},
+		{
+			name:           "local SIF with tag",
+			ref:            "local.file/home/sasha/my.sif:latest",
+			expect:         &Reference{Domain: singularity.LocalFileDomain, Path: "home/sasha/my.sif"},
+			expectString:   "local.file/home/sasha/my.sif",
+			expectFamiliar: "local.file/home/sasha/my.sif",
+		},
+		{
+			name:           "local SIF with colon in directory",
+			ref:            "local.file/opt/v1:2/my.sif",
+			expect:         &Reference{Domain: singularity.LocalFileDomain, Path: "opt/v1:2/my.sif"},
+			expectString:   "local.file/opt/v1:2/my.sif",
+			expectFamiliar: "local.file/opt/v1:2/my.sif",
+		},
+		{
+			name:           "docker image named like local domain",
+			ref:            "local.sif",
+			expect:         &Reference{Domain: "docker.io", Path: "library/local.sif", Tag: "latest"},
+			expectString:   "docker.io/library/local.sif:latest",
+			expectFamiliar: "local.sif:latest",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := Parse(tc.ref)
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, ref)
+			require.Equal(t, tc.expectString, ref.String())
+			require.Equal(t, tc.expectFamiliar, ref.Familiar())
+
+			normalized, err := Normalize(tc.ref)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectString, normalized)
+			familiar, err := Familiar(tc.ref)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectFamiliar, familiar)
+
+			// both representations must parse back into the same reference
+			for _, s := range []string{normalized, familiar} {
+				again, err := Parse(s)
+				require.NoError(t, err)
+				require.Equal(t, tc.expect, again)
+			}
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tt := []struct {
+		name string
+		ref  string
+	}{
+		{name: "empty", ref: ""},
+		{name: "whitespace", ref: "busybox latest"},
+		{name: "uppercase repository", ref: "Busybox:1.29"},
+		{name: "uppercase path", ref: "gcr.io/Google/pause"},
+		{name: "uppercase host without dots", ref: "Registry/app"},
+		{name: "unsupported scheme", ref: "ftp://busybox"},
+		{name: "empty tag", ref: "busybox:"},
+		{name: "invalid tag", ref: "busybox:-1"},
+		{name: "too long tag", ref: "busybox:" + strings.Repeat("a", 129)},
+		{name: "empty digest", ref: "busybox@"},
+		{name: "digest without algorithm", ref: "busybox@9179135b4b4cc5a8721e09379244807553c318d92fa3111a65133241551ca343"},
+		{name: "short sha256 digest", ref: "busybox@sha256:9179135b"},
+		{name: "uppercase sha256 digest", ref: "busybox@" + strings.ToUpper(testSha256)},
+		{name: "empty name", ref: ":latest"},
+		{name: "domain only", ref: "gcr.io/"},
+		{name: "empty path component", ref: "gcr.io/google//pause"},
+		{name: "invalid domain", ref: "-gcr.io/pause"},
+		{name: "invalid port", ref: "localhost:port/pause"},
+		{name: "too long name", ref: "gcr.io/" + strings.Repeat("a", 250)},
+		{name: "empty library name", ref: "library://"},
+		{name: "empty library component", ref: "library://sylabs//busybox"},
+		{name: "empty library digest", ref: "library://sylabs/busybox:sha256."},
+		{name: "empty local file", ref: "local.file/"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := Parse(tc.ref)
+			require.Error(t, err)
+			require.Nil(t, ref)
+			_, err = Normalize(tc.ref)
+			require.Error(t, err)
+			_, err = Familiar(tc.ref)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestParse_ErrorPosition(t *testing.T) {
+	tt := []struct {
+		name            string
+		ref             string
+		expectComponent string
+		expectPos       int
+		expectError     string
+	}{
+		{
+			name:            "uppercase path after registry port",
+			ref:             "registry.internal:5000/Team/app:1.2",
+			expectComponent: ComponentRepository,
+			expectPos:       23,
+			expectError:     `invalid reference "registry.internal:5000/Team/app:1.2": repository must be lowercase, found 'T' at position 24`,
+		},
+		{
+			name:            "invalid port",
+			ref:             "localhost:port/pause",
+			expectComponent: ComponentRegistry,
+			expectPos:       10,
+			expectError:     `registry port has invalid character 'p' at position 11`,
+		},
+		{
+			name:            "empty port",
+			ref:             "registry.internal:/app",
+			expectComponent: ComponentRegistry,
+			expectPos:       18,
+			expectError:     `registry port is empty at position 19`,
+		},
+		{
+			name:            "invalid host character",
+			ref:             "docker://registry_internal.io/app",
+			expectComponent: ComponentRegistry,
+			expectPos:       17,
+			expectError:     `registry host has invalid character '_' at position 18`,
+		},
+		{
+			name:            "invalid host label",
+			ref:             "-gcr.io/pause",
+			expectComponent: ComponentRegistry,
+			expectPos:       0,
+			expectError:     `registry host label 1 "-gcr" is invalid at position 1`,
+		},
+		{
+			name:            "invalid tag character",
+			ref:             "registry.internal:5000/app:1.2+build",
+			expectComponent: ComponentTag,
+			expectPos:       30,
+			expectError:     `tag has invalid character '+' at position 31`,
+		},
+		{
+			name:            "invalid tag start",
+			ref:             "busybox:-1",
+			expectComponent: ComponentTag,
+			expectPos:       8,
+			expectError:     `tag must start with a letter, digit or underscore at position 9`,
+		},
+		{
+			name:            "empty tag",
+			ref:             "busybox:",
+			expectComponent: ComponentTag,
+			expectPos:       8,
+		},
+		{
+			name:            "sha256 digest with uppercase",
+			ref:             "busybox@sha256:" + strings.ToUpper(testSha256[7:]),
+			expectComponent: ComponentDigest,
+			expectPos:       22,
+			expectError:     `digest sha256 hash has invalid character 'B' at position 23`,
+		},
+		{
+			name:            "short sha256 digest",
+			ref:             "busybox@sha256:9179135b",
+			expectComponent: ComponentDigest,
+			expectPos:       15,
+			expectError:     `digest sha256 hash must have 64 characters, found 8 at position 16`,
+		},
+		{
+			name:            "invalid path character",
+			ref:             "gcr.io/google/pau$e",
+			expectComponent: ComponentRepository,
+			expectPos:       17,
+			expectError:     `repository has invalid character '$' at position 18`,
+		},
+		{
+			name:            "empty library tag",
+			ref:             "library://sylabs/busybox:",
+			expectComponent: ComponentTag,
+			expectPos:       25,
+		},
+		{
+			name:            "unsupported scheme",
+			ref:             "ftp://busybox",
+			expectComponent: ComponentScheme,
+			expectPos:       0,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(tc.ref)
+			require.Error(t, err)
+			refErr, ok := err.(*Error)
+			require.True(t, ok, "unexpected error type %T", err)
+			require.Equal(t, tc.ref, refErr.Ref)
+			require.Equal(t, tc.expectComponent, refErr.Component)
+			require.Equal(t, tc.expectPos, refErr.Pos)
+			require.Contains(t, err.Error(), tc.expectError)
+		})
+	}
+}
+
+func TestParse_Lenient(t *testing.T) {
+	SetLenient(true)
+	defer SetLenient(false)
+
+	ref, err := Parse("Registry.Internal:5000/Team/App:V1")
+	require.NoError(t, err)
+	require.Equal(t, &Reference{Domain: "registry.internal:5000", Path: "team/app", Tag: "V1"}, ref)
+	require.Equal(t, "registry.internal:5000/team/app:V1", ref.Familiar())
+
+	ref, err = Parse("Busybox")
+	require.NoError(t, err)
+	require.Equal(t, "busybox:latest", ref.Familiar())
+
+	_, err = Parse("gcr.io/Google/pau$e")
+	require.Error(t, err, "lenient mode must not accept invalid characters")
+}
+
+func TestReference_Kind(t *testing.T) {
+	tt := []struct {
+		ref           string
+		expectDocker  bool
+		expectLibrary bool
+		expectLocal   bool
+	}{
+		{ref: "busybox", expectDocker: true},
+		{ref: "gcr.io/google/pause", expectDocker: true},
+		{ref: "library://sylabs/test/busybox", expectLibrary: true},
+		{ref: "local.file/opt/my.sif", expectLocal: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.ref, func(t *testing.T) {
+			ref, err := Parse(tc.ref)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectDocker, ref.IsDocker())
+			require.Equal(t, tc.expectLibrary, ref.IsLibrary())
+			require.Equal(t, tc.expectLocal, ref.IsLocalFile())
+		})
+	}
+}
@@ -41,6 +41,8 @@
 // (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
 // OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
+// +build linux
+
 package device
 
 import (
@@ -51,30 +53,17 @@ import (
 	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
 	"github.com/golang/glog"
 	"github.com/sylabs/singularity-cri/pkg/singularity"
-	"github.com/sylabs/singularity-cri/pkg/singularity/runtime"
 	"github.com/sylabs/singularity/pkg/util/nvidia"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	k8sDP "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
 )
 
-var (
-	// ErrNoGPUs is returned when device plugin is unable to
-	// detect any GPU device on the host.
-	ErrNoGPUs = fmt.Errorf("GPUs are not found on this host")
-
-	// ErrUnableToLoad is returned when device plugin is unable to
-	// detect loaded graphic driver on the host or unable to load
-	// NVML shared library.
-	ErrUnableToLoad = fmt.Errorf("unable to load: check libnvidia-ml.so.1 library and graphic drivers")
-)
-
 // SingularityDevicePlugin is Singularity implementation of a DevicePluginServer
 // interface that allows containers to request nvidia GPUs.
 type SingularityDevicePlugin struct {
 	devices  map[string]*nvml.Device
 	hospital map[string]string
-	confDir  string
 
 	done         chan struct{}
 	unhealthyDev <-chan string
@@ -89,10 +78,6 @@ func NewSingularityDevicePlugin() (*SingularityDevicePlugin, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not find %s on this machine: %v", singularity.RuntimeName, err)
 	}
-	config, err := runtime.NewCLIClient().BuildConfig()
-	if err != nil {
-		return nil, fmt.Errorf("could not get build config: %v", err)
-	}
 
 	glog.V(1).Infof("Loading NVML")
 	if err = nvml.Init(); err != nil {
@@ -101,8 +86,7 @@ func NewSingularityDevicePlugin() (*SingularityDevicePlugin, error) {
 	}
 
 	dp := &SingularityDevicePlugin{
-		done:    make(chan struct{}),
-		confDir: config.SingularityConfdir,
+		done: make(chan struct{}),
 	}
 	defer func() {
 		if err != nil {
@@ -184,34 +168,14 @@ func (dp *SingularityDevicePlugin) ListAndWatch(_ *k8sDP.Empty, srv k8sDP.Device
 // device specific operations and instruct Kubelet of the steps to make the Device
 // available in the container.
 func (dp *SingularityDevicePlugin) Allocate(ctx context.Context, req *k8sDP.AllocateRequest) (*k8sDP.AllocateResponse, error) {
-	nvLibs, nvBins, err := nvidia.Paths(dp.confDir, "")
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "could not search NVIDIA files: %v", err)
-	}
-	glog.V(4).Infof("NVIDIA paths are %v and %v", nvLibs, nvBins)
-
 	nvDevs, err := nvidia.Devices(false)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not search NVIDIA complementary devices: %v", err)
 	}
 	glog.V(4).Infof("NVIDIA complementary devices are %v", nvDevs)
 
-	nvidiaMounts := make([]*k8sDP.Mount, 0, len(nvLibs)+len(nvBins))
-	for _, libPath := range nvLibs {
-		nvidiaMounts = append(nvidiaMounts, &k8sDP.Mount{
-			ContainerPath: libPath,
-			HostPath:      libPath,
-			ReadOnly:      true,
-		})
-	}
-	for _, binPath := range nvBins {
-		nvidiaMounts = append(nvidiaMounts, &k8sDP.Mount{
-			ContainerPath: binPath,
-			HostPath:      binPath,
-			ReadOnly:      true,
-		})
-	}
-
+	// driver libraries and binaries are bound by the runtime
+	// into every container that gets NVIDIA GPU devices
 	allocateResponses := make([]*k8sDP.ContainerAllocateResponse, 0, len(req.ContainerRequests))
 	for _, allocateRequest := range req.ContainerRequests {
 		nvidiaDevices := make([]*k8sDP.DeviceSpec, 0, len(nvDevs)+len(allocateRequest.DevicesIDs))
@@ -231,7 +195,6 @@ func (dp *SingularityDevicePlugin) Allocate(ctx context.Context, req *k8sDP.Allo
 			})
 		}
 		allocateResponses = append(allocateResponses, &k8sDP.ContainerAllocateResponse{
-			Mounts:  nvidiaMounts,
 			Devices: nvidiaDevices,
 		})
 	}
@@ -0,0 +1,60 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux
+
+package device
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8sDP "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// SingularityDevicePlugin is a stub of device plugin for platforms
+// NVML is not available on.
+type SingularityDevicePlugin struct{}
+
+// NewSingularityDevicePlugin always returns ErrUnableToLoad
+// since NVML library is available on Linux only.
+func NewSingularityDevicePlugin() (*SingularityDevicePlugin, error) {
+	return nil, ErrUnableToLoad
+}
+
+// Shutdown does nothing.
+func (dp *SingularityDevicePlugin) Shutdown() error {
+	return nil
+}
+
+// GetDevicePluginOptions returns options to be communicated with Device Manager.
+func (*SingularityDevicePlugin) GetDevicePluginOptions(context.Context, *k8sDP.Empty) (*k8sDP.DevicePluginOptions, error) {
+	return &k8sDP.DevicePluginOptions{}, nil
+}
+
+// ListAndWatch returns Unimplemented error.
+func (*SingularityDevicePlugin) ListAndWatch(*k8sDP.Empty, k8sDP.DevicePlugin_ListAndWatchServer) error {
+	return status.Errorf(codes.Unimplemented, "not supported on this platform")
+}
+
+// Allocate returns Unimplemented error.
+func (*SingularityDevicePlugin) Allocate(context.Context, *k8sDP.AllocateRequest) (*k8sDP.AllocateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not supported on this platform")
+}
+
+// PreStartContainer returns Unimplemented error.
+func (*SingularityDevicePlugin) PreStartContainer(context.Context, *k8sDP.PreStartContainerRequest) (*k8sDP.PreStartContainerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "not supported on this platform")
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package device
+
+import "fmt"
+
+var (
+	// ErrNoGPUs is returned when device plugin is unable to
+	// detect any GPU device on the host.
+	ErrNoGPUs = fmt.Errorf("GPUs are not found on this host")
+
+	// ErrUnableToLoad is returned when device plugin is unable to
+	// detect loaded graphic driver on the host or unable to load
+	// NVML shared library.
+	ErrUnableToLoad = fmt.Errorf("unable to load: check libnvidia-ml.so.1 library and graphic drivers")
+)
@@ -0,0 +1,111 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"sync"
+)
+
+// pullSlots limits number of image downloads run at the same time.
+// Limit may be changed while pulls are waiting, zero means unlimited.
+type pullSlots struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	changed chan struct{} // closed when a slot is freed or limit is changed
+}
+
+func newPullSlots() *pullSlots {
+	return &pullSlots{changed: make(chan struct{})}
+}
+
+// acquire waits for a free slot until ctx is done. Returned function
+// must be called once download is finished.
+func (p *pullSlots) acquire(ctx context.Context) (func(), error) {
+	for {
+		p.mu.Lock()
+		if p.limit <= 0 || p.active < p.limit {
+			p.active++
+			p.mu.Unlock()
+			return p.release, nil
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (p *pullSlots) release() {
+	p.mu.Lock()
+	p.active--
+	p.notify()
+	p.mu.Unlock()
+}
+
+func (p *pullSlots) setLimit(limit int) {
+	p.mu.Lock()
+	p.limit = limit
+	p.notify()
+	p.mu.Unlock()
+}
+
+// get returns current limit and number of pulls in progress.
+func (p *pullSlots) get() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.limit, p.active
+}
+
+// notify wakes up all waiting pulls, must be called with p.mu held.
+func (p *pullSlots) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// WithMaxConcurrentPulls limits number of image downloads run at the
+// same time, other pulls wait for a free slot. By default it is unlimited.
+func WithMaxConcurrentPulls(n int) Option {
+	return func(r *SingularityRegistry) {
+		r.pulls.setLimit(n)
+	}
+}
+
+// SetMaxConcurrentPulls replaces limit of concurrent image downloads, zero
+// means unlimited. Pulls in progress are not interrupted, waiting ones
+// follow new limit.
+func (s *SingularityRegistry) SetMaxConcurrentPulls(n int) {
+	s.pulls.setLimit(n)
+}
+
+// MaxConcurrentPulls returns limit of concurrent image downloads.
+func (s *SingularityRegistry) MaxConcurrentPulls() int {
+	limit, _ := s.pulls.get()
+	return limit
+}
+
+// WithConcurrentLayerDownloads sets number of layers of a single image
+// downloaded at the same time when images are stored in OCI layout.
+// By default image.DefaultConcurrentDownloads is used.
+func WithConcurrentLayerDownloads(n int) Option {
+	return func(r *SingularityRegistry) {
+		r.layerDownloads = n
+	}
+}
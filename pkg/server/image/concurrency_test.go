@@ -0,0 +1,73 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullSlots(t *testing.T) {
+	p := newPullSlots()
+	p.setLimit(1)
+
+	release, err := p.acquire(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = p.acquire(ctx)
+	require.Equal(t, context.DeadlineExceeded, err, "second pull must wait for a free slot")
+
+	acquired := make(chan func())
+	go func() {
+		release, err := p.acquire(context.Background())
+		if err == nil {
+			acquired <- release
+		}
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("pull acquired busy slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// raised limit lets waiting pull in while the first one is in progress
+	p.setLimit(2)
+	var second func()
+	select {
+	case second = <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("waiting pull is not admitted after limit is raised")
+	}
+	limit, active := p.get()
+	require.Equal(t, 2, limit)
+	require.Equal(t, 2, active)
+
+	release()
+	second()
+	_, active = p.get()
+	require.Zero(t, active)
+
+	// zero limit means unlimited
+	p.setLimit(0)
+	for i := 0; i < 10; i++ {
+		_, err := p.acquire(context.Background())
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"sort"
+
+	"github.com/sylabs/singularity-cri/pkg/image"
+)
+
+// ImageDump is registry internal view of a stored image.
+type ImageDump struct {
+	Info    *image.Info `json:"info"`
+	UsedBy  []string    `json:"usedBy,omitempty"`
+	Corrupt string      `json:"corrupt,omitempty"`
+	// PinnedBy is what keeps image from garbage collection, see pinSource.
+	PinnedBy string `json:"pinnedBy,omitempty"`
+}
+
+// PullsDump is registry internal view of image pulls.
+type PullsDump struct {
+	// MaxConcurrent is a limit of concurrent downloads, zero if unlimited.
+	MaxConcurrent int          `json:"maxConcurrent"`
+	Active        int          `json:"active"`
+	InProgress    []PullStatus `json:"inProgress,omitempty"`
+	Preloads      []string     `json:"preloads,omitempty"`
+}
+
+// DumpImages returns internal view of all images in the registry index.
+func (s *SingularityRegistry) DumpImages() []ImageDump {
+	var infos []*image.Info
+	s.images.Iterate(func(info *image.Info) {
+		infos = append(infos, info)
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	dump := make([]ImageDump, 0, len(infos))
+	for _, info := range infos {
+		dump = append(dump, ImageDump{
+			Info:     info,
+			UsedBy:   info.UsedBy(),
+			Corrupt:  info.Corrupt(),
+			PinnedBy: s.pinSource(info),
+		})
+	}
+	return dump
+}
+
+// DumpPulls returns internal view of image pulls, including ones
+// that wait for a free download slot.
+func (s *SingularityRegistry) DumpPulls() PullsDump {
+	limit, active := s.pulls.get()
+	return PullsDump{
+		MaxConcurrent: limit,
+		Active:        active,
+		InProgress:    s.PullsInProgress(),
+		Preloads:      s.preloads.pinned(),
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/metrics"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+)
+
+// DefaultImageGCInterval is how often image storage usage is checked
+// when local image GC is enabled without an explicit interval.
+const DefaultImageGCInterval = time.Minute
+
+var (
+	gcRemovedImages = metrics.NewCounter("sycri_image_gc_removed_images_total",
+		"Number of images removed by local image GC.")
+	gcReclaimedBytes = metrics.NewCounter("sycri_image_gc_reclaimed_bytes_total",
+		"Space in bytes reclaimed by local image GC.")
+	gcReclaimableBytes = metrics.NewGauge("sycri_image_gc_dry_run_reclaimable_bytes",
+		"Space in bytes local image GC would reclaim on the last check in dry run mode.")
+	gcWatermark = metrics.NewGauge("sycri_image_gc_watermark_percent",
+		"Image storage usage percent local image GC watermarks are set to, zero when GC is disabled.", "watermark")
+	storageUsagePercent = metrics.NewGauge("sycri_image_storage_usage_percent",
+		"Image storage filesystem usage percent on the last local image GC check.")
+)
+
+// ImageGC is a local image garbage collection policy. Once image storage
+// filesystem usage exceeds HighWatermark percent, least recently used images
+// are removed until usage drops to LowWatermark percent. Images that are
+// pinned or used by containers are never collected. In DryRun mode images
+// that would be removed are logged, but are kept.
+type ImageGC struct {
+	HighWatermark int
+	LowWatermark  int
+	Interval      time.Duration
+	DryRun        bool
+}
+
+// Validate checks that watermarks are meaningful percents.
+func (gc ImageGC) Validate() error {
+	if gc.LowWatermark <= 0 || gc.LowWatermark >= gc.HighWatermark || gc.HighWatermark > 100 {
+		return fmt.Errorf("invalid image GC watermarks %d%%/%d%%: 0 < low < high <= 100 is required",
+			gc.HighWatermark, gc.LowWatermark)
+	}
+	if gc.Interval < 0 {
+		return fmt.Errorf("invalid image GC interval %s", gc.Interval)
+	}
+	return nil
+}
+
+// WithImageGC enables local image garbage collection. It is disabled by
+// default so that CRI does not compete with kubelet image GC.
+func WithImageGC(gc ImageGC) Option {
+	return func(r *SingularityRegistry) {
+		if gc.Interval == 0 {
+			gc.Interval = DefaultImageGCInterval
+		}
+		r.gc = &gc
+	}
+}
+
+// gcStats holds totals of images removed by local image GC.
+type gcStats struct {
+	mu        sync.Mutex
+	removed   int
+	reclaimed uint64
+}
+
+func (st *gcStats) add(reclaimed uint64) {
+	st.mu.Lock()
+	st.removed++
+	st.reclaimed += reclaimed
+	st.mu.Unlock()
+}
+
+func (st *gcStats) get() (int, uint64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.removed, st.reclaimed
+}
+
+// ImageGCPolicy returns current local image GC policy, nil when it is disabled.
+func (s *SingularityRegistry) ImageGCPolicy() *ImageGC {
+	s.gcMu.RLock()
+	defer s.gcMu.RUnlock()
+	if s.gc == nil {
+		return nil
+	}
+	gc := *s.gc
+	return &gc
+}
+
+// SetImageGC replaces local image GC watermarks and dry run mode, nil disables GC. Interval
+// of storage usage checks is set on registry creation and is kept.
+func (s *SingularityRegistry) SetImageGC(gc *ImageGC) error {
+	s.gcMu.Lock()
+	defer s.gcMu.Unlock()
+	if gc == nil {
+		s.gc = nil
+		return nil
+	}
+	policy := *gc
+	policy.Interval = s.gcInterval
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	s.gc = &policy
+	return nil
+}
+
+// runGC collects images periodically until ctx is cancelled.
+// Usage is checked only while GC is enabled.
+func (s *SingularityRegistry) runGC(ctx context.Context) {
+	if gc := s.ImageGCPolicy(); gc != nil {
+		glog.Infof("Local image GC is enabled: high watermark %d%%, low watermark %d%%, dry run %t",
+			gc.HighWatermark, gc.LowWatermark, gc.DryRun)
+	}
+	ticker := time.NewTicker(s.gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.collectImages()
+	}
+}
+
+// collectImages removes least recently used images while image storage
+// usage is above the low watermark, provided it exceeded the high one.
+// It returns number of bytes reclaimed.
+// In dry run mode nothing is removed and the space that would be reclaimed
+// is returned instead.
+func (s *SingularityRegistry) collectImages() uint64 {
+	gc := s.ImageGCPolicy()
+	if gc == nil {
+		gcWatermark.Set(0, "high")
+		gcWatermark.Set(0, "low")
+		return 0
+	}
+	gcWatermark.Set(float64(gc.HighWatermark), "high")
+	gcWatermark.Set(float64(gc.LowWatermark), "low")
+	usage, err := s.storageUsage()
+	if err != nil {
+		glog.Errorf("Could not check image storage usage: %v", err)
+		return 0
+	}
+	if gc.DryRun {
+		gcReclaimableBytes.Set(0)
+	}
+	if usage <= gc.HighWatermark {
+		return 0
+	}
+	glog.V(2).Infof("Image storage usage %d%% is above %d%% high watermark, collecting images",
+		usage, gc.HighWatermark)
+	if gc.DryRun {
+		return s.dryRunCollect(gc)
+	}
+
+	var total uint64
+	for _, info := range s.gcCandidates() {
+		freed, err := s.removeImage(info)
+		if err == image.ErrIsUsed {
+			glog.V(4).Infof("Skipping image %s collection: %v", info.ID, err)
+			continue
+		}
+		if err != nil {
+			glog.Errorf("Could not collect image %s: %v", info.ID, err)
+			continue
+		}
+		total += freed
+		s.gcStats.add(freed)
+		gcRemovedImages.Inc()
+		gcReclaimedBytes.Add(float64(freed))
+		glog.Infof("Image GC removed image %s (last used: %s), reclaimed %s",
+			info.ID, formatLastUsed(info.LastUsed()), formatBytes(freed))
+
+		usage, err = s.storageUsage()
+		if err != nil {
+			glog.Errorf("Could not check image storage usage: %v", err)
+			return total
+		}
+		if usage <= gc.LowWatermark {
+			return total
+		}
+	}
+	glog.Warningf("Image storage usage %d%% is above %d%% low watermark, no more images can be collected",
+		usage, gc.LowWatermark)
+	return total
+}
+
+// dryRunCollect logs images collectImages would remove and returns space
+// their removal would reclaim. As nothing is removed, storage usage is
+// estimated from image sizes.
+func (s *SingularityRegistry) dryRunCollect(gc *ImageGC) uint64 {
+	free, total, err := s.space(s.storage)
+	if err != nil {
+		glog.Errorf("Could not check image storage usage: %v", err)
+		return 0
+	}
+	if total == 0 || free > total {
+		return 0
+	}
+	used := total - free
+	var reclaimable uint64
+	for _, info := range s.gcCandidates() {
+		size := info.Size
+		if size > used {
+			size = used
+		}
+		used -= size
+		reclaimable += size
+		glog.Infof("Image GC dry run: would remove image %s (last used: %s), would reclaim %s",
+			info.ID, formatLastUsed(info.LastUsed()), formatBytes(size))
+		if int(used*100/total) <= gc.LowWatermark {
+			break
+		}
+	}
+	gcReclaimableBytes.Set(float64(reclaimable))
+	if int(used*100/total) > gc.LowWatermark {
+		glog.Warningf("Image storage usage would stay above %d%% low watermark, no more images can be collected",
+			gc.LowWatermark)
+	}
+	return reclaimable
+}
+
+// gcCandidates returns images that may be collected, least recently used first.
+// Images that were never used by containers are ordered by size, largest first.
+func (s *SingularityRegistry) gcCandidates() []*image.Info {
+	var all []*image.Info
+	s.images.Iterate(func(info *image.Info) {
+		all = append(all, info)
+	})
+
+	candidates := all[:0]
+	for _, info := range all {
+		if info.Ref.URI() == singularity.LocalFileDomain || len(info.UsedBy()) != 0 {
+			continue
+		}
+		if s.isPinned(info) || !s.preloads.pinnedUntil(info.ID).IsZero() {
+			continue
+		}
+		candidates = append(candidates, info)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ti, tj := candidates[i].LastUsed(), candidates[j].LastUsed()
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return candidates[i].Size > candidates[j].Size
+	})
+	return candidates
+}
+
+// storageUsage returns image storage filesystem usage in percent.
+func (s *SingularityRegistry) storageUsage() (int, error) {
+	free, total, err := s.space(s.storage)
+	if err != nil {
+		return 0, err
+	}
+	if total == 0 || free > total {
+		return 0, nil
+	}
+	usage := int((total - free) * 100 / total)
+	storageUsagePercent.Set(float64(usage))
+	return usage, nil
+}
+
+func formatLastUsed(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	"github.com/sylabs/singularity-cri/pkg/metrics"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestImageGC_Validate(t *testing.T) {
+	tt := []struct {
+		name        string
+		gc          ImageGC
+		expectError bool
+	}{
+		{name: "valid", gc: ImageGC{HighWatermark: 85, LowWatermark: 75}},
+		{name: "full disk", gc: ImageGC{HighWatermark: 100, LowWatermark: 99}},
+		{name: "low above high", gc: ImageGC{HighWatermark: 75, LowWatermark: 85}, expectError: true},
+		{name: "zero low", gc: ImageGC{HighWatermark: 10}, expectError: true},
+		{name: "high above 100", gc: ImageGC{HighWatermark: 110, LowWatermark: 85}, expectError: true},
+		{name: "negative interval", gc: ImageGC{HighWatermark: 85, LowWatermark: 75, Interval: -time.Second}, expectError: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.gc.Validate()
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestCollectImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+	blobs, err := image.NewBlobStore(filepath.Join(dir, blobStoreDir))
+	require.NoError(t, err)
+
+	registry := &SingularityRegistry{
+		storage:  dir,
+		images:   index.NewImageIndex(),
+		blobs:    blobs,
+		preloads: newPreloader(nil, time.Hour),
+		gc:       &ImageGC{HighWatermark: 90, LowWatermark: 60},
+	}
+	// filesystem has 100 bytes with 20 bytes used by other files
+	registry.space = func(string) (uint64, uint64, error) {
+		used := uint64(20)
+		registry.images.Iterate(func(info *image.Info) {
+			used += info.Size
+		})
+		return 100 - used, 100, nil
+	}
+
+	now := time.Now()
+	images := []struct {
+		id       string
+		ref      string
+		size     uint64
+		lastUsed time.Time
+	}{
+		{id: "old", ref: "gcr.io/foo/old:1", size: 10, lastUsed: now.Add(-time.Hour)},
+		{id: "unused", ref: "gcr.io/foo/unused:1", size: 30},
+		{id: "running", ref: "gcr.io/foo/running:1", size: 10},
+		{id: "pinned", ref: "gcr.io/foo/pinned:1", size: 10},
+		{id: "preloaded", ref: "gcr.io/foo/preloaded:1", size: 10},
+		{id: "recent", ref: "gcr.io/foo/recent:1", size: 10, lastUsed: now},
+	}
+	for _, img := range images {
+		ref, err := image.ParseRef(img.ref)
+		require.NoError(t, err)
+		path := filepath.Join(dir, img.id)
+		require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+		info := &image.Info{ID: img.id, Path: path, Size: img.size, Ref: ref}
+		if !img.lastUsed.IsZero() {
+			info.MarkUsed(img.lastUsed)
+		}
+		require.NoError(t, registry.images.Add(info))
+	}
+	running, err := registry.images.Find("running")
+	require.NoError(t, err)
+	running.Borrow("container")
+	require.NoError(t, registry.SetPinnedImages([]string{"gcr.io/foo/pinned:1"}))
+	registry.preloads.pins["preloaded"] = now.Add(time.Hour)
+
+	// never used image goes first, then the least recently used one
+	require.Equal(t, uint64(40), registry.collectImages())
+	for _, id := range []string{"old", "unused"} {
+		_, err := registry.images.Find(id)
+		require.Equal(t, index.ErrNotFound, err, id)
+	}
+	for _, id := range []string{"running", "pinned", "preloaded", "recent"} {
+		_, err := registry.images.Find(id)
+		require.NoError(t, err, id)
+	}
+
+	// usage is below high watermark now
+	require.Equal(t, uint64(0), registry.collectImages())
+
+	resp, err := registry.ImageStatus(context.Background(), &k8s.ImageStatusRequest{
+		Image:   &k8s.ImageSpec{Image: "recent"},
+		Verbose: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "90%", resp.Info["gcHighWatermark"])
+	require.Equal(t, "60%", resp.Info["gcLowWatermark"])
+	require.Equal(t, "2", resp.Info["gcRemovedImages"])
+	require.Equal(t, "40", resp.Info["gcReclaimedBytes"])
+	require.Equal(t, now.Format(time.RFC3339), resp.Info["lastUsed"])
+
+	// pinned and used images are kept even if usage stays above low watermark
+	registry.space = func(string) (uint64, uint64, error) {
+		return 5, 100, nil
+	}
+	require.Equal(t, uint64(10), registry.collectImages())
+	_, err = registry.images.Find("recent")
+	require.Equal(t, index.ErrNotFound, err)
+	for _, id := range []string{"running", "pinned", "preloaded"} {
+		_, err := registry.images.Find(id)
+		require.NoError(t, err, id)
+	}
+}
+
+func TestCollectImages_DryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	registry := &SingularityRegistry{
+		storage:  dir,
+		images:   index.NewImageIndex(),
+		preloads: newPreloader(nil, time.Hour),
+		gc:       &ImageGC{HighWatermark: 60, LowWatermark: 40, DryRun: true},
+	}
+	// filesystem has 100 bytes with 20 bytes used by other files
+	registry.space = func(string) (uint64, uint64, error) {
+		used := uint64(20)
+		registry.images.Iterate(func(info *image.Info) {
+			used += info.Size
+		})
+		return 100 - used, 100, nil
+	}
+
+	now := time.Now()
+	images := []struct {
+		id       string
+		ref      string
+		size     uint64
+		lastUsed time.Time
+	}{
+		{id: "old", ref: "gcr.io/foo/old:1", size: 10, lastUsed: now.Add(-time.Hour)},
+		{id: "unused", ref: "gcr.io/foo/unused:1", size: 30},
+		{id: "recent", ref: "gcr.io/foo/recent:1", size: 10, lastUsed: now},
+	}
+	for _, img := range images {
+		ref, err := image.ParseRef(img.ref)
+		require.NoError(t, err)
+		info := &image.Info{ID: img.id, Path: filepath.Join(dir, img.id), Size: img.size, Ref: ref}
+		if !img.lastUsed.IsZero() {
+			info.MarkUsed(img.lastUsed)
+		}
+		require.NoError(t, registry.images.Add(info))
+	}
+
+	// removal of the never used image would be enough to reach low watermark
+	require.Equal(t, uint64(30), registry.collectImages())
+	for _, img := range images {
+		_, err := registry.images.Find(img.id)
+		require.NoError(t, err, img.id)
+	}
+	removed, reclaimed := registry.gcStats.get()
+	require.Zero(t, removed)
+	require.Zero(t, reclaimed)
+
+	var buf bytes.Buffer
+	_, err = metrics.Default.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "sycri_image_gc_dry_run_reclaimable_bytes 30\n")
+	require.Contains(t, buf.String(), "sycri_image_storage_usage_percent 70\n")
+	require.Contains(t, buf.String(), `sycri_image_gc_watermark_percent{watermark="high"} 60`)
+}
+
+func TestSetImageGC(t *testing.T) {
+	registry := &SingularityRegistry{gcInterval: time.Minute}
+	require.Nil(t, registry.ImageGCPolicy())
+	require.Zero(t, registry.collectImages(), "disabled GC must not check storage")
+
+	require.Error(t, registry.SetImageGC(&ImageGC{HighWatermark: 60, LowWatermark: 90}))
+	require.Nil(t, registry.ImageGCPolicy(), "invalid policy must not be set")
+
+	require.NoError(t, registry.SetImageGC(&ImageGC{HighWatermark: 90, LowWatermark: 60, Interval: time.Second}))
+	require.Equal(t, &ImageGC{HighWatermark: 90, LowWatermark: 60, Interval: time.Minute}, registry.ImageGCPolicy(),
+		"check interval must be kept")
+
+	require.NoError(t, registry.SetImageGC(nil))
+	require.Nil(t, registry.ImageGCPolicy())
+}
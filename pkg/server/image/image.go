@@ -15,119 +15,455 @@
 package image
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/sylabs/scs-key-client/client"
 	"github.com/sylabs/singularity-cri/pkg/fs"
 	"github.com/sylabs/singularity-cri/pkg/image"
 	"github.com/sylabs/singularity-cri/pkg/index"
+	"github.com/sylabs/singularity-cri/pkg/keys"
+	"github.com/sylabs/singularity-cri/pkg/metrics"
 	"github.com/sylabs/singularity-cri/pkg/singularity"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
-const registryInfoFile = "registry.json"
+const (
+	registryInfoFile = "registry.json"
+	blobStoreDir     = "blobs"
+	quarantineDir    = "quarantine"
+
+	// pin sources reported in verbose image status
+	pinByConfig  = "config"
+	pinByPreload = "preload"
+)
+
+var (
+	pullDuration = metrics.NewHistogram("sycri_image_pull_duration_seconds",
+		"Time image pulls took, including pulls of already present images.", metrics.PullBuckets, "code")
+	pullBytes = metrics.NewCounter("sycri_image_pull_bytes_total",
+		"Size of pulled images in bytes.")
+)
 
 // SingularityRegistry implements k8s ImageService interface.
 type SingularityRegistry struct {
 	storage string // path to image storage without trailing slash
+	scratch string // path to pull and conversion temporary files
 	images  *index.ImageIndex
+	blobs   *image.BlobStore
+
+	quarantine *image.Quarantine
+
+	skipDigestCheck bool
+	skipEngineCheck bool
+	ociLayout       bool
+	releaseImage    func(imageID string) bool
+	credentials     *image.CredentialStore
+	sigPolicy       image.SignaturePolicy
+	trustRules      []image.TrustRule
+	keyServer       string
+	verifier        *image.Verifier
+
+	stallTimeout  time.Duration
+	reserve       StorageReserve
+	space         func(path string) (uint64, uint64, error)
+	spaceInterval time.Duration
+	usageInterval time.Duration
+
+	pinTTL   time.Duration
+	preloads *preloader
+	warm     []string
+
+	gcMu       sync.RWMutex
+	gc         *ImageGC
+	gcInterval time.Duration
+	gcStats    gcStats
+
+	throttle       *image.Throttle
+	pulls          *pullSlots
+	layerDownloads int
+	progress       *pullTracker
+
+	stopBackground context.CancelFunc
+
+	pinMu  sync.RWMutex
+	pinned []string
 
 	m        sync.Mutex
-	infoFile *os.File
+	infoPath string
 }
 
-// NewSingularityRegistry initializes and returns SingularityRuntime.
-// Singularity must be installed on the host otherwise it will return an error.
-func NewSingularityRegistry(storePath string, index *index.ImageIndex) (*SingularityRegistry, error) {
-	_, err := exec.LookPath(singularity.RuntimeName)
-	if err != nil {
-		return nil, fmt.Errorf("could not find %s on this machine: %v", singularity.RuntimeName, err)
+// Option is a type representing functional option for SingularityRegistry.
+type Option func(r *SingularityRegistry)
+
+// WithImageRelease sets function that is called before image file is removed
+// so that runtime can unmount image root filesystem it no longer uses. When
+// release returns false image is considered to be used and is not removed.
+func WithImageRelease(release func(imageID string) bool) Option {
+	return func(r *SingularityRegistry) {
+		r.releaseImage = release
+	}
+}
+
+// WithoutDigestCheck disables resolving docker tags into digests before pull,
+// so that an image is always downloaded again even when it hasn't changed.
+func WithoutDigestCheck() Option {
+	return func(r *SingularityRegistry) {
+		r.skipDigestCheck = true
+	}
+}
+
+// WithOCILayout makes docker images stored in OCI layout instead of being
+// converted into SIF on pull, see image.WithOCILayout.
+func WithOCILayout() Option {
+	return func(r *SingularityRegistry) {
+		r.ociLayout = true
+	}
+}
+
+// WithAuthFile sets docker-style config file with node-level registry
+// credentials that are used when PullImage request has no auth config.
+func WithAuthFile(path string) Option {
+	return func(r *SingularityRegistry) {
+		if path != "" {
+			r.credentials = image.NewCredentialStore(path)
+		}
+	}
+}
+
+// WithPreloadPinTTL sets time preloaded images cannot be removed for.
+// Non-positive TTL disables pinning of preloaded images.
+func WithPreloadPinTTL(ttl time.Duration) Option {
+	return func(r *SingularityRegistry) {
+		r.pinTTL = ttl
+	}
+}
+
+// WithPinnedImages sets references of images that cannot be removed.
+func WithPinnedImages(refs []string) Option {
+	return func(r *SingularityRegistry) {
+		r.pinned = refs
+	}
+}
+
+// WithWarmImages sets references of images that are preloaded on startup
+// and cannot be removed. Failed preloads are retried with backoff.
+func WithWarmImages(refs []string) Option {
+	return func(r *SingularityRegistry) {
+		r.warm = refs
+	}
+}
+
+// WithPullStallTimeout sets time pull may transfer no data for before it is
+// aborted. Non-positive timeout disables stall detection. Overrides
+// image.DefaultStallTimeout.
+func WithPullStallTimeout(timeout time.Duration) Option {
+	return func(r *SingularityRegistry) {
+		r.stallTimeout = timeout
+	}
+}
+
+// WithSignaturePolicy sets which signatures of pulled SIF images must be
+// verified, image.SignaturePolicyAny is used by default.
+func WithSignaturePolicy(policy image.SignaturePolicy) Option {
+	return func(r *SingularityRegistry) {
+		r.sigPolicy = policy
+	}
+}
+
+// WithTrustRules sets per registry and per namespace signature requirements
+// of pulled images, e.g. to reject unsigned images or accept only images
+// signed with specific keys.
+func WithTrustRules(rules []image.TrustRule) Option {
+	return func(r *SingularityRegistry) {
+		r.trustRules = rules
+	}
+}
+
+// WithKeyServer sets key server signing keys missing in the local keyring
+// are fetched from. Empty value means singularity.KeysServer.
+func WithKeyServer(url string) Option {
+	return func(r *SingularityRegistry) {
+		if url != "" {
+			r.keyServer = url
+		}
+	}
+}
+
+// WithoutEngineCheck lets registry start when Singularity is not installed,
+// which is the case for fake engine. Only local SIF files can be pulled then.
+func WithoutEngineCheck() Option {
+	return func(r *SingularityRegistry) {
+		r.skipEngineCheck = true
 	}
+}
 
-	storePath, err = filepath.Abs(storePath)
+// NewSingularityRegistry initializes and returns SingularityRuntime.
+// Singularity must be installed on the host otherwise it will return an error,
+// unless WithoutEngineCheck is passed.
+func NewSingularityRegistry(storePath string, index *index.ImageIndex, opts ...Option) (*SingularityRegistry, error) {
+	storePath, err := filepath.Abs(storePath)
 	if err != nil {
 		return nil, fmt.Errorf("could not get absolute storage directory path: %v", err)
 	}
 
 	registry := SingularityRegistry{
-		storage: storePath,
-		images:  index,
+		storage:       storePath,
+		images:        index,
+		pinTTL:        DefaultPreloadPinTTL,
+		stallTimeout:  image.DefaultStallTimeout,
+		reserve:       DefaultStorageReserve,
+		space:         fs.Space,
+		spaceInterval: spaceCheckInterval,
+		usageInterval: DefaultUsageRefreshInterval,
+		sigPolicy:     image.SignaturePolicyAny,
+		keyServer:     singularity.KeysServer,
+		throttle:      image.NewThrottle(image.PullLimits{}),
+		pulls:         newPullSlots(),
+		progress:      newPullTracker(),
+	}
+	for _, o := range opts {
+		o(&registry)
+	}
+	keyClient, err := keys.NewClient(&client.Config{BaseURL: registry.keyServer})
+	if err != nil {
+		return nil, fmt.Errorf("could not create key server client: %v", err)
+	}
+	registry.verifier = image.NewVerifier(registry.sigPolicy, keyClient, registry.trustRules...)
+	if !registry.skipEngineCheck {
+		if _, err := exec.LookPath(singularity.RuntimeName); err != nil {
+			return nil, fmt.Errorf("could not find %s on this machine: %v", singularity.RuntimeName, err)
+		}
+	}
+	if err := registry.SetPinnedImages(registry.pinned); err != nil {
+		return nil, err
+	}
+	registry.preloads = newPreloader(registry.pullImage, registry.pinTTL)
+	for _, ref := range registry.warm {
+		parsed, err := image.ParseRef(ref)
+		if err != nil {
+			return nil, fmt.Errorf("invalid warm image %q: %v", ref, err)
+		}
+		registry.preloads.enqueue(parsed.String(), nil, preloadOptions{pin: true, retry: true})
 	}
 
 	if err := os.MkdirAll(storePath, 0755); err != nil {
 		return nil, fmt.Errorf("could not create storage directory: %v", err)
 	}
-	registry.infoFile, err = os.OpenFile(filepath.Join(storePath, registryInfoFile), os.O_CREATE|os.O_RDWR, 0644)
+	if registry.scratch == "" {
+		registry.scratch = filepath.Join(storePath, scratchDirName)
+	}
+	if err := registry.prepareScratch(); err != nil {
+		return nil, err
+	}
+	registry.blobs, err = image.NewBlobStore(filepath.Join(storePath, blobStoreDir))
 	if err != nil {
-		return nil, fmt.Errorf("could not open registry backup file: %v", err)
+		return nil, err
+	}
+	registry.quarantine, err = image.NewQuarantine(filepath.Join(storePath, quarantineDir))
+	if err != nil {
+		return nil, err
 	}
+	registry.infoPath = filepath.Join(storePath, registryInfoFile)
 	err = registry.loadInfo()
 	if err != nil {
 		return nil, err
 	}
+	registry.images.TrackUsage(registry.blobs.Dir())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	registry.stopBackground = cancel
+	go registry.preloads.run(ctx)
+	registry.gcInterval = DefaultImageGCInterval
+	if registry.gc != nil {
+		registry.gcInterval = registry.gc.Interval
+	}
+	go registry.runGC(ctx)
+	go registry.refreshUsage(ctx)
 	return &registry, nil
 }
 
 // Shutdown should be called whenever SingularityRegistry is no longer
 // used to make sure allocated resources are freed.
 func (s *SingularityRegistry) Shutdown() error {
-	s.m.Lock()
-	defer s.m.Unlock()
-
-	if err := s.infoFile.Close(); err != nil {
-		return fmt.Errorf("could not close infoFile: %v", err)
-	}
+	s.stopBackground()
 	return nil
 }
 
 // PullImage pulls an image with authentication config.
 func (s *SingularityRegistry) PullImage(ctx context.Context, req *k8s.PullImageRequest) (*k8s.PullImageResponse, error) {
-	ref, err := image.ParseRef(req.Image.Image)
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+	done := s.preloads.kubeletPullStarted()
+	defer done()
+	return s.pullImage(ctx, req)
+}
+
+// pullImage is a pull path shared by kubelet pulls and preloads.
+func (s *SingularityRegistry) pullImage(ctx context.Context, req *k8s.PullImageRequest) (*k8s.PullImageResponse, error) {
+	start := time.Now()
+	resp, err := s.doPullImage(ctx, req)
+	pullDuration.Observe(time.Since(start).Seconds(), status.Code(err).String())
+	return resp, err
+}
+
+func (s *SingularityRegistry) doPullImage(ctx context.Context, req *k8s.PullImageRequest) (*k8s.PullImageResponse, error) {
+	ref, err := image.ParseRef(req.GetImage().GetImage())
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "could not parse image reference: %v", err)
 	}
 
-	info, err := image.LibraryInfo(ctx, ref, req.GetAuth())
+	auth, err := image.DecodeAuth(req.GetAuth())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "could not use pull credentials: %v", err)
+	}
+	if isEmptyAuth(auth) && s.credentials != nil {
+		nodeAuth, err := s.credentials.Lookup(ctx, ref)
+		if err != nil {
+			glog.Errorf("Could not lookup node credentials for %s: %v", ref, err)
+		}
+		if nodeAuth != nil {
+			auth = nodeAuth
+		}
+	}
+
+	remoteInfo, err := image.LibraryInfo(ctx, ref, auth)
 	if err == image.ErrNotFound {
 		return nil, status.Errorf(codes.NotFound, "image %s is not found", ref)
 	}
 	if err != nil && err != image.ErrNotLibrary {
 		return nil, status.Errorf(codes.Internal, "could not get %s image metadata: %v", ref, err)
 	}
-	if info != nil {
-		_, err := s.images.Find(info.Sha256)
-		if err == nil {
+	if remoteInfo != nil {
+		existing, err := s.images.Find(remoteInfo.Sha256)
+		if err == nil && existing.Corrupt() == "" {
 			glog.V(2).Infof("Image %s is already present with the same checksum, skipping pull", ref)
 			return &k8s.PullImageResponse{
-				ImageRef: info.ID,
+				ImageRef: remoteInfo.ID,
 			}, nil
 		}
 	}
 
-	info, err = image.Pull(ctx, s.storage, ref, req.GetAuth())
+	var digest string
+	if !s.skipDigestCheck {
+		digest, err = image.RemoteDigest(ctx, ref, auth)
+		if err != nil && err != image.ErrNotDockerTag {
+			glog.V(2).Infof("Could not resolve %s digest, pulling image: %v", ref, err)
+		}
+		if digest != "" {
+			if id, ok := s.findDigest(ref, digest); ok {
+				glog.V(2).Infof("Image %s is already present with the same digest %s, skipping pull", ref, digest)
+				return &k8s.PullImageResponse{
+					ImageRef: id,
+				}, nil
+			}
+			glog.V(2).Infof("Image %s digest %s is not found locally, pulling image", ref, digest)
+		}
+	}
+
+	var layers []image.Layer
+	if remoteInfo == nil {
+		layers, err = image.RemoteLayers(ctx, ref, auth)
+		if err != nil && err != image.ErrNotDockerImage {
+			glog.V(2).Infof("Could not fetch %s layers: %v", ref, err)
+		}
+	}
+
+	if err := image.CheckRateLimit(ref, auth); err != nil {
+		return nil, status.Errorf(codes.ResourceExhausted, "%v", err)
+	}
+	release, err := s.pulls.acquire(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Canceled, "pull of %s is cancelled while waiting for a free slot: %v", ref, err)
+	}
+	defer release()
+
+	pullCtx := ctx
+	stopWatch := func() bool { return false }
+	if ref.URI() != singularity.LocalFileDomain {
+		if err := s.admitPull(ref, estimatePullSize(remoteInfo, layers)); err != nil {
+			return nil, err
+		}
+		pullCtx, stopWatch = s.watchSpace(ctx, ref)
+	}
+	progress := image.NewProgress(expectedDownload(remoteInfo, layers))
+	untrack := s.progress.start(ref, progress)
+	pullOpts := []image.PullOption{
+		image.WithCacheDir(s.blobs.Dir()), image.WithScratchDir(s.scratch), image.WithStallTimeout(s.stallTimeout),
+		image.WithThrottle(s.throttle), image.WithProgress(progress), image.WithQuarantine(s.quarantine),
+	}
+	if s.ociLayout {
+		pullOpts = append(pullOpts, image.WithOCILayout(), image.WithConcurrentDownloads(s.layerDownloads))
+	}
+	info, err := image.Pull(pullCtx, s.storage, ref, auth, pullOpts...)
+	untrack()
+	if exhausted := stopWatch(); exhausted && err != nil {
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"pull of %s is aborted: free space in %s dropped below half of storage reserve", ref, s.storage)
+	}
+	switch err.(type) {
+	case *image.StallError:
+		return nil, status.Errorf(codes.Aborted, "%v", err)
+	case *image.RateLimitError:
+		return nil, status.Errorf(codes.ResourceExhausted, "could not pull image: %v", err)
+	}
+	if err != nil && ctx.Err() != nil {
+		done, _ := progress.Get()
+		glog.Warningf("Pull of %s is cancelled after %s downloaded: %v", ref, formatBytes(uint64(done)), ctx.Err())
+		code := codes.Canceled
+		if ctx.Err() == context.DeadlineExceeded {
+			code = codes.DeadlineExceeded
+		}
+		return nil, status.Errorf(code, "pull of %s is cancelled: %v", ref, ctx.Err())
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not pull image: %v", err)
 	}
-	if err := info.Verify(); err != nil {
+	if remoteInfo != nil {
+		if err := info.VerifyChecksum(remoteInfo.Sha256); err != nil {
+			if _, qErr := info.Quarantine(s.quarantine, err.Error()); qErr != nil {
+				glog.Errorf("Could not quarantine pulled image: %v", qErr)
+				info.Remove()
+			}
+			return nil, status.Errorf(codes.DataLoss, "%v", err)
+		}
+	}
+	if digest != "" {
+		info.Ref.AddDigests([]string{digest})
+	}
+	if info.SourceFormat != image.SourceOCILayout {
+		// layout keeps its own blobs, they are not shared via blob store
+		info.Layers = layers
+	}
+	if err := s.verifier.Verify(ctx, info); err != nil {
 		info.Remove()
 		return nil, status.Errorf(codes.InvalidArgument, "could not verify image: %v", err)
 	}
+	if existing, err := s.images.Find(info.ID); err == nil && existing.Corrupt() != "" {
+		// pulled image has replaced the corrupted file
+		glog.V(2).Infof("Corrupted image %s was pulled again", existing.ID)
+		existing.ClearCorrupt()
+	}
 	if err = s.images.Add(info); err != nil {
 		info.Remove()
 		return nil, status.Errorf(codes.Internal, "could not index image: %v", err)
 	}
+	pullBytes.Add(float64(info.Size))
+	s.blobs.Retain(info.ID, info.Layers)
 	if err = s.dumpInfo(); err != nil {
 		glog.Errorf("Could not dump registry info: %v", err)
 	}
@@ -136,36 +472,155 @@ func (s *SingularityRegistry) PullImage(ctx context.Context, req *k8s.PullImageR
 	}, nil
 }
 
-// RemoveImage removes the image.
-// This call is idempotent, and does not return an error if the image has already been removed.
+// SetPinnedImages replaces references of images that cannot be removed.
+// Pinned images may not be pulled yet, they are matched on each removal.
+func (s *SingularityRegistry) SetPinnedImages(refs []string) error {
+	for _, ref := range refs {
+		if strings.TrimSpace(ref) == "" {
+			return fmt.Errorf("pinned image reference cannot be empty")
+		}
+	}
+	s.pinMu.Lock()
+	s.pinned = refs
+	s.pinMu.Unlock()
+	return nil
+}
+
+// isPinned checks whether any of pinned references resolves to the passed image.
+func (s *SingularityRegistry) isPinned(info *image.Info) bool {
+	return s.pinSource(info) != ""
+}
+
+// pinSource returns what pins the passed image: config for images pinned
+// by node config, preload for images preloaded with pin, warm images included.
+// Empty string is returned when image is not pinned.
+func (s *SingularityRegistry) pinSource(info *image.Info) string {
+	resolves := func(refs []string) bool {
+		for _, ref := range refs {
+			pinned, err := s.images.Find(ref)
+			if err == nil && pinned.ID == info.ID {
+				return true
+			}
+		}
+		return false
+	}
+
+	s.pinMu.RLock()
+	byConfig := resolves(s.pinned)
+	s.pinMu.RUnlock()
+	if byConfig {
+		return pinByConfig
+	}
+	if resolves(s.preloads.pinned()) {
+		return pinByPreload
+	}
+	return ""
+}
+
+func isEmptyAuth(auth *k8s.AuthConfig) bool {
+	return auth.GetUsername() == "" && auth.GetPassword() == "" && auth.GetAuth() == "" &&
+		auth.GetIdentityToken() == "" && auth.GetRegistryToken() == ""
+}
+
+// findDigest looks for an image with the passed digest and, if found,
+// points ref tags to it. Returns ID of the found image.
+func (s *SingularityRegistry) findDigest(ref *image.Reference, digest string) (string, bool) {
+	info, err := s.images.Find(digest)
+	if err != nil || info.Corrupt() != "" {
+		return "", false
+	}
+	err = s.images.Add(&image.Info{
+		ID:  info.ID,
+		Ref: ref,
+	})
+	if err != nil {
+		glog.Errorf("Could not update image %s tags: %v", info.ID, err)
+		return "", false
+	}
+	if err = s.dumpInfo(); err != nil {
+		glog.Errorf("Could not dump registry info: %v", err)
+	}
+	return info.ID, true
+}
+
+// RemoveImage removes the image. When image is referenced by one of its tags
+// and has other tags only that tag is removed, otherwise image is removed
+// with all its references. This call is idempotent, and does not return an error if the image has already been removed.
 func (s *SingularityRegistry) RemoveImage(ctx context.Context, req *k8s.RemoveImageRequest) (*k8s.RemoveImageResponse, error) {
-	info, err := s.images.Find(req.Image.Image)
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+	info, err := s.images.Find(req.GetImage().GetImage())
 	if err == index.ErrNotFound {
 		return &k8s.RemoveImageResponse{}, nil
 	}
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "could not find image: %v", err)
 	}
-	err = info.Remove()
+	if source := s.pinSource(info); source != "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "image %s is pinned by %s", info.ID, source)
+	}
+	if until := s.preloads.pinnedUntil(info.ID); !until.IsZero() {
+		return nil, status.Errorf(codes.FailedPrecondition, "image %s is preloaded and pinned until %s", info.ID, until.Format(time.RFC3339))
+	}
+	// removal by tag only untags image if it has other tags
+	untagged, err := s.images.Untag(req.GetImage().GetImage())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not untag image: %v", err)
+	}
+	if untagged {
+		glog.V(4).Infof("Removed tag %s of image %s", req.GetImage().GetImage(), info.ID)
+		if err = s.dumpInfo(); err != nil {
+			glog.Errorf("Could not dump registry info: %v", err)
+		}
+		return &k8s.RemoveImageResponse{}, nil
+	}
+	_, err = s.removeImage(info)
 	if err == image.ErrIsUsed {
 		return nil, status.Errorf(codes.FailedPrecondition, "unable to remove image: %v", err)
 	}
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "could not remove image: %v", err)
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	return &k8s.RemoveImageResponse{}, nil
+}
+
+// removeImage removes image content along with all its references and blobs
+// no longer used by other images. It returns number of bytes freed.
+// Image that is used by some container is not removed and ErrIsUsed is returned.
+func (s *SingularityRegistry) removeImage(info *image.Info) (uint64, error) {
+	if s.releaseImage != nil && !s.releaseImage(info.ID) {
+		return 0, image.ErrIsUsed
+	}
+	if err := info.Remove(); err != nil {
+		return 0, err
 	}
 	if err := s.images.Remove(info.ID); err != nil {
-		return nil, status.Errorf(codes.Internal, "could not remove image from index: %v", err)
+		return 0, fmt.Errorf("could not remove image from index: %v", err)
+	}
+	info.ClearCorrupt()
+	freed, err := s.blobs.Release(info.ID)
+	if err != nil {
+		glog.Errorf("Could not release image %s blobs: %v", info.ID, err)
+	}
+	if freed != 0 {
+		glog.V(4).Infof("Removed %s of blobs no longer used after image %s removal", formatBytes(freed), info.ID)
 	}
 	if err = s.dumpInfo(); err != nil {
 		glog.Errorf("Could not dump registry info: %v", err)
 	}
-	return &k8s.RemoveImageResponse{}, nil
+	return info.Size + freed, nil
 }
 
 // ImageStatus returns the status of the image. If the image is not
 // present, returns a response with ImageStatusResponse.Image set to nil.
+// Corrupted images are reported as missing so that kubelet pulls them
+// again, unless verbose status is requested which includes the reason.
 func (s *SingularityRegistry) ImageStatus(ctx context.Context, req *k8s.ImageStatusRequest) (*k8s.ImageStatusResponse, error) {
-	info, err := s.images.Find(req.Image.Image)
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+	info, err := s.images.Find(req.GetImage().GetImage())
 	if err == index.ErrNotFound {
 		return &k8s.ImageStatusResponse{}, nil
 	}
@@ -173,11 +628,92 @@ func (s *SingularityRegistry) ImageStatus(ctx context.Context, req *k8s.ImageSta
 		return nil, status.Errorf(codes.InvalidArgument, "could not find image: %v", err)
 	}
 
+	corrupt := info.Corrupt()
+	if corrupt != "" && !req.Verbose {
+		return &k8s.ImageStatusResponse{}, nil
+	}
+
 	var verboseInfo map[string]string
 	if req.Verbose {
 		verboseInfo = map[string]string{
 			"usedBy": fmt.Sprintf("%v", info.UsedBy()),
 		}
+		if corrupt != "" {
+			verboseInfo["corrupt"] = corrupt
+		}
+		if len(info.Layers) != 0 {
+			shared := 0
+			for _, layer := range info.Layers {
+				if s.blobs.Refs(layer.Digest) > 1 {
+					shared++
+				}
+			}
+			verboseInfo["sharedLayers"] = fmt.Sprintf("%d/%d", shared, len(info.Layers))
+		}
+		if len(info.Labels) != 0 {
+			labels, err := json.Marshal(info.Labels)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "could not marshal image labels: %v", err)
+			}
+			verboseInfo["labels"] = string(labels)
+		}
+		if info.DroppedLabels > 0 {
+			verboseInfo["droppedLabels"] = strconv.Itoa(info.DroppedLabels)
+		}
+		if ports := info.ExposedPorts(); len(ports) != 0 {
+			data, err := json.Marshal(ports)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "could not marshal image exposed ports: %v", err)
+			}
+			verboseInfo["exposedPorts"] = string(data)
+		}
+		if info.StopTimeout != 0 {
+			verboseInfo["stopTimeout"] = strconv.FormatInt(info.StopTimeout, 10)
+		}
+		if info.SourceFormat != "" {
+			verboseInfo["sourceFormat"] = info.SourceFormat
+		}
+		if info.Endpoint != "" {
+			verboseInfo["endpoint"] = info.Endpoint
+		}
+		if len(info.Signatures) != 0 {
+			signatures, err := json.Marshal(info.Signatures)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "could not marshal image signatures: %v", err)
+			}
+			verboseInfo["signatures"] = string(signatures)
+		}
+		verboseInfo["blobStoreSavedBytes"] = strconv.FormatUint(s.blobs.Savings(), 10)
+		if source := s.pinSource(info); source != "" {
+			verboseInfo["pinned"] = source
+		} else if until := s.preloads.pinnedUntil(info.ID); !until.IsZero() {
+			verboseInfo["pinned"] = until.Format(time.RFC3339)
+		}
+		if lastUsed := info.LastUsed(); !lastUsed.IsZero() {
+			verboseInfo["lastUsed"] = lastUsed.Format(time.RFC3339)
+		}
+		if entries := info.Warnings().Entries(); len(entries) != 0 {
+			data, err := json.Marshal(entries)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "could not marshal image warnings: %v", err)
+			}
+			verboseInfo["warnings"] = string(data)
+		}
+		if gc := s.ImageGCPolicy(); gc != nil {
+			removed, reclaimed := s.gcStats.get()
+			verboseInfo["gcHighWatermark"] = strconv.Itoa(gc.HighWatermark) + "%"
+			verboseInfo["gcLowWatermark"] = strconv.Itoa(gc.LowWatermark) + "%"
+			verboseInfo["gcDryRun"] = strconv.FormatBool(gc.DryRun)
+			verboseInfo["gcRemovedImages"] = strconv.Itoa(removed)
+			verboseInfo["gcReclaimedBytes"] = strconv.FormatUint(reclaimed, 10)
+		}
+		if s.throttle.Limited() {
+			throttle, err := json.Marshal(s.throttle.Info())
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "could not marshal pull throttle info: %v", err)
+			}
+			verboseInfo["pullThrottle"] = string(throttle)
+		}
 	}
 
 	var uid *k8s.Int64Value
@@ -214,93 +750,154 @@ func (s *SingularityRegistry) ImageStatus(ctx context.Context, req *k8s.ImageSta
 	}, nil
 }
 
-// ListImages lists existing images.
+// ListImages lists existing images. When filter specifies an image, at most
+// one image is returned which is resolved the same way as in ImageStatus.
 func (s *SingularityRegistry) ListImages(ctx context.Context, req *k8s.ListImagesRequest) (*k8s.ListImagesResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	var imgs []*k8s.Image
 	appendToResult := func(info *image.Info) {
-		if info.Matches(req.Filter) {
-			imgs = append(imgs, &k8s.Image{
-				Id:          info.ID,
-				RepoTags:    info.Ref.Tags(),
-				RepoDigests: info.Ref.Digests(),
-				Size_:       info.Size,
-			})
+		imgs = append(imgs, &k8s.Image{
+			Id:          info.ID,
+			RepoTags:    info.Ref.Tags(),
+			RepoDigests: info.Ref.Digests(),
+			Size_:       info.Size,
+		})
+	}
+
+	ref := req.GetFilter().GetImage().GetImage()
+	if ref == "" {
+		var infos []*image.Info
+		s.images.Iterate(func(info *image.Info) {
+			infos = append(infos, info)
+		})
+		// newer images go first, ID breaks ties so that order is stable
+		sort.Slice(infos, func(i, j int) bool {
+			if infos[i].CreatedAt != infos[j].CreatedAt {
+				return infos[i].CreatedAt > infos[j].CreatedAt
+			}
+			return infos[i].ID < infos[j].ID
+		})
+		for _, info := range infos {
+			appendToResult(info)
 		}
+		return &k8s.ListImagesResponse{
+			Images: imgs,
+		}, nil
+	}
+
+	info, err := s.images.Find(ref)
+	if err == nil {
+		appendToResult(info)
+	} else {
+		glog.V(4).Infof("No image matches filter %s: %v", ref, err)
 	}
-	s.images.Iterate(appendToResult)
 	return &k8s.ListImagesResponse{
 		Images: imgs,
 	}, nil
 }
 
-// ImageFsInfo returns information of the filesystem that is used to store images.
+// ImageFsInfo returns information of filesystems that are used to store images,
+// one entry per filesystem that holds image files or shared layer cache.
+// Usage is refreshed in background, see WithUsageRefreshInterval.
 // Note that local SIF images that were not pulled by CRI are not counted in this stat.
 func (s *SingularityRegistry) ImageFsInfo(context.Context, *k8s.ImageFsInfoRequest) (*k8s.ImageFsInfoResponse, error) {
-	fsInfo, err := fs.Usage(s.storage)
+	usage, err := s.images.Usage(s.usageInterval)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not get fs usage: %v", err)
 	}
 
-	fsUsage := &k8s.FilesystemUsage{
-		Timestamp: time.Now().UnixNano(),
-		FsId: &k8s.FilesystemIdentifier{
-			Mountpoint: fsInfo.MountPoint,
-		},
-		UsedBytes: &k8s.UInt64Value{
-			Value: uint64(fsInfo.Bytes),
-		},
-		InodesUsed: &k8s.UInt64Value{
-			Value: uint64(fsInfo.Inodes),
-		},
-	}
+	now := time.Now().UnixNano()
+	fsUsage := make([]*k8s.FilesystemUsage, 0, len(usage))
+	var used uint64
+	for _, u := range usage {
+		fsUsage = append(fsUsage, &k8s.FilesystemUsage{
+			Timestamp: now,
+			FsId: &k8s.FilesystemIdentifier{
+				Mountpoint: u.MountPoint,
+			},
+			UsedBytes: &k8s.UInt64Value{
+				Value: u.Bytes,
+			},
+			InodesUsed: &k8s.UInt64Value{
+				Value: u.Inodes,
+			},
+		})
+		used += u.Bytes
+		glog.V(5).Infof("Image storage uses %s and %d inodes on %s (uuid %q)",
+			formatBytes(u.Bytes), u.Inodes, u.MountPoint, u.UUID)
+	}
+	glog.V(4).Infof("Image storage uses %s, shared blobs saved %s",
+		formatBytes(used), formatBytes(s.blobs.Savings()))
 
 	return &k8s.ImageFsInfoResponse{
-		ImageFilesystems: []*k8s.FilesystemUsage{fsUsage},
+		ImageFilesystems: fsUsage,
 	}, nil
 }
 
 // loadInfo reads backup file and restores registry according to it.
+// Backup file that cannot be decoded, e.g. truncated by a power loss, is
+// quarantined and images decoded before the damaged entry are kept.
 func (s *SingularityRegistry) loadInfo() error {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	_, err := s.infoFile.Seek(0, io.SeekStart)
+	infoFile, err := os.Open(s.infoPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("could not seek registry info file: %v", err)
+		return fmt.Errorf("could not open registry backup file: %v", err)
 	}
-	dec := json.NewDecoder(s.infoFile)
+	defer infoFile.Close()
+	dec := json.NewDecoder(infoFile)
 
+	restored := 0
 	// while the array contains values
 	for dec.More() {
 		var info *image.Info
 		// decode an array value (Message)
 		err := dec.Decode(&info)
 		if err != nil {
-			return fmt.Errorf("could not decode image: %v", err)
+			glog.Warningf("Could not decode image from registry backup file: %v", err)
+			return s.quarantineInfo(restored)
 		}
 		err = s.images.Add(info)
 		if err != nil {
 			return fmt.Errorf("could not add decoded image to index: %v", err)
 		}
+		s.blobs.Retain(info.ID, info.Layers)
+		restored++
 	}
 
 	return nil
 }
 
+// quarantineInfo moves corrupt backup file aside and saves images
+// restored so far. It must be called with s.m held.
+func (s *SingularityRegistry) quarantineInfo(restored int) error {
+	path, err := fs.Quarantine(s.infoPath)
+	if err != nil {
+		return fmt.Errorf("could not quarantine registry backup file: %v", err)
+	}
+	glog.Warningf("Corrupt registry backup file was moved to %s, %d images restored", path, restored)
+	return s.writeInfo()
+}
+
 // dumpInfo dumps registry into backup file.
 func (s *SingularityRegistry) dumpInfo() error {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	_, err := s.infoFile.Seek(0, io.SeekStart)
-	if err != nil {
-		return fmt.Errorf("could not seek registry info file: %v", err)
-	}
-	err = s.infoFile.Truncate(0)
-	if err != nil {
-		return fmt.Errorf("could not reset file: %v", err)
-	}
-	enc := json.NewEncoder(s.infoFile)
+	return s.writeInfo()
+}
+
+// writeInfo atomically replaces backup file with the current registry.
+// It must be called with s.m held.
+func (s *SingularityRegistry) writeInfo() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
 	encodeToFile := func(info *image.Info) {
 		if info.Ref.URI() == singularity.LocalFileDomain {
 			return
@@ -308,5 +905,8 @@ func (s *SingularityRegistry) dumpInfo() error {
 		_ = enc.Encode(info)
 	}
 	s.images.Iterate(encodeToFile)
+	if err := fs.WriteFileAtomic(s.infoPath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("could not save registry backup file: %v", err)
+	}
 	return nil
 }
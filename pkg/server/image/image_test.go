@@ -0,0 +1,278 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestPinnedImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+	blobs, err := image.NewBlobStore(dir)
+	require.NoError(t, err)
+
+	registry := &SingularityRegistry{
+		images:   index.NewImageIndex(),
+		blobs:    blobs,
+		preloads: newPreloader(nil, time.Hour),
+	}
+	for id, ref := range map[string]string{
+		"pause":   "k8s.gcr.io/pause:3.1",
+		"busybox": "busybox:1.29",
+		"alpine":  "library://library/default/alpine:3.8",
+	} {
+		r, err := image.ParseRef(ref)
+		require.NoError(t, err)
+		require.NoError(t, registry.images.Add(&image.Info{ID: id, Ref: r}))
+	}
+	registry.preloads.pins["alpine"] = time.Now().Add(time.Hour)
+
+	require.Error(t, registry.SetPinnedImages([]string{"busybox:1.29", " "}))
+	require.NoError(t, registry.SetPinnedImages([]string{"k8s.gcr.io/pause:3.1", "not-pulled:latest"}))
+
+	tt := []struct {
+		image  string
+		expect codes.Code
+	}{
+		{image: "k8s.gcr.io/pause:3.1", expect: codes.FailedPrecondition},
+		{image: "pause", expect: codes.FailedPrecondition},
+		{image: "alpine", expect: codes.FailedPrecondition},
+		{image: "unknown", expect: codes.OK},
+	}
+	for _, tc := range tt {
+		t.Run(tc.image, func(t *testing.T) {
+			_, err := registry.RemoveImage(context.Background(), &k8s.RemoveImageRequest{
+				Image: &k8s.ImageSpec{Image: tc.image},
+			})
+			require.Equal(t, tc.expect, status.Code(err), "%v", err)
+		})
+	}
+
+	resp, err := registry.ImageStatus(context.Background(), &k8s.ImageStatusRequest{
+		Image:   &k8s.ImageSpec{Image: "pause"},
+		Verbose: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "config", resp.Info["pinned"])
+
+	// unpinned image is no longer protected
+	require.NoError(t, registry.SetPinnedImages(nil))
+	info, err := registry.images.Find("pause")
+	require.NoError(t, err)
+	require.False(t, registry.isPinned(info))
+}
+
+func TestRemoveImage_ByTag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+	blobs, err := image.NewBlobStore(dir)
+	require.NoError(t, err)
+
+	registry := &SingularityRegistry{
+		images:   index.NewImageIndex(),
+		blobs:    blobs,
+		preloads: newPreloader(nil, time.Hour),
+	}
+	imgPath := filepath.Join(dir, "app.sif")
+	require.NoError(t, ioutil.WriteFile(imgPath, nil, 0644))
+	for _, ref := range []string{"gcr.io/foo/app:old", "gcr.io/foo/app:stable"} {
+		r, err := image.ParseRef(ref)
+		require.NoError(t, err)
+		require.NoError(t, registry.images.Add(&image.Info{ID: "app", Path: imgPath, Ref: r}))
+	}
+	info, err := registry.images.Find("app")
+	require.NoError(t, err)
+	info.Borrow("container")
+
+	remove := func(ref string) error {
+		_, err := registry.RemoveImage(context.Background(), &k8s.RemoveImageRequest{
+			Image: &k8s.ImageSpec{Image: ref},
+		})
+		return err
+	}
+
+	// untagging is allowed for images in use
+	require.NoError(t, remove("gcr.io/foo/app:old"))
+	resp, err := registry.ImageStatus(context.Background(), &k8s.ImageStatusRequest{
+		Image: &k8s.ImageSpec{Image: "app"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"gcr.io/foo/app:stable"}, resp.GetImage().GetRepoTags())
+	require.FileExists(t, imgPath)
+
+	// last tag removes content which is in use
+	require.Equal(t, codes.FailedPrecondition, status.Code(remove("gcr.io/foo/app:stable")))
+	info.Return("container")
+	require.NoError(t, remove("gcr.io/foo/app:stable"))
+	_, err = registry.images.Find("app")
+	require.Equal(t, index.ErrNotFound, err)
+	_, err = os.Stat(imgPath)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestRemoveImage_Release(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+	blobs, err := image.NewBlobStore(dir)
+	require.NoError(t, err)
+
+	used := true
+	var released []string
+	registry := &SingularityRegistry{
+		images:   index.NewImageIndex(),
+		blobs:    blobs,
+		preloads: newPreloader(nil, time.Hour),
+		releaseImage: func(imageID string) bool {
+			released = append(released, imageID)
+			return !used
+		},
+	}
+	imgPath := filepath.Join(dir, "app.sif")
+	require.NoError(t, ioutil.WriteFile(imgPath, nil, 0644))
+	r, err := image.ParseRef("gcr.io/foo/app:stable")
+	require.NoError(t, err)
+	require.NoError(t, registry.images.Add(&image.Info{ID: "app", Path: imgPath, Ref: r}))
+
+	remove := func() error {
+		_, err := registry.RemoveImage(context.Background(), &k8s.RemoveImageRequest{
+			Image: &k8s.ImageSpec{Image: "app"},
+		})
+		return err
+	}
+
+	require.Equal(t, codes.FailedPrecondition, status.Code(remove()))
+	require.FileExists(t, imgPath)
+	used = false
+	require.NoError(t, remove())
+	require.Equal(t, []string{"app", "app"}, released)
+	_, err = os.Stat(imgPath)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestLoadInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+	blobs, err := image.NewBlobStore(filepath.Join(dir, blobStoreDir))
+	require.NoError(t, err)
+
+	var backup []byte
+	for id, ref := range map[string]string{
+		"busybox": "busybox:1.29",
+		"alpine":  "gcr.io/foo/alpine:3.8",
+	} {
+		r, err := image.ParseRef(ref)
+		require.NoError(t, err)
+		data, err := json.Marshal(&image.Info{ID: id, Ref: r})
+		require.NoError(t, err)
+		backup = append(backup, data...)
+	}
+	// entry truncated by a power loss
+	backup = append(backup, []byte(`{"id":"pause","ref":{"uri":"docker`)...)
+	infoPath := filepath.Join(dir, registryInfoFile)
+	require.NoError(t, ioutil.WriteFile(infoPath, backup, 0644))
+
+	registry := &SingularityRegistry{
+		images:   index.NewImageIndex(),
+		blobs:    blobs,
+		infoPath: infoPath,
+	}
+	require.NoError(t, registry.loadInfo())
+	for _, id := range []string{"busybox", "alpine"} {
+		_, err := registry.images.Find(id)
+		require.NoError(t, err, id)
+	}
+	_, err = registry.images.Find("pause")
+	require.Equal(t, index.ErrNotFound, err)
+
+	corrupt, err := ioutil.ReadDir(filepath.Join(dir, fs.CorruptDir))
+	require.NoError(t, err)
+	require.Len(t, corrupt, 1)
+	data, err := ioutil.ReadFile(filepath.Join(dir, fs.CorruptDir, corrupt[0].Name()))
+	require.NoError(t, err)
+	require.Equal(t, backup, data)
+
+	// restored images are saved and load cleanly next time
+	restarted := &SingularityRegistry{
+		images:   index.NewImageIndex(),
+		blobs:    blobs,
+		infoPath: infoPath,
+	}
+	require.NoError(t, restarted.loadInfo())
+	for _, id := range []string{"busybox", "alpine"} {
+		_, err := restarted.images.Find(id)
+		require.NoError(t, err, id)
+	}
+	corrupt, err = ioutil.ReadDir(filepath.Join(dir, fs.CorruptDir))
+	require.NoError(t, err)
+	require.Len(t, corrupt, 1)
+
+	// missing backup file means empty registry
+	require.NoError(t, os.Remove(infoPath))
+	empty := &SingularityRegistry{
+		images:   index.NewImageIndex(),
+		blobs:    blobs,
+		infoPath: infoPath,
+	}
+	require.NoError(t, empty.loadInfo())
+}
+
+func TestListImages_Order(t *testing.T) {
+	registry := &SingularityRegistry{
+		images: index.NewImageIndex(),
+	}
+	for _, img := range []struct {
+		id      string
+		ref     string
+		created int64
+	}{
+		{id: "b", ref: "busybox:1.29", created: 100},
+		{id: "c", ref: "alpine:3.8", created: 300},
+		{id: "a", ref: "k8s.gcr.io/pause:3.1", created: 100},
+		{id: "d", ref: "nginx:1.17", created: 0},
+	} {
+		r, err := image.ParseRef(img.ref)
+		require.NoError(t, err)
+		require.NoError(t, registry.images.Add(&image.Info{ID: img.id, Ref: r, CreatedAt: img.created}))
+	}
+
+	for i := 0; i < 5; i++ {
+		resp, err := registry.ListImages(context.Background(), &k8s.ListImagesRequest{})
+		require.NoError(t, err)
+		var ids []string
+		for _, img := range resp.Images {
+			ids = append(ids, img.Id)
+		}
+		require.Equal(t, []string{"c", "a", "b", "d"}, ids)
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// DefaultPreloadPinTTL is the default time preloaded images are protected from removal.
+	DefaultPreloadPinTTL = time.Hour
+
+	// finishedPreloadTTL is the time finished preloads are reported by PreloadStatus.
+	finishedPreloadTTL = time.Hour
+
+	// preloadRetryBackoff and maxPreloadRetryBackoff bound delay before failed
+	// preload is retried, delay is doubled after each failed attempt.
+	preloadRetryBackoff    = 10 * time.Second
+	maxPreloadRetryBackoff = 5 * time.Minute
+)
+
+type pullFunc func(ctx context.Context, req *k8s.PullImageRequest) (*k8s.PullImageResponse, error)
+
+// preloadOptions tells how image is preloaded.
+type preloadOptions struct {
+	priority int32
+	// pin keeps image from removal by reference until unpinned
+	pin bool
+	// retry makes failed preload be queued again after backoff
+	retry bool
+}
+
+type preloadTask struct {
+	seq     uint64
+	auth    *k8s.AuthConfig
+	retry   bool
+	retryAt time.Time
+	info    admin.Preload
+}
+
+// preloader pulls queued images one at a time in order of their priority. Preloads
+// never compete with kubelet: next preload is started only when there
+// are no kubelet-initiated pulls in progress.
+type preloader struct {
+	pull   pullFunc
+	pinTTL time.Duration
+
+	mu           sync.Mutex
+	idle         *sync.Cond // signaled when kubelet pulls are finished
+	kubeletPulls int
+	seq          uint64
+	tasks        map[string]*preloadTask
+	queue        []*preloadTask
+	pins         map[string]time.Time
+	pinnedRefs   map[string]bool
+	wake         chan struct{}
+	retryBackoff time.Duration
+}
+
+func newPreloader(pull pullFunc, pinTTL time.Duration) *preloader {
+	p := &preloader{
+		pull:   pull,
+		pinTTL: pinTTL,
+		tasks:  make(map[string]*preloadTask),
+		pins:   make(map[string]time.Time),
+		wake:   make(chan struct{}, 1),
+
+		pinnedRefs:   make(map[string]bool),
+		retryBackoff: preloadRetryBackoff,
+	}
+	p.idle = sync.NewCond(&p.mu)
+	return p
+}
+
+// enqueue adds image to the preload queue. When the image is already queued or
+// being pulled the existing preload is returned, possibly with raised priority.
+// Pinned reference is protected from removal right away, so that image that
+// is already pulled stays on node while preload is queued.
+func (p *preloader) enqueue(ref string, auth *k8s.AuthConfig, opts preloadOptions) admin.Preload {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if opts.pin {
+		p.pinnedRefs[ref] = true
+	}
+	task, ok := p.tasks[ref]
+	if ok && (task.info.State == admin.PreloadState_PRELOAD_QUEUED || task.info.State == admin.PreloadState_PRELOAD_PULLING) {
+		if opts.priority > task.info.Priority {
+			task.info.Priority = opts.priority
+		}
+		task.retry = task.retry || opts.retry
+		task.info.Pinned = p.pinnedRefs[ref]
+		return task.info
+	}
+
+	p.seq++
+	task = &preloadTask{
+		seq:   p.seq,
+		auth:  auth,
+		retry: opts.retry,
+		info: admin.Preload{
+			Image:    ref,
+			State:    admin.PreloadState_PRELOAD_QUEUED,
+			Priority: opts.priority,
+			QueuedAt: time.Now().UnixNano(),
+			Pinned:   p.pinnedRefs[ref],
+		},
+	}
+	p.tasks[ref] = task
+	p.queue = append(p.queue, task)
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return task.info
+}
+
+// status returns preload of the passed image. When ref is
+// empty all queued, running and recently finished preloads are returned.
+func (p *preloader) status(ref string) []*admin.Preload {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prune()
+	var preloads []*admin.Preload
+	for r, task := range p.tasks {
+		if ref != "" && r != ref {
+			continue
+		}
+		info := task.info
+		preloads = append(preloads, &info)
+	}
+	sort.Slice(preloads, func(i, j int) bool {
+		return preloads[i].QueuedAt < preloads[j].QueuedAt
+	})
+	return preloads
+}
+
+// pinnedUntil returns time until which image with the passed ID
+// must not be removed. Zero time is returned for images that are not pinned.
+func (p *preloader) pinnedUntil(id string) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	until, ok := p.pins[id]
+	if !ok {
+		return time.Time{}
+	}
+	if time.Now().After(until) {
+		delete(p.pins, id)
+		return time.Time{}
+	}
+	return until
+}
+
+// pinned returns references pinned by preloads.
+func (p *preloader) pinned() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	refs := make([]string, 0, len(p.pinnedRefs))
+	for ref := range p.pinnedRefs {
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// kubeletPullStarted should be called at the beginning of every
+// kubelet-initiated pull. Returned function must be called once pull is finished.
+func (p *preloader) kubeletPullStarted() func() {
+	p.mu.Lock()
+	p.kubeletPulls++
+	p.mu.Unlock()
+	return func() {
+		p.mu.Lock()
+		p.kubeletPulls--
+		if p.kubeletPulls == 0 {
+			p.idle.Broadcast()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// run pulls queued images until ctx is cancelled.
+func (p *preloader) run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		p.mu.Lock()
+		p.idle.Broadcast()
+		p.mu.Unlock()
+	}()
+
+	for {
+		task, wait := p.next(ctx)
+		if task == nil {
+			if !p.wait(ctx, wait) {
+				return
+			}
+			continue
+		}
+		p.preload(ctx, task)
+	}
+}
+
+// wait blocks until new preload is queued or, if d is positive, until d
+// passes. Returns false if ctx is cancelled.
+func (p *preloader) wait(ctx context.Context, d time.Duration) bool {
+	var retry <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		retry = timer.C
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-p.wake:
+	case <-retry:
+	}
+	return true
+}
+
+// next waits for kubelet pulls to finish and pops the queued preload with the
+// highest priority marking it as pulling. Preloads waiting for retry are skipped.
+// Returns nil if there is no preload to start along with time until the
+// earliest retry, which is zero when no preload waits for retry.
+func (p *preloader) next(ctx context.Context) (*preloadTask, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.kubeletPulls > 0 && ctx.Err() == nil {
+		p.idle.Wait()
+	}
+	if ctx.Err() != nil {
+		return nil, 0
+	}
+	now := time.Now()
+	best := -1
+	var wait time.Duration
+	for i, task := range p.queue {
+		if d := task.retryAt.Sub(now); d > 0 {
+			if wait == 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		if best == -1 || task.info.Priority > p.queue[best].info.Priority ||
+			(task.info.Priority == p.queue[best].info.Priority && task.seq < p.queue[best].seq) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return nil, wait
+	}
+	task := p.queue[best]
+	p.queue = append(p.queue[:best], p.queue[best+1:]...)
+	task.info.State = admin.PreloadState_PRELOAD_PULLING
+	return task, 0
+}
+
+func (p *preloader) preload(ctx context.Context, task *preloadTask) {
+	ref := task.info.Image
+	glog.V(2).Infof("Preloading image %s", ref)
+	resp, err := p.pull(ctx, &k8s.PullImageRequest{
+		Image: &k8s.ImageSpec{Image: ref},
+		Auth:  task.auth,
+	})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	task.info.Attempts++
+	if err != nil && task.retry && ctx.Err() == nil {
+		backoff := p.retryBackoff << uint(task.info.Attempts-1)
+		if backoff > maxPreloadRetryBackoff || backoff <= 0 {
+			backoff = maxPreloadRetryBackoff
+		}
+		glog.Errorf("Could not preload image %s, retrying in %s: %v", ref, backoff, err)
+		task.retryAt = time.Now().Add(backoff)
+		task.info.State = admin.PreloadState_PRELOAD_QUEUED
+		task.info.Error = status.Convert(err).Message()
+		task.info.RetryAt = task.retryAt.UnixNano()
+		p.queue = append(p.queue, task)
+		return
+	}
+
+	task.auth = nil
+	task.info.FinishedAt = time.Now().UnixNano()
+	task.info.RetryAt = 0
+	if err != nil {
+		glog.Errorf("Could not preload image %s: %v", ref, err)
+		task.info.State = admin.PreloadState_PRELOAD_FAILED
+		task.info.Error = status.Convert(err).Message()
+		return
+	}
+	glog.V(2).Infof("Image %s is preloaded", ref)
+	task.info.State = admin.PreloadState_PRELOAD_DONE
+	task.info.Error = ""
+	task.info.ImageRef = resp.GetImageRef()
+	if p.pinTTL > 0 {
+		until := time.Now().Add(p.pinTTL)
+		p.pins[task.info.ImageRef] = until
+		task.info.PinnedUntil = until.UnixNano()
+	}
+}
+
+// prune removes preloads that are finished long ago. Must be called with mu held.
+func (p *preloader) prune() {
+	deadline := time.Now().Add(-finishedPreloadTTL).UnixNano()
+	for ref, task := range p.tasks {
+		if task.info.FinishedAt != 0 && task.info.FinishedAt < deadline {
+			delete(p.tasks, ref)
+		}
+	}
+}
+
+// PreloadImage queues an image to be pulled in background. Once pulled the image
+// is pinned, i.e. it cannot be removed until pin TTL is expired. Image preloaded
+// with pin set cannot be removed until Singularity-CRI is restarted.
+func (s *SingularityRegistry) PreloadImage(ctx context.Context, req *admin.PreloadImageRequest) (*admin.PreloadImageResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+	ref, err := image.ParseRef(req.GetImage())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "could not parse image reference: %v", err)
+	}
+
+	var auth *k8s.AuthConfig
+	if a := req.GetAuth(); a != nil {
+		auth = &k8s.AuthConfig{
+			Username:      a.Username,
+			Password:      a.Password,
+			Auth:          a.Auth,
+			ServerAddress: a.ServerAddress,
+			IdentityToken: a.IdentityToken,
+			RegistryToken: a.RegistryToken,
+		}
+	}
+	preload := s.preloads.enqueue(ref.String(), auth, preloadOptions{
+		priority: req.GetPriority(),
+		pin:      req.GetPin(),
+		retry:    req.GetRetry(),
+	})
+	return &admin.PreloadImageResponse{
+		Preload: &preload,
+	}, nil
+}
+
+// PreloadStatus returns status of the image preload or of all
+// known preloads when no image is set in the request.
+func (s *SingularityRegistry) PreloadStatus(ctx context.Context, req *admin.PreloadStatusRequest) (*admin.PreloadStatusResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+	var name string
+	if req.GetImage() != "" {
+		ref, err := image.ParseRef(req.GetImage())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "could not parse image reference: %v", err)
+		}
+		name = ref.String()
+	}
+	preloads := s.preloads.status(name)
+	if name != "" && len(preloads) == 0 {
+		return nil, status.Errorf(codes.NotFound, "image %s is not preloaded", name)
+	}
+	return &admin.PreloadStatusResponse{
+		Preloads: preloads,
+	}, nil
+}
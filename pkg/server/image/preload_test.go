@@ -0,0 +1,144 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+type fakePuller struct {
+	mu     sync.Mutex
+	pulled []string
+	pulls  chan string
+}
+
+func (f *fakePuller) pull(ctx context.Context, req *k8s.PullImageRequest) (*k8s.PullImageResponse, error) {
+	ref := req.GetImage().GetImage()
+	f.mu.Lock()
+	f.pulled = append(f.pulled, ref)
+	f.mu.Unlock()
+	f.pulls <- ref
+	if ref == "broken" {
+		return nil, fmt.Errorf("pull failed")
+	}
+	return &k8s.PullImageResponse{ImageRef: "id-" + ref}, nil
+}
+
+func waitPreload(t *testing.T, p *preloader, ref string) *admin.Preload {
+	for i := 0; i < 100; i++ {
+		preloads := p.status(ref)
+		require.Len(t, preloads, 1)
+		if preloads[0].State == admin.PreloadState_PRELOAD_DONE || preloads[0].State == admin.PreloadState_PRELOAD_FAILED {
+			return preloads[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("preload of %s is not finished", ref)
+	return nil
+}
+
+func TestPreloader(t *testing.T) {
+	puller := &fakePuller{pulls: make(chan string, 10)}
+	p := newPreloader(puller.pull, time.Hour)
+
+	// kubelet pull in progress holds preloads back
+	done := p.kubeletPullStarted()
+	p.enqueue("low", nil, preloadOptions{})
+	p.enqueue("high", nil, preloadOptions{priority: 10})
+	p.enqueue("broken", nil, preloadOptions{priority: 5})
+	dup := p.enqueue("low", nil, preloadOptions{priority: 1})
+	require.Equal(t, admin.PreloadState_PRELOAD_QUEUED, dup.State)
+	require.Equal(t, int32(1), dup.Priority)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.run(ctx)
+
+	select {
+	case ref := <-puller.pulls:
+		t.Fatalf("preload of %s started during kubelet pull", ref)
+	case <-time.After(100 * time.Millisecond):
+	}
+	done()
+
+	high := waitPreload(t, p, "high")
+	broken := waitPreload(t, p, "broken")
+	low := waitPreload(t, p, "low")
+	require.Equal(t, []string{"high", "broken", "low"}, puller.pulled)
+
+	require.Equal(t, admin.PreloadState_PRELOAD_DONE, high.State)
+	require.Equal(t, "id-high", high.ImageRef)
+	require.NotZero(t, high.PinnedUntil)
+	require.Equal(t, admin.PreloadState_PRELOAD_FAILED, broken.State)
+	require.Equal(t, "pull failed", broken.Error)
+	require.Equal(t, admin.PreloadState_PRELOAD_DONE, low.State)
+
+	require.False(t, p.pinnedUntil("id-high").IsZero())
+	require.True(t, p.pinnedUntil("id-broken").IsZero())
+	require.Len(t, p.status(""), 3)
+
+	// finished preload may be requested again
+	again := p.enqueue("broken", nil, preloadOptions{})
+	require.Equal(t, admin.PreloadState_PRELOAD_QUEUED, again.State)
+	waitPreload(t, p, "broken")
+}
+
+func TestPreloader_PinExpiry(t *testing.T) {
+	p := newPreloader(nil, time.Hour)
+	p.pins["fresh"] = time.Now().Add(time.Minute)
+	p.pins["stale"] = time.Now().Add(-time.Minute)
+
+	require.False(t, p.pinnedUntil("fresh").IsZero())
+	require.True(t, p.pinnedUntil("stale").IsZero())
+	require.True(t, p.pinnedUntil("unknown").IsZero())
+	require.NotContains(t, p.pins, "stale")
+}
+
+func TestPreloader_Retry(t *testing.T) {
+	var attempts int
+	pull := func(ctx context.Context, req *k8s.PullImageRequest) (*k8s.PullImageResponse, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, fmt.Errorf("registry unavailable")
+		}
+		return &k8s.PullImageResponse{ImageRef: "id-flaky"}, nil
+	}
+	p := newPreloader(pull, 0)
+	p.retryBackoff = 10 * time.Millisecond
+
+	queued := p.enqueue("flaky", nil, preloadOptions{pin: true, retry: true})
+	require.True(t, queued.Pinned)
+	require.Equal(t, []string{"flaky"}, p.pinned(), "reference must be pinned before pull")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.run(ctx)
+
+	flaky := waitPreload(t, p, "flaky")
+	require.Equal(t, admin.PreloadState_PRELOAD_DONE, flaky.State)
+	require.Equal(t, int32(3), flaky.Attempts)
+	require.Empty(t, flaky.Error)
+	require.Zero(t, flaky.RetryAt)
+	require.Zero(t, flaky.PinnedUntil)
+	require.True(t, flaky.Pinned)
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/image"
+)
+
+// defaultProgressInterval is how often progress of running pulls is logged.
+const defaultProgressInterval = 30 * time.Second
+
+// PullStatus describes an image pull in progress.
+type PullStatus struct {
+	Image   string    `json:"image"`
+	Started time.Time `json:"started"`
+	Bytes   int64     `json:"bytes"`
+	// Total is an expected download size, zero when unknown.
+	Total int64 `json:"total,omitempty"`
+}
+
+// pullTracker keeps progress of running pulls and periodically logs it.
+type pullTracker struct {
+	interval time.Duration
+
+	mu    sync.Mutex
+	pulls map[*trackedPull]struct{}
+}
+
+type trackedPull struct {
+	ref      string
+	started  time.Time
+	progress *image.Progress
+}
+
+func newPullTracker() *pullTracker {
+	return &pullTracker{
+		interval: defaultProgressInterval,
+		pulls:    make(map[*trackedPull]struct{}),
+	}
+}
+
+// start tracks pull of ref until returned function is called.
+func (t *pullTracker) start(ref *image.Reference, progress *image.Progress) func() {
+	p := &trackedPull{
+		ref:      ref.String(),
+		started:  time.Now(),
+		progress: progress,
+	}
+	t.mu.Lock()
+	t.pulls[p] = struct{}{}
+	t.mu.Unlock()
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s := p.status()
+				if s.Total > 0 {
+					glog.V(2).Infof("Pulling %s: %s of %s downloaded in %s", s.Image,
+						formatBytes(uint64(s.Bytes)), formatBytes(uint64(s.Total)), time.Since(s.Started).Round(time.Second))
+				} else {
+					glog.V(2).Infof("Pulling %s: %s downloaded in %s", s.Image,
+						formatBytes(uint64(s.Bytes)), time.Since(s.Started).Round(time.Second))
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			t.mu.Lock()
+			delete(t.pulls, p)
+			t.mu.Unlock()
+		})
+	}
+}
+
+// list returns status of all running pulls, the oldest first.
+func (t *pullTracker) list() []PullStatus {
+	t.mu.Lock()
+	pulls := make([]*trackedPull, 0, len(t.pulls))
+	for p := range t.pulls {
+		pulls = append(pulls, p)
+	}
+	t.mu.Unlock()
+
+	statuses := make([]PullStatus, 0, len(pulls))
+	for _, p := range pulls {
+		statuses = append(statuses, p.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Started.Equal(statuses[j].Started) {
+			return statuses[i].Image < statuses[j].Image
+		}
+		return statuses[i].Started.Before(statuses[j].Started)
+	})
+	return statuses
+}
+
+func (p *trackedPull) status() PullStatus {
+	done, total := p.progress.Get()
+	return PullStatus{
+		Image:   p.ref,
+		Started: p.started,
+		Bytes:   done,
+		Total:   total,
+	}
+}
+
+// PullsInProgress returns status of image downloads that are running
+// now. Pulls waiting for a free download slot are not included.
+func (s *SingularityRegistry) PullsInProgress() []PullStatus {
+	return s.progress.list()
+}
+
+// expectedDownload returns number of bytes pull is expected to
+// download, zero when it is unknown.
+func expectedDownload(remoteInfo *image.Info, layers []image.Layer) int64 {
+	if remoteInfo != nil {
+		return int64(remoteInfo.Size)
+	}
+	var size int64
+	for _, layer := range layers {
+		size += layer.Size
+	}
+	return size
+}
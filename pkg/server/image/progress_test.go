@@ -0,0 +1,63 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/image"
+)
+
+func TestPullTracker(t *testing.T) {
+	tracker := newPullTracker()
+	tracker.interval = 10 * time.Millisecond
+	require.Empty(t, tracker.list())
+
+	first, err := image.ParseRef("busybox")
+	require.NoError(t, err)
+	second, err := image.ParseRef("library://sylabs/examples/ruby")
+	require.NoError(t, err)
+
+	stopFirst := tracker.start(first, image.NewProgress(100))
+	time.Sleep(time.Millisecond)
+	stopSecond := tracker.start(second, image.NewProgress(0))
+	// let progress be logged at least once
+	time.Sleep(20 * time.Millisecond)
+
+	pulls := tracker.list()
+	require.Len(t, pulls, 2)
+	require.Equal(t, first.String(), pulls[0].Image)
+	require.Equal(t, int64(100), pulls[0].Total)
+	require.Zero(t, pulls[0].Bytes)
+	require.Equal(t, second.String(), pulls[1].Image)
+	require.Zero(t, pulls[1].Total)
+
+	stopFirst()
+	stopFirst()
+	pulls = tracker.list()
+	require.Len(t, pulls, 1)
+	require.Equal(t, second.String(), pulls[0].Image)
+
+	stopSecond()
+	require.Empty(t, tracker.list())
+}
+
+func TestExpectedDownload(t *testing.T) {
+	require.Zero(t, expectedDownload(nil, nil))
+	require.Equal(t, int64(42), expectedDownload(&image.Info{Size: 42}, []image.Layer{{Size: 1}}))
+	require.Equal(t, int64(30), expectedDownload(nil, []image.Layer{{Size: 10}, {Size: 20}}))
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+)
+
+const (
+	scratchDirName = "scratch"
+	scratchDirPerm = 0700
+)
+
+// leftoverRe matches names of partially pulled or imported images
+// together with their conversion temporary directories.
+var leftoverRe = regexp.MustCompile(`^\.[0-9a-f]{64}(\.tmp)?$`)
+
+// WithScratchDir sets directory images are downloaded and converted in before
+// they are moved into storage. Directory may be located on another filesystem,
+// scratch directory inside storage is used by default.
+func WithScratchDir(dir string) Option {
+	return func(r *SingularityRegistry) {
+		r.scratch = dir
+	}
+}
+
+// prepareScratch creates scratch directory accessible to sycri only and removes
+// anything left by pulls that were interrupted by previous restart.
+func (s *SingularityRegistry) prepareScratch() error {
+	dir, err := filepath.Abs(s.scratch)
+	if err != nil {
+		return fmt.Errorf("could not get absolute scratch directory path: %v", err)
+	}
+	s.scratch = dir
+	if err := os.MkdirAll(dir, scratchDirPerm); err != nil {
+		return fmt.Errorf("could not create scratch directory: %v", err)
+	}
+	if err := os.Chmod(dir, scratchDirPerm); err != nil {
+		return fmt.Errorf("could not restrict scratch directory: %v", err)
+	}
+	// older versions pulled right into storage directory
+	for _, d := range []string{dir, s.storage} {
+		if err := removeLeftovers(d); err != nil {
+			return err
+		}
+	}
+
+	noexec, err := fs.NoExec(dir)
+	if err != nil {
+		glog.Errorf("Could not check %s mount options: %v", dir, err)
+	}
+	if noexec {
+		glog.Warningf("Scratch directory %s is mounted noexec, docker image conversion may fail", dir)
+	}
+	return nil
+}
+
+// removeLeftovers removes partially pulled images from dir. Nothing
+// but pull leftovers is touched as directory may be shared.
+func removeLeftovers(dir string) error {
+	fii, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", dir, err)
+	}
+	for _, fi := range fii {
+		if !leftoverRe.MatchString(fi.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		glog.V(2).Infof("Removing leftover of interrupted pull %s", path)
+		if err := os.RemoveAll(path); err != nil {
+			glog.Errorf("Could not remove %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// isWithinDir checks whether path is root or is located under it.
+func isWithinDir(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareScratch(t *testing.T) {
+	storage, err := ioutil.TempDir("", "scratch-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(storage)
+
+	const id = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	scratch := filepath.Join(storage, scratchDirName)
+	require.NoError(t, os.MkdirAll(filepath.Join(scratch, "."+id+".tmp", "rootfs"), 0755))
+	leftovers := []string{
+		filepath.Join(scratch, "."+id),
+		filepath.Join(storage, "."+id),
+	}
+	kept := []string{
+		filepath.Join(storage, id),
+		filepath.Join(storage, "registry.json"),
+		filepath.Join(scratch, ".keep"),
+	}
+	for _, path := range append(leftovers, kept...) {
+		require.NoError(t, ioutil.WriteFile(path, []byte("data"), 0644))
+	}
+
+	registry := &SingularityRegistry{storage: storage, scratch: scratch}
+	require.NoError(t, registry.prepareScratch())
+
+	fi, err := os.Stat(scratch)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(scratchDirPerm), fi.Mode().Perm())
+	for _, path := range append(leftovers, filepath.Join(scratch, "."+id+".tmp")) {
+		_, err := os.Stat(path)
+		require.True(t, os.IsNotExist(err), "%s must be removed", path)
+	}
+	for _, path := range kept {
+		_, err := os.Stat(path)
+		require.NoError(t, err, "%s must be kept", path)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// pullOverheadFactor is a multiplier of compressed docker image size that
+	// accounts for downloaded layers, unpacked root filesystem and resulting SIF.
+	pullOverheadFactor = 3
+
+	spaceCheckInterval = time.Second
+)
+
+// DefaultStorageReserve is free space left on image storage filesystem
+// by default, i.e. the larger of 10% of filesystem size and 5GiB.
+var DefaultStorageReserve = StorageReserve{Ratio: 0.1, Bytes: 5 << 30}
+
+// StorageReserve is free space that must be left on image storage filesystem
+// after pull. The larger of Ratio of filesystem size and Bytes is reserved.
+// Pulls in progress are aborted when free space drops below half of the reserve.
+type StorageReserve struct {
+	Ratio float64
+	Bytes uint64
+}
+
+var sizeSuffixes = []struct {
+	suffix string
+	mult   uint64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+}
+
+// ParseStorageReserve parses comma separated thresholds of storage reserve. Each
+// threshold is either a percent of filesystem size, e.g. 10%, or amount of
+// bytes with optional binary suffix, e.g. 5Gi or 5GiB.
+func ParseStorageReserve(value string) (StorageReserve, error) {
+	var reserve StorageReserve
+	for _, threshold := range strings.Split(value, ",") {
+		threshold = strings.TrimSpace(threshold)
+		if threshold == "" {
+			continue
+		}
+		if strings.HasSuffix(threshold, "%") {
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(threshold, "%"), 64)
+			if err != nil || percent < 0 || percent > 100 {
+				return StorageReserve{}, fmt.Errorf("invalid storage reserve %q: bad percent", threshold)
+			}
+			reserve.Ratio = percent / 100
+			continue
+		}
+
+		bytes, err := parseSize(threshold)
+		if err != nil {
+			return StorageReserve{}, fmt.Errorf("invalid storage reserve %q: bad size", threshold)
+		}
+		reserve.Bytes = bytes
+	}
+	return reserve, nil
+}
+
+// parseSize parses amount of bytes with optional binary suffix, e.g. 5Gi or 5GiB.
+func parseSize(value string) (uint64, error) {
+	number, mult := strings.TrimSuffix(value, "B"), uint64(1)
+	for _, s := range sizeSuffixes {
+		if strings.HasSuffix(number, s.suffix) {
+			number, mult = strings.TrimSuffix(number, s.suffix), s.mult
+			break
+		}
+	}
+	bytes, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return bytes * mult, nil
+}
+
+// reserved returns number of bytes reserved on filesystem of the passed size.
+func (r StorageReserve) reserved(total uint64) uint64 {
+	reserved := uint64(r.Ratio * float64(total))
+	if r.Bytes > reserved {
+		reserved = r.Bytes
+	}
+	return reserved
+}
+
+// WithStorageReserve sets free space pulls must leave on image storage
+// filesystem. Overrides DefaultStorageReserve.
+func WithStorageReserve(reserve StorageReserve) Option {
+	return func(r *SingularityRegistry) {
+		r.reserve = reserve
+	}
+}
+
+// estimatePullSize returns approximate space needed to pull image. Library
+// image size is known from remote metadata, docker image size is estimated
+// from its layers. Zero is returned when estimate is not available, e.g. for
+// docker images with legacy manifests.
+func estimatePullSize(remoteInfo *image.Info, layers []image.Layer) uint64 {
+	if remoteInfo != nil {
+		return remoteInfo.Size
+	}
+	var size uint64
+	for _, layer := range layers {
+		size += uint64(layer.Size)
+	}
+	return size * pullOverheadFactor
+}
+
+// admitPull checks whether image of the passed size fits into image storage
+// leaving reserved space free. ResourceExhausted error is returned otherwise.
+func (s *SingularityRegistry) admitPull(ref *image.Reference, size uint64) error {
+	free, total, err := s.space(s.storage)
+	if err != nil {
+		glog.Errorf("Could not check free space before pulling %s: %v", ref, err)
+		return nil
+	}
+	reserved := s.reserve.reserved(total)
+	if free < size+reserved {
+		return status.Errorf(codes.ResourceExhausted,
+			"not enough space to pull %s into %s: need about %s plus %s reserve, %s available",
+			ref, s.storage, formatBytes(size), formatBytes(reserved), formatBytes(free))
+	}
+	if s.scratch == "" || isWithinDir(s.storage, s.scratch) {
+		return nil
+	}
+	// image is converted on another filesystem before it is moved to storage
+	free, _, err = s.space(s.scratch)
+	if err != nil {
+		glog.Errorf("Could not check free space before pulling %s: %v", ref, err)
+		return nil
+	}
+	if free < size {
+		return status.Errorf(codes.ResourceExhausted,
+			"not enough space to pull %s into %s: need about %s, %s available",
+			ref, s.scratch, formatBytes(size), formatBytes(free))
+	}
+	return nil
+}
+
+// watchSpace returns context that is cancelled once free space on image
+// storage filesystem drops below half of the reserve. Returned func must be
+// called once pull is finished, it reports whether ctx was cancelled due to low space.
+func (s *SingularityRegistry) watchSpace(ctx context.Context, ref *image.Reference) (context.Context, func() bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	var exhausted int32
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(s.spaceInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			free, total, err := s.space(s.storage)
+			if err != nil {
+				continue
+			}
+			if floor := s.reserve.reserved(total) / 2; free < floor {
+				glog.Warningf("Aborting pull of %s: only %s left in %s", ref, formatBytes(free), s.storage)
+				atomic.StoreInt32(&exhausted, 1)
+				cancel()
+				return
+			}
+		}
+	}()
+	return ctx, func() bool {
+		close(done)
+		cancel()
+		return atomic.LoadInt32(&exhausted) == 1
+	}
+}
+
+// formatBytes formats size with binary unit suffix.
+func formatBytes(size uint64) string {
+	for i := len(sizeSuffixes) - 1; i >= 0; i-- {
+		if s := sizeSuffixes[i]; size >= s.mult {
+			return fmt.Sprintf("%.1f%sB", float64(size)/float64(s.mult), s.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", size)
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseStorageReserve(t *testing.T) {
+	tt := []struct {
+		input       string
+		expect      StorageReserve
+		expectError bool
+	}{
+		{input: "", expect: StorageReserve{}},
+		{input: "10%", expect: StorageReserve{Ratio: 0.1}},
+		{input: "5Gi", expect: StorageReserve{Bytes: 5 << 30}},
+		{input: "10%, 5GiB", expect: StorageReserve{Ratio: 0.1, Bytes: 5 << 30}},
+		{input: "1024", expect: StorageReserve{Bytes: 1024}},
+		{input: "101%", expectError: true},
+		{input: "5GB", expectError: true},
+		{input: "-1Mi", expectError: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.input, func(t *testing.T) {
+			reserve, err := ParseStorageReserve(tc.input)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, reserve)
+		})
+	}
+
+	require.Equal(t, uint64(10<<30), DefaultStorageReserve.reserved(100<<30))
+	require.Equal(t, uint64(5<<30), DefaultStorageReserve.reserved(20<<30))
+}
+
+func TestAdmitPull(t *testing.T) {
+	registry := &SingularityRegistry{
+		storage: "/var/lib/singularity",
+		reserve: DefaultStorageReserve,
+		space: func(string) (uint64, uint64, error) {
+			return 20 << 30, 100 << 30, nil
+		},
+	}
+	ref, err := image.ParseRef("busybox:1.29")
+	require.NoError(t, err)
+
+	require.NoError(t, registry.admitPull(ref, 8<<30))
+	err = registry.admitPull(ref, 12<<30)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Contains(t, err.Error(), "need about 12.0GiB plus 10.0GiB reserve, 20.0GiB available")
+
+	registry.space = func(string) (uint64, uint64, error) {
+		return 0, 0, fmt.Errorf("statfs failed")
+	}
+	require.NoError(t, registry.admitPull(ref, 12<<30), "pull must not be refused when free space is unknown")
+
+	registry.scratch = "/mnt/scratch"
+	registry.space = func(path string) (uint64, uint64, error) {
+		if path == registry.scratch {
+			return 4 << 30, 10 << 30, nil
+		}
+		return 20 << 30, 100 << 30, nil
+	}
+	err = registry.admitPull(ref, 8<<30)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Contains(t, err.Error(), "into /mnt/scratch: need about 8.0GiB, 4.0GiB available")
+	registry.scratch = "/var/lib/singularity/scratch"
+	require.NoError(t, registry.admitPull(ref, 8<<30))
+}
+
+func TestWatchSpace(t *testing.T) {
+	var free uint64 = 20 << 30
+	registry := &SingularityRegistry{
+		storage:       "/var/lib/singularity",
+		reserve:       DefaultStorageReserve,
+		spaceInterval: time.Millisecond,
+		space: func(string) (uint64, uint64, error) {
+			return atomic.LoadUint64(&free), 100 << 30, nil
+		},
+	}
+	ref, err := image.ParseRef("busybox:1.29")
+	require.NoError(t, err)
+
+	ctx, stop := registry.watchSpace(context.Background(), ref)
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, ctx.Err())
+	require.False(t, stop())
+
+	ctx, stop = registry.watchSpace(context.Background(), ref)
+	atomic.StoreUint64(&free, 4<<30)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("pull must be aborted when free space is below hard floor")
+	}
+	require.True(t, stop())
+}
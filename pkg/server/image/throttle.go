@@ -0,0 +1,81 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sylabs/singularity-cri/pkg/image"
+)
+
+// ParsePullLimits parses pull bandwidth limits in bytes per second with
+// optional binary suffix, e.g. 50Mi, global and per registry host. Empty
+// or zero value means unlimited.
+func ParsePullLimits(global string, registries map[string]string) (image.PullLimits, error) {
+	var limits image.PullLimits
+	var err error
+	limits.Global, err = parseBandwidth(global)
+	if err != nil {
+		return image.PullLimits{}, fmt.Errorf("invalid global pull bandwidth %q: bad size", global)
+	}
+	for host, value := range registries {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			return image.PullLimits{}, fmt.Errorf("empty registry host in pull bandwidth limits")
+		}
+		limit, err := parseBandwidth(value)
+		if err != nil {
+			return image.PullLimits{}, fmt.Errorf("invalid %s pull bandwidth %q: bad size", host, value)
+		}
+		if limit == 0 {
+			continue
+		}
+		if limits.Registries == nil {
+			limits.Registries = make(map[string]int64)
+		}
+		limits.Registries[host] = limit
+	}
+	return limits, nil
+}
+
+func parseBandwidth(value string) (int64, error) {
+	value = strings.TrimSuffix(strings.TrimSpace(value), "/s")
+	if value == "" {
+		return 0, nil
+	}
+	bytes, err := parseSize(value)
+	if err != nil {
+		return 0, err
+	}
+	if int64(bytes) < 0 {
+		return 0, fmt.Errorf("%d is too large", bytes)
+	}
+	return int64(bytes), nil
+}
+
+// WithPullLimits limits bandwidth of image downloads.
+// By default downloads are not limited.
+func WithPullLimits(limits image.PullLimits) Option {
+	return func(r *SingularityRegistry) {
+		r.throttle.SetLimits(limits)
+	}
+}
+
+// SetPullLimits replaces bandwidth limits of image downloads.
+// Pulls in progress are not interrupted and follow new limits.
+func (s *SingularityRegistry) SetPullLimits(limits image.PullLimits) {
+	s.throttle.SetLimits(limits)
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/image"
+)
+
+func TestParsePullLimits(t *testing.T) {
+	tt := []struct {
+		name       string
+		global     string
+		registries map[string]string
+		expect     image.PullLimits
+		expectErr  bool
+	}{
+		{
+			name: "unlimited",
+		},
+		{
+			name:   "global only",
+			global: "100Mi",
+			expect: image.PullLimits{Global: 100 << 20},
+		},
+		{
+			name:   "per registry",
+			global: "1GiB/s",
+			registries: map[string]string{
+				"docker.io":       "20Mi",
+				"cloud.sylabs.io": "1048576",
+				"gcr.io":          "0",
+				"quay.io":         "",
+			},
+			expect: image.PullLimits{
+				Global: 1 << 30,
+				Registries: map[string]int64{
+					"docker.io":       20 << 20,
+					"cloud.sylabs.io": 1 << 20,
+				},
+			},
+		},
+		{
+			name:      "bad global",
+			global:    "-5Mi",
+			expectErr: true,
+		},
+		{
+			name:       "bad registry",
+			registries: map[string]string{"docker.io": "20MB"},
+			expectErr:  true,
+		},
+		{
+			name:       "empty host",
+			registries: map[string]string{" ": "20Mi"},
+			expectErr:  true,
+		},
+		{
+			name:      "too large",
+			global:    "8388608Ti",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			limits, err := ParsePullLimits(tc.global, tc.registries)
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, limits)
+		})
+	}
+}
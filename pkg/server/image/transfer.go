@@ -0,0 +1,204 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	"github.com/sylabs/singularity-cri/pkg/rand"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// transferChunkSize is the maximum size of image archive chunk sent in
+// a single message, it is well below default gRPC message size limit.
+const transferChunkSize = 1 << 20
+
+// ExportImage streams archive of the stored image that may be
+// loaded on another node with ImportImage.
+func (s *SingularityRegistry) ExportImage(req *admin.ExportImageRequest, stream admin.ImageAdmin_ExportImageServer) error {
+	if err := validateRequest(req); err != nil {
+		return err
+	}
+	info, err := s.images.Find(req.GetImage())
+	if err == index.ErrNotFound {
+		return status.Errorf(codes.NotFound, "image %s is not found", req.GetImage())
+	}
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "could not find image: %v", err)
+	}
+	if info.Ref.URI() == singularity.LocalFileDomain {
+		return status.Errorf(codes.FailedPrecondition, "image %s is a local SIF file that is not stored by runtime", req.GetImage())
+	}
+	if reason := info.Corrupt(); reason != "" {
+		return status.Errorf(codes.FailedPrecondition, "image %s is corrupted: %s", info.ID, reason)
+	}
+
+	// image must not be removed while it is being exported
+	exporter := "export-" + rand.GenerateID(16)
+	info.Borrow(exporter)
+	defer info.Return(exporter)
+
+	glog.V(2).Infof("Exporting image %s", info.ID)
+	cw := &chunkWriter{send: stream.Send}
+	w := bufio.NewWriterSize(cw, transferChunkSize)
+	err = image.Export(w, info)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cw.err != nil {
+		return cw.err
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not export image: %v", err)
+	}
+	return nil
+}
+
+// ImportImage stores image from archive written by ExportImage and registers
+// its references. When image with the same ID is already stored, only its
+// references are updated.
+func (s *SingularityRegistry) ImportImage(stream admin.ImageAdmin_ImportImageServer) error {
+	r := &chunkReader{recv: stream.Recv}
+	// layouts are imported right into storage, so that they are moved by rename
+	info, err := image.Import(r, s.scratch, s.storage)
+	if r.err != nil {
+		return r.err
+	}
+	if _, ok := err.(*image.ChecksumError); ok {
+		return status.Errorf(codes.DataLoss, "could not import image: %v", err)
+	}
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "could not import image: %v", err)
+	}
+	tmpPath := info.Path
+	cleanup := func() {
+		if err := os.RemoveAll(tmpPath); err != nil {
+			glog.Errorf("Could not remove %s: %v", tmpPath, err)
+		}
+	}
+	if info.Ref.URI() == singularity.LocalFileDomain {
+		cleanup()
+		return status.Errorf(codes.InvalidArgument, "could not import image: local SIF references are not supported")
+	}
+	// imported images are subject to the same signature policy as pulled ones
+	if err := s.verifier.Verify(stream.Context(), info); err != nil {
+		cleanup()
+		return status.Errorf(codes.InvalidArgument, "could not verify image: %v", err)
+	}
+
+	path := filepath.Join(s.storage, info.ID)
+	existing, err := s.images.Find(info.ID)
+	switch {
+	case err == nil && existing.Sha256 != info.Sha256:
+		cleanup()
+		return status.Errorf(codes.AlreadyExists, "different image is already stored under ID %s", info.ID)
+	case err == nil && existing.Corrupt() == "":
+		glog.V(2).Infof("Image %s is already stored, registering references only", info.ID)
+		cleanup()
+		info.Path = existing.Path
+	case err == nil && existing.Path != path:
+		cleanup()
+		return status.Errorf(codes.FailedPrecondition, "image %s is corrupted at %s, remove it first", info.ID, existing.Path)
+	default:
+		glog.V(5).Infof("Moving %s to %s", tmpPath, path)
+		if err := info.MoveTo(path); err != nil {
+			cleanup()
+			return status.Errorf(codes.Internal, "could not save imported image: %v", err)
+		}
+		if existing != nil {
+			// imported image has replaced the corrupted file
+			glog.V(2).Infof("Corrupted image %s was imported again", existing.ID)
+			existing.ClearCorrupt()
+		}
+	}
+
+	if err := s.images.Add(info); err != nil {
+		return status.Errorf(codes.Internal, "could not index image: %v", err)
+	}
+	s.blobs.Retain(info.ID, info.Layers)
+	if err := s.dumpInfo(); err != nil {
+		glog.Errorf("Could not dump registry info: %v", err)
+	}
+	stored, err := s.images.Find(info.ID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not find imported image: %v", err)
+	}
+	glog.V(2).Infof("Imported image %s", info.ID)
+	return stream.SendAndClose(&admin.ImportImageResponse{
+		ImageRef: stored.ID,
+		Tags:     stored.Ref.Tags(),
+	})
+}
+
+// chunkWriter sends written data in chunks of at most transferChunkSize.
+// Send errors are remembered so that they are returned to client as is.
+type chunkWriter struct {
+	send func(*admin.ImageChunk) error
+	err  error
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) != 0 {
+		n := len(p)
+		if n > transferChunkSize {
+			n = transferChunkSize
+		}
+		if err := w.send(&admin.ImageChunk{Data: p[:n]}); err != nil {
+			w.err = err
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// chunkReader reads data received in chunks. Receive errors are
+// remembered so that they are returned to client as is.
+type chunkReader struct {
+	recv func() (*admin.ImageChunk, error)
+	buf  []byte
+	err  error
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		chunk, err := r.recv()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		r.buf = chunk.GetData()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
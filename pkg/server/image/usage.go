@@ -0,0 +1,55 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// DefaultUsageRefreshInterval is how often disk usage
+// of stored images and layer cache is rescanned.
+const DefaultUsageRefreshInterval = time.Minute
+
+// WithUsageRefreshInterval sets how often disk usage reported by ImageFsInfo
+// is rescanned. Image files are accounted as soon as they are pulled or
+// removed, the interval matters for layer cache only. Non-positive interval
+// means DefaultUsageRefreshInterval.
+func WithUsageRefreshInterval(interval time.Duration) Option {
+	return func(r *SingularityRegistry) {
+		if interval > 0 {
+			r.usageInterval = interval
+		}
+	}
+}
+
+// refreshUsage periodically rescans image storage so that
+// ImageFsInfo requests don't wait for directory walks.
+func (s *SingularityRegistry) refreshUsage(ctx context.Context) {
+	ticker := time.NewTicker(s.usageInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.images.RefreshUsage(); err != nil {
+			glog.Errorf("Could not refresh image storage usage: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
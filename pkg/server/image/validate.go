@@ -0,0 +1,56 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// validateRequest checks that all fields handlers rely on are set so that
+// malformed requests are rejected before they are handled. Requests with
+// no required fields are always valid.
+func validateRequest(req interface{}) error {
+	var spec *k8s.ImageSpec
+	switch r := req.(type) {
+	case *k8s.PullImageRequest:
+		spec = r.GetImage()
+	case *k8s.RemoveImageRequest:
+		spec = r.GetImage()
+	case *k8s.ImageStatusRequest:
+		spec = r.GetImage()
+	case *admin.PreloadImageRequest:
+		if r.GetImage() == "" {
+			return status.Error(codes.InvalidArgument, "image: required")
+		}
+		return nil
+	case *admin.ExportImageRequest:
+		if r.GetImage() == "" {
+			return status.Error(codes.InvalidArgument, "image: required")
+		}
+		return nil
+	default:
+		return nil
+	}
+	if spec == nil {
+		return status.Error(codes.InvalidArgument, "image: required")
+	}
+	if spec.GetImage() == "" {
+		return status.Error(codes.InvalidArgument, "image.image: required")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestValidateRequest(t *testing.T) {
+	registry := &SingularityRegistry{
+		images: index.NewImageIndex(),
+	}
+	ctx := context.Background()
+
+	tt := []struct {
+		name        string
+		call        func() error
+		expectError string
+	}{
+		{
+			name: "pull without image spec",
+			call: func() error {
+				_, err := registry.PullImage(ctx, &k8s.PullImageRequest{})
+				return err
+			},
+			expectError: "image: required",
+		},
+		{
+			name: "remove without image spec",
+			call: func() error {
+				_, err := registry.RemoveImage(ctx, &k8s.RemoveImageRequest{})
+				return err
+			},
+			expectError: "image: required",
+		},
+		{
+			name: "status with empty image",
+			call: func() error {
+				_, err := registry.ImageStatus(ctx, &k8s.ImageStatusRequest{Image: &k8s.ImageSpec{}})
+				return err
+			},
+			expectError: "image.image: required",
+		},
+		{
+			name: "preload with empty image",
+			call: func() error {
+				_, err := registry.PreloadImage(ctx, &admin.PreloadImageRequest{})
+				return err
+			},
+			expectError: "image: required",
+		},
+		{
+			name: "status of missing image",
+			call: func() error {
+				_, err := registry.ImageStatus(ctx, &k8s.ImageStatusRequest{Image: &k8s.ImageSpec{Image: "busybox"}})
+				return err
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var err error
+			require.NotPanics(t, func() { err = tc.call() })
+			if tc.expectError == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+			require.Equal(t, tc.expectError, status.Convert(err).Message())
+		})
+	}
+}
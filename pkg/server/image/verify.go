@@ -0,0 +1,111 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+
+	"github.com/golang/glog"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// VerifyImages checks stored images against full checksums recorded at pull
+// time and cached docker blobs against their digests. Corrupted images are
+// marked so that the next pull replaces them and corrupted content is moved
+// into quarantine. Content of images that are in use stays in place.
+func (s *SingularityRegistry) VerifyImages(ctx context.Context, req *admin.VerifyImagesRequest) (*admin.VerifyImagesResponse, error) {
+	var images []*image.Info
+	if req.GetImage() != "" {
+		info, err := s.images.Find(req.GetImage())
+		if err == index.ErrNotFound {
+			return nil, status.Errorf(codes.NotFound, "image %s is not found", req.GetImage())
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "could not find image: %v", err)
+		}
+		images = append(images, info)
+	} else {
+		s.images.Iterate(func(info *image.Info) {
+			images = append(images, info)
+		})
+	}
+
+	resp := new(admin.VerifyImagesResponse)
+	for _, info := range images {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		resp.Images = append(resp.Images, s.verifyImage(info, req.GetDryRun()))
+	}
+	if req.GetImage() != "" {
+		return resp, nil
+	}
+
+	blobs, err := s.blobs.Verify(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, status.Errorf(codes.Internal, "could not verify blobs: %v", err)
+	}
+	for _, blob := range blobs {
+		check := &admin.BlobCheck{
+			Digest:  blob.Digest,
+			Corrupt: blob.Reason,
+		}
+		if !req.GetDryRun() {
+			check.Quarantined, err = s.quarantine.Add(blob.Path, blob.Reason)
+			if err != nil {
+				check.Error = err.Error()
+			}
+		}
+		resp.CorruptBlobs = append(resp.CorruptBlobs, check)
+	}
+	return resp, nil
+}
+
+func (s *SingularityRegistry) verifyImage(info *image.Info, dryRun bool) *admin.ImageCheck {
+	check := &admin.ImageCheck{
+		ImageRef: info.ID,
+		Tags:     info.Ref.Tags(),
+	}
+	err := info.VerifyIntegrity(true)
+	cErr, ok := err.(*image.CorruptError)
+	if !ok {
+		if err != nil {
+			check.Error = err.Error()
+		}
+		return check
+	}
+	check.Corrupt = cErr.Reason
+	if dryRun {
+		return check
+	}
+
+	info.Warnings().Logf(glog.ErrorDepth, "Marking image as corrupted: %v", cErr)
+	info.MarkCorrupt(cErr.Reason)
+	check.Quarantined, err = info.Quarantine(s.quarantine, cErr.Reason)
+	if err == image.ErrIsUsed {
+		err = nil
+	}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	return check
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/index"
+)
+
+func TestVerifyImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	blobs, err := image.NewBlobStore(filepath.Join(dir, blobStoreDir))
+	require.NoError(t, err)
+	quarantine, err := image.NewQuarantine(filepath.Join(dir, quarantineDir))
+	require.NoError(t, err)
+
+	registry := &SingularityRegistry{
+		images:     index.NewImageIndex(),
+		blobs:      blobs,
+		quarantine: quarantine,
+		preloads:   newPreloader(nil, time.Hour),
+	}
+	content := []byte("sif")
+	checksum := fmt.Sprintf("%x", sha256.Sum256(content))
+	add := func(id string, data []byte) *image.Info {
+		path := filepath.Join(dir, id+".sif")
+		require.NoError(t, ioutil.WriteFile(path, data, 0644))
+		ref, err := image.ParseRef("gcr.io/foo/" + id + ":latest")
+		require.NoError(t, err)
+		info := &image.Info{ID: id, Sha256: checksum, Size: uint64(len(content)), Path: path, Ref: ref}
+		require.NoError(t, registry.images.Add(info))
+		return info
+	}
+	add("intact", content)
+	corrupt := add("corrupt", []byte("bad"))
+	used := add("used", []byte("bad"))
+	used.Borrow("container")
+
+	blobDir := filepath.Join(dir, blobStoreDir, "cache", "oci", "blobs", "sha256")
+	require.NoError(t, os.MkdirAll(blobDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(blobDir, checksum), content, 0644))
+	badBlob := filepath.Join(blobDir, checksum[:63]+"0")
+	require.NoError(t, ioutil.WriteFile(badBlob, content, 0644))
+
+	resp, err := registry.VerifyImages(context.Background(), &admin.VerifyImagesRequest{DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, resp.Images, 3)
+	require.Len(t, resp.CorruptBlobs, 1)
+	require.Empty(t, corrupt.Corrupt(), "dry run must not mark images")
+	require.FileExists(t, corrupt.Path)
+	require.FileExists(t, badBlob)
+
+	resp, err = registry.VerifyImages(context.Background(), &admin.VerifyImagesRequest{})
+	require.NoError(t, err)
+	checks := make(map[string]*admin.ImageCheck)
+	for _, check := range resp.Images {
+		checks[check.ImageRef] = check
+	}
+	require.Empty(t, checks["intact"].Corrupt)
+	require.Equal(t, []string{"gcr.io/foo/intact:latest"}, checks["intact"].Tags)
+	require.NotEmpty(t, checks["corrupt"].Corrupt)
+	require.NotEmpty(t, checks["corrupt"].Quarantined)
+	require.NotEmpty(t, corrupt.Corrupt())
+	require.FileExists(t, filepath.Join(quarantine.Dir(), checks["corrupt"].Quarantined))
+	_, err = os.Stat(corrupt.Path)
+	require.True(t, os.IsNotExist(err), "corrupted image must be quarantined")
+	require.NotEmpty(t, checks["used"].Corrupt)
+	require.Empty(t, checks["used"].Quarantined, "image in use must stay in place")
+	require.Empty(t, checks["used"].Error)
+	require.FileExists(t, used.Path)
+
+	require.Len(t, resp.CorruptBlobs, 1)
+	require.Equal(t, "sha256:"+filepath.Base(badBlob), resp.CorruptBlobs[0].Digest)
+	require.NotEmpty(t, resp.CorruptBlobs[0].Quarantined)
+	_, err = os.Stat(badBlob)
+	require.True(t, os.IsNotExist(err), "corrupted blob must be quarantined")
+
+	resp, err = registry.VerifyImages(context.Background(), &admin.VerifyImagesRequest{Image: "intact"})
+	require.NoError(t, err)
+	require.Len(t, resp.Images, 1)
+	require.Empty(t, resp.CorruptBlobs, "blobs are verified only when all images are")
+}
@@ -0,0 +1,370 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// DefaultAdmissionTimeout is the default time admission webhook may respond in.
+const DefaultAdmissionTimeout = 5 * time.Second
+
+// maxAdmissionResponse limits size of admission webhook response.
+const maxAdmissionResponse = 1 << 20
+
+// AdmissionRule is a built-in admission rule applied to pods in Namespaces.
+type AdmissionRule struct {
+	// Name identifies rule in denial reasons.
+	Name string
+	// Namespaces rule applies to, empty list means all namespaces.
+	Namespaces []string
+	// DenyPrivileged rejects privileged pods and containers.
+	DenyPrivileged bool
+	// DenyHostNamespaces rejects pods and containers sharing
+	// host network, PID or IPC namespace.
+	DenyHostNamespaces bool
+	// DenyHostPaths rejects containers mounting host paths within any
+	// of listed ones. Both are resolved following symlinks.
+	DenyHostPaths []string
+	// RequireSignedImages rejects containers which image has no
+	// verified signature recorded on pull.
+	RequireSignedImages bool
+}
+
+// AdmissionWebhook is an external policy engine asked to admit pods and
+// containers. It receives {"input": AdmissionRequest} in a POST request and
+// responds with {"result": AdmissionResult}, so that Open Policy Agent data
+// API may serve as a webhook with policy loaded from a bundle.
+type AdmissionWebhook struct {
+	URL string
+	// Timeout is time webhook may respond in. Zero means DefaultAdmissionTimeout.
+	Timeout time.Duration
+	// Failure defines whether requests are denied or admitted when webhook
+	// is unavailable or responds with error. Empty means HookFailureFail.
+	Failure HookFailurePolicy
+}
+
+// AdmissionPolicy is evaluated on RunPodSandbox and CreateContainer.
+// Built-in rules are applied first, webhook is asked only when
+// none of them denies request.
+type AdmissionPolicy struct {
+	Rules   []AdmissionRule
+	Webhook *AdmissionWebhook
+}
+
+// Validate checks policy is fully and correctly defined.
+func (p *AdmissionPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	names := make(map[string]bool)
+	for _, r := range p.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("admission rule name cannot be empty")
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate admission rule %s", r.Name)
+		}
+		names[r.Name] = true
+		for _, path := range r.DenyHostPaths {
+			if !filepath.IsAbs(path) {
+				return fmt.Errorf("admission rule %s: host path %q is not absolute", r.Name, path)
+			}
+		}
+	}
+	if p.Webhook == nil {
+		return nil
+	}
+	u, err := url.Parse(p.Webhook.URL)
+	if err != nil {
+		return fmt.Errorf("invalid admission webhook URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("admission webhook URL must be an absolute http or https URL")
+	}
+	if p.Webhook.Timeout < 0 {
+		return fmt.Errorf("admission webhook timeout cannot be negative")
+	}
+	switch p.Webhook.Failure {
+	case "", HookFailureLog, HookFailureFail:
+	default:
+		return fmt.Errorf("unknown admission webhook failure policy %q", p.Webhook.Failure)
+	}
+	return nil
+}
+
+// AdmissionRequest describes pod or container being created.
+type AdmissionRequest struct {
+	// Operation is either RunPodSandbox or CreateContainer.
+	Operation     string `json:"operation"`
+	Namespace     string `json:"namespace"`
+	PodName       string `json:"podName"`
+	PodUID        string `json:"podUID,omitempty"`
+	ContainerName string `json:"containerName,omitempty"`
+	Image         string `json:"image,omitempty"`
+	ImageSigned   bool   `json:"imageSigned"`
+	Privileged    bool   `json:"privileged"`
+	HostNetwork   bool   `json:"hostNetwork"`
+	HostPID       bool   `json:"hostPID"`
+	HostIPC       bool   `json:"hostIPC"`
+	// HostPaths are mount sources resolved following symlinks.
+	HostPaths   []string          `json:"hostPaths,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// AdmissionDenial is a single reason request is denied for.
+type AdmissionDenial struct {
+	// Rule is a name of denying rule, webhook for webhook denials
+	// unless webhook names the rule.
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// AdmissionResult is a decision of admission webhook.
+type AdmissionResult struct {
+	Allowed bool              `json:"allowed"`
+	Denials []AdmissionDenial `json:"denials,omitempty"`
+}
+
+// AdmissionError is returned when admission policy denies request.
+type AdmissionError struct {
+	Denials []AdmissionDenial
+}
+
+func (e *AdmissionError) Error() string {
+	reasons := make([]string, 0, len(e.Denials))
+	for _, d := range e.Denials {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", d.Rule, d.Reason))
+	}
+	return "admission denied: " + strings.Join(reasons, "; ")
+}
+
+// admit evaluates admission policy, denial is returned as PermissionDenied
+// error listing all reasons, so that they are shown in kubelet events.
+func (s *SingularityRuntime) admit(ctx context.Context, req *AdmissionRequest) error {
+	if s.admission == nil {
+		return nil
+	}
+	err := s.admission.evaluate(ctx, req)
+	if err == nil {
+		return nil
+	}
+	name := req.Namespace + "/" + req.PodName
+	if req.ContainerName != "" {
+		name += "/" + req.ContainerName
+	}
+	glog.Warningf("%s of %s: %v", req.Operation, name, err)
+	return status.Error(codes.PermissionDenied, err.Error())
+}
+
+func (p *AdmissionPolicy) evaluate(ctx context.Context, req *AdmissionRequest) error {
+	var denials []AdmissionDenial
+	for _, r := range p.Rules {
+		denials = append(denials, r.check(req)...)
+	}
+	if len(denials) != 0 {
+		return &AdmissionError{Denials: denials}
+	}
+	if p.Webhook == nil {
+		return nil
+	}
+	res, err := p.Webhook.ask(ctx, req)
+	if err != nil {
+		if p.Webhook.Failure == HookFailureLog {
+			glog.Errorf("Admission webhook failed, admitting %s: %v", req.Operation, err)
+			return nil
+		}
+		return &AdmissionError{Denials: []AdmissionDenial{{Rule: "webhook", Reason: err.Error()}}}
+	}
+	if res.Allowed {
+		return nil
+	}
+	denials = res.Denials
+	if len(denials) == 0 {
+		denials = []AdmissionDenial{{Rule: "webhook", Reason: "denied without reason"}}
+	}
+	for i := range denials {
+		if denials[i].Rule == "" {
+			denials[i].Rule = "webhook"
+		}
+	}
+	return &AdmissionError{Denials: denials}
+}
+
+// check returns reasons rule denies request for.
+func (r AdmissionRule) check(req *AdmissionRequest) []AdmissionDenial {
+	if !r.appliesTo(req.Namespace) {
+		return nil
+	}
+	var denials []AdmissionDenial
+	deny := func(format string, args ...interface{}) {
+		denials = append(denials, AdmissionDenial{Rule: r.Name, Reason: fmt.Sprintf(format, args...)})
+	}
+	if r.DenyPrivileged && req.Privileged {
+		deny("privileged mode is not allowed in namespace %s", req.Namespace)
+	}
+	if r.DenyHostNamespaces {
+		var shared []string
+		if req.HostNetwork {
+			shared = append(shared, "network")
+		}
+		if req.HostPID {
+			shared = append(shared, "PID")
+		}
+		if req.HostIPC {
+			shared = append(shared, "IPC")
+		}
+		if len(shared) != 0 {
+			deny("host %s namespace is not allowed in namespace %s", strings.Join(shared, ", "), req.Namespace)
+		}
+	}
+	for _, denied := range r.DenyHostPaths {
+		resolved, err := kube.ResolveHostPath(denied)
+		if err != nil {
+			resolved = filepath.Clean(denied)
+		}
+		for _, path := range req.HostPaths {
+			if path == resolved || strings.HasPrefix(path, strings.TrimSuffix(resolved, "/")+"/") {
+				deny("host path %s is not allowed in namespace %s", path, req.Namespace)
+			}
+		}
+	}
+	if r.RequireSignedImages && req.Image != "" && !req.ImageSigned {
+		deny("image %s has no verified signature", req.Image)
+	}
+	return denials
+}
+
+func (r AdmissionRule) appliesTo(namespace string) bool {
+	if len(r.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range r.Namespaces {
+		if ns == namespace || ns == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// ask sends request to webhook and returns its decision.
+func (w *AdmissionWebhook) ask(ctx context.Context, req *AdmissionRequest) (*AdmissionResult, error) {
+	timeout := w.Timeout
+	if timeout == 0 {
+		timeout = DefaultAdmissionTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := json.Marshal(struct {
+		Input *AdmissionRequest `json:"input"`
+	}{req})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode admission request: %v", err)
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach admission webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admission webhook responded with %s", resp.Status)
+	}
+	var decision struct {
+		Result *AdmissionResult `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxAdmissionResponse)).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("could not decode admission webhook response: %v", err)
+	}
+	// OPA responds without result when policy is not defined
+	if decision.Result == nil {
+		return nil, fmt.Errorf("admission webhook responded without result")
+	}
+	return decision.Result, nil
+}
+
+// podAdmission returns admission request of pod creation.
+func podAdmission(config *k8s.PodSandboxConfig) *AdmissionRequest {
+	req := &AdmissionRequest{
+		Operation:   "RunPodSandbox",
+		Namespace:   config.GetMetadata().GetNamespace(),
+		PodName:     config.GetMetadata().GetName(),
+		PodUID:      config.GetMetadata().GetUid(),
+		Privileged:  config.GetLinux().GetSecurityContext().GetPrivileged(),
+		Annotations: config.GetAnnotations(),
+	}
+	setHostNamespaces(req, config.GetLinux().GetSecurityContext().GetNamespaceOptions())
+	return req
+}
+
+// containerAdmission returns admission request of container creation,
+// info is nil when image is not found locally.
+func containerAdmission(config *k8s.ContainerConfig, pod *kube.Pod, info *image.Info) *AdmissionRequest {
+	req := &AdmissionRequest{
+		Operation:     "CreateContainer",
+		Namespace:     pod.GetMetadata().GetNamespace(),
+		PodName:       pod.GetMetadata().GetName(),
+		PodUID:        pod.GetMetadata().GetUid(),
+		ContainerName: config.GetMetadata().GetName(),
+		Image:         config.GetImage().GetImage(),
+		Privileged:    config.GetLinux().GetSecurityContext().GetPrivileged(),
+		Annotations:   config.GetAnnotations(),
+	}
+	setHostNamespaces(req, pod.GetLinux().GetSecurityContext().GetNamespaceOptions())
+	if info != nil {
+		for _, sig := range info.Signatures {
+			if sig.Verified() {
+				req.ImageSigned = true
+				break
+			}
+		}
+	}
+	for _, m := range config.GetMounts() {
+		path, err := kube.ResolveHostPath(m.GetHostPath())
+		if err != nil {
+			path = filepath.Clean(m.GetHostPath())
+		}
+		req.HostPaths = append(req.HostPaths, path)
+	}
+	return req
+}
+
+func setHostNamespaces(req *AdmissionRequest, opts *k8s.NamespaceOption) {
+	req.HostNetwork = opts.GetNetwork() == k8s.NamespaceMode_NODE
+	req.HostPID = opts.GetPid() == k8s.NamespaceMode_NODE
+	req.HostIPC = opts.GetIpc() == k8s.NamespaceMode_NODE
+}
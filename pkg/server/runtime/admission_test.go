@@ -0,0 +1,219 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdmissionPolicy_Validate(t *testing.T) {
+	tt := []struct {
+		name        string
+		policy      *AdmissionPolicy
+		expectError bool
+	}{
+		{
+			name: "no policy",
+		},
+		{
+			name: "all ok",
+			policy: &AdmissionPolicy{
+				Rules:   []AdmissionRule{{Name: "restricted", DenyHostPaths: []string{"/etc"}}},
+				Webhook: &AdmissionWebhook{URL: "http://127.0.0.1:8181/v1/data/sycri", Failure: HookFailureLog},
+			},
+		},
+		{
+			name:        "unnamed rule",
+			policy:      &AdmissionPolicy{Rules: []AdmissionRule{{DenyPrivileged: true}}},
+			expectError: true,
+		},
+		{
+			name:        "duplicate rule",
+			policy:      &AdmissionPolicy{Rules: []AdmissionRule{{Name: "a"}, {Name: "a"}}},
+			expectError: true,
+		},
+		{
+			name:        "relative host path",
+			policy:      &AdmissionPolicy{Rules: []AdmissionRule{{Name: "a", DenyHostPaths: []string{"etc"}}}},
+			expectError: true,
+		},
+		{
+			name:        "relative webhook URL",
+			policy:      &AdmissionPolicy{Webhook: &AdmissionWebhook{URL: "/v1/data/sycri"}},
+			expectError: true,
+		},
+		{
+			name:        "unknown failure policy",
+			policy:      &AdmissionPolicy{Webhook: &AdmissionWebhook{URL: "http://opa:8181", Failure: "retry"}},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.Validate()
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestAdmissionRule_check(t *testing.T) {
+	rule := AdmissionRule{
+		Name:                "restricted",
+		Namespaces:          []string{"apps"},
+		DenyPrivileged:      true,
+		DenyHostNamespaces:  true,
+		DenyHostPaths:       []string{"/etc/"},
+		RequireSignedImages: true,
+	}
+
+	tt := []struct {
+		name    string
+		req     AdmissionRequest
+		reasons []string
+	}{
+		{
+			name: "other namespace",
+			req:  AdmissionRequest{Namespace: "kube-system", Privileged: true, HostNetwork: true},
+		},
+		{
+			name: "compliant",
+			req:  AdmissionRequest{Namespace: "apps", Image: "alpine", ImageSigned: true, HostPaths: []string{"/etcd/data"}},
+		},
+		{
+			name: "everything denied",
+			req: AdmissionRequest{
+				Namespace:   "apps",
+				Image:       "alpine",
+				Privileged:  true,
+				HostNetwork: true,
+				HostPID:     true,
+				HostPaths:   []string{"/var/lib/kubelet/pods/x", "/etc/shadow"},
+			},
+			reasons: []string{
+				"privileged mode is not allowed in namespace apps",
+				"host network, PID namespace is not allowed in namespace apps",
+				"host path /etc/shadow is not allowed in namespace apps",
+				"image alpine has no verified signature",
+			},
+		},
+		{
+			name: "pod without image",
+			req:  AdmissionRequest{Namespace: "apps"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var reasons []string
+			for _, d := range rule.check(&tc.req) {
+				require.Equal(t, "restricted", d.Rule)
+				reasons = append(reasons, d.Reason)
+			}
+			require.Equal(t, tc.reasons, reasons)
+		})
+	}
+}
+
+func TestAdmissionWebhook(t *testing.T) {
+	var received AdmissionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input AdmissionRequest `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		received = body.Input
+		switch body.Input.Namespace {
+		case "allowed":
+			w.Write([]byte(`{"result": {"allowed": true}}`))
+		case "denied":
+			w.Write([]byte(`{"result": {"allowed": false, "denials": [{"rule": "no-latest", "reason": "image tag latest"}, {"reason": "other"}]}}`))
+		case "undefined":
+			w.Write([]byte(`{}`))
+		case "slow":
+			time.Sleep(time.Second)
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	tt := []struct {
+		name      string
+		namespace string
+		failure   HookFailurePolicy
+		expect    string
+	}{
+		{
+			name:      "allowed",
+			namespace: "allowed",
+		},
+		{
+			name:      "denied",
+			namespace: "denied",
+			expect:    "admission denied: no-latest: image tag latest; webhook: other",
+		},
+		{
+			name:      "undefined policy",
+			namespace: "undefined",
+			expect:    "admission denied: webhook: admission webhook responded without result",
+		},
+		{
+			name:      "server error",
+			namespace: "error",
+			expect:    "admission denied: webhook: admission webhook responded with 500 Internal Server Error",
+		},
+		{
+			name:      "server error ignored",
+			namespace: "error",
+			failure:   HookFailureLog,
+		},
+		{
+			name:      "timeout",
+			namespace: "slow",
+			expect:    "admission denied: webhook: could not reach admission webhook",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &SingularityRuntime{admission: &AdmissionPolicy{
+				Webhook: &AdmissionWebhook{URL: server.URL, Timeout: 100 * time.Millisecond, Failure: tc.failure},
+			}}
+			req := &AdmissionRequest{Operation: "RunPodSandbox", Namespace: tc.namespace, PodName: "pod"}
+			err := s.admit(context.Background(), req)
+			require.Equal(t, *req, received)
+			if tc.expect == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Equal(t, codes.PermissionDenied, status.Code(err))
+			require.Contains(t, status.Convert(err).Message(), tc.expect)
+		})
+	}
+}
@@ -16,19 +16,24 @@ package runtime
 
 import (
 	"context"
-	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/image"
 	"github.com/sylabs/singularity-cri/pkg/index"
 	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity-cri/pkg/spec"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
 // CreateContainer creates a new container in specified PodSandbox.
-func (s *SingularityRuntime) CreateContainer(_ context.Context, req *k8s.CreateContainerRequest) (*k8s.CreateContainerResponse, error) {
+func (s *SingularityRuntime) CreateContainer(ctx context.Context, req *k8s.CreateContainerRequest) (*k8s.CreateContainerResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	if req.GetConfig().GetTty() && !req.GetConfig().GetStdin() {
 		return nil, status.Error(codes.InvalidArgument, "tty requires stdin to be true")
 	}
@@ -41,53 +46,173 @@ func (s *SingularityRuntime) CreateContainer(_ context.Context, req *k8s.CreateC
 		req.GetConfig().GetLinux().GetSecurityContext().GetRunAsUsername() == "" {
 		return nil, status.Error(codes.InvalidArgument, "RunAsGroup should only be specified when RunAsUser or RunAsUsername is specified")
 	}
+	if _, err := kube.ParseLogDriver(req.GetConfig().GetAnnotations()[kube.AnnotationLogDriver]); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s annotation: %v", kube.AnnotationLogDriver, err)
+	}
+	if _, err := kube.ParseNetClassID(req.GetConfig().GetAnnotations()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := kube.ParseLogBufferSize(req.GetConfig().GetAnnotations()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := kube.ParseWritableLayerSize(req.GetConfig().GetAnnotations()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := kube.ParseVolumes(req.GetConfig().GetAnnotations()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	info, err := s.imageIndex.Find(req.Config.GetImage().GetImage())
 	if err == index.ErrNotFound {
 		return nil, status.Error(codes.NotFound, "image is not found")
 	}
+	if err == nil {
+		if err := s.checkImage(info); err != nil {
+			return nil, err
+		}
+		info.MarkUsed(time.Now())
+	}
 
 	pod, err := s.findPod(req.PodSandboxId)
 	if err != nil {
 		return nil, err
 	}
+	if _, err := kube.ParseContainerDNS(req.GetConfig().GetAnnotations(), pod.GetDnsConfig()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := kube.ParseHugepageLimits(req.GetConfig().GetAnnotations()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := kube.ValidateNamespaces(req.GetConfig(), pod); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := kube.ValidateCPUSet(req.GetConfig().GetLinux().GetResources()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if s.numaMemoryBinding {
+		kube.BindNUMAMemory(req.GetConfig().GetLinux().GetResources())
+	}
+	if err := kube.ValidateCPUWeight(req.GetConfig().GetLinux().GetResources()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	md := req.GetConfig().GetMetadata()
+	if err := s.faults.inject(ctx, "CreateContainer", containerFaultKey(pod.ID(), md), req.GetConfig().GetAnnotations()); err != nil {
+		return nil, err
+	}
+	existing, err := s.containers.FindByName(pod.ID(), md.GetName(), md.GetAttempt())
+	if err == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "container %s with name %q and attempt %d already exists",
+			existing.ID(), md.GetName(), md.GetAttempt())
+	}
 
-	cont := kube.NewContainer(req.Config, pod, info, s.trashDir)
+	if err := s.admit(ctx, containerAdmission(req.GetConfig(), pod, info)); err != nil {
+		return nil, err
+	}
+	cont := kube.NewContainer(req.Config, pod, info, s.trashDir, s.containerOptions()...)
 	cleanupOnFailure := func() {
 		if err := s.containers.Remove(cont.ID()); err != nil {
 			glog.Errorf("Could not remove container from index: %v", err)
 		}
 	}
 	contBaseDir := filepath.Join(s.baseRunDir, "containers", cont.ID())
-	if err := cont.Create(contBaseDir); err != nil {
+	s.inFlight.add(cont.ID())
+	defer s.inFlight.remove(cont.ID())
+	err = cont.Create(ctx, contBaseDir)
+	if _, ok := err.(*spec.ValidationError); ok {
+		cleanupOnFailure()
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, ok := err.(*kube.MountError); ok {
+		cleanupOnFailure()
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if _, ok := err.(*kube.WorkingDirError); ok {
+		cleanupOnFailure()
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
 		cleanupOnFailure()
 		return nil, status.Errorf(codes.Internal, "could not create container: %v", err)
 	}
 
 	err = s.containers.Add(cont)
+	if err == index.ErrIDExists {
+		// indexed container owns the same ID, so nothing can be cleaned up safely
+		glog.Errorf("Container ID %s collides with existing container", cont.ID())
+		return nil, status.Errorf(codes.AlreadyExists, "container with ID %s already exists", cont.ID())
+	}
+	if err == index.ErrAlreadyExists {
+		// concurrent request has created the same container
+		if err := cont.Remove(); err != nil {
+			glog.Errorf("Could not remove duplicate container: %v", err)
+		}
+		return nil, status.Errorf(codes.AlreadyExists, "container with name %q and attempt %d already exists",
+			md.GetName(), md.GetAttempt())
+	}
 	if err != nil {
 		cleanupOnFailure()
 		return nil, err
 	}
+	s.emitContainerEvent(cont, ContainerCreatedEvent)
+	s.reclaimPreviousAttempts(cont)
 	return &k8s.CreateContainerResponse{
 		ContainerId: cont.ID(),
 	}, nil
 }
 
+// containerOptions returns options containers are constructed with.
+func (s *SingularityRuntime) containerOptions() []kube.ContainerOption {
+	opts := []kube.ContainerOption{
+		kube.WithMountPolicy(s.mountPolicy),
+		kube.WithSELinuxRelabel(s.selinuxRelabel),
+		kube.WithNvidiaFiles(s.nvidia),
+		kube.WithOCIHooks(s.ociHooks),
+		kube.WithContainerAnnotations(s.annotations),
+		kube.WithLowerDirs(s.lowerDirs),
+		kube.WithWritableLayerLimit(s.writableSize, s.writableQuota),
+		kube.WithLogDriver(s.logDriver),
+		kube.WithLogBuffer(s.logBufferSize, s.logOverflow),
+		kube.WithLogRotation(s.logRotation),
+		kube.WithAttachReplay(s.attachReplay),
+		kube.WithContainerDefaults(s.contDefaults),
+		kube.WithMonitor(s.monitorPath),
+	}
+	if s.minOOMScoreAdj != nil {
+		opts = append(opts, kube.WithRestrictedOOMScoreAdj(*s.minOOMScoreAdj))
+	}
+	return opts
+}
+
 // StartContainer starts the container.
-func (s *SingularityRuntime) StartContainer(_ context.Context, req *k8s.StartContainerRequest) (*k8s.StartContainerResponse, error) {
+func (s *SingularityRuntime) StartContainer(ctx context.Context, req *k8s.StartContainerRequest) (*k8s.StartContainerResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	cont, err := s.findContainer(req.ContainerId)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.injectContainerFault(ctx, "StartContainer", cont); err != nil {
+		return nil, err
+	}
 
-	err = cont.Start()
+	err = cont.Start(ctx)
 	if err == kube.ErrContainerNotCreated {
 		return nil, status.Errorf(codes.InvalidArgument, "attempt to start container in %s state", cont.State())
 	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not start container: %v", err)
 	}
+	s.emitContainerEvent(cont, ContainerStartedEvent)
+	pod, _ := s.pods.Find(cont.PodID())
+	if err := s.hooks.run(containerHookPayload(HookContainerStarted, cont, pod)); err != nil {
+		if err := cont.Stop(0); err != nil {
+			glog.Errorf("Could not stop container %s: %v", cont.ID(), err)
+		}
+		s.emitContainerEvent(cont, ContainerStoppedEvent)
+		return nil, status.Errorf(codes.Internal, "could not start container: %v", err)
+	}
 	return &k8s.StartContainerResponse{}, nil
 }
 
@@ -95,22 +220,35 @@ func (s *SingularityRuntime) StartContainer(_ context.Context, req *k8s.StartCon
 // This call is idempotent, and must not return an error if the container has
 // already been stopped. If a grace period is reached runtime will be asked
 // to kill container.
-func (s *SingularityRuntime) StopContainer(_ context.Context, req *k8s.StopContainerRequest) (*k8s.StopContainerResponse, error) {
+func (s *SingularityRuntime) StopContainer(ctx context.Context, req *k8s.StopContainerRequest) (*k8s.StopContainerResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	cont, err := s.findContainer(req.ContainerId)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.injectContainerFault(ctx, "StopContainer", cont); err != nil {
+		return nil, err
+	}
 
 	if err := cont.Stop(req.Timeout); err != nil {
 		return nil, status.Errorf(codes.Internal, "could not stop container: %v", err)
 	}
+	s.emitContainerEvent(cont, ContainerStoppedEvent)
+	if err := s.runContainerExitedHooks(cont, true); err != nil {
+		return nil, status.Errorf(codes.Internal, "container is stopped: %v", err)
+	}
 	return &k8s.StopContainerResponse{}, nil
 }
 
 // RemoveContainer removes the container. If the container is running,
 // the container must be forcibly removed. This call is idempotent, and
 // must not return an error if the container has already been removed.
-func (s *SingularityRuntime) RemoveContainer(_ context.Context, req *k8s.RemoveContainerRequest) (*k8s.RemoveContainerResponse, error) {
+func (s *SingularityRuntime) RemoveContainer(ctx context.Context, req *k8s.RemoveContainerRequest) (*k8s.RemoveContainerResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	cont, err := s.containers.Find(req.ContainerId)
 	if err == index.ErrNotFound {
 		return &k8s.RemoveContainerResponse{}, nil
@@ -118,6 +256,9 @@ func (s *SingularityRuntime) RemoveContainer(_ context.Context, req *k8s.RemoveC
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	if err := s.injectContainerFault(ctx, "RemoveContainer", cont); err != nil {
+		return nil, err
+	}
 
 	if err := cont.Remove(); err != nil {
 		return nil, status.Errorf(codes.Internal, "could not remove container: %v", err)
@@ -125,12 +266,16 @@ func (s *SingularityRuntime) RemoveContainer(_ context.Context, req *k8s.RemoveC
 	if err := s.containers.Remove(cont.ID()); err != nil {
 		return nil, status.Errorf(codes.Internal, "could not remove container from index: %v", err)
 	}
+	s.emitContainerEvent(cont, ContainerDeletedEvent)
 	return &k8s.RemoveContainerResponse{}, nil
 }
 
 // ContainerStatus returns status of the container.
 // If the container is not present, returns an error.
 func (s *SingularityRuntime) ContainerStatus(_ context.Context, req *k8s.ContainerStatusRequest) (*k8s.ContainerStatusResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	cont, err := s.findContainer(req.ContainerId)
 	if err != nil {
 		return nil, err
@@ -139,59 +284,39 @@ func (s *SingularityRuntime) ContainerStatus(_ context.Context, req *k8s.Contain
 	if err := cont.UpdateState(); err != nil {
 		return nil, status.Errorf(codes.Internal, "could not update container state: %v", err)
 	}
+	s.runContainerExitedHooks(cont, false)
 
 	var verboseInfo map[string]string
 	if req.Verbose {
-		verboseInfo = map[string]string{
-			"pid": fmt.Sprintf("%d", cont.Pid()),
+		verboseInfo, err = s.containerInfo(cont)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not get verbose info: %v", err)
 		}
 	}
 	return &k8s.ContainerStatusResponse{
-		Status: &k8s.ContainerStatus{
-			Id:          cont.ID(),
-			Metadata:    cont.GetMetadata(),
-			State:       cont.State(),
-			CreatedAt:   cont.CreatedAt(),
-			StartedAt:   cont.StartedAt(),
-			FinishedAt:  cont.FinishedAt(),
-			ExitCode:    cont.ExitCode(),
-			Image:       cont.GetImage(),
-			ImageRef:    cont.ImageID(),
-			Reason:      cont.StateReason(),
-			Message:     cont.ExitDescription(),
-			Labels:      cont.GetLabels(),
-			Annotations: cont.GetAnnotations(),
-			Mounts:      cont.GetMounts(),
-			LogPath:     cont.LogPath(),
-		},
-		Info: verboseInfo,
+		Status: containerStatus(cont),
+		Info:   verboseInfo,
 	}, nil
 }
 
 // ListContainers lists all containers by filters.
 func (s *SingularityRuntime) ListContainers(_ context.Context, req *k8s.ListContainersRequest) (*k8s.ListContainersResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+	_, all := s.listContainers()
+	filter := req.GetFilter()
+	if isEmptyFilter(filter) {
+		return &k8s.ListContainersResponse{
+			Containers: all,
+		}, nil
+	}
 	var containers []*k8s.Container
-
-	appendContToResult := func(cont *kube.Container) {
-		if err := cont.UpdateState(); err != nil {
-			glog.Errorf("Could not fetch container %s: %v", cont.ID(), err)
-			return
-		}
-		if cont.MatchesFilter(req.Filter) {
-			containers = append(containers, &k8s.Container{
-				Id:           cont.ID(),
-				PodSandboxId: cont.PodID(),
-				Metadata:     cont.GetMetadata(),
-				Image:        cont.GetImage(),
-				ImageRef:     cont.ImageID(),
-				State:        cont.State(),
-				CreatedAt:    cont.CreatedAt(),
-				Labels:       cont.GetLabels(),
-				Annotations:  cont.GetAnnotations(),
-			})
+	for _, c := range all {
+		if messageMatches(c, filter) {
+			containers = append(containers, c)
 		}
 	}
-	s.containers.Iterate(appendContToResult)
 	return &k8s.ListContainersResponse{
 		Containers: containers,
 	}, nil
@@ -207,3 +332,72 @@ func (s *SingularityRuntime) findContainer(id string) (*kube.Container, error) {
 	}
 	return cont, nil
 }
+
+// reclaimPreviousAttempts frees resources of exited previous attempts of
+// the container. Their status and logs are kept until kubelet removes them.
+func (s *SingularityRuntime) reclaimPreviousAttempts(cont *kube.Container) {
+	md := cont.GetMetadata()
+	for _, prev := range s.containers.FindAttempts(cont.PodID(), md.GetName()) {
+		if prev.GetMetadata().GetAttempt() >= md.GetAttempt() {
+			continue
+		}
+		if err := prev.Reclaim(); err != nil {
+			glog.Errorf("Could not reclaim container %s: %v", prev.ID(), err)
+		}
+	}
+}
+
+func containerStatus(cont *kube.Container) *k8s.ContainerStatus {
+	message := cont.ExitDescription()
+	if cont.State() == k8s.ContainerState_CONTAINER_CREATED && cont.StartError() != "" {
+		message = cont.StartError()
+	}
+	if summary := cont.Warnings().Summary(); summary != "" {
+		if message != "" {
+			message += "; "
+		}
+		message += summary
+	}
+	if cont.LogsDisabled() {
+		if message != "" {
+			message += "; "
+		}
+		message += "logs are disabled by null log driver"
+	}
+	return &k8s.ContainerStatus{
+		Id:          cont.ID(),
+		Metadata:    cont.GetMetadata(),
+		State:       cont.State(),
+		CreatedAt:   cont.CreatedAt(),
+		StartedAt:   cont.StartedAt(),
+		FinishedAt:  cont.FinishedAt(),
+		ExitCode:    cont.ExitCode(),
+		Image:       cont.GetImage(),
+		ImageRef:    cont.ImageRef(),
+		Reason:      cont.StateReason(),
+		Message:     message,
+		Labels:      cont.GetLabels(),
+		Annotations: cont.GetAnnotations(),
+		Mounts:      cont.GetMounts(),
+		LogPath:     cont.LogPath(),
+	}
+}
+
+// checkImage verifies image file integrity before it is used by a container.
+// Corrupted image is marked so that the next pull replaces it.
+func (s *SingularityRuntime) checkImage(info *image.Info) error {
+	if reason := info.Corrupt(); reason != "" {
+		return status.Errorf(codes.DataLoss, "image %s is corrupted: %s", info.ID, reason)
+	}
+	err := info.VerifyIntegrity(s.fullImageCheck)
+	if cErr, ok := err.(*image.CorruptError); ok {
+		info.Warnings().Logf(glog.ErrorDepth, "Marking image as corrupted: %v", cErr)
+		info.MarkCorrupt(cErr.Reason)
+		return status.Errorf(codes.DataLoss, "%v", cErr)
+	}
+	if err != nil {
+		info.Warnings().Logf(glog.ErrorDepth, "Could not verify image %s: %v", info.ID, err)
+		return status.Errorf(codes.Internal, "could not verify image: %v", err)
+	}
+	return nil
+}
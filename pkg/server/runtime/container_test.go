@@ -0,0 +1,122 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/critest"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestContainerStatus_Exit(t *testing.T) {
+	srv := critest.StartFakeServer(t, critest.WithImages(critest.FakeImage{Ref: "busybox"}))
+	defer srv.Stop()
+	ctx := context.Background()
+
+	podConfig := critest.PodConfig("exit")
+	pod, err := srv.Runtime.RunPodSandbox(ctx, &k8s.RunPodSandboxRequest{Config: podConfig})
+	require.NoError(t, err)
+	create := func(name string, command ...string) string {
+		resp, err := srv.Runtime.CreateContainer(ctx, &k8s.CreateContainerRequest{
+			PodSandboxId:  pod.GetPodSandboxId(),
+			Config:        critest.ContainerConfig(name, "busybox", command...),
+			SandboxConfig: podConfig,
+		})
+		require.NoError(t, err)
+		return resp.GetContainerId()
+	}
+
+	tt := []struct {
+		name          string
+		command       []string
+		stop          bool
+		expectCode    int32
+		expectReason  string
+		expectMessage string
+	}{
+		{
+			name:         "completed",
+			command:      []string{"true"},
+			expectCode:   0,
+			expectReason: "Completed",
+		},
+		{
+			name:         "exit-code",
+			command:      []string{"sh", "-c", "exit 3"},
+			expectCode:   3,
+			expectReason: "Error",
+		},
+		{
+			name:          "sigsegv",
+			command:       []string{"sh", "-c", "kill -SEGV $$"},
+			expectCode:    139,
+			expectReason:  "Error",
+			expectMessage: "killed by SIGSEGV signal",
+		},
+		{
+			name:          "sigkill",
+			command:       []string{"sleep", "60"},
+			stop:          true,
+			expectCode:    137,
+			expectReason:  "Error",
+			expectMessage: "killed by SIGKILL signal",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			id := create(tc.name, tc.command...)
+			_, err := srv.Runtime.StartContainer(ctx, &k8s.StartContainerRequest{ContainerId: id})
+			require.NoError(t, err)
+			if tc.stop {
+				_, err := srv.Runtime.StopContainer(ctx, &k8s.StopContainerRequest{ContainerId: id})
+				require.NoError(t, err)
+			}
+			st := srv.WaitContainerState(id, k8s.ContainerState_CONTAINER_EXITED, 5*time.Second)
+			require.Equal(t, tc.expectCode, st.GetExitCode())
+			require.Equal(t, tc.expectReason, st.GetReason())
+			require.Equal(t, tc.expectMessage, st.GetMessage())
+		})
+	}
+
+	t.Run("start failure", func(t *testing.T) {
+		id := create("missing", "/not/existing/binary")
+		_, err := srv.Runtime.StartContainer(ctx, &k8s.StartContainerRequest{ContainerId: id})
+		require.Error(t, err)
+		resp, err := srv.Runtime.ContainerStatus(ctx, &k8s.ContainerStatusRequest{ContainerId: id})
+		require.NoError(t, err)
+		require.Equal(t, k8s.ContainerState_CONTAINER_CREATED, resp.GetStatus().GetState())
+		require.Equal(t, "StartError", resp.GetStatus().GetReason())
+		require.Contains(t, resp.GetStatus().GetMessage(), "/not/existing/binary")
+	})
+
+	t.Run("exec sync", func(t *testing.T) {
+		id := create("exec", "sleep", "60")
+		_, err := srv.Runtime.StartContainer(ctx, &k8s.StartContainerRequest{ContainerId: id})
+		require.NoError(t, err)
+		for cmd, code := range map[string]int32{"exit 0": 0, "exit 5": 5, "kill -KILL $$": 137, "kill -SEGV $$": 139} {
+			resp, err := srv.Runtime.ExecSync(ctx, &k8s.ExecSyncRequest{
+				ContainerId: id,
+				Cmd:         []string{"sh", "-c", cmd},
+			})
+			require.NoError(t, err)
+			require.Equal(t, code, resp.GetExitCode(), cmd)
+		}
+	})
+}
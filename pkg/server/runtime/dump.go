@@ -0,0 +1,125 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity/pkg/ociruntime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// PodDump is daemon internal view of a pod. Along with verbose
+// info it holds CRI config pod was run with and engine state.
+type PodDump struct {
+	podVerboseInfo
+	State       string                `json:"state"`
+	Config      *k8s.PodSandboxConfig `json:"config,omitempty"`
+	EngineState *ociruntime.State     `json:"engineState,omitempty"`
+}
+
+// ContainerDump is daemon internal view of a container. Along with
+// verbose info it holds CRI config container was created with and
+// engine state.
+type ContainerDump struct {
+	containerVerboseInfo
+	State       string               `json:"state"`
+	Config      *k8s.ContainerConfig `json:"config,omitempty"`
+	EngineState *ociruntime.State    `json:"engineState,omitempty"`
+}
+
+// DumpPods returns internal view of pods which ID starts with the passed
+// prefix, all pods are returned for empty prefix. Sensitive environment
+// variables and annotations are redacted the same way verbose status does.
+func (s *SingularityRuntime) DumpPods(prefix string) []PodDump {
+	var pods []*kube.Pod
+	s.pods.Iterate(func(pod *kube.Pod) {
+		if strings.HasPrefix(pod.ID(), prefix) {
+			pods = append(pods, pod)
+		}
+	})
+	sort.Slice(pods, func(i, j int) bool { return pods[i].ID() < pods[j].ID() })
+
+	dump := make([]PodDump, 0, len(pods))
+	for _, pod := range pods {
+		d := PodDump{
+			podVerboseInfo: s.podVerboseInfo(pod),
+			State:          pod.State().String(),
+			EngineState:    pod.EngineState(),
+		}
+		if pod.PodSandboxConfig != nil {
+			d.Config = proto.Clone(pod.PodSandboxConfig).(*k8s.PodSandboxConfig)
+			redactAnnotations(d.Config.GetAnnotations(), s.redactedEnvs)
+		}
+		if d.EngineState != nil {
+			d.EngineState.Annotations = redactedCopy(d.EngineState.Annotations, s.redactedEnvs)
+		}
+		dump = append(dump, d)
+	}
+	return dump
+}
+
+// DumpContainers returns internal view of containers which ID or pod ID
+// starts with the passed prefix, all containers are returned for empty
+// prefix. Sensitive values are redacted as in DumpPods.
+func (s *SingularityRuntime) DumpContainers(prefix string) []ContainerDump {
+	var containers []*kube.Container
+	s.containers.Iterate(func(cont *kube.Container) {
+		if strings.HasPrefix(cont.ID(), prefix) || strings.HasPrefix(cont.PodID(), prefix) {
+			containers = append(containers, cont)
+		}
+	})
+	sort.Slice(containers, func(i, j int) bool { return containers[i].ID() < containers[j].ID() })
+
+	dump := make([]ContainerDump, 0, len(containers))
+	for _, cont := range containers {
+		d := ContainerDump{
+			containerVerboseInfo: s.containerVerboseInfo(cont),
+			State:                cont.State().String(),
+			EngineState:          cont.EngineState(),
+		}
+		if cont.ContainerConfig != nil {
+			d.Config = proto.Clone(cont.ContainerConfig).(*k8s.ContainerConfig)
+			for _, env := range d.Config.GetEnvs() {
+				if isRedacted(env.GetKey(), s.redactedEnvs) {
+					env.Value = redactedValue
+				}
+			}
+			redactAnnotations(d.Config.GetAnnotations(), s.redactedEnvs)
+		}
+		if d.EngineState != nil {
+			d.EngineState.Annotations = redactedCopy(d.EngineState.Annotations, s.redactedEnvs)
+		}
+		dump = append(dump, d)
+	}
+	return dump
+}
+
+// redactedCopy returns a copy of annotations with sensitive values hidden,
+// engine state is shared with the pod or container, so it is never modified.
+func redactedCopy(annotations map[string]string, patterns []string) map[string]string {
+	if annotations == nil {
+		return nil
+	}
+	res := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		res[k] = v
+	}
+	redactAnnotations(res, patterns)
+	return res
+}
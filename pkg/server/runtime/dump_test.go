@@ -0,0 +1,49 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestSingularityRuntime_DumpContainers(t *testing.T) {
+	s, engine := newListRuntime()
+	s.redactedEnvs = DefaultRedactedEnvs
+	cont := kube.NewContainer(&k8s.ContainerConfig{
+		Metadata:    &k8s.ContainerMetadata{Name: "secret"},
+		Envs:        []*k8s.KeyValue{{Key: "API_TOKEN", Value: "hunter2"}, {Key: "MODE", Value: "debug"}},
+		Annotations: map[string]string{"db-password": "hunter2"},
+	}, engine.pod, &image.Info{}, "", kube.WithContainerEngine(engine))
+	require.NoError(t, s.containers.Add(cont))
+	require.NoError(t, cont.UpdateState())
+	require.NoError(t, addListContainer(t, s, engine, "other").UpdateState())
+
+	dump := s.DumpContainers(cont.ID()[:12])
+	require.Len(t, dump, 1)
+	require.Equal(t, cont.ID(), dump[0].ID)
+	require.Equal(t, k8s.ContainerState_CONTAINER_RUNNING.String(), dump[0].State)
+	require.NotNil(t, dump[0].EngineState)
+	require.Equal(t, "running", dump[0].EngineState.Status)
+	require.Equal(t, []*k8s.KeyValue{{Key: "API_TOKEN", Value: redactedValue}, {Key: "MODE", Value: "debug"}}, dump[0].Config.Envs)
+	require.Equal(t, map[string]string{"db-password": redactedValue}, dump[0].Config.Annotations)
+	require.Equal(t, "hunter2", cont.GetEnvs()[0].GetValue(), "container config must not be modified")
+
+	require.Len(t, s.DumpContainers(engine.pod.ID()), 2)
+}
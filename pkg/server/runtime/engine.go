@@ -0,0 +1,257 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	sRuntime "github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+)
+
+// engineProbeDelay is a delay between the last engine file change
+// and engine re-probe so that a single upgrade triggers one probe.
+const engineProbeDelay = time.Second
+
+// EngineStatus holds result of the last Singularity engine probe.
+type EngineStatus struct {
+	Path     string    `json:"path"`
+	Version  string    `json:"version,omitempty"`
+	Starter  string    `json:"starter,omitempty"`
+	Setuid   bool      `json:"setuid"`
+	Ready    bool      `json:"ready"`
+	Message  string    `json:"message,omitempty"`
+	Fake     bool      `json:"fake,omitempty"`
+	ProbedAt time.Time `json:"probedAt"`
+}
+
+// engineProbe caches engine status along with the information about
+// binary it was collected for, so that engine is executed only when
+// the binary changes.
+type engineProbe struct {
+	status     EngineStatus
+	binary     os.FileInfo
+	libexecDir string
+}
+
+// RefreshEngineVersion forces Singularity engine probe. It should be called
+// whenever engine may have been upgraded. When engine is found unusable
+// RuntimeReady condition is reported as false until the next successful probe.
+func (s *SingularityRuntime) RefreshEngineVersion() error {
+	return s.probeEngine(true)
+}
+
+// EngineVersion returns version of Singularity engine detected last time.
+func (s *SingularityRuntime) EngineVersion() string {
+	return s.EngineStatus().Version
+}
+
+// EngineStatus returns result of the last Singularity engine probe.
+// It never runs the probe itself so it is safe to call from RPC handlers.
+func (s *SingularityRuntime) EngineStatus() EngineStatus {
+	s.engineMu.RLock()
+	defer s.engineMu.RUnlock()
+	return s.engine.status
+}
+
+// ProbeEngine looks up Singularity engine and checks it is usable the same
+// way runtime does on startup. It is meant for diagnostics without runtime.
+func ProbeEngine() EngineStatus {
+	sing, err := exec.LookPath(singularity.RuntimeName)
+	if err != nil {
+		return EngineStatus{
+			Path:     singularity.RuntimeName,
+			Message:  fmt.Sprintf("could not find %s on this machine: %v", singularity.RuntimeName, err),
+			ProbedAt: time.Now(),
+		}
+	}
+	s := &SingularityRuntime{singularity: sing}
+	_ = s.probeEngine(true)
+	return s.EngineStatus()
+}
+
+// WatchEngine watches Singularity binary and starter directories and
+// re-probes engine whenever anything changes there. Watching stops as
+// soon as ctx is done. Fake engine is not watched.
+func (s *SingularityRuntime) WatchEngine(ctx context.Context) error {
+	if sRuntime.IsFake(s.ociEngine) {
+		return nil
+	}
+	s.engineMu.RLock()
+	dirs := []string{filepath.Dir(s.singularity)}
+	if s.engine.status.Starter != "" && filepath.Dir(s.engine.status.Starter) != dirs[0] {
+		dirs = append(dirs, filepath.Dir(s.engine.status.Starter))
+	}
+	s.engineMu.RUnlock()
+
+	watcher, err := fs.NewWatcher(dirs...)
+	if err != nil {
+		return fmt.Errorf("could not watch engine files: %v", err)
+	}
+	events := watcher.Watch(ctx)
+	go func() {
+		defer watcher.Close()
+
+		timer := time.NewTimer(engineProbeDelay)
+		timer.Stop()
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				glog.V(4).Infof("Engine file %s changed", event.Path)
+				timer.Reset(engineProbeDelay)
+			case <-timer.C:
+				if err := s.probeEngine(false); err != nil {
+					glog.Errorf("Singularity engine is not ready: %v", err)
+				}
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// probeEngine checks Singularity binary and starter are present and
+// usable. Engine version is queried only when binary changed since
+// the last probe or when force is true.
+func (s *SingularityRuntime) probeEngine(force bool) error {
+	if sRuntime.IsFake(s.ociEngine) {
+		s.setEngineStatus(engineProbe{status: EngineStatus{
+			Path:     sRuntime.FakeEngineName,
+			Version:  sRuntime.FakeEngineName,
+			Ready:    true,
+			Message:  "containers are run as host processes",
+			Fake:     true,
+			ProbedAt: time.Now(),
+		}})
+		return nil
+	}
+
+	status := EngineStatus{
+		Path:     s.singularity,
+		ProbedAt: time.Now(),
+	}
+
+	binary, err := os.Stat(s.singularity)
+	if err == nil && binary.Mode()&0111 == 0 {
+		err = fmt.Errorf("%s is not executable", s.singularity)
+	}
+	if err != nil {
+		status.Message = fmt.Sprintf("engine binary is not available: %v", err)
+		s.setEngineStatus(engineProbe{status: status})
+		return fmt.Errorf("%s", status.Message)
+	}
+
+	s.engineMu.RLock()
+	cached := s.engine
+	s.engineMu.RUnlock()
+
+	probe := engineProbe{binary: binary, libexecDir: cached.libexecDir}
+	status.Version = cached.status.Version
+	if force || cached.binary == nil || !sameFile(cached.binary, binary) {
+		version, libexecDir, err := s.queryEngine()
+		if err != nil {
+			status.Message = err.Error()
+			// keep binary info empty so that the next probe queries engine again
+			s.setEngineStatus(engineProbe{status: status})
+			return err
+		}
+		status.Version = version
+		probe.libexecDir = libexecDir
+	}
+
+	status.Starter, status.Setuid, err = findStarter(probe.libexecDir)
+	if err != nil {
+		status.Message = err.Error()
+		probe.status = status
+		s.setEngineStatus(probe)
+		return err
+	}
+	status.Ready = true
+	probe.status = status
+	s.setEngineStatus(probe)
+	return nil
+}
+
+// queryEngine executes engine to get its version and libexec directory.
+func (s *SingularityRuntime) queryEngine() (string, string, error) {
+	out, err := exec.Command(s.singularity, "version").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("could not get engine version: %v", err)
+	}
+	config, err := sRuntime.NewCLIClient().BuildConfig()
+	if err != nil {
+		return "", "", fmt.Errorf("could not get engine build config: %v", err)
+	}
+	return strings.TrimSpace(string(out)), config.LibexecDir, nil
+}
+
+func (s *SingularityRuntime) setEngineStatus(probe engineProbe) {
+	s.engineMu.Lock()
+	defer s.engineMu.Unlock()
+
+	old := s.engine.status
+	switch {
+	case old.Ready && !probe.status.Ready:
+		glog.Errorf("Singularity engine became unusable: %s", probe.status.Message)
+	case !old.Ready && probe.status.Ready && !old.ProbedAt.IsZero():
+		glog.Infof("Singularity engine is usable again")
+	}
+	if old.Version != "" && probe.status.Version != "" && old.Version != probe.status.Version {
+		glog.Infof("Singularity engine version changed from %s to %s", old.Version, probe.status.Version)
+	}
+	s.engine = probe
+}
+
+// findStarter looks for engine starter binary. Setuid starter is
+// preferred, non-setuid one is used when setuid one is not installed.
+// Empty libexec directory means engine didn't report one, in that
+// case starter is not checked.
+func findStarter(libexecDir string) (string, bool, error) {
+	if libexecDir == "" {
+		return "", false, nil
+	}
+	binDir := filepath.Join(libexecDir, "singularity", "bin")
+	suid := filepath.Join(binDir, "starter-suid")
+	fi, err := os.Stat(suid)
+	if err == nil {
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		setuid := fi.Mode()&os.ModeSetuid != 0 && ok && st.Uid == 0
+		return suid, setuid, nil
+	}
+	starter := filepath.Join(binDir, "starter")
+	if _, err := os.Stat(starter); err == nil {
+		return starter, false, nil
+	}
+	return "", false, fmt.Errorf("engine starter is not found in %s", binDir)
+}
+
+// sameFile checks whether both file infos describe the same unchanged file.
+func sameFile(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size() && a.Mode() == b.Mode()
+}
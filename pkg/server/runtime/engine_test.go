@@ -0,0 +1,74 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindStarter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "engine-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	binDir := filepath.Join(dir, "singularity", "bin")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+
+	starter, setuid, err := findStarter("")
+	require.NoError(t, err)
+	require.Empty(t, starter)
+	require.False(t, setuid)
+
+	_, _, err = findStarter(dir)
+	require.Error(t, err, "expected missing starter error")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, "starter"), nil, 0755))
+	starter, setuid, err = findStarter(dir)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(binDir, "starter"), starter)
+	require.False(t, setuid)
+
+	suid := filepath.Join(binDir, "starter-suid")
+	require.NoError(t, ioutil.WriteFile(suid, nil, 0755))
+	require.NoError(t, os.Chmod(suid, 0755|os.ModeSetuid))
+	starter, setuid, err = findStarter(dir)
+	require.NoError(t, err)
+	require.Equal(t, suid, starter)
+	require.Equal(t, os.Getuid() == 0, setuid)
+}
+
+func TestProbeEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "engine-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := &SingularityRuntime{singularity: filepath.Join(dir, "singularity")}
+
+	require.Error(t, s.probeEngine(false))
+	status := s.EngineStatus()
+	require.False(t, status.Ready)
+	require.Contains(t, status.Message, "engine binary is not available")
+
+	require.NoError(t, ioutil.WriteFile(s.singularity, nil, 0644))
+	require.Error(t, s.probeEngine(false))
+	status = s.EngineStatus()
+	require.False(t, status.Ready)
+	require.Contains(t, status.Message, "is not executable")
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity-cri/pkg/metrics"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// DefaultEventBufferSize is the default number of container events
+// buffered for each subscriber before events start to be dropped.
+const DefaultEventBufferSize = 1000
+
+var containerTransitions = metrics.NewCounter("sycri_container_transitions_total",
+	"Number of container lifecycle transitions by CRI event type.", "event")
+
+// ContainerEventType is a type of container lifecycle event.
+type ContainerEventType int
+
+const (
+	// ContainerCreatedEvent is sent when container is created.
+	ContainerCreatedEvent ContainerEventType = iota
+	// ContainerStartedEvent is sent when container is started.
+	ContainerStartedEvent
+	// ContainerStoppedEvent is sent when container is stopped.
+	ContainerStoppedEvent
+	// ContainerDeletedEvent is sent when container is removed.
+	ContainerDeletedEvent
+)
+
+// String returns CRI name of the event type.
+func (t ContainerEventType) String() string {
+	switch t {
+	case ContainerCreatedEvent:
+		return "CONTAINER_CREATED_EVENT"
+	case ContainerStartedEvent:
+		return "CONTAINER_STARTED_EVENT"
+	case ContainerStoppedEvent:
+		return "CONTAINER_STOPPED_EVENT"
+	case ContainerDeletedEvent:
+		return "CONTAINER_DELETED_EVENT"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ContainerEvent describes a single container lifecycle transition.
+type ContainerEvent struct {
+	ContainerID        string
+	Type               ContainerEventType
+	CreatedAt          int64
+	PodSandboxStatus   *k8s.PodSandboxStatus
+	ContainersStatuses []*k8s.ContainerStatus
+	// Overflow is set when some events preceding this one were
+	// dropped because subscriber didn't keep up with the stream.
+	Overflow bool
+}
+
+// EventSubscription is a single subscriber of container events.
+type EventSubscription struct {
+	bus    *eventBus
+	events chan *ContainerEvent
+
+	mu       sync.Mutex
+	overflow bool
+	closed   bool
+}
+
+// Events returns channel container events are delivered to. Channel
+// is closed once subscription is cancelled.
+func (s *EventSubscription) Events() <-chan *ContainerEvent {
+	return s.events
+}
+
+// Cancel stops events delivery and closes events channel.
+func (s *EventSubscription) Cancel() {
+	s.bus.unsubscribe(s)
+}
+
+func (s *EventSubscription) send(event *ContainerEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	e := *event
+	e.Overflow = s.overflow
+	select {
+	case s.events <- &e:
+		s.overflow = false
+	default:
+		if !s.overflow {
+			glog.Warningf("Event subscriber is too slow, dropping container events")
+		}
+		s.overflow = true
+	}
+}
+
+func (s *EventSubscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.events)
+}
+
+// eventBus fans out container events to all subscribers. Each subscriber
+// has its own bounded buffer so a slow one never blocks the runtime.
+type eventBus struct {
+	bufferSize int
+
+	mu   sync.RWMutex
+	subs map[*EventSubscription]struct{}
+}
+
+func newEventBus(bufferSize int) *eventBus {
+	if bufferSize <= 0 {
+		bufferSize = DefaultEventBufferSize
+	}
+	return &eventBus{
+		bufferSize: bufferSize,
+		subs:       make(map[*EventSubscription]struct{}),
+	}
+}
+
+func (b *eventBus) subscribe() *EventSubscription {
+	sub := &EventSubscription{
+		bus:    b,
+		events: make(chan *ContainerEvent, b.bufferSize),
+	}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *eventBus) unsubscribe(sub *EventSubscription) {
+	b.mu.Lock()
+	delete(b.subs, sub)
+	b.mu.Unlock()
+	sub.close()
+}
+
+func (b *eventBus) publish(event *ContainerEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		sub.send(event)
+	}
+}
+
+// WithEventBufferSize sets number of container events buffered for each
+// subscriber, events beyond it are dropped and the next delivered event
+// is marked with overflow flag. Non-positive size means DefaultEventBufferSize.
+func WithEventBufferSize(size int) Option {
+	return func(r *SingularityRuntime) {
+		r.events = newEventBus(size)
+	}
+}
+
+// SubscribeContainerEvents returns a new subscription to container lifecycle
+// events. Caller must cancel subscription once it no longer needs events.
+func (s *SingularityRuntime) SubscribeContainerEvents() *EventSubscription {
+	return s.events.subscribe()
+}
+
+// GetContainerEvents streams container lifecycle events to the client until
+// it goes away. Client that doesn't keep up with the stream misses events,
+// the first event it receives after that has overflow flag set so that it
+// knows to relist containers.
+func (s *SingularityRuntime) GetContainerEvents(_ *admin.GetEventsRequest, stream admin.RuntimeAdmin_GetContainerEventsServer) error {
+	sub := s.SubscribeContainerEvents()
+	defer sub.Cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			err := stream.Send(&admin.ContainerEventResponse{
+				ContainerId:        event.ContainerID,
+				ContainerEventType: admin.ContainerEventType(event.Type),
+				CreatedAt:          event.CreatedAt,
+				PodSandboxStatus:   event.PodSandboxStatus,
+				ContainersStatuses: event.ContainersStatuses,
+				Overflow:           event.Overflow,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *SingularityRuntime) emitContainerEvent(cont *kube.Container, eventType ContainerEventType) {
+	containerTransitions.Inc(eventType.String())
+	event := &ContainerEvent{
+		ContainerID: cont.ID(),
+		Type:        eventType,
+		CreatedAt:   time.Now().UnixNano(),
+	}
+	if eventType != ContainerDeletedEvent {
+		if err := cont.UpdateState(); err != nil {
+			cont.Warnings().Logf(glog.ErrorDepth, "Could not update container %s state: %v", cont.ID(), err)
+		}
+		event.ContainersStatuses = []*k8s.ContainerStatus{containerStatus(cont)}
+	}
+	pod, err := s.pods.Find(cont.PodID())
+	if err == nil {
+		event.PodSandboxStatus = podStatus(pod)
+	}
+	s.syncContainerState(cont, pod, eventType)
+	s.syncContainerRecord(cont, eventType)
+	if eventType == ContainerDeletedEvent {
+		s.hooks.forget(cont.ID())
+	}
+	s.events.publish(event)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"google.golang.org/grpc"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestEventBus(t *testing.T) {
+	tt := []struct {
+		name       string
+		bufferSize int
+		publish    int
+		expect     []bool // overflow flag of each received event
+	}{
+		{
+			name:       "no overflow",
+			bufferSize: 3,
+			publish:    3,
+			expect:     []bool{false, false, false},
+		},
+		{
+			name:       "overflow",
+			bufferSize: 2,
+			publish:    5,
+			expect:     []bool{false, false},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			bus := newEventBus(tc.bufferSize)
+			subs := []*EventSubscription{bus.subscribe(), bus.subscribe()}
+			for i := 0; i < tc.publish; i++ {
+				bus.publish(&ContainerEvent{Type: ContainerCreatedEvent})
+			}
+			for _, sub := range subs {
+				sub.Cancel()
+				var actual []bool
+				for e := range sub.Events() {
+					actual = append(actual, e.Overflow)
+				}
+				require.Equal(t, tc.expect, actual)
+			}
+		})
+	}
+}
+
+func TestEventBus_OverflowFlag(t *testing.T) {
+	bus := newEventBus(1)
+	sub := bus.subscribe()
+	defer sub.Cancel()
+
+	bus.publish(&ContainerEvent{ContainerID: "1"})
+	bus.publish(&ContainerEvent{ContainerID: "2"})
+	e := <-sub.Events()
+	require.Equal(t, "1", e.ContainerID)
+	require.False(t, e.Overflow)
+
+	bus.publish(&ContainerEvent{ContainerID: "3"})
+	e = <-sub.Events()
+	require.Equal(t, "3", e.ContainerID)
+	require.True(t, e.Overflow, "dropped event must be flagged")
+}
+
+type eventStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events chan *admin.ContainerEventResponse
+	err    error
+}
+
+func (s *eventStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *eventStream) Send(e *admin.ContainerEventResponse) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.events <- e
+	return nil
+}
+
+func TestSingularityRuntime_GetContainerEvents(t *testing.T) {
+	s := &SingularityRuntime{events: newEventBus(DefaultEventBufferSize)}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &eventStream{ctx: ctx, events: make(chan *admin.ContainerEventResponse)}
+	done := make(chan error)
+	go func() {
+		done <- s.GetContainerEvents(&admin.GetEventsRequest{}, stream)
+	}()
+	waitSubscribers := func(n int) {
+		for {
+			s.events.mu.RLock()
+			subs := len(s.events.subs)
+			s.events.mu.RUnlock()
+			if subs == n {
+				return
+			}
+			runtime.Gosched()
+		}
+	}
+	waitSubscribers(1)
+
+	status := &k8s.ContainerStatus{Id: "1", State: k8s.ContainerState_CONTAINER_RUNNING}
+	s.events.publish(&ContainerEvent{
+		ContainerID:        "1",
+		Type:               ContainerStartedEvent,
+		CreatedAt:          42,
+		PodSandboxStatus:   &k8s.PodSandboxStatus{Id: "pod"},
+		ContainersStatuses: []*k8s.ContainerStatus{status},
+	})
+	require.Equal(t, &admin.ContainerEventResponse{
+		ContainerId:        "1",
+		ContainerEventType: admin.ContainerEventType_CONTAINER_STARTED_EVENT,
+		CreatedAt:          42,
+		PodSandboxStatus:   &k8s.PodSandboxStatus{Id: "pod"},
+		ContainersStatuses: []*k8s.ContainerStatus{status},
+	}, <-stream.events)
+
+	cancel()
+	require.NoError(t, <-done)
+	waitSubscribers(0)
+
+	stream = &eventStream{ctx: context.Background(), err: fmt.Errorf("client is gone")}
+	go func() {
+		done <- s.GetContainerEvents(&admin.GetEventsRequest{}, stream)
+	}()
+	waitSubscribers(1)
+	s.events.publish(&ContainerEvent{ContainerID: "1", Type: ContainerDeletedEvent})
+	require.EqualError(t, <-done, "client is gone")
+	waitSubscribers(0)
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// AnnotationFakeFaults is a pod or container annotation that injects failures
+// and delays into CRI calls when runtime runs with fake engine. Value is a comma
+// separated list of <rpc>=<fault> pairs, where fault is either fail, fail:<n>
+// to fail the first n calls only, or delay:<duration>, e.g.
+// CreateContainer=fail:1,StopContainer=delay:5s. Annotation is ignored otherwise.
+const AnnotationFakeFaults = "singularity.cri/fake-faults"
+
+// faultRPCs are CRI calls faults may be injected into.
+var faultRPCs = map[string]bool{
+	"RunPodSandbox":    true,
+	"StopPodSandbox":   true,
+	"RemovePodSandbox": true,
+	"CreateContainer":  true,
+	"StartContainer":   true,
+	"StopContainer":    true,
+	"RemoveContainer":  true,
+	"ExecSync":         true,
+}
+
+// fault is a failure injected into a single CRI call.
+type fault struct {
+	// fail is a number of calls that fail, negative means all of them.
+	fail  int
+	delay time.Duration
+}
+
+// parseFaults parses AnnotationFakeFaults value into faults per CRI call.
+func parseFaults(annotations map[string]string) (map[string]fault, error) {
+	val, ok := annotations[AnnotationFakeFaults]
+	if !ok {
+		return nil, nil
+	}
+	faults := make(map[string]fault)
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s annotation: %q is not <rpc>=<fault>", AnnotationFakeFaults, item)
+		}
+		rpc := parts[0]
+		if !faultRPCs[rpc] {
+			return nil, fmt.Errorf("invalid %s annotation: unsupported call %s", AnnotationFakeFaults, rpc)
+		}
+		f := faults[rpc]
+		kind := strings.SplitN(parts[1], ":", 2)
+		switch {
+		case kind[0] == "fail" && len(kind) == 1:
+			f.fail = -1
+		case kind[0] == "fail":
+			n, err := strconv.Atoi(kind[1])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid %s annotation: invalid number of failures %q", AnnotationFakeFaults, kind[1])
+			}
+			f.fail = n
+		case kind[0] == "delay" && len(kind) == 2:
+			d, err := time.ParseDuration(kind[1])
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid %s annotation: invalid delay %q", AnnotationFakeFaults, kind[1])
+			}
+			f.delay = d
+		default:
+			return nil, fmt.Errorf("invalid %s annotation: unknown fault %q", AnnotationFakeFaults, parts[1])
+		}
+		faults[rpc] = f
+	}
+	return faults, nil
+}
+
+// faultInjector injects faults requested with AnnotationFakeFaults. Failures
+// are counted per pod or container name rather than ID, so that a fault limited
+// to n calls is not repeated when kubelet retries with a new sandbox or attempt.
+// Nil faultInjector injects nothing.
+type faultInjector struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{calls: make(map[string]int)}
+}
+
+// inject delays and fails rpc call of the object identified by key according
+// to the passed annotations. Delay is interrupted once ctx is done.
+func (f *faultInjector) inject(ctx context.Context, rpc, key string, annotations map[string]string) error {
+	if f == nil {
+		return nil
+	}
+	faults, err := parseFaults(annotations)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	flt, ok := faults[rpc]
+	if !ok {
+		return nil
+	}
+
+	if flt.delay != 0 {
+		glog.V(2).Infof("Delaying %s of %s by %s", rpc, key, flt.delay)
+		select {
+		case <-time.After(flt.delay):
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if flt.fail == 0 {
+		return nil
+	}
+
+	f.mu.Lock()
+	call := rpc + "/" + key
+	f.calls[call]++
+	n := f.calls[call]
+	f.mu.Unlock()
+	if flt.fail > 0 && n > flt.fail {
+		return nil
+	}
+	glog.V(2).Infof("Failing %s of %s, call %d", rpc, key, n)
+	return status.Errorf(codes.Unavailable, "injected %s failure %d of %s", rpc, n, key)
+}
+
+// podFaultKey identifies pod for fault injection.
+func podFaultKey(md *k8s.PodSandboxMetadata) string {
+	return md.GetNamespace() + "/" + md.GetName() + "/" + md.GetUid()
+}
+
+// containerFaultKey identifies container for fault injection.
+func containerFaultKey(podID string, md *k8s.ContainerMetadata) string {
+	return podID + "/" + md.GetName()
+}
+
+// injectContainerFault injects rpc faults requested by container annotations.
+func (s *SingularityRuntime) injectContainerFault(ctx context.Context, rpc string, cont *kube.Container) error {
+	return s.faults.inject(ctx, rpc, containerFaultKey(cont.PodID(), cont.GetMetadata()), cont.GetAnnotations())
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseFaults(t *testing.T) {
+	tt := []struct {
+		name      string
+		value     string
+		expect    map[string]fault
+		expectErr bool
+	}{
+		{
+			name:   "single failure",
+			value:  "CreateContainer=fail:1",
+			expect: map[string]fault{"CreateContainer": {fail: 1}},
+		},
+		{
+			name:  "multiple faults",
+			value: "StartContainer=fail, StopContainer=delay:5s,StopContainer=fail:2",
+			expect: map[string]fault{
+				"StartContainer": {fail: -1},
+				"StopContainer":  {fail: 2, delay: 5 * time.Second},
+			},
+		},
+		{
+			name:      "unknown call",
+			value:     "PullImage=fail",
+			expectErr: true,
+		},
+		{
+			name:      "unknown fault",
+			value:     "StartContainer=crash",
+			expectErr: true,
+		},
+		{
+			name:      "invalid failures",
+			value:     "StartContainer=fail:0",
+			expectErr: true,
+		},
+		{
+			name:      "invalid delay",
+			value:     "StopContainer=delay:soon",
+			expectErr: true,
+		},
+		{
+			name:      "no fault",
+			value:     "StopContainer",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			faults, err := parseFaults(map[string]string{AnnotationFakeFaults: tc.value})
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, faults)
+		})
+	}
+}
+
+func TestFaultInjector(t *testing.T) {
+	ctx := context.Background()
+	annotations := map[string]string{AnnotationFakeFaults: "CreateContainer=fail:2,StopContainer=delay:50ms"}
+
+	var disabled *faultInjector
+	require.NoError(t, disabled.inject(ctx, "CreateContainer", "pod/cont", annotations))
+
+	f := newFaultInjector()
+	for i := 0; i < 2; i++ {
+		err := f.inject(ctx, "CreateContainer", "pod/cont", annotations)
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	}
+	require.NoError(t, f.inject(ctx, "CreateContainer", "pod/cont", annotations))
+	require.Error(t, f.inject(ctx, "CreateContainer", "pod/other", annotations))
+	require.NoError(t, f.inject(ctx, "StartContainer", "pod/cont", annotations))
+
+	start := time.Now()
+	require.NoError(t, f.inject(ctx, "StopContainer", "pod/cont", annotations))
+	require.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err := f.inject(cancelled, "StopContainer", "pod/cont", annotations)
+	require.Equal(t, codes.Canceled, status.Code(err))
+
+	err = f.inject(ctx, "StopContainer", "pod/cont", map[string]string{AnnotationFakeFaults: "StopContainer=later"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	sRuntime "github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+)
+
+// RuntimeHandler is a named way of running pods that Kubernetes RuntimeClass
+// refers to with its handler field. Pods of a handler are run with Singularity
+// installed at Path, e.g. a non-setuid installation running containers in user
+// namespace, with Flags passed to each of its oci commands. Pods that request
+// no handler or singularity one are run with the default Singularity in PATH.
+type RuntimeHandler struct {
+	// Name is a handler name RuntimeClass refers to.
+	Name string `json:"name"`
+	// Path is a path to singularity binary, empty means the one in PATH.
+	Path string `json:"path,omitempty"`
+	// Flags are global singularity flags, e.g. --nocolor.
+	Flags []string `json:"flags,omitempty"`
+}
+
+// Validate checks handler is fully and correctly defined.
+func (h RuntimeHandler) Validate() error {
+	if h.Name == "" {
+		return fmt.Errorf("name must be set")
+	}
+	if h.Name == singularity.RuntimeName {
+		return fmt.Errorf("%s is the default runtime handler", h.Name)
+	}
+	for _, flag := range h.Flags {
+		if !strings.HasPrefix(flag, "-") {
+			return fmt.Errorf("handler %s flag %q does not start with -", h.Name, flag)
+		}
+	}
+	return nil
+}
+
+// WithRuntimeHandlers sets runtime handlers pods may request in addition to
+// the default one. Handlers are expected to be validated with RuntimeHandler.Validate.
+func WithRuntimeHandlers(handlers []RuntimeHandler) Option {
+	return func(r *SingularityRuntime) {
+		r.handlers = handlers
+	}
+}
+
+// setUpHandlers creates engines of configured runtime handlers.
+// All handlers share fake engine when it is used.
+func (s *SingularityRuntime) setUpHandlers() error {
+	s.handlerEngines = make(map[string]sRuntime.Engine, len(s.handlers))
+	for _, h := range s.handlers {
+		if _, ok := s.handlerEngines[h.Name]; ok {
+			return fmt.Errorf("duplicate runtime handler %s", h.Name)
+		}
+		if sRuntime.IsFake(s.ociEngine) {
+			s.handlerEngines[h.Name] = s.ociEngine
+			continue
+		}
+		path := s.singularity
+		if h.Path != "" {
+			var err error
+			path, err = exec.LookPath(h.Path)
+			if err != nil {
+				return fmt.Errorf("could not find singularity of runtime handler %s: %v", h.Name, err)
+			}
+		}
+		s.handlerEngines[h.Name] = sRuntime.NewCLIClientFor(path, h.Flags...)
+	}
+	return nil
+}
+
+// handlerEngine returns engine pods of the runtime handler are run with.
+// Nil engine is returned for the default handler unless fake engine is used.
+func (s *SingularityRuntime) handlerEngine(name string) (sRuntime.Engine, error) {
+	if name == "" || name == singularity.RuntimeName {
+		return s.ociEngine, nil
+	}
+	engine, ok := s.handlerEngines[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown runtime handler %q", name)
+	}
+	return engine, nil
+}
+
+// runtimeHandlers returns all handlers pods may request including the default one.
+func (s *SingularityRuntime) runtimeHandlers() []RuntimeHandler {
+	handlers := []RuntimeHandler{{Name: singularity.RuntimeName, Path: s.singularity}}
+	return append(handlers, s.handlers...)
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/critest"
+	"github.com/sylabs/singularity-cri/pkg/server/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestRuntimeHandler_Validate(t *testing.T) {
+	tt := []struct {
+		name        string
+		handler     runtime.RuntimeHandler
+		expectError bool
+	}{
+		{
+			name:    "valid",
+			handler: runtime.RuntimeHandler{Name: "userns", Path: "/opt/singularity/bin/singularity", Flags: []string{"--nocolor"}},
+		},
+		{
+			name:        "no name",
+			handler:     runtime.RuntimeHandler{Path: "/opt/singularity/bin/singularity"},
+			expectError: true,
+		},
+		{
+			name:        "default name",
+			handler:     runtime.RuntimeHandler{Name: "singularity"},
+			expectError: true,
+		},
+		{
+			name:        "not a flag",
+			handler:     runtime.RuntimeHandler{Name: "userns", Flags: []string{"nocolor"}},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.handler.Validate()
+			require.Equal(t, tc.expectError, err != nil, "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestRunPodSandbox_RuntimeHandler(t *testing.T) {
+	srv := critest.StartFakeServer(t,
+		critest.WithRuntimeOptions(runtime.WithRuntimeHandlers([]runtime.RuntimeHandler{
+			{Name: "userns", Flags: []string{"--nocolor"}},
+		})),
+	)
+	defer srv.Stop()
+	ctx := context.Background()
+
+	resp, err := srv.Runtime.Status(ctx, &k8s.StatusRequest{Verbose: true})
+	require.NoError(t, err)
+	var handlers []runtime.RuntimeHandler
+	require.NoError(t, json.Unmarshal([]byte(resp.GetInfo()["runtimeHandlers"]), &handlers))
+	require.Len(t, handlers, 2)
+	require.Equal(t, "singularity", handlers[0].Name)
+	require.Equal(t, runtime.RuntimeHandler{Name: "userns", Flags: []string{"--nocolor"}}, handlers[1])
+
+	_, err = srv.Runtime.RunPodSandbox(ctx, &k8s.RunPodSandboxRequest{
+		Config:         critest.PodConfig("kata"),
+		RuntimeHandler: "kata",
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err), "unexpected error: %v", err)
+
+	pod, err := srv.Runtime.RunPodSandbox(ctx, &k8s.RunPodSandboxRequest{
+		Config:         critest.PodConfig("userns"),
+		RuntimeHandler: "userns",
+	})
+	require.NoError(t, err)
+	podStatus, err := srv.Runtime.PodSandboxStatus(ctx, &k8s.PodSandboxStatusRequest{PodSandboxId: pod.GetPodSandboxId(), Verbose: true})
+	require.NoError(t, err)
+	require.Equal(t, k8s.PodSandboxState_SANDBOX_READY, podStatus.GetStatus().GetState())
+	var info struct {
+		RuntimeHandler string `json:"runtimeHandler"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(podStatus.GetInfo()["info"]), &info))
+	require.Equal(t, "userns", info.RuntimeHandler)
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// DefaultHookTimeout is the default time lifecycle hook may run for.
+const DefaultHookTimeout = 10 * time.Second
+
+// HookEvent is a pod or container lifecycle event hook commands are run on.
+type HookEvent string
+
+const (
+	// HookSandboxReady is run once pod is created and its network is set up.
+	HookSandboxReady HookEvent = "on-sandbox-ready"
+	// HookSandboxRemoved is run once pod and all its containers are removed.
+	HookSandboxRemoved HookEvent = "on-sandbox-removed"
+	// HookContainerStarted is run once container is started.
+	HookContainerStarted HookEvent = "on-container-started"
+	// HookContainerExited is run once container is found to be exited,
+	// either stopped by kubelet or exited on its own.
+	HookContainerExited HookEvent = "on-container-exited"
+)
+
+// HookFailurePolicy defines what happens when hook command fails or times out.
+type HookFailurePolicy string
+
+const (
+	// HookFailureLog logs hook failure and lets the operation succeed.
+	HookFailureLog HookFailurePolicy = "log"
+	// HookFailureFail fails the operation hook is run on. Ready pod is removed and
+	// started container is stopped. Removal and exit cannot be undone, error is
+	// only returned to the caller.
+	HookFailureFail HookFailurePolicy = "fail"
+)
+
+// Hook is a command exec'd on a lifecycle event with HookPayload
+// JSON on its stdin. Command is run by the daemon itself, i.e.
+// outside of any pod or container namespaces.
+type Hook struct {
+	Event   HookEvent
+	Command []string
+	// Timeout is time command is killed after. Zero means DefaultHookTimeout.
+	Timeout time.Duration
+	// Failure is a failure policy. Empty means HookFailureLog.
+	Failure HookFailurePolicy
+}
+
+// Validate checks hook is fully and correctly defined.
+func (h Hook) Validate() error {
+	switch h.Event {
+	case HookSandboxReady, HookSandboxRemoved, HookContainerStarted, HookContainerExited:
+	default:
+		return fmt.Errorf("unknown hook event %q", h.Event)
+	}
+	if len(h.Command) == 0 || h.Command[0] == "" {
+		return fmt.Errorf("%s hook command cannot be empty", h.Event)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("%s hook timeout cannot be negative", h.Event)
+	}
+	switch h.Failure {
+	case "", HookFailureLog, HookFailureFail:
+	default:
+		return fmt.Errorf("unknown %s hook failure policy %q", h.Event, h.Failure)
+	}
+	return nil
+}
+
+// HookPayload is passed to hook commands on stdin in JSON format.
+type HookPayload struct {
+	Event         HookEvent `json:"event"`
+	Timestamp     int64     `json:"timestamp"`
+	PodID         string    `json:"podID"`
+	PodUID        string    `json:"podUID,omitempty"`
+	PodName       string    `json:"podName,omitempty"`
+	PodNamespace  string    `json:"podNamespace,omitempty"`
+	PodIPs        []string  `json:"podIPs,omitempty"`
+	ContainerID   string    `json:"containerID,omitempty"`
+	ContainerName string    `json:"containerName,omitempty"`
+	// ExitCode and Reason are set for HookContainerExited only.
+	ExitCode *int32 `json:"exitCode,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// hookRunner runs configured hooks. Nil runner has no hooks to run.
+type hookRunner struct {
+	hooks map[HookEvent][]Hook
+
+	mu     sync.Mutex
+	exited map[string]struct{} // containers exit hooks were run for
+}
+
+func newHookRunner(hooks []Hook) *hookRunner {
+	if len(hooks) == 0 {
+		return nil
+	}
+	r := &hookRunner{
+		hooks:  make(map[HookEvent][]Hook),
+		exited: make(map[string]struct{}),
+	}
+	for _, h := range hooks {
+		r.hooks[h.Event] = append(r.hooks[h.Event], h)
+	}
+	return r
+}
+
+// run runs all hooks of the payload event concurrently and waits for them to
+// finish or time out. Returned error combines failures of hooks with fail policy.
+func (r *hookRunner) run(payload *HookPayload) error {
+	if r == nil || len(r.hooks[payload.Event]) == 0 {
+		return nil
+	}
+	payload.Timestamp = time.Now().UnixNano()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("could not marshal hook payload: %v", err)
+	}
+
+	hooks := r.hooks[payload.Event]
+	errs := make([]error, len(hooks))
+	var wg sync.WaitGroup
+	for i, h := range hooks {
+		wg.Add(1)
+		go func(i int, h Hook) {
+			defer wg.Done()
+			errs[i] = h.exec(data)
+		}(i, h)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		glog.Errorf("Hook %s failed: %v", hooks[i].Event, err)
+		if hooks[i].Failure == HookFailureFail {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("%s hook failed: %s", payload.Event, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// markExited reports whether exit hooks were not run for the container yet
+// and remembers they are now.
+func (r *hookRunner) markExited(id string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.exited[id]; ok {
+		return false
+	}
+	r.exited[id] = struct{}{}
+	return true
+}
+
+// forget drops exit record of the removed container.
+func (r *hookRunner) forget(id string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.exited, id)
+	r.mu.Unlock()
+}
+
+func (h Hook) exec(payload []byte) error {
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	glog.V(4).Infof("Running %s hook %v", h.Event, h.Command)
+	// output is not collected so that background processes left by
+	// the hook holding stdout open cannot block us after timeout
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = []string{
+		fmt.Sprintf("PATH=%s", os.Getenv("PATH")),
+		fmt.Sprintf("SYCRI_HOOK_EVENT=%s", h.Event),
+	}
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%v timed out after %s", h.Command, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %v", h.Command, err)
+	}
+	return nil
+}
+
+func podHookPayload(event HookEvent, pod *kube.Pod) *HookPayload {
+	return &HookPayload{
+		Event:        event,
+		PodID:        pod.ID(),
+		PodUID:       pod.GetMetadata().GetUid(),
+		PodName:      pod.GetMetadata().GetName(),
+		PodNamespace: pod.GetMetadata().GetNamespace(),
+		PodIPs:       pod.IPs(),
+	}
+}
+
+func containerHookPayload(event HookEvent, cont *kube.Container, pod *kube.Pod) *HookPayload {
+	payload := &HookPayload{
+		Event:         event,
+		PodID:         cont.PodID(),
+		ContainerID:   cont.ID(),
+		ContainerName: cont.GetMetadata().GetName(),
+	}
+	if pod != nil {
+		payload = podHookPayload(event, pod)
+		payload.ContainerID = cont.ID()
+		payload.ContainerName = cont.GetMetadata().GetName()
+	}
+	if event == HookContainerExited {
+		code := cont.ExitCode()
+		payload.ExitCode = &code
+		payload.Reason = cont.StateReason()
+	}
+	return payload
+}
+
+// runContainerExitedHooks runs exit hooks once container is seen exited.
+// When wait is false hooks are run in background and failures are only logged,
+// which is used when exit is noticed by status requests.
+func (s *SingularityRuntime) runContainerExitedHooks(cont *kube.Container, wait bool) error {
+	if cont.State() != k8s.ContainerState_CONTAINER_EXITED || !s.hooks.markExited(cont.ID()) {
+		return nil
+	}
+	pod, _ := s.pods.Find(cont.PodID())
+	payload := containerHookPayload(HookContainerExited, cont, pod)
+	if !wait {
+		go s.hooks.run(payload)
+		return nil
+	}
+	return s.hooks.run(payload)
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHook_Validate(t *testing.T) {
+	tt := []struct {
+		name        string
+		hook        Hook
+		expectError bool
+	}{
+		{
+			name: "all ok",
+			hook: Hook{Event: HookSandboxReady, Command: []string{"/bin/true"}, Timeout: time.Second, Failure: HookFailureFail},
+		},
+		{
+			name: "defaults",
+			hook: Hook{Event: HookContainerExited, Command: []string{"true"}},
+		},
+		{
+			name:        "unknown event",
+			hook:        Hook{Event: "on-pod-started", Command: []string{"/bin/true"}},
+			expectError: true,
+		},
+		{
+			name:        "empty command",
+			hook:        Hook{Event: HookSandboxRemoved},
+			expectError: true,
+		},
+		{
+			name:        "negative timeout",
+			hook:        Hook{Event: HookContainerStarted, Command: []string{"/bin/true"}, Timeout: -time.Second},
+			expectError: true,
+		},
+		{
+			name:        "unknown failure policy",
+			hook:        Hook{Event: HookContainerStarted, Command: []string{"/bin/true"}, Failure: "retry"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.hook.Validate()
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestHookRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "could not create temp dir")
+	defer os.RemoveAll(dir)
+
+	capture := filepath.Join(dir, "capture.sh")
+	err = ioutil.WriteFile(capture, []byte("#!/bin/sh\ncat > \"$1\"\necho \"$SYCRI_HOOK_EVENT\" > \"$1.event\"\n"), 0755)
+	require.NoError(t, err)
+
+	exitCode := int32(137)
+	tt := []struct {
+		name        string
+		hooks       []Hook
+		payload     HookPayload
+		expectError bool
+	}{
+		{
+			name: "payload captured",
+			hooks: []Hook{
+				{Event: HookContainerExited, Command: []string{capture, filepath.Join(dir, "exited")}},
+			},
+			payload: HookPayload{
+				Event:         HookContainerExited,
+				PodID:         "pod",
+				PodName:       "nginx",
+				PodNamespace:  "default",
+				PodIPs:        []string{"10.0.0.5"},
+				ContainerID:   "cont",
+				ContainerName: "nginx",
+				ExitCode:      &exitCode,
+			},
+		},
+		{
+			name: "other event",
+			hooks: []Hook{
+				{Event: HookSandboxReady, Command: []string{"/bin/false"}, Failure: HookFailureFail},
+			},
+			payload: HookPayload{Event: HookSandboxRemoved, PodID: "pod"},
+		},
+		{
+			name: "failure logged",
+			hooks: []Hook{
+				{Event: HookSandboxReady, Command: []string{"/bin/false"}},
+				{Event: HookSandboxReady, Command: []string{filepath.Join(dir, "missing")}, Failure: HookFailureLog},
+			},
+			payload: HookPayload{Event: HookSandboxReady, PodID: "pod"},
+		},
+		{
+			name: "failure fails",
+			hooks: []Hook{
+				{Event: HookSandboxReady, Command: []string{"/bin/true"}},
+				{Event: HookSandboxReady, Command: []string{"/bin/false"}, Failure: HookFailureFail},
+			},
+			payload:     HookPayload{Event: HookSandboxReady, PodID: "pod"},
+			expectError: true,
+		},
+		{
+			name: "timeout",
+			hooks: []Hook{
+				{Event: HookContainerStarted, Command: []string{"sleep", "10"}, Timeout: 100 * time.Millisecond, Failure: HookFailureFail},
+			},
+			payload:     HookPayload{Event: HookContainerStarted, PodID: "pod", ContainerID: "cont"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			payload := tc.payload
+			start := time.Now()
+			err := newHookRunner(tc.hooks).run(&payload)
+			require.True(t, time.Since(start) < 5*time.Second, "hook must not block beyond its timeout")
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "exited"))
+	require.NoError(t, err, "hook payload is not captured")
+	var actual HookPayload
+	require.NoError(t, json.Unmarshal(data, &actual))
+	require.NotZero(t, actual.Timestamp)
+	actual.Timestamp = 0
+	require.Equal(t, HookPayload{
+		Event:         HookContainerExited,
+		PodID:         "pod",
+		PodName:       "nginx",
+		PodNamespace:  "default",
+		PodIPs:        []string{"10.0.0.5"},
+		ContainerID:   "cont",
+		ContainerName: "nginx",
+		ExitCode:      &exitCode,
+	}, actual)
+
+	event, err := ioutil.ReadFile(filepath.Join(dir, "exited.event"))
+	require.NoError(t, err)
+	require.Equal(t, "on-container-exited\n", string(event))
+}
+
+func TestHookRunner_MarkExited(t *testing.T) {
+	var none *hookRunner
+	require.NoError(t, none.run(&HookPayload{Event: HookSandboxReady}))
+	require.False(t, none.markExited("cont"), "nil runner has no hooks to run")
+	none.forget("cont")
+
+	r := newHookRunner([]Hook{{Event: HookContainerExited, Command: []string{"/bin/true"}}})
+	require.True(t, r.markExited("cont"))
+	require.False(t, r.markExited("cont"), "exit hooks must run once")
+	r.forget("cont")
+	require.True(t, r.markExited("cont"))
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/network"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultIPAMReconcileInterval is the default interval
+// IPAM allocations of opted in networks are reconciled at.
+const DefaultIPAMReconcileInterval = 10 * time.Minute
+
+// ipamStats holds counters of IPAM reconciliation.
+type ipamStats struct {
+	Runs      uint64 `json:"runs"`
+	Reclaimed uint64 `json:"reclaimed"`
+	Failures  uint64 `json:"failures"`
+	LastRun   string `json:"lastRun,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// ipamReconciler releases IPAM allocations leaked by pods that are gone,
+// e.g. when daemon crashed before CNI DEL was run.
+type ipamReconciler struct {
+	networks []string
+	interval time.Duration
+
+	// mu serializes reconciliations and guards stats
+	mu    sync.Mutex
+	stats ipamStats
+}
+
+// inFlightIDs is a set of pods and containers whose resources, e.g. network,
+// may be set up but which are not indexed yet. Their resources are never
+// released as leaked.
+type inFlightIDs struct {
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+func (p *inFlightIDs) add(id string) {
+	p.mu.Lock()
+	if p.ids == nil {
+		p.ids = make(map[string]struct{})
+	}
+	p.ids[id] = struct{}{}
+	p.mu.Unlock()
+}
+
+func (p *inFlightIDs) remove(id string) {
+	p.mu.Lock()
+	delete(p.ids, id)
+	p.mu.Unlock()
+}
+
+func (p *inFlightIDs) has(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.ids[id]
+	return ok
+}
+
+// WithIPAMReconcile enables release of IPAM allocations leaked by pods that no
+// longer exist in listed CNI networks. Reconciliation is run every interval,
+// zero interval results in DefaultIPAMReconcileInterval and negative
+// one allows reconciliation on ReconcileIPAM request only.
+func WithIPAMReconcile(networks []string, interval time.Duration) Option {
+	return func(r *SingularityRuntime) {
+		if interval == 0 {
+			interval = DefaultIPAMReconcileInterval
+		}
+		r.ipam.networks = networks
+		r.ipam.interval = interval
+	}
+}
+
+// StartIPAMReconcile reconciles IPAM allocations periodically until ctx is done.
+// It is a no-op unless reconciliation is enabled with WithIPAMReconcile.
+func (s *SingularityRuntime) StartIPAMReconcile(ctx context.Context) {
+	if len(s.ipam.networks) == 0 || s.ipam.interval < 0 || s.networkManager == nil {
+		return
+	}
+	glog.Infof("IPAM reconciliation of %v is enabled every %v", s.ipam.networks, s.ipam.interval)
+	go func() {
+		ticker := time.NewTicker(s.ipam.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := s.reconcileIPAM(false); err != nil {
+				glog.Errorf("Could not reconcile IPAM allocations: %v", err)
+			}
+		}
+	}()
+}
+
+// ReconcileIPAM releases addresses host-local IPAM keeps for pods
+// that no longer exist in networks listed in daemon config.
+func (s *SingularityRuntime) ReconcileIPAM(_ context.Context, req *admin.ReconcileIPAMRequest) (*admin.ReconcileIPAMResponse, error) {
+	if len(s.ipam.networks) == 0 || s.networkManager == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "IPAM reconciliation is not enabled for any network")
+	}
+	allocs, err := s.reconcileIPAM(req.GetDryRun())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not reconcile IPAM allocations: %v", err)
+	}
+	resp := new(admin.ReconcileIPAMResponse)
+	for _, alloc := range allocs {
+		resp.Allocations = append(resp.Allocations, &admin.IPAMAllocation{
+			Network:     alloc.Network,
+			Ip:          alloc.IP,
+			ContainerId: alloc.ContainerID,
+			IfName:      alloc.IfName,
+		})
+	}
+	return resp, nil
+}
+
+// reconcileIPAM releases allocations of pods that are neither indexed nor
+// being created. When dryRun is set leaked allocations are only returned.
+func (s *SingularityRuntime) reconcileIPAM(dryRun bool) ([]network.Allocation, error) {
+	s.ipam.mu.Lock()
+	defer s.ipam.mu.Unlock()
+
+	live := func(id string) bool {
+		if s.inFlight.has(id) {
+			return true
+		}
+		_, err := s.pods.Find(id)
+		return err == nil
+	}
+	allocs, err := s.networkManager.ReclaimIPAM(s.ipam.networks, live, dryRun)
+	if dryRun {
+		return allocs, err
+	}
+
+	s.ipam.stats.Runs++
+	s.ipam.stats.LastRun = time.Now().Format(time.RFC3339)
+	s.ipam.stats.LastError = ""
+	if err != nil {
+		s.ipam.stats.Failures++
+		s.ipam.stats.LastError = err.Error()
+		return nil, err
+	}
+	s.ipam.stats.Reclaimed += uint64(len(allocs))
+	orphansReaped.Add(float64(len(allocs)), orphanIPAM)
+	if len(allocs) != 0 {
+		glog.Infof("Reclaimed %d leaked IPAM addresses", len(allocs))
+	}
+	return allocs, nil
+}
+
+// ipamStats returns current counters of IPAM reconciliation.
+func (s *SingularityRuntime) ipamStats() ipamStats {
+	s.ipam.mu.Lock()
+	defer s.ipam.mu.Unlock()
+	return s.ipam.stats
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	"github.com/sylabs/singularity-cri/pkg/network"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReconcileIPAM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipam-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	binDir := filepath.Join(dir, "bin")
+	confDir := filepath.Join(dir, "net.d")
+	storeDir := filepath.Join(dir, "ipam", "test")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	require.NoError(t, os.MkdirAll(confDir, 0755))
+	require.NoError(t, os.MkdirAll(storeDir, 0755))
+
+	// fake plugin releases addresses of the container the way host-local does
+	plugin := "#!/bin/sh\ngrep -lx \"$CNI_CONTAINERID\" " + storeDir + "/* | xargs -r rm\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, "bridge"), []byte(plugin), 0755))
+	err = ioutil.WriteFile(filepath.Join(confDir, "10-test.conflist"), []byte(`{
+	"cniVersion": "0.3.1",
+	"name": "test",
+	"plugins": [{"type": "bridge", "ipam": {"type": "host-local", "dataDir": "`+filepath.Dir(storeDir)+`"}}]
+}`), 0644)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(storeDir, "10.22.0.2"), []byte("gone"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(storeDir, "10.22.0.3"), []byte("creating"), 0644))
+
+	s := &SingularityRuntime{
+		pods:           index.NewPodIndex(),
+		networkManager: &network.Manager{},
+	}
+	require.NoError(t, s.networkManager.Init(&network.CNIPath{Conf: confDir, Plugin: binDir}, ""))
+
+	_, err = s.ReconcileIPAM(context.Background(), &admin.ReconcileIPAMRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err), "reconciliation must be opt-in")
+
+	WithIPAMReconcile([]string{"test"}, 0)(s)
+	s.inFlight.add("creating")
+
+	resp, err := s.ReconcileIPAM(context.Background(), &admin.ReconcileIPAMRequest{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, []*admin.IPAMAllocation{
+		{Network: "test", Ip: "10.22.0.2", ContainerId: "gone"},
+	}, resp.Allocations)
+	require.Zero(t, s.ipamStats().Runs, "dry run must not be accounted")
+
+	resp, err = s.ReconcileIPAM(context.Background(), &admin.ReconcileIPAMRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Allocations, 1)
+	_, err = os.Stat(filepath.Join(storeDir, "10.22.0.2"))
+	require.True(t, os.IsNotExist(err), "leaked address must be released")
+	_, err = os.Stat(filepath.Join(storeDir, "10.22.0.3"))
+	require.NoError(t, err, "address of pod being created must be kept")
+
+	stats := s.ipamStats()
+	require.Equal(t, uint64(1), stats.Runs)
+	require.Equal(t, uint64(1), stats.Reclaimed)
+	require.Zero(t, stats.Failures)
+}
@@ -0,0 +1,339 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// DefaultMaxListAnnotationsSize is a default limit of container annotations
+	// size in bytes in list responses, annotations beyond it are dropped.
+	DefaultMaxListAnnotationsSize = 16 << 10
+	// DefaultListPageSize is a number of containers returned by
+	// ListContainersPage when request doesn't set page size.
+	DefaultListPageSize = 100
+	// MaxListPageSize is the largest page ListContainersPage returns.
+	MaxListPageSize = 1000
+
+	// kubeAnnotationPrefix prefixes annotations kubelet relies on, they
+	// are never trimmed from list responses.
+	kubeAnnotationPrefix = "io.kubernetes."
+)
+
+// listStats holds counters of container list cache.
+type listStats struct {
+	CacheHits          uint64 `json:"cacheHits"`
+	CacheMisses        uint64 `json:"cacheMisses"`
+	TrimmedContainers  uint64 `json:"trimmedContainers"`
+	MaxAnnotationsSize int    `json:"maxAnnotationsSize"`
+}
+
+// listEntry is a container list message along with the state
+// of container it was built from.
+type listEntry struct {
+	cont  *kube.Container
+	state k8s.ContainerState
+	msg   *k8s.Container
+}
+
+// listCache holds the last built container list. Messages are shared
+// between responses and must never be modified once built.
+type listCache struct {
+	mu         sync.Mutex
+	generation uint64
+	entries    []listEntry
+	containers []*k8s.Container
+
+	hits    uint64
+	misses  uint64
+	trimmed uint64
+
+	// dangling holds IDs of containers which pod is not indexed,
+	// so that each of them is logged once
+	dangling map[string]bool
+}
+
+// newerFirst reports whether object created at createdI with idI is listed
+// before object created at createdJ with idJ. Newer objects go first, ties
+// are broken by ID so that list order never changes between calls.
+func newerFirst(createdI int64, idI string, createdJ int64, idJ string) bool {
+	if createdI != createdJ {
+		return createdI > createdJ
+	}
+	return idI < idJ
+}
+
+// listContainers returns messages of all known containers. Result is reused
+// while no container is added or removed and no container changes its state.
+// Entries are in index order, while messages are ordered with newerFirst.
+// Containers which pod is not indexed are skipped, kubelet would fail to
+// look their pod up anyway. Returned slices are shared and must not be modified.
+func (s *SingularityRuntime) listContainers() ([]listEntry, []*k8s.Container) {
+	c := &s.listCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	generation := s.containers.Generation()
+	var (
+		changed = generation != c.generation
+		prev    map[*kube.Container]listEntry
+		entries []listEntry
+		exited   []*kube.Container
+		dangling map[string]bool
+		i        int
+	)
+	// entries up to i matched cached ones so far, copy them
+	markChanged := func() {
+		if !changed {
+			changed = true
+			entries = append(make([]listEntry, 0, len(c.entries)), c.entries[:i]...)
+		}
+	}
+	s.containers.Iterate(func(cont *kube.Container) {
+		if _, err := s.pods.Find(cont.PodID()); err != nil {
+			if !c.dangling[cont.ID()] {
+				glog.Warningf("Skipping container %s in list response: pod %s is not found", cont.ID(), cont.PodID())
+			}
+			if dangling == nil {
+				dangling = make(map[string]bool)
+			}
+			dangling[cont.ID()] = true
+			return
+		}
+		if err := cont.UpdateState(); err != nil {
+			cont.Warnings().Logf(glog.ErrorDepth, "Could not fetch container %s: %v", cont.ID(), err)
+			markChanged()
+			return
+		}
+		s.runContainerExitedHooks(cont, false)
+		state := cont.State()
+		if state == k8s.ContainerState_CONTAINER_EXITED && !cont.Compacted() {
+			exited = append(exited, cont)
+		}
+
+		if !changed && i < len(c.entries) && c.entries[i].cont == cont && c.entries[i].state == state {
+			i++
+			return
+		}
+		markChanged()
+		if prev == nil {
+			prev = make(map[*kube.Container]listEntry, len(c.entries))
+			for _, e := range c.entries {
+				prev[e.cont] = e
+			}
+		}
+		e, ok := prev[cont]
+		if !ok || e.state != state {
+			e = listEntry{cont: cont, state: state, msg: s.containerMessage(cont, state)}
+		}
+		entries = append(entries, e)
+		i++
+	})
+	if i != len(c.entries) {
+		markChanged()
+	}
+	c.dangling = dangling
+	// response only references fields that compacted containers retain
+	if n := kube.CompactExited(exited, s.maxHotExited, s.compactAge, time.Now()); n != 0 {
+		glog.V(3).Infof("Compacted %d exited containers", n)
+	}
+
+	if !changed {
+		atomic.AddUint64(&c.hits, 1)
+		return c.entries, c.containers
+	}
+	atomic.AddUint64(&c.misses, 1)
+	c.generation = generation
+	c.entries = entries
+	c.containers = make([]*k8s.Container, len(entries))
+	for i, e := range entries {
+		c.containers[i] = e.msg
+	}
+	sort.Slice(c.containers, func(i, j int) bool {
+		ci, cj := c.containers[i], c.containers[j]
+		return newerFirst(ci.CreatedAt, ci.Id, cj.CreatedAt, cj.Id)
+	})
+	return c.entries, c.containers
+}
+
+// containerMessage builds list message of the container, annotations
+// are trimmed when they exceed configured size limit.
+func (s *SingularityRuntime) containerMessage(cont *kube.Container, state k8s.ContainerState) *k8s.Container {
+	annotations := cont.GetAnnotations()
+	if s.maxListAnnotations > 0 {
+		trimmed, dropped := trimAnnotations(annotations, s.maxListAnnotations)
+		if dropped != 0 {
+			glog.Warningf("Dropped %d annotations of container %s from list response: size exceeds %d bytes",
+				dropped, cont.ID(), s.maxListAnnotations)
+			atomic.AddUint64(&s.listCache.trimmed, 1)
+			annotations = trimmed
+		}
+	}
+	return &k8s.Container{
+		Id:           cont.ID(),
+		PodSandboxId: cont.PodID(),
+		Metadata:     cont.GetMetadata(),
+		Image:        cont.GetImage(),
+		ImageRef:     cont.ImageRef(),
+		State:        state,
+		CreatedAt:    cont.CreatedAt(),
+		Labels:       cont.GetLabels(),
+		Annotations:  annotations,
+	}
+}
+
+// trimAnnotations returns annotations that fit into limit bytes along with
+// the number of dropped ones. Kubernetes annotations are always kept, others
+// are kept in order of their keys while they fit. Passed map is never modified.
+func trimAnnotations(annotations map[string]string, limit int) (map[string]string, int) {
+	size := 0
+	for k, v := range annotations {
+		size += len(k) + len(v)
+	}
+	if size <= limit {
+		return annotations, 0
+	}
+
+	trimmed := make(map[string]string)
+	var keys []string
+	size = 0
+	for k, v := range annotations {
+		if strings.HasPrefix(k, kubeAnnotationPrefix) {
+			trimmed[k] = v
+			size += len(k) + len(v)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := annotations[k]
+		if size+len(k)+len(v) > limit {
+			continue
+		}
+		trimmed[k] = v
+		size += len(k) + len(v)
+	}
+	return trimmed, len(annotations) - len(trimmed)
+}
+
+// isEmptyFilter checks whether filter matches all containers.
+func isEmptyFilter(f *k8s.ContainerFilter) bool {
+	return f.GetId() == "" && f.GetPodSandboxId() == "" && f.GetState() == nil && len(f.GetLabelSelector()) == 0
+}
+
+// messageMatches checks whether container list message matches filter. Unlike
+// kube.Container.MatchesFilter it checks state message was built with.
+func messageMatches(c *k8s.Container, f *k8s.ContainerFilter) bool {
+	if f.GetId() != "" && f.GetId() != c.Id {
+		return false
+	}
+	if f.GetPodSandboxId() != "" && f.GetPodSandboxId() != c.PodSandboxId {
+		return false
+	}
+	if f.GetState() != nil && f.GetState().GetState() != c.State {
+		return false
+	}
+	for k, v := range f.GetLabelSelector() {
+		if label, ok := c.Labels[k]; !ok || label != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ListContainersPage returns containers ordered by ID one page at a time.
+// Page token is an encoded ID of the last returned container, so containers
+// added or removed between calls do not shift pages.
+func (s *SingularityRuntime) ListContainersPage(_ context.Context, req *admin.ListContainersPageRequest) (*admin.ListContainersPageResponse, error) {
+	size := int(req.GetPageSize())
+	if size < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "page_size: must not be negative")
+	}
+	if size == 0 {
+		size = DefaultListPageSize
+	}
+	if size > MaxListPageSize {
+		size = MaxListPageSize
+	}
+	var after string
+	if token := req.GetPageToken(); token != "" {
+		data, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || len(data) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "page_token: invalid token")
+		}
+		after = string(data)
+	}
+
+	filter := &k8s.ContainerFilter{
+		PodSandboxId:  req.GetPodSandboxId(),
+		LabelSelector: req.GetLabelSelector(),
+	}
+	var matched []*k8s.Container
+	entries, _ := s.listContainers()
+	for _, e := range entries {
+		if e.msg.Id > after && messageMatches(e.msg, filter) {
+			matched = append(matched, e.msg)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Id < matched[j].Id
+	})
+
+	resp := new(admin.ListContainersPageResponse)
+	if len(matched) > size {
+		matched = matched[:size]
+		resp.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(matched[size-1].Id))
+	}
+	for _, c := range matched {
+		resp.Containers = append(resp.Containers, &admin.ContainerSummary{
+			Id:           c.Id,
+			PodSandboxId: c.PodSandboxId,
+			Name:         c.GetMetadata().GetName(),
+			Attempt:      c.GetMetadata().GetAttempt(),
+			Image:        c.GetImage().GetImage(),
+			ImageRef:     c.ImageRef,
+			State:        int32(c.State),
+			CreatedAt:    c.CreatedAt,
+			Labels:       c.Labels,
+			Annotations:  c.Annotations,
+		})
+	}
+	return resp, nil
+}
+
+// listStats returns current counters of container list cache.
+func (s *SingularityRuntime) listStats() listStats {
+	return listStats{
+		CacheHits:          atomic.LoadUint64(&s.listCache.hits),
+		CacheMisses:        atomic.LoadUint64(&s.listCache.misses),
+		TrimmedContainers:  atomic.LoadUint64(&s.listCache.trimmed),
+		MaxAnnotationsSize: s.maxListAnnotations,
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/critest"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestList_Order(t *testing.T) {
+	srv := critest.StartFakeServer(t, critest.WithImages(critest.FakeImage{Ref: "busybox"}))
+	defer srv.Stop()
+	ctx := context.Background()
+
+	var podIDs, contIDs []string
+	for i := 0; i < 4; i++ {
+		config := critest.PodConfig(fmt.Sprintf("pod-%d", i))
+		pod, err := srv.Runtime.RunPodSandbox(ctx, &k8s.RunPodSandboxRequest{Config: config})
+		require.NoError(t, err)
+		cont, err := srv.Runtime.CreateContainer(ctx, &k8s.CreateContainerRequest{
+			PodSandboxId:  pod.GetPodSandboxId(),
+			Config:        critest.ContainerConfig("cont", "busybox", "sleep", "60"),
+			SandboxConfig: config,
+		})
+		require.NoError(t, err)
+		// newer objects are listed first
+		podIDs = append([]string{pod.GetPodSandboxId()}, podIDs...)
+		contIDs = append([]string{cont.GetContainerId()}, contIDs...)
+	}
+
+	for i := 0; i < 5; i++ {
+		pods, err := srv.Runtime.ListPodSandbox(ctx, &k8s.ListPodSandboxRequest{})
+		require.NoError(t, err)
+		var ids []string
+		for _, pod := range pods.GetItems() {
+			ids = append(ids, pod.GetId())
+		}
+		require.Equal(t, podIDs, ids)
+
+		containers, err := srv.Runtime.ListContainers(ctx, &k8s.ListContainersRequest{})
+		require.NoError(t, err)
+		ids = nil
+		for _, cont := range containers.GetContainers() {
+			ids = append(ids, cont.GetId())
+		}
+		require.Equal(t, contIDs, ids)
+	}
+}
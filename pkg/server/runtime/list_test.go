@@ -0,0 +1,368 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/image"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	sRuntime "github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity/pkg/ociruntime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// stateEngine reports container states set by test, other
+// engine operations are not expected to be called.
+type stateEngine struct {
+	sRuntime.Engine
+
+	mu     sync.Mutex
+	status map[string]string
+	// pod is an indexed pod containers are created in
+	pod *kube.Pod
+}
+
+func (e *stateEngine) State(id string) (*ociruntime.State, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status, ok := e.status[id]
+	if !ok {
+		status = "running"
+	}
+	return &ociruntime.State{State: specs.State{ID: id, Status: status}}, nil
+}
+
+func (e *stateEngine) set(id, status string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status[id] = status
+}
+
+// newListRuntime returns runtime with a single indexed pod
+// list containers are created in.
+func newListRuntime() (*SingularityRuntime, *stateEngine) {
+	s := &SingularityRuntime{
+		pods:               index.NewPodIndex(),
+		containers:         index.NewContainerIndex(),
+		maxHotExited:       -1,
+		compactAge:         -1,
+		maxListAnnotations: DefaultMaxListAnnotationsSize,
+	}
+	pod := kube.NewPod(&k8s.PodSandboxConfig{
+		Metadata: &k8s.PodSandboxMetadata{Name: "list", Namespace: "default", Uid: "list"},
+	})
+	if err := s.pods.Add(pod); err != nil {
+		panic(err)
+	}
+	return s, &stateEngine{status: make(map[string]string), pod: pod}
+}
+
+func newListContainer(engine *stateEngine, name string) *kube.Container {
+	return kube.NewContainer(&k8s.ContainerConfig{
+		Metadata: &k8s.ContainerMetadata{Name: name},
+		Labels:   map[string]string{"app": name},
+	}, engine.pod, &image.Info{}, "", kube.WithContainerEngine(engine))
+}
+
+func addListContainer(t *testing.T, s *SingularityRuntime, engine *stateEngine, name string) *kube.Container {
+	cont := newListContainer(engine, name)
+	require.NoError(t, s.containers.Add(cont))
+	return cont
+}
+
+func listIDs(containers []*k8s.Container) []string {
+	var ids []string
+	for _, c := range containers {
+		ids = append(ids, c.Id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func indexIDs(s *SingularityRuntime) []string {
+	var ids []string
+	s.containers.Iterate(func(cont *kube.Container) {
+		ids = append(ids, cont.ID())
+	})
+	sort.Strings(ids)
+	return ids
+}
+
+func TestListContainers_Cache(t *testing.T) {
+	s, engine := newListRuntime()
+	ctx := context.Background()
+	list := func(filter *k8s.ContainerFilter) []*k8s.Container {
+		resp, err := s.ListContainers(ctx, &k8s.ListContainersRequest{Filter: filter})
+		require.NoError(t, err)
+		return resp.Containers
+	}
+
+	busybox := addListContainer(t, s, engine, "busybox")
+	nginx := addListContainer(t, s, engine, "nginx")
+	first := list(&k8s.ContainerFilter{})
+	require.Equal(t, indexIDs(s), listIDs(first))
+	require.Equal(t, uint64(1), s.listStats().CacheMisses)
+
+	t.Run("unchanged relist", func(t *testing.T) {
+		second := list(nil)
+		require.True(t, &first[0] == &second[0], "list slice was rebuilt")
+		require.Equal(t, uint64(1), s.listStats().CacheHits)
+	})
+
+	t.Run("state change", func(t *testing.T) {
+		engine.set(nginx.ID(), "stopped")
+		containers := list(nil)
+		require.Equal(t, indexIDs(s), listIDs(containers))
+		for _, c := range containers {
+			if c.Id == nginx.ID() {
+				require.Equal(t, k8s.ContainerState_CONTAINER_EXITED, c.State)
+				continue
+			}
+			for _, old := range first {
+				if old.Id == c.Id {
+					require.True(t, old == c, "message of unchanged container was rebuilt")
+				}
+			}
+		}
+
+		exited := list(&k8s.ContainerFilter{
+			State: &k8s.ContainerStateValue{State: k8s.ContainerState_CONTAINER_EXITED},
+		})
+		require.Equal(t, []string{nginx.ID()}, listIDs(exited))
+		require.Empty(t, list(&k8s.ContainerFilter{Id: busybox.ID(), LabelSelector: map[string]string{"app": "nginx"}}))
+	})
+
+	t.Run("add and remove between relists", func(t *testing.T) {
+		before := list(nil)
+		alpine := addListContainer(t, s, engine, "alpine")
+		require.NoError(t, s.containers.Remove(alpine.ID()))
+		containers := list(nil)
+		require.Equal(t, listIDs(before), listIDs(containers))
+
+		require.NoError(t, s.containers.Remove(busybox.ID()))
+		alpine = addListContainer(t, s, engine, "alpine")
+		containers = list(nil)
+		require.Equal(t, indexIDs(s), listIDs(containers))
+		require.NotContains(t, listIDs(containers), busybox.ID())
+		require.Contains(t, listIDs(containers), alpine.ID())
+	})
+
+	t.Run("concurrent add and remove", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					cont := newListContainer(engine, fmt.Sprintf("worker-%d-%d", i, j))
+					if err := s.containers.Add(cont); err != nil {
+						t.Errorf("Could not add container: %v", err)
+						return
+					}
+					if j%2 == 0 {
+						if err := s.containers.Remove(cont.ID()); err != nil {
+							t.Errorf("Could not remove container: %v", err)
+							return
+						}
+					}
+				}
+			}(i)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					if _, err := s.ListContainers(ctx, &k8s.ListContainersRequest{}); err != nil {
+						t.Errorf("Could not list containers: %v", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, indexIDs(s), listIDs(list(nil)))
+		require.Equal(t, indexIDs(s), listIDs(list(nil)))
+	})
+}
+
+func TestListContainers_Dangling(t *testing.T) {
+	s, engine := newListRuntime()
+	ctx := context.Background()
+	kept := addListContainer(t, s, engine, "kept")
+	orphan := kube.NewContainer(&k8s.ContainerConfig{
+		Metadata: &k8s.ContainerMetadata{Name: "orphan"},
+	}, kube.NewPod(nil), &image.Info{}, "", kube.WithContainerEngine(engine))
+	require.NoError(t, s.containers.Add(orphan))
+
+	for i := 0; i < 2; i++ {
+		resp, err := s.ListContainers(ctx, &k8s.ListContainersRequest{})
+		require.NoError(t, err)
+		require.Equal(t, []string{kept.ID()}, listIDs(resp.Containers))
+	}
+	require.Equal(t, map[string]bool{orphan.ID(): true}, s.listCache.dangling)
+	require.Equal(t, uint64(1), s.listStats().CacheHits)
+
+	// containers of removed pod are dropped even though container set is the same
+	require.NoError(t, s.pods.Remove(engine.pod.ID()))
+	resp, err := s.ListContainers(ctx, &k8s.ListContainersRequest{})
+	require.NoError(t, err)
+	require.Empty(t, resp.Containers)
+}
+
+func TestNewerFirst(t *testing.T) {
+	type object struct {
+		created int64
+		id      string
+	}
+	objects := []object{{100, "b"}, {0, "a"}, {300, "c"}, {100, "a"}, {0, "b"}}
+	sort.Slice(objects, func(i, j int) bool {
+		return newerFirst(objects[i].created, objects[i].id, objects[j].created, objects[j].id)
+	})
+	require.Equal(t, []object{{300, "c"}, {100, "a"}, {100, "b"}, {0, "a"}, {0, "b"}}, objects)
+}
+
+func TestTrimAnnotations(t *testing.T) {
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		limit       int
+		expect      map[string]string
+		dropped     int
+	}{
+		{
+			name:        "no annotations",
+			annotations: nil,
+			limit:       10,
+			expect:      nil,
+		},
+		{
+			name:        "within limit",
+			annotations: map[string]string{"a": "1", "b": "2"},
+			limit:       4,
+			expect:      map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name:        "drop in key order",
+			annotations: map[string]string{"a": "1", "b": strings.Repeat("x", 10), "c": "3"},
+			limit:       5,
+			expect:      map[string]string{"a": "1", "c": "3"},
+			dropped:     1,
+		},
+		{
+			name: "kubernetes annotations are kept",
+			annotations: map[string]string{
+				"io.kubernetes.container.hash": "abcdef",
+				"a":                            "1",
+			},
+			limit:   10,
+			expect:  map[string]string{"io.kubernetes.container.hash": "abcdef"},
+			dropped: 1,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			orig := make(map[string]string)
+			for k, v := range tc.annotations {
+				orig[k] = v
+			}
+			actual, dropped := trimAnnotations(tc.annotations, tc.limit)
+			require.Equal(t, tc.expect, actual)
+			require.Equal(t, tc.dropped, dropped)
+			if tc.annotations != nil {
+				require.Equal(t, orig, tc.annotations, "passed annotations were modified")
+			}
+		})
+	}
+}
+
+func TestListContainers_TrimAnnotations(t *testing.T) {
+	s, engine := newListRuntime()
+	s.maxListAnnotations = 8
+	cont := kube.NewContainer(&k8s.ContainerConfig{
+		Metadata:    &k8s.ContainerMetadata{Name: "big"},
+		Annotations: map[string]string{"a": "1", "big": strings.Repeat("x", 100)},
+	}, engine.pod, &image.Info{}, "", kube.WithContainerEngine(engine))
+	require.NoError(t, s.containers.Add(cont))
+
+	resp, err := s.ListContainers(context.Background(), &k8s.ListContainersRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Containers, 1)
+	require.Equal(t, map[string]string{"a": "1"}, resp.Containers[0].Annotations)
+	require.Len(t, cont.GetAnnotations(), 2, "container annotations were modified")
+	require.Equal(t, uint64(1), s.listStats().TrimmedContainers)
+}
+
+func TestListContainersPage(t *testing.T) {
+	s, engine := newListRuntime()
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		addListContainer(t, s, engine, fmt.Sprintf("cont-%d", i))
+	}
+
+	_, err := s.ListContainersPage(ctx, &admin.ListContainersPageRequest{PageToken: "!"})
+	require.Error(t, err)
+	_, err = s.ListContainersPage(ctx, &admin.ListContainersPageRequest{PageSize: -1})
+	require.Error(t, err)
+
+	var (
+		seen  []string
+		last  *admin.ContainerSummary
+		token string
+		pages int
+	)
+	for {
+		resp, err := s.ListContainersPage(ctx, &admin.ListContainersPageRequest{
+			PageSize:  2,
+			PageToken: token,
+		})
+		require.NoError(t, err)
+		require.True(t, len(resp.Containers) <= 2)
+		for _, c := range resp.Containers {
+			seen = append(seen, c.Id)
+			last = c
+		}
+		pages++
+		if pages == 1 {
+			// containers before the token do not shift the next page
+			first := resp.Containers[0]
+			require.NoError(t, s.containers.Remove(first.Id))
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		token = resp.NextPageToken
+	}
+	require.Equal(t, 3, pages)
+	require.True(t, sort.StringsAreSorted(seen), "containers are not ordered by ID")
+	require.Len(t, seen, 5)
+
+	resp, err := s.ListContainersPage(ctx, &admin.ListContainersPageRequest{
+		LabelSelector: map[string]string{"app": last.Name},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Containers, 1)
+	require.Equal(t, last.Id, resp.Containers[0].Id)
+	require.Empty(t, resp.NextPageToken)
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// nsenterCommand returns nsenter command line that runs command in pod's
+// network namespace. Pinned namespace is entered when pod has a dedicated
+// one, so that it works even when pod process is gone, otherwise namespace
+// of pod process is entered.
+func nsenterCommand(p *kube.Pod, command ...string) ([]string, error) {
+	nsenterPath, err := exec.LookPath("nsenter")
+	if err != nil {
+		return nil, fmt.Errorf("nsenter not found")
+	}
+	args := []string{nsenterPath}
+	if nsPath := p.NetNsPath(); nsPath != "" {
+		args = append(args, "--net="+nsPath)
+	} else {
+		args = append(args, "-t", strconv.Itoa(p.Pid()), "-n")
+	}
+	args = append(args, "--")
+	return append(args, command...), nil
+}
+
+// PrepareNetNsExec resolves pod by ID, ID prefix or [namespace/]name and returns
+// command line that runs passed command in its network namespace. Caller is
+// expected to run it on the same host, so only local socket peers are served.
+func (s *SingularityRuntime) PrepareNetNsExec(ctx context.Context, req *admin.PrepareNetNsExecRequest) (*admin.PrepareNetNsExecResponse, error) {
+	if p, ok := peer.FromContext(ctx); !ok || p.Addr == nil || p.Addr.Network() != "unix" {
+		return nil, status.Errorf(codes.PermissionDenied, "network namespace exec is only available on local socket")
+	}
+	if req.GetPod() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "pod: must not be empty")
+	}
+	if len(req.GetCommand()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "command: must not be empty")
+	}
+
+	pod, err := s.resolvePod(req.GetPod())
+	if err != nil {
+		return nil, err
+	}
+	nsPath := pod.NetNsPath()
+	if nsPath == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "pod %s uses host network", pod.ID())
+	}
+	if _, err := os.Stat(nsPath); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "pod %s network namespace is not available: %v", pod.ID(), err)
+	}
+	args, err := nsenterCommand(pod, req.GetCommand()...)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "could not enter pod network namespace: %v", err)
+	}
+	glog.V(2).Infof("Prepared network namespace exec of %v in pod %s", req.GetCommand(), pod.ID())
+	return &admin.PrepareNetNsExecResponse{
+		PodSandboxId: pod.ID(),
+		NetNsPath:    nsPath,
+		Args:         args,
+	}, nil
+}
+
+// resolvePod finds pod by ID, unique ID prefix, or by name optionally
+// prefixed with namespace. When several attempts of the pod exist,
+// the ready one is picked.
+func (s *SingularityRuntime) resolvePod(ref string) (*kube.Pod, error) {
+	pod, err := s.pods.Find(ref)
+	if err == nil {
+		return pod, nil
+	}
+	if err != index.ErrNotFound {
+		return nil, status.Errorf(codes.InvalidArgument, "could not find pod %s: %v", ref, err)
+	}
+
+	namespace, name := "", ref
+	if i := strings.IndexByte(ref, '/'); i != -1 {
+		namespace, name = ref[:i], ref[i+1:]
+	}
+	var matched, ready []*kube.Pod
+	s.pods.Iterate(func(p *kube.Pod) {
+		md := p.GetMetadata()
+		if md.GetName() != name || namespace != "" && md.GetNamespace() != namespace {
+			return
+		}
+		matched = append(matched, p)
+		if p.State() == k8s.PodSandboxState_SANDBOX_READY {
+			ready = append(ready, p)
+		}
+	})
+	if len(ready) != 0 {
+		matched = ready
+	}
+	switch len(matched) {
+	case 0:
+		return nil, status.Errorf(codes.NotFound, "pod %s is not found", ref)
+	case 1:
+		return matched[0], nil
+	}
+	ids := make([]string, len(matched))
+	for i, p := range matched {
+		ids[i] = p.ID()
+	}
+	sort.Strings(ids)
+	return nil, status.Errorf(codes.InvalidArgument, "pod %s is ambiguous, use one of IDs: %s", ref, strings.Join(ids, ", "))
+}
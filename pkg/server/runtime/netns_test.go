@@ -0,0 +1,151 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	admin "github.com/sylabs/singularity-cri/pkg/apis/admin/v1alpha"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestResolvePod(t *testing.T) {
+	s := &SingularityRuntime{pods: index.NewPodIndex()}
+	newPod := func(namespace, name string, attempt uint32) *kube.Pod {
+		pod := kube.NewPod(&k8s.PodSandboxConfig{
+			Metadata: &k8s.PodSandboxMetadata{Name: name, Namespace: namespace, Uid: name, Attempt: attempt},
+		})
+		require.NoError(t, s.pods.Add(pod))
+		return pod
+	}
+	nginx := newPod("default", "nginx", 0)
+	newPod("default", "redis", 0)
+	newPod("cache", "redis", 0)
+
+	tt := []struct {
+		name       string
+		ref        string
+		expectPod  *kube.Pod
+		expectCode codes.Code
+	}{
+		{
+			name:      "full ID",
+			ref:       nginx.ID(),
+			expectPod: nginx,
+		},
+		{
+			name:      "ID prefix",
+			ref:       nginx.ID()[:12],
+			expectPod: nginx,
+		},
+		{
+			name:      "name",
+			ref:       "nginx",
+			expectPod: nginx,
+		},
+		{
+			name:      "namespace and name",
+			ref:       "default/nginx",
+			expectPod: nginx,
+		},
+		{
+			name:       "ambiguous name",
+			ref:        "redis",
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			name:       "wrong namespace",
+			ref:        "cache/nginx",
+			expectCode: codes.NotFound,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			pod, err := s.resolvePod(tc.ref)
+			if tc.expectCode != codes.OK {
+				require.Equal(t, tc.expectCode, status.Code(err), "unexpected error %v", err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectPod.ID(), pod.ID())
+		})
+	}
+}
+
+func TestPrepareNetNsExec(t *testing.T) {
+	s := &SingularityRuntime{pods: index.NewPodIndex()}
+	// pod that is not run has no namespaces, just as host network one
+	pod := kube.NewPod(&k8s.PodSandboxConfig{
+		Metadata: &k8s.PodSandboxMetadata{Name: "nginx", Namespace: "default", Uid: "nginx"},
+	})
+	require.NoError(t, s.pods.Add(pod))
+
+	local := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.UnixAddr{Name: "/run/sycri.sock", Net: "unix"}})
+	remote := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4242}})
+
+	tt := []struct {
+		name       string
+		ctx        context.Context
+		req        *admin.PrepareNetNsExecRequest
+		expectCode codes.Code
+	}{
+		{
+			name:       "remote peer",
+			ctx:        remote,
+			req:        &admin.PrepareNetNsExecRequest{Pod: "nginx", Command: []string{"ip", "addr"}},
+			expectCode: codes.PermissionDenied,
+		},
+		{
+			name:       "no peer",
+			ctx:        context.Background(),
+			req:        &admin.PrepareNetNsExecRequest{Pod: "nginx", Command: []string{"ip", "addr"}},
+			expectCode: codes.PermissionDenied,
+		},
+		{
+			name:       "no command",
+			ctx:        local,
+			req:        &admin.PrepareNetNsExecRequest{Pod: "nginx"},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			name:       "unknown pod",
+			ctx:        local,
+			req:        &admin.PrepareNetNsExecRequest{Pod: "redis", Command: []string{"ip", "addr"}},
+			expectCode: codes.NotFound,
+		},
+		{
+			name:       "host network",
+			ctx:        local,
+			req:        &admin.PrepareNetNsExecRequest{Pod: "nginx", Command: []string{"ip", "addr"}},
+			expectCode: codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.PrepareNetNsExec(tc.ctx, tc.req)
+			require.Equal(t, tc.expectCode, status.Code(err), "unexpected error %v", err)
+		})
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity-cri/pkg/metrics"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// DefaultOOMWatchInterval is the default interval OOM kill
+	// counters of running containers are checked at.
+	DefaultOOMWatchInterval = time.Second
+
+	// oomExitChecks is number of checks container is looked for exit on
+	// after OOM kill, as process exits shortly after kill is accounted.
+	oomExitChecks = 5
+)
+
+var containerOOMKills = metrics.NewCounter("sycri_container_oom_kills_total",
+	"Number of container processes killed by OOM killer.")
+
+// oomWatcher watches running containers for processes killed by OOM killer,
+// so that containers exited due to OOM kill are reported without waiting
+// for kubelet to notice exit.
+type oomWatcher struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// since is time watcher was started at, kills of containers
+	// started earlier are not reported as they may be reported already
+	since int64
+	// kills holds last seen OOM kill counters of running containers
+	kills map[string]uint64
+	// exiting holds number of exit checks left for OOM killed containers
+	exiting map[string]int
+}
+
+// WithOOMWatchInterval sets interval OOM kill counters of running containers
+// are checked at. On cgroup v2 hosts kills are noticed as soon as they are
+// accounted regardless of the interval. Zero interval results in
+// DefaultOOMWatchInterval and negative one disables watching, so OOM kills
+// are only found once kubelet notices container exit.
+func WithOOMWatchInterval(interval time.Duration) Option {
+	return func(r *SingularityRuntime) {
+		if interval == 0 {
+			interval = DefaultOOMWatchInterval
+		}
+		r.oom.interval = interval
+	}
+}
+
+// WithRestrictedOOMScoreAdj makes containers never get oom_score_adj lower
+// than the runtime has, which is required when runtime is not allowed to
+// lower it, e.g. in user namespace. By default requested value is set as is.
+func WithRestrictedOOMScoreAdj(restrict bool) Option {
+	return func(r *SingularityRuntime) {
+		r.minOOMScoreAdj = nil
+		if restrict {
+			// actual value is read once all options are applied
+			r.minOOMScoreAdj = new(int)
+		}
+	}
+}
+
+// StartOOMWatcher watches running containers for OOM kills until ctx is done.
+// It is a no-op if watching is disabled with WithOOMWatchInterval.
+func (s *SingularityRuntime) StartOOMWatcher(ctx context.Context) {
+	if s.oom.interval <= 0 {
+		return
+	}
+	s.oom.mu.Lock()
+	s.oom.since = time.Now().UnixNano()
+	s.oom.mu.Unlock()
+
+	var events <-chan fs.WatchEvent
+	watcher, err := fs.NewWatcher()
+	if err != nil {
+		glog.Warningf("OOM kills will be noticed every %v only: %v", s.oom.interval, err)
+	} else {
+		events = watcher.Watch(ctx)
+	}
+	glog.Infof("Containers are checked for OOM kills every %v", s.oom.interval)
+	go func() {
+		if watcher != nil {
+			defer watcher.Close()
+		}
+		ticker := time.NewTicker(s.oom.interval)
+		defer ticker.Stop()
+		for {
+			s.checkOOMKills(watcher)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case _, ok := <-events:
+				if !ok {
+					events = nil
+				}
+			}
+		}
+	}()
+}
+
+// checkOOMKills looks for new OOM kills in running containers and reports
+// containers that exited due to them. Memory events files of containers seen
+// for the first time are added to watcher, if it is not nil.
+func (s *SingularityRuntime) checkOOMKills(watcher *fs.Watcher) {
+	s.oom.mu.Lock()
+	defer s.oom.mu.Unlock()
+
+	if s.oom.kills == nil {
+		s.oom.kills = make(map[string]uint64)
+		s.oom.exiting = make(map[string]int)
+	}
+	var running []*kube.Container
+	s.containers.Iterate(func(c *kube.Container) {
+		if c.State() == k8s.ContainerState_CONTAINER_RUNNING {
+			running = append(running, c)
+		}
+	})
+
+	seen := make(map[string]bool, len(running))
+	for _, cont := range running {
+		seen[cont.ID()] = true
+		kills, ok := cont.OOMKills()
+		if !ok {
+			continue
+		}
+		last, known := s.oom.kills[cont.ID()]
+		s.oom.kills[cont.ID()] = kills
+		if !known {
+			if watcher != nil {
+				for _, path := range cont.OOMEventFiles() {
+					if err := watcher.Add(path); err != nil {
+						glog.V(4).Infof("Could not watch %s: %v", path, err)
+					}
+				}
+			}
+			if cont.StartedAt() < s.oom.since {
+				continue
+			}
+		}
+		if kills <= last {
+			continue
+		}
+		containerOOMKills.Add(float64(kills - last))
+		cont.Warnings().Logf(glog.WarningDepth, "%d processes of container %s were killed by OOM killer", kills-last, cont.ID())
+		s.oom.exiting[cont.ID()] = oomExitChecks
+	}
+	for id := range s.oom.kills {
+		if !seen[id] {
+			delete(s.oom.kills, id)
+		}
+	}
+
+	for id, left := range s.oom.exiting {
+		cont, err := s.containers.Find(id)
+		if err != nil {
+			delete(s.oom.exiting, id)
+			continue
+		}
+		if err := cont.UpdateState(); err != nil {
+			glog.V(4).Infof("Could not update container %s state: %v", id, err)
+		}
+		if cont.State() != k8s.ContainerState_CONTAINER_EXITED {
+			if left <= 1 {
+				// some process other than init was killed
+				delete(s.oom.exiting, id)
+			} else {
+				s.oom.exiting[id] = left - 1
+			}
+			continue
+		}
+		delete(s.oom.exiting, id)
+		if cont.OOMKilled() {
+			glog.Infof("Container %s was killed by OOM killer", id)
+			s.emitContainerEvent(cont, ContainerStoppedEvent)
+			s.runContainerExitedHooks(cont, false)
+		}
+	}
+}
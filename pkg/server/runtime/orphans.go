@@ -0,0 +1,170 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity-cri/pkg/metrics"
+	sRuntime "github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+)
+
+// DefaultOrphanReapInterval is the default interval resources
+// leaked by pods and containers that are gone are cleaned up at.
+const DefaultOrphanReapInterval = 10 * time.Minute
+
+// kinds of leaked resources
+const (
+	orphanNetNs  = "netns"
+	orphanCgroup = "cgroup"
+	orphanMount  = "mount"
+	orphanIPAM   = "ipam"
+)
+
+var orphansReaped = metrics.NewCounter("sycri_orphans_reaped_total",
+	"Number of network namespaces, cgroups, mounts and IPAM addresses leaked by pods and containers that are gone and cleaned up.", "type")
+
+// orphanStats holds counters of orphaned resources cleanup.
+type orphanStats struct {
+	Runs     uint64            `json:"runs"`
+	Reaped   map[string]uint64 `json:"reaped,omitempty"`
+	LastRun  string            `json:"lastRun,omitempty"`
+	Duration string            `json:"lastDuration,omitempty"`
+}
+
+// orphanReaper cleans up resources leaked by pods and containers that are
+// gone, e.g. when daemon crashed in the middle of their removal.
+type orphanReaper struct {
+	interval time.Duration
+
+	// mu serializes cleanups and guards stats
+	mu    sync.Mutex
+	stats orphanStats
+}
+
+// WithOrphanReaper sets interval network namespaces, cgroups and mounts leaked
+// by pods and containers that are gone are cleaned up at. Zero interval results
+// in DefaultOrphanReapInterval and negative one disables periodic cleanup, so
+// that leaked resources are only cleaned up on daemon start. IPAM addresses are
+// reclaimed separately, see WithIPAMReconcile.
+func WithOrphanReaper(interval time.Duration) Option {
+	return func(r *SingularityRuntime) {
+		if interval == 0 {
+			interval = DefaultOrphanReapInterval
+		}
+		r.orphans.interval = interval
+	}
+}
+
+// StartOrphanReaper cleans up leaked resources periodically until ctx is done.
+// It is a no-op if periodic cleanup is disabled with WithOrphanReaper.
+func (s *SingularityRuntime) StartOrphanReaper(ctx context.Context) {
+	if s.orphans.interval <= 0 {
+		return
+	}
+	glog.Infof("Orphaned resources are cleaned up every %v", s.orphans.interval)
+	go func() {
+		ticker := time.NewTicker(s.orphans.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			s.reapOrphans()
+		}
+	}()
+}
+
+// reapOrphans cleans up network namespaces, cgroups and mounts of pods and
+// containers that are neither indexed nor being created and returns number
+// of cleaned up resources of each kind.
+func (s *SingularityRuntime) reapOrphans() map[string]int {
+	s.orphans.mu.Lock()
+	defer s.orphans.mu.Unlock()
+
+	start := time.Now()
+	live := func(id string) bool {
+		if s.inFlight.has(id) {
+			return true
+		}
+		if _, err := s.pods.Find(id); err == nil {
+			return true
+		}
+		_, err := s.containers.Find(id)
+		return err == nil
+	}
+	reaped := make(map[string]int)
+
+	removed, err := kube.RemoveStaleNetNs(s.netNsDir(), live)
+	if err != nil {
+		glog.Errorf("Could not clean up stale network namespaces: %v", err)
+	}
+	reaped[orphanNetNs] = len(removed)
+
+	removed, err = kube.RemoveStaleMounts(s.baseRunDir, live)
+	if err != nil {
+		glog.Errorf("Could not clean up stale mounts: %v", err)
+	}
+	reaped[orphanMount] = len(removed)
+
+	// fake engine processes share cgroups with the runtime
+	if !sRuntime.IsFake(s.ociEngine) && s.cgroupDriver == kube.CgroupDriverCgroupfs {
+		var parents []string
+		s.pods.Iterate(func(pod *kube.Pod) {
+			parents = append(parents, pod.CgroupParent())
+		})
+		removed, err = kube.RemoveStaleCgroups(parents, live)
+		if err != nil {
+			glog.Errorf("Could not clean up stale cgroups: %v", err)
+		}
+		reaped[orphanCgroup] = len(removed)
+	}
+
+	s.orphans.stats.Runs++
+	s.orphans.stats.LastRun = start.Format(time.RFC3339)
+	s.orphans.stats.Duration = time.Since(start).String()
+	for kind, n := range reaped {
+		if n == 0 {
+			continue
+		}
+		if s.orphans.stats.Reaped == nil {
+			s.orphans.stats.Reaped = make(map[string]uint64)
+		}
+		s.orphans.stats.Reaped[kind] += uint64(n)
+		orphansReaped.Add(float64(n), kind)
+		glog.Infof("Cleaned up %d leaked %s resources", n, kind)
+	}
+	return reaped
+}
+
+// orphanStats returns current counters of orphaned resources cleanup.
+func (s *SingularityRuntime) orphanStats() orphanStats {
+	s.orphans.mu.Lock()
+	defer s.orphans.mu.Unlock()
+	stats := s.orphans.stats
+	if stats.Reaped != nil {
+		stats.Reaped = make(map[string]uint64, len(s.orphans.stats.Reaped))
+		for kind, n := range s.orphans.stats.Reaped {
+			stats.Reaped[kind] = n
+		}
+	}
+	return stats
+}
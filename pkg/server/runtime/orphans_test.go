@@ -0,0 +1,59 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	sRuntime "github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+)
+
+func TestReapOrphans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphans-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := &SingularityRuntime{
+		baseRunDir: dir,
+		pods:       index.NewPodIndex(),
+		containers: index.NewContainerIndex(),
+		ociEngine:  sRuntime.NewFakeEngine(),
+	}
+	require.NoError(t, os.MkdirAll(s.netNsDir(), 0755))
+	for _, id := range []string{"creating", "leaked"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(s.netNsDir(), id), nil, 0644))
+	}
+	s.inFlight.add("creating")
+
+	reaped := s.reapOrphans()
+	require.Equal(t, 1, reaped[orphanNetNs])
+	require.Zero(t, reaped[orphanMount])
+	require.FileExists(t, filepath.Join(s.netNsDir(), "creating"))
+	_, err = os.Stat(filepath.Join(s.netNsDir(), "leaked"))
+	require.True(t, os.IsNotExist(err), "leaked namespace must be removed")
+
+	stats := s.orphanStats()
+	require.Equal(t, uint64(1), stats.Runs)
+	require.Equal(t, map[string]uint64{orphanNetNs: 1}, stats.Reaped)
+
+	reaped = s.reapOrphans()
+	require.Zero(t, reaped[orphanNetNs])
+	require.Equal(t, uint64(2), s.orphanStats().Runs)
+}
@@ -16,13 +16,13 @@ package runtime
 
 import (
 	"context"
-	"fmt"
 	"path/filepath"
+	"sort"
 
 	"github.com/golang/glog"
 	"github.com/sylabs/singularity-cri/pkg/index"
 	"github.com/sylabs/singularity-cri/pkg/kube"
-	"github.com/sylabs/singularity-cri/pkg/singularity"
+	sRuntime "github.com/sylabs/singularity-cri/pkg/singularity/runtime"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
@@ -30,38 +30,192 @@ import (
 
 // RunPodSandbox creates and starts a pod-level sandbox. Runtimes must ensure
 // the sandbox is in the ready state on success.
-func (s *SingularityRuntime) RunPodSandbox(_ context.Context, req *k8s.RunPodSandboxRequest) (*k8s.RunPodSandboxResponse, error) {
-	if req.GetRuntimeHandler() != "" && req.GetRuntimeHandler() != singularity.RuntimeName {
-		return nil, status.Errorf(codes.FailedPrecondition, "only %s runtime is supported", singularity.RuntimeName)
+func (s *SingularityRuntime) RunPodSandbox(ctx context.Context, req *k8s.RunPodSandboxRequest) (*k8s.RunPodSandboxResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+	engine, err := s.handlerEngine(req.GetRuntimeHandler())
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
 	}
 
-	pod := kube.NewPod(req.Config)
+	if _, err := kube.ParseExtraHosts(req.GetConfig().GetAnnotations()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s annotation: %v", kube.AnnotationAddHosts, err)
+	}
+	if _, err := kube.ParseTimezone(req.GetConfig().GetAnnotations()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s annotation: %v", kube.AnnotationTimezone, err)
+	}
+	if _, err := kube.ParseLogDriver(req.GetConfig().GetAnnotations()[kube.AnnotationLogDriver]); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s annotation: %v", kube.AnnotationLogDriver, err)
+	}
+	if _, err := kube.ParseSkipNodeDefaults(req.GetConfig().GetAnnotations()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := kube.ParseDisposable(req.GetConfig().GetAnnotations()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := kube.PodUserNamespace(req.GetConfig(), s.userNs); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	debug, err := kube.ParseDebugSandbox(req.GetConfig().GetAnnotations())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	debug = debug || s.debugSandbox
+	hostNetwork := req.GetConfig().GetLinux().GetSecurityContext().GetNamespaceOptions().GetNetwork() != k8s.NamespaceMode_POD
+	if err := kube.ValidateNetQoS(req.GetConfig().GetAnnotations(), hostNetwork); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	networks, err := kube.ParseNetworks(req.GetConfig().GetAnnotations())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(networks) != 0 && hostNetwork {
+		return nil, status.Errorf(codes.InvalidArgument, "%s annotation is not allowed for pods in host network", kube.AnnotationNetworks)
+	}
+	podKey := podFaultKey(req.GetConfig().GetMetadata())
+	if err := s.faults.inject(ctx, "RunPodSandbox", podKey, req.GetConfig().GetAnnotations()); err != nil {
+		return nil, err
+	}
+	existing, err := s.pods.FindByMetadata(req.GetConfig().GetMetadata())
+	if err == nil && existing.Failure() != nil {
+		// retry of failed pod, its artifacts are not needed anymore
+		s.removeFailedPod(existing.ID())
+		existing, err = s.pods.FindByMetadata(req.GetConfig().GetMetadata())
+	}
+	if err == nil {
+		glog.V(2).Infof("Pod %s with the same metadata already exists", existing.ID())
+		return &k8s.RunPodSandboxResponse{
+			PodSandboxId: existing.ID(),
+		}, nil
+	}
+
+	if err := s.admit(ctx, podAdmission(req.GetConfig())); err != nil {
+		return nil, err
+	}
+	pod := s.adoptWarmPod(req.GetRuntimeHandler(), req.GetConfig())
+	if pod == nil {
+		pod, err = s.runPod(ctx, req.GetConfig(), req.GetRuntimeHandler(), engine, debug)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer s.inFlight.remove(pod.ID())
 	cleanupOnFailure := func() {
 		if err := s.pods.Remove(pod.ID()); err != nil {
 			glog.Errorf("Could not remove pod from index: %v", err)
 		}
 	}
-	podBaseDir := filepath.Join(s.baseRunDir, "pods", pod.ID())
-	if err := pod.Run(podBaseDir); err != nil {
+
+	err = s.pods.Add(pod)
+	if err == index.ErrIDExists {
+		// indexed pod owns the same ID, so nothing can be cleaned up safely
+		glog.Errorf("Pod ID %s collides with existing pod", pod.ID())
+		return nil, status.Errorf(codes.AlreadyExists, "pod with ID %s already exists", pod.ID())
+	}
+	if err == index.ErrAlreadyExists {
+		// concurrent request has created the same pod
+		if err := pod.TearDownNetwork(s.networkManager); err != nil {
+			glog.Errorf("Could not tear down network interface: %v", err)
+		}
+		if err := pod.Remove(); err != nil {
+			glog.Errorf("Could not remove duplicate pod: %v", err)
+		}
+		existing, err := s.pods.FindByMetadata(req.GetConfig().GetMetadata())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not find existing pod: %v", err)
+		}
+		return &k8s.RunPodSandboxResponse{
+			PodSandboxId: existing.ID(),
+		}, nil
+	}
+	if err != nil {
+		cleanupOnFailure()
+		return nil, err
+	}
+	if err := s.hooks.run(podHookPayload(HookSandboxReady, pod)); err != nil {
+		if debug {
+			pod.Fail(kube.StageHook, err)
+			s.retainFailedPod(pod, true)
+			return nil, status.Errorf(codes.Internal, "could not run pod: %v", err)
+		}
+		if err := pod.TearDownNetwork(s.networkManager); err != nil {
+			glog.Errorf("Could not tear down network interface: %v", err)
+		}
+		if err := pod.Remove(); err != nil {
+			glog.Errorf("Could not remove pod: %v", err)
+		}
 		cleanupOnFailure()
 		return nil, status.Errorf(codes.Internal, "could not run pod: %v", err)
 	}
+	s.syncPodRecord(pod)
+	return &k8s.RunPodSandboxResponse{
+		PodSandboxId: pod.ID(),
+	}, nil
+}
+
+// runPod creates and runs a new pod with network set up. Returned pod
+// is in flight and must be removed from in flight pods once indexed.
+func (s *SingularityRuntime) runPod(ctx context.Context, config *k8s.PodSandboxConfig,
+	handler string, engine sRuntime.Engine, debug bool) (_ *kube.Pod, err error) {
+	pod := kube.NewPod(config, s.podOptions(handler, engine, debug)...)
+	// pod network must not be reclaimed until pod is indexed,
+	// caller removes pod from in flight ones
+	s.inFlight.add(pod.ID())
+	defer func() {
+		if err != nil {
+			s.inFlight.remove(pod.ID())
+		}
+	}()
+	cleanupOnFailure := func() {
+		if err := s.pods.Remove(pod.ID()); err != nil {
+			glog.Errorf("Could not remove pod from index: %v", err)
+		}
+	}
+	podBaseDir := filepath.Join(s.baseRunDir, "pods", pod.ID())
+	if err := pod.Run(ctx, podBaseDir); err != nil {
+		if pod.Retained() {
+			s.retainFailedPod(pod, false)
+		} else {
+			cleanupOnFailure()
+		}
+		return nil, status.Errorf(codes.Internal, "could not run pod: %v", err)
+	}
 
 	// bring up network interface if requested
 	glog.V(3).Infof("Bringing up network for pod %s", pod.ID())
-	if err := pod.SetUpNetwork(s.networkManager); err != nil {
-		cleanupOnFailure()
+	if err := pod.SetUpNetwork(ctx, s.networkManager); err != nil {
+		if debug {
+			pod.Fail(kube.PhaseNetwork.String(), err)
+			s.retainFailedPod(pod, false)
+		} else {
+			if err := pod.Remove(); err != nil {
+				glog.Errorf("Could not remove pod: %v", err)
+			}
+			cleanupOnFailure()
+		}
 		return nil, status.Errorf(codes.Internal, "could not set up pod network interface: %v", err)
 	}
+	return pod, nil
+}
 
-	err := s.pods.Add(pod)
-	if err != nil {
-		cleanupOnFailure()
-		return nil, err
+// podOptions returns options pods run with the passed handler engine are
+// constructed with. Nil engine means the default one.
+func (s *SingularityRuntime) podOptions(handler string, engine sRuntime.Engine, debug bool) []kube.PodOption {
+	podOpts := []kube.PodOption{
+		kube.WithLogOwner(s.logOwner),
+		kube.WithPodAnnotations(s.annotations),
+		kube.WithRetainOnFailure(debug),
+		kube.WithNetNsDir(s.netNsDir()),
+		kube.WithRuntimeHandler(handler),
+		kube.WithUserNamespace(s.userNs),
+		kube.WithSysctlPolicy(s.sysctlPolicy),
+		kube.WithCgroupDriver(s.cgroupDriver),
 	}
-	return &k8s.RunPodSandboxResponse{
-		PodSandboxId: pod.ID(),
-	}, nil
+	if engine != nil {
+		podOpts = append(podOpts, kube.WithPodEngine(engine))
+	}
+	return podOpts
 }
 
 // StopPodSandbox stops any running process that is part of the sandbox and
@@ -73,11 +227,17 @@ func (s *SingularityRuntime) RunPodSandbox(_ context.Context, req *k8s.RunPodSan
 // at least once before calling RemovePodSandbox. It will also attempt to
 // reclaim resources eagerly, as soon as a sandbox is not needed. Hence,
 // multiple StopPodSandbox calls are expected.
-func (s *SingularityRuntime) StopPodSandbox(_ context.Context, req *k8s.StopPodSandboxRequest) (*k8s.StopPodSandboxResponse, error) {
+func (s *SingularityRuntime) StopPodSandbox(ctx context.Context, req *k8s.StopPodSandboxRequest) (*k8s.StopPodSandboxResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	pod, err := s.findPod(req.PodSandboxId)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.faults.inject(ctx, "StopPodSandbox", podFaultKey(pod.GetMetadata()), pod.GetAnnotations()); err != nil {
+		return nil, err
+	}
 
 	if err := pod.Stop(); err != nil {
 		return nil, status.Errorf(codes.Internal, "could not stop pod: %v", err)
@@ -86,7 +246,7 @@ func (s *SingularityRuntime) StopPodSandbox(_ context.Context, req *k8s.StopPodS
 	// tear down network interface
 	glog.V(3).Infof("Tearing down network for pod %s", pod.ID())
 	if err := pod.TearDownNetwork(s.networkManager); err != nil {
-		glog.Errorf("Could not tear down network interface: %v", err)
+		pod.Warnings().Logf(glog.ErrorDepth, "Could not tear down pod %s network interface: %v", pod.ID(), err)
 	}
 
 	return &k8s.StopPodSandboxResponse{}, nil
@@ -96,7 +256,10 @@ func (s *SingularityRuntime) StopPodSandbox(_ context.Context, req *k8s.StopPodS
 // in the sandbox, they must be forcibly terminated and removed.
 // This call is idempotent, and must not return an error if the sandbox has
 // already been removed.
-func (s *SingularityRuntime) RemovePodSandbox(_ context.Context, req *k8s.RemovePodSandboxRequest) (*k8s.RemovePodSandboxResponse, error) {
+func (s *SingularityRuntime) RemovePodSandbox(ctx context.Context, req *k8s.RemovePodSandboxRequest) (*k8s.RemovePodSandboxResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	pod, err := s.pods.Find(req.PodSandboxId)
 	if err == index.ErrNotFound {
 		return &k8s.RemovePodSandboxResponse{}, nil
@@ -104,24 +267,40 @@ func (s *SingularityRuntime) RemovePodSandbox(_ context.Context, req *k8s.Remove
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	if err := s.faults.inject(ctx, "RemovePodSandbox", podFaultKey(pod.GetMetadata()), pod.GetAnnotations()); err != nil {
+		return nil, err
+	}
 	containers := pod.Containers() // save container IDs to cleanup index later
+	payload := podHookPayload(HookSandboxRemoved, pod)
 	if err := pod.Remove(); err != nil {
 		return nil, status.Errorf(codes.Internal, "could not remove pod: %v", err)
 	}
+	for _, containerID := range containers {
+		if cont, err := s.containers.Find(containerID); err == nil {
+			s.emitContainerEvent(cont, ContainerDeletedEvent)
+		}
+	}
 	if err := s.pods.Remove(pod.ID()); err != nil {
 		return nil, status.Errorf(codes.Internal, "could not remove pod from index: %v", err)
 	}
+	s.removePodRecord(pod.ID())
 	for _, containerID := range containers {
 		if err := s.containers.Remove(containerID); err != nil {
 			return nil, status.Errorf(codes.Internal, "could not remove container from index: %v", err)
 		}
 	}
+	if err := s.hooks.run(payload); err != nil {
+		return nil, status.Errorf(codes.Internal, "pod is removed: %v", err)
+	}
 	return &k8s.RemovePodSandboxResponse{}, nil
 }
 
 // PodSandboxStatus returns the status of the PodSandbox.
 // If the PodSandbox is not present, returns an error.
 func (s *SingularityRuntime) PodSandboxStatus(_ context.Context, req *k8s.PodSandboxStatusRequest) (*k8s.PodSandboxStatusResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	pod, err := s.findPod(req.PodSandboxId)
 	if err != nil {
 		return nil, err
@@ -132,39 +311,30 @@ func (s *SingularityRuntime) PodSandboxStatus(_ context.Context, req *k8s.PodSan
 
 	var verboseInfo map[string]string
 	if req.Verbose {
-		verboseInfo = map[string]string{
-			"pid": fmt.Sprintf("%d", pod.Pid()),
+		verboseInfo, err = s.podInfo(pod)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not get verbose info: %v", err)
 		}
 	}
 	return &k8s.PodSandboxStatusResponse{
-		Status: &k8s.PodSandboxStatus{
-			Id:        pod.ID(),
-			Metadata:  pod.GetMetadata(),
-			State:     pod.State(),
-			CreatedAt: pod.CreatedAt(),
-			Network:   pod.NetworkStatus(),
-			Linux: &k8s.LinuxPodSandboxStatus{
-				Namespaces: &k8s.Namespace{
-					Options: pod.GetLinux().GetSecurityContext().GetNamespaceOptions(),
-				},
-			},
-			Labels:      pod.GetLabels(),
-			Annotations: pod.GetAnnotations(),
-		},
-		Info: verboseInfo,
+		Status: podStatus(pod),
+		Info:   verboseInfo,
 	}, nil
 }
 
 // ListPodSandbox returns a list of PodSandboxes.
 func (s *SingularityRuntime) ListPodSandbox(_ context.Context, req *k8s.ListPodSandboxRequest) (*k8s.ListPodSandboxResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	var pods []*k8s.PodSandbox
 
 	appendPodToResult := func(pod *kube.Pod) {
 		if err := pod.UpdateState(); err != nil {
-			glog.Errorf("Could not update pod state: %v", err)
+			pod.Warnings().Logf(glog.ErrorDepth, "Could not update pod %s state: %v", pod.ID(), err)
 			return
 		}
-		if pod.MatchesFilter(req.Filter) {
+		if pod.MatchesFilter(req.GetFilter()) {
 			pods = append(pods, &k8s.PodSandbox{
 				Id:          pod.ID(),
 				Metadata:    pod.GetMetadata(),
@@ -176,6 +346,9 @@ func (s *SingularityRuntime) ListPodSandbox(_ context.Context, req *k8s.ListPodS
 		}
 	}
 	s.pods.Iterate(appendPodToResult)
+	sort.Slice(pods, func(i, j int) bool {
+		return newerFirst(pods[i].CreatedAt, pods[i].Id, pods[j].CreatedAt, pods[j].Id)
+	})
 	return &k8s.ListPodSandboxResponse{
 		Items: pods,
 	}, nil
@@ -191,3 +364,20 @@ func (s *SingularityRuntime) findPod(id string) (*kube.Pod, error) {
 	}
 	return pod, nil
 }
+
+func podStatus(pod *kube.Pod) *k8s.PodSandboxStatus {
+	return &k8s.PodSandboxStatus{
+		Id:        pod.ID(),
+		Metadata:  pod.GetMetadata(),
+		State:     pod.State(),
+		CreatedAt: pod.CreatedAt(),
+		Network:   pod.NetworkStatus(),
+		Linux: &k8s.LinuxPodSandboxStatus{
+			Namespaces: &k8s.Namespace{
+				Options: pod.GetLinux().GetSecurityContext().GetNamespaceOptions(),
+			},
+		},
+		Labels:      pod.GetLabels(),
+		Annotations: pod.GetAnnotations(),
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+)
+
+// DefaultDebugRetention is a default time failed pods that requested
+// debugging are kept for before their artifacts are removed.
+const DefaultDebugRetention = 10 * time.Minute
+
+// retainFailedPod keeps failed pod in index as not ready, so that its failure
+// can be seen in verbose PodSandboxStatus, and schedules its removal once
+// debug retention passes. Pod that cannot be indexed is removed right away.
+func (s *SingularityRuntime) retainFailedPod(pod *kube.Pod, indexed bool) {
+	if !indexed {
+		err := s.pods.Add(pod)
+		if err == index.ErrIDExists {
+			// indexed pod owns the same ID, so nothing can be cleaned up safely
+			glog.Errorf("Pod ID %s collides with existing pod", pod.ID())
+			return
+		}
+		if err != nil {
+			glog.Errorf("Could not keep failed pod %s: %v", pod.ID(), err)
+			if err := pod.Remove(); err != nil {
+				glog.Errorf("Could not remove pod: %v", err)
+			}
+			return
+		}
+	}
+	id := pod.ID()
+	glog.Infof("Keeping failed pod %s for %v", id, s.debugRetention)
+	time.AfterFunc(s.debugRetention, func() {
+		s.removeFailedPod(id)
+	})
+}
+
+// removeFailedPod removes retained failed pod along with its artifacts.
+// Pod that is already removed, e.g. by kubelet, is ignored.
+func (s *SingularityRuntime) removeFailedPod(id string) {
+	pod, err := s.pods.Find(id)
+	if err != nil || pod.Failure() == nil {
+		return
+	}
+	glog.V(2).Infof("Removing failed pod %s", id)
+	if err := pod.TearDownNetwork(s.networkManager); err != nil {
+		glog.Errorf("Could not tear down network interface: %v", err)
+	}
+	if err := pod.Remove(); err != nil {
+		glog.Errorf("Could not remove failed pod %s: %v", id, err)
+		return
+	}
+	if err := s.pods.Remove(id); err != nil {
+		glog.Errorf("Could not remove pod from index: %v", err)
+	}
+}
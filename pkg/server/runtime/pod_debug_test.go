@@ -0,0 +1,78 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/critest"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity-cri/pkg/server/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestRunPodSandbox_DebugSandbox(t *testing.T) {
+	srv := critest.StartFakeServer(t, critest.WithRuntimeOptions(
+		runtime.WithDebugSandbox(false, 100*time.Millisecond),
+	))
+	defer srv.Stop()
+
+	// net sysctl requires pod network namespace, so pod fails to run
+	config := func(name string, annotations map[string]string) *k8s.PodSandboxConfig {
+		config := critest.PodConfig(name)
+		config.Annotations = annotations
+		config.Linux.Sysctls = map[string]string{"net.ipv4.ip_forward": "1"}
+		return config
+	}
+	listPods := func() []*k8s.PodSandbox {
+		resp, err := srv.Runtime.ListPodSandbox(context.Background(), &k8s.ListPodSandboxRequest{})
+		require.NoError(t, err)
+		return resp.GetItems()
+	}
+	ctx := context.Background()
+
+	_, err := srv.Runtime.RunPodSandbox(ctx, &k8s.RunPodSandboxRequest{Config: config("regular", nil)})
+	require.Error(t, err)
+	require.Empty(t, listPods(), "failed pod is kept without debugging")
+
+	debug := map[string]string{kube.AnnotationDebugSandbox: "true"}
+	_, err = srv.Runtime.RunPodSandbox(ctx, &k8s.RunPodSandboxRequest{Config: config("debug", debug)})
+	require.Error(t, err)
+	pods := listPods()
+	require.Len(t, pods, 1, "failed pod is not kept")
+	podID := pods[0].GetId()
+
+	resp, err := srv.Runtime.PodSandboxStatus(ctx, &k8s.PodSandboxStatusRequest{PodSandboxId: podID, Verbose: true})
+	require.NoError(t, err)
+	require.Equal(t, k8s.PodSandboxState_SANDBOX_NOTREADY, resp.GetStatus().GetState())
+	var info struct {
+		Failure *kube.RunFailure `json:"failure"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(resp.GetInfo()["info"]), &info))
+	require.NotNil(t, info.Failure)
+	require.Equal(t, kube.StageConfig, info.Failure.Stage)
+	require.Contains(t, info.Failure.Error, "net.ipv4.ip_forward")
+
+	require.Eventually(t, func() bool {
+		_, err := srv.Runtime.PodSandboxStatus(ctx, &k8s.PodSandboxStatusRequest{PodSandboxId: podID})
+		return status.Code(err) == codes.NotFound
+	}, time.Second, 10*time.Millisecond, "failed pod is not removed after retention")
+}
@@ -0,0 +1,482 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity-cri/pkg/rand"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	sRuntime "github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// DefaultWarmPoolIdleTimeout is the default time pooled sandbox
+	// is kept for before it is torn down when nobody adopts it.
+	DefaultWarmPoolIdleTimeout = 10 * time.Minute
+
+	// maxPoolCheckInterval limits how often pools are refilled and expired.
+	maxPoolCheckInterval = 10 * time.Second
+	// warmPoolHostname is a hostname of sandboxes that are not adopted yet.
+	warmPoolHostname = "warm-pool"
+)
+
+// WarmPool describes a pool of pre-created pod sandboxes that pods requesting
+// its class with kube.AnnotationWarmPool adopt instead of creating new ones.
+// Pooled sandboxes have network set up and root filesystem of pool image
+// mounted. They are never shared across namespaces or users.
+type WarmPool struct {
+	// Handler is a runtime handler pool serves, empty means the default one.
+	Handler string
+	// Namespace is the only Kubernetes namespace whose pods may adopt sandboxes.
+	Namespace string
+	// Class is a resource class pods request with kube.AnnotationWarmPool.
+	Class string
+	// Image is a reference of image whose root filesystem is kept mounted.
+	// Sandboxes are not created until image is pulled.
+	Image string
+	// RunAsUser and RunAsGroup are user and group sandboxes run as, only
+	// pods with the same sandbox security context adopt them.
+	RunAsUser  *int64
+	RunAsGroup *int64
+	// Size is a number of idle sandboxes kept.
+	Size int
+	// IdleTimeout is time idle sandbox is kept for. Pool is not refilled
+	// once no pod requested its class for that long. Zero means
+	// DefaultWarmPoolIdleTimeout.
+	IdleTimeout time.Duration
+}
+
+// Validate checks pool is fully and correctly defined.
+func (p WarmPool) Validate() error {
+	if p.Namespace == "" || p.Class == "" || p.Image == "" {
+		return fmt.Errorf("namespace, class and image must be set")
+	}
+	if p.Size <= 0 {
+		return fmt.Errorf("pool %s size must be positive", p.Class)
+	}
+	if p.IdleTimeout < 0 {
+		return fmt.Errorf("pool %s idle timeout cannot be negative", p.Class)
+	}
+	if p.RunAsGroup != nil && p.RunAsUser == nil {
+		return fmt.Errorf("pool %s group requires user to be set", p.Class)
+	}
+	return nil
+}
+
+// WarmPoolStats shows how pool is used.
+type WarmPoolStats struct {
+	Handler   string  `json:"handler"`
+	Namespace string  `json:"namespace"`
+	Class     string  `json:"class"`
+	Image     string  `json:"image"`
+	ImageID   string  `json:"imageId,omitempty"`
+	Size      int     `json:"size"`
+	Idle      int     `json:"idle"`
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Expired   uint64  `json:"expired"`
+	HitRate   float64 `json:"hitRate"`
+	LastError string  `json:"lastError,omitempty"`
+}
+
+// poolKey identifies pool pods may adopt sandboxes from.
+type poolKey struct {
+	handler   string
+	namespace string
+	class     string
+}
+
+// pooledPod is an idle pooled sandbox.
+type pooledPod struct {
+	pod     *kube.Pod
+	imageID string
+	since   time.Time
+}
+
+type warmPool struct {
+	config     WarmPool
+	imageID    string
+	idle       []*pooledPod
+	lastDemand time.Time
+	lastError  string
+
+	hits    uint64
+	misses  uint64
+	expired uint64
+}
+
+// warmPools keeps all configured pools.
+type warmPools struct {
+	mu     sync.Mutex
+	pools  map[poolKey]*warmPool
+	refill chan struct{}
+	stop   context.CancelFunc
+	done   chan struct{}
+}
+
+// WithWarmPools sets pools of pre-created pod sandboxes, see WarmPool.
+// Pools are filled in background once runtime is created.
+func WithWarmPools(pools []WarmPool) Option {
+	return func(r *SingularityRuntime) {
+		if len(pools) == 0 {
+			return
+		}
+		r.warmPools = &warmPools{
+			pools:  make(map[poolKey]*warmPool, len(pools)),
+			refill: make(chan struct{}, 1),
+		}
+		now := time.Now()
+		for _, p := range pools {
+			if p.IdleTimeout == 0 {
+				p.IdleTimeout = DefaultWarmPoolIdleTimeout
+			}
+			r.warmPools.pools[newPoolKey(p.Handler, p.Namespace, p.Class)] = &warmPool{
+				config:     p,
+				lastDemand: now,
+			}
+		}
+	}
+}
+
+func newPoolKey(handler, namespace, class string) poolKey {
+	if handler == "" {
+		handler = singularity.RuntimeName
+	}
+	return poolKey{handler: handler, namespace: namespace, class: class}
+}
+
+// startWarmPools fills pools and expires idle sandboxes until stopWarmPools is called.
+func (s *SingularityRuntime) startWarmPools() {
+	if s.warmPools == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.warmPools.stop = cancel
+	s.warmPools.done = make(chan struct{})
+	interval := maxPoolCheckInterval
+	for _, p := range s.warmPools.pools {
+		if half := p.config.IdleTimeout / 2; half < interval {
+			interval = half
+		}
+	}
+	go func() {
+		defer close(s.warmPools.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.maintainWarmPools(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-s.warmPools.refill:
+			}
+		}
+	}()
+}
+
+// stopWarmPools stops pool maintenance and tears down all idle sandboxes.
+func (s *SingularityRuntime) stopWarmPools() {
+	if s.warmPools == nil {
+		return
+	}
+	if s.warmPools.stop != nil {
+		s.warmPools.stop()
+		<-s.warmPools.done
+	}
+	s.warmPools.mu.Lock()
+	var idle []*pooledPod
+	for _, p := range s.warmPools.pools {
+		idle = append(idle, p.idle...)
+		p.idle = nil
+	}
+	s.warmPools.mu.Unlock()
+	for _, entry := range idle {
+		s.tearDownPooledPod(entry)
+	}
+}
+
+// maintainWarmPools tears down sandboxes that are idle for too long,
+// as well as those created from an outdated image, and refills pools
+// that had demand recently.
+func (s *SingularityRuntime) maintainWarmPools(ctx context.Context) {
+	now := time.Now()
+	var stale []*pooledPod
+	var fill []*warmPool
+	s.warmPools.mu.Lock()
+	for _, p := range s.warmPools.pools {
+		p.imageID = s.poolImageID(p.config.Image)
+		kept := p.idle[:0]
+		for _, entry := range p.idle {
+			switch {
+			case now.Sub(entry.since) > p.config.IdleTimeout:
+				p.expired++
+				stale = append(stale, entry)
+			case entry.imageID != p.imageID:
+				stale = append(stale, entry)
+			default:
+				kept = append(kept, entry)
+			}
+		}
+		p.idle = kept
+		if now.Sub(p.lastDemand) <= p.config.IdleTimeout && len(p.idle) < p.config.Size {
+			fill = append(fill, p)
+		}
+	}
+	s.warmPools.mu.Unlock()
+
+	for _, entry := range stale {
+		glog.V(3).Infof("Tearing down idle pooled pod %s", entry.pod.ID())
+		s.tearDownPooledPod(entry)
+	}
+	for _, p := range fill {
+		s.fillWarmPool(ctx, p)
+	}
+}
+
+// fillWarmPool creates sandboxes until pool has its size.
+func (s *SingularityRuntime) fillWarmPool(ctx context.Context, p *warmPool) {
+	for ctx.Err() == nil {
+		s.warmPools.mu.Lock()
+		imageID, missing := p.imageID, p.config.Size-len(p.idle)
+		s.warmPools.mu.Unlock()
+		if missing <= 0 {
+			return
+		}
+		if imageID == "" {
+			s.setPoolError(p, fmt.Errorf("image %s is not pulled", p.config.Image))
+			return
+		}
+		entry, err := s.newPooledPod(ctx, p.config, imageID)
+		if err != nil {
+			glog.Errorf("Could not create pooled pod of %s class: %v", p.config.Class, err)
+			s.setPoolError(p, err)
+			return
+		}
+		s.warmPools.mu.Lock()
+		p.idle = append(p.idle, entry)
+		p.lastError = ""
+		s.warmPools.mu.Unlock()
+	}
+}
+
+func (s *SingularityRuntime) setPoolError(p *warmPool, err error) {
+	s.warmPools.mu.Lock()
+	p.lastError = err.Error()
+	s.warmPools.mu.Unlock()
+}
+
+// poolImageID returns ID of the image pool sandboxes are created for,
+// empty string is returned when image is not pulled.
+func (s *SingularityRuntime) poolImageID(ref string) string {
+	info, err := s.imageIndex.Find(ref)
+	if err != nil {
+		return ""
+	}
+	return info.ID
+}
+
+// newPooledPod runs sandbox with a placeholder config and mounts pool image.
+func (s *SingularityRuntime) newPooledPod(ctx context.Context, config WarmPool, imageID string) (*pooledPod, error) {
+	podOpts := []kube.PodOption{
+		kube.WithLogOwner(s.logOwner),
+		kube.WithPodAnnotations(s.annotations),
+		kube.WithNetNsDir(s.netNsDir()),
+		kube.WithRuntimeHandler(config.Handler),
+		kube.WithUserNamespace(s.userNs),
+	}
+	engine, err := s.handlerEngine(config.Handler)
+	if err != nil {
+		return nil, err
+	}
+	if engine != nil {
+		podOpts = append(podOpts, kube.WithPodEngine(engine))
+	}
+	pod := kube.NewPod(poolTemplate(config), podOpts...)
+	// pooled pods are never indexed, keep their network from being reclaimed
+	s.inFlight.add(pod.ID())
+	entry := &pooledPod{pod: pod}
+	if err := pod.Run(ctx, filepath.Join(s.baseRunDir, "pods", pod.ID())); err != nil {
+		s.inFlight.remove(pod.ID())
+		return nil, fmt.Errorf("could not run pod: %v", err)
+	}
+	if err := pod.SetUpNetwork(ctx, s.networkManager); err != nil {
+		s.tearDownPooledPod(entry)
+		return nil, fmt.Errorf("could not set up pod network interface: %v", err)
+	}
+	if !sRuntime.IsFake(s.ociEngine) && s.lowerDirs != nil {
+		info, err := s.imageIndex.Find(imageID)
+		if err == nil {
+			_, err = s.lowerDirs.Acquire(info.ID, info.Path, pod.ID(), nil)
+		}
+		if err != nil {
+			s.tearDownPooledPod(entry)
+			return nil, fmt.Errorf("could not mount image: %v", err)
+		}
+	}
+	entry.imageID = imageID
+	entry.since = time.Now()
+	glog.V(3).Infof("Pooled pod %s of %s class is ready", pod.ID(), config.Class)
+	return entry, nil
+}
+
+// poolTemplate returns placeholder config pooled sandboxes are run with.
+func poolTemplate(config WarmPool) *k8s.PodSandboxConfig {
+	name := fmt.Sprintf("%s-%s-%s", warmPoolHostname, config.Class, rand.GenerateID(8))
+	security := &k8s.LinuxSandboxSecurityContext{
+		NamespaceOptions: &k8s.NamespaceOption{
+			Network: k8s.NamespaceMode_POD,
+			Pid:     k8s.NamespaceMode_CONTAINER,
+			Ipc:     k8s.NamespaceMode_POD,
+		},
+	}
+	if config.RunAsUser != nil {
+		security.RunAsUser = &k8s.Int64Value{Value: *config.RunAsUser}
+	}
+	if config.RunAsGroup != nil {
+		security.RunAsGroup = &k8s.Int64Value{Value: *config.RunAsGroup}
+	}
+	return &k8s.PodSandboxConfig{
+		Metadata: &k8s.PodSandboxMetadata{
+			Name:      name,
+			Namespace: config.Namespace,
+			Uid:       rand.NewID(),
+		},
+		Hostname:    warmPoolHostname,
+		Annotations: map[string]string{kube.AnnotationWarmPool: config.Class},
+		Linux: &k8s.LinuxPodSandboxConfig{
+			SecurityContext: security,
+		},
+	}
+}
+
+// tearDownPooledPod stops and removes sandbox that is not adopted.
+func (s *SingularityRuntime) tearDownPooledPod(entry *pooledPod) {
+	pod := entry.pod
+	defer s.inFlight.remove(pod.ID())
+	if err := pod.Stop(); err != nil {
+		glog.Errorf("Could not stop pooled pod %s: %v", pod.ID(), err)
+	}
+	if err := pod.TearDownNetwork(s.networkManager); err != nil {
+		glog.Errorf("Could not tear down pooled pod %s network: %v", pod.ID(), err)
+	}
+	if err := pod.Remove(); err != nil {
+		glog.Errorf("Could not remove pooled pod %s: %v", pod.ID(), err)
+	}
+	if entry.imageID != "" && s.lowerDirs != nil {
+		s.lowerDirs.Release(entry.imageID, pod.ID())
+	}
+}
+
+// adoptWarmPod returns pooled sandbox adopted by pod with the passed config.
+// When pod doesn't request warm pool or no compatible sandbox is idle nil is
+// returned and pod should be created as usual. Returned pod is in flight
+// and must be removed from in flight pods once indexed.
+func (s *SingularityRuntime) adoptWarmPod(handler string, config *k8s.PodSandboxConfig) *kube.Pod {
+	class := config.GetAnnotations()[kube.AnnotationWarmPool]
+	if class == "" || s.warmPools == nil {
+		return nil
+	}
+	key := newPoolKey(handler, config.GetMetadata().GetNamespace(), class)
+	md := config.GetMetadata()
+
+	s.warmPools.mu.Lock()
+	p, ok := s.warmPools.pools[key]
+	if !ok {
+		s.warmPools.mu.Unlock()
+		glog.V(3).Infof("No %s warm pool for pod %s/%s", class, md.GetNamespace(), md.GetName())
+		return nil
+	}
+	p.lastDemand = time.Now()
+	var entry *pooledPod
+	if err := kube.CheckAdoptable(poolTemplate(p.config), config); err != nil {
+		glog.V(2).Infof("Pod %s/%s cannot adopt %s pooled sandbox: %v", md.GetNamespace(), md.GetName(), class, err)
+	} else if n := len(p.idle); n != 0 && p.idle[n-1].imageID == p.imageID {
+		entry, p.idle = p.idle[n-1], p.idle[:n-1]
+	}
+	if entry == nil {
+		p.misses++
+	} else {
+		p.hits++
+	}
+	s.warmPools.mu.Unlock()
+	s.triggerPoolRefill()
+	if entry == nil {
+		return nil
+	}
+
+	if err := entry.pod.Adopt(config); err != nil {
+		glog.Errorf("Could not adopt pooled pod %s: %v", entry.pod.ID(), err)
+		s.tearDownPooledPod(entry)
+		return nil
+	}
+	// image stays mounted for grace period, so containers find it warm
+	if s.lowerDirs != nil {
+		s.lowerDirs.Release(entry.imageID, entry.pod.ID())
+	}
+	return entry.pod
+}
+
+func (s *SingularityRuntime) triggerPoolRefill() {
+	select {
+	case s.warmPools.refill <- struct{}{}:
+	default:
+	}
+}
+
+// warmPoolStats returns usage of each pool.
+func (s *SingularityRuntime) warmPoolStats() []WarmPoolStats {
+	if s.warmPools == nil {
+		return nil
+	}
+	s.warmPools.mu.Lock()
+	defer s.warmPools.mu.Unlock()
+	stats := make([]WarmPoolStats, 0, len(s.warmPools.pools))
+	for key, p := range s.warmPools.pools {
+		stat := WarmPoolStats{
+			Handler:   key.handler,
+			Namespace: key.namespace,
+			Class:     key.class,
+			Image:     p.config.Image,
+			ImageID:   p.imageID,
+			Size:      p.config.Size,
+			Idle:      len(p.idle),
+			Hits:      p.hits,
+			Misses:    p.misses,
+			Expired:   p.expired,
+			LastError: p.lastError,
+		}
+		if total := p.hits + p.misses; total != 0 {
+			stat.HitRate = float64(p.hits) / float64(total)
+		}
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Namespace != stats[j].Namespace {
+			return stats[i].Namespace < stats[j].Namespace
+		}
+		return stats[i].Class < stats[j].Class
+	})
+	return stats
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/critest"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity-cri/pkg/server/runtime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func warmPoolStats(t *testing.T, srv *critest.Server) []runtime.WarmPoolStats {
+	resp, err := srv.Runtime.Status(context.Background(), &k8s.StatusRequest{Verbose: true})
+	require.NoError(t, err)
+	var stats []runtime.WarmPoolStats
+	require.NoError(t, json.Unmarshal([]byte(resp.GetInfo()["warmPools"]), &stats))
+	return stats
+}
+
+func TestWarmPool_Validate(t *testing.T) {
+	tt := []struct {
+		name        string
+		pool        runtime.WarmPool
+		expectError bool
+	}{
+		{
+			name: "valid",
+			pool: runtime.WarmPool{Namespace: "batch", Class: "small", Image: "busybox", Size: 2},
+		},
+		{
+			// handlers are checked against configured ones by runtime
+			name: "custom handler",
+			pool: runtime.WarmPool{Handler: "userns", Namespace: "batch", Class: "small", Image: "busybox", Size: 2},
+		},
+		{
+			name:        "no image",
+			pool:        runtime.WarmPool{Namespace: "batch", Class: "small", Size: 2},
+			expectError: true,
+		},
+		{
+			name:        "empty",
+			pool:        runtime.WarmPool{Namespace: "batch", Class: "small", Image: "busybox"},
+			expectError: true,
+		},
+		{
+			name:        "negative idle timeout",
+			pool:        runtime.WarmPool{Namespace: "batch", Class: "small", Image: "busybox", Size: 2, IdleTimeout: -time.Second},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.pool.Validate()
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestRunPodSandbox_WarmPool(t *testing.T) {
+	srv := critest.StartFakeServer(t,
+		critest.WithImages(critest.FakeImage{Ref: "busybox"}),
+		critest.WithRuntimeOptions(runtime.WithWarmPools([]runtime.WarmPool{
+			{Namespace: "batch", Class: "small", Image: "busybox", Size: 2},
+		})),
+	)
+	defer srv.Stop()
+
+	require.Eventually(t, func() bool {
+		stats := warmPoolStats(t, srv)
+		return len(stats) == 1 && stats[0].Idle == 2
+	}, 5*time.Second, 20*time.Millisecond, "pool is not filled")
+
+	config := func(namespace string) *k8s.PodSandboxConfig {
+		config := critest.PodConfig("job")
+		config.Metadata.Namespace = namespace
+		config.Hostname = "job"
+		config.Annotations = map[string]string{kube.AnnotationWarmPool: "small"}
+		config.Linux.SecurityContext.NamespaceOptions = &k8s.NamespaceOption{
+			Network: k8s.NamespaceMode_POD,
+			Pid:     k8s.NamespaceMode_CONTAINER,
+			Ipc:     k8s.NamespaceMode_POD,
+		}
+		return config
+	}
+	ctx := context.Background()
+
+	resp, err := srv.Runtime.RunPodSandbox(ctx, &k8s.RunPodSandboxRequest{Config: config("batch")})
+	require.NoError(t, err)
+	podStatus, err := srv.Runtime.PodSandboxStatus(ctx, &k8s.PodSandboxStatusRequest{PodSandboxId: resp.GetPodSandboxId(), Verbose: true})
+	require.NoError(t, err)
+	require.Equal(t, k8s.PodSandboxState_SANDBOX_READY, podStatus.GetStatus().GetState())
+	require.Equal(t, "job", podStatus.GetStatus().GetMetadata().GetName())
+	require.Equal(t, "batch", podStatus.GetStatus().GetMetadata().GetNamespace())
+	var info struct {
+		Adopted bool `json:"adopted"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(podStatus.GetInfo()["info"]), &info))
+	require.True(t, info.Adopted, "pod is not adopted from the pool")
+
+	// pods of other namespaces never get pooled sandboxes
+	resp, err = srv.Runtime.RunPodSandbox(ctx, &k8s.RunPodSandboxRequest{Config: config("default")})
+	require.NoError(t, err)
+	podStatus, err = srv.Runtime.PodSandboxStatus(ctx, &k8s.PodSandboxStatusRequest{PodSandboxId: resp.GetPodSandboxId(), Verbose: true})
+	require.NoError(t, err)
+	info.Adopted = false
+	require.NoError(t, json.Unmarshal([]byte(podStatus.GetInfo()["info"]), &info))
+	require.False(t, info.Adopted, "pod of other namespace is adopted")
+
+	stats := warmPoolStats(t, srv)
+	require.Len(t, stats, 1)
+	require.EqualValues(t, 1, stats[0].Hits)
+	require.EqualValues(t, 0, stats[0].Misses)
+
+	// pooled sandboxes are not listed
+	list, err := srv.Runtime.ListPodSandbox(ctx, &k8s.ListPodSandboxRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetItems(), 2)
+
+	require.Eventually(t, func() bool {
+		return warmPoolStats(t, srv)[0].Idle == 2
+	}, 5*time.Second, 20*time.Millisecond, "pool is not refilled")
+}
+
+func TestWarmPool_Expire(t *testing.T) {
+	srv := critest.StartFakeServer(t,
+		critest.WithImages(critest.FakeImage{Ref: "busybox"}),
+		critest.WithRuntimeOptions(runtime.WithWarmPools([]runtime.WarmPool{
+			{Namespace: "batch", Class: "small", Image: "busybox", Size: 1, IdleTimeout: 200 * time.Millisecond},
+		})),
+	)
+	defer srv.Stop()
+
+	require.Eventually(t, func() bool {
+		stats := warmPoolStats(t, srv)
+		return len(stats) == 1 && stats[0].Expired > 0 && stats[0].Idle == 0
+	}, 5*time.Second, 20*time.Millisecond, "idle sandbox is not expired")
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	sRuntime "github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+)
+
+const (
+	// podRecordDir and containerRecordDir are directories under base run
+	// directory where records needed to restore pods and containers after
+	// restart are stored. Records hold full configs, including container
+	// environment, so unlike state files they are readable by owner only.
+	podRecordDir       = "restore/pods"
+	containerRecordDir = "restore/containers"
+)
+
+// WithLiveRestore makes Shutdown leave pods and containers running so that
+// they are restored by the next runtime instance. By default all pods are
+// stopped and removed on shutdown.
+func WithLiveRestore(enabled bool) Option {
+	return func(r *SingularityRuntime) {
+		r.liveRestore = enabled
+	}
+}
+
+// WithContainerMonitor makes containers supervised by a monitor run from the
+// executable at path, see kube.WithMonitor. Empty path disables monitors,
+// which is the default.
+func WithContainerMonitor(path string) Option {
+	return func(r *SingularityRuntime) {
+		r.monitorPath = path
+	}
+}
+
+func (s *SingularityRuntime) recordPath(dir, id string) string {
+	return filepath.Join(s.baseRunDir, dir, id+".json")
+}
+
+// syncPodRecord replaces pod record with the current pod state.
+// Failures are logged only, pod is not restored after restart then.
+func (s *SingularityRuntime) syncPodRecord(pod *kube.Pod) {
+	if err := writeRecord(s.recordPath(podRecordDir, pod.ID()), pod.Record()); err != nil {
+		glog.Errorf("Could not update pod %s record: %v", pod.ID(), err)
+	}
+}
+
+// syncContainerRecord updates container record according to the passed
+// lifecycle event. Failures are logged only, container is not restored
+// after restart then.
+func (s *SingularityRuntime) syncContainerRecord(cont *kube.Container, eventType ContainerEventType) {
+	if eventType != ContainerDeletedEvent {
+		s.writeContainerRecord(cont)
+		return
+	}
+	if err := removeRecord(s.recordPath(containerRecordDir, cont.ID())); err != nil {
+		glog.Errorf("Could not remove container %s record: %v", cont.ID(), err)
+	}
+}
+
+// writeContainerRecord replaces container record with the current container state.
+func (s *SingularityRuntime) writeContainerRecord(cont *kube.Container) {
+	if err := writeRecord(s.recordPath(containerRecordDir, cont.ID()), cont.Record()); err != nil {
+		glog.Errorf("Could not update container %s record: %v", cont.ID(), err)
+	}
+}
+
+// removePodRecord removes record of the removed pod.
+func (s *SingularityRuntime) removePodRecord(id string) {
+	if err := removeRecord(s.recordPath(podRecordDir, id)); err != nil {
+		glog.Errorf("Could not remove pod %s record: %v", id, err)
+	}
+}
+
+// writeRecord atomically replaces private record file at path.
+func writeRecord(path string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("could not marshal record: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("could not create record directory: %v", err)
+	}
+	if err := fs.WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("could not write record file: %v", err)
+	}
+	return nil
+}
+
+func removeRecord(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// readRecords decodes every record found in dir with decode.
+// Records that cannot be read are removed.
+func readRecords(dir string, decode func(data []byte) error) error {
+	fii, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read records directory: %v", err)
+	}
+	for _, fi := range fii {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = decode(data)
+		}
+		if err != nil {
+			glog.Errorf("Skipping invalid record %s: %v", path, err)
+			if err := removeRecord(path); err != nil {
+				glog.Errorf("Could not remove invalid record: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// restore re-adopts pods and containers recorded by previous runtime
+// instance and reconciles their state with the engine. Records of pods
+// and containers that are gone are removed.
+func (s *SingularityRuntime) restore() error {
+	var pods []*kube.PodRecord
+	err := readRecords(filepath.Join(s.baseRunDir, podRecordDir), func(data []byte) error {
+		rec := new(kube.PodRecord)
+		if err := json.Unmarshal(data, rec); err != nil {
+			return err
+		}
+		pods = append(pods, rec)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rec := range pods {
+		if err := s.restorePod(rec); err != nil {
+			glog.Errorf("Could not restore pod %s: %v", rec.ID, err)
+			s.removePodRecord(rec.ID)
+		}
+	}
+
+	var containers []*kube.ContainerRecord
+	err = readRecords(filepath.Join(s.baseRunDir, containerRecordDir), func(data []byte) error {
+		rec := new(kube.ContainerRecord)
+		if err := json.Unmarshal(data, rec); err != nil {
+			return err
+		}
+		containers = append(containers, rec)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rec := range containers {
+		if err := s.restoreContainer(rec); err != nil {
+			glog.Errorf("Could not restore container %s: %v", rec.ID, err)
+			if err := removeRecord(s.recordPath(containerRecordDir, rec.ID)); err != nil {
+				glog.Errorf("Could not remove container %s record: %v", rec.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SingularityRuntime) restorePod(rec *kube.PodRecord) error {
+	engine, err := s.handlerEngine(rec.Handler)
+	if err != nil {
+		return err
+	}
+	pod, err := kube.RestorePod(rec, s.podOptions(rec.Handler, engine, false)...)
+	if err == sRuntime.ErrNotFound {
+		return fmt.Errorf("pod is gone")
+	}
+	if err != nil {
+		return err
+	}
+	if s.networkManager != nil {
+		if err := pod.RestoreNetwork(s.networkManager); err != nil {
+			pod.Warnings().Logf(glog.WarningDepth, "Pod %s network is not torn down on removal: %v", pod.ID(), err)
+		}
+	}
+	return s.pods.Add(pod)
+}
+
+func (s *SingularityRuntime) restoreContainer(rec *kube.ContainerRecord) error {
+	pod, err := s.pods.Find(rec.PodID)
+	if err != nil {
+		return fmt.Errorf("could not find pod %s: %v", rec.PodID, err)
+	}
+	info, err := s.imageIndex.Find(rec.ImageID)
+	if err != nil {
+		return fmt.Errorf("could not find image %s: %v", rec.ImageID, err)
+	}
+	cont, err := kube.RestoreContainer(rec, pod, info, s.trashDir, s.containerOptions()...)
+	if err == sRuntime.ErrNotFound {
+		return fmt.Errorf("container is gone")
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.containers.Add(cont); err != nil {
+		return err
+	}
+	if err := s.writeContainerState(cont, pod); err != nil {
+		glog.Errorf("Could not update container %s state file: %v", cont.ID(), err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	sRuntime "github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestWriteRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, containerRecordDir, "test.json")
+	require.NoError(t, writeRecord(path, &kube.ContainerRecord{ID: "test"}))
+	for _, p := range []string{path, filepath.Dir(path)} {
+		fi, err := os.Stat(p)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0), fi.Mode().Perm()&0077, "%s must be private", p)
+	}
+	require.NoError(t, removeRecord(path))
+	require.NoError(t, removeRecord(path), "removal must be idempotent")
+}
+
+func TestSingularityRuntime_restore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := &SingularityRuntime{
+		baseRunDir: dir,
+		imageIndex: index.NewImageIndex(),
+		pods:       index.NewPodIndex(),
+		containers: index.NewContainerIndex(),
+		ociEngine:  sRuntime.NewFakeEngine(),
+	}
+	podDir := filepath.Join(dir, "pods", "gone")
+	require.NoError(t, os.MkdirAll(podDir, 0755))
+	require.NoError(t, writeRecord(s.recordPath(podRecordDir, "gone"), &kube.PodRecord{
+		ID:      "gone",
+		Config:  &k8s.PodSandboxConfig{Metadata: &k8s.PodSandboxMetadata{Name: "pod"}},
+		BaseDir: podDir,
+	}))
+	require.NoError(t, writeRecord(s.recordPath(podRecordDir, "unknown-handler"), &kube.PodRecord{
+		ID:      "unknown-handler",
+		Handler: "kata",
+	}))
+	require.NoError(t, ioutil.WriteFile(s.recordPath(podRecordDir, "invalid"), []byte("{"), 0600))
+	require.NoError(t, writeRecord(s.recordPath(containerRecordDir, "orphan"), &kube.ContainerRecord{
+		ID:    "orphan",
+		PodID: "gone",
+	}))
+
+	require.NoError(t, s.restore())
+	_, err = s.pods.Find("gone")
+	require.Equal(t, index.ErrNotFound, err)
+	_, err = os.Stat(podDir)
+	require.True(t, os.IsNotExist(err), "files of gone pod must be removed")
+	for _, recordDir := range []string{podRecordDir, containerRecordDir} {
+		fii, err := ioutil.ReadDir(filepath.Join(dir, recordDir))
+		if !os.IsNotExist(err) {
+			require.NoError(t, err)
+		}
+		require.Empty(t, fii, "records of pods and containers that are not restored must be removed")
+	}
+}
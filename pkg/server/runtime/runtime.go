@@ -16,18 +16,25 @@ package runtime
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"os/exec"
-	"strings"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/fs"
 	"github.com/sylabs/singularity-cri/pkg/index"
 	"github.com/sylabs/singularity-cri/pkg/kube"
 	"github.com/sylabs/singularity-cri/pkg/network"
+	"github.com/sylabs/singularity-cri/pkg/preflight"
 	"github.com/sylabs/singularity-cri/pkg/singularity"
-	snetwork "github.com/sylabs/singularity/pkg/network"
+	sRuntime "github.com/sylabs/singularity-cri/pkg/singularity/runtime"
+	"github.com/sylabs/singularity-cri/pkg/version"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
@@ -40,6 +47,12 @@ const (
 
 	// DefaultStreamingURL is the default streaming server address.
 	DefaultStreamingURL = "127.0.0.1:12345"
+
+	// DefaultStreamingTokenTTL is the default time streaming URLs are valid for.
+	DefaultStreamingTokenTTL = time.Minute
+
+	// fakeEngineCondition is a runtime condition reported when fake engine is used.
+	fakeEngineCondition = "FakeEngine"
 )
 
 // SingularityRuntime implements k8s RuntimeService interface.
@@ -51,9 +64,70 @@ type SingularityRuntime struct {
 	baseRunDir  string
 	trashDir    string
 
+	fullImageCheck bool
+	logOwner       *kube.Owner
+	redactedEnvs   []string
+	mountPolicy    *kube.MountPolicy
+	selinuxRelabel bool
+	sysctlPolicy   *kube.SysctlPolicy
+	minOOMScoreAdj *int
+	ociHooks       *kube.OCIHooks
+	nvidia         *kube.NvidiaFiles
+	annotations    []string
+	logDriver      kube.LogDriver
+	logBufferSize  int
+	logOverflow    kube.LogOverflow
+	logRotation    kube.LogRotation
+	userNs         *kube.UserNamespace
+	cgroupDriver   kube.CgroupDriver
+	writableSize   int64
+	writableQuota  *fs.ProjectQuota
+	attachReplay   int
+	execOutput     int
+	contDefaults   *kube.ContainerDefaults
+	lowerGrace     time.Duration
+	lowerDirs      *kube.LowerDirs
+	maxHotExited   int
+	compactAge     time.Duration
+	preflight      []preflight.Result
+
+	maxListAnnotations int
+	listCache          listCache
+
+	// numaMemoryBinding binds memory of pinned containers to local NUMA nodes
+	numaMemoryBinding bool
+
+	// liveRestore leaves pods running on shutdown
+	liveRestore bool
+	// monitorPath is an executable containers are supervised by
+	monitorPath string
+
+	debugSandbox   bool
+	debugRetention time.Duration
+
+	engineMu sync.RWMutex
+	engine   engineProbe
+	// ociEngine is nil unless pods and containers are
+	// run with an engine other than Singularity.
+	ociEngine sRuntime.Engine
+	faults    *faultInjector
+
+	handlers       []RuntimeHandler
+	handlerEngines map[string]sRuntime.Engine
+
 	streaming streaming.Server
 
 	networkManager *network.Manager
+	ipam           ipamReconciler
+	stats          statsCollector
+	inFlight       inFlightIDs
+	orphans        orphanReaper
+	oom            oomWatcher
+	warmPools      *warmPools
+
+	events    *eventBus
+	hooks     *hookRunner
+	admission *AdmissionPolicy
 }
 
 // Option is run during SingularityRuntime initialization.
@@ -61,40 +135,92 @@ type SingularityRuntime struct {
 type Option func(r *SingularityRuntime)
 
 // NewSingularityRuntime initializes and returns SingularityRuntime.
-// Singularity must be installed on the host otherwise it will return an error.
-// SingularityRuntime depends on SingularityRegistry so it must not be nil.
+// Singularity must be installed on the host otherwise it will return an error,
+// unless fake engine is used. SingularityRuntime depends on SingularityRegistry
+// so it must not be nil.
 func NewSingularityRuntime(imgIndex *index.ImageIndex, opts ...Option) (*SingularityRuntime, error) {
-	sing, err := exec.LookPath(singularity.RuntimeName)
-	if err != nil {
-		return nil, fmt.Errorf("could not find %s on this machine: %v", singularity.RuntimeName, err)
-	}
-
 	runtime := &SingularityRuntime{
-		singularity: sing,
-		imageIndex:  imgIndex,
-		pods:        index.NewPodIndex(),
-		containers:  index.NewContainerIndex(),
-		baseRunDir:  DefaultBaseRunDir,
+		imageIndex:   imgIndex,
+		pods:         index.NewPodIndex(),
+		containers:   index.NewContainerIndex(),
+		baseRunDir:   DefaultBaseRunDir,
+		redactedEnvs: DefaultRedactedEnvs,
+		attachReplay: kube.DefaultAttachReplaySize,
+		execOutput:   kube.DefaultExecSyncOutputLimit,
+		lowerGrace:   kube.DefaultLowerDirGracePeriod,
+		maxHotExited: kube.DefaultMaxHotExited,
+		compactAge:   kube.DefaultExitedCompactAge,
+		events:       newEventBus(DefaultEventBufferSize),
+		stats:        statsCollector{interval: DefaultStatsInterval},
+		orphans:      orphanReaper{interval: DefaultOrphanReapInterval},
+		oom:          oomWatcher{interval: DefaultOOMWatchInterval},
+
+		maxListAnnotations: DefaultMaxListAnnotationsSize,
+		debugRetention:     DefaultDebugRetention,
+		selinuxRelabel:     true,
+		cgroupDriver:       kube.CgroupDriverCgroupfs,
 	}
 
 	for _, opt := range opts {
 		opt(runtime)
 	}
+	if runtime.minOOMScoreAdj != nil {
+		adj, err := kube.ReadOOMScoreAdj(os.Getpid())
+		if err != nil {
+			return nil, err
+		}
+		*runtime.minOOMScoreAdj = adj
+	}
+	if !sRuntime.IsFake(runtime.ociEngine) {
+		sing, err := exec.LookPath(singularity.RuntimeName)
+		if err != nil {
+			return nil, fmt.Errorf("could not find %s on this machine: %v", singularity.RuntimeName, err)
+		}
+		runtime.singularity = sing
+		runtime.nvidia = kube.NewNvidiaFiles()
+	}
+	if err := runtime.setUpHandlers(); err != nil {
+		return nil, err
+	}
+	if err := runtime.RefreshEngineVersion(); err != nil {
+		return nil, err
+	}
+	var err error
+	runtime.lowerDirs, err = kube.NewLowerDirs(filepath.Join(runtime.baseRunDir, "lower"), runtime.lowerGrace)
+	if err != nil {
+		return nil, err
+	}
+	runtime.writableQuota, err = fs.NewProjectQuota(runtime.baseRunDir)
+	switch {
+	case err == fs.ErrQuotaNotSupported:
+		glog.V(2).Infof("No project quotas in %s, limited writable layers are kept on tmpfs", runtime.baseRunDir)
+	case err != nil:
+		glog.Warningf("Could not set up project quotas, limited writable layers are kept on tmpfs: %v", err)
+	default:
+		glog.V(2).Infof("Writable layers are limited with project quotas on %s", runtime.writableQuota.MountPoint())
+	}
+	if err := runtime.restore(); err != nil {
+		glog.Errorf("Could not restore pods, leaked resources are not cleaned up: %v", err)
+	} else {
+		// pods and containers are restored by now, so every
+		// namespace, cgroup and mount left behind is leaked
+		runtime.reapOrphans()
+	}
+	runtime.startWarmPools()
 	return runtime, nil
 }
 
 // WithStreaming sets enables streaming endpoints by setting streaming server URL.
-// If url is empty DefaultStreamingURL will be used.
-func WithStreaming(url string) Option {
+// If url is empty DefaultStreamingURL will be used. Streaming URLs returned by
+// Exec, Attach and PortForward calls stay valid for tokenTTL, DefaultStreamingTokenTTL
+// is used when it is zero. Streaming server is served over TLS when tlsConfig is set.
+func WithStreaming(url string, tokenTTL time.Duration, tlsConfig *tls.Config) Option {
 	return func(r *SingularityRuntime) {
 		if url == "" {
 			url = DefaultStreamingURL
 		}
 
-		streamingRuntime := &streamingRuntime{r}
-		streamingConfig := streaming.DefaultConfig
-		streamingConfig.Addr = url
-		streamingServer, err := streaming.NewServer(streamingConfig, streamingRuntime)
+		streamingServer, err := newStreamingServer(&streamingRuntime{r}, url, tokenTTL, tlsConfig)
 		if err != nil {
 			glog.Errorf("Could not create streaming server: %v", err)
 			glog.Warning("Streaming endpoints are disabled")
@@ -114,10 +240,11 @@ func WithStreaming(url string) Option {
 
 // WithNetwork accepts CNI paths and enables networking support.
 // If cniBin or cniConf is an empty string corresponding default
-// value from network package will be used.
-func WithNetwork(cniBin, cniConf string) Option {
+// value from network package will be used. When cniConfTemplate is set
+// CNI network configuration is rendered from it once pod CIDR is known.
+func WithNetwork(cniBin, cniConf, cniConfTemplate string) Option {
 	return func(r *SingularityRuntime) {
-		cniPath := &snetwork.CNIPath{
+		cniPath := &network.CNIPath{
 			Conf:   cniConf,
 			Plugin: cniBin,
 		}
@@ -128,12 +255,53 @@ func WithNetwork(cniBin, cniConf string) Option {
 			cniPath.Plugin = network.CNIBinDir
 		}
 		r.networkManager = &network.Manager{}
-		if err := r.networkManager.Init(cniPath); err != nil {
+		if err := r.networkManager.Init(cniPath, cniConfTemplate); err != nil {
 			glog.Errorf("Could not initialize network manager: %v", err)
 		}
 	}
 }
 
+// WithLowerDirGracePeriod sets time shared image root filesystem stays mounted
+// after the last container using it is removed. Overrides kube.DefaultLowerDirGracePeriod.
+func WithLowerDirGracePeriod(grace time.Duration) Option {
+	return func(r *SingularityRuntime) {
+		r.lowerGrace = grace
+	}
+}
+
+// WithExitedCompaction sets how many most recently exited containers are kept
+// in full and time since exit after which exited containers are compacted, see
+// kube.Container.Compact. Negative value disables the respective limit.
+// Overrides kube.DefaultMaxHotExited and kube.DefaultExitedCompactAge.
+func WithExitedCompaction(maxHot int, age time.Duration) Option {
+	return func(r *SingularityRuntime) {
+		r.maxHotExited = maxHot
+		r.compactAge = age
+	}
+}
+
+// WithListAnnotationsLimit sets size limit in bytes of each container's
+// annotations in list responses. Negative value disables the limit.
+// Overrides DefaultMaxListAnnotationsSize.
+func WithListAnnotationsLimit(size int) Option {
+	return func(r *SingularityRuntime) {
+		r.maxListAnnotations = size
+	}
+}
+
+// WithDebugSandbox makes all pods keep their files, namespaces and partial
+// bundle when they fail to run, individual pods may request that with
+// kube.AnnotationDebugSandbox. Failed pods are kept as not ready for the
+// retention time, non-positive retention overrides DefaultDebugRetention.
+func WithDebugSandbox(enabled bool, retention time.Duration) Option {
+	return func(r *SingularityRuntime) {
+		r.debugSandbox = enabled
+		if retention > 0 {
+			r.debugRetention = retention
+		}
+	}
+}
+
 // WithBaseRunDir sets base directory where all running pods
 // and containers are stored. Overrides DefaultBaseRunDir.
 func WithBaseRunDir(dir string) Option {
@@ -150,11 +318,218 @@ func WithTrashDir(dir string) Option {
 	}
 }
 
+// WithFullImageCheck makes container creation verify full checksum of
+// the image file instead of a quick size and partial checksum check.
+func WithFullImageCheck(full bool) Option {
+	return func(r *SingularityRuntime) {
+		r.fullImageCheck = full
+	}
+}
+
+// WithLogDirOwner sets owner of pod log directories created by runtime.
+func WithLogDirOwner(owner *kube.Owner) Option {
+	return func(r *SingularityRuntime) {
+		r.logOwner = owner
+	}
+}
+
+// WithMountPolicy sets policy host path mounts of containers are
+// checked against. By default any host path may be mounted.
+func WithMountPolicy(policy *kube.MountPolicy) Option {
+	return func(r *SingularityRuntime) {
+		r.mountPolicy = policy
+	}
+}
+
+// WithSELinuxRelabel sets whether host paths of mounts that request
+// SELinux relabeling are relabeled. By default they are.
+func WithSELinuxRelabel(enabled bool) Option {
+	return func(r *SingularityRuntime) {
+		r.selinuxRelabel = enabled
+	}
+}
+
+// WithSysctlPolicy sets policy sysctls requested for pods are
+// checked against. By default only kube.SafeSysctls are allowed.
+func WithSysctlPolicy(policy *kube.SysctlPolicy) Option {
+	return func(r *SingularityRuntime) {
+		r.sysctlPolicy = policy
+	}
+}
+
+// WithNUMAMemoryBinding enables binding memory of containers with cpuset
+// cpus to NUMA nodes holding those CPUs, see kube.BindNUMAMemory. Cpuset
+// mems requested explicitly are never changed. By default it is disabled.
+func WithNUMAMemoryBinding(enabled bool) Option {
+	return func(r *SingularityRuntime) {
+		r.numaMemoryBinding = enabled
+	}
+}
+
+// WithOCIHooks sets OCI hooks injected into specs of matching containers.
+func WithOCIHooks(hooks *kube.OCIHooks) Option {
+	return func(r *SingularityRuntime) {
+		r.ociHooks = hooks
+	}
+}
+
+// WithAnnotationPassthrough sets patterns of pod and container annotations
+// that are copied into OCI spec in addition to Kubernetes and Singularity-CRI
+// ones. By default no other annotations are exposed to OCI hooks.
+func WithAnnotationPassthrough(allowed []string) Option {
+	return func(r *SingularityRuntime) {
+		r.annotations = allowed
+	}
+}
+
+// WithLogDriver sets log driver of containers that do not select
+// one with annotation. Overrides kube.DefaultLogDriver.
+func WithLogDriver(driver kube.LogDriver) Option {
+	return func(r *SingularityRuntime) {
+		r.logDriver = driver
+	}
+}
+
+// WithLogBuffer sets size of the buffer container output forwarded by
+// the daemon goes through and what happens when it is full. By default
+// kube.DefaultLogBufferSize is used and container is blocked on write.
+func WithLogBuffer(size int, overflow kube.LogOverflow) Option {
+	return func(r *SingularityRuntime) {
+		r.logBufferSize = size
+		r.logOverflow = overflow
+	}
+}
+
+// WithLogRotation makes the daemon rotate CRI log files of containers,
+// which is disabled by default leaving it up to kubelet.
+func WithLogRotation(rotation kube.LogRotation) Option {
+	return func(r *SingularityRuntime) {
+		r.logRotation = rotation
+	}
+}
+
+// WithCgroupDriver sets how cgroup parents of pods are interpreted.
+// By default kube.CgroupDriverCgroupfs is used.
+func WithCgroupDriver(driver kube.CgroupDriver) Option {
+	return func(r *SingularityRuntime) {
+		r.cgroupDriver = driver
+	}
+}
+
+// WithWritableLayerSize sets default size limit in bytes of container
+// writable layers. By default writable layers are not limited unless
+// kube.AnnotationWritableLayerSize is set.
+func WithWritableLayerSize(size int64) Option {
+	return func(r *SingularityRuntime) {
+		r.writableSize = size
+	}
+}
+
+// WithUserNamespace makes pods run in a new user namespace with the passed
+// ID mappings unless they are privileged or in host network, pods may choose
+// narrower mappings with kube.AnnotationUserNamespace. Mappings are expected
+// to be validated with kube.UserNamespace.Validate.
+func WithUserNamespace(userNs *kube.UserNamespace) Option {
+	return func(r *SingularityRuntime) {
+		r.userNs = userNs
+	}
+}
+
+// WithAttachReplay sets size of the most recent container output replayed to
+// clients attaching to container. Zero size keeps kube.DefaultAttachReplaySize,
+// negative one disables replay so that no output is buffered for it.
+func WithAttachReplay(size int) Option {
+	return func(r *SingularityRuntime) {
+		if size != 0 {
+			r.attachReplay = size
+		}
+	}
+}
+
+// WithExecSyncOutputLimit sets number of bytes of each output stream
+// captured by ExecSync. Zero size keeps kube.DefaultExecSyncOutputLimit,
+// negative one captures all output.
+func WithExecSyncOutputLimit(size int) Option {
+	return func(r *SingularityRuntime) {
+		if size != 0 {
+			r.execOutput = size
+		}
+	}
+}
+
+// WithContainerDefaults sets node-wide defaults applied to every
+// container unless its pod opts out. By default none are applied.
+func WithContainerDefaults(defaults *kube.ContainerDefaults) Option {
+	return func(r *SingularityRuntime) {
+		r.contDefaults = defaults
+	}
+}
+
+// WithHooks sets commands run on pod and container lifecycle events.
+// Hooks are expected to be validated with Hook.Validate.
+func WithHooks(hooks []Hook) Option {
+	return func(r *SingularityRuntime) {
+		r.hooks = newHookRunner(hooks)
+	}
+}
+
+// WithAdmission sets policy pods and containers are admitted with. Policy
+// is expected to be validated with AdmissionPolicy.Validate. By default
+// all requests are admitted.
+func WithAdmission(policy *AdmissionPolicy) Option {
+	return func(r *SingularityRuntime) {
+		r.admission = policy
+	}
+}
+
+// WithRedactedEnvs sets patterns of environment variable names
+// which values are hidden in verbose container and pod status.
+func WithRedactedEnvs(patterns []string) Option {
+	return func(r *SingularityRuntime) {
+		r.redactedEnvs = patterns
+	}
+}
+
+// WithPreflight sets results of host checks run on startup
+// so that they are reported in verbose runtime status.
+func WithPreflight(results []preflight.Result) Option {
+	return func(r *SingularityRuntime) {
+		r.preflight = results
+	}
+}
+
+// WithFakeEngine makes runtime run pods and containers as plain host processes
+// with sRuntime.FakeEngine instead of Singularity and enables fault injection
+// with AnnotationFakeFaults. It is meant for integration testing only.
+func WithFakeEngine() Option {
+	return func(r *SingularityRuntime) {
+		r.ociEngine = sRuntime.NewFakeEngine()
+		r.faults = newFaultInjector()
+	}
+}
+
+// errStreamingDisabled is returned by streaming calls when streaming
+// server is not configured or could not be created.
+var errStreamingDisabled = status.Error(codes.Unavailable, "streaming endpoints are disabled")
+
 // Shutdown shuts down any running background tasks created by SingularityRuntime.
 // This methods should be called when SingularityRuntime will no longer be used.
 func (s *SingularityRuntime) Shutdown() error {
-	if err := s.streaming.Stop(); err != nil {
-		return fmt.Errorf("could not stop streaming server: %v", err)
+	if s.streaming != nil {
+		if err := s.streaming.Stop(); err != nil {
+			return fmt.Errorf("could not stop streaming server: %v", err)
+		}
+	}
+
+	glog.V(4).Infof("Removing pooled pods")
+	s.stopWarmPools()
+
+	if s.liveRestore {
+		glog.V(4).Infof("Leaving pods running to be restored")
+		if s.lowerDirs != nil {
+			s.lowerDirs.Flush()
+		}
+		return nil
 	}
 
 	var cleanupErr error
@@ -172,37 +547,91 @@ func (s *SingularityRuntime) Shutdown() error {
 			glog.Errorf("Cleanup failed: %v", cleanupErr)
 		}
 	})
+	if s.lowerDirs != nil {
+		s.lowerDirs.Flush()
+	}
 	return cleanupErr
 }
 
+// netNsDir returns directory pod network namespaces are pinned under.
+func (s *SingularityRuntime) netNsDir() string {
+	return filepath.Join(s.baseRunDir, "netns")
+}
+
+// ProbeIndexes checks that none of pod, container and image indexes is
+// deadlocked, i.e. that each one's lock is acquired within timeout.
+func (s *SingularityRuntime) ProbeIndexes(timeout time.Duration) error {
+	if err := s.pods.Probe(timeout); err != nil {
+		return fmt.Errorf("pod index: %v", err)
+	}
+	if err := s.containers.Probe(timeout); err != nil {
+		return fmt.Errorf("container index: %v", err)
+	}
+	if err := s.imageIndex.Probe(timeout); err != nil {
+		return fmt.Errorf("image index: %v", err)
+	}
+	return nil
+}
+
+// ReleaseImage unmounts shared root filesystem of image with the passed ID
+// so that image file may be removed. It returns false if some container
+// still uses image root filesystem.
+func (s *SingularityRuntime) ReleaseImage(imageID string) bool {
+	if s.lowerDirs == nil {
+		return true
+	}
+	return s.lowerDirs.Drop(imageID)
+}
+
 // Version returns the runtime name, runtime version and runtime API version.
+// Runtime version includes both Singularity-CRI build information and
+// Singularity engine version, while runtime API version is the version of CRI
+// Singularity-CRI is compiled against.
 func (s *SingularityRuntime) Version(context.Context, *k8s.VersionRequest) (*k8s.VersionResponse, error) {
 	const kubeAPIVersion = "0.1.0"
 
-	syVersion, err := exec.Command(s.singularity, "version").Output()
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "could not get Singularity version: %v", err)
-	}
-
-	version := strings.TrimSpace(string(syVersion))
+	info := version.Get()
 	return &k8s.VersionResponse{
-		Version:           kubeAPIVersion, // todo or use req.Version?
-		RuntimeName:       singularity.RuntimeName,
-		RuntimeVersion:    version,
-		RuntimeApiVersion: version,
+		Version:     kubeAPIVersion,
+		RuntimeName: singularity.RuntimeName,
+		RuntimeVersion: fmt.Sprintf("%s (commit %s, built %s, engine %s)",
+			info.Version, info.GitCommit, info.BuildDate, s.EngineVersion()),
+		RuntimeApiVersion: info.CRIVersion,
 	}, nil
 }
 
 // UpdateContainerResources updates ContainerConfig of the container.
 func (s *SingularityRuntime) UpdateContainerResources(ctx context.Context, req *k8s.UpdateContainerResourcesRequest) (*k8s.UpdateContainerResourcesResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	cont, err := s.findContainer(req.ContainerId)
 	if err != nil {
 		return nil, err
 	}
+	if err := cont.UpdateState(); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not update container state: %v", err)
+	}
+	if cont.State() == k8s.ContainerState_CONTAINER_EXITED {
+		return nil, status.Error(codes.FailedPrecondition, "container is not running")
+	}
+	if err := kube.ValidateCPUSet(req.GetLinux()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if s.numaMemoryBinding {
+		kube.BindNUMAMemory(req.GetLinux())
+	}
+	if err := kube.ValidateCPUWeight(req.GetLinux()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	err = cont.UpdateResources(req.GetLinux())
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not update container resources: %v", err)
 	}
+	s.writeContainerRecord(cont)
+	if limits := cont.EffectiveLimits(); limits != nil {
+		glog.V(2).Infof("Updated container %s resources, effective limits: %s", cont.ID(), limits)
+	}
 	return &k8s.UpdateContainerResourcesResponse{}, nil
 }
 
@@ -212,6 +641,9 @@ func (s *SingularityRuntime) UpdateContainerResources(ctx context.Context, req *
 // to either create a new log file and return nil, or return an error.
 // Once it returns error, new container log file MUST NOT be created.
 func (s *SingularityRuntime) ReopenContainerLog(ctx context.Context, req *k8s.ReopenContainerLogRequest) (*k8s.ReopenContainerLogResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	cont, err := s.findContainer(req.ContainerId)
 	if err != nil {
 		return nil, err
@@ -232,13 +664,26 @@ func (s *SingularityRuntime) ReopenContainerLog(ctx context.Context, req *k8s.Re
 
 // ExecSync runs a command in a container synchronously.
 func (s *SingularityRuntime) ExecSync(ctx context.Context, req *k8s.ExecSyncRequest) (*k8s.ExecSyncResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	cont, err := s.findContainer(req.ContainerId)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.injectContainerFault(ctx, "ExecSync", cont); err != nil {
+		return nil, err
+	}
 
 	timeout := time.Second * time.Duration(req.Timeout)
-	resp, err := cont.ExecSync(timeout, req.Cmd)
+	limit := s.execOutput
+	if limit < 0 {
+		limit = 0
+	}
+	resp, err := cont.ExecSync(timeout, req.Cmd, limit)
+	if err == context.DeadlineExceeded {
+		return nil, status.Errorf(codes.DeadlineExceeded, "command timed out after %v", timeout)
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not execute in container: %v", err)
 	}
@@ -247,6 +692,9 @@ func (s *SingularityRuntime) ExecSync(ctx context.Context, req *k8s.ExecSyncRequ
 
 // Exec prepares a streaming endpoint to execute a command in the container.
 func (s *SingularityRuntime) Exec(ctx context.Context, req *k8s.ExecRequest) (*k8s.ExecResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	_, err := s.findContainer(req.ContainerId)
 	if err != nil {
 		return nil, err
@@ -257,11 +705,17 @@ func (s *SingularityRuntime) Exec(ctx context.Context, req *k8s.ExecRequest) (*k
 	if req.GetTty() && req.GetStderr() {
 		return nil, status.Error(codes.InvalidArgument, "If `tty` is true, `stderr` MUST be false")
 	}
+	if s.streaming == nil {
+		return nil, errStreamingDisabled
+	}
 	return s.streaming.GetExec(req)
 }
 
 // Attach prepares a streaming endpoint to attach to a running container.
 func (s *SingularityRuntime) Attach(ctx context.Context, req *k8s.AttachRequest) (*k8s.AttachResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	c, err := s.findContainer(req.ContainerId)
 	if err != nil {
 		return nil, err
@@ -275,26 +729,38 @@ func (s *SingularityRuntime) Attach(ctx context.Context, req *k8s.AttachRequest)
 	if req.GetTty() && req.GetStderr() {
 		return nil, status.Error(codes.InvalidArgument, "If `tty` is true, `stderr` MUST be false")
 	}
+	if s.streaming == nil {
+		return nil, errStreamingDisabled
+	}
 	return s.streaming.GetAttach(req)
 }
 
 // PortForward prepares a streaming endpoint to forward ports from a PodSandbox.
 func (s *SingularityRuntime) PortForward(ctx context.Context, req *k8s.PortForwardRequest) (*k8s.PortForwardResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	_, err := s.findPod(req.PodSandboxId)
 	if err != nil {
 		return nil, err
 	}
+	if s.streaming == nil {
+		return nil, errStreamingDisabled
+	}
 	return s.streaming.GetPortForward(req)
 }
 
 // ContainerStats returns stats of the container. If the container does not
 // exist, the call returns an error.
 func (s *SingularityRuntime) ContainerStats(ctx context.Context, req *k8s.ContainerStatsRequest) (*k8s.ContainerStatsResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	c, err := s.findContainer(req.ContainerId)
 	if err != nil {
 		return nil, err
 	}
-	stat, err := c.Stat()
+	stat, err := s.containerStat(c)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not container stat: %v", err)
 	}
@@ -306,23 +772,26 @@ func (s *SingularityRuntime) ContainerStats(ctx context.Context, req *k8s.Contai
 
 // ListContainerStats returns stats of all running containers.
 func (s *SingularityRuntime) ListContainerStats(ctx context.Context, req *k8s.ListContainerStatsRequest) (*k8s.ListContainerStatsResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	var containers []*k8s.ContainerStats
 
 	var filter *k8s.ContainerFilter
-	if req.Filter != nil {
+	if req.GetFilter() != nil {
 		filter = &k8s.ContainerFilter{
-			Id:            req.Filter.GetId(),
+			Id:            req.GetFilter().GetId(),
 			State:         &k8s.ContainerStateValue{State: k8s.ContainerState_CONTAINER_RUNNING},
-			PodSandboxId:  req.Filter.GetPodSandboxId(),
-			LabelSelector: req.Filter.GetLabelSelector(),
+			PodSandboxId:  req.GetFilter().GetPodSandboxId(),
+			LabelSelector: req.GetFilter().GetLabelSelector(),
 		}
 	}
 
 	appendContToResult := func(cont *kube.Container) {
 		if cont.MatchesFilter(filter) {
-			stat, err := cont.Stat()
+			stat, err := s.containerStat(cont)
 			if err != nil {
-				glog.Errorf("Skipping container %s due to %v", cont.ID(), err)
+				cont.Warnings().Logf(glog.ErrorDepth, "Could not get container %s stats: %v", cont.ID(), err)
 				return
 			}
 			containers = append(containers, containerStats(cont, stat))
@@ -336,18 +805,26 @@ func (s *SingularityRuntime) ListContainerStats(ctx context.Context, req *k8s.Li
 
 // UpdateRuntimeConfig updates the runtime configuration based on the given request.
 func (s *SingularityRuntime) UpdateRuntimeConfig(ctx context.Context, req *k8s.UpdateRuntimeConfigRequest) (*k8s.UpdateRuntimeConfigResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	config := req.GetRuntimeConfig()
 	if config == nil {
 		return &k8s.UpdateRuntimeConfigResponse{}, nil
 	}
-	if config.NetworkConfig.PodCidr != "" {
-		s.networkManager.SetPodCIDR(config.NetworkConfig.PodCidr)
+	if cidr := config.GetNetworkConfig().GetPodCidr(); cidr != "" {
+		if err := s.networkManager.SetPodCIDR(cidr); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "could not update pod CIDR: %v", err)
+		}
 	}
 	return &k8s.UpdateRuntimeConfigResponse{}, nil
 }
 
 // Status returns the status of the runtime.
 func (s *SingularityRuntime) Status(ctx context.Context, req *k8s.StatusRequest) (*k8s.StatusResponse, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
 	runtimeReady := &k8s.RuntimeCondition{
 		Type:   k8s.RuntimeReady,
 		Status: true,
@@ -357,20 +834,87 @@ func (s *SingularityRuntime) Status(ctx context.Context, req *k8s.StatusRequest)
 		Status: true,
 	}
 	conditions := []*k8s.RuntimeCondition{runtimeReady, networkReady}
-	if err := s.networkManager.Status(); err != nil {
-		networkReady.Status = false
-		networkReady.Reason = "NetworkNotReady"
-		networkReady.Message = fmt.Sprintf("sycri: network is not ready: %v", err)
+	engine := s.EngineStatus()
+	if engine.Fake {
+		conditions = append(conditions, &k8s.RuntimeCondition{
+			Type:    fakeEngineCondition,
+			Status:  true,
+			Reason:  "FakeEngine",
+			Message: "sycri: containers are run as host processes by fake engine, do not use in production",
+		})
+	}
+	if !engine.Ready {
+		runtimeReady.Status = false
+		runtimeReady.Reason = "EngineNotReady"
+		runtimeReady.Message = fmt.Sprintf("sycri: singularity engine is not ready: %s", engine.Message)
+	}
+	// fake engine runs pods in host network, there is no network manager
+	if s.networkManager != nil {
+		if err := s.networkManager.Status(); err != nil {
+			networkReady.Status = false
+			networkReady.Reason = "NetworkNotReady"
+			networkReady.Message = fmt.Sprintf("sycri: network is not ready: %v", err)
+		}
+	}
+	var verboseInfo map[string]string
+	if req.GetVerbose() {
+		data, err := json.Marshal(engine)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not marshal engine status: %v", err)
+		}
+		verboseInfo = map[string]string{"engine": string(data)}
+		if s.preflight != nil {
+			data, err := json.Marshal(s.preflight)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "could not marshal preflight results: %v", err)
+			}
+			verboseInfo["preflight"] = string(data)
+		}
+		data, err = json.Marshal(s.listStats())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not marshal list stats: %v", err)
+		}
+		verboseInfo["listContainers"] = string(data)
+		data, err = json.Marshal(s.runtimeHandlers())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not marshal runtime handlers: %v", err)
+		}
+		verboseInfo["runtimeHandlers"] = string(data)
+		if len(s.ipam.networks) != 0 {
+			data, err = json.Marshal(s.ipamStats())
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "could not marshal IPAM reconciliation stats: %v", err)
+			}
+			verboseInfo["ipamReconcile"] = string(data)
+		}
+		data, err = json.Marshal(s.orphanStats())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not marshal orphan cleanup stats: %v", err)
+		}
+		verboseInfo["orphanReaper"] = string(data)
+		data, err = json.Marshal(s.attachReplayStats())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not marshal attach replay stats: %v", err)
+		}
+		verboseInfo["attachReplay"] = string(data)
+		if s.warmPools != nil {
+			data, err = json.Marshal(s.warmPoolStats())
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "could not marshal warm pool stats: %v", err)
+			}
+			verboseInfo["warmPools"] = string(data)
+		}
 	}
 	return &k8s.StatusResponse{
 		Status: &k8s.RuntimeStatus{
 			Conditions: conditions,
 		},
+		Info: verboseInfo,
 	}, nil
 }
 
 func containerStats(c *kube.Container, stat *kube.ContainerStat) *k8s.ContainerStats {
-	now := time.Now().UnixNano()
+	now := stat.Timestamp.UnixNano()
 	return &k8s.ContainerStats{
 		Attributes: &k8s.ContainerAttributes{
 			Id:          c.ID(),
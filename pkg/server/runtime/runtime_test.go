@@ -16,6 +16,7 @@ package runtime
 
 import (
 	"context"
+	"fmt"
 	"os/exec"
 	"strings"
 	"testing"
@@ -34,10 +35,11 @@ func TestSingularityRuntimeService_Version(t *testing.T) {
 	actualVersion, err := s.Version(context.Background(), &v1alpha2.VersionRequest{})
 	require.NoError(t, err, "could not query runtime version")
 	require.Equal(t, &v1alpha2.VersionResponse{
-		Version:           "0.1.0",
-		RuntimeName:       "singularity",
-		RuntimeVersion:    strings.TrimSpace(string(expectedVersion)),
-		RuntimeApiVersion: strings.TrimSpace(string(expectedVersion)),
+		Version:     "0.1.0",
+		RuntimeName: "singularity",
+		RuntimeVersion: fmt.Sprintf("unknown (commit unknown, built unknown, engine %s)",
+			strings.TrimSpace(string(expectedVersion))),
+		RuntimeApiVersion: "v1alpha2",
 	}, actualVersion, "runtime version mismatch")
 
 }
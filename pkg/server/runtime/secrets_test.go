@@ -0,0 +1,77 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/critest"
+	"github.com/sylabs/singularity-cri/pkg/server/runtime"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestContainerSecrets(t *testing.T) {
+	const marker = "marker-4f1c2e9a"
+
+	srv := critest.StartFakeServer(t, critest.WithImages(critest.FakeImage{Ref: "busybox"}))
+	defer srv.Stop()
+	ctx := context.Background()
+
+	podConfig := critest.PodConfig("secrets")
+	pod, err := srv.Runtime.RunPodSandbox(ctx, &k8s.RunPodSandboxRequest{Config: podConfig})
+	require.NoError(t, err)
+
+	config := critest.ContainerConfig("app", "busybox", "/bin/true")
+	config.Envs = append(config.Envs, &k8s.KeyValue{Key: "API_TOKEN", Value: marker})
+	req := &k8s.CreateContainerRequest{
+		PodSandboxId:  pod.PodSandboxId,
+		Config:        config,
+		SandboxConfig: podConfig,
+	}
+	cont, err := srv.Runtime.CreateContainer(ctx, req)
+	require.NoError(t, err)
+
+	// bundle is readable by its owner only
+	contDir := filepath.Join(srv.RunDir(), "containers", cont.ContainerId)
+	fi, err := os.Stat(contDir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+	configPath := filepath.Join(contDir, "bundle", "config.json")
+	fi, err = os.Stat(configPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	ociConfig, err := ioutil.ReadFile(configPath)
+	require.NoError(t, err)
+	require.Contains(t, string(ociConfig), marker, "container environment is not passed to the engine")
+
+	// request as logged by the interceptor
+	logged, err := json.Marshal(runtime.RedactRequest(req, runtime.DefaultRedactedEnvs))
+	require.NoError(t, err)
+	require.NotContains(t, string(logged), marker)
+	require.Equal(t, marker, req.Config.Envs[1].Value, "logged request must not modify original one")
+
+	resp, err := srv.Runtime.ContainerStatus(ctx, &k8s.ContainerStatusRequest{ContainerId: cont.ContainerId, Verbose: true})
+	require.NoError(t, err)
+	status, err := json.Marshal(resp)
+	require.NoError(t, err)
+	require.NotContains(t, string(status), marker)
+	require.Contains(t, string(status), "API_TOKEN")
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/fs"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+)
+
+// containerStateDir is a directory under base run directory where
+// per-container state files for node tooling are stored.
+const containerStateDir = "state/containers"
+
+// containerStateFile is a world readable container state for node tooling
+// to correlate host PIDs to pods. It must never hold container environment
+// or command since those may contain secrets.
+type containerStateFile struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	State        string `json:"state"`
+	Pid          int    `json:"pid"`
+	CgroupsPath  string `json:"cgroupsPath,omitempty"`
+	PodID        string `json:"podID"`
+	PodUID       string `json:"podUID,omitempty"`
+	PodName      string `json:"podName,omitempty"`
+	PodNamespace string `json:"podNamespace,omitempty"`
+}
+
+func (s *SingularityRuntime) containerStatePath(id string) string {
+	return filepath.Join(s.baseRunDir, containerStateDir, id+".json")
+}
+
+// syncContainerState updates container state file according to the
+// passed lifecycle event. Failures are logged only since state files
+// are informational.
+func (s *SingularityRuntime) syncContainerState(cont *kube.Container, pod *kube.Pod, eventType ContainerEventType) {
+	var err error
+	if eventType == ContainerDeletedEvent {
+		err = os.Remove(s.containerStatePath(cont.ID()))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		err = s.writeContainerState(cont, pod)
+	}
+	if err != nil {
+		glog.Errorf("Could not update container %s state file: %v", cont.ID(), err)
+	}
+}
+
+// writeContainerState replaces container state file with the current state.
+func (s *SingularityRuntime) writeContainerState(cont *kube.Container, pod *kube.Pod) error {
+	state := containerStateFile{
+		ID:    cont.ID(),
+		Name:  cont.GetMetadata().GetName(),
+		State: cont.State().String(),
+		Pid:   cont.Pid(),
+		PodID: cont.PodID(),
+	}
+	if pod != nil {
+		state.PodUID = pod.GetMetadata().GetUid()
+		state.PodName = pod.GetMetadata().GetName()
+		state.PodNamespace = pod.GetMetadata().GetNamespace()
+	}
+	if spec, err := cont.Spec(); err == nil && spec.Linux != nil {
+		state.CgroupsPath = spec.Linux.CgroupsPath
+	}
+	return writeStateFile(s.containerStatePath(cont.ID()), state)
+}
+
+// writeStateFile atomically replaces world readable state file at path.
+func writeStateFile(path string, state containerStateFile) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("could not marshal state: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create state directory: %v", err)
+	}
+	if err := fs.WriteFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write state file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, containerStateDir, "test.json")
+	for _, pid := range []int{42, 43} {
+		state := containerStateFile{
+			ID:     "test",
+			Name:   "nginx",
+			State:  "CONTAINER_RUNNING",
+			Pid:    pid,
+			PodID:  "pod",
+			PodUID: "uid",
+		}
+		require.NoError(t, writeStateFile(path, state))
+
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+
+		data, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		var actual containerStateFile
+		require.NoError(t, json.Unmarshal(data, &actual))
+		require.Equal(t, state, actual)
+	}
+
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, files, 1, "temporary state files are left")
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// DefaultStatsInterval is the default interval usage
+	// of running containers is sampled in background at.
+	DefaultStatsInterval = 10 * time.Second
+
+	// statsWorkers limits number of containers sampled concurrently.
+	statsWorkers = 4
+)
+
+// statsCollector caches usage samples of running containers so that
+// stats requests do not walk cgroups and writable layers every time.
+type statsCollector struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	samples map[string]*kube.ContainerStat
+}
+
+// WithStatsInterval sets interval usage of running containers is sampled
+// at in background. Zero interval results in DefaultStatsInterval and
+// negative one disables sampling, so stats are collected on request.
+func WithStatsInterval(interval time.Duration) Option {
+	return func(r *SingularityRuntime) {
+		if interval == 0 {
+			interval = DefaultStatsInterval
+		}
+		r.stats.interval = interval
+	}
+}
+
+// StartStatsCollector samples usage of running containers periodically until
+// ctx is done. It is a no-op if sampling is disabled with WithStatsInterval.
+func (s *SingularityRuntime) StartStatsCollector(ctx context.Context) {
+	if s.stats.interval <= 0 {
+		return
+	}
+	glog.Infof("Container stats are sampled every %v", s.stats.interval)
+	go func() {
+		ticker := time.NewTicker(s.stats.interval)
+		defer ticker.Stop()
+		for {
+			var running []*kube.Container
+			s.containers.Iterate(func(c *kube.Container) {
+				if c.State() == k8s.ContainerState_CONTAINER_RUNNING {
+					running = append(running, c)
+				}
+			})
+			s.stats.sample(running)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// containerStat returns usage of the container, cached sample is used
+// unless it is missing or stale.
+func (s *SingularityRuntime) containerStat(c *kube.Container) (*kube.ContainerStat, error) {
+	return s.stats.get(c.ID(), c.Stat)
+}
+
+// get returns cached usage sample of container with the passed id. Sample
+// is taken synchronously with stat if there is none or it is older than two
+// sampling intervals, i.e. background sampling is lagging behind.
+func (c *statsCollector) get(id string, stat func() (*kube.ContainerStat, error)) (*kube.ContainerStat, error) {
+	if c.interval <= 0 {
+		return stat()
+	}
+	c.mu.Lock()
+	sample, ok := c.samples[id]
+	c.mu.Unlock()
+	if ok && time.Since(sample.Timestamp) < 2*c.interval {
+		return sample, nil
+	}
+	sample, err := stat()
+	if err != nil {
+		return nil, err
+	}
+	c.store(id, sample)
+	return sample, nil
+}
+
+func (c *statsCollector) store(id string, sample *kube.ContainerStat) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.samples == nil {
+		c.samples = make(map[string]*kube.ContainerStat)
+	}
+	c.samples[id] = sample
+}
+
+// sample takes usage samples of the passed containers with a bounded number
+// of workers. Samples of containers that are not listed are dropped.
+func (c *statsCollector) sample(conts []*kube.Container) {
+	queue := make(chan *kube.Container)
+	var wg sync.WaitGroup
+	for i := 0; i < statsWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cont := range queue {
+				sample, err := cont.Stat()
+				if err != nil {
+					glog.V(4).Infof("Could not sample container %s stats: %v", cont.ID(), err)
+					continue
+				}
+				c.store(cont.ID(), sample)
+			}
+		}()
+	}
+	ids := make(map[string]bool, len(conts))
+	for _, cont := range conts {
+		ids[cont.ID()] = true
+		queue <- cont
+	}
+	close(queue)
+	wg.Wait()
+	c.retain(ids)
+}
+
+// retain drops samples of containers that are not in ids.
+func (c *statsCollector) retain(ids map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range c.samples {
+		if !ids[id] {
+			delete(c.samples, id)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+)
+
+func TestStatsCollector_Get(t *testing.T) {
+	var calls int
+	stat := func(age time.Duration) func() (*kube.ContainerStat, error) {
+		return func() (*kube.ContainerStat, error) {
+			calls++
+			return &kube.ContainerStat{CPU: uint64(calls), Timestamp: time.Now().Add(-age)}, nil
+		}
+	}
+	failing := func() (*kube.ContainerStat, error) {
+		calls++
+		return nil, fmt.Errorf("no cgroup usage found")
+	}
+
+	c := &statsCollector{interval: time.Minute}
+	sample, err := c.get("fresh", stat(0))
+	require.NoError(t, err)
+	require.EqualValues(t, 1, sample.CPU)
+	sample, err = c.get("fresh", stat(0))
+	require.NoError(t, err)
+	require.EqualValues(t, 1, sample.CPU, "fresh sample must be cached")
+
+	_, err = c.get("stale", stat(3*time.Minute))
+	require.NoError(t, err)
+	sample, err = c.get("stale", stat(0))
+	require.NoError(t, err)
+	require.EqualValues(t, 3, sample.CPU, "stale sample must be retaken")
+
+	_, err = c.get("failing", failing)
+	require.Error(t, err)
+	require.NotContains(t, c.samples, "failing")
+
+	c.retain(map[string]bool{"stale": true})
+	require.Len(t, c.samples, 1)
+	require.Contains(t, c.samples, "stale")
+
+	c = &statsCollector{interval: -1}
+	calls = 0
+	_, err = c.get("disabled", stat(0))
+	require.NoError(t, err)
+	_, err = c.get("disabled", stat(0))
+	require.NoError(t, err)
+	require.Equal(t, 2, calls, "disabled sampling must not be cached")
+	require.Empty(t, c.samples)
+}
@@ -15,32 +15,92 @@
 package runtime
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
+	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/kr/pty"
 	"github.com/kubernetes-sigs/cri-o/utils"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity-cri/pkg/metrics"
+	"github.com/sylabs/singularity-cri/pkg/namespace"
+	sRuntime "github.com/sylabs/singularity-cri/pkg/singularity/runtime"
 	"github.com/sylabs/singularity/pkg/ociruntime"
 	"github.com/sylabs/singularity/pkg/util/unix"
 	"k8s.io/client-go/tools/remotecommand"
 	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+	"k8s.io/kubernetes/pkg/kubelet/server/streaming"
+	utilexec "k8s.io/utils/exec"
+)
+
+// terminalDrainTimeout limits time exec terminal output is read
+// for after command exits.
+const terminalDrainTimeout = 2 * time.Second
+
+var (
+	streamingSessions = metrics.NewGauge("sycri_streaming_sessions",
+		"Number of active exec, attach and port-forward sessions.", "type")
+	streamingSessionsTotal = metrics.NewCounter("sycri_streaming_sessions_total",
+		"Number of started exec, attach and port-forward sessions.", "type")
 )
 
 type streamingRuntime struct {
 	runtime *SingularityRuntime
 }
 
+// trackSession accounts a streaming session of the given type
+// and returns a function to call once the session is over.
+func trackSession(typ string) func() {
+	streamingSessionsTotal.Inc(typ)
+	streamingSessions.Inc(typ)
+	return func() { streamingSessions.Dec(typ) }
+}
+
+// streamingTokenLen is a length of base64 encoded random tokens streaming
+// URLs are identified with, i.e. 192 bits, so that URLs cannot be guessed.
+// Length must be a multiple of 4 for streaming server to check generated
+// tokens for uniqueness properly.
+const streamingTokenLen = 32
+
+// newStreamingServer returns streaming server that serves exec, attach and
+// port-forward sessions of runtime on addr. Each session is identified by a
+// random single-use token that expires after tokenTTL. Token is bound to the
+// request it is issued for, i.e. to the container or pod and verb, so that
+// URL of other verb or replayed or expired URL is rejected with 404.
+func newStreamingServer(runtime streaming.Runtime, addr string, tokenTTL time.Duration, tlsConfig *tls.Config) (streaming.Server, error) {
+	if tokenTTL == 0 {
+		tokenTTL = DefaultStreamingTokenTTL
+	}
+	// token settings are global to streaming package,
+	// there is a single streaming server per process though
+	streaming.TokenLen = streamingTokenLen
+	streaming.CacheTTL = tokenTTL
+
+	config := streaming.DefaultConfig
+	config.Addr = addr
+	config.TLSConfig = tlsConfig
+	return streaming.NewServer(config, runtime)
+}
+
 // Exec executes a command inside a container with attaching passed io streams to it.
 func (s *streamingRuntime) Exec(containerID string, cmd []string,
 	stdin io.Reader, stdout, stderr io.WriteCloser,
 	tty bool, resize <-chan remotecommand.TerminalSize) error {
 
+	defer trackSession("exec")()
 	glog.V(4).Infof("Exec %v in %s...", cmd, containerID)
 	c, err := s.runtime.containers.Find(containerID)
 	if err != nil {
@@ -56,58 +116,156 @@ func (s *streamingRuntime) Exec(containerID string, cmd []string,
 
 	var execErr error
 	if tty {
-		// stderr is nil here
-		execCmd := c.PrepareExec(cmd)
-
-		master, err := pty.Start(execCmd)
+		// stderr is nil here as it is merged with stdout by terminal
+		execCmd, err := c.PrepareExec(cmd)
 		if err != nil {
-			return fmt.Errorf("could not start exec in pty: %v", err)
+			return fmt.Errorf("could not prepare exec: %v", err)
 		}
+		execErr = execInTerminal(execCmd, stdin, stdout, resize)
+	} else {
+		execErr = c.Exec(cmd, stdin, stdout, stderr)
+	}
 
-		done := make(chan struct{})
-		go func() {
-			glog.V(5).Infof("Resize start for %s", containerID)
-			for {
-				select {
-				case <-done:
-					glog.V(5).Infof("Resize end for %s", containerID)
-					return
-				case size := <-resize:
-					glog.V(5).Infof("Got resize event for %s: %+v", containerID, size)
-					s := &pty.Winsize{
-						Cols: size.Width,
-						Rows: size.Height,
-					}
-					if err := pty.Setsize(master, s); err != nil {
-						glog.Errorf("Could not resize terminal: %v", err)
-					}
-				}
-			}
-		}()
+	glog.V(4).Infof("Exec for %s returned %v...", containerID, execErr)
+	// streaming server reports exit code to client for utilexec.ExitError only
+	if exitErr, ok := execErr.(*sRuntime.ExitError); ok {
+		return utilexec.CodeExitError{Err: exitErr, Code: int(exitErr.Status.Code)}
+	}
+	return execErr
+}
 
-		defer master.Close()
-		defer close(done)
+// execInTerminal runs prepared exec command with a pseudo terminal as its stdio.
+// Terminal size follows resize events, the size queued before command start
+// is applied right away so that command never sees the default one. Output is
+// drained after command exits, so that nothing written right before exit is lost.
+func execInTerminal(cmd *exec.Cmd, stdin io.Reader, stdout io.Writer, resize <-chan remotecommand.TerminalSize) error {
+	var ws *pty.Winsize
+	select {
+	case size, ok := <-resize:
+		if ok && size.Width != 0 && size.Height != 0 {
+			ws = &pty.Winsize{Cols: size.Width, Rows: size.Height}
+		}
+	default:
+	}
+	master, err := startInTerminal(cmd, ws)
+	if err != nil {
+		return fmt.Errorf("could not start exec in pty: %v", err)
+	}
+	defer master.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go resizeTerminal(master, resize, done)
+
+	if stdin != nil {
+		go io.Copy(master, stdin)
+	}
+	if stdout == nil {
+		// terminal must be read anyway, otherwise command blocks on write
+		stdout = ioutil.Discard
+	}
+	drained := make(chan struct{})
+	go func() {
+		io.Copy(stdout, master)
+		close(drained)
+	}()
 
-		if stdin != nil {
-			go io.Copy(master, stdin)
+	err = sRuntime.CheckExit(cmd.Wait())
+	// processes started in background may keep terminal open
+	select {
+	case <-drained:
+	case <-time.After(terminalDrainTimeout):
+		glog.V(4).Infof("Terminal of exec %v is still open after exit", cmd.Args)
+	}
+	return err
+}
+
+// startInTerminal starts command with a new pseudo terminal as its stdio and
+// controlling terminal and returns the terminal master. Terminal is resized
+// before command starts unless size is nil.
+func startInTerminal(cmd *exec.Cmd, size *pty.Winsize) (*os.File, error) {
+	master, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+	if size != nil {
+		if err := pty.Setsize(master, size); err != nil {
+			master.Close()
+			return nil, err
 		}
-		if stdout != nil {
-			go io.Copy(stdout, master)
+	}
+
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	// Ctty is a descriptor in the child, where terminal is stdin
+	cmd.SysProcAttr.Ctty = 0
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, err
+	}
+	return master, nil
+}
+
+// resizeTerminal applies terminal size changes until done is closed
+// or there are no more resize events. Empty sizes are ignored.
+func resizeTerminal(master *os.File, resize <-chan remotecommand.TerminalSize, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case size, ok := <-resize:
+			if !ok {
+				return
+			}
+			glog.V(5).Infof("Got resize event for %s: %+v", master.Name(), size)
+			if size.Width == 0 || size.Height == 0 {
+				continue
+			}
+			ws := &pty.Winsize{
+				Cols: size.Width,
+				Rows: size.Height,
+			}
+			if err := pty.Setsize(master, ws); err != nil {
+				glog.Errorf("Could not resize terminal: %v", err)
+			}
 		}
-		execErr = execCmd.Wait()
-	} else {
-		execErr = c.Exec(cmd, stdin, stdout, stderr)
 	}
+}
 
-	glog.V(4).Infof("Exec for %s returned %v...", containerID, execErr)
-	return execErr
+// attachReplayStats reports memory held for attach replay.
+type attachReplayStats struct {
+	Enabled bool `json:"enabled"`
+	// Size is replay buffer size of a single container.
+	Size int `json:"sizeBytes,omitempty"`
+	// Buffered is memory held by replay buffers of all containers.
+	Buffered int64 `json:"bufferedBytes"`
+}
+
+func (s *SingularityRuntime) attachReplayStats() attachReplayStats {
+	stats := attachReplayStats{
+		Enabled:  s.attachReplay > 0,
+		Buffered: kube.ReplayBufferBytes(),
+	}
+	if stats.Enabled {
+		stats.Size = s.attachReplay
+	}
+	return stats
 }
 
-// Attach attaches passed streams to the container.
+// Attach attaches passed streams to the container. Recent container
+// output kept for replay, if any, is passed before live output.
 func (s *streamingRuntime) Attach(containerID string,
 	stdin io.Reader, stdout, stderr io.WriteCloser,
 	tty bool, resize <-chan remotecommand.TerminalSize) error {
 
+	defer trackSession("attach")()
 	glog.V(4).Infof("Attaching to %s...", containerID)
 	c, err := s.runtime.containers.Find(containerID)
 	if err != nil {
@@ -121,15 +279,36 @@ func (s *streamingRuntime) Attach(containerID string,
 		return fmt.Errorf("container is not running")
 	}
 
-	socket := c.AttachSocket()
-	if socket == "" {
-		return fmt.Errorf("container didn't provide attach socket: %v", err)
-	}
-	attachSock, err := unix.Dial(socket)
-	if err != nil {
-		return fmt.Errorf("could not conntect to attach socket: %v", err)
+	// output forwarded by the daemon is served from the forwarder,
+	// so that streams are kept apart and slow client is not waited for;
+	// TTY output is fanned out from a connection shared by all clients
+	var attachSock net.Conn
+	detach, outputDone, forwarded := c.AttachOutput(stdout, stderr)
+	if forwarded {
+		defer detach()
+	} else if tty {
+		// output is merged with TTY, so replay goes to stdout only
+		if stdout != nil {
+			if err := c.ReplayOutput(stdout); err != nil {
+				return fmt.Errorf("could not replay container output: %v", err)
+			}
+		}
+		detach, outputDone, err = c.AttachTTY(stdout)
+		if err != nil {
+			return err
+		}
+		defer detach()
+	} else {
+		socket := c.AttachSocket()
+		if socket == "" {
+			return fmt.Errorf("container didn't provide attach socket: %v", err)
+		}
+		attachSock, err = unix.Dial(socket)
+		if err != nil {
+			return fmt.Errorf("could not conntect to attach socket: %v", err)
+		}
+		defer attachSock.Close()
 	}
-	defer attachSock.Close()
 
 	if tty {
 		// start TTY controls handling only if TTY has been allocated
@@ -172,7 +351,12 @@ func (s *streamingRuntime) Attach(containerID string,
 	}
 
 	errors := make(chan error, 2)
-	if stdout != nil || stderr != nil {
+	if outputDone != nil && (stdout != nil || stderr != nil) {
+		go func() {
+			<-outputDone
+			errors <- nil
+		}()
+	} else if stdout != nil || stderr != nil {
 		go func() {
 			// there is no way to distinguish stdout and stderr
 			// as both of them are written to attach socket by the runtime
@@ -190,28 +374,27 @@ func (s *streamingRuntime) Attach(containerID string,
 		}()
 	}
 
-	if stdin != nil && c.GetStdin() && !c.StdinClosed() {
-		contStdin := io.Writer(attachSock)
-		if !tty {
-			contStdin = c.Stdin()
-		}
-
-		if contStdin != nil {
-			go func() {
-				// copy until ctrl-d hits
-				_, err := utils.CopyDetachable(contStdin, stdin, []byte{4})
-				// do not treat detach as an error
-				if _, ok := err.(utils.DetachError); ok {
-					errors <- nil
-				}
-				errors <- err
-			}()
-		}
+	// input of all attached clients is serialized
+	var contStdin io.Writer
+	if stdin != nil {
+		contStdin = c.AttachStdin()
+	}
+	if contStdin != nil {
+		go func() {
+			// copy until ctrl-d hits
+			_, err := utils.CopyDetachable(contStdin, stdin, []byte{4})
+			// do not treat detach as an error
+			if _, ok := err.(utils.DetachError); ok {
+				errors <- nil
+			}
+			errors <- err
+		}()
 	}
 
 	err = <-errors
 	glog.V(4).Infof("Attach for %s returned %v...", containerID, err)
-	if c.GetStdinOnce() && !c.StdinClosed() {
+	// with StdinOnce stdin is closed once the client that passed it detaches
+	if contStdin != nil && c.GetStdinOnce() && !c.StdinClosed() {
 		glog.V(2).Infof("Closing stdin for container %s", c.ID())
 		err := c.CloseStdin()
 		if err != nil {
@@ -224,6 +407,7 @@ func (s *streamingRuntime) Attach(containerID string,
 // PortForward enters pod's NET namespace to forward passed
 // stream to the given port and back.
 func (s *streamingRuntime) PortForward(podSandboxID string, port int32, stream io.ReadWriteCloser) error {
+	defer trackSession("portforward")()
 	p, err := s.runtime.pods.Find(podSandboxID)
 	if err != nil {
 		return fmt.Errorf("could not fetch container: %v", err)
@@ -236,45 +420,77 @@ func (s *streamingRuntime) PortForward(podSandboxID string, port int32, stream i
 		return fmt.Errorf("pod is not ready")
 	}
 
-	socatPath, err := exec.LookPath("socat")
-	if err != nil {
-		return fmt.Errorf("unable to do port forwarding: socat not found")
+	nsPath := p.NetNsPath()
+	if nsPath == "" {
+		nsPath = fmt.Sprintf("/proc/%d/ns/net", p.Pid())
 	}
-	nsenterPath, err := exec.LookPath("nsenter")
-	if err != nil {
-		return fmt.Errorf("unable to do port forwarding: nsenter not found")
-	}
-
-	args := []string{"-t", fmt.Sprintf("%d", p.Pid()), "-n", socatPath, "-", fmt.Sprintf("TCP4:localhost:%d", port)}
-	commandString := fmt.Sprintf("%s %s", nsenterPath, strings.Join(args, " "))
-	glog.V(5).Infof("Executing port forwarding command: %s", commandString)
-
-	var stderr bytes.Buffer
-	cmd := exec.Command(nsenterPath, args...)
-	cmd.Stdout = stream
-	cmd.Stderr = &stderr
-
-	// If we use Stdin, cmd.Run() won't return until the goroutine that's copying
-	// from stream finishes. Unfortunately, if you have a client like telnet connected
-	// via port forwarding, as long as the user's telnet client is connected to the user's
-	// local listener that port forwarding sets up, the telnet session never exits. This
-	// means that even if socat has finished running, cmd.Run() won't ever return
-	// (because the client still has the connection and stream open).
-	//
-	// The work around is to use StdinPipe(), as Wait() (called by Run()) closes the pipe
-	// when the cmd (socat) exits.
-	inPipe, err := cmd.StdinPipe()
+	return forwardPort(nsPath, p.Pid(), port, stream)
+}
+
+// forwardPort connects to the port on loopback interface of network namespace
+// at nsPath and copies data between the connection and the stream. Connection
+// is dialed from CRI itself, so no helper binary is run inside the namespace.
+// Forwarding ends once the port closes the connection or, after the stream
+// is closed by the client, once the port has written all its output.
+func forwardPort(nsPath string, pid int, port int32, stream io.ReadWriter) error {
+	network, address := loopbackAddress(pid, port)
+	glog.V(5).Infof("Forwarding stream to %s %s in %s", network, address, nsPath)
+	conn, err := namespace.Dial(nsPath, network, address)
 	if err != nil {
-		return fmt.Errorf("unable to do port forwarding: error creating stdin pipe: %v", err)
+		return fmt.Errorf("unable to do port forwarding: %v", err)
 	}
+	defer conn.Close()
+
 	go func() {
-		io.Copy(inPipe, stream)
-		inPipe.Close()
+		_, err := io.Copy(conn, stream)
+		if err != nil {
+			glog.V(4).Infof("Port forwarding to %s stopped: %v", address, err)
+		}
+		// let the port know client is done, but keep reading its output
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
 	}()
+	if _, err := io.Copy(stream, conn); err != nil {
+		return fmt.Errorf("could not forward port %d: %v", port, err)
+	}
+	return nil
+}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("%v: %s", err, stderr.Bytes())
+// loopbackAddress returns network and address of the port on pod's loopback
+// interface. IPv6 loopback is used when anything listens on it inside pod's
+// network namespace, otherwise IPv4 loopback is used.
+func loopbackAddress(pid int, port int32) (string, string) {
+	tcp6, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/net/tcp6", pid))
+	if err == nil && hasLoopbackListener(tcp6, port) {
+		return "tcp6", fmt.Sprintf("[::1]:%d", port)
 	}
+	return "tcp4", fmt.Sprintf("127.0.0.1:%d", port)
+}
 
-	return nil
+// hasLoopbackListener parses /proc/net/tcp6 content and checks whether
+// there is a socket listening on the port of IPv6 loopback or any address.
+func hasLoopbackListener(tcp6 []byte, port int32) bool {
+	const (
+		anyAddr      = "00000000000000000000000000000000"
+		loopbackAddr = "00000000000000000000000001000000"
+		stateListen  = "0A"
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(tcp6))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] != stateListen {
+			continue
+		}
+		local := strings.SplitN(fields[1], ":", 2)
+		if len(local) != 2 || (local[0] != anyAddr && local[0] != loopbackAddr) {
+			continue
+		}
+		p, err := strconv.ParseInt(local[1], 16, 32)
+		if err == nil && int32(p) == port {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,214 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kr/pty"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/remotecommand"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestHasLoopbackListener(t *testing.T) {
+	const header = "  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+	tt := []struct {
+		name   string
+		tcp6   string
+		port   int32
+		expect bool
+	}{
+		{
+			name:   "no sockets",
+			tcp6:   header,
+			port:   8080,
+			expect: false,
+		},
+		{
+			name:   "any address listener",
+			tcp6:   header + "   0: 00000000000000000000000000000000:1F90 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0\n",
+			port:   8080,
+			expect: true,
+		},
+		{
+			name:   "loopback listener",
+			tcp6:   header + "   0: 00000000000000000000000001000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0\n",
+			port:   80,
+			expect: true,
+		},
+		{
+			name:   "other port",
+			tcp6:   header + "   0: 00000000000000000000000000000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0\n",
+			port:   8080,
+			expect: false,
+		},
+		{
+			name:   "pod address listener",
+			tcp6:   header + "   0: 00DF10FD000000000000000003000000:1F90 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0\n",
+			port:   8080,
+			expect: false,
+		},
+		{
+			name:   "established connection",
+			tcp6:   header + "   0: 00000000000000000000000001000000:1F90 00000000000000000000000001000000:A2B4 01 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0\n",
+			port:   8080,
+			expect: false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expect, hasLoopbackListener([]byte(tc.tcp6), tc.port))
+		})
+	}
+}
+
+// forwardStream is a port forwarding stream that sends request
+// and collects reply.
+type forwardStream struct {
+	request io.Reader
+	reply   bytes.Buffer
+}
+
+func (s *forwardStream) Read(p []byte) (int, error) {
+	return s.request.Read(p)
+}
+
+func (s *forwardStream) Write(p []byte) (int, error) {
+	return s.reply.Write(p)
+}
+
+func TestForwardPort(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	port := int32(ln.Addr().(*net.TCPAddr).Port)
+	nsPath := fmt.Sprintf("/proc/%d/ns/net", os.Getpid())
+
+	// echo server replies with everything written before client closed the stream
+	stream := &forwardStream{request: strings.NewReader("ping")}
+	require.NoError(t, forwardPort(nsPath, os.Getpid(), port, stream))
+	require.Equal(t, "ping", stream.reply.String())
+
+	ln.Close()
+	err = forwardPort(nsPath, os.Getpid(), port, &forwardStream{request: strings.NewReader("ping")})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unable to do port forwarding")
+}
+
+func TestExecInTerminal(t *testing.T) {
+	resize := make(chan remotecommand.TerminalSize, 1)
+	resize <- remotecommand.TerminalSize{Width: 120, Height: 40}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command("sh", "-c", "stty size; echo done; exit 2")
+	err := execInTerminal(cmd, nil, &stdout, resize)
+	require.Error(t, err)
+	require.Equal(t, "40 120\r\ndone\r\n", stdout.String())
+}
+
+func TestResizeTerminal(t *testing.T) {
+	master, tty, err := pty.Open()
+	require.NoError(t, err)
+	defer master.Close()
+	defer tty.Close()
+
+	resize := make(chan remotecommand.TerminalSize)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		resizeTerminal(master, resize, done)
+		close(stopped)
+	}()
+
+	resize <- remotecommand.TerminalSize{Width: 100, Height: 30}
+	// empty size is ignored
+	resize <- remotecommand.TerminalSize{}
+	close(resize)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("resize must stop once resize channel is closed")
+	}
+
+	rows, cols, err := pty.Getsize(tty)
+	require.NoError(t, err)
+	require.Equal(t, 30, rows)
+	require.Equal(t, 100, cols)
+}
+
+func TestStreamingServerTokens(t *testing.T) {
+	srv, err := newStreamingServer(&streamingRuntime{}, "127.0.0.1:0", time.Minute, nil)
+	require.NoError(t, err)
+
+	serve := func(rawURL string) int {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.Path, nil))
+		return rec.Code
+	}
+	exec := func() string {
+		resp, err := srv.GetExec(&k8s.ExecRequest{ContainerId: "foo", Cmd: []string{"ls"}, Stdout: true})
+		require.NoError(t, err)
+		return resp.GetUrl()
+	}
+
+	execURL := exec()
+	u, err := url.Parse(execURL)
+	require.NoError(t, err)
+	require.Equal(t, "http", u.Scheme)
+	require.Len(t, path.Base(u.Path), streamingTokenLen)
+	require.NotEqual(t, http.StatusNotFound, serve(execURL), "fresh token")
+	require.Equal(t, http.StatusNotFound, serve(execURL), "replayed token")
+
+	execURL = exec()
+	require.Equal(t, http.StatusNotFound, serve(strings.Replace(execURL, "/exec/", "/attach/", 1)), "other verb")
+	require.Equal(t, http.StatusNotFound, serve(execURL), "token used with other verb")
+
+	srv, err = newStreamingServer(&streamingRuntime{}, "127.0.0.1:0", time.Millisecond, &tls.Config{})
+	require.NoError(t, err)
+	execURL = exec()
+	require.True(t, strings.HasPrefix(execURL, "https://"))
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, http.StatusNotFound, serve(execURL), "expired token")
+}
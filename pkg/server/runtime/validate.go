@@ -0,0 +1,144 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/validation"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// fieldError returns InvalidArgument error pointing to the invalid request field.
+func fieldError(path, format string, a ...interface{}) error {
+	return status.Errorf(codes.InvalidArgument, "%s: %s", path, fmt.Sprintf(format, a...))
+}
+
+// validateRequest checks that all fields handlers rely on are set so that
+// malformed requests are rejected before they are handled. Requests with
+// no required fields are always valid.
+func validateRequest(req interface{}) error {
+	switch r := req.(type) {
+	case *k8s.RunPodSandboxRequest:
+		if r.GetConfig() == nil {
+			return fieldError("config", "required")
+		}
+		return validatePodMetadata(r.GetConfig().GetMetadata())
+	case *k8s.StopPodSandboxRequest:
+		return requireID("pod_sandbox_id", r.GetPodSandboxId())
+	case *k8s.RemovePodSandboxRequest:
+		return requireID("pod_sandbox_id", r.GetPodSandboxId())
+	case *k8s.PodSandboxStatusRequest:
+		return requireID("pod_sandbox_id", r.GetPodSandboxId())
+	case *k8s.PortForwardRequest:
+		return requireID("pod_sandbox_id", r.GetPodSandboxId())
+	case *k8s.CreateContainerRequest:
+		if err := requireID("pod_sandbox_id", r.GetPodSandboxId()); err != nil {
+			return err
+		}
+		if r.GetConfig() == nil {
+			return fieldError("config", "required")
+		}
+		if err := validateContainerMetadata(r.GetConfig().GetMetadata()); err != nil {
+			return err
+		}
+		if r.GetConfig().GetImage() == nil {
+			return fieldError("config.image", "required")
+		}
+		return requireID("config.image.image", r.GetConfig().GetImage().GetImage())
+	case *k8s.StartContainerRequest:
+		return requireID("container_id", r.GetContainerId())
+	case *k8s.StopContainerRequest:
+		return requireID("container_id", r.GetContainerId())
+	case *k8s.RemoveContainerRequest:
+		return requireID("container_id", r.GetContainerId())
+	case *k8s.ContainerStatusRequest:
+		return requireID("container_id", r.GetContainerId())
+	case *k8s.UpdateContainerResourcesRequest:
+		return requireID("container_id", r.GetContainerId())
+	case *k8s.ReopenContainerLogRequest:
+		return requireID("container_id", r.GetContainerId())
+	case *k8s.ContainerStatsRequest:
+		return requireID("container_id", r.GetContainerId())
+	case *k8s.ExecSyncRequest:
+		if err := requireID("container_id", r.GetContainerId()); err != nil {
+			return err
+		}
+		return requireCmd(r.GetCmd())
+	case *k8s.ExecRequest:
+		if err := requireID("container_id", r.GetContainerId()); err != nil {
+			return err
+		}
+		return requireCmd(r.GetCmd())
+	case *k8s.AttachRequest:
+		return requireID("container_id", r.GetContainerId())
+	}
+	return nil
+}
+
+func requireID(path, id string) error {
+	if id == "" {
+		return fieldError(path, "required")
+	}
+	return nil
+}
+
+func requireCmd(cmd []string) error {
+	if len(cmd) == 0 || cmd[0] == "" {
+		return fieldError("cmd", "required")
+	}
+	return nil
+}
+
+// validatePodMetadata checks that pod metadata is set and has
+// kubernetes compliant name and namespace. Attempt is unsigned
+// in CRI so it never needs to be checked.
+func validatePodMetadata(md *k8s.PodSandboxMetadata) error {
+	if md == nil {
+		return fieldError("config.metadata", "required")
+	}
+	if md.GetName() == "" {
+		return fieldError("config.metadata.name", "required")
+	}
+	if errs := validation.IsDNS1123Subdomain(md.GetName()); len(errs) != 0 {
+		return fieldError("config.metadata.name", strings.Join(errs, ", "))
+	}
+	if md.GetNamespace() == "" {
+		return fieldError("config.metadata.namespace", "required")
+	}
+	if errs := validation.IsDNS1123Label(md.GetNamespace()); len(errs) != 0 {
+		return fieldError("config.metadata.namespace", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// validateContainerMetadata checks that container metadata is set and has
+// kubernetes compliant name. Attempt is unsigned in CRI so it never needs
+// to be checked.
+func validateContainerMetadata(md *k8s.ContainerMetadata) error {
+	if md == nil {
+		return fieldError("config.metadata", "required")
+	}
+	if md.GetName() == "" {
+		return fieldError("config.metadata.name", "required")
+	}
+	if errs := validation.IsDNS1123Label(md.GetName()); len(errs) != 0 {
+		return fieldError("config.metadata.name", strings.Join(errs, ", "))
+	}
+	return nil
+}
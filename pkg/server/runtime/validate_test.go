@@ -0,0 +1,219 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/singularity-cri/pkg/index"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestValidateRequest(t *testing.T) {
+	tt := []struct {
+		name        string
+		req         interface{}
+		expectError string
+	}{
+		{
+			name:        "nil sandbox config",
+			req:         &k8s.RunPodSandboxRequest{},
+			expectError: "config: required",
+		},
+		{
+			name:        "nil pod metadata",
+			req:         &k8s.RunPodSandboxRequest{Config: &k8s.PodSandboxConfig{}},
+			expectError: "config.metadata: required",
+		},
+		{
+			name: "empty pod name",
+			req: &k8s.RunPodSandboxRequest{Config: &k8s.PodSandboxConfig{
+				Metadata: &k8s.PodSandboxMetadata{Namespace: "default"},
+			}},
+			expectError: "config.metadata.name: required",
+		},
+		{
+			name: "valid pod",
+			req: &k8s.RunPodSandboxRequest{Config: &k8s.PodSandboxConfig{
+				Metadata: &k8s.PodSandboxMetadata{Name: "nginx", Namespace: "default"},
+			}},
+		},
+		{
+			name: "missing image spec",
+			req: &k8s.CreateContainerRequest{
+				PodSandboxId: "pod",
+				Config: &k8s.ContainerConfig{
+					Metadata: &k8s.ContainerMetadata{Name: "nginx"},
+				},
+			},
+			expectError: "config.image: required",
+		},
+		{
+			name:        "nil request",
+			req:         (*k8s.StopContainerRequest)(nil),
+			expectError: "container_id: required",
+		},
+		{
+			name:        "empty exec command",
+			req:         &k8s.ExecSyncRequest{ContainerId: "cont", Cmd: []string{""}},
+			expectError: "cmd: required",
+		},
+		{
+			name: "no required fields",
+			req:  &k8s.ListContainersRequest{},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateRequest(tc.req)
+			if tc.expectError == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+			require.Equal(t, tc.expectError, status.Convert(err).Message())
+		})
+	}
+}
+
+// fillMessage sets all fields of the protobuf message, allocating nested ones.
+func fillMessage(v reflect.Value, depth int) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if depth > 5 {
+			return
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		fillMessage(v.Elem(), depth+1)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				fillMessage(v.Field(i), depth)
+			}
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fillMessage(v.Index(0), depth)
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key := reflect.New(v.Type().Key()).Elem()
+		elem := reflect.New(v.Type().Elem()).Elem()
+		fillMessage(key, depth)
+		fillMessage(elem, depth)
+		v.SetMapIndex(key, elem)
+	case reflect.String:
+		v.SetString("fuzz")
+	}
+}
+
+// nilFields randomly resets pointer, slice and map fields of the message.
+func nilFields(r *rand.Rand, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if r.Intn(3) == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		nilFields(r, v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				nilFields(r, v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		if r.Intn(3) == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			nilFields(r, v.Index(i))
+		}
+	case reflect.Map:
+		if r.Intn(3) == 0 {
+			v.Set(reflect.Zero(v.Type()))
+		}
+	case reflect.String:
+		if r.Intn(3) == 0 {
+			v.SetString("")
+		}
+	}
+}
+
+func TestHandlers_NilFields(t *testing.T) {
+	s := &SingularityRuntime{
+		imageIndex: index.NewImageIndex(),
+		pods:       index.NewPodIndex(),
+		containers: index.NewContainerIndex(),
+		events:     newEventBus(DefaultEventBufferSize),
+	}
+	ctx := context.Background()
+	// requests are expected to fail on lookup at most since indices are empty,
+	// pods are not run since runtime handler is always set to unknown value
+	handlers := []interface{}{
+		s.RunPodSandbox,
+		s.StopPodSandbox,
+		s.RemovePodSandbox,
+		s.PodSandboxStatus,
+		s.ListPodSandbox,
+		s.CreateContainer,
+		s.StartContainer,
+		s.StopContainer,
+		s.RemoveContainer,
+		s.ListContainers,
+		s.ContainerStatus,
+		s.UpdateContainerResources,
+		s.ReopenContainerLog,
+		s.ExecSync,
+		s.Exec,
+		s.Attach,
+		s.PortForward,
+		s.ContainerStats,
+		s.ListContainerStats,
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for _, handler := range handlers {
+		h := reflect.ValueOf(handler)
+		reqType := h.Type().In(1)
+		t.Run(reqType.Elem().Name(), func(t *testing.T) {
+			for i := 0; i < 200; i++ {
+				req := reflect.New(reqType).Elem()
+				fillMessage(req, 0)
+				nilFields(r, req)
+				if run, ok := req.Interface().(*k8s.RunPodSandboxRequest); ok && run != nil {
+					run.RuntimeHandler = "fuzz"
+				}
+				require.NotPanics(t, func() {
+					out := h.Call([]reflect.Value{reflect.ValueOf(ctx), req})
+					if err, ok := out[1].Interface().(error); ok && err != nil {
+						require.NotEqual(t, codes.Unknown, status.Code(err), fmt.Sprintf("%v", err))
+					}
+				}, "request: %v", req.Interface())
+			}
+		})
+	}
+}
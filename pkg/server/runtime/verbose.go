@@ -0,0 +1,358 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity-cri/pkg/kube"
+	"github.com/sylabs/singularity-cri/pkg/network"
+	"github.com/sylabs/singularity-cri/pkg/warnings"
+	k8s "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const redactedValue = "<redacted>"
+
+// DefaultRedactedEnvs is the default list of environment variable name
+// patterns whose values are hidden in verbose status responses and logs.
+var DefaultRedactedEnvs = []string{"*PASSWORD*", "*PASSWD*", "*SECRET*", "*TOKEN*", "*KEY*", "*CREDENTIAL*", "*AUTH*"}
+
+type imageVerboseInfo struct {
+	ID      string   `json:"id"`
+	Ref     string   `json:"ref"`
+	Digests []string `json:"digests,omitempty"`
+}
+
+type containerVerboseInfo struct {
+	ID          string           `json:"id"`
+	SandboxID   string           `json:"sandboxID"`
+	Pid         int              `json:"pid"`
+	Image       imageVerboseInfo `json:"image"`
+	CgroupsPath string           `json:"cgroupsPath,omitempty"`
+	NetNsPath   string           `json:"netNsPath,omitempty"`
+	// HostUser is uid:gid container process runs as on the host
+	// when pod is run in a new user namespace.
+	HostUser    string             `json:"hostUser,omitempty"`
+	LogDriver   string             `json:"logDriver,omitempty"`
+	Logs        *logsVerboseInfo   `json:"logs,omitempty"`
+	InjectedEnv []string           `json:"injectedEnv,omitempty"`
+	CreatedAt   string             `json:"createdAt,omitempty"`
+	StartedAt   string             `json:"startedAt,omitempty"`
+	FinishedAt  string             `json:"finishedAt,omitempty"`
+	Phases      map[string]string  `json:"phases,omitempty"`
+	ExitStats   *kube.ExitStats    `json:"exitStats,omitempty"`
+	Compacted   bool               `json:"compacted,omitempty"`
+	Overlay     []string           `json:"overlayOptions,omitempty"`
+	Volumes     []kube.VolumeMount `json:"volumes,omitempty"`
+	CPUSet      *cpusetVerboseInfo `json:"cpuset,omitempty"`
+	// Resources are ones requested on creation updated by
+	// UpdateContainerResources calls since then.
+	Resources   *k8s.LinuxContainerResources `json:"resources,omitempty"`
+	Limits      *kube.CgroupLimits           `json:"effectiveLimits,omitempty"`
+	Processes   int                          `json:"processes,omitempty"`
+	Threads     *int                         `json:"threads,omitempty"`
+	OpenFds     string                       `json:"openFds,omitempty"`
+	Warnings    []warnings.Entry             `json:"warnings,omitempty"`
+	RuntimeSpec *specs.Spec                  `json:"runtimeSpec,omitempty"`
+
+	// Capabilities and NoNewPrivs are applied to container process.
+	Capabilities *specs.LinuxCapabilities `json:"capabilities,omitempty"`
+	NoNewPrivs   bool                     `json:"noNewPrivs"`
+}
+
+type logsVerboseInfo struct {
+	DroppedBytes uint64 `json:"droppedBytes"`
+	Blocked      string `json:"blocked"`
+	ReplayBytes  int64  `json:"replayBytes,omitempty"`
+}
+
+type cpusetVerboseInfo struct {
+	Cpus       string         `json:"cpus"`
+	Mems       string         `json:"mems"`
+	Pinned     bool           `json:"pinned"`
+	LocalMems  bool           `json:"localMems,omitempty"`
+	NUMANodes  []int          `json:"numaNodes,omitempty"`
+	NUMAMemory map[int]uint64 `json:"numaMemory,omitempty"`
+}
+
+type podVerboseInfo struct {
+	ID             string              `json:"id"`
+	Pid            int                 `json:"pid"`
+	RuntimeHandler string              `json:"runtimeHandler,omitempty"`
+	CgroupsPath    string              `json:"cgroupsPath,omitempty"`
+	NetNsPath      string              `json:"netNsPath,omitempty"`
+	UserNamespace  *kube.UserNamespace `json:"userNamespace,omitempty"`
+	CreatedAt      string              `json:"createdAt,omitempty"`
+	IPs            []string            `json:"ips,omitempty"`
+	Containers     []string            `json:"containers,omitempty"`
+	Phases         map[string]string   `json:"phases,omitempty"`
+	Failure        *kube.RunFailure    `json:"failure,omitempty"`
+	Helpers        []kube.Helper       `json:"helpers,omitempty"`
+	// Adopted is set for pods taken from warm pool, runtime spec
+	// of such pods holds placeholder metadata of the pool.
+	Adopted     bool                 `json:"adopted,omitempty"`
+	Networks    []network.Attachment `json:"networks,omitempty"`
+	Volumes     []string             `json:"volumes,omitempty"`
+	Warnings    []warnings.Entry     `json:"warnings,omitempty"`
+	RuntimeSpec *specs.Spec          `json:"runtimeSpec,omitempty"`
+}
+
+// containerInfo returns verbose container info in a form crictl inspect
+// understands. It must not be called with any index lock held since
+// runtime spec is read from disk and marshaled here.
+func (s *SingularityRuntime) containerInfo(cont *kube.Container) (map[string]string, error) {
+	info := s.containerVerboseInfo(cont)
+	return verboseInfo(info.Pid, info)
+}
+
+// containerVerboseInfo collects verbose container info, runtime spec
+// is read from disk, so no index lock should be held.
+func (s *SingularityRuntime) containerVerboseInfo(cont *kube.Container) containerVerboseInfo {
+	info := containerVerboseInfo{
+		ID:          cont.ID(),
+		SandboxID:   cont.PodID(),
+		Pid:         cont.Pid(),
+		LogDriver:   string(cont.LogDriver()),
+		InjectedEnv: cont.InjectedEnv(),
+		CreatedAt:   formatTimestamp(cont.CreatedAt()),
+		StartedAt:   formatTimestamp(cont.StartedAt()),
+		FinishedAt:  formatTimestamp(cont.FinishedAt()),
+		Phases:      formatPhases(cont.PhaseDurations()),
+		Compacted:   cont.Compacted(),
+		Overlay:     cont.OverlayOptions(),
+		Volumes:     cont.Volumes(),
+		Warnings:    cont.Warnings().Entries(),
+	}
+	if img := cont.Image(); img != nil {
+		info.Image.ID = img.ID
+		if img.Ref != nil {
+			info.Image.Ref = img.Ref.String()
+			info.Image.Digests = img.Ref.Digests()
+		}
+	}
+	if stats, ok := cont.LogStats(); ok {
+		info.Logs = &logsVerboseInfo{
+			DroppedBytes: stats.DroppedBytes,
+			Blocked:      stats.Blocked.String(),
+			ReplayBytes:  stats.ReplayBytes,
+		}
+	}
+	info.CPUSet = cpusetInfo(cont)
+	info.Resources = cont.Resources()
+	if cont.State() == k8s.ContainerState_CONTAINER_EXITED {
+		info.ExitStats = cont.ExitStats()
+	}
+	if cont.State() == k8s.ContainerState_CONTAINER_RUNNING {
+		counts, err := cont.ProcessCounts()
+		if err != nil {
+			glog.V(4).Infof("Could not count container %s processes: %v", cont.ID(), err)
+		} else {
+			info.Processes = counts.Processes
+			info.Threads = counts.Threads
+			info.OpenFds = counts.FdsString()
+		}
+		info.Limits = cont.EffectiveLimits()
+	}
+	spec, err := cont.Spec()
+	if err != nil {
+		glog.Warningf("Could not read container %s spec: %v", cont.ID(), err)
+	} else {
+		redactSpec(spec, s.redactedEnvs)
+		info.RuntimeSpec = spec
+		if spec.Linux != nil {
+			info.CgroupsPath = spec.Linux.CgroupsPath
+			for _, ns := range spec.Linux.Namespaces {
+				if ns.Type == specs.NetworkNamespace {
+					info.NetNsPath = ns.Path
+				}
+			}
+		}
+		if spec.Process != nil {
+			info.Capabilities = spec.Process.Capabilities
+			info.NoNewPrivs = spec.Process.NoNewPrivileges
+		}
+		if userNs := cont.UserNamespace(); userNs != nil && spec.Process != nil {
+			uid, _ := userNs.HostUID(spec.Process.User.UID)
+			gid, _ := userNs.HostGID(spec.Process.User.GID)
+			info.HostUser = fmt.Sprintf("%d:%d", uid, gid)
+		}
+	}
+	return info
+}
+
+// cpusetInfo returns effective cpuset of the container along with NUMA nodes
+// it spans. Container is pinned when it has its own cpuset cpus, e.g. ones
+// assigned by kubelet static CPU manager policy, and its memory is local when
+// mems are bound to NUMA nodes of those CPUs. Per node memory usage is
+// reported when memory cgroup provides it.
+func cpusetInfo(cont *kube.Container) *cpusetVerboseInfo {
+	topology, err := kube.ReadTopology()
+	if err != nil {
+		glog.Warningf("Could not read node topology: %v", err)
+		return nil
+	}
+	set := cont.CPUSet()
+	effective := topology.Effective(set)
+	info := &cpusetVerboseInfo{
+		Cpus:      effective.Cpus,
+		Mems:      effective.Mems,
+		Pinned:    set.Cpus != "",
+		LocalMems: set.Cpus != "" && set.Mems == topology.LocalMems(set.Cpus),
+		NUMANodes: topology.NUMANodes(set),
+	}
+	if cont.State() == k8s.ContainerState_CONTAINER_RUNNING {
+		usage, err := cont.NUMAMemory()
+		if err != nil {
+			glog.V(4).Infof("Could not read container %s NUMA memory usage: %v", cont.ID(), err)
+		}
+		info.NUMAMemory = usage
+	}
+	return info
+}
+
+// podInfo returns verbose pod info in a form crictl inspect understands.
+// It must not be called with any index lock held.
+func (s *SingularityRuntime) podInfo(pod *kube.Pod) (map[string]string, error) {
+	info := s.podVerboseInfo(pod)
+	return verboseInfo(info.Pid, info)
+}
+
+// podVerboseInfo collects verbose pod info, runtime spec is
+// read from disk, so no index lock should be held.
+func (s *SingularityRuntime) podVerboseInfo(pod *kube.Pod) podVerboseInfo {
+	info := podVerboseInfo{
+		ID:             pod.ID(),
+		Pid:            pod.Pid(),
+		RuntimeHandler: pod.RuntimeHandler(),
+		CreatedAt:      formatTimestamp(pod.CreatedAt()),
+		NetNsPath:      pod.NetNsPath(),
+		UserNamespace:  pod.UserNamespace(),
+		IPs:            pod.IPs(),
+		Containers:     pod.Containers(),
+		Phases:         formatPhases(pod.PhaseDurations()),
+		Failure:        pod.Failure(),
+		Helpers:        pod.Helpers(),
+		Adopted:        pod.Adopted(),
+		Networks:       pod.Networks(),
+		Volumes:        pod.Volumes(),
+		Warnings:       pod.Warnings().Entries(),
+	}
+	spec, err := pod.Spec()
+	if err != nil {
+		glog.Warningf("Could not read pod %s spec: %v", pod.ID(), err)
+	} else {
+		redactSpec(spec, s.redactedEnvs)
+		info.RuntimeSpec = spec
+		if spec.Linux != nil {
+			info.CgroupsPath = spec.Linux.CgroupsPath
+		}
+	}
+	return info
+}
+
+// formatPhases converts phase durations into human readable strings.
+func formatPhases(phases map[string]time.Duration) map[string]string {
+	if len(phases) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(phases))
+	for phase, d := range phases {
+		res[phase] = d.String()
+	}
+	return res
+}
+
+func verboseInfo(pid int, info interface{}) (map[string]string, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal verbose info: %v", err)
+	}
+	return map[string]string{
+		"pid":  fmt.Sprintf("%d", pid),
+		"info": string(data),
+	}, nil
+}
+
+// redactSpec hides values of environment variables and annotations
+// which names match any of the passed patterns. Patterns are matched
+// case insensitively using shell file name pattern syntax.
+func redactSpec(spec *specs.Spec, patterns []string) {
+	if spec.Process != nil {
+		for i, env := range spec.Process.Env {
+			kv := strings.SplitN(env, "=", 2)
+			if len(kv) == 2 && isRedacted(kv[0], patterns) {
+				spec.Process.Env[i] = kv[0] + "=" + redactedValue
+			}
+		}
+	}
+	redactAnnotations(spec.Annotations, patterns)
+}
+
+// RedactRequest returns a copy of CRI request with values of container
+// environment variables and annotations which names match any of the passed
+// patterns hidden, so that request may be logged. Other requests are
+// returned as is.
+func RedactRequest(req interface{}, patterns []string) interface{} {
+	switch r := req.(type) {
+	case *k8s.CreateContainerRequest:
+		r = proto.Clone(r).(*k8s.CreateContainerRequest)
+		for _, env := range r.GetConfig().GetEnvs() {
+			if isRedacted(env.GetKey(), patterns) {
+				env.Value = redactedValue
+			}
+		}
+		redactAnnotations(r.GetConfig().GetAnnotations(), patterns)
+		redactAnnotations(r.GetSandboxConfig().GetAnnotations(), patterns)
+		return r
+	case *k8s.RunPodSandboxRequest:
+		r = proto.Clone(r).(*k8s.RunPodSandboxRequest)
+		redactAnnotations(r.GetConfig().GetAnnotations(), patterns)
+		return r
+	}
+	return req
+}
+
+func redactAnnotations(annotations map[string]string, patterns []string) {
+	for k := range annotations {
+		if isRedacted(k, patterns) {
+			annotations[k] = redactedValue
+		}
+	}
+}
+
+func isRedacted(name string, patterns []string) bool {
+	name = strings.ToUpper(name)
+	for _, pattern := range patterns {
+		ok, err := filepath.Match(strings.ToUpper(pattern), name)
+		if err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func formatTimestamp(ns int64) string {
+	if ns == 0 {
+		return ""
+	}
+	return time.Unix(0, ns).UTC().Format(time.RFC3339Nano)
+}
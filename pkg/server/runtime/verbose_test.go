@@ -0,0 +1,100 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactSpec(t *testing.T) {
+	tt := []struct {
+		name     string
+		patterns []string
+		spec     *specs.Spec
+		expect   *specs.Spec
+	}{
+		{
+			name:     "no process",
+			patterns: DefaultRedactedEnvs,
+			spec:     &specs.Spec{},
+			expect:   &specs.Spec{},
+		},
+		{
+			name:     "default patterns",
+			patterns: DefaultRedactedEnvs,
+			spec: &specs.Spec{
+				Process: &specs.Process{
+					Env: []string{"PATH=/bin", "DB_PASSWORD=qwerty", "github_token=abc", "EMPTY", "api_key=a=b"},
+				},
+				Annotations: map[string]string{
+					"io.kubernetes.pod.name": "test",
+					"registry.auth":          "secret",
+				},
+			},
+			expect: &specs.Spec{
+				Process: &specs.Process{
+					Env: []string{"PATH=/bin", "DB_PASSWORD=<redacted>", "github_token=<redacted>", "EMPTY", "api_key=<redacted>"},
+				},
+				Annotations: map[string]string{
+					"io.kubernetes.pod.name": "test",
+					"registry.auth":          "<redacted>",
+				},
+			},
+		},
+		{
+			name:     "no patterns",
+			patterns: nil,
+			spec: &specs.Spec{
+				Process: &specs.Process{
+					Env: []string{"DB_PASSWORD=qwerty"},
+				},
+			},
+			expect: &specs.Spec{
+				Process: &specs.Process{
+					Env: []string{"DB_PASSWORD=qwerty"},
+				},
+			},
+		},
+		{
+			name:     "exact pattern",
+			patterns: []string{"foo"},
+			spec: &specs.Spec{
+				Process: &specs.Process{
+					Env: []string{"FOO=1", "FOOBAR=2"},
+				},
+			},
+			expect: &specs.Spec{
+				Process: &specs.Process{
+					Env: []string{"FOO=<redacted>", "FOOBAR=2"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			redactSpec(tc.spec, tc.patterns)
+			require.Equal(t, tc.expect, tc.spec)
+		})
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	require.Equal(t, "", formatTimestamp(0))
+	require.Equal(t, "2019-01-01T00:00:00.5Z", formatTimestamp(1546300800500000000))
+}
@@ -17,7 +17,9 @@ package runtime
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
@@ -25,6 +27,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/sylabs/singularity-cri/pkg/singularity"
+	"github.com/sylabs/singularity-cri/pkg/trace"
 )
 
 const (
@@ -45,6 +48,7 @@ type (
 	// BuildConfig is Singularity's build configuration.
 	BuildConfig struct {
 		SingularityConfdir string
+		LibexecDir         string
 	}
 )
 
@@ -56,15 +60,39 @@ var (
 // NewCLIClient returns new CLIClient ready to use.
 func NewCLIClient() *CLIClient {
 	once.Do(func() {
-		logFlag := "-q"
-		if os.Getenv(LogLevelEnv) == LogLevelDebug {
-			logFlag = "-d"
-		}
-		client = &CLIClient{ociBaseCmd: []string{singularity.RuntimeName, logFlag, "oci"}}
+		client = NewCLIClientFor(singularity.RuntimeName)
 	})
 	return client
 }
 
+// NewCLIClientFor returns new CLIClient that runs Singularity installed
+// at path, e.g. a non-setuid installation, with global flags passed
+// to every oci command.
+func NewCLIClientFor(path string, flags ...string) *CLIClient {
+	logFlag := "-q"
+	if os.Getenv(LogLevelEnv) == LogLevelDebug {
+		logFlag = "-d"
+	}
+	// commands are appended to base one, so it must have no spare
+	// capacity for concurrent calls not to share the same array
+	cmd := make([]string, 0, len(flags)+3)
+	cmd = append(cmd, path, logFlag)
+	cmd = append(cmd, flags...)
+	return &CLIClient{ociBaseCmd: append(cmd, "oci")}
+}
+
+// NewCLIClientWithCommand returns new CLIClient that runs oci commands
+// prefixed with cmd, as returned by Command of another client.
+func NewCLIClientWithCommand(cmd []string) *CLIClient {
+	return &CLIClient{ociBaseCmd: cmd[:len(cmd):len(cmd)]}
+}
+
+// Command returns command every oci command of the client is prefixed
+// with, so that the same engine can be called from another process.
+func (c *CLIClient) Command() []string {
+	return append([]string(nil), c.ociBaseCmd...)
+}
+
 // BuildConfig returns configuration which was used to build
 // current Singularity installation.
 func (c *CLIClient) BuildConfig() (*BuildConfig, error) {
@@ -81,19 +109,42 @@ func (c *CLIClient) BuildConfig() (*BuildConfig, error) {
 }
 
 func run(cmd []string) error {
-	runCmd := exec.Command(cmd[0], cmd[1:]...)
-	runCmd.Stderr = os.Stderr
+	return runContext(context.Background(), cmd)
+}
+
+func runContext(ctx context.Context, cmd []string) error {
+	var stderr stderrTail
+	runCmd := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	runCmd.Env = engineEnv(ctx)
+	runCmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 
 	glog.V(5).Infof("Executing %v", cmd)
 	err := runCmd.Run()
+	if err != nil && ctx.Err() != nil {
+		return &ExecError{Err: fmt.Errorf("could not execute: %v", ctx.Err()), Stderr: stderr.String()}
+	}
 	if err != nil {
-		return fmt.Errorf("could not execute: %v", err)
+		return &ExecError{Err: fmt.Errorf("could not execute: %v", err), Stderr: stderr.String()}
 	}
 	return nil
 }
 
+// engineEnv returns environment of engine commands run on behalf of
+// traced request, so that engine may continue the trace. Nil is returned
+// when request is not traced, i.e. daemon environment is inherited.
+func engineEnv(ctx context.Context) []string {
+	env := trace.Environ(ctx)
+	if env == nil {
+		return nil
+	}
+	return append(os.Environ(), env...)
+}
+
 func parseBuildConfig(data []byte) BuildConfig {
-	const singularityConfdir = "SINGULARITY_CONFDIR"
+	const (
+		singularityConfdir = "SINGULARITY_CONFDIR"
+		libexecDir         = "LIBEXECDIR"
+	)
 
 	var cfg BuildConfig
 	scanner := bufio.NewScanner(bytes.NewReader(data))
@@ -106,9 +157,11 @@ func parseBuildConfig(data []byte) BuildConfig {
 		if len(parts) != 2 {
 			continue
 		}
-		if parts[0] == singularityConfdir {
+		switch parts[0] {
+		case singularityConfdir:
 			cfg.SingularityConfdir = parts[1]
-			break
+		case libexecDir:
+			cfg.LibexecDir = parts[1]
 		}
 	}
 	return cfg
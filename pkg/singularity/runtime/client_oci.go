@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -30,6 +31,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	syio "github.com/sylabs/singularity-cri/pkg/io"
+	"github.com/sylabs/singularity-cri/pkg/trace"
 	"github.com/sylabs/singularity/pkg/ociruntime"
 )
 
@@ -46,6 +48,9 @@ type (
 		Stderr []byte
 		// Exit code the command finished with.
 		ExitCode int32
+		// Truncated is true when any output exceeded the capture limit
+		// and the rest of it was discarded.
+		Truncated bool
 	}
 )
 
@@ -100,15 +105,27 @@ func (c *CLIClient) Delete(id string) error {
 // (need to allocate it to separate stderr) that can be used to propagate any input into container,
 // if stdin was requested. Master end should be closed as soon as container is
 // not running any more. For pod master end can be closed immediately.
-func (c *CLIClient) Create(id, bundle string, stdin, tty bool, flags ...string) (io.WriteCloser, error) {
+// Create command is killed once the passed context is done.
+func (c *CLIClient) Create(ctx context.Context, id, bundle string, stdin, tty bool, flags ...string) (_ io.WriteCloser, err error) {
+	ctx, span := trace.Start(ctx, "engine.create")
+	span.SetAttribute("container.id", id)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
 	var stdinWrite io.WriteCloser
 
 	cmd := append(c.ociBaseCmd, "create")
 	cmd = append(cmd, flags...)
 	cmd = append(cmd, "-b", bundle, id)
 
-	createCmd := exec.Command(cmd[0], cmd[1:]...)
-	createCmd.Stderr = os.Stderr
+	// stderr may miss the last output of failed command run with pty as
+	// copying from master end is stopped as soon as command returns
+	var stderr stderrTail
+	createCmd := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	createCmd.Env = engineEnv(ctx)
+	createCmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 	if !tty {
 		master, slave, err := pty.Open()
 		if err != nil {
@@ -117,11 +134,11 @@ func (c *CLIClient) Create(id, bundle string, stdin, tty bool, flags ...string)
 		createCmd.Stderr = slave
 		defer slave.Close()
 
-		ctx, cancel := context.WithCancel(context.Background())
+		copyCtx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 		go func() {
 			glog.V(5).Info("Starting stream copying from master to stderr")
-			_, err := io.Copy(os.Stderr, syio.NewContextReader(ctx, master))
+			_, err := io.Copy(io.MultiWriter(os.Stderr, &stderr), syio.NewContextReader(copyCtx, master))
 			glog.V(5).Infof("Stream copying returned: %v", err)
 			// we need to drain master to prevent buffer overflow,
 			// see https://github.com/sylabs/singularity-cri/pull/348
@@ -135,86 +152,197 @@ func (c *CLIClient) Create(id, bundle string, stdin, tty bool, flags ...string)
 	}
 
 	glog.V(5).Infof("Executing %v", cmd)
-	err := createCmd.Run()
+	err = createCmd.Run()
 	if err != nil {
-		return nil, fmt.Errorf("could not execute create container command: %v", err)
+		if stdinWrite != nil {
+			stdinWrite.Close()
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, &ExecError{
+			Err:    fmt.Errorf("could not execute create container command: %v", err),
+			Stderr: stderr.String(),
+		}
 	}
 
 	return stdinWrite, nil
 }
 
-// Start asks runtime to start container with passed id.
-func (c *CLIClient) Start(id string) error {
+// Start asks runtime to start container with passed id. Start
+// command is killed once the passed context is done.
+func (c *CLIClient) Start(ctx context.Context, id string) error {
+	ctx, span := trace.Start(ctx, "engine.start")
+	defer span.End()
+	span.SetAttribute("container.id", id)
+
 	cmd := append(c.ociBaseCmd, "start", id)
-	return run(cmd)
+	err := runContext(ctx, cmd)
+	span.SetError(err)
+	return err
 }
 
 // ExecSync executes a command inside a container synchronously until
 // context is done and returns the result.
-func (c *CLIClient) ExecSync(ctx context.Context, id string, args, envs []string) (*ExecResponse, error) {
-	cmd := append(c.ociBaseCmd, "exec", id)
-	cmd = append(cmd, args...)
+func (c *CLIClient) ExecSync(ctx context.Context, id string, args, envs []string, opts ...ExecOption) (*ExecResponse, error) {
+	return RunSync(c.PrepareExec(ctx, id, args, envs, opts...))
+}
+
+// Exec executes passed command inside a container setting io streams to passed ones.
+func (c *CLIClient) Exec(ctx context.Context, id string,
+	stdin io.Reader, stdout, stderr io.Writer,
+	args, envs []string, opts ...ExecOption) error {
+
+	return RunAttached(c.PrepareExec(ctx, id, args, envs, opts...), stdin, stdout, stderr)
+}
 
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
+// RunSync runs prepared exec command until it exits and returns the result.
+// Non-zero exit code of the command is not considered an error.
+func RunSync(runCmd *exec.Cmd) (*ExecResponse, error) {
+	return RunSyncLimited(context.Background(), runCmd, 0)
+}
 
-	runCmd := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
-	runCmd.Stdout = &stdout
-	runCmd.Stderr = &stderr
-	runCmd.Env = envs
+// RunSyncLimited runs prepared exec command like RunSync, but keeps at most
+// limit bytes of each output stream, zero limit means unlimited. Command is
+// run in its own process group that is killed as a whole once ctx is done,
+// ctx.Err() is returned then.
+func RunSyncLimited(ctx context.Context, runCmd *exec.Cmd, limit int) (*ExecResponse, error) {
+	stdout := &cappedBuffer{limit: limit}
+	stderr := &cappedBuffer{limit: limit}
 
-	glog.V(5).Infof("Executing %v", cmd)
-	err := runCmd.Run()
-	var exitCode int32
-	exitErr, ok := err.(*exec.ExitError)
-	if ok {
-		// TODO use unix package here
-		var waitStatus syscall.WaitStatus
-		waitStatus, ok = exitErr.Sys().(syscall.WaitStatus)
-		if ok {
-			exitCode = int32(waitStatus.ExitStatus())
+	runCmd.Stdout = stdout
+	runCmd.Stderr = stderr
+	if runCmd.SysProcAttr == nil {
+		runCmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if !runCmd.SysProcAttr.Setsid {
+		runCmd.SysProcAttr.Setpgid = true
+	}
+
+	if err := runCmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not execute: %v", err)
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- runCmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-waitErr:
+		// command prepared with ctx may be killed before ctx.Done is seen here
+		if ctx.Err() != nil {
+			syscall.Kill(-runCmd.Process.Pid, syscall.SIGKILL)
+			return nil, ctx.Err()
 		}
+	case <-ctx.Done():
+		// children may keep output open, so the whole group is killed
+		syscall.Kill(-runCmd.Process.Pid, syscall.SIGKILL)
+		<-waitErr
+		return nil, ctx.Err()
 	}
-	if !ok && err != nil {
+	status, ok := ExitStatusOf(err)
+	if !ok {
 		return nil, fmt.Errorf("could not execute: %v", err)
 	}
 	return &ExecResponse{
-		Stdout:   stdout.Bytes(),
-		Stderr:   stderr.Bytes(),
-		ExitCode: exitCode,
+		Stdout:    stdout.Bytes(),
+		Stderr:    stderr.Bytes(),
+		ExitCode:  status.Code,
+		Truncated: stdout.truncated || stderr.truncated,
 	}, nil
 }
 
-// Exec executes passed command inside a container setting io streams to passed ones.
-func (c *CLIClient) Exec(ctx context.Context, id string,
-	stdin io.Reader, stdout, stderr io.Writer,
-	args, envs []string) error {
+// cappedBuffer keeps at most limit bytes written to it, zero limit
+// means unlimited. Writes beyond the limit are discarded silently
+// so that the writer is never blocked or failed.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.limit > 0 && b.buf.Len()+n > b.limit {
+		p = p[:b.limit-b.buf.Len()]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+// Bytes returns captured output.
+func (b *cappedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
 
-	runCmd := c.PrepareExec(ctx, id, args, envs)
+// RunAttached runs prepared exec command setting io streams to passed ones.
+// Command input is fed through a pipe, so RunAttached returns once command
+// exits even if stdin is never closed. Non-zero exit code of the command is
+// reported with *ExitError, exit code of command killed by signal is decoded
+// the same way RunSync does it.
+func RunAttached(runCmd *exec.Cmd, stdin io.Reader, stdout, stderr io.Writer) error {
 	runCmd.Stdout = stdout
 	runCmd.Stderr = stderr
-	runCmd.Stdin = stdin
+	if stdin != nil {
+		inPipe, err := runCmd.StdinPipe()
+		if err != nil {
+			return fmt.Errorf("could not create stdin pipe: %v", err)
+		}
+		go func() {
+			io.Copy(inPipe, stdin)
+			inPipe.Close()
+		}()
+	}
 
-	err := runCmd.Run()
-	_, ok := err.(*exec.ExitError)
-	if !ok && err != nil {
+	err := CheckExit(runCmd.Run())
+	if _, ok := err.(*ExitError); !ok && err != nil {
 		return fmt.Errorf("could not execute: %v", err)
 	}
-	return nil
+	return err
 }
 
 // PrepareExec simply prepares command to call to execute inside a
-// given container. It makes sure singularity exec script is called.
-func (c *CLIClient) PrepareExec(ctx context.Context, id string, args, envs []string) *exec.Cmd {
+// given container. Command never inherits environment of the caller,
+// only the passed envs are set.
+func (c *CLIClient) PrepareExec(ctx context.Context, id string, args, envs []string, opts ...ExecOption) *exec.Cmd {
 	cmd := append(c.ociBaseCmd, "exec", id)
 	cmd = append(cmd, args...)
 
 	glog.V(5).Infof("Prepared %v", cmd)
 	cmdCtx := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
-	cmdCtx.Env = envs
+	// nil env means inherit the current process one
+	cmdCtx.Env = append([]string{}, envs...)
+	for _, o := range opts {
+		o(cmdCtx)
+	}
 	return cmdCtx
 }
 
+// ExecOption is a type representing functional option for exec commands.
+type ExecOption func(cmd *exec.Cmd)
+
+// WithCgroups makes executed process join cgroups by writing its pid into the
+// passed cgroup.procs files before engine is invoked, so that the process and
+// all its children are accounted and limited the same way the container is.
+func WithCgroups(procsFiles []string) ExecOption {
+	// $$ stays the same after exec, so the engine and the executed
+	// command are spawned inside the joined cgroups
+	const script = `n=$1; shift; while [ "$n" -gt 0 ]; do echo $$ > "$1" || exit 126; shift; n=$((n-1)); done; exec "$@"`
+	return func(cmd *exec.Cmd) {
+		if len(procsFiles) == 0 {
+			return
+		}
+		args := []string{"sh", "-c", script, "sh", strconv.Itoa(len(procsFiles))}
+		args = append(args, procsFiles...)
+		args = append(args, cmd.Path)
+		args = append(args, cmd.Args[1:]...)
+		cmd.Path = "/bin/sh"
+		cmd.Args = args
+	}
+}
+
 // Kill asks runtime to send SIGINT to container with passed id.
 // If force is true that SIGKILL is sent instead.
 func (c *CLIClient) Kill(id string, force bool) error {
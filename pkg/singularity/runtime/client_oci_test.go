@@ -0,0 +1,205 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCLIClient_Cancel(t *testing.T) {
+	// fake engine that hangs on any command
+	c := &CLIClient{ociBaseCmd: []string{"sh", "-c", "exec sleep 60", "sh"}}
+
+	tt := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{
+			name: "create",
+			call: func(ctx context.Context) error {
+				_, err := c.Create(ctx, "test", "/tmp", true, false)
+				return err
+			},
+		},
+		{
+			name: "start",
+			call: func(ctx context.Context) error {
+				return c.Start(ctx, "test")
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			goroutines := runtime.NumGoroutine()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			err := tc.call(ctx)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+			require.True(t, time.Since(start) < 10*time.Second, "engine call was not cancelled")
+
+			// give copying goroutines a chance to exit
+			for i := 0; i < 50 && runtime.NumGoroutine() > goroutines; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			require.True(t, runtime.NumGoroutine() <= goroutines, "goroutines leaked after cancellation")
+		})
+	}
+}
+
+func TestCLIClient_ExecSync(t *testing.T) {
+	// fake engine that reports its pid and environment
+	c := &CLIClient{ociBaseCmd: []string{"sh", "-c", `echo "$$:$FOO:$HOME"`, "sh"}}
+
+	dir, err := ioutil.TempDir("", "cgroups-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	procs := []string{filepath.Join(dir, "memory.procs"), filepath.Join(dir, "cpu.procs")}
+
+	require.NotEmpty(t, os.Getenv("HOME"), "test requires HOME to be set")
+	tt := []struct {
+		name string
+		opts []ExecOption
+	}{
+		{
+			name: "no cgroups",
+		},
+		{
+			name: "join cgroups",
+			opts: []ExecOption{WithCgroups(procs)},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := c.ExecSync(context.Background(), "test", []string{"ls"}, []string{"FOO=bar"}, tc.opts...)
+			require.NoError(t, err)
+			require.Equal(t, int32(0), resp.ExitCode, string(resp.Stderr))
+
+			parts := strings.Split(strings.TrimSpace(string(resp.Stdout)), ":")
+			require.Len(t, parts, 3)
+			require.Equal(t, "bar", parts[1])
+			require.Empty(t, parts[2], "daemon environment leaked into exec")
+			if len(tc.opts) == 0 {
+				return
+			}
+			for _, f := range procs {
+				pid, err := ioutil.ReadFile(f)
+				require.NoError(t, err)
+				require.Equal(t, parts[0], strings.TrimSpace(string(pid)), "engine pid is not written into %s", f)
+			}
+		})
+	}
+
+	procs = append(procs, filepath.Join(dir, "missing", "cgroup.procs"))
+	resp, err := c.ExecSync(context.Background(), "test", nil, nil, WithCgroups(procs))
+	require.NoError(t, err)
+	require.Equal(t, int32(126), resp.ExitCode, "exec must fail when cgroup cannot be joined")
+}
+
+func TestRunAttached(t *testing.T) {
+	// stdin stays open as with an interactive client
+	stdin, w := io.Pipe()
+	defer w.Close()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", "echo out; echo err >&2; exit 3")
+	err := RunAttached(cmd, stdin, &stdout, &stderr)
+	exitErr, ok := err.(*ExitError)
+	require.True(t, ok, "unexpected error: %v", err)
+	require.EqualValues(t, 3, exitErr.Status.Code)
+	require.Equal(t, "out\n", stdout.String())
+	require.Equal(t, "err\n", stderr.String())
+}
+
+func TestRunSyncLimited(t *testing.T) {
+	tt := []struct {
+		name          string
+		cmd           string
+		limit         int
+		timeout       time.Duration
+		expectStdout  string
+		expectStderr  string
+		expectTrunc   bool
+		expectTimeout bool
+	}{
+		{
+			name:         "unlimited",
+			cmd:          "echo out; echo err >&2",
+			expectStdout: "out\n",
+			expectStderr: "err\n",
+		},
+		{
+			name:         "stdout truncated",
+			cmd:          "echo 0123456789; echo err >&2",
+			limit:        4,
+			expectStdout: "0123",
+			expectStderr: "err\n",
+			expectTrunc:  true,
+		},
+		{
+			name:         "output within limit",
+			cmd:          "echo out",
+			limit:        4,
+			expectStdout: "out\n",
+		},
+		{
+			// background child keeps output open after shell is killed
+			name:          "timeout",
+			cmd:           "sleep 60 & sleep 60",
+			timeout:       100 * time.Millisecond,
+			expectTimeout: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+
+			start := time.Now()
+			resp, err := RunSyncLimited(ctx, exec.CommandContext(ctx, "sh", "-c", tc.cmd), tc.limit)
+			if tc.expectTimeout {
+				require.Equal(t, context.DeadlineExceeded, err)
+				require.True(t, time.Since(start) < 10*time.Second, "process group was not killed")
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectStdout, string(resp.Stdout))
+			require.Equal(t, tc.expectStderr, string(resp.Stderr))
+			require.Equal(t, tc.expectTrunc, resp.Truncated)
+		})
+	}
+}
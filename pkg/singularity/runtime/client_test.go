@@ -62,6 +62,19 @@ SINGULARITY_CONFDIR=/usr/local/etc/singularity
 				SingularityConfdir: "/usr/local/etc/singularity",
 			},
 		},
+		{
+			name: "with libexecdir",
+			in: []byte(`
+PACKAGE_NAME=singularity
+PREFIX=/usr/local
+LIBEXECDIR=/usr/local/libexec
+SINGULARITY_CONFDIR=/usr/local/etc/singularity
+`),
+			expect: BuildConfig{
+				SingularityConfdir: "/usr/local/etc/singularity",
+				LibexecDir:         "/usr/local/libexec",
+			},
+		},
 	}
 
 	for _, tc := range tt {
@@ -71,3 +84,9 @@ SINGULARITY_CONFDIR=/usr/local/etc/singularity
 		})
 	}
 }
+
+func TestNewCLIClientFor(t *testing.T) {
+	c := NewCLIClientFor("/opt/singularity/bin/singularity", "--nocolor")
+	require.Equal(t, []string{"/opt/singularity/bin/singularity", "-q", "--nocolor", "oci"}, c.ociBaseCmd)
+	require.Equal(t, len(c.ociBaseCmd), cap(c.ociBaseCmd))
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"io"
+	"os/exec"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/pkg/ociruntime"
+)
+
+// Engine is a set of operations on containers and pods that are
+// delegated to OCI engine. CLIClient is the one talking to Singularity,
+// FakeEngine runs processes on the host for integration testing.
+type Engine interface {
+	State(id string) (*ociruntime.State, error)
+	Delete(id string) error
+	Create(ctx context.Context, id, bundle string, stdin, tty bool, flags ...string) (io.WriteCloser, error)
+	Start(ctx context.Context, id string) error
+	Kill(id string, force bool) error
+	Signal(id, sig string) error
+	UpdateContainerResources(id string, req *specs.LinuxResources) error
+	PrepareExec(ctx context.Context, id string, args, envs []string, opts ...ExecOption) *exec.Cmd
+}
+
+var (
+	_ Engine = (*CLIClient)(nil)
+	_ Engine = (*FakeEngine)(nil)
+)
+
+// IsFake checks whether passed engine is a FakeEngine.
+func IsFake(e Engine) bool {
+	_, ok := e.(*FakeEngine)
+	return ok
+}
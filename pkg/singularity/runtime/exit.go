@@ -0,0 +1,103 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+
+	sysunix "golang.org/x/sys/unix"
+)
+
+// signalExitBase is added to number of the signal that killed process
+// to get its exit code, the way shells report signal deaths.
+const signalExitBase = 128
+
+// ExitStatus is a decoded wait status of a finished process.
+type ExitStatus struct {
+	// Code is process exit code or signalExitBase+signal number
+	// when process was killed by signal.
+	Code int32
+	// Signal is signal that killed process, zero if process exited.
+	Signal syscall.Signal
+}
+
+// DecodeWaitStatus decodes wait status of a finished process.
+func DecodeWaitStatus(ws syscall.WaitStatus) ExitStatus {
+	if ws.Signaled() {
+		return ExitStatus{
+			Code:   signalExitBase + int32(ws.Signal()),
+			Signal: ws.Signal(),
+		}
+	}
+	return ExitStatus{Code: int32(ws.ExitStatus())}
+}
+
+// ExitStatusOf decodes error returned by Run or Wait of exec.Cmd, nil error
+// means zero exit code. False is returned when error doesn't come from
+// finished process, e.g. when process could not be started at all.
+func ExitStatusOf(err error) (ExitStatus, bool) {
+	if err == nil {
+		return ExitStatus{}, true
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return ExitStatus{}, false
+	}
+	ws, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return ExitStatus{}, false
+	}
+	return DecodeWaitStatus(ws), true
+}
+
+// Signaled checks whether process was killed by signal.
+func (s ExitStatus) Signaled() bool {
+	return s.Signal != 0
+}
+
+// String returns human readable description of exit status,
+// e.g. killed by SIGKILL signal.
+func (s ExitStatus) String() string {
+	if s.Signaled() {
+		return fmt.Sprintf("killed by %s signal", sysunix.SignalName(s.Signal))
+	}
+	return fmt.Sprintf("exited with code %d", s.Code)
+}
+
+// ExitError is returned when executed command finishes with non-zero exit code.
+type ExitError struct {
+	Status ExitStatus
+}
+
+// Error implements error interface.
+func (e *ExitError) Error() string {
+	return "command " + e.Status.String()
+}
+
+// CheckExit converts error returned by Run or Wait of exec.Cmd so that
+// zero exit code is not an error and non-zero one is *ExitError.
+// Other errors are returned as is.
+func CheckExit(err error) error {
+	status, ok := ExitStatusOf(err)
+	if !ok {
+		return err
+	}
+	if status.Code == 0 {
+		return nil
+	}
+	return &ExitError{Status: status}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"bytes"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitStatusOf(t *testing.T) {
+	tt := []struct {
+		name         string
+		cmd          []string
+		expectOK     bool
+		expectStatus ExitStatus
+		expectString string
+	}{
+		{
+			name:         "success",
+			cmd:          []string{"true"},
+			expectOK:     true,
+			expectString: "exited with code 0",
+		},
+		{
+			name:         "exit code",
+			cmd:          []string{"sh", "-c", "exit 3"},
+			expectOK:     true,
+			expectStatus: ExitStatus{Code: 3},
+			expectString: "exited with code 3",
+		},
+		{
+			name:         "sigkill",
+			cmd:          []string{"sh", "-c", "kill -KILL $$"},
+			expectOK:     true,
+			expectStatus: ExitStatus{Code: 137, Signal: syscall.SIGKILL},
+			expectString: "killed by SIGKILL signal",
+		},
+		{
+			name:         "sigsegv",
+			cmd:          []string{"sh", "-c", "kill -SEGV $$"},
+			expectOK:     true,
+			expectStatus: ExitStatus{Code: 139, Signal: syscall.SIGSEGV},
+			expectString: "killed by SIGSEGV signal",
+		},
+		{
+			name:     "not started",
+			cmd:      []string{"/not/existing/binary"},
+			expectOK: false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := exec.Command(tc.cmd[0], tc.cmd[1:]...).Run()
+			status, ok := ExitStatusOf(err)
+			require.Equal(t, tc.expectOK, ok)
+			if !ok {
+				return
+			}
+			require.Equal(t, tc.expectStatus, status)
+			require.Equal(t, tc.expectString, status.String())
+
+			// exec sync and attached exec decode exit the same way
+			resp, err := RunSync(exec.Command(tc.cmd[0], tc.cmd[1:]...))
+			require.NoError(t, err)
+			require.Equal(t, tc.expectStatus.Code, resp.ExitCode)
+
+			var out bytes.Buffer
+			err = RunAttached(exec.Command(tc.cmd[0], tc.cmd[1:]...), nil, &out, &out)
+			if tc.expectStatus.Code == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Equal(t, &ExitError{Status: tc.expectStatus}, err)
+		})
+	}
+}
@@ -0,0 +1,448 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity-cri/pkg/crilog"
+	"github.com/sylabs/singularity-cri/pkg/singularity"
+	"github.com/sylabs/singularity/pkg/ociruntime"
+	"github.com/sylabs/singularity/pkg/util/unix"
+	sysunix "golang.org/x/sys/unix"
+)
+
+// FakeEngineName is reported as engine name and version when FakeEngine is used.
+const FakeEngineName = "fake"
+
+const (
+	statusCreating = "creating"
+	statusCreated  = "created"
+	statusRunning  = "running"
+	statusStopped  = "stopped"
+)
+
+type (
+	// FakeEngine is an Engine that runs container processes directly on the
+	// host without any isolation. It needs neither Singularity nor root and is
+	// meant for integration testing only: processes share host filesystem,
+	// namespaces and cgroups, resources updates and exec options are ignored,
+	// attach and control sockets are not provided.
+	FakeEngine struct {
+		mu        sync.Mutex
+		instances map[string]*fakeInstance
+	}
+
+	fakeInstance struct {
+		mu      sync.Mutex
+		state   ociruntime.State
+		args    []string
+		env     []string
+		dir     string
+		socket  string
+		logPath string
+		stdin   *os.File
+		cmd     *exec.Cmd
+	}
+)
+
+// NewFakeEngine returns new FakeEngine ready to use.
+func NewFakeEngine() *FakeEngine {
+	return &FakeEngine{instances: make(map[string]*fakeInstance)}
+}
+
+// State returns state of an instance with passed id. If there is no
+// such instance, ErrNotFound is returned.
+func (e *FakeEngine) State(id string) (*ociruntime.State, error) {
+	inst, err := e.instance(id)
+	if err != nil {
+		return nil, err
+	}
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	state := inst.state
+	return &state, nil
+}
+
+// Delete removes instance with passed id. Running instances
+// cannot be removed. If there is no such instance, ErrNotFound is returned.
+func (e *FakeEngine) Delete(id string) error {
+	inst, err := e.instance(id)
+	if err != nil {
+		return err
+	}
+	inst.mu.Lock()
+	running := inst.cmd != nil && inst.state.Status != statusStopped
+	inst.mu.Unlock()
+	if running {
+		return fmt.Errorf("could not delete instance %s: instance is running", id)
+	}
+	if inst.stdin != nil {
+		inst.stdin.Close()
+	}
+
+	e.mu.Lock()
+	delete(e.instances, id)
+	e.mu.Unlock()
+	return nil
+}
+
+// Create creates an instance from the bundle config. It understands the same
+// --sync-socket, --log-path and --empty-process flags Singularity does. Tty is
+// not supported, so when stdin is requested the returned writer is a pipe
+// to the instance process, otherwise all writes are discarded.
+func (e *FakeEngine) Create(ctx context.Context, id, bundle string, stdin, tty bool, flags ...string) (io.WriteCloser, error) {
+	inst := &fakeInstance{}
+	empty := false
+	for i := 0; i < len(flags); i++ {
+		switch flags[i] {
+		case "--empty-process":
+			empty = true
+		case "--sync-socket", "--log-path":
+			if i+1 == len(flags) {
+				return nil, fmt.Errorf("flag %s needs an argument", flags[i])
+			}
+			if flags[i] == "--sync-socket" {
+				inst.socket = flags[i+1]
+			} else {
+				inst.logPath = flags[i+1]
+			}
+			i++
+		}
+	}
+
+	spec, err := readBundleSpec(bundle)
+	if err != nil {
+		return nil, err
+	}
+	if !empty && spec.Process != nil {
+		inst.args = hostArgs(spec.Process.Args)
+		inst.env = spec.Process.Env
+		if fi, err := os.Stat(spec.Process.Cwd); err == nil && fi.IsDir() {
+			inst.dir = spec.Process.Cwd
+		}
+	}
+	now := time.Now().UnixNano()
+	inst.state = ociruntime.State{
+		State: specs.State{
+			Version:     specs.Version,
+			ID:          id,
+			Status:      statusCreating,
+			Bundle:      bundle,
+			Annotations: spec.Annotations,
+		},
+		CreatedAt: &now,
+	}
+
+	e.mu.Lock()
+	if _, ok := e.instances[id]; ok {
+		e.mu.Unlock()
+		return nil, fmt.Errorf("instance %s already exists", id)
+	}
+	e.instances[id] = inst
+	e.mu.Unlock()
+
+	glog.V(5).Infof("Fake engine created instance %s running %v", id, inst.args)
+	if err := inst.notify(statusCreating); err != nil {
+		return nil, err
+	}
+
+	var stdinWrite io.WriteCloser = nopWriteCloser{ioutil.Discard}
+	if stdin && len(inst.args) != 0 {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, fmt.Errorf("could not create stdin pipe: %v", err)
+		}
+		inst.stdin = r
+		stdinWrite = w
+	}
+
+	inst.mu.Lock()
+	inst.state.Status = statusCreated
+	inst.mu.Unlock()
+	if err := inst.notify(statusCreated); err != nil {
+		stdinWrite.Close()
+		return nil, err
+	}
+	return stdinWrite, nil
+}
+
+// Start starts process of the created instance with passed id.
+func (e *FakeEngine) Start(ctx context.Context, id string) error {
+	inst, err := e.instance(id)
+	if err != nil {
+		return err
+	}
+
+	inst.mu.Lock()
+	if inst.state.Status != statusCreated {
+		status := inst.state.Status
+		inst.mu.Unlock()
+		return fmt.Errorf("could not start instance %s: instance is %s", id, status)
+	}
+	var cmd *exec.Cmd
+	var logs io.Closer
+	if len(inst.args) != 0 {
+		cmd = exec.Command(inst.args[0], inst.args[1:]...)
+		cmd.Env = inst.env
+		cmd.Dir = inst.dir
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if inst.stdin != nil {
+			cmd.Stdin = inst.stdin
+		}
+		if inst.logPath != "" {
+			file, err := os.OpenFile(inst.logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+			if err != nil {
+				inst.mu.Unlock()
+				return fmt.Errorf("could not open log file: %v", err)
+			}
+			mu := new(sync.Mutex)
+			stdout := crilog.NewWriter(file, crilog.Stdout, crilog.WithLock(mu))
+			stderr := crilog.NewWriter(file, crilog.Stderr, crilog.WithLock(mu))
+			cmd.Stdout = stdout
+			cmd.Stderr = stderr
+			logs = &criLogFile{file: file, streams: []*crilog.Writer{stdout, stderr}}
+		}
+		if err := cmd.Start(); err != nil {
+			inst.mu.Unlock()
+			if logs != nil {
+				logs.Close()
+			}
+			return fmt.Errorf("could not start %v: %v", inst.args, err)
+		}
+		inst.cmd = cmd
+		inst.state.Pid = cmd.Process.Pid
+	}
+	now := time.Now().UnixNano()
+	inst.state.StartedAt = &now
+	inst.state.Status = statusRunning
+	inst.mu.Unlock()
+
+	if err := inst.notify(statusRunning); err != nil {
+		return err
+	}
+	if cmd != nil {
+		go inst.wait(cmd, logs)
+	}
+	return nil
+}
+
+// Kill sends SIGINT to instance with passed id.
+// If force is true that SIGKILL is sent instead.
+func (e *FakeEngine) Kill(id string, force bool) error {
+	sig := "SIGINT"
+	if force {
+		sig = "SIGKILL"
+	}
+	return e.Signal(id, sig)
+}
+
+// Signal sends passed sig to process group of instance with passed id.
+// Instances without a running process are stopped by any signal.
+func (e *FakeEngine) Signal(id, sig string) error {
+	inst, err := e.instance(id)
+	if err != nil {
+		return err
+	}
+	num, err := parseSignal(sig)
+	if err != nil {
+		return err
+	}
+
+	inst.mu.Lock()
+	status, cmd := inst.state.Status, inst.cmd
+	inst.mu.Unlock()
+	switch {
+	case status == statusStopped:
+		return nil
+	case cmd == nil:
+		inst.exit(0, "")
+		return nil
+	}
+	glog.V(5).Infof("Fake engine sends %s to instance %s", sig, id)
+	if err := syscall.Kill(-cmd.Process.Pid, num); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("could not send %s to instance %s: %v", sig, id, err)
+	}
+	return nil
+}
+
+// UpdateContainerResources does nothing but checking instance exists since
+// fake instances are not limited in any way.
+func (e *FakeEngine) UpdateContainerResources(id string, req *specs.LinuxResources) error {
+	_, err := e.instance(id)
+	return err
+}
+
+// PrepareExec prepares command to run on the host on behalf of instance
+// with passed id. Exec options are ignored.
+func (e *FakeEngine) PrepareExec(ctx context.Context, id string, args, envs []string, opts ...ExecOption) *exec.Cmd {
+	args = hostArgs(args)
+	var name string
+	if len(args) != 0 {
+		name, args = args[0], args[1:]
+	}
+	glog.V(5).Infof("Prepared fake exec %s %v", name, args)
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append([]string{}, envs...)
+	return cmd
+}
+
+func (e *FakeEngine) instance(id string) (*fakeInstance, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	inst, ok := e.instances[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return inst, nil
+}
+
+// wait waits for instance process to finish and records its exit status.
+func (i *fakeInstance) wait(cmd *exec.Cmd, logs io.Closer) {
+	err := cmd.Wait()
+	if logs != nil {
+		logs.Close()
+	}
+
+	var code int
+	var desc string
+	if status, ok := ExitStatusOf(err); ok {
+		code = int(status.Code)
+		if status.Signaled() {
+			desc = status.String()
+		}
+	} else {
+		code = -1
+		desc = err.Error()
+	}
+	i.exit(code, desc)
+}
+
+// exit moves instance into stopped state unless it is there already.
+func (i *fakeInstance) exit(code int, desc string) {
+	i.mu.Lock()
+	if i.state.Status == statusStopped {
+		i.mu.Unlock()
+		return
+	}
+	now := time.Now().UnixNano()
+	i.state.Status = statusStopped
+	i.state.FinishedAt = &now
+	i.state.ExitCode = &code
+	i.state.ExitDesc = desc
+	i.mu.Unlock()
+
+	if i.stdin != nil {
+		i.stdin.Close()
+	}
+	if err := i.notify(statusStopped); err != nil {
+		glog.Errorf("Could not report instance %s exit: %v", i.state.ID, err)
+	}
+}
+
+// notify reports passed status on instance sync socket, if any.
+func (i *fakeInstance) notify(status string) error {
+	if i.socket == "" {
+		return nil
+	}
+	conn, err := unix.Dial(i.socket)
+	if err != nil {
+		return fmt.Errorf("could not connect to sync socket: %v", err)
+	}
+	defer conn.Close()
+	err = json.NewEncoder(conn).Encode(map[string]string{"status": status})
+	if err != nil {
+		return fmt.Errorf("could not send %s status: %v", status, err)
+	}
+	return nil
+}
+
+func readBundleSpec(bundle string) (*specs.Spec, error) {
+	f, err := os.Open(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not open bundle config: %v", err)
+	}
+	defer f.Close()
+
+	var spec specs.Spec
+	if err := json.NewDecoder(f).Decode(&spec); err != nil {
+		return nil, fmt.Errorf("could not decode bundle config: %v", err)
+	}
+	return &spec, nil
+}
+
+// hostArgs drops Singularity action script from command, if any,
+// since images are not mounted and scripts are missing on the host.
+func hostArgs(args []string) []string {
+	if len(args) != 0 && (args[0] == singularity.RunScript || args[0] == singularity.ExecScript) {
+		return args[1:]
+	}
+	return args
+}
+
+// parseSignal parses signal passed either by name, with
+// or without SIG prefix, or by number.
+func parseSignal(sig string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(sig); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	name := strings.ToUpper(sig)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	num := sysunix.SignalNum(name)
+	if num == 0 {
+		return 0, fmt.Errorf("unknown signal %s", sig)
+	}
+	return num, nil
+}
+
+// criLogFile is a CRI log file written by fake instance streams.
+type criLogFile struct {
+	file    *os.File
+	streams []*crilog.Writer
+}
+
+// Close writes incomplete lines of streams and closes the file.
+func (f *criLogFile) Close() error {
+	for _, stream := range f.streams {
+		if err := stream.Close(); err != nil {
+			glog.Errorf("Could not write log entry: %v", err)
+		}
+	}
+	return f.file.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFakeBundle(t *testing.T, args ...string) string {
+	bundle, err := ioutil.TempDir("", "fake-bundle-")
+	require.NoError(t, err)
+	spec := specs.Spec{
+		Process: &specs.Process{
+			Args: args,
+			Env:  []string{"PATH=/bin:/usr/bin", "GREETING=hello"},
+			Cwd:  "/",
+		},
+	}
+	data, err := json.Marshal(spec)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bundle, "config.json"), data, 0644))
+	return bundle
+}
+
+func TestFakeEngine(t *testing.T) {
+	bundle := writeFakeBundle(t, "/.singularity.d/actions/exec", "/bin/sh", "-c", `echo "$GREETING"; echo oops >&2; exec sleep 60`)
+	defer os.RemoveAll(bundle)
+	socket := filepath.Join(bundle, "sync.sock")
+	logPath := filepath.Join(bundle, "container.log")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	states, err := ObserveState(ctx, socket)
+	require.NoError(t, err)
+
+	e := NewFakeEngine()
+	stdin, err := e.Create(ctx, "test", bundle, false, false, "--sync-socket", socket, "--log-path", logPath)
+	require.NoError(t, err)
+	defer stdin.Close()
+	require.Equal(t, StateCreating, <-states)
+	require.Equal(t, StateCreated, <-states)
+
+	_, err = e.Create(ctx, "test", bundle, false, false)
+	require.Error(t, err, "duplicate instance")
+
+	require.NoError(t, e.Start(ctx, "test"))
+	require.Equal(t, StateRunning, <-states)
+	state, err := e.State("test")
+	require.NoError(t, err)
+	require.Equal(t, "running", state.Status)
+	require.NotZero(t, state.Pid)
+	require.Error(t, e.Delete("test"), "running instance")
+
+	resp, err := RunSync(e.PrepareExec(ctx, "test", []string{"/.singularity.d/actions/exec", "/bin/sh", "-c", "echo $GREETING"}, []string{"GREETING=hi"}))
+	require.NoError(t, err)
+	require.Equal(t, "hi\n", string(resp.Stdout))
+
+	// let shell write its output before it is terminated
+	for i := 0; i < 50; i++ {
+		if logs, _ := ioutil.ReadFile(logPath); strings.Count(string(logs), "\n") == 2 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NoError(t, e.Signal("test", "TERM"))
+	require.Equal(t, StateExited, <-states)
+	state, err = e.State("test")
+	require.NoError(t, err)
+	require.Equal(t, "stopped", state.Status)
+	require.Equal(t, 128+int(syscall.SIGTERM), *state.ExitCode)
+	require.NoError(t, e.Kill("test", true), "stopped instance")
+
+	logs, err := ioutil.ReadFile(logPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(logs)), "\n")
+	require.Len(t, lines, 2)
+	var streams []string
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 4)
+		require.Len(t, fields, 4)
+		_, err := time.Parse(time.RFC3339Nano, fields[0])
+		require.NoError(t, err)
+		require.Equal(t, "F", fields[2])
+		streams = append(streams, fields[1]+":"+fields[3])
+	}
+	require.ElementsMatch(t, []string{"stdout:hello", "stderr:oops"}, streams)
+
+	require.NoError(t, e.Delete("test"))
+	_, err = e.State("test")
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestFakeEngine_EmptyProcess(t *testing.T) {
+	bundle := writeFakeBundle(t, "/bin/false")
+	defer os.RemoveAll(bundle)
+
+	ctx := context.Background()
+	e := NewFakeEngine()
+	stdin, err := e.Create(ctx, "pod", bundle, false, false, "--empty-process")
+	require.NoError(t, err)
+	require.NoError(t, stdin.Close())
+	require.NoError(t, e.Start(ctx, "pod"))
+	state, err := e.State("pod")
+	require.NoError(t, err)
+	require.Equal(t, "running", state.Status)
+	require.Zero(t, state.Pid)
+
+	require.NoError(t, e.Kill("pod", false))
+	state, err = e.State("pod")
+	require.NoError(t, err)
+	require.Equal(t, "stopped", state.Status)
+	require.Equal(t, 0, *state.ExitCode)
+	require.NoError(t, e.Delete("pod"))
+}
+
+func TestParseSignal(t *testing.T) {
+	tt := []struct {
+		sig       string
+		expect    syscall.Signal
+		expectErr bool
+	}{
+		{sig: "SIGTERM", expect: syscall.SIGTERM},
+		{sig: "kill", expect: syscall.SIGKILL},
+		{sig: "2", expect: syscall.SIGINT},
+		{sig: "SIGFOO", expectErr: true},
+		{sig: "-1", expectErr: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.sig, func(t *testing.T) {
+			sig, err := parseSignal(tc.sig)
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, sig)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"strings"
+	"sync"
+)
+
+// maxStderrTail is the number of last stderr bytes ExecError keeps.
+const maxStderrTail = 4 << 10
+
+// ExecError is returned when engine command fails. Besides the error
+// it holds the tail of command stderr output, which is forwarded to
+// CRI stderr as well.
+type ExecError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *ExecError) Error() string {
+	return e.Err.Error()
+}
+
+// Stderr returns command stderr output captured by err
+// or an empty string if err is not an *ExecError.
+func Stderr(err error) string {
+	if e, ok := err.(*ExecError); ok {
+		return e.Stderr
+	}
+	return ""
+}
+
+// stderrTail keeps last maxStderrTail bytes written to it.
+// It is safe to write to and read from stderrTail concurrently.
+type stderrTail struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > maxStderrTail {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-maxStderrTail:]...)
+	}
+	return len(p), nil
+}
+
+func (t *stderrTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.TrimSpace(string(t.buf))
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStderrTail(t *testing.T) {
+	var tail stderrTail
+	_, err := tail.Write([]byte(strings.Repeat("a", maxStderrTail)))
+	require.NoError(t, err)
+	_, err = tail.Write([]byte("FATAL: failed\n"))
+	require.NoError(t, err)
+	out := tail.String()
+	require.Len(t, out, maxStderrTail-1)
+	require.True(t, strings.HasSuffix(out, "aFATAL: failed"))
+}
+
+func TestRunContext_Stderr(t *testing.T) {
+	err := runContext(context.Background(), []string{"sh", "-c", "echo FATAL: no instance >&2; exit 1"})
+	require.Error(t, err)
+	require.Equal(t, "could not execute: exit status 1", err.Error())
+	require.Equal(t, "FATAL: no instance", Stderr(err))
+
+	require.NoError(t, runContext(context.Background(), []string{"true"}))
+	require.Empty(t, Stderr(fmt.Errorf("not an exec error")))
+}
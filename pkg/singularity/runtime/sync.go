@@ -99,11 +99,26 @@ func ObserveState(ctx context.Context, socket string) (<-chan State, error) {
 	return syncChan, nil
 }
 
-func readState(conn io.ReadCloser) (State, error) {
-	type statusInfo struct {
-		Status string `json:"status"`
+// NotifyState reports state change on passed socket the same way engine
+// does, so that it may be relayed to a listener started with ObserveState.
+func NotifyState(socket string, state State) error {
+	conn, err := unix.Dial(socket)
+	if err != nil {
+		return fmt.Errorf("could not connect to sync socket: %v", err)
+	}
+	defer conn.Close()
+	err = json.NewEncoder(conn).Encode(statusInfo{Status: StateToStatus(state)})
+	if err != nil {
+		return fmt.Errorf("could not send %v state: %v", state, err)
 	}
+	return nil
+}
 
+type statusInfo struct {
+	Status string `json:"status"`
+}
+
+func readState(conn io.ReadCloser) (State, error) {
 	defer conn.Close()
 	dec := json.NewDecoder(conn)
 	var status statusInfo
@@ -149,3 +164,12 @@ func StatusToState(status string) State {
 	}
 	return state
 }
+
+// StateToStatus is a helper func to convert State to container OCI status,
+// it is the reverse of StatusToState.
+func StateToStatus(state State) string {
+	if state == StateExited {
+		return "stopped"
+	}
+	return state.String()
+}
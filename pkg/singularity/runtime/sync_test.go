@@ -105,3 +105,18 @@ func TestObserveState_AllWithDelay(t *testing.T) {
 	cancel()
 	assert.True(t, os.IsNotExist(os.Remove(socket)))
 }
+
+func TestNotifyState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	socket := filepath.Join(os.TempDir(), fmt.Sprintf("cri-test-%s.sock", t.Name()))
+
+	state, err := ObserveState(ctx, socket)
+	require.NoError(t, err, "could not listen on socket")
+	for _, s := range []State{StateCreating, StateCreated, StateRunning, StateExited} {
+		require.NoError(t, NotifyState(socket, s))
+		assert.Equal(t, s, <-state)
+	}
+	_, ok := <-state
+	assert.False(t, ok, "channel must be closed after exit")
+}
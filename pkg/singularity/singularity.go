@@ -50,4 +50,12 @@ const (
 	// EnvDockerPassword should be used to set Docker password for
 	// build engine when building from a private registry.
 	EnvDockerPassword = "SINGULARITY_DOCKER_PASSWORD"
+
+	// EnvCacheDir should be used to set directory build engine
+	// keeps downloaded docker layers in.
+	EnvCacheDir = "SINGULARITY_CACHEDIR"
+
+	// EnvTmpDir should be used to set directory build engine
+	// unpacks image root filesystem in.
+	EnvTmpDir = "SINGULARITY_TMPDIR"
 )
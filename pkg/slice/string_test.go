@@ -83,3 +83,36 @@ func TestRemoveFromString(t *testing.T) {
 		})
 	}
 }
+
+func TestContainsString(t *testing.T) {
+	tt := []struct {
+		name   string
+		s      []string
+		v      string
+		expect bool
+	}{
+		{
+			name:   "empty slice",
+			v:      "CAP_SYS_ADMIN",
+			expect: false,
+		},
+		{
+			name:   "not found",
+			s:      []string{"CAP_CHOWN", "CAP_KILL"},
+			v:      "CAP_SYS_ADMIN",
+			expect: false,
+		},
+		{
+			name:   "found",
+			s:      []string{"CAP_CHOWN", "CAP_SYS_ADMIN"},
+			v:      "CAP_SYS_ADMIN",
+			expect: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expect, ContainsString(tc.s, tc.v))
+		})
+	}
+}
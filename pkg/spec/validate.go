@@ -0,0 +1,245 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spec provides static validation of generated OCI runtime specs,
+// so that invalid configuration is reported before the engine is invoked.
+package spec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Kernel limits for resources.
+const (
+	minCPUShares = 2
+	maxCPUShares = 262144
+
+	minCPUPeriod = 1000    // 1ms
+	maxCPUPeriod = 1000000 // 1s
+	minCPUQuota  = 1000    // 1ms
+
+	minOOMScoreAdj = -1000
+	maxOOMScoreAdj = 1000
+)
+
+// ValidationError holds all violations found in OCI spec.
+type ValidationError struct {
+	Violations []string
+}
+
+// Error implements error interface.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid OCI spec: %s", strings.Join(e.Violations, "; "))
+}
+
+type validator struct {
+	spec       *specs.Spec
+	violations []string
+}
+
+// Validate checks OCI spec for mount and device collisions, duplicate
+// environment variables, inconsistent namespaces, out of range resources
+// and missing rootfs. When any violation is found *ValidationError
+// is returned.
+func Validate(spec *specs.Spec) error {
+	if spec == nil {
+		return &ValidationError{Violations: []string{"spec is nil"}}
+	}
+
+	v := validator{spec: spec}
+	v.validateRoot()
+	v.validateProcess()
+	v.validateMounts()
+	if spec.Linux != nil {
+		v.validateDevices()
+		v.validateNamespaces()
+		v.validateCgroupsPath()
+		v.validateResources()
+	}
+	if len(v.violations) != 0 {
+		return &ValidationError{Violations: v.violations}
+	}
+	return nil
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.violations = append(v.violations, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) validateRoot() {
+	if v.spec.Root == nil || v.spec.Root.Path == "" {
+		v.addf("root.path: must be set")
+		return
+	}
+	fi, err := os.Stat(v.spec.Root.Path)
+	if err != nil {
+		v.addf("root.path: %v", err)
+		return
+	}
+	if !fi.IsDir() {
+		v.addf("root.path: %s is not a directory", v.spec.Root.Path)
+	}
+}
+
+func (v *validator) validateProcess() {
+	if v.spec.Process == nil {
+		v.addf("process: must be set")
+		return
+	}
+	if len(v.spec.Process.Args) == 0 {
+		v.addf("process.args: must not be empty")
+	}
+	if cwd := v.spec.Process.Cwd; cwd != "" && !filepath.IsAbs(cwd) {
+		v.addf("process.cwd: %s is not an absolute path", cwd)
+	}
+	keys := make(map[string]int)
+	for i, env := range v.spec.Process.Env {
+		key := strings.SplitN(env, "=", 2)[0]
+		if key == "" {
+			v.addf("process.env[%d]: empty variable name", i)
+			continue
+		}
+		if j, ok := keys[key]; ok {
+			v.addf("process.env[%d]: %s is already set by process.env[%d]", i, key, j)
+			continue
+		}
+		keys[key] = i
+	}
+	if adj := v.spec.Process.OOMScoreAdj; adj != nil && (*adj < minOOMScoreAdj || *adj > maxOOMScoreAdj) {
+		v.addf("process.oomScoreAdj: %d is out of range [%d, %d]", *adj, minOOMScoreAdj, maxOOMScoreAdj)
+	}
+}
+
+func (v *validator) validateMounts() {
+	dests := make(map[string]int)
+	for i, m := range v.spec.Mounts {
+		if !filepath.IsAbs(m.Destination) {
+			v.addf("mounts[%d].destination: %s is not an absolute path", i, m.Destination)
+			continue
+		}
+		dest := filepath.Clean(m.Destination)
+		if j, ok := dests[dest]; ok {
+			v.addf("mounts[%d].destination: %s collides with mounts[%d]", i, m.Destination, j)
+			continue
+		}
+		dests[dest] = i
+		if isBind(m) && !filepath.IsAbs(m.Source) {
+			v.addf("mounts[%d].source: %s is not an absolute path", i, m.Source)
+		}
+	}
+}
+
+func (v *validator) validateDevices() {
+	paths := make(map[string]int)
+	for i, d := range v.spec.Linux.Devices {
+		if !filepath.IsAbs(d.Path) {
+			v.addf("linux.devices[%d].path: %s is not an absolute path", i, d.Path)
+			continue
+		}
+		path := filepath.Clean(d.Path)
+		if j, ok := paths[path]; ok {
+			v.addf("linux.devices[%d].path: %s is already used by linux.devices[%d]", i, d.Path, j)
+			continue
+		}
+		paths[path] = i
+	}
+}
+
+func (v *validator) validateNamespaces() {
+	namespaces := make(map[specs.LinuxNamespaceType]specs.LinuxNamespace)
+	for i, ns := range v.spec.Linux.Namespaces {
+		switch ns.Type {
+		case specs.PIDNamespace, specs.NetworkNamespace, specs.MountNamespace,
+			specs.IPCNamespace, specs.UTSNamespace, specs.UserNamespace, specs.CgroupNamespace:
+		default:
+			v.addf("linux.namespaces[%d].type: unknown namespace type %q", i, ns.Type)
+			continue
+		}
+		if _, ok := namespaces[ns.Type]; ok {
+			v.addf("linux.namespaces[%d].type: %s namespace is specified more than once", i, ns.Type)
+			continue
+		}
+		if ns.Path != "" && !filepath.IsAbs(ns.Path) {
+			v.addf("linux.namespaces[%d].path: %s is not an absolute path", i, ns.Path)
+		}
+		namespaces[ns.Type] = ns
+	}
+
+	userNs, hasUserNs := namespaces[specs.UserNamespace]
+	if _, hasNetNs := namespaces[specs.NetworkNamespace]; hasUserNs && userNs.Path != "" && !hasNetNs {
+		v.addf("linux.namespaces: joining existing user namespace is not supported with host network")
+	}
+	if hasUserNs && len(v.spec.Linux.UIDMappings) == 0 && userNs.Path == "" {
+		v.addf("linux.uidMappings: must be set when new user namespace is created")
+	}
+}
+
+func (v *validator) validateCgroupsPath() {
+	path := v.spec.Linux.CgroupsPath
+	if path == "" {
+		return
+	}
+	for _, elem := range strings.Split(path, "/") {
+		if elem == ".." {
+			v.addf("linux.cgroupsPath: %s must not contain '..'", path)
+			return
+		}
+	}
+}
+
+func (v *validator) validateResources() {
+	res := v.spec.Linux.Resources
+	if res == nil {
+		return
+	}
+	if cpu := res.CPU; cpu != nil {
+		if cpu.Shares != nil && *cpu.Shares != 0 && (*cpu.Shares < minCPUShares || *cpu.Shares > maxCPUShares) {
+			v.addf("linux.resources.cpu.shares: %d is out of range [%d, %d]", *cpu.Shares, minCPUShares, maxCPUShares)
+		}
+		if cpu.Period != nil && *cpu.Period != 0 && (*cpu.Period < minCPUPeriod || *cpu.Period > maxCPUPeriod) {
+			v.addf("linux.resources.cpu.period: %d is out of range [%d, %d]", *cpu.Period, minCPUPeriod, maxCPUPeriod)
+		}
+		if cpu.Quota != nil && *cpu.Quota > 0 && *cpu.Quota < minCPUQuota {
+			v.addf("linux.resources.cpu.quota: %d is less than %d", *cpu.Quota, minCPUQuota)
+		}
+	}
+	if mem := res.Memory; mem != nil {
+		if mem.Limit != nil && *mem.Limit < -1 {
+			v.addf("linux.resources.memory.limit: %d is negative", *mem.Limit)
+		}
+		if mem.Limit != nil && mem.Swap != nil && *mem.Limit > 0 && *mem.Swap > 0 && *mem.Swap < *mem.Limit {
+			v.addf("linux.resources.memory.swap: %d is less than memory limit %d", *mem.Swap, *mem.Limit)
+		}
+	}
+	if pids := res.Pids; pids != nil && pids.Limit < -1 {
+		v.addf("linux.resources.pids.limit: %d is negative", pids.Limit)
+	}
+}
+
+func isBind(m specs.Mount) bool {
+	if m.Type == "bind" {
+		return true
+	}
+	for _, opt := range m.Options {
+		if opt == "bind" || opt == "rbind" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "spec-test-")
+	require.NoError(t, err, "could not create temp rootfs")
+	defer os.RemoveAll(rootfs)
+
+	int64Ptr := func(i int64) *int64 { return &i }
+	uint64Ptr := func(i uint64) *uint64 { return &i }
+	intPtr := func(i int) *int { return &i }
+
+	newSpec := func() *specs.Spec {
+		return &specs.Spec{
+			Root: &specs.Root{
+				Path: rootfs,
+			},
+			Process: &specs.Process{
+				Args: []string{"sh"},
+				Cwd:  "/",
+				Env:  []string{"PATH=/bin", "TERM=xterm"},
+			},
+			Mounts: []specs.Mount{
+				{Destination: "/proc", Type: "proc", Source: "proc"},
+				{Destination: "/etc/hostname", Source: "/var/run/hostname", Options: []string{"bind", "ro"}},
+			},
+			Linux: &specs.Linux{
+				Namespaces: []specs.LinuxNamespace{
+					{Type: specs.PIDNamespace},
+					{Type: specs.MountNamespace},
+					{Type: specs.NetworkNamespace, Path: "/var/run/netns/pod"},
+				},
+				Devices: []specs.LinuxDevice{
+					{Path: "/dev/fuse", Type: "c"},
+				},
+				CgroupsPath: "singularity-cri/pod/container",
+				Resources:   &specs.LinuxResources{},
+			},
+		}
+	}
+
+	tt := []struct {
+		name      string
+		modify    func(s *specs.Spec)
+		expectErr error
+	}{
+		{
+			name:   "valid spec",
+			modify: func(s *specs.Spec) {},
+		},
+		{
+			name: "missing rootfs",
+			modify: func(s *specs.Spec) {
+				s.Root.Path = "/non/existent/rootfs"
+			},
+			expectErr: &ValidationError{Violations: []string{
+				"root.path: stat /non/existent/rootfs: no such file or directory",
+			}},
+		},
+		{
+			name: "duplicate env",
+			modify: func(s *specs.Spec) {
+				s.Process.Env = append(s.Process.Env, "PATH=/usr/bin")
+			},
+			expectErr: &ValidationError{Violations: []string{
+				"process.env[2]: PATH is already set by process.env[0]",
+			}},
+		},
+		{
+			name: "mount collision and relative destination",
+			modify: func(s *specs.Spec) {
+				s.Mounts = append(s.Mounts,
+					specs.Mount{Destination: "/etc/hostname/", Source: "/tmp/hostname", Options: []string{"rbind"}},
+					specs.Mount{Destination: "data", Source: "/tmp/data", Options: []string{"rbind"}},
+					specs.Mount{Destination: "/data", Source: "data", Options: []string{"rbind"}},
+				)
+			},
+			expectErr: &ValidationError{Violations: []string{
+				"mounts[2].destination: /etc/hostname/ collides with mounts[1]",
+				"mounts[3].destination: data is not an absolute path",
+				"mounts[4].source: data is not an absolute path",
+			}},
+		},
+		{
+			name: "duplicate device",
+			modify: func(s *specs.Spec) {
+				s.Linux.Devices = append(s.Linux.Devices, specs.LinuxDevice{Path: "/dev/fuse", Type: "c"})
+			},
+			expectErr: &ValidationError{Violations: []string{
+				"linux.devices[1].path: /dev/fuse is already used by linux.devices[0]",
+			}},
+		},
+		{
+			name: "inconsistent namespaces",
+			modify: func(s *specs.Spec) {
+				s.Linux.Namespaces = []specs.LinuxNamespace{
+					{Type: specs.PIDNamespace},
+					{Type: specs.PIDNamespace},
+					{Type: "foo"},
+					{Type: specs.UserNamespace, Path: "/proc/1/ns/user"},
+				}
+			},
+			expectErr: &ValidationError{Violations: []string{
+				"linux.namespaces[1].type: pid namespace is specified more than once",
+				"linux.namespaces[2].type: unknown namespace type \"foo\"",
+				"linux.namespaces: joining existing user namespace is not supported with host network",
+			}},
+		},
+		{
+			name: "invalid cgroups path",
+			modify: func(s *specs.Spec) {
+				s.Linux.CgroupsPath = "singularity-cri/../../escape"
+			},
+			expectErr: &ValidationError{Violations: []string{
+				"linux.cgroupsPath: singularity-cri/../../escape must not contain '..'",
+			}},
+		},
+		{
+			name: "resources out of range",
+			modify: func(s *specs.Spec) {
+				s.Process.OOMScoreAdj = intPtr(1001)
+				s.Linux.Resources.CPU = &specs.LinuxCPU{
+					Shares: uint64Ptr(1),
+					Period: uint64Ptr(100),
+					Quota:  int64Ptr(10),
+				}
+				s.Linux.Resources.Memory = &specs.LinuxMemory{
+					Limit: int64Ptr(-2),
+				}
+			},
+			expectErr: &ValidationError{Violations: []string{
+				"process.oomScoreAdj: 1001 is out of range [-1000, 1000]",
+				"linux.resources.cpu.shares: 1 is out of range [2, 262144]",
+				"linux.resources.cpu.period: 100 is out of range [1000, 1000000]",
+				"linux.resources.cpu.quota: 10 is less than 1000",
+				"linux.resources.memory.limit: -2 is negative",
+			}},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newSpec()
+			tc.modify(s)
+			err := Validate(s)
+			if tc.expectErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.Equal(t, tc.expectErr, err)
+		})
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultExportInterval is how often finished spans are exported.
+	DefaultExportInterval = 5 * time.Second
+	// DefaultServiceName is a service name spans are reported with.
+	DefaultServiceName = "sycri"
+
+	// maxQueuedSpans limits memory held by spans collector cannot
+	// accept, spans beyond it are dropped.
+	maxQueuedSpans = 4096
+	// maxBatchSpans is a number of spans sent in a single request.
+	maxBatchSpans = 512
+
+	scopeName = "github.com/sylabs/singularity-cri"
+)
+
+// Exporter sends finished spans to OTLP collector over HTTP.
+type Exporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+}
+
+// NewExporter returns exporter sending spans to endpoint, which is a full
+// OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces. Headers are
+// set on each request, e.g. to authenticate with collector.
+func NewExporter(endpoint, serviceName string, headers map[string]string) *Exporter {
+	if serviceName == "" {
+		serviceName = DefaultServiceName
+	}
+	return &Exporter{
+		endpoint:    endpoint,
+		headers:     headers,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *Exporter) export(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) >= maxQueuedSpans {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, s)
+}
+
+// Run exports finished spans every interval until ctx is done,
+// remaining spans are exported before Run returns.
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultExportInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.flush(ctx)
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), interval)
+			e.flush(flushCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+// flush exports all queued spans in batches. Failed batches are dropped,
+// so that unavailable collector never makes daemon hold unbounded memory.
+func (e *Exporter) flush(ctx context.Context) {
+	e.mu.Lock()
+	spans := e.queue
+	dropped := e.dropped
+	e.queue = nil
+	e.dropped = 0
+	e.mu.Unlock()
+
+	if dropped != 0 {
+		glog.Warningf("Dropped %d spans exceeding export queue", dropped)
+	}
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > maxBatchSpans {
+			n = maxBatchSpans
+		}
+		if err := e.send(ctx, spans[:n]); err != nil {
+			glog.Warningf("Could not export %d spans: %v", n, err)
+		}
+		spans = spans[n:]
+	}
+}
+
+func (e *Exporter) send(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return fmt.Errorf("could not encode spans: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP/HTTP JSON request, see opentelemetry-proto trace.proto. IDs are
+// hex encoded and 64 bit integers are passed as strings.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []spanData `json:"spans"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+	spanData struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              SpanKind   `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Status            spanStatus `json:"status"`
+	}
+	keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	anyValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	spanStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// span status codes as defined by OTLP
+const (
+	statusOK    = 1
+	statusError = 2
+)
+
+func (e *Exporter) request(spans []*Span) exportRequest {
+	data := make([]spanData, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		d := spanData{
+			TraceID:           s.sc.TraceID.String(),
+			SpanID:            s.sc.SpanID.String(),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Status:            spanStatus{Code: statusOK},
+		}
+		if s.parent != (SpanID{}) {
+			d.ParentSpanID = s.parent.String()
+		}
+		for _, a := range s.attrs {
+			d.Attributes = append(d.Attributes, keyValue{Key: a.Key, Value: anyValue{StringValue: a.Value}})
+		}
+		if s.err != "" {
+			d.Status = spanStatus{Code: statusError, Message: s.err}
+		}
+		s.mu.Unlock()
+		data = append(data, d)
+	}
+	return exportRequest{
+		ResourceSpans: []resourceSpans{
+			{
+				Resource: resource{
+					Attributes: []keyValue{{Key: "service.name", Value: anyValue{StringValue: e.serviceName}}},
+				},
+				ScopeSpans: []scopeSpans{{Scope: scope{Name: scopeName}, Spans: data}},
+			},
+		},
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// TraceparentHeader is a W3C trace context header, it is
+	// also used as gRPC metadata key.
+	TraceparentHeader = "traceparent"
+	// EnvTraceparent is an environment variable span context
+	// is passed to executed processes with.
+	EnvTraceparent = "TRACEPARENT"
+)
+
+const traceparentVersion = "00"
+
+// FormatTraceparent returns span context in W3C traceparent format.
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("%s-%s-%s-%s", traceparentVersion, sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses span context in W3C traceparent format.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 {
+		return sc, fmt.Errorf("invalid traceparent %q", value)
+	}
+	// future versions may append fields
+	if parts[0] == traceparentVersion && len(parts) != 4 || parts[0] == "ff" {
+		return sc, fmt.Errorf("invalid traceparent %q", value)
+	}
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return sc, fmt.Errorf("invalid trace ID: %v", err)
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return sc, fmt.Errorf("invalid span ID: %v", err)
+	}
+	var flags [1]byte
+	if err := decodeHex(flags[:], parts[3]); err != nil {
+		return sc, fmt.Errorf("invalid trace flags: %v", err)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("trace and span IDs must not be zero")
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+func decodeHex(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return fmt.Errorf("expected %d lowercase hex digits", hex.EncodedLen(len(dst)))
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// Environ returns environment variables passing span carried by ctx to
+// executed process, nil is returned when there is no such span.
+func Environ(ctx context.Context) []string {
+	sc := spanContextFrom(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return []string{EnvTraceparent + "=" + FormatTraceparent(sc)}
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package trace implements OpenTelemetry compatible tracing. Spans are
+// propagated in W3C trace context format and exported with OTLP over HTTP
+// in JSON encoding. Only the subset sycri needs is supported, so that no
+// SDK is required.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// String returns hex encoded trace ID.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// String returns hex encoded span ID.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext is a part of span propagated to other processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled is true when span is recorded and exported.
+	Sampled bool
+}
+
+// IsValid returns true when both trace and span IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// SpanKind is a relationship of span to its parent and children.
+type SpanKind int
+
+// Span kinds as defined by OTLP.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Attribute is a single key value pair describing span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Span is a single traced operation. All methods of nil span are no-op,
+// so that callers need not check whether tracing is enabled.
+type Span struct {
+	tracer *Tracer
+	name   string
+	kind   SpanKind
+	sc     SpanContext
+	parent SpanID
+	start  time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs []Attribute
+	err   string
+	ended bool
+}
+
+// SpanContext returns propagated part of span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttribute records a key value pair describing span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, Attribute{Key: key, Value: value})
+	s.mu.Unlock()
+}
+
+// SetError marks span as failed with passed error, nil error is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes span and hands it to exporter, consequent calls are no-op.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		s.tracer.exporter.export(s)
+	}
+}
+
+// Tracer creates spans and passes finished sampled ones to exporter.
+type Tracer struct {
+	exporter *Exporter
+	// sampleRatio is a fraction of traces without sampled
+	// parent that are recorded.
+	sampleRatio float64
+}
+
+// NewTracer returns tracer that records sampleRatio of traces started
+// locally, traces continued from other processes follow their sampling
+// decision. Sampled spans are exported with exporter.
+func NewTracer(exporter *Exporter, sampleRatio float64) *Tracer {
+	return &Tracer{
+		exporter:    exporter,
+		sampleRatio: sampleRatio,
+	}
+}
+
+var (
+	globalMu sync.RWMutex
+	global   *Tracer
+)
+
+// SetTracer sets tracer used by Start, nil tracer disables tracing.
+func SetTracer(t *Tracer) {
+	globalMu.Lock()
+	global = t
+	globalMu.Unlock()
+}
+
+func currentTracer() *Tracer {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return global
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// Start starts internal span that is a child of span found in ctx, if any.
+// Returned context carries the new span. Nil span is returned when tracing
+// is disabled. Caller must End returned span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return start(ctx, name, SpanKindInternal)
+}
+
+// StartServer starts span of handling a request that is a child of span
+// found in ctx, usually one passed with ContextWithRemote.
+func StartServer(ctx context.Context, name string) (context.Context, *Span) {
+	return start(ctx, name, SpanKindServer)
+}
+
+func start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	t := currentTracer()
+	if t == nil {
+		return ctx, nil
+	}
+	parent := spanContextFrom(ctx)
+	s := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+	if parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = t.sample(s.sc.TraceID)
+	}
+	rand.Read(s.sc.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// sample makes sampling decision based on trace ID, so that all
+// processes with the same ratio make the same decision.
+func (t *Tracer) sample(id TraceID) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	bound := uint64(t.sampleRatio * (1 << 63))
+	return binary.BigEndian.Uint64(id[8:])>>1 < bound
+}
+
+// FromContext returns span carried by ctx or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// ContextWithRemote returns context carrying span context received from
+// other process, spans started with it become its children.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// spanContextFrom returns context of the span carried by ctx, if none
+// the remote one is returned.
+func spanContextFrom(ctx context.Context) SpanContext {
+	if s := FromContext(ctx); s != nil {
+		return s.sc
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
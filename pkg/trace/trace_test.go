@@ -0,0 +1,179 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tt := []struct {
+		name        string
+		value       string
+		expectError bool
+		sampled     bool
+	}{
+		{
+			name:    "sampled",
+			value:   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			sampled: true,
+		},
+		{
+			name:  "not sampled",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		},
+		{
+			name:    "future version",
+			value:   "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-the-future-will-be-like",
+			sampled: true,
+		},
+		{
+			name:        "extra field",
+			value:       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			expectError: true,
+		},
+		{
+			name:        "zero trace ID",
+			value:       "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			expectError: true,
+		},
+		{
+			name:        "uppercase",
+			value:       "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			expectError: true,
+		},
+		{
+			name:        "short span ID",
+			value:       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01",
+			expectError: true,
+		},
+		{
+			name:        "garbage",
+			value:       "foo",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tc.value)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+			require.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+			require.Equal(t, tc.sampled, sc.Sampled)
+			if tc.sampled {
+				require.Equal(t, tc.value[3:55], FormatTraceparent(sc)[3:55])
+			}
+		})
+	}
+}
+
+func TestStart_Disabled(t *testing.T) {
+	SetTracer(nil)
+	ctx, span := Start(context.Background(), "noop")
+	require.Nil(t, span)
+	span.SetAttribute("key", "value")
+	span.SetError(fmt.Errorf("failed"))
+	span.End()
+	require.Nil(t, Environ(ctx))
+}
+
+func TestExporter(t *testing.T) {
+	requests := make(chan exportRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/traces", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.Equal(t, "secret", r.Header.Get("Authorization"))
+		var req exportRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests <- req
+	}))
+	defer server.Close()
+
+	exporter := NewExporter(server.URL+"/v1/traces", "", map[string]string{"Authorization": "secret"})
+	SetTracer(NewTracer(exporter, 1))
+	defer SetTracer(nil)
+
+	remote, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	ctx, server1 := StartServer(ContextWithRemote(context.Background(), remote), "RunPodSandbox")
+	ctx, child := Start(ctx, "engine.start")
+	child.SetAttribute("pod.id", "abc")
+	child.SetError(fmt.Errorf("engine failed"))
+
+	env := Environ(ctx)
+	require.Len(t, env, 1)
+	propagated, err := ParseTraceparent(env[0][len(EnvTraceparent)+1:])
+	require.NoError(t, err)
+	require.Equal(t, child.SpanContext(), propagated, "helper processes must see the innermost span")
+
+	child.End()
+	child.End()
+	server1.End()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		exporter.Run(ctx, time.Hour)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	req := <-requests
+	require.Len(t, req.ResourceSpans, 1)
+	require.Equal(t, DefaultServiceName, req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2, "ended span must be exported once")
+
+	engine, pod := spans[0], spans[1]
+	require.Equal(t, "engine.start", engine.Name)
+	require.Equal(t, SpanKindInternal, engine.Kind)
+	require.Equal(t, pod.SpanID, engine.ParentSpanID)
+	require.Equal(t, statusError, engine.Status.Code)
+	require.Equal(t, "engine failed", engine.Status.Message)
+	require.Equal(t, []keyValue{{Key: "pod.id", Value: anyValue{StringValue: "abc"}}}, engine.Attributes)
+
+	require.Equal(t, "RunPodSandbox", pod.Name)
+	require.Equal(t, SpanKindServer, pod.Kind)
+	require.Equal(t, remote.TraceID.String(), pod.TraceID)
+	require.Equal(t, remote.SpanID.String(), pod.ParentSpanID)
+	require.Equal(t, statusOK, pod.Status.Code)
+}
+
+func TestTracer_sample(t *testing.T) {
+	none := NewTracer(nil, 0)
+	all := NewTracer(nil, 1)
+	half := NewTracer(nil, 0.5)
+
+	var id TraceID
+	require.False(t, none.sample(id))
+	require.True(t, all.sample(id))
+	require.True(t, half.sample(id))
+	id[8] = 0xff
+	require.False(t, half.sample(id))
+}
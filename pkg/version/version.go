@@ -0,0 +1,60 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package version holds Singularity-CRI build information. Variables
+// declared here are expected to be set at build time with -ldflags.
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// CRIVersion is the version of CRI API Singularity-CRI is compiled against.
+const CRIVersion = "v1alpha2"
+
+var (
+	// Version is Singularity-CRI release version.
+	Version = "unknown"
+	// GitCommit is a git commit Singularity-CRI is built from.
+	GitCommit = "unknown"
+	// BuildDate is a date Singularity-CRI is built at in RFC3339 format.
+	BuildDate = "unknown"
+)
+
+// Info holds Singularity-CRI build information.
+type Info struct {
+	Version    string `json:"version"`
+	GitCommit  string `json:"gitCommit"`
+	BuildDate  string `json:"buildDate"`
+	CRIVersion string `json:"criVersion"`
+	GoVersion  string `json:"goVersion"`
+}
+
+// Get returns Singularity-CRI build information.
+func Get() Info {
+	return Info{
+		Version:    Version,
+		GitCommit:  GitCommit,
+		BuildDate:  BuildDate,
+		CRIVersion: CRIVersion,
+		GoVersion:  runtime.Version(),
+	}
+}
+
+// String returns human readable build information.
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, CRI %s, %s)",
+		i.Version, i.GitCommit, i.BuildDate, i.CRIVersion, i.GoVersion)
+}
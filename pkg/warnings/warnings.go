@@ -0,0 +1,232 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package warnings keeps recent distinct warnings about CRI objects, e.g.
+// pods, containers and images, so that repeated failures are counted
+// instead of flooding logs and may be shown to users in object status.
+package warnings
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// MaxEntries is a number of distinct warnings kept per object,
+	// the least recently seen one is dropped to fit a new one.
+	MaxEntries = 5
+	// MaxMessageLength is a length in bytes warning messages are cut to.
+	MaxMessageLength = 256
+	// DefaultRetention is time warning is kept for after it was last seen.
+	DefaultRetention = 10 * time.Minute
+
+	// logInterval is how often the same warning is logged.
+	logInterval = time.Minute
+)
+
+var retention = int64(DefaultRetention)
+
+// SetRetention sets time warnings are kept for after they were last seen,
+// i.e. once condition stops recurring. Zero value means DefaultRetention.
+func SetRetention(d time.Duration) {
+	if d <= 0 {
+		d = DefaultRetention
+	}
+	atomic.StoreInt64(&retention, int64(d))
+}
+
+// Retention returns time warnings are kept for after they were last seen.
+func Retention() time.Duration {
+	return time.Duration(atomic.LoadInt64(&retention))
+}
+
+// Entry is a single deduplicated warning.
+type Entry struct {
+	// Template is a format warning message was built with,
+	// warnings with the same template are counted as repeats.
+	Template string `json:"template"`
+	// Message is the most recent warning message.
+	Message string `json:"message"`
+	// Count is a number of times warning was seen.
+	Count uint64 `json:"count"`
+	// FirstSeen and LastSeen are times in unix nano.
+	FirstSeen int64 `json:"firstSeen"`
+	LastSeen  int64 `json:"lastSeen"`
+
+	lastLogged int64
+}
+
+// Buffer is a bounded set of recent warnings about a single object.
+// Zero value is ready to use. Buffer is thread-safe.
+type Buffer struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// Add records warning built from format and args. Warnings with the same
+// format are deduplicated. Returned value reports whether warning should
+// be logged, which is the case when it is seen for the first time or was
+// not logged for a minute.
+func (b *Buffer) Add(format string, args ...interface{}) bool {
+	now := time.Now().UnixNano()
+	msg := fmt.Sprintf(format, args...)
+	if len(msg) > MaxMessageLength {
+		msg = msg[:MaxMessageLength-3] + "..."
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(now)
+	for i := range b.entries {
+		e := &b.entries[i]
+		if e.Template != format {
+			continue
+		}
+		e.Message = msg
+		e.Count++
+		e.LastSeen = now
+		if now-e.lastLogged < int64(logInterval) {
+			return false
+		}
+		e.lastLogged = now
+		return true
+	}
+
+	if len(b.entries) == MaxEntries {
+		oldest := 0
+		for i, e := range b.entries {
+			if e.LastSeen < b.entries[oldest].LastSeen {
+				oldest = i
+			}
+		}
+		b.entries = append(b.entries[:oldest], b.entries[oldest+1:]...)
+	}
+	b.entries = append(b.entries, Entry{
+		Template:   format,
+		Message:    msg,
+		Count:      1,
+		FirstSeen:  now,
+		LastSeen:   now,
+		lastLogged: now,
+	})
+	return true
+}
+
+// Logf records warning same as Add and logs it with the passed
+// function, e.g. glog.WarningDepth, unless it was logged recently.
+func (b *Buffer) Logf(log func(depth int, args ...interface{}), format string, args ...interface{}) {
+	if !b.Add(format, args...) {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if count := b.count(format); count > 1 {
+		msg = fmt.Sprintf("%s (seen %d times)", msg, count)
+	}
+	log(1, msg)
+}
+
+// Entries returns warnings that are still recurring, most recent first.
+func (b *Buffer) Entries() []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(time.Now().UnixNano())
+	if len(b.entries) == 0 {
+		return nil
+	}
+	entries := append([]Entry(nil), b.entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].LastSeen > entries[j].LastSeen
+	})
+	return entries
+}
+
+// Summary returns a single line describing recurring warnings,
+// e.g. for status message. Empty string is returned if there are none.
+func (b *Buffer) Summary() string {
+	entries := b.Entries()
+	parts := make([]string, 0, len(entries))
+	for _, e := range entries {
+		part := "warning: " + e.Message
+		if e.Count > 1 {
+			part += fmt.Sprintf(" (%d times, last at %s)", e.Count,
+				time.Unix(0, e.LastSeen).UTC().Format(time.RFC3339))
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Clear removes all warnings.
+func (b *Buffer) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries = nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (b *Buffer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.Entries())
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Entries over
+// MaxEntries and expired ones are dropped.
+func (b *Buffer) UnmarshalJSON(data []byte) error {
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].LastSeen > entries[j].LastSeen
+	})
+	if len(entries) > MaxEntries {
+		entries = entries[:MaxEntries]
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries = entries
+	b.expire(time.Now().UnixNano())
+	return nil
+}
+
+func (b *Buffer) count(format string) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range b.entries {
+		if e.Template == format {
+			return e.Count
+		}
+	}
+	return 0
+}
+
+// expire drops warnings that were not seen for retention period.
+// It must be called with b.mu held.
+func (b *Buffer) expire(now int64) {
+	keep := b.entries[:0]
+	for _, e := range b.entries {
+		if now-e.LastSeen <= int64(Retention()) {
+			keep = append(keep, e)
+		}
+	}
+	if len(keep) == 0 {
+		keep = nil
+	}
+	b.entries = keep
+}
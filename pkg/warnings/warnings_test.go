@@ -0,0 +1,118 @@
+// Copyright (c) 2018-2019 Sylabs, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warnings
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer_Add(t *testing.T) {
+	var b Buffer
+	require.True(t, b.Add("could not fetch %s: %v", "foo", "timeout"))
+	require.False(t, b.Add("could not fetch %s: %v", "foo", "refused"))
+	require.True(t, b.Add("could not stat %s", "foo"))
+
+	entries := b.Entries()
+	require.Len(t, entries, 2)
+	require.Equal(t, "could not stat foo", entries[0].Message)
+	require.Equal(t, uint64(1), entries[0].Count)
+	require.Equal(t, "could not fetch foo: refused", entries[1].Message)
+	require.Equal(t, uint64(2), entries[1].Count)
+	require.True(t, entries[1].LastSeen >= entries[1].FirstSeen)
+
+	b.Clear()
+	require.Empty(t, b.Entries())
+	require.Empty(t, b.Summary())
+}
+
+func TestBuffer_Bounded(t *testing.T) {
+	var b Buffer
+	for i := 0; i < MaxEntries+2; i++ {
+		b.Add(fmt.Sprintf("warning %d", i))
+		time.Sleep(time.Millisecond)
+	}
+	entries := b.Entries()
+	require.Len(t, entries, MaxEntries)
+	require.Equal(t, fmt.Sprintf("warning %d", MaxEntries+1), entries[0].Message)
+	require.Equal(t, "warning 2", entries[MaxEntries-1].Message)
+
+	b.Add("%s", strings.Repeat("x", 2*MaxMessageLength))
+	require.Len(t, b.Entries()[0].Message, MaxMessageLength)
+	require.True(t, strings.HasSuffix(b.Entries()[0].Message, "..."))
+}
+
+func TestBuffer_Expire(t *testing.T) {
+	SetRetention(50 * time.Millisecond)
+	defer SetRetention(0)
+
+	var b Buffer
+	b.Add("warning")
+	require.Len(t, b.Entries(), 1)
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(t, b.Entries())
+
+	SetRetention(-time.Second)
+	require.Equal(t, DefaultRetention, Retention())
+}
+
+func TestBuffer_Logf(t *testing.T) {
+	var logged []string
+	log := func(depth int, args ...interface{}) {
+		require.Equal(t, 1, depth)
+		logged = append(logged, fmt.Sprint(args...))
+	}
+
+	var b Buffer
+	b.Logf(log, "could not update %s", "foo")
+	b.Logf(log, "could not update %s", "foo")
+	require.Equal(t, []string{"could not update foo"}, logged)
+
+	b.entries[0].lastLogged -= int64(logInterval)
+	b.Logf(log, "could not update %s", "foo")
+	require.Equal(t, []string{"could not update foo", "could not update foo (seen 3 times)"}, logged)
+	require.Contains(t, b.Summary(), "warning: could not update foo (3 times, last at ")
+}
+
+func TestBuffer_JSON(t *testing.T) {
+	var b Buffer
+	b.Add("could not verify %s", "foo")
+	b.Add("could not verify %s", "bar")
+
+	data, err := json.Marshal(&b)
+	require.NoError(t, err)
+
+	var actual Buffer
+	require.NoError(t, json.Unmarshal(data, &actual))
+	require.Equal(t, stripLogged(b.Entries()), actual.Entries())
+
+	old := Entry{Template: "old", Message: "old", Count: 1, LastSeen: time.Now().Add(-2 * DefaultRetention).UnixNano()}
+	data, err = json.Marshal([]Entry{old})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &actual))
+	require.Empty(t, actual.Entries())
+}
+
+func stripLogged(entries []Entry) []Entry {
+	for i := range entries {
+		entries[i].lastLogged = 0
+	}
+	return entries
+}